@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"dk/core"
+	"dk/db"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+)
+
+const archiveUsage = `Usage: dk -userId ID archive -out DIR [flags]
+
+Exports the messages kept by this node to DIR. Running the command again with the same
+flags resumes an interrupted export.
+
+Flags:
+`
+
+// runArchiveCommand implements the "archive" subcommand and returns the exit code
+func runArchiveCommand(dbPath, identity string, args []string) int {
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, archiveUsage)
+		fs.PrintDefaults()
+	}
+	out := fs.String("out", "", "Directory of the archive")
+	format := fs.String("format", core.ArchiveJSONL, "Archive format: jsonl or mbox")
+	peer := fs.String("peer", "", "Only export messages exchanged with this peer")
+	since := fs.String("since", "", "Only export messages received at or after this date (YYYY-MM-DD or RFC 3339)")
+	until := fs.String("until", "", "Only export messages received before this date (YYYY-MM-DD or RFC 3339)")
+	partSize := fs.Int("part_size", 10000, "Messages per archive file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *out == "" {
+		fs.Usage()
+		return 2
+	}
+
+	options := core.ArchiveOptions{Dir: *out, Format: *format, Identity: identity, Peer: *peer, PartSize: *partSize}
+	var err error
+	if options.Since, err = parseArchiveDate(*since); err != nil {
+		fmt.Fprintf(os.Stderr, "archive: invalid -since: %v\n", err)
+		return 2
+	}
+	if options.Until, err = parseArchiveDate(*until); err != nil {
+		fmt.Fprintf(os.Stderr, "archive: invalid -until: %v\n", err)
+		return 2
+	}
+
+	database, err := db.Initialize(dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "archive: %v\n", err)
+		return 1
+	}
+	defer database.Close()
+	if err := db.RunMigrations(database); err != nil {
+		fmt.Fprintf(os.Stderr, "archive: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	manifest, err := core.ExportArchive(ctx, database, options)
+	if err != nil {
+		if manifest != nil {
+			fmt.Fprintf(os.Stderr, "archive: stopped after %d messages; run the command again to resume: %v\n", manifest.Records, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "archive: %v\n", err)
+		}
+		return 1
+	}
+	fmt.Printf("Exported %d messages in %d files to %s\n", manifest.Records, len(manifest.Parts), *out)
+	return 0
+}
+
+// parseArchiveDate parses a date or an RFC 3339 time; an empty value is the zero time
+func parseArchiveDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
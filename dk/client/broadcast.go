@@ -0,0 +1,17 @@
+package lib
+
+import "log"
+
+// broadcastAddress is the recipient of the messages sent to every user.
+const broadcastAddress = "broadcast"
+
+// flagSpoofedBroadcast marks a broadcast that is unsigned, or whose signature does not match
+// its claimed sender, with the status "spoofed". Broadcasts are not encrypted, so their
+// signature is the only proof of where their content comes from; every client signs them.
+func flagSpoofedBroadcast(msg *Message) {
+	if msg.To != broadcastAddress || (msg.Signature != "" && msg.Status != "invalid_signature") {
+		return
+	}
+	log.Printf("WARNING: Spoofed broadcast claiming to be from %s", msg.From)
+	msg.Status = "spoofed"
+}
@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSpoofedBroadcasts(t *testing.T) {
+	alice, bob := ratchetPeers(t)
+	signed, ok := alice.prepare(Message{From: "alice", To: broadcastAddress, Content: "hello", Timestamp: time.Now()})
+	if !ok {
+		t.Fatal("Failed to prepare a broadcast")
+	}
+	if signed.Content != "hello" || signed.Signature == "" {
+		t.Fatalf("Expected a signed broadcast in the clear, got %+v", signed)
+	}
+	tampered := signed
+	tampered.Content = "goodbye"
+	unsigned := Message{From: "alice", To: broadcastAddress, Content: "hello", Timestamp: time.Now(), Status: "delivered"}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for _, msg := range []Message{tampered, unsigned, signed} {
+			conn.WriteJSON(msg)
+		}
+		conn.ReadMessage()
+	}))
+	defer srv.Close()
+	bob.setServer(srv.URL)
+	if err := bob.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer bob.Disconnect()
+
+	want := []struct{ status, content string }{
+		{"spoofed", "goodbye"},
+		{"spoofed", "hello"},
+		{"verified", "hello"},
+	}
+	for i, w := range want {
+		select {
+		case msg := <-bob.Messages():
+			if msg.Status != w.status || msg.Content != w.content {
+				t.Errorf("Broadcast %d: expected %s %q, got %s %q", i, w.status, w.content, msg.Status, msg.Content)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Broadcast %d was not delivered", i)
+		}
+	}
+}
@@ -2,12 +2,13 @@ package lib
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha512"
-	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -16,6 +17,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -34,19 +36,35 @@ type Message struct {
 	Status           string    `json:"status,omitempty"`
 	Signature        string    `json:"signature,omitempty"`          // Base64-encoded signature of message content
 	IsForwardMessage bool      `json:"is_forward_message,omitempty"` // Indicates if this is a forward message
+	MessageID        string    `json:"message_id,omitempty"`         // Chosen by the sender to match acknowledgments
+	Ack              string    `json:"ack,omitempty"`                // Set on acknowledgment frames: the state of message MessageID
+	Network          string    `json:"-"`                            // Partner network the message was received on; empty for the home network
+
+	// Replay protection: Sequence increases with each message of the sender, and
+	// SequenceSignature signs it with the message. Signature stays for older clients.
+	Sequence          uint64 `json:"seq,omitempty"`
+	SequenceSignature string `json:"seq_signature,omitempty"` // Base64-encoded
+
+	queuedAt time.Time // When SendMessage queued the message, for ReconnectPolicy.MaxQueueAge
 }
 
 // EncryptedMessage is the structure that will be marshaled into the Message.Content field
 // for direct messages. It contains the envelope (asymmetrically encrypted symmetric key)
 // and the symmetrically encrypted message content.
 type EncryptedMessage struct {
+	// Identifier of the Encryptor that produced the envelope; empty means DefaultEncryptionScheme.
+	Scheme string `json:"scheme,omitempty"`
 	// Data to allow the receiver to recover the AES key.
-	EphemeralPublicKey string `json:"ephemeral_public_key"`
-	KeyNonce           string `json:"key_nonce"`
-	EncryptedKey       string `json:"encrypted_key"`
+	EphemeralPublicKey string `json:"ephemeral_public_key,omitempty"`
+	KeyNonce           string `json:"key_nonce,omitempty"`
+	EncryptedKey       string `json:"encrypted_key,omitempty"`
 	// Data for AES-GCM encryption of the message content.
-	DataNonce        string `json:"data_nonce"`
-	EncryptedContent string `json:"encrypted_content"`
+	DataNonce        string `json:"data_nonce,omitempty"`
+	EncryptedContent string `json:"encrypted_content,omitempty"`
+	// Opaque ciphertext produced by a custom Encryptor.
+	Payload string `json:"payload,omitempty"`
+	// Set by senders that support RatchetScheme, in envelopes of DefaultEncryptionScheme.
+	Ratchet bool `json:"ratchet,omitempty"`
 }
 
 // UserStatusResponse holds the list of online and offline usernames.
@@ -60,39 +78,184 @@ type Client struct {
 	UserID     string
 	privateKey ed25519.PrivateKey
 	publicKey  ed25519.PublicKey
+	// Keys this client had before rotating to the current one, latest first, kept to decrypt
+	// the messages sent to them
+	previousKeys []ed25519.PrivateKey
+	keyMu        sync.RWMutex
 
 	serverURL string
-	jwtToken  string
-
-	// The WebSocket connection is protected by a read–write mutex.
-	wsConn *websocket.Conn
-	connMu sync.RWMutex
-
-	recvCh chan Message // Channel for incoming messages.
-	sendCh chan Message // Channel for outgoing messages.
-	doneCh chan struct{}
 
-	// Cache of user public keys for signature verification
-	pubKeyCache   map[string]ed25519.PublicKey
-	pubKeyCacheMu sync.RWMutex
+	// Token obtained at login, renewed in the background before it expires
+	jwtToken           string
+	tokenObtainedAt    time.Time // On the server clock
+	tokenRefreshMargin time.Duration
+	tokenChanged       chan struct{}
+	tokenMu            sync.RWMutex
+	refreshOnce        sync.Once
+	loginMu            sync.Mutex // Serializes logging in again
+
+	// Servers to fail over between, the preferred one first; the URL in use is serverURL.
+	servers       []*serverEndpoint
+	serverMu      sync.RWMutex
+	probeInterval time.Duration
+	monitorOnce   sync.Once
 
-	reconnectInterval time.Duration
-	insecure          bool
+	// The WebSocket connection is protected by a read–write mutex.
+	wsConn       *websocket.Conn
+	connClosed   chan struct{} // Closed when the current connection is torn down.
+	writerDone   chan struct{} // Closed when the writer of the current connection has stopped.
+	reconnecting bool
+	connMu       sync.RWMutex
+
+	// Connection state reported by Status, protected by connMu.
+	connectedAt      time.Time
+	reconnectAttempt int
+	lastError        string
+	lastErrorAt      time.Time
+
+	recvCh   chan Message   // Channel for incoming messages.
+	sendCh   chan Message   // Channel for outgoing messages, replaced only before connecting.
+	overflow OverflowPolicy // What Send does when sendCh is full, protected by connMu
+	doneCh   chan struct{}
+	recvOnce sync.Once
+
+	// Held while adding to sendCh, so that the parts of a message are queued together.
+	enqueueMu sync.Mutex
+
+	// Acknowledgments of sent messages, reported by DeliveryStatus.
+	deliveryCh chan DeliveryStatus
+	// Callers of SendMessageSync waiting for an acknowledgment, by message ID.
+	waiters   map[string]*deliveryWaiter
+	waitersMu sync.Mutex
+
+	// Messages that were prepared but could not be written, resent first after a reconnect.
+	outbox   []Message
+	outboxMu sync.Mutex
+	// Optional persistent queue of outgoing messages, flushed by the writer when signalled.
+	outboxStore OutboxStore
+	outboxReady chan struct{}
+
+	// Cache of user public keys for signature verification, and of the keys whose signatures
+	// are accepted for each user, current and previous
+	pubKeyCache     map[string]ed25519.PublicKey
+	pubKeyFetchedAt map[string]time.Time // Of the keys fetched from the server; others do not expire
+	keyHistories    map[string]keyHistory
+	keyCacheTTL     time.Duration
+	pubKeyCacheMu   sync.RWMutex
+
+	// Key trusted for each peer, and the changes of the keys served for peers
+	peerKeys   PeerKeyStore
+	keyChanges chan KeyChange
+	trustMu    sync.Mutex
+
+	reconnectPolicy ReconnectPolicy
+	compression     bool // Offer permessage-deflate compression, protected by connMu
+	maxMessageSize  int  // Larger messages are fragmented, protected by connMu
+
+	// Encryption scheme used for outgoing direct messages, and all schemes
+	// available for decrypting incoming ones, keyed by scheme identifier.
+	encryptor    Encryptor
+	encryptors   map[string]Encryptor
+	encryptorsMu sync.RWMutex
+
+	// Skew between the local and the server clock, estimated at login
+	clock clock
+
+	// Instrumentation callbacks and metrics set by the embedding application
+	hooks   Hooks
+	metrics Metrics
+	hooksMu sync.RWMutex
+
+	// Keys of the groups this client is a member of
+	groupKeys GroupKeyStore
+	groupMu   sync.Mutex
+
+	// TLS and proxy settings of the connections to the server, and the HTTP client using them
+	insecure bool
+	rootCAs  *x509.CertPool
+	pins     map[string]bool // SHA-256 fingerprints of certificate public keys, in hex
+	proxy    *url.URL        // Proxy set with SetProxy; the environment is used otherwise
+	httpc    *http.Client
+	tlsMu    sync.Mutex
+
+	// Ratcheting sessions of direct messages, by peer
+	ratchets  RatchetStore
+	ratchetMu sync.Mutex
+
+	// Sequence number of the last message sent, and the sequence numbers received from each
+	// sender, to drop replays
+	sequence   uint64
+	sequenceMu sync.Mutex
+	senders    map[string]*senderSequences
+	sequences  SequenceStore
+	sendersMu  sync.Mutex
+
+	// Files offered by this client, by transfer ID, and the chunks of files being received,
+	// by sender and transfer ID
+	outgoingFiles map[string]*outgoingFile
+	incomingFiles map[string]chan fileChunk
+	fileOffers    chan FileOffer
+	filesMu       sync.Mutex
+
+	// Users whose presence changes are reported on presenceCh
+	presenceWatch map[string]bool
+	presenceCh    chan PresenceEvent
+	presenceMu    sync.Mutex
+
+	// Clients of the partner networks, by name, and the network each peer is routed to,
+	// explicitly or as learned from the messages received
+	partners   map[string]*Client
+	routes     map[string]string
+	learned    map[string]string
+	forwarders sync.WaitGroup
+	networksMu sync.RWMutex
+
+	// Fragmented messages being received, by sender, recipient and message ID
+	assemblies           map[string]*assembly
+	pendingFragmentBytes int
+	fragmentsMu          sync.Mutex
 }
 
 // NewClient creates a new Client instance.
 func NewClient(serverURL, userID string, privateKey ed25519.PrivateKey, publicKey ed25519.PublicKey) *Client {
 	// Create client with public key cache
 	client := &Client{
-		serverURL:         serverURL,
-		UserID:            userID,
-		privateKey:        privateKey,
-		publicKey:         publicKey,
-		recvCh:            make(chan Message, 100),
-		sendCh:            make(chan Message, 100),
-		doneCh:            make(chan struct{}),
-		pubKeyCache:       make(map[string]ed25519.PublicKey),
-		reconnectInterval: 5 * time.Second,
+		serverURL:          serverURL,
+		UserID:             userID,
+		privateKey:         privateKey,
+		publicKey:          publicKey,
+		recvCh:             make(chan Message, 100),
+		sendCh:             make(chan Message, DefaultSendQueueSize),
+		doneCh:             make(chan struct{}),
+		outboxReady:        make(chan struct{}, 1),
+		deliveryCh:         make(chan DeliveryStatus, 100),
+		waiters:            make(map[string]*deliveryWaiter),
+		pubKeyCache:        make(map[string]ed25519.PublicKey),
+		keyHistories:       make(map[string]keyHistory),
+		pubKeyFetchedAt:    make(map[string]time.Time),
+		keyCacheTTL:        keyHistoryTTL,
+		peerKeys:           newMemoryPeerKeys(),
+		keyChanges:         make(chan KeyChange, 16),
+		partners:           make(map[string]*Client),
+		routes:             make(map[string]string),
+		learned:            make(map[string]string),
+		reconnectPolicy:    DefaultReconnectPolicy(),
+		compression:        true,
+		maxMessageSize:     DefaultMaxMessageSize,
+		assemblies:         make(map[string]*assembly),
+		encryptor:          HybridEncryptor{},
+		encryptors:         map[string]Encryptor{DefaultEncryptionScheme: HybridEncryptor{}},
+		groupKeys:          newMemoryGroupKeys(),
+		ratchets:           newMemoryRatchets(),
+		senders:            make(map[string]*senderSequences),
+		sequences:          newMemorySequences(),
+		outgoingFiles:      make(map[string]*outgoingFile),
+		incomingFiles:      make(map[string]chan fileChunk),
+		fileOffers:         make(chan FileOffer, 16),
+		presenceWatch:      make(map[string]bool),
+		presenceCh:         make(chan PresenceEvent, 100),
+		tokenChanged:       make(chan struct{}, 1),
+		tokenRefreshMargin: DefaultTokenRefreshMargin,
 	}
 
 	// Add own public key to cache
@@ -101,12 +264,18 @@ func NewClient(serverURL, userID string, privateKey ed25519.PrivateKey, publicKe
 	return client
 }
 
+// Token returns the token obtained at login, or an empty string before it.
 func (c *Client) Token() string {
+	c.tokenMu.RLock()
+	defer c.tokenMu.RUnlock()
 	return c.jwtToken
 }
 
+// SetReconnectInterval sets the delay after the first failed reconnect attempt.
 func (c *Client) SetReconnectInterval(interval time.Duration) {
-	c.reconnectInterval = interval
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.reconnectPolicy.InitialDelay = interval
 }
 
 // GetUserDescriptions retrieves the list of descriptions for the specified userID.
@@ -114,7 +283,7 @@ func (c *Client) SetReconnectInterval(interval time.Duration) {
 // Since no authentication is required for this endpoint, the request is sent without an Authorization header.
 func (c *Client) GetUserDescriptions(userID string) ([]string, error) {
 	// Construct the endpoint URL using the base server URL and the user ID.
-	endpoint := fmt.Sprintf("%s/user/descriptions/%s", c.serverURL, userID)
+	endpoint := fmt.Sprintf("%s/user/descriptions/%s", c.ServerURL(), userID)
 
 	// Create a new HTTP GET request.
 	req, err := http.NewRequest("GET", endpoint, nil)
@@ -131,9 +300,7 @@ func (c *Client) GetUserDescriptions(userID string) ([]string, error) {
 
 	// Check if the response status code is OK.
 	if resp.StatusCode != http.StatusOK {
-		// Read the response body to include any error message.
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to get user descriptions: %s (status code %d)", string(bodyBytes), resp.StatusCode)
+		return nil, statusError(resp, "failed to get user descriptions")
 	}
 
 	// Decode the JSON array response into a slice of strings.
@@ -162,7 +329,7 @@ func (c *Client) SetUserDescriptions(descriptions []string) error {
 	}
 
 	// Construct the endpoint URL.
-	endpoint := fmt.Sprintf("%s/user/descriptions", c.serverURL)
+	endpoint := fmt.Sprintf("%s/user/descriptions", c.ServerURL())
 
 	// Create a new HTTP POST request with the JSON payload.
 	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(payload))
@@ -172,14 +339,12 @@ func (c *Client) SetUserDescriptions(descriptions []string) error {
 
 	// Set the required headers.
 	req.Header.Set("Content-Type", "application/json")
-	if c.jwtToken != "" {
-		req.Header.Set("Authorization", "Bearer "+c.jwtToken)
-	} else {
+	if c.Token() == "" {
 		return fmt.Errorf("JWT token is not set; please login first")
 	}
 
-	// Execute the request using the client's HTTP client.
-	resp, err := c.httpClient().Do(req)
+	// Execute the request with the token, logging in again if it was rejected.
+	resp, err := c.doAuthorized(req)
 	if err != nil {
 		return fmt.Errorf("HTTP request failed: %w", err)
 	}
@@ -187,8 +352,7 @@ func (c *Client) SetUserDescriptions(descriptions []string) error {
 
 	// Check if the response status is OK.
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to set descriptions: %s (status code %d)", string(bodyBytes), resp.StatusCode)
+		return statusError(resp, "failed to set descriptions")
 	}
 
 	return nil
@@ -199,7 +363,7 @@ func (c *Client) SetUserDescriptions(descriptions []string) error {
 // It follows best practices for error handling and resource management.
 func (c *Client) GetActiveUsers() (*UserStatusResponse, error) {
 	// Build the endpoint URL.
-	endpoint := fmt.Sprintf("%s/active-users", c.serverURL)
+	endpoint := fmt.Sprintf("%s/active-users", c.ServerURL())
 
 	// Create a new HTTP GET request.
 	req, err := http.NewRequest("GET", endpoint, nil)
@@ -207,18 +371,13 @@ func (c *Client) GetActiveUsers() (*UserStatusResponse, error) {
 		return nil, fmt.Errorf("failed to create GET request for active users: %w", err)
 	}
 
-	// Include the Authorization header if JWT token is set.
-	if c.jwtToken != "" {
-		req.Header.Set("Authorization", "Bearer "+c.jwtToken)
-	}
-
 	// Optionally, you could add a context with timeout here:
 	// ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	// defer cancel()
 	// req = req.WithContext(ctx)
 
-	// Execute the request using the client's HTTP client.
-	resp, err := c.httpClient().Do(req)
+	// Execute the request, with the JWT token if it is set.
+	resp, err := c.doAuthorized(req)
 	if err != nil {
 		return nil, fmt.Errorf("HTTP request to %s failed: %w", endpoint, err)
 	}
@@ -226,8 +385,7 @@ func (c *Client) GetActiveUsers() (*UserStatusResponse, error) {
 
 	// Check for a successful response.
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, statusError(resp, "failed to get active users")
 	}
 
 	// Decode the JSON response into the UserStatusResponse struct.
@@ -245,7 +403,7 @@ func (c *Client) GetActiveUsers() (*UserStatusResponse, error) {
 func (c *Client) signMessage(msg *Message) error {
 	// Ensure timestamp exists
 	if msg.Timestamp.IsZero() {
-		msg.Timestamp = time.Now()
+		msg.Timestamp = c.Now()
 	}
 
 	// Create a canonical representation of the message for signing
@@ -257,11 +415,22 @@ func (c *Client) signMessage(msg *Message) error {
 		msg.Content)
 
 	// Sign the canonical message
-	signature := ed25519.Sign(c.privateKey, []byte(canonicalMsg))
+	privateKey, _ := c.identity()
+	signature := ed25519.Sign(privateKey, []byte(canonicalMsg))
 
 	// Store base64-encoded signature
 	msg.Signature = base64.StdEncoding.EncodeToString(signature)
 
+	// Sign it again with a sequence number, which recipients use to drop replays
+	msg.Sequence = c.nextSequence()
+	msg.SequenceSignature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, []byte(sequencedForm(*msg))))
+
+	// The signature without the sequence number would let the message verify with the number
+	// stripped, and be replayed. Recipients that number their messages verify the other one.
+	if c.sequencedPeer(msg.To) {
+		msg.Signature = msg.SequenceSignature
+	}
+
 	return nil
 }
 
@@ -283,9 +452,16 @@ func (c *Client) verifyMessageSignature(msg Message, senderPubKey ed25519.Public
 		msg.To,
 		timestampValue,
 		msg.Content)
+	encodedSignature := msg.Signature
+
+	// Messages with a sequence number are verified with the signature covering it.
+	if msg.SequenceSignature != "" {
+		canonicalMsg = sequencedForm(msg)
+		encodedSignature = msg.SequenceSignature
+	}
 
 	// Decode signature.
-	signature, err := base64.StdEncoding.DecodeString(msg.Signature)
+	signature, err := base64.StdEncoding.DecodeString(encodedSignature)
 	if err != nil {
 		log.Printf("Failed to decode signature: %v", err)
 		return false
@@ -295,39 +471,55 @@ func (c *Client) verifyMessageSignature(msg Message, senderPubKey ed25519.Public
 	return ed25519.Verify(senderPubKey, []byte(canonicalMsg), signature)
 }
 
+// Sign signs arbitrary data with the client's private key.
+func (c *Client) Sign(data []byte) []byte {
+	privateKey, _ := c.identity()
+	return ed25519.Sign(privateKey, data)
+}
+
+// PublicKey returns the client's public key.
+func (c *Client) PublicKey() ed25519.PublicKey {
+	_, publicKey := c.identity()
+	return publicKey
+}
+
 // GetUserPublicKey fetches a user's public key for verification.
 func (c *Client) GetUserPublicKey(userID string) (ed25519.PublicKey, error) {
-	// Check cache first (read lock)
+	// Check cache first (read lock). Keys cached without being fetched, like the client's
+	// own, do not expire.
 	c.pubKeyCacheMu.RLock()
 	pubKey, found := c.pubKeyCache[userID]
+	fetchedAt, fetched := c.pubKeyFetchedAt[userID]
+	ttl := c.keyCacheTTL
 	c.pubKeyCacheMu.RUnlock()
 
-	if found {
+	if found && (!fetched || time.Since(fetchedAt) < ttl) {
 		return pubKey, nil
 	}
+	if keys, ok := c.cachedKeys(userID); ok {
+		return keys[0], nil
+	}
 
 	// Not in cache, need to fetch from server.
-	endpoint := fmt.Sprintf("%s/auth/users/%s", c.serverURL, userID)
+	endpoint := fmt.Sprintf("%s/auth/users/%s", c.ServerURL(), userID)
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	// Add authorization header.
-	if c.jwtToken != "" {
-		req.Header.Add("Authorization", "Bearer "+c.jwtToken)
-	}
-
-	// Send request.
-	resp, err := c.httpClient().Do(req)
+	// Send request, with the authorization header.
+	resp, err := c.doAuthorized(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to get user public key: %s", string(body))
+		err := statusError(resp, "failed to get the public key of "+userID)
+		if resp.StatusCode == http.StatusNotFound {
+			err.Err = ErrRecipientUnknown
+		}
+		return nil, err
 	}
 
 	// Parse response.
@@ -346,41 +538,38 @@ func (c *Client) GetUserPublicKey(userID string) (ed25519.PublicKey, error) {
 		return nil, err
 	}
 
+	if len(pubKeyBytes) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key for user %s", userID)
+	}
+	pubKeyBytes = c.trustKeys(userID, []ed25519.PublicKey{pubKeyBytes})[0]
+
 	// Cache the public key (write lock)
 	c.pubKeyCacheMu.Lock()
-	c.pubKeyCache[userID] = pubKeyBytes
+	c.cacheKeyLocked(userID, pubKeyBytes, time.Now())
 	c.pubKeyCacheMu.Unlock()
 
 	return pubKeyBytes, nil
 }
 
-// SetInsecure configures the client to skip TLS verification (for testing only).
-func (c *Client) SetInsecure(insecure bool) {
-	c.insecure = insecure
+// SetCompression sets whether the client offers permessage-deflate compression when it
+// connects. Messages are compressed if the server accepts it; the setting applies from the
+// next connection.
+func (c *Client) SetCompression(enabled bool) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.compression = enabled
 }
 func (c *Client) SetReadLimit(limit int) {
 	c.wsConn.SetReadLimit(int64(limit))
 }
 
-// httpClient returns a custom HTTP client.
-func (c *Client) httpClient() *http.Client {
-	if c.insecure {
-		return &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			},
-		}
-	}
-	return http.DefaultClient
-}
-
 // Register calls the /auth/register endpoint.
 func (c *Client) Register(username string) error {
-	endpoint := fmt.Sprintf("%s/auth/register", c.serverURL)
+	endpoint := fmt.Sprintf("%s/auth/register", c.ServerURL())
 	payload := map[string]string{
 		"user_id":    c.UserID,
 		"username":   username,
-		"public_key": base64.StdEncoding.EncodeToString(c.publicKey),
+		"public_key": base64.StdEncoding.EncodeToString(c.PublicKey()),
 	}
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -402,13 +591,19 @@ func (c *Client) Register(username string) error {
 
 // Login performs challenge–response authentication using /auth/login.
 func (c *Client) Login() error {
+	return c.login(c.ServerURL())
+}
+
+// login authenticates against the given server.
+func (c *Client) login(serverURL string) error {
 	// Step 1: Get challenge.
-	loginURL := fmt.Sprintf("%s/auth/login", c.serverURL)
+	loginURL := fmt.Sprintf("%s/auth/login", serverURL)
 	payload := map[string]string{"user_id": c.UserID}
 	body, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
+	sent := time.Now()
 	resp, err := c.httpClient().Post(loginURL, "application/json", bytes.NewReader(body))
 	if err != nil {
 		return err
@@ -416,23 +611,27 @@ func (c *Client) Login() error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("login challenge failed: %s", string(b))
+		return responseError(resp, "login challenge failed")
 	}
 
 	var challengeResp map[string]string
 	if err := json.NewDecoder(resp.Body).Decode(&challengeResp); err != nil {
 		return err
 	}
+	if c.clock.observe(challengeResp["server_time"], sent, time.Now()) {
+		if status := c.clock.status(); !status.WithinTolerance {
+			log.Printf("WARNING: Local clock is off the server clock by %v, more than the %v tolerance", status.Skew.Abs(), status.Tolerance)
+		}
+	}
 	challenge, ok := challengeResp["challenge"]
 	if !ok {
 		return errors.New("challenge not found in response")
 	}
 
 	// Step 2: Sign challenge and verify.
-	signature := ed25519.Sign(c.privateKey, []byte(challenge))
+	signature := c.Sign([]byte(challenge))
 	sigB64 := base64.StdEncoding.EncodeToString(signature)
-	verifyURL := fmt.Sprintf("%s/auth/login?verify=true", c.serverURL)
+	verifyURL := fmt.Sprintf("%s/auth/login?verify=true", serverURL)
 	payloadVerify := map[string]string{
 		"user_id":   c.UserID,
 		"signature": sigB64,
@@ -449,8 +648,7 @@ func (c *Client) Login() error {
 	defer resp2.Body.Close()
 
 	if resp2.StatusCode != http.StatusOK {
-		b, _ := io.ReadAll(resp2.Body)
-		return fmt.Errorf("login verification failed: %s", string(b))
+		return responseError(resp2, "login verification failed")
 	}
 
 	var tokenResp map[string]string
@@ -461,17 +659,29 @@ func (c *Client) Login() error {
 	if !ok {
 		return errors.New("token not found in response")
 	}
-	c.jwtToken = token
+	c.setToken(token)
 	return nil
 }
 
 // Connect opens a WebSocket connection and launches the read and write pumps.
 func (c *Client) Connect() error {
-	wsURL := fmt.Sprintf("%s/ws?token=%s", c.serverURL, c.jwtToken)
-	parsedURL, err := url.Parse(wsURL)
+	serverURL := c.ServerURL()
+	conn, err := c.dial(serverURL)
 	if err != nil {
 		return err
 	}
+	c.attach(conn, serverURL, nil)
+	c.startMonitor()
+	return nil
+}
+
+// dial opens a WebSocket connection to a server and records the outcome in its statistics.
+func (c *Client) dial(serverURL string) (*websocket.Conn, error) {
+	wsURL := fmt.Sprintf("%s/ws?token=%s", serverURL, c.Token())
+	parsedURL, err := url.Parse(wsURL)
+	if err != nil {
+		return nil, err
+	}
 	// Convert HTTP(S) to WS(S) accordingly.
 	switch parsedURL.Scheme {
 	case "https":
@@ -479,51 +689,90 @@ func (c *Client) Connect() error {
 	case "http":
 		parsedURL.Scheme = "ws"
 	}
-	dialer := websocket.DefaultDialer
+	// A copy, so the settings of this client do not leak into other users of the default dialer
+	dialer := *websocket.DefaultDialer
+	c.connMu.RLock()
+	dialer.EnableCompression = c.compression
+	c.connMu.RUnlock()
 	if parsedURL.Scheme == "wss" {
-		dialer.TLSClientConfig = &tls.Config{InsecureSkipVerify: c.insecure}
+		dialer.TLSClientConfig = c.tlsConfig()
 	}
+	dialer.Proxy = c.proxyFunc()
 
-	conn, _, err := dialer.Dial(parsedURL.String(), nil)
+	conn, resp, err := dialer.Dial(parsedURL.String(), nil)
+	// A rejected token says nothing about the health of the server
+	c.record(serverURL, err == nil || (resp != nil && resp.StatusCode == http.StatusUnauthorized), 0)
 	if err != nil {
-		return err
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("%w: %v", ErrUnauthorized, err)
+		}
+		if resp != nil {
+			if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+				return nil, &RetryAfterError{StatusCode: resp.StatusCode, RetryAfter: delay, Message: err.Error()}
+			}
+		}
+		return nil, err
 	}
+	return conn, nil
+}
 
-	c.connMu.Lock()
-	c.wsConn = conn
-	c.connMu.Unlock()
+// closeRecv closes the channel of received messages once the client is disconnected.
+func (c *Client) closeRecv() {
+	select {
+	case <-c.doneCh:
+		c.recvOnce.Do(func() {
+			c.forwarders.Wait()
+			close(c.recvCh)
+		})
+	default:
+	}
+}
 
-	// Set pong handler for keep–alive.
-	c.wsConn.SetPongHandler(func(appData string) error {
-		c.wsConn.SetReadDeadline(time.Now().Add(60 * time.Second))
-		return nil
-	})
+// requeue keeps a prepared message that could not be written so it is resent after a reconnect.
+func (c *Client) requeue(msg Message) {
+	c.outboxMu.Lock()
+	c.outbox = append(c.outbox, msg)
+	c.outboxMu.Unlock()
+}
 
-	// Launch read and write pumps.
-	go c.readPump()
-	go c.writePump()
+// flushOutbox writes the messages left over from a previous connection. Messages that fail
+// again stay queued.
+func (c *Client) flushOutbox(conn *websocket.Conn) error {
+	c.outboxMu.Lock()
+	defer c.outboxMu.Unlock()
+	for len(c.outbox) > 0 {
+		if c.expired(c.outbox[0]) {
+			c.outbox = c.outbox[1:]
+			continue
+		}
+		msgBytes, err := json.Marshal(c.outbox[0])
+		if err != nil {
+			c.outbox = c.outbox[1:]
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := conn.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+			return err
+		}
+		c.sent(c.outbox[0], len(msgBytes))
+		c.outbox = c.outbox[1:]
+	}
 	return nil
 }
 
-// readPump continuously reads messages from the WebSocket.
-func (c *Client) readPump() {
-	defer close(c.recvCh)
+// readPump continuously reads messages from a WebSocket connection until it fails.
+func (c *Client) readPump(conn *websocket.Conn) {
+	defer c.closeRecv()
 	for {
 		select {
 		case <-c.doneCh:
 			return
 		default:
-			c.connMu.RLock()
-			conn := c.wsConn
-			c.connMu.RUnlock()
-			if conn == nil {
-				return
-			}
 			conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 			_, msgBytes, err := conn.ReadMessage()
 			if err != nil {
 				log.Printf("WebSocket read error: %v", err)
-				go c.handleReconnect()
+				c.connectionLost(conn, err)
 				return
 			}
 			var msg Message
@@ -532,33 +781,58 @@ func (c *Client) readPump() {
 				continue
 			}
 
+			// Acknowledgments of messages this client sent are reported, not delivered.
+			if msg.Ack != "" {
+				c.acknowledged(msg)
+				continue
+			}
+
+			// Membership changes of groups this client owns, and presence changes of watched
+			// users, are handled, not delivered.
+			if msg.From == "system" && (c.handleGroupEvent(msg) || c.handlePresence(msg)) {
+				continue
+			}
+
 			// Skip decryption/signature verification for system messages and forward messages.
 			if msg.From == "system" || msg.IsForwardMessage {
 				if msg.IsForwardMessage {
 					log.Printf("Received forward message, skipping decryption/verification")
 				}
-				c.recvCh <- msg
+				c.deliver(msg)
 				continue
 			}
 
 			// Verify the message signature if present.
 			if msg.Signature != "" {
-				// Get sender's public key.
-				senderPubKey, err := c.GetUserPublicKey(msg.From)
+				// Verify signature against the keys of the sender.
+				valid, err := c.verifySender(msg)
 				if err != nil {
 					log.Printf("Failed to get public key for user %s: %v", msg.From, err)
 					// We still deliver the message but add a warning about unverified signature.
 					msg.Status = "unverified"
-					c.recvCh <- msg
+					c.deliver(msg)
 					continue
 				}
-
-				// Verify signature.
-				if !c.verifyMessageSignature(msg, senderPubKey) {
+				if !valid {
 					log.Printf("WARNING: Invalid signature for message from %s", msg.From)
 					// We still deliver the message but mark it as having an invalid signature.
 					msg.Status = "invalid_signature"
-					c.recvCh <- msg
+					flagSpoofedBroadcast(&msg)
+					c.deliver(msg)
+					continue
+				}
+
+				// A signed timestamp too far ahead of the server clock cannot be trusted.
+				if !c.timestampValid(msg.Timestamp) {
+					log.Printf("WARNING: Timestamp of message from %s is %v ahead of the server clock", msg.From, msg.Timestamp.Sub(c.Now()).Round(time.Second))
+					msg.Status = "invalid_timestamp"
+					c.deliver(msg)
+					continue
+				}
+
+				// A captured message sent again is dropped.
+				if !c.freshSequence(msg) {
+					log.Printf("WARNING: Dropping replayed message from %s (sequence %d)", msg.From, msg.Sequence)
 					continue
 				}
 
@@ -571,11 +845,21 @@ func (c *Client) readPump() {
 				if msg.Status == "" {
 					msg.Status = "unsigned"
 				}
+				flagSpoofedBroadcast(&msg)
 			}
 
-			// If the message is a direct message to this client, attempt decryption.
-			if msg.To == c.UserID {
-				plaintext, err := decryptDirectMessage(msg.Content, c.privateKey)
+			// If the message is a direct message to this client, or to one of its groups,
+			// attempt decryption.
+			if groupID, ok := strings.CutPrefix(msg.To, GroupAddressPrefix); ok {
+				plaintext, err := c.openGroupContent(groupID, msg.Content)
+				if err != nil {
+					log.Printf("Failed to decrypt message from %s to group %s: %v", msg.From, groupID, err)
+					msg.Status = "decryption_failed"
+				} else {
+					msg.Content = plaintext
+				}
+			} else if msg.To == c.UserID {
+				plaintext, err := c.openDirect(msg)
 				if err != nil {
 					log.Printf("Failed to decrypt message from %s: %v", msg.From, err)
 					msg.Status = "decryption_failed"
@@ -584,62 +868,59 @@ func (c *Client) readPump() {
 				}
 			}
 
-			c.recvCh <- msg
+			// Parts of a fragmented message are held until the whole message was received.
+			msg, complete := c.reassemble(msg)
+			if !complete {
+				continue
+			}
+
+			// Group keys and file transfers are handled, not delivered
+			if msg.To == c.UserID && msg.Status != "decryption_failed" && (c.handleGroupKey(msg) || c.handleFileMessage(msg)) {
+				continue
+			}
+
+			c.deliver(msg)
+			if msg.To == c.UserID {
+				go c.acknowledge(msg, AckDelivered)
+			}
 		}
 	}
 }
 
-// writePump handles outgoing messages and periodic pings.
-func (c *Client) writePump() {
+// writePump handles outgoing messages and periodic pings on a WebSocket connection until the
+// connection is torn down. Messages stay queued in the meantime and are sent once reconnected.
+func (c *Client) writePump(conn *websocket.Conn, closed, written chan struct{}) {
 	ticker := time.NewTicker(54 * time.Second)
 	defer func() {
 		ticker.Stop()
-		c.connMu.RLock()
-		if c.wsConn != nil {
-			c.wsConn.Close()
-		}
-		c.connMu.RUnlock()
+		conn.Close()
+		close(written)
 	}()
+
+	// The send queue is only replaced before connecting
+	sendCh := c.queue()
+	err := c.writePresenceSubscription(conn)
+	if err == nil {
+		err = c.flushOutbox(conn)
+	}
+	if err == nil {
+		err = c.flushStored(conn)
+	}
+	if err != nil {
+		log.Printf("Write error: %v", err)
+		c.connectionLost(conn, err)
+		return
+	}
 	for {
 		select {
-		case msg, _ := <-c.sendCh:
-			c.connMu.RLock()
-			conn := c.wsConn
-			c.connMu.RUnlock()
-			if conn == nil {
-				return
-			}
-
-			// Skip encryption and signing for forward messages
-			if !msg.IsForwardMessage {
-				// For direct messages (non-broadcast), encrypt the message content.
-				if msg.To != "broadcast" {
-					recipientPub, err := c.GetUserPublicKey(msg.To)
-					if err != nil {
-						log.Printf("Failed to get recipient public key: %v", err)
-						continue
-					}
-					encryptedContent, err := encryptDirectMessage(msg.Content, recipientPub, c.privateKey)
-					if err != nil {
-						log.Printf("Failed to encrypt message: %v", err)
-						continue
-					}
-					msg.Content = encryptedContent
-				}
-
-				// Sign the message with our private key.
-				if err := c.signMessage(&msg); err != nil {
-					log.Printf("Failed to sign message: %v", err)
-					continue
-				}
-			} else {
-				// For forward messages, just log that we're skipping encryption and signing
-				log.Printf("Skipping encryption and signing for forward message")
+		case msg, _ := <-sendCh:
+			c.queueDepth()
+			if c.expired(msg) {
+				continue
 			}
-
-			// Add timestamp if not present.
-			if msg.Timestamp.IsZero() {
-				msg.Timestamp = time.Now()
+			msg, ok := c.prepare(msg)
+			if !ok {
+				continue
 			}
 
 			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
@@ -650,56 +931,157 @@ func (c *Client) writePump() {
 			}
 			if err := conn.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
 				log.Printf("Write error: %v", err)
-				go c.handleReconnect()
+				c.requeue(msg)
+				c.connectionLost(conn, err)
 				return
 			}
-		case <-ticker.C:
-			c.connMu.RLock()
-			conn := c.wsConn
-			c.connMu.RUnlock()
-			if conn == nil {
+			c.sent(msg, len(msgBytes))
+		case <-c.outboxReady:
+			if err := c.flushStored(conn); err != nil {
+				log.Printf("Write error: %v", err)
+				c.connectionLost(conn, err)
 				return
 			}
+		case <-ticker.C:
 			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				log.Printf("Ping error: %v", err)
-				go c.handleReconnect()
+				c.connectionLost(conn, err)
 				return
 			}
+		case <-closed:
+			return
 		case <-c.doneCh:
 			return
 		}
 	}
 }
 
-// SendMessage enqueues a message to be sent over the WebSocket.
+// prepare encrypts a direct message for its recipient and signs it, unless it is forwarded.
+// It reports false when the message has to be dropped.
+func (c *Client) prepare(msg Message) (Message, bool) {
+	// Skip encryption and signing for forward messages, for acknowledgments, which have
+	// no content and whose sender the server sets, and for control messages to the server
+	if msg.IsForwardMessage {
+		log.Printf("Skipping encryption and signing for forward message")
+	} else if msg.Ack == "" && msg.To != systemAddress {
+		// Messages to a group are encrypted with the group key, direct messages (non-broadcast)
+		// with the recipient's key.
+		if groupID, ok := strings.CutPrefix(msg.To, GroupAddressPrefix); ok {
+			encryptedContent, err := c.sealGroupContent(groupID, msg.Content)
+			if err != nil {
+				log.Printf("Failed to encrypt group message: %v", err)
+				c.encryptFailed(msg, err)
+				return msg, false
+			}
+			msg.Content = encryptedContent
+		} else if msg.To != broadcastAddress {
+			// Encrypt to the current key of the recipient, which follows its rotations
+			recipientKeys, err := c.GetUserPublicKeys(msg.To)
+			if err != nil {
+				log.Printf("Failed to get recipient public key: %v", err)
+				c.encryptFailed(msg, err)
+				return msg, false
+			}
+			encryptedContent, err := c.sealDirect(msg.To, msg.Content, recipientKeys[0])
+			if err != nil {
+				log.Printf("Failed to encrypt message: %v", err)
+				c.encryptFailed(msg, err)
+				return msg, false
+			}
+			msg.Content = encryptedContent
+		}
+
+		// Sign the message with our private key.
+		if err := c.signMessage(&msg); err != nil {
+			log.Printf("Failed to sign message: %v", err)
+			c.undelivered(msg, fmt.Errorf("failed to sign message: %w", err))
+			return msg, false
+		}
+	}
+
+	// Add timestamp if not present.
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = c.Now()
+	}
+	return msg, true
+}
+
+// SendMessage enqueues a message to be sent over the WebSocket. With an outbox store the
+// message is stored first, so it is not lost if the connection is down.
 func (c *Client) SendMessage(msg Message) error {
+	_, err := c.Send(msg)
+	return err
+}
+
+// Send enqueues a message like SendMessage and returns its message ID, which the
+// acknowledgments reported by DeliveryStatus refer to. It fails with ErrNotConnected once the
+// client was disconnected.
+func (c *Client) Send(msg Message) (string, error) {
+	// Messages to peers of a partner network are sent through it
+	if partner := c.routeFor(msg.To); partner != nil {
+		return partner.Send(msg)
+	}
+	if c.closed() {
+		return "", ErrNotConnected
+	}
+
 	// Ensure the message has the correct sender ID.
 	msg.From = c.UserID
+	if msg.MessageID == "" {
+		id, err := newMessageID()
+		if err != nil {
+			return "", err
+		}
+		msg.MessageID = id
+	}
 
 	// Add timestamp if not present.
 	if msg.Timestamp.IsZero() {
-		msg.Timestamp = time.Now()
+		msg.Timestamp = c.Now()
 	}
+	msg.queuedAt = time.Now()
 
-	// Enqueue the message (encryption will be done in writePump for direct messages).
-	select {
-	case c.sendCh <- msg:
-		return nil
-	case <-time.After(10 * time.Second):
-		return errors.New("send message timeout")
+	// Messages too large for the server are sent in parts
+	parts, err := c.fragment(msg)
+	if err != nil {
+		return "", err
+	}
+	var queued []Message
+	for _, part := range parts {
+		if !c.storeMessage(part) {
+			queued = append(queued, part)
+		}
+	}
+	if len(queued) > 0 {
+		// Enqueue the message (encryption will be done in writePump for direct messages).
+		if err := c.enqueue(queued...); err != nil {
+			return "", err
+		}
 	}
+	return msg.MessageID, nil
 }
 
 // BroadcastMessage creates a broadcast message (with a proper timestamp) and enqueues it.
 func (c *Client) BroadcastMessage(content string) error {
 	msg := Message{
 		From:      c.UserID,
-		To:        "broadcast",
+		To:        broadcastAddress,
 		Content:   content,
-		Timestamp: time.Now(),
+		Timestamp: c.Now(),
 	}
-	return c.SendMessage(msg)
+	return errors.Join(c.SendMessage(msg), c.broadcastNetworks(content))
+}
+
+// BroadcastMessageSync broadcasts a message like BroadcastMessage and waits until the server
+// of every network accepted it.
+func (c *Client) BroadcastMessageSync(ctx context.Context, content string) error {
+	_, err := c.SendMessageSync(ctx, Message{To: broadcastAddress, Content: content, Timestamp: c.Now()})
+	errs := []error{err}
+	for _, partner := range c.partnerClients() {
+		errs = append(errs, partner.BroadcastMessageSync(ctx, content))
+	}
+	return errors.Join(errs...)
 }
 
 // Messages returns the channel for received messages.
@@ -709,51 +1091,134 @@ func (c *Client) Messages() <-chan Message {
 
 // SendCh returns the send channel (used for testing spoofing attempts).
 func (c *Client) SendCh() chan<- Message {
-	return c.sendCh
+	return c.queue()
 }
 
 // Disconnect cleanly closes the WebSocket connection.
 func (c *Client) Disconnect() error {
+	// Partner networks are not added while closing
+	c.networksMu.Lock()
 	select {
 	case <-c.doneCh:
 		// Already closed.
 	default:
 		close(c.doneCh)
 	}
+	c.networksMu.Unlock()
+	c.disconnectNetworks()
 	c.connMu.Lock()
-	defer c.connMu.Unlock()
-	if c.wsConn != nil {
-		closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Client disconnecting")
-		if err := c.wsConn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(10*time.Second)); err != nil {
-			log.Printf("Error sending close message: %v", err)
-		}
-		err := c.wsConn.Close()
-		c.wsConn = nil
-		return err
+	conn := c.wsConn
+	c.wsConn = nil
+	c.connMu.Unlock()
+	if conn == nil {
+		return nil
 	}
-	return nil
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "Client disconnecting")
+	if err := conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(10*time.Second)); err != nil {
+		log.Printf("Error sending close message: %v", err)
+	}
+	err := conn.Close()
+	c.disconnected(nil)
+	return err
 }
 
-// handleReconnect attempts to re-establish the WebSocket connection using exponential backoff.
-func (c *Client) handleReconnect() {
+// handleReconnect attempts to re-establish the WebSocket connection following the reconnect
+// policy: exponential backoff with jitter, unless the server asks for a longer wait through a
+// Retry-After header, a maintenance window or its load status. Only one reconnect runs at a
+// time, and failures of a connection that was already replaced are ignored. If the server
+// rejects the token, for instance after a failover to a standby server, the client logs in
+// again before the next attempt. With several servers, each attempt goes to the server
+// pickServer chooses after checking their health.
+func (c *Client) handleReconnect(failed *websocket.Conn, cause error) {
 	c.connMu.Lock()
+	select {
+	case <-c.doneCh:
+		c.connMu.Unlock()
+		return
+	default:
+	}
+	if c.reconnecting || c.wsConn != failed {
+		c.connMu.Unlock()
+		return
+	}
+	c.reconnecting = true
+	wasConnected := c.wsConn != nil
 	if c.wsConn != nil {
 		c.wsConn.Close()
 		c.wsConn = nil
 	}
+	if c.connClosed != nil {
+		close(c.connClosed)
+		c.connClosed = nil
+	}
 	c.connMu.Unlock()
+	if wasConnected {
+		c.disconnected(cause)
+	}
+	defer func() {
+		c.connMu.Lock()
+		c.reconnecting = false
+		c.reconnectAttempt = 0
+		c.connMu.Unlock()
+	}()
+
+	policy := c.ReconnectPolicy()
+	backoff := policy.InitialDelay
+	if backoff <= 0 {
+		backoff = DefaultReconnectPolicy().InitialDelay
+	}
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-c.doneCh:
+			return
+		default:
+		}
+		if c.hasFailover() {
+			c.probeAll()
+			if target := c.pickServer(); target != c.ServerURL() {
+				log.Printf("Failing over to server %s", target)
+				c.setServer(target)
+			}
+		}
+		if hint := c.reconnectHint(); hint > 0 {
+			hint = policy.jitter(hint, true)
+			log.Printf("Server asked clients to wait; reconnecting in %v", hint.Round(time.Second))
+			if !c.wait(hint) {
+				return
+			}
+		}
 
-	interval := c.reconnectInterval
-	for {
 		log.Printf("Attempting to reconnect...")
-		if err := c.Connect(); err == nil {
+		err := c.Connect()
+		if err != nil && errors.Is(err, ErrUnauthorized) {
+			log.Printf("Server rejected the token; logging in again")
+			if err = c.RefreshToken(); err != nil {
+				log.Printf("Login failed: %v", err)
+			} else {
+				err = c.Connect()
+			}
+		}
+		c.reconnectAttempted(attempt, err)
+		if err == nil {
 			log.Printf("Reconnected successfully")
 			return
 		}
-		log.Printf("Reconnect failed; retrying in %v", interval)
-		time.Sleep(interval)
-		if interval < 60*time.Second {
-			interval *= 2
+		c.reconnectFailed(attempt, err)
+
+		delay, hinted := backoff, false
+		var retry *RetryAfterError
+		if errors.As(err, &retry) && retry.RetryAfter > delay {
+			delay, hinted = retry.RetryAfter, true
+		} else {
+			backoff *= 2
+			if policy.MaxDelay > 0 && backoff > policy.MaxDelay {
+				backoff = policy.MaxDelay
+			}
+		}
+		delay = policy.jitter(delay, hinted)
+		log.Printf("Reconnect failed; retrying in %v", delay)
+		if !c.wait(delay) {
+			return
 		}
 	}
 }
@@ -806,6 +1271,7 @@ func encryptDirectMessage(plaintext string, recipientEdPub ed25519.PublicKey, se
 
 	// Create the envelope.
 	env := EncryptedMessage{
+		Scheme:             DefaultEncryptionScheme,
 		EphemeralPublicKey: base64.StdEncoding.EncodeToString(ephemeralPub[:]),
 		KeyNonce:           base64.StdEncoding.EncodeToString(boxNonce),
 		EncryptedKey:       base64.StdEncoding.EncodeToString(encryptedSymKey),
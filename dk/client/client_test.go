@@ -328,8 +328,11 @@ func TestSendMessage(t *testing.T) {
 		Content: "Hello, this is a test message!",
 	}
 
-	// Spin a goroutine to receive from the send channel to prevent blocking.
+	// Spin a goroutine to receive from the send channel to prevent blocking. The test waits
+	// for it, so that it does not report after the test has completed.
+	received := make(chan struct{})
 	go func() {
+		defer close(received)
 		<-client.sendCh
 	}()
 
@@ -337,9 +340,12 @@ func TestSendMessage(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to send message: %v", err)
 	}
+	<-received
 
 	// Test sending a broadcast message.
+	received = make(chan struct{})
 	go func() {
+		defer close(received)
 		sentMsg := <-client.sendCh
 
 		// Verify broadcast message properties.
@@ -352,8 +358,13 @@ func TestSendMessage(t *testing.T) {
 		if sentMsg.Content != "Broadcast test" {
 			t.Errorf("Expected Content to be 'Broadcast test', got '%s'", sentMsg.Content)
 		}
-		if sentMsg.Signature == "" {
-			t.Error("Expected Signature to be set")
+
+		// Messages are signed as they are written, not as they are enqueued.
+		if sentMsg.Signature != "" {
+			t.Error("Expected the queued message not to be signed yet")
+		}
+		if prepared, ok := client.prepare(sentMsg); !ok || prepared.Signature == "" {
+			t.Error("Expected Signature to be set once the message is written")
 		}
 	}()
 
@@ -361,6 +372,7 @@ func TestSendMessage(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to send broadcast message: %v", err)
 	}
+	<-received
 }
 
 // package lib
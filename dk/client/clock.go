@@ -0,0 +1,90 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultTimestampTolerance is how far ahead of the client's estimate of the server time a
+// message timestamp may be before the message is marked "invalid_timestamp".
+const DefaultTimestampTolerance = 5 * time.Minute
+
+// ClockStatus describes how the client's clock compares to the server's.
+type ClockStatus struct {
+	// Skew is how far the server clock is ahead of the local clock; negative when it is behind
+	Skew      time.Duration `json:"skew"`
+	Tolerance time.Duration `json:"tolerance"`
+	// MeasuredAt is when the skew was last estimated, at login; zero if it never was
+	MeasuredAt time.Time `json:"measured_at"`
+	// WithinTolerance is false when the skew is larger than the timestamp tolerance, so that
+	// peers may reject the timestamps of this client's messages
+	WithinTolerance bool `json:"within_tolerance"`
+}
+
+// clock tracks the estimated skew between the local and the server clock.
+type clock struct {
+	mu         sync.RWMutex
+	skew       time.Duration
+	measuredAt time.Time
+	tolerance  time.Duration
+}
+
+// observe estimates the skew from a server time received in a response to a request sent at
+// sent and answered at received, assuming the server read its clock half way through.
+func (k *clock) observe(serverTime string, sent, received time.Time) bool {
+	server, err := time.Parse(time.RFC3339Nano, serverTime)
+	if err != nil {
+		return false
+	}
+	midpoint := sent.Add(received.Sub(sent) / 2)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.skew = server.Sub(midpoint)
+	k.measuredAt = received.UTC()
+	return true
+}
+
+// status returns the skew estimate and tolerance.
+func (k *clock) status() ClockStatus {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	tolerance := k.tolerance
+	if tolerance <= 0 {
+		tolerance = DefaultTimestampTolerance
+	}
+	return ClockStatus{
+		Skew:            k.skew,
+		Tolerance:       tolerance,
+		MeasuredAt:      k.measuredAt,
+		WithinTolerance: k.skew.Abs() <= tolerance,
+	}
+}
+
+// Now returns the current time on the server's clock, as estimated at login, in UTC.
+// Outgoing messages are stamped with it so that peers with different clocks agree on it.
+func (c *Client) Now() time.Time {
+	c.clock.mu.RLock()
+	defer c.clock.mu.RUnlock()
+	return time.Now().Add(c.clock.skew).UTC()
+}
+
+// ClockStatus returns the estimated skew between the local and the server clock.
+func (c *Client) ClockStatus() ClockStatus {
+	return c.clock.status()
+}
+
+// SetTimestampTolerance sets how far in the future a message timestamp may be; 0 restores
+// DefaultTimestampTolerance.
+func (c *Client) SetTimestampTolerance(tolerance time.Duration) {
+	c.clock.mu.Lock()
+	defer c.clock.mu.Unlock()
+	c.clock.tolerance = tolerance
+}
+
+// timestampValid reports whether a message timestamp is not further in the future than the
+// tolerance allows. Old timestamps are valid: messages may have waited on the server while
+// the recipient was offline.
+func (c *Client) timestampValid(timestamp time.Time) bool {
+	return !timestamp.After(c.Now().Add(c.clock.status().Tolerance))
+}
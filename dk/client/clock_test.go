@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockSkew(t *testing.T) {
+	client := NewClient("https://localhost", "alice", nil, nil)
+	if status := client.ClockStatus(); status.Skew != 0 || !status.WithinTolerance || status.Tolerance != DefaultTimestampTolerance {
+		t.Fatalf("Expected no skew before login, got %+v", status)
+	}
+
+	// The server answered a request that took two seconds with a clock ten minutes ahead
+	sent := time.Now()
+	received := sent.Add(2 * time.Second)
+	serverTime := sent.Add(time.Second + 10*time.Minute).In(time.FixedZone("UTC-5", -5*60*60))
+	if !client.clock.observe(serverTime.Format(time.RFC3339Nano), sent, received) {
+		t.Fatal("Expected the server time to be parsed")
+	}
+	status := client.ClockStatus()
+	if status.Skew != 10*time.Minute || status.WithinTolerance {
+		t.Errorf("Expected a skew of 10m beyond the tolerance, got %+v", status)
+	}
+	if now := client.Now(); now.Location() != time.UTC || now.Sub(time.Now()) < 9*time.Minute {
+		t.Errorf("Expected Now on the server clock in UTC, got %v", now)
+	}
+
+	// Timestamps are checked against the server clock, with the tolerance ahead of it
+	if !client.timestampValid(time.Now().Add(12 * time.Minute)) {
+		t.Error("Expected a timestamp within the tolerance of the server clock to be valid")
+	}
+	if client.timestampValid(time.Now().Add(20 * time.Minute)) {
+		t.Error("Expected a timestamp beyond the tolerance to be invalid")
+	}
+	if !client.timestampValid(time.Now().Add(-24 * time.Hour)) {
+		t.Error("Expected old timestamps to be valid")
+	}
+
+	client.SetTimestampTolerance(time.Hour)
+	if !client.ClockStatus().WithinTolerance || !client.timestampValid(time.Now().Add(20*time.Minute)) {
+		t.Error("Expected a larger tolerance to accept the skew")
+	}
+	if client.clock.observe("not a time", sent, received) {
+		t.Error("Expected an invalid server time to be ignored")
+	}
+}
@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestCompressionNegotiation(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		offered := make(chan string, 1)
+		received := make(chan Message, 1)
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			offered <- r.Header.Get("Sec-WebSocket-Extensions")
+			conn, err := (&websocket.Upgrader{EnableCompression: true}).Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			var msg Message
+			if conn.ReadJSON(&msg) == nil {
+				received <- msg
+			}
+		}))
+
+		pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+		c := NewClient(srv.URL, "alice", priv, pub)
+		c.SetCompression(enabled)
+		if err := c.Connect(); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		if extensions := <-offered; strings.Contains(extensions, "permessage-deflate") != enabled {
+			t.Errorf("With compression %v, the client offered %q", enabled, extensions)
+		}
+
+		// Large, repetitive payloads such as app submissions survive compression
+		content := strings.Repeat(`{"path":"app/src/main.py","content":"print('hello')"}`, 2000)
+		if err := c.BroadcastMessage(content); err != nil {
+			t.Fatalf("BroadcastMessage failed: %v", err)
+		}
+		if msg := receive(t, received); msg.Content != content {
+			t.Errorf("With compression %v, the message arrived changed", enabled)
+		}
+		c.Disconnect()
+		srv.Close()
+	}
+}
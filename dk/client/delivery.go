@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Acknowledgments of a sent message, in the order they normally arrive.
+const (
+	AckAccepted  = "accepted"  // The server stored the message
+	AckDelivered = "delivered" // The recipient's client received it
+	AckRead      = "read"      // The recipient's application consumed it, see MarkRead
+)
+
+// ackStates are the acknowledgments of a message in the order they normally arrive.
+var ackStates = []string{AckAccepted, AckDelivered, AckRead}
+
+// DeliveryStatus reports an acknowledgment of a message sent by the client.
+type DeliveryStatus struct {
+	MessageID string    `json:"message_id"` // As returned by Send
+	From      string    `json:"from"`       // "system" for the server, otherwise the recipient
+	State     string    `json:"state"`      // AckAccepted, AckDelivered or AckRead
+	At        time.Time `json:"at"`
+}
+
+// DeliveryStatus returns the channel of acknowledgments of sent messages. Direct messages are
+// acknowledged by the server, then by the recipient's client and application; broadcasts only
+// by the server. Acknowledgments arriving while the channel is full are dropped.
+func (c *Client) DeliveryStatus() <-chan DeliveryStatus {
+	return c.deliveryCh
+}
+
+// MarkRead tells the sender of a direct message that the application consumed it, through the
+// network it was received on. Messages that are not direct messages from a peer, or that have
+// no message ID, are ignored.
+func (c *Client) MarkRead(msg Message) error {
+	if network := c.Network(msg.Network); network != nil && network != c {
+		return network.MarkRead(msg)
+	}
+	return c.acknowledge(msg, AckRead)
+}
+
+// acknowledge sends an acknowledgment of a received direct message to its sender.
+func (c *Client) acknowledge(msg Message, state string) error {
+	if msg.MessageID == "" || msg.To != c.UserID || msg.From == "" || msg.From == "system" || msg.IsForwardMessage {
+		return nil
+	}
+	_, err := c.Send(Message{To: msg.From, MessageID: msg.MessageID, Ack: state})
+	if err != nil {
+		log.Printf("Failed to acknowledge message %s from %s: %v", msg.MessageID, msg.From, err)
+	}
+	return err
+}
+
+// SendMessageSync sends a message like Send and waits until the server accepted it, that is
+// stored it for its recipient, so the caller knows it will not be lost. It returns the message
+// ID, and an error if the message could not be sent or ctx is done first.
+func (c *Client) SendMessageSync(ctx context.Context, msg Message) (string, error) {
+	return c.SendMessageUntil(ctx, msg, AckAccepted)
+}
+
+// SendMessageUntil sends a message like Send and waits for its acknowledgment in state, or a
+// later one: AckAccepted by the server, AckDelivered to the recipient's client or AckRead by
+// its application. Broadcasts and group messages are only acknowledged by the server.
+func (c *Client) SendMessageUntil(ctx context.Context, msg Message, state string) (string, error) {
+	if partner := c.routeFor(msg.To); partner != nil {
+		return partner.SendMessageUntil(ctx, msg, state)
+	}
+	rank := slices.Index(ackStates, state)
+	if rank < 0 {
+		return "", fmt.Errorf("unknown acknowledgment state %q", state)
+	}
+	if _, group := strings.CutPrefix(msg.To, GroupAddressPrefix); rank > 0 && (group || msg.To == broadcastAddress) {
+		return "", fmt.Errorf("only the server acknowledges messages to %s", msg.To)
+	}
+	if msg.MessageID == "" {
+		id, err := newMessageID()
+		if err != nil {
+			return "", err
+		}
+		msg.MessageID = id
+	}
+
+	// The waiter is registered first, as the acknowledgment may arrive before Send returns
+	waiter := &deliveryWaiter{rank: rank, done: make(chan error, 1)}
+	c.waitersMu.Lock()
+	c.waiters[msg.MessageID] = waiter
+	c.waitersMu.Unlock()
+	defer func() {
+		c.waitersMu.Lock()
+		delete(c.waiters, msg.MessageID)
+		c.waitersMu.Unlock()
+	}()
+	if _, err := c.Send(msg); err != nil {
+		return "", err
+	}
+
+	select {
+	case err := <-waiter.done:
+		return msg.MessageID, err
+	case <-ctx.Done():
+		return msg.MessageID, fmt.Errorf("message %s not %s: %w", msg.MessageID, state, ctx.Err())
+	case <-c.doneCh:
+		return msg.MessageID, ErrNotConnected
+	}
+}
+
+// deliveryWaiter is a caller of SendMessageUntil waiting for the acknowledgment of a message.
+type deliveryWaiter struct {
+	rank int        // Index in ackStates of the acknowledgment waited for
+	done chan error // Receives nil once acknowledged, or why the message was not sent
+}
+
+// undelivered tells a caller of SendMessageUntil that its message was dropped.
+func (c *Client) undelivered(msg Message, err error) {
+	c.waitersMu.Lock()
+	defer c.waitersMu.Unlock()
+	if waiter, ok := c.waiters[msg.MessageID]; ok {
+		select {
+		case waiter.done <- err:
+		default:
+		}
+	}
+}
+
+// acknowledged reports an acknowledgment received for a message sent by the client.
+func (c *Client) acknowledged(msg Message) {
+	// Parts of a fragmented message other than the last are acknowledged with it
+	if isFragmentID(msg.MessageID) {
+		return
+	}
+	status := DeliveryStatus{MessageID: msg.MessageID, From: msg.From, State: msg.Ack, At: msg.Timestamp}
+	if status.At.IsZero() {
+		status.At = c.Now()
+	}
+	c.waitersMu.Lock()
+	if waiter, ok := c.waiters[msg.MessageID]; ok && slices.Index(ackStates, msg.Ack) >= waiter.rank {
+		select {
+		case waiter.done <- nil:
+		default:
+		}
+	}
+	c.waitersMu.Unlock()
+	select {
+	case c.deliveryCh <- status:
+	default:
+		log.Printf("Delivery status channel is full, dropping %s acknowledgment of %s", msg.Ack, msg.MessageID)
+	}
+}
+
+// newMessageID returns a random message ID.
+func newMessageID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,191 @@
+package lib
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ackServer acknowledges the messages it receives like the server does once they are stored,
+// and sends the messages of outgoing to the client.
+type ackServer struct {
+	received chan Message
+	outgoing chan Message
+}
+
+func (s *ackServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	go func() {
+		for {
+			var msg Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.MessageID != "" && msg.Ack == "" {
+				s.outgoing <- Message{From: "system", To: msg.From, MessageID: msg.MessageID, Ack: AckAccepted}
+			}
+			s.received <- msg
+		}
+	}()
+	for msg := range s.outgoing {
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+func receive(t *testing.T, ch <-chan Message) Message {
+	t.Helper()
+	select {
+	case msg := <-ch:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("No message received")
+		return Message{}
+	}
+}
+
+func TestDeliveryAcknowledgments(t *testing.T) {
+	server := &ackServer{received: make(chan Message, 10), outgoing: make(chan Message, 10)}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Disconnect()
+
+	// The server acknowledges a sent message once stored
+	if err := c.BroadcastMessage("hello"); err != nil {
+		t.Fatalf("BroadcastMessage failed: %v", err)
+	}
+	sent := receive(t, server.received)
+	if sent.MessageID == "" {
+		t.Fatal("Expected the message to get a message ID")
+	}
+	select {
+	case status := <-c.DeliveryStatus():
+		if status.MessageID != sent.MessageID || status.State != AckAccepted || status.From != "system" {
+			t.Errorf("Unexpected delivery status %+v", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No delivery status reported")
+	}
+
+	// Acknowledgments of the recipient are reported too
+	server.outgoing <- Message{From: "bob", To: "alice", MessageID: sent.MessageID, Ack: AckRead}
+	select {
+	case status := <-c.DeliveryStatus():
+		if status.MessageID != sent.MessageID || status.State != AckRead || status.From != "bob" {
+			t.Errorf("Unexpected delivery status %+v", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No read receipt reported")
+	}
+
+	// A received direct message is acknowledged on receipt, and once marked as read
+	bobPub, bobPriv, _ := ed25519.GenerateKey(rand.Reader)
+	bob := NewClient(srv.URL, "bob", bobPriv, bobPub)
+	content, err := bob.sealContent("a question", pub)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	server.outgoing <- Message{From: "bob", To: "alice", Content: content, MessageID: "bob-1"}
+	var msg Message
+	select {
+	case msg = <-c.Messages():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Direct message was not delivered")
+	}
+	if msg.Content != "a question" || msg.MessageID != "bob-1" {
+		t.Fatalf("Unexpected message %+v", msg)
+	}
+	ack := receive(t, server.received)
+	if ack.To != "bob" || ack.MessageID != "bob-1" || ack.Ack != AckDelivered || ack.Content != "" || ack.Signature != "" {
+		t.Errorf("Unexpected delivery acknowledgment %+v", ack)
+	}
+	if err := c.MarkRead(msg); err != nil {
+		t.Fatalf("MarkRead failed: %v", err)
+	}
+	if ack := receive(t, server.received); ack.MessageID != "bob-1" || ack.Ack != AckRead {
+		t.Errorf("Unexpected read receipt %+v", ack)
+	}
+
+	// Acknowledgments are not acknowledged
+	select {
+	case status := <-c.DeliveryStatus():
+		t.Errorf("Unexpected delivery status %+v", status)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSendMessageSync(t *testing.T) {
+	server := &ackServer{received: make(chan Message, 10), outgoing: make(chan Message, 10)}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Disconnect()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Returns once the server accepted the message
+	id, err := c.SendMessageSync(ctx, Message{To: "broadcast", Content: "hello"})
+	if err != nil {
+		t.Fatalf("SendMessageSync failed: %v", err)
+	}
+	if sent := receive(t, server.received); sent.MessageID != id {
+		t.Errorf("Expected the ID of the sent message, got %s and %s", id, sent.MessageID)
+	}
+	if _, err := c.SendMessageUntil(ctx, Message{To: "broadcast", Content: "hello"}, AckRead); err == nil {
+		t.Error("Expected broadcasts not to wait for read receipts")
+	}
+
+	// Waits for the recipient when asked to
+	bobPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	c.pubKeyCacheMu.Lock()
+	c.cacheKeysLocked("bob", []ed25519.PublicKey{bobPub}, time.Now(), true)
+	c.pubKeyCacheMu.Unlock()
+	result := make(chan error, 1)
+	go func() {
+		_, err := c.SendMessageUntil(ctx, Message{To: "bob", Content: "a question"}, AckDelivered)
+		result <- err
+	}()
+	sent := receive(t, server.received)
+	select {
+	case err := <-result:
+		t.Fatalf("Expected to wait for the recipient, got %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	server.outgoing <- Message{From: "bob", To: "alice", MessageID: sent.MessageID, Ack: AckDelivered}
+	if err := <-result; err != nil {
+		t.Errorf("Expected the message to be delivered, got %v", err)
+	}
+
+	// Fails when no acknowledgment comes in time, or the message cannot be sent
+	short, cancelShort := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancelShort()
+	if _, err := c.SendMessageUntil(short, Message{To: "bob", Content: "a question"}, AckRead); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+	if _, err := c.SendMessageSync(ctx, Message{To: "carol", Content: "a question"}); err == nil || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a message to a user without a key to fail, got %v", err)
+	}
+}
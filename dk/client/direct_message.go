@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 )
 
@@ -28,12 +27,12 @@ type DirectMessageResponse struct {
 // Note: This function now only sends messages to the authenticated user (token owner)
 // as the server no longer accepts a recipient field
 func (c *Client) SendDirectMessage(queryText string, _ string) (string, error) {
-	if c.jwtToken == "" {
+	if c.Token() == "" {
 		return "", fmt.Errorf("JWT token is not set; please login first")
 	}
 
 	// Construct the endpoint URL
-	endpoint := fmt.Sprintf("%s/direct-message/", c.serverURL)
+	endpoint := fmt.Sprintf("%s/direct-message/", c.ServerURL())
 
 	// Create the payload without recipient field
 	payload := DirectMessagePayload{
@@ -55,10 +54,9 @@ func (c *Client) SendDirectMessage(queryText string, _ string) (string, error) {
 
 	// Set the required headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.jwtToken)
 
-	// Send the request
-	resp, err := c.httpClient().Do(req)
+	// Send the request with the token
+	resp, err := c.doAuthorized(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to send direct message: %w", err)
 	}
@@ -66,8 +64,7 @@ func (c *Client) SendDirectMessage(queryText string, _ string) (string, error) {
 
 	// Check for a successful response
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("direct message request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return "", statusError(resp, "direct message request failed")
 	}
 
 	// Parse the response
@@ -89,12 +86,12 @@ func (c *Client) QuerySelf(queryText string) (string, error) {
 
 // RegisterDocument registers a new document with the RAG system
 func (c *Client) RegisterDocument(filename string, content string) (string, error) {
-	if c.jwtToken == "" {
+	if c.Token() == "" {
 		return "", fmt.Errorf("JWT token is not set; please login first")
 	}
 
 	// Construct the endpoint URL - use the register-document endpoint
-	endpoint := fmt.Sprintf("%s/register-document/", c.serverURL)
+	endpoint := fmt.Sprintf("%s/register-document/", c.ServerURL())
 
 	// Create the payload for document registration
 	payload := DirectMessagePayload{
@@ -117,10 +114,9 @@ func (c *Client) RegisterDocument(filename string, content string) (string, erro
 
 	// Set the required headers
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.jwtToken)
 
-	// Send the request
-	resp, err := c.httpClient().Do(req)
+	// Send the request with the token
+	resp, err := c.doAuthorized(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to register document: %w", err)
 	}
@@ -128,8 +124,7 @@ func (c *Client) RegisterDocument(filename string, content string) (string, erro
 
 	// Check for a successful response
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("document registration request failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return "", statusError(resp, "document registration request failed")
 	}
 
 	// Parse the response - match the structure of the response from the server
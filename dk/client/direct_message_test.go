@@ -42,22 +42,23 @@ func TestSendDirectMessage(t *testing.T) {
 		}
 
 		// Decode and verify the request payload
-		var payload DirectMessagePayload
-		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		var fields map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
 			t.Errorf("Failed to decode request body: %v", err)
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
 
-		// Verify payload fields
-		if payload.Type != "forward" {
-			t.Errorf("Expected Type 'forward', got '%s'", payload.Type)
+		// Verify payload fields; the server delivers to the owner of the token, so no
+		// recipient is sent
+		if fields["type"] != "forward" {
+			t.Errorf("Expected Type 'forward', got '%v'", fields["type"])
 		}
-		if payload.Query != "test query" {
-			t.Errorf("Expected Query 'test query', got '%s'", payload.Query)
+		if fields["query"] != "test query" {
+			t.Errorf("Expected Query 'test query', got '%v'", fields["query"])
 		}
-		if payload.Recipient != "recipient_id" {
-			t.Errorf("Expected Recipient 'recipient_id', got '%s'", payload.Recipient)
+		if _, ok := fields["recipient"]; ok {
+			t.Errorf("Expected no recipient, got '%v'", fields["recipient"])
 		}
 
 		// Return a successful response
@@ -86,21 +87,20 @@ func TestSendDirectMessage(t *testing.T) {
 		t.Errorf("Expected answer '%s', got '%s'", expectedAnswer, answer)
 	}
 
-	// Test error case: missing recipient
-	_, err = client.SendDirectMessage("test query", "")
-	if err == nil {
-		t.Error("Expected error for missing recipient, got nil")
+	// The recipient argument is ignored, so a missing one is not an error
+	if _, err = client.SendDirectMessage("test query", ""); err != nil {
+		t.Errorf("Expected the message to be sent without a recipient, got %v", err)
 	}
 
 	// Test convenience method for querying self
-	// Create a new server that verifies recipient is the same as sender
+	// Create a new server that verifies the query is sent with the sender's token
 	selfServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var payload DirectMessagePayload
 		json.NewDecoder(r.Body).Decode(&payload)
 
 		// Verify payload fields
-		if payload.Recipient != "test_user" {
-			t.Errorf("Expected Recipient 'test_user', got '%s'", payload.Recipient)
+		if r.Header.Get("Authorization") != "Bearer test_token" || payload.Query != "test self query" {
+			t.Errorf("Expected the self query with the sender's token, got %+v", payload)
 			http.Error(w, "Bad request", http.StatusBadRequest)
 			return
 		}
@@ -0,0 +1,112 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+)
+
+// reverseEncryptor is a toy scheme used to exercise the Encryptor hooks.
+type reverseEncryptor struct{}
+
+func (reverseEncryptor) Scheme() string { return "test-reverse" }
+
+func (reverseEncryptor) Encrypt(plaintext string, _ ed25519.PublicKey, _ ed25519.PrivateKey) (string, error) {
+	b := []byte(plaintext)
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func (e reverseEncryptor) Decrypt(payload string, _ ed25519.PrivateKey) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", err
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return string(b), nil
+}
+
+func TestDefaultEncryptionScheme(t *testing.T) {
+	senderPub, senderPriv, _ := ed25519.GenerateKey(rand.Reader)
+	recipientPub, recipientPriv, _ := ed25519.GenerateKey(rand.Reader)
+
+	sender := NewClient("https://example.com", "sender", senderPriv, senderPub)
+	recipient := NewClient("https://example.com", "recipient", recipientPriv, recipientPub)
+
+	sealed, err := sender.sealContent("hello", recipientPub)
+	if err != nil {
+		t.Fatalf("Failed to seal content: %v", err)
+	}
+
+	var env EncryptedMessage
+	if err := json.Unmarshal([]byte(sealed), &env); err != nil {
+		t.Fatalf("Failed to unmarshal envelope: %v", err)
+	}
+	if env.Scheme != DefaultEncryptionScheme {
+		t.Errorf("Expected scheme %s, got %s", DefaultEncryptionScheme, env.Scheme)
+	}
+	if env.EncryptedContent == "" || env.Payload != "" {
+		t.Error("Expected default scheme to use the flat envelope format")
+	}
+
+	plaintext, err := recipient.openContent(sealed)
+	if err != nil {
+		t.Fatalf("Failed to open content: %v", err)
+	}
+	if plaintext != "hello" {
+		t.Errorf("Expected 'hello', got '%s'", plaintext)
+	}
+
+	// Envelopes from clients that predate scheme identifiers still decrypt.
+	env.Scheme = ""
+	legacy, _ := json.Marshal(env)
+	if plaintext, err := recipient.openContent(string(legacy)); err != nil || plaintext != "hello" {
+		t.Errorf("Failed to open legacy envelope: %q, %v", plaintext, err)
+	}
+}
+
+func TestCustomEncryptor(t *testing.T) {
+	senderPub, senderPriv, _ := ed25519.GenerateKey(rand.Reader)
+	recipientPub, recipientPriv, _ := ed25519.GenerateKey(rand.Reader)
+
+	sender := NewClient("https://example.com", "sender", senderPriv, senderPub)
+	sender.SetEncryptor(reverseEncryptor{})
+
+	sealed, err := sender.sealContent("custom scheme", recipientPub)
+	if err != nil {
+		t.Fatalf("Failed to seal content: %v", err)
+	}
+
+	var env EncryptedMessage
+	if err := json.Unmarshal([]byte(sealed), &env); err != nil {
+		t.Fatalf("Failed to unmarshal envelope: %v", err)
+	}
+	if env.Scheme != "test-reverse" || env.Payload == "" {
+		t.Errorf("Expected wrapped test-reverse envelope, got %+v", env)
+	}
+
+	// A recipient without the scheme cannot open the message.
+	recipient := NewClient("https://example.com", "recipient", recipientPriv, recipientPub)
+	if _, err := recipient.openContent(sealed); err == nil {
+		t.Error("Expected error for unsupported scheme")
+	}
+
+	// Registering the scheme for decryption only keeps the default for sending.
+	recipient.RegisterEncryptor(reverseEncryptor{})
+	plaintext, err := recipient.openContent(sealed)
+	if err != nil {
+		t.Fatalf("Failed to open content: %v", err)
+	}
+	if plaintext != "custom scheme" {
+		t.Errorf("Expected 'custom scheme', got '%s'", plaintext)
+	}
+	if recipient.encryptor.Scheme() != DefaultEncryptionScheme {
+		t.Errorf("Expected recipient to keep sending with %s", DefaultEncryptionScheme)
+	}
+}
@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Causes of the errors of the client. Errors are wrapped with more detail, so callers tell
+// them apart with errors.Is.
+var (
+	// ErrUnauthorized: the server rejected the credentials of the client, or it has none yet.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrRecipientUnknown: the server has no user with the ID of a recipient.
+	ErrRecipientUnknown = errors.New("recipient unknown")
+	// ErrNotConnected: the application disconnected the client.
+	ErrNotConnected = errors.New("client is disconnected")
+	// ErrEncryption: a message could not be encrypted for its recipient.
+	ErrEncryption = errors.New("encryption failed")
+)
+
+// StatusError is a response of the server with an unexpected status code. A 401 response
+// matches ErrUnauthorized.
+type StatusError struct {
+	StatusCode int
+	Message    string
+	Err        error // The cause the status stands for, if any
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s (status code %d)", e.Message, e.StatusCode)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+func (e *StatusError) Is(target error) bool {
+	return target == ErrUnauthorized && e.StatusCode == http.StatusUnauthorized
+}
+
+// statusError describes a response with an unexpected status code, with its body.
+func statusError(resp *http.Response, message string) *StatusError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if detail := strings.TrimSpace(string(body)); detail != "" {
+		message = fmt.Sprintf("%s: %s", message, detail)
+	}
+	return &StatusError{StatusCode: resp.StatusCode, Message: message}
+}
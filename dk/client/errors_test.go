@@ -0,0 +1,58 @@
+package lib
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTypedErrors(t *testing.T) {
+	server := &ackServer{received: make(chan Message, 10), outgoing: make(chan Message, 10)}
+	mux := http.NewServeMux()
+	mux.Handle("/ws", server)
+	mux.HandleFunc("/auth/users/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "User not found", http.StatusNotFound)
+	})
+	mux.HandleFunc("/active-users", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	if _, err := c.GetActiveUsers(); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized, got %v", err)
+	}
+	var status *StatusError
+	if _, err := c.GetActiveUsers(); !errors.As(err, &status) || status.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected the status of the response, got %v", err)
+	}
+	if _, err := c.Groups(); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected ErrUnauthorized without a token, got %v", err)
+	}
+
+	c.jwtToken = "alice"
+	if _, err := c.GetUserPublicKeys("nobody"); !errors.Is(err, ErrRecipientUnknown) {
+		t.Errorf("Expected ErrRecipientUnknown, got %v", err)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := c.SendMessageSync(ctx, Message{To: "nobody", Content: "hello"})
+	if !errors.Is(err, ErrEncryption) || !errors.Is(err, ErrRecipientUnknown) {
+		t.Errorf("Expected an encryption error for an unknown recipient, got %v", err)
+	}
+
+	c.Disconnect()
+	if err := c.SendMessage(Message{To: "broadcast", Content: "hello"}); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("Expected ErrNotConnected, got %v", err)
+	}
+}
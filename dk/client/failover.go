@@ -0,0 +1,362 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// defaultProbeInterval is how often the health of the servers is checked when the client
+	// has more than one
+	defaultProbeInterval = 30 * time.Second
+	// recoveryProbes is the number of health checks in a row the preferred server must pass
+	// before the client moves back to it, so a flapping server does not drag clients along
+	recoveryProbes = 3
+	// statsWeight is the weight of the latest observation in the moving averages of latency
+	// and success rate
+	statsWeight = 0.3
+	// writerStopTimeout bounds the wait for the writer of a replaced connection to stop
+	writerStopTimeout = 15 * time.Second
+)
+
+// ServerStatus describes one of the servers the client can connect to.
+type ServerStatus struct {
+	URL         string        `json:"url"`
+	Preferred   bool          `json:"preferred"`    // The first server of the list
+	Connected   bool          `json:"connected"`    // The server the client uses
+	Healthy     bool          `json:"healthy"`      // Result of the last health check or connection attempt
+	Latency     time.Duration `json:"latency"`      // Moving average of the health check latency
+	SuccessRate float64       `json:"success_rate"` // Moving average of successful checks and connection attempts, from 0 to 1
+	LastChecked time.Time     `json:"last_checked,omitempty"`
+}
+
+// serverEndpoint holds the health statistics of a server.
+type serverEndpoint struct {
+	url         string
+	healthy     bool
+	latency     time.Duration
+	successRate float64
+	streak      int // Health checks passed in a row
+	lastChecked time.Time
+}
+
+// observe folds the outcome of a health check or connection attempt into the statistics.
+func (s *serverEndpoint) observe(ok bool, latency time.Duration, now time.Time) {
+	s.healthy = ok
+	s.lastChecked = now
+	outcome := 0.0
+	if ok {
+		outcome = 1
+		s.streak++
+		if latency > 0 {
+			if s.latency == 0 {
+				s.latency = latency
+			} else {
+				s.latency = time.Duration(statsWeight*float64(latency) + (1-statsWeight)*float64(s.latency))
+			}
+		}
+	} else {
+		s.streak = 0
+	}
+	s.successRate = statsWeight*outcome + (1-statsWeight)*s.successRate
+}
+
+// score ranks healthy servers: lower is better. Latency counts more on servers that often fail.
+func (s *serverEndpoint) score() float64 {
+	return float64(s.latency) / max(s.successRate, 0.05)
+}
+
+// SetServerURLs sets the servers the client fails over between, the preferred one first. The
+// client connects to the preferred server while it is healthy, and otherwise stays on the
+// server it uses as long as that one is healthy. If neither is, it reconnects to the healthy
+// server with the best latency and success rate. Once the preferred server has recovered, the
+// connection is moved back to it. Call it before Connect.
+func (c *Client) SetServerURLs(urls []string) error {
+	var servers []*serverEndpoint
+	seen := make(map[string]bool)
+	for _, raw := range urls {
+		raw = strings.TrimRight(strings.TrimSpace(raw), "/")
+		if raw == "" || seen[raw] {
+			continue
+		}
+		parsed, err := url.Parse(raw)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid server URL %q: expected http(s)://host[:port]", raw)
+		}
+		seen[raw] = true
+		// Servers are presumed healthy until checked, so the preferred one is tried first
+		servers = append(servers, &serverEndpoint{url: raw, healthy: true, successRate: 1, streak: recoveryProbes})
+	}
+	if len(servers) == 0 {
+		return errors.New("no server URL given")
+	}
+
+	c.serverMu.Lock()
+	defer c.serverMu.Unlock()
+	c.servers = servers
+	if !seen[c.serverURL] {
+		c.serverURL = servers[0].url
+	}
+	return nil
+}
+
+// ServerURL returns the URL of the server the client uses.
+func (c *Client) ServerURL() string {
+	c.serverMu.RLock()
+	defer c.serverMu.RUnlock()
+	return c.serverURL
+}
+
+// Servers reports the health of the servers the client can connect to.
+func (c *Client) Servers() []ServerStatus {
+	c.serverMu.RLock()
+	defer c.serverMu.RUnlock()
+	if len(c.servers) == 0 {
+		return []ServerStatus{{URL: c.serverURL, Preferred: true, Connected: true, Healthy: true, SuccessRate: 1}}
+	}
+	statuses := make([]ServerStatus, len(c.servers))
+	for i, s := range c.servers {
+		statuses[i] = ServerStatus{
+			URL:         s.url,
+			Preferred:   i == 0,
+			Connected:   s.url == c.serverURL,
+			Healthy:     s.healthy,
+			Latency:     s.latency,
+			SuccessRate: s.successRate,
+			LastChecked: s.lastChecked,
+		}
+	}
+	return statuses
+}
+
+// SelectServer checks the health of the servers and switches to the best one, as described
+// in SetServerURLs, returning its URL. Use it before logging in, so a client does not start
+// against a server that is down. The connection, if any, is not moved.
+func (c *Client) SelectServer() string {
+	if !c.hasFailover() {
+		return c.ServerURL()
+	}
+	c.probeAll()
+	target := c.pickServer()
+	c.setServer(target)
+	return target
+}
+
+// hasFailover reports whether the client has more than one server to choose from.
+func (c *Client) hasFailover() bool {
+	c.serverMu.RLock()
+	defer c.serverMu.RUnlock()
+	return len(c.servers) > 1
+}
+
+func (c *Client) setServer(serverURL string) {
+	c.serverMu.Lock()
+	defer c.serverMu.Unlock()
+	c.serverURL = serverURL
+}
+
+// record folds the outcome of a health check or connection attempt into the statistics of a
+// server.
+func (c *Client) record(serverURL string, ok bool, latency time.Duration) {
+	c.serverMu.Lock()
+	defer c.serverMu.Unlock()
+	for _, s := range c.servers {
+		if s.url == serverURL {
+			s.observe(ok, latency, time.Now())
+			return
+		}
+	}
+}
+
+// probe checks the health endpoint of a server. Servers in maintenance or overloaded answer
+// with an error status and count as unhealthy.
+func (c *Client) probe(serverURL string) (bool, time.Duration) {
+	client := *c.httpClient()
+	client.Timeout = 5 * time.Second
+	start := time.Now()
+	resp, err := client.Get(serverURL + "/health")
+	if err != nil {
+		return false, 0
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK, time.Since(start)
+}
+
+// probeAll checks the health of every server in parallel.
+func (c *Client) probeAll() {
+	c.serverMu.RLock()
+	urls := make([]string, len(c.servers))
+	for i, s := range c.servers {
+		urls[i] = s.url
+	}
+	c.serverMu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, serverURL := range urls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ok, latency := c.probe(serverURL)
+			c.record(serverURL, ok, latency)
+		}()
+	}
+	wg.Wait()
+}
+
+// pickServer chooses the server to connect to: the preferred one if it is healthy, else the
+// current one if it is healthy, else the healthy server with the best score. When no server
+// is healthy it moves on to the next server in the list, so every server gets tried.
+func (c *Client) pickServer() string {
+	c.serverMu.RLock()
+	defer c.serverMu.RUnlock()
+	if len(c.servers) == 0 {
+		return c.serverURL
+	}
+	if c.servers[0].healthy {
+		return c.servers[0].url
+	}
+	current := -1
+	var best *serverEndpoint
+	for i, s := range c.servers {
+		if s.url == c.serverURL {
+			current = i
+			if s.healthy {
+				return s.url
+			}
+		}
+		if s.healthy && (best == nil || s.score() < best.score()) {
+			best = s
+		}
+	}
+	if best != nil {
+		return best.url
+	}
+	return c.servers[(current+1)%len(c.servers)].url
+}
+
+// preferredRecovered returns the preferred server if the client is elsewhere and the
+// preferred server has passed enough health checks in a row to move back to it.
+func (c *Client) preferredRecovered() (string, bool) {
+	c.serverMu.RLock()
+	defer c.serverMu.RUnlock()
+	if len(c.servers) < 2 {
+		return "", false
+	}
+	preferred := c.servers[0]
+	if preferred.url == c.serverURL || !preferred.healthy || preferred.streak < recoveryProbes {
+		return "", false
+	}
+	return preferred.url, true
+}
+
+// startMonitor starts checking the health of the servers in the background, once, when the
+// client has more than one.
+func (c *Client) startMonitor() {
+	if !c.hasFailover() {
+		return
+	}
+	c.monitorOnce.Do(func() { go c.monitor() })
+}
+
+// monitor checks the health of the servers periodically until the client disconnects, and
+// moves the connection back to the preferred server once it has recovered.
+func (c *Client) monitor() {
+	interval := c.probeInterval
+	if interval <= 0 {
+		interval = defaultProbeInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.doneCh:
+			return
+		case <-ticker.C:
+			c.probeAll()
+			if target, ok := c.preferredRecovered(); ok {
+				c.migrate(target)
+			}
+		}
+	}
+}
+
+// migrate moves a working connection to another server without dropping messages: the new
+// connection is opened first, and messages the old one could not write are sent on the new
+// one. If the other server cannot be reached, the client stays where it is.
+func (c *Client) migrate(target string) {
+	c.connMu.RLock()
+	current, reconnecting := c.wsConn, c.reconnecting
+	c.connMu.RUnlock()
+	if current == nil || reconnecting {
+		return
+	}
+
+	log.Printf("Moving the connection to server %s", target)
+	conn, err := c.dial(target)
+	if err != nil && errors.Is(err, ErrUnauthorized) {
+		// Tokens issued by one server are not necessarily accepted by another
+		if err = c.login(target); err == nil {
+			conn, err = c.dial(target)
+		}
+	}
+	if err != nil {
+		log.Printf("Could not move the connection to server %s: %v", target, err)
+		return
+	}
+	if !c.attach(conn, target, current) {
+		log.Printf("Connection changed while moving to server %s; keeping it", target)
+		return
+	}
+	log.Printf("Connection moved to server %s", target)
+}
+
+// attach makes conn, opened to serverURL, the connection of the client and tears down the
+// previous one. The pumps of the new connection start once the writer of the previous one has
+// stopped, so messages it could not write are resent first and in order. If only is set, conn
+// is attached only while only is still the connection of the client, and attach reports
+// whether it was.
+func (c *Client) attach(conn *websocket.Conn, serverURL string, only *websocket.Conn) bool {
+	closed, written := make(chan struct{}), make(chan struct{})
+	c.connMu.Lock()
+	if only != nil && (c.wsConn != only || c.reconnecting) {
+		c.connMu.Unlock()
+		conn.Close()
+		return false
+	}
+	if c.connClosed != nil {
+		close(c.connClosed)
+	}
+	previousWriter := c.writerDone
+	c.wsConn, c.connClosed, c.writerDone = conn, closed, written
+	c.connectedAt = time.Now()
+	c.setServer(serverURL)
+	c.connMu.Unlock()
+
+	if previousWriter != nil {
+		select {
+		case <-previousWriter:
+		case <-time.After(writerStopTimeout):
+			log.Printf("Writer of the previous connection did not stop in time")
+		}
+	}
+
+	// Set pong handler for keep–alive.
+	conn.SetPongHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return nil
+	})
+
+	// Launch read and write pumps.
+	go c.readPump(conn)
+	go c.writePump(conn, closed, written)
+	c.connected(serverURL)
+	return true
+}
@@ -0,0 +1,215 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// switchableServer is a server that can be taken down, dropping its connections, and brought
+// back up.
+type switchableServer struct {
+	mu       sync.Mutex
+	down     bool
+	conns    []*websocket.Conn
+	received chan Message
+}
+
+func (s *switchableServer) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+	if down {
+		for _, conn := range s.conns {
+			conn.Close()
+		}
+		s.conns = nil
+	}
+}
+
+func (s *switchableServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	down := s.down
+	s.mu.Unlock()
+	if down {
+		http.Error(w, "Server is down", http.StatusServiceUnavailable)
+		return
+	}
+	switch r.URL.Path {
+	case "/health":
+		json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+	case "/ws":
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		for {
+			var msg Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			s.received <- msg
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func expectMessage(t *testing.T, received chan Message, want string) {
+	t.Helper()
+	select {
+	case msg := <-received:
+		if msg.Content != want {
+			t.Errorf("Expected %q, got %q", want, msg.Content)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Message %q was not delivered", want)
+	}
+}
+
+func TestFailoverMovesBackToPreferredServer(t *testing.T) {
+	preferred := &switchableServer{down: true, received: make(chan Message, 10)}
+	backup := &switchableServer{received: make(chan Message, 10)}
+	preferredSrv, backupSrv := httptest.NewServer(preferred), httptest.NewServer(backup)
+	defer preferredSrv.Close()
+	defer backupSrv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(preferredSrv.URL, "alice", priv, pub)
+	if err := c.SetServerURLs([]string{preferredSrv.URL, backupSrv.URL + "/"}); err != nil {
+		t.Fatalf("SetServerURLs failed: %v", err)
+	}
+	c.probeInterval = 20 * time.Millisecond
+	c.jwtToken = "token"
+
+	if got := c.SelectServer(); got != backupSrv.URL {
+		t.Fatalf("Expected the backup server while the preferred one is down, got %s", got)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Disconnect()
+	if err := c.BroadcastMessage("on backup"); err != nil {
+		t.Fatalf("BroadcastMessage failed: %v", err)
+	}
+	expectMessage(t, backup.received, "on backup")
+
+	preferred.setDown(false)
+	deadline := time.Now().Add(5 * time.Second)
+	for c.ServerURL() != preferredSrv.URL {
+		if time.Now().After(deadline) {
+			t.Fatal("Connection was not moved back to the preferred server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := c.BroadcastMessage("on preferred"); err != nil {
+		t.Fatalf("BroadcastMessage failed: %v", err)
+	}
+	expectMessage(t, preferred.received, "on preferred")
+
+	statuses := c.Servers()
+	if len(statuses) != 2 || !statuses[0].Preferred || !statuses[0].Connected || statuses[1].Connected {
+		t.Errorf("Unexpected server statuses: %+v", statuses)
+	}
+}
+
+func TestReconnectFailsOverToHealthyServer(t *testing.T) {
+	primary := &switchableServer{received: make(chan Message, 10)}
+	secondary := &switchableServer{received: make(chan Message, 10)}
+	primarySrv, secondarySrv := httptest.NewServer(primary), httptest.NewServer(secondary)
+	defer primarySrv.Close()
+	defer secondarySrv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(primarySrv.URL, "alice", priv, pub)
+	if err := c.SetServerURLs([]string{primarySrv.URL, secondarySrv.URL}); err != nil {
+		t.Fatalf("SetServerURLs failed: %v", err)
+	}
+	c.probeInterval = time.Hour
+	c.SetReconnectInterval(10 * time.Millisecond)
+	c.jwtToken = "token"
+	reconnected := make(chan struct{}, 1)
+	c.SetHooks(Hooks{OnReconnect: func(attempt int, err error) {
+		if err == nil {
+			reconnected <- struct{}{}
+		}
+	}})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Disconnect()
+
+	primary.setDown(true)
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not reconnect")
+	}
+	if err := c.BroadcastMessage("after failover"); err != nil {
+		t.Fatalf("BroadcastMessage failed: %v", err)
+	}
+	expectMessage(t, secondary.received, "after failover")
+	if c.ServerURL() != secondarySrv.URL {
+		t.Errorf("Expected the client to use %s, got %s", secondarySrv.URL, c.ServerURL())
+	}
+}
+
+func TestPickServer(t *testing.T) {
+	c := NewClient("https://a.example", "alice", nil, nil)
+	if err := c.SetServerURLs([]string{"https://a.example", "https://b.example", "https://c.example"}); err != nil {
+		t.Fatalf("SetServerURLs failed: %v", err)
+	}
+	set := func(i int, healthy bool, latency time.Duration, rate float64) {
+		s := c.servers[i]
+		s.healthy, s.latency, s.successRate = healthy, latency, rate
+	}
+
+	if got := c.pickServer(); got != "https://a.example" {
+		t.Errorf("Expected the preferred server, got %s", got)
+	}
+
+	set(0, false, 0, 0)
+	set(1, true, 80*time.Millisecond, 1)
+	set(2, true, 20*time.Millisecond, 1)
+	if got := c.pickServer(); got != "https://c.example" {
+		t.Errorf("Expected the fastest healthy server, got %s", got)
+	}
+
+	// Sticky: a healthy current server is kept even if another is faster
+	c.setServer("https://b.example")
+	if got := c.pickServer(); got != "https://b.example" {
+		t.Errorf("Expected to stay on the current server, got %s", got)
+	}
+
+	// A fast server that often fails ranks behind a slower reliable one
+	c.setServer("https://a.example")
+	set(2, true, 20*time.Millisecond, 0.1)
+	if got := c.pickServer(); got != "https://b.example" {
+		t.Errorf("Expected the reliable server, got %s", got)
+	}
+
+	// With no healthy server, the next one in the list is tried
+	set(1, false, 0, 0)
+	set(2, false, 0, 0)
+	if got := c.pickServer(); got != "https://b.example" {
+		t.Errorf("Expected the next server, got %s", got)
+	}
+
+	if err := c.SetServerURLs([]string{"ftp://a.example"}); err == nil {
+		t.Error("Expected an error for a non-HTTP server URL")
+	}
+	if err := c.SetServerURLs(nil); err == nil {
+		t.Error("Expected an error without servers")
+	}
+}
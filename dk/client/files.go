@@ -0,0 +1,445 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Types of the messages of a file transfer. They are handled by the client and not delivered
+// to the application.
+const (
+	fileOfferMessageType   = "file_offer"   // Sender to receiver: the file and its hashes
+	fileRequestMessageType = "file_request" // Receiver to sender: the chunks to send
+	fileChunkMessageType   = "file_chunk"   // Sender to receiver: one chunk of the file
+	fileDoneMessageType    = "file_done"    // Receiver to sender: the file arrived whole
+)
+
+const (
+	// FileChunkSize is the file data sent per message. Encoded and encrypted it stays well
+	// below the message size limit of the server.
+	FileChunkSize = 256 << 10
+	// MaxFileSize is the largest file that can be sent with SendFile
+	MaxFileSize = 256 << 20
+	// fileWindow is how many chunks a receiver requests at a time
+	fileWindow = 8
+	// fileOfferTTL is how long a sender keeps serving the chunks of an offered file
+	fileOfferTTL = 24 * time.Hour
+)
+
+// fileStallTimeout is how long a receiver waits for requested chunks before requesting them
+// again, e.g. after the sender reconnected.
+var fileStallTimeout = 30 * time.Second
+
+// FileOffer describes a file a user offered to send. The hashes are those of the file when it
+// was offered; every chunk and the whole file are checked against them.
+type FileOffer struct {
+	TransferID  string            `json:"transfer_id"`
+	From        string            `json:"from,omitempty"` // Set by the receiving client
+	Name        string            `json:"name"`
+	Size        int64             `json:"size"`
+	SHA256      string            `json:"sha256"`       // Hex digest of the file
+	ChunkSize   int               `json:"chunk_size"`   // Size of every chunk but the last
+	ChunkHashes []string          `json:"chunk_hashes"` // Hex digest of each chunk
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+// Chunks returns the number of chunks of the file.
+func (o FileOffer) Chunks() int {
+	if o.Size == 0 || o.ChunkSize <= 0 {
+		return 0
+	}
+	return int((o.Size + int64(o.ChunkSize) - 1) / int64(o.ChunkSize))
+}
+
+// chunkLen returns the size of a chunk of the file.
+func (o FileOffer) chunkLen(index int) int {
+	return int(min(int64(o.ChunkSize), o.Size-int64(index)*int64(o.ChunkSize)))
+}
+
+// validate checks that the description of a file is consistent.
+func (o FileOffer) validate() error {
+	// The transfer ID names the partial file, so it must be the hex ID SendFile creates
+	if id, err := hex.DecodeString(o.TransferID); err != nil || len(id) != 16 {
+		return fmt.Errorf("file offer %q has an invalid transfer ID", o.TransferID)
+	}
+	if o.Size < 0 || o.Size > MaxFileSize || o.ChunkSize <= 0 || o.ChunkSize > FileChunkSize {
+		return fmt.Errorf("file offer %q has an invalid size", o.TransferID)
+	}
+	if len(o.ChunkHashes) != o.Chunks() {
+		return fmt.Errorf("file offer %s has %d chunk hashes for %d chunks", o.TransferID, len(o.ChunkHashes), o.Chunks())
+	}
+	return nil
+}
+
+// fileMessage is the content of the messages of a file transfer.
+type fileMessage struct {
+	Type       string     `json:"type"`
+	TransferID string     `json:"transfer_id"`
+	Offer      *FileOffer `json:"offer,omitempty"`  // file_offer
+	Chunks     []int      `json:"chunks,omitempty"` // file_request
+	Index      int        `json:"index"`            // file_chunk
+	Data       string     `json:"data,omitempty"`   // file_chunk, base64
+}
+
+// outgoingFile is a file offered by the client, served until it arrived or the offer expired.
+type outgoingFile struct {
+	offer     FileOffer
+	to        string
+	path      string
+	offeredAt time.Time
+}
+
+// fileChunk is a chunk received for a file being received.
+type fileChunk struct {
+	index int
+	data  []byte
+}
+
+// FileOffers returns a channel of the files offered to the client. Offers are dropped while
+// the channel is full; a dropped file is offered again only if the sender calls SendFile again.
+func (c *Client) FileOffers() <-chan FileOffer {
+	return c.fileOffers
+}
+
+// SendFile offers a file to a user and returns the offer. The file is sent in chunks, each in
+// its own encrypted message, as the receiver requests them with ReceiveFile, so an interrupted
+// transfer resumes where it stopped. The file must not change until it has arrived.
+func (c *Client) SendFile(to, path string, metadata map[string]string) (FileOffer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FileOffer{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return FileOffer{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return FileOffer{}, fmt.Errorf("%s is not a regular file", path)
+	}
+	if info.Size() > MaxFileSize {
+		return FileOffer{}, fmt.Errorf("%s is %d bytes; files are limited to %d bytes", path, info.Size(), MaxFileSize)
+	}
+
+	transferID, err := newMessageID()
+	if err != nil {
+		return FileOffer{}, err
+	}
+	offer := FileOffer{
+		TransferID:  transferID,
+		Name:        filepath.Base(path),
+		Size:        info.Size(),
+		ChunkSize:   FileChunkSize,
+		ChunkHashes: []string{},
+		Metadata:    metadata,
+	}
+	fileHash := sha256.New()
+	buf := make([]byte, FileChunkSize)
+	for {
+		n, err := io.ReadFull(f, buf)
+		if n > 0 {
+			fileHash.Write(buf[:n])
+			sum := sha256.Sum256(buf[:n])
+			offer.ChunkHashes = append(offer.ChunkHashes, hex.EncodeToString(sum[:]))
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return FileOffer{}, err
+		}
+	}
+	offer.SHA256 = hex.EncodeToString(fileHash.Sum(nil))
+	if err := offer.validate(); err != nil {
+		// The file changed while it was read
+		return FileOffer{}, err
+	}
+
+	c.filesMu.Lock()
+	for id, out := range c.outgoingFiles {
+		if time.Since(out.offeredAt) > fileOfferTTL {
+			delete(c.outgoingFiles, id)
+		}
+	}
+	c.outgoingFiles[transferID] = &outgoingFile{offer: offer, to: to, path: path, offeredAt: time.Now()}
+	c.filesMu.Unlock()
+
+	if err := c.sendFileMessage(to, fileMessage{Type: fileOfferMessageType, TransferID: transferID, Offer: &offer}); err != nil {
+		c.filesMu.Lock()
+		delete(c.outgoingFiles, transferID)
+		c.filesMu.Unlock()
+		return FileOffer{}, fmt.Errorf("failed to offer %s to %s: %w", offer.Name, to, err)
+	}
+	return offer, nil
+}
+
+// ReceiveFile receives an offered file into a directory and returns its path once every chunk
+// arrived and the file matches its hash. The data received so far is kept in a partial file
+// in the directory, so calling ReceiveFile again with the same offer, e.g. after ctx was
+// cancelled or the application restarted, only requests the missing chunks.
+func (c *Client) ReceiveFile(ctx context.Context, offer FileOffer, dir string) (string, error) {
+	if err := offer.validate(); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	partPath := filepath.Join(dir, "."+offer.TransferID+".part")
+	part, err := os.OpenFile(partPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer part.Close()
+
+	// Chunks already in the partial file count only if they match their hash
+	missing := make(map[int]bool)
+	for index := range offer.Chunks() {
+		if !partChunkValid(part, offer, index) {
+			missing[index] = true
+		}
+	}
+	if err := part.Truncate(offer.Size); err != nil {
+		return "", err
+	}
+
+	key := offer.From + "/" + offer.TransferID
+	chunks := make(chan fileChunk, fileWindow*2)
+	c.filesMu.Lock()
+	c.incomingFiles[key] = chunks
+	c.filesMu.Unlock()
+	defer func() {
+		c.filesMu.Lock()
+		delete(c.incomingFiles, key)
+		c.filesMu.Unlock()
+	}()
+
+	var requested []int
+	request := func() error {
+		if len(requested) == 0 {
+			for index := range offer.Chunks() {
+				if missing[index] {
+					requested = append(requested, index)
+					if len(requested) == fileWindow {
+						break
+					}
+				}
+			}
+		}
+		if len(requested) == 0 {
+			return nil
+		}
+		return c.sendFileMessage(offer.From, fileMessage{Type: fileRequestMessageType, TransferID: offer.TransferID, Chunks: requested})
+	}
+	if err := request(); err != nil {
+		return "", err
+	}
+	stall := time.NewTimer(fileStallTimeout)
+	defer stall.Stop()
+	for len(missing) > 0 {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-stall.C:
+			log.Printf("No chunk of %s from %s for %s; requesting them again", offer.Name, offer.From, fileStallTimeout)
+			if err := request(); err != nil {
+				return "", err
+			}
+			stall.Reset(fileStallTimeout)
+		case chunk := <-chunks:
+			if !missing[chunk.index] {
+				continue
+			}
+			sum := sha256.Sum256(chunk.data)
+			if len(chunk.data) != offer.chunkLen(chunk.index) || hex.EncodeToString(sum[:]) != offer.ChunkHashes[chunk.index] {
+				log.Printf("Chunk %d of %s from %s does not match its hash", chunk.index, offer.Name, offer.From)
+				continue
+			}
+			if _, err := part.WriteAt(chunk.data, int64(chunk.index)*int64(offer.ChunkSize)); err != nil {
+				return "", err
+			}
+			delete(missing, chunk.index)
+			requested = removeChunk(requested, chunk.index)
+			if len(requested) == 0 {
+				if err := request(); err != nil {
+					return "", err
+				}
+			}
+			stall.Reset(fileStallTimeout)
+		}
+	}
+
+	if err := part.Sync(); err != nil {
+		return "", err
+	}
+	if _, err := part.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	fileHash := sha256.New()
+	if _, err := io.Copy(fileHash, part); err != nil {
+		return "", err
+	}
+	if hex.EncodeToString(fileHash.Sum(nil)) != offer.SHA256 {
+		os.Remove(partPath)
+		return "", fmt.Errorf("%s from %s does not match its SHA-256 hash", offer.Name, offer.From)
+	}
+	path := filepath.Join(dir, receivedFileName(offer))
+	if err := os.Rename(partPath, path); err != nil {
+		return "", err
+	}
+	if err := c.sendFileMessage(offer.From, fileMessage{Type: fileDoneMessageType, TransferID: offer.TransferID}); err != nil {
+		log.Printf("Failed to tell %s that %s arrived: %v", offer.From, offer.Name, err)
+	}
+	return path, nil
+}
+
+// partChunkValid reports whether a chunk of a partial file was already received.
+func partChunkValid(part *os.File, offer FileOffer, index int) bool {
+	data := make([]byte, offer.chunkLen(index))
+	if _, err := part.ReadAt(data, int64(index)*int64(offer.ChunkSize)); err != nil {
+		return false
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) == offer.ChunkHashes[index]
+}
+
+// removeChunk removes a chunk index from a list.
+func removeChunk(indices []int, index int) []int {
+	for i, requested := range indices {
+		if requested == index {
+			return append(indices[:i], indices[i+1:]...)
+		}
+	}
+	return indices
+}
+
+// receivedFileName returns a name to save a received file under that cannot escape its
+// directory; the start of the transfer ID keeps files of the same name apart.
+func receivedFileName(offer FileOffer) string {
+	name := filepath.Base(filepath.Clean("/" + strings.ReplaceAll(offer.Name, `\`, "/")))
+	name = strings.TrimLeft(name, ".")
+	if name == "" || name == "/" {
+		name = "file"
+	}
+	return offer.TransferID[:min(8, len(offer.TransferID))] + "-" + name
+}
+
+// sendFileMessage sends a message of a file transfer to a user.
+func (c *Client) sendFileMessage(to string, msg fileMessage) error {
+	content, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.SendMessage(Message{To: to, Content: string(content)})
+}
+
+// sendFileChunks sends chunks of an offered file, checking each against the hash it had when
+// it was offered.
+func (c *Client) sendFileChunks(out *outgoingFile, indices []int) {
+	f, err := os.Open(out.path)
+	if err != nil {
+		log.Printf("Failed to send %s to %s: %v", out.offer.Name, out.to, err)
+		return
+	}
+	defer f.Close()
+	for _, index := range indices {
+		if index < 0 || index >= out.offer.Chunks() {
+			continue
+		}
+		data := make([]byte, out.offer.chunkLen(index))
+		if _, err := f.ReadAt(data, int64(index)*int64(out.offer.ChunkSize)); err != nil {
+			log.Printf("Failed to read chunk %d of %s: %v", index, out.offer.Name, err)
+			return
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != out.offer.ChunkHashes[index] {
+			log.Printf("Stopped sending %s to %s: the file changed since it was offered", out.offer.Name, out.to)
+			return
+		}
+		msg := fileMessage{
+			Type:       fileChunkMessageType,
+			TransferID: out.offer.TransferID,
+			Index:      index,
+			Data:       base64.StdEncoding.EncodeToString(data),
+		}
+		if err := c.sendFileMessage(out.to, msg); err != nil {
+			log.Printf("Failed to send chunk %d of %s to %s: %v", index, out.offer.Name, out.to, err)
+			return
+		}
+	}
+}
+
+// handleFileMessage handles a message of a file transfer received in a verified direct
+// message. It reports false for other messages.
+func (c *Client) handleFileMessage(msg Message) bool {
+	var fm fileMessage
+	if json.Unmarshal([]byte(msg.Content), &fm) != nil {
+		return false
+	}
+	switch fm.Type {
+	case fileOfferMessageType, fileRequestMessageType, fileChunkMessageType, fileDoneMessageType:
+	default:
+		return false
+	}
+	if msg.Status != "verified" {
+		log.Printf("Ignoring %s of transfer %s from %s: the message is %s", fm.Type, fm.TransferID, msg.From, msg.Status)
+		return true
+	}
+
+	switch fm.Type {
+	case fileOfferMessageType:
+		if fm.Offer == nil || fm.Offer.TransferID != fm.TransferID || fm.Offer.validate() != nil {
+			log.Printf("Ignoring an invalid file offer from %s", msg.From)
+			return true
+		}
+		offer := *fm.Offer
+		offer.From = msg.From
+		select {
+		case c.fileOffers <- offer:
+		default:
+			log.Printf("Dropping the offer of %s from %s: the channel is full", offer.Name, msg.From)
+		}
+	case fileRequestMessageType, fileDoneMessageType:
+		c.filesMu.Lock()
+		out, ok := c.outgoingFiles[fm.TransferID]
+		if ok && out.to != msg.From {
+			ok = false
+		}
+		if ok && fm.Type == fileDoneMessageType {
+			delete(c.outgoingFiles, fm.TransferID)
+		}
+		c.filesMu.Unlock()
+		if !ok {
+			log.Printf("Ignoring %s from %s: no such transfer %s", fm.Type, msg.From, fm.TransferID)
+		} else if fm.Type == fileDoneMessageType {
+			log.Printf("%s arrived at %s", out.offer.Name, msg.From)
+		} else {
+			go c.sendFileChunks(out, fm.Chunks)
+		}
+	case fileChunkMessageType:
+		data, err := base64.StdEncoding.DecodeString(fm.Data)
+		if err != nil {
+			return true
+		}
+		c.filesMu.Lock()
+		chunks, ok := c.incomingFiles[msg.From+"/"+fm.TransferID]
+		c.filesMu.Unlock()
+		if !ok {
+			return true
+		}
+		// A dropped chunk is requested again once the transfer stalls
+		select {
+		case chunks <- fileChunk{index: fm.Index, data: data}:
+		default:
+		}
+	}
+	return true
+}
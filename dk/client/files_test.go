@@ -0,0 +1,218 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// relayServer routes the messages of connected users to their recipient, the way the server
+// does for direct messages. The token of a connection is its user ID.
+type relayServer struct {
+	mu    sync.Mutex
+	conns map[string]*websocket.Conn
+	// drop reports whether a message is lost on its way
+	drop func(msg Message) bool
+	// replay reports whether a message is delivered a second time
+	replay func(msg Message) bool
+	// chunks counts the file chunks relayed
+	chunks int
+}
+
+func (s *relayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	s.mu.Lock()
+	s.conns[r.URL.Query().Get("token")] = conn
+	s.mu.Unlock()
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		s.mu.Lock()
+		if msg.Ack == "" && len(msg.Content) > FileChunkSize {
+			s.chunks++
+		}
+		if recipient, ok := s.conns[msg.To]; ok && (s.drop == nil || !s.drop(msg)) {
+			recipient.WriteMessage(websocket.TextMessage, data)
+			if s.replay != nil && s.replay(msg) {
+				recipient.WriteMessage(websocket.TextMessage, data)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// connectPeers connects two users to a relay server.
+func connectPeers(t *testing.T, server *relayServer) (*Client, *Client) {
+	t.Helper()
+	srv := httptest.NewServer(server)
+	t.Cleanup(srv.Close)
+	alicePub, alicePriv, _ := ed25519.GenerateKey(rand.Reader)
+	bobPub, bobPriv, _ := ed25519.GenerateKey(rand.Reader)
+	alice := NewClient(srv.URL, "alice", alicePriv, alicePub)
+	bob := NewClient(srv.URL, "bob", bobPriv, bobPub)
+	for _, c := range []*Client{alice, bob} {
+		c.jwtToken = c.UserID
+		c.pubKeyCache["alice"] = alicePub
+		c.pubKeyCache["bob"] = bobPub
+		if err := c.Connect(); err != nil {
+			t.Fatalf("Connect failed: %v", err)
+		}
+		t.Cleanup(func() { c.Disconnect() })
+	}
+	return alice, bob
+}
+
+func receiveOffer(t *testing.T, c *Client) FileOffer {
+	t.Helper()
+	select {
+	case offer := <-c.FileOffers():
+		return offer
+	case <-time.After(5 * time.Second):
+		t.Fatal("No file offered")
+		return FileOffer{}
+	}
+}
+
+func TestSendAndReceiveFile(t *testing.T) {
+	server := &relayServer{conns: make(map[string]*websocket.Conn)}
+	// The first chunk sent is lost, so the receiver has to request it again
+	lost := false
+	server.drop = func(msg Message) bool {
+		if msg.From == "alice" && len(msg.Content) > FileChunkSize && !lost {
+			lost = true
+			return true
+		}
+		return false
+	}
+	alice, bob := connectPeers(t, server)
+	defer func(timeout time.Duration) { fileStallTimeout = timeout }(fileStallTimeout)
+	fileStallTimeout = 200 * time.Millisecond
+
+	data := make([]byte, 3*FileChunkSize+1000)
+	rand.Read(data)
+	path := filepath.Join(t.TempDir(), "report.bin")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sent, err := alice.SendFile("bob", path, map[string]string{"type": "report"})
+	if err != nil {
+		t.Fatalf("SendFile failed: %v", err)
+	}
+	if sent.Chunks() != 4 || len(sent.ChunkHashes) != 4 {
+		t.Fatalf("Expected 4 chunks, got %d", sent.Chunks())
+	}
+
+	offer := receiveOffer(t, bob)
+	if offer.From != "alice" || offer.Name != "report.bin" || offer.SHA256 != sent.SHA256 || offer.Metadata["type"] != "report" {
+		t.Fatalf("Unexpected offer %+v", offer)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	dir := t.TempDir()
+	received, err := bob.ReceiveFile(ctx, offer, dir)
+	if err != nil {
+		t.Fatalf("ReceiveFile failed: %v", err)
+	}
+	if got, _ := os.ReadFile(received); !bytes.Equal(got, data) {
+		t.Error("Received file differs from the sent one")
+	}
+	if filepath.Dir(received) != dir {
+		t.Errorf("Expected the file in %s, got %s", dir, received)
+	}
+
+	// The sender forgets the file once it arrived
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		alice.filesMu.Lock()
+		pending := len(alice.outgoingFiles)
+		alice.filesMu.Unlock()
+		if pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Sender still serves the file after it arrived")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReceiveFileResumes(t *testing.T) {
+	server := &relayServer{conns: make(map[string]*websocket.Conn)}
+	alice, bob := connectPeers(t, server)
+
+	data := make([]byte, 4*FileChunkSize)
+	rand.Read(data)
+	path := filepath.Join(t.TempDir(), "model.bin")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alice.SendFile("bob", path, nil); err != nil {
+		t.Fatalf("SendFile failed: %v", err)
+	}
+	offer := receiveOffer(t, bob)
+
+	// A previous attempt received the first two chunks, the second one corrupted
+	dir := t.TempDir()
+	partial := append([]byte(nil), data[:2*FileChunkSize]...)
+	partial[FileChunkSize] ^= 0xff
+	if err := os.WriteFile(filepath.Join(dir, "."+offer.TransferID+".part"), partial, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	received, err := bob.ReceiveFile(ctx, offer, dir)
+	if err != nil {
+		t.Fatalf("ReceiveFile failed: %v", err)
+	}
+	if got, _ := os.ReadFile(received); !bytes.Equal(got, data) {
+		t.Error("Resumed file differs from the sent one")
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.chunks != 3 {
+		t.Errorf("Expected only the 3 missing chunks to be sent, got %d", server.chunks)
+	}
+}
+
+func TestFileOfferValidation(t *testing.T) {
+	valid := FileOffer{TransferID: "0123456789abcdef0123456789abcdef", Size: 10, ChunkSize: FileChunkSize, ChunkHashes: []string{"x"}}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("Expected a valid offer, got %v", err)
+	}
+	invalid := map[string]FileOffer{
+		"path in transfer ID": {TransferID: "../../../etc/passwd", Size: 10, ChunkSize: FileChunkSize, ChunkHashes: []string{"x"}},
+		"too large":           {TransferID: valid.TransferID, Size: MaxFileSize + 1, ChunkSize: FileChunkSize},
+		"chunks too large":    {TransferID: valid.TransferID, Size: 10, ChunkSize: FileChunkSize + 1, ChunkHashes: []string{"x"}},
+		"hashes missing":      {TransferID: valid.TransferID, Size: FileChunkSize + 1, ChunkSize: FileChunkSize, ChunkHashes: []string{"x"}},
+	}
+	for name, offer := range invalid {
+		if offer.validate() == nil {
+			t.Errorf("Expected the offer with %s to be invalid", name)
+		}
+	}
+	if name := receivedFileName(FileOffer{TransferID: valid.TransferID, Name: "../../.bashrc"}); name != "01234567-bashrc" {
+		t.Errorf("Expected a name inside the directory, got %q", name)
+	}
+}
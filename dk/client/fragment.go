@@ -0,0 +1,313 @@
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultMaxMessageSize is the size of the largest message the server reads.
+const DefaultMaxMessageSize = 1 << 20
+
+const (
+	// messageOverhead is the room left in a message for its fields other than the content,
+	// and for the envelope of encrypted content.
+	messageOverhead = 8 << 10
+	// fragmentPrefix starts the content of the parts of a fragmented message.
+	fragmentPrefix = `{"dk_fragment":`
+)
+
+var (
+	// Limits of the fragmented messages sent and received: the number of parts of a message,
+	// how long the parts of a message are kept until the others arrive, and the size of the
+	// parts kept, beyond which the oldest incomplete messages are dropped.
+	maxFragments            = 1024
+	fragmentTTL             = 10 * time.Minute
+	maxPendingFragmentBytes = 64 << 20
+)
+
+// ErrMessageTooLarge is returned by Send for a message that cannot be sent even split into
+// parts.
+var ErrMessageTooLarge = errors.New("message too large")
+
+// fragment is a numbered part of the content of a message too large for the server.
+type fragment struct {
+	ID    string `json:"id"`    // ID of the whole message
+	Index int    `json:"index"` // From 0
+	Count int    `json:"count"`
+	Data  string `json:"data"`
+}
+
+// valid reports whether a fragment received is one a client sends.
+func (f *fragment) valid() bool {
+	return f.ID != "" && f.Data != "" && f.Count >= 2 && f.Count <= maxFragments && f.Index >= 0 && f.Index < f.Count
+}
+
+// fragmentEnvelope is the content of a message carrying a fragment.
+type fragmentEnvelope struct {
+	Fragment *fragment `json:"dk_fragment"`
+}
+
+// assembly gathers the parts of a fragmented message being received.
+type assembly struct {
+	parts    []string
+	received int
+	size     int
+	status   string  // Status of the parts: verified unless one of them is not
+	last     Message // The last part, whose ID is the ID of the whole message
+	started  time.Time
+}
+
+// SetMaxMessageSize sets the size of the largest message the server accepts,
+// DefaultMaxMessageSize by default. Larger messages are split into numbered parts, sent as
+// separate messages and reassembled by the receiving client, which delivers them whole; 0
+// sends them whole.
+func (c *Client) SetMaxMessageSize(size int) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.maxMessageSize = size
+}
+
+// fragment splits a message too large for the server into numbered parts, each small enough
+// once encoded, and encrypted for a direct or group message. The last part keeps the ID of the
+// message, so its acknowledgments are those of the message; the others get IDs of their own.
+// Acknowledgments, forwarded messages and control messages are never split.
+func (c *Client) fragment(msg Message) ([]Message, error) {
+	c.connMu.RLock()
+	limit := c.maxMessageSize
+	c.connMu.RUnlock()
+	if limit <= 0 || msg.Ack != "" || msg.IsForwardMessage || msg.To == systemAddress {
+		return []Message{msg}, nil
+	}
+	budget := limit - messageOverhead
+	encrypted := msg.To != broadcastAddress
+	if contentSize(msg.Content, encrypted) <= budget {
+		return []Message{msg}, nil
+	}
+	if len(msg.Content) > maxPendingFragmentBytes {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(msg.Content))
+	}
+
+	// The size of a part is measured with the largest index and count it may have
+	fits := func(data string) bool {
+		envelope, err := json.Marshal(fragmentEnvelope{&fragment{ID: msg.MessageID, Index: maxFragments, Count: maxFragments, Data: data}})
+		return err == nil && contentSize(string(envelope), encrypted) <= budget
+	}
+	data, ok := splitContent(msg.Content, fits)
+	if !ok || len(data) > maxFragments {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, len(msg.Content))
+	}
+
+	parts := make([]Message, len(data))
+	for i := range data {
+		envelope, err := json.Marshal(fragmentEnvelope{&fragment{ID: msg.MessageID, Index: i, Count: len(data), Data: data[i]}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal fragment: %w", err)
+		}
+		parts[i] = msg
+		parts[i].Content = string(envelope)
+		if i < len(data)-1 {
+			parts[i].MessageID = fragmentID(msg.MessageID, i)
+		}
+	}
+	return parts, nil
+}
+
+// contentSize returns the size content takes in a message sent to the server: encoded as a
+// JSON string, or, when it is encrypted, about the size of its ciphertext in base64.
+func contentSize(content string, encrypted bool) int {
+	if encrypted {
+		return base64.StdEncoding.EncodedLen(len(content))
+	}
+	quoted, _ := json.Marshal(content)
+	return len(quoted)
+}
+
+// splitContent halves content, at rune boundaries, until every part fits. It reports false if
+// a single rune does not.
+func splitContent(content string, fits func(string) bool) ([]string, bool) {
+	if fits(content) {
+		return []string{content}, true
+	}
+	mid := len(content) / 2
+	for mid > 0 && !utf8.RuneStart(content[mid]) {
+		mid--
+	}
+	if mid == 0 {
+		return nil, false
+	}
+	left, ok := splitContent(content[:mid], fits)
+	if !ok {
+		return nil, false
+	}
+	right, ok := splitContent(content[mid:], fits)
+	if !ok {
+		return nil, false
+	}
+	return append(left, right...), true
+}
+
+// fragmentID returns the message ID of a part of a fragmented message other than the last.
+func fragmentID(messageID string, index int) string {
+	return messageID + "#" + strconv.Itoa(index)
+}
+
+// isFragmentID reports whether a message ID is that of a part of a fragmented message other
+// than the last, whose acknowledgments are not reported.
+func isFragmentID(messageID string) bool {
+	i := strings.LastIndexByte(messageID, '#')
+	if i < 0 {
+		return false
+	}
+	_, err := strconv.Atoi(messageID[i+1:])
+	return err == nil
+}
+
+// parseFragment returns the fragment a message content carries, if any.
+func parseFragment(content string) (*fragment, bool) {
+	if !strings.HasPrefix(content, fragmentPrefix) {
+		return nil, false
+	}
+	var envelope fragmentEnvelope
+	if err := json.Unmarshal([]byte(content), &envelope); err != nil || envelope.Fragment == nil {
+		return nil, false
+	}
+	return envelope.Fragment, true
+}
+
+// reassemble holds the parts of a fragmented message until all of them were received, and
+// then returns the whole message, which is verified only if every part is. Messages that are
+// not fragmented are returned as they are. It reports false while the message is incomplete,
+// and for invalid or repeated parts.
+func (c *Client) reassemble(msg Message) (Message, bool) {
+	part, ok := parseFragment(msg.Content)
+	if !ok {
+		return msg, true
+	}
+	if !part.valid() {
+		log.Printf("Dropping invalid fragment of a message from %s", msg.From)
+		return msg, false
+	}
+
+	c.fragmentsMu.Lock()
+	defer c.fragmentsMu.Unlock()
+	c.expireAssembliesLocked()
+	key := msg.From + "\x00" + msg.To + "\x00" + part.ID
+	a, found := c.assemblies[key]
+	if !found {
+		a = &assembly{parts: make([]string, part.Count), started: time.Now()}
+		c.assemblies[key] = a
+	}
+	if part.Count != len(a.parts) {
+		log.Printf("Dropping fragment of message %s from %s: expected %d parts, got %d", part.ID, msg.From, len(a.parts), part.Count)
+		return msg, false
+	}
+	if a.parts[part.Index] != "" {
+		return msg, false
+	}
+	a.parts[part.Index] = part.Data
+	a.received++
+	a.size += len(part.Data)
+	c.pendingFragmentBytes += len(part.Data)
+	if a.status == "" || (a.status == "verified" && msg.Status != "verified") {
+		a.status = msg.Status
+	}
+	if part.Index == part.Count-1 {
+		a.last = msg
+	}
+	if a.received < len(a.parts) {
+		c.limitAssembliesLocked()
+		return msg, false
+	}
+
+	c.dropAssemblyLocked(key)
+	whole := a.last
+	whole.Content = strings.Join(a.parts, "")
+	whole.Status = a.status
+	return whole, true
+}
+
+// expireAssembliesLocked drops the incomplete messages whose first part was received more
+// than fragmentTTL ago. The caller holds fragmentsMu.
+func (c *Client) expireAssembliesLocked() {
+	for key, a := range c.assemblies {
+		if time.Since(a.started) > fragmentTTL {
+			log.Printf("Dropping incomplete message after receiving %d of its %d parts", a.received, len(a.parts))
+			c.dropAssemblyLocked(key)
+		}
+	}
+}
+
+// limitAssembliesLocked drops the oldest incomplete messages while their parts take more than
+// maxPendingFragmentBytes. The caller holds fragmentsMu.
+func (c *Client) limitAssembliesLocked() {
+	for c.pendingFragmentBytes > maxPendingFragmentBytes {
+		var oldest string
+		for key, a := range c.assemblies {
+			if oldest == "" || a.started.Before(c.assemblies[oldest].started) {
+				oldest = key
+			}
+		}
+		log.Printf("Too many parts of incomplete messages; dropping the oldest message")
+		c.dropAssemblyLocked(oldest)
+	}
+}
+
+// dropAssemblyLocked forgets an incomplete message. The caller holds fragmentsMu.
+func (c *Client) dropAssemblyLocked(key string) {
+	if a, ok := c.assemblies[key]; ok {
+		c.pendingFragmentBytes -= a.size
+		delete(c.assemblies, key)
+	}
+}
+
+// joinFragments replaces the parts of fragmented messages with the whole messages, at the
+// place of their last part, when all of them are in messages. Parts of messages that are not
+// all there are left as they are.
+func joinFragments(messages []Message) []Message {
+	type fragments struct {
+		parts    []string
+		received int
+	}
+	found := make(map[string]*fragments)
+	for _, msg := range messages {
+		part, ok := parseFragment(msg.Content)
+		if !ok || !part.valid() {
+			continue
+		}
+		key := msg.From + "\x00" + msg.To + "\x00" + part.ID
+		f := found[key]
+		if f == nil {
+			f = &fragments{parts: make([]string, part.Count)}
+			found[key] = f
+		}
+		if part.Count == len(f.parts) && f.parts[part.Index] == "" {
+			f.parts[part.Index] = part.Data
+			f.received++
+		}
+	}
+
+	joined := make([]Message, 0, len(messages))
+	for _, msg := range messages {
+		part, ok := parseFragment(msg.Content)
+		if !ok {
+			joined = append(joined, msg)
+			continue
+		}
+		f := found[msg.From+"\x00"+msg.To+"\x00"+part.ID]
+		switch {
+		case !part.valid() || f.received < len(f.parts):
+			joined = append(joined, msg)
+		case part.Index == part.Count-1:
+			msg.Content = strings.Join(f.parts, "")
+			joined = append(joined, msg)
+		}
+	}
+	return joined
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestFragmentedMessage(t *testing.T) {
+	const limit = 32 << 10
+	server := &relayServer{conns: make(map[string]*websocket.Conn)}
+	// Every part is relayed twice, and the size of each part is checked
+	var parts int
+	var oversized bool
+	server.replay = func(msg Message) bool {
+		if msg.From != "alice" || msg.Ack != "" {
+			return false
+		}
+		parts++
+		encoded, _ := json.Marshal(msg)
+		oversized = oversized || len(encoded) > limit
+		return true
+	}
+	alice, bob := connectPeers(t, server)
+	alice.SetMaxMessageSize(limit)
+
+	content := strings.Repeat(`A "large" document, with <markup> and accents: é, ü, 漢字. `, 2000)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	id, err := alice.SendMessageUntil(ctx, Message{To: "bob", Content: content}, AckDelivered)
+	if err != nil {
+		t.Fatalf("SendMessageUntil failed: %v", err)
+	}
+	msg := receive(t, bob.Messages())
+	if msg.Content != content || msg.MessageID != id || msg.Status != "verified" {
+		t.Errorf("Expected the whole verified message %s, got %s with %d bytes", id, msg.Status, len(msg.Content))
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if parts < 2 || oversized {
+		t.Errorf("Expected the message in parts within the limit, got %d parts", parts)
+	}
+	select {
+	case extra := <-bob.Messages():
+		t.Errorf("Expected the message to be delivered once, got %+v", extra)
+	default:
+	}
+}
+
+func TestReassemble(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient("", "bob", priv, pub)
+	c.SetMaxMessageSize(messageOverhead + 1000)
+	content := strings.Repeat("ünïcödé ", 1000)
+	parts, err := c.fragment(Message{From: "alice", To: "broadcast", Content: content, MessageID: "m1"})
+	if err != nil || len(parts) < 3 {
+		t.Fatalf("Expected at least 3 parts, got %d: %v", len(parts), err)
+	}
+	for i := range parts {
+		parts[i].Status = "verified"
+	}
+	last := len(parts) - 1
+	if parts[last].MessageID != "m1" || !isFragmentID(parts[0].MessageID) {
+		t.Errorf("Expected the last part to keep the ID of the message, got %s and %s", parts[0].MessageID, parts[last].MessageID)
+	}
+
+	// The history joins the parts it has all of
+	if joined := joinFragments(append(parts[:1:1], Message{Content: "other"})); len(joined) != 2 {
+		t.Errorf("Expected an incomplete message to be left in parts, got %d messages", len(joined))
+	}
+	if joined := joinFragments(parts); len(joined) != 1 || joined[0].Content != content {
+		t.Errorf("Expected the history to join the parts, got %d messages", len(joined))
+	}
+
+	// Parts are reassembled in any order, and repeated parts are ignored
+	parts[1].Status = "unverified"
+	for i := last; i > 0; i-- {
+		if _, complete := c.reassemble(parts[i]); complete {
+			t.Fatalf("Expected the message to be incomplete before part %d", i)
+		}
+	}
+	if _, complete := c.reassemble(parts[last]); complete {
+		t.Error("Expected a repeated part to be ignored")
+	}
+	msg, complete := c.reassemble(parts[0])
+	if !complete || msg.Content != content || msg.MessageID != "m1" || msg.Status != "unverified" {
+		t.Errorf("Expected the whole unverified message, got %s with %d bytes", msg.Status, len(msg.Content))
+	}
+	if len(c.assemblies) != 0 || c.pendingFragmentBytes != 0 {
+		t.Error("Expected the parts to be released")
+	}
+
+	// Incomplete messages are dropped once expired
+	defer func(ttl time.Duration) { fragmentTTL = ttl }(fragmentTTL)
+	fragmentTTL = 0
+	c.reassemble(parts[0])
+	c.reassemble(parts[1])
+	if a := c.assemblies["alice\x00broadcast\x00m1"]; a == nil || a.received != 1 || c.pendingFragmentBytes != a.size {
+		t.Error("Expected the expired parts to be dropped")
+	}
+
+	defer func(limit int) { maxFragments = limit }(maxFragments)
+	maxFragments = 2
+	if _, err := c.fragment(Message{To: "broadcast", Content: content, MessageID: "m2"}); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("Expected a message needing too many parts to fail, got %v", err)
+	}
+}
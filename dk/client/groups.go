@@ -0,0 +1,422 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// GroupAddressPrefix starts the recipient of a message to a group, followed by the group ID.
+const GroupAddressPrefix = "group:"
+
+// Types of the messages that manage group keys. They are handled by the client and not
+// delivered to the application.
+const (
+	groupKeyMessageType    = "group_key"    // A group key, sent by the owner in a direct message
+	groupMemberMessageType = "group_member" // A membership change, sent by the server to the owner
+)
+
+// groupKeySize is the size of group keys, for AES-256.
+const groupKeySize = 32
+
+// Group is a set of users that messages can be sent to at once. Its owner hands the group key
+// to members and rotates it when one leaves.
+type Group struct {
+	GroupID   string    `json:"group_id"`
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"`
+	Members   []string  `json:"members"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GroupKey is a key that messages to a group are encrypted with. Each rotation starts a new
+// epoch; earlier keys are kept to read messages sent before it.
+type GroupKey struct {
+	GroupID string
+	Epoch   int
+	Key     []byte
+}
+
+// GroupKeyStore keeps the group keys of the client. Without one, keys are kept in memory and
+// lost when the application stops.
+type GroupKeyStore interface {
+	// SaveGroupKey stores a key, replacing the key of the same group and epoch.
+	SaveGroupKey(key GroupKey) error
+	// GroupKey returns the key of a group for an epoch, or its latest key when epoch is 0.
+	GroupKey(groupID string, epoch int) (GroupKey, bool, error)
+}
+
+// GroupAddress returns the recipient of messages to a group.
+func GroupAddress(groupID string) string {
+	return GroupAddressPrefix + groupID
+}
+
+// SetGroupKeyStore replaces the store of group keys.
+func (c *Client) SetGroupKeyStore(store GroupKeyStore) {
+	c.groupMu.Lock()
+	defer c.groupMu.Unlock()
+	c.groupKeys = store
+}
+
+// groupKeyStore returns the store of group keys.
+func (c *Client) groupKeyStore() GroupKeyStore {
+	c.groupMu.Lock()
+	defer c.groupMu.Unlock()
+	return c.groupKeys
+}
+
+// CreateGroup creates a group owned by the client, and its first key.
+func (c *Client) CreateGroup(name string) (Group, error) {
+	var group Group
+	if err := c.authRequest(http.MethodPost, "/groups", map[string]string{"name": name}, &group); err != nil {
+		return Group{}, fmt.Errorf("failed to create group: %w", err)
+	}
+	if _, err := c.newGroupKey(group.GroupID, 1); err != nil {
+		return group, fmt.Errorf("failed to create the key of group %s: %w", group.GroupID, err)
+	}
+	return group, nil
+}
+
+// JoinGroup makes the client a member of a group. The owner of the group sends its key once
+// they are told, so messages to the group can only be read and sent after that.
+func (c *Client) JoinGroup(groupID string) (Group, error) {
+	var group Group
+	if err := c.authRequest(http.MethodPost, "/groups/"+url.PathEscape(groupID)+"/join", nil, &group); err != nil {
+		return Group{}, fmt.Errorf("failed to join group: %w", err)
+	}
+	return group, nil
+}
+
+// LeaveGroup removes the client from a group. Its owner then rotates the group key.
+func (c *Client) LeaveGroup(groupID string) error {
+	if err := c.authRequest(http.MethodPost, "/groups/"+url.PathEscape(groupID)+"/leave", nil, nil); err != nil {
+		return fmt.Errorf("failed to leave group: %w", err)
+	}
+	return nil
+}
+
+// Groups returns the groups the client is a member of.
+func (c *Client) Groups() ([]Group, error) {
+	var groups []Group
+	if err := c.authRequest(http.MethodGet, "/groups", nil, &groups); err != nil {
+		return nil, fmt.Errorf("failed to list groups: %w", err)
+	}
+	return groups, nil
+}
+
+// Group returns a group the client is a member of, with its members.
+func (c *Client) Group(groupID string) (Group, error) {
+	var group Group
+	if err := c.authRequest(http.MethodGet, "/groups/"+url.PathEscape(groupID), nil, &group); err != nil {
+		return Group{}, fmt.Errorf("failed to get group: %w", err)
+	}
+	return group, nil
+}
+
+// SendGroupMessage sends a message to the members of a group, encrypted once with the group
+// key, and returns its message ID. Only the server acknowledges it.
+func (c *Client) SendGroupMessage(groupID, content string) (string, error) {
+	return c.Send(Message{To: GroupAddress(groupID), Content: content})
+}
+
+// authRequest sends an authenticated request to the server and decodes the response
+// into out, if set.
+func (c *Client) authRequest(method, path string, body, out any) error {
+	if c.Token() == "" {
+		return fmt.Errorf("%w: JWT token is not set; please login first", ErrUnauthorized)
+	}
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, c.ServerURL()+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.doAuthorized(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return statusError(resp, method+" "+path+" failed")
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// groupKeyMessage is the content of the direct message handing a group key to a member.
+type groupKeyMessage struct {
+	Type    string `json:"type"`
+	GroupID string `json:"group_id"`
+	Epoch   int    `json:"epoch"`
+	Key     string `json:"key"` // Base64
+}
+
+// groupMemberEvent is the content of the message telling the owner of a group that a user
+// joined or left it.
+type groupMemberEvent struct {
+	Type    string `json:"type"`
+	GroupID string `json:"group_id"`
+	UserID  string `json:"user_id"`
+	Event   string `json:"event"` // "joined" or "left"
+}
+
+// newGroupKey creates and stores a random key for an epoch of a group.
+func (c *Client) newGroupKey(groupID string, epoch int) (GroupKey, error) {
+	key := GroupKey{GroupID: groupID, Epoch: epoch, Key: make([]byte, groupKeySize)}
+	if _, err := rand.Read(key.Key); err != nil {
+		return GroupKey{}, err
+	}
+	return key, c.groupKeyStore().SaveGroupKey(key)
+}
+
+// sendGroupKey hands a group key to a member in a direct message, so it is sealed to the
+// member's key.
+func (c *Client) sendGroupKey(key GroupKey, member string) error {
+	content, err := json.Marshal(groupKeyMessage{
+		Type:    groupKeyMessageType,
+		GroupID: key.GroupID,
+		Epoch:   key.Epoch,
+		Key:     base64.StdEncoding.EncodeToString(key.Key),
+	})
+	if err != nil {
+		return err
+	}
+	return c.SendMessage(Message{To: member, Content: string(content)})
+}
+
+// rotateGroupKey starts a new epoch of a group owned by the client and hands its key to the
+// current members.
+func (c *Client) rotateGroupKey(groupID string) error {
+	group, err := c.Group(groupID)
+	if err != nil {
+		return err
+	}
+	if group.Owner != c.UserID {
+		return fmt.Errorf("group %s is owned by %s", groupID, group.Owner)
+	}
+	epoch := 1
+	if latest, ok, err := c.groupKeyStore().GroupKey(groupID, 0); err != nil {
+		return err
+	} else if ok {
+		epoch = latest.Epoch + 1
+	}
+	key, err := c.newGroupKey(groupID, epoch)
+	if err != nil {
+		return err
+	}
+	for _, member := range group.Members {
+		if member == c.UserID {
+			continue
+		}
+		if err := c.sendGroupKey(key, member); err != nil {
+			log.Printf("Failed to send the key of group %s to %s: %v", groupID, member, err)
+		}
+	}
+	return nil
+}
+
+// handleGroupEvent handles a membership change the server sent to the owner of a group: a new
+// member gets the current key, and the key is rotated when a member leaves. It reports false
+// for other messages.
+func (c *Client) handleGroupEvent(msg Message) bool {
+	var event groupMemberEvent
+	if json.Unmarshal([]byte(msg.Content), &event) != nil || event.Type != groupMemberMessageType {
+		return false
+	}
+	switch event.Event {
+	case "joined":
+		// The event is not signed, so the membership is checked with the server before the
+		// key is handed over
+		group, err := c.Group(event.GroupID)
+		if err != nil {
+			log.Printf("Failed to check the members of group %s: %v", event.GroupID, err)
+			return true
+		}
+		if group.Owner != c.UserID || !slices.Contains(group.Members, event.UserID) {
+			log.Printf("Ignoring that %s joined group %s: not a member of a group owned by this client", event.UserID, event.GroupID)
+			return true
+		}
+		key, ok, err := c.groupKeyStore().GroupKey(event.GroupID, 0)
+		if err == nil && !ok {
+			// An owner without a key, e.g. after losing its store, starts a new epoch
+			err = c.rotateGroupKey(event.GroupID)
+		} else if err == nil {
+			err = c.sendGroupKey(key, event.UserID)
+		}
+		if err != nil {
+			log.Printf("Failed to send the key of group %s to %s: %v", event.GroupID, event.UserID, err)
+		}
+	case "left":
+		if err := c.rotateGroupKey(event.GroupID); err != nil {
+			log.Printf("Failed to rotate the key of group %s after %s left: %v", event.GroupID, event.UserID, err)
+		}
+	}
+	return true
+}
+
+// handleGroupKey stores a group key received in a verified direct message from the owner of
+// the group. It reports false for other messages.
+func (c *Client) handleGroupKey(msg Message) bool {
+	var keyMsg groupKeyMessage
+	if json.Unmarshal([]byte(msg.Content), &keyMsg) != nil || keyMsg.Type != groupKeyMessageType {
+		return false
+	}
+	if msg.Status != "verified" {
+		log.Printf("Ignoring a key of group %s from %s: the message is %s", keyMsg.GroupID, msg.From, msg.Status)
+		return true
+	}
+	group, err := c.Group(keyMsg.GroupID)
+	if err != nil {
+		log.Printf("Ignoring a key of group %s: %v", keyMsg.GroupID, err)
+		return true
+	}
+	if group.Owner != msg.From {
+		log.Printf("Ignoring a key of group %s from %s, who does not own it", keyMsg.GroupID, msg.From)
+		return true
+	}
+	key, err := base64.StdEncoding.DecodeString(keyMsg.Key)
+	if err != nil || len(key) != groupKeySize || keyMsg.Epoch < 1 {
+		log.Printf("Ignoring an invalid key of group %s from %s", keyMsg.GroupID, msg.From)
+		return true
+	}
+	if err := c.groupKeyStore().SaveGroupKey(GroupKey{GroupID: keyMsg.GroupID, Epoch: keyMsg.Epoch, Key: key}); err != nil {
+		log.Printf("Failed to store the key of group %s: %v", keyMsg.GroupID, err)
+	}
+	return true
+}
+
+// groupEnvelope is the content of a message to a group.
+type groupEnvelope struct {
+	Epoch      int    `json:"epoch"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// groupCipher returns the AES-GCM cipher of a group key.
+func groupCipher(key GroupKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key.Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// groupAAD binds the ciphertext of a message to its group and epoch.
+func groupAAD(groupID string, epoch int) []byte {
+	return []byte(groupID + "|" + strconv.Itoa(epoch))
+}
+
+// sealGroupContent encrypts the content of a message to a group with its latest key.
+func (c *Client) sealGroupContent(groupID, plaintext string) (string, error) {
+	key, ok, err := c.groupKeyStore().GroupKey(groupID, 0)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("no key for group %s yet", groupID)
+	}
+	aead, err := groupCipher(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	envelope, err := json.Marshal(groupEnvelope{
+		Epoch:      key.Epoch,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, []byte(plaintext), groupAAD(groupID, key.Epoch))),
+	})
+	return string(envelope), err
+}
+
+// openGroupContent decrypts the content of a message to a group with the key of its epoch.
+func (c *Client) openGroupContent(groupID, content string) (string, error) {
+	var envelope groupEnvelope
+	if err := json.Unmarshal([]byte(content), &envelope); err != nil {
+		return "", fmt.Errorf("invalid group message: %w", err)
+	}
+	key, ok, err := c.groupKeyStore().GroupKey(groupID, envelope.Epoch)
+	if err != nil {
+		return "", err
+	}
+	if !ok || envelope.Epoch < 1 {
+		return "", fmt.Errorf("no key for epoch %d of group %s", envelope.Epoch, groupID)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(envelope.Nonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(envelope.Ciphertext)
+	if err != nil {
+		return "", err
+	}
+	aead, err := groupCipher(key)
+	if err != nil {
+		return "", err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return "", errors.New("invalid nonce")
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, groupAAD(groupID, envelope.Epoch))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// memoryGroupKeys keeps group keys in memory.
+type memoryGroupKeys struct {
+	mu   sync.Mutex
+	keys map[string]map[int][]byte
+}
+
+func newMemoryGroupKeys() *memoryGroupKeys {
+	return &memoryGroupKeys{keys: make(map[string]map[int][]byte)}
+}
+
+func (m *memoryGroupKeys) SaveGroupKey(key GroupKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.keys[key.GroupID] == nil {
+		m.keys[key.GroupID] = make(map[int][]byte)
+	}
+	m.keys[key.GroupID][key.Epoch] = append([]byte(nil), key.Key...)
+	return nil
+}
+
+func (m *memoryGroupKeys) GroupKey(groupID string, epoch int) (GroupKey, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if epoch == 0 {
+		for e := range m.keys[groupID] {
+			epoch = max(epoch, e)
+		}
+	}
+	key, ok := m.keys[groupID][epoch]
+	return GroupKey{GroupID: groupID, Epoch: epoch, Key: key}, ok, nil
+}
@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// groupKeyContent returns the content of the direct message handing a group key to a member.
+func groupKeyContent(t *testing.T, key GroupKey) string {
+	t.Helper()
+	content, err := json.Marshal(groupKeyMessage{
+		Type:    groupKeyMessageType,
+		GroupID: key.GroupID,
+		Epoch:   key.Epoch,
+		Key:     base64.StdEncoding.EncodeToString(key.Key),
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode the group key: %v", err)
+	}
+	return string(content)
+}
+
+func TestGroupKeys(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/groups/grp-1" || r.Header.Get("Authorization") != "Bearer token" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(Group{GroupID: "grp-1", Name: "team", Owner: "alice", Members: []string{"alice", "bob"}})
+	}))
+	defer srv.Close()
+
+	alicePub, alicePriv, _ := ed25519.GenerateKey(rand.Reader)
+	alice := NewClient(srv.URL, "alice", alicePriv, alicePub)
+	bobPub, bobPriv, _ := ed25519.GenerateKey(rand.Reader)
+	bob := NewClient(srv.URL, "bob", bobPriv, bobPub)
+	bob.jwtToken = "token"
+
+	if _, err := alice.sealGroupContent("grp-1", "hello"); err == nil {
+		t.Fatal("Expected sealing without a group key to fail")
+	}
+	first, err := alice.newGroupKey("grp-1", 1)
+	if err != nil {
+		t.Fatalf("Failed to create the group key: %v", err)
+	}
+	sealed, err := alice.sealGroupContent("grp-1", "hello")
+	if err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+
+	// Only a verified key from the owner of the group is accepted
+	rejected := []Message{
+		{From: "mallory", Status: "verified", Content: groupKeyContent(t, first)},
+		{From: "alice", Status: "unsigned", Content: groupKeyContent(t, first)},
+	}
+	for _, msg := range rejected {
+		if !bob.handleGroupKey(msg) {
+			t.Fatalf("Expected the key message from %s to be handled", msg.From)
+		}
+		if _, err := bob.openGroupContent("grp-1", sealed); err == nil {
+			t.Fatalf("Key from %s (%s) was accepted", msg.From, msg.Status)
+		}
+	}
+	if bob.handleGroupKey(Message{From: "alice", Status: "verified", Content: "a question"}) {
+		t.Error("Expected an ordinary message not to be handled")
+	}
+	bob.handleGroupKey(Message{From: "alice", Status: "verified", Content: groupKeyContent(t, first)})
+	if plaintext, err := bob.openGroupContent("grp-1", sealed); err != nil || plaintext != "hello" {
+		t.Fatalf("Expected %q, got %q (%v)", "hello", plaintext, err)
+	}
+	if _, err := bob.openGroupContent("grp-2", sealed); err == nil {
+		t.Error("Expected a message of another group not to open")
+	}
+
+	// After a rotation, members without the new key cannot read, but old messages still open
+	if _, err := alice.newGroupKey("grp-1", 2); err != nil {
+		t.Fatalf("Failed to rotate the group key: %v", err)
+	}
+	rotated, err := alice.sealGroupContent("grp-1", "after rotation")
+	if err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+	if _, err := bob.openGroupContent("grp-1", rotated); err == nil {
+		t.Error("Expected a message of the new epoch not to open without its key")
+	}
+	if plaintext, err := bob.openGroupContent("grp-1", sealed); err != nil || plaintext != "hello" {
+		t.Errorf("Expected the message of the first epoch to still open, got %q (%v)", plaintext, err)
+	}
+}
+
+func TestGroupOwnerHandsKeyToNewMembers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Group{GroupID: "grp-1", Name: "team", Owner: "alice", Members: []string{"alice", "bob"}})
+	}))
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	alice := NewClient(srv.URL, "alice", priv, pub)
+	alice.jwtToken = "token"
+	key, err := alice.newGroupKey("grp-1", 1)
+	if err != nil {
+		t.Fatalf("Failed to create the group key: %v", err)
+	}
+
+	joined := func(user string) Message {
+		content, _ := json.Marshal(groupMemberEvent{Type: groupMemberMessageType, GroupID: "grp-1", UserID: user, Event: "joined"})
+		return Message{From: "system", To: "alice", Content: string(content)}
+	}
+	// Membership events are not signed: a user the server does not list gets nothing
+	if !alice.handleGroupEvent(joined("mallory")) {
+		t.Fatal("Expected the membership event to be handled")
+	}
+	if len(alice.sendCh) != 0 {
+		t.Fatal("Expected no key to be sent to a user who is not a member")
+	}
+
+	alice.handleGroupEvent(joined("bob"))
+	if len(alice.sendCh) != 1 {
+		t.Fatalf("Expected the key to be sent to the new member, %d messages queued", len(alice.sendCh))
+	}
+	sent := <-alice.sendCh
+	if sent.To != "bob" || sent.Content != groupKeyContent(t, key) {
+		t.Errorf("Unexpected key message %+v", sent)
+	}
+
+	if alice.handleGroupEvent(Message{From: "system", To: "alice", Content: "Rate limit exceeded. Please slow down."}) {
+		t.Error("Expected other system messages not to be handled")
+	}
+}
@@ -0,0 +1,82 @@
+package lib
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// GetHistory fetches the messages this client exchanged with a peer from the server, sent
+// before a time, latest first; a zero before fetches the latest ones. With the peer
+// "broadcast" it fetches the broadcasts sent since the client registered. The server caps
+// limit, and picks a default for 0. To page back, pass the timestamp of the oldest message
+// returned.
+//
+// Received messages are verified and decrypted like live ones, without being delivered on
+// Messages. Messages of ratcheting sessions can only be decrypted once, so those already
+// received come back with the status "decryption_failed". Messages this client sent come back
+// with the status "sent", direct ones still encrypted for their recipient. The parts of a
+// fragmented message are returned whole when the page has all of them, and as they are
+// otherwise.
+func (c *Client) GetHistory(peerID string, before time.Time, limit int) ([]Message, error) {
+	query := url.Values{"peer": {peerID}}
+	if !before.IsZero() {
+		query.Set("before", before.UTC().Format(time.RFC3339Nano))
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	var messages []Message
+	if err := c.authRequest(http.MethodGet, "/messages/history?"+query.Encode(), nil, &messages); err != nil {
+		return nil, err
+	}
+	for i := range messages {
+		messages[i] = c.openHistoryMessage(messages[i])
+	}
+	return joinFragments(messages), nil
+}
+
+// openHistoryMessage verifies and decrypts a message fetched with GetHistory. A verified
+// message this client did not receive yet is recorded as received, and decrypted the way
+// readPump does, so it is not processed again if it arrives later.
+func (c *Client) openHistoryMessage(msg Message) Message {
+	if msg.From == c.UserID {
+		msg.Status = "sent"
+		return msg
+	}
+	if msg.From == "system" || msg.IsForwardMessage {
+		return msg
+	}
+
+	if msg.Signature == "" {
+		msg.Status = "unsigned"
+	} else if valid, err := c.verifySender(msg); err != nil {
+		log.Printf("Failed to get public key for user %s: %v", msg.From, err)
+		msg.Status = "unverified"
+	} else if !valid {
+		msg.Status = "invalid_signature"
+	} else {
+		msg.Status = "verified"
+	}
+	flagSpoofedBroadcast(&msg)
+	if msg.To != c.UserID {
+		return msg
+	}
+
+	var plaintext string
+	var err error
+	if msg.Status == "verified" && c.freshSequence(msg) {
+		plaintext, err = c.openDirect(msg)
+	} else {
+		plaintext, err = c.openContent(msg.Content)
+	}
+	if err != nil {
+		log.Printf("Failed to decrypt message from %s in the history: %v", msg.From, err)
+		msg.Status = "decryption_failed"
+		return msg
+	}
+	msg.Content = plaintext
+	return msg
+}
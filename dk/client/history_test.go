@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// prepareFrom prepares a direct message the way the writer does before sending it.
+func prepareFrom(t *testing.T, from, to *Client, content string) Message {
+	t.Helper()
+	msg, ok := from.prepare(Message{From: from.UserID, To: to.UserID, Content: content, Timestamp: time.Now()})
+	if !ok {
+		t.Fatalf("Failed to prepare a message from %s", from.UserID)
+	}
+	msg.Status = "delivered"
+	return msg
+}
+
+// receiveLive processes a message the way readPump does for a verified direct message.
+func receiveLive(t *testing.T, c *Client, msg Message, want string) {
+	t.Helper()
+	if !c.freshSequence(msg) {
+		t.Fatalf("Expected the message from %s to be fresh", msg.From)
+	}
+	msg.Status = "verified"
+	openExpect(t, c, msg, want)
+}
+
+func TestGetHistory(t *testing.T) {
+	alice, bob := ratchetPeers(t)
+
+	// The first message upgrades the pair to a ratcheting session, whose messages can only be
+	// decrypted once
+	first := prepareFrom(t, alice, bob, "first")
+	receiveLive(t, bob, first, "first")
+	reply := prepareFrom(t, bob, alice, "reply")
+	receiveLive(t, alice, reply, "reply")
+	received := prepareFrom(t, alice, bob, "received")
+	receiveLive(t, bob, received, "received")
+	missed := prepareFrom(t, alice, bob, "missed")
+	if schemeOf(t, missed) != RatchetScheme {
+		t.Fatalf("Expected a ratcheting session, got %s", schemeOf(t, missed))
+	}
+
+	history := []Message{missed, received, reply, first}
+	var query map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/messages/history" || r.Header.Get("Authorization") != "Bearer token" {
+			http.NotFound(w, r)
+			return
+		}
+		query = map[string]string{"peer": r.URL.Query().Get("peer"), "before": r.URL.Query().Get("before"), "limit": r.URL.Query().Get("limit")}
+		json.NewEncoder(w).Encode(history)
+	}))
+	defer srv.Close()
+	bob.setServer(srv.URL)
+	bob.jwtToken = "token"
+
+	before := time.Date(2025, 3, 1, 12, 0, 0, 500, time.UTC)
+	messages, err := bob.GetHistory("alice", before, 10)
+	if err != nil {
+		t.Fatalf("GetHistory failed: %v", err)
+	}
+	if query["peer"] != "alice" || query["before"] != "2025-03-01T12:00:00.0000005Z" || query["limit"] != "10" {
+		t.Errorf("Expected the peer, time and limit in the query, got %v", query)
+	}
+	want := []struct{ status, content string }{
+		{"verified", "missed"},
+		{"decryption_failed", received.Content},
+		{"sent", reply.Content},
+		{"verified", "first"},
+	}
+	if len(messages) != len(want) {
+		t.Fatalf("Expected %d messages, got %d", len(want), len(messages))
+	}
+	for i, msg := range messages {
+		if msg.Status != want[i].status || msg.Content != want[i].content {
+			t.Errorf("Message %d: expected %s %q, got %s %q", i, want[i].status, want[i].content, msg.Status, msg.Content)
+		}
+	}
+
+	// A message read from the history is not processed again when it arrives
+	if bob.freshSequence(missed) {
+		t.Error("Expected the message read from the history to be recorded as received")
+	}
+}
@@ -0,0 +1,95 @@
+package lib
+
+import "fmt"
+
+// Hooks are optional callbacks for applications embedding the client, for instance to record
+// their own metrics or logs. Hooks run synchronously on the client's goroutines, so they should
+// return quickly and must not call back into the client's send path. Nil hooks are skipped.
+type Hooks struct {
+	// OnMessageSent is called after a message was written to the connection, with the message
+	// as sent: signed, and encrypted when it is a direct message.
+	OnMessageSent func(msg Message)
+	// OnMessageReceived is called for every message read from the connection before it is
+	// delivered on Messages, with its verification status and decrypted content.
+	OnMessageReceived func(msg Message)
+	// OnEncryptFail is called when an outgoing direct message is dropped because the
+	// recipient's public key could not be fetched or the content could not be encrypted.
+	OnEncryptFail func(msg Message, err error)
+	// OnConnect is called whenever a connection to a server is opened: by Connect, by a
+	// reconnect, and when the connection moves to another server.
+	OnConnect func(serverURL string)
+	// OnDisconnect is called when the connection is lost, with the error that broke it, before
+	// the client reconnects, or with nil when the application disconnects the client.
+	OnDisconnect func(err error)
+	// OnReconnect is called after every reconnect attempt with its number, starting at 1, and
+	// its error, which is nil once the client reconnected.
+	OnReconnect func(attempt int, err error)
+}
+
+// SetHooks replaces the instrumentation hooks of the client.
+func (c *Client) SetHooks(hooks Hooks) {
+	c.hooksMu.Lock()
+	defer c.hooksMu.Unlock()
+	c.hooks = hooks
+}
+
+// currentHooks returns the instrumentation hooks of the client.
+func (c *Client) currentHooks() Hooks {
+	c.hooksMu.RLock()
+	defer c.hooksMu.RUnlock()
+	return c.hooks
+}
+
+// sent reports a message written to the connection, of size bytes.
+func (c *Client) sent(msg Message, size int) {
+	c.observeSent(msg, size)
+	if hook := c.currentHooks().OnMessageSent; hook != nil {
+		hook(msg)
+	}
+}
+
+// connected reports a connection opened to a server.
+func (c *Client) connected(serverURL string) {
+	if hook := c.currentHooks().OnConnect; hook != nil {
+		hook(serverURL)
+	}
+}
+
+// disconnected reports the loss of the connection, with a nil error when the application
+// disconnected the client.
+func (c *Client) disconnected(err error) {
+	if hook := c.currentHooks().OnDisconnect; hook != nil {
+		hook(err)
+	}
+}
+
+// reconnectAttempted reports a reconnect attempt and its error.
+func (c *Client) reconnectAttempted(attempt int, err error) {
+	if err == nil {
+		c.count(MetricReconnects, 1)
+	} else {
+		c.count(MetricReconnectFailures, 1)
+	}
+	if hook := c.currentHooks().OnReconnect; hook != nil {
+		hook(attempt, err)
+	}
+}
+
+// deliver hands a received message to the application.
+func (c *Client) deliver(msg Message) {
+	c.learnRoute(msg)
+	c.count(MetricMessagesReceived, 1)
+	if hook := c.currentHooks().OnMessageReceived; hook != nil {
+		hook(msg)
+	}
+	c.recvCh <- msg
+}
+
+// encryptFailed reports an outgoing message dropped because it could not be encrypted.
+func (c *Client) encryptFailed(msg Message, err error) {
+	c.count(MetricEncryptFailures, 1)
+	c.undelivered(msg, fmt.Errorf("%w: %w", ErrEncryption, err))
+	if hook := c.currentHooks().OnEncryptFail; hook != nil {
+		hook(msg, err)
+	}
+}
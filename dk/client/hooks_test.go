@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHooks(t *testing.T) {
+	received := make(chan Message, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws" {
+			// No public keys are known to this server
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteJSON(Message{From: "system", To: "alice", Content: "welcome"})
+		for {
+			var msg Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			received <- msg
+		}
+	}))
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	sent := make(chan Message, 10)
+	delivered := make(chan Message, 10)
+	failed := make(chan error, 10)
+	c.SetHooks(Hooks{
+		OnMessageSent:     func(msg Message) { sent <- msg },
+		OnMessageReceived: func(msg Message) { delivered <- msg },
+		OnEncryptFail:     func(msg Message, err error) { failed <- err },
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Disconnect()
+
+	select {
+	case msg := <-delivered:
+		if msg.Content != "welcome" {
+			t.Errorf("Expected the received message, got %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnMessageReceived was not called")
+	}
+	<-c.Messages()
+
+	// A direct message to a user without a known key cannot be encrypted
+	if err := c.SendMessage(Message{To: "bob", Content: "secret"}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Error("Expected the encryption error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnEncryptFail was not called")
+	}
+
+	if err := c.BroadcastMessage("hello"); err != nil {
+		t.Fatalf("BroadcastMessage failed: %v", err)
+	}
+	select {
+	case msg := <-sent:
+		if msg.Content != "hello" || msg.Signature == "" {
+			t.Errorf("Expected the signed message as sent, got %+v", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnMessageSent was not called")
+	}
+	if msg := <-received; msg.Content != "hello" {
+		t.Errorf("Expected only the broadcast to reach the server, got %+v", msg)
+	}
+}
+
+func TestConnectionHooks(t *testing.T) {
+	// The first connection is dropped when drop is closed
+	drop, first := make(chan struct{}), make(chan struct{}, 1)
+	first <- struct{}{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		select {
+		case <-first:
+			go func() {
+				<-drop
+				conn.Close()
+			}()
+		default:
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	c.SetReconnectPolicy(ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: time.Second})
+	events := make(chan string, 10)
+	c.SetHooks(Hooks{
+		OnConnect: func(serverURL string) { events <- "connect " + serverURL },
+		OnDisconnect: func(err error) {
+			if err == nil {
+				events <- "disconnect"
+			} else {
+				events <- "lost"
+			}
+		},
+		OnReconnect: func(attempt int, err error) {
+			if err == nil {
+				events <- "reconnect"
+			}
+		},
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	next := func() string {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("No connection event reported")
+			return ""
+		}
+	}
+
+	if event := next(); event != "connect "+srv.URL {
+		t.Errorf("Expected the connection to be reported, got %q", event)
+	}
+	// The server drops the connection, which the client reports and opens again
+	close(drop)
+	for _, expected := range []string{"lost", "connect " + srv.URL, "reconnect"} {
+		if event := next(); event != expected {
+			t.Errorf("Expected %q, got %q", expected, event)
+		}
+	}
+	c.Disconnect()
+	if event := next(); event != "disconnect" {
+		t.Errorf("Expected the disconnection to be reported, got %q", event)
+	}
+}
@@ -0,0 +1,230 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// keyHistoryTTL is how long the keys accepted for a user are cached by default before they
+// are fetched again. A message that no cached key verifies fetches them at once, unless they were fetched
+// less than keyRefetchInterval ago.
+var (
+	keyHistoryTTL      = 5 * time.Minute
+	keyRefetchInterval = 10 * time.Second
+)
+
+// keyHistory is the keys whose signatures are accepted for a user, the current one first.
+type keyHistory struct {
+	keys      []ed25519.PublicKey
+	fetchedAt time.Time
+	pinned    bool
+}
+
+// UserKeys is the key history of a user as served by the server: the current key and the keys
+// it replaced, latest first.
+type UserKeys struct {
+	UserID   string        `json:"user_id"`
+	Current  string        `json:"current"` // Base64
+	Previous []PreviousKey `json:"previous"`
+}
+
+// PreviousKey is a key a user rotated away from. Its signatures are accepted until it is
+// revoked.
+type PreviousKey struct {
+	PublicKey string     `json:"public_key"` // Base64
+	Successor string     `json:"successor"`  // The key that replaced it
+	Signature string     `json:"signature"`  // By this key, of the rotation statement naming Successor
+	RetiredAt time.Time  `json:"retired_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// rotationStatement is what a key signs to name the key that replaces it.
+func rotationStatement(userID, newPublicKey string) string {
+	return "dk-key-rotation|" + userID + "|" + newPublicKey
+}
+
+// revocationStatement is what the current key signs to revoke a previous key.
+func revocationStatement(userID, publicKey string) string {
+	return "dk-key-revocation|" + userID + "|" + publicKey
+}
+
+// identity returns the current keypair of the client.
+func (c *Client) identity() (ed25519.PrivateKey, ed25519.PublicKey) {
+	c.keyMu.RLock()
+	defer c.keyMu.RUnlock()
+	return c.privateKey, c.publicKey
+}
+
+// decryptionKeys returns the private keys messages to this client may be encrypted to, the
+// current one first.
+func (c *Client) decryptionKeys() []ed25519.PrivateKey {
+	c.keyMu.RLock()
+	defer c.keyMu.RUnlock()
+	return append([]ed25519.PrivateKey{c.privateKey}, c.previousKeys...)
+}
+
+// AddDecryptionKey keeps a previous private key of this client to decrypt the messages sent to
+// it before the rotation, e.g. after a restart.
+func (c *Client) AddDecryptionKey(privateKey ed25519.PrivateKey) {
+	c.keyMu.Lock()
+	defer c.keyMu.Unlock()
+	c.previousKeys = append(c.previousKeys, privateKey)
+}
+
+// RotateKey replaces the keypair of the client with a new one. The current key signs the new
+// one, so peers keep accepting messages signed by the current key until it is revoked, at once
+// when revokePrevious is set. The current private key is kept for decryption; the new key is
+// used from now on and has to be saved by the caller.
+func (c *Client) RotateKey(newKey ed25519.PrivateKey, revokePrevious bool) error {
+	if len(newKey) != ed25519.PrivateKeySize {
+		return fmt.Errorf("invalid private key size: %d", len(newKey))
+	}
+	oldKey, _ := c.identity()
+	newPub := newKey.Public().(ed25519.PublicKey)
+	encoded := base64.StdEncoding.EncodeToString(newPub)
+	payload := map[string]any{
+		"public_key":      encoded,
+		"signature":       base64.StdEncoding.EncodeToString(ed25519.Sign(oldKey, []byte(rotationStatement(c.UserID, encoded)))),
+		"revoke_previous": revokePrevious,
+	}
+	if err := c.authRequest(http.MethodPost, "/auth/users/"+url.PathEscape(c.UserID)+"/keys", payload, nil); err != nil {
+		return fmt.Errorf("failed to rotate key: %w", err)
+	}
+
+	c.keyMu.Lock()
+	c.previousKeys = append([]ed25519.PrivateKey{oldKey}, c.previousKeys...)
+	c.privateKey, c.publicKey = newKey, newPub
+	c.keyMu.Unlock()
+
+	c.pubKeyCacheMu.Lock()
+	c.pubKeyCache[c.UserID] = newPub
+	delete(c.keyHistories, c.UserID)
+	c.pubKeyCacheMu.Unlock()
+	log.Printf("Rotated key of %s", c.UserID)
+	return nil
+}
+
+// RevokeKey revokes a previous key of this client, so peers no longer accept messages signed
+// by it.
+func (c *Client) RevokeKey(publicKey ed25519.PublicKey) error {
+	encoded := base64.StdEncoding.EncodeToString(publicKey)
+	payload := map[string]string{
+		"public_key": encoded,
+		"signature":  base64.StdEncoding.EncodeToString(c.Sign([]byte(revocationStatement(c.UserID, encoded)))),
+	}
+	if err := c.authRequest(http.MethodPost, "/auth/users/"+url.PathEscape(c.UserID)+"/keys/revoke", payload, nil); err != nil {
+		return fmt.Errorf("failed to revoke key: %w", err)
+	}
+	c.pubKeyCacheMu.Lock()
+	delete(c.keyHistories, c.UserID)
+	c.pubKeyCacheMu.Unlock()
+	return nil
+}
+
+// GetUserPublicKeys returns the keys whose signatures are accepted for a user: the current key
+// first, then the previous keys that were not revoked. Servers without key history serve the
+// current key only.
+func (c *Client) GetUserPublicKeys(userID string) ([]ed25519.PublicKey, error) {
+	if keys, ok := c.cachedKeys(userID); ok {
+		return keys, nil
+	}
+	return c.fetchUserPublicKeys(userID)
+}
+
+// fetchUserPublicKeys fetches the keys accepted for a user from the server, checks them against
+// the key trusted for the user, and caches them.
+func (c *Client) fetchUserPublicKeys(userID string) ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	var history UserKeys
+	err := c.authRequest(http.MethodGet, "/auth/users/"+url.PathEscape(userID)+"/keys", nil, &history)
+	if err == nil && history.Current != "" {
+		keys, err = acceptedKeys(userID, history)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		current, err := c.GetUserPublicKey(userID)
+		if err != nil {
+			return nil, err
+		}
+		keys = []ed25519.PublicKey{current}
+	}
+
+	keys = c.trustKeys(userID, keys)
+
+	c.pubKeyCacheMu.Lock()
+	c.cacheKeysLocked(userID, keys, time.Now(), false)
+	c.pubKeyCacheMu.Unlock()
+	return keys, nil
+}
+
+// acceptedKeys returns the current key of a key history and the previous keys that were not
+// revoked. A previous key is only accepted if it signed the rotation to its successor, and that
+// successor is itself a key of the user.
+func acceptedKeys(userID string, history UserKeys) ([]ed25519.PublicKey, error) {
+	current, err := base64.StdEncoding.DecodeString(history.Current)
+	if err != nil || len(current) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid current key for user %s", userID)
+	}
+	known := map[string]bool{history.Current: true}
+	for _, previous := range history.Previous {
+		known[previous.PublicKey] = true
+	}
+
+	keys := []ed25519.PublicKey{current}
+	for _, previous := range history.Previous {
+		if previous.RevokedAt != nil || !known[previous.Successor] {
+			continue
+		}
+		pub, err := base64.StdEncoding.DecodeString(previous.PublicKey)
+		if err != nil || len(pub) != ed25519.PublicKeySize {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(previous.Signature)
+		if err != nil || !ed25519.Verify(pub, []byte(rotationStatement(userID, previous.Successor)), sig) {
+			log.Printf("WARNING: Ignoring previous key of %s without a valid rotation signature", userID)
+			continue
+		}
+		keys = append(keys, pub)
+	}
+	return keys, nil
+}
+
+// verifySender reports whether a message was signed by any accepted key of its sender. The
+// keys are fetched again when none of the cached ones verifies it, since the sender may have
+// rotated since.
+func (c *Client) verifySender(msg Message) (bool, error) {
+	keys, err := c.GetUserPublicKeys(msg.From)
+	if err != nil {
+		return false, err
+	}
+	if c.signedByAny(msg, keys) {
+		return true, nil
+	}
+
+	c.pubKeyCacheMu.RLock()
+	history := c.keyHistories[msg.From]
+	c.pubKeyCacheMu.RUnlock()
+	if history.pinned || time.Since(history.fetchedAt) < keyRefetchInterval {
+		return false, nil
+	}
+	if keys, err = c.fetchUserPublicKeys(msg.From); err != nil {
+		return false, err
+	}
+	return c.signedByAny(msg, keys), nil
+}
+
+// signedByAny reports whether a message was signed by any of the keys.
+func (c *Client) signedByAny(msg Message, keys []ed25519.PublicKey) bool {
+	for _, key := range keys {
+		if c.verifyMessageSignature(msg, key) {
+			return true
+		}
+	}
+	return false
+}
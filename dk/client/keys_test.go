@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// keyServer keeps the key history of users the way the server does, checking the signatures
+// of rotations and revocations.
+type keyServer struct {
+	mu    sync.Mutex
+	users map[string]*UserKeys
+}
+
+func (s *keyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	userID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/auth/users/"), "/")
+	keys, ok := s.users[userID]
+	if !ok {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodPost {
+		var payload struct {
+			PublicKey      string `json:"public_key"`
+			Signature      string `json:"signature"`
+			RevokePrevious bool   `json:"revoke_previous"`
+		}
+		json.NewDecoder(r.Body).Decode(&payload)
+		statement := rotationStatement(userID, payload.PublicKey)
+		if action == "keys/revoke" {
+			statement = revocationStatement(userID, payload.PublicKey)
+		}
+		current, _ := base64.StdEncoding.DecodeString(keys.Current)
+		sig, _ := base64.StdEncoding.DecodeString(payload.Signature)
+		if !ed25519.Verify(current, []byte(statement), sig) {
+			http.Error(w, "signature does not match the statement", http.StatusUnauthorized)
+			return
+		}
+		now := time.Now()
+		if action == "keys/revoke" {
+			for i := range keys.Previous {
+				if keys.Previous[i].PublicKey == payload.PublicKey {
+					keys.Previous[i].RevokedAt = &now
+				}
+			}
+		} else {
+			previous := PreviousKey{PublicKey: keys.Current, Successor: payload.PublicKey, Signature: payload.Signature, RetiredAt: now}
+			if payload.RevokePrevious {
+				previous.RevokedAt = &now
+			}
+			keys.Previous = append([]PreviousKey{previous}, keys.Previous...)
+			keys.Current = payload.PublicKey
+		}
+	}
+	json.NewEncoder(w).Encode(keys)
+}
+
+// signedBy returns a message from a user signed with a key.
+func signedBy(from string, key ed25519.PrivateKey) Message {
+	signer := NewClient("", from, key, key.Public().(ed25519.PublicKey))
+	msg := Message{From: from, To: "bob", Content: "hello", Timestamp: time.Now()}
+	signer.signMessage(&msg)
+	return msg
+}
+
+func TestRotateKey(t *testing.T) {
+	defer func(interval time.Duration) { keyRefetchInterval = interval }(keyRefetchInterval)
+	keyRefetchInterval = 0
+
+	oldPub, oldPriv, _ := ed25519.GenerateKey(rand.Reader)
+	_, newPriv, _ := ed25519.GenerateKey(rand.Reader)
+	server := &keyServer{users: map[string]*UserKeys{
+		"alice": {UserID: "alice", Current: base64.StdEncoding.EncodeToString(oldPub)},
+	}}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+	alice := NewClient(srv.URL, "alice", oldPriv, oldPub)
+	alice.jwtToken = "alice"
+	bobPub, bobPriv, _ := ed25519.GenerateKey(rand.Reader)
+	bob := NewClient(srv.URL, "bob", bobPriv, bobPub)
+	bob.jwtToken = "bob"
+
+	// A message sealed to the old key is still readable after the rotation
+	sealed, err := bob.sealContent("before the rotation", oldPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := bob.verifySender(signedBy("alice", oldPriv)); err != nil || !valid {
+		t.Fatalf("Expected a message signed by the current key to verify, got %v %v", valid, err)
+	}
+
+	if err := alice.RotateKey(newPriv, false); err != nil {
+		t.Fatalf("RotateKey failed: %v", err)
+	}
+	if !alice.PublicKey().Equal(newPriv.Public()) {
+		t.Error("Expected the client to use the new key")
+	}
+	if plaintext, err := alice.openContent(sealed); err != nil || plaintext != "before the rotation" {
+		t.Errorf("Expected the previous key to decrypt, got %q %v", plaintext, err)
+	}
+
+	// Bob cached the old key only; the new signature makes him fetch the history again
+	if valid, err := bob.verifySender(signedBy("alice", newPriv)); err != nil || !valid {
+		t.Fatalf("Expected a message signed by the new key to verify, got %v %v", valid, err)
+	}
+	if valid, _ := bob.verifySender(signedBy("alice", oldPriv)); !valid {
+		t.Error("Expected a message signed by the previous key to verify until it is revoked")
+	}
+	if keys, _ := bob.GetUserPublicKeys("alice"); len(keys) != 2 || !keys[0].Equal(newPriv.Public()) {
+		t.Errorf("Expected the new key first, then the previous one, got %d keys", len(keys))
+	}
+
+	if err := alice.RevokeKey(oldPub); err != nil {
+		t.Fatalf("RevokeKey failed: %v", err)
+	}
+	bob.SetKeyCacheTTL(0)
+	if valid, _ := bob.verifySender(signedBy("alice", oldPriv)); valid {
+		t.Error("Expected a message signed by a revoked key to be rejected")
+	}
+	_, otherPriv, _ := ed25519.GenerateKey(rand.Reader)
+	if valid, _ := bob.verifySender(signedBy("alice", otherPriv)); valid {
+		t.Error("Expected a message signed by an unknown key to be rejected")
+	}
+}
+
+func TestAcceptedKeys(t *testing.T) {
+	oldPub, oldPriv, _ := ed25519.GenerateKey(rand.Reader)
+	newPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	forgedPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	encode := base64.StdEncoding.EncodeToString
+	current := encode(newPub)
+	history := UserKeys{Current: current, Previous: []PreviousKey{
+		{PublicKey: encode(oldPub), Successor: current, Signature: encode(ed25519.Sign(oldPriv, []byte(rotationStatement("alice", current))))},
+		// Listed by the server, but it never signed a rotation
+		{PublicKey: encode(forgedPub), Successor: current, Signature: encode(ed25519.Sign(oldPriv, []byte(rotationStatement("alice", current))))},
+	}}
+	keys, err := acceptedKeys("alice", history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || !keys[0].Equal(newPub) || !keys[1].Equal(oldPub) {
+		t.Errorf("Expected the current and the signed previous key, got %d keys", len(keys))
+	}
+	// The rotation of another user does not carry over
+	if keys, _ := acceptedKeys("mallory", history); len(keys) != 1 {
+		t.Errorf("Expected only the current key, got %d keys", len(keys))
+	}
+}
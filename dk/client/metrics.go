@@ -0,0 +1,200 @@
+package lib
+
+import (
+	"encoding/json"
+	"expvar"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Names of the metrics recorded by the client.
+const (
+	MetricMessagesSent      = "messages_sent"        // Counter
+	MetricMessagesReceived  = "messages_received"    // Counter
+	MetricReconnects        = "reconnects"           // Counter of successful reconnects
+	MetricReconnectFailures = "reconnect_failures"   // Counter of failed reconnect attempts
+	MetricEncryptFailures   = "encrypt_failures"     // Counter
+	MetricMessagesDropped   = "messages_dropped"     // Counter of messages dropped from a full send queue
+	MetricSendQueueDepth    = "send_queue_depth"     // Gauge of messages waiting to be sent
+	MetricMessageBytes      = "message_bytes"        // Histogram of the size of sent messages
+	MetricSendLatency       = "send_latency_seconds" // Histogram of the time from queueing to writing
+)
+
+// Metrics receives the counters, gauges and histogram observations of the client, for instance
+// to export them to a monitoring system. Its methods are called on the client's goroutines, so
+// they should return quickly.
+type Metrics interface {
+	// Add adds delta to a counter.
+	Add(name string, delta int64)
+	// Set sets a gauge.
+	Set(name string, value float64)
+	// Observe records a value of a histogram.
+	Observe(name string, value float64)
+}
+
+// SetMetrics makes the client record its metrics to m. A nil m stops recording.
+func (c *Client) SetMetrics(m Metrics) {
+	c.hooksMu.Lock()
+	defer c.hooksMu.Unlock()
+	c.metrics = m
+}
+
+// currentMetrics returns the metrics the client records to, or nil.
+func (c *Client) currentMetrics() Metrics {
+	c.hooksMu.RLock()
+	defer c.hooksMu.RUnlock()
+	return c.metrics
+}
+
+// count adds delta to a counter of the client's metrics.
+func (c *Client) count(name string, delta int64) {
+	if m := c.currentMetrics(); m != nil {
+		m.Add(name, delta)
+	}
+}
+
+// queueDepth records the number of messages waiting in the send queue.
+func (c *Client) queueDepth() {
+	if m := c.currentMetrics(); m != nil {
+		m.Set(MetricSendQueueDepth, float64(len(c.queue())))
+	}
+}
+
+// histogramBuckets are the upper bounds of the buckets of the histograms recorded by
+// ExpvarMetrics. Histograms not listed use defaultBuckets.
+var (
+	histogramBuckets = map[string][]float64{
+		MetricMessageBytes: {256, 1024, 4096, 16384, 65536, 262144, 1048576},
+	}
+	defaultBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10, 60}
+)
+
+// ExpvarMetrics keeps the metrics of a client in expvar variables. It is itself an expvar.Var,
+// so it can be published on /debug/vars:
+//
+//	metrics := lib.NewExpvarMetrics()
+//	client.SetMetrics(metrics)
+//	expvar.Publish("dk_client", metrics)
+type ExpvarMetrics struct {
+	vars expvar.Map
+	mu   sync.Mutex // Serializes the creation of variables
+}
+
+// NewExpvarMetrics returns empty metrics.
+func NewExpvarMetrics() *ExpvarMetrics {
+	m := &ExpvarMetrics{}
+	m.vars.Init()
+	return m
+}
+
+// Add adds delta to a counter.
+func (m *ExpvarMetrics) Add(name string, delta int64) {
+	m.vars.Add(name, delta)
+}
+
+// Set sets a gauge.
+func (m *ExpvarMetrics) Set(name string, value float64) {
+	m.variable(name, func() expvar.Var { return new(expvar.Float) }).(*expvar.Float).Set(value)
+}
+
+// Observe records a value of a histogram.
+func (m *ExpvarMetrics) Observe(name string, value float64) {
+	m.variable(name, func() expvar.Var { return newHistogram(name) }).(*histogram).observe(value)
+}
+
+// Get returns the variable of a metric, or nil if it was not recorded yet.
+func (m *ExpvarMetrics) Get(name string) expvar.Var {
+	return m.vars.Get(name)
+}
+
+// String returns the metrics as a JSON object, as expvar.Var.
+func (m *ExpvarMetrics) String() string {
+	return m.vars.String()
+}
+
+// variable returns the variable of a metric, creating it if needed.
+func (m *ExpvarMetrics) variable(name string, create func() expvar.Var) expvar.Var {
+	if v := m.vars.Get(name); v != nil {
+		return v
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v := m.vars.Get(name)
+	if v == nil {
+		v = create()
+		m.vars.Set(name, v)
+	}
+	return v
+}
+
+// histogram counts observed values in buckets, cumulatively like Prometheus histograms.
+type histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64 // Per bound, then one for the values above the last bound
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(name string) *histogram {
+	bounds, ok := histogramBuckets[name]
+	if !ok {
+		bounds = defaultBuckets
+	}
+	return &histogram{bounds: bounds, buckets: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buckets[sort.SearchFloat64s(h.bounds, value)]++
+	h.count++
+	h.sum += value
+}
+
+// HistogramSnapshot is the state of a histogram: the number and sum of the observed values,
+// and how many of them were at most each bucket's upper bound, "+Inf" for all of them.
+type HistogramSnapshot struct {
+	Count   uint64            `json:"count"`
+	Sum     float64           `json:"sum"`
+	Buckets map[string]uint64 `json:"buckets"`
+}
+
+// Snapshot returns the state of the histogram.
+func (h *histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	snapshot := HistogramSnapshot{Count: h.count, Sum: h.sum, Buckets: make(map[string]uint64, len(h.buckets))}
+	var cumulative uint64
+	for i, n := range h.buckets {
+		cumulative += n
+		bound := math.Inf(1)
+		if i < len(h.bounds) {
+			bound = h.bounds[i]
+		}
+		snapshot.Buckets[strconv.FormatFloat(bound, 'f', -1, 64)] = cumulative
+	}
+	return snapshot
+}
+
+// String returns the histogram as a JSON object, as expvar.Var.
+func (h *histogram) String() string {
+	data, _ := json.Marshal(h.Snapshot())
+	return string(data)
+}
+
+// observeSent records the metrics of a message written to the connection, of size bytes.
+func (c *Client) observeSent(msg Message, size int) {
+	m := c.currentMetrics()
+	if m == nil {
+		return
+	}
+	m.Add(MetricMessagesSent, 1)
+	m.Observe(MetricMessageBytes, float64(size))
+	if !msg.queuedAt.IsZero() {
+		m.Observe(MetricSendLatency, time.Since(msg.queuedAt).Seconds())
+	}
+}
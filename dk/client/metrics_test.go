@@ -0,0 +1,110 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestMetrics(t *testing.T) {
+	received := make(chan Message, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ws" {
+			http.NotFound(w, r)
+			return
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteJSON(Message{From: "system", To: "alice", Content: "welcome"})
+		for {
+			var msg Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			received <- msg
+		}
+	}))
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	metrics := NewExpvarMetrics()
+	c.SetMetrics(metrics)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Disconnect()
+	<-c.Messages()
+
+	// Without a known key of bob the direct message is dropped
+	if err := c.SendMessage(Message{To: "bob", Content: "secret"}); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if err := c.BroadcastMessage("hello"); err != nil {
+		t.Fatalf("BroadcastMessage failed: %v", err)
+	}
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The broadcast did not reach the server")
+	}
+
+	// The counter is incremented after the write returns
+	deadline := time.Now().Add(5 * time.Second)
+	for metrics.Get(MetricMessagesSent) == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for name, want := range map[string]int64{
+		MetricMessagesSent:     1,
+		MetricMessagesReceived: 1,
+		MetricEncryptFailures:  1,
+	} {
+		v, ok := metrics.Get(name).(*expvar.Int)
+		if !ok || v.Value() != want {
+			t.Errorf("Expected %s to be %d, got %v", name, want, metrics.Get(name))
+		}
+	}
+	if depth, ok := metrics.Get(MetricSendQueueDepth).(*expvar.Float); !ok || depth.Value() != 0 {
+		t.Errorf("Expected an empty send queue, got %v", metrics.Get(MetricSendQueueDepth))
+	}
+	for _, name := range []string{MetricMessageBytes, MetricSendLatency} {
+		h, ok := metrics.Get(name).(*histogram)
+		if !ok || h.Snapshot().Count != 1 {
+			t.Errorf("Expected one observation of %s, got %v", name, metrics.Get(name))
+		}
+	}
+
+	var vars map[string]any
+	if err := json.Unmarshal([]byte(metrics.String()), &vars); err != nil {
+		t.Fatalf("Expected the metrics as JSON, got %q: %v", metrics.String(), err)
+	}
+	if _, ok := vars[MetricMessageBytes].(map[string]any)["buckets"]; !ok {
+		t.Errorf("Expected the buckets of %s, got %v", MetricMessageBytes, vars[MetricMessageBytes])
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram(MetricMessageBytes)
+	for _, size := range []float64{100, 256, 1000, 5000000} {
+		h.observe(size)
+	}
+	snapshot := h.Snapshot()
+	if snapshot.Count != 4 || snapshot.Sum != 5001356 {
+		t.Errorf("Expected 4 values summing to 5001356, got %+v", snapshot)
+	}
+	for bound, want := range map[string]uint64{"256": 2, "1024": 3, "1048576": 3, "+Inf": 4} {
+		if snapshot.Buckets[bound] != want {
+			t.Errorf("Expected %d values up to %s, got %d", want, bound, snapshot.Buckets[bound])
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+)
+
+// HomeNetwork is the name of the network of the server the client itself connects to.
+const HomeNetwork = "home"
+
+// AddNetwork joins a partner network through another client, connected to the server of that
+// network, so this client takes part in several networks at once. Messages received on the
+// partner network are delivered on Messages with their Network set, and messages to peers
+// routed to it are sent through it: peers are routed with Route, or else to the network they
+// last sent a message on, and to the home network by default. Broadcasts go to every network.
+// The partner client is disconnected with this one.
+func (c *Client) AddNetwork(name string, partner *Client) error {
+	if name == "" || name == HomeNetwork {
+		return fmt.Errorf("invalid network name %q", name)
+	}
+	if partner == nil || partner == c {
+		return errors.New("a partner network needs its own client")
+	}
+	c.networksMu.Lock()
+	defer c.networksMu.Unlock()
+	if c.closed() {
+		return ErrNotConnected
+	}
+	if _, exists := c.partners[name]; exists {
+		return fmt.Errorf("network %q already added", name)
+	}
+	c.partners[name] = partner
+	c.forwarders.Add(1)
+	go c.forwardNetwork(name, partner)
+	return nil
+}
+
+// Networks returns the names of the networks of the client, the home network first.
+func (c *Client) Networks() []string {
+	c.networksMu.RLock()
+	defer c.networksMu.RUnlock()
+	names := make([]string, 0, len(c.partners))
+	for name := range c.partners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append([]string{HomeNetwork}, names...)
+}
+
+// Network returns the client of a network, or nil for an unknown one.
+func (c *Client) Network(name string) *Client {
+	if name == "" || name == HomeNetwork {
+		return c
+	}
+	c.networksMu.RLock()
+	defer c.networksMu.RUnlock()
+	return c.partners[name]
+}
+
+// Route sends the messages to a peer, or to a group, through a network, whatever network the
+// peer writes from. An empty network removes the route.
+func (c *Client) Route(peerID, network string) error {
+	c.networksMu.Lock()
+	defer c.networksMu.Unlock()
+	if network == "" {
+		delete(c.routes, peerID)
+		return nil
+	}
+	if _, exists := c.partners[network]; !exists && network != HomeNetwork {
+		return fmt.Errorf("unknown network %q", network)
+	}
+	c.routes[peerID] = network
+	return nil
+}
+
+// routeFor returns the client of the partner network messages to a recipient are sent
+// through, or nil for the home network.
+func (c *Client) routeFor(to string) *Client {
+	if to == broadcastAddress || to == systemAddress {
+		return nil
+	}
+	c.networksMu.RLock()
+	defer c.networksMu.RUnlock()
+	if len(c.partners) == 0 {
+		return nil
+	}
+	network, ok := c.routes[to]
+	if !ok {
+		network = c.learned[to]
+	}
+	return c.partners[network]
+}
+
+// learnRoute records the network a peer last sent a message on.
+func (c *Client) learnRoute(msg Message) {
+	if msg.From == "" || msg.From == systemAddress || msg.From == c.UserID {
+		return
+	}
+	c.networksMu.Lock()
+	defer c.networksMu.Unlock()
+	if len(c.partners) == 0 {
+		return
+	}
+	if msg.Network == "" {
+		delete(c.learned, msg.From)
+	} else {
+		c.learned[msg.From] = msg.Network
+	}
+}
+
+// partnerClients returns the clients of the partner networks.
+func (c *Client) partnerClients() []*Client {
+	c.networksMu.RLock()
+	defer c.networksMu.RUnlock()
+	partners := make([]*Client, 0, len(c.partners))
+	for _, partner := range c.partners {
+		partners = append(partners, partner)
+	}
+	return partners
+}
+
+// forwardNetwork delivers the messages received on a partner network until either client is
+// disconnected.
+func (c *Client) forwardNetwork(name string, partner *Client) {
+	defer c.forwarders.Done()
+	for {
+		select {
+		case msg, ok := <-partner.Messages():
+			if !ok {
+				return
+			}
+			msg.Network = name
+			c.learnRoute(msg)
+			select {
+			case c.recvCh <- msg:
+			case <-c.doneCh:
+				return
+			}
+		case <-c.doneCh:
+			return
+		}
+	}
+}
+
+// disconnectNetworks disconnects the clients of the partner networks.
+func (c *Client) disconnectNetworks() {
+	for _, partner := range c.partnerClients() {
+		if err := partner.Disconnect(); err != nil {
+			log.Printf("Error disconnecting from a partner network: %v", err)
+		}
+	}
+}
+
+// broadcastNetworks broadcasts a message on the partner networks.
+func (c *Client) broadcastNetworks(content string) error {
+	var errs []error
+	for _, partner := range c.partnerClients() {
+		if err := partner.BroadcastMessage(content); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,87 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"slices"
+	"testing"
+	"time"
+)
+
+// queued returns the recipients of the messages waiting in the send queue of a client.
+func queued(c *Client) []string {
+	var recipients []string
+	for len(c.sendCh) > 0 {
+		recipients = append(recipients, (<-c.sendCh).To)
+	}
+	return recipients
+}
+
+func TestNetworks(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	home := NewClient("", "alice", priv, pub)
+	partner := NewClient("", "alice", priv, pub)
+	if err := home.AddNetwork(HomeNetwork, partner); err == nil {
+		t.Error("Expected the home network name to be refused")
+	}
+	if err := home.AddNetwork("partner", partner); err != nil {
+		t.Fatalf("AddNetwork failed: %v", err)
+	}
+	if err := home.AddNetwork("partner", NewClient("", "alice", priv, pub)); err == nil {
+		t.Error("Expected a second network with the same name to be refused")
+	}
+	if names := home.Networks(); !slices.Equal(names, []string{HomeNetwork, "partner"}) || home.Network("partner") != partner {
+		t.Errorf("Expected the home and partner networks, got %v", names)
+	}
+
+	// Messages of the partner network are delivered with it, and replies go back through it
+	partner.deliver(Message{From: "carol", To: "alice", Content: "hello", MessageID: "m1"})
+	select {
+	case msg := <-home.Messages():
+		if msg.Network != "partner" || msg.From != "carol" {
+			t.Fatalf("Expected the message of carol on the partner network, got %+v", msg)
+		}
+		if err := home.MarkRead(msg); err != nil {
+			t.Fatalf("MarkRead failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The message of the partner network was not delivered")
+	}
+	home.SendMessage(Message{To: "carol", Content: "reply"})
+	home.SendMessage(Message{To: "dave", Content: "hello"})
+	if got := queued(partner); !slices.Equal(got, []string{"carol", "carol"}) {
+		t.Errorf("Expected the acknowledgment and reply to carol on the partner network, got %v", got)
+	}
+	if got := queued(home); !slices.Equal(got, []string{"dave"}) {
+		t.Errorf("Expected the message to dave on the home network, got %v", got)
+	}
+
+	// A route overrides the network the peer last wrote from
+	if err := home.Route("carol", "other"); err == nil {
+		t.Error("Expected a route to an unknown network to be refused")
+	}
+	home.Route("carol", HomeNetwork)
+	home.SendMessage(Message{To: "carol", Content: "routed"})
+	if got := queued(home); !slices.Equal(got, []string{"carol"}) {
+		t.Errorf("Expected the routed message on the home network, got %v", got)
+	}
+	home.Route("carol", "")
+	home.deliver(Message{From: "carol", To: "alice"})
+	<-home.Messages()
+	home.SendMessage(Message{To: "carol", Content: "back home"})
+	if got := queued(home); !slices.Equal(got, []string{"carol"}) {
+		t.Errorf("Expected carol to be routed to the network she last wrote from, got %v", got)
+	}
+
+	if err := home.BroadcastMessage("everyone"); err != nil {
+		t.Fatalf("BroadcastMessage failed: %v", err)
+	}
+	if len(queued(home)) != 1 || len(queued(partner)) != 1 {
+		t.Error("Expected the broadcast on both networks")
+	}
+
+	home.Disconnect()
+	if !partner.closed() {
+		t.Error("Expected the partner network to be disconnected with the client")
+	}
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// outboxBatch is how many stored messages are read at a time when flushing the outbox.
+const outboxBatch = 50
+
+// OutboxEntry is a message kept by an OutboxStore until it is sent.
+type OutboxEntry struct {
+	ID       int64
+	Message  Message // As queued: not yet encrypted nor signed
+	QueuedAt time.Time
+}
+
+// OutboxStore keeps outgoing messages until they are written to the connection, so that
+// messages queued while the connection is down survive a failed reconnect or a restart.
+// Messages are sent in the order they were added.
+type OutboxStore interface {
+	// Add stores a message queued at the given time.
+	Add(msg Message, queuedAt time.Time) error
+	// Pending returns up to limit stored messages, oldest first.
+	Pending(limit int) ([]OutboxEntry, error)
+	// Remove deletes a message once it was sent or dropped.
+	Remove(id int64) error
+	// Len returns the number of stored messages.
+	Len() (int, error)
+}
+
+// SetOutboxStore makes the client store every outgoing message before sending it. Stored
+// messages are sent in order once the client is connected, including those left over by a
+// previous run; messages older than ReconnectPolicy.MaxQueueAge are dropped instead. Without
+// a store, messages queued while disconnected are kept in memory only.
+func (c *Client) SetOutboxStore(store OutboxStore) {
+	c.outboxMu.Lock()
+	c.outboxStore = store
+	c.outboxMu.Unlock()
+	c.signalOutbox()
+}
+
+// currentOutboxStore returns the outbox store of the client, if any.
+func (c *Client) currentOutboxStore() OutboxStore {
+	c.outboxMu.Lock()
+	defer c.outboxMu.Unlock()
+	return c.outboxStore
+}
+
+// signalOutbox wakes the writer up to flush the outbox store.
+func (c *Client) signalOutbox() {
+	select {
+	case c.outboxReady <- struct{}{}:
+	default:
+	}
+}
+
+// storeMessage adds a queued message to the outbox store. It reports false when there is no
+// store or the message could not be stored, in which case it is queued in memory instead.
+func (c *Client) storeMessage(msg Message) bool {
+	store := c.currentOutboxStore()
+	if store == nil {
+		return false
+	}
+	if err := store.Add(msg, msg.queuedAt); err != nil {
+		log.Printf("Failed to store message to %s, keeping it in memory: %v", msg.To, err)
+		return false
+	}
+	c.signalOutbox()
+	return true
+}
+
+// flushStored writes the messages of the outbox store in order, removing each one once it was
+// written. A message that could not be written stays stored for the next connection.
+func (c *Client) flushStored(conn *websocket.Conn) error {
+	store := c.currentOutboxStore()
+	if store == nil {
+		return nil
+	}
+	for {
+		entries, err := store.Pending(outboxBatch)
+		if err != nil {
+			log.Printf("Failed to read the outbox: %v", err)
+			return nil
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		for _, entry := range entries {
+			msg := entry.Message
+			msg.queuedAt = entry.QueuedAt
+			if !c.expired(msg) {
+				prepared, ok := c.prepare(msg)
+				if ok {
+					msgBytes, err := json.Marshal(prepared)
+					if err != nil {
+						log.Printf("Failed to marshal message: %v", err)
+					} else {
+						conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+						if err := conn.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+							return err
+						}
+						c.sent(prepared, len(msgBytes))
+					}
+				}
+			}
+			// A message that cannot be removed would be sent again and again
+			if err := store.Remove(entry.ID); err != nil {
+				log.Printf("Failed to remove message %d from the outbox: %v", entry.ID, err)
+				return nil
+			}
+		}
+	}
+}
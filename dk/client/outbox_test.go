@@ -0,0 +1,150 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryOutbox is an OutboxStore kept in memory; reopen simulates a restart of the client.
+type memoryOutbox struct {
+	mu      sync.Mutex
+	nextID  int64
+	entries []OutboxEntry
+}
+
+func (o *memoryOutbox) Add(msg Message, queuedAt time.Time) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.nextID++
+	o.entries = append(o.entries, OutboxEntry{ID: o.nextID, Message: msg, QueuedAt: queuedAt})
+	return nil
+}
+
+func (o *memoryOutbox) Pending(limit int) ([]OutboxEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.entries) < limit {
+		limit = len(o.entries)
+	}
+	return append([]OutboxEntry(nil), o.entries[:limit]...), nil
+}
+
+func (o *memoryOutbox) Remove(id int64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, entry := range o.entries {
+		if entry.ID == id {
+			o.entries = append(o.entries[:i], o.entries[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (o *memoryOutbox) Len() (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries), nil
+}
+
+func TestOutboxStoreFlushesInOrderAfterReconnect(t *testing.T) {
+	server := &failoverServer{retrying: make(chan struct{}, 1), received: make(chan Message, 10)}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	c.SetReconnectInterval(10 * time.Millisecond)
+	c.jwtToken = "active-token"
+	store := &memoryOutbox{}
+	c.SetOutboxStore(store)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Disconnect()
+
+	select {
+	case <-server.retrying:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not try to reconnect")
+	}
+
+	// Queued while the server is down: they must be stored, not left in memory
+	contents := []string{"first", "second", "third"}
+	for _, content := range contents {
+		if err := c.BroadcastMessage(content); err != nil {
+			t.Fatalf("BroadcastMessage failed: %v", err)
+		}
+	}
+	if stored, _ := store.Len(); stored != len(contents) {
+		t.Fatalf("Expected %d stored messages, got %d", len(contents), stored)
+	}
+	if queued := c.Status().QueuedMessages; queued != len(contents) {
+		t.Errorf("Expected Status to report %d queued messages, got %d", len(contents), queued)
+	}
+
+	server.mu.Lock()
+	server.promoted = true
+	server.mu.Unlock()
+
+	for _, want := range contents {
+		select {
+		case msg := <-server.received:
+			if msg.Content != want {
+				t.Errorf("Expected %q, got %q", want, msg.Content)
+			}
+			if msg.Signature == "" {
+				t.Errorf("Expected %q to be signed", want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Message %q was not delivered after the reconnect", want)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stored, _ := store.Len()
+		if stored == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the outbox to be emptied, %d messages left", stored)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestOutboxStoreDropsExpiredMessages(t *testing.T) {
+	store := &memoryOutbox{}
+	store.Add(Message{From: "alice", To: "broadcast", Content: "stale"}, time.Now().Add(-time.Hour))
+	store.Add(Message{From: "alice", To: "broadcast", Content: "fresh"}, time.Now())
+
+	// The second connection of the failover server is accepted once promoted
+	server := &failoverServer{retrying: make(chan struct{}, 1), received: make(chan Message, 10), promoted: true, connections: 1}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	c.jwtToken = "standby-token"
+	policy := DefaultReconnectPolicy()
+	policy.MaxQueueAge = time.Minute
+	c.SetReconnectPolicy(policy)
+	c.SetOutboxStore(store)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Disconnect()
+
+	select {
+	case msg := <-server.received:
+		if msg.Content != "fresh" {
+			t.Errorf("Expected only the fresh message to be sent, got %q", msg.Content)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Message left over from a previous run was not sent")
+	}
+}
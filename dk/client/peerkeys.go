@@ -0,0 +1,248 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxCachedKeys is the number of users whose keys are cached in memory. Beyond it, expired
+// entries are evicted, then arbitrary ones except pinned keys; evicted keys are fetched again
+// when needed.
+var maxCachedKeys = 10000
+
+// PeerKey is the public key trusted for a peer: the first key seen for it, the key it rotated
+// to with a signature of that key, or a key pinned by the application.
+type PeerKey struct {
+	UserID    string
+	PublicKey ed25519.PublicKey
+	Pinned    bool      // Only this key is accepted for the peer
+	FirstSeen time.Time // When this key was first trusted
+	FetchedAt time.Time // When this key was last served by the server
+}
+
+// PeerKeyStore keeps the key trusted for each peer, so a key changed by the server is noticed
+// across restarts, and keys fetched recently are not fetched again after one. Without one,
+// they are kept in memory.
+type PeerKeyStore interface {
+	// SavePeerKey stores the key trusted for a peer, replacing the previous one.
+	SavePeerKey(key PeerKey) error
+	// PeerKey returns the key trusted for a peer.
+	PeerKey(userID string) (PeerKey, bool, error)
+}
+
+// KeyChange reports that the server served a key for a peer other than the trusted one.
+type KeyChange struct {
+	UserID   string
+	Previous ed25519.PublicKey // The trusted key
+	Current  ed25519.PublicKey // The key served by the server
+	// Rotated is set when the previous key signed its rotation to the new one, which is then
+	// trusted. Otherwise the new key is trusted on the word of the server alone.
+	Rotated bool
+	// Pinned is set when the previous key is pinned; the new key is then refused.
+	Pinned bool
+	At     time.Time
+}
+
+// SetPeerKeyStore replaces the store of trusted peer keys, and clears the keys cached in memory.
+func (c *Client) SetPeerKeyStore(store PeerKeyStore) {
+	c.pubKeyCacheMu.Lock()
+	defer c.pubKeyCacheMu.Unlock()
+	c.peerKeys = store
+	c.keyHistories = make(map[string]keyHistory)
+	c.pubKeyCache = map[string]ed25519.PublicKey{c.UserID: c.PublicKey()}
+	c.pubKeyFetchedAt = make(map[string]time.Time)
+}
+
+// SetKeyCacheTTL sets how long the keys of a user are used before they are fetched from the
+// server again, 5 minutes by default. Pinned keys are never fetched again.
+func (c *Client) SetKeyCacheTTL(ttl time.Duration) {
+	c.pubKeyCacheMu.Lock()
+	defer c.pubKeyCacheMu.Unlock()
+	c.keyCacheTTL = ttl
+}
+
+// KeyChanges returns the channel on which changes of the keys of peers are reported. Changes
+// are dropped while the channel is full.
+func (c *Client) KeyChanges() <-chan KeyChange {
+	return c.keyChanges
+}
+
+// PinPeerKey makes key the only key accepted for a peer, whatever the server serves, e.g. after
+// comparing it with the peer out of band.
+func (c *Client) PinPeerKey(userID string, key ed25519.PublicKey) error {
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key size: %d", len(key))
+	}
+	now := time.Now()
+	trusted := PeerKey{UserID: userID, PublicKey: key, Pinned: true, FirstSeen: now, FetchedAt: now}
+	c.pubKeyCacheMu.Lock()
+	defer c.pubKeyCacheMu.Unlock()
+	if stored, found, err := c.peerKeys.PeerKey(userID); err == nil && found && stored.PublicKey.Equal(key) {
+		trusted.FirstSeen = stored.FirstSeen
+	}
+	if err := c.peerKeys.SavePeerKey(trusted); err != nil {
+		return fmt.Errorf("failed to pin the key of %s: %w", userID, err)
+	}
+	c.cacheKeysLocked(userID, []ed25519.PublicKey{key}, now, true)
+	return nil
+}
+
+// UnpinPeerKey accepts the keys served by the server for a peer again, starting from its
+// pinned key.
+func (c *Client) UnpinPeerKey(userID string) error {
+	c.pubKeyCacheMu.Lock()
+	defer c.pubKeyCacheMu.Unlock()
+	stored, found, err := c.peerKeys.PeerKey(userID)
+	if err != nil || !found || !stored.Pinned {
+		return err
+	}
+	stored.Pinned = false
+	stored.FetchedAt = time.Time{}
+	if err := c.peerKeys.SavePeerKey(stored); err != nil {
+		return fmt.Errorf("failed to unpin the key of %s: %w", userID, err)
+	}
+	delete(c.keyHistories, userID)
+	delete(c.pubKeyCache, userID)
+	delete(c.pubKeyFetchedAt, userID)
+	return nil
+}
+
+// cachedKeys returns the keys of a user cached in memory, or else stored in the peer key
+// store, if they are pinned or were fetched less than the TTL ago.
+func (c *Client) cachedKeys(userID string) ([]ed25519.PublicKey, bool) {
+	c.pubKeyCacheMu.RLock()
+	history, found := c.keyHistories[userID]
+	ttl := c.keyCacheTTL
+	c.pubKeyCacheMu.RUnlock()
+	if found && (history.pinned || time.Since(history.fetchedAt) < ttl) {
+		return history.keys, true
+	}
+
+	stored, found, err := c.peerKeys.PeerKey(userID)
+	if err != nil {
+		log.Printf("Failed to load the key of %s: %v", userID, err)
+		return nil, false
+	}
+	if !found || (!stored.Pinned && time.Since(stored.FetchedAt) >= ttl) {
+		return nil, false
+	}
+	keys := []ed25519.PublicKey{stored.PublicKey}
+	c.pubKeyCacheMu.Lock()
+	c.cacheKeysLocked(userID, keys, stored.FetchedAt, stored.Pinned)
+	c.pubKeyCacheMu.Unlock()
+	return keys, true
+}
+
+// trustKeys checks the keys served by the server for a peer, the current one first, against
+// the key trusted for it. The first key seen is trusted. A new key is trusted too, but reported
+// on KeyChanges, with a warning unless the trusted key signed its rotation to it. A pinned key
+// stays the only one accepted.
+func (c *Client) trustKeys(userID string, keys []ed25519.PublicKey) []ed25519.PublicKey {
+	if userID == c.UserID {
+		return keys
+	}
+	c.trustMu.Lock()
+	defer c.trustMu.Unlock()
+	now := time.Now()
+	stored, found, err := c.peerKeys.PeerKey(userID)
+	if err != nil {
+		log.Printf("Failed to load the trusted key of %s: %v", userID, err)
+		return keys
+	}
+
+	trusted := PeerKey{UserID: userID, PublicKey: keys[0], FirstSeen: now, FetchedAt: now}
+	switch {
+	case !found:
+	case stored.PublicKey.Equal(keys[0]):
+		trusted.Pinned, trusted.FirstSeen = stored.Pinned, stored.FirstSeen
+	case stored.Pinned:
+		log.Printf("WARNING: Server served a new key for %s, whose key is pinned; refusing it", userID)
+		c.keyChanged(KeyChange{UserID: userID, Previous: stored.PublicKey, Current: keys[0], Pinned: true, At: now})
+		return []ed25519.PublicKey{stored.PublicKey}
+	default:
+		rotated := slices.ContainsFunc(keys[1:], func(key ed25519.PublicKey) bool { return key.Equal(stored.PublicKey) })
+		if rotated {
+			log.Printf("Key of %s was rotated", userID)
+		} else {
+			log.Printf("WARNING: Key of %s changed without a rotation signed by the previous key", userID)
+		}
+		c.keyChanged(KeyChange{UserID: userID, Previous: stored.PublicKey, Current: keys[0], Rotated: rotated, At: now})
+	}
+	if err := c.peerKeys.SavePeerKey(trusted); err != nil {
+		log.Printf("Failed to save the trusted key of %s: %v", userID, err)
+	}
+	return keys
+}
+
+// keyChanged reports a change of the key of a peer on KeyChanges.
+func (c *Client) keyChanged(change KeyChange) {
+	select {
+	case c.keyChanges <- change:
+	default:
+		log.Printf("Key change channel is full; dropping the change of %s", change.UserID)
+	}
+}
+
+// cacheKeysLocked caches the keys of a user in memory. The caller holds pubKeyCacheMu.
+func (c *Client) cacheKeysLocked(userID string, keys []ed25519.PublicKey, fetchedAt time.Time, pinned bool) {
+	c.keyHistories[userID] = keyHistory{keys: keys, fetchedAt: fetchedAt, pinned: pinned}
+	c.cacheKeyLocked(userID, keys[0], fetchedAt)
+}
+
+// cacheKeyLocked caches the current key of a user in memory, evicting others beyond
+// maxCachedKeys. The caller holds pubKeyCacheMu.
+func (c *Client) cacheKeyLocked(userID string, key ed25519.PublicKey, fetchedAt time.Time) {
+	c.pubKeyCache[userID] = key
+	c.pubKeyFetchedAt[userID] = fetchedAt
+	if len(c.pubKeyCache) <= maxCachedKeys {
+		return
+	}
+	for id, fetchedAt := range c.pubKeyFetchedAt {
+		if id != userID && !c.keyHistories[id].pinned && time.Since(fetchedAt) >= c.keyCacheTTL {
+			c.evictLocked(id)
+		}
+	}
+	for id := range c.pubKeyFetchedAt {
+		if len(c.pubKeyCache) <= maxCachedKeys {
+			break
+		}
+		if id != userID && !c.keyHistories[id].pinned {
+			c.evictLocked(id)
+		}
+	}
+}
+
+// evictLocked drops the cached keys of a user. The caller holds pubKeyCacheMu.
+func (c *Client) evictLocked(userID string) {
+	delete(c.keyHistories, userID)
+	delete(c.pubKeyCache, userID)
+	delete(c.pubKeyFetchedAt, userID)
+}
+
+// memoryPeerKeys keeps trusted peer keys in memory.
+type memoryPeerKeys struct {
+	mu   sync.Mutex
+	keys map[string]PeerKey
+}
+
+func newMemoryPeerKeys() *memoryPeerKeys {
+	return &memoryPeerKeys{keys: make(map[string]PeerKey)}
+}
+
+func (m *memoryPeerKeys) SavePeerKey(key PeerKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys[key.UserID] = key
+	return nil
+}
+
+func (m *memoryPeerKeys) PeerKey(userID string) (PeerKey, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key, ok := m.keys[userID]
+	return key, ok, nil
+}
@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// nextKeyChange returns the next key change reported by a client, or false if there is none.
+func nextKeyChange(c *Client) (KeyChange, bool) {
+	select {
+	case change := <-c.KeyChanges():
+		return change, true
+	default:
+		return KeyChange{}, false
+	}
+}
+
+func TestPeerKeyTrust(t *testing.T) {
+	firstPub, firstPriv, _ := ed25519.GenerateKey(rand.Reader)
+	server := &keyServer{users: map[string]*UserKeys{
+		"alice": {UserID: "alice", Current: base64.StdEncoding.EncodeToString(firstPub)},
+	}}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+	alice := NewClient(srv.URL, "alice", firstPriv, firstPub)
+	alice.jwtToken = "alice"
+	bobPub, bobPriv, _ := ed25519.GenerateKey(rand.Reader)
+	bob := NewClient(srv.URL, "bob", bobPriv, bobPub)
+	bob.jwtToken = "bob"
+	store := newMemoryPeerKeys()
+	bob.SetPeerKeyStore(store)
+	bob.SetKeyCacheTTL(0)
+
+	// The first key seen is trusted
+	if keys, err := bob.GetUserPublicKeys("alice"); err != nil || !keys[0].Equal(firstPub) {
+		t.Fatalf("Expected the key of alice, got %v", err)
+	}
+	if _, ok := nextKeyChange(bob); ok {
+		t.Error("Expected no key change for the first key seen")
+	}
+
+	// A rotation signed by the trusted key is reported as such
+	rotatedPub, rotatedPriv, _ := ed25519.GenerateKey(rand.Reader)
+	if err := alice.RotateKey(rotatedPriv, false); err != nil {
+		t.Fatalf("RotateKey failed: %v", err)
+	}
+	bob.GetUserPublicKeys("alice")
+	if change, ok := nextKeyChange(bob); !ok || !change.Rotated || !change.Current.Equal(rotatedPub) {
+		t.Errorf("Expected a signed rotation, got %+v", change)
+	}
+
+	// A key the server swaps in without a rotation is reported, and trusted from then on
+	swappedPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	server.mu.Lock()
+	server.users["alice"] = &UserKeys{UserID: "alice", Current: base64.StdEncoding.EncodeToString(swappedPub)}
+	server.mu.Unlock()
+	if keys, _ := bob.GetUserPublicKeys("alice"); !keys[0].Equal(swappedPub) {
+		t.Error("Expected the swapped key to be used")
+	}
+	if change, ok := nextKeyChange(bob); !ok || change.Rotated || change.Pinned || !change.Previous.Equal(rotatedPub) {
+		t.Errorf("Expected an unsigned key change, got %+v", change)
+	}
+
+	// A pinned key is the only one accepted, whatever the server serves
+	if err := bob.PinPeerKey("alice", rotatedPub); err != nil {
+		t.Fatalf("PinPeerKey failed: %v", err)
+	}
+	if valid, _ := bob.verifySender(signedBy("alice", rotatedPriv)); !valid {
+		t.Error("Expected a message signed by the pinned key to verify")
+	}
+	if key, err := bob.GetUserPublicKey("alice"); err != nil || !key.Equal(rotatedPub) {
+		t.Errorf("Expected the pinned key, got %v", err)
+	}
+	if _, ok := nextKeyChange(bob); ok {
+		t.Error("Expected the pinned key to be used without fetching the keys")
+	}
+	bob.SetPeerKeyStore(store)
+	if keys, _ := bob.fetchUserPublicKeys("alice"); len(keys) != 1 || !keys[0].Equal(rotatedPub) {
+		t.Error("Expected the pinned key to be kept after a restart")
+	}
+	if change, ok := nextKeyChange(bob); !ok || !change.Pinned || !change.Current.Equal(swappedPub) {
+		t.Errorf("Expected the served key to be refused, got %+v", change)
+	}
+
+	if err := bob.UnpinPeerKey("alice"); err != nil {
+		t.Fatalf("UnpinPeerKey failed: %v", err)
+	}
+	if keys, _ := bob.GetUserPublicKeys("alice"); !keys[0].Equal(swappedPub) {
+		t.Error("Expected the served key to be used once unpinned")
+	}
+}
+
+func TestPeerKeysPersisted(t *testing.T) {
+	alicePub, _, _ := ed25519.GenerateKey(rand.Reader)
+	server := &keyServer{users: map[string]*UserKeys{
+		"alice": {UserID: "alice", Current: base64.StdEncoding.EncodeToString(alicePub)},
+	}}
+	srv := httptest.NewServer(server)
+	store := newMemoryPeerKeys()
+	bobPub, bobPriv, _ := ed25519.GenerateKey(rand.Reader)
+	bob := NewClient(srv.URL, "bob", bobPriv, bobPub)
+	bob.jwtToken = "bob"
+	bob.SetPeerKeyStore(store)
+	if _, err := bob.GetUserPublicKeys("alice"); err != nil {
+		t.Fatalf("GetUserPublicKeys failed: %v", err)
+	}
+	srv.Close()
+
+	// After a restart, a key fetched within the TTL is used without the server
+	restarted := NewClient(srv.URL, "bob", bobPriv, bobPub)
+	restarted.jwtToken = "bob"
+	restarted.SetPeerKeyStore(store)
+	if key, err := restarted.GetUserPublicKey("alice"); err != nil || !key.Equal(alicePub) {
+		t.Fatalf("Expected the stored key, got %v", err)
+	}
+	restarted.SetKeyCacheTTL(0)
+	if _, err := restarted.GetUserPublicKeys("alice"); err == nil {
+		t.Error("Expected an expired key to be fetched again")
+	}
+}
+
+func TestKeyCacheBounded(t *testing.T) {
+	defer func(limit int) { maxCachedKeys = limit }(maxCachedKeys)
+	maxCachedKeys = 3
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient("", "bob", priv, pub)
+	c.pubKeyCacheMu.Lock()
+	c.cacheKeysLocked("alice", []ed25519.PublicKey{pub}, time.Now(), true)
+	c.cacheKeyLocked("carol", pub, time.Now().Add(-time.Hour))
+	c.cacheKeyLocked("dave", pub, time.Now())
+	c.cacheKeyLocked("erin", pub, time.Now())
+	c.pubKeyCacheMu.Unlock()
+
+	if len(c.pubKeyCache) != 3 {
+		t.Fatalf("Expected 3 cached keys, got %d", len(c.pubKeyCache))
+	}
+	for _, kept := range []string{"bob", "alice", "erin"} {
+		if _, ok := c.pubKeyCache[kept]; !ok {
+			t.Errorf("Expected the key of %s to be kept", kept)
+		}
+	}
+}
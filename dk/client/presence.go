@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Types of the presence messages exchanged with the server.
+const (
+	presenceMessageType   = "presence"           // A watched user went online or offline
+	presenceSubscribeType = "presence_subscribe" // The users this client watches, sent to the server
+)
+
+// systemAddress is the sender of the messages of the server and the recipient of the control
+// messages sent to it.
+const systemAddress = "system"
+
+// PresenceEvent reports that a watched user went online or offline.
+type PresenceEvent struct {
+	UserID string    `json:"user_id"`
+	Online bool      `json:"online"`
+	At     time.Time `json:"at"`
+}
+
+// presenceSubscription is the control message choosing the users the server reports on.
+type presenceSubscription struct {
+	Type  string   `json:"type"`
+	Users []string `json:"users"`
+}
+
+// presenceEvent is the content of a presence message from the server.
+type presenceEvent struct {
+	Type   string `json:"type"`
+	UserID string `json:"user_id"`
+	Online bool   `json:"online"`
+}
+
+// WatchPresence subscribes to the online and offline transitions of users, reported on
+// PresenceEvents. The current state of each user is reported first. The subscription is
+// renewed after every reconnect, with the current state again.
+func (c *Client) WatchPresence(userIDs ...string) error {
+	c.presenceMu.Lock()
+	for _, userID := range userIDs {
+		c.presenceWatch[userID] = true
+	}
+	c.presenceMu.Unlock()
+	return c.sendPresenceSubscription()
+}
+
+// UnwatchPresence ends the subscription to the transitions of users.
+func (c *Client) UnwatchPresence(userIDs ...string) error {
+	c.presenceMu.Lock()
+	for _, userID := range userIDs {
+		delete(c.presenceWatch, userID)
+	}
+	c.presenceMu.Unlock()
+	return c.sendPresenceSubscription()
+}
+
+// PresenceEvents returns the channel of the online and offline transitions of the users
+// watched with WatchPresence. Events arriving while the channel is full are dropped.
+func (c *Client) PresenceEvents() <-chan PresenceEvent {
+	return c.presenceCh
+}
+
+// presenceSubscription returns the control message subscribing to the watched users.
+func (c *Client) presenceSubscription() Message {
+	c.presenceMu.Lock()
+	users := make([]string, 0, len(c.presenceWatch))
+	for userID := range c.presenceWatch {
+		users = append(users, userID)
+	}
+	c.presenceMu.Unlock()
+	sort.Strings(users)
+
+	content, _ := json.Marshal(presenceSubscription{Type: presenceSubscribeType, Users: users})
+	return Message{From: c.UserID, To: systemAddress, Timestamp: c.Now(), Content: string(content)}
+}
+
+// watchingPresence reports whether the client watches any user.
+func (c *Client) watchingPresence() bool {
+	c.presenceMu.Lock()
+	defer c.presenceMu.Unlock()
+	return len(c.presenceWatch) > 0
+}
+
+// sendPresenceSubscription queues the subscription to the watched users. It is not kept in the
+// outbox: the writer sends it again on every new connection.
+func (c *Client) sendPresenceSubscription() error {
+	c.enqueueMu.Lock()
+	defer c.enqueueMu.Unlock()
+	select {
+	case c.queue() <- c.presenceSubscription():
+		return nil
+	case <-time.After(sendTimeout):
+		return errors.New("send presence subscription timeout")
+	}
+}
+
+// handlePresence reports a presence message from the server. It reports false for other
+// messages.
+func (c *Client) handlePresence(msg Message) bool {
+	var event presenceEvent
+	if json.Unmarshal([]byte(msg.Content), &event) != nil || event.Type != presenceMessageType {
+		return false
+	}
+	status := PresenceEvent{UserID: event.UserID, Online: event.Online, At: msg.Timestamp}
+	if status.At.IsZero() {
+		status.At = c.Now()
+	}
+	select {
+	case c.presenceCh <- status:
+	default:
+		log.Printf("Presence channel is full, dropping the presence of %s", event.UserID)
+	}
+	return true
+}
+
+// writePresenceSubscription renews the subscription to the watched users on a new connection.
+func (c *Client) writePresenceSubscription(conn *websocket.Conn) error {
+	if !c.watchingPresence() {
+		return nil
+	}
+	msgBytes, err := json.Marshal(c.presenceSubscription())
+	if err != nil {
+		return err
+	}
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return conn.WriteMessage(websocket.TextMessage, msgBytes)
+}
@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// presenceServer answers presence subscriptions with the state of each watched user, bob being
+// the only one online, and drops the first connection after answering.
+type presenceServer struct {
+	subscriptions chan []string
+	connections   atomic.Int32
+}
+
+func (s *presenceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/ws" {
+		http.NotFound(w, r)
+		return
+	}
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	first := s.connections.Add(1) == 1
+	for {
+		var msg Message
+		if err := conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		var subscription presenceSubscription
+		if msg.To != systemAddress || json.Unmarshal([]byte(msg.Content), &subscription) != nil || subscription.Type != presenceSubscribeType {
+			continue
+		}
+		s.subscriptions <- subscription.Users
+		for _, userID := range subscription.Users {
+			content, _ := json.Marshal(presenceEvent{Type: presenceMessageType, UserID: userID, Online: userID == "bob"})
+			conn.WriteJSON(Message{From: systemAddress, To: "alice", Timestamp: time.Now(), Content: string(content)})
+		}
+		if first {
+			return
+		}
+	}
+}
+
+// nextPresenceEvent returns the next presence event of a client.
+func nextPresenceEvent(t *testing.T, c *Client) PresenceEvent {
+	t.Helper()
+	select {
+	case event := <-c.PresenceEvents():
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("No presence event was reported")
+		return PresenceEvent{}
+	}
+}
+
+func TestWatchPresence(t *testing.T) {
+	server := &presenceServer{subscriptions: make(chan []string, 10)}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	c.SetReconnectInterval(10 * time.Millisecond)
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Disconnect()
+
+	if err := c.WatchPresence("carol", "bob"); err != nil {
+		t.Fatalf("WatchPresence failed: %v", err)
+	}
+	if users := <-server.subscriptions; !slices.Equal(users, []string{"bob", "carol"}) {
+		t.Errorf("Expected a subscription to bob and carol, got %v", users)
+	}
+	for range 2 {
+		if event := nextPresenceEvent(t, c); event.Online != (event.UserID == "bob") || event.At.IsZero() {
+			t.Errorf("Expected only bob online, got %+v", event)
+		}
+	}
+
+	// The subscription is renewed on the new connection, which reports the state again
+	select {
+	case users := <-server.subscriptions:
+		if !slices.Equal(users, []string{"bob", "carol"}) {
+			t.Errorf("Expected the subscription to be renewed, got %v", users)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("The subscription was not renewed after reconnecting")
+	}
+	nextPresenceEvent(t, c)
+	nextPresenceEvent(t, c)
+
+	if err := c.UnwatchPresence("carol"); err != nil {
+		t.Fatalf("UnwatchPresence failed: %v", err)
+	}
+	if users := <-server.subscriptions; !slices.Equal(users, []string{"bob"}) {
+		t.Errorf("Expected the subscription to be reduced to bob, got %v", users)
+	}
+	if event := nextPresenceEvent(t, c); event.UserID != "bob" {
+		t.Errorf("Expected the state of bob, got %+v", event)
+	}
+
+	// Presence messages are not delivered as messages
+	select {
+	case msg := <-c.Messages():
+		t.Errorf("Expected no delivered message, got %+v", msg)
+	default:
+	}
+}
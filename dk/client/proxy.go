@@ -0,0 +1,44 @@
+package lib
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// SetProxy routes the HTTP requests and the websocket connection of the client through a proxy:
+// an HTTP proxy (http://host:port, tunnelling with CONNECT) or a SOCKS5 proxy
+// (socks5://host:port), with credentials in the URL if it needs them. Without one, the proxy
+// of the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables is used; an empty URL
+// returns to it. The setting applies from the next request and connection.
+func (c *Client) SetProxy(proxyURL string) error {
+	var proxy *url.URL
+	if proxyURL != "" {
+		parsed, err := url.Parse(proxyURL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "socks5" {
+			return fmt.Errorf("unsupported proxy scheme %q: use http or socks5", parsed.Scheme)
+		}
+		if parsed.Host == "" {
+			return fmt.Errorf("invalid proxy URL %q: no host", proxyURL)
+		}
+		proxy = parsed
+	}
+	c.tlsMu.Lock()
+	defer c.tlsMu.Unlock()
+	c.proxy = proxy
+	c.httpc = nil
+	return nil
+}
+
+// proxyFunc returns how the websocket dialer chooses its proxy.
+func (c *Client) proxyFunc() func(*http.Request) (*url.URL, error) {
+	c.tlsMu.Lock()
+	defer c.tlsMu.Unlock()
+	if c.proxy != nil {
+		return http.ProxyURL(c.proxy)
+	}
+	return http.ProxyFromEnvironment
+}
@@ -0,0 +1,189 @@
+package lib
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// httpProxy forwards plain requests and tunnels CONNECT requests, counting both.
+type httpProxy struct {
+	requests atomic.Int32
+}
+
+func (p *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.requests.Add(1)
+	if r.Method != http.MethodConnect {
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+	upstream, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	pipe(conn, upstream)
+}
+
+// socks5Proxy serves the CONNECT command of SOCKS5 without authentication, counting the
+// connections.
+func socks5Proxy(t *testing.T) (string, *atomic.Int32) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	var connections atomic.Int32
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			go func() {
+				buf := make([]byte, 262)
+				// Greeting: version, methods; no authentication
+				if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+					conn.Close()
+					return
+				}
+				io.ReadFull(conn, buf[:buf[1]])
+				conn.Write([]byte{5, 0})
+				// Request: version, CONNECT, reserved, address type, address, port
+				if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+					conn.Close()
+					return
+				}
+				var host string
+				switch buf[3] {
+				case 1:
+					io.ReadFull(conn, buf[:4])
+					host = net.IP(buf[:4]).String()
+				case 3:
+					io.ReadFull(conn, buf[:1])
+					n := int(buf[0])
+					io.ReadFull(conn, buf[:n])
+					host = string(buf[:n])
+				}
+				io.ReadFull(conn, buf[:2])
+				port := binary.BigEndian.Uint16(buf[:2])
+				upstream, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+				if err != nil {
+					conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+					conn.Close()
+					return
+				}
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				pipe(conn, upstream)
+			}()
+		}
+	}()
+	return "socks5://" + listener.Addr().String(), &connections
+}
+
+// pipe copies between two connections until one of them closes.
+func pipe(a, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	io.Copy(b, a)
+	b.Close()
+}
+
+// proxiedServer returns a server that answers health checks and relays websocket messages.
+func proxiedServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	relay := &relayServer{conns: make(map[string]*websocket.Conn)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	mux.Handle("/ws", relay)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPProxy(t *testing.T) {
+	srv := proxiedServer(t)
+	proxy := &httpProxy{}
+	proxySrv := httptest.NewServer(proxy)
+	defer proxySrv.Close()
+
+	c := newTokenClient(t, srv.URL)
+	if err := c.SetProxy(proxySrv.URL); err != nil {
+		t.Fatalf("SetProxy failed: %v", err)
+	}
+	if err := reachable(c, srv.URL); err != nil {
+		t.Fatalf("Request through the proxy failed: %v", err)
+	}
+	if proxy.requests.Load() != 1 {
+		t.Errorf("Expected the request to go through the proxy, got %d proxied requests", proxy.requests.Load())
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Websocket connection through the proxy failed: %v", err)
+	}
+	c.Disconnect()
+	if proxy.requests.Load() != 2 {
+		t.Errorf("Expected the websocket connection to be tunnelled, got %d proxied requests", proxy.requests.Load())
+	}
+
+	// Without the option, the environment decides, which does not proxy local servers
+	if err := c.SetProxy(""); err != nil {
+		t.Fatal(err)
+	}
+	reachable(c, srv.URL)
+	if proxy.requests.Load() != 2 {
+		t.Error("Expected the proxy not to be used once removed")
+	}
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	srv := proxiedServer(t)
+	proxyURL, connections := socks5Proxy(t)
+
+	c := newTokenClient(t, srv.URL)
+	if err := c.SetProxy(proxyURL); err != nil {
+		t.Fatalf("SetProxy failed: %v", err)
+	}
+	if err := reachable(c, srv.URL); err != nil {
+		t.Fatalf("Request through the proxy failed: %v", err)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Websocket connection through the proxy failed: %v", err)
+	}
+	c.Disconnect()
+	if connections.Load() != 2 {
+		t.Errorf("Expected both connections through the proxy, got %d", connections.Load())
+	}
+}
+
+func TestSetProxyValidation(t *testing.T) {
+	c := newTokenClient(t, "http://localhost")
+	for _, proxyURL := range []string{"ftp://proxy:21", "https://proxy:443", "http://", "://proxy"} {
+		if err := c.SetProxy(proxyURL); err == nil {
+			t.Errorf("Expected %q to be refused", proxyURL)
+		}
+	}
+}
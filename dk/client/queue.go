@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// DefaultSendQueueSize is the number of messages the send queue holds by default.
+const DefaultSendQueueSize = 100
+
+// sendTimeout is how long Send waits for room in a full send queue with OverflowBlock.
+const sendTimeout = 10 * time.Second
+
+// OverflowPolicy decides what Send does with a message when the send queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room in the queue, and fails after 10 seconds.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest refuses the new message at once with ErrSendQueueFull.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest queued message to make room for the new one.
+	OverflowDropOldest
+)
+
+// ErrSendQueueFull is returned by Send when the send queue is full and the new message is
+// dropped.
+var ErrSendQueueFull = errors.New("send queue is full")
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop_newest"
+	case OverflowDropOldest:
+		return "drop_oldest"
+	}
+	return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+}
+
+// ParseOverflowPolicy returns the policy named by OverflowPolicy.String.
+func ParseOverflowPolicy(name string) (OverflowPolicy, error) {
+	for _, p := range []OverflowPolicy{OverflowBlock, OverflowDropNewest, OverflowDropOldest} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return OverflowBlock, fmt.Errorf("unknown overflow policy %q", name)
+}
+
+// SetSendQueue sets the number of messages the send queue holds and what Send does when it is
+// full. Messages already queued are kept. The size can only change before Connect; the policy
+// applies at once. With an outbox store, messages are stored rather than queued, so the queue
+// only fills while the writer catches up.
+func (c *Client) SetSendQueue(size int, policy OverflowPolicy) error {
+	if size <= 0 {
+		return fmt.Errorf("invalid send queue size %d", size)
+	}
+	if policy < OverflowBlock || policy > OverflowDropOldest {
+		return fmt.Errorf("invalid overflow policy %v", policy)
+	}
+	// Queued messages are moved while no message is being added
+	c.enqueueMu.Lock()
+	defer c.enqueueMu.Unlock()
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	if size != cap(c.sendCh) {
+		if c.wsConn != nil || c.reconnecting {
+			return errors.New("the send queue size can only change before connecting")
+		}
+		if len(c.sendCh) > size {
+			return fmt.Errorf("%d messages are already queued", len(c.sendCh))
+		}
+		queue := make(chan Message, size)
+		for len(c.sendCh) > 0 {
+			queue <- <-c.sendCh
+		}
+		c.sendCh = queue
+	}
+	c.overflow = policy
+	return nil
+}
+
+// QueueDepth returns the number of messages waiting in the send queue, so applications can
+// slow down before it fills.
+func (c *Client) QueueDepth() int {
+	return len(c.queue())
+}
+
+// queue returns the send queue, which SetSendQueue may replace.
+func (c *Client) queue() chan Message {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.sendCh
+}
+
+// queuePollInterval is how often enqueue checks for room with OverflowBlock.
+const queuePollInterval = 10 * time.Millisecond
+
+// enqueue adds the parts of a message to the send queue, following the overflow policy when it
+// has no room for all of them. The parts are queued together or not at all, so that a full
+// queue never sends only part of a fragmented message.
+func (c *Client) enqueue(parts ...Message) error {
+	c.enqueueMu.Lock()
+	defer c.enqueueMu.Unlock()
+	sendCh := c.queue()
+	// The last part carries the ID of the whole message
+	msg := parts[len(parts)-1]
+	if len(parts) > cap(sendCh) {
+		c.dropped(msg)
+		return ErrSendQueueFull
+	}
+
+	c.connMu.RLock()
+	policy := c.overflow
+	c.connMu.RUnlock()
+	deadline := time.Now().Add(sendTimeout)
+	for cap(sendCh)-len(sendCh) < len(parts) {
+		switch policy {
+		case OverflowDropNewest:
+			c.dropped(msg)
+			return ErrSendQueueFull
+		case OverflowDropOldest:
+			select {
+			case oldest := <-sendCh:
+				c.dropped(oldest)
+			default:
+			}
+		default:
+			if time.Now().After(deadline) {
+				return errors.New("send message timeout")
+			}
+			time.Sleep(queuePollInterval)
+		}
+	}
+	// Only the writer takes from the queue meanwhile, so every part finds room
+	for _, part := range parts {
+		sendCh <- part
+	}
+	c.queueDepth()
+	return nil
+}
+
+// dropped reports a message dropped because the send queue was full.
+func (c *Client) dropped(msg Message) {
+	log.Printf("Send queue is full, dropping message %s to %s", msg.MessageID, msg.To)
+	c.count(MetricMessagesDropped, 1)
+	c.undelivered(msg, ErrSendQueueFull)
+}
@@ -0,0 +1,116 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSendQueueOverflow(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient("", "alice", priv, pub)
+	metrics := NewExpvarMetrics()
+	c.SetMetrics(metrics)
+	if _, err := c.Send(Message{To: "bob", Content: "kept"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	if err := c.SetSendQueue(0, OverflowBlock); err == nil {
+		t.Error("Expected an empty queue to be refused")
+	}
+	if err := c.SetSendQueue(2, OverflowDropNewest); err != nil {
+		t.Fatalf("SetSendQueue failed: %v", err)
+	}
+	if c.QueueDepth() != 1 {
+		t.Fatalf("Expected the queued message to be kept, got %d", c.QueueDepth())
+	}
+	if _, err := c.Send(Message{To: "bob", Content: "second"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if _, err := c.Send(Message{To: "bob", Content: "refused"}); !errors.Is(err, ErrSendQueueFull) {
+		t.Fatalf("Expected ErrSendQueueFull, got %v", err)
+	}
+	if err := c.SetSendQueue(1, OverflowDropOldest); err == nil {
+		t.Error("Expected a queue smaller than the queued messages to be refused")
+	}
+
+	if err := c.SetSendQueue(2, OverflowDropOldest); err != nil {
+		t.Fatalf("SetSendQueue failed: %v", err)
+	}
+	if _, err := c.Send(Message{To: "bob", Content: "third"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if c.QueueDepth() != 2 || c.Status().QueuedMessages != 2 {
+		t.Errorf("Expected 2 queued messages, got %d", c.QueueDepth())
+	}
+	for _, want := range []string{"second", "third"} {
+		if msg := <-c.sendCh; msg.Content != want {
+			t.Errorf("Expected %q to be queued, got %q", want, msg.Content)
+		}
+	}
+	if dropped := metrics.Get(MetricMessagesDropped).String(); dropped != "2" {
+		t.Errorf("Expected 2 dropped messages, got %s", dropped)
+	}
+}
+
+func TestSendQueueFragments(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient("", "alice", priv, pub)
+	c.SetMaxMessageSize(messageOverhead + 1000)
+	large := Message{To: "broadcast", Content: strings.Repeat("ünïcödé ", 1000)}
+	parts, err := c.fragment(large)
+	if err != nil || len(parts) < 3 {
+		t.Fatalf("Expected at least 3 parts, got %d: %v", len(parts), err)
+	}
+
+	// A message with more parts than the queue holds is dropped whole
+	if err := c.SetSendQueue(len(parts)-1, OverflowDropOldest); err != nil {
+		t.Fatalf("SetSendQueue failed: %v", err)
+	}
+	if _, err := c.Send(large); !errors.Is(err, ErrSendQueueFull) || c.QueueDepth() != 0 {
+		t.Fatalf("Expected ErrSendQueueFull and an empty queue, got %v and %d", err, c.QueueDepth())
+	}
+
+	// Without room for every part, no part is queued
+	if err := c.SetSendQueue(len(parts)+1, OverflowDropNewest); err != nil {
+		t.Fatalf("SetSendQueue failed: %v", err)
+	}
+	for _, content := range []string{"first", "second"} {
+		if _, err := c.Send(Message{To: "broadcast", Content: content}); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+	}
+	if _, err := c.Send(large); !errors.Is(err, ErrSendQueueFull) || c.QueueDepth() != 2 {
+		t.Fatalf("Expected ErrSendQueueFull and 2 queued messages, got %v and %d", err, c.QueueDepth())
+	}
+
+	// Dropping the oldest messages makes room for every part
+	if err := c.SetSendQueue(len(parts)+1, OverflowDropOldest); err != nil {
+		t.Fatalf("SetSendQueue failed: %v", err)
+	}
+	id, err := c.Send(large)
+	if err != nil || c.QueueDepth() != len(parts)+1 {
+		t.Fatalf("Expected every part to be queued, got %d (%v)", c.QueueDepth(), err)
+	}
+	if msg := <-c.sendCh; msg.Content != "second" {
+		t.Errorf("Expected the oldest message to be dropped, got %q", msg.Content)
+	}
+	for i := range parts {
+		if msg := <-c.sendCh; (i == len(parts)-1) != (msg.MessageID == id) {
+			t.Errorf("Expected part %d to be queued in order, got %s", i, msg.MessageID)
+		}
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for _, p := range []OverflowPolicy{OverflowBlock, OverflowDropNewest, OverflowDropOldest} {
+		if parsed, err := ParseOverflowPolicy(p.String()); err != nil || parsed != p {
+			t.Errorf("Expected %v, got %v (%v)", p, parsed, err)
+		}
+	}
+	if _, err := ParseOverflowPolicy("drop_all"); err == nil {
+		t.Error("Expected an unknown policy to be refused")
+	}
+}
@@ -0,0 +1,570 @@
+package lib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// RatchetScheme identifies direct messages encrypted in a ratcheting session: each message has
+// its own key, derived from a chain that moves forward with every message and is reseeded with
+// a fresh X25519 exchange each time the conversation changes direction. Keys are deleted once
+// used, so a leaked identity key does not decrypt the messages of a session after the first
+// reply. The messages the initiator sends before that are not protected: their chain mixes in
+// the responder's identity key, so that key decrypts them, past ones included.
+//
+// Peers announce that they support it in the envelopes of DefaultEncryptionScheme, which older
+// clients ignore; messages to peers that never did use DefaultEncryptionScheme.
+const RatchetScheme = "x25519-ratchet-aes256gcm"
+
+const (
+	// maxRatchetSkip is how many message keys a session derives ahead to read messages
+	// delivered out of order, and how many of those it keeps.
+	maxRatchetSkip = 1000
+	// maxRatchetSessions is how many sessions are kept per peer, the latest used first.
+	maxRatchetSessions = 4
+)
+
+// RatchetStore keeps the ratcheting sessions of the client, by peer. The state holds the keys
+// of the sessions, so it is as sensitive as the identity key. Without a store, sessions are
+// kept in memory and peers start new ones after a restart.
+type RatchetStore interface {
+	// SaveRatchetState stores the sessions with a peer, replacing the stored ones.
+	SaveRatchetState(peer string, state []byte) error
+	// RatchetState returns the sessions with a peer.
+	RatchetState(peer string) ([]byte, bool, error)
+}
+
+// SetRatchetStore replaces the store of ratcheting sessions.
+func (c *Client) SetRatchetStore(store RatchetStore) {
+	c.ratchetMu.Lock()
+	defer c.ratchetMu.Unlock()
+	c.ratchets = store
+}
+
+// ratchetPeer is the state kept for a peer: whether it supports RatchetScheme, and the sessions
+// with it.
+type ratchetPeer struct {
+	Capable  bool              `json:"capable"`
+	Active   string            `json:"active,omitempty"` // Session messages are sent in
+	Sessions []*ratchetSession `json:"sessions"`
+}
+
+// ratchetSession is the state of a double ratchet session.
+type ratchetSession struct {
+	ID        string       `json:"id"`
+	RootKey   []byte       `json:"root_key"`
+	SendChain []byte       `json:"send_chain,omitempty"`
+	RecvChain []byte       `json:"recv_chain,omitempty"`
+	SendPriv  []byte       `json:"send_priv"`
+	SendPub   []byte       `json:"send_pub"`
+	RemotePub []byte       `json:"remote_pub"`
+	Sent      int          `json:"sent"`
+	Received  int          `json:"received"`
+	PrevSent  int          `json:"prev_sent"`
+	Skipped   []skippedKey `json:"skipped,omitempty"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// skippedKey is the key of a message not received yet, derived to read a later one.
+type skippedKey struct {
+	DH  []byte `json:"dh"`
+	N   int    `json:"n"`
+	Key []byte `json:"key"`
+}
+
+// ratchetPayload is the payload of RatchetScheme envelopes.
+type ratchetPayload struct {
+	Session    string `json:"session"`
+	DH         string `json:"dh"` // Base64 ratchet key of the sender
+	PrevSent   int    `json:"pn"` // Messages sent in the previous sending chain
+	N          int    `json:"n"`  // Number of the message in the sending chain
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// sealDirect encrypts a direct message body for a peer: in a ratcheting session when the peer
+// supports it, with the configured encryptor otherwise.
+func (c *Client) sealDirect(to, plaintext string, recipientPub ed25519.PublicKey) (string, error) {
+	c.encryptorsMu.RLock()
+	scheme := c.encryptor.Scheme()
+	c.encryptorsMu.RUnlock()
+	// Custom encryptors may keep their keys outside the process, sessions need the identity key
+	if scheme != DefaultEncryptionScheme {
+		return c.sealContent(plaintext, recipientPub)
+	}
+
+	c.ratchetMu.Lock()
+	defer c.ratchetMu.Unlock()
+	peer, err := c.loadRatchetPeer(to)
+	if err != nil {
+		return "", err
+	}
+	if !peer.Capable {
+		content, err := c.sealContent(plaintext, recipientPub)
+		if err != nil {
+			return "", err
+		}
+		var env EncryptedMessage
+		if err := json.Unmarshal([]byte(content), &env); err != nil {
+			return "", fmt.Errorf("failed to unmarshal encrypted envelope: %v", err)
+		}
+		env.Ratchet = true
+		envBytes, err := json.Marshal(env)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal encrypted envelope: %v", err)
+		}
+		return string(envBytes), nil
+	}
+
+	session := peer.session(peer.Active)
+	if session == nil {
+		privateKey, _ := c.identity()
+		if session, err = newInitiatorSession(privateKey, recipientPub); err != nil {
+			return "", err
+		}
+		peer.Active = session.ID
+		peer.add(session)
+	}
+	payload, err := session.encrypt(plaintext, ratchetAD(c.UserID, to))
+	if err != nil {
+		return "", err
+	}
+	if err := c.saveRatchetPeer(to, peer); err != nil {
+		return "", err
+	}
+	envBytes, err := json.Marshal(EncryptedMessage{Scheme: RatchetScheme, Payload: payload})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal encrypted envelope: %v", err)
+	}
+	return string(envBytes), nil
+}
+
+// openDirect decrypts the body of a direct message to this client. Verified messages that
+// announce RatchetScheme, or use it, mark their sender as supporting it.
+func (c *Client) openDirect(msg Message) (string, error) {
+	var env EncryptedMessage
+	if err := json.Unmarshal([]byte(msg.Content), &env); err != nil {
+		return "", fmt.Errorf("failed to unmarshal encrypted envelope: %v", err)
+	}
+	verified := msg.Status == "verified"
+	if env.Scheme != RatchetScheme {
+		plaintext, err := c.openContent(msg.Content)
+		if err == nil && env.Ratchet && verified {
+			c.ratchetMu.Lock()
+			defer c.ratchetMu.Unlock()
+			if peer, err := c.loadRatchetPeer(msg.From); err == nil && !peer.Capable {
+				peer.Capable = true
+				if err := c.saveRatchetPeer(msg.From, peer); err != nil {
+					log.Printf("Failed to save the sessions with %s: %v", msg.From, err)
+				}
+			}
+		}
+		return plaintext, err
+	}
+
+	var payload ratchetPayload
+	if err := json.Unmarshal([]byte(env.Payload), &payload); err != nil {
+		return "", fmt.Errorf("failed to unmarshal ratchet payload: %v", err)
+	}
+	c.ratchetMu.Lock()
+	defer c.ratchetMu.Unlock()
+	peer, err := c.loadRatchetPeer(msg.From)
+	if err != nil {
+		return "", err
+	}
+	ad := ratchetAD(msg.From, c.UserID)
+	var plaintext string
+	session := peer.session(payload.Session)
+	if session != nil {
+		plaintext, err = session.decrypt(payload, ad)
+	} else {
+		plaintext, err = c.acceptSession(peer, msg.From, payload, ad)
+	}
+	if err != nil {
+		// A session this side lost, e.g. in a restart without a store: the next message starts
+		// a new one, which the peer moves to
+		if verified && session == nil {
+			peer.Capable = true
+			peer.Active = ""
+			if err := c.saveRatchetPeer(msg.From, peer); err != nil {
+				log.Printf("Failed to save the sessions with %s: %v", msg.From, err)
+			}
+		}
+		return "", err
+	}
+	if verified {
+		peer.Capable = true
+		peer.Active = payload.Session
+	}
+	if err := c.saveRatchetPeer(msg.From, peer); err != nil {
+		log.Printf("Failed to save the sessions with %s: %v", msg.From, err)
+	}
+	return plaintext, nil
+}
+
+// acceptSession decrypts the first message of a session a peer started, and keeps the session.
+// The session is seeded by the identity keys of both sides, the current or a previous one.
+func (c *Client) acceptSession(peer *ratchetPeer, from string, payload ratchetPayload, ad string) (string, error) {
+	senderKeys, err := c.GetUserPublicKeys(from)
+	if err != nil {
+		return "", err
+	}
+	for _, privateKey := range c.decryptionKeys() {
+		for _, senderPub := range senderKeys {
+			session, err := newResponderSession(privateKey, senderPub, payload.Session)
+			if err != nil {
+				continue
+			}
+			if plaintext, err := session.decrypt(payload, ad); err == nil {
+				peer.add(session)
+				return plaintext, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("failed to open session %s with %s", payload.Session, from)
+}
+
+// loadRatchetPeer returns the state kept for a peer. ratchetMu must be held.
+func (c *Client) loadRatchetPeer(peer string) (*ratchetPeer, error) {
+	data, found, err := c.ratchets.RatchetState(peer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the sessions with %s: %w", peer, err)
+	}
+	state := &ratchetPeer{}
+	if found {
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("failed to decode the sessions with %s: %w", peer, err)
+		}
+	}
+	return state, nil
+}
+
+// saveRatchetPeer stores the state kept for a peer. ratchetMu must be held.
+func (c *Client) saveRatchetPeer(peer string, state *ratchetPeer) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return c.ratchets.SaveRatchetState(peer, data)
+}
+
+// session returns the session with an ID, or nil.
+func (p *ratchetPeer) session(id string) *ratchetSession {
+	for _, s := range p.Sessions {
+		if s.ID == id && id != "" {
+			return s
+		}
+	}
+	return nil
+}
+
+// add keeps a new session, forgetting the least recently used ones beyond maxRatchetSessions.
+func (p *ratchetPeer) add(session *ratchetSession) {
+	p.Sessions = append(p.Sessions, session)
+	sort.Slice(p.Sessions, func(i, j int) bool { return p.Sessions[i].UpdatedAt.After(p.Sessions[j].UpdatedAt) })
+	if len(p.Sessions) > maxRatchetSessions {
+		p.Sessions = p.Sessions[:maxRatchetSessions]
+	}
+}
+
+// newInitiatorSession starts a session with a peer, whose identity key serves as its first
+// ratchet key.
+func newInitiatorSession(privateKey ed25519.PrivateKey, peerPub ed25519.PublicKey) (*ratchetSession, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	s := &ratchetSession{ID: hex.EncodeToString(id)}
+	rootKey, err := sessionSecret(privateKey, peerPub, s.ID)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := convertEd25519PublicKeyToX25519(peerPub)
+	if err != nil {
+		return nil, err
+	}
+	s.RootKey, s.RemotePub = rootKey, remote[:]
+	if err := s.newRatchetKey(); err != nil {
+		return nil, err
+	}
+	dh, err := curve25519.X25519(s.SendPriv, s.RemotePub)
+	if err != nil {
+		return nil, err
+	}
+	s.RootKey, s.SendChain = kdfRoot(s.RootKey, dh)
+	s.UpdatedAt = time.Now()
+	return s, nil
+}
+
+// newResponderSession prepares the session a peer started, whose first ratchet key is the
+// identity key of this side.
+func newResponderSession(privateKey ed25519.PrivateKey, peerPub ed25519.PublicKey, id string) (*ratchetSession, error) {
+	rootKey, err := sessionSecret(privateKey, peerPub, id)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := convertEd25519PrivateKeyToX25519(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := curve25519.X25519(priv[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &ratchetSession{ID: id, RootKey: rootKey, SendPriv: priv[:], SendPub: pub, UpdatedAt: time.Now()}, nil
+}
+
+// sessionSecret derives the first root key of a session from the identity keys of both sides,
+// which authenticates them to each other.
+func sessionSecret(privateKey ed25519.PrivateKey, peerPub ed25519.PublicKey, id string) ([]byte, error) {
+	priv, err := convertEd25519PrivateKeyToX25519(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := convertEd25519PublicKeyToX25519(peerPub)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(priv[:], pub[:])
+	if err != nil {
+		return nil, err
+	}
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, nil, []byte("dk-ratchet-session|"+id)), secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// encrypt seals a message with the next key of the sending chain.
+func (s *ratchetSession) encrypt(plaintext, ad string) (string, error) {
+	var messageKey []byte
+	s.SendChain, messageKey = kdfChain(s.SendChain)
+	payload := ratchetPayload{
+		Session:  s.ID,
+		DH:       base64.StdEncoding.EncodeToString(s.SendPub),
+		PrevSent: s.PrevSent,
+		N:        s.Sent,
+	}
+	s.Sent++
+	s.UpdatedAt = time.Now()
+
+	aesgcm, err := messageCipher(messageKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aesgcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate AES nonce: %v", err)
+	}
+	payload.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	payload.Ciphertext = base64.StdEncoding.EncodeToString(aesgcm.Seal(nil, nonce, []byte(plaintext), []byte(payload.header(ad))))
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// decrypt opens a message of the session, moving the ratchet forward. The session is left
+// unchanged when the message cannot be opened.
+func (s *ratchetSession) decrypt(payload ratchetPayload, ad string) (string, error) {
+	dh, err := base64.StdEncoding.DecodeString(payload.DH)
+	if err != nil || len(dh) != curve25519.PointSize {
+		return "", errors.New("invalid ratchet key")
+	}
+	nonce, err := base64.StdEncoding.DecodeString(payload.Nonce)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode nonce: %v", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(payload.Ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode ciphertext: %v", err)
+	}
+	open := func(messageKey []byte) (string, error) {
+		aesgcm, err := messageCipher(messageKey)
+		if err != nil {
+			return "", err
+		}
+		if len(nonce) != aesgcm.NonceSize() {
+			return "", errors.New("nonce has invalid length")
+		}
+		plaintext, err := aesgcm.Open(nil, nonce, ciphertext, []byte(payload.header(ad)))
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt content: %v", err)
+		}
+		return string(plaintext), nil
+	}
+
+	// A message delivered after a later one
+	for i, skipped := range s.Skipped {
+		if skipped.N == payload.N && hmac.Equal(skipped.DH, dh) {
+			plaintext, err := open(skipped.Key)
+			if err != nil {
+				return "", err
+			}
+			s.Skipped = append(s.Skipped[:i:i], s.Skipped[i+1:]...)
+			s.UpdatedAt = time.Now()
+			return plaintext, nil
+		}
+	}
+
+	next := s.clone()
+	if !hmac.Equal(dh, next.RemotePub) {
+		if next.RecvChain != nil {
+			if err := next.skip(payload.PrevSent); err != nil {
+				return "", err
+			}
+		}
+		if err := next.ratchet(dh); err != nil {
+			return "", err
+		}
+	}
+	if err := next.skip(payload.N); err != nil {
+		return "", err
+	}
+	var messageKey []byte
+	next.RecvChain, messageKey = kdfChain(next.RecvChain)
+	next.Received++
+	plaintext, err := open(messageKey)
+	if err != nil {
+		return "", err
+	}
+	next.UpdatedAt = time.Now()
+	*s = *next
+	return plaintext, nil
+}
+
+// skip keeps the keys of the messages of the receiving chain before message n.
+func (s *ratchetSession) skip(n int) error {
+	if n-s.Received > maxRatchetSkip {
+		return errors.New("too many skipped messages")
+	}
+	for ; s.Received < n; s.Received++ {
+		var messageKey []byte
+		s.RecvChain, messageKey = kdfChain(s.RecvChain)
+		s.Skipped = append(s.Skipped, skippedKey{DH: s.RemotePub, N: s.Received, Key: messageKey})
+	}
+	if len(s.Skipped) > maxRatchetSkip {
+		s.Skipped = s.Skipped[len(s.Skipped)-maxRatchetSkip:]
+	}
+	return nil
+}
+
+// ratchet reseeds the chains with the new ratchet key of the peer and a new one of this side.
+func (s *ratchetSession) ratchet(remotePub []byte) error {
+	s.PrevSent, s.Sent, s.Received = s.Sent, 0, 0
+	s.RemotePub = remotePub
+	dh, err := curve25519.X25519(s.SendPriv, s.RemotePub)
+	if err != nil {
+		return err
+	}
+	s.RootKey, s.RecvChain = kdfRoot(s.RootKey, dh)
+	if err := s.newRatchetKey(); err != nil {
+		return err
+	}
+	if dh, err = curve25519.X25519(s.SendPriv, s.RemotePub); err != nil {
+		return err
+	}
+	s.RootKey, s.SendChain = kdfRoot(s.RootKey, dh)
+	return nil
+}
+
+// newRatchetKey replaces the ratchet key of this side.
+func (s *ratchetSession) newRatchetKey() error {
+	priv := make([]byte, curve25519.ScalarSize)
+	if _, err := rand.Read(priv); err != nil {
+		return err
+	}
+	pub, err := curve25519.X25519(priv, curve25519.Basepoint)
+	if err != nil {
+		return err
+	}
+	s.SendPriv, s.SendPub = priv, pub
+	return nil
+}
+
+// clone returns a copy of the session that can be changed independently.
+func (s *ratchetSession) clone() *ratchetSession {
+	c := *s
+	c.Skipped = append([]skippedKey(nil), s.Skipped...)
+	return &c
+}
+
+// header returns the associated data of a message: the sender and recipient, and the ratchet
+// state the message was sealed in.
+func (p ratchetPayload) header(ad string) string {
+	return fmt.Sprintf("%s|%s|%s|%d|%d", ad, p.Session, p.DH, p.PrevSent, p.N)
+}
+
+// ratchetAD binds ratchet messages to their sender and recipient.
+func ratchetAD(from, to string) string {
+	return "dk-ratchet|" + from + "|" + to
+}
+
+// kdfRoot derives the next root key and a chain key from a root key and an X25519 output.
+func kdfRoot(rootKey, dh []byte) ([]byte, []byte) {
+	out := make([]byte, 64)
+	io.ReadFull(hkdf.New(sha256.New, dh, rootKey, []byte("dk-ratchet-root")), out)
+	return out[:32], out[32:]
+}
+
+// kdfChain derives the next chain key and a message key from a chain key.
+func kdfChain(chainKey []byte) ([]byte, []byte) {
+	mac := hmac.New(sha256.New, chainKey)
+	mac.Write([]byte{1})
+	messageKey := mac.Sum(nil)
+	mac.Reset()
+	mac.Write([]byte{2})
+	return mac.Sum(nil), messageKey
+}
+
+// messageCipher returns the AES-GCM cipher of a message key.
+func messageCipher(messageKey []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(messageKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// memoryRatchets keeps ratcheting sessions in memory.
+type memoryRatchets struct {
+	mu     sync.Mutex
+	states map[string][]byte
+}
+
+func newMemoryRatchets() *memoryRatchets {
+	return &memoryRatchets{states: make(map[string][]byte)}
+}
+
+func (m *memoryRatchets) SaveRatchetState(peer string, state []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.states[peer] = append([]byte(nil), state...)
+	return nil
+}
+
+func (m *memoryRatchets) RatchetState(peer string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.states[peer]
+	return state, ok, nil
+}
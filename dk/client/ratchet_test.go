@@ -0,0 +1,167 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// ratchetPeers returns two clients that know each other's keys, without a server.
+func ratchetPeers(t *testing.T) (*Client, *Client) {
+	t.Helper()
+	alicePub, alicePriv, _ := ed25519.GenerateKey(rand.Reader)
+	bobPub, bobPriv, _ := ed25519.GenerateKey(rand.Reader)
+	alice := NewClient("", "alice", alicePriv, alicePub)
+	bob := NewClient("", "bob", bobPriv, bobPub)
+	for _, c := range []*Client{alice, bob} {
+		c.keyHistories["alice"] = keyHistory{keys: []ed25519.PublicKey{alicePub}, fetchedAt: time.Now()}
+		c.keyHistories["bob"] = keyHistory{keys: []ed25519.PublicKey{bobPub}, fetchedAt: time.Now()}
+	}
+	return alice, bob
+}
+
+// sealFrom encrypts a direct message the way prepare does, as received with a valid signature.
+func sealFrom(t *testing.T, from, to *Client, content string) Message {
+	t.Helper()
+	sealed, err := from.sealDirect(to.UserID, content, to.PublicKey())
+	if err != nil {
+		t.Fatalf("sealDirect failed: %v", err)
+	}
+	return Message{From: from.UserID, To: to.UserID, Content: sealed, Status: "verified"}
+}
+
+func openExpect(t *testing.T, c *Client, msg Message, want string) {
+	t.Helper()
+	plaintext, err := c.openDirect(msg)
+	if err != nil {
+		t.Fatalf("openDirect failed: %v", err)
+	}
+	if plaintext != want {
+		t.Fatalf("Expected %q, got %q", want, plaintext)
+	}
+}
+
+func schemeOf(t *testing.T, msg Message) string {
+	t.Helper()
+	var env EncryptedMessage
+	if err := json.Unmarshal([]byte(msg.Content), &env); err != nil {
+		t.Fatal(err)
+	}
+	if env.Scheme == "" {
+		return DefaultEncryptionScheme
+	}
+	return env.Scheme
+}
+
+func TestRatchetUpgradesFromDefaultScheme(t *testing.T) {
+	alice, bob := ratchetPeers(t)
+
+	// Until bob announced support, alice uses the default scheme
+	first := sealFrom(t, alice, bob, "hello bob")
+	if scheme := schemeOf(t, first); scheme != DefaultEncryptionScheme {
+		t.Fatalf("Expected the first message in %s, got %s", DefaultEncryptionScheme, scheme)
+	}
+	// Older clients read it, ignoring the announcement
+	if plaintext, err := decryptDirectMessage(first.Content, bob.privateKey); err != nil || plaintext != "hello bob" {
+		t.Fatalf("Expected the announcement to keep the default format, got %q %v", plaintext, err)
+	}
+	openExpect(t, bob, first, "hello bob")
+
+	reply := sealFrom(t, bob, alice, "hello alice")
+	if scheme := schemeOf(t, reply); scheme != RatchetScheme {
+		t.Fatalf("Expected the reply in %s, got %s", RatchetScheme, scheme)
+	}
+	openExpect(t, alice, reply, "hello alice")
+
+	for i, content := range []string{"one", "two", "three"} {
+		from, to := alice, bob
+		if i%2 == 1 {
+			from, to = bob, alice
+		}
+		msg := sealFrom(t, from, to, content)
+		if scheme := schemeOf(t, msg); scheme != RatchetScheme {
+			t.Fatalf("Expected %q in %s, got %s", content, RatchetScheme, scheme)
+		}
+		openExpect(t, to, msg, content)
+	}
+}
+
+func TestRatchetIgnoresUnverifiedAnnouncements(t *testing.T) {
+	alice, bob := ratchetPeers(t)
+	msg := sealFrom(t, alice, bob, "hello")
+	msg.Status = "unsigned"
+	openExpect(t, bob, msg, "hello")
+	if scheme := schemeOf(t, sealFrom(t, bob, alice, "reply")); scheme != DefaultEncryptionScheme {
+		t.Errorf("Expected an unverified announcement to be ignored, got %s", scheme)
+	}
+}
+
+func TestRatchetForwardSecrecy(t *testing.T) {
+	alice, bob := ratchetPeers(t)
+	openExpect(t, bob, sealFrom(t, alice, bob, "hello"), "hello")
+	openExpect(t, alice, sealFrom(t, bob, alice, "hi"), "hi")
+
+	msg := sealFrom(t, alice, bob, "secret")
+	openExpect(t, bob, msg, "secret")
+	// The key of a message is gone once it was read
+	if _, err := bob.openDirect(msg); err == nil {
+		t.Error("Expected a message to be readable only once")
+	}
+	// The identity key alone does not read it
+	thief := NewClient("", "bob", bob.privateKey, bob.publicKey)
+	thief.keyHistories = bob.keyHistories
+	if _, err := thief.openDirect(msg); err == nil {
+		t.Error("Expected the identity key not to decrypt a ratchet message")
+	}
+}
+
+func TestRatchetOutOfOrder(t *testing.T) {
+	alice, bob := ratchetPeers(t)
+	openExpect(t, bob, sealFrom(t, alice, bob, "hello"), "hello")
+	openExpect(t, alice, sealFrom(t, bob, alice, "hi"), "hi")
+
+	first := sealFrom(t, alice, bob, "first")
+	second := sealFrom(t, alice, bob, "second")
+	third := sealFrom(t, alice, bob, "third")
+	openExpect(t, bob, third, "third")
+	openExpect(t, bob, first, "first")
+	// Bob answers, starting a new chain, before alice's delayed message arrives
+	openExpect(t, alice, sealFrom(t, bob, alice, "answer"), "answer")
+	openExpect(t, bob, second, "second")
+	openExpect(t, bob, sealFrom(t, alice, bob, "fourth"), "fourth")
+}
+
+func TestRatchetRecoversLostSessions(t *testing.T) {
+	alice, bob := ratchetPeers(t)
+	openExpect(t, bob, sealFrom(t, alice, bob, "hello"), "hello")
+	openExpect(t, alice, sealFrom(t, bob, alice, "hi"), "hi")
+
+	// Bob restarts without a store
+	bob.SetRatchetStore(newMemoryRatchets())
+	if _, err := bob.openDirect(sealFrom(t, alice, bob, "lost")); err == nil {
+		t.Fatal("Expected the message of the lost session to be unreadable")
+	}
+	// Bob starts a new session, which alice moves to
+	restart := sealFrom(t, bob, alice, "restarted")
+	if scheme := schemeOf(t, restart); scheme != RatchetScheme {
+		t.Fatalf("Expected bob to start a new session, got %s", scheme)
+	}
+	openExpect(t, alice, restart, "restarted")
+	openExpect(t, bob, sealFrom(t, alice, bob, "welcome back"), "welcome back")
+}
+
+func TestRatchetSimultaneousSessions(t *testing.T) {
+	alice, bob := ratchetPeers(t)
+	alice.saveRatchetPeer("bob", &ratchetPeer{Capable: true})
+	bob.saveRatchetPeer("alice", &ratchetPeer{Capable: true})
+
+	// Both start a session at once; each reads the other's and the conversation goes on
+	fromAlice := sealFrom(t, alice, bob, "from alice")
+	fromBob := sealFrom(t, bob, alice, "from bob")
+	openExpect(t, bob, fromAlice, "from alice")
+	openExpect(t, alice, fromBob, "from bob")
+	openExpect(t, bob, sealFrom(t, alice, bob, "again from alice"), "again from alice")
+	openExpect(t, alice, sealFrom(t, bob, alice, "again from bob"), "again from bob")
+}
@@ -0,0 +1,170 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReconnectPolicy controls how the client reconnects after losing its connection. Between
+// attempts it backs off exponentially, but hints from the server take precedence: the
+// Retry-After header of a rejected login or connection, an announced maintenance window, and
+// the delay a degraded server asks for on its health endpoint.
+type ReconnectPolicy struct {
+	InitialDelay time.Duration // Delay after the first failed attempt
+	MaxDelay     time.Duration // Upper bound of the backoff; server hints may ask for longer
+	// Jitter is the fraction by which delays are randomized, so clients that lost their
+	// connection together do not return together
+	Jitter float64
+	// MaxQueueAge drops messages that waited longer than this to be sent; 0 keeps them until
+	// the client reconnects
+	MaxQueueAge time.Duration
+}
+
+// DefaultReconnectPolicy returns the policy of new clients.
+func DefaultReconnectPolicy() ReconnectPolicy {
+	return ReconnectPolicy{
+		InitialDelay: 5 * time.Second,
+		MaxDelay:     60 * time.Second,
+		Jitter:       0.2,
+		MaxQueueAge:  15 * time.Minute,
+	}
+}
+
+// RetryAfterError is returned when the server turns a request away and says when to retry,
+// e.g. during maintenance or while a standby server waits to take over.
+type RetryAfterError struct {
+	StatusCode int
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("%s (status %d, retry after %v)", e.Message, e.StatusCode, e.RetryAfter)
+}
+
+// SetReconnectPolicy replaces the reconnect policy.
+func (c *Client) SetReconnectPolicy(policy ReconnectPolicy) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.reconnectPolicy = policy
+}
+
+// ReconnectPolicy returns the reconnect policy.
+func (c *Client) ReconnectPolicy() ReconnectPolicy {
+	c.connMu.RLock()
+	defer c.connMu.RUnlock()
+	return c.reconnectPolicy
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := at.Sub(now); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// responseError describes a failed response, as a RetryAfterError if the server said when to
+// retry.
+func responseError(resp *http.Response, message string) error {
+	err := statusError(resp, message)
+	if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		return &RetryAfterError{StatusCode: resp.StatusCode, RetryAfter: delay, Message: err.Message}
+	}
+	return err
+}
+
+// serverHealth is the part of the server's health report that guides reconnects.
+type serverHealth struct {
+	Status      string `json:"status"`
+	RetryAfter  int    `json:"retry_after"`
+	Maintenance *struct {
+		Start time.Time `json:"start"`
+		End   time.Time `json:"end"`
+	} `json:"maintenance"`
+}
+
+// reconnectHint asks the health endpoint how long to wait before reconnecting. It returns 0
+// when the server has no objection or cannot be reached, in which case the backoff applies.
+func (c *Client) reconnectHint() time.Duration {
+	client := *c.httpClient()
+	client.Timeout = 5 * time.Second
+	resp, err := client.Get(c.ServerURL() + "/health")
+	if err != nil {
+		return 0
+	}
+	defer resp.Body.Close()
+
+	now := time.Now()
+	var delay time.Duration
+	if resp.StatusCode == http.StatusServiceUnavailable {
+		delay, _ = parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	}
+	var health serverHealth
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&health); err != nil {
+		return delay
+	}
+	if m := health.Maintenance; m != nil && !now.Before(m.Start) && now.Before(m.End) {
+		if until := m.End.Sub(now); until > delay {
+			delay = until
+		}
+	}
+	if hinted := time.Duration(health.RetryAfter) * time.Second; hinted > delay {
+		delay = hinted
+	}
+	return delay
+}
+
+// jitter randomizes a delay by the policy's jitter fraction. Server hints are only ever
+// extended, since reconnecting before them is pointless.
+func (p ReconnectPolicy) jitter(delay time.Duration, hinted bool) time.Duration {
+	if p.Jitter <= 0 || delay <= 0 {
+		return delay
+	}
+	spread := float64(delay) * p.Jitter
+	if hinted {
+		return delay + time.Duration(rand.Float64()*spread)
+	}
+	return delay + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// expired reports whether a queued message waited longer than the policy allows.
+func (c *Client) expired(msg Message) bool {
+	maxAge := c.ReconnectPolicy().MaxQueueAge
+	if maxAge <= 0 || msg.queuedAt.IsZero() || time.Since(msg.queuedAt) <= maxAge {
+		return false
+	}
+	log.Printf("Dropping message to %s queued %v ago", msg.To, time.Since(msg.queuedAt).Round(time.Second))
+	c.undelivered(msg, errors.New("message expired in the send queue"))
+	return true
+}
+
+// wait sleeps for the given delay and reports false if the client is disconnected meanwhile.
+func (c *Client) wait(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.doneCh:
+		return false
+	}
+}
@@ -0,0 +1,249 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// failoverServer simulates a switchover: the first connection is dropped, the server is
+// unavailable until promoted, and then only accepts tokens it issued itself.
+type failoverServer struct {
+	mu          sync.Mutex
+	connections int
+	promoted    bool
+	retrying    chan struct{} // Signalled when the client retries before promotion
+	received    chan Message
+}
+
+func (s *failoverServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/auth/login" && r.URL.Query().Get("verify") == "true":
+		json.NewEncoder(w).Encode(map[string]string{"token": "standby-token"})
+	case r.URL.Path == "/auth/login":
+		json.NewEncoder(w).Encode(map[string]string{"challenge": "challenge"})
+	case r.URL.Path == "/ws":
+		s.mu.Lock()
+		s.connections++
+		first, promoted := s.connections == 1, s.promoted
+		s.mu.Unlock()
+
+		if !first && !promoted {
+			select {
+			case s.retrying <- struct{}{}:
+			default:
+			}
+			http.Error(w, "Server is on standby", http.StatusServiceUnavailable)
+			return
+		}
+		if !first && r.URL.Query().Get("token") != "standby-token" {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if first {
+			// The active server goes away
+			return
+		}
+		for {
+			var msg Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			s.received <- msg
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestReconnectSurvivesSwitchover(t *testing.T) {
+	server := &failoverServer{retrying: make(chan struct{}, 1), received: make(chan Message, 10)}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	c.SetReconnectInterval(10 * time.Millisecond)
+	c.jwtToken = "active-token"
+	reconnected := make(chan int, 1)
+	c.SetHooks(Hooks{OnReconnect: func(attempt int, err error) {
+		if err == nil {
+			reconnected <- attempt
+		}
+	}})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Disconnect()
+
+	select {
+	case <-server.retrying:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not try to reconnect")
+	}
+
+	// Queued while the client is switching over
+	for _, content := range []string{"first", "second"} {
+		if err := c.BroadcastMessage(content); err != nil {
+			t.Fatalf("BroadcastMessage failed: %v", err)
+		}
+	}
+	server.mu.Lock()
+	server.promoted = true
+	server.mu.Unlock()
+
+	for _, want := range []string{"first", "second"} {
+		select {
+		case msg := <-server.received:
+			if msg.Content != want {
+				t.Errorf("Expected %q, got %q", want, msg.Content)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Message %q was not delivered after the switchover", want)
+		}
+	}
+	if c.Token() != "standby-token" {
+		t.Errorf("Expected the client to log in again, token is %q", c.Token())
+	}
+	if attempt := <-reconnected; attempt < 2 {
+		t.Errorf("Expected OnReconnect to report the failed attempts first, got attempt %d", attempt)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"30", 30 * time.Second, true},
+		{now.Add(2 * time.Minute).Format(http.TimeFormat), 2 * time.Minute, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+	for _, tc := range cases {
+		if got, ok := parseRetryAfter(tc.value, now); got != tc.want || ok != tc.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tc.value, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+// maintenanceServer drops the first connection and then reports a maintenance window on its
+// health endpoint until it is over.
+type maintenanceServer struct {
+	mu          sync.Mutex
+	connections int
+	until       time.Time
+	reconnected chan time.Time
+	received    chan Message
+}
+
+func (s *maintenanceServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	until := s.until
+	s.mu.Unlock()
+	inMaintenance := time.Now().Before(until)
+
+	switch r.URL.Path {
+	case "/health":
+		if inMaintenance {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]any{"status": "maintenance", "retry_after": 1})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"status": "ok"})
+	case "/auth/login":
+		w.Header().Set("Retry-After", "2")
+		http.Error(w, "Server is under maintenance", http.StatusServiceUnavailable)
+	case "/ws":
+		s.mu.Lock()
+		s.connections++
+		first := s.connections == 1
+		if first {
+			s.until = time.Now().Add(time.Second)
+		}
+		s.mu.Unlock()
+		if !first {
+			s.reconnected <- time.Now()
+		}
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if first {
+			return
+		}
+		for {
+			var msg Message
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			s.received <- msg
+		}
+	}
+}
+
+func TestReconnectHonorsServerHints(t *testing.T) {
+	server := &maintenanceServer{reconnected: make(chan time.Time, 1), received: make(chan Message, 10)}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	c.SetReconnectPolicy(ReconnectPolicy{InitialDelay: 10 * time.Millisecond, MaxDelay: time.Second, MaxQueueAge: 800 * time.Millisecond})
+
+	var retry *RetryAfterError
+	if err := c.Login(); !errors.As(err, &retry) || retry.RetryAfter != 2*time.Second {
+		t.Fatalf("Expected a RetryAfterError of 2s from the login, got %v", err)
+	}
+
+	c.jwtToken = "token"
+	start := time.Now()
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Disconnect()
+
+	// Queued while offline; the first message waits too long and is dropped
+	if err := c.BroadcastMessage("stale"); err != nil {
+		t.Fatalf("BroadcastMessage failed: %v", err)
+	}
+	time.Sleep(900 * time.Millisecond)
+	if err := c.BroadcastMessage("fresh"); err != nil {
+		t.Fatalf("BroadcastMessage failed: %v", err)
+	}
+
+	select {
+	case at := <-server.reconnected:
+		if waited := at.Sub(start); waited < time.Second {
+			t.Errorf("Expected the client to wait out the maintenance window, reconnected after %v", waited)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Client did not reconnect after the maintenance window")
+	}
+	select {
+	case msg := <-server.received:
+		if msg.Content != "fresh" {
+			t.Errorf("Expected only the fresh message to be sent, got %q", msg.Content)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Queued message was not delivered")
+	}
+}
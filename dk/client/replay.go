@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replayWindow is how far behind the latest sequence number of a sender a message may be and
+// still be accepted, for messages delivered out of order. Sequence numbers follow the clock of
+// the sender, in microseconds.
+var replayWindow = time.Hour
+
+// SequenceStore keeps the latest sequence number received from each sender, so messages
+// captured before a restart are not accepted again after it. Without one, they are kept in
+// memory.
+type SequenceStore interface {
+	// SaveSequence stores the latest sequence number received from a sender.
+	SaveSequence(sender string, sequence uint64) error
+	// Sequence returns the latest sequence number received from a sender.
+	Sequence(sender string) (uint64, bool, error)
+}
+
+// SetSequenceStore replaces the store of received sequence numbers.
+func (c *Client) SetSequenceStore(store SequenceStore) {
+	c.sendersMu.Lock()
+	defer c.sendersMu.Unlock()
+	c.sequences = store
+	c.senders = make(map[string]*senderSequences)
+}
+
+// senderSequences is the sequence numbers received from a sender: the latest one, those
+// within replayWindow behind it, and the number at or below which nothing is accepted.
+type senderSequences struct {
+	latest uint64
+	floor  uint64
+	seen   map[uint64]bool
+}
+
+// sequencedForm is the canonical form of a message signed with its sequence number.
+func sequencedForm(msg Message) string {
+	return fmt.Sprintf("dk-seq|%s|%s|%d|%d|%s", msg.From, msg.To, msg.Timestamp.UnixNano(), msg.Sequence, msg.Content)
+}
+
+// nextSequence returns the sequence number of the next message sent: the time in microseconds,
+// or the last number plus one if the clock did not move forward. Numbers increase across
+// restarts without being stored.
+func (c *Client) nextSequence() uint64 {
+	c.sequenceMu.Lock()
+	defer c.sequenceMu.Unlock()
+	c.sequence = max(c.sequence+1, uint64(c.Now().UnixMicro()))
+	return c.sequence
+}
+
+// sequencedPeer reports whether a peer numbers its messages, so it verifies the signatures
+// covering sequence numbers. Broadcasts and group messages may reach older clients.
+func (c *Client) sequencedPeer(peer string) bool {
+	if peer == broadcastAddress || strings.HasPrefix(peer, GroupAddressPrefix) {
+		return false
+	}
+	c.sendersMu.Lock()
+	defer c.sendersMu.Unlock()
+	if state, ok := c.senders[peer]; ok {
+		return state.latest != 0
+	}
+	latest, _, err := c.sequences.Sequence(peer)
+	return err == nil && latest != 0
+}
+
+// freshSequence reports whether a verified message was not received before, and records it.
+// Messages without a sequence number, from older clients, are accepted until their sender sent
+// one with a sequence number.
+func (c *Client) freshSequence(msg Message) bool {
+	c.sendersMu.Lock()
+	defer c.sendersMu.Unlock()
+	state, ok := c.senders[msg.From]
+	if !ok {
+		latest, _, err := c.sequences.Sequence(msg.From)
+		if err != nil {
+			log.Printf("Failed to load the sequence number of %s: %v", msg.From, err)
+		}
+		// What was received before the restart is not known, only what came before it
+		state = &senderSequences{latest: latest, floor: latest, seen: make(map[uint64]bool)}
+		c.senders[msg.From] = state
+	}
+
+	if msg.Sequence == 0 {
+		return state.latest == 0
+	}
+	if msg.Sequence <= state.floor || state.seen[msg.Sequence] {
+		return false
+	}
+	state.seen[msg.Sequence] = true
+	if msg.Sequence <= state.latest {
+		return true
+	}
+
+	state.latest = msg.Sequence
+	window := uint64(replayWindow.Microseconds())
+	if state.latest > window {
+		state.floor = max(state.floor, state.latest-window)
+	}
+	for sequence := range state.seen {
+		if sequence <= state.floor {
+			delete(state.seen, sequence)
+		}
+	}
+	if err := c.sequences.SaveSequence(msg.From, state.latest); err != nil {
+		log.Printf("Failed to save the sequence number of %s: %v", msg.From, err)
+	}
+	return true
+}
+
+// memorySequences keeps received sequence numbers in memory.
+type memorySequences struct {
+	mu        sync.Mutex
+	sequences map[string]uint64
+}
+
+func newMemorySequences() *memorySequences {
+	return &memorySequences{sequences: make(map[string]uint64)}
+}
+
+func (m *memorySequences) SaveSequence(sender string, sequence uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sequences[sender] = sequence
+	return nil
+}
+
+func (m *memorySequences) Sequence(sender string) (uint64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sequence, ok := m.sequences[sender]
+	return sequence, ok, nil
+}
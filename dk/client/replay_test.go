@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestSequencedSignature(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient("", "alice", priv, pub)
+	msg := Message{From: "alice", To: "bob", Content: "hello"}
+	if err := c.signMessage(&msg); err != nil {
+		t.Fatal(err)
+	}
+	next := Message{From: "alice", To: "bob", Content: "hello"}
+	c.signMessage(&next)
+	if msg.Sequence == 0 || next.Sequence <= msg.Sequence {
+		t.Fatalf("Expected increasing sequence numbers, got %d then %d", msg.Sequence, next.Sequence)
+	}
+	if !c.verifyMessageSignature(msg, pub) {
+		t.Fatal("Expected the sequenced signature to verify")
+	}
+
+	// Older clients verify the signature without the sequence number
+	legacy := msg
+	legacy.Sequence, legacy.SequenceSignature = 0, ""
+	if !c.verifyMessageSignature(legacy, pub) {
+		t.Error("Expected the signature for older clients to verify")
+	}
+	// Recipients known to number their messages only get the signature covering the number
+	c.freshSequence(Message{From: "bob", Sequence: 10_000_000})
+	c.signMessage(&next)
+	stripped := next
+	stripped.Sequence, stripped.SequenceSignature = 0, ""
+	if !c.verifyMessageSignature(next, pub) || c.verifyMessageSignature(stripped, pub) {
+		t.Error("Expected the message to bob to verify only with its sequence number")
+	}
+	// The sequence number cannot be changed, e.g. to replay the message
+	tampered := msg
+	tampered.Sequence++
+	if c.verifyMessageSignature(tampered, pub) {
+		t.Error("Expected a changed sequence number to invalidate the signature")
+	}
+	swapped := msg
+	swapped.SequenceSignature = base64.StdEncoding.EncodeToString(make([]byte, ed25519.SignatureSize))
+	if c.verifyMessageSignature(swapped, pub) {
+		t.Error("Expected an invalid sequenced signature not to fall back to the other one")
+	}
+}
+
+func TestFreshSequence(t *testing.T) {
+	defer func(window time.Duration) { replayWindow = window }(replayWindow)
+	replayWindow = time.Second
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient("", "bob", priv, pub)
+	from := func(sequence uint64) Message { return Message{From: "alice", Sequence: sequence} }
+
+	// Older clients do not number their messages
+	if !c.freshSequence(from(0)) {
+		t.Error("Expected a message without sequence number to be accepted")
+	}
+	base := uint64(10_000_000)
+	for _, tc := range []struct {
+		sequence uint64
+		fresh    bool
+	}{
+		{base, true},
+		{base, false},     // replayed
+		{base + 5, true},  // later
+		{base + 2, true},  // delivered out of order
+		{base + 2, false}, // replayed out of order
+		{base + 2_000_000, true},
+		{base + 5, false}, // more than the window behind the latest
+		{0, false},        // without sequence number, once the sender numbers them
+	} {
+		if fresh := c.freshSequence(from(tc.sequence)); fresh != tc.fresh {
+			t.Errorf("Sequence %d: expected fresh=%v, got %v", tc.sequence, tc.fresh, fresh)
+		}
+	}
+
+	// After a restart, nothing up to the latest sequence number is accepted again
+	restarted := NewClient("", "bob", priv, pub)
+	restarted.SetSequenceStore(c.sequences)
+	if restarted.freshSequence(from(base + 2_000_000)) {
+		t.Error("Expected a message received before the restart to be dropped")
+	}
+	if !restarted.freshSequence(from(base + 2_000_001)) {
+		t.Error("Expected a new message to be accepted after the restart")
+	}
+}
+
+func TestReplayedMessagesAreDropped(t *testing.T) {
+	server := &relayServer{conns: make(map[string]*websocket.Conn)}
+	server.replay = func(msg Message) bool { return msg.From == "alice" && msg.Ack == "" }
+	alice, bob := connectPeers(t, server)
+
+	for i := range 3 {
+		if err := alice.SendMessage(Message{To: "bob", Content: fmt.Sprintf("message %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range 3 {
+		select {
+		case msg := <-bob.Messages():
+			if want := fmt.Sprintf("message %d", i); msg.Content != want || msg.Status != "verified" {
+				t.Fatalf("Expected %q verified, got %q (%s)", want, msg.Content, msg.Status)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Message not received")
+		}
+	}
+	select {
+	case msg := <-bob.Messages():
+		t.Errorf("Expected the replayed copies to be dropped, got %q", msg.Content)
+	case <-time.After(300 * time.Millisecond):
+	}
+}
+
+func TestStrippedSequenceReplayed(t *testing.T) {
+	server := &relayServer{conns: make(map[string]*websocket.Conn)}
+	var captured []Message
+	server.drop = func(msg Message) bool {
+		if msg.From == "alice" && msg.Ack == "" {
+			captured = append(captured, msg)
+		}
+		return false
+	}
+	alice, bob := connectPeers(t, server)
+
+	// Once bob wrote to alice, alice knows bob numbers his messages
+	if err := bob.SendMessage(Message{To: "alice", Content: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, alice.Messages())
+	if err := alice.SendMessage(Message{To: "bob", Content: "transfer 10"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := receive(t, bob.Messages()); msg.Status != "verified" {
+		t.Fatalf("Expected the message to be verified, got %s", msg.Status)
+	}
+
+	// A copy without its sequence number does not verify, even for a client that lost the
+	// sequence numbers it received, e.g. after a restart
+	server.mu.Lock()
+	stripped := captured[len(captured)-1]
+	server.mu.Unlock()
+	stripped.Sequence, stripped.SequenceSignature = 0, ""
+	restarted := NewClient("", "bob", bob.privateKey, bob.publicKey)
+	restarted.pubKeyCache["alice"] = alice.PublicKey()
+	if valid, _ := restarted.verifySender(stripped); valid {
+		t.Error("Expected the message stripped of its sequence number not to verify")
+	}
+}
@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// SecretShare is one share of a secret split with Shamir's secret sharing. Any Threshold
+// shares of the same split reconstruct the secret; fewer reveal nothing about it.
+type SecretShare struct {
+	Index     byte   `json:"index"` // x coordinate of the share, 1..255
+	Threshold int    `json:"threshold"`
+	Value     []byte `json:"value"` // One polynomial evaluation per byte of the secret
+}
+
+// GF(256) exponent and logarithm tables for the AES polynomial x^8 + x^4 + x^3 + x + 1
+var gfExp, gfLog = func() ([510]byte, [256]byte) {
+	var exp [510]byte
+	var log [256]byte
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		exp[i] = x
+		exp[i+255] = x
+		log[x] = byte(i)
+		// multiply by the generator 3
+		x ^= gfDouble(x)
+	}
+	return exp, log
+}()
+
+func gfDouble(x byte) byte {
+	if x&0x80 != 0 {
+		return x<<1 ^ 0x1b
+	}
+	return x << 1
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+255-int(gfLog[b])]
+}
+
+// SplitSecret splits secret into parts shares, any threshold of which reconstruct it
+func SplitSecret(secret []byte, parts, threshold int) ([]SecretShare, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("secret must not be empty")
+	}
+	if threshold < 2 || threshold > parts {
+		return nil, fmt.Errorf("threshold must be between 2 and the number of shares (%d)", parts)
+	}
+	if parts > 255 {
+		return nil, errors.New("at most 255 shares are supported")
+	}
+
+	shares := make([]SecretShare, parts)
+	for i := range shares {
+		shares[i] = SecretShare{Index: byte(i + 1), Threshold: threshold, Value: make([]byte, len(secret))}
+	}
+
+	// One random polynomial of degree threshold-1 per byte, with the byte as constant term
+	coefficients := make([]byte, threshold)
+	for pos, b := range secret {
+		coefficients[0] = b
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("failed to generate share coefficients: %w", err)
+		}
+		for i := range shares {
+			x := shares[i].Index
+			// Horner's method
+			var y byte
+			for c := threshold - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coefficients[c]
+			}
+			shares[i].Value[pos] = y
+		}
+	}
+	return shares, nil
+}
+
+// CombineShares reconstructs a secret from at least Threshold shares of the same split
+func CombineShares(shares []SecretShare) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares to combine")
+	}
+	threshold := shares[0].Threshold
+	if len(shares) < threshold {
+		return nil, fmt.Errorf("%d shares are needed, only %d available", threshold, len(shares))
+	}
+
+	length := len(shares[0].Value)
+	seen := make(map[byte]bool)
+	for _, s := range shares {
+		if s.Index == 0 || seen[s.Index] {
+			return nil, fmt.Errorf("invalid or duplicate share index %d", s.Index)
+		}
+		if len(s.Value) != length || s.Threshold != threshold {
+			return nil, errors.New("shares belong to different splits")
+		}
+		seen[s.Index] = true
+	}
+
+	// Lagrange interpolation at x = 0
+	secret := make([]byte, length)
+	for pos := 0; pos < length; pos++ {
+		var value byte
+		for i, si := range shares {
+			basis := byte(1)
+			for j, sj := range shares {
+				if i == j {
+					continue
+				}
+				basis = gfMul(basis, gfDiv(sj.Index, sj.Index^si.Index))
+			}
+			value ^= gfMul(si.Value[pos], basis)
+		}
+		secret[pos] = value
+	}
+	return secret, nil
+}
+
+// SplitIdentityKey splits the client's private key into parts shares for key escrow, any
+// threshold of which recover it with RecoverIdentityKey
+func (c *Client) SplitIdentityKey(parts, threshold int) ([]SecretShare, error) {
+	privateKey, _ := c.identity()
+	return SplitSecret(privateKey.Seed(), parts, threshold)
+}
+
+// RecoverIdentityKey reconstructs an identity key from escrow shares and checks that it
+// matches the expected public key
+func RecoverIdentityKey(shares []SecretShare, expected ed25519.PublicKey) (ed25519.PrivateKey, error) {
+	seed, err := CombineShares(shares)
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("shares do not contain an identity key")
+	}
+	privateKey := ed25519.NewKeyFromSeed(seed)
+	if !privateKey.Public().(ed25519.PublicKey).Equal(expected) {
+		return nil, errors.New("recovered key does not match the registered public key")
+	}
+	return privateKey, nil
+}
@@ -0,0 +1,67 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+func TestSplitAndCombineSecret(t *testing.T) {
+	secret := []byte("correct horse battery staple")
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("SplitSecret failed: %v", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("Expected 5 shares, got %d", len(shares))
+	}
+
+	// Every combination of 3 shares recovers the secret
+	for a := 0; a < 5; a++ {
+		for b := a + 1; b < 5; b++ {
+			for c := b + 1; c < 5; c++ {
+				got, err := CombineShares([]SecretShare{shares[a], shares[b], shares[c]})
+				if err != nil {
+					t.Fatalf("CombineShares failed: %v", err)
+				}
+				if !bytes.Equal(got, secret) {
+					t.Errorf("Shares %d,%d,%d recovered %q", a, b, c, got)
+				}
+			}
+		}
+	}
+
+	if _, err := CombineShares(shares[:2]); err == nil {
+		t.Error("Expected an error when fewer shares than the threshold are combined")
+	}
+	if _, err := CombineShares([]SecretShare{shares[0], shares[0], shares[1]}); err == nil {
+		t.Error("Expected an error for duplicate shares")
+	}
+	if _, err := SplitSecret(secret, 3, 4); err == nil {
+		t.Error("Expected an error for a threshold above the number of shares")
+	}
+}
+
+func TestRecoverIdentityKey(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient("https://example.com", "alice", priv, pub)
+
+	shares, err := c.SplitIdentityKey(3, 2)
+	if err != nil {
+		t.Fatalf("SplitIdentityKey failed: %v", err)
+	}
+
+	recovered, err := RecoverIdentityKey(shares[1:], pub)
+	if err != nil {
+		t.Fatalf("RecoverIdentityKey failed: %v", err)
+	}
+	if !recovered.Equal(priv) {
+		t.Error("Recovered key differs from the original key")
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := RecoverIdentityKey(shares[:2], otherPub); err == nil {
+		t.Error("Expected an error when the recovered key does not match the public key")
+	}
+}
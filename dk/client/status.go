@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Connection states reported by Status.
+const (
+	StateConnected    = "connected"
+	StateReconnecting = "reconnecting"
+	StateDisconnected = "disconnected" // Not connected yet, or disconnected by the application
+)
+
+// ErrReconnectInProgress is returned by Reconnect while the client is already reconnecting.
+var ErrReconnectInProgress = errors.New("a reconnect is already in progress")
+
+// errReconnectRequested is reported to OnDisconnect when the application calls Reconnect.
+var errReconnectRequested = errors.New("reconnect requested")
+
+// ConnectionStatus describes the WebSocket connection of the client.
+type ConnectionStatus struct {
+	State            string    `json:"state"`
+	ServerURL        string    `json:"server_url"`
+	ConnectedAt      time.Time `json:"connected_at,omitempty"`      // When the current connection was opened
+	ReconnectAttempt int       `json:"reconnect_attempt,omitempty"` // Attempts of the reconnect in progress
+	TokenExpiresAt   time.Time `json:"token_expires_at,omitempty"`  // Zero when the token has no expiry
+	LastError        string    `json:"last_error,omitempty"`        // Last lost connection or failed reconnect attempt
+	LastErrorAt      time.Time `json:"last_error_at,omitempty"`
+	QueuedMessages   int       `json:"queued_messages"` // Messages waiting to be sent, including those in the outbox store
+}
+
+// Status reports the state of the connection, the server in use, when the token expires and
+// the last connection error, so applications can notice a connection that silently dropped.
+func (c *Client) Status() ConnectionStatus {
+	status := ConnectionStatus{ServerURL: c.ServerURL()}
+	if expiry, ok := tokenExpiry(c.Token()); ok {
+		status.TokenExpiresAt = expiry
+	}
+
+	c.connMu.RLock()
+	switch {
+	case c.closed():
+		status.State = StateDisconnected
+	case c.reconnecting:
+		status.State = StateReconnecting
+		status.ReconnectAttempt = c.reconnectAttempt
+	case c.wsConn != nil:
+		status.State = StateConnected
+		status.ConnectedAt = c.connectedAt
+	default:
+		status.State = StateDisconnected
+	}
+	status.LastError, status.LastErrorAt = c.lastError, c.lastErrorAt
+	c.connMu.RUnlock()
+
+	c.outboxMu.Lock()
+	status.QueuedMessages = len(c.outbox) + c.QueueDepth()
+	store := c.outboxStore
+	c.outboxMu.Unlock()
+	if store != nil {
+		if stored, err := store.Len(); err == nil {
+			status.QueuedMessages += stored
+		}
+	}
+	return status
+}
+
+// Reconnect drops the current connection, if any, and reconnects in the background following
+// the reconnect policy, as after a lost connection. Use Status to follow its progress. It
+// returns ErrReconnectInProgress while a reconnect is running and fails once the client has
+// been disconnected.
+func (c *Client) Reconnect() error {
+	c.connMu.RLock()
+	current, reconnecting, closed := c.wsConn, c.reconnecting, c.closed()
+	c.connMu.RUnlock()
+	if closed {
+		return ErrNotConnected
+	}
+	if reconnecting {
+		return ErrReconnectInProgress
+	}
+	go c.handleReconnect(current, errReconnectRequested)
+	return nil
+}
+
+// connectionLost records the error that broke a connection and reconnects. Errors of a
+// connection that is already being replaced are not recorded.
+func (c *Client) connectionLost(conn *websocket.Conn, err error) {
+	c.connMu.Lock()
+	if c.wsConn == conn && !c.reconnecting && !c.closed() {
+		c.lastError, c.lastErrorAt = err.Error(), time.Now()
+	}
+	c.connMu.Unlock()
+	go c.handleReconnect(conn, err)
+}
+
+// reconnectFailed records a failed reconnect attempt.
+func (c *Client) reconnectFailed(attempt int, err error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.reconnectAttempt = attempt
+	c.lastError, c.lastErrorAt = err.Error(), time.Now()
+}
+
+// closed reports whether the application disconnected the client.
+func (c *Client) closed() bool {
+	select {
+	case <-c.doneCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// tokenExpiry reads the expiry of a JWT from its "exp" claim. The token is not verified, the
+// server does that; the expiry is only informative.
+func tokenExpiry(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(claims.Exp), 0), true
+}
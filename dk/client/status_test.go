@@ -0,0 +1,107 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func waitForState(t *testing.T, c *Client, want string) ConnectionStatus {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := c.Status()
+		if status.State == want {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected state %q, got %q", want, status.State)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStatusAndReconnect(t *testing.T) {
+	server := &switchableServer{received: make(chan Message, 10)}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	c.SetReconnectInterval(10 * time.Millisecond)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","exp":1900000000}`))
+	c.jwtToken = "header." + payload + ".signature"
+
+	if status := c.Status(); status.State != StateDisconnected {
+		t.Errorf("Expected a new client to be disconnected, got %q", status.State)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer c.Disconnect()
+
+	status := waitForState(t, c, StateConnected)
+	if status.ServerURL != srv.URL || status.ConnectedAt.IsZero() || status.LastError != "" {
+		t.Errorf("Unexpected status of a new connection: %+v", status)
+	}
+	if !status.TokenExpiresAt.Equal(time.Unix(1900000000, 0)) {
+		t.Errorf("Expected the token expiry from its exp claim, got %v", status.TokenExpiresAt)
+	}
+
+	// A server going away is noticed and reported
+	server.setDown(true)
+	status = waitForState(t, c, StateReconnecting)
+	for status.LastError == "" && status.State == StateReconnecting {
+		time.Sleep(10 * time.Millisecond)
+		status = c.Status()
+	}
+	if status.LastError == "" || status.LastErrorAt.IsZero() {
+		t.Errorf("Expected the lost connection to be reported, got %+v", status)
+	}
+	if err := c.Reconnect(); err != ErrReconnectInProgress {
+		t.Errorf("Expected ErrReconnectInProgress while reconnecting, got %v", err)
+	}
+	server.setDown(false)
+	status = waitForState(t, c, StateConnected)
+
+	// A forced reconnect opens a new connection
+	if err := c.Reconnect(); err != nil {
+		t.Fatalf("Reconnect failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		current := c.Status()
+		if current.State == StateConnected && current.ConnectedAt.After(status.ConnectedAt) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Client did not reconnect, status %+v", current)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := c.BroadcastMessage("after reconnect"); err != nil {
+		t.Fatalf("BroadcastMessage failed: %v", err)
+	}
+	expectMessage(t, server.received, "after reconnect")
+
+	c.Disconnect()
+	if status := c.Status(); status.State != StateDisconnected {
+		t.Errorf("Expected a disconnected client, got %q", status.State)
+	}
+	if err := c.Reconnect(); err == nil {
+		t.Error("Expected Reconnect to fail once disconnected")
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	if _, ok := tokenExpiry("not-a-jwt"); ok {
+		t.Error("Expected no expiry for a malformed token")
+	}
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice"}`))
+	if _, ok := tokenExpiry("h." + payload + ".s"); ok {
+		t.Error("Expected no expiry for a token without exp claim")
+	}
+}
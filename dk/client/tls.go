@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// SetInsecure configures the client to skip TLS verification (for testing only). Servers with
+// certificates of a private CA are verified with SetRootCAs instead.
+func (c *Client) SetInsecure(insecure bool) {
+	c.tlsMu.Lock()
+	defer c.tlsMu.Unlock()
+	c.insecure = insecure
+	c.httpc = nil
+}
+
+// SetRootCAs makes the client trust the CA certificates of a PEM bundle, in addition to the
+// roots of the system, e.g. the CA of a self-hosted server. A self-signed server certificate
+// can be given as its own CA.
+func (c *Client) SetRootCAs(pemCerts []byte) error {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemCerts) {
+		return errors.New("no certificates found in the CA bundle")
+	}
+	c.tlsMu.Lock()
+	defer c.tlsMu.Unlock()
+	c.rootCAs = pool
+	c.httpc = nil
+	return nil
+}
+
+// LoadRootCAs makes the client trust the CA certificates of a PEM file, see SetRootCAs.
+func (c *Client) LoadRootCAs(path string) error {
+	pemCerts, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the CA bundle: %w", err)
+	}
+	if err := c.SetRootCAs(pemCerts); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// PinCertificates restricts the servers the client accepts to those presenting a certificate,
+// or a CA certificate in their verified chain, whose public key has one of the SHA-256 fingerprints (in
+// hex, colons allowed; see CertificateFingerprint). Pinning the public key rather than the
+// certificate keeps the pin valid when the certificate is renewed with the same key. The
+// certificate is still verified; no fingerprint removes the pins.
+func (c *Client) PinCertificates(fingerprints ...string) error {
+	pins := make(map[string]bool, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		fingerprint = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+		if decoded, err := hex.DecodeString(fingerprint); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("invalid SHA-256 fingerprint %q", fingerprint)
+		}
+		pins[fingerprint] = true
+	}
+	c.tlsMu.Lock()
+	defer c.tlsMu.Unlock()
+	c.pins = pins
+	c.httpc = nil
+	return nil
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of the public key of a certificate,
+// in hex, as PinCertificates expects it.
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// tlsConfig returns the TLS settings of connections to the server, or nil for the defaults.
+func (c *Client) tlsConfig() *tls.Config {
+	c.tlsMu.Lock()
+	defer c.tlsMu.Unlock()
+	return c.tlsConfigLocked()
+}
+
+func (c *Client) tlsConfigLocked() *tls.Config {
+	if !c.insecure && c.rootCAs == nil && len(c.pins) == 0 {
+		return nil
+	}
+	config := &tls.Config{InsecureSkipVerify: c.insecure, RootCAs: c.rootCAs}
+	if len(c.pins) > 0 {
+		pins := c.pins
+		insecure := c.insecure
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			if pinnedChain(state, pins, insecure) {
+				return nil
+			}
+			return errors.New("server certificate does not match the pinned fingerprints")
+		}
+	}
+	return config
+}
+
+// pinnedChain reports whether the certificate of the server, or a CA certificate of its
+// verified chain, is pinned. Only the chains that were verified count: the server may send any
+// certificate along with its own, including a pinned one. Without verification, only the
+// certificate of the server itself is compared.
+func pinnedChain(state tls.ConnectionState, pins map[string]bool, insecure bool) bool {
+	if insecure {
+		return len(state.PeerCertificates) > 0 && pins[CertificateFingerprint(state.PeerCertificates[0])]
+	}
+	for _, chain := range state.VerifiedChains {
+		for _, cert := range chain {
+			if pins[CertificateFingerprint(cert)] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// httpClient returns the HTTP client for requests to the server, with the TLS and proxy
+// settings of the client.
+func (c *Client) httpClient() *http.Client {
+	c.tlsMu.Lock()
+	defer c.tlsMu.Unlock()
+	config := c.tlsConfigLocked()
+	if config == nil && c.proxy == nil {
+		return http.DefaultClient
+	}
+	if c.httpc == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config
+		if c.proxy != nil {
+			transport.Proxy = http.ProxyURL(c.proxy)
+		}
+		c.httpc = &http.Client{Transport: transport}
+	}
+	return c.httpc
+}
@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func newTokenClient(t *testing.T, serverURL string) *Client {
+	t.Helper()
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(serverURL, "alice", priv, pub)
+	c.jwtToken = "alice"
+	return c
+}
+
+func reachable(c *Client, serverURL string) error {
+	resp, err := c.httpClient().Get(serverURL + "/health")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func TestCustomRootCAs(t *testing.T) {
+	srv := httptest.NewTLSServer(&relayServer{conns: make(map[string]*websocket.Conn)})
+	defer srv.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, pemCert, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTokenClient(t, srv.URL)
+	if err := reachable(c, srv.URL); err == nil {
+		t.Fatal("Expected a certificate of an unknown CA to be rejected")
+	}
+	if err := c.Connect(); err == nil {
+		c.Disconnect()
+		t.Fatal("Expected the websocket connection to an unknown CA to be rejected")
+	}
+
+	if err := c.LoadRootCAs(caFile); err != nil {
+		t.Fatalf("LoadRootCAs failed: %v", err)
+	}
+	if err := reachable(c, srv.URL); err != nil {
+		t.Fatalf("Expected the server to be trusted, got %v", err)
+	}
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Expected the websocket connection to be trusted, got %v", err)
+	}
+	c.Disconnect()
+
+	if err := c.SetRootCAs([]byte("not a certificate")); err == nil {
+		t.Error("Expected a bundle without certificates to be refused")
+	}
+}
+
+func TestPinCertificates(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	fingerprint := CertificateFingerprint(srv.Certificate())
+
+	c := newTokenClient(t, srv.URL)
+	if err := c.SetRootCAs(pemCert); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PinCertificates(strings.Repeat("ab", 32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := reachable(c, srv.URL); err == nil || !strings.Contains(err.Error(), "pinned") {
+		t.Errorf("Expected a certificate that is not pinned to be rejected, got %v", err)
+	}
+
+	// Fingerprints are accepted with colons and in upper case
+	var colons []string
+	for i := 0; i < len(fingerprint); i += 2 {
+		colons = append(colons, strings.ToUpper(fingerprint[i:i+2]))
+	}
+	if err := c.PinCertificates(strings.Repeat("ab", 32), strings.Join(colons, ":")); err != nil {
+		t.Fatal(err)
+	}
+	if err := reachable(c, srv.URL); err != nil {
+		t.Errorf("Expected the pinned certificate to be accepted, got %v", err)
+	}
+
+	// Pinning does not replace the verification of the certificate
+	unverified := newTokenClient(t, srv.URL)
+	unverified.PinCertificates(fingerprint)
+	if err := reachable(unverified, srv.URL); err == nil {
+		t.Error("Expected a pinned certificate of an unknown CA to be rejected")
+	}
+
+	if err := c.PinCertificates("sha256"); err == nil {
+		t.Error("Expected an invalid fingerprint to be refused")
+	}
+}
+
+func TestPinnedCertificateOutsideVerifiedChain(t *testing.T) {
+	// The server sends a pinned certificate along with its own, which it is not signed by
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Pinned CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	pinnedDER, err := x509.CreateCertificate(rand.Reader, template, template, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinned, _ := x509.ParseCertificate(pinnedDER)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.StartTLS()
+	defer srv.Close()
+	srv.TLS.Certificates[0].Certificate = append(srv.TLS.Certificates[0].Certificate, pinnedDER)
+	pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+
+	c := newTokenClient(t, srv.URL)
+	if err := c.SetRootCAs(pemCert); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PinCertificates(CertificateFingerprint(pinned)); err != nil {
+		t.Fatal(err)
+	}
+	if err := reachable(c, srv.URL); err == nil || !strings.Contains(err.Error(), "pinned") {
+		t.Errorf("Expected a pinned certificate outside the verified chain to be rejected, got %v", err)
+	}
+
+	// Without verification, only the certificate of the server counts
+	insecure := newTokenClient(t, srv.URL)
+	insecure.SetInsecure(true)
+	insecure.PinCertificates(CertificateFingerprint(pinned))
+	if err := reachable(insecure, srv.URL); err == nil {
+		t.Error("Expected a pinned certificate sent after the server certificate to be rejected")
+	}
+	insecure.PinCertificates(CertificateFingerprint(srv.Certificate()))
+	if err := reachable(insecure, srv.URL); err != nil {
+		t.Errorf("Expected the pinned server certificate to be accepted, got %v", err)
+	}
+}
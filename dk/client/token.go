@@ -0,0 +1,146 @@
+package lib
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// DefaultTokenRefreshMargin is how long before its expiry the token is refreshed by default.
+const DefaultTokenRefreshMargin = 5 * time.Minute
+
+// tokenRetryInterval is the delay before retrying a failed token refresh.
+const tokenRetryInterval = 30 * time.Second
+
+// setToken stores the token obtained at login and wakes the refresher up, starting it on the
+// first login.
+func (c *Client) setToken(token string) {
+	c.tokenMu.Lock()
+	c.jwtToken = token
+	c.tokenObtainedAt = c.Now()
+	c.tokenMu.Unlock()
+	select {
+	case c.tokenChanged <- struct{}{}:
+	default:
+	}
+	c.refreshOnce.Do(func() { go c.refreshTokens() })
+}
+
+// TokenExpiry returns when the token expires, read from its "exp" claim. It reports false
+// without a token, or for a token without an expiry.
+func (c *Client) TokenExpiry() (time.Time, bool) {
+	return tokenExpiry(c.Token())
+}
+
+// SetTokenRefreshMargin sets how long before its expiry the token is renewed in the
+// background by logging in again. At most half of the lifetime of the token is used, so short
+// tokens are not renewed continuously. A margin of 0 disables the refresh; requests rejected
+// with 401 still log in again.
+func (c *Client) SetTokenRefreshMargin(margin time.Duration) {
+	c.tokenMu.Lock()
+	c.tokenRefreshMargin = margin
+	c.tokenMu.Unlock()
+	select {
+	case c.tokenChanged <- struct{}{}:
+	default:
+	}
+}
+
+// RefreshToken logs in again to get a new token, as the background refresh does.
+func (c *Client) RefreshToken() error {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+	return c.Login()
+}
+
+// reauthenticate logs in again after the server rejected a token, unless another request did
+// already.
+func (c *Client) reauthenticate(rejected string) error {
+	c.loginMu.Lock()
+	defer c.loginMu.Unlock()
+	if c.Token() != rejected {
+		return nil
+	}
+	log.Printf("Server rejected the token; logging in again")
+	return c.Login()
+}
+
+// refreshDelay returns how long to wait before refreshing the token, and false if it does not
+// need refreshing.
+func (c *Client) refreshDelay(retry bool) (time.Duration, bool) {
+	c.tokenMu.RLock()
+	token, obtainedAt, margin := c.jwtToken, c.tokenObtainedAt, c.tokenRefreshMargin
+	c.tokenMu.RUnlock()
+	expiry, ok := tokenExpiry(token)
+	if !ok || margin <= 0 {
+		return 0, false
+	}
+	lifetime := expiry.Sub(obtainedAt)
+	if retry || lifetime <= 0 {
+		return tokenRetryInterval, true
+	}
+	margin = min(margin, lifetime/2)
+	return max(expiry.Add(-margin).Sub(c.Now()), 0), true
+}
+
+// refreshTokens renews the token before it expires until the client disconnects. Failed
+// refreshes are retried every 30 seconds.
+func (c *Client) refreshTokens() {
+	retry := false
+	for {
+		var fire <-chan time.Time
+		var timer *time.Timer
+		if delay, ok := c.refreshDelay(retry); ok {
+			timer = time.NewTimer(delay)
+			fire = timer.C
+		}
+		select {
+		case <-c.doneCh:
+		case <-c.tokenChanged:
+			retry = false
+		case <-fire:
+			if err := c.RefreshToken(); err != nil {
+				log.Printf("Failed to refresh the token: %v", err)
+				retry = true
+			} else {
+				retry = false
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if c.closed() {
+			return
+		}
+	}
+}
+
+// doAuthorized sends a request with the token, if the client has one. If the server rejects
+// the token, the client logs in again and sends the request once more with the new one.
+func (c *Client) doAuthorized(req *http.Request) (*http.Response, error) {
+	token := c.Token()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || token == "" {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+	if err := c.reauthenticate(token); err != nil {
+		log.Printf("Login failed: %v", err)
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+c.Token())
+	return c.httpClient().Do(retry)
+}
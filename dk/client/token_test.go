@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tokenServer issues numbered tokens valid for lifetime, and only accepts the latest one on
+// its other endpoints.
+type tokenServer struct {
+	lifetime time.Duration
+	mu       sync.Mutex
+	issued   int
+	bodies   []string // Of the requests accepted with the latest token
+}
+
+func (s *tokenServer) token(n int) string {
+	claims := fmt.Sprintf(`{"sub":"alice","n":%d,"exp":%d}`, n, time.Now().Add(s.lifetime).Unix())
+	return "header." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+}
+
+func (s *tokenServer) logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.issued
+}
+
+func (s *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case r.URL.Path == "/auth/login" && r.URL.Query().Get("verify") == "true":
+		s.issued++
+		json.NewEncoder(w).Encode(map[string]string{"token": s.token(s.issued)})
+	case r.URL.Path == "/auth/login":
+		json.NewEncoder(w).Encode(map[string]string{"challenge": "challenge"})
+	default:
+		claims, _ := base64.RawURLEncoding.DecodeString(bearerClaims(r.Header.Get("Authorization")))
+		var token struct{ N int }
+		if json.Unmarshal(claims, &token) != nil || token.N != s.issued {
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		s.bodies = append(s.bodies, string(body))
+		w.Write([]byte("[]"))
+	}
+}
+
+// bearerClaims returns the encoded claims of the token of an Authorization header.
+func bearerClaims(header string) string {
+	parts := strings.Split(strings.TrimPrefix(header, "Bearer "), ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	return parts[1]
+}
+
+func TestTokenRefreshedBeforeExpiry(t *testing.T) {
+	server := &tokenServer{lifetime: 2 * time.Second}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	defer c.Disconnect()
+	if err := c.Login(); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	first := c.Token()
+	if expiry, ok := c.TokenExpiry(); !ok || time.Until(expiry) > 2*time.Second {
+		t.Fatalf("Expected the token to expire within 2 seconds, got %v", expiry)
+	}
+
+	// The token is renewed halfway through its lifetime, shorter than the default margin
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Token() == first {
+		if time.Now().After(deadline) {
+			t.Fatal("The token was not refreshed before it expired")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := c.GetHistory("bob", time.Time{}, 0); err != nil {
+		t.Errorf("Expected the refreshed token to be accepted, got %v", err)
+	}
+
+	// Without a margin the token is not refreshed any more
+	c.SetTokenRefreshMargin(0)
+	logins := server.logins()
+	time.Sleep(1500 * time.Millisecond)
+	if server.logins() != logins {
+		t.Errorf("Expected no refresh with the refresh disabled, got %d logins", server.logins()-logins)
+	}
+}
+
+func TestRejectedTokenLogsInAgain(t *testing.T) {
+	server := &tokenServer{lifetime: time.Hour}
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	c := NewClient(srv.URL, "alice", priv, pub)
+	defer c.Disconnect()
+	c.SetTokenRefreshMargin(0)
+	if err := c.Login(); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	// The server forgets the token, e.g. after a restart with a new signing key
+	server.mu.Lock()
+	server.issued++
+	server.mu.Unlock()
+
+	if err := c.SetUserDescriptions([]string{"library"}); err != nil {
+		t.Fatalf("Expected the request to be sent again with a new token, got %v", err)
+	}
+	if server.logins() != 3 || len(server.bodies) != 1 || server.bodies[0] != `["library"]` {
+		t.Errorf("Expected one login and the request sent again with its body, got %d logins and %q", server.logins(), server.bodies)
+	}
+}
@@ -0,0 +1,63 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// PeerAnswer is an answer a peer sent back to one of our questions
+type PeerAnswer struct {
+	Question   string
+	From       string
+	Answer     string
+	ReceivedAt time.Time
+}
+
+// answerWatchers holds the channels waiting for answers, by question
+var answerWatchers = struct {
+	sync.Mutex
+	byQuestion map[string][]chan PeerAnswer
+}{byQuestion: make(map[string][]chan PeerAnswer)}
+
+// WatchAnswers returns a channel that receives the answers peers send to a question from now
+// on. Call stop once done; it closes the channel. Answers are dropped while the channel is
+// full, so a slow reader never holds up incoming messages.
+func WatchAnswers(question string) (answers <-chan PeerAnswer, stop func()) {
+	ch := make(chan PeerAnswer, 16)
+	answerWatchers.Lock()
+	answerWatchers.byQuestion[question] = append(answerWatchers.byQuestion[question], ch)
+	answerWatchers.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			answerWatchers.Lock()
+			defer answerWatchers.Unlock()
+			watchers := answerWatchers.byQuestion[question]
+			for i, w := range watchers {
+				if w == ch {
+					watchers = append(watchers[:i], watchers[i+1:]...)
+					break
+				}
+			}
+			if len(watchers) == 0 {
+				delete(answerWatchers.byQuestion, question)
+			} else {
+				answerWatchers.byQuestion[question] = watchers
+			}
+			close(ch)
+		})
+	}
+}
+
+// notifyAnswer hands an incoming answer to the channels watching its question
+func notifyAnswer(answer PeerAnswer) {
+	answerWatchers.Lock()
+	defer answerWatchers.Unlock()
+	for _, ch := range answerWatchers.byQuestion[answer.Question] {
+		select {
+		case ch <- answer:
+		default:
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package core
+
+import (
+	"context"
+	dk_client "dk/client"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestWatchAnswers(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := utils.WithDatabase(context.Background(), testDB.DB)
+
+	answer := func(from, question, text string) dk_client.Message {
+		payload, _ := json.Marshal(utils.AnswerMessage{Query: question, Answer: text, From: from})
+		content, _ := json.Marshal(utils.RemoteMessage{Type: "answer", Message: string(payload)})
+		return dk_client.Message{From: from, Content: string(content)}
+	}
+
+	answers, stop := WatchAnswers("Is the release ready?")
+	other, stopOther := WatchAnswers("How many open tickets?")
+	defer stopOther()
+
+	if _, err := HandleAnswer(ctx, answer("bob", "Is the release ready?", "Yes")); err != nil {
+		t.Fatalf("HandleAnswer failed: %v", err)
+	}
+	select {
+	case got := <-answers:
+		if got.From != "bob" || got.Answer != "Yes" {
+			t.Errorf("Unexpected answer: %+v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("The answer was not delivered to the watcher")
+	}
+	select {
+	case got := <-other:
+		t.Errorf("Answer to another question was delivered: %+v", got)
+	default:
+	}
+
+	// Stopping closes the channel, and later answers are not delivered
+	stop()
+	stop()
+	if _, ok := <-answers; ok {
+		t.Error("Expected the channel to be closed")
+	}
+	if _, err := HandleAnswer(ctx, answer("carol", "Is the release ready?", "No")); err != nil {
+		t.Fatalf("HandleAnswer failed: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package core
+
+import (
+	"compress/gzip"
+	"context"
+	dk_client "dk/client"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Metadata of the file offers of apps. An app travels as a gzipped JSON object of its files by
+// path, sent with the chunked file transfer of the client.
+const (
+	fileTypeKey        = "type"
+	fileDescriptionKey = "description"
+	appFileType        = "app"
+)
+
+// appArchiveTTL is how long an app archive is kept for peers to request it
+const appArchiveTTL = 24 * time.Hour
+
+// transfersDir returns the directory files sent to and received from peers are kept in while
+// they are transferred: the "transfers" directory next to the database
+func transfersDir(ctx context.Context, sub string) (string, error) {
+	params, err := utils.ParamsFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	if params.DBPath == nil || *params.DBPath == "" {
+		return "", errors.New("no data directory to keep file transfers in")
+	}
+	dir := filepath.Join(filepath.Dir(*params.DBPath), "transfers", sub)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	return dir, nil
+}
+
+// writeAppArchive packs the files of an app into an archive in the outgoing transfers
+// directory, removing the archives old enough that no peer requests them anymore
+func writeAppArchive(ctx context.Context, appPath string) (string, error) {
+	files, err := ScanDirToMap(ctx, appPath)
+	if err != nil {
+		return "", err
+	}
+	if len(files) == 0 {
+		return "", fmt.Errorf("%s has no files", appPath)
+	}
+	dir, err := transfersDir(ctx, "outgoing")
+	if err != nil {
+		return "", err
+	}
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, entry := range entries {
+			if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > appArchiveTTL {
+				os.Remove(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+
+	f, err := os.CreateTemp(dir, filepath.Base(appPath)+"-*.json.gz")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	if err := json.NewEncoder(zw).Encode(files); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// SendApplication sends an app folder to peers, or to every online user when no peer is given.
+// Each peer receives it as a file transfer, in encrypted chunks it can resume, and records it
+// as an app request.
+func SendApplication(ctx context.Context, peers []string, appPath, description string) error {
+	client, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if len(peers) == 0 {
+		users, err := client.GetActiveUsers()
+		if err != nil {
+			return fmt.Errorf("failed to list online users: %w", err)
+		}
+		for _, user := range users.Online {
+			if user != client.UserID {
+				peers = append(peers, user)
+			}
+		}
+		if len(peers) == 0 {
+			return errors.New("no online users to send the app to")
+		}
+	}
+
+	archive, err := writeAppArchive(ctx, appPath)
+	if err != nil {
+		return err
+	}
+	metadata := map[string]string{fileTypeKey: appFileType, fileDescriptionKey: description}
+	for _, peer := range peers {
+		if _, err := client.SendFile(peer, archive, metadata); err != nil {
+			return fmt.Errorf("failed to send the app to %s: %w", peer, err)
+		}
+	}
+	return nil
+}
+
+// HandleFileOffers receives the files peers offer. Apps are received and recorded as app
+// requests; other files are ignored, since nothing asked for them.
+func HandleFileOffers(ctx context.Context) {
+	client, err := utils.DkFromContext(ctx)
+	if err != nil {
+		log.Printf("[Files] Error getting client from context: %v", err)
+		return
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case offer := <-client.FileOffers():
+			if offer.Metadata[fileTypeKey] != appFileType {
+				log.Printf("[Files] Ignoring %s offered by %s", offer.Name, offer.From)
+				continue
+			}
+			go func() {
+				if err := receiveApplication(ctx, client, offer); err != nil {
+					log.Printf("[Files] Failed to receive the app from %s: %v", offer.From, err)
+				}
+			}()
+		}
+	}
+}
+
+// receiveApplication receives an app offered by a peer and records its request
+func receiveApplication(ctx context.Context, client *dk_client.Client, offer dk_client.FileOffer) error {
+	dir, err := transfersDir(ctx, "incoming")
+	if err != nil {
+		return err
+	}
+	// The sender serves the archive as long as it keeps it
+	ctx, cancel := context.WithTimeout(ctx, appArchiveTTL)
+	defer cancel()
+	path, err := client.ReceiveFile(ctx, offer, dir)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("invalid app archive: %w", err)
+	}
+	// The archive was checked against the limit of the transfer, its content is bounded too
+	var files map[string]string
+	if err := json.NewDecoder(io.LimitReader(zr, dk_client.MaxFileSize)).Decode(&files); err != nil {
+		return fmt.Errorf("invalid app archive: %w", err)
+	}
+	log.Printf("[Files] Received app %s (%d files) from %s", offer.Name, len(files), offer.From)
+	return saveApplicationRequest(ctx, offer.From, offer.Metadata[fileDescriptionKey], files)
+}
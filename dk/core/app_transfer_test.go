@@ -0,0 +1,52 @@
+package core
+
+import (
+	"compress/gzip"
+	"context"
+	"dk/utils"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteAppArchive(t *testing.T) {
+	dir := t.TempDir()
+	appPath := filepath.Join(dir, "my_app")
+	if err := os.MkdirAll(filepath.Join(appPath, "src"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(appPath, "run.sh"), []byte("#!/bin/sh\n"), 0700)
+	os.WriteFile(filepath.Join(appPath, "src", "main.py"), []byte("print('hi')\n"), 0600)
+
+	dbPath := filepath.Join(dir, "node", "app.db")
+	ctx := utils.WithParams(context.Background(), utils.Parameters{DBPath: &dbPath})
+	archive, err := writeAppArchive(ctx, appPath)
+	if err != nil {
+		t.Fatalf("writeAppArchive failed: %v", err)
+	}
+	if want := filepath.Join(dir, "node", "transfers", "outgoing"); filepath.Dir(archive) != want {
+		t.Errorf("Expected the archive in %s, got %s", want, archive)
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Archive is not gzipped: %v", err)
+	}
+	var files map[string]string
+	if err := json.NewDecoder(zr).Decode(&files); err != nil {
+		t.Fatalf("Archive is not a JSON object of files: %v", err)
+	}
+	if len(files) != 2 || files[filepath.Join("my_app", "src", "main.py")] != "print('hi')\n" {
+		t.Errorf("Unexpected files %v", files)
+	}
+
+	if _, err := writeAppArchive(ctx, t.TempDir()); err == nil {
+		t.Error("Expected an empty folder to be refused")
+	}
+}
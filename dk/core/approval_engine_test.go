@@ -0,0 +1,72 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"testing"
+)
+
+func TestEvaluateApprovalConditions(t *testing.T) {
+	ctx := context.Background()
+	conditions := []string{"Accept questions from @alice about the roadmap", "Reject questions about salaries"}
+	query := Query{From: "bob", Question: "What is the CEO's salary?", Answer: "It is confidential."}
+
+	provider := &stubProvider{answer: "Decision: Reject\nCondition: 2\nReason: The question asks about salaries.\n"}
+	evaluation, err := evaluateApprovalConditions(ctx, provider, query, conditions)
+	if err != nil {
+		t.Fatalf("evaluateApprovalConditions failed: %v", err)
+	}
+	if evaluation.Decision != db.ApprovalReject || evaluation.Condition != conditions[1] || evaluation.Reason != "The question asks about salaries." {
+		t.Errorf("Unexpected evaluation: %+v", evaluation)
+	}
+
+	// A decision that does not name a valid condition leaves the query to the host
+	for _, reply := range []string{
+		"Decision: accept\nCondition: 7\nReason: Looks fine.",
+		"Decision: accept\nCondition: none\nReason: Looks fine.",
+		"Decision: none\nCondition: none\nReason: No condition applies.",
+		"I would approve this.",
+	} {
+		evaluation, err := evaluateApprovalConditions(ctx, &stubProvider{answer: reply}, query, conditions)
+		if err != nil || evaluation.Decision != db.ApprovalNone || evaluation.Condition != "" || evaluation.Reason == "" {
+			t.Errorf("Expected no decision for %q, got %+v, %v", reply, evaluation, err)
+		}
+	}
+
+	provider = &stubProvider{answer: "Decision: accept\nCondition: 1\nReason: Matches."}
+	if evaluation, _ := evaluateApprovalConditions(ctx, provider, query, nil); evaluation.Decision != db.ApprovalNone || provider.calls.Load() != 0 {
+		t.Errorf("Expected no evaluation without conditions, got %+v", evaluation)
+	}
+}
+
+func TestApprovalDecisionsAudit(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := context.Background()
+
+	for _, d := range []db.ApprovalDecision{
+		{QueryID: "qry-1", Decision: db.ApprovalAccept, Condition: "Accept questions from @alice", Reason: "From alice."},
+		{QueryID: "qry-2", Decision: db.ApprovalNone, Reason: "No condition applies"},
+	} {
+		if err := db.InsertApprovalDecision(ctx, testDB.DB, d); err != nil {
+			t.Fatalf("InsertApprovalDecision failed: %v", err)
+		}
+	}
+	decisions, err := db.ListApprovalDecisions(ctx, testDB.DB, "", 0)
+	if err != nil {
+		t.Fatalf("ListApprovalDecisions failed: %v", err)
+	}
+	if len(decisions) != 2 || decisions[0].QueryID != "qry-2" || decisions[0].CreatedAt.IsZero() {
+		t.Errorf("Expected the newest decision first, got %+v", decisions)
+	}
+	decisions, err = db.ListApprovalDecisions(ctx, testDB.DB, "qry-1", 10)
+	if err != nil || len(decisions) != 1 || decisions[0].Condition != "Accept questions from @alice" {
+		t.Errorf("Unexpected decisions of qry-1: %+v, %v", decisions, err)
+	}
+}
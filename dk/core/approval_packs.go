@@ -0,0 +1,342 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"dk/db"
+	"dk/utils"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ApprovalPackFormat identifies approval pack files
+const ApprovalPackFormat = "dk-approval-pack"
+
+// approvalPackVersion is the version of the pack format written by this build
+const approvalPackVersion = 1
+
+// defaultPackPreviewLimit is the number of recent queries a pack is previewed against
+const defaultPackPreviewLimit = 20
+
+// ErrInvalidPackSignature is returned when a pack's signature does not match its contents
+var ErrInvalidPackSignature = errors.New("invalid approval pack signature")
+
+// ApprovalPack is a shareable, signed set of automatic approval conditions
+type ApprovalPack struct {
+	Format      string    `json:"format"`
+	Version     int       `json:"version"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Author      string    `json:"author"`     // User ID of the signer
+	PublicKey   string    `json:"public_key"` // Base64 ed25519 public key of the signer
+	CreatedAt   time.Time `json:"created_at"`
+	Conditions  []string  `json:"conditions"`
+	// AppApprovalRules is reserved for application approval rules; they are not applied yet.
+	AppApprovalRules []string `json:"app_approval_rules,omitempty"`
+	Signature        string   `json:"signature,omitempty"` // Base64 signature over the pack without this field
+}
+
+// PackVerification describes who signed a pack and whether the signature can be trusted
+type PackVerification struct {
+	Author         string `json:"author"`
+	Fingerprint    string `json:"fingerprint"`     // SHA-256 of the signer's public key
+	AuthorVerified bool   `json:"author_verified"` // The key matches the author's key on the server
+	Warning        string `json:"warning,omitempty"`
+}
+
+// PackPreviewItem is the outcome of a pack's conditions for one historical query
+type PackPreviewItem struct {
+	QueryID      string `json:"query_id"`
+	Question     string `json:"question"`
+	Status       string `json:"status"` // Status the query actually has
+	WouldApprove bool   `json:"would_approve"`
+	Reason       string `json:"reason"`
+}
+
+// PackPreview summarizes what applying a pack would have done to recent queries
+type PackPreview struct {
+	Pack              string            `json:"pack"`
+	Verification      PackVerification  `json:"verification"`
+	NewConditions     []string          `json:"new_conditions"`     // Conditions not yet installed
+	Evaluated         int               `json:"evaluated"`          // Queries evaluated
+	WouldApprove      int               `json:"would_approve"`      // Queries the pack would approve
+	WouldApproveFresh int               `json:"would_approve_new"`  // ... that are not accepted today
+	RejectedConflicts int               `json:"rejected_conflicts"` // ... that were manually rejected
+	Items             []PackPreviewItem `json:"items"`
+}
+
+// PackImportResult reports the outcome of importing a pack
+type PackImportResult struct {
+	Pack         string           `json:"pack"`
+	Verification PackVerification `json:"verification"`
+	Added        []string         `json:"added"`
+	Skipped      []string         `json:"skipped"` // Conditions that were already installed
+	Ignored      int              `json:"ignored_app_rules,omitempty"`
+}
+
+// signingPayload returns the bytes a pack's signature covers: its JSON without the signature
+func (p ApprovalPack) signingPayload() ([]byte, error) {
+	p.Signature = ""
+	return json.Marshal(p)
+}
+
+// SignApprovalPack fills in the signer fields of a pack and signs it
+func SignApprovalPack(pack *ApprovalPack, author string, publicKey ed25519.PublicKey, sign func([]byte) []byte) error {
+	pack.Format = ApprovalPackFormat
+	pack.Version = approvalPackVersion
+	pack.Author = author
+	pack.PublicKey = base64.StdEncoding.EncodeToString(publicKey)
+	if pack.CreatedAt.IsZero() {
+		pack.CreatedAt = time.Now().UTC()
+	}
+
+	payload, err := pack.signingPayload()
+	if err != nil {
+		return fmt.Errorf("failed to encode approval pack: %w", err)
+	}
+	pack.Signature = base64.StdEncoding.EncodeToString(sign(payload))
+	return nil
+}
+
+// VerifyApprovalPackSignature checks that a pack is well formed and was signed with the key it
+// carries. It returns the signer's public key.
+func VerifyApprovalPackSignature(pack *ApprovalPack) (ed25519.PublicKey, error) {
+	if pack.Format != ApprovalPackFormat {
+		return nil, fmt.Errorf("not an approval pack (format %q)", pack.Format)
+	}
+	if pack.Version > approvalPackVersion {
+		return nil, fmt.Errorf("approval pack version %d is not supported", pack.Version)
+	}
+
+	publicKey, err := base64.StdEncoding.DecodeString(pack.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("approval pack has an invalid public key")
+	}
+	signature, err := base64.StdEncoding.DecodeString(pack.Signature)
+	if err != nil || len(signature) == 0 {
+		return nil, ErrInvalidPackSignature
+	}
+
+	payload, err := pack.signingPayload()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode approval pack: %w", err)
+	}
+	if !ed25519.Verify(publicKey, payload, signature) {
+		return nil, ErrInvalidPackSignature
+	}
+	return publicKey, nil
+}
+
+// ParseApprovalPack decodes a pack from JSON
+func ParseApprovalPack(data []byte) (*ApprovalPack, error) {
+	var pack ApprovalPack
+	if err := json.Unmarshal(data, &pack); err != nil {
+		return nil, fmt.Errorf("failed to parse approval pack: %w", err)
+	}
+	return &pack, nil
+}
+
+// ExportApprovalPack packs the installed automatic approval conditions and signs the pack with
+// the client's key
+func ExportApprovalPack(ctx context.Context, name, description string) (*ApprovalPack, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions, err := db.ListRules(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	if len(conditions) == 0 {
+		return nil, fmt.Errorf("there are no automatic approval conditions to export")
+	}
+
+	pack := &ApprovalPack{Name: name, Description: description, Conditions: conditions}
+	if err := SignApprovalPack(pack, client.UserID, client.PublicKey(), client.Sign); err != nil {
+		return nil, err
+	}
+	return pack, nil
+}
+
+// VerifyApprovalPack checks a pack's signature and, when the network is reachable, that the
+// signing key belongs to the pack's author. A key that does not match the author's is an error.
+func VerifyApprovalPack(ctx context.Context, pack *ApprovalPack) (PackVerification, error) {
+	publicKey, err := VerifyApprovalPackSignature(pack)
+	if err != nil {
+		return PackVerification{}, err
+	}
+
+	sum := sha256.Sum256(publicKey)
+	verification := PackVerification{Author: pack.Author, Fingerprint: hex.EncodeToString(sum[:])}
+
+	client, err := utils.DkFromContext(ctx)
+	if err != nil {
+		verification.Warning = "author could not be verified: not connected to the network"
+		return verification, nil
+	}
+	registered, err := client.GetUserPublicKey(pack.Author)
+	if err != nil {
+		verification.Warning = fmt.Sprintf("author could not be verified: %v", err)
+		return verification, nil
+	}
+	if !bytes.Equal(registered, publicKey) {
+		return verification, fmt.Errorf("approval pack was not signed by its author %s", pack.Author)
+	}
+	verification.AuthorVerified = true
+	return verification, nil
+}
+
+// PreviewApprovalPack evaluates a pack's conditions against the most recent answered queries
+// without installing them
+func PreviewApprovalPack(ctx context.Context, pack *ApprovalPack, limit int) (*PackPreview, error) {
+	verification, err := VerifyApprovalPack(ctx, pack)
+	if err != nil {
+		return nil, err
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	llmProvider, err := LLMProviderFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	installed, err := db.ListRules(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	preview := &PackPreview{
+		Pack:          pack.Name,
+		Verification:  verification,
+		NewConditions: newConditions(pack.Conditions, installed),
+		Items:         []PackPreviewItem{},
+	}
+
+	queries, err := db.ListQueries(ctx, database, "", "")
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultPackPreviewLimit
+	}
+
+	for _, q := range queries {
+		if preview.Evaluated >= limit {
+			break
+		}
+		if strings.TrimSpace(q.Answer) == "" {
+			continue
+		}
+
+		query := Query{
+			ID:               q.ID,
+			From:             q.From,
+			Question:         q.Question,
+			Answer:           q.Answer,
+			DocumentsRelated: q.DocumentsRelated,
+			Status:           q.Status,
+		}
+		evaluation, err := evaluateApprovalConditions(ctx, llmProvider, query, pack.Conditions)
+		if err != nil {
+			log.Printf("[Approval] Failed to preview pack %q on query %s: %v", pack.Name, q.ID, err)
+			continue
+		}
+
+		preview.Evaluated++
+		approve := evaluation.Decision == db.ApprovalAccept
+		if approve {
+			preview.WouldApprove++
+			if !strings.EqualFold(q.Status, "accepted") {
+				preview.WouldApproveFresh++
+			}
+			if strings.EqualFold(q.Status, "rejected") {
+				preview.RejectedConflicts++
+			}
+		}
+		preview.Items = append(preview.Items, PackPreviewItem{
+			QueryID:      q.ID,
+			Question:     q.Question,
+			Status:       q.Status,
+			WouldApprove: approve,
+			Reason:       evaluation.Reason,
+		})
+	}
+	return preview, nil
+}
+
+// ImportApprovalPack verifies a pack and installs the conditions that are not installed yet
+func ImportApprovalPack(ctx context.Context, pack *ApprovalPack) (*PackImportResult, error) {
+	verification, err := VerifyApprovalPack(ctx, pack)
+	if err != nil {
+		return nil, err
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	installed, err := db.ListRules(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PackImportResult{
+		Pack:         pack.Name,
+		Verification: verification,
+		Added:        []string{},
+		Skipped:      []string{},
+		Ignored:      len(pack.AppApprovalRules),
+	}
+	for _, condition := range newConditions(pack.Conditions, installed) {
+		if err := db.InsertRule(ctx, database, condition); err != nil {
+			return result, fmt.Errorf("failed to add condition %q: %w", condition, err)
+		}
+		result.Added = append(result.Added, condition)
+	}
+	for _, condition := range pack.Conditions {
+		if !containsCondition(result.Added, condition) && strings.TrimSpace(condition) != "" {
+			result.Skipped = append(result.Skipped, condition)
+		}
+	}
+
+	log.Printf("[Approval] Imported pack %q by %s: %d conditions added, %d already installed",
+		pack.Name, pack.Author, len(result.Added), len(result.Skipped))
+	return result, nil
+}
+
+// newConditions returns the non-empty conditions that are not installed, without duplicates
+func newConditions(conditions, installed []string) []string {
+	out := []string{}
+	for _, condition := range conditions {
+		condition = strings.TrimSpace(condition)
+		if condition == "" || containsCondition(installed, condition) || containsCondition(out, condition) {
+			continue
+		}
+		out = append(out, condition)
+	}
+	return out
+}
+
+func containsCondition(conditions []string, condition string) bool {
+	for _, c := range conditions {
+		if strings.TrimSpace(c) == strings.TrimSpace(condition) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestApprovalPackSignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	sign := func(data []byte) []byte { return ed25519.Sign(privateKey, data) }
+
+	pack := &ApprovalPack{Name: "research", Conditions: []string{"Approve questions about published papers"}}
+	if err := SignApprovalPack(pack, "alice", publicKey, sign); err != nil {
+		t.Fatalf("Failed to sign pack: %v", err)
+	}
+
+	data, err := json.Marshal(pack)
+	if err != nil {
+		t.Fatalf("Failed to encode pack: %v", err)
+	}
+	parsed, err := ParseApprovalPack(data)
+	if err != nil {
+		t.Fatalf("Failed to parse pack: %v", err)
+	}
+	if _, err := VerifyApprovalPackSignature(parsed); err != nil {
+		t.Fatalf("Expected a valid signature, got %v", err)
+	}
+
+	parsed.Conditions = append(parsed.Conditions, "Approve everything")
+	if _, err := VerifyApprovalPackSignature(parsed); !errors.Is(err, ErrInvalidPackSignature) {
+		t.Errorf("Expected a tampered pack to be rejected, got %v", err)
+	}
+}
+
+func TestNewConditions(t *testing.T) {
+	got := newConditions([]string{"a", " b ", "a", "", "c"}, []string{"c"})
+	if len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Unexpected new conditions %q", got)
+	}
+}
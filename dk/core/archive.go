@@ -0,0 +1,386 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"dk/db"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Formats of message archives
+const (
+	ArchiveJSONL = "jsonl" // One JSON record per line, described by schema.json
+	ArchiveMbox  = "mbox"  // One mboxrd mailbox per part
+)
+
+const (
+	archiveSchemaVersion   = 1
+	archiveManifestFile    = "manifest.json"
+	archiveSchemaFile      = "schema.json"
+	archiveChecksumsFile   = "SHA256SUMS"
+	defaultArchivePartSize = 10000
+)
+
+// ErrArchiveMismatch is returned when resuming an export into a directory that holds an
+// archive written with other options, or whose parts were modified
+var ErrArchiveMismatch = errors.New("archive does not match")
+
+// ArchiveOptions selects what an export writes and where
+type ArchiveOptions struct {
+	Dir      string    // Directory of the archive; an export into it resumes where it stopped
+	Format   string    // ArchiveJSONL or ArchiveMbox
+	Identity string    // Local user, the recipient of the archived messages
+	Peer     string    // Only messages exchanged with this peer
+	Since    time.Time // Received at or after; zero for no bound
+	Until    time.Time // Received before; defaults to the time the export started
+	PartSize int       // Messages per file; defaults to 10000
+}
+
+// ArchivePart is a file of an archive
+type ArchivePart struct {
+	File    string `json:"file"`
+	Records int    `json:"records"`
+	SHA256  string `json:"sha256"`
+}
+
+// ArchiveManifest describes an archive and how far its export got. It is rewritten after
+// every part, so an interrupted export continues after the last complete part.
+type ArchiveManifest struct {
+	SchemaVersion int              `json:"schema_version"`
+	Format        string           `json:"format"`
+	Identity      string           `json:"identity"`
+	Peer          string           `json:"peer,omitempty"`
+	Since         *time.Time       `json:"since,omitempty"`
+	Until         time.Time        `json:"until"`
+	StartedAt     time.Time        `json:"started_at"`
+	CompletedAt   *time.Time       `json:"completed_at,omitempty"`
+	Schema        *ArchivePart     `json:"schema,omitempty"`
+	Parts         []ArchivePart    `json:"parts"`
+	Records       int              `json:"records"`
+	Cursor        db.ArchiveCursor `json:"cursor"`
+}
+
+// archiveRecord is a line of a JSONL archive
+type archiveRecord struct {
+	Kind      string    `json:"kind"`
+	ID        string    `json:"id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Timestamp time.Time `json:"timestamp"`
+	Question  string    `json:"question"`
+	Answer    string    `json:"answer,omitempty"`
+	Status    string    `json:"status,omitempty"`
+}
+
+// archiveSchema is the JSON Schema of the records of a JSONL archive
+const archiveSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "$id": "https://github.com/OpenMined/DistributedKnowledge/schemas/message-archive-v1.json",
+  "title": "DistributedKnowledge archived message",
+  "type": "object",
+  "required": ["kind", "id", "from", "to", "timestamp", "question"],
+  "properties": {
+    "kind": {"enum": ["query", "answer"], "description": "A query a peer sent, or a peer's answer to a query of the local user"},
+    "id": {"type": "string"},
+    "from": {"type": "string", "description": "Sending peer"},
+    "to": {"type": "string", "description": "Local user"},
+    "timestamp": {"type": "string", "format": "date-time"},
+    "question": {"type": "string"},
+    "answer": {"type": "string", "description": "For queries, the answer prepared by the local user"},
+    "status": {"type": "string", "description": "Review status of a query, e.g. pending or accepted"}
+  }
+}
+`
+
+// ExportArchive writes the messages kept in the local database to an archive in
+// options.Dir. The archive is split into parts whose SHA-256 hashes are recorded in the
+// manifest and, once complete, in a SHA256SUMS file. Exporting into a directory with an
+// incomplete archive verifies the parts written so far and continues after them.
+func ExportArchive(ctx context.Context, database *sql.DB, options ArchiveOptions) (*ArchiveManifest, error) {
+	if options.Format != ArchiveJSONL && options.Format != ArchiveMbox {
+		return nil, fmt.Errorf("unknown archive format %q", options.Format)
+	}
+	if options.Dir == "" {
+		return nil, errors.New("archive directory is required")
+	}
+	if options.PartSize <= 0 {
+		options.PartSize = defaultArchivePartSize
+	}
+	if err := os.MkdirAll(options.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	manifest, err := readArchiveManifest(options.Dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if manifest, err = startArchive(options); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		if err := manifest.check(options); err != nil {
+			return nil, err
+		}
+	}
+	if manifest.CompletedAt != nil {
+		return manifest, nil
+	}
+
+	filter := db.ArchiveFilter{Peer: manifest.Peer, Until: manifest.Until}
+	if manifest.Since != nil {
+		filter.Since = *manifest.Since
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return manifest, err
+		}
+		messages, err := db.ListArchivedMessages(ctx, database, filter, manifest.Cursor, options.PartSize)
+		if err != nil {
+			return manifest, err
+		}
+		if len(messages) > 0 {
+			name := fmt.Sprintf("messages-%06d.%s", len(manifest.Parts)+1, manifest.Format)
+			part, err := writeArchiveFile(options.Dir, name, func(w io.Writer) error {
+				return writeArchiveMessages(w, manifest.Format, manifest.Identity, messages)
+			})
+			if err != nil {
+				return manifest, err
+			}
+			last := messages[len(messages)-1]
+			part.Records = len(messages)
+			manifest.Parts = append(manifest.Parts, part)
+			manifest.Records += len(messages)
+			manifest.Cursor = db.ArchiveCursor{Timestamp: last.Timestamp, Kind: last.Kind, ID: last.ID}
+		}
+		if len(messages) < options.PartSize {
+			break
+		}
+		if err := writeArchiveManifest(options.Dir, manifest); err != nil {
+			return manifest, err
+		}
+	}
+
+	var sums strings.Builder
+	if manifest.Schema != nil {
+		fmt.Fprintf(&sums, "%s  %s\n", manifest.Schema.SHA256, manifest.Schema.File)
+	}
+	for _, part := range manifest.Parts {
+		fmt.Fprintf(&sums, "%s  %s\n", part.SHA256, part.File)
+	}
+	if _, err := writeArchiveFile(options.Dir, archiveChecksumsFile, func(w io.Writer) error {
+		_, err := io.WriteString(w, sums.String())
+		return err
+	}); err != nil {
+		return manifest, err
+	}
+	completedAt := time.Now().UTC()
+	manifest.CompletedAt = &completedAt
+	return manifest, writeArchiveManifest(options.Dir, manifest)
+}
+
+// startArchive creates the manifest of a new archive and writes its schema
+func startArchive(options ArchiveOptions) (*ArchiveManifest, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	manifest := &ArchiveManifest{
+		SchemaVersion: archiveSchemaVersion,
+		Format:        options.Format,
+		Identity:      options.Identity,
+		Peer:          options.Peer,
+		Until:         options.Until.UTC(),
+		StartedAt:     now,
+		Parts:         []ArchivePart{},
+	}
+	if !options.Since.IsZero() {
+		since := options.Since.UTC()
+		manifest.Since = &since
+	}
+	// Later messages are left out, so a resumed export sees the same history
+	if options.Until.IsZero() {
+		manifest.Until = now
+	}
+	if options.Format == ArchiveJSONL {
+		schema, err := writeArchiveFile(options.Dir, archiveSchemaFile, func(w io.Writer) error {
+			_, err := io.WriteString(w, archiveSchema)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		manifest.Schema = &schema
+	}
+	return manifest, writeArchiveManifest(options.Dir, manifest)
+}
+
+// check verifies that an existing archive was written with the given options and that its
+// files are unchanged
+func (m *ArchiveManifest) check(options ArchiveOptions) error {
+	var since time.Time
+	if m.Since != nil {
+		since = *m.Since
+	}
+	switch {
+	case m.Format != options.Format:
+		return fmt.Errorf("%w: it has format %s", ErrArchiveMismatch, m.Format)
+	case m.Identity != options.Identity:
+		return fmt.Errorf("%w: it belongs to %s", ErrArchiveMismatch, m.Identity)
+	case m.Peer != options.Peer || !since.Equal(options.Since) || (!options.Until.IsZero() && !m.Until.Equal(options.Until)):
+		return fmt.Errorf("%w: it was started with another peer or date range", ErrArchiveMismatch)
+	}
+
+	files := m.Parts
+	if m.Schema != nil {
+		files = append([]ArchivePart{*m.Schema}, files...)
+	}
+	for _, part := range files {
+		sum, err := fileSHA256(filepath.Join(options.Dir, part.File))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrArchiveMismatch, err)
+		}
+		if sum != part.SHA256 {
+			return fmt.Errorf("%w: %s was modified", ErrArchiveMismatch, part.File)
+		}
+	}
+	return nil
+}
+
+func readArchiveManifest(dir string) (*ArchiveManifest, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, archiveManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var manifest ArchiveManifest
+	if err := json.Unmarshal(raw, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid archive manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+func writeArchiveManifest(dir string, manifest *ArchiveManifest) error {
+	_, err := writeArchiveFile(dir, archiveManifestFile, func(w io.Writer) error {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(manifest)
+	})
+	return err
+}
+
+// writeArchiveFile writes a file of the archive through a temporary file, so an interrupted
+// export never leaves a partly written file under its final name, and returns its hash
+func writeArchiveFile(dir, name string, write func(io.Writer) error) (ArchivePart, error) {
+	tmp, err := os.CreateTemp(dir, "."+name+".*")
+	if err != nil {
+		return ArchivePart{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(tmp, h))
+	if err := write(w); err != nil {
+		tmp.Close()
+		return ArchivePart{}, err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return ArchivePart{}, err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return ArchivePart{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return ArchivePart{}, err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, name)); err != nil {
+		return ArchivePart{}, err
+	}
+	return ArchivePart{File: name, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeArchiveMessages writes messages in the format of the archive
+func writeArchiveMessages(w io.Writer, format, identity string, messages []db.ArchivedMessage) error {
+	e := json.NewEncoder(w)
+	e.SetEscapeHTML(false)
+	for _, m := range messages {
+		var err error
+		if format == ArchiveJSONL {
+			err = e.Encode(archiveRecord{
+				Kind:      m.Kind,
+				ID:        m.ID,
+				From:      m.Peer,
+				To:        identity,
+				Timestamp: m.Timestamp,
+				Question:  m.Question,
+				Answer:    m.Answer,
+				Status:    m.Status,
+			})
+		} else {
+			err = writeMboxMessage(w, identity, m)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMboxMessage writes a message in mboxrd format: body lines starting with "From ",
+// possibly after '>' characters, get another '>' prepended
+func writeMboxMessage(w io.Writer, identity string, m db.ArchivedMessage) error {
+	subject := "Query: "
+	if m.Kind == "answer" {
+		subject = "Re: "
+	}
+	subject += strings.SplitN(strings.TrimSpace(m.Question), "\n", 2)[0]
+	if r := []rune(subject); len(r) > 78 {
+		subject = string(r[:77]) + "…"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From %s %s\n", m.Peer, m.Timestamp.UTC().Format(time.ANSIC))
+	fmt.Fprintf(&b, "From: %s\nTo: %s\nDate: %s\nSubject: %s\nMessage-ID: <%s@distributedknowledge>\n",
+		m.Peer, identity, m.Timestamp.UTC().Format(time.RFC1123Z), subject, m.ID)
+	fmt.Fprintf(&b, "X-DK-Kind: %s\n", m.Kind)
+	if m.Status != "" {
+		fmt.Fprintf(&b, "X-DK-Status: %s\n", m.Status)
+	}
+	b.WriteString("Content-Type: text/plain; charset=utf-8\n\n")
+
+	body := "Question:\n" + m.Question
+	if m.Answer != "" {
+		body += "\n\nAnswer:\n" + m.Answer
+	}
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(strings.TrimLeft(line, ">"), "From ") {
+			b.WriteByte('>')
+		}
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,111 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportArchive(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	for _, stmt := range []string{
+		`INSERT INTO queries (id, from_source, question, answer, documents_related, status, reason, created_at)
+		 VALUES ('qry-1', 'bob', 'What is DK?', 'A network', '[]', 'accepted', '', '2024-03-01 10:00:00')`,
+		`INSERT INTO queries (id, from_source, question, answer, documents_related, status, reason, created_at)
+		 VALUES ('qry-2', 'carol', 'Who wrote it?', '', '[]', 'pending', '', '2024-03-02 10:00:00')`,
+		`INSERT INTO queries (id, from_source, question, answer, documents_related, status, reason, created_at)
+		 VALUES ('qry-3', 'bob', 'Status?', '', '[]', 'pending', '', '2024-03-05 10:00:00')`,
+		`INSERT INTO answers (question, user, answer, created_at) VALUES ('Any news?', 'bob', 'From now on, yes', '2024-03-03 10:00:00')`,
+		`INSERT INTO answers (question, user, answer, created_at) VALUES ('Any news?', 'carol', 'No', '2024-03-04 10:00:00')`,
+	} {
+		if _, err := testDB.Exec(stmt); err != nil {
+			t.Fatalf("Failed to insert fixture: %v", err)
+		}
+	}
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "archive")
+	options := ArchiveOptions{Dir: dir, Format: ArchiveJSONL, Identity: "alice", PartSize: 2}
+
+	manifest, err := ExportArchive(ctx, testDB.DB, options)
+	if err != nil {
+		t.Fatalf("ExportArchive failed: %v", err)
+	}
+	if manifest.Records != 5 || len(manifest.Parts) != 3 || manifest.CompletedAt == nil {
+		t.Fatalf("Expected 5 messages in 3 parts, got %+v", manifest)
+	}
+	raw, _ := os.ReadFile(filepath.Join(dir, manifest.Parts[0].File))
+	var first archiveRecord
+	if err := json.Unmarshal([]byte(strings.SplitN(string(raw), "\n", 2)[0]), &first); err != nil {
+		t.Fatal(err)
+	}
+	if first.ID != "qry-1" || first.From != "bob" || first.To != "alice" || first.Answer != "A network" {
+		t.Errorf("Unexpected first record: %+v", first)
+	}
+	sums, _ := os.ReadFile(filepath.Join(dir, archiveChecksumsFile))
+	if strings.Count(string(sums), "\n") != 4 {
+		t.Errorf("Expected checksums of the schema and 3 parts, got:\n%s", sums)
+	}
+
+	// An interrupted export continues after its last complete part
+	parts := manifest.Parts
+	manifest.Parts, manifest.Records, manifest.CompletedAt = parts[:1], 2, nil
+	manifest.Cursor = db.ArchiveCursor{Timestamp: time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC), Kind: "query", ID: "qry-2"}
+	if err := writeArchiveManifest(dir, manifest); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, parts[1].File))
+	os.Remove(filepath.Join(dir, parts[2].File))
+	resumed, err := ExportArchive(ctx, testDB.DB, options)
+	if err != nil {
+		t.Fatalf("Resuming failed: %v", err)
+	}
+	if !reflect.DeepEqual(resumed.Parts, parts) {
+		t.Errorf("Expected the resumed export to write the same parts, got %+v", resumed.Parts)
+	}
+
+	// Other options or modified parts are refused
+	if _, err := ExportArchive(ctx, testDB.DB, ArchiveOptions{Dir: dir, Format: ArchiveMbox, Identity: "alice"}); !errors.Is(err, ErrArchiveMismatch) {
+		t.Errorf("Expected ErrArchiveMismatch for another format, got %v", err)
+	}
+	os.WriteFile(filepath.Join(dir, parts[0].File), []byte("tampered\n"), 0o644)
+	if _, err := ExportArchive(ctx, testDB.DB, options); !errors.Is(err, ErrArchiveMismatch) {
+		t.Errorf("Expected ErrArchiveMismatch for a modified part, got %v", err)
+	}
+
+	// Messages can be limited to a peer and date range
+	mboxDir := filepath.Join(t.TempDir(), "mbox")
+	manifest, err = ExportArchive(ctx, testDB.DB, ArchiveOptions{
+		Dir:      mboxDir,
+		Format:   ArchiveMbox,
+		Identity: "alice",
+		Peer:     "bob",
+		Since:    time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+		Until:    time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("ExportArchive failed: %v", err)
+	}
+	if manifest.Records != 1 || manifest.Schema != nil {
+		t.Fatalf("Expected one message without a schema, got %+v", manifest)
+	}
+	mbox, _ := os.ReadFile(filepath.Join(mboxDir, manifest.Parts[0].File))
+	for _, want := range []string{"From bob Sun Mar  3 10:00:00 2024\n", "Subject: Re: Any news?\n", "\n>From now on, yes\n"} {
+		if !strings.Contains(string(mbox), want) {
+			t.Errorf("Expected the mailbox to contain %q, got:\n%s", want, mbox)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	dk_client "dk/client"
+	"dk/utils"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AttachmentMessageType is the message type of the chunks of a file sent to a peer. Each chunk
+// travels as its own message, a utils.FileChunkPayload, so files larger than a websocket
+// message can be sent.
+const AttachmentMessageType = utils.MessageTypeAttachment
+
+const (
+	// MaxAttachmentSize is the largest file that can be sent or received as an attachment
+	MaxAttachmentSize = 25 << 20
+	// attachmentChunkSize is the file data sent per message. Encoded and encrypted it stays
+	// well below the message size limit of the server.
+	attachmentChunkSize = 256 << 10
+	// attachmentTimeout is how long an incomplete attachment is kept waiting for its chunks
+	attachmentTimeout = 10 * time.Minute
+	// maxPendingAttachments bounds the incomplete attachments kept per sender
+	maxPendingAttachments = 8
+)
+
+// Attachment describes a file sent to or received from peers
+type Attachment struct {
+	ID       string `json:"id"`
+	FileName string `json:"file_name"`
+	MIMEType string `json:"mime_type"`
+	Size     int    `json:"size"`
+	SHA256   string `json:"sha256"`
+	Chunks   int    `json:"chunks"`
+	Note     string `json:"note,omitempty"`
+	Path     string `json:"path,omitempty"` // Where a received attachment was saved
+}
+
+// chunkAttachment reads a file and splits it into the chunks of an attachment
+func chunkAttachment(path, note string) (*Attachment, []*utils.FileChunkPayload, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		return nil, nil, fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() > MaxAttachmentSize {
+		return nil, nil, fmt.Errorf("%s is %d bytes; attachments are limited to %d bytes", path, info.Size(), MaxAttachmentSize)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	attachment := &Attachment{
+		ID:       uuid.New().String(),
+		FileName: filepath.Base(path),
+		MIMEType: attachmentMIMEType(path, data),
+		Size:     len(data),
+		SHA256:   hex.EncodeToString(sum[:]),
+		Chunks:   max(1, (len(data)+attachmentChunkSize-1)/attachmentChunkSize),
+		Note:     strings.TrimSpace(note),
+	}
+	message := attachment.Note
+	if message == "" {
+		message = attachment.FileName
+	}
+
+	chunks := make([]*utils.FileChunkPayload, attachment.Chunks)
+	for i := range chunks {
+		chunks[i] = &utils.FileChunkPayload{
+			AttachmentID: attachment.ID,
+			Index:        i,
+			Count:        attachment.Chunks,
+			Size:         attachment.Size,
+			SHA256:       attachment.SHA256,
+			MIMEType:     attachment.MIMEType,
+			FileName:     attachment.FileName,
+			Note:         message,
+			Data:         data[min(i*attachmentChunkSize, len(data)):min((i+1)*attachmentChunkSize, len(data))],
+		}
+	}
+	return attachment, chunks, nil
+}
+
+// attachmentMIMEType guesses the MIME type of a file from its extension, then its content
+func attachmentMIMEType(path string, data []byte) string {
+	if byExtension := mime.TypeByExtension(filepath.Ext(path)); byExtension != "" {
+		return byExtension
+	}
+	return http.DetectContentType(data)
+}
+
+// SendAttachment sends a local file to peers. The file is sent in chunks, each in its own
+// encrypted message, and the recipients check it against its SHA-256 hash once every chunk
+// has arrived.
+func SendAttachment(ctx context.Context, peers []string, path, note string) (*Attachment, error) {
+	if len(peers) == 0 {
+		return nil, errors.New("no peers to send the attachment to")
+	}
+	client, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	attachment, chunks, err := chunkAttachment(path, note)
+	if err != nil {
+		return nil, err
+	}
+	for _, peer := range peers {
+		for _, chunk := range chunks {
+			content, err := utils.EncodePayload(chunk)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode attachment: %w", err)
+			}
+			if err := client.SendMessage(dk_client.Message{
+				From:      client.UserID,
+				To:        peer,
+				Content:   content,
+				Timestamp: time.Now(),
+			}); err != nil {
+				return nil, fmt.Errorf("failed to send %s to %s: %w", attachment.FileName, peer, err)
+			}
+		}
+	}
+	return attachment, nil
+}
+
+// pendingAttachment is an attachment whose chunks are still arriving
+type pendingAttachment struct {
+	Attachment
+	from    string
+	chunks  [][]byte
+	missing int
+	started time.Time
+}
+
+// attachmentAssembler collects the chunks of incoming attachments
+type attachmentAssembler struct {
+	mu      sync.Mutex
+	pending map[string]*pendingAttachment // By sender and attachment ID
+	now     func() time.Time
+}
+
+var incomingAttachments = &attachmentAssembler{pending: make(map[string]*pendingAttachment), now: time.Now}
+
+// add stores a chunk and returns the attachment with its data once all its chunks arrived
+func (a *attachmentAssembler) add(from string, message *utils.FileChunkPayload) (*pendingAttachment, error) {
+	id, index, count, size := message.AttachmentID, message.Index, message.Count, message.Size
+	if size < 0 || size > MaxAttachmentSize || count < 1 || count > max(1, (size+attachmentChunkSize-1)/attachmentChunkSize) || index < 0 || index >= count {
+		return nil, fmt.Errorf("attachment %s is too large or has an invalid chunk count", id)
+	}
+	chunk := message.Data
+	if len(chunk) > attachmentChunkSize {
+		return nil, fmt.Errorf("attachment %s has an invalid chunk", id)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := a.now()
+	fromSender := 0
+	for key, p := range a.pending {
+		if now.Sub(p.started) > attachmentTimeout {
+			log.Printf("[Attachment] Dropping %s from %s: chunks missing after %s", p.FileName, p.from, attachmentTimeout)
+			delete(a.pending, key)
+		} else if p.from == from {
+			fromSender++
+		}
+	}
+
+	key := from + "/" + id
+	p, ok := a.pending[key]
+	if !ok {
+		if fromSender >= maxPendingAttachments {
+			return nil, fmt.Errorf("too many incomplete attachments from %s", from)
+		}
+		p = &pendingAttachment{
+			Attachment: Attachment{
+				ID:       id,
+				FileName: message.FileName,
+				MIMEType: message.MIMEType,
+				Size:     size,
+				SHA256:   message.SHA256,
+				Chunks:   count,
+				Note:     message.Note,
+			},
+			from:    from,
+			chunks:  make([][]byte, count),
+			missing: count,
+			started: now,
+		}
+		a.pending[key] = p
+	}
+	if count != p.Chunks || size != p.Size {
+		return nil, fmt.Errorf("attachment %s changed its description between chunks", id)
+	}
+	if p.chunks[index] == nil {
+		p.chunks[index] = chunk
+		p.missing--
+	}
+	if p.missing > 0 {
+		return nil, nil
+	}
+	delete(a.pending, key)
+	return p, nil
+}
+
+// data joins the chunks of a complete attachment and checks them against its size and hash
+func (p *pendingAttachment) data() ([]byte, error) {
+	data := make([]byte, 0, p.Size)
+	for _, chunk := range p.chunks {
+		data = append(data, chunk...)
+	}
+	sum := sha256.Sum256(data)
+	if len(data) != p.Size || !strings.EqualFold(hex.EncodeToString(sum[:]), p.SHA256) {
+		return nil, fmt.Errorf("attachment %s from %s is corrupt: its size or SHA-256 hash does not match", p.FileName, p.from)
+	}
+	return data, nil
+}
+
+// attachmentsDir returns the directory received attachments of a peer are saved in: the
+// "attachments" directory next to the database, with a subdirectory per peer
+func attachmentsDir(ctx context.Context, from string) (string, error) {
+	params, err := utils.ParamsFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	if params.DBPath == nil || *params.DBPath == "" {
+		return "", errors.New("no data directory to save attachments in")
+	}
+	peer := filepath.Base(filepath.Clean("/" + from))
+	if peer == "/" || peer == "." {
+		return "", fmt.Errorf("invalid sender %q", from)
+	}
+	return filepath.Join(filepath.Dir(*params.DBPath), "attachments", peer), nil
+}
+
+// attachmentFileName returns a safe name to save an attachment under, so that it cannot
+// escape the directory of its sender or overwrite another attachment
+func attachmentFileName(attachment Attachment) string {
+	name := filepath.Base(filepath.Clean("/" + strings.ReplaceAll(attachment.FileName, `\`, "/")))
+	name = strings.TrimLeft(name, ".")
+	if name == "" || name == "/" {
+		name = "attachment"
+	}
+	prefix := attachment.ID
+	if len(prefix) > 8 {
+		prefix = prefix[:8]
+	}
+	return prefix + "-" + name
+}
+
+// HandleAttachmentMessage collects a chunk of an attachment sent by a peer and, once every
+// chunk has arrived, saves the file in the attachments directory of the peer
+func HandleAttachmentMessage(ctx context.Context, msg dk_client.Message) (*Attachment, error) {
+	message, err := utils.DecodePayloadAs[*utils.FileChunkPayload](msg.Content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attachment message: %w", err)
+	}
+	complete, err := incomingAttachments.add(msg.From, message)
+	if err != nil || complete == nil {
+		return nil, err
+	}
+	data, err := complete.data()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := attachmentsDir(ctx, msg.From)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	attachment := complete.Attachment
+	attachment.Path = filepath.Join(dir, attachmentFileName(attachment))
+	if err := os.WriteFile(attachment.Path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save attachment: %w", err)
+	}
+	log.Printf("[Attachment] Received %s (%d bytes) from %s, saved to %s", attachment.FileName, attachment.Size, msg.From, attachment.Path)
+	return &attachment, nil
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	dk_client "dk/client"
+	"dk/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAttachmentRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 2*attachmentChunkSize+1000)
+	rand.Read(data)
+	path := filepath.Join(dir, "report.bin")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	sent, messages, err := chunkAttachment(path, "Quarterly numbers")
+	if err != nil {
+		t.Fatalf("chunkAttachment failed: %v", err)
+	}
+	if sent.Chunks != 3 || len(messages) != 3 {
+		t.Fatalf("Expected 3 chunks, got %d", len(messages))
+	}
+
+	dbPath := filepath.Join(dir, "node", "app.db")
+	ctx := utils.WithParams(context.Background(), utils.Parameters{DBPath: &dbPath})
+	receive := func(chunk *utils.FileChunkPayload) (*Attachment, error) {
+		content, _ := utils.EncodePayload(chunk)
+		return HandleAttachmentMessage(ctx, dk_client.Message{From: "bob", Content: content})
+	}
+
+	// Chunks may arrive out of order and more than once
+	for _, i := range []int{2, 0, 2} {
+		if received, err := receive(messages[i]); err != nil || received != nil {
+			t.Fatalf("Expected chunk %d to be stored, got %v, %v", i, received, err)
+		}
+	}
+	received, err := receive(messages[1])
+	if err != nil || received == nil {
+		t.Fatalf("Expected the attachment to be complete, got %v", err)
+	}
+	if received.Note != "Quarterly numbers" || received.SHA256 != sent.SHA256 {
+		t.Errorf("Unexpected attachment: %+v", received)
+	}
+	if want := filepath.Join(dir, "node", "attachments", "bob"); filepath.Dir(received.Path) != want {
+		t.Errorf("Expected the attachment to be saved in %s, got %s", want, received.Path)
+	}
+	saved, err := os.ReadFile(received.Path)
+	if err != nil || !bytes.Equal(saved, data) {
+		t.Errorf("Saved attachment differs from the sent file (%v)", err)
+	}
+}
+
+func TestAttachmentRejected(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	os.WriteFile(path, []byte("secret plans"), 0600)
+	dbPath := filepath.Join(dir, "app.db")
+	ctx := utils.WithParams(context.Background(), utils.Parameters{DBPath: &dbPath})
+	receive := func(chunk *utils.FileChunkPayload) (*Attachment, error) {
+		content, _ := utils.EncodePayload(chunk)
+		return HandleAttachmentMessage(ctx, dk_client.Message{From: "mallory", Content: content})
+	}
+
+	// A file name cannot escape the directory of the sender
+	_, messages, _ := chunkAttachment(path, "")
+	messages[0].FileName = "../../.bashrc"
+	received, err := receive(messages[0])
+	if err != nil {
+		t.Fatalf("Expected the attachment to be saved, got %v", err)
+	}
+	if filepath.Dir(received.Path) != filepath.Join(dir, "attachments", "mallory") || strings.HasPrefix(filepath.Base(received.Path), ".") {
+		t.Errorf("Attachment saved outside of the sender's directory or hidden: %s", received.Path)
+	}
+
+	// Data that does not match the announced hash is dropped
+	_, messages, _ = chunkAttachment(path, "")
+	messages[0].SHA256 = strings.Repeat("0", 64)
+	if _, err := receive(messages[0]); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("Expected a corrupt attachment to be rejected, got %v", err)
+	}
+
+	// Sizes beyond the limit are refused before any data is kept
+	_, messages, _ = chunkAttachment(path, "")
+	messages[0].Size = 999999999
+	if _, err := receive(messages[0]); err == nil {
+		t.Error("Expected an oversized attachment to be refused")
+	}
+}
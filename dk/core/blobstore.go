@@ -0,0 +1,484 @@
+package core
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"dk/db"
+	"dk/utils"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Blob store backends
+const (
+	BlobBackendLocal = "local" // Files under a directory, downloaded through the node
+	BlobBackendS3    = "s3"    // An S3 compatible bucket, such as AWS S3 or MinIO
+)
+
+const (
+	// defaultBlobURLTTL is how long signed download URLs are valid when the configuration
+	// sets no TTL
+	defaultBlobURLTTL = 15 * time.Minute
+	// LocalBlobPath is where the node serves the files of the local blob store
+	LocalBlobPath = "/api/v1/blobs/"
+)
+
+var (
+	// ErrBlobNotFound is returned when a blob or the original file of a document is missing
+	ErrBlobNotFound = errors.New("blob not found")
+	// ErrInvalidBlobSignature is returned for download URLs that are forged or expired
+	ErrInvalidBlobSignature = errors.New("invalid or expired download signature")
+)
+
+// BlobStoreConfig chooses where the original files of documents are kept. The local backend
+// is used when no configuration is given.
+type BlobStoreConfig struct {
+	Backend string `json:"backend,omitempty"` // "local" (default) or "s3"
+	// Dir is the directory of the local backend
+	Dir string `json:"dir,omitempty"`
+	// PublicURL is the base URL consumers reach the node at, for local download URLs
+	PublicURL string `json:"public_url,omitempty"`
+	// SigningKey signs local download URLs; one is generated and kept in Dir when empty
+	SigningKey string `json:"signing_key,omitempty"`
+
+	// Endpoint, Region and Bucket locate the S3 bucket, e.g. "https://s3.amazonaws.com" or
+	// "http://localhost:9000" for MinIO
+	Endpoint        string `json:"endpoint,omitempty"`
+	Region          string `json:"region,omitempty"`
+	Bucket          string `json:"bucket,omitempty"`
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	// PathStyle addresses the bucket in the path rather than the host name, as MinIO expects
+	PathStyle bool `json:"path_style,omitempty"`
+	// Prefix is prepended to the keys of the files in the bucket
+	Prefix string `json:"prefix,omitempty"`
+	// ResidencyRegion is the data residency region the bucket is in, e.g. "EU". Originals of
+	// documents in a collection restricted to a region are only stored in a bucket declared in
+	// that region, unless the residency constraint is overridden.
+	ResidencyRegion string `json:"residency_region,omitempty"`
+
+	// URLTTLSeconds is how long download URLs are valid, 15 minutes by default
+	URLTTLSeconds int `json:"url_ttl_seconds,omitempty"`
+	// Lifecycle removes files that are no longer needed
+	Lifecycle BlobLifecycle `json:"lifecycle,omitempty"`
+}
+
+// BlobLifecycle declares when stored files are deleted. Zero values keep files forever.
+type BlobLifecycle struct {
+	// ExpireAfterDays deletes files this many days after they were stored
+	ExpireAfterDays int `json:"expire_after_days,omitempty"`
+	// UnreferencedAfterDays deletes files whose document has not been associated with any
+	// API or request for this many days
+	UnreferencedAfterDays int `json:"unreferenced_after_days,omitempty"`
+}
+
+// Validate checks the backend and its required settings
+func (c BlobStoreConfig) Validate() error {
+	switch c.Backend {
+	case "", BlobBackendLocal:
+		if c.Dir == "" {
+			return errors.New("blob store: dir is required for the local backend")
+		}
+	case BlobBackendS3:
+		if c.Endpoint == "" || c.Bucket == "" || c.Region == "" {
+			return errors.New("blob store: endpoint, region and bucket are required for the s3 backend")
+		}
+		if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+			return errors.New("blob store: access_key_id and secret_access_key are required for the s3 backend")
+		}
+		if _, err := url.Parse(c.Endpoint); err != nil {
+			return fmt.Errorf("blob store: invalid endpoint: %w", err)
+		}
+	default:
+		return fmt.Errorf("blob store: unknown backend %q", c.Backend)
+	}
+	if c.URLTTLSeconds < 0 || c.Lifecycle.ExpireAfterDays < 0 || c.Lifecycle.UnreferencedAfterDays < 0 {
+		return errors.New("blob store: durations must not be negative")
+	}
+	return nil
+}
+
+// URLTTL returns how long download URLs are valid
+func (c BlobStoreConfig) URLTTL() time.Duration {
+	if c.URLTTLSeconds > 0 {
+		return time.Duration(c.URLTTLSeconds) * time.Second
+	}
+	return defaultBlobURLTTL
+}
+
+// BlobStore keeps the original files of documents
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns ErrBlobNotFound when there is no blob with the key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete succeeds when the blob is already gone
+	Delete(ctx context.Context, key string) error
+	// SignedURL returns a URL anyone can download the blob from until it expires
+	SignedURL(key string, ttl time.Duration, now time.Time) (string, error)
+}
+
+// NewBlobStore creates the blob store of a configuration
+func NewBlobStore(config BlobStoreConfig) (BlobStore, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if config.Backend == BlobBackendS3 {
+		return newS3BlobStore(config)
+	}
+	return newLocalBlobStore(config)
+}
+
+// LocalBlobStore keeps files under a directory. Download URLs point at the node itself and
+// carry an HMAC of the key and expiry, checked by VerifySignature.
+type LocalBlobStore struct {
+	dir       string
+	publicURL string
+	key       []byte
+}
+
+func newLocalBlobStore(config BlobStoreConfig) (*LocalBlobStore, error) {
+	if err := os.MkdirAll(config.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("blob store: %w", err)
+	}
+	signingKey := []byte(config.SigningKey)
+	if len(signingKey) == 0 {
+		var err error
+		if signingKey, err = loadOrCreateSigningKey(filepath.Join(config.Dir, ".signing_key")); err != nil {
+			return nil, err
+		}
+	}
+	return &LocalBlobStore{dir: config.Dir, publicURL: strings.TrimRight(config.PublicURL, "/"), key: signingKey}, nil
+}
+
+// loadOrCreateSigningKey reads the signing key of the local store, creating it on first use so
+// download URLs stay valid across restarts
+func loadOrCreateSigningKey(path string) ([]byte, error) {
+	if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
+		return data, nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("blob store: %w", err)
+	}
+	encoded := []byte(hex.EncodeToString(key))
+	if err := os.WriteFile(path, encoded, 0600); err != nil {
+		return nil, fmt.Errorf("blob store: %w", err)
+	}
+	return encoded, nil
+}
+
+// path returns the file of a key, refusing keys that would leave the directory
+func (s *LocalBlobStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean != "/"+key || strings.HasPrefix(path.Base(clean), ".") {
+		return "", fmt.Errorf("blob store: invalid key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(clean)), nil
+}
+
+// Put implements BlobStore
+func (s *LocalBlobStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	// Written aside and renamed, so readers never see a partial file
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Get implements BlobStore
+func (s *LocalBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	file, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+// Delete implements BlobStore
+func (s *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// SignedURL implements BlobStore
+func (s *LocalBlobStore) SignedURL(key string, ttl time.Duration, now time.Time) (string, error) {
+	if _, err := s.path(key); err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(now.Add(ttl).Unix(), 10)
+	query := url.Values{"expires": {expires}, "signature": {s.sign(key, expires)}}
+	return s.publicURL + LocalBlobPath + escapeKey(key) + "?" + query.Encode(), nil
+}
+
+// VerifySignature checks the signature and expiry of a local download URL
+func (s *LocalBlobStore) VerifySignature(key, expires, signature string, now time.Time) error {
+	expiry, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > expiry {
+		return ErrInvalidBlobSignature
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.sign(key, expires))) {
+		return ErrInvalidBlobSignature
+	}
+	return nil
+}
+
+func (s *LocalBlobStore) sign(key, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// escapeKey escapes each segment of a key for use in a URL path
+func escapeKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+type blobStoreKey struct{}
+
+// WithBlobStore returns a context carrying the blob store and its configuration
+func WithBlobStore(ctx context.Context, store BlobStore, config BlobStoreConfig) context.Context {
+	return context.WithValue(ctx, blobStoreKey{}, &blobStoreEntry{store: store, config: config})
+}
+
+type blobStoreEntry struct {
+	store  BlobStore
+	config BlobStoreConfig
+}
+
+// BlobStoreFromContext returns the blob store and its configuration, or nil when original
+// files are not kept
+func BlobStoreFromContext(ctx context.Context) (BlobStore, BlobStoreConfig) {
+	if entry, ok := ctx.Value(blobStoreKey{}).(*blobStoreEntry); ok {
+		return entry.store, entry.config
+	}
+	return nil, BlobStoreConfig{}
+}
+
+// StoreDocumentBlob keeps the original file of a document, replacing the one stored before.
+// It does nothing when no blob store is configured. Storing the original in a bucket outside
+// the region of the collection of the context fails with ErrResidencyViolation, unless the
+// context overrides it (see WithResidencyOverride).
+func StoreDocumentBlob(ctx context.Context, filename string, data []byte, contentType string) error {
+	store, config := BlobStoreFromContext(ctx)
+	if store == nil {
+		return nil
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	previous, err := db.GetDocumentBlob(ctx, database, filename)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+
+	digest := sha256.Sum256(data)
+	blob := db.DocumentBlob{
+		DocumentFilename: filename,
+		BlobKey:          "documents/" + uuid.New().String() + "/" + blobFileName(filename),
+		ContentType:      contentType,
+		SizeBytes:        int64(len(data)),
+		SHA256:           hex.EncodeToString(digest[:]),
+		CreatedAt:        time.Now(),
+	}
+	if previous != nil && previous.SHA256 == blob.SHA256 {
+		return nil
+	}
+	if config.Backend == BlobBackendS3 {
+		destination := ExportDestination{
+			Collection: contextCollectionName(ctx),
+			Target:     "s3://" + path.Join(config.Bucket, config.Prefix),
+			Region:     config.ResidencyRegion,
+		}
+		if _, err := CheckExportResidency(ctx, database, destination, residencyOverrideFromContext(ctx)); err != nil {
+			return fmt.Errorf("store original of %s: %w", filename, err)
+		}
+	}
+	if err := store.Put(ctx, blob.BlobKey, data, contentType); err != nil {
+		return fmt.Errorf("store original of %s: %w", filename, err)
+	}
+	if err := db.PutDocumentBlob(ctx, database, blob); err != nil {
+		store.Delete(ctx, blob.BlobKey)
+		return err
+	}
+	if previous != nil {
+		if err := store.Delete(ctx, previous.BlobKey); err != nil {
+			log.Printf("[Blobs] Failed to delete the previous original of %s: %v", filename, err)
+		}
+	}
+	return nil
+}
+
+// blobFileName turns a document filename into a safe last segment of a blob key
+func blobFileName(filename string) string {
+	name := path.Base(filepath.ToSlash(filename))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == "/" || strings.HasPrefix(name, ".") {
+		name = "_" + name
+	}
+	return name
+}
+
+// DeleteDocumentBlob removes the original file of a document, if one is stored
+func DeleteDocumentBlob(ctx context.Context, filename string) error {
+	store, _ := BlobStoreFromContext(ctx)
+	if store == nil {
+		return nil
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	blob, err := db.GetDocumentBlob(ctx, database, filename)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := store.Delete(ctx, blob.BlobKey); err != nil {
+		return err
+	}
+	return db.DeleteDocumentBlob(ctx, database, filename)
+}
+
+// DocumentDownloadURL returns a signed URL to download the original file of a document, and
+// when it expires
+func DocumentDownloadURL(ctx context.Context, filename string, now time.Time) (string, time.Time, error) {
+	store, config := BlobStoreFromContext(ctx)
+	if store == nil {
+		return "", time.Time{}, ErrBlobNotFound
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	blob, err := db.GetDocumentBlob(ctx, database, filename)
+	if errors.Is(err, db.ErrNotFound) {
+		return "", time.Time{}, ErrBlobNotFound
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	ttl := config.URLTTL()
+	signed, err := store.SignedURL(blob.BlobKey, ttl, now)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, now.Add(ttl), nil
+}
+
+// ApplyBlobLifecycle deletes the stored files the lifecycle rules no longer keep and tracks
+// since when documents are unreferenced. It returns how many files were deleted.
+func ApplyBlobLifecycle(ctx context.Context, now time.Time) (int, error) {
+	store, config := BlobStoreFromContext(ctx)
+	if store == nil {
+		return 0, nil
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	blobs, err := db.ListDocumentBlobs(ctx, database)
+	if err != nil {
+		return 0, err
+	}
+
+	rules := config.Lifecycle
+	deleted := 0
+	for _, blob := range blobs {
+		expired := rules.ExpireAfterDays > 0 && now.Sub(blob.CreatedAt) >= days(rules.ExpireAfterDays)
+		abandoned := rules.UnreferencedAfterDays > 0 && blob.References == 0 &&
+			blob.UnreferencedAt != nil && now.Sub(*blob.UnreferencedAt) >= days(rules.UnreferencedAfterDays)
+		switch {
+		case expired || abandoned:
+			if err := store.Delete(ctx, blob.BlobKey); err != nil {
+				log.Printf("[Blobs] Failed to delete the original of %s: %v", blob.DocumentFilename, err)
+				continue
+			}
+			if err := db.DeleteDocumentBlob(ctx, database, blob.DocumentFilename); err != nil {
+				return deleted, err
+			}
+			deleted++
+		case blob.References == 0 && blob.UnreferencedAt == nil:
+			if err := db.SetDocumentBlobUnreferenced(ctx, database, blob.DocumentFilename, &now); err != nil {
+				return deleted, err
+			}
+		case blob.References > 0 && blob.UnreferencedAt != nil:
+			if err := db.SetDocumentBlobUnreferenced(ctx, database, blob.DocumentFilename, nil); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	return deleted, nil
+}
+
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
+
+// StartBlobLifecycleWorker begins a background worker that periodically applies the lifecycle
+// rules of the blob store
+func StartBlobLifecycleWorker(ctx context.Context, checkInterval time.Duration) {
+	if store, _ := BlobStoreFromContext(ctx); store == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				deleted, err := ApplyBlobLifecycle(ctx, now)
+				if err != nil {
+					log.Printf("[Blobs] Lifecycle run failed: %v", err)
+				} else if deleted > 0 {
+					log.Printf("[Blobs] Deleted %d original files past their lifecycle", deleted)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,244 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxPresignTTL is the longest validity SigV4 allows for presigned URLs
+const maxPresignTTL = 7 * 24 * time.Hour
+
+// S3BlobStore keeps files in an S3 compatible bucket. Requests and presigned download URLs
+// are signed with AWS Signature Version 4.
+type S3BlobStore struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	prefix    string
+	client    *http.Client
+	now       func() time.Time
+}
+
+func newS3BlobStore(config BlobStoreConfig) (*S3BlobStore, error) {
+	endpoint, err := url.Parse(strings.TrimRight(config.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("blob store: invalid endpoint %q", config.Endpoint)
+	}
+	return &S3BlobStore{
+		endpoint:  endpoint,
+		region:    config.Region,
+		bucket:    config.Bucket,
+		accessKey: config.AccessKeyID,
+		secretKey: config.SecretAccessKey,
+		pathStyle: config.PathStyle,
+		prefix:    strings.Trim(config.Prefix, "/"),
+		client:    &http.Client{Timeout: time.Minute},
+		now:       time.Now,
+	}, nil
+}
+
+// objectURL returns the host and escaped path of an object
+func (s *S3BlobStore) objectURL(key string) (host, escapedPath string) {
+	if s.prefix != "" {
+		key = s.prefix + "/" + key
+	}
+	host = s.endpoint.Host
+	escapedPath = "/" + s3Escape(key, false)
+	if s.pathStyle {
+		escapedPath = "/" + s3Escape(s.bucket, true) + escapedPath
+	} else {
+		host = s.bucket + "." + host
+	}
+	return host, escapedPath
+}
+
+// Put implements BlobStore
+func (s *S3BlobStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, data, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error("put", key, resp)
+	}
+	return nil
+}
+
+// Get implements BlobStore
+func (s *S3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error("get", key, resp)
+	}
+	return resp.Body, nil
+}
+
+// Delete implements BlobStore
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error("delete", key, resp)
+	}
+	return nil
+}
+
+// SignedURL implements BlobStore with a presigned GET URL
+func (s *S3BlobStore) SignedURL(key string, ttl time.Duration, now time.Time) (string, error) {
+	if ttl <= 0 || ttl > maxPresignTTL {
+		return "", fmt.Errorf("blob store: presigned URLs must expire within %v", maxPresignTTL)
+	}
+	host, escapedPath := s.objectURL(key)
+	amzDate, scope := s.scope(now)
+
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKey + "/" + scope},
+		"X-Amz-Date":          {amzDate},
+		"X-Amz-Expires":       {strconv.Itoa(int(ttl / time.Second))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	canonicalQuery := s3CanonicalQuery(query)
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet, escapedPath, canonicalQuery, "host:" + host + "\n", "host", "UNSIGNED-PAYLOAD",
+	}, "\n")
+	signature := s.signature(now, amzDate, scope, canonicalRequest)
+	return s.endpoint.Scheme + "://" + host + escapedPath + "?" + canonicalQuery + "&X-Amz-Signature=" + signature, nil
+}
+
+// do sends a request signed in the Authorization header
+func (s *S3BlobStore) do(ctx context.Context, method, key string, body []byte, header http.Header) (*http.Response, error) {
+	host, escapedPath := s.objectURL(key)
+	req, err := http.NewRequestWithContext(ctx, method, s.endpoint.Scheme+"://"+host+escapedPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	now := s.now()
+	amzDate, scope := s.scope(now)
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	signed := map[string]string{"host": host, "x-amz-date": amzDate, "x-amz-content-sha256": payloadHash}
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		signed["content-type"] = contentType
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(signed[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		method, escapedPath, "", canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	signature := s.signature(now, amzDate, scope, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+	return s.client.Do(req)
+}
+
+// scope returns the request date and credential scope of a signature
+func (s *S3BlobStore) scope(now time.Time) (amzDate, scope string) {
+	now = now.UTC()
+	return now.Format("20060102T150405Z"), now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+}
+
+// signature derives the signing key of the day and signs a canonical request
+func (s *S3BlobStore) signature(now time.Time, amzDate, scope, canonicalRequest string) string {
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.UTC().Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Escape percent-encodes everything but unreserved characters, and slashes unless
+// encodeSlash is set, as SigV4 expects
+func s3Escape(value string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(value) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery encodes query parameters sorted by name
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, s3Escape(name, true)+"="+s3Escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func s3Error(op, key string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("blob store: %s %s: %s: %s", op, key, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"dk/db"
+	"dk/utils"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// QuerySelection selects the incoming queries ReviewQueries processes
+type QuerySelection struct {
+	Status string // Only queries with this status; "pending" when empty
+	From   string // Only queries of this peer
+	Topic  string // Regular expression the question, or the summary or a tag of its screening, must match, ignoring case
+}
+
+// ReviewOutcome is what ReviewQueries did with a query
+type ReviewOutcome struct {
+	ID       string `json:"id"`
+	From     string `json:"from"`
+	Question string `json:"question"`
+	Status   string `json:"status"`          // The status after the review, or the current one on a dry run
+	Error    string `json:"error,omitempty"` // Why the query could not be reviewed
+}
+
+// ReviewQueries accepts or rejects every query matching the selection, like ReviewQuery does
+// one at a time. A query that fails does not stop the others; its error is in its outcome.
+// With dryRun the matching queries are returned without being reviewed.
+func ReviewQueries(ctx context.Context, selection QuerySelection, approve, dryRun bool) ([]ReviewOutcome, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var topic *regexp.Regexp
+	if strings.TrimSpace(selection.Topic) != "" {
+		topic, err = regexp.Compile("(?i)" + selection.Topic)
+		if err != nil {
+			return nil, fmt.Errorf("invalid topic: %w", err)
+		}
+	}
+	status := strings.TrimSpace(selection.Status)
+	if status == "" {
+		status = "pending"
+	}
+
+	queries, err := db.ListQueries(ctx, database, status, strings.TrimSpace(selection.From))
+	if err != nil {
+		return nil, err
+	}
+	outcomes := []ReviewOutcome{}
+	for _, query := range queries {
+		if topic != nil && !matchesTopic(ctx, database, topic, query) {
+			continue
+		}
+		outcome := ReviewOutcome{ID: query.ID, From: query.From, Question: query.Question, Status: query.Status}
+		if !dryRun {
+			reviewed, err := ReviewQuery(ctx, query.ID, approve)
+			if reviewed.Status != "" {
+				outcome.Status = reviewed.Status
+			}
+			if err != nil {
+				outcome.Error = err.Error()
+			}
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes, nil
+}
+
+// matchesTopic reports whether the question of a query or its screening matches topic
+func matchesTopic(ctx context.Context, database *sql.DB, topic *regexp.Regexp, query db.Query) bool {
+	if topic.MatchString(query.Question) {
+		return true
+	}
+	screening, err := db.GetQueryScreening(ctx, database, query.ID)
+	if err != nil {
+		return false
+	}
+	if topic.MatchString(screening.Summary) {
+		return true
+	}
+	for _, tag := range screening.Tags {
+		if topic.MatchString(tag) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"testing"
+)
+
+func TestReviewQueries(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := utils.WithDatabase(context.Background(), testDB.DB)
+
+	for _, q := range []db.Query{
+		{ID: "qry-1", From: "bob", Question: "What is the salary of the CEO?", Status: "pending"},
+		{ID: "qry-2", From: "bob", Question: "When is the next release?", Status: "pending"},
+		{ID: "qry-3", From: "carol", Question: "How much do engineers earn?", Status: "pending"},
+		{ID: "qry-4", From: "carol", Question: "What are the salary bands?", Status: "accepted"},
+	} {
+		if err := db.InsertQuery(ctx, testDB.DB, q); err != nil {
+			t.Fatalf("InsertQuery failed: %v", err)
+		}
+	}
+	if err := db.SaveQueryScreening(ctx, testDB.DB, "qry-3", db.QueryScreening{Summary: "Engineer pay", Tags: []string{"salaries"}}); err != nil {
+		t.Fatalf("SaveQueryScreening failed: %v", err)
+	}
+
+	if _, err := ReviewQueries(ctx, QuerySelection{Topic: "("}, false, true); err == nil {
+		t.Error("Expected an invalid topic to be refused")
+	}
+
+	// The topic matches questions and screening tags; only pending queries by default
+	selection := QuerySelection{Topic: "SALAR"}
+	preview, err := ReviewQueries(ctx, selection, false, true)
+	if err != nil {
+		t.Fatalf("ReviewQueries failed: %v", err)
+	}
+	if len(preview) != 2 || preview[0].Status != "pending" || preview[1].Status != "pending" {
+		t.Fatalf("Expected qry-1 and qry-3 to be previewed as pending, got %+v", preview)
+	}
+	if query, _ := db.GetQuery(ctx, testDB.DB, "qry-1"); query.Status != "pending" {
+		t.Errorf("Expected a dry run to leave queries pending, got %q", query.Status)
+	}
+
+	outcomes, err := ReviewQueries(ctx, selection, false, false)
+	if err != nil {
+		t.Fatalf("ReviewQueries failed: %v", err)
+	}
+	if len(outcomes) != 2 {
+		t.Fatalf("Expected 2 rejected queries, got %+v", outcomes)
+	}
+	for _, outcome := range outcomes {
+		if outcome.Status != "rejected" || outcome.Error != "" {
+			t.Errorf("Expected %s to be rejected, got %+v", outcome.ID, outcome)
+		}
+	}
+	for id, want := range map[string]string{"qry-1": "rejected", "qry-2": "pending", "qry-3": "rejected", "qry-4": "accepted"} {
+		if query, _ := db.GetQuery(ctx, testDB.DB, id); query.Status != want {
+			t.Errorf("Expected %s to be %s, got %q", id, want, query.Status)
+		}
+	}
+
+	// Filtering by peer
+	outcomes, err = ReviewQueries(ctx, QuerySelection{From: "carol"}, false, true)
+	if err != nil || len(outcomes) != 0 {
+		t.Errorf("Expected carol to have no pending queries left, got %+v (%v)", outcomes, err)
+	}
+}
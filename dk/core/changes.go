@@ -0,0 +1,28 @@
+package core
+
+import "sync/atomic"
+
+// Change is a kind of data whose reads may be cached by callers
+type Change int
+
+const (
+	// QueriesChanged follows the incoming queries: new queries, reviews and drafted answers
+	QueriesChanged Change = iota
+	// DocumentsChanged follows the documents of the collections and the descriptions made of them
+	DocumentsChanged
+	changeKinds
+)
+
+// changeGenerations counts the writes of each kind of change
+var changeGenerations [changeKinds]atomic.Uint64
+
+// ChangeGeneration returns a number that grows whenever data of the kind changes. A cached read
+// is still current while the generation is the one taken before the read.
+func ChangeGeneration(change Change) uint64 {
+	return changeGenerations[change].Load()
+}
+
+// notifyChange is called by the write paths once data of the kind has changed
+func notifyChange(change Change) {
+	changeGenerations[change].Add(1)
+}
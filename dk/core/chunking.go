@@ -0,0 +1,218 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// Chunking strategies supported in ChunkingConfig
+const (
+	ChunkStrategyNone      = "none"      // Each file is stored as a single document
+	ChunkStrategySentence  = "sentence"  // Chunks of Size sentences
+	ChunkStrategyParagraph = "paragraph" // Chunks of Size paragraphs, separated by blank lines
+	ChunkStrategyToken     = "token"     // Windows of Size whitespace-separated tokens
+)
+
+// Default chunk sizes, in units of the strategy, when Size is not configured
+const (
+	defaultSentenceChunkSize  = 5
+	defaultParagraphChunkSize = 1
+	defaultTokenChunkSize     = 256
+)
+
+// Metadata keys describing the position of a chunk within its file
+const (
+	chunkIndexKey  = "chunk"        // Position of the chunk, starting at 0
+	chunkCountKey  = "chunks"       // Number of chunks of the file
+	chunkOffsetKey = "chunk_offset" // Byte offset of the chunk in the file content
+)
+
+var (
+	sentenceEnd    = regexp.MustCompile(`[.!?]+["')\]]*\s+`)
+	paragraphBreak = regexp.MustCompile(`\n[ \t]*\n\s*`)
+	tokenPattern   = regexp.MustCompile(`\S+`)
+)
+
+// ChunkingConfig controls how documents are split before they are embedded. Smaller chunks
+// make retrieval more precise; overlap keeps context that spans a chunk boundary retrievable.
+type ChunkingConfig struct {
+	Strategy string `json:"strategy"`          // "none", "sentence", "paragraph" or "token"
+	Size     int    `json:"size,omitempty"`    // Units per chunk: sentences, paragraphs or tokens
+	Overlap  int    `json:"overlap,omitempty"` // Units repeated from the end of the previous chunk
+}
+
+// Validate checks the configuration and fills in the default size of its strategy
+func (c *ChunkingConfig) Validate() error {
+	switch c.Strategy {
+	case "", ChunkStrategyNone:
+		return nil
+	case ChunkStrategySentence:
+		if c.Size <= 0 {
+			c.Size = defaultSentenceChunkSize
+		}
+	case ChunkStrategyParagraph:
+		if c.Size <= 0 {
+			c.Size = defaultParagraphChunkSize
+		}
+	case ChunkStrategyToken:
+		if c.Size <= 0 {
+			c.Size = defaultTokenChunkSize
+		}
+	default:
+		return fmt.Errorf("unknown chunking strategy %q", c.Strategy)
+	}
+	if c.Overlap < 0 || c.Overlap >= c.Size {
+		return fmt.Errorf("chunk overlap must be between 0 and %d", c.Size-1)
+	}
+	return nil
+}
+
+// DocumentChunk is a part of a document. Text is content[Offset:Offset+len(Text)], so consecutive
+// chunks cover the whole document and only overlap by the configured number of units.
+type DocumentChunk struct {
+	Offset int
+	Text   string
+}
+
+// ChunkDocument splits content according to config. Without a strategy, or when the content
+// fits in one chunk, it returns the whole content as a single chunk.
+func ChunkDocument(content string, config ChunkingConfig) []DocumentChunk {
+	var pattern *regexp.Regexp
+	switch config.Strategy {
+	case ChunkStrategySentence:
+		pattern = sentenceEnd
+	case ChunkStrategyParagraph:
+		pattern = paragraphBreak
+	case ChunkStrategyToken:
+		pattern = tokenPattern
+	}
+	if pattern == nil || config.Size <= 0 {
+		return []DocumentChunk{{Offset: 0, Text: content}}
+	}
+
+	// Unit starts; every unit runs until the next one starts, so no separator is lost
+	starts := []int{0}
+	if config.Strategy == ChunkStrategyToken {
+		starts = starts[:0]
+		for _, loc := range pattern.FindAllStringIndex(content, -1) {
+			starts = append(starts, loc[0])
+		}
+		if len(starts) == 0 {
+			return []DocumentChunk{{Offset: 0, Text: content}}
+		}
+		starts[0] = 0
+	} else {
+		for _, loc := range pattern.FindAllStringIndex(content, -1) {
+			if loc[1] < len(content) {
+				starts = append(starts, loc[1])
+			}
+		}
+	}
+	if len(starts) <= config.Size {
+		return []DocumentChunk{{Offset: 0, Text: content}}
+	}
+
+	overlap := config.Overlap
+	if overlap < 0 || overlap >= config.Size {
+		overlap = 0
+	}
+	var chunks []DocumentChunk
+	for first := 0; ; first += config.Size - overlap {
+		last := first + config.Size
+		end := len(content)
+		if last < len(starts) {
+			end = starts[last]
+		}
+		chunks = append(chunks, DocumentChunk{Offset: starts[first], Text: content[starts[first]:end]})
+		if last >= len(starts) {
+			break
+		}
+	}
+	return chunks
+}
+
+// isChunkKey reports whether a metadata key describes the position of a chunk
+func isChunkKey(key string) bool {
+	return key == chunkIndexKey || key == chunkCountKey || key == chunkOffsetKey
+}
+
+// mergeChunks reassembles the chunks of each file into one document, keeping the order in
+// which files first appear. Documents that were stored whole pass through unchanged apart
+// from the chunk metadata being removed. Stored summaries are not part of the content and are
+// left out unless nothing else of their file is present.
+func mergeChunks(docs []Document) []Document {
+	byFile := make(map[string][]Document)
+	summaries := make(map[string]Document)
+	seen := make(map[string]bool)
+	var order []string
+	for _, doc := range docs {
+		if !seen[doc.FileName] {
+			seen[doc.FileName] = true
+			order = append(order, doc.FileName)
+		}
+		if isSummary(doc.Metadata) {
+			summaries[doc.FileName] = doc
+			continue
+		}
+		byFile[doc.FileName] = append(byFile[doc.FileName], doc)
+	}
+
+	merged := make([]Document, 0, len(order))
+	for _, file := range order {
+		parts := byFile[file]
+		if len(parts) == 0 {
+			parts = []Document{summaries[file]}
+		}
+		sort.SliceStable(parts, func(i, j int) bool {
+			a, _ := strconv.Atoi(parts[i].Metadata[chunkIndexKey])
+			b, _ := strconv.Atoi(parts[j].Metadata[chunkIndexKey])
+			return a < b
+		})
+
+		doc := Document{FileName: file, Metadata: make(map[string]string), Score: parts[0].Score}
+		for key, value := range parts[0].Metadata {
+			if !isChunkKey(key) {
+				doc.Metadata[key] = value
+			}
+		}
+
+		var content []byte
+		for _, part := range parts {
+			offset, err := strconv.Atoi(part.Metadata[chunkOffsetKey])
+			if err != nil {
+				offset = len(content)
+			}
+			text := part.Content
+			switch {
+			case offset+len(text) <= len(content):
+				continue
+			case offset < len(content):
+				text = text[len(content)-offset:]
+			}
+			content = append(content, text...)
+			if part.Score > doc.Score {
+				doc.Score = part.Score
+			}
+		}
+		doc.Content = string(content)
+		merged = append(merged, doc)
+	}
+	return merged
+}
+
+type chunkingKey struct{}
+
+// WithChunking adds the document chunking configuration to the context
+func WithChunking(ctx context.Context, config ChunkingConfig) context.Context {
+	return context.WithValue(ctx, chunkingKey{}, config)
+}
+
+// ChunkingFromContext returns the chunking configuration of the context. Without one,
+// documents are stored whole.
+func ChunkingFromContext(ctx context.Context) ChunkingConfig {
+	config, _ := ctx.Value(chunkingKey{}).(ChunkingConfig)
+	return config
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"strconv"
+	"testing"
+)
+
+// chunkDocuments turns chunks into the documents retrieval would return for them
+func chunkDocuments(file string, chunks []DocumentChunk) []Document {
+	var docs []Document
+	for i, c := range chunks {
+		docs = append(docs, Document{
+			FileName: file,
+			Content:  c.Text,
+			Metadata: map[string]string{
+				"active":       "true",
+				chunkIndexKey:  strconv.Itoa(i),
+				chunkCountKey:  strconv.Itoa(len(chunks)),
+				chunkOffsetKey: strconv.Itoa(c.Offset),
+			},
+		})
+	}
+	return docs
+}
+
+func TestChunkDocumentStrategies(t *testing.T) {
+	text := "First sentence. Second one! Third?\n\nA new paragraph here. And its end.\n\nLast paragraph."
+
+	cases := []struct {
+		config ChunkingConfig
+		want   []string
+	}{
+		{ChunkingConfig{Strategy: ChunkStrategyNone}, []string{text}},
+		{ChunkingConfig{Strategy: ChunkStrategyParagraph, Size: 1}, []string{
+			"First sentence. Second one! Third?\n\n",
+			"A new paragraph here. And its end.\n\n",
+			"Last paragraph.",
+		}},
+		{ChunkingConfig{Strategy: ChunkStrategySentence, Size: 2, Overlap: 1}, []string{
+			"First sentence. Second one! ",
+			"Second one! Third?\n\n",
+			"Third?\n\nA new paragraph here. ",
+			"A new paragraph here. And its end.\n\n",
+			"And its end.\n\nLast paragraph.",
+		}},
+		{ChunkingConfig{Strategy: ChunkStrategyToken, Size: 6, Overlap: 2}, []string{
+			"First sentence. Second one! Third?\n\nA ",
+			"Third?\n\nA new paragraph here. And ",
+			"here. And its end.\n\nLast paragraph.",
+		}},
+	}
+	for _, tc := range cases {
+		chunks := ChunkDocument(text, tc.config)
+		if len(chunks) != len(tc.want) {
+			t.Errorf("%s: expected %d chunks, got %d: %q", tc.config.Strategy, len(tc.want), len(chunks), chunks)
+			continue
+		}
+		for i, c := range chunks {
+			if c.Text != tc.want[i] {
+				t.Errorf("%s chunk %d: expected %q, got %q", tc.config.Strategy, i, tc.want[i], c.Text)
+			}
+			if text[c.Offset:c.Offset+len(c.Text)] != c.Text {
+				t.Errorf("%s chunk %d: offset %d does not point at its text", tc.config.Strategy, i, c.Offset)
+			}
+		}
+	}
+}
+
+func TestMergeChunksRestoresContent(t *testing.T) {
+	text := "One. Two. Three. Four. Five. Six. Seven."
+	chunks := ChunkDocument(text, ChunkingConfig{Strategy: ChunkStrategySentence, Size: 3, Overlap: 1})
+	if len(chunks) < 2 {
+		t.Fatalf("Expected several chunks, got %d", len(chunks))
+	}
+
+	// Retrieval returns chunks in any order, mixed with other files
+	docs := chunkDocuments("numbers.txt", chunks)
+	docs[0], docs[len(docs)-1] = docs[len(docs)-1], docs[0]
+	docs = append(docs, Document{FileName: "whole.txt", Content: "Unchunked.", Metadata: map[string]string{"active": "true"}})
+
+	merged := mergeChunks(docs)
+	if len(merged) != 2 {
+		t.Fatalf("Expected 2 documents, got %d", len(merged))
+	}
+	if merged[0].Content != text {
+		t.Errorf("Expected %q, got %q", text, merged[0].Content)
+	}
+	if _, ok := merged[0].Metadata[chunkIndexKey]; ok || merged[0].Metadata["active"] != "true" {
+		t.Errorf("Expected chunk metadata to be removed and other metadata kept, got %v", merged[0].Metadata)
+	}
+	if merged[1].Content != "Unchunked." {
+		t.Errorf("Expected the unchunked document unchanged, got %q", merged[1].Content)
+	}
+}
+
+func TestChunkingConfigValidate(t *testing.T) {
+	config := ChunkingConfig{Strategy: ChunkStrategyToken}
+	if err := config.Validate(); err != nil || config.Size != defaultTokenChunkSize {
+		t.Errorf("Expected the default token size, got %d (%v)", config.Size, err)
+	}
+	if err := (&ChunkingConfig{Strategy: ChunkStrategySentence, Size: 2, Overlap: 2}).Validate(); err == nil {
+		t.Error("Expected an error for an overlap as large as the chunk")
+	}
+	if err := (&ChunkingConfig{Strategy: "words"}).Validate(); err == nil {
+		t.Error("Expected an error for an unknown strategy")
+	}
+}
@@ -0,0 +1,326 @@
+package core
+
+import (
+	"context"
+	"dk/utils"
+	"errors"
+	"fmt"
+	"github.com/philippgille/chromem-go"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// DefaultCollection is the collection used when no collection is named
+const DefaultCollection = "PersonalKnowledge"
+
+// collectionMetadataKey is added to retrieved documents searched in several collections
+const collectionMetadataKey = "collection"
+
+// searchAllCollections in CollectionsConfig.Default searches every collection
+const searchAllCollections = "*"
+
+var collectionNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ErrCollectionNotFound is returned when a named collection does not exist
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// CollectionRoute sends questions to specific collections. A route matches when the question
+// matches Pattern and the metadata filter of the query contains every pair of Metadata;
+// empty criteria are ignored, but a route needs at least one of them.
+type CollectionRoute struct {
+	Pattern     string            `json:"pattern,omitempty"`  // Case-insensitive regular expression
+	Metadata    map[string]string `json:"metadata,omitempty"` // e.g. {"api_id": "weather"}
+	Collections []string          `json:"collections"`
+
+	pattern *regexp.Regexp
+}
+
+// CollectionsConfig declares the vector collections and which of them a query searches
+type CollectionsConfig struct {
+	// Names are created at startup, next to the default collection and any collection that
+	// already exists under the vector DB path
+	Names []string `json:"names,omitempty"`
+	// Routes are evaluated in order; the collections of every matching route are searched
+	Routes []CollectionRoute `json:"routes,omitempty"`
+	// Default is searched when no route matches; "*" searches all collections. Defaults to
+	// the default collection.
+	Default []string `json:"default,omitempty"`
+}
+
+// Validate checks collection names and compiles the route patterns
+func (c *CollectionsConfig) Validate() error {
+	names := append([]string{}, c.Names...)
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		if route.Pattern == "" && len(route.Metadata) == 0 {
+			return fmt.Errorf("route %d needs a pattern or metadata to match", i+1)
+		}
+		if len(route.Collections) == 0 {
+			return fmt.Errorf("route %d has no collections", i+1)
+		}
+		if route.Pattern != "" {
+			pattern, err := regexp.Compile("(?i)" + route.Pattern)
+			if err != nil {
+				return fmt.Errorf("route %d has an invalid pattern: %w", i+1, err)
+			}
+			route.pattern = pattern
+		}
+		names = append(names, route.Collections...)
+	}
+	for _, name := range append(names, c.Default...) {
+		if name != searchAllCollections && !collectionNamePattern.MatchString(name) {
+			return fmt.Errorf("invalid collection name %q: use up to 64 letters, digits, '-' or '_'", name)
+		}
+	}
+	return nil
+}
+
+// matches reports whether a route applies to a question and its metadata filter
+func (r *CollectionRoute) matches(question string, filter map[string]string) bool {
+	if r.pattern != nil && !r.pattern.MatchString(question) {
+		return false
+	}
+	for key, value := range r.Metadata {
+		if filter[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Collections holds the named collections of the vector database, each with its own keyword index
+type Collections struct {
+	db     *chromem.DB
+	embed  chromem.EmbeddingFunc
+	config CollectionsConfig
+
+	mu          sync.Mutex
+	collections map[string]*namedCollection
+}
+
+type namedCollection struct {
+	collection *chromem.Collection
+	keywords   *KeywordIndex
+}
+
+// SetupCollections opens the vector database at vectorPath with the default collection, the
+// configured collections and every collection stored there before
+func SetupCollections(ctx context.Context, vectorPath string, config CollectionsConfig) (*Collections, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	db, err := chromem.NewPersistentDB(vectorPath, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Documents are embedded by a locally running Ollama, serving its API at "http://localhost:11434/api"
+	c := &Collections{
+		db:          db,
+		embed:       chromem.NewEmbeddingFuncOllama(embeddingModel, ""),
+		config:      config,
+		collections: make(map[string]*namedCollection),
+	}
+	// Embeddings are kept in the database, so unchanged text is not embedded again
+	if database, err := utils.DatabaseFromContext(ctx); err == nil {
+		c.embed = CachedEmbeddingFunc(database, embeddingModel, c.embed)
+	}
+
+	names := append([]string{DefaultCollection}, config.Names...)
+	for name := range db.ListCollections() {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		if _, err := c.open(ctx, name, true); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// open returns a collection, creating it when create is set
+func (c *Collections) open(ctx context.Context, name string, create bool) (*namedCollection, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if nc, ok := c.collections[name]; ok {
+		return nc, nil
+	}
+	if !collectionNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid collection name %q", name)
+	}
+
+	collection := c.db.GetCollection(name, c.embed)
+	if collection == nil {
+		if !create {
+			return nil, fmt.Errorf("%w: %s", ErrCollectionNotFound, name)
+		}
+		var err error
+		if collection, err = c.db.CreateCollection(name, nil, c.embed); err != nil {
+			return nil, fmt.Errorf("failed to create collection %s: %w", name, err)
+		}
+		log.Printf("[RAG] Created collection %s", name)
+	}
+
+	nc := &namedCollection{collection: collection, keywords: NewKeywordIndex()}
+	if err := BuildKeywordIndex(utils.WithChromemCollection(ctx, collection), nc.keywords); err != nil {
+		log.Printf("[RAG] Warning: Failed to build keyword index of collection %s: %v", name, err)
+	}
+	c.collections[name] = nc
+	return nc, nil
+}
+
+// Default returns the default collection and its keyword index
+func (c *Collections) Default() (*chromem.Collection, *KeywordIndex) {
+	nc, err := c.open(context.Background(), DefaultCollection, true)
+	if err != nil {
+		panic(err)
+	}
+	return nc.collection, nc.keywords
+}
+
+// Embed returns the embedding of text with the embedding model of the collections
+func (c *Collections) Embed(ctx context.Context, text string) ([]float32, error) {
+	return c.embed(ctx, text)
+}
+
+// CollectionInfo describes a collection for listings
+type CollectionInfo struct {
+	Name      string `json:"name"`
+	Documents int    `json:"documents"` // Stored documents, counting every chunk
+}
+
+// List returns the collections sorted by name
+func (c *Collections) List() []CollectionInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	infos := make([]CollectionInfo, 0, len(c.collections))
+	for name, nc := range c.collections {
+		infos = append(infos, CollectionInfo{Name: name, Documents: nc.collection.Count()})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Route returns the collections a question is searched in
+func (c *Collections) Route(question string, filter map[string]string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	var add func(list []string)
+	add = func(list []string) {
+		for _, name := range list {
+			if name == searchAllCollections {
+				for _, info := range c.List() {
+					add([]string{info.Name})
+				}
+			} else if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	for i := range c.config.Routes {
+		if c.config.Routes[i].matches(question, filter) {
+			add(c.config.Routes[i].Collections)
+		}
+	}
+	if len(names) == 0 {
+		add(c.config.Default)
+	}
+	if len(names) == 0 {
+		add([]string{DefaultCollection})
+	}
+	return names
+}
+
+type collectionsKey struct{}
+
+type selectedCollectionKey struct{}
+
+// WithCollections adds the named collections to the context
+func WithCollections(ctx context.Context, collections *Collections) context.Context {
+	return context.WithValue(ctx, collectionsKey{}, collections)
+}
+
+// CollectionsFromContext returns the named collections, or nil when there are none
+func CollectionsFromContext(ctx context.Context) *Collections {
+	collections, _ := ctx.Value(collectionsKey{}).(*Collections)
+	return collections
+}
+
+// UseCollection returns a context in which documents are added to, read from and searched in
+// the named collection only. An empty name leaves the context unchanged. The collection is
+// created if it does not exist and create is set.
+func UseCollection(ctx context.Context, name string, create bool) (context.Context, error) {
+	if name == "" {
+		return ctx, nil
+	}
+	collections := CollectionsFromContext(ctx)
+	if collections == nil {
+		if name == DefaultCollection {
+			return ctx, nil
+		}
+		return nil, fmt.Errorf("%w: %s", ErrCollectionNotFound, name)
+	}
+
+	nc, err := collections.open(ctx, name, create)
+	if err != nil {
+		return nil, err
+	}
+	ctx = utils.WithChromemCollection(ctx, nc.collection)
+	ctx = WithKeywordIndex(ctx, nc.keywords)
+	return context.WithValue(ctx, selectedCollectionKey{}, name), nil
+}
+
+// routeCollections returns the collections a query searches, or nil when it only searches the
+// collection of the context
+func routeCollections(ctx context.Context, question string, filter map[string]string) []string {
+	collections := CollectionsFromContext(ctx)
+	if collections == nil {
+		return nil
+	}
+	if _, selected := ctx.Value(selectedCollectionKey{}).(string); selected {
+		return nil
+	}
+	names := collections.Route(question, filter)
+	if len(names) == 1 && names[0] == DefaultCollection {
+		return nil
+	}
+	return names
+}
+
+// retrieveFromCollections searches several collections and merges the results by score
+func retrieveFromCollections(ctx context.Context, names []string, question string, numResults int, metadataFilter map[string]string) ([]Document, error) {
+	results := []Document{}
+	for _, name := range names {
+		collectionCtx, err := UseCollection(ctx, name, false)
+		if err != nil {
+			log.Printf("[RAG] Skipping collection %s: %v", name, err)
+			continue
+		}
+		docs, err := retrieveFromCollection(collectionCtx, question, numResults, metadataFilter)
+		if err != nil {
+			return nil, fmt.Errorf("collection %s: %w", name, err)
+		}
+		for _, doc := range docs {
+			metadata := make(map[string]string, len(doc.Metadata)+1)
+			for key, value := range doc.Metadata {
+				metadata[key] = value
+			}
+			metadata[collectionMetadataKey] = name
+			doc.Metadata = metadata
+			results = append(results, doc)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if len(results) > numResults {
+		results = results[:numResults]
+	}
+	log.Printf("[RAG] Merged %d results from collections %v", len(results), names)
+	return results, nil
+}
@@ -0,0 +1,125 @@
+package core
+
+import (
+	"context"
+	"dk/utils"
+	"errors"
+	"github.com/philippgille/chromem-go"
+	"strings"
+	"testing"
+)
+
+// letterEmbedding embeds text as its letter frequencies, so tests need no embedding model
+func letterEmbedding(_ context.Context, text string) ([]float32, error) {
+	vector := make([]float32, 27)
+	vector[26] = 1
+	for _, r := range strings.ToLower(text) {
+		if r >= 'a' && r <= 'z' {
+			vector[r-'a']++
+		}
+	}
+	return vector, nil
+}
+
+func newTestCollections(t *testing.T, config CollectionsConfig) *Collections {
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	return &Collections{
+		db:          chromem.NewDB(),
+		embed:       letterEmbedding,
+		config:      config,
+		collections: make(map[string]*namedCollection),
+	}
+}
+
+func addTestDocument(t *testing.T, ctx context.Context, collection, file, content string) {
+	collectionCtx, err := UseCollection(ctx, collection, true)
+	if err != nil {
+		t.Fatalf("UseCollection failed: %v", err)
+	}
+	col, _ := utils.ChromemCollectionFromContext(collectionCtx)
+	for _, doc := range chunkedDocuments(content, map[string]string{"file": file, "active": "true"}, ChunkingConfig{}) {
+		if err := col.AddDocument(collectionCtx, doc); err != nil {
+			t.Fatalf("AddDocument failed: %v", err)
+		}
+		KeywordIndexFromContext(collectionCtx).Add(doc.ID, content, doc.Metadata)
+	}
+}
+
+func TestCollectionsConfigValidate(t *testing.T) {
+	invalid := []CollectionsConfig{
+		{Names: []string{"bad name"}},
+		{Routes: []CollectionRoute{{Collections: []string{"docs"}}}},
+		{Routes: []CollectionRoute{{Pattern: "(", Collections: []string{"docs"}}}},
+		{Routes: []CollectionRoute{{Pattern: "tax"}}},
+	}
+	for i, config := range invalid {
+		if err := config.Validate(); err == nil {
+			t.Errorf("Config %d: expected a validation error", i)
+		}
+	}
+}
+
+func TestCollectionsRoute(t *testing.T) {
+	c := newTestCollections(t, CollectionsConfig{
+		Routes: []CollectionRoute{
+			{Pattern: `\b(tax|invoice)`, Collections: []string{"finance"}},
+			{Metadata: map[string]string{"api_id": "weather"}, Collections: []string{"weather", "finance"}},
+		},
+		Default: []string{DefaultCollection, "general"},
+	})
+
+	cases := []struct {
+		question string
+		filter   map[string]string
+		want     string
+	}{
+		{"How do I file my TAX return?", nil, "finance"},
+		{"Will it rain?", map[string]string{"api_id": "weather"}, "weather,finance"},
+		{"Who wrote this?", nil, DefaultCollection + ",general"},
+	}
+	for _, tc := range cases {
+		if got := strings.Join(c.Route(tc.question, tc.filter), ","); got != tc.want {
+			t.Errorf("%q: expected %s, got %s", tc.question, tc.want, got)
+		}
+	}
+}
+
+func TestRetrieveDocumentsRoutesToCollections(t *testing.T) {
+	c := newTestCollections(t, CollectionsConfig{
+		Routes: []CollectionRoute{{Pattern: "physics", Collections: []string{"physics"}}},
+	})
+	ctx := WithCollections(context.Background(), c)
+	defaultCtx, _ := UseCollection(ctx, DefaultCollection, true)
+	ctx = WithKeywordIndex(ctx, KeywordIndexFromContext(defaultCtx))
+
+	addTestDocument(t, ctx, DefaultCollection, "notes.txt", "Shopping list: apples and pears")
+	addTestDocument(t, ctx, "physics", "relativity.txt", "Physics: Einstein published relativity in 1905")
+
+	// Routed questions search the routed collection only
+	docs, err := RetrieveDocuments(ctx, "physics of relativity", 5, nil)
+	if err != nil {
+		t.Fatalf("RetrieveDocuments failed: %v", err)
+	}
+	if len(docs) != 1 || docs[0].FileName != "relativity.txt" || docs[0].Metadata["collection"] != "physics" {
+		t.Errorf("Expected the physics document, got %+v", docs)
+	}
+
+	// A selected collection overrides the routes
+	selected, err := UseCollection(ctx, DefaultCollection, false)
+	if err != nil {
+		t.Fatalf("UseCollection failed: %v", err)
+	}
+	docs, _ = RetrieveDocuments(selected, "physics apples", 5, nil)
+	if len(docs) != 1 || docs[0].FileName != "notes.txt" {
+		t.Errorf("Expected only the default collection to be searched, got %+v", docs)
+	}
+
+	if _, err := UseCollection(ctx, "missing", false); !errors.Is(err, ErrCollectionNotFound) {
+		t.Errorf("Expected ErrCollectionNotFound, got %v", err)
+	}
+	if infos := c.List(); len(infos) != 2 || infos[1].Name != "physics" || infos[1].Documents != 1 {
+		t.Errorf("Unexpected collection list: %+v", infos)
+	}
+}
@@ -0,0 +1,33 @@
+package core
+
+import (
+	dk_client "dk/client"
+	"log"
+	"sync/atomic"
+)
+
+// offline is set while the connection to the server is lost, so the node does not generate
+// queries that could only wait in the outbox.
+var offline atomic.Bool
+
+// ConnectionHooks returns the client hooks logging the connection state changes of the node
+// and pausing its scheduled queries while the connection is lost.
+func ConnectionHooks() dk_client.Hooks {
+	return dk_client.Hooks{
+		OnConnect: func(serverURL string) {
+			if offline.Swap(false) {
+				log.Printf("[Connection] Connection to %s is back; resuming scheduled queries", serverURL)
+				return
+			}
+			log.Printf("[Connection] Connected to %s", serverURL)
+		},
+		OnDisconnect: func(err error) {
+			if err == nil {
+				log.Printf("[Connection] Disconnected from the server")
+				return
+			}
+			offline.Store(true)
+			log.Printf("[Connection] Lost the connection to the server: %v; pausing scheduled queries", err)
+		},
+	}
+}
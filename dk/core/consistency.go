@@ -0,0 +1,182 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"fmt"
+	"github.com/philippgille/chromem-go"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OrphanedDocument is a filename referenced by document associations that has no
+// embeddings in the vector store
+type OrphanedDocument struct {
+	Filename     string                    `json:"filename"`
+	Associations []*db.DocumentAssociation `json:"associations"`
+}
+
+// ConsistencyReport is the result of cross-checking document associations against the vector store
+type ConsistencyReport struct {
+	CheckedAt             time.Time          `json:"checked_at"`
+	VectorDocuments       int                `json:"vector_documents"`
+	AssociatedFilenames   int                `json:"associated_filenames"`
+	OrphanedDocuments     []OrphanedDocument `json:"orphaned_documents"`
+	UnassociatedDocuments []string           `json:"unassociated_documents"`
+	Repaired              bool               `json:"repaired"`
+	RemovedAssociations   int                `json:"removed_associations"`
+}
+
+var (
+	lastConsistencyReport   *ConsistencyReport
+	lastConsistencyReportMu sync.RWMutex
+)
+
+// LastConsistencyReport returns the report of the most recent consistency check, or nil if none ran yet
+func LastConsistencyReport() *ConsistencyReport {
+	lastConsistencyReportMu.RLock()
+	defer lastConsistencyReportMu.RUnlock()
+	return lastConsistencyReport
+}
+
+// vectorStoreFilenames returns the set of filenames that have at least one document in any
+// collection, or in the collection of the context when there are no named collections
+func vectorStoreFilenames(ctx context.Context) (map[string]bool, error) {
+	filenames := make(map[string]bool)
+	collections := CollectionsFromContext(ctx)
+	if collections == nil {
+		chromemCollection, err := utils.ChromemCollectionFromContext(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get Chromem collection: %w", err)
+		}
+		return filenames, addCollectionFilenames(ctx, chromemCollection, filenames)
+	}
+
+	for _, info := range collections.List() {
+		nc, err := collections.open(ctx, info.Name, false)
+		if err != nil {
+			return nil, err
+		}
+		if err := addCollectionFilenames(ctx, nc.collection, filenames); err != nil {
+			return nil, fmt.Errorf("collection %s: %w", info.Name, err)
+		}
+	}
+	return filenames, nil
+}
+
+// addCollectionFilenames adds the filenames of the documents of a collection to filenames
+func addCollectionFilenames(ctx context.Context, chromemCollection *chromem.Collection, filenames map[string]bool) error {
+	// Soft-deleted documents keep their associations, so that they can be restored
+	results, err := allDocuments(ctx, chromemCollection, true)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve documents: %w", err)
+	}
+
+	for _, doc := range results {
+		if filename := doc.Metadata["file"]; filename != "" {
+			filenames[filename] = true
+		}
+	}
+	return nil
+}
+
+// CheckDocumentConsistency cross-checks the document associations against the contents of the
+// vector store. Associations whose filename has no embeddings are reported as orphans and, when
+// repair is true, deleted. Documents without associations are only reported, since documents
+// are not required to belong to an API or request.
+func CheckDocumentConsistency(ctx context.Context, repair bool) (*ConsistencyReport, error) {
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+
+	associated, err := db.ListAssociatedDocumentFilenames(database)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := vectorStoreFilenames(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ConsistencyReport{
+		CheckedAt:             time.Now(),
+		VectorDocuments:       len(stored),
+		AssociatedFilenames:   len(associated),
+		OrphanedDocuments:     []OrphanedDocument{},
+		UnassociatedDocuments: []string{},
+		Repaired:              repair,
+	}
+
+	for filename := range associated {
+		if stored[filename] {
+			continue
+		}
+
+		associations, err := db.GetAllAssociationsForDocument(database, filename)
+		if err != nil {
+			return nil, err
+		}
+		report.OrphanedDocuments = append(report.OrphanedDocuments, OrphanedDocument{
+			Filename:     filename,
+			Associations: associations,
+		})
+	}
+	sort.Slice(report.OrphanedDocuments, func(i, j int) bool {
+		return report.OrphanedDocuments[i].Filename < report.OrphanedDocuments[j].Filename
+	})
+
+	for filename := range stored {
+		if _, ok := associated[filename]; !ok {
+			report.UnassociatedDocuments = append(report.UnassociatedDocuments, filename)
+		}
+	}
+	sort.Strings(report.UnassociatedDocuments)
+
+	if repair {
+		for _, orphan := range report.OrphanedDocuments {
+			if err := db.DeleteAllDocumentAssociationsByFilename(database, orphan.Filename); err != nil {
+				return report, err
+			}
+			report.RemovedAssociations += len(orphan.Associations)
+			log.Printf("[RAG] Removed %d orphaned associations for document '%s'", len(orphan.Associations), orphan.Filename)
+		}
+	}
+
+	lastConsistencyReportMu.Lock()
+	lastConsistencyReport = report
+	lastConsistencyReportMu.Unlock()
+
+	return report, nil
+}
+
+// StartConsistencyWorker begins a background worker that periodically checks document associations
+// against the vector store. Orphaned associations are deleted only when repair is true.
+func StartConsistencyWorker(ctx context.Context, checkInterval time.Duration, repair bool) {
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Consistency worker shutting down")
+				return
+			case <-ticker.C:
+				report, err := CheckDocumentConsistency(ctx, repair)
+				if err != nil {
+					log.Printf("Error checking document consistency: %v", err)
+					continue
+				}
+				log.Printf("Document consistency check found %d orphaned and %d unassociated documents (removed %d associations)",
+					len(report.OrphanedDocuments), len(report.UnassociatedDocuments), report.RemovedAssociations)
+			}
+		}
+	}()
+
+	log.Printf("Consistency worker started with check interval of %v (repair: %v)", checkInterval, repair)
+}
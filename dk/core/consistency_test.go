@@ -0,0 +1,51 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"testing"
+)
+
+func TestCheckDocumentConsistency(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunAPIMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	c := newTestCollections(t, CollectionsConfig{})
+	ctx := WithCollections(context.Background(), c)
+	ctx = utils.WithDatabase(ctx, testDB.DB)
+	addTestDocument(t, ctx, DefaultCollection, "notes.txt", "Shopping list: apples and pears")
+	addTestDocument(t, ctx, "physics", "relativity.txt", "Einstein published relativity in 1905")
+	addTestDocument(t, ctx, "physics", "unlinked.txt", "Bohr model of the atom")
+	ctx, err = UseCollection(ctx, DefaultCollection, false)
+	if err != nil {
+		t.Fatalf("UseCollection failed: %v", err)
+	}
+
+	for _, filename := range []string{"notes.txt", "relativity.txt", "deleted.txt"} {
+		if err := db.CreateDocumentAssociation(testDB.DB, &db.DocumentAssociation{DocumentFilename: filename, EntityID: "api-1", EntityType: "api"}); err != nil {
+			t.Fatalf("CreateDocumentAssociation failed: %v", err)
+		}
+	}
+
+	// Documents of every collection count, not only those of the default one
+	report, err := CheckDocumentConsistency(ctx, true)
+	if err != nil {
+		t.Fatalf("CheckDocumentConsistency failed: %v", err)
+	}
+	if len(report.OrphanedDocuments) != 1 || report.OrphanedDocuments[0].Filename != "deleted.txt" || report.RemovedAssociations != 1 {
+		t.Errorf("Expected only deleted.txt to be orphaned, got %+v", report.OrphanedDocuments)
+	}
+	if len(report.UnassociatedDocuments) != 1 || report.UnassociatedDocuments[0] != "unlinked.txt" {
+		t.Errorf("Expected unlinked.txt to be unassociated, got %v", report.UnassociatedDocuments)
+	}
+	if associations, _ := db.GetAllAssociationsForDocument(testDB.DB, "relativity.txt"); len(associations) != 1 {
+		t.Errorf("Expected the association of a document in a named collection to be kept, got %d", len(associations))
+	}
+}
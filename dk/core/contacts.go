@@ -0,0 +1,196 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoTaggedPeers is returned when questions are addressed to tags no peer has
+var ErrNoTaggedPeers = errors.New("no peer is tagged with these tags")
+
+// Peer is a peer known from the server or from the contacts of the host
+type Peer struct {
+	ID     string   `json:"id"`
+	Status string   `json:"status"` // "online", "offline" or "unknown" when the server does not list the peer
+	Alias  string   `json:"alias,omitempty"`
+	Notes  string   `json:"notes,omitempty"`
+	Tags   []string `json:"tags"`
+}
+
+// ListPeers returns the peers the server lists, online first, followed by contacts the server
+// does not list. With tags, only peers tagged with any of them are returned.
+func ListPeers(ctx context.Context, tags []string) ([]Peer, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	contacts, err := db.ListContacts(ctx, database)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]db.Contact, len(contacts))
+	for _, contact := range contacts {
+		byID[contact.PeerID] = contact
+	}
+
+	var online, offline []string
+	if dkClient, err := utils.DkFromContext(ctx); err == nil {
+		status, err := dkClient.GetActiveUsers()
+		if err != nil {
+			return nil, fmt.Errorf("get active users: %w", err)
+		}
+		online, offline = status.Online, status.Offline
+	}
+
+	peers := []Peer{}
+	listed := make(map[string]bool)
+	add := func(id, status string) {
+		if listed[id] {
+			return
+		}
+		listed[id] = true
+		peer := Peer{ID: id, Status: status, Tags: []string{}}
+		if contact, ok := byID[id]; ok {
+			peer.Alias, peer.Notes, peer.Tags = contact.Alias, contact.Notes, contact.Tags
+		}
+		peers = append(peers, peer)
+	}
+	for _, id := range online {
+		add(id, "online")
+	}
+	for _, id := range offline {
+		add(id, "offline")
+	}
+	for _, contact := range contacts {
+		add(contact.PeerID, "unknown")
+	}
+
+	if wanted := normalizeTags(tags); len(wanted) > 0 {
+		filtered := []Peer{}
+		for _, peer := range peers {
+			if hasAnyTag(peer.Tags, wanted) {
+				filtered = append(filtered, peer)
+			}
+		}
+		peers = filtered
+	}
+	return peers, nil
+}
+
+func hasAnyTag(tags, wanted []string) bool {
+	for _, tag := range tags {
+		for _, w := range wanted {
+			if tag == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UpdateContact sets the alias and notes of a peer; nil leaves a value unchanged and an empty
+// alias removes it. An alias may not be the ID of another contact.
+func UpdateContact(ctx context.Context, peerID string, alias, notes *string) (*db.Contact, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	peerID = peerName(peerID)
+	if peerID == "" {
+		return nil, errors.New("peer is required")
+	}
+	if alias != nil {
+		trimmed := peerName(*alias)
+		if strings.ContainsAny(trimmed, " \t\n,") {
+			return nil, fmt.Errorf("invalid alias %q: aliases are single words", trimmed)
+		}
+		if trimmed != "" && trimmed != peerID {
+			if _, err := db.GetContact(ctx, database, trimmed); err == nil {
+				return nil, fmt.Errorf("invalid alias %q: it is the ID of another contact", trimmed)
+			}
+		}
+		alias = &trimmed
+	}
+	return db.SaveContact(ctx, database, peerID, alias, notes, time.Now())
+}
+
+// TagPeer adds and removes the dataset tags of a peer
+func TagPeer(ctx context.Context, peerID string, add, remove []string) (*db.Contact, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	peerID = peerName(peerID)
+	if peerID == "" {
+		return nil, errors.New("peer is required")
+	}
+	if id, err := resolveAlias(ctx, peerID); err == nil {
+		peerID = id
+	}
+	return db.TagContact(ctx, database, peerID, normalizeTags(add), normalizeTags(remove), time.Now())
+}
+
+// ResolvePeers turns the peers a question is addressed to into peer IDs: aliases are replaced
+// by the peer they name, and peers tagged with any of the tags are added. It returns
+// ErrNoTaggedPeers when tags are given but no peer has them, so that a question meant for a
+// group is never broadcast to everyone.
+func ResolvePeers(ctx context.Context, peers, tags []string) ([]string, error) {
+	resolved := []string{}
+	seen := make(map[string]bool)
+	add := func(id string) {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			resolved = append(resolved, id)
+		}
+	}
+	for _, peer := range peers {
+		id := peerName(peer)
+		if aliased, err := resolveAlias(ctx, id); err == nil {
+			id = aliased
+		}
+		add(id)
+	}
+
+	tags = normalizeTags(tags)
+	if len(tags) == 0 {
+		return resolved, nil
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tagged, err := db.ListPeersTagged(ctx, database, tags)
+	if err != nil {
+		return nil, err
+	}
+	if len(tagged) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoTaggedPeers, strings.Join(tags, ", "))
+	}
+	for _, id := range tagged {
+		add(id)
+	}
+	return resolved, nil
+}
+
+// resolveAlias returns the peer an alias names
+func resolveAlias(ctx context.Context, alias string) (string, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	contact, err := db.GetContactByAlias(ctx, database, alias)
+	if err != nil {
+		return "", err
+	}
+	return contact.PeerID, nil
+}
+
+// peerName trims a peer identifier and its '@' prefix
+func peerName(peer string) string {
+	return strings.TrimPrefix(strings.TrimSpace(peer), "@")
+}
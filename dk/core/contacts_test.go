@@ -0,0 +1,68 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestContacts(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := utils.WithDatabase(context.Background(), testDB.DB)
+
+	alias, notes := "@gene", "Runs the sequencing lab"
+	contact, err := UpdateContact(ctx, "@alice", &alias, &notes)
+	if err != nil {
+		t.Fatalf("UpdateContact failed: %v", err)
+	}
+	if contact.PeerID != "alice" || contact.Alias != "gene" || contact.Notes != notes {
+		t.Errorf("Unexpected contact: %+v", contact)
+	}
+	// Notes are kept when only the alias changes, and an alias names a single peer
+	alias = "genie"
+	if contact, err = UpdateContact(ctx, "alice", &alias, nil); err != nil || contact.Notes != notes {
+		t.Errorf("Expected the notes to be kept, got %+v (%v)", contact, err)
+	}
+	if _, err := UpdateContact(ctx, "bob", &alias, nil); !errors.Is(err, db.ErrAliasTaken) {
+		t.Errorf("Expected ErrAliasTaken, got %v", err)
+	}
+
+	if _, err := TagPeer(ctx, "genie", []string{"Genomics", "proteomics"}, nil); err != nil {
+		t.Fatalf("TagPeer failed: %v", err)
+	}
+	if contact, err = TagPeer(ctx, "carol", []string{"genomics"}, nil); err != nil || !reflect.DeepEqual(contact.Tags, []string{"genomics"}) {
+		t.Fatalf("Expected carol to be tagged, got %+v (%v)", contact, err)
+	}
+	if contact, err = TagPeer(ctx, "alice", nil, []string{"proteomics"}); err != nil || !reflect.DeepEqual(contact.Tags, []string{"genomics"}) {
+		t.Errorf("Expected the tag to be removed, got %+v (%v)", contact, err)
+	}
+
+	peers, err := ResolvePeers(ctx, []string{"@genie", "dave"}, []string{"GENOMICS"})
+	if err != nil {
+		t.Fatalf("ResolvePeers failed: %v", err)
+	}
+	if !reflect.DeepEqual(peers, []string{"alice", "dave", "carol"}) {
+		t.Errorf("Unexpected peers: %v", peers)
+	}
+	if _, err := ResolvePeers(ctx, nil, []string{"astronomy"}); !errors.Is(err, ErrNoTaggedPeers) {
+		t.Errorf("Expected ErrNoTaggedPeers, got %v", err)
+	}
+
+	listed, err := ListPeers(ctx, []string{"genomics"})
+	if err != nil {
+		t.Fatalf("ListPeers failed: %v", err)
+	}
+	if len(listed) != 2 || listed[0].ID != "alice" || listed[0].Alias != "genie" || listed[1].ID != "carol" || listed[1].Status != "unknown" {
+		t.Errorf("Unexpected peers: %+v", listed)
+	}
+}
@@ -0,0 +1,167 @@
+package core
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// defaultCompletionReserve is the room kept for the answer when a model configuration does
+// not set max_tokens
+const defaultCompletionReserve = 1024
+
+// minPackedTokens is the smallest remainder of the window worth filling with a truncated
+// document; less than this would only give the model a fragment
+const minPackedTokens = 32
+
+// modelContextWindows are the context windows, in tokens, of well known models by name
+// prefix. Longer prefixes take precedence, so "gpt-4o" wins over "gpt-4".
+var modelContextWindows = map[string]int{
+	"gpt-3.5-turbo": 16385,
+	"gpt-4":         8192,
+	"gpt-4-turbo":   128000,
+	"gpt-4o":        128000,
+	"gpt-4.1":       1047576,
+	"o1":            200000,
+	"o3":            200000,
+	"o4-mini":       200000,
+	"claude":        200000,
+	"llama2":        4096,
+	"llama3":        8192,
+	"llama3.1":      128000,
+	"llama3.2":      128000,
+	"llama3.3":      128000,
+	"mistral":       32768,
+	"mixtral":       32768,
+	"gemma":         8192,
+	"gemma2":        8192,
+	"gemma3":        128000,
+	"qwen2.5":       32768,
+	"phi3":          4096,
+}
+
+// ModelContextWindow returns the context window of a model, or 0 if it is not known
+func ModelContextWindow(model string) int {
+	model = strings.ToLower(model)
+	window, matched := 0, 0
+	for prefix, tokens := range modelContextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > matched {
+			window, matched = tokens, len(prefix)
+		}
+	}
+	return window
+}
+
+// PromptWindow returns the number of tokens a prompt may use with a model configuration: the
+// context window of the model less the room reserved for the answer. For a fallback chain it is
+// the smallest window of the chain, so that prompts fit whichever provider serves them. It
+// returns 0 when the window of a model is not known.
+func PromptWindow(config *ModelConfig) int {
+	if len(config.Providers) > 0 {
+		smallest := 0
+		for i := range config.Providers {
+			window := PromptWindow(&config.Providers[i])
+			if window == 0 {
+				return 0
+			}
+			if smallest == 0 || window < smallest {
+				smallest = window
+			}
+		}
+		return smallest
+	}
+
+	window := config.ContextWindow
+	if window == 0 {
+		window = ModelContextWindow(config.Model)
+	}
+	if window == 0 {
+		return 0
+	}
+	reserve := defaultCompletionReserve
+	if maxTokens, ok := config.Parameters["max_tokens"].(float64); ok && maxTokens > 0 {
+		reserve = int(maxTokens)
+	}
+	if reserve >= window {
+		return 0
+	}
+	return window - reserve
+}
+
+type promptWindowKey struct{}
+
+// WithPromptWindow adds the number of tokens the active model accepts in a prompt to the context
+func WithPromptWindow(ctx context.Context, tokens int) context.Context {
+	return context.WithValue(ctx, promptWindowKey{}, tokens)
+}
+
+// PromptWindowFromContext returns the prompt window of the context, or 0 if there is none
+func PromptWindowFromContext(ctx context.Context) int {
+	tokens, _ := ctx.Value(promptWindowKey{}).(int)
+	return tokens
+}
+
+// packContext fits the highest scoring documents into available tokens. docTokens holds the
+// token count of each document. The rules are deterministic:
+//
+//   - documents are ranked by score, ties keeping their retrieval order;
+//   - in rank order, each document that fits whole is kept, and one that does not is skipped
+//     so that smaller, lower ranked documents may still use the room;
+//   - if a document was skipped and at least minPackedTokens remain, the highest ranked
+//     skipped document is cut to the remainder, at the end of a sentence when one falls in
+//     the second half of the cut.
+//
+// Kept documents are returned in rank order along with the tokens they use.
+func packContext(counter TokenCounter, docs []Document, docTokens []int, available int) ([]Document, int) {
+	order := make([]int, len(docs))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return docs[order[a]].Score > docs[order[b]].Score
+	})
+
+	keep := make([]bool, len(docs))
+	used := 0
+	skipped := -1
+	for _, i := range order {
+		if used+docTokens[i] <= available {
+			keep[i] = true
+			used += docTokens[i]
+		} else if skipped < 0 {
+			skipped = i
+		}
+	}
+
+	var cut string
+	if skipped >= 0 && available-used >= minPackedTokens {
+		cut = truncateToSentence(truncateToTokens(docs[skipped].Content, available-used))
+		if tokens := counter.CountTokens(cut); tokens > 0 && used+tokens <= available {
+			keep[skipped] = true
+			used += tokens
+		}
+	}
+
+	kept := make([]Document, 0, len(docs))
+	for _, i := range order {
+		if !keep[i] {
+			continue
+		}
+		doc := docs[i]
+		if i == skipped {
+			doc.Content = cut
+		}
+		kept = append(kept, doc)
+	}
+	return kept, used
+}
+
+// truncateToSentence cuts text after its last sentence end, provided that keeps at least half
+// of it
+func truncateToSentence(text string) string {
+	end := strings.LastIndexAny(text, ".!?\n")
+	if end < len(text)/2 {
+		return text
+	}
+	return text[:end+1]
+}
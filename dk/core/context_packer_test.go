@@ -0,0 +1,80 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPackContext(t *testing.T) {
+	docs := []Document{
+		{FileName: "low.txt", Content: strings.Repeat("low ", 40), Score: 0.2},
+		{FileName: "large.txt", Content: strings.Repeat("Large sentence here. ", 40), Score: 0.9},
+		{FileName: "high.txt", Content: strings.Repeat("high ", 40), Score: 0.9},
+		{FileName: "mid.txt", Content: strings.Repeat("mid ", 40), Score: 0.5},
+	}
+	docTokens := make([]int, len(docs))
+	for i, doc := range docs {
+		docTokens[i] = DefaultTokenCounter.CountTokens(doc.Content)
+	}
+
+	// large.txt ranks first but does not fit whole; the documents after it do, and large.txt
+	// is cut at a sentence end to fill the rest
+	available := docTokens[2] + docTokens[3] + docTokens[0] + 60
+	kept, used := packContext(DefaultTokenCounter, docs, docTokens, available)
+	var names []string
+	for _, doc := range kept {
+		names = append(names, doc.FileName)
+	}
+	if got := strings.Join(names, ","); got != "large.txt,high.txt,mid.txt,low.txt" {
+		t.Fatalf("Expected documents in rank order, got %s", got)
+	}
+	if used > available {
+		t.Errorf("Packed %d tokens into %d", used, available)
+	}
+	if cut := kept[0].Content; len(cut) >= len(docs[1].Content) || !strings.HasSuffix(cut, ".") {
+		t.Errorf("Expected large.txt cut at a sentence end, got %q", cut)
+	}
+
+	// Packing is deterministic
+	again, _ := packContext(DefaultTokenCounter, docs, docTokens, available)
+	for i := range kept {
+		if again[i].FileName != kept[i].FileName || again[i].Content != kept[i].Content {
+			t.Fatalf("Expected the same packing twice")
+		}
+	}
+
+	// A remainder under minPackedTokens is left empty rather than filled with a fragment
+	kept, _ = packContext(DefaultTokenCounter, docs, docTokens, docTokens[2]+minPackedTokens-1)
+	if len(kept) != 1 || kept[0].FileName != "high.txt" {
+		t.Errorf("Expected only high.txt to be kept, got %d documents", len(kept))
+	}
+}
+
+func TestPromptWindow(t *testing.T) {
+	if window := ModelContextWindow("gpt-4o-mini"); window != 128000 {
+		t.Errorf("Expected gpt-4o-mini to match gpt-4o, got %d", window)
+	}
+	if window := ModelContextWindow("gpt-4-0613"); window != 8192 {
+		t.Errorf("Expected gpt-4-0613 to match gpt-4, got %d", window)
+	}
+
+	config := &ModelConfig{Model: "llama3", Parameters: map[string]any{"max_tokens": float64(2000)}}
+	if window := PromptWindow(config); window != 8192-2000 {
+		t.Errorf("Expected max_tokens to be reserved for the answer, got %d", window)
+	}
+	chain := &ModelConfig{Providers: []ModelConfig{{Model: "claude-3-5-sonnet"}, {Model: "custom", ContextWindow: 4096}}}
+	if window := PromptWindow(chain); window != 4096-defaultCompletionReserve {
+		t.Errorf("Expected the smallest window of the chain, got %d", window)
+	}
+	if window := PromptWindow(&ModelConfig{Model: "unknown-model"}); window != 0 {
+		t.Errorf("Expected unknown models to be unlimited, got %d", window)
+	}
+
+	// The window applies even without a token budget
+	docs := []Document{{FileName: "a.txt", Content: strings.Repeat("alpha beta gamma ", 500)}}
+	_, total, _ := EnforceTokenBudget(DefaultTokenCounter, nil, 0, DefaultPromptTemplates, "question", docs)
+	kept, tokens, err := EnforceTokenBudget(DefaultTokenCounter, nil, total/2, DefaultPromptTemplates, "question", docs)
+	if err != nil || tokens > total/2 || len(kept) != 1 {
+		t.Errorf("Expected the prompt packed into the window, got %d tokens (%v)", tokens, err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"dk/db"
 	"dk/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -20,33 +21,56 @@ func HandleRequests(ctx context.Context) {
 		fmt.Println("Error getting client from context:", err)
 		return
 	}
-	var query utils.RemoteMessage
 	for msg := range client.Messages() {
-		err := json.Unmarshal([]byte(msg.Content), &query)
-		if err != nil || strings.TrimSpace(query.Message) == "" {
-			fmt.Println("Error unmarshaling message content:", err, "skipping item")
-			// return nil, fmt.Errorf("'question' parameter is required")
+		// A broadcast that does not come from its claimed sender is not handled
+		if msg.Status == "spoofed" {
+			log.Printf("Skipping spoofed broadcast claiming to be from %s", msg.From)
+			continue
 		}
-		if query.Type == "query" {
+		query, err := utils.DecodeEnvelope(msg.Content)
+		if err != nil {
+			log.Printf("Skipping message from %s: %v", msg.From, err)
+			client.MarkRead(msg)
+			continue
+		}
+		if query.Type == utils.MessageTypeQuery {
+			// The first question of a peer starts the capability handshake with it
+			if handshakes := HandshakesFromContext(ctx); handshakes != nil {
+				handshakes.Start(ctx, msg.From)
+			}
 			HandleQuery(ctx, msg)
-		} else if query.Type == "app" {
+		} else if query.Type == utils.MessageTypeApp {
 			HandleApplicationRequest(ctx, msg)
-		} else if query.Type == "forward" {
+		} else if query.Type == utils.MessageTypeForward {
 			HandleForwardMessage(ctx, msg)
+		} else if query.Type == EscrowShareMessageType || query.Type == EscrowRecoveryMessageType || query.Type == EscrowReleaseMessageType {
+			if _, err := HandleEscrowMessage(ctx, msg); err != nil {
+				log.Printf("[Escrow] %v", err)
+			}
+		} else if query.Type == HandshakeRequestMessageType || query.Type == HandshakeReplyMessageType {
+			if _, err := HandleHandshakeMessage(ctx, msg); err != nil {
+				log.Printf("[Handshake] %v", err)
+			}
+		} else if query.Type == AttachmentMessageType {
+			if _, err := HandleAttachmentMessage(ctx, msg); err != nil {
+				log.Printf("[Attachment] %v", err)
+			}
 		} else {
 			HandleAnswer(ctx, msg)
 		}
+		// The sender learns that the message was handled
+		client.MarkRead(msg)
 	}
 }
 
 func HandleQuery(ctx context.Context, msg dk_client.Message) (string, error) {
-	var query utils.RemoteMessage
-	err := json.Unmarshal([]byte(msg.Content), &query)
-	if err != nil || strings.TrimSpace(query.Message) == "" {
+	query, err := utils.DecodePayloadAs[*utils.QueryPayload](msg.Content)
+	if err != nil || strings.TrimSpace(query.Question) == "" {
 		return "", fmt.Errorf("failed to parse message or empty question")
 	}
 
 	origin := msg.From
+	RecordFeature(ctx, TelemetryQueries, "received")
 
 	// Get app parameters
 	params, err := utils.ParamsFromContext(ctx)
@@ -77,18 +101,35 @@ func HandleQuery(ctx context.Context, msg dk_client.Message) (string, error) {
 		}
 	}
 
-	// Retrieve relevant documents with empty metadata filter
-	docs, err := RetrieveDocuments(ctx, query.Message, 3, make(map[string]string))
+	apiID := apiIDFromMetadata(query.Metadata)
+	if err := checkCredits(ctx, apiID, origin); err != nil {
+		recordTokenUsage(ctx, apiID, origin, "query", TokenUsage{}, true)
+		return "", err
+	}
 
+	// Retrieve relevant documents and generate the answer using the LLM provider
+	answerCtx, trace := WithProviderTrace(ctx)
+	pipeline := NewAnswerPipeline(ctx, llmProvider)
+	if filter, ok := PeerFiltersFromContext(ctx).For(origin); ok {
+		// Only documents the peer is entitled to are used. Accepted answers may have drawn
+		// on other documents, so they are not reused.
+		answerCtx = WithMetadataFilter(answerCtx, filter)
+		pipeline.MatchFAQ = nil
+	}
+	answerCtx, restricted, err := withPeerDocumentAccess(answerCtx, origin)
 	if err != nil {
-		return "", fmt.Errorf("failed to retrieve documents: %v", err)
+		return "", err
 	}
-
-	// Generate answer using the LLM provider
-	answer, err := llmProvider.GenerateAnswer(ctx, query.Message, docs)
+	if restricted {
+		// Only documents of the APIs the peer can access are used
+		pipeline.MatchFAQ = nil
+	}
+	answer, result, err := pipeline.Answer(answerCtx, query.Question)
+	recordTokenUsage(ctx, apiID, origin, "query", result.Usage, errors.Is(err, ErrTokenBudgetExceeded))
 	if err != nil {
 		return "", fmt.Errorf("failed to generate answer: %v", err)
 	}
+	docs := result.Docs
 
 	// Generate new query ID
 	newID, err := generateQueryID()
@@ -106,16 +147,12 @@ func HandleQuery(ctx context.Context, msg dk_client.Message) (string, error) {
 	newQuery := Query{
 		ID:               newID,
 		From:             origin,
-		Question:         query.Message,
+		Question:         query.Question,
 		Answer:           answer,
 		DocumentsRelated: docFilenames,
 		Status:           "pending",
 	}
 
-	// Check for automatic approval
-	var reason string
-	var automaticApproval bool
-
 	// ------------------------------------------------------------------
 	//  ➤  Persist into SQLite instead of queries.json
 	// ------------------------------------------------------------------
@@ -132,66 +169,80 @@ func HandleQuery(ctx context.Context, msg dk_client.Message) (string, error) {
 	newQueryItem := db.Query{
 		ID:               newID,
 		From:             origin,
-		Question:         query.Message,
+		Question:         query.Question,
 		Answer:           answer,
 		DocumentsRelated: docJSONNames,
 		Status:           "pending",
-		Reason:           reason,
 	}
 
-	automaticApprovalRules, err := db.ListRules(ctx, dbInstance)
-
-	if err == nil {
-		if len(automaticApprovalRules) != 0 {
-			reason, automaticApproval, err = llmProvider.CheckAutomaticApproval(ctx, answer, newQuery, automaticApprovalRules)
-			if err != nil {
-				reason = fmt.Sprintf("Error checking automatic approval: %v", err)
-				automaticApproval = false
-			}
-		} else {
-			reason = "There's not condition for automatic approval"
-			automaticApproval = false
+	// The automatic approval conditions may accept or reject the query; when none applies, or
+	// they cannot be evaluated, it waits for the host
+	evaluation := ApprovalEvaluation{Decision: db.ApprovalNone, Reason: "Error recovering automatic approval rules from database."}
+	approvalCtx, approvalTrace := WithProviderTrace(ctx)
+	automaticApprovalRules, rulesErr := db.ListRules(ctx, dbInstance)
+	if rulesErr == nil {
+		evaluation, err = evaluateApprovalConditions(approvalCtx, llmProvider, newQuery, automaticApprovalRules)
+		if err != nil {
+			log.Printf("[Approval] Failed to evaluate the conditions for a query from %s: %v", origin, err)
 		}
-	} else {
-		reason = "Error recovering automatic approval rules from database."
-		automaticApproval = false
 	}
-
-	if automaticApproval {
+	switch evaluation.Decision {
+	case db.ApprovalAccept:
 		newQueryItem.Status = "accepted"
+	case db.ApprovalReject:
+		newQueryItem.Status = "rejected"
 	}
-	newQueryItem.Reason = reason
+	newQueryItem.Reason = evaluation.Reason
+	automaticApproval := evaluation.Decision == db.ApprovalAccept
 
 	if err := db.InsertQuery(ctx, dbInstance, newQueryItem); err != nil {
 		return "", err
 	}
+	notifyChange(QueriesChanged)
+	if result.CalledProvider() {
+		recordProviderUsage(ctx, llmProvider, trace, "answer", newQueryItem.ID)
+	}
+	if rulesErr != nil || len(automaticApprovalRules) > 0 {
+		decision := db.ApprovalDecision{
+			QueryID:   newQueryItem.ID,
+			Decision:  evaluation.Decision,
+			Condition: evaluation.Condition,
+			Reason:    evaluation.Reason,
+		}
+		if err := db.InsertApprovalDecision(ctx, dbInstance, decision); err != nil {
+			log.Printf("[Approval] %v", err)
+		}
+	}
+	if len(automaticApprovalRules) > 0 {
+		recordProviderUsage(ctx, llmProvider, approvalTrace, "approval", newQueryItem.ID)
+	}
+
+	// Summarize the query for the host's triage; a failed screening does not hold it up
+	screenCtx, screenTrace := WithProviderTrace(ctx)
+	screening, screened := screenQuery(screenCtx, llmProvider, query.Question, answer, len(docs))
+	if err := db.SaveQueryScreening(ctx, dbInstance, newQueryItem.ID, screening); err != nil {
+		log.Printf("[Screening] %v", err)
+	}
+	if screened {
+		recordProviderUsage(ctx, llmProvider, screenTrace, "screening", newQueryItem.ID)
+	}
 
 	// If automatically approved, send the answer
 	if automaticApproval {
 		dkClient, err := utils.DkFromContext(ctx)
 		if err == nil {
-			answerMessage := utils.AnswerMessage{
+			content, err := utils.EncodePayload(&utils.AnswerPayload{
 				Query:  newQueryItem.Question,
 				Answer: newQueryItem.Answer,
 				From:   dkClient.UserID,
-			}
-
-			jsonAnswer, err := json.Marshal(answerMessage)
+			})
 			if err == nil {
-				queryMsg := utils.RemoteMessage{
-					Type:    "answer",
-					Message: string(jsonAnswer),
-				}
-
-				jsonData, err := json.Marshal(queryMsg)
-				if err == nil {
-					dkClient.SendMessage(dk_client.Message{
-						From:      dkClient.UserID,
-						To:        newQueryItem.From,
-						Content:   string(jsonData),
-						Timestamp: time.Now(),
-					})
-				}
+				dkClient.SendMessage(dk_client.Message{
+					From:      dkClient.UserID,
+					To:        newQueryItem.From,
+					Content:   content,
+					Timestamp: time.Now(),
+				})
 			}
 		}
 	}
@@ -205,15 +256,9 @@ func HandleAnswer(ctx context.Context, msg dk_client.Message) (string, error) {
 		return "", err
 	}
 
-	var remoteMsg utils.RemoteMessage
-	if err := json.Unmarshal([]byte(msg.Content), &remoteMsg); err != nil ||
-		strings.TrimSpace(remoteMsg.Message) == "" {
-		return "", fmt.Errorf("invalid outer message: %w", err)
-	}
-
-	var answer utils.AnswerMessage
-	if err := json.Unmarshal([]byte(remoteMsg.Message), &answer); err != nil {
-		return "", fmt.Errorf("invalid answer payload: %w", err)
+	answer, err := utils.DecodePayloadAs[*utils.AnswerPayload](msg.Content)
+	if err != nil {
+		return "", err
 	}
 
 	if err := db.InsertAnswer(ctx, dbHandler, db.Answer{
@@ -223,6 +268,11 @@ func HandleAnswer(ctx context.Context, msg dk_client.Message) (string, error) {
 	}); err != nil {
 		return "", err
 	}
+	// Answers to scheduled queries are also kept with the run that asked them
+	if _, err := db.RecordScheduledQueryAnswer(ctx, dbHandler, answer.Query, msg.From, answer.Answer); err != nil {
+		log.Printf("[Scheduler] %v", err)
+	}
+	notifyAnswer(PeerAnswer{Question: answer.Query, From: msg.From, Answer: answer.Answer, ReceivedAt: time.Now()})
 	return "", nil // no reply – same behaviour as before
 }
 
@@ -321,12 +371,6 @@ func HandleForwardMessage(ctx context.Context, msg dk_client.Message) (string, e
 		}
 	} else if forwardMsg.Type == utils.MessageTypeForward && forwardMsg.Message != "" {
 		// This is a regular query
-		// Retrieve relevant documents using RAG
-		docs, err := RetrieveDocuments(ctx, forwardMsg.Message, 3, make(map[string]string))
-		if err != nil {
-			return "", fmt.Errorf("failed to retrieve documents: %w", err)
-		}
-
 		log.Printf("Processing forward query: %s", forwardMsg.Message)
 		// Get LLM provider from context or config
 		llmProvider, err := LLMProviderFromContext(ctx)
@@ -355,11 +399,22 @@ func HandleForwardMessage(ctx context.Context, msg dk_client.Message) (string, e
 			}
 		}
 
-		// Generate answer using the LLM provider
-		answer, err := llmProvider.GenerateAnswer(ctx, forwardMsg.Message, docs)
+		apiID := apiIDFromMetadata(forwardMsg.Metadata)
+		if err := checkCredits(ctx, apiID, msg.From); err != nil {
+			recordTokenUsage(ctx, apiID, msg.From, "forward", TokenUsage{}, true)
+			return "", err
+		}
+
+		// Retrieve relevant documents using RAG and generate the answer using the LLM provider
+		answerCtx, trace := WithProviderTrace(ctx)
+		answer, result, err := NewAnswerPipeline(ctx, llmProvider).Answer(answerCtx, forwardMsg.Message)
+		recordTokenUsage(ctx, apiID, msg.From, "forward", result.Usage, errors.Is(err, ErrTokenBudgetExceeded))
 		if err != nil {
 			return "", fmt.Errorf("failed to generate answer: %w", err)
 		}
+		if result.CalledProvider() {
+			recordProviderUsage(ctx, llmProvider, trace, "forward", "")
+		}
 
 		responseMsg = answer
 		responseType = "forward_response"
@@ -412,21 +467,25 @@ SendResponse:
 }
 
 func HandleApplicationRequest(ctx context.Context, msg dk_client.Message) (string, error) {
-	var appRequest utils.RemoteMessage
-	err := json.Unmarshal([]byte(msg.Content), &appRequest)
+	appRequest, err := utils.DecodePayloadAs[*utils.AppSubmissionPayload](msg.Content)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse message or empty question")
+		return "", fmt.Errorf("invalid app submission: %w", err)
 	}
+	return "", saveApplicationRequest(ctx, msg.From, appRequest.Description, appRequest.Files)
+}
 
+// saveApplicationRequest writes the files of an app sent by a peer to the SyftBox inbox and
+// records the request for the app to be approved
+func saveApplicationRequest(ctx context.Context, from, description string, files map[string]string) error {
 	parameters, err := utils.ParamsFromContext(ctx)
 	if err != nil {
-		return "", nil
+		return nil
 	}
 
 	file, err := os.ReadFile(*parameters.SyftboxConfig)
 	if err != nil {
 		// Wrap the result in a CallToolResult.
-		return "", nil
+		return nil
 	}
 
 	var syftboxConfig struct {
@@ -440,12 +499,12 @@ func HandleApplicationRequest(ctx context.Context, msg dk_client.Message) (strin
 	}
 
 	if err := json.Unmarshal(file, &syftboxConfig); err != nil {
-		return "", nil
+		return nil
 	}
 
 	inboxPath := filepath.Join(syftboxConfig.DataDir, "datasites", syftboxConfig.Email, "inbox")
 
-	err = WriteMapToDir(ctx, inboxPath, appRequest.Files)
+	err = WriteMapToDir(ctx, inboxPath, files)
 	if err != nil {
 		log.Println(err.Error())
 	}
@@ -454,11 +513,11 @@ func HandleApplicationRequest(ctx context.Context, msg dk_client.Message) (strin
 
 	dbConn, err := utils.DatabaseFromContext(ctx)
 	if err != nil {
-		return "", fmt.Errorf("db connection missing: %w", err)
+		return fmt.Errorf("db connection missing: %w", err)
 	}
 
 	var firstKey string
-	for k := range appRequest.Files {
+	for k := range files {
 		firstKey = k
 		break
 	}
@@ -469,15 +528,15 @@ func HandleApplicationRequest(ctx context.Context, msg dk_client.Message) (strin
 
 	ar := db.AppRequest{
 		AppName:        appName,
-		RequestedBy:    msg.From,
-		AppDescription: appRequest.Message,
+		RequestedBy:    from,
+		AppDescription: description,
 		Status:         "pending",
 		Reason:         defaultReason,
 		Safety:         "Undefined",
 	}
 
 	if err := db.InsertOrUpdateAppRequest(ctx, dbConn, ar); err != nil {
-		return "", fmt.Errorf("saving app request: %w", err)
+		return fmt.Errorf("saving app request: %w", err)
 	}
-	return "", nil
+	return nil
 }
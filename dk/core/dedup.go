@@ -0,0 +1,125 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"dk/utils"
+	"encoding/hex"
+	"log"
+	"runtime"
+	"strings"
+
+	"github.com/philippgille/chromem-go"
+)
+
+// contentHashKey holds the hash of the text a file was embedded from, so that adding the same
+// text again does not store it twice
+const contentHashKey = "content_hash"
+
+// contentHash returns the value of contentHashKey for the text of a file
+func contentHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// What to do with a file that is added again
+type ingestAction int
+
+const (
+	ingestAdd     ingestAction = iota // The file is not stored yet
+	ingestSkip                        // The same text and metadata are stored already
+	ingestRetag                       // The same text is stored with other metadata
+	ingestReplace                     // Other text is stored under the file's name
+)
+
+// planIngest decides how to add a file given the chunks stored under its name. hashKey names
+// the metadata holding the hash the stored and new versions are compared by.
+func planIngest(stored []chromem.Result, metadata map[string]string, hashKey string) ingestAction {
+	switch {
+	case len(stored) == 0:
+		return ingestAdd
+	case stored[0].Metadata[hashKey] == "" || stored[0].Metadata[hashKey] != metadata[hashKey]:
+		return ingestReplace
+	case sameIngestMetadata(stored[0].Metadata, metadata):
+		return ingestSkip
+	default:
+		return ingestRetag
+	}
+}
+
+// volatileMetadata reports whether a metadata key changes between ingests of the same file
+// without the file changing: its chunk positions, summary marker and ingest times
+func volatileMetadata(key string) bool {
+	return isChunkKey(key) || key == summaryKey || key == "date" || key == indexedAtKey
+}
+
+// sameIngestMetadata reports whether stored and new metadata agree on everything but
+// volatile keys
+func sameIngestMetadata(stored, metadata map[string]string) bool {
+	for key, value := range metadata {
+		if !volatileMetadata(key) && stored[key] != value {
+			return false
+		}
+	}
+	for key := range stored {
+		if _, ok := metadata[key]; !ok && !volatileMetadata(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// retagChunks replaces the metadata of a file's stored chunks, keeping their embeddings since
+// the text did not change. Chunk positions, the summary marker and the time the file was
+// first indexed carry over.
+func retagChunks(ctx context.Context, collection *chromem.Collection, fileName string, stored []chromem.Result, metadata map[string]string) error {
+	ctx, unlock := lockCollection(ctx, collection)
+	defer unlock()
+
+	docs := make([]chromem.Document, 0, len(stored))
+	for _, chunk := range stored {
+		docMetadata := make(map[string]string, len(metadata)+4)
+		for key, value := range metadata {
+			docMetadata[key] = value
+		}
+		for key, value := range chunk.Metadata {
+			if isChunkKey(key) || key == summaryKey || key == indexedAtKey {
+				docMetadata[key] = value
+			}
+		}
+		docs = append(docs, chromem.Document{ID: chunk.ID, Metadata: docMetadata, Embedding: chunk.Embedding, Content: chunk.Content})
+	}
+
+	if err := RemoveDocument(ctx, fileName); err != nil {
+		return err
+	}
+	if err := collection.AddDocuments(ctx, docs, runtime.NumCPU()); err != nil {
+		return err
+	}
+	if idx := KeywordIndexFromContext(ctx); idx != nil {
+		for _, doc := range docs {
+			idx.Add(doc.ID, strings.TrimPrefix(doc.Content, "search_document: "), doc.Metadata)
+		}
+	}
+	notifyChange(DocumentsChanged)
+	return nil
+}
+
+// storedSource returns the chunks stored for a file in a collection, or nil when the
+// collection does not exist yet
+func storedSource(ctx context.Context, collectionName, fileName string) []chromem.Result {
+	collectionCtx, err := UseCollection(ctx, collectionName, false)
+	if err != nil {
+		return nil
+	}
+	collection, err := utils.ChromemCollectionFromContext(collectionCtx)
+	if err != nil {
+		return nil
+	}
+	stored, err := fileChunks(collectionCtx, collection, fileName)
+	if err != nil {
+		log.Printf("[RAG] Failed to look up source %s: %v", fileName, err)
+		return nil
+	}
+	return stored
+}
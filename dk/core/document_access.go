@@ -0,0 +1,64 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"fmt"
+)
+
+type documentAccessKey struct{}
+
+type allowedFilesKey struct{}
+
+// WithDocumentAccess makes answers to peers draw only on the documents associated with the
+// APIs each peer has access to
+func WithDocumentAccess(ctx context.Context, enabled bool) context.Context {
+	return context.WithValue(ctx, documentAccessKey{}, enabled)
+}
+
+// DocumentAccessFromContext reports whether answers to peers are restricted to the documents
+// of their APIs
+func DocumentAccessFromContext(ctx context.Context) bool {
+	enabled, _ := ctx.Value(documentAccessKey{}).(bool)
+	return enabled
+}
+
+// WithAllowedFiles restricts every retrieval made with the context to documents with one of
+// the given file names. An empty list allows no document.
+func WithAllowedFiles(ctx context.Context, files []string) context.Context {
+	allowed := make(map[string]bool, len(files))
+	for _, file := range files {
+		allowed[file] = true
+	}
+	return context.WithValue(ctx, allowedFilesKey{}, allowed)
+}
+
+// allowedFilesFromContext returns the file names retrieval is restricted to, or nil when it
+// is not restricted
+func allowedFilesFromContext(ctx context.Context) map[string]bool {
+	allowed, _ := ctx.Value(allowedFilesKey{}).(map[string]bool)
+	return allowed
+}
+
+// fileAllowed reports whether a file may be retrieved under the restriction
+func fileAllowed(allowed map[string]bool, file string) bool {
+	return allowed == nil || allowed[file]
+}
+
+// withPeerDocumentAccess restricts retrieval to the documents a peer can access through the
+// APIs it was granted, when document access control is enabled
+func withPeerDocumentAccess(ctx context.Context, peer string) (context.Context, bool, error) {
+	if !DocumentAccessFromContext(ctx) {
+		return ctx, false, nil
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	files, err := db.GetUserAccessibleDocuments(database, peer)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to look up the documents of %s: %w", peer, err)
+	}
+	return WithAllowedFiles(ctx, files), true, nil
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"testing"
+)
+
+func TestPeerDocumentAccess(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunAPIMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	c := newTestCollections(t, CollectionsConfig{})
+	ctx := WithCollections(context.Background(), c)
+	ctx = utils.WithDatabase(ctx, testDB.DB)
+	addTestDocument(t, ctx, DefaultCollection, "forecast.txt", "weather forecast for tomorrow")
+	addTestDocument(t, ctx, DefaultCollection, "salaries.txt", "weather of the salaries review")
+	ctx, err = UseCollection(ctx, DefaultCollection, false)
+	if err != nil {
+		t.Fatalf("UseCollection failed: %v", err)
+	}
+
+	weather := &db.API{Name: "Weather", IsActive: true, HostUserID: "host"}
+	if err := db.CreateAPI(testDB.DB, weather); err != nil {
+		t.Fatalf("CreateAPI failed: %v", err)
+	}
+	if err := db.CreateAPIUserAccess(testDB.DB, &db.APIUserAccess{APIID: weather.ID, ExternalUserID: "bob", AccessLevel: "read", IsActive: true}); err != nil {
+		t.Fatalf("CreateAPIUserAccess failed: %v", err)
+	}
+	if err := db.CreateDocumentAssociation(testDB.DB, &db.DocumentAssociation{DocumentFilename: "forecast.txt", EntityID: weather.ID, EntityType: "api"}); err != nil {
+		t.Fatalf("CreateDocumentAssociation failed: %v", err)
+	}
+
+	// Without access control every document is a candidate
+	peerCtx, restricted, err := withPeerDocumentAccess(ctx, "bob")
+	if err != nil || restricted {
+		t.Fatalf("Expected no restriction when disabled, got %v (%v)", restricted, err)
+	}
+	if docs, _ := RetrieveDocuments(peerCtx, "weather", 5, nil); len(docs) != 2 {
+		t.Errorf("Expected both documents, got %+v", docs)
+	}
+
+	// With it, a peer only gets the documents of the APIs it can access
+	ctx = WithDocumentAccess(ctx, true)
+	peerCtx, restricted, err = withPeerDocumentAccess(ctx, "bob")
+	if err != nil || !restricted {
+		t.Fatalf("Expected a restriction, got %v (%v)", restricted, err)
+	}
+	docs, err := RetrieveDocuments(peerCtx, "weather", 5, nil)
+	if err != nil || len(docs) != 1 || docs[0].FileName != "forecast.txt" {
+		t.Errorf("Expected only the API's document, got %+v (%v)", docs, err)
+	}
+
+	peerCtx, _, _ = withPeerDocumentAccess(ctx, "mallory")
+	if docs, _ := RetrieveDocuments(peerCtx, "weather", 5, nil); len(docs) != 0 {
+		t.Errorf("Expected no documents for a peer without access, got %+v", docs)
+	}
+
+	// Revoked access no longer grants documents
+	access, err := db.GetAPIUserAccessByUserID(testDB.DB, weather.ID, "bob")
+	if err != nil {
+		t.Fatalf("GetAPIUserAccessByUserID failed: %v", err)
+	}
+	access.IsActive = false
+	if err := db.UpdateAPIUserAccess(testDB.DB, access); err != nil {
+		t.Fatalf("UpdateAPIUserAccess failed: %v", err)
+	}
+	if files, _ := db.GetUserAccessibleDocuments(testDB.DB, "bob"); len(files) != 0 {
+		t.Errorf("Expected no documents after revoking access, got %v", files)
+	}
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"context"
+	"dk/utils"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DocumentStats describes a stored file without its content
+type DocumentStats struct {
+	FileName string            `json:"file"`
+	Chunks   int               `json:"chunks"`  // Stored chunks, not counting the summary
+	Size     int               `json:"size"`    // Length of the content in bytes
+	Summary  bool              `json:"summary"` // Whether a summary is stored with the chunks
+	Tags     []string          `json:"tags"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// StoredChunk is a piece of a file as stored in the vector database
+type StoredChunk struct {
+	ID      string `json:"id"`
+	Index   int    `json:"index"`             // Position of the chunk, starting at 0
+	Offset  int    `json:"offset"`            // Byte offset of the chunk in the file content
+	Summary bool   `json:"summary,omitempty"` // The chunk is the summary of the file
+	Content string `json:"content"`
+}
+
+// ListDocumentStats returns every file of the collection of the context with the number of
+// chunks it is stored in, sorted by file name. Soft-deleted files are left out.
+func ListDocumentStats(ctx context.Context) ([]DocumentStats, error) {
+	col, err := utils.ChromemCollectionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results, err := allDocuments(ctx, col, false)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	stats := make(map[string]*DocumentStats)
+	parts := make([]Document, 0, len(results))
+	for _, res := range results {
+		file := res.Metadata["file"]
+		stat, ok := stats[file]
+		if !ok {
+			stat = &DocumentStats{FileName: file, Tags: []string{}, Metadata: make(map[string]string)}
+			stats[file] = stat
+		}
+		if isSummary(res.Metadata) {
+			stat.Summary = true
+		} else {
+			stat.Chunks++
+		}
+		for key, value := range res.Metadata {
+			switch {
+			case strings.HasPrefix(key, tagKeyPrefix):
+			case key == "file" || key == tagsKey || key == summaryKey || isChunkKey(key):
+			default:
+				stat.Metadata[key] = value
+			}
+		}
+		parts = append(parts, Document{
+			FileName: file,
+			Content:  strings.TrimPrefix(res.Content, "search_document: "),
+			Metadata: res.Metadata,
+		})
+	}
+
+	documents := make([]DocumentStats, 0, len(stats))
+	for _, doc := range mergeChunks(parts) {
+		stat := stats[doc.FileName]
+		if stat.Chunks > 0 {
+			stat.Size = len(doc.Content)
+		}
+		for key := range doc.Metadata {
+			if tag, ok := strings.CutPrefix(key, tagKeyPrefix); ok {
+				stat.Tags = append(stat.Tags, tag)
+			}
+		}
+		sort.Strings(stat.Tags)
+		documents = append(documents, *stat)
+	}
+	sort.Slice(documents, func(i, j int) bool { return documents[i].FileName < documents[j].FileName })
+	return documents, nil
+}
+
+// DocumentChunks returns the stored chunks of a file of the collection of the context in
+// order, followed by its summary if one is stored. It returns ErrDocumentNotFound when the
+// collection does not hold the file.
+func DocumentChunks(ctx context.Context, fileName string) ([]StoredChunk, error) {
+	col, err := utils.ChromemCollectionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results, err := fileChunks(ctx, col, fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up document: %w", err)
+	}
+	if len(results) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, fileName)
+	}
+
+	chunks := make([]StoredChunk, 0, len(results))
+	for _, res := range results {
+		chunk := StoredChunk{
+			ID:      res.ID,
+			Summary: isSummary(res.Metadata),
+			Content: strings.TrimPrefix(res.Content, "search_document: "),
+		}
+		chunk.Index, _ = strconv.Atoi(res.Metadata[chunkIndexKey])
+		chunk.Offset, _ = strconv.Atoi(res.Metadata[chunkOffsetKey])
+		chunks = append(chunks, chunk)
+	}
+	sort.SliceStable(chunks, func(i, j int) bool {
+		if chunks[i].Summary != chunks[j].Summary {
+			return !chunks[i].Summary
+		}
+		return chunks[i].Index < chunks[j].Index
+	})
+	return chunks, nil
+}
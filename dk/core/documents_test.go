@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDocumentStatsAndChunks(t *testing.T) {
+	ctx := WithCollections(context.Background(), newTestCollections(t, CollectionsConfig{}))
+	ctx = WithChunking(ctx, ChunkingConfig{Strategy: ChunkStrategySentence, Size: 5})
+	ctx, err := UseCollection(ctx, DefaultCollection, true)
+	if err != nil {
+		t.Fatalf("UseCollection failed: %v", err)
+	}
+	if stats, err := ListDocumentStats(ctx); err != nil || len(stats) != 0 {
+		t.Fatalf("Expected no documents, got %+v (%v)", stats, err)
+	}
+
+	long := strings.Repeat("Each chunk of a document is listed on its own. ", 100)
+	if err := AddDocument(ctx, "manual.md", long, false, map[string]string{"tags": "guide,admin"}); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+	if err := AddDocument(ctx, "faq.txt", "Frequently asked questions", false, nil); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+	if err := AddDocument(ctx, "old.txt", "Removed", false, map[string]string{"is_deleted": "true"}); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+
+	stats, err := ListDocumentStats(ctx)
+	if err != nil {
+		t.Fatalf("ListDocumentStats failed: %v", err)
+	}
+	if len(stats) != 2 || stats[0].FileName != "faq.txt" || stats[1].FileName != "manual.md" {
+		t.Fatalf("Expected faq.txt and manual.md without the deleted file, got %+v", stats)
+	}
+	if stats[0].Chunks != 1 || stats[0].Size != len("Frequently asked questions") || len(stats[0].Tags) != 0 {
+		t.Errorf("Unexpected stats of faq.txt: %+v", stats[0])
+	}
+	manual := stats[1]
+	if manual.Chunks < 2 || manual.Size != len(long) || strings.Join(manual.Tags, ",") != "admin,guide" {
+		t.Errorf("Unexpected stats of manual.md: %+v", manual)
+	}
+	if _, ok := manual.Metadata[chunkIndexKey]; ok {
+		t.Errorf("Expected no chunk metadata in the stats, got %v", manual.Metadata)
+	}
+
+	chunks, err := DocumentChunks(ctx, "manual.md")
+	if err != nil {
+		t.Fatalf("DocumentChunks failed: %v", err)
+	}
+	if len(chunks) != manual.Chunks {
+		t.Fatalf("Expected %d chunks, got %d", manual.Chunks, len(chunks))
+	}
+	for i, chunk := range chunks {
+		if chunk.Index != i || chunk.ID == "" || strings.HasPrefix(chunk.Content, "search_document: ") {
+			t.Errorf("Unexpected chunk %d: %+v", i, chunk)
+		}
+		if !strings.HasPrefix(long[chunk.Offset:], chunk.Content) {
+			t.Errorf("Chunk %d does not start at its offset %d", i, chunk.Offset)
+		}
+	}
+
+	if _, err := DocumentChunks(ctx, "missing.txt"); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected ErrDocumentNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"dk/db"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/philippgille/chromem-go"
+)
+
+// embeddingModel is the Ollama model documents and queries are embedded with
+const embeddingModel = "nomic-embed-text"
+
+// CachedEmbeddingFunc wraps an embedding function with a cache in the embedding_cache table,
+// keyed by the model and the hash of the text, so that re-ingesting unchanged text does not
+// call the embedding provider again. Search queries are embedded without the cache, since
+// they seldom repeat and would only grow the table.
+func CachedEmbeddingFunc(database *sql.DB, model string, embed chromem.EmbeddingFunc) chromem.EmbeddingFunc {
+	return func(ctx context.Context, text string) ([]float32, error) {
+		if strings.HasPrefix(text, "search_query: ") {
+			return embed(ctx, text)
+		}
+
+		hash := contentHash(text)
+		embedding, err := db.GetCachedEmbedding(ctx, database, model, hash)
+		if err == nil {
+			return embedding, nil
+		}
+		if !errors.Is(err, db.ErrNotFound) {
+			log.Printf("[RAG] Embedding cache lookup failed: %v", err)
+		}
+
+		embedding, err = embed(ctx, text)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.PutCachedEmbedding(ctx, database, model, hash, embedding, time.Now()); err != nil {
+			log.Printf("[RAG] Failed to cache embedding: %v", err)
+		}
+		return embedding, nil
+	}
+}
@@ -0,0 +1,53 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"testing"
+)
+
+func TestCachedEmbeddingFunc(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	calls := 0
+	counting := func(ctx context.Context, text string) ([]float32, error) {
+		calls++
+		return letterEmbedding(ctx, text)
+	}
+	ctx := context.Background()
+	embed := CachedEmbeddingFunc(testDB.DB, "test-model", counting)
+
+	first, err := embed(ctx, "search_document: Installation guide")
+	if err != nil {
+		t.Fatalf("embed failed: %v", err)
+	}
+	second, err := embed(ctx, "search_document: Installation guide")
+	if err != nil {
+		t.Fatalf("embed failed: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the second embedding served from the cache, got %d calls", calls)
+	}
+	if len(second) != len(first) || second[0] != first[0] || second[26] != first[26] {
+		t.Errorf("Expected the cached embedding to equal the computed one, got %v and %v", first, second)
+	}
+
+	// Another model does not share the cache, and queries are not cached
+	CachedEmbeddingFunc(testDB.DB, "other-model", counting)(ctx, "search_document: Installation guide")
+	embed(ctx, "search_query: guide")
+	embed(ctx, "search_query: guide")
+	if calls != 4 {
+		t.Errorf("Expected 4 calls to the embedding function, got %d", calls)
+	}
+
+	if n, err := db.ClearEmbeddingCache(ctx, testDB.DB, "test-model"); err != nil || n != 1 {
+		t.Errorf("Expected one cached embedding of the model to be cleared, got %d (%v)", n, err)
+	}
+}
@@ -0,0 +1,218 @@
+// Package eval measures retrieval quality against a labeled set of questions, so changes to
+// chunking, embedding or reranking can be compared by their recall@k and mean reciprocal rank.
+package eval
+
+import (
+	"context"
+	"dk/core"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// DefaultK are the cutoffs recall is reported at unless others are given
+var DefaultK = []int{1, 3, 5, 10}
+
+// Case is a labeled question: the files of the documents that answer it
+type Case struct {
+	ID       string            `json:"id,omitempty"`
+	Question string            `json:"question"`
+	Relevant []string          `json:"relevant"`
+	Filter   map[string]string `json:"filter,omitempty"` // Metadata filter passed to retrieval
+}
+
+// Retriever returns the documents retrieved for a question, best first
+type Retriever func(ctx context.Context, question string, n int, filter map[string]string) ([]core.Document, error)
+
+// PipelineRetriever retrieves like the answer pipeline: from the collections the question is
+// routed to, reranked by the reranker of the context if there is one
+func PipelineRetriever(ctx context.Context) Retriever {
+	reranker := core.RerankerFromContext(ctx)
+	return func(ctx context.Context, question string, n int, filter map[string]string) ([]core.Document, error) {
+		candidates := n
+		if reranker != nil && reranker.Candidates() > candidates {
+			candidates = reranker.Candidates()
+		}
+		if filter == nil {
+			filter = make(map[string]string)
+		}
+		docs, err := core.RetrieveDocuments(ctx, question, candidates, filter)
+		if err != nil || reranker == nil {
+			return docs, err
+		}
+		ranked, err := reranker.Rerank(ctx, question, docs)
+		if err != nil {
+			return nil, fmt.Errorf("rerank: %w", err)
+		}
+		return ranked, nil
+	}
+}
+
+// CaseResult is the outcome of one case. Retrieved lists the distinct files in rank order,
+// since a document may be retrieved as several chunks.
+type CaseResult struct {
+	ID             string          `json:"id,omitempty"`
+	Question       string          `json:"question"`
+	Retrieved      []string        `json:"retrieved"`
+	FirstRelevant  int             `json:"first_relevant,omitempty"` // Rank of the first relevant file; 0 if none was retrieved
+	Recall         map[int]float64 `json:"recall"`
+	ReciprocalRank float64         `json:"reciprocal_rank"`
+	Error          string          `json:"error,omitempty"`
+}
+
+// Report holds the metrics of a run, averaged over the cases that could be evaluated
+type Report struct {
+	K       []int           `json:"k"`
+	Cases   int             `json:"cases"`
+	Failed  int             `json:"failed"` // Cases whose retrieval failed; they are left out of the averages
+	Recall  map[int]float64 `json:"recall"`
+	MRR     float64         `json:"mrr"`
+	Results []CaseResult    `json:"results"`
+}
+
+// LoadCases reads cases from a JSONL file, one case per line
+func LoadCases(path string) ([]Case, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cases []Case
+	d := json.NewDecoder(f)
+	for line := 1; ; line++ {
+		var c Case
+		if err := d.Decode(&c); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("case %d: %w", line, err)
+		}
+		if strings.TrimSpace(c.Question) == "" || len(c.Relevant) == 0 {
+			return nil, fmt.Errorf("case %d: a question and at least one relevant file are required", line)
+		}
+		cases = append(cases, c)
+	}
+	if len(cases) == 0 {
+		return nil, errors.New("no cases")
+	}
+	return cases, nil
+}
+
+// Run retrieves the documents of every case and scores them at the cutoffs k. Enough
+// documents are retrieved to fill the largest cutoff with distinct files.
+func Run(ctx context.Context, cases []Case, retrieve Retriever, k []int) (*Report, error) {
+	if len(k) == 0 {
+		k = DefaultK
+	}
+	k = append([]int(nil), k...)
+	sort.Ints(k)
+	if k[0] <= 0 {
+		return nil, fmt.Errorf("cutoffs must be positive, got %d", k[0])
+	}
+	maxK := k[len(k)-1]
+
+	report := &Report{K: k, Cases: len(cases), Recall: make(map[int]float64)}
+	for _, c := range cases {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		result := CaseResult{ID: c.ID, Question: c.Question, Recall: make(map[int]float64)}
+		docs, err := retrieve(ctx, c.Question, maxK*retrievalFactor, c.Filter)
+		if err != nil {
+			result.Error = err.Error()
+			report.Failed++
+			report.Results = append(report.Results, result)
+			continue
+		}
+		result.Retrieved = distinctFiles(docs, maxK)
+		score(&result, c.Relevant, k)
+		for _, cutoff := range k {
+			report.Recall[cutoff] += result.Recall[cutoff]
+		}
+		report.MRR += result.ReciprocalRank
+		report.Results = append(report.Results, result)
+	}
+
+	if evaluated := report.Cases - report.Failed; evaluated > 0 {
+		for _, cutoff := range k {
+			report.Recall[cutoff] /= float64(evaluated)
+		}
+		report.MRR /= float64(evaluated)
+	}
+	return report, nil
+}
+
+// retrievalFactor over-fetches chunks, since several chunks of a file count as one result
+const retrievalFactor = 3
+
+// distinctFiles returns up to n files of docs in rank order, each once
+func distinctFiles(docs []core.Document, n int) []string {
+	seen := make(map[string]bool)
+	files := []string{}
+	for _, doc := range docs {
+		if len(files) == n {
+			break
+		}
+		if !seen[doc.FileName] {
+			seen[doc.FileName] = true
+			files = append(files, doc.FileName)
+		}
+	}
+	return files
+}
+
+// score computes the recall at each cutoff and the reciprocal rank of a result
+func score(result *CaseResult, relevant []string, k []int) {
+	want := make(map[string]bool, len(relevant))
+	for _, file := range relevant {
+		want[file] = true
+	}
+	for rank, file := range result.Retrieved {
+		if !want[file] {
+			continue
+		}
+		if result.FirstRelevant == 0 {
+			result.FirstRelevant = rank + 1
+			result.ReciprocalRank = 1 / float64(rank+1)
+		}
+		for _, cutoff := range k {
+			if rank < cutoff {
+				result.Recall[cutoff] += 1 / float64(len(want))
+			}
+		}
+	}
+}
+
+// WriteSummary prints the metrics of a report, with the change from a baseline if one is
+// given
+func (r *Report) WriteSummary(w io.Writer, baseline *Report) {
+	fmt.Fprintf(w, "Cases: %d", r.Cases)
+	if r.Failed > 0 {
+		fmt.Fprintf(w, " (%d failed)", r.Failed)
+	}
+	fmt.Fprintln(w)
+	line := func(name string, value float64, base float64, hasBase bool) {
+		fmt.Fprintf(w, "%-10s %.3f", name, value)
+		if hasBase {
+			fmt.Fprintf(w, "  (%+.3f)", value-base)
+		}
+		fmt.Fprintln(w)
+	}
+	for _, cutoff := range r.K {
+		var base float64
+		var hasBase bool
+		if baseline != nil {
+			base, hasBase = baseline.Recall[cutoff]
+		}
+		line(fmt.Sprintf("recall@%d", cutoff), r.Recall[cutoff], base, hasBase)
+	}
+	if baseline != nil {
+		line("MRR", r.MRR, baseline.MRR, true)
+	} else {
+		line("MRR", r.MRR, 0, false)
+	}
+}
@@ -0,0 +1,95 @@
+package eval
+
+import (
+	"bytes"
+	"context"
+	"dk/core"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeRetriever returns chunks of the given files for each question
+func fakeRetriever(results map[string][]string) Retriever {
+	return func(ctx context.Context, question string, n int, filter map[string]string) ([]core.Document, error) {
+		files, ok := results[question]
+		if !ok {
+			return nil, errors.New("collection unavailable")
+		}
+		var docs []core.Document
+		for _, file := range files {
+			docs = append(docs, core.Document{FileName: file, Content: "chunk of " + file})
+		}
+		return docs, nil
+	}
+}
+
+func TestRun(t *testing.T) {
+	cases := []Case{
+		{ID: "hit", Question: "q1", Relevant: []string{"a.md"}},
+		{ID: "second", Question: "q2", Relevant: []string{"b.md", "c.md"}},
+		{ID: "miss", Question: "q3", Relevant: []string{"z.md"}},
+		{ID: "broken", Question: "q4", Relevant: []string{"a.md"}},
+	}
+	retrieve := fakeRetriever(map[string][]string{
+		"q1": {"a.md", "a.md", "b.md"},
+		// Repeated chunks of x.md count as one rank
+		"q2": {"x.md", "x.md", "b.md", "y.md", "c.md"},
+		"q3": {"a.md", "b.md"},
+	})
+
+	report, err := Run(context.Background(), cases, retrieve, []int{3, 1})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Cases != 4 || report.Failed != 1 || report.Results[3].Error == "" {
+		t.Fatalf("Expected one failed case out of 4, got %+v", report)
+	}
+	second := report.Results[1]
+	if strings.Join(second.Retrieved, ",") != "x.md,b.md,y.md" || second.FirstRelevant != 2 {
+		t.Errorf("Unexpected result for the second case: %+v", second)
+	}
+	approx := func(name string, got, want float64) {
+		if math.Abs(got-want) > 1e-9 {
+			t.Errorf("Expected %s %.4f, got %.4f", name, want, got)
+		}
+	}
+	approx("recall@1", report.Recall[1], 1.0/3)
+	approx("recall@3", report.Recall[3], (1+0.5+0)/3)
+	approx("MRR", report.MRR, (1+0.5+0)/3)
+
+	var out bytes.Buffer
+	baseline := &Report{Recall: map[int]float64{1: 0.5, 3: 0.5}, MRR: 0.25}
+	report.WriteSummary(&out, baseline)
+	for _, want := range []string{"Cases: 4 (1 failed)", "recall@1   0.333  (-0.167)", "MRR        0.500  (+0.250)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected the summary to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	if _, err := Run(context.Background(), cases, retrieve, []int{0}); err == nil {
+		t.Error("Expected a zero cutoff to be rejected")
+	}
+}
+
+func TestLoadCases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cases.jsonl")
+	os.WriteFile(path, []byte(`{"id": "q1", "question": "How?", "relevant": ["a.md"], "filter": {"team": "ops"}}
+{"question": "Why?", "relevant": ["b.md", "c.md"]}
+`), 0o644)
+	cases, err := LoadCases(path)
+	if err != nil {
+		t.Fatalf("LoadCases failed: %v", err)
+	}
+	if len(cases) != 2 || cases[0].Filter["team"] != "ops" || len(cases[1].Relevant) != 2 {
+		t.Errorf("Unexpected cases: %+v", cases)
+	}
+
+	os.WriteFile(path, []byte(`{"question": "Unlabeled"}`), 0o644)
+	if _, err := LoadCases(path); err == nil {
+		t.Error("Expected a case without relevant files to be rejected")
+	}
+}
@@ -0,0 +1,476 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Document formats recognized by ExtractText
+const (
+	FormatText = "text"
+	FormatPDF  = "pdf"
+	FormatDOCX = "docx"
+	FormatHTML = "html"
+)
+
+// maxExtractedStream bounds the size of a single decompressed PDF stream
+const maxExtractedStream = 64 << 20
+
+// DetectFormat identifies the format of a file from its content rather than its name, so text
+// that was already extracted and is stored under a .pdf or .docx name is recognized as text.
+func DetectFormat(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return FormatPDF
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		if isDOCX(data) {
+			return FormatDOCX
+		}
+	case strings.HasPrefix(http.DetectContentType(data), "text/html"):
+		return FormatHTML
+	}
+	return FormatText
+}
+
+// ExtractText converts PDF, DOCX and HTML files into plain text for embedding. Any other
+// content is returned unchanged.
+func ExtractText(fileName string, data []byte) (string, error) {
+	var text string
+	var err error
+	switch DetectFormat(data) {
+	case FormatPDF:
+		text, err = extractPDFText(data)
+	case FormatDOCX:
+		text, err = extractDOCXText(data)
+	case FormatHTML:
+		text = extractHTMLText(string(data))
+	default:
+		return string(data), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to extract text from %s: %w", fileName, err)
+	}
+	if strings.TrimSpace(text) == "" {
+		return "", fmt.Errorf("no text found in %s", fileName)
+	}
+	return text, nil
+}
+
+// ---------------------- DOCX ----------------------
+
+func isDOCX(data []byte) bool {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return false
+	}
+	for _, f := range r.File {
+		if f.Name == "word/document.xml" {
+			return true
+		}
+	}
+	return false
+}
+
+// extractDOCXText reads the paragraphs of the main document part of a DOCX file
+func extractDOCXText(data []byte) (string, error) {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", err
+	}
+	var part *zip.File
+	for _, f := range r.File {
+		if f.Name == "word/document.xml" {
+			part = f
+			break
+		}
+	}
+	if part == nil {
+		return "", errors.New("word/document.xml not found")
+	}
+	rc, err := part.Open()
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+
+	var sb strings.Builder
+	inText := false
+	decoder := xml.NewDecoder(rc)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("invalid document.xml: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				sb.WriteByte('\t')
+			case "br", "cr":
+				sb.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				sb.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// ---------------------- HTML ----------------------
+
+var (
+	htmlHidden   = regexp.MustCompile(`(?is)<(script|style|noscript|template|head)\b.*?</(script|style|noscript|template|head)\s*>|<!--.*?-->`)
+	htmlBlockTag = regexp.MustCompile(`(?i)</?(p|div|br|hr|li|ul|ol|h[1-6]|tr|table|section|article|header|footer|nav|aside|blockquote|pre|dt|dd|main|figure|figcaption)\b[^>]*>`)
+	htmlTag      = regexp.MustCompile(`<[^>]*>`)
+	spaceRun     = regexp.MustCompile(`[ \t\f\v\r]+`)
+	blankLineRun = regexp.MustCompile(`\n{3,}`)
+)
+
+// extractHTMLText strips markup from an HTML document, keeping block elements on separate lines
+func extractHTMLText(doc string) string {
+	doc = htmlHidden.ReplaceAllString(doc, " ")
+	doc = htmlBlockTag.ReplaceAllString(doc, "\n")
+	doc = htmlTag.ReplaceAllString(doc, " ")
+	doc = html.UnescapeString(doc)
+	return normalizeWhitespace(doc)
+}
+
+// normalizeWhitespace collapses runs of spaces and blank lines
+func normalizeWhitespace(text string) string {
+	lines := strings.Split(spaceRun.ReplaceAllString(text, " "), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(blankLineRun.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+// ---------------------- PDF ----------------------
+
+var (
+	pdfStream = regexp.MustCompile(`(?s)stream\r?\n`)
+	pdfDict   = regexp.MustCompile(`(?s)<<(.*)>>\s*$`)
+)
+
+// extractPDFText extracts the text shown by the content streams of a PDF. It handles
+// uncompressed and Flate-compressed streams with simple font encodings; text drawn with
+// embedded CID fonts or scanned pages cannot be recovered without OCR.
+func extractPDFText(data []byte) (string, error) {
+	var pages []string
+	for _, loc := range pdfStream.FindAllIndex(data, -1) {
+		start := loc[1]
+		end := bytes.Index(data[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		raw := data[start : start+end]
+
+		// The stream dictionary precedes the "stream" keyword
+		dictStart := bytes.LastIndex(data[:loc[0]], []byte("obj"))
+		if dictStart < 0 {
+			dictStart = 0
+		}
+		dict := string(data[dictStart:loc[0]])
+		if m := pdfDict.FindStringSubmatch(dict); m != nil {
+			dict = m[1]
+		}
+		if strings.Contains(dict, "/Image") || strings.Contains(dict, "/FontFile") ||
+			strings.Contains(dict, "/Length1") || strings.Contains(dict, "/XRef") {
+			continue
+		}
+
+		content := raw
+		if strings.Contains(dict, "/FlateDecode") {
+			zr, err := zlib.NewReader(bytes.NewReader(raw))
+			if err != nil {
+				continue
+			}
+			decoded, err := io.ReadAll(io.LimitReader(zr, maxExtractedStream))
+			zr.Close()
+			if err != nil && len(decoded) == 0 {
+				continue
+			}
+			content = decoded
+		} else if strings.Contains(dict, "/Filter") {
+			// Other filters (LZW, DCT, ...) do not carry extractable text
+			continue
+		}
+
+		if text := pdfContentText(content); text != "" {
+			pages = append(pages, text)
+		}
+	}
+	if len(pages) == 0 {
+		return "", errors.New("no extractable text; the PDF may be scanned or use embedded font encodings")
+	}
+	return normalizeWhitespace(strings.Join(pages, "\n\n")), nil
+}
+
+// pdfContentText interprets the text operators of a content stream
+func pdfContentText(content []byte) string {
+	if !bytes.Contains(content, []byte("BT")) {
+		return ""
+	}
+
+	var sb strings.Builder
+	var operands []string
+	lex := &pdfLexer{data: content}
+	for {
+		token, kind := lex.next()
+		if kind == pdfEOF {
+			break
+		}
+		switch kind {
+		case pdfString:
+			operands = append(operands, token)
+		case pdfNumber:
+			// Inside TJ arrays, a large negative kerning stands for a word space
+			if lex.depth > 0 {
+				if n := parsePDFNumber(token); n < -200 {
+					operands = append(operands, " ")
+				}
+			}
+		case pdfOperator:
+			switch token {
+			case "Tj", "TJ":
+				sb.WriteString(strings.Join(operands, ""))
+			case "'", "\"":
+				sb.WriteByte('\n')
+				sb.WriteString(strings.Join(operands, ""))
+			case "T*", "Td", "TD", "ET":
+				sb.WriteByte('\n')
+			case "Tm":
+				sb.WriteByte(' ')
+			}
+			operands = operands[:0]
+		}
+	}
+
+	text := sb.String()
+	if !mostlyPrintable(text) {
+		return ""
+	}
+	return strings.TrimSpace(text)
+}
+
+// mostlyPrintable rejects text decoded with the wrong encoding, such as CID font glyph IDs
+func mostlyPrintable(text string) bool {
+	total, printable := 0, 0
+	for _, r := range text {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		total++
+		if unicode.IsPrint(r) && r != utf8.RuneError {
+			printable++
+		}
+	}
+	return total > 0 && printable*10 >= total*9
+}
+
+func parsePDFNumber(token string) float64 {
+	var n float64
+	fmt.Sscanf(token, "%g", &n)
+	return n
+}
+
+type pdfTokenKind int
+
+const (
+	pdfEOF pdfTokenKind = iota
+	pdfString
+	pdfNumber
+	pdfOperator
+	pdfArrayEnd
+	pdfOther
+)
+
+// pdfLexer tokenizes a PDF content stream
+type pdfLexer struct {
+	data  []byte
+	pos   int
+	depth int // nesting of [ ] arrays
+}
+
+func (l *pdfLexer) next() (string, pdfTokenKind) {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isPDFSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case c == '(':
+			return l.literalString(), pdfString
+		case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+			l.pos += 2
+			return "<<", pdfOther
+		case c == '>' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '>':
+			l.pos += 2
+			return ">>", pdfOther
+		case c == '<':
+			return l.hexString(), pdfString
+		case c == '[':
+			l.pos++
+			l.depth++
+			return "[", pdfOther
+		case c == ']':
+			l.pos++
+			if l.depth > 0 {
+				l.depth--
+			}
+			return "]", pdfArrayEnd
+		case c == '/':
+			start := l.pos
+			l.pos++
+			for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+				l.pos++
+			}
+			return string(l.data[start:l.pos]), pdfOther
+		default:
+			start := l.pos
+			for l.pos < len(l.data) && !isPDFSpace(l.data[l.pos]) && !isPDFDelimiter(l.data[l.pos]) {
+				l.pos++
+			}
+			if l.pos == start {
+				l.pos++
+				continue
+			}
+			token := string(l.data[start:l.pos])
+			if strings.IndexFunc(token, func(r rune) bool { return !strings.ContainsRune("+-.0123456789", r) }) < 0 {
+				return token, pdfNumber
+			}
+			return token, pdfOperator
+		}
+	}
+	return "", pdfEOF
+}
+
+// literalString reads a (...) string, handling nesting and escapes
+func (l *pdfLexer) literalString() string {
+	var out []byte
+	l.pos++ // (
+	nesting := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '\\':
+			if l.pos >= len(l.data) {
+				break
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b':
+				out = append(out, '\b')
+			case 'f':
+				out = append(out, '\f')
+			case '\r', '\n':
+				// line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						v = v*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+		case '(':
+			nesting++
+			out = append(out, c)
+		case ')':
+			nesting--
+			if nesting == 0 {
+				return decodePDFString(out)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return decodePDFString(out)
+}
+
+// hexString reads a <...> string
+func (l *pdfLexer) hexString() string {
+	l.pos++ // <
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; strings.IndexByte("0123456789abcdefABCDEF", c) >= 0 {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++ // >
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	out := make([]byte, len(digits)/2)
+	for i := range out {
+		fmt.Sscanf(string(digits[2*i:2*i+2]), "%02x", &out[i])
+	}
+	return decodePDFString(out)
+}
+
+// decodePDFString decodes UTF-16BE strings with a byte order mark and treats anything else as
+// Latin-1, which matches PDFDocEncoding for printable text
+func decodePDFString(b []byte) string {
+	if len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF {
+		units := make([]uint16, 0, len(b)/2)
+		for i := 2; i+1 < len(b); i += 2 {
+			units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+		}
+		return string(utf16.Decode(units))
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte("()<>[]{}/%", c) >= 0
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func buildDOCX(t *testing.T, body string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("word/document.xml")
+	if err != nil {
+		t.Fatalf("Creating document.xml failed: %v", err)
+	}
+	fmt.Fprintf(w, `<?xml version="1.0"?><w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>%s</w:body></w:document>`, body)
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Closing zip failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// buildPDF writes a minimal PDF with one plain and one Flate compressed content stream
+func buildPDF(t *testing.T, plain, compressed string) []byte {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write([]byte(compressed))
+	zw.Close()
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	fmt.Fprintf(&pdf, "4 0 obj\n<< /Length %d >>\nstream\n%s\nendstream\nendobj\n", len(plain), plain)
+	fmt.Fprintf(&pdf, "5 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", z.Len())
+	pdf.Write(z.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n%%EOF\n")
+	return pdf.Bytes()
+}
+
+func TestDetectFormat(t *testing.T) {
+	cases := map[string][]byte{
+		FormatPDF:  []byte("%PDF-1.7\n..."),
+		FormatDOCX: buildDOCX(t, ""),
+		FormatHTML: []byte("<!DOCTYPE html><html><body>Hi</body></html>"),
+		FormatText: []byte("Plain notes about <b>bold</b> ideas."),
+	}
+	for want, data := range cases {
+		if got := DetectFormat(data); got != want {
+			t.Errorf("Expected %s, got %s", want, got)
+		}
+	}
+}
+
+func TestExtractTextDOCX(t *testing.T) {
+	data := buildDOCX(t, `<w:p><w:r><w:t>Quarterly</w:t></w:r><w:r><w:tab/><w:t>report</w:t></w:r></w:p><w:p><w:r><w:t>Revenue &amp; costs</w:t></w:r></w:p>`)
+
+	text, err := ExtractText("report.docx", data)
+	if err != nil {
+		t.Fatalf("ExtractText failed: %v", err)
+	}
+	if text != "Quarterly\treport\nRevenue & costs" {
+		t.Errorf("Unexpected DOCX text: %q", text)
+	}
+}
+
+func TestExtractTextHTML(t *testing.T) {
+	doc := `<html><head><title>Ignored</title><style>p { color: red }</style></head>
+<body><script>alert("x")</script><h1>Title</h1><p>First &amp; only<br>paragraph</p><!-- hidden --></body></html>`
+
+	text, err := ExtractText("page.html", []byte(doc))
+	if err != nil {
+		t.Fatalf("ExtractText failed: %v", err)
+	}
+	for _, unwanted := range []string{"Ignored", "color", "alert", "hidden", "<"} {
+		if strings.Contains(text, unwanted) {
+			t.Errorf("Expected %q to be removed, got %q", unwanted, text)
+		}
+	}
+	if !strings.Contains(text, "Title") || !strings.Contains(text, "First & only\nparagraph") {
+		t.Errorf("Unexpected HTML text: %q", text)
+	}
+}
+
+func TestExtractTextPDF(t *testing.T) {
+	data := buildPDF(t,
+		"BT /F1 12 Tf 72 712 Td (Hello \\(PDF\\) world) Tj ET",
+		"BT /F1 12 Tf 72 690 Td [(Kerned) -300 (text)] TJ T* <48657821> Tj ET",
+	)
+
+	text, err := ExtractText("doc.pdf", data)
+	if err != nil {
+		t.Fatalf("ExtractText failed: %v", err)
+	}
+	for _, want := range []string{"Hello (PDF) world", "Kerned text", "Hex!"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected %q in PDF text, got %q", want, text)
+		}
+	}
+}
+
+func TestExtractTextPassesThroughText(t *testing.T) {
+	// Text already extracted from a PDF is stored under the original file name
+	content := "Some notes.\n\nAlready plain text."
+	text, err := ExtractText("notes.pdf", []byte(content))
+	if err != nil || text != content {
+		t.Errorf("Expected text to pass through unchanged, got %q (%v)", text, err)
+	}
+
+	if _, err := ExtractText("scan.pdf", []byte("%PDF-1.4\n%%EOF\n")); err == nil {
+		t.Error("Expected an error for a PDF without text")
+	}
+}
@@ -0,0 +1,155 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Current versions of the queries.json and answers.json formats. Files written before
+// versioning have no schema_version and count as version 0.
+const (
+	QueriesSchemaVersion = 1
+	AnswersSchemaVersion = 1
+)
+
+// ErrUnsupportedSchemaVersion is returned for files written by a newer version of dk
+var ErrUnsupportedSchemaVersion = errors.New("unsupported schema version")
+
+// schemaMigrator upgrades a decoded file by one version. The migrator at index i upgrades
+// version i to version i+1.
+type schemaMigrator func(doc map[string]json.RawMessage) (map[string]json.RawMessage, error)
+
+// queriesMigrators upgrade queries.json to QueriesSchemaVersion
+var queriesMigrators = []schemaMigrator{
+	// 0 → 1: only the version was added
+	func(doc map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+		return doc, nil
+	},
+}
+
+// answersMigrators upgrade answers.json to AnswersSchemaVersion
+var answersMigrators = []schemaMigrator{
+	// 0 → 1: the answers, keyed by question and then by peer, moved from the top level
+	// into "answers"
+	func(doc map[string]json.RawMessage) (map[string]json.RawMessage, error) {
+		answers, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]json.RawMessage{"answers": answers}, nil
+	},
+}
+
+// schemaVersion returns the version of a decoded file
+func schemaVersion(doc map[string]json.RawMessage) (int, error) {
+	raw, ok := doc["schema_version"]
+	if !ok {
+		return 0, nil
+	}
+	var version int
+	if err := json.Unmarshal(raw, &version); err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid schema_version %s", raw)
+	}
+	return version, nil
+}
+
+// migrateSchema upgrades the raw content of a file to the current version with the given
+// migrators. It reports whether the content changed, and refuses versions newer than current.
+func migrateSchema(raw []byte, current int, migrators []schemaMigrator) ([]byte, bool, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, false, err
+	}
+	version, err := schemaVersion(doc)
+	if err != nil {
+		return nil, false, err
+	}
+	if version > current {
+		return nil, false, fmt.Errorf("%w: version %d is newer than the supported version %d; upgrade dk to read it", ErrUnsupportedSchemaVersion, version, current)
+	}
+	if version == current {
+		return raw, false, nil
+	}
+
+	for ; version < current; version++ {
+		delete(doc, "schema_version")
+		if doc, err = migrators[version](doc); err != nil {
+			return nil, false, fmt.Errorf("failed to migrate from version %d: %w", version, err)
+		}
+	}
+	doc["schema_version"] = json.RawMessage(fmt.Sprint(current))
+	upgraded, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, false, err
+	}
+	return upgraded, true, nil
+}
+
+// readVersionedFile reads a file and upgrades it to the current version. An upgraded file is
+// written back, after the original was kept next to it as <file>.bak.
+func readVersionedFile(path string, current int, migrators []schemaMigrator) ([]byte, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	upgraded, changed, err := migrateSchema(raw, current, migrators)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if changed {
+		if err := os.WriteFile(path+".bak", raw, 0644); err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+		if err := os.WriteFile(path, upgraded, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write upgraded %s: %w", path, err)
+		}
+	}
+	return upgraded, nil
+}
+
+// AnswersData represents the answers received for each question, keyed by question and then
+// by peer
+type AnswersData struct {
+	SchemaVersion int                          `json:"schema_version"`
+	Answers       map[string]map[string]string `json:"answers"`
+}
+
+func LoadAnswers(answersFile string) (AnswersData, error) {
+	var data AnswersData
+	if _, err := os.Stat(answersFile); os.IsNotExist(err) {
+		data.SchemaVersion = AnswersSchemaVersion
+		data.Answers = make(map[string]map[string]string)
+		return data, nil
+	}
+	raw, err := readVersionedFile(answersFile, AnswersSchemaVersion, answersMigrators)
+	if err != nil {
+		return data, fmt.Errorf("failed to read answers file: %w", err)
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return data, fmt.Errorf("failed to unmarshal answers file: %w", err)
+	}
+	if data.Answers == nil {
+		data.Answers = make(map[string]map[string]string)
+	}
+	return data, nil
+}
+
+func SaveAnswers(answersFile string, data AnswersData) error {
+	data.SchemaVersion = AnswersSchemaVersion
+	return writeJSONFile(answersFile, data)
+}
+
+// CheckDataFiles upgrades the queries.json and answers.json files kept in dir by earlier
+// versions, and fails if one of them was written by a newer version
+func CheckDataFiles(dir string) error {
+	if _, err := LoadQueries(filepath.Join(dir, "queries.json")); err != nil {
+		return err
+	}
+	if _, err := LoadAnswers(filepath.Join(dir, "answers.json")); err != nil {
+		return err
+	}
+	return nil
+}
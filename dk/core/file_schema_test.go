@@ -0,0 +1,69 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAnswersMigratesUnversionedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "answers.json")
+	legacy := `{"What is DK?": {"alice": "A network", "bob": "A library"}}`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := LoadAnswers(path)
+	if err != nil {
+		t.Fatalf("LoadAnswers failed: %v", err)
+	}
+	if data.SchemaVersion != AnswersSchemaVersion || data.Answers["What is DK?"]["bob"] != "A library" {
+		t.Fatalf("unexpected answers: %+v", data)
+	}
+
+	// The file is upgraded in place and the original kept
+	backup, err := os.ReadFile(path + ".bak")
+	if err != nil || string(backup) != legacy {
+		t.Errorf("expected the original file as backup, got %q (%v)", backup, err)
+	}
+	again, err := LoadAnswers(path)
+	if err != nil || again.Answers["What is DK?"]["alice"] != "A network" {
+		t.Errorf("upgraded file did not load: %+v (%v)", again, err)
+	}
+}
+
+func TestLoadQueriesVersions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "queries.json")
+	if err := os.WriteFile(path, []byte(`{"queries": {"qry-1": {"id": "qry-1", "question": "Why?"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := LoadQueries(path)
+	if err != nil {
+		t.Fatalf("LoadQueries failed: %v", err)
+	}
+	if data.SchemaVersion != QueriesSchemaVersion || data.Queries["qry-1"].Question != "Why?" {
+		t.Fatalf("unexpected queries: %+v", data)
+	}
+
+	// Saving and loading a current file leaves it alone
+	if err := SaveQueries(path, data); err != nil {
+		t.Fatalf("SaveQueries failed: %v", err)
+	}
+	os.Remove(path + ".bak")
+	if _, err := LoadQueries(path); err != nil {
+		t.Fatalf("LoadQueries failed: %v", err)
+	}
+	if _, err := os.Stat(path + ".bak"); !os.IsNotExist(err) {
+		t.Errorf("a current file should not be rewritten")
+	}
+
+	// A file from a newer version is refused
+	if err := os.WriteFile(path, []byte(`{"schema_version": 99, "queries": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckDataFiles(dir); !errors.Is(err, ErrUnsupportedSchemaVersion) {
+		t.Errorf("expected ErrUnsupportedSchemaVersion, got %v", err)
+	}
+}
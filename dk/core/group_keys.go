@@ -0,0 +1,37 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	dk_client "dk/client"
+	"dk/db"
+	"time"
+)
+
+// groupKeyStore keeps the keys of the websocket groups the node is a member of in the
+// database
+type groupKeyStore struct {
+	db *sql.DB
+}
+
+// NewGroupKeyStore returns a group key store for the client backed by the group_keys table
+func NewGroupKeyStore(database *sql.DB) dk_client.GroupKeyStore {
+	return groupKeyStore{db: database}
+}
+
+func (s groupKeyStore) SaveGroupKey(key dk_client.GroupKey) error {
+	return db.SaveGroupKey(context.Background(), s.db, db.GroupKey{
+		GroupID:   key.GroupID,
+		Epoch:     key.Epoch,
+		Key:       key.Key,
+		CreatedAt: time.Now(),
+	})
+}
+
+func (s groupKeyStore) GroupKey(groupID string, epoch int) (dk_client.GroupKey, bool, error) {
+	key, err := db.GetGroupKey(context.Background(), s.db, groupID, epoch)
+	if err != nil || key == nil {
+		return dk_client.GroupKey{}, false, err
+	}
+	return dk_client.GroupKey{GroupID: key.GroupID, Epoch: key.Epoch, Key: key.Key}, true, nil
+}
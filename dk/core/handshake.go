@@ -0,0 +1,381 @@
+package core
+
+import (
+	"context"
+	"crypto/ed25519"
+	dk_client "dk/client"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message types of the capability handshake. A peer sends its capabilities with the request,
+// and the recipient answers with its own, so both learn about each other in one round trip.
+const (
+	HandshakeRequestMessageType = "handshake_request" // Our capabilities; reply with yours
+	HandshakeReplyMessageType   = "handshake"         // Capabilities in reply to a request
+)
+
+const (
+	// defaultHandshakeTTL is how long capabilities are valid when the configuration sets no TTL
+	defaultHandshakeTTL = 24 * time.Hour
+	// handshakeRetry is how long to wait for a reply before asking a peer again
+	handshakeRetry = 5 * time.Minute
+	// HandshakeWait bounds how long a question waits for the first handshake with a peer
+	HandshakeWait = 3 * time.Second
+)
+
+// HandshakeConfig declares what the node tells peers about itself on first contact
+type HandshakeConfig struct {
+	// Topics questions are accepted about; empty accepts questions about anything
+	Topics []string `json:"topics,omitempty"`
+	// DeclinedTopics are topics questions are not accepted about
+	DeclinedTopics []string `json:"declined_topics,omitempty"`
+	// Collections are the collections advertised to peers; empty advertises all of them
+	Collections []string `json:"collections,omitempty"`
+	// TTLSeconds is how long peers may cache the capabilities, 24 hours by default
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+}
+
+// CollectionSummary describes a shareable collection
+type CollectionSummary struct {
+	Name      string `json:"name"`
+	Documents int    `json:"documents"` // Stored documents, counting every chunk
+}
+
+// Capabilities are what a peer shares and accepts questions about. They are signed with the
+// identity key of the peer, so they can be cached and checked later.
+type Capabilities struct {
+	Peer           string              `json:"peer"`
+	Collections    []CollectionSummary `json:"collections"`
+	Descriptions   []string            `json:"descriptions,omitempty"` // Summaries of the knowledge base
+	Topics         []string            `json:"topics,omitempty"`
+	DeclinedTopics []string            `json:"declined_topics,omitempty"`
+	IssuedAt       time.Time           `json:"issued_at"`
+	ExpiresAt      time.Time           `json:"expires_at"`
+	Signature      []byte              `json:"signature,omitempty"`
+}
+
+// signedBytes returns the canonical form of the capabilities that is signed
+func (c Capabilities) signedBytes() ([]byte, error) {
+	c.Signature = nil
+	return json.Marshal(c)
+}
+
+// Sign signs the capabilities with the private key of the peer
+func (c *Capabilities) Sign(sign func([]byte) []byte) error {
+	data, err := c.signedBytes()
+	if err != nil {
+		return err
+	}
+	c.Signature = sign(data)
+	return nil
+}
+
+// Verify checks the signature of the capabilities and that they have not expired
+func (c *Capabilities) Verify(publicKey ed25519.PublicKey, now time.Time) error {
+	data, err := c.signedBytes()
+	if err != nil {
+		return err
+	}
+	if len(publicKey) != ed25519.PublicKeySize || !ed25519.Verify(publicKey, data, c.Signature) {
+		return errors.New("invalid signature")
+	}
+	if !now.Before(c.ExpiresAt) {
+		return errors.New("capabilities expired")
+	}
+	return nil
+}
+
+// Refusal returns why the peer would not accept a question, or "" if it accepts it
+func (c *Capabilities) Refusal(question string) string {
+	for _, topic := range c.DeclinedTopics {
+		if topicMatches(topic, question) {
+			return fmt.Sprintf("peer %s does not accept questions about %s", c.Peer, topic)
+		}
+	}
+	if len(c.Topics) == 0 {
+		return ""
+	}
+	for _, topic := range c.Topics {
+		if topicMatches(topic, question) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("peer %s only accepts questions about %s", c.Peer, strings.Join(c.Topics, ", "))
+}
+
+// topicMatches reports whether a question mentions every word of a topic. Words of four or more
+// letters also match longer words they start, so "record" matches "records".
+func topicMatches(topic, question string) bool {
+	words := tokenize(question)
+	terms := tokenize(topic)
+	if len(terms) == 0 {
+		return false
+	}
+	for _, term := range terms {
+		found := false
+		for _, word := range words {
+			if word == term || (len(term) >= 4 && strings.HasPrefix(word, term)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type handshakeEntry struct {
+	capabilities *Capabilities
+	requestedAt  time.Time     // When we last asked the peer for its capabilities
+	arrived      chan struct{} // Closed when the capabilities of a pending request arrive
+}
+
+// Handshakes exchanges capabilities with peers and caches the verified capabilities of each
+// peer until they expire. The cache is kept in memory, so peers are asked again after a restart.
+type Handshakes struct {
+	config HandshakeConfig
+	now    func() time.Time
+
+	mu    sync.Mutex
+	peers map[string]*handshakeEntry
+}
+
+// NewHandshakes creates the handshake state with the capabilities the node declares
+func NewHandshakes(config HandshakeConfig) *Handshakes {
+	return &Handshakes{config: config, now: time.Now, peers: make(map[string]*handshakeEntry)}
+}
+
+func (h *Handshakes) ttl() time.Duration {
+	if h.config.TTLSeconds > 0 {
+		return time.Duration(h.config.TTLSeconds) * time.Second
+	}
+	return defaultHandshakeTTL
+}
+
+// Get returns the cached capabilities of a peer, if they have not expired
+func (h *Handshakes) Get(peer string) (*Capabilities, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry, ok := h.peers[peer]
+	if !ok || entry.capabilities == nil || !h.now().Before(entry.capabilities.ExpiresAt) {
+		return nil, false
+	}
+	return entry.capabilities, true
+}
+
+// store caches verified capabilities and wakes up whoever waits for them
+func (h *Handshakes) store(capabilities *Capabilities) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry, ok := h.peers[capabilities.Peer]
+	if !ok {
+		entry = &handshakeEntry{}
+		h.peers[capabilities.Peer] = entry
+	}
+	entry.capabilities = capabilities
+	if entry.arrived != nil {
+		close(entry.arrived)
+		entry.arrived = nil
+	}
+}
+
+// pending marks a request to a peer as sent and returns the channel closed when the reply
+// arrives. send is false when the peer was asked recently and has not replied yet.
+func (h *Handshakes) pending(peer string) (arrived chan struct{}, send bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entry, ok := h.peers[peer]
+	if !ok {
+		entry = &handshakeEntry{}
+		h.peers[peer] = entry
+	}
+	if entry.arrived == nil {
+		entry.arrived = make(chan struct{})
+	}
+	if !entry.requestedAt.IsZero() && h.now().Sub(entry.requestedAt) < handshakeRetry {
+		return entry.arrived, false
+	}
+	entry.requestedAt = h.now()
+	return entry.arrived, true
+}
+
+// Local returns the signed capabilities of this node
+func (h *Handshakes) Local(ctx context.Context) (*Capabilities, error) {
+	client, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	issuedAt := h.now().UTC().Truncate(time.Second)
+	capabilities := &Capabilities{
+		Peer:           client.UserID,
+		Collections:    []CollectionSummary{},
+		Topics:         h.config.Topics,
+		DeclinedTopics: h.config.DeclinedTopics,
+		IssuedAt:       issuedAt,
+		ExpiresAt:      issuedAt.Add(h.ttl()),
+	}
+
+	advertised := make(map[string]bool)
+	for _, name := range h.config.Collections {
+		advertised[name] = true
+	}
+	if collections := CollectionsFromContext(ctx); collections != nil {
+		for _, info := range collections.List() {
+			if len(advertised) == 0 || advertised[info.Name] {
+				capabilities.Collections = append(capabilities.Collections, CollectionSummary{Name: info.Name, Documents: info.Documents})
+			}
+		}
+	} else if collection, err := utils.ChromemCollectionFromContext(ctx); err == nil {
+		capabilities.Collections = append(capabilities.Collections, CollectionSummary{Name: DefaultCollection, Documents: collection.Count()})
+	}
+	if descriptions, err := utils.GetDescriptions(ctx); err == nil {
+		capabilities.Descriptions = descriptions
+	}
+
+	if err := capabilities.Sign(client.Sign); err != nil {
+		return nil, fmt.Errorf("failed to sign capabilities: %w", err)
+	}
+	return capabilities, nil
+}
+
+// send sends the capabilities of this node to a peer
+func (h *Handshakes) send(ctx context.Context, peer, messageType string) error {
+	client, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	capabilities, err := h.Local(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(capabilities)
+	if err != nil {
+		return fmt.Errorf("failed to encode capabilities: %w", err)
+	}
+	content, err := json.Marshal(utils.RemoteMessage{Type: messageType, Message: string(body)})
+	if err != nil {
+		return fmt.Errorf("failed to encode handshake: %w", err)
+	}
+	return client.SendMessage(dk_client.Message{To: peer, Content: string(content)})
+}
+
+// Start begins the handshake with a peer unless its capabilities are cached or it was asked
+// recently. It returns the channel closed when the capabilities arrive, or nil if they are
+// already cached.
+func (h *Handshakes) Start(ctx context.Context, peer string) <-chan struct{} {
+	if _, ok := h.Get(peer); ok {
+		return nil
+	}
+	arrived, send := h.pending(peer)
+	if send {
+		if err := h.send(ctx, peer, HandshakeRequestMessageType); err != nil {
+			log.Printf("[Handshake] Failed to send handshake to %s: %v", peer, err)
+		}
+	}
+	return arrived
+}
+
+// Capabilities returns the capabilities of a peer, starting the handshake and waiting up to
+// wait for the reply if they are not cached. It returns false if the peer did not answer.
+func (h *Handshakes) Capabilities(ctx context.Context, peer string, wait time.Duration) (*Capabilities, bool) {
+	arrived := h.Start(ctx, peer)
+	if arrived != nil {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-arrived:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	return h.Get(peer)
+}
+
+// Refusals returns why peers would not accept a question, by peer. The capabilities of all
+// peers are looked up at once, each waiting up to wait for a first handshake; peers that do
+// not answer in time are assumed to accept the question.
+func (h *Handshakes) Refusals(ctx context.Context, peers []string, question string, wait time.Duration) map[string]string {
+	refusals := make(map[string]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			capabilities, ok := h.Capabilities(ctx, peer, wait)
+			if !ok {
+				return
+			}
+			if refusal := capabilities.Refusal(question); refusal != "" {
+				mu.Lock()
+				refusals[peer] = refusal
+				mu.Unlock()
+			}
+		}(peer)
+	}
+	wg.Wait()
+	return refusals
+}
+
+// HandleHandshakeMessage verifies and caches the capabilities a peer sent, and replies with
+// ours when the peer asked for them
+func HandleHandshakeMessage(ctx context.Context, msg dk_client.Message) (string, error) {
+	handshakes := HandshakesFromContext(ctx)
+	if handshakes == nil {
+		return "", nil
+	}
+	client, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var remoteMsg utils.RemoteMessage
+	if err := json.Unmarshal([]byte(msg.Content), &remoteMsg); err != nil {
+		return "", fmt.Errorf("invalid handshake: %w", err)
+	}
+	var capabilities Capabilities
+	if err := json.Unmarshal([]byte(remoteMsg.Message), &capabilities); err != nil {
+		return "", fmt.Errorf("invalid handshake payload: %w", err)
+	}
+	if capabilities.Peer != msg.From {
+		return "", fmt.Errorf("rejected capabilities of %s sent by %s", capabilities.Peer, msg.From)
+	}
+	publicKey, err := client.GetUserPublicKey(msg.From)
+	if err != nil {
+		return "", fmt.Errorf("failed to get the public key of %s: %w", msg.From, err)
+	}
+	if err := capabilities.Verify(publicKey, handshakes.now()); err != nil {
+		return "", fmt.Errorf("rejected capabilities of %s: %w", msg.From, err)
+	}
+	handshakes.store(&capabilities)
+	log.Printf("[Handshake] %s shares %d collections until %s", msg.From, len(capabilities.Collections), capabilities.ExpiresAt.Format(time.RFC3339))
+
+	if remoteMsg.Type == HandshakeRequestMessageType {
+		if err := handshakes.send(ctx, msg.From, HandshakeReplyMessageType); err != nil {
+			return "", fmt.Errorf("failed to answer the handshake of %s: %w", msg.From, err)
+		}
+	}
+	return "", nil
+}
+
+type handshakesKey struct{}
+
+// WithHandshakes adds the handshake state to the context
+func WithHandshakes(ctx context.Context, handshakes *Handshakes) context.Context {
+	return context.WithValue(ctx, handshakesKey{}, handshakes)
+}
+
+// HandshakesFromContext returns the handshake state, or nil when handshakes are not exchanged
+func HandshakesFromContext(ctx context.Context) *Handshakes {
+	handshakes, _ := ctx.Value(handshakesKey{}).(*Handshakes)
+	return handshakes
+}
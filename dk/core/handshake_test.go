@@ -0,0 +1,107 @@
+package core
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCapabilitiesSignature(t *testing.T) {
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	issuedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	capabilities := Capabilities{
+		Peer:        "bob",
+		Collections: []CollectionSummary{{Name: DefaultCollection, Documents: 12}},
+		Topics:      []string{"weather"},
+		IssuedAt:    issuedAt,
+		ExpiresAt:   issuedAt.Add(time.Hour),
+	}
+	if err := capabilities.Sign(func(data []byte) []byte { return ed25519.Sign(privateKey, data) }); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	// The signature survives the trip through a message
+	raw, _ := json.Marshal(capabilities)
+	var received Capabilities
+	if err := json.Unmarshal(raw, &received); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if err := received.Verify(publicKey, issuedAt.Add(time.Minute)); err != nil {
+		t.Fatalf("Expected valid capabilities, got %v", err)
+	}
+	if err := received.Verify(publicKey, issuedAt.Add(2*time.Hour)); err == nil {
+		t.Error("Expected expired capabilities to be rejected")
+	}
+
+	received.Topics = append(received.Topics, "salaries")
+	if err := received.Verify(publicKey, issuedAt.Add(time.Minute)); err == nil {
+		t.Error("Expected tampered capabilities to be rejected")
+	}
+	otherKey, _, _ := ed25519.GenerateKey(nil)
+	if err := capabilities.Verify(otherKey, issuedAt.Add(time.Minute)); err == nil {
+		t.Error("Expected capabilities signed by another key to be rejected")
+	}
+}
+
+func TestCapabilitiesRefusal(t *testing.T) {
+	open := Capabilities{Peer: "bob", DeclinedTopics: []string{"medical records"}}
+	if refusal := open.Refusal("Where are the medical records of 2023 kept?"); !strings.Contains(refusal, "does not accept questions about medical records") {
+		t.Errorf("Expected the declined topic to be refused, got %q", refusal)
+	}
+	if refusal := open.Refusal("Which records are medical?"); refusal == "" {
+		t.Error("Expected the topic to match its words in any order")
+	}
+	if refusal := open.Refusal("What is the weather like?"); refusal != "" {
+		t.Errorf("Expected other topics to be accepted, got %q", refusal)
+	}
+
+	narrow := Capabilities{Peer: "carol", Topics: []string{"weather", "climate data"}}
+	if refusal := narrow.Refusal("Is the weather sunny in Lisbon?"); refusal != "" {
+		t.Errorf("Expected an accepted topic to pass, got %q", refusal)
+	}
+	if refusal := narrow.Refusal("What does the budget look like?"); refusal != "peer carol only accepts questions about weather, climate data" {
+		t.Errorf("Unexpected refusal: %q", refusal)
+	}
+}
+
+func TestHandshakesCache(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	handshakes := NewHandshakes(HandshakeConfig{})
+	handshakes.now = func() time.Time { return now }
+
+	arrived, send := handshakes.pending("bob")
+	if !send {
+		t.Fatal("Expected the first handshake to be sent")
+	}
+	if _, send := handshakes.pending("bob"); send {
+		t.Error("Expected no second request while the first is pending")
+	}
+
+	handshakes.store(&Capabilities{Peer: "bob", DeclinedTopics: []string{"salaries"}, ExpiresAt: now.Add(time.Hour)})
+	select {
+	case <-arrived:
+	default:
+		t.Fatal("Expected the waiters to be woken up")
+	}
+	if _, ok := handshakes.Get("bob"); !ok {
+		t.Fatal("Expected the capabilities to be cached")
+	}
+
+	// Cached capabilities answer without a handshake; unknown peers are given the benefit of the doubt
+	ctx := context.Background()
+	refusals := handshakes.Refusals(ctx, []string{"bob", "carol"}, "What are the salaries?", 10*time.Millisecond)
+	if len(refusals) != 1 || refusals["bob"] == "" {
+		t.Errorf("Expected only bob to refuse, got %v", refusals)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, ok := handshakes.Get("bob"); ok {
+		t.Error("Expected the capabilities to expire")
+	}
+	if _, send := handshakes.pending("bob"); !send {
+		t.Error("Expected a new handshake once the old request is stale")
+	}
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"dk/db"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Formats of history exports
+const (
+	HistoryJSON = "json" // One JSON document with the range and the records
+	HistoryCSV  = "csv"  // One row per record, with a header row
+)
+
+// ErrExportExists is returned when the file of an export already exists and may not be replaced
+var ErrExportExists = errors.New("export file already exists")
+
+// HistoryExportOptions selects what ExportHistory writes and where
+type HistoryExportOptions struct {
+	Path      string    // File to write
+	Format    string    // HistoryJSON or HistoryCSV; taken from the extension of Path when empty
+	Since     time.Time // Received at or after; zero for no bound
+	Until     time.Time // Received before; zero for no bound
+	Overwrite bool      // Replace the file if it exists
+}
+
+// HistoryExport describes a written export
+type HistoryExport struct {
+	Path    string `json:"path"`
+	Format  string `json:"format"`
+	Queries int    `json:"queries"`
+	Answers int    `json:"answers"`
+	SHA256  string `json:"sha256"`
+}
+
+// historyDocument is the content of a JSON export
+type historyDocument struct {
+	ExportedAt time.Time          `json:"exported_at"`
+	Since      *time.Time         `json:"since,omitempty"`
+	Until      *time.Time         `json:"until,omitempty"`
+	Records    []db.HistoryRecord `json:"records"`
+}
+
+var historyCSVHeader = []string{"kind", "id", "peer", "question", "answer", "status", "reason", "created_at"}
+
+// ExportHistory writes the queries peers asked, with their drafted answers and review status,
+// and the answers peers sent, to a file for compliance reporting. The file is written through a
+// temporary file, so a failed export never leaves a partial file behind.
+func ExportHistory(ctx context.Context, database *sql.DB, options HistoryExportOptions) (*HistoryExport, error) {
+	if strings.TrimSpace(options.Path) == "" {
+		return nil, errors.New("export path is required")
+	}
+	path, err := filepath.Abs(options.Path)
+	if err != nil {
+		return nil, err
+	}
+	format := strings.ToLower(strings.TrimSpace(options.Format))
+	if format == "" {
+		format = HistoryJSON
+		if strings.EqualFold(filepath.Ext(path), ".csv") {
+			format = HistoryCSV
+		}
+	}
+	if format != HistoryJSON && format != HistoryCSV {
+		return nil, fmt.Errorf("invalid export format %q: expected json or csv", options.Format)
+	}
+	if !options.Since.IsZero() && !options.Until.IsZero() && !options.Since.Before(options.Until) {
+		return nil, errors.New("the start of the range must be before its end")
+	}
+	if info, err := os.Stat(path); err == nil {
+		if info.IsDir() || !options.Overwrite {
+			return nil, fmt.Errorf("%w: %s", ErrExportExists, path)
+		}
+	}
+
+	records, err := db.ListHistory(ctx, database, options.Since, options.Until)
+	if err != nil {
+		return nil, err
+	}
+	export := &HistoryExport{Path: path, Format: format}
+	for _, record := range records {
+		if record.Kind == db.HistoryQuery {
+			export.Queries++
+		} else {
+			export.Answers++
+		}
+	}
+
+	part, err := writeArchiveFile(filepath.Dir(path), filepath.Base(path), func(w io.Writer) error {
+		if format == HistoryCSV {
+			return writeHistoryCSV(w, records)
+		}
+		document := historyDocument{ExportedAt: time.Now().UTC(), Records: records}
+		if !options.Since.IsZero() {
+			since := options.Since.UTC()
+			document.Since = &since
+		}
+		if !options.Until.IsZero() {
+			until := options.Until.UTC()
+			document.Until = &until
+		}
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(document)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("write export: %w", err)
+	}
+	export.SHA256 = part.SHA256
+	return export, nil
+}
+
+func writeHistoryCSV(w io.Writer, records []db.HistoryRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(historyCSVHeader); err != nil {
+		return err
+	}
+	for _, r := range records {
+		row := []string{r.Kind, r.ID, r.Peer, r.Question, r.Answer, r.Status, r.Reason, r.CreatedAt.UTC().Format(time.RFC3339)}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
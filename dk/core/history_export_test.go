@@ -0,0 +1,102 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExportHistory(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := context.Background()
+
+	for _, q := range []db.Query{
+		{ID: "qry-1", From: "bob", Question: "Old question?", Answer: "Old answer", Status: "accepted"},
+		{ID: "qry-2", From: "carol", Question: "Salaries, please?", Answer: "No", Status: "rejected", Reason: "Confidential"},
+	} {
+		if err := db.InsertQuery(ctx, testDB.DB, q); err != nil {
+			t.Fatalf("InsertQuery failed: %v", err)
+		}
+	}
+	if err := db.InsertAnswer(ctx, testDB.DB, db.Answer{Question: "When is the release?", User: "dave", Text: "Next week, \"probably\""}); err != nil {
+		t.Fatalf("InsertAnswer failed: %v", err)
+	}
+	for table, times := range map[string][]string{
+		"queries": {"qry-1", "2025-04-01 09:00:00", "qry-2", "2025-05-02 10:00:00"},
+		"answers": {"dave", "2025-05-03 11:00:00"},
+	} {
+		column := "id"
+		if table == "answers" {
+			column = "user"
+		}
+		for i := 0; i < len(times); i += 2 {
+			if _, err := testDB.DB.Exec("UPDATE "+table+" SET created_at = ? WHERE "+column+" = ?", times[i+1], times[i]); err != nil {
+				t.Fatalf("Failed to set created_at: %v", err)
+			}
+		}
+	}
+
+	dir := t.TempDir()
+	since := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	export, err := ExportHistory(ctx, testDB.DB, HistoryExportOptions{Path: filepath.Join(dir, "history.json"), Since: since})
+	if err != nil {
+		t.Fatalf("ExportHistory failed: %v", err)
+	}
+	if export.Format != HistoryJSON || export.Queries != 1 || export.Answers != 1 || export.SHA256 == "" {
+		t.Errorf("Unexpected export: %+v", export)
+	}
+	data, err := os.ReadFile(export.Path)
+	if err != nil {
+		t.Fatalf("Failed to read export: %v", err)
+	}
+	var document historyDocument
+	if err := json.Unmarshal(data, &document); err != nil {
+		t.Fatalf("Export is not valid JSON: %v", err)
+	}
+	if len(document.Records) != 2 || document.Records[0].ID != "qry-2" || document.Records[0].Reason != "Confidential" ||
+		document.Records[1].Kind != db.HistoryAnswer || document.Since == nil || !document.Since.Equal(since) {
+		t.Errorf("Unexpected export content: %+v", document)
+	}
+
+	if _, err := ExportHistory(ctx, testDB.DB, HistoryExportOptions{Path: export.Path}); !errors.Is(err, ErrExportExists) {
+		t.Errorf("Expected an existing export not to be replaced, got %v", err)
+	}
+	if _, err := ExportHistory(ctx, testDB.DB, HistoryExportOptions{Path: export.Path, Overwrite: true}); err != nil {
+		t.Errorf("Expected Overwrite to replace the export, got %v", err)
+	}
+
+	// The format follows the extension
+	export, err = ExportHistory(ctx, testDB.DB, HistoryExportOptions{Path: filepath.Join(dir, "history.csv"), Until: since})
+	if err != nil {
+		t.Fatalf("ExportHistory failed: %v", err)
+	}
+	f, err := os.Open(export.Path)
+	if err != nil {
+		t.Fatalf("Failed to open export: %v", err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatalf("Export is not valid CSV: %v", err)
+	}
+	if len(rows) != 2 || rows[1][1] != "qry-1" || rows[1][7] != "2025-04-01T09:00:00Z" {
+		t.Errorf("Unexpected CSV export: %v", rows)
+	}
+
+	if _, err := ExportHistory(ctx, testDB.DB, HistoryExportOptions{Path: filepath.Join(dir, "x.xml"), Format: "xml"}); err == nil {
+		t.Error("Expected an unknown format to be refused")
+	}
+}
@@ -0,0 +1,352 @@
+package core
+
+import (
+	"context"
+	dk_client "dk/client"
+	"dk/db"
+	"dk/utils"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Message types of the key escrow protocol. Direct messages are end-to-end encrypted, so
+// shares are only readable by their recipient.
+const (
+	EscrowShareMessageType    = "escrow_share"            // owner -> trustee: a share to hold
+	EscrowRecoveryMessageType = "escrow_recovery_request" // new device -> trustee: release a share
+	EscrowReleaseMessageType  = "escrow_share_release"    // trustee -> new device: the released share
+)
+
+// EscrowMessage is the payload of the key escrow messages
+type EscrowMessage struct {
+	Owner      string `json:"owner"`                 // Peer whose identity key the share belongs to
+	ShareIndex int    `json:"share_index,omitempty"` // Set on shares and releases
+	Threshold  int    `json:"threshold,omitempty"`   // Set on shares and releases
+	Share      []byte `json:"share,omitempty"`       // Set on shares and releases
+}
+
+// KeyRecoveryStatus reports the progress of recovering an identity key
+type KeyRecoveryStatus struct {
+	Owner     string   `json:"owner"`
+	Collected int      `json:"collected"`
+	Threshold int      `json:"threshold"`
+	Trustees  []string `json:"trustees"` // Trustees that released their share
+	Recovered bool     `json:"recovered"`
+}
+
+// sendEscrowMessage sends a key escrow message to a peer
+func sendEscrowMessage(client *dk_client.Client, to, messageType string, payload EscrowMessage) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode escrow message: %w", err)
+	}
+	content, err := json.Marshal(utils.RemoteMessage{Type: messageType, Message: string(body)})
+	if err != nil {
+		return fmt.Errorf("failed to encode escrow message: %w", err)
+	}
+	return client.SendMessage(dk_client.Message{To: to, Content: string(content)})
+}
+
+// DistributeIdentityKey splits the identity key into one share per trustee, any threshold of
+// which recover it, and sends each trustee its share. A new distribution replaces the previous
+// one; shares of earlier distributions cannot be combined with the new ones.
+func DistributeIdentityKey(ctx context.Context, trustees []string, threshold int) ([]db.EscrowTrustee, error) {
+	client, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var peers []string
+	for _, trustee := range trustees {
+		trustee = strings.TrimPrefix(strings.TrimSpace(trustee), "@")
+		if trustee == "" || seen[trustee] {
+			continue
+		}
+		if trustee == client.UserID {
+			return nil, fmt.Errorf("you cannot be your own trustee")
+		}
+		// Shares are encrypted to the trustee's key, so the trustee must be registered
+		if _, err := client.GetUserPublicKey(trustee); err != nil {
+			return nil, fmt.Errorf("unknown trustee %s: %w", trustee, err)
+		}
+		seen[trustee] = true
+		peers = append(peers, trustee)
+	}
+
+	shares, err := client.SplitIdentityKey(len(peers), threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]db.EscrowTrustee, 0, len(peers))
+	for i, trustee := range peers {
+		share := shares[i]
+		err := sendEscrowMessage(client, trustee, EscrowShareMessageType, EscrowMessage{
+			Owner:      client.UserID,
+			ShareIndex: int(share.Index),
+			Threshold:  share.Threshold,
+			Share:      share.Value,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to send share to %s: %w", trustee, err)
+		}
+		records = append(records, db.EscrowTrustee{TrusteeID: trustee, ShareIndex: int(share.Index), Threshold: share.Threshold})
+	}
+
+	if err := db.ReplaceEscrowTrustees(ctx, database, records); err != nil {
+		return nil, err
+	}
+	log.Printf("[Escrow] Identity key split into %d shares (threshold %d)", len(records), threshold)
+	return records, nil
+}
+
+// RequestKeyRecovery asks trustees to release their shares of owner's identity key to this
+// peer. It runs on the new device under a temporary identity.
+func RequestKeyRecovery(ctx context.Context, owner string, trustees []string) error {
+	client, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	owner = strings.TrimPrefix(strings.TrimSpace(owner), "@")
+	if owner == "" {
+		return fmt.Errorf("owner is required")
+	}
+	if owner == client.UserID {
+		return fmt.Errorf("recovery must be requested from a different identity than %s", owner)
+	}
+
+	sent := 0
+	for _, trustee := range trustees {
+		trustee = strings.TrimPrefix(strings.TrimSpace(trustee), "@")
+		if trustee == "" {
+			continue
+		}
+		if err := sendEscrowMessage(client, trustee, EscrowRecoveryMessageType, EscrowMessage{Owner: owner}); err != nil {
+			return fmt.Errorf("failed to send recovery request to %s: %w", trustee, err)
+		}
+		sent++
+	}
+	if sent == 0 {
+		return fmt.Errorf("at least one trustee is required")
+	}
+	log.Printf("[Escrow] Requested recovery of %s's key from %d trustees", owner, sent)
+	return nil
+}
+
+// DecideKeyRecovery approves or denies a recovery request received as a trustee. Approving
+// sends the held share to the requesting device.
+func DecideKeyRecovery(ctx context.Context, requestID string, approve bool) (*db.EscrowRequest, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	request, err := db.GetEscrowRequest(ctx, database, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if request.Status != db.EscrowRequestPending {
+		return nil, fmt.Errorf("recovery request %s is already %s", requestID, request.Status)
+	}
+
+	if !approve {
+		if err := db.DecideEscrowRequest(ctx, database, requestID, db.EscrowRequestDenied); err != nil {
+			return nil, err
+		}
+		request.Status = db.EscrowRequestDenied
+		return request, nil
+	}
+
+	client, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	share, err := db.GetHeldEscrowShare(ctx, database, request.OwnerID)
+	if err != nil {
+		return nil, fmt.Errorf("no share held for %s: %w", request.OwnerID, err)
+	}
+	err = sendEscrowMessage(client, request.RequesterID, EscrowReleaseMessageType, EscrowMessage{
+		Owner:      share.OwnerID,
+		ShareIndex: share.ShareIndex,
+		Threshold:  share.Threshold,
+		Share:      share.Share,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to release share: %w", err)
+	}
+	if err := db.DecideEscrowRequest(ctx, database, requestID, db.EscrowRequestApproved); err != nil {
+		return nil, err
+	}
+	log.Printf("[Escrow] Released share of %s's key to %s", request.OwnerID, request.RequesterID)
+	request.Status = db.EscrowRequestApproved
+	return request, nil
+}
+
+// KeyRecoveryProgress reports how many shares of owner's key have been collected
+func KeyRecoveryProgress(ctx context.Context, owner string) (*KeyRecoveryStatus, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	shares, err := db.ListCollectedEscrowShares(ctx, database, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &KeyRecoveryStatus{Owner: owner, Collected: len(shares), Trustees: []string{}}
+	for _, share := range shares {
+		status.Threshold = share.Threshold
+		status.Trustees = append(status.Trustees, share.TrusteeID)
+	}
+	return status, nil
+}
+
+// CompleteKeyRecovery combines the collected shares of owner's identity key, checks the result
+// against the public key owner registered on the server, and writes the key pair in the format
+// of utils.LoadOrCreateKeys. Existing files are never overwritten.
+func CompleteKeyRecovery(ctx context.Context, owner, privateKeyPath, publicKeyPath string) (*KeyRecoveryStatus, error) {
+	client, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := KeyRecoveryProgress(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	if status.Collected == 0 || status.Collected < status.Threshold {
+		return status, fmt.Errorf("%d of %d shares collected", status.Collected, status.Threshold)
+	}
+
+	collected, err := db.ListCollectedEscrowShares(ctx, database, owner)
+	if err != nil {
+		return nil, err
+	}
+	shares := make([]dk_client.SecretShare, 0, len(collected))
+	for _, s := range collected {
+		shares = append(shares, dk_client.SecretShare{Index: byte(s.ShareIndex), Threshold: s.Threshold, Value: s.Share})
+	}
+
+	publicKey, err := client.GetUserPublicKey(owner)
+	if err != nil {
+		return status, fmt.Errorf("failed to get %s's public key: %w", owner, err)
+	}
+	privateKey, err := dk_client.RecoverIdentityKey(shares, publicKey)
+	if err != nil {
+		return status, err
+	}
+
+	for _, path := range []string{privateKeyPath, publicKeyPath} {
+		if _, err := os.Stat(path); err == nil {
+			return status, fmt.Errorf("%s already exists", path)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return status, err
+		}
+	}
+	if err := os.WriteFile(privateKeyPath, []byte(hex.EncodeToString(privateKey)), 0600); err != nil {
+		return status, err
+	}
+	if err := os.WriteFile(publicKeyPath, []byte(hex.EncodeToString(publicKey)), 0600); err != nil {
+		return status, err
+	}
+
+	if err := db.DeleteCollectedEscrowShares(ctx, database, owner); err != nil {
+		log.Printf("[Escrow] Failed to delete collected shares: %v", err)
+	}
+	status.Recovered = true
+	log.Printf("[Escrow] Recovered %s's identity key from %d shares", owner, len(shares))
+	return status, nil
+}
+
+// HandleEscrowMessage processes an incoming key escrow message. Only messages whose signature
+// was verified are handled, since the sender decides whose key a share belongs to and who
+// receives a released share.
+func HandleEscrowMessage(ctx context.Context, msg dk_client.Message) (string, error) {
+	if msg.Status != "verified" {
+		return "", fmt.Errorf("rejected escrow message from %s: the message is %s", msg.From, msg.Status)
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var remoteMsg utils.RemoteMessage
+	if err := json.Unmarshal([]byte(msg.Content), &remoteMsg); err != nil {
+		return "", fmt.Errorf("invalid escrow message: %w", err)
+	}
+	var payload EscrowMessage
+	if err := json.Unmarshal([]byte(remoteMsg.Message), &payload); err != nil {
+		return "", fmt.Errorf("invalid escrow payload: %w", err)
+	}
+
+	switch remoteMsg.Type {
+	case EscrowShareMessageType:
+		// Only the owner of a key can hand out its shares
+		if payload.Owner != msg.From || len(payload.Share) == 0 {
+			return "", fmt.Errorf("rejected escrow share for %s sent by %s", payload.Owner, msg.From)
+		}
+		if err := db.PutHeldEscrowShare(ctx, database, db.EscrowShare{
+			OwnerID:    payload.Owner,
+			ShareIndex: payload.ShareIndex,
+			Threshold:  payload.Threshold,
+			Share:      payload.Share,
+		}); err != nil {
+			return "", err
+		}
+		log.Printf("[Escrow] Holding a share of %s's identity key", payload.Owner)
+
+	case EscrowRecoveryMessageType:
+		// Requests for keys we hold no share of are ignored without revealing that
+		if _, err := db.GetHeldEscrowShare(ctx, database, payload.Owner); err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				log.Printf("[Escrow] Ignoring recovery request for %s from %s: no share held", payload.Owner, msg.From)
+				return "", nil
+			}
+			return "", err
+		}
+		request := db.EscrowRequest{
+			ID:          "esc-" + uuid.NewString()[:8],
+			OwnerID:     payload.Owner,
+			RequesterID: msg.From,
+			Status:      db.EscrowRequestPending,
+		}
+		if err := db.InsertEscrowRequest(ctx, database, request); err != nil {
+			return "", err
+		}
+		log.Printf("[Escrow] %s requests recovery of %s's key (request %s awaits approval)", msg.From, payload.Owner, request.ID)
+
+	case EscrowReleaseMessageType:
+		if len(payload.Share) == 0 {
+			return "", fmt.Errorf("empty escrow share released by %s", msg.From)
+		}
+		if err := db.PutCollectedEscrowShare(ctx, database, db.EscrowShare{
+			OwnerID:    payload.Owner,
+			TrusteeID:  msg.From,
+			ShareIndex: payload.ShareIndex,
+			Threshold:  payload.Threshold,
+			Share:      payload.Share,
+		}); err != nil {
+			return "", err
+		}
+		log.Printf("[Escrow] %s released a share of %s's identity key", msg.From, payload.Owner)
+
+	default:
+		return "", fmt.Errorf("unknown escrow message type %q", remoteMsg.Type)
+	}
+	return "", nil
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"context"
+	dk_client "dk/client"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestHandleEscrowMessage(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := utils.WithDatabase(context.Background(), testDB.DB)
+
+	message := func(from, messageType, status string, payload EscrowMessage) dk_client.Message {
+		t.Helper()
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := json.Marshal(utils.RemoteMessage{Type: messageType, Message: string(encoded)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dk_client.Message{From: from, Content: string(content), Status: status}
+	}
+	share := EscrowMessage{Owner: "alice", ShareIndex: 1, Threshold: 2, Share: []byte{1, 2, 3}}
+
+	// Messages whose signature was not verified are rejected, whatever they claim
+	for _, status := range []string{"", "unverified", "spoofed"} {
+		for _, messageType := range []string{EscrowShareMessageType, EscrowRecoveryMessageType, EscrowReleaseMessageType} {
+			if _, err := HandleEscrowMessage(ctx, message("alice", messageType, status, share)); err == nil {
+				t.Errorf("Expected a %s message with status %q to be rejected", messageType, status)
+			}
+		}
+	}
+	if _, err := db.GetHeldEscrowShare(ctx, testDB.DB, "alice"); !errors.Is(err, db.ErrNotFound) {
+		t.Errorf("Expected no share to be held, got %v", err)
+	}
+	if collected, err := db.ListCollectedEscrowShares(ctx, testDB.DB, "alice"); err != nil || len(collected) != 0 {
+		t.Errorf("Expected no share to be collected, got %d (%v)", len(collected), err)
+	}
+
+	// Verified shares from their owner are held, and recovery requests for them await approval
+	if _, err := HandleEscrowMessage(ctx, message("alice", EscrowShareMessageType, "verified", share)); err != nil {
+		t.Fatalf("Expected the verified share to be held, got %v", err)
+	}
+	if held, err := db.GetHeldEscrowShare(ctx, testDB.DB, "alice"); err != nil || held.ShareIndex != 1 {
+		t.Errorf("Expected the share of alice, got %+v (%v)", held, err)
+	}
+	if _, err := HandleEscrowMessage(ctx, message("bob", EscrowRecoveryMessageType, "unverified", EscrowMessage{Owner: "alice"})); err == nil {
+		t.Error("Expected an unverified recovery request to be rejected")
+	}
+	if _, err := HandleEscrowMessage(ctx, message("bob", EscrowRecoveryMessageType, "verified", EscrowMessage{Owner: "alice"})); err != nil {
+		t.Fatalf("Expected the verified recovery request to be stored, got %v", err)
+	}
+	requests, err := db.ListEscrowRequests(ctx, testDB.DB, db.EscrowRequestPending)
+	if err != nil || len(requests) != 1 || requests[0].RequesterID != "bob" {
+		t.Errorf("Expected one pending request from bob, got %+v (%v)", requests, err)
+	}
+}
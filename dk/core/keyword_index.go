@@ -0,0 +1,304 @@
+package core
+
+import (
+	"context"
+	"dk/utils"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25 parameters, using the usual defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// rrfK dampens the weight of top ranks in reciprocal rank fusion. 60 is the value from the
+// original RRF paper and works well without tuning.
+const rrfK = 60
+
+// hybridCandidateFactor is how many candidates each retriever contributes to the fusion, as a
+// multiple of the number of results requested
+const hybridCandidateFactor = 2
+
+// KeywordIndex is an in-memory BM25 index over the documents in the vector store. Embeddings
+// are poor at matching exact terms such as IDs and names, so retrieval fuses both rankings.
+type KeywordIndex struct {
+	mu          sync.RWMutex
+	docs        map[string]*keywordDoc    // Keyed by the chromem document ID
+	postings    map[string]map[string]int // Term -> document ID -> term frequency
+	totalLength int
+}
+
+type keywordDoc struct {
+	FileName string
+	Content  string
+	Metadata map[string]string
+	Length   int
+}
+
+// KeywordHit is a document matched by a keyword search
+type KeywordHit struct {
+	ID       string
+	Document Document
+}
+
+// NewKeywordIndex creates an empty keyword index
+func NewKeywordIndex() *KeywordIndex {
+	return &KeywordIndex{
+		docs:     make(map[string]*keywordDoc),
+		postings: make(map[string]map[string]int),
+	}
+}
+
+// Len returns the number of indexed documents
+func (idx *KeywordIndex) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Add indexes a document, replacing any document with the same ID
+func (idx *KeywordIndex) Add(id, content string, metadata map[string]string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.removeLocked(id)
+
+	terms := tokenize(content)
+	meta := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		meta[k] = v
+	}
+	idx.docs[id] = &keywordDoc{
+		FileName: metadata["file"],
+		Content:  content,
+		Metadata: meta,
+		Length:   len(terms),
+	}
+	idx.totalLength += len(terms)
+	for _, term := range terms {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[string]int)
+		}
+		idx.postings[term][id]++
+	}
+}
+
+// RemoveFile removes every document that belongs to the given file
+func (idx *KeywordIndex) RemoveFile(fileName string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	for id, doc := range idx.docs {
+		if doc.FileName == fileName {
+			idx.removeLocked(id)
+		}
+	}
+}
+
+// Clear removes every document from the index
+func (idx *KeywordIndex) Clear() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.docs = make(map[string]*keywordDoc)
+	idx.postings = make(map[string]map[string]int)
+	idx.totalLength = 0
+}
+
+func (idx *KeywordIndex) removeLocked(id string) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for _, term := range tokenize(doc.Content) {
+		if posting, ok := idx.postings[term]; ok {
+			delete(posting, id)
+			if len(posting) == 0 {
+				delete(idx.postings, term)
+			}
+		}
+	}
+	idx.totalLength -= doc.Length
+	delete(idx.docs, id)
+}
+
+// Search returns up to n documents ranked by BM25 score. Only documents whose metadata
+// contains every key/value pair of filter are considered.
+func (idx *KeywordIndex) Search(query string, n int, filter map[string]string) []KeywordHit {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if n <= 0 || len(idx.docs) == 0 {
+		return nil
+	}
+
+	total := float64(len(idx.docs))
+	avgLength := float64(idx.totalLength) / total
+	scores := make(map[string]float64)
+
+	seen := make(map[string]bool)
+	for _, term := range tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		posting := idx.postings[term]
+		if len(posting) == 0 {
+			continue
+		}
+		df := float64(len(posting))
+		idf := math.Log(1 + (total-df+0.5)/(df+0.5))
+		for id, tf := range posting {
+			doc := idx.docs[id]
+			if !matchesFilter(doc.Metadata, filter) {
+				continue
+			}
+			freq := float64(tf)
+			norm := 1 - bm25B + bm25B*float64(doc.Length)/avgLength
+			scores[id] += idf * freq * (bm25K1 + 1) / (freq + bm25K1*norm)
+		}
+	}
+
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+
+	hits := make([]KeywordHit, 0, len(ids))
+	for _, id := range ids {
+		doc := idx.docs[id]
+		metadata := make(map[string]string)
+		for key, value := range doc.Metadata {
+			if key != "file" {
+				metadata[key] = value
+			}
+		}
+		hits = append(hits, KeywordHit{
+			ID: id,
+			Document: Document{
+				FileName: doc.FileName,
+				Content:  doc.Content,
+				Metadata: metadata,
+				Score:    float32(scores[id]),
+			},
+		})
+	}
+	return hits
+}
+
+func matchesFilter(metadata, filter map[string]string) bool {
+	for key, value := range filter {
+		if metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// tokenize lowercases text and splits it into terms. Identifiers joined by '-', '_' or '.'
+// (e.g. "REQ-1042" or "user_id") are kept whole and their parts are indexed as well, so both
+// the exact identifier and its pieces match.
+func tokenize(text string) []string {
+	var terms []string
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' && r != '.'
+	})
+	for _, field := range fields {
+		field = strings.Trim(field, "-_.")
+		if field == "" {
+			continue
+		}
+		parts := strings.FieldsFunc(field, func(r rune) bool { return r == '-' || r == '_' || r == '.' })
+		if len(parts) > 1 {
+			terms = append(terms, field)
+		}
+		terms = append(terms, parts...)
+	}
+	return terms
+}
+
+// rankedDocument is one entry of a ranking passed to fuseRankings
+type rankedDocument struct {
+	ID       string
+	Document Document
+}
+
+// fuseRankings merges rankings with reciprocal rank fusion: each document scores the sum of
+// 1/(rrfK + rank) over the rankings it appears in. The fused score replaces Document.Score.
+func fuseRankings(n int, rankings ...[]rankedDocument) []Document {
+	scores := make(map[string]float64)
+	docs := make(map[string]Document)
+	var order []string
+	for _, ranking := range rankings {
+		for rank, entry := range ranking {
+			if _, ok := docs[entry.ID]; !ok {
+				docs[entry.ID] = entry.Document
+				order = append(order, entry.ID)
+			}
+			scores[entry.ID] += 1 / float64(rrfK+rank+1)
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+	if len(order) > n {
+		order = order[:n]
+	}
+
+	results := make([]Document, 0, len(order))
+	for _, id := range order {
+		doc := docs[id]
+		doc.Score = float32(scores[id])
+		results = append(results, doc)
+	}
+	return results
+}
+
+// BuildKeywordIndex indexes every document currently in the vector store
+func BuildKeywordIndex(ctx context.Context, idx *KeywordIndex) error {
+	chromemCollection, err := utils.ChromemCollectionFromContext(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get Chromem collection: %w", err)
+	}
+
+	idx.Clear()
+	results, err := allDocuments(ctx, chromemCollection, false)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve documents: %w", err)
+	}
+	for _, res := range results {
+		idx.Add(res.ID, strings.TrimPrefix(res.Content, "search_document: "), res.Metadata)
+	}
+	log.Printf("[RAG] Keyword index built with %d documents", idx.Len())
+	return nil
+}
+
+type keywordIndexKey struct{}
+
+// WithKeywordIndex adds a keyword index to the context
+func WithKeywordIndex(ctx context.Context, idx *KeywordIndex) context.Context {
+	return context.WithValue(ctx, keywordIndexKey{}, idx)
+}
+
+// KeywordIndexFromContext returns the keyword index of the context, or nil if retrieval is
+// vector only
+func KeywordIndexFromContext(ctx context.Context) *KeywordIndex {
+	idx, _ := ctx.Value(keywordIndexKey{}).(*KeywordIndex)
+	return idx
+}
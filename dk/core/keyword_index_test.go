@@ -0,0 +1,70 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestKeywordIndexMatchesExactTerms(t *testing.T) {
+	idx := NewKeywordIndex()
+	idx.Add("1", "Ticket REQ-1042 covers the login timeout.", map[string]string{"file": "tickets.txt", "active": "true"})
+	idx.Add("2", "General notes about authentication and login flows.", map[string]string{"file": "notes.txt", "active": "true"})
+	idx.Add("3", "REQ-1042 was closed as a duplicate.", map[string]string{"file": "archive.txt", "active": "false"})
+
+	hits := idx.Search("what is REQ-1042?", 5, map[string]string{"active": "true"})
+	if len(hits) != 1 || hits[0].Document.FileName != "tickets.txt" {
+		t.Fatalf("Expected only the active ticket to match, got %+v", hits)
+	}
+	if _, ok := hits[0].Document.Metadata["file"]; ok {
+		t.Error("Expected the file key to be moved out of the metadata")
+	}
+
+	// Parts of an identifier match as well
+	if hits := idx.Search("1042", 5, nil); len(hits) != 2 {
+		t.Errorf("Expected 2 documents to match an identifier part, got %d", len(hits))
+	}
+
+	idx.RemoveFile("tickets.txt")
+	if hits := idx.Search("REQ-1042", 5, map[string]string{"active": "true"}); len(hits) != 0 {
+		t.Errorf("Expected removed file not to match, got %+v", hits)
+	}
+	if idx.Len() != 2 {
+		t.Errorf("Expected 2 indexed documents, got %d", idx.Len())
+	}
+
+	idx.Clear()
+	if hits := idx.Search("login", 5, nil); len(hits) != 0 || idx.Len() != 0 {
+		t.Errorf("Expected an empty index after Clear, got %d hits", len(hits))
+	}
+}
+
+func TestKeywordIndexRanksByTermFrequency(t *testing.T) {
+	idx := NewKeywordIndex()
+	idx.Add("1", "chromem stores vectors", nil)
+	idx.Add("2", "chromem chromem chromem is the vector store", nil)
+	idx.Add("3", "unrelated document", nil)
+
+	hits := idx.Search("chromem", 1, nil)
+	if len(hits) != 1 || hits[0].ID != "2" {
+		t.Fatalf("Expected the document mentioning the term most to rank first, got %+v", hits)
+	}
+}
+
+func TestFuseRankings(t *testing.T) {
+	doc := func(id string) rankedDocument {
+		return rankedDocument{ID: id, Document: Document{FileName: id + ".txt"}}
+	}
+	vector := []rankedDocument{doc("a"), doc("b"), doc("c")}
+	keyword := []rankedDocument{doc("c"), doc("d")}
+
+	fused := fuseRankings(3, vector, keyword)
+	if len(fused) != 3 {
+		t.Fatalf("Expected 3 fused results, got %d", len(fused))
+	}
+	// c is found by both retrievers and beats a, which only the vector search ranked first
+	if fused[0].FileName != "c.txt" || fused[1].FileName != "a.txt" {
+		t.Errorf("Unexpected fused order: %s, %s", fused[0].FileName, fused[1].FileName)
+	}
+	if fused[0].Score <= fused[1].Score {
+		t.Errorf("Expected fused scores to be descending, got %v and %v", fused[0].Score, fused[1].Score)
+	}
+}
@@ -0,0 +1,299 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Gap reasons of a query
+const (
+	GapRejected   = "rejected"   // The host rejected the drafted answer
+	GapUnanswered = "unanswered" // The draft was made without any relevant document
+)
+
+// Similarity above which two questions are put in the same gap, for question embeddings and,
+// when embeddings are not available, for the overlap of their words
+const (
+	gapEmbeddingThreshold = 0.75
+	gapWordThreshold      = 0.3
+)
+
+// Limits of the questions a gap is labeled from and of the documents suggested for it
+const (
+	gapLabelQuestions    = 10
+	gapSuggestedDocsMax  = 3
+	gapFallbackTopicSize = 3
+)
+
+// unansweredMarker is part of the answer the default prompt asks for when the documents do
+// not answer a question
+const unansweredMarker = "cannot answer this question"
+
+const labelGapPrompt = `The following questions were asked to a knowledge base that could not answer them:
+
+%s
+Name the topic these questions have in common and suggest up to %d documents that, added to the knowledge base, would answer them. Reply in exactly this format and nothing else:
+Topic: <the topic in 2 to 5 words>
+- <a document to add>`
+
+// KnowledgeGap is a topic peers asked about that the knowledge base could not answer
+type KnowledgeGap struct {
+	Topic              string   `json:"topic"`
+	Questions          []string `json:"questions"`
+	QueryIDs           []string `json:"query_ids"`
+	Rejected           int      `json:"rejected"`
+	Unanswered         int      `json:"unanswered"`
+	SuggestedDocuments []string `json:"suggested_documents,omitempty"`
+}
+
+// KnowledgeGapReport groups the rejected and unanswered incoming queries by topic, largest gap first
+type KnowledgeGapReport struct {
+	AnalyzedAt time.Time      `json:"analyzed_at"`
+	Queries    int            `json:"queries"` // Rejected and unanswered queries analyzed
+	Embeddings bool           `json:"embeddings"`
+	Gaps       []KnowledgeGap `json:"gaps"`
+}
+
+var (
+	lastKnowledgeGapReport   *KnowledgeGapReport
+	lastKnowledgeGapReportMu sync.RWMutex
+)
+
+// LastKnowledgeGapReport returns the report of the most recent gap analysis, or nil if none ran yet
+func LastKnowledgeGapReport() *KnowledgeGapReport {
+	lastKnowledgeGapReportMu.RLock()
+	defer lastKnowledgeGapReportMu.RUnlock()
+	return lastKnowledgeGapReport
+}
+
+// gapReason returns why a query points at a gap in the knowledge base, or "" if it does not
+func gapReason(q db.Query) string {
+	if strings.EqualFold(q.Status, "rejected") {
+		return GapRejected
+	}
+	if strings.TrimSpace(q.Answer) == "" {
+		return ""
+	}
+	if len(q.DocumentsRelated) == 0 || strings.Contains(strings.ToLower(q.Answer), unansweredMarker) {
+		return GapUnanswered
+	}
+	return ""
+}
+
+// AnalyzeKnowledgeGaps clusters the rejected and unanswered incoming queries by topic and asks
+// the LLM to name each topic and suggest documents to add. Questions are compared by their
+// embeddings, or by their words when the embedding model is not available; topics are named
+// after their most common words when there is no LLM.
+func AnalyzeKnowledgeGaps(ctx context.Context) (*KnowledgeGapReport, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+	queries, err := db.ListQueries(ctx, database, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	var embed func(ctx context.Context, text string) ([]float32, error)
+	if collections := CollectionsFromContext(ctx); collections != nil {
+		embed = collections.Embed
+	}
+	llmProvider, _ := LLMProviderFromContext(ctx)
+
+	report := analyzeKnowledgeGaps(ctx, queries, embed, llmProvider)
+	lastKnowledgeGapReportMu.Lock()
+	lastKnowledgeGapReport = report
+	lastKnowledgeGapReportMu.Unlock()
+	return report, nil
+}
+
+// analyzeKnowledgeGaps implements AnalyzeKnowledgeGaps; embed and llmProvider may be nil
+func analyzeKnowledgeGaps(ctx context.Context, queries []db.Query, embed func(ctx context.Context, text string) ([]float32, error), llmProvider LLMProvider) *KnowledgeGapReport {
+	var gapQueries []db.Query
+	var reasons []string
+	for _, q := range queries {
+		if reason := gapReason(q); reason != "" {
+			gapQueries = append(gapQueries, q)
+			reasons = append(reasons, reason)
+		}
+	}
+	report := &KnowledgeGapReport{AnalyzedAt: time.Now(), Queries: len(gapQueries), Gaps: []KnowledgeGap{}}
+
+	similarity, threshold := questionSimilarity(ctx, gapQueries, embed)
+	report.Embeddings = threshold == gapEmbeddingThreshold
+
+	// Each question joins the cluster it is most similar to on average, or starts a new one
+	var clusters [][]int
+	for i := range gapQueries {
+		best, bestScore := -1, threshold
+		for c, members := range clusters {
+			total := 0.0
+			for _, j := range members {
+				total += similarity(i, j)
+			}
+			if score := total / float64(len(members)); score >= bestScore {
+				best, bestScore = c, score
+			}
+		}
+		if best < 0 {
+			clusters = append(clusters, []int{i})
+		} else {
+			clusters[best] = append(clusters[best], i)
+		}
+	}
+
+	for _, members := range clusters {
+		gap := KnowledgeGap{}
+		for _, i := range members {
+			gap.Questions = append(gap.Questions, gapQueries[i].Question)
+			gap.QueryIDs = append(gap.QueryIDs, gapQueries[i].ID)
+			if reasons[i] == GapRejected {
+				gap.Rejected++
+			} else {
+				gap.Unanswered++
+			}
+		}
+		gap.Topic, gap.SuggestedDocuments = labelGap(ctx, llmProvider, gap.Questions)
+		report.Gaps = append(report.Gaps, gap)
+	}
+	sort.SliceStable(report.Gaps, func(i, j int) bool {
+		return len(report.Gaps[i].Questions) > len(report.Gaps[j].Questions)
+	})
+	return report
+}
+
+// questionSimilarity returns the pairwise similarity of the questions and the threshold that
+// applies to it. Embeddings are used when every question can be embedded.
+func questionSimilarity(ctx context.Context, queries []db.Query, embed func(ctx context.Context, text string) ([]float32, error)) (func(i, j int) float64, float64) {
+	if embed != nil {
+		vectors := make([][]float32, len(queries))
+		var err error
+		for i, q := range queries {
+			if vectors[i], err = embed(ctx, "clustering: "+q.Question); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			return func(i, j int) float64 { return cosineSimilarity(vectors[i], vectors[j]) }, gapEmbeddingThreshold
+		}
+		log.Printf("[Insights] Embedding questions failed, comparing their words instead: %v", err)
+	}
+
+	words := make([]map[string]bool, len(queries))
+	for i, q := range queries {
+		words[i] = questionWords(q.Question)
+	}
+	return func(i, j int) float64 { return wordSimilarity(words[i], words[j]) }, gapWordThreshold
+}
+
+// cosineSimilarity returns the cosine of the angle between two vectors
+func cosineSimilarity(a, b []float32) float64 {
+	var dot, normA, normB float64
+	for i := range min(len(a), len(b)) {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// labelGap asks the LLM for the topic of a gap and the documents that would fill it. Without
+// an LLM, or when its reply cannot be used, the topic is made of the most common words.
+func labelGap(ctx context.Context, llmProvider LLMProvider, questions []string) (string, []string) {
+	if llmProvider != nil {
+		var list strings.Builder
+		for _, q := range questions[:min(len(questions), gapLabelQuestions)] {
+			fmt.Fprintf(&list, "- %s\n", q)
+		}
+		stream, err := llmProvider.GenerateStream(ctx, fmt.Sprintf(labelGapPrompt, list.String(), gapSuggestedDocsMax))
+		var reply string
+		if err == nil {
+			reply, err = CollectStream(ctx, stream)
+		}
+		if err != nil {
+			log.Printf("[Insights] Failed to label knowledge gap: %v", err)
+		} else if topic, docs := parseGapLabel(reply); topic != "" {
+			return topic, docs
+		}
+	}
+	return commonWordsTopic(questions), nil
+}
+
+// parseGapLabel reads the topic and suggested documents from the reply to labelGapPrompt
+func parseGapLabel(reply string) (string, []string) {
+	var topic string
+	var docs []string
+	for _, line := range strings.Split(reply, "\n") {
+		line = strings.TrimSpace(line)
+		if rest, ok := strings.CutPrefix(line, "Topic:"); ok && topic == "" {
+			topic = strings.TrimSpace(rest)
+		} else if rest, ok := strings.CutPrefix(line, "- "); ok && len(docs) < gapSuggestedDocsMax {
+			if rest = strings.TrimSpace(rest); rest != "" {
+				docs = append(docs, rest)
+			}
+		}
+	}
+	return topic, docs
+}
+
+// commonWordsTopic names a group of questions after the words that occur in most of them,
+// skipping short words, which are mostly question words and articles
+func commonWordsTopic(questions []string) string {
+	counts := make(map[string]int)
+	for _, q := range questions {
+		for word := range questionWords(q) {
+			if len(word) > 3 {
+				counts[word]++
+			}
+		}
+	}
+	words := make([]string, 0, len(counts))
+	for word := range counts {
+		words = append(words, word)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if counts[words[i]] != counts[words[j]] {
+			return counts[words[i]] > counts[words[j]]
+		}
+		return words[i] < words[j]
+	})
+	if len(words) == 0 {
+		return "general"
+	}
+	return strings.Join(words[:min(len(words), gapFallbackTopicSize)], " ")
+}
+
+// StartKnowledgeGapWorker begins a background worker that periodically analyzes knowledge gaps
+func StartKnowledgeGapWorker(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				report, err := AnalyzeKnowledgeGaps(ctx)
+				if err != nil {
+					log.Printf("Error analyzing knowledge gaps: %v", err)
+					continue
+				}
+				log.Printf("Knowledge gap analysis found %d gaps in %d queries", len(report.Gaps), report.Queries)
+			}
+		}
+	}()
+
+	log.Printf("Knowledge gap worker started with interval of %v", interval)
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"errors"
+	"testing"
+)
+
+func TestAnalyzeKnowledgeGaps(t *testing.T) {
+	queries := []db.Query{
+		{ID: "q1", Question: "How do I renew my passport abroad?", Status: "rejected"},
+		{ID: "q2", Question: "What is the capital of France?", Answer: "Paris.", DocumentsRelated: []string{"france.txt"}, Status: "accepted"},
+		{ID: "q3", Question: "How do I renew my passport when abroad?", Answer: "I cannot answer this question as the provided documents do not contain relevant information.", DocumentsRelated: []string{"travel.txt"}, Status: "pending"},
+		{ID: "q4", Question: "Which vaccines are needed for Brazil?", Answer: "Probably yellow fever.", Status: "accepted"},
+		{ID: "q5", Question: "Still waiting for a draft", Status: "pending"},
+	}
+
+	// Without embeddings or an LLM, questions are grouped by their words and named after them
+	report := analyzeKnowledgeGaps(context.Background(), queries, nil, nil)
+	if report.Queries != 3 || report.Embeddings {
+		t.Fatalf("Expected 3 queries analyzed by words, got %+v", report)
+	}
+	if len(report.Gaps) != 2 {
+		t.Fatalf("Expected 2 gaps, got %+v", report.Gaps)
+	}
+	passport := report.Gaps[0]
+	if len(passport.QueryIDs) != 2 || passport.Rejected != 1 || passport.Unanswered != 1 {
+		t.Errorf("Expected the passport questions grouped first, got %+v", passport)
+	}
+	if passport.Topic != "abroad passport renew" {
+		t.Errorf("Expected a topic of common words, got %q", passport.Topic)
+	}
+
+	// With embeddings and an LLM, the LLM names each gap
+	embed := func(ctx context.Context, text string) ([]float32, error) {
+		if text == "clustering: "+queries[3].Question {
+			return []float32{0, 1}, nil
+		}
+		return []float32{1, 0.1}, nil
+	}
+	llm := &stubProvider{answer: "Topic: Travel documents\n- Passport renewal guide\n- Consulate contacts"}
+	report = analyzeKnowledgeGaps(context.Background(), queries, embed, llm)
+	if !report.Embeddings || len(report.Gaps) != 2 {
+		t.Fatalf("Expected 2 gaps found by embeddings, got %+v", report)
+	}
+	if gap := report.Gaps[0]; gap.Topic != "Travel documents" || len(gap.SuggestedDocuments) != 2 {
+		t.Errorf("Expected the LLM's topic and suggestions, got %+v", gap)
+	}
+
+	// Failing embeddings fall back to words
+	failing := func(ctx context.Context, text string) ([]float32, error) { return nil, errors.New("no embedding model") }
+	if report := analyzeKnowledgeGaps(context.Background(), queries, failing, nil); report.Embeddings || len(report.Gaps) != 2 {
+		t.Errorf("Expected the word comparison after embeddings failed, got %+v", report)
+	}
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -32,6 +33,7 @@ type AnthropicRequest struct {
 	Temperature float64            `json:"temperature,omitempty"`
 	MaxTokens   int                `json:"max_tokens,omitempty"`
 	System      string             `json:"system,omitempty"`
+	Stream      bool               `json:"stream,omitempty"`
 }
 
 // AnthropicResponse represents a response from the Anthropic API
@@ -45,6 +47,18 @@ type AnthropicResponse struct {
 	} `json:"error,omitempty"`
 }
 
+// AnthropicStreamEvent represents a server-sent event from the streaming Anthropic API
+type AnthropicStreamEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"delta"`
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
 // NewAnthropicProvider creates a new Anthropic provider from a ModelConfig
 func NewAnthropicProvider(config ModelConfig) (*AnthropicProvider, error) {
 
@@ -66,15 +80,11 @@ func NewAnthropicProvider(config ModelConfig) (*AnthropicProvider, error) {
 // GenerateAnswer implements LLMProvider interface
 func (p *AnthropicProvider) GenerateAnswer(ctx context.Context, question string, docs []Document) (string, error) {
 	// Construct the system prompt and user prompt
-	systemPrompt := GenerateAnswerPrompt
+	templates := PromptTemplatesFromContext(ctx)
+	systemPrompt := templates.SystemPrompt()
 
 	// Construct a prompt that includes the question and context from the documents
-	prompt := fmt.Sprintf("<QUESTION>%s<QUESTION>\n", question)
-	prompt += "<CONTEXT>\n"
-	for _, doc := range docs {
-		prompt += fmt.Sprintf("%s", doc.Content)
-	}
-	prompt += "<CONTEXT>\n"
+	prompt := templates.AnswerPrompt(question, docs)
 
 	// userPrompt := fmt.Sprintf("Question: %s\n\nDocuments:\n", question)
 	// for i, doc := range docs {
@@ -149,7 +159,7 @@ func (p *AnthropicProvider) GenerateAnswer(ctx context.Context, question string,
 
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API error: %s", anthropicResp.Error.Message)
+		return "", &APIStatusError{StatusCode: resp.StatusCode, Message: anthropicResp.Error.Message}
 	}
 
 	// Extract the answer
@@ -160,6 +170,141 @@ func (p *AnthropicProvider) GenerateAnswer(ctx context.Context, question string,
 	return anthropicResp.Content[0].Text, nil
 }
 
+// GenerateStream implements LLMProvider interface
+func (p *AnthropicProvider) GenerateStream(ctx context.Context, prompt string) (<-chan Chunk, error) {
+	// Default to claude-3-sonnet-20240229 if not specified
+	model := p.config.Model
+	if model == "" {
+		model = "claude-3-sonnet-20240229"
+	}
+
+	// Create the request
+	apiURL := "https://api.anthropic.com/v1/messages"
+	if p.config.BaseURL != "" {
+		apiURL = p.config.BaseURL
+	}
+
+	req := AnthropicRequest{
+		Model:    model,
+		Messages: []AnthropicMessage{{Role: "user", Content: prompt}},
+		System:   PromptTemplatesFromContext(ctx).SystemPrompt(),
+		Stream:   true,
+	}
+
+	// Apply custom parameters if provided
+	if p.config.Parameters != nil {
+		if temp, ok := p.config.Parameters["temperature"].(float64); ok {
+			req.Temperature = temp
+		}
+		if maxTokens, ok := p.config.Parameters["max_tokens"].(float64); ok {
+			req.MaxTokens = int(maxTokens)
+		}
+	}
+
+	// Convert request to JSON
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Create HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", apiURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add headers
+	httpReq.Header.Set("content-type", "application/json")
+	httpReq.Header.Set("accept", "text/event-stream")
+	httpReq.Header.Set("x-api-key", p.config.ApiKey)
+	httpReq.Header.Set("anthropic-version", "2023-06-01")
+
+	// Add custom headers if provided
+	if p.config.Headers != nil {
+		for key, value := range p.config.Headers {
+			httpReq.Header.Set(key, value)
+		}
+	}
+
+	// Send request
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var anthropicResp AnthropicResponse
+		if err := json.NewDecoder(resp.Body).Decode(&anthropicResp); err != nil {
+			return nil, fmt.Errorf("API error: status %d", resp.StatusCode)
+		}
+		return nil, &APIStatusError{StatusCode: resp.StatusCode, Message: anthropicResp.Error.Message}
+	}
+
+	ch := make(chan Chunk)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+
+		// The body is a stream of server-sent events; only the data lines carry content
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if !strings.HasPrefix(line, "data:") {
+				continue
+			}
+
+			var event AnthropicStreamEvent
+			if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+				continue // Skip events that can't be parsed
+			}
+
+			switch event.Type {
+			case "content_block_delta":
+				if event.Delta.Text != "" && !sendChunk(ctx, ch, Chunk{Content: event.Delta.Text}) {
+					return
+				}
+			case "message_stop":
+				sendChunk(ctx, ch, Chunk{Done: true})
+				return
+			case "error":
+				sendChunk(ctx, ch, Chunk{Err: fmt.Errorf("API error: %s", event.Error.Message)})
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			sendChunk(ctx, ch, Chunk{Err: fmt.Errorf("failed to read answer stream: %w", err)})
+			return
+		}
+		sendChunk(ctx, ch, Chunk{Done: true})
+	}()
+
+	return ch, nil
+}
+
+// Warm implements ProviderWarmer by opening a connection to the API; the response itself is ignored
+func (p *AnthropicProvider) Warm(ctx context.Context) error {
+	apiURL := "https://api.anthropic.com/v1/messages"
+	if p.config.BaseURL != "" {
+		apiURL = p.config.BaseURL
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "HEAD", apiURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil
+}
+
 // CheckAutomaticApproval implements LLMProvider interface
 func (p *AnthropicProvider) CheckAutomaticApproval(ctx context.Context, answer string, query Query, conditions []string) (string, bool, error) {
 	// Format the list as a pretty JSON string
@@ -239,7 +384,7 @@ func (p *AnthropicProvider) CheckAutomaticApproval(ctx context.Context, answer s
 
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
-		return "API error", false, fmt.Errorf("API error: %s", anthropicResp.Error.Message)
+		return "API error", false, &APIStatusError{StatusCode: resp.StatusCode, Message: anthropicResp.Error.Message}
 	}
 
 	// Extract the answer
@@ -345,7 +490,7 @@ func (p *AnthropicProvider) GenerateDescription(ctx context.Context, text string
 
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API error")
+		return "", &APIStatusError{StatusCode: resp.StatusCode, Message: string(body)}
 	}
 
 	// Parse the response - Ollama streams the response, so we need to collect it all
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"dk/db"
+	"dk/utils"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// defaultLLMCacheTTL is used when the cache is enabled without a TTL
+const defaultLLMCacheTTL = time.Hour
+
+// LLMCacheConfig enables caching of LLM completions
+type LLMCacheConfig struct {
+	TTLSeconds int `json:"ttl_seconds"` // How long a completion is served from cache; defaults to one hour
+}
+
+// LLMCacheStats reports how effective the LLM cache is
+type LLMCacheStats struct {
+	Enabled    bool    `json:"enabled"`
+	TTLSeconds int     `json:"ttl_seconds"`
+	Hits       int64   `json:"hits"`     // Cache hits since startup
+	Misses     int64   `json:"misses"`   // Cache misses since startup
+	HitRate    float64 `json:"hit_rate"` // Hits / (hits + misses) since startup
+	db.LLMCacheStats
+}
+
+// LLMCache serves identical prompts from a persistent cache of previous completions.
+// Entries are stored in the llm_cache table of the database of the context.
+type LLMCache struct {
+	TTL    time.Duration
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// NewLLMCache creates a cache from its configuration
+func NewLLMCache(config LLMCacheConfig) *LLMCache {
+	ttl := time.Duration(config.TTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = defaultLLMCacheTTL
+	}
+	return &LLMCache{TTL: ttl}
+}
+
+// LLMCacheKey hashes everything that determines a completion: the provider and the full prompt
+func LLMCacheKey(provider, systemPrompt, prompt string) string {
+	h := sha256.New()
+	for _, part := range []string{provider, systemPrompt, prompt} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cacheProviderName identifies a provider, or a whole fallback chain, in cache keys
+func cacheProviderName(llmProvider LLMProvider) string {
+	if chain, ok := llmProvider.(*FallbackProvider); ok {
+		return strings.Join(chain.Names(), ",")
+	}
+	return describeProvider(llmProvider)
+}
+
+// Lookup returns the cached completion for a key, if there is an unexpired one
+func (c *LLMCache) Lookup(ctx context.Context, key string) (string, bool) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return "", false
+	}
+
+	entry, err := db.GetLLMCacheEntry(ctx, database, key, time.Now())
+	if err != nil {
+		if !errors.Is(err, db.ErrNotFound) {
+			log.Printf("[LLM] Cache lookup failed: %v", err)
+		}
+		c.misses.Add(1)
+		return "", false
+	}
+	c.hits.Add(1)
+	return entry.Response, true
+}
+
+// Store caches a completion for a key
+func (c *LLMCache) Store(ctx context.Context, key, provider, response string) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	if err := db.PutLLMCacheEntry(ctx, database, db.LLMCacheEntry{
+		PromptHash: key,
+		Provider:   provider,
+		Response:   response,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(c.TTL),
+	}); err != nil {
+		log.Printf("[LLM] Failed to cache completion: %v", err)
+	}
+}
+
+// Tee forwards a completion stream and caches the completion once it finishes successfully.
+// The entry is stored before the final chunk is forwarded.
+func (c *LLMCache) Tee(ctx context.Context, key, provider string, stream <-chan Chunk) <-chan Chunk {
+	out := make(chan Chunk)
+	go func() {
+		defer close(out)
+		var sb strings.Builder
+		for chunk := range stream {
+			if chunk.Err == nil {
+				sb.WriteString(chunk.Content)
+				if chunk.Done && sb.Len() > 0 {
+					c.Store(ctx, key, provider, sb.String())
+				}
+			}
+			if !sendChunk(ctx, out, chunk) || chunk.Done || chunk.Err != nil {
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// Stats returns the hit counters since startup together with the contents of the cache
+func (c *LLMCache) Stats(ctx context.Context) (LLMCacheStats, error) {
+	stats := LLMCacheStats{
+		Enabled:    true,
+		TTLSeconds: int(c.TTL / time.Second),
+		Hits:       c.hits.Load(),
+		Misses:     c.misses.Load(),
+	}
+	if lookups := stats.Hits + stats.Misses; lookups > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(lookups)
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return stats, err
+	}
+	stats.LLMCacheStats, err = db.GetLLMCacheStats(ctx, database, time.Now())
+	return stats, err
+}
+
+// Clear removes every cached completion
+func (c *LLMCache) Clear(ctx context.Context) (int64, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return db.ClearLLMCache(ctx, database)
+}
+
+type llmCacheKey struct{}
+
+// WithLLMCache adds an LLM cache to the context
+func WithLLMCache(ctx context.Context, cache *LLMCache) context.Context {
+	return context.WithValue(ctx, llmCacheKey{}, cache)
+}
+
+// LLMCacheFromContext returns the LLM cache of the context, or nil if caching is disabled
+func LLMCacheFromContext(ctx context.Context) *LLMCache {
+	cache, _ := ctx.Value(llmCacheKey{}).(*LLMCache)
+	return cache
+}
@@ -6,6 +6,10 @@ import (
 
 // CreateLLMProvider creates an LLM provider based on the provided configuration
 func CreateLLMProvider(config ModelConfig) (LLMProvider, error) {
+	if len(config.Providers) > 0 {
+		return NewFallbackProvider(config.Providers)
+	}
+
 	switch config.Provider {
 	case "openai":
 		return NewOpenAIProvider(config)
@@ -0,0 +1,328 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// APIStatusError is returned by providers when the LLM API answers with a non-200 status
+type APIStatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIStatusError) Error() string {
+	return fmt.Sprintf("API error: %s", e.Message)
+}
+
+// ProviderTrace records which provider of a fallback chain served a request
+type ProviderTrace struct {
+	mu       sync.Mutex
+	Provider string   // Provider that produced the result, e.g. "openai/gpt-4o"
+	Failed   []string // Providers that were tried first and failed over
+}
+
+type providerTraceKey struct{}
+
+// WithProviderTrace attaches a new ProviderTrace to the context
+func WithProviderTrace(ctx context.Context) (context.Context, *ProviderTrace) {
+	trace := &ProviderTrace{}
+	return context.WithValue(ctx, providerTraceKey{}, trace), trace
+}
+
+// ProviderTraceFromContext returns the ProviderTrace attached to the context, if any
+func ProviderTraceFromContext(ctx context.Context) *ProviderTrace {
+	trace, _ := ctx.Value(providerTraceKey{}).(*ProviderTrace)
+	return trace
+}
+
+func (t *ProviderTrace) served(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Provider = name
+}
+
+func (t *ProviderTrace) failed(name string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Failed = append(t.Failed, name)
+}
+
+// ProviderName returns the identifier used to record which provider served a request
+func ProviderName(config ModelConfig) string {
+	if config.Model == "" {
+		return config.Provider
+	}
+	return config.Provider + "/" + config.Model
+}
+
+// describeProvider returns the identifier of a single (non-chain) provider
+func describeProvider(provider LLMProvider) string {
+	switch p := provider.(type) {
+	case *OpenAIProvider:
+		return ProviderName(p.config)
+	case *AnthropicProvider:
+		return ProviderName(p.config)
+	case *OllamaProvider:
+		return ProviderName(p.config)
+	default:
+		return fmt.Sprintf("%T", provider)
+	}
+}
+
+// recordProviderUsage stores which provider served a request, as captured by trace
+func recordProviderUsage(ctx context.Context, llmProvider LLMProvider, trace *ProviderTrace, operation, queryID string) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return
+	}
+
+	trace.mu.Lock()
+	provider := trace.Provider
+	failed := strings.Join(trace.Failed, ",")
+	trace.mu.Unlock()
+	if provider == "" {
+		provider = describeProvider(llmProvider)
+	}
+
+	if err := db.InsertLLMRequest(ctx, database, db.LLMRequest{
+		QueryID:    queryID,
+		Operation:  operation,
+		Provider:   provider,
+		FailedOver: failed,
+	}); err != nil {
+		log.Printf("[LLM] Failed to record provider usage: %v", err)
+	}
+}
+
+// fallbackEntry is one provider of a fallback chain
+type fallbackEntry struct {
+	name     string
+	provider LLMProvider
+	timeout  time.Duration
+}
+
+// FallbackProvider implements the LLMProvider interface over an ordered list of providers,
+// failing over to the next one when a provider times out or is rate limited.
+type FallbackProvider struct {
+	entries []fallbackEntry
+}
+
+// NewFallbackProvider creates a fallback chain from an ordered list of model configurations.
+// Providers that cannot be created are skipped; at least one must succeed.
+func NewFallbackProvider(configs []ModelConfig) (*FallbackProvider, error) {
+	fp := &FallbackProvider{}
+	for _, config := range configs {
+		if len(config.Providers) > 0 {
+			return nil, fmt.Errorf("nested provider lists are not supported")
+		}
+
+		provider, err := CreateLLMProvider(config)
+		if err != nil {
+			log.Printf("[LLM] Skipping provider %s in fallback chain: %v", ProviderName(config), err)
+			continue
+		}
+
+		entry := fallbackEntry{name: ProviderName(config), provider: provider}
+		if config.TimeoutSeconds > 0 {
+			entry.timeout = time.Duration(config.TimeoutSeconds) * time.Second
+		}
+		fp.entries = append(fp.entries, entry)
+	}
+
+	if len(fp.entries) == 0 {
+		return nil, fmt.Errorf("no usable provider in fallback chain")
+	}
+	return fp, nil
+}
+
+// Names returns the provider identifiers of the chain in order
+func (fp *FallbackProvider) Names() []string {
+	names := make([]string, len(fp.entries))
+	for i, entry := range fp.entries {
+		names[i] = entry.name
+	}
+	return names
+}
+
+// try runs call against each provider in order until one succeeds or fails with an
+// error that does not warrant failing over
+func (fp *FallbackProvider) try(ctx context.Context, operation string, call func(ctx context.Context, provider LLMProvider) error) error {
+	trace := ProviderTraceFromContext(ctx)
+
+	var lastErr error
+	for i, entry := range fp.entries {
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if entry.timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, entry.timeout)
+		}
+		err := call(attemptCtx, entry.provider)
+		cancel()
+
+		if err == nil {
+			trace.served(entry.name)
+			if i > 0 {
+				log.Printf("[LLM] %s served by fallback provider %s", operation, entry.name)
+			}
+			return nil
+		}
+
+		lastErr = err
+		// Stop if the caller gave up or the failure is not transient
+		if ctx.Err() != nil || !isFailoverError(err) {
+			return err
+		}
+
+		trace.failed(entry.name)
+		log.Printf("[LLM] %s failed on provider %s, failing over: %v", operation, entry.name, err)
+	}
+
+	return fmt.Errorf("all providers failed: %w", lastErr)
+}
+
+// GenerateAnswer implements LLMProvider interface
+func (fp *FallbackProvider) GenerateAnswer(ctx context.Context, question string, docs []Document) (string, error) {
+	var answer string
+	err := fp.try(ctx, "GenerateAnswer", func(ctx context.Context, provider LLMProvider) error {
+		var err error
+		answer, err = provider.GenerateAnswer(ctx, question, docs)
+		return err
+	})
+	return answer, err
+}
+
+// GenerateStream implements LLMProvider interface. Failover only happens while opening
+// the stream; once chunks are flowing the stream belongs to a single provider. The timeout of
+// a provider bounds its whole stream, which ends with a timeout error when it runs out.
+func (fp *FallbackProvider) GenerateStream(ctx context.Context, prompt string) (<-chan Chunk, error) {
+	trace := ProviderTraceFromContext(ctx)
+
+	var lastErr error
+	for _, entry := range fp.entries {
+		if entry.timeout <= 0 {
+			stream, err := entry.provider.GenerateStream(ctx, prompt)
+			if err == nil {
+				trace.served(entry.name)
+				return stream, nil
+			}
+			lastErr = err
+		} else {
+			attemptCtx, cancel := context.WithTimeout(ctx, entry.timeout)
+			stream, err := entry.provider.GenerateStream(attemptCtx, prompt)
+			if err == nil {
+				trace.served(entry.name)
+				return forwardTimedStream(ctx, attemptCtx, cancel, stream), nil
+			}
+			cancel()
+			lastErr = err
+		}
+
+		if ctx.Err() != nil || !isFailoverError(lastErr) {
+			return nil, lastErr
+		}
+
+		trace.failed(entry.name)
+		log.Printf("[LLM] GenerateStream failed on provider %s, failing over: %v", entry.name, lastErr)
+	}
+
+	return nil, fmt.Errorf("all providers failed: %w", lastErr)
+}
+
+// forwardTimedStream forwards the chunks of a stream opened with attemptCtx, releasing it once
+// the stream is closed. When attemptCtx expires first, the stream ends with a timeout error.
+func forwardTimedStream(ctx, attemptCtx context.Context, cancel context.CancelFunc, stream <-chan Chunk) <-chan Chunk {
+	out := make(chan Chunk)
+	go func() {
+		defer close(out)
+		defer cancel()
+		for {
+			select {
+			case chunk, ok := <-stream:
+				if !ok || !sendChunk(ctx, out, chunk) || chunk.Done || chunk.Err != nil {
+					return
+				}
+			case <-attemptCtx.Done():
+				if ctx.Err() == nil {
+					sendChunk(ctx, out, Chunk{Err: fmt.Errorf("answer stream timed out: %w", attemptCtx.Err())})
+				}
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// CheckAutomaticApproval implements LLMProvider interface
+func (fp *FallbackProvider) CheckAutomaticApproval(ctx context.Context, answer string, query Query, conditions []string) (string, bool, error) {
+	var reason string
+	var approved bool
+	err := fp.try(ctx, "CheckAutomaticApproval", func(ctx context.Context, provider LLMProvider) error {
+		var err error
+		reason, approved, err = provider.CheckAutomaticApproval(ctx, answer, query, conditions)
+		return err
+	})
+	return reason, approved, err
+}
+
+// GenerateDescription implements LLMProvider interface
+func (fp *FallbackProvider) GenerateDescription(ctx context.Context, text string) (string, error) {
+	var description string
+	err := fp.try(ctx, "GenerateDescription", func(ctx context.Context, provider LLMProvider) error {
+		var err error
+		description, err = provider.GenerateDescription(ctx, text)
+		return err
+	})
+	return description, err
+}
+
+// isFailoverError reports whether an error is a timeout or rate limit that justifies
+// trying the next provider
+func isFailoverError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var statusErr *APIStatusError
+	if errors.As(err, &statusErr) {
+		return isFailoverStatus(statusErr.StatusCode)
+	}
+
+	var openaiErr *openai.APIError
+	if errors.As(err, &openaiErr) {
+		return isFailoverStatus(openaiErr.HTTPStatusCode)
+	}
+
+	var requestErr *openai.RequestError
+	if errors.As(err, &requestErr) {
+		return isFailoverStatus(requestErr.HTTPStatusCode)
+	}
+
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "timeout") || strings.Contains(msg, "too many requests") || strings.Contains(msg, "rate limit")
+}
+
+func isFailoverStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == http.StatusRequestTimeout || code == http.StatusGatewayTimeout
+}
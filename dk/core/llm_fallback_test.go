@@ -0,0 +1,37 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFallbackStreamTimeout(t *testing.T) {
+	fast := &FallbackProvider{entries: []fallbackEntry{
+		{name: "fast", provider: &stubProvider{answer: "The answer is forty two."}, timeout: time.Second},
+	}}
+	stream, err := fast.GenerateStream(context.Background(), "question")
+	if err != nil {
+		t.Fatalf("GenerateStream failed: %v", err)
+	}
+	if answer, err := CollectStream(context.Background(), stream); err != nil || answer != "The answer is forty two." {
+		t.Errorf("Expected the whole answer within the timeout, got %q, %v", answer, err)
+	}
+
+	// A stream running past the timeout of its provider ends with a timeout error
+	slow := &FallbackProvider{entries: []fallbackEntry{
+		{name: "slow", provider: &stubProvider{firstToken: 200 * time.Millisecond, answer: "Too late."}, timeout: 20 * time.Millisecond},
+	}}
+	stream, err = slow.GenerateStream(context.Background(), "question")
+	if err != nil {
+		t.Fatalf("GenerateStream failed: %v", err)
+	}
+	start := time.Now()
+	if _, err := CollectStream(context.Background(), stream); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the stream to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("Expected the timeout to end the stream, took %v", elapsed)
+	}
+}
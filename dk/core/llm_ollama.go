@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -31,6 +32,7 @@ type OllamaRequest struct {
 // OllamaResponse represents a response from the Ollama API
 type OllamaResponse struct {
 	Response string `json:"response"`
+	Done     bool   `json:"done,omitempty"`
 	Error    string `json:"error,omitempty"`
 }
 
@@ -47,15 +49,11 @@ func NewOllamaProvider(config ModelConfig) (*OllamaProvider, error) {
 // GenerateAnswer implements LLMProvider interface
 func (p *OllamaProvider) GenerateAnswer(ctx context.Context, question string, docs []Document) (string, error) {
 	// Construct the system prompt and user prompt
-	systemPrompt := GenerateAnswerPrompt
+	templates := PromptTemplatesFromContext(ctx)
+	systemPrompt := templates.SystemPrompt()
 
 	// Construct a prompt that includes the question and context from the nDocuments
-	prompt := fmt.Sprintf("<QUESTION>%s<QUESTION>\n", question)
-	prompt += "<CONTEXT>\n"
-	for _, doc := range docs {
-		prompt += fmt.Sprintf("%s", doc.Content)
-	}
-	prompt += "<CONTEXT>\n"
+	prompt := templates.AnswerPrompt(question, docs)
 
 	// userPrompt := fmt.Sprintf("Question: %s\n\nDocuments:\n", question)
 	// for i, doc := range docs {
@@ -129,7 +127,7 @@ func (p *OllamaProvider) GenerateAnswer(ctx context.Context, question string, do
 
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API error: %s", string(body))
+		return "", &APIStatusError{StatusCode: resp.StatusCode, Message: string(body)}
 	}
 
 	// Parse the response - Ollama streams the response, so we might need to handle it differently
@@ -151,6 +149,148 @@ func (p *OllamaProvider) GenerateAnswer(ctx context.Context, question string, do
 	return sb.String(), nil
 }
 
+// GenerateStream implements LLMProvider interface
+func (p *OllamaProvider) GenerateStream(ctx context.Context, prompt string) (<-chan Chunk, error) {
+	// Default to llama3 if not specified
+	model := p.config.Model
+	if model == "" {
+		model = "llama3"
+	}
+
+	// Create the request
+	baseURL := "http://localhost:11434/api/generate"
+	if p.config.BaseURL != "" {
+		baseURL = p.config.BaseURL
+	}
+
+	req := OllamaRequest{
+		Model:  model,
+		Prompt: prompt,
+		System: PromptTemplatesFromContext(ctx).SystemPrompt(),
+	}
+
+	// Apply custom parameters if provided
+	if p.config.Parameters != nil {
+		if temp, ok := p.config.Parameters["temperature"].(float64); ok {
+			req.Temperature = temp
+		}
+		if maxTokens, ok := p.config.Parameters["max_tokens"].(float64); ok {
+			req.MaxTokens = int(maxTokens)
+		}
+	}
+
+	// Convert request to JSON
+	reqBody, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Create HTTP request
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", baseURL, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add headers
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	// Add custom headers if provided
+	if p.config.Headers != nil {
+		for key, value := range p.config.Headers {
+			httpReq.Header.Set(key, value)
+		}
+	}
+
+	// Send request
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &APIStatusError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+
+	ch := make(chan Chunk)
+	go func() {
+		defer close(ch)
+		defer resp.Body.Close()
+
+		// Ollama streams one JSON object per line
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(line) == 0 {
+				continue
+			}
+
+			var ollamaResp OllamaResponse
+			if err := json.Unmarshal(line, &ollamaResp); err != nil {
+				continue // Skip lines that can't be parsed
+			}
+			if ollamaResp.Error != "" {
+				sendChunk(ctx, ch, Chunk{Err: fmt.Errorf("API error: %s", ollamaResp.Error)})
+				return
+			}
+			if ollamaResp.Response != "" && !sendChunk(ctx, ch, Chunk{Content: ollamaResp.Response}) {
+				return
+			}
+			if ollamaResp.Done {
+				sendChunk(ctx, ch, Chunk{Done: true})
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			sendChunk(ctx, ch, Chunk{Err: fmt.Errorf("failed to read answer stream: %w", err)})
+			return
+		}
+		sendChunk(ctx, ch, Chunk{Done: true})
+	}()
+
+	return ch, nil
+}
+
+// Warm implements ProviderWarmer. A generate request without a prompt makes Ollama load the
+// model into memory, so the first answer does not wait for it.
+func (p *OllamaProvider) Warm(ctx context.Context) error {
+	model := p.config.Model
+	if model == "" {
+		model = "llama3"
+	}
+
+	baseURL := "http://localhost:11434/api/generate"
+	if p.config.BaseURL != "" {
+		baseURL = p.config.BaseURL
+	}
+
+	reqBody, err := json.Marshal(OllamaRequest{Model: model})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", baseURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return &APIStatusError{StatusCode: resp.StatusCode, Message: resp.Status}
+	}
+	return nil
+}
+
 // CheckAutomaticApproval implements LLMProvider interface
 func (p *OllamaProvider) CheckAutomaticApproval(ctx context.Context, answer string, query Query, conditions []string) (string, bool, error) {
 	// Format the list as a pretty JSON string
@@ -230,7 +370,7 @@ func (p *OllamaProvider) CheckAutomaticApproval(ctx context.Context, answer stri
 
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
-		return "API error", false, fmt.Errorf("API error: %s", string(body))
+		return "API error", false, &APIStatusError{StatusCode: resp.StatusCode, Message: string(body)}
 	}
 
 	// Parse the response - Ollama streams the response, so we need to collect it all
@@ -348,7 +488,7 @@ func (p *OllamaProvider) GenerateDescription(ctx context.Context, text string) (
 
 	// Check for errors
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API error")
+		return "", &APIStatusError{StatusCode: resp.StatusCode, Message: string(body)}
 	}
 
 	// Parse the response - Ollama streams the response, so we need to collect it all
@@ -3,8 +3,10 @@ package core
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	openai "github.com/sashabaranov/go-openai"
+	"io"
 	"os"
 )
 
@@ -40,12 +42,8 @@ func NewOpenAIProvider(config ModelConfig) (*OpenAIProvider, error) {
 func (p *OpenAIProvider) GenerateAnswer(ctx context.Context, question string, docs []Document) (string, error) {
 	// Construct a prompt that includes the question and context from the documents.
 	// prompt := "Question:" + question // fmt.Sprintf("You are an AI assistant that answers questions based on the context provided in the documents.\n\nQuestion: %s\n\nDocuments:\n", question)
-	prompt := fmt.Sprintf("<QUESTION>%s<QUESTION>\n", question)
-	prompt += "<CONTEXT>\n"
-	for _, doc := range docs {
-		prompt += fmt.Sprintf("%s", doc.Content)
-	}
-	prompt += "<CONTEXT>\n"
+	templates := PromptTemplatesFromContext(ctx)
+	prompt := templates.AnswerPrompt(question, docs)
 
 	// Default to GPT-3.5 if not specified
 	model := p.config.Model
@@ -57,7 +55,7 @@ func (p *OpenAIProvider) GenerateAnswer(ctx context.Context, question string, do
 	chatReq := openai.ChatCompletionRequest{
 		Model: model,
 		Messages: []openai.ChatCompletionMessage{
-			{Role: "system", Content: GenerateAnswerPrompt},
+			{Role: "system", Content: templates.SystemPrompt()},
 			{Role: "user", Content: prompt},
 		},
 	}
@@ -83,6 +81,70 @@ func (p *OpenAIProvider) GenerateAnswer(ctx context.Context, question string, do
 	return answer, nil
 }
 
+// GenerateStream implements LLMProvider interface
+func (p *OpenAIProvider) GenerateStream(ctx context.Context, prompt string) (<-chan Chunk, error) {
+	// Default to GPT-3.5 if not specified
+	model := p.config.Model
+	if model == "" {
+		model = openai.GPT3Dot5Turbo
+	}
+
+	chatReq := openai.ChatCompletionRequest{
+		Model: model,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: "system", Content: PromptTemplatesFromContext(ctx).SystemPrompt()},
+			{Role: "user", Content: prompt},
+		},
+		Stream: true,
+	}
+
+	// Apply custom parameters if provided
+	if p.config.Parameters != nil {
+		if temp, ok := p.config.Parameters["temperature"].(float64); ok {
+			chatReq.Temperature = float32(temp)
+		}
+		if maxTokens, ok := p.config.Parameters["max_tokens"].(float64); ok {
+			chatReq.MaxTokens = int(maxTokens)
+		}
+	}
+
+	stream, err := p.client.CreateChatCompletionStream(ctx, chatReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start answer stream: %w", err)
+	}
+
+	ch := make(chan Chunk)
+	go func() {
+		defer close(ch)
+		defer stream.Close()
+		for {
+			resp, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				sendChunk(ctx, ch, Chunk{Done: true})
+				return
+			}
+			if err != nil {
+				sendChunk(ctx, ch, Chunk{Err: fmt.Errorf("failed to read answer stream: %w", err)})
+				return
+			}
+			if len(resp.Choices) == 0 || resp.Choices[0].Delta.Content == "" {
+				continue
+			}
+			if !sendChunk(ctx, ch, Chunk{Content: resp.Choices[0].Delta.Content}) {
+				return
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// Warm implements ProviderWarmer by listing the models, which opens a connection to the API
+func (p *OpenAIProvider) Warm(ctx context.Context) error {
+	_, err := p.client.ListModels(ctx)
+	return err
+}
+
 // CheckAutomaticApproval implements LLMProvider interface
 func (p *OpenAIProvider) CheckAutomaticApproval(ctx context.Context, answer string, query Query, conditions []string) (string, bool, error) {
 	// Format the list as a pretty JSON string.
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"context"
+	"dk/utils"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Metadata stored with every document at ingest time
+const (
+	sourceKey    = "source"     // Where the document came from, e.g. "mcp", "http" or "rag_sources"
+	ownerKey     = "owner"      // User that added the document
+	tagsKey      = "tags"       // Comma-separated tags
+	indexedAtKey = "indexed_at" // RFC 3339 time the document was first added
+	tagKeyPrefix = "tag:"       // One "tag:<name>" = "true" entry per tag, so tags can be matched exactly
+)
+
+// MetadataFilter restricts retrieval to documents with matching metadata. All set conditions
+// must hold.
+type MetadataFilter struct {
+	Equals map[string]string `json:"equals,omitempty"` // Exact metadata values
+	Tags   []string          `json:"tags,omitempty"`   // Tags every document must have
+	Source string            `json:"source,omitempty"`
+	Owner  string            `json:"owner,omitempty"`
+	After  *time.Time        `json:"after,omitempty"`  // Added at or after
+	Before *time.Time        `json:"before,omitempty"` // Added before
+}
+
+// IsZero reports whether the filter has no conditions
+func (f MetadataFilter) IsZero() bool {
+	return len(f.Equals) == 0 && len(f.Tags) == 0 && f.Source == "" && f.Owner == "" && f.After == nil && f.Before == nil
+}
+
+// where returns the exact-match conditions of the filter, which the vector store and the
+// keyword index evaluate themselves
+func (f MetadataFilter) where() map[string]string {
+	where := make(map[string]string, len(f.Equals)+len(f.Tags)+2)
+	for key, value := range f.Equals {
+		where[key] = value
+	}
+	for _, tag := range normalizeTags(f.Tags) {
+		where[tagKeyPrefix+tag] = "true"
+	}
+	if f.Source != "" {
+		where[sourceKey] = f.Source
+	}
+	if f.Owner != "" {
+		where[ownerKey] = f.Owner
+	}
+	return where
+}
+
+// hasDateRange reports whether the filter needs the time a document was added
+func (f MetadataFilter) hasDateRange() bool {
+	return f.After != nil || f.Before != nil
+}
+
+// Matches reports whether a document's metadata satisfies the filter
+func (f MetadataFilter) Matches(metadata map[string]string) bool {
+	return matchesFilter(metadata, f.where()) && f.matchesDate(metadata)
+}
+
+// matchesDate reports whether a document was added within the date range of the filter
+func (f MetadataFilter) matchesDate(metadata map[string]string) bool {
+	if !f.hasDateRange() {
+		return true
+	}
+	indexedAt, err := time.Parse(time.RFC3339, metadata[indexedAtKey])
+	if err != nil {
+		// Documents added before indexed_at was recorded have no known date
+		return false
+	}
+	if f.After != nil && indexedAt.Before(*f.After) {
+		return false
+	}
+	if f.Before != nil && !indexedAt.Before(*f.Before) {
+		return false
+	}
+	return true
+}
+
+// mergeWhere adds the exact-match conditions of the filter to where. It reports false when a
+// condition contradicts one already in where, in which case no document can match.
+func (f MetadataFilter) mergeWhere(where map[string]string) bool {
+	for key, value := range f.where() {
+		if existing, ok := where[key]; ok && existing != value {
+			return false
+		}
+		where[key] = value
+	}
+	return true
+}
+
+// normalizeTags lowercases, trims, deduplicates and sorts tags
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// ParseTags splits a comma-separated tag list
+func ParseTags(tags string) []string {
+	return normalizeTags(strings.Split(tags, ","))
+}
+
+// applyIngestMetadata fills in the owner and time a document was added and expands its tags
+// into exactly matchable keys
+func applyIngestMetadata(ctx context.Context, metadata map[string]string) {
+	if metadata[ownerKey] == "" {
+		if dkClient, err := utils.DkFromContext(ctx); err == nil && dkClient.UserID != "" {
+			metadata[ownerKey] = dkClient.UserID
+		}
+	}
+	if metadata[indexedAtKey] == "" {
+		metadata[indexedAtKey] = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	for key := range metadata {
+		if strings.HasPrefix(key, tagKeyPrefix) {
+			delete(metadata, key)
+		}
+	}
+	tags := ParseTags(metadata[tagsKey])
+	if len(tags) == 0 {
+		delete(metadata, tagsKey)
+		return
+	}
+	metadata[tagsKey] = strings.Join(tags, ",")
+	for _, tag := range tags {
+		metadata[tagKeyPrefix+tag] = "true"
+	}
+}
+
+// PeerFilters restrict the documents used to answer each peer. The filter under "*" applies
+// to peers without their own entry; peers without any filter may use all documents.
+type PeerFilters map[string]MetadataFilter
+
+// defaultPeerFilter is the PeerFilters key of the filter for all other peers
+const defaultPeerFilter = "*"
+
+// For returns the filter for answers to a peer
+func (p PeerFilters) For(peer string) (MetadataFilter, bool) {
+	if filter, ok := p[peer]; ok {
+		return filter, true
+	}
+	filter, ok := p[defaultPeerFilter]
+	return filter, ok
+}
+
+// Validate checks that no filter has an empty date range
+func (p PeerFilters) Validate() error {
+	for peer, filter := range p {
+		if filter.After != nil && filter.Before != nil && !filter.After.Before(*filter.Before) {
+			return fmt.Errorf("peer filter %q: 'after' must be before 'before'", peer)
+		}
+	}
+	return nil
+}
+
+type metadataFilterKey struct{}
+
+type peerFiltersKey struct{}
+
+// WithMetadataFilter restricts every retrieval made with the context to matching documents
+func WithMetadataFilter(ctx context.Context, filter MetadataFilter) context.Context {
+	return context.WithValue(ctx, metadataFilterKey{}, filter)
+}
+
+// MetadataFilterFromContext returns the retrieval filter of the context, which is empty when none was set
+func MetadataFilterFromContext(ctx context.Context) MetadataFilter {
+	filter, _ := ctx.Value(metadataFilterKey{}).(MetadataFilter)
+	return filter
+}
+
+// WithPeerFilters adds the per-peer retrieval filters to the context
+func WithPeerFilters(ctx context.Context, filters PeerFilters) context.Context {
+	return context.WithValue(ctx, peerFiltersKey{}, filters)
+}
+
+// PeerFiltersFromContext returns the per-peer retrieval filters, or nil when there are none
+func PeerFiltersFromContext(ctx context.Context) PeerFilters {
+	filters, _ := ctx.Value(peerFiltersKey{}).(PeerFilters)
+	return filters
+}
@@ -0,0 +1,113 @@
+package core
+
+import (
+	"context"
+	"dk/utils"
+	"testing"
+	"time"
+)
+
+func TestApplyIngestMetadata(t *testing.T) {
+	metadata := map[string]string{"file": "a.txt", "tags": " Finance, public,finance ", "tag:stale": "true"}
+	applyIngestMetadata(context.Background(), metadata)
+
+	if metadata["tags"] != "finance,public" {
+		t.Errorf("Expected normalized tags, got %q", metadata["tags"])
+	}
+	if metadata["tag:finance"] != "true" || metadata["tag:public"] != "true" {
+		t.Errorf("Expected a key per tag, got %v", metadata)
+	}
+	if _, ok := metadata["tag:stale"]; ok {
+		t.Error("Expected tag keys without a matching tag to be removed")
+	}
+	if _, err := time.Parse(time.RFC3339, metadata["indexed_at"]); err != nil {
+		t.Errorf("Expected indexed_at to be set, got %q", metadata["indexed_at"])
+	}
+}
+
+func TestMetadataFilterMatches(t *testing.T) {
+	jan := time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)
+	feb := time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)
+	metadata := map[string]string{"owner": "alice", "source": "mcp", "tag:public": "true", "indexed_at": jan.Format(time.RFC3339)}
+
+	cases := []struct {
+		filter MetadataFilter
+		want   bool
+	}{
+		{MetadataFilter{}, true},
+		{MetadataFilter{Tags: []string{"Public"}, Owner: "alice"}, true},
+		{MetadataFilter{Tags: []string{"public", "internal"}}, false},
+		{MetadataFilter{Source: "http"}, false},
+		{MetadataFilter{Before: &feb}, true},
+		{MetadataFilter{After: &feb}, false},
+		{MetadataFilter{After: &jan, Before: &feb}, true},
+	}
+	for i, tc := range cases {
+		if got := tc.filter.Matches(metadata); got != tc.want {
+			t.Errorf("Case %d: expected %v, got %v", i, tc.want, got)
+		}
+	}
+
+	if (MetadataFilter{After: &jan}).Matches(map[string]string{}) {
+		t.Error("Expected documents without a known date to fail a date range")
+	}
+}
+
+func TestPeerFiltersFor(t *testing.T) {
+	filters := PeerFilters{
+		"alice": {Tags: []string{"internal"}},
+		"*":     {Tags: []string{"public"}},
+	}
+	if filter, ok := filters.For("alice"); !ok || filter.Tags[0] != "internal" {
+		t.Errorf("Expected alice's own filter, got %+v", filter)
+	}
+	if filter, ok := filters.For("bob"); !ok || filter.Tags[0] != "public" {
+		t.Errorf("Expected the default filter, got %+v", filter)
+	}
+	if _, ok := (PeerFilters{"alice": {}}).For("bob"); ok {
+		t.Error("Expected no filter for a peer without an entry")
+	}
+}
+
+func TestRetrieveDocumentsAppliesContextFilter(t *testing.T) {
+	c := newTestCollections(t, CollectionsConfig{})
+	ctx, err := UseCollection(WithCollections(context.Background(), c), DefaultCollection, true)
+	if err != nil {
+		t.Fatalf("UseCollection failed: %v", err)
+	}
+	col, _ := utils.ChromemCollectionFromContext(ctx)
+
+	for file, tags := range map[string]string{"public.txt": "public", "internal.txt": "internal"} {
+		metadata := map[string]string{"file": file, "active": "true", "tags": tags}
+		applyIngestMetadata(ctx, metadata)
+		for _, doc := range chunkedDocuments("Quarterly revenue report "+tags, metadata, ChunkingConfig{}) {
+			if err := col.AddDocument(ctx, doc); err != nil {
+				t.Fatalf("AddDocument failed: %v", err)
+			}
+			KeywordIndexFromContext(ctx).Add(doc.ID, doc.Content, doc.Metadata)
+		}
+	}
+
+	docs, err := RetrieveDocuments(WithMetadataFilter(ctx, MetadataFilter{Tags: []string{"public"}}), "revenue report", 5, nil)
+	if err != nil {
+		t.Fatalf("RetrieveDocuments failed: %v", err)
+	}
+	if len(docs) != 1 || docs[0].FileName != "public.txt" {
+		t.Fatalf("Expected only the public document, got %+v", docs)
+	}
+	if _, ok := docs[0].Metadata["tag:public"]; ok || docs[0].Metadata["tags"] != "public" {
+		t.Errorf("Expected tags without expanded tag keys, got %v", docs[0].Metadata)
+	}
+
+	// A request filter cannot widen the filter of the context
+	docs, _ = RetrieveDocuments(WithMetadataFilter(ctx, MetadataFilter{Owner: "alice"}), "revenue report", 5, map[string]string{"owner": "bob"})
+	if len(docs) != 0 {
+		t.Errorf("Expected no documents for contradicting filters, got %d", len(docs))
+	}
+
+	future := time.Now().Add(time.Hour)
+	docs, _ = RetrieveDocuments(WithMetadataFilter(ctx, MetadataFilter{After: &future}), "revenue report", 5, nil)
+	if len(docs) != 0 {
+		t.Errorf("Expected no documents added after now, got %d", len(docs))
+	}
+}
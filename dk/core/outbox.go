@@ -0,0 +1,63 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	dk_client "dk/client"
+	"dk/db"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// outboxStore keeps the outgoing messages of the websocket client in the database, so peer
+// queries and answers sent while the connection is down survive a restart
+type outboxStore struct {
+	db *sql.DB
+}
+
+// NewOutboxStore returns an outbox store for the client backed by the outbox table
+func NewOutboxStore(database *sql.DB) dk_client.OutboxStore {
+	return outboxStore{db: database}
+}
+
+func (s outboxStore) Add(msg dk_client.Message, queuedAt time.Time) error {
+	blob, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return db.InsertOutboxMessage(context.Background(), s.db, db.OutboxMessage{
+		Recipient: msg.To,
+		Message:   string(blob),
+		QueuedAt:  queuedAt,
+	})
+}
+
+func (s outboxStore) Pending(limit int) ([]dk_client.OutboxEntry, error) {
+	messages, err := db.ListOutboxMessages(context.Background(), s.db, limit)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]dk_client.OutboxEntry, 0, len(messages))
+	for _, m := range messages {
+		entry := dk_client.OutboxEntry{ID: m.ID, QueuedAt: m.QueuedAt}
+		if err := json.Unmarshal([]byte(m.Message), &entry.Message); err != nil {
+			// An unreadable message would block the outbox
+			log.Printf("Dropping unreadable outbox message %d to %s: %v", m.ID, m.Recipient, err)
+			if err := db.DeleteOutboxMessage(context.Background(), s.db, m.ID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s outboxStore) Remove(id int64) error {
+	return db.DeleteOutboxMessage(context.Background(), s.db, id)
+}
+
+func (s outboxStore) Len() (int, error) {
+	return db.CountOutboxMessages(context.Background(), s.db)
+}
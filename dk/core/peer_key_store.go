@@ -0,0 +1,42 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	dk_client "dk/client"
+	"dk/db"
+)
+
+// peerKeyStore keeps the public key trusted for each peer in the database
+type peerKeyStore struct {
+	db *sql.DB
+}
+
+// NewPeerKeyStore returns a peer key store for the client backed by the peer_keys table
+func NewPeerKeyStore(database *sql.DB) dk_client.PeerKeyStore {
+	return peerKeyStore{db: database}
+}
+
+func (s peerKeyStore) SavePeerKey(key dk_client.PeerKey) error {
+	return db.SavePeerKey(context.Background(), s.db, db.PeerKey{
+		Peer:      key.UserID,
+		PublicKey: key.PublicKey,
+		Pinned:    key.Pinned,
+		FirstSeen: key.FirstSeen,
+		FetchedAt: key.FetchedAt,
+	})
+}
+
+func (s peerKeyStore) PeerKey(userID string) (dk_client.PeerKey, bool, error) {
+	key, found, err := db.GetPeerKey(context.Background(), s.db, userID)
+	if err != nil || !found {
+		return dk_client.PeerKey{}, found, err
+	}
+	return dk_client.PeerKey{
+		UserID:    key.Peer,
+		PublicKey: key.PublicKey,
+		Pinned:    key.Pinned,
+		FirstSeen: key.FirstSeen,
+		FetchedAt: key.FetchedAt,
+	}, true, nil
+}
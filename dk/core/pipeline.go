@@ -0,0 +1,330 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// faqReuseThreshold is the similarity above which a previously accepted answer is reused
+// instead of drafting a new one
+const faqReuseThreshold = 0.85
+
+// pipelineRetrievalResults is the number of documents retrieved as context for an answer
+const pipelineRetrievalResults = 3
+
+// FAQMatch is a previously accepted answer to a question similar to the one being asked
+type FAQMatch struct {
+	QueryID  string
+	Question string
+	Answer   string
+	Score    float64
+}
+
+// PipelineResult describes how an answer was produced by the AnswerPipeline
+type PipelineResult struct {
+	Docs   []Document   // Context documents sent to the LLM
+	FAQ    *FAQMatch    // Set when a previously accepted answer was reused
+	Cached bool         // Set when the completion was served from the LLM cache
+	Usage  TokenUsage   // Prompt tokens; completion tokens once the stream has been collected
+	Stream <-chan Chunk // Answer stream
+}
+
+// CalledProvider reports whether the answer was drafted by the LLM provider
+func (r *PipelineResult) CalledProvider() bool {
+	return r.FAQ == nil && !r.Cached
+}
+
+// AnswerPipeline produces answers with as little latency as possible: document retrieval,
+// FAQ matching and prompt preparation run concurrently, and the draft is streamed from the
+// provider as it is generated.
+type AnswerPipeline struct {
+	Provider  LLMProvider
+	Retrieve  func(ctx context.Context, question string) ([]Document, error)
+	MatchFAQ  func(ctx context.Context, question string) (*FAQMatch, error)
+	Counter   TokenCounter
+	Budget    *TokenBudget
+	Window    int // Prompt tokens the model accepts; retrieved context is packed to fit, 0 is unlimited
+	Templates *PromptTemplates
+	Cache     *LLMCache
+	Reranker  Reranker // Reorders retrieved documents before the best are kept as context
+	// Summaries returns the stored summaries of the files of docs; a summary replaces the
+	// retrieved chunks of its file when it is shorter
+	Summaries func(ctx context.Context, docs []Document) map[string]Document
+}
+
+// NewAnswerPipeline creates a pipeline that retrieves from the collection of the context and
+// matches against the accepted queries of its database
+func NewAnswerPipeline(ctx context.Context, llmProvider LLMProvider) *AnswerPipeline {
+	// With a reranker, more documents are retrieved so that it has candidates to choose from
+	reranker := RerankerFromContext(ctx)
+	numResults := pipelineRetrievalResults
+	if reranker != nil && reranker.Candidates() > numResults {
+		numResults = reranker.Candidates()
+	}
+
+	var summaries func(ctx context.Context, docs []Document) map[string]Document
+	if SummarizationFromContext(ctx) != nil {
+		summaries = storedSummaries
+	}
+
+	return &AnswerPipeline{
+		Provider: llmProvider,
+		Retrieve: func(ctx context.Context, question string) ([]Document, error) {
+			return RetrieveDocuments(ctx, question, numResults, make(map[string]string))
+		},
+		MatchFAQ:  MatchAcceptedQuery,
+		Counter:   DefaultTokenCounter,
+		Budget:    TokenBudgetFromContext(ctx),
+		Window:    PromptWindowFromContext(ctx),
+		Templates: PromptTemplatesFromContext(ctx),
+		Cache:     LLMCacheFromContext(ctx),
+		Reranker:  reranker,
+		Summaries: summaries,
+	}
+}
+
+// Stream starts producing the answer to question and returns as soon as the answer stream is open
+func (p *AnswerPipeline) Stream(ctx context.Context, question string) (*PipelineResult, error) {
+	start := time.Now()
+
+	retrieveCtx, cancelRetrieve := context.WithCancel(ctx)
+	defer cancelRetrieve()
+
+	type retrieval struct {
+		docs []Document
+		err  error
+	}
+	retrieved := make(chan retrieval, 1)
+	go func() {
+		docs, err := p.Retrieve(retrieveCtx, question)
+		if err == nil && p.Reranker != nil {
+			docs = rerankDocuments(retrieveCtx, p.Reranker, question, docs, pipelineRetrievalResults)
+		}
+		if err == nil && p.Summaries != nil {
+			docs = preferSummaries(p.Counter, docs, p.Summaries(retrieveCtx, docs))
+		}
+		retrieved <- retrieval{docs, err}
+	}()
+
+	faqMatched := make(chan *FAQMatch, 1)
+	go func() {
+		if p.MatchFAQ == nil {
+			faqMatched <- nil
+			return
+		}
+		match, err := p.MatchFAQ(ctx, question)
+		if err != nil {
+			log.Printf("[LLM] FAQ matching failed: %v", err)
+		}
+		faqMatched <- match
+	}()
+
+	// The fixed part of the prompt is counted while retrieval runs
+	templates := p.Templates
+	if templates == nil {
+		templates = DefaultPromptTemplates
+	}
+	baseTokens := make(chan int, 1)
+	go func() {
+		baseTokens <- basePromptTokens(p.Counter, templates, question)
+	}()
+
+	// FAQ matching is a local lookup and normally finishes first; a close match makes
+	// retrieval and drafting unnecessary
+	if match := <-faqMatched; match != nil && match.Score >= faqReuseThreshold {
+		log.Printf("[LLM] Reusing accepted answer %s (similarity %.2f)", match.QueryID, match.Score)
+		ch := make(chan Chunk, 1)
+		ch <- Chunk{Content: match.Answer, Done: true}
+		close(ch)
+		return &PipelineResult{FAQ: match, Stream: ch}, nil
+	}
+
+	var r retrieval
+	select {
+	case r = <-retrieved:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	docs, promptTokens, err := enforceTokenBudget(p.Counter, p.Budget, p.Window, r.docs, <-baseTokens)
+	result := &PipelineResult{Docs: docs, Usage: TokenUsage{PromptTokens: promptTokens}}
+	if err != nil {
+		return result, err
+	}
+
+	// Identical prompts within the cache TTL are answered without calling the provider
+	var cacheKey, providerName string
+	if p.Cache != nil {
+		providerName = cacheProviderName(p.Provider)
+		cacheKey = LLMCacheKey(providerName, templates.SystemPrompt(), templates.AnswerPrompt(question, docs))
+		if answer, ok := p.Cache.Lookup(ctx, cacheKey); ok {
+			log.Printf("[LLM] Serving cached completion after %v", time.Since(start))
+			ch := make(chan Chunk, 1)
+			ch <- Chunk{Content: answer, Done: true}
+			close(ch)
+			return &PipelineResult{Docs: docs, Cached: true, Stream: ch}, nil
+		}
+	}
+
+	if p.Templates != nil {
+		ctx = WithPromptTemplates(ctx, p.Templates)
+	}
+	stream, err := StreamAnswer(ctx, p.Provider, question, docs)
+	if err != nil {
+		log.Printf("[LLM] Streaming unavailable, falling back to blocking completion: %v", err)
+		answer, err := p.Provider.GenerateAnswer(ctx, question, docs)
+		if err != nil {
+			return result, err
+		}
+		ch := make(chan Chunk, 1)
+		ch <- Chunk{Content: answer, Done: true}
+		close(ch)
+		stream = ch
+	}
+	if cacheKey != "" {
+		stream = p.Cache.Tee(ctx, cacheKey, providerName, stream)
+	}
+
+	result.Stream = stream
+	log.Printf("[LLM] Answer stream opened after %v", time.Since(start))
+	return result, nil
+}
+
+// Answer produces the complete answer to question
+func (p *AnswerPipeline) Answer(ctx context.Context, question string) (string, *PipelineResult, error) {
+	result, err := p.Stream(ctx, question)
+	if err != nil {
+		if result == nil {
+			result = &PipelineResult{}
+		}
+		return "", result, err
+	}
+
+	answer, err := CollectStream(ctx, result.Stream)
+	if result.CalledProvider() {
+		result.Usage.CompletionTokens = p.Counter.CountTokens(answer)
+	}
+	return answer, result, err
+}
+
+// MatchAcceptedQuery finds the accepted query whose question is most similar to question
+func MatchAcceptedQuery(ctx context.Context, question string) (*FAQMatch, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, nil
+	}
+
+	accepted, err := db.ListQueries(ctx, database, "accepted", "")
+	if err != nil {
+		return nil, err
+	}
+
+	words := questionWords(question)
+	var best *FAQMatch
+	for _, q := range accepted {
+		if strings.TrimSpace(q.Answer) == "" {
+			continue
+		}
+		score := wordSimilarity(words, questionWords(q.Question))
+		if best == nil || score > best.Score {
+			best = &FAQMatch{QueryID: q.ID, Question: q.Question, Answer: q.Answer, Score: score}
+		}
+	}
+	return best, nil
+}
+
+// questionWords returns the set of lower-cased words of a question
+func questionWords(text string) map[string]bool {
+	words := make(map[string]bool)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		words[w] = true
+	}
+	return words
+}
+
+// wordSimilarity returns the Jaccard similarity of two word sets
+func wordSimilarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for w := range a {
+		if b[w] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
+
+// ProviderWarmer is implemented by providers that can open their connections ahead of the
+// first request
+type ProviderWarmer interface {
+	Warm(ctx context.Context) error
+}
+
+// WarmProvider opens the connections of a provider so the first answer does not pay for
+// DNS, TCP and TLS setup or model loading
+func WarmProvider(ctx context.Context, llmProvider LLMProvider) {
+	warmer, ok := llmProvider.(ProviderWarmer)
+	if !ok {
+		return
+	}
+
+	start := time.Now()
+	if err := warmer.Warm(ctx); err != nil {
+		log.Printf("[LLM] Provider warm-up failed: %v", err)
+		return
+	}
+	log.Printf("[LLM] Provider warmed up in %v", time.Since(start))
+}
+
+// StartProviderWarmer warms the provider immediately and then periodically, keeping idle
+// connections from being closed between queries
+func StartProviderWarmer(ctx context.Context, llmProvider LLMProvider, interval time.Duration) {
+	go func() {
+		WarmProvider(ctx, llmProvider)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				WarmProvider(ctx, llmProvider)
+			}
+		}
+	}()
+}
+
+// Warm implements ProviderWarmer by warming every provider of the chain concurrently
+func (fp *FallbackProvider) Warm(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for _, entry := range fp.entries {
+		warmer, ok := entry.provider.(ProviderWarmer)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, warmer ProviderWarmer) {
+			defer wg.Done()
+			if err := warmer.Warm(ctx); err != nil {
+				log.Printf("[LLM] Warm-up of provider %s failed: %v", name, err)
+			}
+		}(entry.name, warmer)
+	}
+	wg.Wait()
+	return nil
+}
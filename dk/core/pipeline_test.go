@@ -0,0 +1,230 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubProvider streams a fixed answer after a delay before the first chunk
+type stubProvider struct {
+	firstToken time.Duration
+	answer     string
+	calls      atomic.Int32
+}
+
+func (p *stubProvider) GenerateAnswer(ctx context.Context, question string, docs []Document) (string, error) {
+	time.Sleep(p.firstToken)
+	return p.answer, nil
+}
+
+func (p *stubProvider) GenerateStream(ctx context.Context, prompt string) (<-chan Chunk, error) {
+	p.calls.Add(1)
+	ch := make(chan Chunk)
+	go func() {
+		defer close(ch)
+		time.Sleep(p.firstToken)
+		for _, word := range strings.SplitAfter(p.answer, " ") {
+			if !sendChunk(ctx, ch, Chunk{Content: word}) {
+				return
+			}
+		}
+		sendChunk(ctx, ch, Chunk{Done: true})
+	}()
+	return ch, nil
+}
+
+func (p *stubProvider) CheckAutomaticApproval(ctx context.Context, answer string, query Query, conditions []string) (string, bool, error) {
+	return "", false, nil
+}
+
+func (p *stubProvider) GenerateDescription(ctx context.Context, text string) (string, error) {
+	return "", nil
+}
+
+func stubPipeline(retrieval, faq, firstToken time.Duration, match *FAQMatch) *AnswerPipeline {
+	docs := []Document{
+		{FileName: "a.txt", Content: strings.Repeat("Retrieval augmented generation context. ", 50)},
+		{FileName: "b.txt", Content: strings.Repeat("Another relevant document about the topic. ", 50)},
+	}
+	return &AnswerPipeline{
+		Provider: &stubProvider{firstToken: firstToken, answer: "The answer is forty two."},
+		Retrieve: func(ctx context.Context, question string) ([]Document, error) {
+			select {
+			case <-time.After(retrieval):
+				return docs, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		},
+		MatchFAQ: func(ctx context.Context, question string) (*FAQMatch, error) {
+			time.Sleep(faq)
+			return match, nil
+		},
+		Counter: DefaultTokenCounter,
+	}
+}
+
+func TestAnswerPipelineRunsStagesConcurrently(t *testing.T) {
+	p := stubPipeline(50*time.Millisecond, 40*time.Millisecond, 0, nil)
+
+	start := time.Now()
+	answer, result, err := p.Answer(context.Background(), "What is the answer?")
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("Answer failed: %v", err)
+	}
+
+	if answer != "The answer is forty two." {
+		t.Errorf("Unexpected answer %q", answer)
+	}
+	if len(result.Docs) != 2 || result.Usage.PromptTokens == 0 || result.Usage.CompletionTokens == 0 {
+		t.Errorf("Unexpected result %+v", result)
+	}
+	// Sequential retrieval and FAQ matching would take at least 90ms
+	if elapsed >= 85*time.Millisecond {
+		t.Errorf("Retrieval and FAQ matching did not overlap: took %v", elapsed)
+	}
+}
+
+func TestAnswerPipelineReusesAcceptedAnswer(t *testing.T) {
+	match := &FAQMatch{QueryID: "qry-1", Answer: "Stored answer", Score: 0.9}
+	p := stubPipeline(time.Second, 0, time.Second, match)
+
+	start := time.Now()
+	answer, result, err := p.Answer(context.Background(), "What is the answer?")
+	if err != nil {
+		t.Fatalf("Answer failed: %v", err)
+	}
+	if answer != "Stored answer" || result.FAQ != match {
+		t.Errorf("Expected the accepted answer to be reused, got %q", answer)
+	}
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("FAQ reuse waited for retrieval or drafting: took %v", elapsed)
+	}
+}
+
+func TestAnswerPipelineCachesCompletions(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := utils.WithDatabase(context.Background(), testDB.DB)
+
+	p := stubPipeline(0, 0, 0, nil)
+	p.Cache = NewLLMCache(LLMCacheConfig{TTLSeconds: 60})
+	provider := p.Provider.(*stubProvider)
+
+	for i := 0; i < 2; i++ {
+		answer, result, err := p.Answer(ctx, "What is the answer?")
+		if err != nil {
+			t.Fatalf("Answer failed: %v", err)
+		}
+		if answer != "The answer is forty two." {
+			t.Errorf("Unexpected answer %q", answer)
+		}
+		if result.Cached != (i == 1) {
+			t.Errorf("Answer %d: expected cached=%v", i, i == 1)
+		}
+	}
+	if calls := provider.calls.Load(); calls != 1 {
+		t.Errorf("Expected the provider to be called once, got %d calls", calls)
+	}
+
+	stats, err := p.Cache.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get cache stats: %v", err)
+	}
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("Unexpected cache stats %+v", stats)
+	}
+}
+
+func TestEnforceTokenBudget(t *testing.T) {
+	docs := []Document{
+		{FileName: "a.txt", Content: strings.Repeat("alpha beta gamma ", 100)},
+		{FileName: "b.txt", Content: strings.Repeat("delta epsilon zeta ", 100)},
+	}
+	_, total, err := EnforceTokenBudget(DefaultTokenCounter, nil, 0, DefaultPromptTemplates, "question", docs)
+	if err != nil {
+		t.Fatalf("Unlimited budget failed: %v", err)
+	}
+
+	budget := &TokenBudget{MaxPromptTokens: total - 50}
+	if _, _, err := EnforceTokenBudget(DefaultTokenCounter, budget, 0, DefaultPromptTemplates, "question", docs); err == nil {
+		t.Error("Expected prompt over budget to be rejected")
+	}
+
+	budget.Strategy = BudgetStrategyTruncate
+	kept, tokens, err := EnforceTokenBudget(DefaultTokenCounter, budget, 0, DefaultPromptTemplates, "question", docs)
+	if err != nil {
+		t.Fatalf("Truncation failed: %v", err)
+	}
+	if tokens > budget.MaxPromptTokens {
+		t.Errorf("Truncated prompt has %d tokens, budget is %d", tokens, budget.MaxPromptTokens)
+	}
+	if len(kept) != 2 || len(kept[1].Content) >= len(docs[1].Content) {
+		t.Errorf("Expected the last document to be shortened")
+	}
+}
+
+func TestWordSimilarity(t *testing.T) {
+	a := questionWords("What is the capital of France?")
+	if score := wordSimilarity(a, questionWords("what is the capital of france")); score != 1 {
+		t.Errorf("Expected identical questions to score 1, got %v", score)
+	}
+	if score := wordSimilarity(a, questionWords("How tall is Mount Everest?")); score >= faqReuseThreshold {
+		t.Errorf("Unrelated questions scored %v", score)
+	}
+}
+
+// BenchmarkAnswerPipelineFirstToken measures the time until the first answer chunk arrives
+// with simulated retrieval, FAQ lookup and provider latencies
+func BenchmarkAnswerPipelineFirstToken(b *testing.B) {
+	p := stubPipeline(2*time.Millisecond, time.Millisecond, time.Millisecond, nil)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := p.Stream(ctx, "What is the answer?")
+		if err != nil {
+			b.Fatal(err)
+		}
+		<-result.Stream
+		b.StopTimer()
+		for range result.Stream {
+		}
+		b.StartTimer()
+	}
+}
+
+// BenchmarkAnswerPipelineFAQ measures answering from a previously accepted answer
+func BenchmarkAnswerPipelineFAQ(b *testing.B) {
+	match := &FAQMatch{QueryID: "qry-1", Answer: "Stored answer", Score: 1}
+	p := stubPipeline(2*time.Millisecond, 0, time.Millisecond, match)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := p.Answer(ctx, "What is the answer?"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCountTokens measures token counting of a typical retrieved document
+func BenchmarkCountTokens(b *testing.B) {
+	text := strings.Repeat("Distributed knowledge networks answer questions from local documents. ", 200)
+	b.SetBytes(int64(len(text)))
+	for i := 0; i < b.N; i++ {
+		DefaultTokenCounter.CountTokens(text)
+	}
+}
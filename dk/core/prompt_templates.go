@@ -0,0 +1,177 @@
+package core
+
+import (
+	"context"
+	"dk/utils"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// PromptConfig customizes the prompts used for answer generation. Each template can be given
+// inline or read from a file; the file takes precedence when both are set.
+type PromptConfig struct {
+	System      string            `json:"system,omitempty"`       // System prompt template
+	SystemFile  string            `json:"system_file,omitempty"`  // Path to the system prompt template
+	Context     string            `json:"context,omitempty"`      // Template formatting the question and RAG documents
+	ContextFile string            `json:"context_file,omitempty"` // Path to the context template
+	AnswerStyle string            `json:"answer_style,omitempty"` // Style instructions available to both templates
+	Variables   map[string]string `json:"variables,omitempty"`    // Deployment specific values available as .Vars
+}
+
+// SystemPromptData is the data available to the system prompt template
+type SystemPromptData struct {
+	DefaultSystemPrompt string
+	AnswerStyle         string
+	Vars                map[string]string
+}
+
+// ContextPromptData is the data available to the context template
+type ContextPromptData struct {
+	Question    string
+	Documents   []Document
+	AnswerStyle string
+	Vars        map[string]string
+}
+
+// defaultSystemTemplate is the built-in system prompt with the answer style appended when one is set
+const defaultSystemTemplate = `{{.DefaultSystemPrompt}}{{if .AnswerStyle}}
+
+### ANSWER STYLE ###
+{{.AnswerStyle}}{{end}}`
+
+// defaultContextTemplate wraps the question and the concatenated documents in the tags the
+// default system prompt refers to
+const defaultContextTemplate = "<QUESTION>{{.Question}}<QUESTION>\n<CONTEXT>\n{{range .Documents}}{{.Content}}{{end}}<CONTEXT>\n"
+
+// PromptTemplates renders the prompts used for answer generation
+type PromptTemplates struct {
+	system      *template.Template
+	context     *template.Template
+	answerStyle string
+	vars        map[string]string
+}
+
+// DefaultPromptTemplates renders the built-in prompts
+var DefaultPromptTemplates = mustPromptTemplates(PromptConfig{})
+
+func mustPromptTemplates(config PromptConfig) *PromptTemplates {
+	templates, err := NewPromptTemplates(config)
+	if err != nil {
+		panic(err)
+	}
+	return templates
+}
+
+// NewPromptTemplates parses the templates of a prompt configuration and verifies that they
+// render. Templates that are not set use the built-in defaults.
+func NewPromptTemplates(config PromptConfig) (*PromptTemplates, error) {
+	systemText, err := templateText(config.System, config.SystemFile, defaultSystemTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read system prompt template: %w", err)
+	}
+	contextText, err := templateText(config.Context, config.ContextFile, defaultContextTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read context template: %w", err)
+	}
+
+	t := &PromptTemplates{answerStyle: config.AnswerStyle, vars: config.Variables}
+	if t.vars == nil {
+		t.vars = map[string]string{}
+	}
+
+	// Missing keys are errors so that typos in .Vars are caught when the templates load
+	if t.system, err = template.New("system").Option("missingkey=error").Parse(systemText); err != nil {
+		return nil, fmt.Errorf("failed to parse system prompt template: %w", err)
+	}
+	if t.context, err = template.New("context").Option("missingkey=error").Parse(contextText); err != nil {
+		return nil, fmt.Errorf("failed to parse context template: %w", err)
+	}
+
+	if _, err := t.renderSystem(); err != nil {
+		return nil, fmt.Errorf("failed to render system prompt template: %w", err)
+	}
+	sample := []Document{{FileName: "sample.txt", Content: "sample", Metadata: map[string]string{}}}
+	if _, err := t.renderContext("sample question", sample); err != nil {
+		return nil, fmt.Errorf("failed to render context template: %w", err)
+	}
+	return t, nil
+}
+
+// templateText returns the template read from path, the inline template, or the default
+func templateText(inline, path, fallback string) (string, error) {
+	if path != "" {
+		expanded, err := utils.ExpandHomePath(path)
+		if err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(expanded)
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	if inline != "" {
+		return inline, nil
+	}
+	return fallback, nil
+}
+
+func (t *PromptTemplates) renderSystem() (string, error) {
+	var sb strings.Builder
+	err := t.system.Execute(&sb, SystemPromptData{
+		DefaultSystemPrompt: GenerateAnswerPrompt,
+		AnswerStyle:         t.answerStyle,
+		Vars:                t.vars,
+	})
+	return sb.String(), err
+}
+
+func (t *PromptTemplates) renderContext(question string, docs []Document) (string, error) {
+	var sb strings.Builder
+	err := t.context.Execute(&sb, ContextPromptData{
+		Question:    question,
+		Documents:   docs,
+		AnswerStyle: t.answerStyle,
+		Vars:        t.vars,
+	})
+	return sb.String(), err
+}
+
+// SystemPrompt returns the system prompt for answer generation. Templates are verified when
+// they load, so rendering errors fall back to the built-in prompt.
+func (t *PromptTemplates) SystemPrompt() string {
+	prompt, err := t.renderSystem()
+	if err != nil {
+		log.Printf("[LLM] Failed to render system prompt template, using default: %v", err)
+		return GenerateAnswerPrompt
+	}
+	return prompt
+}
+
+// AnswerPrompt returns the user prompt for a question and its context documents
+func (t *PromptTemplates) AnswerPrompt(question string, docs []Document) string {
+	prompt, err := t.renderContext(question, docs)
+	if err != nil {
+		log.Printf("[LLM] Failed to render context template, using default: %v", err)
+		return BuildAnswerPrompt(question, docs)
+	}
+	return prompt
+}
+
+type promptTemplatesKey struct{}
+
+// WithPromptTemplates adds prompt templates to the context
+func WithPromptTemplates(ctx context.Context, templates *PromptTemplates) context.Context {
+	return context.WithValue(ctx, promptTemplatesKey{}, templates)
+}
+
+// PromptTemplatesFromContext returns the prompt templates of the context, or the defaults
+func PromptTemplatesFromContext(ctx context.Context) *PromptTemplates {
+	if templates, ok := ctx.Value(promptTemplatesKey{}).(*PromptTemplates); ok && templates != nil {
+		return templates
+	}
+	return DefaultPromptTemplates
+}
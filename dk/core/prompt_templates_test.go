@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultPromptTemplatesMatchBuiltInPrompts(t *testing.T) {
+	docs := []Document{{FileName: "a.txt", Content: "first"}, {FileName: "b.txt", Content: "second"}}
+
+	if got := DefaultPromptTemplates.SystemPrompt(); got != GenerateAnswerPrompt {
+		t.Errorf("Default system prompt differs from GenerateAnswerPrompt")
+	}
+	if got, want := DefaultPromptTemplates.AnswerPrompt("why?", docs), BuildAnswerPrompt("why?", docs); got != want {
+		t.Errorf("Default answer prompt %q, want %q", got, want)
+	}
+}
+
+func TestCustomPromptTemplates(t *testing.T) {
+	dir := t.TempDir()
+	contextFile := filepath.Join(dir, "context.tmpl")
+	contextTemplate := "Q: {{.Question}}\n{{range $i, $d := .Documents}}[{{$i}}] {{$d.FileName}}: {{$d.Content}}\n{{end}}"
+	if err := os.WriteFile(contextFile, []byte(contextTemplate), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	templates, err := NewPromptTemplates(PromptConfig{
+		System:      "You answer for {{.Vars.org}}. {{.AnswerStyle}}",
+		ContextFile: contextFile,
+		AnswerStyle: "Answer in one sentence.",
+		Variables:   map[string]string{"org": "Acme"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+
+	if got := templates.SystemPrompt(); got != "You answer for Acme. Answer in one sentence." {
+		t.Errorf("Unexpected system prompt %q", got)
+	}
+	got := templates.AnswerPrompt("why?", []Document{{FileName: "a.txt", Content: "first"}})
+	if got != "Q: why?\n[0] a.txt: first\n" {
+		t.Errorf("Unexpected answer prompt %q", got)
+	}
+
+	ctx := WithPromptTemplates(context.Background(), templates)
+	if PromptTemplatesFromContext(ctx) != templates {
+		t.Error("Expected templates from context")
+	}
+	if PromptTemplatesFromContext(context.Background()) != DefaultPromptTemplates {
+		t.Error("Expected default templates without templates in context")
+	}
+}
+
+func TestDefaultSystemTemplateAppendsAnswerStyle(t *testing.T) {
+	templates, err := NewPromptTemplates(PromptConfig{AnswerStyle: "Use bullet points."})
+	if err != nil {
+		t.Fatalf("Failed to load templates: %v", err)
+	}
+	got := templates.SystemPrompt()
+	if !strings.HasPrefix(got, GenerateAnswerPrompt) || !strings.HasSuffix(got, "Use bullet points.") {
+		t.Errorf("Answer style not appended to the default system prompt")
+	}
+}
+
+func TestInvalidPromptTemplates(t *testing.T) {
+	cases := map[string]PromptConfig{
+		"parse error":   {System: "{{.AnswerStyle"},
+		"unknown field": {Context: "{{.Missing}}"},
+		"unknown var":   {System: "{{.Vars.missing}}"},
+		"missing file":  {SystemFile: filepath.Join(t.TempDir(), "missing.tmpl")},
+	}
+	for name, config := range cases {
+		if _, err := NewPromptTemplates(config); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
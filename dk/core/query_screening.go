@@ -0,0 +1,129 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// screenQueryPrompt asks the LLM to summarize an incoming query for the host's triage
+const screenQueryPrompt = `A peer sent this question to a knowledge base:
+
+%s
+
+A draft answer was written from %d matching documents:
+
+%s
+
+Summarize the question for someone triaging many of them. Reply in exactly this format and nothing else:
+Summary: <the question in one line of at most 15 words>
+Tags: <1 to %d topic tags, lowercase, comma-separated>
+Effort: <low if the draft answers the question, medium if it needs editing, high if it needs a new answer>`
+
+const (
+	// screeningMaxTags bounds the topic tags of a screened query
+	screeningMaxTags = 4
+	// screeningSummaryLength bounds the summary made without an LLM, in characters
+	screeningSummaryLength = 100
+	// screeningDraftLength bounds the part of the draft answer sent to the LLM, in characters
+	screeningDraftLength = 1500
+)
+
+// screenQuery summarizes an incoming query, with topic tags and the effort its answer needs,
+// so the host can triage queries without reading each one. Without an LLM, or when its reply
+// cannot be used, the summary is made from the question itself.
+func screenQuery(ctx context.Context, llmProvider LLMProvider, question, draft string, matchedDocs int) (db.QueryScreening, bool) {
+	screening := fallbackScreening(question, matchedDocs)
+	if llmProvider == nil {
+		return screening, false
+	}
+
+	prompt := fmt.Sprintf(screenQueryPrompt, question, matchedDocs, truncateRunes(draft, screeningDraftLength), screeningMaxTags)
+	stream, err := llmProvider.GenerateStream(ctx, prompt)
+	var reply string
+	if err == nil {
+		reply, err = CollectStream(ctx, stream)
+	}
+	if err != nil {
+		log.Printf("[Screening] Failed to screen query: %v", err)
+		return screening, true
+	}
+	parsed, ok := parseScreening(reply)
+	if !ok {
+		return screening, true
+	}
+	parsed.MatchedDocuments = matchedDocs
+	if parsed.Effort == "" {
+		parsed.Effort = screening.Effort
+	}
+	if len(parsed.Tags) == 0 {
+		parsed.Tags = screening.Tags
+	}
+	return parsed, true
+}
+
+// parseScreening reads the reply to screenQueryPrompt
+func parseScreening(reply string) (db.QueryScreening, bool) {
+	var s db.QueryScreening
+	for _, line := range strings.Split(reply, "\n") {
+		line = strings.TrimSpace(line)
+		if rest, ok := strings.CutPrefix(line, "Summary:"); ok && s.Summary == "" {
+			s.Summary = strings.TrimSpace(rest)
+		} else if rest, ok := strings.CutPrefix(line, "Tags:"); ok && s.Tags == nil {
+			s.Tags = []string{}
+			for _, tag := range strings.Split(rest, ",") {
+				if tag = strings.ToLower(strings.TrimSpace(tag)); tag != "" && len(s.Tags) < screeningMaxTags {
+					s.Tags = append(s.Tags, tag)
+				}
+			}
+		} else if rest, ok := strings.CutPrefix(line, "Effort:"); ok && s.Effort == "" {
+			switch effort := strings.ToLower(strings.TrimSpace(rest)); effort {
+			case db.EffortLow, db.EffortMedium, db.EffortHigh:
+				s.Effort = effort
+			}
+		}
+	}
+	return s, s.Summary != ""
+}
+
+// fallbackScreening screens a query without an LLM: the summary is the start of the question,
+// the tags are its longest words, and a draft without documents needs the most work
+func fallbackScreening(question string, matchedDocs int) db.QueryScreening {
+	summary := strings.Join(strings.Fields(question), " ")
+	s := db.QueryScreening{
+		Summary:          truncateRunes(summary, screeningSummaryLength),
+		Tags:             []string{},
+		Effort:           db.EffortMedium,
+		MatchedDocuments: matchedDocs,
+	}
+	if matchedDocs == 0 {
+		s.Effort = db.EffortHigh
+	}
+
+	words := make([]string, 0)
+	for word := range questionWords(question) {
+		if len(word) > 3 {
+			words = append(words, word)
+		}
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if len(words[i]) != len(words[j]) {
+			return len(words[i]) > len(words[j])
+		}
+		return words[i] < words[j]
+	})
+	s.Tags = append(s.Tags, words[:min(len(words), screeningMaxTags)]...)
+	return s
+}
+
+// truncateRunes shortens text to at most n runes, marking the cut with an ellipsis
+func truncateRunes(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:n-1])) + "…"
+}
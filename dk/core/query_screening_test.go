@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"strings"
+	"testing"
+)
+
+func TestScreenQuery(t *testing.T) {
+	ctx := context.Background()
+	question := "What was the churn rate of enterprise customers in the second quarter?"
+
+	provider := &stubProvider{answer: "Here you go:\nSummary: Q2 enterprise churn rate\nTags: Churn, Enterprise, Q2, Sales, Extra\nEffort: LOW\n"}
+	s, called := screenQuery(ctx, provider, question, "Churn was 2%.", 3)
+	if !called || s.Summary != "Q2 enterprise churn rate" || s.Effort != db.EffortLow || s.MatchedDocuments != 3 {
+		t.Errorf("Unexpected screening: %+v", s)
+	}
+	if strings.Join(s.Tags, ",") != "churn,enterprise,q2,sales" {
+		t.Errorf("Expected at most %d lowercase tags, got %v", screeningMaxTags, s.Tags)
+	}
+
+	// Replies in another format fall back to the question itself
+	provider = &stubProvider{answer: "I cannot help with that."}
+	s, _ = screenQuery(ctx, provider, question, "", 0)
+	if s.Summary != question || s.Effort != db.EffortHigh || len(s.Tags) == 0 {
+		t.Errorf("Unexpected fallback screening: %+v", s)
+	}
+
+	s, called = screenQuery(ctx, nil, strings.Repeat("long question ", 20), "", 1)
+	if called || len([]rune(s.Summary)) > screeningSummaryLength || !strings.HasSuffix(s.Summary, "…") || s.Effort != db.EffortMedium {
+		t.Errorf("Unexpected screening without an LLM: %+v", s)
+	}
+}
+
+func TestQueryScreeningListed(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := context.Background()
+	for _, id := range []string{"qry-old", "qry-new"} {
+		if err := db.InsertQuery(ctx, testDB.DB, db.Query{ID: id, From: "bob", Question: "Budget?", Status: "pending"}); err != nil {
+			t.Fatalf("Failed to insert query: %v", err)
+		}
+	}
+	if err := db.SaveQueryScreening(ctx, testDB.DB, "qry-new", fallbackScreening("What is the marketing budget?", 2)); err != nil {
+		t.Fatalf("SaveQueryScreening failed: %v", err)
+	}
+
+	page, err := db.ListQueriesPage(ctx, testDB.DB, db.QueryFilter{}, 10, 0, "")
+	if err != nil {
+		t.Fatalf("ListQueriesPage failed: %v", err)
+	}
+	screened := map[string]*db.QueryScreening{}
+	for _, q := range page.Queries {
+		screened[q.ID] = q.Screening
+	}
+	if s := screened["qry-new"]; s == nil || s.MatchedDocuments != 2 || len(s.Tags) == 0 {
+		t.Errorf("Expected the screening of qry-new to be listed, got %+v", s)
+	}
+	if screened["qry-old"] != nil {
+		t.Errorf("Expected no screening for qry-old, got %+v", screened["qry-old"])
+	}
+}
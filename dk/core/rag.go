@@ -2,6 +2,8 @@ package core
 
 import (
 	"context"
+	"database/sql"
+	"dk/db"
 	"dk/utils"
 	"encoding/json"
 	"errors"
@@ -12,33 +14,24 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
-func SetupChromemCollection(vectorPath string) *chromem.Collection {
-	// Setup chromem-go
-	db, err := chromem.NewPersistentDB(vectorPath, false)
-	if err != nil {
-		panic(err)
-	}
-
-	embeddingModel := "nomic-embed-text"
-
-	// Create collection if it wasn't loaded from persistent storage yet.
-	// You can pass nil as embedding function to use the default (OpenAI text-embedding-3-small),
-	// which is very good and cheap. It would require the OPENAI_API_KEY environment
-	// variable to be set.
-	// For this example we choose to use a locally running embedding model though.
-	// It requires Ollama to serve its API at "http://localhost:11434/api".
-	collection, err := db.GetOrCreateCollection("PersonalKnowledge", nil, chromem.NewEmbeddingFuncOllama(embeddingModel, ""))
-	if err != nil {
-		panic(err)
+// RetrieveDocuments searches the collections the question is routed to, or only the collection
+// of the context when one was selected with UseCollection
+func RetrieveDocuments(ctx context.Context, question string, numResults int, metadataFilter map[string]string) ([]Document, error) {
+	RecordFeature(ctx, TelemetryRAG, "retrieve")
+	if names := routeCollections(ctx, question, metadataFilter); names != nil {
+		return retrieveFromCollections(ctx, names, question, numResults, metadataFilter)
 	}
-	return collection
+	return retrieveFromCollection(ctx, question, numResults, metadataFilter)
 }
 
-func RetrieveDocuments(ctx context.Context, question string, numResults int, metadataFilter map[string]string) ([]Document, error) {
+// retrieveFromCollection searches the collection of the context
+func retrieveFromCollection(ctx context.Context, question string, numResults int, metadataFilter map[string]string) ([]Document, error) {
 	chromemCollection, err := utils.ChromemCollectionFromContext(ctx)
 	if err != nil {
 		log.Printf("[RAG] Failed to get Chromem collection from context: %v", err)
@@ -60,14 +53,33 @@ func RetrieveDocuments(ctx context.Context, question string, numResults int, met
 		filter[key] = value
 	}
 
+	// The filter of the context, such as the documents a peer is entitled to, always applies
+	contextFilter := MetadataFilterFromContext(ctx)
+	if !contextFilter.mergeWhere(filter) {
+		log.Printf("[RAG] Metadata filters contradict each other, returning empty results")
+		return []Document{}, nil
+	}
+
 	// Get the total document count to avoid requesting more than available
 	totalCount := chromemCollection.Count()
 	log.Printf("[RAG] Query request: %s, numResults: %d, filters: %v", query, numResults, filter)
 	log.Printf("[RAG] Total document count: %d", totalCount)
 
-	// Use the smaller of numResults or totalCount to avoid "nResults must be <= number of documents" error
-	queryLimit := numResults
-	if totalCount < numResults {
+	// With a keyword index both retrievers contribute extra candidates to the fusion
+	keywordIndex := KeywordIndexFromContext(ctx)
+	candidates := numResults
+	if keywordIndex != nil {
+		candidates = numResults * hybridCandidateFactor
+	}
+	// Dates and allowed files are checked after the search, so every document is a candidate
+	allowedFiles := allowedFilesFromContext(ctx)
+	if contextFilter.hasDateRange() || allowedFiles != nil {
+		candidates = totalCount
+	}
+
+	// Use the smaller of the candidates or totalCount to avoid "nResults must be <= number of documents" error
+	queryLimit := candidates
+	if totalCount < candidates {
 		queryLimit = totalCount
 	}
 	log.Printf("[RAG] Adjusted query limit: %d", queryLimit)
@@ -94,7 +106,12 @@ func RetrieveDocuments(ctx context.Context, question string, numResults int, met
 	}
 
 	var results []Document = []Document{}
+	var vectorRanking []rankedDocument
 	for _, res := range docRes {
+		if res.Metadata["is_deleted"] == "true" || !contextFilter.matchesDate(res.Metadata) || !fileAllowed(allowedFiles, res.Metadata["file"]) {
+			continue
+		}
+
 		// Cut off the prefix we added before adding the document (see comment above).
 		// This is specific to the "nomic-embed-text" model.
 		contentString := strings.TrimPrefix(res.Content, "search_document: ")
@@ -102,8 +119,8 @@ func RetrieveDocuments(ctx context.Context, question string, numResults int, met
 		// Extract metadata from the document's metadata map
 		metadata := make(map[string]string)
 		for key, value := range res.Metadata {
-			// Skip the "file" field as it's handled separately
-			if key != "file" {
+			// Skip the "file" field as it's handled separately, and the expanded tags
+			if key != "file" && !strings.HasPrefix(key, tagKeyPrefix) {
 				metadata[key] = value
 			}
 		}
@@ -115,6 +132,24 @@ func RetrieveDocuments(ctx context.Context, question string, numResults int, met
 			Score:    res.Similarity,
 		}
 		results = append(results, content)
+		vectorRanking = append(vectorRanking, rankedDocument{ID: res.ID, Document: content})
+	}
+
+	if keywordIndex != nil {
+		var keywordRanking []rankedDocument
+		for _, hit := range keywordIndex.Search(question, candidates, filter) {
+			if hit.Document.Metadata["is_deleted"] == "true" || !contextFilter.matchesDate(hit.Document.Metadata) || !fileAllowed(allowedFiles, hit.Document.FileName) {
+				continue
+			}
+			for key := range hit.Document.Metadata {
+				if strings.HasPrefix(key, tagKeyPrefix) {
+					delete(hit.Document.Metadata, key)
+				}
+			}
+			keywordRanking = append(keywordRanking, rankedDocument{ID: hit.ID, Document: hit.Document})
+		}
+		results = fuseRankings(numResults, vectorRanking, keywordRanking)
+		log.Printf("[RAG] Fused %d vector and %d keyword results", len(vectorRanking), len(keywordRanking))
 	}
 
 	log.Printf("[RAG] Processed %d results", len(results))
@@ -132,6 +167,8 @@ func RemoveDocument(ctx context.Context, filename string) error {
 		log.Printf("[RAG] %v", err)
 		return nil
 	}
+	ctx, unlock := lockCollection(ctx, chromemCollection)
+	defer unlock()
 
 	if strings.TrimSpace(filename) == "" {
 		return errors.New("filename must be non‑empty")
@@ -142,69 +179,176 @@ func RemoveDocument(ctx context.Context, filename string) error {
 	if err := chromemCollection.Delete(ctx, where, nil); err != nil {
 		return fmt.Errorf("delete failed: %w", err)
 	}
+	if idx := KeywordIndexFromContext(ctx); idx != nil {
+		idx.RemoveFile(filename)
+	}
+	notifyChange(DocumentsChanged)
 	return nil
 }
 
+// AddDocument embeds a file into the vector store. PDF, DOCX and HTML content is converted to
+// plain text first.
 func AddDocument(ctx context.Context, fileName string, fileContent string, UpdateDescriptions bool, metadata map[string]string) error {
+	return AddDocuments(ctx, []NewDocument{{FileName: fileName, Content: fileContent, Metadata: metadata}}, UpdateDescriptions)
+}
+
+// NewDocument is a file to add with AddDocuments
+type NewDocument struct {
+	FileName string
+	Content  string
+	Metadata map[string]string
+}
+
+// AddDocuments embeds several files into the vector store in one write, so their chunks are
+// embedded concurrently. Each file is added as AddDocument would; if the write fails, the files
+// it replaced are restored.
+func AddDocuments(ctx context.Context, files []NewDocument, updateDescriptions bool) error {
 	chromemCollection, err := utils.ChromemCollectionFromContext(ctx)
 	if err != nil {
 		log.Printf("[RAG] %v", err)
 		return nil
 	}
-	content := "search_document: " + fileContent
-
-	// Format current time in the required format
-	currentTime := time.Now().Format("Jan 2, 2006, 03:04 PM")
-
-	// Create metadata map with the required "file", "active", and "date" fields
-	docMetadata := map[string]string{
-		"file":   fileName,
-		"active": "true",
-		"date":   currentTime,
+	seen := make(map[string]bool, len(files))
+	for _, file := range files {
+		if seen[file.FileName] {
+			return fmt.Errorf("document %s is added twice", file.FileName)
+		}
+		seen[file.FileName] = true
 	}
 
-	// Add additional metadata if provided
-	for key, value := range metadata {
-		docMetadata[key] = value
+	written, err := writeDocuments(ctx, chromemCollection, files)
+	if err != nil || !updateDescriptions || len(written) == 0 {
+		return err
 	}
 
-	newDoc := chromem.Document{
-		ID:       uuid.NewString(),
-		Metadata: docMetadata,
-		Content:  content,
+	// Descriptions are generated after the write lock is released, since the LLM may be slow
+	dkClient, err := utils.DkFromContext(ctx)
+	if err != nil {
+		panic(err)
 	}
-
-	err = chromemCollection.AddDocument(ctx, newDoc)
+	descriptions, err := utils.GetDescriptions(ctx)
 	if err != nil {
 		return err
 	}
-
-	dkClient, err := utils.DkFromContext(ctx)
+	llmProvider, err := LLMProviderFromContext(ctx)
 	if err != nil {
 		panic(err)
 	}
-
-	if UpdateDescriptions {
-		descriptions, err := utils.GetDescriptions(ctx)
+	for _, text := range written {
+		description, err := llmProvider.GenerateDescription(ctx, text)
 		if err != nil {
-			return err
+			panic(err)
 		}
+		descriptions = append(descriptions, description)
+	}
+
+	dkClient.SetUserDescriptions(descriptions)
+	utils.UpdateDescriptions(ctx, descriptions)
+	notifyChange(DocumentsChanged)
+	return nil
+}
+
+// writeDocuments adds files to a collection under its write lock and returns the text of the
+// files whose content was stored anew
+func writeDocuments(ctx context.Context, chromemCollection *chromem.Collection, files []NewDocument) ([]string, error) {
+	ctx, unlock := lockCollection(ctx, chromemCollection)
+	defer unlock()
+
+	var newDocs []chromem.Document
+	var written []string
+	replaced := make(map[string][]chromem.Result) // Stored chunks of replaced files
+	restore := func() {
+		for fileName, stored := range replaced {
+			if restoreErr := restoreChunks(ctx, chromemCollection, fileName, stored); restoreErr != nil {
+				log.Printf("[RAG] Failed to restore document %s: %v", fileName, restoreErr)
+			}
+		}
+	}
 
-		llmProvider, err := LLMProviderFromContext(ctx)
+	for _, file := range files {
+		fileContent, err := ExtractText(file.FileName, []byte(file.Content))
 		if err != nil {
-			panic(err)
+			restore()
+			return nil, err
+		}
+		// Format current time in the required format
+		currentTime := time.Now().Format("Jan 2, 2006, 03:04 PM")
+
+		// Create metadata map with the required "file", "active", and "date" fields
+		docMetadata := map[string]string{
+			"file":   file.FileName,
+			"active": "true",
+			"date":   currentTime,
+		}
+
+		// Add additional metadata if provided; chunk positions and summaries are assigned below
+		for key, value := range file.Metadata {
+			if !isChunkKey(key) && key != summaryKey {
+				docMetadata[key] = value
+			}
 		}
+		docMetadata[contentHashKey] = contentHash(fileContent)
+		applyIngestMetadata(ctx, docMetadata)
+		RecordFeature(ctx, TelemetryRAG, "add_document")
 
-		description, err := llmProvider.GenerateDescription(ctx, fileContent)
+		// Adding a file again only stores it anew if its text changed
+		stored, err := fileChunks(ctx, chromemCollection, file.FileName)
 		if err != nil {
-			panic(err)
+			restore()
+			return nil, fmt.Errorf("failed to look up document: %w", err)
+		}
+		switch planIngest(stored, docMetadata, contentHashKey) {
+		case ingestSkip:
+			log.Printf("[RAG] Document %s is unchanged, skipping", file.FileName)
+			continue
+		case ingestRetag:
+			log.Printf("[RAG] Document %s is unchanged, updating its metadata", file.FileName)
+			if err := retagChunks(ctx, chromemCollection, file.FileName, stored, docMetadata); err != nil {
+				restore()
+				return nil, err
+			}
+			continue
+		case ingestReplace:
+			if err := RemoveDocument(ctx, file.FileName); err != nil {
+				restore()
+				return nil, err
+			}
+			replaced[file.FileName] = stored
 		}
-		descriptions = append(descriptions, description)
 
-		dkClient.SetUserDescriptions(descriptions)
-		utils.UpdateDescriptions(ctx, descriptions)
+		newDocs = append(newDocs, chunkedDocuments(fileContent, docMetadata, ChunkingFromContext(ctx))...)
+		if summary := summaryDocument(ctx, fileContent, docMetadata); summary != nil {
+			newDocs = append(newDocs, *summary)
+		}
+		written = append(written, fileContent)
 	}
-	return nil
+	if len(newDocs) == 0 {
+		return nil, nil
+	}
+
+	var err error
+	if len(newDocs) == 1 {
+		err = chromemCollection.AddDocument(ctx, newDocs[0])
+	} else {
+		err = chromemCollection.AddDocuments(ctx, newDocs, runtime.NumCPU())
+	}
+	if err != nil {
+		// Chunks chromem added before failing are removed with the files
+		for _, doc := range newDocs {
+			if _, ok := replaced[doc.Metadata["file"]]; !ok {
+				replaced[doc.Metadata["file"]] = nil
+			}
+		}
+		restore()
+		return nil, err
+	}
+	if idx := KeywordIndexFromContext(ctx); idx != nil {
+		for _, doc := range newDocs {
+			idx.Add(doc.ID, strings.TrimPrefix(doc.Content, "search_document: "), doc.Metadata)
+		}
+	}
+	notifyChange(DocumentsChanged)
+	return written, nil
 }
 
 func FeedChromem(ctx context.Context, sourcePath string, update bool) {
@@ -227,8 +371,8 @@ func FeedChromem(ctx context.Context, sourcePath string, update bool) {
 		return
 	}
 
-	// Feed chromem with documents
-	var docs []chromem.Document
+	// Feed chromem with documents, grouped by the collection they go to
+	docs := make(map[string][]chromem.Document)
 	var descriptions []string
 	if chromemCollection.Count() == 0 || update {
 		// Here we use a DBpedia sample, where each line contains the lead section/introduction
@@ -240,10 +384,7 @@ func FeedChromem(ctx context.Context, sourcePath string, update bool) {
 		defer f.Close()
 		d := json.NewDecoder(f)
 		for i := 1; ; i++ {
-			var article struct {
-				Text     string `json:"text"`
-				FileName string `json:"file"`
-			}
+			var article RagSource
 			err := d.Decode(&article)
 			if err == io.EOF {
 				break
@@ -252,6 +393,36 @@ func FeedChromem(ctx context.Context, sourcePath string, update bool) {
 				panic(err)
 			}
 
+			// PDF, DOCX and HTML sources are converted to plain text
+			raw, err := article.read()
+			if err != nil {
+				log.Printf("[RAG] Skipping source %s: %v", article.Path, err)
+				continue
+			}
+			article.Text, err = ExtractText(article.FileName, raw)
+			if err != nil {
+				log.Printf("[RAG] Skipping source %s: %v", article.FileName, err)
+				continue
+			}
+
+			// Sources that did not change since they were last fed are kept as they are, and
+			// changed ones replace what was stored for them instead of adding to it
+			hash := sourceHash(raw, article.Tags, article.Owner)
+			if stored := storedSource(ctx, article.Collection, article.FileName); len(stored) > 0 {
+				if stored[0].Metadata[sourceHashKey] == hash {
+					log.Printf("[RAG] Source %s is unchanged, skipping", article.FileName)
+					if description := stored[0].Metadata["description"]; description != "" {
+						descriptions = append(descriptions, description)
+					}
+					continue
+				}
+				if collectionCtx, err := UseCollection(ctx, article.Collection, false); err == nil {
+					if err := RemoveDocument(collectionCtx, article.FileName); err != nil {
+						log.Printf("[RAG] Failed to remove the previous version of %s: %v", article.FileName, err)
+					}
+				}
+			}
+
 			llmProvider, err := LLMProviderFromContext(ctx)
 			if err != nil {
 
@@ -270,16 +441,19 @@ func FeedChromem(ctx context.Context, sourcePath string, update bool) {
 			// An alternative is to create the embedding with `chromem.NewDocument()`,
 			// and then change back the content before adding it do the collection
 			// with `collection.AddDocument()`.
-			content := "search_document: " + article.Text
-
-			docs = append(docs, chromem.Document{
-				ID: uuid.NewString(),
-				Metadata: map[string]string{
-					"file":        article.FileName,
-					"description": description,
-				},
-				Content: content, //"search_document: " + article.Text,
-			})
+			metadata := map[string]string{
+				"file":        article.FileName,
+				"description": description,
+				sourceKey:     sourceRagSources,
+				sourceHashKey: hash,
+				ownerKey:      article.Owner,
+				tagsKey:       strings.Join(article.Tags, ","),
+			}
+			applyIngestMetadata(ctx, metadata)
+			docs[article.Collection] = append(docs[article.Collection], chunkedDocuments(article.Text, metadata, ChunkingFromContext(ctx))...)
+			if summary := summaryDocument(ctx, article.Text, metadata); summary != nil {
+				docs[article.Collection] = append(docs[article.Collection], *summary)
+			}
 		}
 
 		dkClient, err := utils.DkFromContext(ctx)
@@ -295,15 +469,61 @@ func FeedChromem(ctx context.Context, sourcePath string, update bool) {
 			log.Println("There's no content to generate the RAG. Skipping it for now")
 			return
 		}
-		err = chromemCollection.AddDocuments(ctx, docs, runtime.NumCPU())
-		if err != nil {
-			// panic(err)
+		for name, collectionDocs := range docs {
+			collectionCtx, err := UseCollection(ctx, name, true)
+			if err != nil {
+				log.Printf("[RAG] Skipping %d documents for collection %s: %v", len(collectionDocs), name, err)
+				continue
+			}
+			collection, err := utils.ChromemCollectionFromContext(collectionCtx)
+			if err != nil {
+				continue
+			}
+			collectionCtx, unlock := lockCollection(collectionCtx, collection)
+			err = collection.AddDocuments(collectionCtx, collectionDocs, runtime.NumCPU())
+			if err != nil {
+				// panic(err)
+			}
+			if idx := KeywordIndexFromContext(collectionCtx); idx != nil {
+				for _, doc := range collectionDocs {
+					idx.Add(doc.ID, strings.TrimPrefix(doc.Content, "search_document: "), doc.Metadata)
+				}
+			}
+			unlock()
 		}
 	} else {
 		log.Println("Not reading JSON lines because collection was loaded from persistent storage.")
 	}
 }
 
+// chunkedDocuments splits a file's content into the vector store documents of its chunks.
+// Each chunk gets a copy of metadata plus its position when the file is split.
+func chunkedDocuments(fileContent string, metadata map[string]string, config ChunkingConfig) []chromem.Document {
+	chunks := ChunkDocument(fileContent, config)
+	docs := make([]chromem.Document, 0, len(chunks))
+	for i, chunk := range chunks {
+		docMetadata := make(map[string]string, len(metadata)+3)
+		for key, value := range metadata {
+			docMetadata[key] = value
+		}
+		if len(chunks) > 1 {
+			docMetadata[chunkIndexKey] = strconv.Itoa(i)
+			docMetadata[chunkCountKey] = strconv.Itoa(len(chunks))
+			docMetadata[chunkOffsetKey] = strconv.Itoa(chunk.Offset)
+		}
+		// The embeddings model we use ("nomic-embed-text") fares better with a prefix to
+		// differentiate between document and query; it is cut off again on retrieval.
+		docs = append(docs, chromem.Document{
+			ID:       uuid.NewString(),
+			Metadata: docMetadata,
+			Content:  "search_document: " + chunk.Text,
+		})
+	}
+	return docs
+}
+
+// GetDocument returns the document of the first file matching the filter, reassembled from
+// its chunks
 func GetDocument(ctx context.Context, filterName string, filterValue string, nElements int) (*Document, error) {
 	if strings.TrimSpace(filterValue) == "" {
 		return nil, errors.New("filterValue shouldn't be empty")
@@ -326,23 +546,38 @@ func GetDocument(ctx context.Context, filterName string, filterValue string, nEl
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
 
-	content := strings.TrimPrefix(results[0].Content, "search_document: ")
+	// A chunked or summarized file is spread over several documents; fetch all of them
+	if _, chunked := results[0].Metadata[chunkCountKey]; chunked || isSummary(results[0].Metadata) {
+		where = map[string]string{"file": results[0].Metadata["file"]}
+		results, err = col.Query(ctx, dummyQuery, col.Count(), where, nil)
+		if err != nil {
+			return nil, fmt.Errorf("query failed: %w", err)
+		}
+	}
 
-	// Extract metadata from the document's metadata map
-	metadata := make(map[string]string)
-	for key, value := range results[0].Metadata {
-		metadata[key] = value
-		// if strings.HasPrefix(key, "metadata_") {
-		// Strip the "metadata_" prefix and use the rest as the key
-		// }
+	parts := make([]Document, 0, len(results))
+	for _, res := range results {
+		content := strings.TrimPrefix(res.Content, "search_document: ")
+
+		// Extract metadata from the document's metadata map
+		metadata := make(map[string]string)
+		for key, value := range res.Metadata {
+			metadata[key] = value
+			// if strings.HasPrefix(key, "metadata_") {
+			// Strip the "metadata_" prefix and use the rest as the key
+			// }
+		}
+
+		parts = append(parts, Document{
+			FileName: res.Metadata["file"],
+			Content:  content,
+			Metadata: metadata,
+			Score:    res.Similarity,
+		})
 	}
 
-	return &Document{
-		FileName: results[0].Metadata["file"],
-		Content:  content,
-		Metadata: metadata,
-		Score:    results[0].Similarity,
-	}, nil
+	doc := mergeChunks(parts)[0]
+	return &doc, nil
 }
 
 // GetDocuments returns all documents that match the given filter criteria
@@ -359,8 +594,14 @@ func GetDocuments(ctx context.Context, filterName string, filterValue string, nE
 	where := map[string]string{filterName: filterValue}
 
 	// chromem-go requires a non‑empty queryText; a throw‑away literal is fine.
+	// Files may be split into several chunks, so all matches are fetched and merged before
+	// limiting the result to nElements files
+	count := col.Count()
+	if count == 0 {
+		return []Document{}, nil
+	}
 	const dummyQuery = "search_query: _"
-	results, err := col.Query(ctx, dummyQuery, nElements, where, nil)
+	results, err := col.Query(ctx, dummyQuery, count, where, nil)
 	if err != nil {
 		return nil, fmt.Errorf("query failed: %w", err)
 	}
@@ -390,23 +631,302 @@ func GetDocuments(ctx context.Context, filterName string, filterValue string, nE
 		})
 	}
 
+	documents = mergeChunks(documents)
+	if nElements > 0 && len(documents) > nElements {
+		documents = documents[:nElements]
+	}
 	return documents, nil
 }
 
-// UpdateDocument overwrites (or creates) the document identified by fileName.
-// It re‑uses the existing helpers to keep the behaviour (embeddings, description
-// list, etc.) consistent in one place.
+// ListDocuments returns every file of the collection of the context, with its chunks merged,
+// sorted by file name. Soft-deleted files are left out.
+func ListDocuments(ctx context.Context) ([]Document, error) {
+	col, err := utils.ChromemCollectionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results, err := allDocuments(ctx, col, false)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	parts := make([]Document, 0, len(results))
+	for _, res := range results {
+		metadata := make(map[string]string, len(res.Metadata))
+		for key, value := range res.Metadata {
+			if key != "file" && !strings.HasPrefix(key, tagKeyPrefix) {
+				metadata[key] = value
+			}
+		}
+		parts = append(parts, Document{
+			FileName: res.Metadata["file"],
+			Content:  strings.TrimPrefix(res.Content, "search_document: "),
+			Metadata: metadata,
+		})
+	}
+
+	documents := mergeChunks(parts)
+	sort.Slice(documents, func(i, j int) bool { return documents[i].FileName < documents[j].FileName })
+	return documents, nil
+}
+
+// ErrDocumentNotFound is returned when no document has the given file name
+var ErrDocumentNotFound = errors.New("document not found")
+
+// allDocuments returns every stored chunk of a collection. Chunks of soft-deleted files are
+// left out unless withDeleted is set.
+func allDocuments(ctx context.Context, collection *chromem.Collection, withDeleted bool) ([]chromem.Result, error) {
+	count := collection.Count()
+	if count == 0 {
+		return nil, nil
+	}
+	// chromem-go has no listing API, so fetch everything with a throw-away query
+	results, err := collection.Query(ctx, "search_query: _", count, nil, nil)
+	if err != nil || withDeleted {
+		return results, err
+	}
+	documents := results[:0]
+	for _, res := range results {
+		if res.Metadata["is_deleted"] != "true" {
+			documents = append(documents, res)
+		}
+	}
+	return documents, nil
+}
+
+// fileChunks returns the stored chunks of a file
+func fileChunks(ctx context.Context, collection *chromem.Collection, fileName string) ([]chromem.Result, error) {
+	count := collection.Count()
+	if count == 0 {
+		return nil, nil
+	}
+	return collection.Query(ctx, "search_query: _", count, map[string]string{"file": fileName}, nil)
+}
+
+// DeleteDocument marks a file of the collection of the context as deleted. The file is left
+// out of retrieval and listings but keeps its chunks and document associations, so that
+// RestoreDocument can bring it back. PurgeDocument removes it for good.
+func DeleteDocument(ctx context.Context, fileName string) error {
+	return setDeleted(ctx, fileName, true)
+}
+
+// RestoreDocument clears the deletion mark of a file set by DeleteDocument
+func RestoreDocument(ctx context.Context, fileName string) error {
+	return setDeleted(ctx, fileName, false)
+}
+
+// setDeleted sets or clears the deletion mark on every chunk of a file. Deleting a file that
+// is already deleted reports it as not found; restoring a file that is not deleted is a no-op.
+func setDeleted(ctx context.Context, fileName string, deleted bool) error {
+	if strings.TrimSpace(fileName) == "" {
+		return errors.New("filename must be non‑empty")
+	}
+	chromemCollection, err := utils.ChromemCollectionFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, unlock := lockCollection(ctx, chromemCollection)
+	defer unlock()
+
+	chunks, err := fileChunks(ctx, chromemCollection, fileName)
+	if err != nil {
+		return fmt.Errorf("failed to look up document: %w", err)
+	}
+	if len(chunks) == 0 || (deleted && chunks[0].Metadata["is_deleted"] == "true") {
+		return fmt.Errorf("%w: %s", ErrDocumentNotFound, fileName)
+	}
+	if !deleted && chunks[0].Metadata["is_deleted"] != "true" {
+		return nil
+	}
+
+	metadata := make(map[string]string, len(chunks[0].Metadata)+2)
+	for key, value := range chunks[0].Metadata {
+		if !isChunkKey(key) && key != summaryKey && key != "is_deleted" && key != "deletion_date" {
+			metadata[key] = value
+		}
+	}
+	if deleted {
+		metadata["is_deleted"] = "true"
+		metadata["deletion_date"] = time.Now().Format(time.RFC3339)
+	} else {
+		metadata["is_deleted"] = "false"
+	}
+	return retagChunks(ctx, chromemCollection, fileName, chunks, metadata)
+}
+
+// PurgeDocument removes a file from the collection of the context together with the document
+// associations that reference it and its stored blob. Associations are kept while another
+// collection still holds a file of the same name, since they are keyed by file name only.
+// A failure leaves the chunks and the associations as they were.
+func PurgeDocument(ctx context.Context, fileName string) error {
+	if strings.TrimSpace(fileName) == "" {
+		return errors.New("filename must be non‑empty")
+	}
+	chromemCollection, err := utils.ChromemCollectionFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, unlock := lockCollection(ctx, chromemCollection)
+	defer unlock()
+
+	chunks, err := fileChunks(ctx, chromemCollection, fileName)
+	if err != nil {
+		return fmt.Errorf("failed to look up document: %w", err)
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		if len(chunks) == 0 {
+			return fmt.Errorf("%w: %s", ErrDocumentNotFound, fileName)
+		}
+		return RemoveDocument(ctx, fileName)
+	}
+	associations, err := db.CountDocumentAssociationsByFilename(database, fileName)
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 && associations == 0 {
+		return fmt.Errorf("%w: %s", ErrDocumentNotFound, fileName)
+	}
+	// The original file and the associations stay while another collection holds the file
+	if fileInOtherCollections(ctx, chromemCollection, fileName) {
+		return RemoveDocument(ctx, fileName)
+	}
+	if associations == 0 {
+		if err := RemoveDocument(ctx, fileName); err != nil {
+			return err
+		}
+	} else if err := removeWithAssociations(ctx, database, chromemCollection, fileName, chunks); err != nil {
+		return err
+	}
+	if err := DeleteDocumentBlob(ctx, fileName); err != nil {
+		log.Printf("[RAG] Failed to delete the original of document %s: %v", fileName, err)
+	}
+	return nil
+}
+
+// removeWithAssociations deletes the associations of a file in a transaction that only commits
+// once its chunks are gone, and puts the chunks back if the commit fails
+func removeWithAssociations(ctx context.Context, database *sql.DB, collection *chromem.Collection, fileName string, chunks []chromem.Result) error {
+	tx, err := database.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+	if err := db.DeleteAllDocumentAssociationsByFilenameTx(tx, fileName); err != nil {
+		return err
+	}
+	if err := RemoveDocument(ctx, fileName); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		if restoreErr := restoreChunks(ctx, collection, fileName, chunks); restoreErr != nil {
+			log.Printf("[RAG] Failed to restore document %s: %v", fileName, restoreErr)
+		}
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// fileInOtherCollections reports whether a named collection other than current holds the file
+func fileInOtherCollections(ctx context.Context, current *chromem.Collection, fileName string) bool {
+	collections := CollectionsFromContext(ctx)
+	if collections == nil {
+		return false
+	}
+	for _, info := range collections.List() {
+		collectionCtx, err := UseCollection(ctx, info.Name, false)
+		if err != nil {
+			continue
+		}
+		collection, err := utils.ChromemCollectionFromContext(collectionCtx)
+		if err != nil || collection == current || collection.Count() == 0 {
+			continue
+		}
+		if results, err := collection.Query(ctx, "search_query: _", 1, map[string]string{"file": fileName}, nil); err == nil && len(results) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateDocument overwrites (or creates) the document identified by fileName. The metadata of
+// the replaced document, such as its tags and the time it was first added, carries over unless
+// metadata overrides it. If the new content cannot be added the old document is restored.
 func UpdateDocument(ctx context.Context, fileName, newContent string, metadata map[string]string) error {
-	// Remove first – we don't care if the old doc did not exist.
+	chromemCollection, err := utils.ChromemCollectionFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, unlock := lockCollection(ctx, chromemCollection)
+	defer unlock()
+
+	old, err := fileChunks(ctx, chromemCollection, fileName)
+	if err != nil {
+		return fmt.Errorf("failed to look up document: %w", err)
+	}
+
+	merged := make(map[string]string)
+	if len(old) > 0 {
+		for key, value := range old[0].Metadata {
+			if !isChunkKey(key) && !strings.HasPrefix(key, tagKeyPrefix) && key != "file" && key != "date" && key != summaryKey {
+				merged[key] = value
+			}
+		}
+	}
+	for key, value := range metadata {
+		merged[key] = value
+	}
+
 	if err := RemoveDocument(ctx, fileName); err != nil {
 		return err
 	}
-	return AddDocument(ctx, fileName, newContent, false, metadata)
+	if err := AddDocument(ctx, fileName, newContent, false, merged); err != nil {
+		if restoreErr := restoreChunks(ctx, chromemCollection, fileName, old); restoreErr != nil {
+			log.Printf("[RAG] Failed to restore document %s: %v", fileName, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// restoreChunks puts removed chunks back with their stored embeddings, replacing whatever was
+// added for the file since
+func restoreChunks(ctx context.Context, collection *chromem.Collection, fileName string, chunks []chromem.Result) error {
+	ctx, unlock := lockCollection(ctx, collection)
+	defer unlock()
+
+	if err := RemoveDocument(ctx, fileName); err != nil {
+		return err
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+	docs := make([]chromem.Document, 0, len(chunks))
+	for _, chunk := range chunks {
+		docs = append(docs, chromem.Document{ID: chunk.ID, Metadata: chunk.Metadata, Embedding: chunk.Embedding, Content: chunk.Content})
+	}
+	if err := collection.AddDocuments(ctx, docs, runtime.NumCPU()); err != nil {
+		return err
+	}
+	if idx := KeywordIndexFromContext(ctx); idx != nil {
+		for _, doc := range docs {
+			idx.Add(doc.ID, strings.TrimPrefix(doc.Content, "search_document: "), doc.Metadata)
+		}
+	}
+	notifyChange(DocumentsChanged)
+	return nil
 }
 
 // AppendDocument appends new content to an existing document identified by fileName.
 // If the document doesn't exist, it creates a new one with the provided content.
 func AppendDocument(ctx context.Context, fileName, newContent string, metadata map[string]string) error {
+	chromemCollection, err := utils.ChromemCollectionFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	ctx, unlock := lockCollection(ctx, chromemCollection)
+	defer unlock()
+
 	// Try to get the existing document
 	existingDoc, err := GetDocument(ctx, "file", fileName, 1)
 	if err != nil {
@@ -433,6 +953,8 @@ func ToggleActiveMetadata(ctx context.Context, filterField string, filterValue s
 	if err != nil {
 		return fmt.Errorf("Failed to get the vector db collection: %w", err)
 	}
+	ctx, unlock := lockCollection(ctx, chromemCollection)
+	defer unlock()
 
 	// Use collection count instead of a fixed value to avoid "nResults must be <= number of documents" error
 	count := chromemCollection.Count()
@@ -490,6 +1012,8 @@ func DeleteAllDocuments(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to get the vector db collection: %w", err)
 	}
+	ctx, unlock := lockCollection(ctx, chromemCollection)
+	defer unlock()
 
 	// First delete documents with "active" = "true"
 	filter := map[string]string{"active": "true"}
@@ -503,6 +1027,10 @@ func DeleteAllDocuments(ctx context.Context) error {
 		return fmt.Errorf("failed to delete documents with active=false: %w", err)
 	}
 
+	if idx := KeywordIndexFromContext(ctx); idx != nil {
+		idx.Clear()
+	}
+	notifyChange(DocumentsChanged)
 	return nil
 }
 
@@ -560,6 +1088,8 @@ func EnsureDocumentMetadata(ctx context.Context) (map[string]int, error) {
 		log.Printf("[RAG] Metadata repair failed: Could not get Chromem collection: %v", err)
 		return nil, fmt.Errorf("failed to get Chromem collection: %w", err)
 	}
+	ctx, unlock := lockCollection(ctx, chromemCollection)
+	defer unlock()
 
 	// Get the total document count
 	count := chromemCollection.Count()
@@ -570,9 +1100,8 @@ func EnsureDocumentMetadata(ctx context.Context) (map[string]int, error) {
 		return map[string]int{"total": 0, "fixed": 0}, nil
 	}
 
-	// Get all documents using a dummy query with large limit
-	const dummyQuery = "search_query: _"
-	results, err := chromemCollection.Query(ctx, dummyQuery, count, nil, nil)
+	// Soft-deleted files are repaired too, so they are complete once restored
+	results, err := allDocuments(ctx, chromemCollection, true)
 	if err != nil {
 		log.Printf("[RAG] Failed to retrieve documents for metadata validation: %v", err)
 		return nil, fmt.Errorf("failed to retrieve documents: %w", err)
@@ -580,9 +1109,20 @@ func EnsureDocumentMetadata(ctx context.Context) (map[string]int, error) {
 
 	log.Printf("[RAG] Retrieved %d documents for metadata validation", len(results))
 
+	// Repairs re-add whole files, so the chunks of each file are merged first
+	documents := make([]Document, 0, len(results))
+	for _, res := range results {
+		documents = append(documents, Document{
+			FileName: res.Metadata["file"],
+			Content:  strings.TrimPrefix(res.Content, "search_document: "),
+			Metadata: res.Metadata,
+		})
+	}
+	documents = mergeChunks(documents)
+
 	// Track statistics
 	stats := map[string]int{
-		"total":             len(results),
+		"total":             len(documents),
 		"fixed":             0,
 		"missing_active":    0,
 		"missing_date":      0,
@@ -590,7 +1130,7 @@ func EnsureDocumentMetadata(ctx context.Context) (map[string]int, error) {
 	}
 
 	// Process each document
-	for _, doc := range results {
+	for _, doc := range documents {
 		needsUpdate := false
 		updatedMetadata := make(map[string]string)
 
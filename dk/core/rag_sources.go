@@ -0,0 +1,330 @@
+package core
+
+import (
+	"crypto/sha256"
+	"dk/utils"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Values of the source metadata of documents ingested from files
+const (
+	sourceRagSources   = "rag_sources"   // An entry of the RAG sources file
+	sourceDocumentsDir = "documents_dir" // A file in the watched documents directory
+)
+
+// sourceHashKey holds the hash of the content and metadata a file-based document was ingested
+// from, so changed sources can be detected without re-embedding unchanged ones
+const sourceHashKey = "source_hash"
+
+// maxSourceURLSize bounds the content downloaded for a URL source
+const maxSourceURLSize = 32 << 20
+
+var (
+	// ErrInvalidRagSource is returned for entries that cannot be ingested
+	ErrInvalidRagSource = errors.New("invalid RAG source")
+	// ErrRagSourceNotFound is returned when no entry has the given collection and file name
+	ErrRagSourceNotFound = errors.New("RAG source not found")
+	// ErrRagSourceExists is returned when adding an entry whose collection and file name are taken
+	ErrRagSourceExists = errors.New("RAG source already exists")
+)
+
+// sourceURLClient downloads URL sources
+var sourceURLClient = &http.Client{Timeout: 30 * time.Second}
+
+// ragSourcesMu serializes edits of RAG sources files
+var ragSourcesMu sync.Mutex
+
+// RagSource is an entry of the RAG sources JSONL file. Exactly one of Text, Path and URL holds
+// the content.
+type RagSource struct {
+	Text       string   `json:"text,omitempty"`
+	FileName   string   `json:"file,omitempty"`
+	Path       string   `json:"path,omitempty"`       // File to read when text is empty
+	URL        string   `json:"url,omitempty"`        // HTTP(S) address to download when text is empty
+	Collection string   `json:"collection,omitempty"` // Named collection; defaults to the collection of the context
+	Tags       []string `json:"tags,omitempty"`
+	Owner      string   `json:"owner,omitempty"`
+}
+
+// read returns the raw content of the source. A source read from a path or URL is named after
+// the file unless it has a file name.
+func (s *RagSource) read() ([]byte, error) {
+	switch {
+	case s.Text != "" || (s.Path == "" && s.URL == ""):
+		return []byte(s.Text), nil
+	case s.Path != "":
+		path, err := utils.ExpandHomePath(s.Path)
+		if err != nil {
+			return nil, err
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if s.FileName == "" {
+			s.FileName = filepath.Base(path)
+		}
+		return raw, nil
+	default:
+		resp, err := sourceURLClient.Get(s.URL)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("GET %s: %s", s.URL, resp.Status)
+		}
+		raw, err := io.ReadAll(io.LimitReader(resp.Body, maxSourceURLSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) > maxSourceURLSize {
+			return nil, fmt.Errorf("GET %s: content exceeds %d bytes", s.URL, maxSourceURLSize)
+		}
+		if s.FileName == "" {
+			s.FileName = urlFileName(s.URL)
+		}
+		return raw, nil
+	}
+}
+
+// name returns the file name the source is stored under
+func (s *RagSource) name() string {
+	switch {
+	case s.FileName != "":
+		return s.FileName
+	case s.Text == "" && s.Path != "":
+		return filepath.Base(s.Path)
+	case s.Text == "" && s.URL != "":
+		return urlFileName(s.URL)
+	}
+	return ""
+}
+
+// key identifies the document of the source
+func (s *RagSource) key() sourceDocument {
+	return newSourceDocument(s.Collection, s.name())
+}
+
+// urlFileName names a document after the last element of its URL path, or its host
+func urlFileName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	if base := path.Base(u.Path); base != "/" && base != "." {
+		return base
+	}
+	return u.Host
+}
+
+// Validate checks that the source has exactly one kind of content, that its path is a
+// readable file or its URL an absolute HTTP(S) address, and that it has a file name
+func (s *RagSource) Validate() error {
+	kinds := 0
+	for _, set := range []bool{s.Text != "", s.Path != "", s.URL != ""} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("%w: exactly one of text, path and url is required", ErrInvalidRagSource)
+	}
+
+	if s.Path != "" {
+		path, err := utils.ExpandHomePath(s.Path)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRagSource, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRagSource, err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%w: %s is not a regular file", ErrInvalidRagSource, s.Path)
+		}
+	}
+	if s.URL != "" {
+		u, err := url.Parse(s.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%w: %q is not an http or https URL", ErrInvalidRagSource, s.URL)
+		}
+	}
+	if strings.TrimSpace(s.name()) == "" {
+		return fmt.Errorf("%w: file name is required", ErrInvalidRagSource)
+	}
+	if s.Collection != "" && !collectionNamePattern.MatchString(s.Collection) {
+		return fmt.Errorf("%w: invalid collection name %q", ErrInvalidRagSource, s.Collection)
+	}
+	return nil
+}
+
+// ReadRagSources parses a RAG sources JSONL file
+func ReadRagSources(path string) ([]RagSource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var sources []RagSource
+	d := json.NewDecoder(f)
+	for line := 1; ; line++ {
+		var source RagSource
+		if err := d.Decode(&source); errors.Is(err, io.EOF) {
+			return sources, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", line, err)
+		}
+		sources = append(sources, source)
+	}
+}
+
+// writeRagSources replaces a RAG sources file. The entries are written to a temporary file
+// first, so readers never see a partly written file.
+func writeRagSources(path string, sources []RagSource) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	e := json.NewEncoder(tmp)
+	e.SetEscapeHTML(false)
+	for _, source := range sources {
+		if err := e.Encode(source); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// AddRagSource validates a source and appends it to a RAG sources file, which is created if
+// it does not exist
+func AddRagSource(path string, source RagSource) error {
+	if err := source.Validate(); err != nil {
+		return err
+	}
+
+	ragSourcesMu.Lock()
+	defer ragSourcesMu.Unlock()
+
+	sources, err := ReadRagSources(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := range sources {
+		if sources[i].key() == source.key() {
+			return fmt.Errorf("%w: %s", ErrRagSourceExists, source.name())
+		}
+	}
+	return writeRagSources(path, append(sources, source))
+}
+
+// UpdateRagSource changes the entry of a RAG sources file with the given collection and file
+// name. The updated entry must be valid and must not take the name of another entry.
+func UpdateRagSource(path, collection, file string, update func(*RagSource)) (RagSource, error) {
+	ragSourcesMu.Lock()
+	defer ragSourcesMu.Unlock()
+
+	sources, err := ReadRagSources(path)
+	if err != nil {
+		return RagSource{}, err
+	}
+	key := newSourceDocument(collection, file)
+	for i := range sources {
+		if sources[i].key() != key {
+			continue
+		}
+		// The entry keeps its name when its content changes from a path or URL to text
+		source := sources[i]
+		source.FileName = source.name()
+		update(&source)
+		if err := source.Validate(); err != nil {
+			return RagSource{}, err
+		}
+		for j := range sources {
+			if j != i && sources[j].key() == source.key() {
+				return RagSource{}, fmt.Errorf("%w: %s", ErrRagSourceExists, source.name())
+			}
+		}
+		sources[i] = source
+		return source, writeRagSources(path, sources)
+	}
+	return RagSource{}, fmt.Errorf("%w: %s", ErrRagSourceNotFound, file)
+}
+
+// RemoveRagSource deletes the entry of a RAG sources file with the given collection and file
+// name
+func RemoveRagSource(path, collection, file string) (RagSource, error) {
+	ragSourcesMu.Lock()
+	defer ragSourcesMu.Unlock()
+
+	sources, err := ReadRagSources(path)
+	if err != nil {
+		return RagSource{}, err
+	}
+	key := newSourceDocument(collection, file)
+	for i := range sources {
+		if sources[i].key() == key {
+			removed := sources[i]
+			return removed, writeRagSources(path, append(sources[:i], sources[i+1:]...))
+		}
+	}
+	return RagSource{}, fmt.Errorf("%w: %s", ErrRagSourceNotFound, file)
+}
+
+// sourceHash identifies the content and metadata a document is ingested from
+func sourceHash(raw []byte, tags []string, owner string) string {
+	h := sha256.New()
+	h.Write(raw)
+	h.Write([]byte{0})
+	h.Write([]byte(strings.Join(normalizeTags(tags), ",")))
+	h.Write([]byte{0})
+	h.Write([]byte(owner))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// documentsDirFiles lists the regular files below dir, relative to it with forward slashes.
+// Hidden files and directories are skipped.
+func documentsDirFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
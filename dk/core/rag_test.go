@@ -0,0 +1,245 @@
+package core
+
+import (
+	"context"
+	lib "dk/client"
+	"dk/db"
+	"dk/utils"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUpdateAndDeleteDocument(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunAPIMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	c := newTestCollections(t, CollectionsConfig{})
+	ctx := WithCollections(context.Background(), c)
+	ctx = utils.WithDK(ctx, lib.NewClient("https://localhost", "alice", nil, nil))
+	ctx = utils.WithDatabase(ctx, testDB.DB)
+	ctx, err = UseCollection(ctx, DefaultCollection, true)
+	if err != nil {
+		t.Fatalf("UseCollection failed: %v", err)
+	}
+
+	if err := AddDocument(ctx, "guide.txt", "Installation guide", false, map[string]string{"tags": "docs,setup"}); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+	before, _ := GetDocument(ctx, "file", "guide.txt", 1)
+
+	// Tags and the time the document was first added carry over
+	if err := UpdateDocument(ctx, "guide.txt", "Upgrade guide", map[string]string{}); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	after, err := GetDocument(ctx, "file", "guide.txt", 1)
+	if err != nil || after.Content != "Upgrade guide" {
+		t.Fatalf("Expected the updated content, got %+v (%v)", after, err)
+	}
+	if after.Metadata["tags"] != "docs,setup" || after.Metadata["tag:setup"] != "true" || after.Metadata["indexed_at"] != before.Metadata["indexed_at"] {
+		t.Errorf("Expected the metadata to carry over, got %v", after.Metadata)
+	}
+
+	if err := UpdateDocument(ctx, "guide.txt", "Upgrade guide", map[string]string{"tags": ""}); err != nil {
+		t.Fatalf("UpdateDocument failed: %v", err)
+	}
+	if after, _ := GetDocument(ctx, "file", "guide.txt", 1); after.Metadata["tags"] != "" || after.Metadata["tag:docs"] != "" {
+		t.Errorf("Expected the tags to be cleared, got %v", after.Metadata)
+	}
+
+	if err := db.CreateDocumentAssociation(testDB.DB, &db.DocumentAssociation{DocumentFilename: "guide.txt", EntityID: "api-1", EntityType: "api"}); err != nil {
+		t.Fatalf("CreateDocumentAssociation failed: %v", err)
+	}
+	collection, _ := utils.ChromemCollectionFromContext(ctx)
+
+	// Deleting marks the document, which leaves the listings but keeps its chunks and associations
+	if err := DeleteDocument(ctx, "guide.txt"); err != nil {
+		t.Fatalf("DeleteDocument failed: %v", err)
+	}
+	if n, _ := db.CountDocumentAssociationsByFilename(testDB.DB, "guide.txt"); n != 1 {
+		t.Errorf("Expected the associations to be kept, got %d", n)
+	}
+	chunks, _ := fileChunks(ctx, collection, "guide.txt")
+	if len(chunks) == 0 || chunks[0].Metadata["is_deleted"] != "true" || chunks[0].Metadata["deletion_date"] == "" {
+		t.Errorf("Expected the chunks to be marked as deleted, got %+v", chunks)
+	}
+	if docs, _ := ListDocuments(ctx); len(docs) != 0 {
+		t.Errorf("Expected the deleted document not to be listed, got %+v", docs)
+	}
+	if docs, _ := RetrieveDocuments(ctx, "guide", 5, nil); len(docs) != 0 {
+		t.Errorf("Expected the deleted document not to be retrieved, got %+v", docs)
+	}
+	if err := DeleteDocument(ctx, "guide.txt"); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected ErrDocumentNotFound, got %v", err)
+	}
+
+	if err := RestoreDocument(ctx, "guide.txt"); err != nil {
+		t.Fatalf("RestoreDocument failed: %v", err)
+	}
+	if docs, _ := ListDocuments(ctx); len(docs) != 1 || docs[0].Metadata["deletion_date"] != "" {
+		t.Errorf("Expected the restored document, got %+v", docs)
+	}
+	if err := DeleteDocument(ctx, "guide.txt"); err != nil {
+		t.Fatalf("DeleteDocument failed: %v", err)
+	}
+
+	// Purging removes the document together with its associations
+	if err := PurgeDocument(ctx, "guide.txt"); err != nil {
+		t.Fatalf("PurgeDocument failed: %v", err)
+	}
+	if n, _ := db.CountDocumentAssociationsByFilename(testDB.DB, "guide.txt"); n != 0 {
+		t.Errorf("Expected the associations to be removed, got %d", n)
+	}
+	if chunks, _ := fileChunks(ctx, collection, "guide.txt"); len(chunks) != 0 {
+		t.Errorf("Expected the chunks to be removed, got %d", len(chunks))
+	}
+	if err := PurgeDocument(ctx, "guide.txt"); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected ErrDocumentNotFound, got %v", err)
+	}
+	if err := RestoreDocument(ctx, "guide.txt"); !errors.Is(err, ErrDocumentNotFound) {
+		t.Errorf("Expected ErrDocumentNotFound, got %v", err)
+	}
+}
+
+func TestAddDocumentDeduplicates(t *testing.T) {
+	c := newTestCollections(t, CollectionsConfig{})
+	ctx := WithCollections(context.Background(), c)
+	ctx = utils.WithDK(ctx, lib.NewClient("https://localhost", "alice", nil, nil))
+	ctx, err := UseCollection(ctx, DefaultCollection, true)
+	if err != nil {
+		t.Fatalf("UseCollection failed: %v", err)
+	}
+	collection, _ := utils.ChromemCollectionFromContext(ctx)
+
+	if err := AddDocument(ctx, "guide.txt", "Installation guide", false, map[string]string{"tags": "docs"}); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+	first, _ := fileChunks(ctx, collection, "guide.txt")
+
+	// The same content is not stored twice
+	if err := AddDocument(ctx, "guide.txt", "Installation guide", false, map[string]string{"tags": "docs"}); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+	if collection.Count() != 1 {
+		t.Errorf("Expected the unchanged document to be skipped, got %d chunks", collection.Count())
+	}
+
+	// Other metadata for the same content updates the stored chunks
+	if err := AddDocument(ctx, "guide.txt", "Installation guide", false, map[string]string{"tags": "docs,setup"}); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+	chunks, _ := fileChunks(ctx, collection, "guide.txt")
+	if len(chunks) != 1 || chunks[0].Metadata["tag:setup"] != "true" || chunks[0].Metadata[indexedAtKey] != first[0].Metadata[indexedAtKey] {
+		t.Errorf("Expected the document retagged in place, got %+v", chunks)
+	}
+
+	// Changed content replaces the stored chunks
+	if err := AddDocument(ctx, "guide.txt", "Upgrade guide", false, map[string]string{"tags": "docs,setup"}); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+	chunks, _ = fileChunks(ctx, collection, "guide.txt")
+	if len(chunks) != 1 || chunks[0].Content != "search_document: Upgrade guide" || chunks[0].Metadata[contentHashKey] != contentHash("Upgrade guide") {
+		t.Errorf("Expected the changed document to replace the old one, got %+v", chunks)
+	}
+}
+
+func TestListDocuments(t *testing.T) {
+	ctx := WithCollections(context.Background(), newTestCollections(t, CollectionsConfig{}))
+	ctx = WithChunking(ctx, ChunkingConfig{Strategy: ChunkStrategySentence, Size: 5})
+	ctx, err := UseCollection(ctx, DefaultCollection, true)
+	if err != nil {
+		t.Fatalf("UseCollection failed: %v", err)
+	}
+	if docs, err := ListDocuments(ctx); err != nil || len(docs) != 0 {
+		t.Fatalf("Expected no documents, got %+v (%v)", docs, err)
+	}
+
+	long := strings.Repeat("Chunked documents are listed once with their whole content. ", 100)
+	if err := AddDocument(ctx, "manual.md", long, false, nil); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+	addTestDocument(t, ctx, DefaultCollection, "faq.txt", "Frequently asked questions")
+	if err := AddDocument(ctx, "old.txt", "Removed", false, map[string]string{"is_deleted": "true"}); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+
+	docs, err := ListDocuments(ctx)
+	if err != nil {
+		t.Fatalf("ListDocuments failed: %v", err)
+	}
+	if len(docs) != 2 || docs[0].FileName != "faq.txt" || docs[1].FileName != "manual.md" {
+		t.Fatalf("Expected faq.txt and manual.md without the deleted file, got %+v", docs)
+	}
+	if collection, _ := utils.ChromemCollectionFromContext(ctx); collection.Count() < 4 {
+		t.Fatalf("Expected manual.md to be chunked, the collection holds %d documents", collection.Count())
+	}
+	if docs[1].Content != long {
+		t.Errorf("Expected the chunks of manual.md to be merged, got %d bytes", len(docs[1].Content))
+	}
+
+	// Every listing shares allDocuments, which leaves soft-deleted files out unless asked
+	collection, _ := utils.ChromemCollectionFromContext(ctx)
+	files := func(withDeleted bool) map[string]bool {
+		t.Helper()
+		results, err := allDocuments(ctx, collection, withDeleted)
+		if err != nil {
+			t.Fatalf("allDocuments failed: %v", err)
+		}
+		files := make(map[string]bool)
+		for _, res := range results {
+			files[res.Metadata["file"]] = true
+		}
+		return files
+	}
+	if files(false)["old.txt"] || !files(false)["faq.txt"] {
+		t.Errorf("Expected the chunks without old.txt, got %v", files(false))
+	}
+	if !files(true)["old.txt"] {
+		t.Errorf("Expected the chunks with old.txt, got %v", files(true))
+	}
+	idx := NewKeywordIndex()
+	if err := BuildKeywordIndex(ctx, idx); err != nil {
+		t.Fatalf("BuildKeywordIndex failed: %v", err)
+	}
+	if hits := idx.Search("removed", 5, nil); len(hits) != 0 {
+		t.Errorf("Expected the keyword index to leave old.txt out, got %+v", hits)
+	}
+}
+
+func TestSearchDocuments(t *testing.T) {
+	ctx := WithCollections(context.Background(), newTestCollections(t, CollectionsConfig{}))
+	ctx, err := UseCollection(ctx, DefaultCollection, true)
+	if err != nil {
+		t.Fatalf("UseCollection failed: %v", err)
+	}
+	for file, content := range map[string]string{"zzz.txt": "zzz zzz", "abc.txt": "abc abc", "abd.txt": "abd abd"} {
+		if err := AddDocument(ctx, file, content, false, map[string]string{"tags": file[:2]}); err != nil {
+			t.Fatalf("AddDocument failed: %v", err)
+		}
+	}
+	if err := AddDocument(ctx, "removed.txt", "abc", false, map[string]string{"is_deleted": "true"}); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+
+	docs, err := SearchDocuments(ctx, "abc", 2, MetadataFilter{})
+	if err != nil {
+		t.Fatalf("SearchDocuments failed: %v", err)
+	}
+	if len(docs) != 2 || docs[0].FileName != "abc.txt" || docs[1].FileName != "abd.txt" {
+		t.Fatalf("Expected abc.txt then abd.txt, got %+v", docs)
+	}
+	if docs[0].Score <= docs[1].Score || docs[0].Content != "abc abc" {
+		t.Errorf("Expected scored chunks in order, got %+v", docs)
+	}
+
+	docs, err = SearchDocuments(ctx, "abc", 5, MetadataFilter{Tags: []string{"zz"}})
+	if err != nil || len(docs) != 1 || docs[0].FileName != "zzz.txt" {
+		t.Errorf("Expected the tag filter to leave zzz.txt, got %+v (%v)", docs, err)
+	}
+}
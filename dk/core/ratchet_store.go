@@ -0,0 +1,28 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	dk_client "dk/client"
+	"dk/db"
+)
+
+// ratchetStore keeps the ratcheting sessions of direct messages with each peer in the
+// database
+type ratchetStore struct {
+	db *sql.DB
+}
+
+// NewRatchetStore returns a ratchet store for the client backed by the ratchet_sessions table
+func NewRatchetStore(database *sql.DB) dk_client.RatchetStore {
+	return ratchetStore{db: database}
+}
+
+func (s ratchetStore) SaveRatchetState(peer string, state []byte) error {
+	return db.SaveRatchetState(context.Background(), s.db, peer, state)
+}
+
+func (s ratchetStore) RatchetState(peer string) ([]byte, bool, error) {
+	state, err := db.GetRatchetState(context.Background(), s.db, peer)
+	return state, state != nil, err
+}
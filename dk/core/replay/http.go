@@ -0,0 +1,114 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DecisionHeader is set by policy enforcement on requests it throttled or blocked
+const DecisionHeader = "X-Policy-Decision"
+
+// HTTPTarget replays traffic against the HTTP API of a node, identifying the API and user of
+// each request with the X-API-ID and X-User-ID headers like consumers do
+type HTTPTarget struct {
+	BaseURL string // Such as http://localhost:8081
+	Token   string // Sent as "Authorization: Bearer" if the node requires one
+	Client  *http.Client
+}
+
+func (t *HTTPTarget) client() *http.Client {
+	if t.Client != nil {
+		return t.Client
+	}
+	return http.DefaultClient
+}
+
+func (t *HTTPTarget) do(ctx context.Context, method, path string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(t.BaseURL, "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.Token)
+	}
+	return t.client().Do(req)
+}
+
+// Send makes the request of an event and returns the decision enforcement made. Requests
+// without an endpoint look up the usage of their API, which every consumer may call.
+func (t *HTTPTarget) Send(ctx context.Context, event Event) (Decision, error) {
+	endpoint := event.Endpoint
+	if endpoint == "" {
+		endpoint = "/api/v1/usage/" + event.APIID + "?limit=1"
+	}
+	header := http.Header{}
+	header.Set("X-API-ID", event.APIID)
+	header.Set("X-User-ID", event.UserID)
+	resp, err := t.do(ctx, http.MethodGet, endpoint, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch decision := Decision(resp.Header.Get(DecisionHeader)); {
+	case decision == Throttle || decision == Block:
+		return decision, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusPaymentRequired:
+		return Block, nil
+	case resp.StatusCode == http.StatusForbidden:
+		return Deny, nil
+	case resp.StatusCode >= 500:
+		return "", fmt.Errorf("%s returned %s", endpoint, resp.Status)
+	}
+	return Allow, nil
+}
+
+// UsageTotals refreshes the usage summaries of the node and returns today's totals of an API
+func (t *HTTPTarget) UsageTotals(ctx context.Context, apiID string) (Totals, error) {
+	resp, err := t.do(ctx, http.MethodPost, "/api/v1/usage-summary/refresh", nil)
+	if err != nil {
+		return Totals{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Totals{}, fmt.Errorf("refreshing usage summaries: %s", resp.Status)
+	}
+
+	now := time.Now().UTC()
+	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	path := fmt.Sprintf("/api/v1/usage-summary/%s?period=daily&from=%s", apiID, startOfDay.Format(time.RFC3339))
+	resp, err = t.do(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return Totals{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Totals{}, fmt.Errorf("reading usage summaries: %s", resp.Status)
+	}
+	var body struct {
+		Items []struct {
+			TotalRequests     int `json:"total_requests"`
+			ThrottledRequests int `json:"throttled_requests"`
+			BlockedRequests   int `json:"blocked_requests"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return Totals{}, fmt.Errorf("reading usage summaries: %w", err)
+	}
+	var totals Totals
+	for _, item := range body.Items {
+		totals.Requests += item.TotalRequests
+		totals.Throttled += item.ThrottledRequests
+		totals.Blocked += item.BlockedRequests
+	}
+	return totals, nil
+}
@@ -0,0 +1,334 @@
+// Package replay sends recorded or synthetic API traffic to a node and compares the
+// decisions of its policy enforcement with the expected ones, so policies can be load-tested
+// on a test instance before enforcement is turned on in production.
+package replay
+
+import (
+	"bufio"
+	"context"
+	"dk/db"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Decision is what policy enforcement did with a request
+type Decision string
+
+const (
+	Allow    Decision = "allow"    // Served without delay
+	Throttle Decision = "throttle" // Served after a delay
+	Block    Decision = "block"    // Refused because a limit was reached or credits ran out
+	Deny     Decision = "deny"     // Refused because the user has no access to the API
+)
+
+// Event is one request of the traffic. OffsetMs is the time since the start of the traffic at
+// which it is sent; Expect is the decision it should get, or empty if any decision is fine.
+type Event struct {
+	OffsetMs int64    `json:"offset_ms"`
+	APIID    string   `json:"api_id"`
+	UserID   string   `json:"user_id"`
+	Endpoint string   `json:"endpoint,omitempty"`
+	Expect   Decision `json:"expect,omitempty"`
+}
+
+func (e Event) offset() time.Duration {
+	return time.Duration(e.OffsetMs) * time.Millisecond
+}
+
+// Target receives the requests of a replay
+type Target interface {
+	Send(ctx context.Context, event Event) (Decision, error)
+}
+
+// Totals are the request counts of an API in its usage summary
+type Totals struct {
+	Requests  int `json:"requests"`
+	Throttled int `json:"throttled"`
+	Blocked   int `json:"blocked"`
+}
+
+// UsageReader is implemented by targets that can report the usage they recorded, so the
+// replay can check that its requests were counted
+type UsageReader interface {
+	UsageTotals(ctx context.Context, apiID string) (Totals, error)
+}
+
+// Options control the pace of a replay
+type Options struct {
+	Speed  float64       // Multiplies the pace of the traffic; 0 or less sends it as fast as possible
+	Settle time.Duration // Time given to the target to record usage before it is read back
+}
+
+// Result is the outcome of one event
+type Result struct {
+	Event  Event    `json:"event"`
+	Actual Decision `json:"actual,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// SummaryCheck compares the requests a replay observed for an API with the change of the
+// usage summary the target recorded
+type SummaryCheck struct {
+	APIID    string `json:"api_id"`
+	Observed Totals `json:"observed"`
+	Recorded Totals `json:"recorded"`
+	Match    bool   `json:"match"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report holds the outcome of a replay
+type Report struct {
+	Events    int              `json:"events"`
+	Failed    int              `json:"failed"` // Requests that could not be sent
+	Decisions map[Decision]int `json:"decisions"`
+	Expected  int              `json:"expected"` // Events with an expected decision
+	Matched   int              `json:"matched"`
+	// Confusion counts the expected and actual decisions of events, as "expected->actual"
+	Confusion  map[string]int `json:"confusion"`
+	Mismatches []Result       `json:"mismatches,omitempty"`
+	Summaries  []SummaryCheck `json:"summaries,omitempty"`
+	Duration   time.Duration  `json:"duration_ns"`
+}
+
+// LoadTraffic reads events from a JSONL file, one event per line, sorted by offset
+func LoadTraffic(path string) ([]Event, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal([]byte(text), &event); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if event.APIID == "" || event.UserID == "" {
+			return nil, fmt.Errorf("line %d: api_id and user_id are required", line)
+		}
+		switch event.Expect {
+		case "", Allow, Throttle, Block, Deny:
+		default:
+			return nil, fmt.Errorf("line %d: unknown decision %q", line, event.Expect)
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		return nil, errors.New("no events")
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].OffsetMs < events[j].OffsetMs })
+	return events, nil
+}
+
+// FromUsage turns recorded api_usage rows into traffic with the same timing, expecting the
+// decisions that were recorded for them. Throttled requests were served, so they may be
+// throttled or allowed depending on when the limit is reached.
+func FromUsage(records []*db.APIUsage) []Event {
+	sorted := append([]*db.APIUsage(nil), records...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp.Before(sorted[j].Timestamp) })
+
+	events := make([]Event, 0, len(sorted))
+	for _, record := range sorted {
+		event := Event{
+			OffsetMs: record.Timestamp.Sub(sorted[0].Timestamp).Milliseconds(),
+			APIID:    record.APIID,
+			UserID:   record.ExternalUserID,
+			Endpoint: record.Endpoint,
+		}
+		switch {
+		case record.WasBlocked:
+			event.Expect = Block
+		case record.WasThrottled:
+			event.Expect = Throttle
+		default:
+			event.Expect = Allow
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// Synthetic generates count requests to an API at rate requests per second, from the users
+// in turn. The events have no expected decision.
+func Synthetic(apiID string, users []string, count int, rate float64) []Event {
+	if len(users) == 0 || count <= 0 {
+		return nil
+	}
+	var interval time.Duration
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+	events := make([]Event, count)
+	for i := range events {
+		events[i] = Event{
+			OffsetMs: (time.Duration(i) * interval).Milliseconds(),
+			APIID:    apiID,
+			UserID:   users[i%len(users)],
+		}
+	}
+	return events
+}
+
+// Run sends the events to the target at their offsets, scaled by the speed, and reports the
+// decisions. Events are sent one at a time, so a slow target delays the ones after it. If the
+// target is a UsageReader, the usage summaries of the APIs are read before and after the
+// replay and compared with the decisions.
+func Run(ctx context.Context, events []Event, target Target, opts Options) (*Report, error) {
+	report := &Report{
+		Events:    len(events),
+		Decisions: make(map[Decision]int),
+		Confusion: make(map[string]int),
+	}
+
+	reader, _ := target.(UsageReader)
+	before := make(map[string]Totals)
+	observed := make(map[string]*Totals)
+	if reader != nil {
+		for _, event := range events {
+			if _, ok := observed[event.APIID]; ok {
+				continue
+			}
+			observed[event.APIID] = &Totals{}
+			totals, err := reader.UsageTotals(ctx, event.APIID)
+			if err != nil {
+				return nil, fmt.Errorf("read usage of API %s: %w", event.APIID, err)
+			}
+			before[event.APIID] = totals
+		}
+	}
+
+	start := time.Now()
+	for _, event := range events {
+		if opts.Speed > 0 {
+			due := start.Add(time.Duration(float64(event.offset()) / opts.Speed))
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Until(due)):
+			}
+		} else if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		result := Result{Event: event}
+		decision, err := target.Send(ctx, event)
+		if err != nil {
+			result.Error = err.Error()
+			report.Failed++
+			report.Mismatches = append(report.Mismatches, result)
+			continue
+		}
+		result.Actual = decision
+		report.Decisions[decision]++
+		if totals := observed[event.APIID]; totals != nil {
+			totals.add(decision)
+		}
+		if event.Expect == "" {
+			continue
+		}
+		report.Expected++
+		report.Confusion[string(event.Expect)+"->"+string(decision)]++
+		if decision == event.Expect {
+			report.Matched++
+		} else {
+			report.Mismatches = append(report.Mismatches, result)
+		}
+	}
+	report.Duration = time.Since(start)
+
+	if reader != nil {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(opts.Settle):
+		}
+		apiIDs := make([]string, 0, len(observed))
+		for apiID := range observed {
+			apiIDs = append(apiIDs, apiID)
+		}
+		sort.Strings(apiIDs)
+		for _, apiID := range apiIDs {
+			check := SummaryCheck{APIID: apiID, Observed: *observed[apiID]}
+			after, err := reader.UsageTotals(ctx, apiID)
+			if err != nil {
+				check.Error = err.Error()
+			} else {
+				check.Recorded = Totals{
+					Requests:  after.Requests - before[apiID].Requests,
+					Throttled: after.Throttled - before[apiID].Throttled,
+					Blocked:   after.Blocked - before[apiID].Blocked,
+				}
+				check.Match = check.Recorded == check.Observed
+			}
+			report.Summaries = append(report.Summaries, check)
+		}
+	}
+	return report, nil
+}
+
+// add counts a decision the way usage summaries do: denied requests are not recorded
+func (t *Totals) add(decision Decision) {
+	switch decision {
+	case Allow:
+		t.Requests++
+	case Throttle:
+		t.Requests++
+		t.Throttled++
+	case Block:
+		t.Requests++
+		t.Blocked++
+	}
+}
+
+// WriteSummary prints the decisions of a report, how many matched the expected ones, and
+// the usage summary checks
+func (r *Report) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "Events: %d in %s", r.Events, r.Duration.Round(time.Millisecond))
+	if r.Failed > 0 {
+		fmt.Fprintf(w, " (%d failed)", r.Failed)
+	}
+	fmt.Fprintln(w)
+	for _, decision := range []Decision{Allow, Throttle, Block, Deny} {
+		fmt.Fprintf(w, "%-10s %d\n", decision, r.Decisions[decision])
+	}
+	if r.Expected > 0 {
+		fmt.Fprintf(w, "Matched:   %d/%d (%.1f%%)\n", r.Matched, r.Expected, 100*float64(r.Matched)/float64(r.Expected))
+		keys := make([]string, 0, len(r.Confusion))
+		for key := range r.Confusion {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if expected, actual, _ := strings.Cut(key, "->"); expected != actual {
+				fmt.Fprintf(w, "  expected %s, got %s: %d\n", expected, actual, r.Confusion[key])
+			}
+		}
+	}
+	for _, check := range r.Summaries {
+		status := "ok"
+		switch {
+		case check.Error != "":
+			status = "error: " + check.Error
+		case !check.Match:
+			status = fmt.Sprintf("MISMATCH: recorded %d requests, %d throttled, %d blocked",
+				check.Recorded.Requests, check.Recorded.Throttled, check.Recorded.Blocked)
+		}
+		fmt.Fprintf(w, "Summary of API %s: observed %d requests, %d throttled, %d blocked; %s\n",
+			check.APIID, check.Observed.Requests, check.Observed.Throttled, check.Observed.Blocked, status)
+	}
+}
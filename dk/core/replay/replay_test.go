@@ -0,0 +1,171 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"dk/db"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeTarget blocks users after their limit and records usage like a node would
+type fakeTarget struct {
+	limit    int
+	sent     map[string]int
+	recorded Totals
+	dropped  bool // Forget to record blocked requests
+}
+
+func (f *fakeTarget) Send(ctx context.Context, event Event) (Decision, error) {
+	if event.UserID == "" {
+		return "", errors.New("connection refused")
+	}
+	if event.UserID == "stranger" {
+		return Deny, nil
+	}
+	f.sent[event.UserID]++
+	f.recorded.Requests++
+	if f.sent[event.UserID] > f.limit {
+		if f.dropped {
+			f.recorded.Requests--
+		} else {
+			f.recorded.Blocked++
+		}
+		return Block, nil
+	}
+	return Allow, nil
+}
+
+func (f *fakeTarget) UsageTotals(ctx context.Context, apiID string) (Totals, error) {
+	return f.recorded, nil
+}
+
+func TestRun(t *testing.T) {
+	events := []Event{
+		{APIID: "a1", UserID: "bob", Expect: Allow},
+		{APIID: "a1", UserID: "bob", Expect: Allow},
+		{APIID: "a1", UserID: "bob", Expect: Allow}, // Blocked, since the limit is 2
+		{APIID: "a1", UserID: "stranger", Expect: Deny},
+		{APIID: "a1", UserID: "carol"},
+		{APIID: "a1", UserID: ""},
+	}
+	target := &fakeTarget{limit: 2, sent: make(map[string]int), recorded: Totals{Requests: 10}}
+
+	report, err := Run(context.Background(), events, target, Options{})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Events != 6 || report.Failed != 1 || report.Expected != 4 || report.Matched != 3 {
+		t.Fatalf("Unexpected report: %+v", report)
+	}
+	if report.Decisions[Allow] != 3 || report.Decisions[Block] != 1 || report.Decisions[Deny] != 1 {
+		t.Errorf("Unexpected decisions: %v", report.Decisions)
+	}
+	if report.Confusion["allow->block"] != 1 || len(report.Mismatches) != 2 {
+		t.Errorf("Expected one allow->block mismatch and one failure, got %v %+v", report.Confusion, report.Mismatches)
+	}
+	want := SummaryCheck{APIID: "a1", Observed: Totals{Requests: 4, Blocked: 1}, Recorded: Totals{Requests: 4, Blocked: 1}, Match: true}
+	if len(report.Summaries) != 1 || report.Summaries[0] != want {
+		t.Errorf("Expected summary check %+v, got %+v", want, report.Summaries)
+	}
+
+	var out bytes.Buffer
+	report.WriteSummary(&out)
+	for _, line := range []string{"Events: 6", "(1 failed)", "Matched:   3/4 (75.0%)", "expected allow, got block: 1", "observed 4 requests, 0 throttled, 1 blocked; ok"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("Expected the summary to contain %q, got:\n%s", line, out.String())
+		}
+	}
+
+	// Requests the target did not count show up in the summary check
+	target = &fakeTarget{limit: 0, sent: make(map[string]int), dropped: true}
+	report, err = Run(context.Background(), events[:1], target, Options{})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Summaries[0].Match || report.Summaries[0].Recorded.Blocked != 0 {
+		t.Errorf("Expected the uncounted block to be reported, got %+v", report.Summaries[0])
+	}
+}
+
+func TestRunPacesEvents(t *testing.T) {
+	events := Synthetic("a1", []string{"bob", "carol"}, 3, 10) // Every 100ms
+	if events[1].UserID != "carol" || events[2].OffsetMs != 200 {
+		t.Fatalf("Unexpected synthetic traffic: %+v", events)
+	}
+	target := &fakeTarget{limit: 10, sent: make(map[string]int)}
+	report, err := Run(context.Background(), events, target, Options{Speed: 4})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if report.Duration < 50*time.Millisecond || report.Duration > time.Second {
+		t.Errorf("Expected 200ms of traffic to take about 50ms at speed 4, took %s", report.Duration)
+	}
+}
+
+func TestLoadTraffic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.jsonl")
+	os.WriteFile(path, []byte(`{"offset_ms": 500, "api_id": "a1", "user_id": "bob", "expect": "block"}
+
+{"offset_ms": 0, "api_id": "a1", "user_id": "carol"}
+`), 0o644)
+	events, err := LoadTraffic(path)
+	if err != nil {
+		t.Fatalf("LoadTraffic failed: %v", err)
+	}
+	if len(events) != 2 || events[0].UserID != "carol" || events[1].Expect != Block {
+		t.Errorf("Expected the events sorted by offset, got %+v", events)
+	}
+
+	os.WriteFile(path, []byte(`{"api_id": "a1", "user_id": "bob", "expect": "maybe"}`), 0o644)
+	if _, err := LoadTraffic(path); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("Expected an unknown decision to be rejected, got %v", err)
+	}
+}
+
+func TestFromUsage(t *testing.T) {
+	start := time.Now()
+	events := FromUsage([]*db.APIUsage{
+		{APIID: "a1", ExternalUserID: "bob", Timestamp: start.Add(2 * time.Second), WasBlocked: true},
+		{APIID: "a1", ExternalUserID: "bob", Timestamp: start, Endpoint: "/api/v1/usage/a1"},
+		{APIID: "a1", ExternalUserID: "bob", Timestamp: start.Add(time.Second), WasThrottled: true},
+	})
+	if len(events) != 3 || events[0].Expect != Allow || events[0].Endpoint != "/api/v1/usage/a1" {
+		t.Fatalf("Unexpected events: %+v", events)
+	}
+	if events[1].OffsetMs != 1000 || events[1].Expect != Throttle || events[2].Expect != Block {
+		t.Errorf("Expected the recorded timing and decisions, got %+v", events)
+	}
+}
+
+func TestHTTPTarget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Header.Get("X-User-ID") {
+		case "throttled":
+			w.Header().Set(DecisionHeader, "throttle")
+		case "limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "stranger":
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+
+	target := &HTTPTarget{BaseURL: server.URL, Token: "secret"}
+	for user, want := range map[string]Decision{"bob": Allow, "throttled": Throttle, "limited": Block, "stranger": Deny} {
+		decision, err := target.Send(context.Background(), Event{APIID: "a1", UserID: user})
+		if err != nil || decision != want {
+			t.Errorf("Expected %s for %s, got %s (%v)", want, user, decision, err)
+		}
+	}
+}
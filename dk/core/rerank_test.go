@@ -0,0 +1,79 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func rerankDocs() []Document {
+	return []Document{
+		{FileName: "a.txt", Content: "alpha"},
+		{FileName: "b.txt", Content: "beta"},
+		{FileName: "c.txt", Content: "gamma"},
+	}
+}
+
+func TestLLMRerankerOrdersByGrade(t *testing.T) {
+	reranker, err := NewReranker(RerankConfig{Method: RerankMethodLLM}, &stubProvider{answer: "1: 2\n2: 9\n3: 5"})
+	if err != nil {
+		t.Fatalf("Failed to create reranker: %v", err)
+	}
+	if reranker.Candidates() != defaultRerankCandidates {
+		t.Errorf("Expected %d candidates by default, got %d", defaultRerankCandidates, reranker.Candidates())
+	}
+
+	ranked, err := reranker.Rerank(context.Background(), "which?", rerankDocs())
+	if err != nil {
+		t.Fatalf("Rerank failed: %v", err)
+	}
+	if ranked[0].FileName != "b.txt" || ranked[1].FileName != "c.txt" || ranked[2].FileName != "a.txt" {
+		t.Errorf("Unexpected order: %s, %s, %s", ranked[0].FileName, ranked[1].FileName, ranked[2].FileName)
+	}
+	if ranked[0].Score != 9 {
+		t.Errorf("Expected the rerank score to replace the retrieval score, got %v", ranked[0].Score)
+	}
+
+	// A response without grades is an error, and the pipeline then keeps the retrieval order
+	reranker, _ = NewReranker(RerankConfig{Method: RerankMethodLLM}, &stubProvider{answer: "I cannot help with that."})
+	if _, err := reranker.Rerank(context.Background(), "which?", rerankDocs()); err == nil {
+		t.Error("Expected an error for a response without grades")
+	}
+	kept := rerankDocuments(context.Background(), reranker, "which?", rerankDocs(), 2)
+	if len(kept) != 2 || kept[0].FileName != "a.txt" {
+		t.Errorf("Expected the first 2 documents in retrieval order, got %+v", kept)
+	}
+}
+
+func TestCrossEncoderReranker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req crossEncoderRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Texts) != 3 || req.Query != "which?" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode([]crossEncoderScore{{Index: 2, Score: 0.9}, {Index: 0, Score: 0.4}, {Index: 1, Score: 0.1}})
+	}))
+	defer server.Close()
+
+	reranker, err := NewReranker(RerankConfig{Method: RerankMethodCrossEncoder, URL: server.URL, Candidates: 5}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create reranker: %v", err)
+	}
+	ranked, err := reranker.Rerank(context.Background(), "which?", rerankDocs())
+	if err != nil {
+		t.Fatalf("Rerank failed: %v", err)
+	}
+	if ranked[0].FileName != "c.txt" || ranked[2].FileName != "b.txt" {
+		t.Errorf("Unexpected order: %s, %s, %s", ranked[0].FileName, ranked[1].FileName, ranked[2].FileName)
+	}
+
+	if _, err := NewReranker(RerankConfig{Method: RerankMethodCrossEncoder}, nil); err == nil {
+		t.Error("Expected an error for a cross-encoder without url")
+	}
+	if _, err := NewReranker(RerankConfig{Method: "bm25"}, nil); err == nil {
+		t.Error("Expected an error for an unsupported method")
+	}
+}
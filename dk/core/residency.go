@@ -0,0 +1,172 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	"dk/db"
+	"dk/utils"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ErrResidencyViolation is returned when collection data would be written to a destination
+// outside the region its collection is restricted to
+var ErrResidencyViolation = errors.New("data residency violation")
+
+// ExportDestination is a place collection data is written to outside the node, such as a
+// bucket of the blob store or a backup
+type ExportDestination struct {
+	Collection string
+	Target     string // Identifies the destination in the audit log, e.g. "s3://bucket/prefix"
+	Region     string // Region the destination is in; empty when it is not declared
+}
+
+type residencyOverrideKey struct{}
+
+// WithResidencyOverride returns a context in which writes of collection data that violate a
+// residency constraint go ahead, each audited with the reason. An empty reason overrides
+// nothing.
+func WithResidencyOverride(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, residencyOverrideKey{}, strings.TrimSpace(reason))
+}
+
+// residencyOverrideFromContext returns the reason of the override of the context, if any
+func residencyOverrideFromContext(ctx context.Context) string {
+	reason, _ := ctx.Value(residencyOverrideKey{}).(string)
+	return reason
+}
+
+// contextCollectionName returns the name of the collection of the context
+func contextCollectionName(ctx context.Context) string {
+	if collection, err := utils.ChromemCollectionFromContext(ctx); err == nil && collection != nil {
+		return collection.Name
+	}
+	return DefaultCollection
+}
+
+// CollectionResidency returns the residency constraint of a collection, or nil if it has none
+func CollectionResidency(database *sql.DB, collectionName string) (*db.CollectionResidency, error) {
+	if collectionName == "" {
+		return nil, nil
+	}
+	residency, err := db.GetCollectionResidency(database, collectionName)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, nil
+	}
+	return residency, err
+}
+
+// CheckExportResidency verifies that the data of a collection may be written to a destination.
+// Destinations without a declared region cannot be shown to comply. A violation is returned as
+// an error wrapping ErrResidencyViolation, unless overrideReason is set: the override is then
+// audited and returned.
+func CheckExportResidency(ctx context.Context, database *sql.DB, destination ExportDestination, overrideReason string) (*db.ResidencyOverride, error) {
+	residency, err := CollectionResidency(database, destination.Collection)
+	if err != nil || residency == nil {
+		return nil, err
+	}
+	if destination.Region != "" && db.RegionAllowed(residency.Region, destination.Region) {
+		return nil, nil
+	}
+
+	if strings.TrimSpace(overrideReason) == "" {
+		return nil, fmt.Errorf("%w: collection %s is restricted to region %s, %s is in %q",
+			ErrResidencyViolation, destination.Collection, residency.Region, destination.Target, destination.Region)
+	}
+	override := &db.ResidencyOverride{
+		CollectionName: destination.Collection,
+		RequiredRegion: residency.Region,
+		TargetType:     "export",
+		TargetID:       destination.Target,
+		TargetRegion:   destination.Region,
+		Reason:         overrideReason,
+	}
+	if err := AuditResidencyOverride(ctx, database, override); err != nil {
+		return nil, err
+	}
+	return override, nil
+}
+
+// ConsumerResidencyViolation is a grant of access to an API that would expose documents of a
+// collection to a consumer flagged outside the region the collection is restricted to
+type ConsumerResidencyViolation struct {
+	Collection     string
+	RequiredRegion string
+	APIID          string
+	Consumer       string
+	ConsumerRegion string
+}
+
+func (v *ConsumerResidencyViolation) String() string {
+	return fmt.Sprintf("API '%s' exposes documents restricted to region %s; '%s' is flagged in %s",
+		v.APIID, v.RequiredRegion, v.Consumer, v.ConsumerRegion)
+}
+
+// CheckConsumerResidency verifies that granting a consumer access to an API does not expose
+// documents of the collection of the context outside its region. Consumers without a flagged
+// region are allowed. A violation is returned unless overrideReason is set: the override is
+// then audited.
+func CheckConsumerResidency(ctx context.Context, database *sql.DB, apiID, userID, overrideReason string) (*ConsumerResidencyViolation, error) {
+	collection, err := utils.ChromemCollectionFromContext(ctx)
+	if err != nil || collection == nil {
+		return nil, nil
+	}
+	residency, err := CollectionResidency(database, collection.Name)
+	if err != nil || residency == nil {
+		return nil, err
+	}
+
+	documents, err := db.GetAPIDocuments(database, apiID)
+	if err != nil || len(documents) == 0 {
+		return nil, err
+	}
+	consumer, err := db.GetConsumerRegion(database, userID)
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if db.RegionAllowed(residency.Region, consumer.Region) {
+		return nil, nil
+	}
+
+	if strings.TrimSpace(overrideReason) != "" {
+		return nil, AuditResidencyOverride(ctx, database, &db.ResidencyOverride{
+			CollectionName: collection.Name,
+			RequiredRegion: residency.Region,
+			TargetType:     "api",
+			TargetID:       apiID,
+			TargetRegion:   consumer.Region,
+			Reason:         overrideReason,
+		})
+	}
+	return &ConsumerResidencyViolation{
+		Collection:     collection.Name,
+		RequiredRegion: residency.Region,
+		APIID:          apiID,
+		Consumer:       userID,
+		ConsumerRegion: consumer.Region,
+	}, nil
+}
+
+// AuditResidencyOverride records an override of a residency constraint, confirmed by the user
+// of the context
+func AuditResidencyOverride(ctx context.Context, database *sql.DB, override *db.ResidencyOverride) error {
+	userID, err := utils.UserIDFromContext(ctx)
+	if err != nil {
+		// For development/testing - in production, should return an error
+		userID = "local-user"
+	}
+	override.ConfirmedBy = userID
+
+	if err := db.CreateResidencyOverride(database, override); err != nil {
+		return err
+	}
+
+	log.Printf("[Residency] Override %s confirmed by %s for %s %s: %s",
+		override.ID, override.ConfirmedBy, override.TargetType, override.TargetID, override.Reason)
+	return nil
+}
@@ -0,0 +1,141 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"errors"
+	"github.com/philippgille/chromem-go"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBlobStoreResidency(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if err := db.RunAPIMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run API migrations: %v", err)
+	}
+	if err := db.SetCollectionResidency(testDB.DB, &db.CollectionResidency{CollectionName: DefaultCollection, Region: "eu"}); err != nil {
+		t.Fatalf("SetCollectionResidency failed: %v", err)
+	}
+
+	puts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			puts++
+		}
+	}))
+	defer server.Close()
+	newCtx := func(region string) context.Context {
+		config := BlobStoreConfig{
+			Backend:         BlobBackendS3,
+			Endpoint:        server.URL,
+			Region:          "us-east-1",
+			Bucket:          "documents",
+			AccessKeyID:     "minio",
+			SecretAccessKey: "minio-secret",
+			PathStyle:       true,
+			ResidencyRegion: region,
+		}
+		store, err := NewBlobStore(config)
+		if err != nil {
+			t.Fatalf("NewBlobStore failed: %v", err)
+		}
+		return WithBlobStore(utils.WithDatabase(context.Background(), testDB.DB), store, config)
+	}
+
+	// Buckets outside the region of the collection, or in no declared region, are refused
+	for _, region := range []string{"US", ""} {
+		if err := StoreDocumentBlob(newCtx(region), "notes.txt", []byte("notes"), "text/plain"); !errors.Is(err, ErrResidencyViolation) {
+			t.Errorf("Expected a bucket in region %q to be refused, got %v", region, err)
+		}
+	}
+	if puts != 0 {
+		t.Fatalf("Expected nothing written to the bucket, got %d writes", puts)
+	}
+
+	// An override lets the write go ahead and is audited
+	overridden := WithResidencyOverride(newCtx("US"), "Migration approved by the DPO")
+	if err := StoreDocumentBlob(overridden, "notes.txt", []byte("notes"), "text/plain"); err != nil || puts != 1 {
+		t.Fatalf("Expected the overridden write to go ahead, got %v", err)
+	}
+	overrides, total, err := db.ListResidencyOverrides(testDB.DB, 10, 0)
+	if err != nil || total != 1 || overrides[0].TargetType != "export" || overrides[0].TargetID != "s3://documents" {
+		t.Errorf("Expected the override to be audited, got %+v (%v)", overrides, err)
+	}
+
+	if err := StoreDocumentBlob(newCtx("EU"), "report.txt", []byte("report"), "text/plain"); err != nil || puts != 2 {
+		t.Errorf("Expected a bucket in the region to be accepted, got %v", err)
+	}
+}
+
+func TestCheckConsumerResidency(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if err := db.RunAPIMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run API migrations: %v", err)
+	}
+
+	api := &db.API{Name: "Reports", HostUserID: "host"}
+	if err := db.CreateAPI(testDB.DB, api); err != nil {
+		t.Fatalf("CreateAPI failed: %v", err)
+	}
+	if err := db.SetCollectionResidency(testDB.DB, &db.CollectionResidency{CollectionName: "reports", Region: "eu"}); err != nil {
+		t.Fatalf("SetCollectionResidency failed: %v", err)
+	}
+	for user, region := range map[string]string{"alice": "eu", "bob": "us"} {
+		if err := db.SetConsumerRegion(testDB.DB, &db.ConsumerRegion{ExternalUserID: user, Region: region}); err != nil {
+			t.Fatalf("SetConsumerRegion failed: %v", err)
+		}
+	}
+	collection, err := chromem.NewDB().CreateCollection("reports", nil, letterEmbedding)
+	if err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	ctx := utils.WithChromemCollection(context.Background(), collection)
+
+	// APIs without documents expose nothing
+	if violation, err := CheckConsumerResidency(ctx, testDB.DB, api.ID, "bob", ""); err != nil || violation != nil {
+		t.Fatalf("Expected an API without documents to be allowed, got %+v (%v)", violation, err)
+	}
+	if err := db.CreateDocumentAssociation(testDB.DB, &db.DocumentAssociation{DocumentFilename: "q3.txt", EntityID: api.ID, EntityType: "api"}); err != nil {
+		t.Fatalf("CreateDocumentAssociation failed: %v", err)
+	}
+
+	// Only consumers flagged in another region are refused
+	for _, user := range []string{"alice", "carol"} {
+		if violation, err := CheckConsumerResidency(ctx, testDB.DB, api.ID, user, ""); err != nil || violation != nil {
+			t.Errorf("Expected %s to be allowed, got %+v (%v)", user, violation, err)
+		}
+	}
+	violation, err := CheckConsumerResidency(ctx, testDB.DB, api.ID, "bob", "")
+	if err != nil || violation == nil || violation.Collection != "reports" || violation.RequiredRegion != "EU" || violation.ConsumerRegion != "US" {
+		t.Fatalf("Expected bob to be refused, got %+v (%v)", violation, err)
+	}
+	if violation, err := CheckConsumerResidency(context.Background(), testDB.DB, api.ID, "bob", ""); err != nil || violation != nil {
+		t.Errorf("Expected no check without a collection, got %+v (%v)", violation, err)
+	}
+
+	// An override lets the grant go ahead and is audited
+	if violation, err := CheckConsumerResidency(ctx, testDB.DB, api.ID, "bob", "Contract with a US subsidiary"); err != nil || violation != nil {
+		t.Fatalf("Expected the override to be accepted, got %+v (%v)", violation, err)
+	}
+	overrides, total, err := db.ListResidencyOverrides(testDB.DB, 10, 0)
+	if err != nil || total != 1 || overrides[0].TargetType != "api" || overrides[0].TargetID != api.ID || overrides[0].TargetRegion != "US" {
+		t.Errorf("Expected the override to be audited, got %+v (%v)", overrides, err)
+	}
+}
@@ -0,0 +1,188 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	dk_client "dk/client"
+	"dk/db"
+	"dk/utils"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Roles of the users of a node. The host owns the node and may do anything; the other roles
+// are delegated by the host.
+const (
+	RoleHost    = "host"
+	RoleCurator = "curator" // Drafts and reviews answers to incoming queries
+)
+
+// ErrForbidden is returned when the role of the caller does not permit an operation
+var ErrForbidden = errors.New("not permitted for this role")
+
+// ErrInvalidRoleToken is returned for access tokens that are unknown or were revoked
+var ErrInvalidRoleToken = errors.New("invalid or revoked access token")
+
+// ErrAuthorizationRequired is returned for requests without an access token when the host
+// requires one
+var ErrAuthorizationRequired = errors.New("Authorization required")
+
+// curatorTools are the MCP tools a curator may call: reading, drafting and accepting or
+// rejecting the answers to incoming queries, and choosing the language of the session. Policies, API requests, keys and the knowledge
+// base stay with the host.
+var curatorTools = map[string]bool{
+	"cqListRequestedQueries": true,
+	"cqUpdateEditAnswer":     true,
+	"cqProcessQuery":         true,
+	"cqProcessQueries":       true,
+	"cqSummarizeAnswers":     true,
+	"cqSetLanguage":          true,
+}
+
+// Principal is the user on whose behalf a request is made
+type Principal struct {
+	UserID string `json:"user_id,omitempty"`
+	Role   string `json:"role"`
+}
+
+// ToolAllowed reports whether the principal may call an MCP tool
+func (p Principal) ToolAllowed(tool string) bool {
+	return p.Role == RoleHost || (p.Role == RoleCurator && curatorTools[tool])
+}
+
+type principalKey struct{}
+
+// WithPrincipal adds the principal of a request to the context
+func WithPrincipal(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal of the context. Requests that were not
+// authenticated as a delegated role are made by the host.
+func PrincipalFromContext(ctx context.Context) Principal {
+	if principal, ok := ctx.Value(principalKey{}).(Principal); ok {
+		return principal
+	}
+	return Principal{Role: RoleHost}
+}
+
+// hashRoleToken returns the stored form of an access token
+func hashRoleToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AssignRole delegates a role to a user and returns the user's new access token. The token is
+// only returned here; assigning the role again issues a new one and invalidates the old.
+func AssignRole(ctx context.Context, database *sql.DB, userID, role string) (string, error) {
+	if userID == "" {
+		return "", errors.New("user_id is required")
+	}
+	if role != RoleCurator {
+		return "", fmt.Errorf("unknown role %q; only %q can be assigned", role, RoleCurator)
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	token := hex.EncodeToString(raw)
+	if err := db.AssignRole(ctx, database, userID, role, hashRoleToken(token)); err != nil {
+		return "", err
+	}
+	log.Printf("[Roles] Assigned role %s to %s", role, userID)
+	return token, nil
+}
+
+// AuthenticateRole returns the principal an access token was issued to
+func AuthenticateRole(ctx context.Context, database *sql.DB, token string) (Principal, error) {
+	assignment, err := db.GetRoleAssignmentByToken(ctx, database, hashRoleToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Principal{}, ErrInvalidRoleToken
+	}
+	if err != nil {
+		return Principal{}, err
+	}
+	return Principal{UserID: assignment.UserID, Role: assignment.Role}, nil
+}
+
+// AuthenticateBearer returns the principal of a request from its Authorization header. A
+// "Bearer" token is either hostToken or the access token of a delegated role. Requests without
+// a token act as the host, unless hostToken is set.
+func AuthenticateBearer(ctx context.Context, database *sql.DB, authorization, hostToken string) (Principal, error) {
+	token, hasToken := strings.CutPrefix(authorization, "Bearer ")
+	switch {
+	case hasToken && hostToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(hostToken)) == 1:
+	case hasToken:
+		return AuthenticateRole(ctx, database, token)
+	case hostToken != "":
+		return Principal{}, ErrAuthorizationRequired
+	}
+	return Principal{Role: RoleHost}, nil
+}
+
+// UpdateQueryAnswer replaces the drafted answer of an incoming query. It returns sql.ErrNoRows
+// if there is no such query.
+func UpdateQueryAnswer(ctx context.Context, id, answer string) error {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	if err := db.UpdateQueryAnswer(ctx, database, id, answer); err != nil {
+		return err
+	}
+	notifyChange(QueriesChanged)
+	return nil
+}
+
+// ReviewQuery accepts or rejects the drafted answer to an incoming query. An accepted answer
+// is sent to the peer that asked. It returns sql.ErrNoRows if there is no such query.
+func ReviewQuery(ctx context.Context, id string, approve bool) (db.Query, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return db.Query{}, err
+	}
+	status := "accepted"
+	if !approve {
+		status = "rejected"
+	}
+	if err := db.UpdateQueryStatus(ctx, database, id, status); err != nil {
+		return db.Query{}, err
+	}
+	notifyChange(QueriesChanged)
+	query, err := db.GetQuery(ctx, database, id)
+	if err != nil {
+		return query, err
+	}
+	if principal := PrincipalFromContext(ctx); principal.Role != RoleHost {
+		log.Printf("[Roles] Query %s %s by %s %s", id, status, principal.Role, principal.UserID)
+	}
+	if !approve {
+		return query, nil
+	}
+
+	dkClient, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return query, err
+	}
+	content, err := utils.EncodePayload(&utils.AnswerPayload{Query: query.Question, Answer: query.Answer, From: dkClient.UserID})
+	if err != nil {
+		return query, err
+	}
+	err = dkClient.SendMessage(dk_client.Message{
+		From:      dkClient.UserID,
+		To:        query.From,
+		Content:   content,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return query, fmt.Errorf("send answer: %w", err)
+	}
+	return query, nil
+}
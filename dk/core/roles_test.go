@@ -0,0 +1,90 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"errors"
+	"testing"
+)
+
+func TestCuratorRole(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := context.Background()
+
+	first, err := AssignRole(ctx, testDB.DB, "carol", RoleCurator)
+	if err != nil {
+		t.Fatalf("AssignRole failed: %v", err)
+	}
+	token, err := AssignRole(ctx, testDB.DB, "carol", RoleCurator)
+	if err != nil {
+		t.Fatalf("AssignRole failed: %v", err)
+	}
+	if _, err := AuthenticateRole(ctx, testDB.DB, first); !errors.Is(err, ErrInvalidRoleToken) {
+		t.Errorf("Expected reassigning to invalidate the previous token, got %v", err)
+	}
+	principal, err := AuthenticateRole(ctx, testDB.DB, token)
+	if err != nil || principal != (Principal{UserID: "carol", Role: RoleCurator}) {
+		t.Fatalf("Expected carol as curator, got %+v (%v)", principal, err)
+	}
+
+	// Curators may review answers but not manage policies, API requests or keys
+	for tool, allowed := range map[string]bool{
+		"cqListRequestedQueries":      true,
+		"cqProcessQuery":              true,
+		"cqProcessQueries":            true,
+		"cqUpdateEditAnswer":          true,
+		"cqAddAutoApprovalCondition":  false,
+		"cqProcessApplicationRequest": false,
+		"cqDistributeKeyShares":       false,
+	} {
+		if principal.ToolAllowed(tool) != allowed {
+			t.Errorf("Expected ToolAllowed(%s) to be %v for a curator", tool, allowed)
+		}
+	}
+	if host := PrincipalFromContext(ctx); host.Role != RoleHost || !host.ToolAllowed("cqDistributeKeyShares") {
+		t.Errorf("Expected requests without a principal to act as the host, got %+v", host)
+	}
+}
+
+func TestAuthenticateBearer(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := context.Background()
+	token, err := AssignRole(ctx, testDB.DB, "carol", RoleCurator)
+	if err != nil {
+		t.Fatalf("AssignRole failed: %v", err)
+	}
+
+	host := Principal{Role: RoleHost}
+	curator := Principal{UserID: "carol", Role: RoleCurator}
+	for _, tc := range []struct {
+		authorization, hostToken string
+		want                     Principal
+		err                      error
+	}{
+		{"", "", host, nil},
+		{"", "secret", Principal{}, ErrAuthorizationRequired},
+		{"Bearer secret", "secret", host, nil},
+		{"Bearer " + token, "secret", curator, nil},
+		{"Bearer " + token, "", curator, nil},
+		{"Bearer wrong", "secret", Principal{}, ErrInvalidRoleToken},
+	} {
+		principal, err := AuthenticateBearer(ctx, testDB.DB, tc.authorization, tc.hostToken)
+		if !errors.Is(err, tc.err) || principal != tc.want {
+			t.Errorf("AuthenticateBearer(%q, %q) = %+v, %v; want %+v, %v", tc.authorization, tc.hostToken, principal, err, tc.want, tc.err)
+		}
+	}
+}
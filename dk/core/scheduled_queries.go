@@ -0,0 +1,161 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	dk_client "dk/client"
+	"dk/db"
+	"dk/utils"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ScheduleQuery registers a question to send to peers, or to broadcast when peers is empty.
+// A cron expression sends it on a recurring schedule, in the local time zone of the node;
+// otherwise it is sent once at the given time.
+func ScheduleQuery(ctx context.Context, question string, peers []string, schedule string, at time.Time) (*db.ScheduledQuery, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	question = strings.TrimSpace(question)
+	if question == "" {
+		return nil, errors.New("the question is empty")
+	}
+
+	now := time.Now()
+	var next time.Time
+	switch schedule = strings.TrimSpace(schedule); {
+	case schedule != "" && !at.IsZero():
+		return nil, errors.New("give either a schedule or a time, not both")
+	case schedule != "":
+		cron, err := ParseCron(schedule)
+		if err != nil {
+			return nil, err
+		}
+		if next = cron.Next(now); next.IsZero() {
+			return nil, fmt.Errorf("schedule %q never runs", schedule)
+		}
+	case at.IsZero():
+		return nil, errors.New("give a schedule or a time to send the question at")
+	case !at.After(now):
+		return nil, fmt.Errorf("%s is in the past", at.Format(time.RFC3339))
+	default:
+		next = at
+	}
+
+	q := db.ScheduledQuery{
+		ID:        "sch-" + uuid.New().String()[:8],
+		Question:  question,
+		Peers:     peers,
+		Schedule:  schedule,
+		NextRun:   &next,
+		Active:    true,
+		CreatedAt: now,
+	}
+	if err := db.InsertScheduledQuery(ctx, database, q); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// sendQuery sends a question to peers, or broadcasts it when peers is empty
+func sendQuery(ctx context.Context, question string, peers []string) error {
+	client, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	content, err := utils.EncodePayload(&utils.QueryPayload{Question: question})
+	if err != nil {
+		return err
+	}
+	if len(peers) == 0 {
+		return client.BroadcastMessage(content)
+	}
+	var failed []string
+	for _, peer := range peers {
+		if err := client.SendMessage(dk_client.Message{
+			From:      client.UserID,
+			To:        peer,
+			Content:   content,
+			Timestamp: time.Now(),
+		}); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", peer, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to send to %s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// runDueScheduledQueries sends the scheduled queries due at now and moves each to its next
+// run. Runs missed while the node was offline are sent once, not once per missed run.
+func runDueScheduledQueries(ctx context.Context, database *sql.DB, now time.Time, send func(question string, peers []string) error) error {
+	due, err := db.DueScheduledQueries(ctx, database, now)
+	if err != nil {
+		return err
+	}
+	for _, q := range due {
+		var next *time.Time
+		if q.Schedule != "" {
+			if cron, err := ParseCron(q.Schedule); err != nil {
+				log.Printf("[Scheduler] Ending scheduled query %s: %v", q.ID, err)
+			} else if t := cron.Next(now); !t.IsZero() {
+				next = &t
+			}
+		}
+
+		sendErr := send(q.Question, q.Peers)
+		if sendErr != nil {
+			log.Printf("[Scheduler] Failed to send scheduled query %s: %v", q.ID, sendErr)
+		} else {
+			log.Printf("[Scheduler] Sent scheduled query %s: %s", q.ID, q.Question)
+		}
+		if err := db.RecordScheduledQueryRun(ctx, database, q.ID, now, sendErr, next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunDueScheduledQueries sends the scheduled queries that are due. While the connection to the
+// server is lost they stay due, and are sent once it is back.
+func RunDueScheduledQueries(ctx context.Context) error {
+	if offline.Load() {
+		return nil
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return err
+	}
+	return runDueScheduledQueries(ctx, database, time.Now(), func(question string, peers []string) error {
+		return sendQuery(ctx, question, peers)
+	})
+}
+
+// StartQueryScheduler begins a background worker that sends scheduled queries when they are due
+func StartQueryScheduler(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := RunDueScheduledQueries(ctx); err != nil {
+					log.Printf("Error running scheduled queries: %v", err)
+				}
+			}
+		}
+	}()
+
+	log.Printf("Query scheduler started with interval of %v", interval)
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScheduledQueries(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := utils.WithDatabase(context.Background(), testDB.DB)
+
+	recurring, err := ScheduleQuery(ctx, "How many open tickets?", []string{"bob", "carol"}, "0 9 * * *", time.Time{})
+	if err != nil {
+		t.Fatalf("ScheduleQuery failed: %v", err)
+	}
+	once, err := ScheduleQuery(ctx, "Is the release ready?", nil, "", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ScheduleQuery failed: %v", err)
+	}
+	if _, err := ScheduleQuery(ctx, "Too late", nil, "", time.Now().Add(-time.Hour)); err == nil {
+		t.Error("Expected a time in the past to be refused")
+	}
+
+	// A day later both are due; the recurring one is sent once and moves to its next run
+	var sent []string
+	now := time.Now().Add(25 * time.Hour)
+	err = runDueScheduledQueries(ctx, testDB.DB, now, func(question string, peers []string) error {
+		sent = append(sent, question)
+		if peers == nil {
+			return errors.New("server unreachable")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("runDueScheduledQueries failed: %v", err)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("Expected both queries to be sent, got %v", sent)
+	}
+
+	got, _ := db.GetScheduledQuery(ctx, testDB.DB, recurring.ID)
+	if !got.Active || got.NextRun == nil || !got.NextRun.After(now) || got.LastRun == nil {
+		t.Errorf("Expected the recurring query to move to its next run, got %+v", got)
+	}
+	got, _ = db.GetScheduledQuery(ctx, testDB.DB, once.ID)
+	if got.Active || got.NextRun != nil {
+		t.Errorf("Expected the one-time query to end, got %+v", got)
+	}
+
+	// Answers are recorded with the run that was sent, not with failed runs
+	if ok, err := db.RecordScheduledQueryAnswer(ctx, testDB.DB, "How many open tickets?", "bob", "Twelve"); !ok || err != nil {
+		t.Errorf("Expected the answer to be recorded, got %v, %v", ok, err)
+	}
+	if ok, _ := db.RecordScheduledQueryAnswer(ctx, testDB.DB, "Is the release ready?", "bob", "Yes"); ok {
+		t.Error("Expected no run to record an answer to a query that failed to send")
+	}
+	runs, err := db.ListScheduledQueryRuns(ctx, testDB.DB, recurring.ID, 10)
+	if err != nil {
+		t.Fatalf("ListScheduledQueryRuns failed: %v", err)
+	}
+	if len(runs) != 1 || len(runs[0].Answers) != 1 || runs[0].Answers[0].Answer != "Twelve" {
+		t.Errorf("Unexpected runs: %+v", runs)
+	}
+	runs, _ = db.ListScheduledQueryRuns(ctx, testDB.DB, once.ID, 10)
+	if len(runs) != 1 || runs[0].Error != "server unreachable" {
+		t.Errorf("Expected the failed run to be recorded, got %+v", runs)
+	}
+
+	if err := db.CancelScheduledQuery(ctx, testDB.DB, recurring.ID); err != nil {
+		t.Fatalf("CancelScheduledQuery failed: %v", err)
+	}
+	if active, _ := db.ListScheduledQueries(ctx, testDB.DB, true); len(active) != 0 {
+		t.Errorf("Expected no active scheduled queries, got %+v", active)
+	}
+}
+
+func TestScheduledQueriesPausedOffline(t *testing.T) {
+	hooks := ConnectionHooks()
+	defer hooks.OnConnect("")
+
+	// Without a database, a run fails as soon as it looks for due queries
+	hooks.OnDisconnect(errors.New("connection reset"))
+	if err := RunDueScheduledQueries(context.Background()); err != nil {
+		t.Errorf("Expected no run while offline, got %v", err)
+	}
+	hooks.OnConnect("wss://example.org")
+	if err := RunDueScheduledQueries(context.Background()); err == nil {
+		t.Error("Expected runs to resume once connected")
+	}
+}
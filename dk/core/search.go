@@ -0,0 +1,69 @@
+package core
+
+import (
+	"context"
+	"dk/utils"
+	"fmt"
+	"strings"
+)
+
+// SearchDocuments runs a similarity query against the collection of the context and returns
+// the closest chunks with their scores. Unlike RetrieveDocuments it neither fuses keyword
+// results nor routes to other collections, so scores are plain cosine similarities.
+func SearchDocuments(ctx context.Context, query string, numResults int, filter MetadataFilter) ([]Document, error) {
+	RecordFeature(ctx, TelemetryRAG, "search")
+	collection, err := utils.ChromemCollectionFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	where := map[string]string{"active": "true"}
+	contextFilter := MetadataFilterFromContext(ctx)
+	if !filter.mergeWhere(where) || !contextFilter.mergeWhere(where) {
+		return []Document{}, nil
+	}
+
+	// Dates, allowed files and deleted documents are checked after the query
+	allowedFiles := allowedFilesFromContext(ctx)
+	limit := numResults * hybridCandidateFactor
+	if filter.hasDateRange() || contextFilter.hasDateRange() || allowedFiles != nil {
+		limit = collection.Count()
+	}
+	limit = min(limit, collection.Count())
+	if limit <= 0 {
+		return []Document{}, nil
+	}
+
+	// Same query prefix as the documents were embedded with, see retrieveFromCollection
+	results, err := collection.Query(ctx, "search_query: "+query, limit, where, nil)
+	if err != nil {
+		if strings.Contains(err.Error(), "nResults must be <= number of documents") {
+			return []Document{}, nil
+		}
+		return nil, fmt.Errorf("error querying collection: %w", err)
+	}
+
+	docs := []Document{}
+	for _, res := range results {
+		if res.Metadata["is_deleted"] == "true" || !filter.matchesDate(res.Metadata) ||
+			!contextFilter.matchesDate(res.Metadata) || !fileAllowed(allowedFiles, res.Metadata["file"]) {
+			continue
+		}
+		metadata := make(map[string]string)
+		for key, value := range res.Metadata {
+			if key != "file" && !strings.HasPrefix(key, tagKeyPrefix) {
+				metadata[key] = value
+			}
+		}
+		docs = append(docs, Document{
+			FileName: res.Metadata["file"],
+			Content:  strings.TrimPrefix(res.Content, "search_document: "),
+			Metadata: metadata,
+			Score:    res.Similarity,
+		})
+		if len(docs) == numResults {
+			break
+		}
+	}
+	return docs, nil
+}
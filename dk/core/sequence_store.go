@@ -0,0 +1,26 @@
+package core
+
+import (
+	"context"
+	"database/sql"
+	dk_client "dk/client"
+	"dk/db"
+)
+
+// sequenceStore keeps the latest sequence number received from each peer in the database
+type sequenceStore struct {
+	db *sql.DB
+}
+
+// NewSequenceStore returns a sequence store for the client backed by the peer_sequences table
+func NewSequenceStore(database *sql.DB) dk_client.SequenceStore {
+	return sequenceStore{db: database}
+}
+
+func (s sequenceStore) SaveSequence(sender string, sequence uint64) error {
+	return db.SavePeerSequence(context.Background(), s.db, sender, sequence)
+}
+
+func (s sequenceStore) Sequence(sender string) (uint64, bool, error) {
+	return db.GetPeerSequence(context.Background(), s.db, sender)
+}
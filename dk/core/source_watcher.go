@@ -0,0 +1,504 @@
+package core
+
+import (
+	"context"
+	"dk/utils"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ingestion status of a RAG source
+const (
+	SourcePending = "pending" // Not ingested since the watcher started
+	SourceIndexed = "indexed" // Stored in the vector database
+	SourceFailed  = "failed"  // The last ingestion failed; a previously indexed version is kept
+)
+
+// SourceWatcher keeps the vector store in sync with the RAG sources file and a documents
+// directory. Entries that were added, changed or removed since the last sync are embedded,
+// re-embedded or deleted; unchanged ones are left alone.
+type SourceWatcher struct {
+	SourcesFile        string        // RAG sources JSONL file
+	DocumentsDir       string        // Directory whose files are indexed into the default collection; optional
+	Interval           time.Duration // How often the sources are checked for changes
+	UpdateDescriptions bool          // Regenerate the user descriptions for added documents
+
+	mu         sync.Mutex
+	known      map[sourceDocument]knownSource
+	status     map[sourceDocument]*sourceStatus
+	lastSeen   string // Signature of the sources at the previous check
+	lastSynced string // Signature of the sources at the last successful sync
+}
+
+// sourceDocument identifies a file-based document
+type sourceDocument struct {
+	collection string
+	file       string
+}
+
+// newSourceDocument identifies a document; an empty collection is the default collection
+func newSourceDocument(collection, file string) sourceDocument {
+	if collection == "" {
+		collection = DefaultCollection
+	}
+	return sourceDocument{collection: collection, file: file}
+}
+
+type knownSource struct {
+	source string // sourceRagSources or sourceDocumentsDir
+	hash   string // Empty for documents indexed before source hashes were stored
+}
+
+type sourceStatus struct {
+	err        error
+	ingestedAt time.Time // Zero for documents indexed before the watcher started
+}
+
+// desiredSource is a document the sources currently describe. When err is set the source
+// could not be read, in which case the indexed document is kept as it is.
+type desiredSource struct {
+	source string
+	raw    []byte
+	err    error
+	hash   string
+	tags   []string
+	owner  string
+}
+
+// SyncStats counts the documents changed by a sync
+type SyncStats struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	Removed int `json:"removed"`
+}
+
+// RagSourceStatus is an entry of the RAG sources file with its ingestion status. The text of
+// inline entries is left out; TextLength gives its size.
+type RagSourceStatus struct {
+	RagSource
+	TextLength int        `json:"text_length,omitempty"`
+	Status     string     `json:"status"`
+	LastError  string     `json:"last_error,omitempty"`
+	IngestedAt *time.Time `json:"ingested_at,omitempty"`
+}
+
+// NewSourceWatcher creates a watcher for the RAG sources file and an optional documents directory
+func NewSourceWatcher(sourcesFile, documentsDir string, interval time.Duration) *SourceWatcher {
+	return &SourceWatcher{
+		SourcesFile:  sourcesFile,
+		DocumentsDir: documentsDir,
+		Interval:     interval,
+	}
+}
+
+// Start checks the sources every interval until ctx is done. Changes are synced once the
+// sources have not changed for a whole interval, so files still being written are not indexed.
+func (w *SourceWatcher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(w.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("[RAG] Source watcher shutting down")
+				return
+			case <-ticker.C:
+				signature := w.signature()
+				if signature != w.lastSeen {
+					w.lastSeen = signature
+					continue
+				}
+				if signature == w.lastSynced {
+					continue
+				}
+				stats, err := w.Sync(ctx)
+				if err != nil {
+					log.Printf("[RAG] Source sync incomplete: %v", err)
+					continue
+				}
+				w.lastSynced = signature
+				log.Printf("[RAG] Synced sources: %d added, %d updated, %d removed", stats.Added, stats.Updated, stats.Removed)
+			}
+		}
+	}()
+
+	log.Printf("[RAG] Source watcher started for %s (documents directory: %q, interval: %v)", w.SourcesFile, w.DocumentsDir, w.Interval)
+}
+
+// Sync adds, updates and removes the documents whose sources changed since the last sync. The
+// first sync loads the file-based documents already in the vector store. When the sources file
+// cannot be read its documents are kept, so a file being replaced does not empty the store.
+func (w *SourceWatcher) Sync(ctx context.Context) (SyncStats, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sync(ctx, nil)
+}
+
+// sync implements Sync. The document force, if given, is re-embedded even if its source did
+// not change.
+func (w *SourceWatcher) sync(ctx context.Context, force *sourceDocument) (SyncStats, error) {
+	var stats SyncStats
+	if w.known == nil {
+		known, err := loadSourceDocuments(ctx)
+		if err != nil {
+			return stats, fmt.Errorf("failed to load indexed sources: %w", err)
+		}
+		w.known = known
+		w.status = make(map[sourceDocument]*sourceStatus, len(known))
+		for key := range known {
+			w.status[key] = &sourceStatus{}
+		}
+	}
+
+	desired, readable := w.desiredSources()
+	var errs []error
+	for key, want := range desired {
+		have, ok := w.known[key]
+		switch {
+		case want.err != nil:
+			w.setStatus(key, want.err)
+			continue
+		case force != nil && key == *force:
+		case ok && have.hash == "":
+			// Documents indexed before hashes were stored are assumed to be current
+			w.known[key] = knownSource{source: want.source, hash: want.hash}
+			continue
+		case ok && have.hash == want.hash:
+			continue
+		}
+
+		if err := w.ingest(ctx, key, want, ok); err != nil {
+			w.setStatus(key, err)
+			errs = append(errs, fmt.Errorf("%s: %w", key.file, err))
+			continue
+		}
+		w.setStatus(key, nil)
+		if ok {
+			stats.Updated++
+		} else {
+			stats.Added++
+		}
+	}
+
+	for key, have := range w.known {
+		if _, ok := desired[key]; ok || !readable[have.source] {
+			continue
+		}
+		collectionCtx, err := UseCollection(ctx, key.collection, false)
+		if err == nil {
+			err = RemoveDocument(collectionCtx, key.file)
+		}
+		if err != nil && !errors.Is(err, ErrCollectionNotFound) {
+			errs = append(errs, fmt.Errorf("%s: %w", key.file, err))
+			continue
+		}
+		delete(w.known, key)
+		delete(w.status, key)
+		stats.Removed++
+	}
+	return stats, errors.Join(errs...)
+}
+
+// ingest embeds a source, replacing the document indexed before if there is one
+func (w *SourceWatcher) ingest(ctx context.Context, key sourceDocument, want desiredSource, replace bool) error {
+	collectionCtx, err := UseCollection(ctx, key.collection, true)
+	if err != nil {
+		return err
+	}
+	// No other write to the collection comes between removing the previous document and adding
+	// the new one
+	collection, err := utils.ChromemCollectionFromContext(collectionCtx)
+	if err != nil {
+		return err
+	}
+	collectionCtx, unlock := lockCollection(collectionCtx, collection)
+	defer unlock()
+	if replace {
+		if err := RemoveDocument(collectionCtx, key.file); err != nil {
+			return err
+		}
+		delete(w.known, key)
+	}
+	metadata := map[string]string{
+		sourceKey:     want.source,
+		sourceHashKey: want.hash,
+		ownerKey:      want.owner,
+		tagsKey:       strings.Join(want.tags, ","),
+	}
+	if err := AddDocument(collectionCtx, key.file, string(want.raw), w.UpdateDescriptions, metadata); err != nil {
+		return err
+	}
+	w.known[key] = knownSource{source: want.source, hash: want.hash}
+	return nil
+}
+
+// setStatus records the outcome of ingesting a source; err is nil on success
+func (w *SourceWatcher) setStatus(key sourceDocument, err error) {
+	status, ok := w.status[key]
+	if !ok {
+		status = &sourceStatus{}
+		w.status[key] = status
+	}
+	status.err = err
+	if err == nil {
+		status.ingestedAt = time.Now()
+	}
+}
+
+// Sources lists the entries of the RAG sources file with their ingestion status
+func (w *SourceWatcher) Sources() ([]RagSourceStatus, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	sources, err := ReadRagSources(w.SourcesFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []RagSourceStatus{}, nil
+		}
+		return nil, err
+	}
+	statuses := make([]RagSourceStatus, 0, len(sources))
+	for _, source := range sources {
+		statuses = append(statuses, w.sourceStatus(source))
+	}
+	return statuses, nil
+}
+
+// sourceStatus describes an entry of the RAG sources file
+func (w *SourceWatcher) sourceStatus(source RagSource) RagSourceStatus {
+	key := source.key()
+	status := RagSourceStatus{RagSource: source, TextLength: len(source.Text), Status: SourcePending}
+	status.Text = ""
+	if s, ok := w.status[key]; ok {
+		status.Status = SourceIndexed
+		if s.err != nil {
+			status.Status = SourceFailed
+			status.LastError = s.err.Error()
+		}
+		if !s.ingestedAt.IsZero() {
+			ingestedAt := s.ingestedAt
+			status.IngestedAt = &ingestedAt
+		}
+	}
+	return status
+}
+
+// AddSource adds an entry to the RAG sources file and ingests it
+func (w *SourceWatcher) AddSource(ctx context.Context, source RagSource) (RagSourceStatus, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := AddRagSource(w.SourcesFile, source); err != nil {
+		return RagSourceStatus{}, err
+	}
+	return w.syncSource(ctx, source, false), nil
+}
+
+// UpdateSource changes an entry of the RAG sources file and re-ingests it
+func (w *SourceWatcher) UpdateSource(ctx context.Context, collection, file string, update func(*RagSource)) (RagSourceStatus, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	source, err := UpdateRagSource(w.SourcesFile, collection, file, update)
+	if err != nil {
+		return RagSourceStatus{}, err
+	}
+	return w.syncSource(ctx, source, false), nil
+}
+
+// RemoveSource removes an entry from the RAG sources file and its document from the vector
+// database
+func (w *SourceWatcher) RemoveSource(ctx context.Context, collection, file string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := RemoveRagSource(w.SourcesFile, collection, file); err != nil {
+		return err
+	}
+	if _, err := w.sync(ctx, nil); err != nil {
+		log.Printf("[RAG] Source sync incomplete: %v", err)
+	}
+	return nil
+}
+
+// Reingest embeds an entry of the RAG sources file again, even if it did not change
+func (w *SourceWatcher) Reingest(ctx context.Context, collection, file string) (RagSourceStatus, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	sources, err := ReadRagSources(w.SourcesFile)
+	if err != nil {
+		return RagSourceStatus{}, err
+	}
+	key := newSourceDocument(collection, file)
+	for _, source := range sources {
+		if source.key() == key {
+			return w.syncSource(ctx, source, true), nil
+		}
+	}
+	return RagSourceStatus{}, fmt.Errorf("%w: %s", ErrRagSourceNotFound, file)
+}
+
+// syncSource syncs the sources after an entry changed and returns the status of the entry.
+// Failures of other entries are only logged.
+func (w *SourceWatcher) syncSource(ctx context.Context, source RagSource, force bool) RagSourceStatus {
+	key := source.key()
+	var forced *sourceDocument
+	if force {
+		forced = &key
+	}
+	if _, err := w.sync(ctx, forced); err != nil {
+		log.Printf("[RAG] Source sync incomplete: %v", err)
+	}
+	return w.sourceStatus(source)
+}
+
+// desiredSources returns the documents the sources describe, and which sources could be read
+func (w *SourceWatcher) desiredSources() (map[sourceDocument]desiredSource, map[string]bool) {
+	desired := make(map[sourceDocument]desiredSource)
+	readable := make(map[string]bool)
+
+	if sources, err := ReadRagSources(w.SourcesFile); err != nil {
+		log.Printf("[RAG] Not syncing %s: %v", w.SourcesFile, err)
+	} else {
+		readable[sourceRagSources] = true
+		for _, source := range sources {
+			raw, err := source.read()
+			if err != nil {
+				log.Printf("[RAG] Keeping source %s as indexed: %v", source.name(), err)
+			}
+			if source.FileName == "" {
+				source.FileName = source.name()
+			}
+			if source.FileName == "" {
+				continue
+			}
+			desired[source.key()] = desiredSource{
+				source: sourceRagSources,
+				raw:    raw,
+				err:    err,
+				hash:   sourceHash(raw, source.Tags, source.Owner),
+				tags:   source.Tags,
+				owner:  source.Owner,
+			}
+		}
+	}
+
+	if w.DocumentsDir == "" {
+		return desired, readable
+	}
+	dir, err := utils.ExpandHomePath(w.DocumentsDir)
+	if err != nil {
+		log.Printf("[RAG] Not syncing %s: %v", w.DocumentsDir, err)
+		return desired, readable
+	}
+	files, err := documentsDirFiles(dir)
+	if err != nil {
+		log.Printf("[RAG] Not syncing %s: %v", w.DocumentsDir, err)
+		return desired, readable
+	}
+	readable[sourceDocumentsDir] = true
+	for _, file := range files {
+		key := newSourceDocument("", file)
+		if _, ok := desired[key]; ok {
+			// The sources file takes precedence
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			log.Printf("[RAG] Keeping %s as indexed: %v", file, err)
+		}
+		desired[key] = desiredSource{source: sourceDocumentsDir, raw: raw, err: err, hash: sourceHash(raw, nil, "")}
+	}
+	return desired, readable
+}
+
+// signature summarizes the modification times and sizes of the watched files. Changes of URL
+// sources are not detected; they are re-downloaded when re-ingested.
+func (w *SourceWatcher) signature() string {
+	var b strings.Builder
+	stat := func(path string) {
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&b, "%s:%d:%d;", path, info.ModTime().UnixNano(), info.Size())
+		} else {
+			fmt.Fprintf(&b, "%s:-;", path)
+		}
+	}
+
+	stat(w.SourcesFile)
+	if sources, err := ReadRagSources(w.SourcesFile); err == nil {
+		for _, source := range sources {
+			if source.Text == "" && source.Path != "" {
+				if path, err := utils.ExpandHomePath(source.Path); err == nil {
+					stat(path)
+				}
+			}
+		}
+	}
+	if w.DocumentsDir != "" {
+		if dir, err := utils.ExpandHomePath(w.DocumentsDir); err == nil {
+			files, _ := documentsDirFiles(dir)
+			for _, file := range files {
+				stat(filepath.Join(dir, filepath.FromSlash(file)))
+			}
+		}
+	}
+	return b.String()
+}
+
+// loadSourceDocuments returns the file-based documents stored in every collection
+func loadSourceDocuments(ctx context.Context) (map[sourceDocument]knownSource, error) {
+	names := []string{DefaultCollection}
+	if collections := CollectionsFromContext(ctx); collections != nil {
+		names = names[:0]
+		for _, info := range collections.List() {
+			names = append(names, info.Name)
+		}
+	}
+
+	known := make(map[sourceDocument]knownSource)
+	for _, name := range names {
+		collectionCtx, err := UseCollection(ctx, name, false)
+		if err != nil {
+			return nil, err
+		}
+		collection, err := utils.ChromemCollectionFromContext(collectionCtx)
+		if err != nil {
+			return nil, err
+		}
+		count := collection.Count()
+		if count == 0 {
+			continue
+		}
+		for _, source := range []string{sourceRagSources, sourceDocumentsDir} {
+			results, err := collection.Query(collectionCtx, "search_query: _", count, map[string]string{sourceKey: source}, nil)
+			if err != nil {
+				return nil, fmt.Errorf("collection %s: %w", name, err)
+			}
+			for _, res := range results {
+				known[newSourceDocument(name, res.Metadata["file"])] = knownSource{source: source, hash: res.Metadata[sourceHashKey]}
+			}
+		}
+	}
+	return known, nil
+}
+
+type sourceWatcherKey struct{}
+
+// WithSourceWatcher adds the RAG source watcher to the context
+func WithSourceWatcher(ctx context.Context, watcher *SourceWatcher) context.Context {
+	return context.WithValue(ctx, sourceWatcherKey{}, watcher)
+}
+
+// SourceWatcherFromContext returns the RAG source watcher, or nil when there is none
+func SourceWatcherFromContext(ctx context.Context) *SourceWatcher {
+	watcher, _ := ctx.Value(sourceWatcherKey{}).(*SourceWatcher)
+	return watcher
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"context"
+	lib "dk/client"
+	"dk/utils"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSourceWatcherSync(t *testing.T) {
+	dir := t.TempDir()
+	sourcesFile := filepath.Join(dir, "rag_sources.jsonl")
+	docsDir := filepath.Join(dir, "docs")
+	if err := os.MkdirAll(filepath.Join(docsDir, ".hidden"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(path, content string) {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(sourcesFile, `{"text": "Apples grow on trees", "file": "apples.txt"}
+{"text": "Invoices are due monthly", "file": "invoices.txt", "collection": "finance"}
+`)
+	write(filepath.Join(docsDir, "notes.txt"), "Meeting notes")
+	write(filepath.Join(docsDir, ".hidden", "secret.txt"), "Ignored")
+
+	c := newTestCollections(t, CollectionsConfig{})
+	ctx := WithCollections(context.Background(), c)
+	ctx = utils.WithDK(ctx, lib.NewClient("https://localhost", "alice", nil, nil))
+	w := NewSourceWatcher(sourcesFile, docsDir, 0)
+
+	stats, err := w.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if stats != (SyncStats{Added: 3}) {
+		t.Fatalf("Expected 3 added documents, got %+v", stats)
+	}
+
+	// Unchanged sources are not re-embedded
+	if stats, _ := w.Sync(ctx); stats != (SyncStats{}) {
+		t.Errorf("Expected no changes, got %+v", stats)
+	}
+
+	write(sourcesFile, `{"text": "Apples grow on tall trees", "file": "apples.txt"}
+`)
+	if err := os.Remove(filepath.Join(docsDir, "notes.txt")); err != nil {
+		t.Fatal(err)
+	}
+	stats, err = w.Sync(ctx)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if stats != (SyncStats{Updated: 1, Removed: 2}) {
+		t.Fatalf("Expected 1 updated and 2 removed documents, got %+v", stats)
+	}
+
+	infos := c.List()
+	if len(infos) != 2 || infos[0].Documents != 1 || infos[1].Documents != 0 {
+		t.Errorf("Unexpected collections after sync: %+v", infos)
+	}
+	defaultCtx, _ := UseCollection(ctx, DefaultCollection, false)
+	doc, err := GetDocument(defaultCtx, "file", "apples.txt", 1)
+	if err != nil || doc.Content != "Apples grow on tall trees" {
+		t.Errorf("Expected the updated content, got %+v (%v)", doc, err)
+	}
+
+	// A sources file that cannot be read keeps its documents
+	if err := os.Remove(sourcesFile); err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ := w.Sync(ctx); stats != (SyncStats{}) {
+		t.Errorf("Expected no changes without a sources file, got %+v", stats)
+	}
+
+	// A new watcher picks up the documents already indexed
+	write(sourcesFile, `{"text": "Apples grow on tall trees", "file": "apples.txt"}
+`)
+	if stats, _ := NewSourceWatcher(sourcesFile, "", 0).Sync(ctx); stats != (SyncStats{}) {
+		t.Errorf("Expected indexed documents to be recognized, got %+v", stats)
+	}
+}
+
+func TestSourceWatcherManagesSources(t *testing.T) {
+	dir := t.TempDir()
+	sourcesFile := filepath.Join(dir, "rag_sources.jsonl")
+	c := newTestCollections(t, CollectionsConfig{})
+	ctx := WithCollections(context.Background(), c)
+	ctx = utils.WithDK(ctx, lib.NewClient("https://localhost", "alice", nil, nil))
+	w := NewSourceWatcher(sourcesFile, "", 0)
+
+	invalid := []RagSource{
+		{FileName: "none.txt"},
+		{Text: "both", Path: filepath.Join(dir, "a.txt"), FileName: "both.txt"},
+		{Path: filepath.Join(dir, "missing.txt")},
+		{URL: "ftp://example.org/a.txt"},
+		{Text: "no name"},
+	}
+	for i, source := range invalid {
+		if _, err := w.AddSource(ctx, source); !errors.Is(err, ErrInvalidRagSource) {
+			t.Errorf("Source %d: expected ErrInvalidRagSource, got %v", i, err)
+		}
+	}
+
+	path := filepath.Join(dir, "guide.txt")
+	if err := os.WriteFile(path, []byte("Installation guide"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	status, err := w.AddSource(ctx, RagSource{Path: path, Tags: []string{"docs"}})
+	if err != nil {
+		t.Fatalf("AddSource failed: %v", err)
+	}
+	if status.Status != SourceIndexed || status.FileName != "" || status.IngestedAt == nil {
+		t.Errorf("Expected an indexed source named after its path, got %+v", status)
+	}
+	if _, err := w.AddSource(ctx, RagSource{Text: "Duplicate", FileName: "guide.txt"}); !errors.Is(err, ErrRagSourceExists) {
+		t.Errorf("Expected ErrRagSourceExists, got %v", err)
+	}
+
+	// A source whose file disappeared fails but keeps its document
+	os.Remove(path)
+	status, err = w.Reingest(ctx, "", "guide.txt")
+	if err != nil || status.Status != SourceFailed || status.LastError == "" {
+		t.Errorf("Expected a failed re-ingestion, got %+v (%v)", status, err)
+	}
+	if infos := c.List(); infos[0].Documents != 1 {
+		t.Errorf("Expected the document to be kept, got %+v", infos)
+	}
+
+	status, err = w.UpdateSource(ctx, "", "guide.txt", func(s *RagSource) { s.Text, s.Path = "Updated guide", "" })
+	if err != nil || status.Status != SourceIndexed || status.FileName != "guide.txt" || status.TextLength != len("Updated guide") {
+		t.Errorf("Expected the updated source to be indexed under the same name, got %+v (%v)", status, err)
+	}
+	if _, err := w.Reingest(ctx, "", "missing.txt"); !errors.Is(err, ErrRagSourceNotFound) {
+		t.Errorf("Expected ErrRagSourceNotFound, got %v", err)
+	}
+
+	if err := w.RemoveSource(ctx, "", "guide.txt"); err != nil {
+		t.Fatalf("RemoveSource failed: %v", err)
+	}
+	sources, err := w.Sources()
+	if err != nil || len(sources) != 0 || c.List()[0].Documents != 0 {
+		t.Errorf("Expected no sources and documents left, got %+v (%v)", sources, err)
+	}
+}
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// BuildAnswerPrompt assembles the default user prompt sent to the LLM for a question and its
+// context documents. Deployments can replace it with a context template, see PromptTemplates.
+func BuildAnswerPrompt(question string, docs []Document) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("<QUESTION>%s<QUESTION>\n", question))
+	sb.WriteString("<CONTEXT>\n")
+	for _, doc := range docs {
+		sb.WriteString(doc.Content)
+	}
+	sb.WriteString("<CONTEXT>\n")
+	return sb.String()
+}
+
+// StreamAnswer streams the answer to a question incrementally using the provider's GenerateStream
+func StreamAnswer(ctx context.Context, llmProvider LLMProvider, question string, docs []Document) (<-chan Chunk, error) {
+	return llmProvider.GenerateStream(ctx, PromptTemplatesFromContext(ctx).AnswerPrompt(question, docs))
+}
+
+// CollectStream drains a chunk stream and returns the concatenated content
+func CollectStream(ctx context.Context, stream <-chan Chunk) (string, error) {
+	var sb strings.Builder
+	for {
+		select {
+		case <-ctx.Done():
+			return sb.String(), ctx.Err()
+		case chunk, ok := <-stream:
+			if !ok {
+				return sb.String(), nil
+			}
+			if chunk.Err != nil {
+				return sb.String(), chunk.Err
+			}
+			sb.WriteString(chunk.Content)
+			if chunk.Done {
+				return sb.String(), nil
+			}
+		}
+	}
+}
+
+// sendChunk delivers a chunk unless the context is cancelled first
+func sendChunk(ctx context.Context, ch chan<- Chunk, chunk Chunk) bool {
+	select {
+	case ch <- chunk:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,180 @@
+package core
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamServer serves lines as a streamed response, flushing after each one. With abort, the
+// connection is dropped after the lines instead of ending the response.
+func streamServer(t *testing.T, lines []string, abort bool) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, line := range lines {
+			w.Write([]byte(line + "\n"))
+			w.(http.Flusher).Flush()
+		}
+		if abort {
+			panic(http.ErrAbortHandler)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// streamProviders are the streaming providers with the lines of their APIs for an answer, for
+// an error event after the first chunk, and for an answer cut off after the first chunk
+var streamProviders = []struct {
+	name   string
+	new    func(config ModelConfig) (LLMProvider, error)
+	answer []string
+	failed []string
+	cutOff []string
+}{
+	{
+		name: "openai",
+		new:  func(config ModelConfig) (LLMProvider, error) { return NewOpenAIProvider(config) },
+		answer: []string{
+			`data: {"choices":[{"index":0,"delta":{"role":"assistant"}}]}`, "",
+			`data: {"choices":[{"index":0,"delta":{"content":"The answer "}}]}`, "",
+			`data: {"choices":[{"index":0,"delta":{"content":"is forty two."}}]}`, "",
+			`data: [DONE]`, "",
+		},
+		failed: []string{
+			`data: {"choices":[{"index":0,"delta":{"content":"The answer "}}]}`, "",
+			`data: {"error":{"message":"overloaded","type":"server_error"}}`, "",
+		},
+		cutOff: []string{`data: {"choices":[{"index":0,"delta":{"content":"The answer "}}]}`, ""},
+	},
+	{
+		name: "anthropic",
+		new:  func(config ModelConfig) (LLMProvider, error) { return NewAnthropicProvider(config) },
+		answer: []string{
+			"event: message_start", `data: {"type":"message_start"}`, "",
+			"event: content_block_delta", `data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"The answer "}}`, "",
+			"event: ping", `data: {"type":"ping"}`, "",
+			"event: content_block_delta", `data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"is forty two."}}`, "",
+			"event: message_stop", `data: {"type":"message_stop"}`, "",
+		},
+		failed: []string{
+			"event: content_block_delta", `data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"The answer "}}`, "",
+			"event: error", `data: {"type":"error","error":{"type":"overloaded_error","message":"overloaded"}}`, "",
+		},
+		cutOff: []string{
+			"event: content_block_delta", `data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"The answer "}}`, "",
+		},
+	},
+	{
+		name: "ollama",
+		new:  func(config ModelConfig) (LLMProvider, error) { return NewOllamaProvider(config) },
+		answer: []string{
+			`{"response":"The answer ","done":false}`,
+			`{"response":"is forty two.","done":false}`,
+			`{"response":"","done":true}`,
+		},
+		failed: []string{
+			`{"response":"The answer ","done":false}`,
+			`{"error":"overloaded"}`,
+		},
+		cutOff: []string{`{"response":"The answer ","done":false}`},
+	},
+}
+
+// readStream returns the chunks of a stream until it is closed
+func readStream(t *testing.T, stream <-chan Chunk) []Chunk {
+	t.Helper()
+	var chunks []Chunk
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case chunk, ok := <-stream:
+			if !ok {
+				return chunks
+			}
+			chunks = append(chunks, chunk)
+		case <-timeout:
+			t.Fatalf("The stream was not closed, got %+v", chunks)
+		}
+	}
+}
+
+func TestProviderStreams(t *testing.T) {
+	for _, tc := range streamProviders {
+		t.Run(tc.name, func(t *testing.T) {
+			open := func(lines []string, abort bool) <-chan Chunk {
+				srv := streamServer(t, lines, abort)
+				provider, err := tc.new(ModelConfig{Provider: tc.name, ApiKey: "test", BaseURL: srv.URL})
+				if err != nil {
+					t.Fatal(err)
+				}
+				stream, err := provider.GenerateStream(context.Background(), "What is the answer?")
+				if err != nil {
+					t.Fatalf("GenerateStream failed: %v", err)
+				}
+				return stream
+			}
+
+			// The answer arrives in the chunks of the API, then ends with Done
+			chunks := readStream(t, open(tc.answer, false))
+			if len(chunks) != 3 || chunks[0].Content != "The answer " || chunks[1].Content != "is forty two." {
+				t.Fatalf("Expected the two chunks of the answer and Done, got %+v", chunks)
+			}
+			if last := chunks[2]; !last.Done || last.Err != nil || last.Content != "" {
+				t.Errorf("Expected the stream to end with Done, got %+v", last)
+			}
+
+			// Errors reported in the stream end it after the chunks received before
+			chunks = readStream(t, open(tc.failed, false))
+			if len(chunks) != 2 || chunks[0].Content != "The answer " {
+				t.Fatalf("Expected the first chunk and the error, got %+v", chunks)
+			}
+			if last := chunks[1]; last.Err == nil || !strings.Contains(last.Err.Error(), "overloaded") || last.Done {
+				t.Errorf("Expected the error of the API, got %+v", last)
+			}
+
+			// A dropped connection is an error, not the end of the answer
+			chunks = readStream(t, open(tc.cutOff, true))
+			if len(chunks) != 2 || chunks[0].Content != "The answer " {
+				t.Fatalf("Expected the first chunk and the error, got %+v", chunks)
+			}
+			if last := chunks[1]; last.Err == nil || last.Done {
+				t.Errorf("Expected a read error, got %+v", last)
+			}
+		})
+	}
+}
+
+func TestProviderStreamUsage(t *testing.T) {
+	docs := []Document{{FileName: "a.txt", Content: "The answer to everything is forty two."}}
+	for _, tc := range streamProviders {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := streamServer(t, tc.answer, false)
+			provider, err := tc.new(ModelConfig{Provider: tc.name, ApiKey: "test", BaseURL: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			pipeline := &AnswerPipeline{
+				Provider: provider,
+				Retrieve: func(ctx context.Context, question string) ([]Document, error) { return docs, nil },
+				Counter:  DefaultTokenCounter,
+			}
+
+			// The completion tokens are counted once the stream has been collected
+			answer, result, err := pipeline.Answer(context.Background(), "What is the answer?")
+			if err != nil {
+				t.Fatalf("Answer failed: %v", err)
+			}
+			if answer != "The answer is forty two." {
+				t.Errorf("Expected the streamed answer, got %q", answer)
+			}
+			if result.Usage.PromptTokens == 0 || result.Usage.CompletionTokens != DefaultTokenCounter.CountTokens(answer) {
+				t.Errorf("Expected the tokens of the prompt and of the answer, got %+v", result.Usage)
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package core
+
+import (
+	"context"
+	"dk/utils"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/philippgille/chromem-go"
+)
+
+// summaryKey marks the stored summary of a file. It is kept alongside the chunks of the file
+// and shares their metadata, so it is filtered, toggled and removed together with them.
+const summaryKey = "summary"
+
+// Default summarization limits, in tokens, when they are not configured
+const (
+	defaultSummaryMinTokens   = 2000
+	defaultSummaryMaxTokens   = 300
+	defaultSummaryInputTokens = 6000
+)
+
+// maxSummaryRounds bounds how often the summaries of the parts of a very long document are
+// summarized again
+const maxSummaryRounds = 4
+
+// summarizePrompt asks for the summary of a document, or of one part of it
+const summarizePrompt = `Summarize the following document in at most %d words. Keep names, numbers,
+definitions and conclusions that someone answering questions about the document would need.
+Respond with the summary only.
+
+Document:
+%s`
+
+// SummarizationConfig enables LLM summaries of long documents at ingest. Summaries are
+// embedded next to the chunks of their document, and answer context uses a summary instead of
+// the chunks of its document when that is shorter.
+type SummarizationConfig struct {
+	MinTokens   int `json:"min_tokens,omitempty"`   // Documents longer than this are summarized
+	MaxTokens   int `json:"max_tokens,omitempty"`   // Length asked of a summary
+	InputTokens int `json:"input_tokens,omitempty"` // Largest part of a document sent to the LLM at once
+}
+
+// Validate checks the configuration and fills in the defaults
+func (c *SummarizationConfig) Validate() error {
+	if c.MinTokens <= 0 {
+		c.MinTokens = defaultSummaryMinTokens
+	}
+	if c.MaxTokens <= 0 {
+		c.MaxTokens = defaultSummaryMaxTokens
+	}
+	if c.InputTokens <= 0 {
+		c.InputTokens = defaultSummaryInputTokens
+	}
+	// Parts are summarized until their summaries fit in one request, which needs them to shrink
+	if c.MaxTokens*2 > c.InputTokens {
+		return fmt.Errorf("summary max_tokens must be at most half of input_tokens (%d)", c.InputTokens)
+	}
+	return nil
+}
+
+type summarizationKey struct{}
+
+// WithSummarization adds the summarization configuration to the context
+func WithSummarization(ctx context.Context, config SummarizationConfig) context.Context {
+	return context.WithValue(ctx, summarizationKey{}, config)
+}
+
+// SummarizationFromContext returns the summarization configuration of the context, or nil if
+// documents are not summarized
+func SummarizationFromContext(ctx context.Context) *SummarizationConfig {
+	config, ok := ctx.Value(summarizationKey{}).(SummarizationConfig)
+	if !ok {
+		return nil
+	}
+	return &config
+}
+
+// isSummary reports whether a document is the summary of a file
+func isSummary(metadata map[string]string) bool {
+	return metadata[summaryKey] == "true"
+}
+
+// SummarizeDocument asks the LLM for a summary of content. Content longer than the input limit
+// is split into parts that are summarized separately, and their summaries are summarized again
+// until they fit in a single request.
+func SummarizeDocument(ctx context.Context, llmProvider LLMProvider, content string, config SummarizationConfig) (string, error) {
+	text := content
+	for round := 0; ; round++ {
+		parts := splitToTokens(text, config.InputTokens)
+		summaries := make([]string, 0, len(parts))
+		for _, part := range parts {
+			stream, err := llmProvider.GenerateStream(ctx, fmt.Sprintf(summarizePrompt, config.MaxTokens*3/4, part))
+			if err != nil {
+				return "", err
+			}
+			summary, err := CollectStream(ctx, stream)
+			if err != nil {
+				return "", err
+			}
+			if summary = strings.TrimSpace(summary); summary != "" {
+				summaries = append(summaries, summary)
+			}
+		}
+		if len(summaries) == 0 {
+			return "", errors.New("the LLM returned an empty summary")
+		}
+		text = strings.Join(summaries, "\n\n")
+		if len(parts) == 1 || round+1 == maxSummaryRounds {
+			return text, nil
+		}
+	}
+}
+
+// splitToTokens splits text into consecutive parts of at most maxTokens tokens
+func splitToTokens(text string, maxTokens int) []string {
+	var parts []string
+	for text != "" {
+		part := truncateToTokens(text, maxTokens)
+		if part == "" {
+			// A single piece longer than the limit, such as a long run of symbols
+			part = text[:min(len(text), maxTokens*4)]
+		}
+		parts = append(parts, part)
+		text = text[len(part):]
+	}
+	return parts
+}
+
+// summaryDocument returns the vector store document of the summary of a file, or nil if the
+// file is too short to be summarized or summarization is off. Failures are logged rather
+// than returned: the file is still retrievable through its chunks.
+func summaryDocument(ctx context.Context, fileContent string, metadata map[string]string) *chromem.Document {
+	config := SummarizationFromContext(ctx)
+	if config == nil || DefaultTokenCounter.CountTokens(fileContent) <= config.MinTokens {
+		return nil
+	}
+	llmProvider, err := LLMProviderFromContext(ctx)
+	if err != nil {
+		log.Printf("[RAG] Not summarizing %s: %v", metadata["file"], err)
+		return nil
+	}
+	summary, err := SummarizeDocument(ctx, llmProvider, fileContent, *config)
+	if err != nil {
+		log.Printf("[RAG] Failed to summarize %s: %v", metadata["file"], err)
+		return nil
+	}
+
+	docMetadata := make(map[string]string, len(metadata)+1)
+	for key, value := range metadata {
+		docMetadata[key] = value
+	}
+	docMetadata[summaryKey] = "true"
+	log.Printf("[RAG] Summarized %s in %d tokens", metadata["file"], DefaultTokenCounter.CountTokens(summary))
+	return &chromem.Document{
+		ID:       uuid.NewString(),
+		Metadata: docMetadata,
+		Content:  "search_document: " + summary,
+	}
+}
+
+// storedSummaries returns the summaries kept in the collection of the context for the files of
+// docs, keyed by file name
+func storedSummaries(ctx context.Context, docs []Document) map[string]Document {
+	summaries := make(map[string]Document)
+	collection, err := utils.ChromemCollectionFromContext(ctx)
+	if err != nil || collection.Count() == 0 {
+		return summaries
+	}
+	for _, doc := range docs {
+		if _, ok := summaries[doc.FileName]; ok || isSummary(doc.Metadata) {
+			continue
+		}
+		results, err := collection.Query(ctx, "search_query: _", 1, map[string]string{"file": doc.FileName, summaryKey: "true"}, nil)
+		if err != nil || len(results) == 0 {
+			continue
+		}
+		metadata := make(map[string]string)
+		for key, value := range results[0].Metadata {
+			if key != "file" && !strings.HasPrefix(key, tagKeyPrefix) {
+				metadata[key] = value
+			}
+		}
+		summaries[doc.FileName] = Document{
+			FileName: doc.FileName,
+			Content:  strings.TrimPrefix(results[0].Content, "search_document: "),
+			Metadata: metadata,
+			Score:    doc.Score,
+		}
+	}
+	return summaries
+}
+
+// preferSummaries uses the summary of a file in place of its retrieved chunks when the summary
+// is shorter, so that long documents do not crowd the prompt out of the model window. Each
+// file is then represented once, at the position of its best ranked document. summaries holds
+// the stored summaries of the files; summaries among docs are used as well.
+func preferSummaries(counter TokenCounter, docs []Document, summaries map[string]Document) []Document {
+	available := make(map[string]Document, len(summaries))
+	for file, summary := range summaries {
+		available[file] = summary
+	}
+	chunkTokens := make(map[string]int)
+	for _, doc := range docs {
+		if !isSummary(doc.Metadata) {
+			chunkTokens[doc.FileName] += counter.CountTokens(doc.Content)
+		} else if _, ok := available[doc.FileName]; !ok {
+			available[doc.FileName] = doc
+		}
+	}
+
+	result := make([]Document, 0, len(docs))
+	added := make(map[string]bool)
+	for _, doc := range docs {
+		summary, ok := available[doc.FileName]
+		useSummary := ok && (chunkTokens[doc.FileName] == 0 || counter.CountTokens(summary.Content) < chunkTokens[doc.FileName])
+		switch {
+		case useSummary:
+			if !added[doc.FileName] {
+				added[doc.FileName] = true
+				result = append(result, summary)
+			}
+		case !isSummary(doc.Metadata):
+			result = append(result, doc)
+		}
+	}
+	return result
+}
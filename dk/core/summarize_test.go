@@ -0,0 +1,100 @@
+package core
+
+import (
+	"context"
+	lib "dk/client"
+	"dk/utils"
+	"strings"
+	"testing"
+)
+
+func TestSummarizeDocument(t *testing.T) {
+	provider := &stubProvider{answer: "A short summary."}
+	config := SummarizationConfig{MaxTokens: 20, InputTokens: 100}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	content := strings.Repeat("The quick brown fox jumps over the lazy dog. ", 30)
+
+	summary, err := SummarizeDocument(context.Background(), provider, content, config)
+	if err != nil {
+		t.Fatalf("SummarizeDocument failed: %v", err)
+	}
+	if summary != "A short summary." {
+		t.Errorf("Expected the summary of the part summaries, got %q", summary)
+	}
+	parts := len(splitToTokens(content, config.InputTokens))
+	if parts < 2 || int(provider.calls.Load()) != parts+1 {
+		t.Errorf("Expected %d part summaries and one combined summary, got %d calls", parts, provider.calls.Load())
+	}
+	if strings.Join(splitToTokens(content, config.InputTokens), "") != content {
+		t.Error("Expected the parts to cover the whole content")
+	}
+
+	if err := (&SummarizationConfig{MaxTokens: 400, InputTokens: 600}).Validate(); err == nil {
+		t.Error("Expected summaries longer than half the input limit to be rejected")
+	}
+}
+
+func TestPreferSummaries(t *testing.T) {
+	long := strings.Repeat("Detailed chunk text about the installation. ", 20)
+	summary := Document{FileName: "manual.txt", Content: "Manual summary.", Metadata: map[string]string{summaryKey: "true"}}
+	docs := []Document{
+		{FileName: "manual.txt", Content: long, Metadata: map[string]string{}},
+		{FileName: "faq.txt", Content: "Short answer.", Metadata: map[string]string{}},
+		{FileName: "manual.txt", Content: long, Metadata: map[string]string{}},
+		{FileName: "notes.txt", Content: "Notes summary that is longer than the only chunk retrieved.", Metadata: map[string]string{summaryKey: "true"}},
+		{FileName: "notes.txt", Content: "Tiny note.", Metadata: map[string]string{}},
+	}
+
+	got := preferSummaries(DefaultTokenCounter, docs, map[string]Document{"manual.txt": summary})
+	var contents []string
+	for _, doc := range got {
+		contents = append(contents, doc.Content)
+	}
+	want := []string{"Manual summary.", "Short answer.", "Tiny note."}
+	if strings.Join(contents, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected %q, got %q", want, contents)
+	}
+}
+
+func TestAddDocumentStoresSummary(t *testing.T) {
+	provider := &stubProvider{answer: "Summary of the handbook."}
+	ctx := WithCollections(context.Background(), newTestCollections(t, CollectionsConfig{}))
+	ctx = utils.WithDK(ctx, lib.NewClient("https://localhost", "alice", nil, nil))
+	ctx = WithLLMProvider(ctx, provider)
+	ctx = WithChunking(ctx, ChunkingConfig{Strategy: ChunkStrategySentence, Size: 5})
+	ctx = WithSummarization(ctx, SummarizationConfig{MinTokens: 100, MaxTokens: 50, InputTokens: 1000})
+	ctx, err := UseCollection(ctx, DefaultCollection, true)
+	if err != nil {
+		t.Fatalf("UseCollection failed: %v", err)
+	}
+
+	content := strings.Repeat("Employees accrue vacation days every month. ", 40)
+	if err := AddDocument(ctx, "handbook.txt", content, false, map[string]string{summaryKey: "true"}); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+	if err := AddDocument(ctx, "short.txt", "A short memo.", false, nil); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+	if provider.calls.Load() != 1 {
+		t.Errorf("Expected only the long document to be summarized, got %d calls", provider.calls.Load())
+	}
+
+	// The summary is stored next to the chunks but is not part of the document
+	doc, err := GetDocument(ctx, "file", "handbook.txt", 1)
+	if err != nil || doc.Content != content || isSummary(doc.Metadata) {
+		t.Fatalf("Expected the original document, got %+v (%v)", doc, err)
+	}
+	summaries := storedSummaries(ctx, []Document{{FileName: "handbook.txt"}, {FileName: "short.txt"}})
+	if len(summaries) != 1 || summaries["handbook.txt"].Content != "Summary of the handbook." {
+		t.Fatalf("Expected the stored summary of the handbook, got %+v", summaries)
+	}
+
+	if err := RemoveDocument(ctx, "handbook.txt"); err != nil {
+		t.Fatalf("RemoveDocument failed: %v", err)
+	}
+	if summaries := storedSummaries(ctx, []Document{{FileName: "handbook.txt"}}); len(summaries) != 0 {
+		t.Errorf("Expected the summary to be removed with its document, got %+v", summaries)
+	}
+}
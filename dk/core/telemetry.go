@@ -0,0 +1,182 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"dk/db"
+	"dk/utils"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Telemetry categories the user can opt in to separately. Nothing is collected for a category
+// until the user enabled it.
+const (
+	TelemetryMCP     = "mcp"     // MCP tool calls, by tool name
+	TelemetryRAG     = "rag"     // Document ingestion and retrieval
+	TelemetryQueries = "queries" // Questions received from peers
+)
+
+// TelemetryCategories lists every telemetry category
+var TelemetryCategories = []string{TelemetryMCP, TelemetryRAG, TelemetryQueries}
+
+// telemetryDay is the layout of the days counters are aggregated by
+const telemetryDay = "2006-01-02"
+
+var (
+	// ErrTelemetryReportChanged is returned when the report to send differs from the approved one
+	ErrTelemetryReportChanged = errors.New("telemetry report changed since it was approved")
+	// ErrTelemetryNotConfigured is returned when sending without a telemetry endpoint
+	ErrTelemetryNotConfigured = errors.New("no telemetry endpoint configured")
+)
+
+// TelemetryConfig sets where approved telemetry reports are sent
+type TelemetryConfig struct {
+	Endpoint string `json:"endpoint"` // URL reports are POSTed to
+}
+
+// Telemetry sends approved usage reports. Counters are only ever collected for the categories
+// the user opted in to, and only complete days are reported.
+type Telemetry struct {
+	Endpoint string
+	client   *http.Client
+}
+
+// TelemetryReport is the anonymous payload sent to the telemetry endpoint. It holds feature
+// usage counts summed over the unreported days; no user, peer or content is included.
+type TelemetryReport struct {
+	Schema   int              `json:"schema"`
+	From     string           `json:"from"` // First day included
+	To       string           `json:"to"`   // Last day included
+	Counters map[string]int64 `json:"counters"`
+}
+
+// TelemetryPreview shows the user exactly what would be sent. The digest approves the payload.
+type TelemetryPreview struct {
+	Endpoint string           `json:"endpoint,omitempty"`
+	Report   *TelemetryReport `json:"report,omitempty"` // Nil when there is nothing to report
+	Payload  string           `json:"payload,omitempty"`
+	Digest   string           `json:"digest,omitempty"`
+}
+
+// NewTelemetry creates the reporter from its configuration
+func NewTelemetry(config TelemetryConfig) *Telemetry {
+	return &Telemetry{Endpoint: config.Endpoint, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// RecordFeature counts a use of a feature if the user opted in to its category
+func RecordFeature(ctx context.Context, category, feature string) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return
+	}
+	day := time.Now().UTC().Format(telemetryDay)
+	if err := db.IncrementTelemetryCounter(ctx, database, category, feature, day); err != nil {
+		log.Printf("[Telemetry] Failed to record %s.%s: %v", category, feature, err)
+	}
+}
+
+// IsTelemetryCategory reports whether category is a known telemetry category
+func IsTelemetryCategory(category string) bool {
+	for _, c := range TelemetryCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// Preview builds the report of the unreported complete days
+func (t *Telemetry) Preview(ctx context.Context) (TelemetryPreview, error) {
+	preview := TelemetryPreview{Endpoint: t.Endpoint}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return preview, err
+	}
+
+	today := time.Now().UTC().Format(telemetryDay)
+	counters, err := db.ListTelemetryCounters(ctx, database, today)
+	if err != nil {
+		return preview, err
+	}
+	if len(counters) == 0 {
+		return preview, nil
+	}
+
+	report := &TelemetryReport{Schema: 1, From: counters[0].Day, To: counters[len(counters)-1].Day, Counters: make(map[string]int64)}
+	for _, c := range counters {
+		report.Counters[c.Category+"."+c.Feature] += c.Count
+	}
+	payload, err := json.Marshal(report)
+	if err != nil {
+		return preview, err
+	}
+	sum := sha256.Sum256(payload)
+	preview.Report = report
+	preview.Payload = string(payload)
+	preview.Digest = hex.EncodeToString(sum[:])
+	return preview, nil
+}
+
+// Send transmits the pending report if its digest matches the one the user approved, and
+// marks the reported days so they are not sent again
+func (t *Telemetry) Send(ctx context.Context, approvedDigest string) (*TelemetryReport, error) {
+	if t.Endpoint == "" {
+		return nil, ErrTelemetryNotConfigured
+	}
+	preview, err := t.Preview(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if preview.Report == nil {
+		return nil, nil
+	}
+	if preview.Digest != approvedDigest {
+		return nil, ErrTelemetryReportChanged
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.Endpoint, bytes.NewReader([]byte(preview.Payload)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send telemetry: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("telemetry endpoint returned %s", resp.Status)
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.MarkTelemetryReported(ctx, database, preview.Report.To); err != nil {
+		return nil, err
+	}
+	return preview.Report, nil
+}
+
+type telemetryKey struct{}
+
+// WithTelemetry adds the telemetry reporter to the context
+func WithTelemetry(ctx context.Context, telemetry *Telemetry) context.Context {
+	return context.WithValue(ctx, telemetryKey{}, telemetry)
+}
+
+// TelemetryFromContext returns the telemetry reporter. Without one reports can be previewed
+// and exported but not sent.
+func TelemetryFromContext(ctx context.Context) *Telemetry {
+	if telemetry, ok := ctx.Value(telemetryKey{}).(*Telemetry); ok {
+		return telemetry
+	}
+	return &Telemetry{}
+}
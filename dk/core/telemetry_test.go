@@ -0,0 +1,84 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTelemetryIsOptInAndSendsApprovedReport(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := utils.WithDatabase(context.Background(), testDB.DB)
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(telemetryDay)
+
+	// Nothing is collected without consent
+	RecordFeature(ctx, TelemetryMCP, "cqAskQuestion")
+	if counters, _ := db.ListTelemetryCounters(ctx, testDB.DB, ""); len(counters) != 0 {
+		t.Fatalf("Expected no counters without consent, got %+v", counters)
+	}
+
+	if err := db.SetTelemetryConsent(ctx, testDB.DB, TelemetryMCP, true); err != nil {
+		t.Fatalf("SetTelemetryConsent failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.IncrementTelemetryCounter(ctx, testDB.DB, TelemetryMCP, "cqAskQuestion", yesterday); err != nil {
+			t.Fatalf("IncrementTelemetryCounter failed: %v", err)
+		}
+	}
+	db.IncrementTelemetryCounter(ctx, testDB.DB, TelemetryRAG, "retrieve", yesterday)
+	// Today is still being counted and is not reported yet
+	RecordFeature(ctx, TelemetryMCP, "cqAskQuestion")
+
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+	}))
+	defer server.Close()
+	telemetry := NewTelemetry(TelemetryConfig{Endpoint: server.URL})
+
+	preview, err := telemetry.Preview(ctx)
+	if err != nil {
+		t.Fatalf("Preview failed: %v", err)
+	}
+	if preview.Report == nil || len(preview.Report.Counters) != 1 || preview.Report.Counters["mcp.cqAskQuestion"] != 2 {
+		t.Fatalf("Unexpected report %+v", preview.Report)
+	}
+
+	if _, err := telemetry.Send(ctx, "not the digest"); !errors.Is(err, ErrTelemetryReportChanged) {
+		t.Errorf("Expected ErrTelemetryReportChanged, got %v", err)
+	}
+	if received != "" {
+		t.Fatal("Expected nothing to be sent without approval")
+	}
+	if _, err := telemetry.Send(ctx, preview.Digest); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if received != preview.Payload {
+		t.Errorf("Expected the previewed payload to be sent, got %s", received)
+	}
+	if preview, _ := telemetry.Preview(ctx); preview.Report != nil {
+		t.Errorf("Expected reported days not to be reported again, got %+v", preview.Report)
+	}
+
+	// Opting out deletes what was collected
+	if err := db.SetTelemetryConsent(ctx, testDB.DB, TelemetryMCP, false); err != nil {
+		t.Fatalf("SetTelemetryConsent failed: %v", err)
+	}
+	if counters, _ := db.ListTelemetryCounters(ctx, testDB.DB, ""); len(counters) != 0 {
+		t.Errorf("Expected counters to be deleted on opt-out, got %+v", counters)
+	}
+}
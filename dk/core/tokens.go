@@ -0,0 +1,232 @@
+package core
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Token budget strategies
+const (
+	BudgetStrategyReject   = "reject"
+	BudgetStrategyTruncate = "truncate"
+)
+
+// ErrTokenBudgetExceeded is returned when a prompt does not fit in the configured token budget
+var ErrTokenBudgetExceeded = errors.New("prompt exceeds token budget")
+
+// ErrInsufficientCredits is returned when a consumer of a credit based API has no credits left
+var ErrInsufficientCredits = errors.New("insufficient credits")
+
+// TokenBudget limits the size of prompts sent to the LLM
+type TokenBudget struct {
+	MaxPromptTokens int    `json:"max_prompt_tokens"` // 0 disables the budget
+	Strategy        string `json:"strategy"`          // "reject" (default) or "truncate"
+}
+
+// Validate checks the budget and fills in the default strategy, so a misspelt strategy is
+// reported at startup rather than rejecting every prompt over the budget
+func (b *TokenBudget) Validate() error {
+	if b.MaxPromptTokens < 0 {
+		return errors.New("max_prompt_tokens must not be negative")
+	}
+	switch b.Strategy {
+	case "":
+		b.Strategy = BudgetStrategyReject
+	case BudgetStrategyReject, BudgetStrategyTruncate:
+	default:
+		return fmt.Errorf("unknown token budget strategy %q: expected %q or %q", b.Strategy, BudgetStrategyReject, BudgetStrategyTruncate)
+	}
+	return nil
+}
+
+// TokenUsage is the number of tokens consumed by a single LLM call
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// Total returns the sum of prompt and completion tokens
+func (u TokenUsage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// TokenCounter counts the tokens a text is split into by a model's tokenizer
+type TokenCounter interface {
+	CountTokens(text string) int
+}
+
+// pretokenizePattern splits text the way BPE tokenizers of the GPT family do before merging:
+// contractions, letter runs with an optional leading symbol, numbers of up to three digits,
+// punctuation runs and whitespace.
+var pretokenizePattern = regexp.MustCompile(`'(?i:[sdmt]|ll|ve|re)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// EstimatedTokenCounter estimates token counts without the vocabulary of any model: text is
+// pre-tokenized with the rules of the GPT family tokenizers, then each piece is assumed to merge
+// into chunks of about four bytes. Non-ASCII pieces count one token per character, which
+// matches CJK text closely and over-estimates accented Latin text slightly. Counts differ from
+// those of the model's tokenizer, so the estimate errs on the high side to keep prompts within
+// budgets.
+type EstimatedTokenCounter struct{}
+
+// CountTokens implements TokenCounter
+func (EstimatedTokenCounter) CountTokens(text string) int {
+	count := 0
+	for _, piece := range pretokenizePattern.FindAllString(text, -1) {
+		count += pieceTokens(piece)
+	}
+	return count
+}
+
+func pieceTokens(piece string) int {
+	if n := utf8.RuneCountInString(piece); n != len(piece) {
+		return n
+	}
+	return (len(piece) + 3) / 4
+}
+
+// DefaultTokenCounter is the counter used for budget enforcement and usage accounting
+var DefaultTokenCounter TokenCounter = EstimatedTokenCounter{}
+
+// truncateToTokens returns the longest prefix of text made of whole pre-tokenized pieces
+// that fits in maxTokens
+func truncateToTokens(text string, maxTokens int) string {
+	used := 0
+	end := 0
+	for _, loc := range pretokenizePattern.FindAllStringIndex(text, -1) {
+		tokens := pieceTokens(text[loc[0]:loc[1]])
+		if used+tokens > maxTokens {
+			break
+		}
+		used += tokens
+		end = loc[1]
+	}
+	return text[:end]
+}
+
+type tokenBudgetKey struct{}
+
+// WithTokenBudget adds a token budget to the context
+func WithTokenBudget(ctx context.Context, budget *TokenBudget) context.Context {
+	return context.WithValue(ctx, tokenBudgetKey{}, budget)
+}
+
+// TokenBudgetFromContext returns the token budget of the context, or nil if there is none
+func TokenBudgetFromContext(ctx context.Context) *TokenBudget {
+	budget, _ := ctx.Value(tokenBudgetKey{}).(*TokenBudget)
+	return budget
+}
+
+// EnforceTokenBudget counts the tokens of the answer prompt for question and docs. If the prompt
+// exceeds the budget it is either rejected with ErrTokenBudgetExceeded or, with the truncate
+// strategy, packed to fit (see packContext). A prompt larger than window, the tokens the model
+// accepts, is always packed to it; a window of 0 is unlimited. It returns the documents to use
+// and the prompt token count.
+func EnforceTokenBudget(counter TokenCounter, budget *TokenBudget, window int, templates *PromptTemplates, question string, docs []Document) ([]Document, int, error) {
+	return enforceTokenBudget(counter, budget, window, docs, basePromptTokens(counter, templates, question))
+}
+
+// basePromptTokens counts the tokens of the answer prompt without any documents
+func basePromptTokens(counter TokenCounter, templates *PromptTemplates, question string) int {
+	return counter.CountTokens(templates.SystemPrompt()) + counter.CountTokens(templates.AnswerPrompt(question, nil))
+}
+
+// enforceTokenBudget implements EnforceTokenBudget given the token count of the prompt without documents
+func enforceTokenBudget(counter TokenCounter, budget *TokenBudget, window int, docs []Document, base int) ([]Document, int, error) {
+	docTokens := make([]int, len(docs))
+	tokens := base
+	for i, doc := range docs {
+		docTokens[i] = counter.CountTokens(doc.Content)
+		tokens += docTokens[i]
+	}
+
+	limit := window
+	if budget != nil && budget.MaxPromptTokens > 0 {
+		if tokens > budget.MaxPromptTokens && budget.Strategy != BudgetStrategyTruncate {
+			return nil, tokens, fmt.Errorf("%w: %d tokens, budget is %d", ErrTokenBudgetExceeded, tokens, budget.MaxPromptTokens)
+		}
+		if limit <= 0 || budget.MaxPromptTokens < limit {
+			limit = budget.MaxPromptTokens
+		}
+	}
+	if limit <= 0 || tokens <= limit {
+		return docs, tokens, nil
+	}
+
+	// The fixed part of the prompt must fit on its own
+	if base > limit {
+		return nil, base, fmt.Errorf("%w: question alone needs %d tokens, limit is %d", ErrTokenBudgetExceeded, base, limit)
+	}
+
+	kept, used := packContext(counter, docs, docTokens, limit-base)
+	log.Printf("[LLM] Prompt packed to %d of %d tokens (%d of %d documents kept)", base+used, limit, len(kept), len(docs))
+	return kept, base + used, nil
+}
+
+// recordTokenUsage stores the tokens consumed answering a question asked through an API in the
+// api_usage table and charges them to the consumer's credits. Usage is only recorded when the
+// request names the API it was made against.
+func recordTokenUsage(ctx context.Context, apiID, userID, endpoint string, usage TokenUsage, blocked bool) {
+	if apiID == "" {
+		return
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return
+	}
+
+	record := &db.APIUsage{
+		ID:             uuid.New().String(),
+		APIID:          apiID,
+		ExternalUserID: userID,
+		Timestamp:      time.Now(),
+		RequestCount:   1,
+		TokensUsed:     usage.Total(),
+		Endpoint:       endpoint,
+		WasBlocked:     blocked,
+	}
+	if err := db.ChargeAPIUsage(database, record); err != nil {
+		log.Printf("[LLM] Failed to charge credits for API %s: %v", apiID, err)
+	}
+	if err := db.RecordAPIUsage(database, record); err != nil {
+		log.Printf("[LLM] Failed to record token usage for API %s: %v", apiID, err)
+	}
+}
+
+// checkCredits returns ErrInsufficientCredits when the API a question was asked through is
+// credit based and the consumer has used up their credits
+func checkCredits(ctx context.Context, apiID, userID string) error {
+	if apiID == "" {
+		return nil
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil
+	}
+
+	exhausted, err := db.CreditsExhausted(database, apiID, userID)
+	if err != nil {
+		log.Printf("[LLM] Failed to check credits for API %s: %v", apiID, err)
+		return nil
+	}
+	if exhausted {
+		return ErrInsufficientCredits
+	}
+	return nil
+}
+
+// apiIDFromMetadata returns the API a peer message was addressed to, if it names one
+func apiIDFromMetadata(metadata map[string]string) string {
+	return strings.TrimSpace(metadata["api_id"])
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTokenBudgetValidate(t *testing.T) {
+	budget := &TokenBudget{MaxPromptTokens: 100}
+	if err := budget.Validate(); err != nil || budget.Strategy != BudgetStrategyReject {
+		t.Errorf("Expected the reject strategy by default, got %q, %v", budget.Strategy, err)
+	}
+	for _, invalid := range []TokenBudget{
+		{MaxPromptTokens: 100, Strategy: "truncated"},
+		{MaxPromptTokens: 100, Strategy: "Truncate"},
+		{MaxPromptTokens: -1},
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("Expected %+v to be refused", invalid)
+		}
+	}
+}
+
+func TestEstimatedTokenCounter(t *testing.T) {
+	// Counts of the cl100k tokenizer, which the estimate must not fall below
+	cases := []struct {
+		text   string
+		tokens int
+	}{
+		{"Hello, world!", 4},
+		{"The quick brown fox jumps over the lazy dog.", 10},
+	}
+	counter := EstimatedTokenCounter{}
+	for _, tc := range cases {
+		if got := counter.CountTokens(tc.text); got < tc.tokens || got > 2*tc.tokens {
+			t.Errorf("%q: expected an estimate from %d to %d tokens, got %d", tc.text, tc.tokens, 2*tc.tokens, got)
+		}
+	}
+	if got := counter.CountTokens("日本語のテキスト"); got != 8 {
+		t.Errorf("Expected one token per CJK character, got %d", got)
+	}
+
+	text := strings.Repeat("Tokens are counted in pre-tokenized pieces. ", 20)
+	truncated := truncateToTokens(text, 50)
+	if !strings.HasPrefix(text, truncated) || counter.CountTokens(truncated) > 50 || counter.CountTokens(truncated) < 45 {
+		t.Errorf("Expected a prefix of close to 50 tokens, got %d", counter.CountTokens(truncated))
+	}
+}
@@ -15,7 +15,8 @@ type Query struct {
 
 // QueriesData represents a collection of queries
 type QueriesData struct {
-	Queries map[string]Query `json:"queries"`
+	SchemaVersion int              `json:"schema_version"`
+	Queries       map[string]Query `json:"queries"`
 }
 
 const GenerateDescriptionPrompt = `
@@ -327,9 +328,20 @@ type Document struct {
 	Score    float32           `json:"score,omitempty"`
 }
 
+// Chunk is a piece of a streamed completion. The final chunk on a stream has Done set,
+// or Err set if the completion failed part way through.
+type Chunk struct {
+	Content string `json:"content,omitempty"`
+	Done    bool   `json:"done,omitempty"`
+	Err     error  `json:"-"`
+}
+
 // LLMProvider defines the interface that all LLM providers must implement
 type LLMProvider interface {
 	GenerateAnswer(ctx context.Context, question string, docs []Document) (string, error)
+	// GenerateStream streams the answer to an already assembled prompt (see BuildAnswerPrompt).
+	// The returned channel is closed once the completion ends or ctx is cancelled.
+	GenerateStream(ctx context.Context, prompt string) (<-chan Chunk, error)
 	CheckAutomaticApproval(ctx context.Context, answer string, query Query, conditions []string) (string, bool, error)
 	GenerateDescription(ctx context.Context, text string) (string, error)
 }
@@ -342,4 +354,34 @@ type ModelConfig struct {
 	BaseURL    string            `json:"base_url"`   // Optional base URL for the API
 	Parameters map[string]any    `json:"parameters"` // Additional parameters like temperature, max_tokens, etc.
 	Headers    map[string]string `json:"headers"`    // Additional headers for API requests
+
+	// ContextWindow is the number of tokens the model accepts, prompt and answer together. It
+	// is looked up from the model name when not set.
+	ContextWindow int `json:"context_window,omitempty"`
+	// TimeoutSeconds bounds a single request to this provider when it is part of a fallback chain.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	// Providers declares an ordered fallback chain; when set, the fields above are ignored.
+	Providers []ModelConfig `json:"providers,omitempty"`
+	// TokenBudget limits the size of answer prompts.
+	TokenBudget *TokenBudget `json:"token_budget,omitempty"`
+	// Prompts customizes the system prompt, context formatting and answer style.
+	Prompts *PromptConfig `json:"prompts,omitempty"`
+	// Cache serves identical prompts from previous completions.
+	Cache *LLMCacheConfig `json:"cache,omitempty"`
+	// Rerank reorders retrieved documents before they are used as answer context.
+	Rerank *RerankConfig `json:"rerank,omitempty"`
+	// Chunking splits documents into smaller parts before they are embedded.
+	Chunking *ChunkingConfig `json:"chunking,omitempty"`
+	// Summarization stores LLM summaries of long documents for use as answer context.
+	Summarization *SummarizationConfig `json:"summarization,omitempty"`
+	// Collections declares named vector collections and routes queries to them.
+	Collections *CollectionsConfig `json:"collections,omitempty"`
+	// PeerFilters restrict the documents used to answer each peer.
+	PeerFilters PeerFilters `json:"peer_filters,omitempty"`
+	// Telemetry sets where approved usage reports are sent.
+	Telemetry *TelemetryConfig `json:"telemetry,omitempty"`
+	// Handshake declares the topics and collections announced to peers on first contact.
+	Handshake *HandshakeConfig `json:"handshake,omitempty"`
+	// Blobs sets where the original files of uploaded documents are kept.
+	Blobs *BlobStoreConfig `json:"blobs,omitempty"`
 }
@@ -55,10 +55,11 @@ func LoadQueries(queriesFile string) (QueriesData, error) {
 	var data QueriesData
 	// If file doesn't exist, initialize an empty map.
 	if _, err := os.Stat(queriesFile); os.IsNotExist(err) {
+		data.SchemaVersion = QueriesSchemaVersion
 		data.Queries = make(map[string]Query)
 		return data, nil
 	}
-	raw, err := os.ReadFile(queriesFile)
+	raw, err := readVersionedFile(queriesFile, QueriesSchemaVersion, queriesMigrators)
 	if err != nil {
 		return data, fmt.Errorf("failed to read queries file: %w", err)
 	}
@@ -69,17 +70,23 @@ func LoadQueries(queriesFile string) (QueriesData, error) {
 }
 
 func SaveQueries(queriesFile string, data QueriesData) error {
+	data.SchemaVersion = QueriesSchemaVersion
+	return writeJSONFile(queriesFile, data)
+}
+
+// writeJSONFile writes data as indented JSON, creating the directory of the file if needed
+func writeJSONFile(path string, data any) error {
 	// Ensure directory exists.
-	dir := filepath.Dir(queriesFile)
+	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, fs.ModePerm); err != nil {
 		return fmt.Errorf("failed to create directory %s: %w", dir, err)
 	}
 	raw, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
-		return fmt.Errorf("failed to marshal queries data: %w", err)
+		return fmt.Errorf("failed to marshal %s: %w", filepath.Base(path), err)
 	}
-	if err := os.WriteFile(queriesFile, raw, 0644); err != nil {
-		return fmt.Errorf("failed to write queries file: %w", err)
+	if err := os.WriteFile(path, raw, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
 	}
 	return nil
 }
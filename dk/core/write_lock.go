@@ -0,0 +1,47 @@
+package core
+
+import (
+	"context"
+	"github.com/philippgille/chromem-go"
+	"sync"
+)
+
+// Concurrency of the vector store
+//
+// chromem-go makes each call on a collection safe on its own, but most writes of this package
+// are several calls: adding a file looks up its stored chunks, removes them and adds the new
+// ones. Documents are added concurrently from MCP tools, the HTTP API, the RAG sources file and
+// the source watcher, so two of these sequences on the same file could interleave and leave it
+// stored twice or half removed.
+//
+// Every function of this package that writes to a collection therefore holds the write lock of
+// that collection for its whole sequence: AddDocument, AddDocuments, AppendDocument,
+// UpdateDocument, RemoveDocument, DeleteDocument, RestoreDocument, PurgeDocument,
+// DeleteAllDocuments, ToggleActiveMetadata, EnsureDocumentMetadata and FeedChromem. Writes to one collection run one at a time, embedding
+// included; writes to different collections run in parallel. Reads such as RetrieveDocuments and
+// GetDocument take no lock and see each write either before or after chromem applied it, but
+// may see a file between the removal of its old chunks and the addition of the new ones.
+//
+// The lock is held through the context: functions called with a context returned by
+// lockCollection do not wait for the lock again, so write functions can call each other. Such a
+// context must not be handed to other goroutines that write to the collection.
+
+// collectionLocks holds the write lock of each collection, by collection
+var collectionLocks sync.Map // *chromem.Collection -> *sync.Mutex
+
+// heldLockKey marks a context as holding the write lock of a collection
+type heldLockKey struct {
+	collection *chromem.Collection
+}
+
+// lockCollection takes the write lock of a collection unless the context already holds it. It
+// returns the context to write with and the function that releases the lock.
+func lockCollection(ctx context.Context, collection *chromem.Collection) (context.Context, func()) {
+	if held, _ := ctx.Value(heldLockKey{collection}).(bool); held {
+		return ctx, func() {}
+	}
+	value, _ := collectionLocks.LoadOrStore(collection, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return context.WithValue(ctx, heldLockKey{collection}, true), mu.Unlock
+}
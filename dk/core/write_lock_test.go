@@ -0,0 +1,93 @@
+package core
+
+import (
+	"context"
+	"dk/utils"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentWritesToOneFile(t *testing.T) {
+	ctx := WithCollections(context.Background(), newTestCollections(t, CollectionsConfig{}))
+	ctx = WithChunking(ctx, ChunkingConfig{Strategy: ChunkStrategySentence, Size: 5})
+	ctx, err := UseCollection(ctx, DefaultCollection, true)
+	if err != nil {
+		t.Fatalf("UseCollection failed: %v", err)
+	}
+
+	// Without serialization, writers interleave their lookups and removals and the file ends up
+	// stored several times
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			content := fmt.Sprintf("Version %d. %s", i, strings.Repeat("Long enough to be split into chunks. ", 80))
+			if err := AddDocument(ctx, "notes.txt", content, false, nil); err != nil {
+				t.Errorf("AddDocument failed: %v", err)
+			}
+		}(i)
+		if i%4 == 0 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := RemoveDocument(ctx, "notes.txt"); err != nil {
+					t.Errorf("RemoveDocument failed: %v", err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	collection, _ := utils.ChromemCollectionFromContext(ctx)
+	chunks, err := fileChunks(ctx, collection, "notes.txt")
+	if err != nil {
+		t.Fatalf("fileChunks failed: %v", err)
+	}
+	if len(chunks) == 0 {
+		return // A removal came last
+	}
+	versions := make(map[string]bool)
+	for _, chunk := range chunks {
+		versions[chunk.Metadata[contentHashKey]] = true
+	}
+	if count := chunks[0].Metadata[chunkCountKey]; len(versions) != 1 || count != fmt.Sprint(len(chunks)) {
+		t.Errorf("Expected the chunks of one version, got %d chunks of %d versions (chunk count %s)", len(chunks), len(versions), count)
+	}
+	if idx := KeywordIndexFromContext(ctx); idx.Len() != len(chunks) {
+		t.Errorf("Expected the keyword index to hold the %d stored chunks, got %d", len(chunks), idx.Len())
+	}
+}
+
+func TestAddDocuments(t *testing.T) {
+	ctx := WithCollections(context.Background(), newTestCollections(t, CollectionsConfig{}))
+	ctx, err := UseCollection(ctx, DefaultCollection, true)
+	if err != nil {
+		t.Fatalf("UseCollection failed: %v", err)
+	}
+	if err := AddDocument(ctx, "b.txt", "Old content", false, nil); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+
+	files := []NewDocument{
+		{FileName: "a.txt", Content: "First file"},
+		{FileName: "b.txt", Content: "New content", Metadata: map[string]string{"tags": "docs"}},
+		{FileName: "c.txt", Content: "Third file"},
+	}
+	if err := AddDocuments(ctx, files, false); err != nil {
+		t.Fatalf("AddDocuments failed: %v", err)
+	}
+	docs, err := ListDocuments(ctx)
+	if err != nil || len(docs) != 3 {
+		t.Fatalf("Expected 3 documents, got %+v (%v)", docs, err)
+	}
+	if docs[1].Content != "New content" || docs[1].Metadata["tags"] != "docs" {
+		t.Errorf("Expected b.txt to be replaced, got %+v", docs[1])
+	}
+
+	if err := AddDocuments(ctx, []NewDocument{{FileName: "d.txt"}, {FileName: "d.txt"}}, false); err == nil {
+		t.Error("Expected a file added twice in one batch to be rejected")
+	}
+}
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// APIArchive is the immutable record kept of an API once it is deprecated past its
+// deprecation date. The API itself is removed, so its name can be used again.
+type APIArchive struct {
+	APIID      string            `json:"api_id"`
+	Name       string            `json:"name"`
+	ArchivedAt time.Time         `json:"archived_at"`
+	API        API               `json:"api"`              // Configuration, without the key
+	Documents  []string          `json:"documents"`        // Filenames of the associated documents
+	Policy     *Policy           `json:"policy,omitempty"` // Policy in force, with its rules
+	Consumers  []APIArchiveGrant `json:"consumers"`        // External users with access when it was archived
+	Usage      APIArchiveUsage   `json:"usage"`            // Usage over the lifetime of the API
+}
+
+// APIArchiveGrant is the access an external user had to an archived API
+type APIArchiveGrant struct {
+	ExternalUserID string `json:"external_user_id"`
+	AccessLevel    string `json:"access_level"`
+}
+
+// APIArchiveUsage is the final usage summary of an archived API
+type APIArchiveUsage struct {
+	TotalRequests     int        `json:"total_requests"`
+	TotalTokens       int        `json:"total_tokens"`
+	TotalCredits      float64    `json:"total_credits"`
+	TotalTimeMs       int        `json:"total_time_ms"`
+	ThrottledRequests int        `json:"throttled_requests"`
+	BlockedRequests   int        `json:"blocked_requests"`
+	Consumers         int        `json:"consumers"` // Distinct external users that called the API
+	FirstUsedAt       *time.Time `json:"first_used_at,omitempty"`
+	LastUsedAt        *time.Time `json:"last_used_at,omitempty"`
+}
+
+// ListAPIsDueForArchive returns the IDs of deprecated APIs whose deprecation date is before now
+func ListAPIsDueForArchive(db *sql.DB, now time.Time) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT id FROM apis
+		WHERE is_deprecated = TRUE AND deprecation_date IS NOT NULL AND deprecation_date < ?
+		ORDER BY deprecation_date`, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deprecated APIs: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan deprecated API: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ArchiveAPI snapshots an API into api_archives and removes it with its access grants,
+// metadata and document associations. Usage records are left alone; the snapshot carries
+// their summary.
+func ArchiveAPI(db *sql.DB, apiID string, now time.Time) (*APIArchive, error) {
+	api, err := GetAPI(db, apiID)
+	if err != nil {
+		return nil, err
+	}
+	archive := &APIArchive{APIID: api.ID, Name: api.Name, ArchivedAt: now, API: *api}
+	archive.API.APIKey = ""
+	if archive.API.Metadata, err = GetAPIMetadata(db, apiID); err != nil {
+		return nil, err
+	}
+
+	documents, err := GetAPIDocuments(db, apiID)
+	if err != nil {
+		return nil, err
+	}
+	archive.Documents = make([]string, 0, len(documents))
+	for _, doc := range documents {
+		archive.Documents = append(archive.Documents, doc.DocumentFilename)
+	}
+
+	if api.PolicyID != nil {
+		policy, err := GetPolicyWithRules(db, *api.PolicyID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, err
+		}
+		archive.Policy = policy
+	}
+
+	grants, err := GetAPIExternalUsers(db, apiID)
+	if err != nil {
+		return nil, err
+	}
+	archive.Consumers = make([]APIArchiveGrant, 0, len(grants))
+	for _, grant := range grants {
+		archive.Consumers = append(archive.Consumers, APIArchiveGrant{
+			ExternalUserID: grant.ExternalUserID,
+			AccessLevel:    grant.AccessLevel,
+		})
+	}
+
+	if archive.Usage, err = lifetimeAPIUsage(db, apiID); err != nil {
+		return nil, err
+	}
+
+	snapshot, err := json.Marshal(archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode API archive: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`INSERT INTO api_archives (api_id, name, archived_at, snapshot) VALUES (?, ?, ?, ?)`,
+		archive.APIID, archive.Name, archive.ArchivedAt, string(snapshot)); err != nil {
+		return nil, fmt.Errorf("failed to store API archive: %v", err)
+	}
+	for _, query := range []string{
+		"DELETE FROM api_metadata WHERE api_id = ?",
+		"DELETE FROM api_user_access WHERE api_id = ?",
+		"DELETE FROM document_associations WHERE entity_type = 'api' AND entity_id = ?",
+		"DELETE FROM apis WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, apiID); err != nil {
+			return nil, fmt.Errorf("failed to remove archived API: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	invalidateAPIListCache()
+	return archive, nil
+}
+
+// GetAPIArchive retrieves the archive of an API
+func GetAPIArchive(db *sql.DB, apiID string) (*APIArchive, error) {
+	var snapshot string
+	err := db.QueryRow("SELECT snapshot FROM api_archives WHERE api_id = ?", apiID).Scan(&snapshot)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API archive: %v", err)
+	}
+
+	var archive APIArchive
+	if err := json.Unmarshal([]byte(snapshot), &archive); err != nil {
+		return nil, fmt.Errorf("failed to decode API archive: %v", err)
+	}
+	return &archive, nil
+}
+
+// lifetimeAPIUsage sums the usage of an API across all its consumers
+func lifetimeAPIUsage(db *sql.DB, apiID string) (APIArchiveUsage, error) {
+	var usage APIArchiveUsage
+	var totalRequests, totalTokens, totalTimeMs, throttled, blocked sql.NullInt64
+	var totalCredits sql.NullFloat64
+	err := db.QueryRow(`
+		SELECT
+			SUM(request_count), SUM(tokens_used), SUM(credits_consumed), SUM(execution_time_ms),
+			SUM(CASE WHEN was_throttled = TRUE THEN 1 ELSE 0 END),
+			SUM(CASE WHEN was_blocked = TRUE THEN 1 ELSE 0 END),
+			COUNT(DISTINCT external_user_id)
+		FROM api_usage
+		WHERE api_id = ?`, apiID).Scan(
+		&totalRequests, &totalTokens, &totalCredits, &totalTimeMs,
+		&throttled, &blocked, &usage.Consumers,
+	)
+	if err != nil {
+		return usage, fmt.Errorf("failed to summarize API usage: %v", err)
+	}
+
+	usage.TotalRequests = int(totalRequests.Int64)
+	usage.TotalTokens = int(totalTokens.Int64)
+	usage.TotalCredits = totalCredits.Float64
+	usage.TotalTimeMs = int(totalTimeMs.Int64)
+	usage.ThrottledRequests = int(throttled.Int64)
+	usage.BlockedRequests = int(blocked.Int64)
+	if usage.TotalRequests == 0 && usage.Consumers == 0 {
+		return usage, nil
+	}
+
+	// Read as columns rather than MIN and MAX, so the driver returns them as times
+	var first, last time.Time
+	if err := db.QueryRow("SELECT timestamp FROM api_usage WHERE api_id = ? ORDER BY timestamp ASC LIMIT 1", apiID).Scan(&first); err != nil {
+		return usage, fmt.Errorf("failed to query first API usage: %v", err)
+	}
+	if err := db.QueryRow("SELECT timestamp FROM api_usage WHERE api_id = ? ORDER BY timestamp DESC LIMIT 1", apiID).Scan(&last); err != nil {
+		return usage, fmt.Errorf("failed to query last API usage: %v", err)
+	}
+	usage.FirstUsedAt, usage.LastUsedAt = &first, &last
+	return usage, nil
+}
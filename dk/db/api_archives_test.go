@@ -0,0 +1,102 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestArchiveAPI(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	db := testDB.DB
+	if err := RunAPIMigrations(db); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	policy := &Policy{Name: "Free tier", Type: "free", IsActive: true}
+	if err := CreatePolicy(db, policy); err != nil {
+		t.Fatalf("CreatePolicy failed: %v", err)
+	}
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	weather := &API{Name: "Weather", IsActive: true, HostUserID: "host", PolicyID: &policy.ID,
+		IsDeprecated: true, DeprecationDate: &past, DeprecationMessage: "Use Weather v2"}
+	traffic := &API{Name: "Traffic", IsActive: true, HostUserID: "host", IsDeprecated: true, DeprecationDate: &future}
+	for _, api := range []*API{weather, traffic} {
+		if err := CreateAPI(db, api); err != nil {
+			t.Fatalf("CreateAPI failed: %v", err)
+		}
+	}
+	if err := SetAPIMetadata(db, weather.ID, map[string]string{"team": "forecasting"}); err != nil {
+		t.Fatalf("SetAPIMetadata failed: %v", err)
+	}
+	if err := CreateDocumentAssociation(db, &DocumentAssociation{DocumentFilename: "stations.csv", EntityID: weather.ID, EntityType: "api"}); err != nil {
+		t.Fatalf("CreateDocumentAssociation failed: %v", err)
+	}
+	if err := CreateAPIUserAccess(db, &APIUserAccess{APIID: weather.ID, ExternalUserID: "bob", AccessLevel: "read", IsActive: true}); err != nil {
+		t.Fatalf("CreateAPIUserAccess failed: %v", err)
+	}
+	for _, tokens := range []int{100, 250} {
+		if err := RecordAPIUsage(db, &APIUsage{APIID: weather.ID, ExternalUserID: "bob", RequestCount: 1, TokensUsed: tokens}); err != nil {
+			t.Fatalf("RecordAPIUsage failed: %v", err)
+		}
+	}
+
+	// Only APIs past their deprecation date are due
+	due, err := ListAPIsDueForArchive(db, now)
+	if err != nil {
+		t.Fatalf("ListAPIsDueForArchive failed: %v", err)
+	}
+	if len(due) != 1 || due[0] != weather.ID {
+		t.Fatalf("Expected only %s to be due, got %v", weather.ID, due)
+	}
+
+	if _, err := ArchiveAPI(db, weather.ID, now); err != nil {
+		t.Fatalf("ArchiveAPI failed: %v", err)
+	}
+	archive, err := GetAPIArchive(db, weather.ID)
+	if err != nil {
+		t.Fatalf("GetAPIArchive failed: %v", err)
+	}
+	if archive.Name != "Weather" || archive.API.APIKey != "" || archive.API.DeprecationMessage != "Use Weather v2" {
+		t.Errorf("Unexpected archived configuration: %+v", archive.API)
+	}
+	if archive.API.Metadata["team"] != "forecasting" {
+		t.Errorf("Expected the metadata to be archived, got %v", archive.API.Metadata)
+	}
+	if len(archive.Documents) != 1 || archive.Documents[0] != "stations.csv" {
+		t.Errorf("Unexpected documents manifest: %v", archive.Documents)
+	}
+	if archive.Policy == nil || archive.Policy.Name != "Free tier" {
+		t.Errorf("Expected the policy to be archived, got %+v", archive.Policy)
+	}
+	if len(archive.Consumers) != 1 || archive.Consumers[0].ExternalUserID != "bob" {
+		t.Errorf("Unexpected consumers: %+v", archive.Consumers)
+	}
+	if archive.Usage.TotalRequests != 2 || archive.Usage.TotalTokens != 350 || archive.Usage.Consumers != 1 || archive.Usage.LastUsedAt == nil {
+		t.Errorf("Unexpected usage summary: %+v", archive.Usage)
+	}
+
+	// The API is gone, so its name is free again
+	if _, err := GetAPI(db, weather.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the archived API to be removed, got %v", err)
+	}
+	if docs, _ := GetAPIDocuments(db, weather.ID); len(docs) != 0 {
+		t.Errorf("Expected the document associations to be removed, got %d", len(docs))
+	}
+
+	// Archives cannot be changed
+	if _, err := db.Exec("UPDATE api_archives SET name = 'Other' WHERE api_id = ?", weather.ID); err == nil {
+		t.Error("Expected updating an archive to fail")
+	}
+	if _, err := db.Exec("DELETE FROM api_archives WHERE api_id = ?", weather.ID); err == nil {
+		t.Error("Expected deleting an archive to fail")
+	}
+	if _, err := GetAPIArchive(db, traffic.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected no archive for an API still in use, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaskAPIKey returns the key with everything but its prefix and last four characters hidden,
+// enough to tell keys apart without exposing them. Keys too short to mask safely are hidden
+// entirely.
+func MaskAPIKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	prefix := ""
+	if i := strings.Index(key, "_"); i >= 0 && i < 8 {
+		prefix = key[:i+1]
+	}
+	if len(key)-len(prefix) < 12 {
+		return prefix + "****"
+	}
+	return prefix + "****" + key[len(key)-4:]
+}
+
+// CreateAPIKeyReveal records that an API key was shown in full in the audit trail
+func CreateAPIKeyReveal(db *sql.DB, reveal *APIKeyReveal) error {
+	if reveal.APIID == "" || reveal.RevealedBy == "" || strings.TrimSpace(reveal.Reason) == "" {
+		return fmt.Errorf("API key reveal requires an API, a user and a reason")
+	}
+
+	if reveal.ID == "" {
+		reveal.ID = uuid.New().String()
+	}
+
+	if reveal.CreatedAt.IsZero() {
+		reveal.CreatedAt = time.Now()
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO api_key_reveals (id, api_id, revealed_by, reason, remote_addr, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, reveal.ID, reveal.APIID, reveal.RevealedBy, reveal.Reason, reveal.RemoteAddr, reveal.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record API key reveal: %v", err)
+	}
+
+	return nil
+}
+
+// ListAPIKeyReveals retrieves the audited reveals of an API's key, newest first
+func ListAPIKeyReveals(db *sql.DB, apiID string, limit, offset int) ([]*APIKeyReveal, int, error) {
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM api_key_reveals WHERE api_id = ?", apiID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count API key reveals: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, api_id, revealed_by, reason, remote_addr, created_at
+		FROM api_key_reveals
+		WHERE api_id = ?
+		ORDER BY created_at DESC
+		LIMIT ? OFFSET ?
+	`, apiID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query API key reveals: %v", err)
+	}
+	defer rows.Close()
+
+	reveals := []*APIKeyReveal{}
+	for rows.Next() {
+		reveal := &APIKeyReveal{}
+		var remoteAddr sql.NullString
+		if err := rows.Scan(&reveal.ID, &reveal.APIID, &reveal.RevealedBy, &reveal.Reason, &remoteAddr, &reveal.CreatedAt); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan API key reveal row: %v", err)
+		}
+		reveal.RemoteAddr = remoteAddr.String
+		reveals = append(reveals, reveal)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating API key reveal rows: %v", err)
+	}
+
+	return reveals, total, nil
+}
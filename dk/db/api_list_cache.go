@@ -0,0 +1,219 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+// apiListCacheTTL bounds how long a listing is served from the cache. Writes through this
+// package invalidate the cache right away; the TTL covers writes in transactions, whose
+// invalidation happens before they commit.
+const apiListCacheTTL = 30 * time.Second
+
+// maxAPIListCacheEntries bounds the number of cached pages; the cache is reset when full
+const maxAPIListCacheEntries = 256
+
+// apiSummarySelect fetches APIs with their user and document counts and their policy in a
+// single query, instead of three more queries per API
+const apiSummarySelect = `
+	SELECT a.id, a.name, a.description, a.created_at, a.updated_at, a.is_active,
+		a.api_key, a.host_user_id, a.policy_id, a.is_deprecated,
+		a.deprecation_date, a.deprecation_message,
+		COALESCE(u.users, 0), COALESCE(d.documents, 0), p.name, p.type
+	FROM apis a
+	LEFT JOIN (
+		SELECT api_id, COUNT(*) AS users FROM api_user_access WHERE is_active = TRUE GROUP BY api_id
+	) u ON u.api_id = a.id
+	LEFT JOIN (
+		SELECT entity_id, COUNT(*) AS documents FROM document_associations WHERE entity_type = 'api' GROUP BY entity_id
+	) d ON d.entity_id = a.id
+	LEFT JOIN policies p ON p.id = a.policy_id`
+
+// apiListKey identifies a cached page of an API listing
+type apiListKey struct {
+	db             *sql.DB
+	status         string
+	externalUserID string
+	policyID       string
+	metadata       string
+	limit, offset  int
+	sort, order    string
+}
+
+type apiListEntry struct {
+	summaries []APISummary
+	total     int
+	expires   time.Time
+}
+
+// apiListCache holds recent API listings. The generation is bumped on every write, so a
+// listing read concurrently with a write is not cached.
+var apiListCache = struct {
+	sync.Mutex
+	generation uint64
+	entries    map[apiListKey]apiListEntry
+}{entries: map[apiListKey]apiListEntry{}}
+
+// invalidateAPIListCache drops all cached listings. It is called by every function that writes
+// to apis, api_metadata, api_user_access, document_associations or policies.
+func invalidateAPIListCache() {
+	apiListCache.Lock()
+	apiListCache.generation++
+	apiListCache.entries = map[apiListKey]apiListEntry{}
+	apiListCache.Unlock()
+}
+
+// ListAPISummaries is ListAPIs with the user and document counts and the policy of every API,
+// served from an in-process cache when the same page was listed recently. Only APIs whose
+// metadata has all the values in metadata are listed.
+func ListAPISummaries(db *sql.DB, status, externalUserID string, metadata map[string]string, limit, offset int, sort, order string) ([]*APISummary, int, error) {
+	where := ""
+	args := []interface{}{}
+
+	switch status {
+	case "active":
+		where += " AND a.is_active = TRUE AND a.is_deprecated = FALSE"
+	case "inactive":
+		where += " AND a.is_active = FALSE AND a.is_deprecated = FALSE"
+	case "deprecated":
+		where += " AND a.is_deprecated = TRUE"
+	}
+
+	if externalUserID != "" {
+		where += " AND a.id IN (SELECT api_id FROM api_user_access WHERE external_user_id = ? AND is_active = TRUE)"
+		args = append(args, externalUserID)
+	}
+
+	metadataWhere, metadataArgs := metadataFilter(metadata)
+	where += metadataWhere
+	args = append(args, metadataArgs...)
+
+	key := apiListKey{db: db, status: status, externalUserID: externalUserID, metadata: metadataCacheKey(metadata), limit: limit, offset: offset, sort: sort, order: order}
+	return cachedAPISummaries(db, key, where, args)
+}
+
+// ListAPISummariesByPolicy is ListAPIsByPolicy with the user and document counts of every API
+func ListAPISummariesByPolicy(db *sql.DB, policyID string, limit, offset int, sort, order string) ([]*APISummary, int, error) {
+	key := apiListKey{db: db, policyID: policyID, limit: limit, offset: offset, sort: sort, order: order}
+	return cachedAPISummaries(db, key, " AND a.policy_id = ?", []interface{}{policyID})
+}
+
+// cachedAPISummaries returns a listing from the cache or queries and caches it
+func cachedAPISummaries(db *sql.DB, key apiListKey, where string, args []interface{}) ([]*APISummary, int, error) {
+	apiListCache.Lock()
+	entry, ok := apiListCache.entries[key]
+	generation := apiListCache.generation
+	apiListCache.Unlock()
+
+	if !ok || time.Now().After(entry.expires) {
+		summaries, total, err := queryAPISummaries(db, where, args, key.limit, key.offset, key.sort, key.order)
+		if err != nil {
+			return nil, 0, err
+		}
+		entry = apiListEntry{summaries: summaries, total: total, expires: time.Now().Add(apiListCacheTTL)}
+
+		apiListCache.Lock()
+		if apiListCache.generation == generation {
+			if len(apiListCache.entries) >= maxAPIListCacheEntries {
+				apiListCache.entries = map[apiListKey]apiListEntry{}
+			}
+			apiListCache.entries[key] = entry
+		}
+		apiListCache.Unlock()
+	}
+
+	// Callers get their own copies, so they cannot modify the cached listing
+	result := make([]*APISummary, len(entry.summaries))
+	for i := range entry.summaries {
+		summary := entry.summaries[i]
+		summary.Metadata = maps.Clone(summary.Metadata)
+		result[i] = &summary
+	}
+	return result, entry.total, nil
+}
+
+// queryAPISummaries runs the listing query with the given filter, sorting and pagination
+func queryAPISummaries(db *sql.DB, where string, args []interface{}, limit, offset int, sort, order string) ([]APISummary, int, error) {
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM apis a WHERE 1=1"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count APIs: %v", err)
+	}
+
+	if sort != "name" && sort != "created_at" {
+		sort = "created_at"
+	}
+	if order != "asc" && order != "desc" {
+		order = "desc"
+	}
+
+	query := apiSummarySelect + " WHERE 1=1" + where + " ORDER BY a." + sort + " " + order + " LIMIT ? OFFSET ?"
+	rows, err := db.Query(query, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query APIs: %v", err)
+	}
+	defer rows.Close()
+
+	summaries := []APISummary{}
+	for rows.Next() {
+		var summary APISummary
+		var policyID, deprecationMessage, policyName, policyType sql.NullString
+		var deprecationDate sql.NullTime
+
+		err := rows.Scan(
+			&summary.ID,
+			&summary.Name,
+			&summary.Description,
+			&summary.CreatedAt,
+			&summary.UpdatedAt,
+			&summary.IsActive,
+			&summary.APIKey,
+			&summary.HostUserID,
+			&policyID,
+			&summary.IsDeprecated,
+			&deprecationDate,
+			&deprecationMessage,
+			&summary.ExternalUsersCount,
+			&summary.DocumentsCount,
+			&policyName,
+			&policyType,
+		)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan API row: %v", err)
+		}
+
+		if policyID.Valid {
+			policyIDStr := policyID.String
+			summary.PolicyID = &policyIDStr
+		}
+		if deprecationDate.Valid {
+			summary.DeprecationDate = &deprecationDate.Time
+		}
+		summary.DeprecationMessage = deprecationMessage.String
+		summary.PolicyName = policyName.String
+		summary.PolicyType = policyType.String
+
+		summaries = append(summaries, summary)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating API rows: %v", err)
+	}
+	rows.Close()
+
+	ids := make([]string, len(summaries))
+	for i := range summaries {
+		ids[i] = summaries[i].ID
+	}
+	metadata, err := getAPIsMetadata(db, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range summaries {
+		summaries[i].Metadata = metadata[summaries[i].ID]
+	}
+
+	return summaries, total, nil
+}
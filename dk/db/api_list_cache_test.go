@@ -0,0 +1,77 @@
+package db
+
+import (
+	"testing"
+)
+
+// TestListAPISummaries tests the batched listing and its invalidation on writes
+func TestListAPISummaries(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	db := testDB.DB
+	if err := RunAPIMigrations(db); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	policy := &Policy{Name: "Free tier", Type: "free", IsActive: true}
+	if err := CreatePolicy(db, policy); err != nil {
+		t.Fatalf("CreatePolicy failed: %v", err)
+	}
+	withPolicy := &API{Name: "Weather", IsActive: true, HostUserID: "host", PolicyID: &policy.ID}
+	plain := &API{Name: "Archive", IsActive: true, HostUserID: "host"}
+	for _, api := range []*API{withPolicy, plain} {
+		if err := CreateAPI(db, api); err != nil {
+			t.Fatalf("CreateAPI failed: %v", err)
+		}
+	}
+	for _, user := range []string{"alice", "bob"} {
+		if err := CreateAPIUserAccess(db, &APIUserAccess{APIID: withPolicy.ID, ExternalUserID: user, AccessLevel: "read", IsActive: true}); err != nil {
+			t.Fatalf("CreateAPIUserAccess failed: %v", err)
+		}
+	}
+	if err := CreateDocumentAssociation(db, &DocumentAssociation{DocumentFilename: "forecast.txt", EntityID: withPolicy.ID, EntityType: "api"}); err != nil {
+		t.Fatalf("CreateDocumentAssociation failed: %v", err)
+	}
+
+	summaries, total, err := ListAPISummaries(db, "", "", nil, 10, 0, "name", "asc")
+	if err != nil {
+		t.Fatalf("ListAPISummaries failed: %v", err)
+	}
+	if total != 2 || len(summaries) != 2 {
+		t.Fatalf("Expected 2 APIs, got %d (total %d)", len(summaries), total)
+	}
+	archive, weather := summaries[0], summaries[1]
+	if archive.Name != "Archive" || archive.ExternalUsersCount != 0 || archive.DocumentsCount != 0 || archive.PolicyName != "" {
+		t.Errorf("Unexpected summary for the API without users: %+v", archive)
+	}
+	if weather.ExternalUsersCount != 2 || weather.DocumentsCount != 1 || weather.PolicyName != "Free tier" || weather.PolicyType != "free" {
+		t.Errorf("Unexpected summary for the API with users: %+v", weather)
+	}
+
+	// Modifying a result must not change the cached listing
+	weather.Name = "Changed"
+	summaries, _, _ = ListAPISummaries(db, "", "", nil, 10, 0, "name", "asc")
+	if summaries[1].Name != "Weather" {
+		t.Errorf("Cached listing was modified by a caller: %q", summaries[1].Name)
+	}
+
+	// A write invalidates the cache
+	if err := CreateAPIUserAccess(db, &APIUserAccess{APIID: plain.ID, ExternalUserID: "carol", AccessLevel: "read", IsActive: true}); err != nil {
+		t.Fatalf("CreateAPIUserAccess failed: %v", err)
+	}
+	summaries, _, _ = ListAPISummaries(db, "", "carol", nil, 10, 0, "", "")
+	if len(summaries) != 1 || summaries[0].ID != plain.ID || summaries[0].ExternalUsersCount != 1 {
+		t.Errorf("Expected the new access in the listing, got %+v", summaries)
+	}
+
+	byPolicy, total, err := ListAPISummariesByPolicy(db, policy.ID, 10, 0, "", "")
+	if err != nil {
+		t.Fatalf("ListAPISummariesByPolicy failed: %v", err)
+	}
+	if total != 1 || len(byPolicy) != 1 || byPolicy[0].ID != withPolicy.ID {
+		t.Errorf("Expected only the API with the policy, got %d (total %d)", len(byPolicy), total)
+	}
+}
@@ -375,3 +375,87 @@ func TestAPIRequestCRUD(t *testing.T) {
 		t.Errorf("Delete failed: API request still exists with ID %s", requestID)
 	}
 }
+
+// TestRotateAPIKey tests that rotating replaces the stored key
+func TestRotateAPIKey(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	db := testDB.DB
+	if err := RunAPIMigrations(db); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	api := &API{Name: "Weather", IsActive: true, HostUserID: "host"}
+	if err := CreateAPI(db, api); err != nil {
+		t.Fatalf("CreateAPI failed: %v", err)
+	}
+
+	key, err := RotateAPIKey(db, api.ID)
+	if err != nil {
+		t.Fatalf("RotateAPIKey failed: %v", err)
+	}
+	if key == "" || key == api.APIKey {
+		t.Errorf("Expected a new key, got %q (old %q)", key, api.APIKey)
+	}
+	stored, err := GetAPI(db, api.ID)
+	if err != nil {
+		t.Fatalf("GetAPI failed: %v", err)
+	}
+	if stored.APIKey != key {
+		t.Errorf("Expected the stored key to be %q, got %q", key, stored.APIKey)
+	}
+
+	if _, err := RotateAPIKey(db, "missing"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an unknown API, got %v", err)
+	}
+}
+
+// TestTrackerCRUD tests creating, listing and deactivating trackers
+func TestTrackerCRUD(t *testing.T) {
+	if os.Getenv("SKIP_DB_TESTS") != "" {
+		t.Skip("Skipping database test due to SKIP_DB_TESTS environment variable")
+	}
+
+	db := setupTestDB(t)
+
+	suffix := uuid.New().String()
+	audit := &Tracker{Name: "Audit log " + suffix, Description: "Records every call", IsActive: true}
+	consent := &Tracker{Name: "Consent " + suffix, IsActive: true}
+	for _, tracker := range []*Tracker{audit, consent} {
+		if err := CreateTracker(db, tracker); err != nil {
+			t.Fatalf("Failed to create tracker: %v", err)
+		}
+		if tracker.ID == "" {
+			t.Fatal("Expected CreateTracker to set an ID")
+		}
+	}
+
+	if err := SetTrackerActive(db, consent.ID, false); err != nil {
+		t.Fatalf("Failed to deactivate tracker: %v", err)
+	}
+	if err := SetTrackerActive(db, uuid.New().String(), false); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an unknown tracker, got %v", err)
+	}
+
+	names := func(activeOnly bool) map[string]bool {
+		trackers, err := ListTrackers(db, activeOnly)
+		if err != nil {
+			t.Fatalf("Failed to list trackers: %v", err)
+		}
+		found := map[string]bool{}
+		for _, tracker := range trackers {
+			found[tracker.Name] = tracker.IsActive
+		}
+		return found
+	}
+	all := names(false)
+	if active, listed := all[consent.Name]; !all[audit.Name] || !listed || active {
+		t.Errorf("Expected the audit tracker active and the consent tracker listed as inactive, got %v", all)
+	}
+	if _, ok := names(true)[consent.Name]; ok {
+		t.Error("Expected the active trackers to leave out the inactive one")
+	}
+}
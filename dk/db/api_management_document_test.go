@@ -513,3 +513,47 @@ func TestDocumentAssociationCRUD(t *testing.T) {
 		}
 	})
 }
+
+// TestListAssociatedDocumentFilenames tests counting associations per document filename
+func TestListAssociatedDocumentFilenames(t *testing.T) {
+	db := setupTestDB(t)
+
+	apiID := uuid.New().String()
+	_, err := db.Exec(`
+		INSERT INTO apis (id, name, api_key, host_user_id)
+		VALUES (?, ?, ?, ?)`,
+		apiID, "Filename API", "test_key_"+apiID[0:8], "test_host")
+	assert.NoError(t, err, "Failed to insert test API")
+
+	requestID := uuid.New().String()
+	_, err = db.Exec(`
+		INSERT INTO api_requests (id, api_name, status, requester_id)
+		VALUES (?, ?, ?, ?)`,
+		requestID, "Filename API", "pending", "test_requester")
+	assert.NoError(t, err, "Failed to insert test API request")
+
+	shared := "shared_" + apiID[0:8] + ".txt"
+	single := "single_" + apiID[0:8] + ".txt"
+
+	for _, assoc := range []*DocumentAssociation{
+		{ID: uuid.New().String(), DocumentFilename: shared, EntityID: apiID, EntityType: "api"},
+		{ID: uuid.New().String(), DocumentFilename: shared, EntityID: requestID, EntityType: "request"},
+		{ID: uuid.New().String(), DocumentFilename: single, EntityID: apiID, EntityType: "api"},
+	} {
+		assoc.CreatedAt = time.Now()
+		assert.NoError(t, CreateDocumentAssociation(db, assoc), "Failed to create document association")
+	}
+
+	filenames, err := ListAssociatedDocumentFilenames(db)
+	assert.NoError(t, err, "Failed to list associated document filenames")
+	assert.Equal(t, 2, filenames[shared], "Shared document should have two associations")
+	assert.Equal(t, 1, filenames[single], "Single document should have one association")
+
+	assert.NoError(t, DeleteAllDocumentAssociationsByFilename(db, shared))
+
+	filenames, err = ListAssociatedDocumentFilenames(db)
+	assert.NoError(t, err, "Failed to list associated document filenames")
+	_, exists := filenames[shared]
+	assert.False(t, exists, "Deleted document should no longer be listed")
+	assert.Equal(t, 1, filenames[single], "Single document should be unaffected")
+}
@@ -18,6 +18,17 @@ type API struct {
 	IsDeprecated       bool       `json:"is_deprecated"`
 	DeprecationDate    *time.Time `json:"deprecation_date,omitempty"`
 	DeprecationMessage string     `json:"deprecation_message,omitempty"`
+	// Metadata holds custom key/value fields such as the team or data classification
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// APISummary is an API with the counts and policy shown in API listings
+type APISummary struct {
+	API
+	ExternalUsersCount int    // Active external users with access
+	DocumentsCount     int    // Documents associated with the API
+	PolicyName         string // Empty when the API has no policy
+	PolicyType         string
 }
 
 // APIRequest represents a request for API access
@@ -130,6 +141,34 @@ type APIUsageSummary struct {
 	LastUpdated       time.Time `json:"last_updated"`
 }
 
+// CreditPricing defines how many credits a policy charges for usage
+type CreditPricing struct {
+	PolicyID          string    `json:"policy_id"`
+	CreditsPerRequest float64   `json:"credits_per_request"`
+	CreditsPerToken   float64   `json:"credits_per_token"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// CreditBalance represents the remaining credits of an external consumer
+type CreditBalance struct {
+	ExternalUserID string    `json:"external_user_id"`
+	Balance        float64   `json:"balance"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// CreditLedgerEntry represents a single change to a consumer's credit balance
+type CreditLedgerEntry struct {
+	ID             string    `json:"id"`
+	ExternalUserID string    `json:"external_user_id"`
+	APIID          string    `json:"api_id,omitempty"` // Set for debits
+	EntryType      string    `json:"entry_type"`       // 'topup', 'debit'
+	Amount         float64   `json:"amount"`           // Positive for top-ups, negative for debits
+	BalanceAfter   float64   `json:"balance_after"`
+	Reference      string    `json:"reference,omitempty"` // Endpoint of a debit or note of a top-up
+	CreatedBy      string    `json:"created_by,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
 // PolicyChange represents a history record of policy changes for an API
 type PolicyChange struct {
 	ID            string     `json:"id"`
@@ -155,3 +194,49 @@ type QuotaNotification struct {
 	IsRead           bool       `json:"is_read"`
 	ReadAt           *time.Time `json:"read_at,omitempty"`
 }
+
+// CollectionResidency represents a data residency constraint on a chromem collection
+type CollectionResidency struct {
+	CollectionName string    `json:"collection_name"`
+	Region         string    `json:"region"` // e.g. 'EU', 'US'
+	UpdatedAt      time.Time `json:"updated_at"`
+	UpdatedBy      string    `json:"updated_by,omitempty"`
+}
+
+// ConsumerRegion represents the region an external consumer is flagged in
+type ConsumerRegion struct {
+	ExternalUserID string    `json:"external_user_id"`
+	Region         string    `json:"region"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// ResidencyOverride represents an audited, confirmed bypass of a residency constraint
+type ResidencyOverride struct {
+	ID               string    `json:"id"`
+	CollectionName   string    `json:"collection_name"`
+	RequiredRegion   string    `json:"required_region"`
+	DocumentFilename string    `json:"document_filename,omitempty"`
+	TargetType       string    `json:"target_type"` // 'api', 'export'
+	TargetID         string    `json:"target_id"`
+	TargetRegion     string    `json:"target_region,omitempty"`
+	ConfirmedBy      string    `json:"confirmed_by"`
+	Reason           string    `json:"reason"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// APIKeyReveal is an audit record of an API key shown in full
+type APIKeyReveal struct {
+	ID         string    `json:"id"`
+	APIID      string    `json:"api_id"`
+	RevealedBy string    `json:"revealed_by"`
+	Reason     string    `json:"reason"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ResidencyViolation describes a consumer whose region conflicts with a residency constraint
+type ResidencyViolation struct {
+	ExternalUserID string `json:"external_user_id"`
+	Region         string `json:"region"`
+	RequiredRegion string `json:"required_region"`
+}
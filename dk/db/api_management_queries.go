@@ -13,6 +13,8 @@ import (
 
 // CreateAPI inserts a new API record
 func CreateAPI(db *sql.DB, api *API) error {
+	defer invalidateAPIListCache()
+
 	// Generate UUID if not provided
 	if api.ID == "" {
 		api.ID = uuid.New().String()
@@ -61,6 +63,8 @@ func CreateAPI(db *sql.DB, api *API) error {
 
 // CreateAPITx inserts a new API record within a transaction
 func CreateAPITx(tx *sql.Tx, api *API) error {
+	defer invalidateAPIListCache()
+
 	// Generate UUID if not provided
 	if api.ID == "" {
 		api.ID = uuid.New().String()
@@ -157,11 +161,17 @@ func GetAPI(db *sql.DB, id string) (*API, error) {
 		api.DeprecationMessage = deprecationMessage.String
 	}
 
+	if api.Metadata, err = GetAPIMetadata(db, id); err != nil {
+		return nil, err
+	}
+
 	return api, nil
 }
 
 // UpdateAPI updates an existing API record
 func UpdateAPI(db *sql.DB, api *API) error {
+	defer invalidateAPIListCache()
+
 	// Update timestamp
 	api.UpdatedAt = time.Now()
 
@@ -205,8 +215,42 @@ func UpdateAPI(db *sql.DB, api *API) error {
 	return nil
 }
 
+// RotateAPIKey replaces the key of an API with a newly generated one and returns it. The old
+// key stops working immediately.
+func RotateAPIKey(db *sql.DB, id string) (string, error) {
+	defer invalidateAPIListCache()
+
+	apiKey, err := generateAPIKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate API key: %v", err)
+	}
+
+	result, err := db.Exec("UPDATE apis SET api_key = ?, updated_at = ? WHERE id = ?", apiKey, time.Now(), id)
+	if err != nil {
+		return "", err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+
+	if rows == 0 {
+		return "", ErrNotFound
+	}
+
+	return apiKey, nil
+}
+
 // DeleteAPI deletes an API record
 func DeleteAPI(db *sql.DB, id string) error {
+	defer invalidateAPIListCache()
+
+	// Metadata goes with the API even when foreign keys are not enforced
+	if _, err := db.Exec("DELETE FROM api_metadata WHERE api_id = ?", id); err != nil {
+		return err
+	}
+
 	query := "DELETE FROM apis WHERE id = ?"
 	result, err := db.Exec(query, id)
 	if err != nil {
@@ -575,6 +619,8 @@ func ListAPIUserAccess(db *sql.DB, apiID string, activeOnly bool, limit, offset
 
 // UpdateAPIUserAccess updates an existing API user access record
 func UpdateAPIUserAccess(db *sql.DB, access *APIUserAccess) error {
+	defer invalidateAPIListCache()
+
 	query := `
 		UPDATE api_user_access
 		SET access_level = ?, revoked_at = ?, is_active = ?
@@ -650,10 +696,47 @@ func GetAPIDocuments(db *sql.DB, apiID string) ([]*DocumentAssociation, error) {
 	return docs, nil
 }
 
+// GetUserAccessibleDocuments returns the file names of the documents associated with the
+// active APIs an external user has active access to
+func GetUserAccessibleDocuments(db *sql.DB, externalUserID string) ([]string, error) {
+	query := `
+		SELECT DISTINCT da.document_filename
+		FROM document_associations da
+		JOIN api_user_access ua ON ua.api_id = da.entity_id
+		JOIN apis a ON a.id = da.entity_id
+		WHERE da.entity_type = 'api' AND ua.external_user_id = ?
+			AND ua.is_active = TRUE AND a.is_active = TRUE
+		ORDER BY da.document_filename
+	`
+
+	rows, err := db.Query(query, externalUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query accessible documents: %v", err)
+	}
+	defer rows.Close()
+
+	files := []string{}
+	for rows.Next() {
+		var file string
+		if err := rows.Scan(&file); err != nil {
+			return nil, fmt.Errorf("failed to scan accessible document: %v", err)
+		}
+		files = append(files, file)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating accessible documents: %v", err)
+	}
+
+	return files, nil
+}
+
 // Document association database functions
 
 // CreateDocumentAssociation creates a new document association
 func CreateDocumentAssociation(db *sql.DB, assoc *DocumentAssociation) error {
+	defer invalidateAPIListCache()
+
 	// Check if association already exists
 	var count int
 	err := db.QueryRow(
@@ -703,6 +786,8 @@ func CreateDocumentAssociation(db *sql.DB, assoc *DocumentAssociation) error {
 
 // CreateDocumentAssociationTx creates a new document association within a transaction
 func CreateDocumentAssociationTx(tx *sql.Tx, assoc *DocumentAssociation) error {
+	defer invalidateAPIListCache()
+
 	// Check if association already exists
 	var count int
 	err := tx.QueryRow(
@@ -921,6 +1006,8 @@ func GetAllAssociationsForDocument(db *sql.DB, filename string) ([]*DocumentAsso
 
 // DeleteDocumentAssociation deletes a document association by ID
 func DeleteDocumentAssociation(db *sql.DB, id string) error {
+	defer invalidateAPIListCache()
+
 	query := "DELETE FROM document_associations WHERE id = ?"
 
 	result, err := db.Exec(query, id)
@@ -940,8 +1027,20 @@ func DeleteDocumentAssociation(db *sql.DB, id string) error {
 	return nil
 }
 
+// CountDocumentAssociationsByFilename returns the number of associations referencing a document
+func CountDocumentAssociationsByFilename(db *sql.DB, filename string) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM document_associations WHERE document_filename = ?", filename).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count document associations: %v", err)
+	}
+	return count, nil
+}
+
 // DeleteAllDocumentAssociationsByFilename deletes all associations for a document
 func DeleteAllDocumentAssociationsByFilename(db *sql.DB, filename string) error {
+	defer invalidateAPIListCache()
+
 	query := "DELETE FROM document_associations WHERE document_filename = ?"
 
 	_, err := db.Exec(query, filename)
@@ -954,6 +1053,8 @@ func DeleteAllDocumentAssociationsByFilename(db *sql.DB, filename string) error
 
 // DeleteAllDocumentAssociationsByFilenameTx deletes all associations for a document within a transaction
 func DeleteAllDocumentAssociationsByFilenameTx(tx *sql.Tx, filename string) error {
+	defer invalidateAPIListCache()
+
 	query := "DELETE FROM document_associations WHERE document_filename = ?"
 
 	_, err := tx.Exec(query, filename)
@@ -964,8 +1065,42 @@ func DeleteAllDocumentAssociationsByFilenameTx(tx *sql.Tx, filename string) erro
 	return nil
 }
 
+// ListAssociatedDocumentFilenames returns every filename referenced by a document association
+// together with the number of associations pointing at it
+func ListAssociatedDocumentFilenames(db *sql.DB) (map[string]int, error) {
+	query := `
+		SELECT document_filename, COUNT(*)
+		FROM document_associations
+		GROUP BY document_filename
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query associated document filenames: %v", err)
+	}
+	defer rows.Close()
+
+	filenames := make(map[string]int)
+	for rows.Next() {
+		var filename string
+		var count int
+		if err := rows.Scan(&filename, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan associated document filename: %v", err)
+		}
+		filenames[filename] = count
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating associated document filenames: %v", err)
+	}
+
+	return filenames, nil
+}
+
 // CreateAPIUserAccess inserts a new API user access record
 func CreateAPIUserAccess(db *sql.DB, access *APIUserAccess) error {
+	defer invalidateAPIListCache()
+
 	// Generate UUID if not provided
 	if access.ID == "" {
 		access.ID = uuid.New().String()
@@ -998,6 +1133,8 @@ func CreateAPIUserAccess(db *sql.DB, access *APIUserAccess) error {
 
 // CreateAPIUserAccessTx inserts a new API user access record within a transaction
 func CreateAPIUserAccessTx(tx *sql.Tx, access *APIUserAccess) error {
+	defer invalidateAPIListCache()
+
 	// Generate UUID if not provided
 	if access.ID == "" {
 		access.ID = uuid.New().String()
@@ -1932,6 +2069,89 @@ func GetTracker(tx *sql.Tx, id string) (*Tracker, error) {
 	return tracker, nil
 }
 
+// CreateTracker creates a tracker that API requests can require
+func CreateTracker(db *sql.DB, tracker *Tracker) error {
+	// Generate UUID if not provided
+	if tracker.ID == "" {
+		tracker.ID = uuid.New().String()
+	}
+	if tracker.CreatedAt.IsZero() {
+		tracker.CreatedAt = time.Now()
+	}
+
+	query := "INSERT INTO trackers (id, name, description, is_active, created_at) VALUES (?, ?, ?, ?, ?)"
+
+	_, err := db.Exec(query, tracker.ID, tracker.Name, tracker.Description, tracker.IsActive, tracker.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create tracker: %v", err)
+	}
+
+	return nil
+}
+
+// ListTrackers retrieves the trackers ordered by name, only the active ones if activeOnly is set
+func ListTrackers(db *sql.DB, activeOnly bool) ([]*Tracker, error) {
+	query := "SELECT id, name, description, is_active, created_at FROM trackers"
+	if activeOnly {
+		query += " WHERE is_active = TRUE"
+	}
+	query += " ORDER BY name, created_at"
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query trackers: %v", err)
+	}
+	defer rows.Close()
+
+	trackers := []*Tracker{}
+	for rows.Next() {
+		tracker := &Tracker{}
+		var description sql.NullString
+
+		err := rows.Scan(
+			&tracker.ID,
+			&tracker.Name,
+			&description,
+			&tracker.IsActive,
+			&tracker.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tracker row: %v", err)
+		}
+
+		if description.Valid {
+			tracker.Description = description.String
+		}
+
+		trackers = append(trackers, tracker)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating tracker rows: %v", err)
+	}
+
+	return trackers, nil
+}
+
+// SetTrackerActive activates or deactivates a tracker. Requests that already require an
+// inactive tracker keep it, but new requests cannot.
+func SetTrackerActive(db *sql.DB, id string, active bool) error {
+	result, err := db.Exec("UPDATE trackers SET is_active = ? WHERE id = ?", active, id)
+	if err != nil {
+		return fmt.Errorf("failed to update tracker: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
 // Helper function to generate a secure API key
 func generateAPIKey() (string, error) {
 	// Example implementation using UUID as the base
@@ -2024,6 +2244,8 @@ func ListPolicies(db *sql.DB, policyType string, activeOnly bool, createdBy stri
 
 // UpdatePolicy updates an existing policy
 func UpdatePolicy(db *sql.DB, policy *Policy) error {
+	defer invalidateAPIListCache()
+
 	query := `
 		UPDATE policies
 		SET name = ?, description = ?, is_active = ?,
@@ -2058,6 +2280,8 @@ func UpdatePolicy(db *sql.DB, policy *Policy) error {
 
 // UpdatePolicyTx updates an existing policy within a transaction
 func UpdatePolicyTx(tx *sql.Tx, policy *Policy) error {
+	defer invalidateAPIListCache()
+
 	query := `
 		UPDATE policies
 		SET name = ?, description = ?, is_active = ?,
@@ -2092,6 +2316,8 @@ func UpdatePolicyTx(tx *sql.Tx, policy *Policy) error {
 
 // DeletePolicy permanently deletes a policy
 func DeletePolicy(db *sql.DB, id string) error {
+	defer invalidateAPIListCache()
+
 	query := "DELETE FROM policies WHERE id = ?"
 
 	result, err := db.Exec(query, id)
@@ -2396,6 +2622,8 @@ func GetPendingPolicyChanges(db *sql.DB) ([]*PolicyChange, error) {
 
 // ApplyPendingPolicyChange applies a pending policy change
 func ApplyPendingPolicyChange(db *sql.DB, change *PolicyChange) error {
+	defer invalidateAPIListCache()
+
 	if change.NewPolicyID == nil {
 		return fmt.Errorf("cannot apply change without a new policy ID")
 	}
@@ -54,7 +54,8 @@ func RunAPIMigrations(db *sql.DB) error {
 		entity_type TEXT NOT NULL CHECK (entity_type IN ('api', 'request')),
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		UNIQUE (document_filename, entity_id, entity_type)
-	);`
+	);
+	CREATE INDEX IF NOT EXISTS idx_document_associations_entity ON document_associations(entity_type, entity_id);`
 
 	// API external user access permissions
 	apiUserAccessTable := `
@@ -188,6 +189,117 @@ func RunAPIMigrations(db *sql.DB) error {
 		FOREIGN KEY (api_id) REFERENCES apis(id) ON DELETE CASCADE
 	);`
 
+	// Data residency constraints attached to chromem collections
+	collectionResidencyTable := `
+	CREATE TABLE IF NOT EXISTS collection_residency (
+		collection_name TEXT PRIMARY KEY,             -- Name of the chromem collection
+		region TEXT NOT NULL,                         -- Required region (e.g., 'EU')
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_by TEXT
+	);`
+
+	// Regions flagged for external consumers
+	consumerRegionsTable := `
+	CREATE TABLE IF NOT EXISTS consumer_regions (
+		external_user_id TEXT PRIMARY KEY,
+		region TEXT NOT NULL,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Audit trail of confirmed residency overrides
+	residencyOverridesTable := `
+	CREATE TABLE IF NOT EXISTS residency_overrides (
+		id TEXT PRIMARY KEY,                          -- UUID for override record
+		collection_name TEXT NOT NULL,
+		required_region TEXT NOT NULL,
+		document_filename TEXT,
+		target_type TEXT NOT NULL CHECK (target_type IN ('api', 'export')),
+		target_id TEXT NOT NULL,                      -- API ID or export destination
+		target_region TEXT,
+		confirmed_by TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Audit trail of API keys revealed in full
+	apiKeyRevealsTable := `
+	CREATE TABLE IF NOT EXISTS api_key_reveals (
+		id TEXT PRIMARY KEY,                          -- UUID for reveal record
+		api_id TEXT NOT NULL,
+		revealed_by TEXT NOT NULL,
+		reason TEXT NOT NULL,
+		remote_addr TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_api_key_reveals_api ON api_key_reveals(api_id, created_at);`
+
+	// Credit pricing of credit based policies
+	creditPricingTable := `
+	CREATE TABLE IF NOT EXISTS credit_pricing (
+		policy_id TEXT PRIMARY KEY,
+		credits_per_request REAL DEFAULT 0,
+		credits_per_token REAL DEFAULT 0,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (policy_id) REFERENCES policies(id) ON DELETE CASCADE
+	);`
+
+	// Current credit balance of each external consumer
+	creditBalancesTable := `
+	CREATE TABLE IF NOT EXISTS credit_balances (
+		external_user_id TEXT PRIMARY KEY,
+		balance REAL NOT NULL DEFAULT 0,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Every top-up and debit of a consumer's credits
+	creditLedgerTable := `
+	CREATE TABLE IF NOT EXISTS credit_ledger (
+		id TEXT PRIMARY KEY,                          -- UUID for ledger entry
+		external_user_id TEXT NOT NULL,
+		api_id TEXT,                                  -- API charged, for debits
+		entry_type TEXT NOT NULL CHECK (entry_type IN ('topup', 'debit')),
+		amount REAL NOT NULL,                         -- Positive for top-ups, negative for debits
+		balance_after REAL NOT NULL,
+		reference TEXT,
+		created_by TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_credit_ledger_user ON credit_ledger(external_user_id, created_at);`
+
+	// Custom key/value metadata of APIs, such as their team or data classification
+	apiMetadataTable := `
+	CREATE TABLE IF NOT EXISTS api_metadata (
+		api_id TEXT NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (api_id, key),
+		FOREIGN KEY (api_id) REFERENCES apis(id) ON DELETE CASCADE
+	);
+	CREATE INDEX IF NOT EXISTS idx_api_metadata_key ON api_metadata(key, value);`
+
+	// Optional schema the metadata of APIs is validated against
+	apiMetadataFieldsTable := `
+	CREATE TABLE IF NOT EXISTS api_metadata_fields (
+		key TEXT PRIMARY KEY,
+		type TEXT NOT NULL CHECK (type IN ('string', 'number', 'boolean', 'enum')),
+		allowed_values TEXT,                          -- Comma-separated, for enum fields
+		required BOOLEAN DEFAULT FALSE,
+		description TEXT
+	);`
+
+	// Snapshots of APIs archived after their deprecation date; they are never changed
+	apiArchivesTable := `
+	CREATE TABLE IF NOT EXISTS api_archives (
+		api_id TEXT PRIMARY KEY,                      -- ID the API had
+		name TEXT NOT NULL,
+		archived_at DATETIME NOT NULL,
+		snapshot TEXT NOT NULL                        -- JSON configuration, documents, policy and usage
+	);
+	CREATE TRIGGER IF NOT EXISTS api_archives_no_update BEFORE UPDATE ON api_archives
+	BEGIN SELECT RAISE(ABORT, 'API archives are immutable'); END;
+	CREATE TRIGGER IF NOT EXISTS api_archives_no_delete BEFORE DELETE ON api_archives
+	BEGIN SELECT RAISE(ABORT, 'API archives are immutable'); END;`
+
 	// Execute all table creation statements
 	tables := []struct {
 		name  string
@@ -205,6 +317,16 @@ func RunAPIMigrations(db *sql.DB) error {
 		{"api_usage_summary", apiUsageSummaryTable},
 		{"policy_changes", policyChangesTable},
 		{"quota_notifications", quotaNotificationsTable},
+		{"collection_residency", collectionResidencyTable},
+		{"consumer_regions", consumerRegionsTable},
+		{"residency_overrides", residencyOverridesTable},
+		{"api_key_reveals", apiKeyRevealsTable},
+		{"credit_pricing", creditPricingTable},
+		{"credit_balances", creditBalancesTable},
+		{"credit_ledger", creditLedgerTable},
+		{"api_metadata", apiMetadataTable},
+		{"api_metadata_fields", apiMetadataFieldsTable},
+		{"api_archives", apiArchivesTable},
 	}
 
 	for _, table := range tables {
@@ -0,0 +1,295 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of API metadata fields
+const (
+	MetadataString  = "string"
+	MetadataNumber  = "number"
+	MetadataBoolean = "boolean"
+	MetadataEnum    = "enum"
+)
+
+// maxMetadataValueLength bounds the length of a metadata value
+const maxMetadataValueLength = 1024
+
+// ErrInvalidMetadata is returned when API metadata does not match the metadata schema
+var ErrInvalidMetadata = errors.New("invalid API metadata")
+
+// metadataKeyPattern restricts keys to what can be used in a meta.KEY query parameter
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// APIMetadataField describes a metadata key the host allows on APIs. When no fields are
+// defined any key is accepted.
+type APIMetadataField struct {
+	Key           string   `json:"key"`
+	Type          string   `json:"type"`                     // 'string', 'number', 'boolean', 'enum'
+	AllowedValues []string `json:"allowed_values,omitempty"` // For 'enum' fields
+	Required      bool     `json:"required"`
+	Description   string   `json:"description,omitempty"`
+}
+
+// metadataExecutor is implemented by both *sql.DB and *sql.Tx
+type metadataExecutor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// ValidateMetadataField checks that a schema field is well formed
+func ValidateMetadataField(field APIMetadataField) error {
+	if !metadataKeyPattern.MatchString(field.Key) {
+		return fmt.Errorf("%w: key %q must be 1-64 letters, digits, '_' or '-'", ErrInvalidMetadata, field.Key)
+	}
+	switch field.Type {
+	case MetadataString, MetadataNumber, MetadataBoolean:
+	case MetadataEnum:
+		if len(field.AllowedValues) == 0 {
+			return fmt.Errorf("%w: enum field %s needs allowed values", ErrInvalidMetadata, field.Key)
+		}
+	default:
+		return fmt.Errorf("%w: field %s has unknown type %q", ErrInvalidMetadata, field.Key, field.Type)
+	}
+	return nil
+}
+
+// ListAPIMetadataFields returns the metadata schema, ordered by key
+func ListAPIMetadataFields(db *sql.DB) ([]APIMetadataField, error) {
+	return listAPIMetadataFields(db)
+}
+
+func listAPIMetadataFields(db metadataExecutor) ([]APIMetadataField, error) {
+	rows, err := db.Query(`SELECT key, type, allowed_values, required, description FROM api_metadata_fields ORDER BY key`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query metadata fields: %v", err)
+	}
+	defer rows.Close()
+
+	fields := []APIMetadataField{}
+	for rows.Next() {
+		var field APIMetadataField
+		var allowed, description sql.NullString
+		if err := rows.Scan(&field.Key, &field.Type, &allowed, &field.Required, &description); err != nil {
+			return nil, fmt.Errorf("failed to scan metadata field: %v", err)
+		}
+		if allowed.String != "" {
+			field.AllowedValues = strings.Split(allowed.String, ",")
+		}
+		field.Description = description.String
+		fields = append(fields, field)
+	}
+	return fields, rows.Err()
+}
+
+// SetAPIMetadataField adds a field to the metadata schema or replaces it
+func SetAPIMetadataField(db *sql.DB, field APIMetadataField) error {
+	if err := ValidateMetadataField(field); err != nil {
+		return err
+	}
+	for _, value := range field.AllowedValues {
+		if value == "" || strings.Contains(value, ",") {
+			return fmt.Errorf("%w: allowed values of %s must be non-empty and contain no commas", ErrInvalidMetadata, field.Key)
+		}
+	}
+	_, err := db.Exec(`
+		INSERT INTO api_metadata_fields (key, type, allowed_values, required, description)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET type = excluded.type, allowed_values = excluded.allowed_values,
+			required = excluded.required, description = excluded.description`,
+		field.Key, field.Type, strings.Join(field.AllowedValues, ","), field.Required, field.Description)
+	if err != nil {
+		return fmt.Errorf("failed to save metadata field: %v", err)
+	}
+	return nil
+}
+
+// DeleteAPIMetadataField removes a field from the metadata schema. Values already stored
+// under the key are kept.
+func DeleteAPIMetadataField(db *sql.DB, key string) error {
+	result, err := db.Exec(`DELETE FROM api_metadata_fields WHERE key = ?`, key)
+	if err != nil {
+		return fmt.Errorf("failed to delete metadata field: %v", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ValidateAPIMetadata checks metadata against the schema. Without a schema any key with a
+// well formed name is accepted; with one, keys must be defined, values must match their type
+// and required keys must be present.
+func ValidateAPIMetadata(db *sql.DB, metadata map[string]string) error {
+	return validateAPIMetadata(db, metadata)
+}
+
+func validateAPIMetadata(db metadataExecutor, metadata map[string]string) error {
+	fields, err := listAPIMetadataFields(db)
+	if err != nil {
+		return err
+	}
+	return checkAPIMetadata(fields, metadata)
+}
+
+// checkAPIMetadata checks metadata against a schema
+func checkAPIMetadata(fields []APIMetadataField, metadata map[string]string) error {
+	for key, value := range metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			return fmt.Errorf("%w: key %q must be 1-64 letters, digits, '_' or '-'", ErrInvalidMetadata, key)
+		}
+		if len(value) > maxMetadataValueLength {
+			return fmt.Errorf("%w: value of %s is longer than %d bytes", ErrInvalidMetadata, key, maxMetadataValueLength)
+		}
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+
+	defined := make(map[string]APIMetadataField, len(fields))
+	for _, field := range fields {
+		defined[field.Key] = field
+		if _, ok := metadata[field.Key]; field.Required && !ok {
+			return fmt.Errorf("%w: %s is required", ErrInvalidMetadata, field.Key)
+		}
+	}
+	for key, value := range metadata {
+		field, ok := defined[key]
+		if !ok {
+			return fmt.Errorf("%w: %s is not a defined metadata field", ErrInvalidMetadata, key)
+		}
+		switch field.Type {
+		case MetadataNumber:
+			if _, err := strconv.ParseFloat(value, 64); err != nil {
+				return fmt.Errorf("%w: %s must be a number", ErrInvalidMetadata, key)
+			}
+		case MetadataBoolean:
+			if value != "true" && value != "false" {
+				return fmt.Errorf("%w: %s must be true or false", ErrInvalidMetadata, key)
+			}
+		case MetadataEnum:
+			allowed := false
+			for _, v := range field.AllowedValues {
+				allowed = allowed || v == value
+			}
+			if !allowed {
+				return fmt.Errorf("%w: %s must be one of %s", ErrInvalidMetadata, key, strings.Join(field.AllowedValues, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// GetAPIMetadata returns the metadata of an API
+func GetAPIMetadata(db *sql.DB, apiID string) (map[string]string, error) {
+	metadata, err := getAPIsMetadata(db, []string{apiID})
+	if err != nil {
+		return nil, err
+	}
+	return metadata[apiID], nil
+}
+
+// getAPIsMetadata returns the metadata of several APIs, keyed by API ID
+func getAPIsMetadata(db metadataExecutor, apiIDs []string) (map[string]map[string]string, error) {
+	result := make(map[string]map[string]string)
+	if len(apiIDs) == 0 {
+		return result, nil
+	}
+	args := make([]interface{}, len(apiIDs))
+	for i, id := range apiIDs {
+		args[i] = id
+	}
+	query := `SELECT api_id, key, value FROM api_metadata WHERE api_id IN (?` + strings.Repeat(", ?", len(apiIDs)-1) + `)`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API metadata: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var apiID, key, value string
+		if err := rows.Scan(&apiID, &key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan API metadata: %v", err)
+		}
+		if result[apiID] == nil {
+			result[apiID] = make(map[string]string)
+		}
+		result[apiID][key] = value
+	}
+	return result, rows.Err()
+}
+
+// SetAPIMetadata validates metadata against the schema and replaces the metadata of an API
+// with it
+func SetAPIMetadata(db *sql.DB, apiID string, metadata map[string]string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := SetAPIMetadataTx(tx, apiID, metadata); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetAPIMetadataTx is SetAPIMetadata within a transaction
+func SetAPIMetadataTx(tx *sql.Tx, apiID string, metadata map[string]string) error {
+	defer invalidateAPIListCache()
+
+	if err := validateAPIMetadata(tx, metadata); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM api_metadata WHERE api_id = ?`, apiID); err != nil {
+		return fmt.Errorf("failed to clear API metadata: %v", err)
+	}
+	for key, value := range metadata {
+		if _, err := tx.Exec(`INSERT INTO api_metadata (api_id, key, value) VALUES (?, ?, ?)`, apiID, key, value); err != nil {
+			return fmt.Errorf("failed to save API metadata: %v", err)
+		}
+	}
+	return nil
+}
+
+// metadataFilter returns the WHERE clause and arguments that restrict a listing of APIs,
+// aliased a, to those whose metadata has all the given values
+func metadataFilter(metadata map[string]string) (string, []interface{}) {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	where := ""
+	args := []interface{}{}
+	for _, key := range keys {
+		where += " AND a.id IN (SELECT api_id FROM api_metadata WHERE key = ? AND value = ?)"
+		args = append(args, key, metadata[key])
+	}
+	return where, args
+}
+
+// metadataCacheKey returns a comparable form of a metadata filter for the listing cache
+func metadataCacheKey(metadata map[string]string) string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		b.WriteString(strconv.Quote(key))
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(metadata[key]))
+		b.WriteByte(';')
+	}
+	return b.String()
+}
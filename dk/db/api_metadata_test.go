@@ -0,0 +1,80 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAPIMetadata(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	db := testDB.DB
+	if err := RunAPIMigrations(db); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	weather := &API{Name: "Weather", IsActive: true, HostUserID: "host"}
+	payroll := &API{Name: "Payroll", IsActive: true, HostUserID: "host"}
+	for _, api := range []*API{weather, payroll} {
+		if err := CreateAPI(db, api); err != nil {
+			t.Fatalf("CreateAPI failed: %v", err)
+		}
+	}
+
+	// Without a schema any well formed key is accepted
+	if err := SetAPIMetadata(db, weather.ID, map[string]string{"team": "forecasting", "classification": "public"}); err != nil {
+		t.Fatalf("SetAPIMetadata failed: %v", err)
+	}
+	if err := SetAPIMetadata(db, payroll.ID, map[string]string{"bad key": "x"}); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("Expected a malformed key to be rejected, got %v", err)
+	}
+
+	// With a schema, values must match it
+	if err := SetAPIMetadataField(db, APIMetadataField{Key: "classification", Type: MetadataEnum, AllowedValues: []string{"public", "restricted"}, Required: true}); err != nil {
+		t.Fatalf("SetAPIMetadataField failed: %v", err)
+	}
+	if err := SetAPIMetadataField(db, APIMetadataField{Key: "cost_center", Type: MetadataNumber}); err != nil {
+		t.Fatalf("SetAPIMetadataField failed: %v", err)
+	}
+	for _, metadata := range []map[string]string{
+		{"classification": "secret"},
+		{"classification": "restricted", "cost_center": "finance"},
+		{"cost_center": "4200"},
+		{"classification": "restricted", "team": "hr"},
+	} {
+		if err := SetAPIMetadata(db, payroll.ID, metadata); !errors.Is(err, ErrInvalidMetadata) {
+			t.Errorf("Expected %v to be rejected, got %v", metadata, err)
+		}
+	}
+	if err := SetAPIMetadata(db, payroll.ID, map[string]string{"classification": "restricted", "cost_center": "4200"}); err != nil {
+		t.Fatalf("SetAPIMetadata failed: %v", err)
+	}
+
+	api, err := GetAPI(db, payroll.ID)
+	if err != nil || api.Metadata["cost_center"] != "4200" {
+		t.Errorf("Expected the metadata with the API, got %+v (%v)", api, err)
+	}
+
+	// Listings filter on metadata and include it
+	summaries, total, err := ListAPISummaries(db, "", "", map[string]string{"classification": "restricted"}, 10, 0, "name", "asc")
+	if err != nil {
+		t.Fatalf("ListAPISummaries failed: %v", err)
+	}
+	if total != 1 || len(summaries) != 1 || summaries[0].ID != payroll.ID || summaries[0].Metadata["classification"] != "restricted" {
+		t.Errorf("Expected only the restricted API, got %d: %+v", total, summaries)
+	}
+	if _, total, _ := ListAPISummaries(db, "", "", nil, 10, 0, "name", "asc"); total != 2 {
+		t.Errorf("Expected both APIs without a filter, got %d", total)
+	}
+
+	// Deleting an API deletes its metadata
+	if err := DeleteAPI(db, payroll.ID); err != nil {
+		t.Fatalf("DeleteAPI failed: %v", err)
+	}
+	if metadata, _ := GetAPIMetadata(db, payroll.ID); len(metadata) != 0 {
+		t.Errorf("Expected the metadata to be deleted, got %v", metadata)
+	}
+}
@@ -8,6 +8,8 @@ import (
 
 // UpdateAPITx updates an existing API within a transaction
 func UpdateAPITx(tx *sql.Tx, api *API) error {
+	defer invalidateAPIListCache()
+
 	// Update timestamp if not already set
 	if api.UpdatedAt.IsZero() {
 		api.UpdatedAt = time.Now()
@@ -355,16 +355,22 @@ func UpsertAPIUsageSummary(db *sql.DB, summary *APIUsageSummary) error {
 	return nil
 }
 
-// GetAPIUsageSummaries retrieves usage summaries for an API with optional filtering
+// GetAPIUsageSummaries retrieves usage summaries for an API with optional filtering. An empty
+// apiID returns the summaries of every API.
 func GetAPIUsageSummaries(db *sql.DB, apiID, externalUserID, periodType string, fromDate, toDate time.Time) ([]*APIUsageSummary, error) {
 	query := `
 		SELECT id, api_id, external_user_id, period_type, period_start, period_end,
 			total_requests, total_tokens, total_credits, total_time_ms,
 			throttled_requests, blocked_requests, last_updated
 		FROM api_usage_summary
-		WHERE api_id = ?
+		WHERE 1=1
 	`
-	args := []interface{}{apiID}
+	args := []interface{}{}
+
+	if apiID != "" {
+		query += " AND api_id = ?"
+		args = append(args, apiID)
+	}
 
 	if externalUserID != "" {
 		query += " AND external_user_id = ?"
@@ -531,7 +537,7 @@ func UpdateAPIUsageSummaries(db *sql.DB) error {
 		return fmt.Errorf("error iterating API rows: %v", err)
 	}
 
-	now := time.Now()
+	now := time.Now().UTC()
 
 	// For each API, recalculate summaries
 	for _, apiID := range apiIDs {
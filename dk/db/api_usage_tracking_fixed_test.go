@@ -143,6 +143,35 @@ func TestFixedAPIUsageSummaryOperations(t *testing.T) {
 		assert.Equal(t, 1500, updatedSummaries[0].TotalTokens, "Total tokens should be updated")
 		assert.Equal(t, 1.5, updatedSummaries[0].TotalCredits, "Total credits should be updated")
 	})
+
+	// Test retrieving summaries across all APIs
+	t.Run("SummariesAcrossAPIs", func(t *testing.T) {
+		now := time.Now()
+		startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		endOfDay := startOfDay.Add(24 * time.Hour).Add(-time.Second)
+
+		otherAPIID, _ := createUniqueTestAPI(t, db)
+		err := UpsertAPIUsageSummary(db, &APIUsageSummary{
+			ID:             uuid.New().String(),
+			APIID:          otherAPIID,
+			ExternalUserID: "other_user",
+			PeriodType:     "daily",
+			PeriodStart:    startOfDay,
+			PeriodEnd:      endOfDay,
+			TotalRequests:  3,
+			LastUpdated:    now,
+		})
+		assert.NoError(t, err, "Should upsert API usage summary without error")
+
+		summaries, err := GetAPIUsageSummaries(db, "", "", "daily", startOfDay, endOfDay)
+		assert.NoError(t, err, "Should retrieve API usage summaries without error")
+		assert.Equal(t, 2, len(summaries), "Should have one summary per API")
+
+		summaries, err = GetAPIUsageSummaries(db, "", "other_user", "daily", startOfDay, endOfDay)
+		assert.NoError(t, err, "Should retrieve API usage summaries without error")
+		assert.Equal(t, 1, len(summaries), "Should only have the other user's summary")
+		assert.Equal(t, otherAPIID, summaries[0].APIID, "API ID should match")
+	})
 }
 
 // TestFixedQuotaNotificationOperations tests operations related to quota notifications
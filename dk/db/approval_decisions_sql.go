@@ -0,0 +1,67 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Outcomes of evaluating the automatic approval conditions for an incoming query
+const (
+	ApprovalAccept = "accept" // A condition accepts the query, so its answer is sent
+	ApprovalReject = "reject" // A condition rejects the query
+	ApprovalNone   = "none"   // No condition applies; the query waits for the host
+)
+
+// ApprovalDecision is the audit record of an automatic approval evaluation
+type ApprovalDecision struct {
+	ID        int64     `json:"id"`
+	QueryID   string    `json:"query_id"`
+	Decision  string    `json:"decision"`
+	Condition string    `json:"condition,omitempty"` // The condition that decided
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// InsertApprovalDecision records the outcome of an automatic approval evaluation
+func InsertApprovalDecision(ctx context.Context, db *sql.DB, d ApprovalDecision) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO approval_decisions (query_id, decision, matched_condition, reason) VALUES (?, ?, ?, ?)`,
+		d.QueryID, d.Decision, d.Condition, d.Reason)
+	if err != nil {
+		return fmt.Errorf("insert approval decision: %w", err)
+	}
+	return nil
+}
+
+// ListApprovalDecisions returns the latest approval decisions, newest first, of one query
+// when queryID is set. A limit of 0 or less returns them all.
+func ListApprovalDecisions(ctx context.Context, db *sql.DB, queryID string, limit int) ([]ApprovalDecision, error) {
+	query := `SELECT id, query_id, decision, matched_condition, reason, created_at FROM approval_decisions`
+	var args []any
+	if queryID != "" {
+		query += ` WHERE query_id = ?`
+		args = append(args, queryID)
+	}
+	query += ` ORDER BY id DESC`
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list approval decisions: %w", err)
+	}
+	defer rows.Close()
+
+	decisions := []ApprovalDecision{}
+	for rows.Next() {
+		var d ApprovalDecision
+		if err := rows.Scan(&d.ID, &d.QueryID, &d.Decision, &d.Condition, &d.Reason, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan approval decision: %w", err)
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions, rows.Err()
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ArchivedMessage is a message kept in the local database: a query a peer sent, or a peer's
+// answer to one of our queries
+type ArchivedMessage struct {
+	Kind      string    `json:"kind"` // "query" or "answer"
+	ID        string    `json:"id"`
+	Peer      string    `json:"peer"`
+	Question  string    `json:"question"`
+	Answer    string    `json:"answer,omitempty"`
+	Status    string    `json:"status,omitempty"` // Review status of a query
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ArchiveCursor positions a listing after the given message; the zero cursor starts at the
+// beginning
+type ArchiveCursor struct {
+	Timestamp time.Time `json:"timestamp"`
+	Kind      string    `json:"kind"`
+	ID        string    `json:"id"`
+}
+
+// ArchiveFilter selects the messages of an archive
+type ArchiveFilter struct {
+	Peer  string    // Only messages exchanged with this peer
+	Since time.Time // Received at or after; zero for no bound
+	Until time.Time // Received before; zero for no bound
+}
+
+// archiveTimeLayout is the UTC layout timestamps are compared and ordered in
+const archiveTimeLayout = "2006-01-02T15:04:05Z"
+
+// ListArchivedMessages returns up to limit messages matching the filter, oldest first, after
+// the cursor. Messages are ordered by timestamp, kind and ID, so a listing can be continued
+// from the last message returned.
+func ListArchivedMessages(ctx context.Context, db *sql.DB, filter ArchiveFilter, after ArchiveCursor, limit int) ([]ArchivedMessage, error) {
+	query := `SELECT kind, id, peer, question, answer, status, ts FROM (
+		SELECT 'query' AS kind, id, from_source AS peer, question, COALESCE(answer, '') AS answer,
+		       status, strftime('%Y-%m-%dT%H:%M:%SZ', created_at) AS ts
+		FROM queries
+		UNION ALL
+		SELECT 'answer', printf('ans-%d', id), user, question, answer,
+		       '', strftime('%Y-%m-%dT%H:%M:%SZ', created_at)
+		FROM answers
+	)`
+	where := []string{"ts IS NOT NULL"}
+	var args []any
+	if filter.Peer != "" {
+		where = append(where, "peer = ?")
+		args = append(args, filter.Peer)
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "ts >= ?")
+		args = append(args, filter.Since.UTC().Format(archiveTimeLayout))
+	}
+	if !filter.Until.IsZero() {
+		where = append(where, "ts < ?")
+		args = append(args, filter.Until.UTC().Format(archiveTimeLayout))
+	}
+	if after.Kind != "" {
+		where = append(where, "(ts, kind, id) > (?, ?, ?)")
+		args = append(args, after.Timestamp.UTC().Format(archiveTimeLayout), after.Kind, after.ID)
+	}
+	query += " WHERE " + strings.Join(where, " AND ") + " ORDER BY ts, kind, id LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list archived messages: %w", err)
+	}
+	defer rows.Close()
+
+	var out []ArchivedMessage
+	for rows.Next() {
+		var m ArchivedMessage
+		var ts string
+		if err := rows.Scan(&m.Kind, &m.ID, &m.Peer, &m.Question, &m.Answer, &m.Status, &ts); err != nil {
+			return nil, fmt.Errorf("scan archived message: %w", err)
+		}
+		if m.Timestamp, err = time.Parse(archiveTimeLayout, ts); err != nil {
+			return nil, fmt.Errorf("parse timestamp of %s: %w", m.ID, err)
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,183 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrAliasTaken is returned when an alias is already given to another peer
+var ErrAliasTaken = errors.New("alias already used by another peer")
+
+// Contact is what the host keeps about a peer: an alias, notes and the datasets the peer is
+// tagged with
+type Contact struct {
+	PeerID    string    `json:"peer_id"`
+	Alias     string    `json:"alias,omitempty"`
+	Notes     string    `json:"notes,omitempty"`
+	Tags      []string  `json:"tags"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// SaveContact sets the alias and notes of a peer. A nil alias or notes keeps the current
+// value; an empty alias removes it.
+func SaveContact(ctx context.Context, db *sql.DB, peerID string, alias, notes *string, now time.Time) (*Contact, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("save contact: %w", err)
+	}
+	defer tx.Rollback()
+
+	if alias != nil && *alias != "" {
+		var owner string
+		err := tx.QueryRowContext(ctx, `SELECT peer_id FROM contacts WHERE alias = ?`, *alias).Scan(&owner)
+		if err == nil && owner != peerID {
+			return nil, ErrAliasTaken
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("save contact: %w", err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO contacts (peer_id, notes, updated_at) VALUES (?, '', ?) ON CONFLICT(peer_id) DO NOTHING`,
+		peerID, now); err != nil {
+		return nil, fmt.Errorf("save contact: %w", err)
+	}
+	if alias != nil {
+		var value any
+		if *alias != "" {
+			value = *alias
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE contacts SET alias = ?, updated_at = ? WHERE peer_id = ?`, value, now, peerID); err != nil {
+			return nil, fmt.Errorf("save contact: %w", err)
+		}
+	}
+	if notes != nil {
+		if _, err := tx.ExecContext(ctx, `UPDATE contacts SET notes = ?, updated_at = ? WHERE peer_id = ?`, *notes, now, peerID); err != nil {
+			return nil, fmt.Errorf("save contact: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("save contact: %w", err)
+	}
+	return GetContact(ctx, db, peerID)
+}
+
+// TagContact adds and removes dataset tags of a peer
+func TagContact(ctx context.Context, db *sql.DB, peerID string, add, remove []string, now time.Time) (*Contact, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("tag contact: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO contacts (peer_id, notes, updated_at) VALUES (?, '', ?)
+		 ON CONFLICT(peer_id) DO UPDATE SET updated_at = excluded.updated_at`,
+		peerID, now); err != nil {
+		return nil, fmt.Errorf("tag contact: %w", err)
+	}
+	for _, tag := range add {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO contact_tags (peer_id, tag) VALUES (?, ?)`, peerID, tag); err != nil {
+			return nil, fmt.Errorf("tag contact: %w", err)
+		}
+	}
+	for _, tag := range remove {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM contact_tags WHERE peer_id = ? AND tag = ?`, peerID, tag); err != nil {
+			return nil, fmt.Errorf("tag contact: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("tag contact: %w", err)
+	}
+	return GetContact(ctx, db, peerID)
+}
+
+// GetContact returns the contact of a peer, or ErrNotFound
+func GetContact(ctx context.Context, db *sql.DB, peerID string) (*Contact, error) {
+	contacts, err := queryContacts(ctx, db, "WHERE c.peer_id = ?", peerID)
+	if err != nil {
+		return nil, err
+	}
+	if len(contacts) == 0 {
+		return nil, ErrNotFound
+	}
+	return &contacts[0], nil
+}
+
+// GetContactByAlias returns the contact with an alias, or ErrNotFound
+func GetContactByAlias(ctx context.Context, db *sql.DB, alias string) (*Contact, error) {
+	contacts, err := queryContacts(ctx, db, "WHERE c.alias = ?", alias)
+	if err != nil {
+		return nil, err
+	}
+	if len(contacts) == 0 {
+		return nil, ErrNotFound
+	}
+	return &contacts[0], nil
+}
+
+// ListContacts returns every contact ordered by peer ID
+func ListContacts(ctx context.Context, db *sql.DB) ([]Contact, error) {
+	return queryContacts(ctx, db, "ORDER BY c.peer_id")
+}
+
+// ListPeersTagged returns the peers tagged with any of the tags, ordered by peer ID
+func ListPeersTagged(ctx context.Context, db *sql.DB, tags []string) ([]string, error) {
+	if len(tags) == 0 {
+		return []string{}, nil
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(tags)), ", ")
+	args := make([]any, len(tags))
+	for i, tag := range tags {
+		args[i] = tag
+	}
+	rows, err := db.QueryContext(ctx,
+		`SELECT DISTINCT peer_id FROM contact_tags WHERE tag IN (`+placeholders+`) ORDER BY peer_id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list tagged peers: %w", err)
+	}
+	defer rows.Close()
+
+	peers := []string{}
+	for rows.Next() {
+		var peer string
+		if err := rows.Scan(&peer); err != nil {
+			return nil, fmt.Errorf("scan tagged peer: %w", err)
+		}
+		peers = append(peers, peer)
+	}
+	return peers, rows.Err()
+}
+
+func queryContacts(ctx context.Context, db *sql.DB, clause string, args ...any) ([]Contact, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.peer_id, c.alias, c.notes, c.updated_at,
+			COALESCE((SELECT GROUP_CONCAT(tag, ',') FROM (SELECT tag FROM contact_tags t WHERE t.peer_id = c.peer_id ORDER BY tag)), '')
+		FROM contacts c `+clause, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query contacts: %w", err)
+	}
+	defer rows.Close()
+
+	contacts := []Contact{}
+	for rows.Next() {
+		var contact Contact
+		var alias sql.NullString
+		var tags string
+		if err := rows.Scan(&contact.PeerID, &alias, &contact.Notes, &contact.UpdatedAt, &tags); err != nil {
+			return nil, fmt.Errorf("scan contact: %w", err)
+		}
+		contact.Alias = alias.String
+		contact.Tags = []string{}
+		if tags != "" {
+			contact.Tags = strings.Split(tags, ",")
+		}
+		contacts = append(contacts, contact)
+	}
+	return contacts, rows.Err()
+}
@@ -0,0 +1,316 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Credit ledger entry types
+const (
+	CreditEntryTopUp = "topup"
+	CreditEntryDebit = "debit"
+)
+
+// DefaultCreditPricing is applied to policies without explicit pricing
+var DefaultCreditPricing = CreditPricing{CreditsPerToken: 0.001}
+
+// Cost returns the credits charged for a number of requests and tokens
+func (p CreditPricing) Cost(requests, tokens int) float64 {
+	return p.CreditsPerRequest*float64(requests) + p.CreditsPerToken*float64(tokens)
+}
+
+// PolicyUsesCredits reports whether usage under a policy is paid for with credits
+func PolicyUsesCredits(policy *Policy) bool {
+	if policy == nil || !policy.IsActive {
+		return false
+	}
+	if policy.Type == "credit" {
+		return true
+	}
+	for _, rule := range policy.Rules {
+		if rule.RuleType == "credit" {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCreditPricing creates or replaces the pricing of a policy
+func SetCreditPricing(db *sql.DB, pricing *CreditPricing) error {
+	if pricing.CreditsPerRequest < 0 || pricing.CreditsPerToken < 0 {
+		return fmt.Errorf("credit prices cannot be negative")
+	}
+	if pricing.UpdatedAt.IsZero() {
+		pricing.UpdatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO credit_pricing (policy_id, credits_per_request, credits_per_token, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(policy_id) DO UPDATE SET
+			credits_per_request = excluded.credits_per_request,
+			credits_per_token = excluded.credits_per_token,
+			updated_at = excluded.updated_at
+	`
+
+	_, err := db.Exec(query, pricing.PolicyID, pricing.CreditsPerRequest, pricing.CreditsPerToken, pricing.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to set credit pricing: %v", err)
+	}
+
+	return nil
+}
+
+// GetCreditPricing retrieves the pricing of a policy, falling back to DefaultCreditPricing
+func GetCreditPricing(db *sql.DB, policyID string) (*CreditPricing, error) {
+	query := `
+		SELECT policy_id, credits_per_request, credits_per_token, updated_at
+		FROM credit_pricing
+		WHERE policy_id = ?
+	`
+
+	pricing := &CreditPricing{}
+	err := db.QueryRow(query, policyID).Scan(
+		&pricing.PolicyID,
+		&pricing.CreditsPerRequest,
+		&pricing.CreditsPerToken,
+		&pricing.UpdatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			pricing := DefaultCreditPricing
+			pricing.PolicyID = policyID
+			return &pricing, nil
+		}
+		return nil, fmt.Errorf("failed to get credit pricing: %v", err)
+	}
+
+	return pricing, nil
+}
+
+// GetCreditBalance retrieves the credit balance of a consumer. Consumers that never
+// received credits have a zero balance.
+func GetCreditBalance(db *sql.DB, userID string) (*CreditBalance, error) {
+	query := `
+		SELECT external_user_id, balance, updated_at
+		FROM credit_balances
+		WHERE external_user_id = ?
+	`
+
+	balance := &CreditBalance{}
+	err := db.QueryRow(query, userID).Scan(&balance.ExternalUserID, &balance.Balance, &balance.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return &CreditBalance{ExternalUserID: userID}, nil
+		}
+		return nil, fmt.Errorf("failed to get credit balance: %v", err)
+	}
+
+	return balance, nil
+}
+
+// addCreditEntry applies an entry to the consumer's balance and appends it to the ledger
+func addCreditEntry(db *sql.DB, entry *CreditLedgerEntry) error {
+	if entry.ID == "" {
+		entry.ID = uuid.New().String()
+	}
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Updating the balance first takes the write lock, so concurrent entries are serialized
+	_, err = tx.Exec(`
+		INSERT INTO credit_balances (external_user_id, balance, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(external_user_id) DO UPDATE SET
+			balance = balance + excluded.balance,
+			updated_at = excluded.updated_at
+	`, entry.ExternalUserID, entry.Amount, entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to update credit balance: %v", err)
+	}
+
+	err = tx.QueryRow(`SELECT balance FROM credit_balances WHERE external_user_id = ?`,
+		entry.ExternalUserID).Scan(&entry.BalanceAfter)
+	if err != nil {
+		return fmt.Errorf("failed to read credit balance: %v", err)
+	}
+
+	apiID := sql.NullString{String: entry.APIID, Valid: entry.APIID != ""}
+	_, err = tx.Exec(`
+		INSERT INTO credit_ledger (
+			id, external_user_id, api_id, entry_type, amount,
+			balance_after, reference, created_by, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, entry.ID, entry.ExternalUserID, apiID, entry.EntryType, entry.Amount, entry.BalanceAfter, entry.Reference, entry.CreatedBy, entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert credit ledger entry: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit credit ledger entry: %v", err)
+	}
+	return nil
+}
+
+// TopUpCredits grants credits to a consumer
+func TopUpCredits(db *sql.DB, userID string, amount float64, note, createdBy string) (*CreditLedgerEntry, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("top-up amount must be positive")
+	}
+
+	entry := &CreditLedgerEntry{
+		ExternalUserID: userID,
+		EntryType:      CreditEntryTopUp,
+		Amount:         amount,
+		Reference:      note,
+		CreatedBy:      createdBy,
+	}
+	if err := addCreditEntry(db, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// DebitCredits charges a consumer for the use of an API. The balance may become negative
+// when a request costs more than what is left; further requests are then blocked.
+func DebitCredits(db *sql.DB, apiID, userID string, amount float64, reference string) (*CreditLedgerEntry, error) {
+	if amount <= 0 {
+		return nil, nil
+	}
+
+	entry := &CreditLedgerEntry{
+		ExternalUserID: userID,
+		APIID:          apiID,
+		EntryType:      CreditEntryDebit,
+		Amount:         -amount,
+		Reference:      reference,
+	}
+	if err := addCreditEntry(db, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// ListCreditLedger retrieves the ledger entries of a consumer, newest first
+func ListCreditLedger(db *sql.DB, userID string, limit, offset int) ([]*CreditLedgerEntry, int, error) {
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM credit_ledger WHERE external_user_id = ?`, userID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count credit ledger entries: %v", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, external_user_id, api_id, entry_type, amount,
+			balance_after, reference, created_by, created_at
+		FROM credit_ledger
+		WHERE external_user_id = ?
+		ORDER BY created_at DESC, rowid DESC
+		LIMIT ? OFFSET ?
+	`, userID, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list credit ledger entries: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []*CreditLedgerEntry
+	for rows.Next() {
+		entry := &CreditLedgerEntry{}
+		var apiID, reference, createdBy sql.NullString
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.ExternalUserID,
+			&apiID,
+			&entry.EntryType,
+			&entry.Amount,
+			&entry.BalanceAfter,
+			&reference,
+			&createdBy,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan credit ledger entry: %v", err)
+		}
+		entry.APIID = apiID.String
+		entry.Reference = reference.String
+		entry.CreatedBy = createdBy.String
+		entries = append(entries, entry)
+	}
+
+	return entries, total, rows.Err()
+}
+
+// apiPolicy returns the policy of an API with its rules, or nil if it has none
+func apiPolicy(db *sql.DB, apiID string) (*Policy, error) {
+	api, err := GetAPI(db, apiID)
+	if err != nil {
+		return nil, err
+	}
+	if api.PolicyID == nil {
+		return nil, nil
+	}
+	policy, err := GetPolicyWithRules(db, *api.PolicyID)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return policy, err
+}
+
+// ChargeAPIUsage prices a usage record according to the policy of its API and sets its
+// CreditsConsumed. When the policy is credit based, the consumer's ledger is debited.
+func ChargeAPIUsage(db *sql.DB, usage *APIUsage) error {
+	policy, err := apiPolicy(db, usage.APIID)
+	if err != nil {
+		return err
+	}
+
+	pricing := DefaultCreditPricing
+	if policy != nil {
+		p, err := GetCreditPricing(db, policy.ID)
+		if err != nil {
+			return err
+		}
+		pricing = *p
+	}
+	usage.CreditsConsumed = pricing.Cost(usage.RequestCount, usage.TokensUsed)
+
+	if usage.WasBlocked || !PolicyUsesCredits(policy) {
+		return nil
+	}
+	_, err = DebitCredits(db, usage.APIID, usage.ExternalUserID, usage.CreditsConsumed, usage.Endpoint)
+	return err
+}
+
+// CreditsExhausted reports whether a consumer must be blocked from an API because its policy
+// has a blocking credit rule and the consumer has no credits left
+func CreditsExhausted(db *sql.DB, apiID, userID string) (bool, error) {
+	policy, err := apiPolicy(db, apiID)
+	if err != nil || policy == nil || !policy.IsActive {
+		return false, err
+	}
+
+	blocks := false
+	for _, rule := range policy.Rules {
+		if rule.RuleType == "credit" && rule.Action == "block" {
+			blocks = true
+			break
+		}
+	}
+	if !blocks {
+		return false, nil
+	}
+
+	balance, err := GetCreditBalance(db, userID)
+	if err != nil {
+		return false, err
+	}
+	return balance.Balance <= 0, nil
+}
@@ -0,0 +1,115 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestCreditLedger tests pricing, top-ups, debits and blocking at a zero balance
+func TestCreditLedger(t *testing.T) {
+	db := setupTestDB(t)
+
+	policy := &Policy{Name: "Credit Policy", Type: "credit", IsActive: true}
+	if err := CreatePolicy(db, policy); err != nil {
+		t.Fatalf("Failed to create policy: %v", err)
+	}
+	rule := &PolicyRule{
+		ID:         uuid.New().String(),
+		PolicyID:   policy.ID,
+		RuleType:   "credit",
+		LimitValue: 1000,
+		Period:     "month",
+		Action:     "block",
+		CreatedAt:  time.Now(),
+	}
+	if err := CreatePolicyRule(db, rule); err != nil {
+		t.Fatalf("Failed to create policy rule: %v", err)
+	}
+
+	// Policies without pricing use the default
+	pricing, err := GetCreditPricing(db, policy.ID)
+	if err != nil {
+		t.Fatalf("Failed to get credit pricing: %v", err)
+	}
+	if pricing.CreditsPerToken != DefaultCreditPricing.CreditsPerToken {
+		t.Errorf("Expected default pricing, got %+v", pricing)
+	}
+	if err := SetCreditPricing(db, &CreditPricing{PolicyID: policy.ID, CreditsPerRequest: 1, CreditsPerToken: 0.01}); err != nil {
+		t.Fatalf("Failed to set credit pricing: %v", err)
+	}
+
+	apiID := uuid.New().String()
+	_, err = db.Exec(`
+		INSERT INTO apis (id, name, description, is_active, api_key, host_user_id, policy_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, apiID, "Credit API", "API for credit testing", true, "test_key_"+apiID[0:8], "test_host", policy.ID)
+	if err != nil {
+		t.Fatalf("Failed to insert API: %v", err)
+	}
+
+	userID := "credit_user_" + apiID[0:8]
+
+	// A consumer without credits is blocked
+	exhausted, err := CreditsExhausted(db, apiID, userID)
+	if err != nil {
+		t.Fatalf("Failed to check credits: %v", err)
+	}
+	if !exhausted {
+		t.Error("Expected consumer without credits to be blocked")
+	}
+
+	if _, err := TopUpCredits(db, userID, 0, "", "test_host"); err == nil {
+		t.Error("Expected a zero top-up to be rejected")
+	}
+	entry, err := TopUpCredits(db, userID, 5, "welcome credits", "test_host")
+	if err != nil {
+		t.Fatalf("Failed to top up credits: %v", err)
+	}
+	if entry.BalanceAfter != 5 {
+		t.Errorf("Expected balance 5 after top-up, got %v", entry.BalanceAfter)
+	}
+
+	// 1 request and 200 tokens cost 1 + 2 credits
+	usage := &APIUsage{APIID: apiID, ExternalUserID: userID, RequestCount: 1, TokensUsed: 200, Endpoint: "/api/v1/query"}
+	if err := ChargeAPIUsage(db, usage); err != nil {
+		t.Fatalf("Failed to charge usage: %v", err)
+	}
+	if usage.CreditsConsumed != 3 {
+		t.Errorf("Expected 3 credits consumed, got %v", usage.CreditsConsumed)
+	}
+
+	balance, err := GetCreditBalance(db, userID)
+	if err != nil {
+		t.Fatalf("Failed to get credit balance: %v", err)
+	}
+	if balance.Balance != 2 {
+		t.Errorf("Expected balance 2, got %v", balance.Balance)
+	}
+	if exhausted, _ := CreditsExhausted(db, apiID, userID); exhausted {
+		t.Error("Expected consumer with credits left not to be blocked")
+	}
+
+	// The next charge overdraws the balance and blocks further requests
+	if err := ChargeAPIUsage(db, &APIUsage{APIID: apiID, ExternalUserID: userID, RequestCount: 1, TokensUsed: 200}); err != nil {
+		t.Fatalf("Failed to charge usage: %v", err)
+	}
+	if exhausted, _ := CreditsExhausted(db, apiID, userID); !exhausted {
+		t.Error("Expected consumer with a negative balance to be blocked")
+	}
+
+	entries, total, err := ListCreditLedger(db, userID, 10, 0)
+	if err != nil {
+		t.Fatalf("Failed to list credit ledger: %v", err)
+	}
+	if total != 3 || len(entries) != 3 {
+		t.Fatalf("Expected 3 ledger entries, got %d", total)
+	}
+	if entries[0].EntryType != CreditEntryDebit || entries[0].BalanceAfter != -1 || entries[0].APIID != apiID {
+		t.Errorf("Unexpected latest ledger entry %+v", entries[0])
+	}
+	if entries[2].EntryType != CreditEntryTopUp || entries[2].Reference != "welcome credits" {
+		t.Errorf("Unexpected first ledger entry %+v", entries[2])
+	}
+}
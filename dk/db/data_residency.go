@@ -0,0 +1,327 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// NormalizeRegion returns the canonical form of a region tag
+func NormalizeRegion(region string) string {
+	return strings.ToUpper(strings.TrimSpace(region))
+}
+
+// RegionAllowed reports whether a target region satisfies a required region.
+// An empty target region is treated as unknown and therefore allowed; only
+// consumers or destinations explicitly flagged outside the region are blocked.
+func RegionAllowed(requiredRegion, targetRegion string) bool {
+	requiredRegion = NormalizeRegion(requiredRegion)
+	targetRegion = NormalizeRegion(targetRegion)
+	if requiredRegion == "" || targetRegion == "" {
+		return true
+	}
+	return requiredRegion == targetRegion
+}
+
+// SetCollectionResidency creates or replaces the residency constraint of a collection
+func SetCollectionResidency(db *sql.DB, residency *CollectionResidency) error {
+	residency.Region = NormalizeRegion(residency.Region)
+	if residency.CollectionName == "" || residency.Region == "" {
+		return fmt.Errorf("collection name and region are required")
+	}
+
+	if residency.UpdatedAt.IsZero() {
+		residency.UpdatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO collection_residency (collection_name, region, updated_at, updated_by)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(collection_name) DO UPDATE SET
+			region = excluded.region,
+			updated_at = excluded.updated_at,
+			updated_by = excluded.updated_by
+	`
+
+	_, err := db.Exec(query, residency.CollectionName, residency.Region, residency.UpdatedAt, residency.UpdatedBy)
+	if err != nil {
+		return fmt.Errorf("failed to set collection residency: %v", err)
+	}
+
+	return nil
+}
+
+// GetCollectionResidency retrieves the residency constraint of a collection
+func GetCollectionResidency(db *sql.DB, collectionName string) (*CollectionResidency, error) {
+	query := `
+		SELECT collection_name, region, updated_at, updated_by
+		FROM collection_residency
+		WHERE collection_name = ?
+	`
+
+	residency := &CollectionResidency{}
+	var updatedBy sql.NullString
+
+	err := db.QueryRow(query, collectionName).Scan(
+		&residency.CollectionName,
+		&residency.Region,
+		&residency.UpdatedAt,
+		&updatedBy,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get collection residency: %v", err)
+	}
+
+	if updatedBy.Valid {
+		residency.UpdatedBy = updatedBy.String
+	}
+
+	return residency, nil
+}
+
+// ListCollectionResidencies retrieves all collection residency constraints
+func ListCollectionResidencies(db *sql.DB) ([]*CollectionResidency, error) {
+	query := `
+		SELECT collection_name, region, updated_at, updated_by
+		FROM collection_residency
+		ORDER BY collection_name
+	`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collection residencies: %v", err)
+	}
+	defer rows.Close()
+
+	residencies := []*CollectionResidency{}
+	for rows.Next() {
+		residency := &CollectionResidency{}
+		var updatedBy sql.NullString
+
+		if err := rows.Scan(&residency.CollectionName, &residency.Region, &residency.UpdatedAt, &updatedBy); err != nil {
+			return nil, fmt.Errorf("failed to scan collection residency row: %v", err)
+		}
+
+		if updatedBy.Valid {
+			residency.UpdatedBy = updatedBy.String
+		}
+
+		residencies = append(residencies, residency)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating collection residency rows: %v", err)
+	}
+
+	return residencies, nil
+}
+
+// DeleteCollectionResidency removes the residency constraint of a collection
+func DeleteCollectionResidency(db *sql.DB, collectionName string) error {
+	result, err := db.Exec("DELETE FROM collection_residency WHERE collection_name = ?", collectionName)
+	if err != nil {
+		return fmt.Errorf("failed to delete collection residency: %v", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// SetConsumerRegion creates or replaces the region flag of an external consumer
+func SetConsumerRegion(db *sql.DB, consumer *ConsumerRegion) error {
+	consumer.Region = NormalizeRegion(consumer.Region)
+	if consumer.ExternalUserID == "" || consumer.Region == "" {
+		return fmt.Errorf("external user ID and region are required")
+	}
+
+	if consumer.UpdatedAt.IsZero() {
+		consumer.UpdatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO consumer_regions (external_user_id, region, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(external_user_id) DO UPDATE SET
+			region = excluded.region,
+			updated_at = excluded.updated_at
+	`
+
+	if _, err := db.Exec(query, consumer.ExternalUserID, consumer.Region, consumer.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to set consumer region: %v", err)
+	}
+
+	return nil
+}
+
+// GetConsumerRegion retrieves the region flag of an external consumer
+func GetConsumerRegion(db *sql.DB, externalUserID string) (*ConsumerRegion, error) {
+	query := `
+		SELECT external_user_id, region, updated_at
+		FROM consumer_regions
+		WHERE external_user_id = ?
+	`
+
+	consumer := &ConsumerRegion{}
+	err := db.QueryRow(query, externalUserID).Scan(&consumer.ExternalUserID, &consumer.Region, &consumer.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get consumer region: %v", err)
+	}
+
+	return consumer, nil
+}
+
+// FindAPIResidencyViolations returns the active consumers of an API that are
+// flagged in a region other than the required one
+func FindAPIResidencyViolations(db *sql.DB, apiID, requiredRegion string) ([]ResidencyViolation, error) {
+	query := `
+		SELECT a.external_user_id, r.region
+		FROM api_user_access a
+		JOIN consumer_regions r ON r.external_user_id = a.external_user_id
+		WHERE a.api_id = ? AND a.is_active = TRUE
+	`
+
+	rows, err := db.Query(query, apiID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API consumer regions: %v", err)
+	}
+	defer rows.Close()
+
+	violations := []ResidencyViolation{}
+	for rows.Next() {
+		var userID, region string
+		if err := rows.Scan(&userID, &region); err != nil {
+			return nil, fmt.Errorf("failed to scan consumer region row: %v", err)
+		}
+
+		if !RegionAllowed(requiredRegion, region) {
+			violations = append(violations, ResidencyViolation{
+				ExternalUserID: userID,
+				Region:         region,
+				RequiredRegion: NormalizeRegion(requiredRegion),
+			})
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating consumer region rows: %v", err)
+	}
+
+	return violations, nil
+}
+
+// CreateResidencyOverride records a confirmed residency override in the audit trail
+func CreateResidencyOverride(db *sql.DB, override *ResidencyOverride) error {
+	if override.ConfirmedBy == "" || strings.TrimSpace(override.Reason) == "" {
+		return fmt.Errorf("residency override requires a confirming user and a reason")
+	}
+
+	if override.ID == "" {
+		override.ID = uuid.New().String()
+	}
+
+	if override.CreatedAt.IsZero() {
+		override.CreatedAt = time.Now()
+	}
+
+	query := `
+		INSERT INTO residency_overrides (
+			id, collection_name, required_region, document_filename, target_type,
+			target_id, target_region, confirmed_by, reason, created_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+
+	_, err := db.Exec(
+		query,
+		override.ID,
+		override.CollectionName,
+		NormalizeRegion(override.RequiredRegion),
+		override.DocumentFilename,
+		override.TargetType,
+		override.TargetID,
+		NormalizeRegion(override.TargetRegion),
+		override.ConfirmedBy,
+		override.Reason,
+		override.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create residency override: %v", err)
+	}
+
+	return nil
+}
+
+// ListResidencyOverrides retrieves audited residency overrides, newest first
+func ListResidencyOverrides(db *sql.DB, limit, offset int) ([]*ResidencyOverride, int, error) {
+	var total int
+	if err := db.QueryRow("SELECT COUNT(*) FROM residency_overrides").Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count residency overrides: %v", err)
+	}
+
+	query := `
+		SELECT id, collection_name, required_region, document_filename, target_type,
+			target_id, target_region, confirmed_by, reason, created_at
+		FROM residency_overrides
+		ORDER BY created_at DESC
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := db.Query(query, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query residency overrides: %v", err)
+	}
+	defer rows.Close()
+
+	overrides := []*ResidencyOverride{}
+	for rows.Next() {
+		override := &ResidencyOverride{}
+		var documentFilename, targetRegion sql.NullString
+
+		err := rows.Scan(
+			&override.ID,
+			&override.CollectionName,
+			&override.RequiredRegion,
+			&documentFilename,
+			&override.TargetType,
+			&override.TargetID,
+			&targetRegion,
+			&override.ConfirmedBy,
+			&override.Reason,
+			&override.CreatedAt,
+		)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan residency override row: %v", err)
+		}
+
+		if documentFilename.Valid {
+			override.DocumentFilename = documentFilename.String
+		}
+		if targetRegion.Valid {
+			override.TargetRegion = targetRegion.String
+		}
+
+		overrides = append(overrides, override)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating residency override rows: %v", err)
+	}
+
+	return overrides, total, nil
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TestDataResidency tests collection residency, consumer regions and override auditing
+func TestDataResidency(t *testing.T) {
+	db := setupTestDB(t)
+
+	collection := "residency_" + uuid.New().String()[0:8]
+
+	// Unknown collection has no constraint
+	if _, err := GetCollectionResidency(db, collection); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for unconstrained collection, got %v", err)
+	}
+
+	if err := SetCollectionResidency(db, &CollectionResidency{CollectionName: collection, Region: " eu "}); err != nil {
+		t.Fatalf("Failed to set collection residency: %v", err)
+	}
+
+	residency, err := GetCollectionResidency(db, collection)
+	if err != nil {
+		t.Fatalf("Failed to get collection residency: %v", err)
+	}
+	if residency.Region != "EU" {
+		t.Errorf("Expected normalized region EU, got %s", residency.Region)
+	}
+
+	// Create an API with one consumer inside and one outside the region
+	apiID := uuid.New().String()
+	_, err = db.Exec(`
+		INSERT INTO apis (id, name, description, is_active, api_key, host_user_id)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, apiID, "Residency API", "API for residency testing", true, "test_key_"+apiID[0:8], "test_host")
+	if err != nil {
+		t.Fatalf("Failed to insert API: %v", err)
+	}
+
+	euUser := "eu_user_" + apiID[0:8]
+	usUser := "us_user_" + apiID[0:8]
+	for _, userID := range []string{euUser, usUser} {
+		_, err = db.Exec(`
+			INSERT INTO api_user_access (id, api_id, external_user_id, access_level, granted_at, granted_by, is_active)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, uuid.New().String(), apiID, userID, "read", time.Now(), "test_host", true)
+		if err != nil {
+			t.Fatalf("Failed to insert API user access: %v", err)
+		}
+	}
+
+	if err := SetConsumerRegion(db, &ConsumerRegion{ExternalUserID: euUser, Region: "EU"}); err != nil {
+		t.Fatalf("Failed to set consumer region: %v", err)
+	}
+	if err := SetConsumerRegion(db, &ConsumerRegion{ExternalUserID: usUser, Region: "US"}); err != nil {
+		t.Fatalf("Failed to set consumer region: %v", err)
+	}
+
+	violations, err := FindAPIResidencyViolations(db, apiID, residency.Region)
+	if err != nil {
+		t.Fatalf("Failed to find residency violations: %v", err)
+	}
+	if len(violations) != 1 || violations[0].ExternalUserID != usUser {
+		t.Fatalf("Expected a single violation for %s, got %+v", usUser, violations)
+	}
+
+	// Moving the consumer into the region clears the violation
+	if err := SetConsumerRegion(db, &ConsumerRegion{ExternalUserID: usUser, Region: "eu"}); err != nil {
+		t.Fatalf("Failed to update consumer region: %v", err)
+	}
+	violations, err = FindAPIResidencyViolations(db, apiID, residency.Region)
+	if err != nil {
+		t.Fatalf("Failed to find residency violations: %v", err)
+	}
+	if len(violations) != 0 {
+		t.Errorf("Expected no violations, got %+v", violations)
+	}
+
+	// Overrides require a reason
+	override := &ResidencyOverride{
+		CollectionName: collection,
+		RequiredRegion: "EU",
+		TargetType:     "export",
+		TargetID:       "s3://backups",
+		TargetRegion:   "US",
+		ConfirmedBy:    "test_host",
+	}
+	if err := CreateResidencyOverride(db, override); err == nil {
+		t.Error("Expected override without reason to fail")
+	}
+
+	override.Reason = "Legal hold copy approved by DPO"
+	if err := CreateResidencyOverride(db, override); err != nil {
+		t.Fatalf("Failed to create residency override: %v", err)
+	}
+
+	overrides, total, err := ListResidencyOverrides(db, 100, 0)
+	if err != nil {
+		t.Fatalf("Failed to list residency overrides: %v", err)
+	}
+	found := false
+	for _, o := range overrides {
+		if o.ID == override.ID {
+			found = true
+			if o.TargetRegion != "US" || o.Reason != override.Reason {
+				t.Errorf("Unexpected override contents: %+v", o)
+			}
+		}
+	}
+	if !found || total < 1 {
+		t.Errorf("Expected override %s to be listed", override.ID)
+	}
+
+	if err := DeleteCollectionResidency(db, collection); err != nil {
+		t.Fatalf("Failed to delete collection residency: %v", err)
+	}
+}
+
+// TestRegionAllowed tests region matching rules
+func TestRegionAllowed(t *testing.T) {
+	tests := []struct {
+		required, target string
+		want             bool
+	}{
+		{"EU", "EU", true},
+		{"EU", "eu", true},
+		{"EU", "US", false},
+		{"EU", "", true},
+		{"", "US", true},
+	}
+
+	for _, tt := range tests {
+		if got := RegionAllowed(tt.required, tt.target); got != tt.want {
+			t.Errorf("RegionAllowed(%q, %q) = %v, want %v", tt.required, tt.target, got, tt.want)
+		}
+	}
+}
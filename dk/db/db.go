@@ -20,7 +20,7 @@ func Initialize(dbPath string) (*sql.DB, error) {
 	// Use a DSN with memory settings and timeout configurations
 	dsn := fmt.Sprintf("%s?_busy_timeout=5000&_journal_mode=DELETE&cache=shared", dbPath)
 
-	db, err := sql.Open("sqlite", dsn)
+	db, err := sql.Open(utcDriverName, dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -59,6 +59,10 @@ func RunMigrations(db *sql.DB) error {
 		return fmt.Errorf("failed to run API Management migrations: %v", err)
 	}
 
+	if err := normalizeStoredTimestamps(db); err != nil {
+		return fmt.Errorf("failed to normalize stored timestamps: %v", err)
+	}
+
 	return nil
 }
 
@@ -116,6 +120,238 @@ func createOriginalTables(db *sql.DB) error {
 		created_at  DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
 
+	// Which LLM provider served each request (relevant with fallback chains)
+	llmRequestsTable := `
+	CREATE TABLE IF NOT EXISTS llm_requests (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		query_id    TEXT,                          -- "qry‑…" identifier, if any
+		operation   TEXT NOT NULL,                 -- "answer", "forward", …
+		provider    TEXT NOT NULL,                 -- e.g. "openai/gpt-4o"
+		failed_over TEXT,                          -- providers tried first, comma separated
+		created_at  DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Cached LLM completions, keyed by a hash of the provider and the full prompt
+	llmCacheTable := `
+	CREATE TABLE IF NOT EXISTS llm_cache (
+		prompt_hash TEXT PRIMARY KEY,              -- sha256 of provider, system and user prompt
+		provider    TEXT NOT NULL,                 -- e.g. "openai/gpt-4o"
+		response    TEXT NOT NULL,
+		hits        INTEGER NOT NULL DEFAULT 0,    -- times the entry was served
+		created_at  INTEGER NOT NULL,              -- unix seconds
+		expires_at  INTEGER NOT NULL               -- unix seconds
+	);
+	CREATE INDEX IF NOT EXISTS idx_llm_cache_expires ON llm_cache(expires_at);`
+
+	// Cached embeddings, keyed by the embedding model and a hash of the embedded text
+	embeddingCacheTable := `
+	CREATE TABLE IF NOT EXISTS embedding_cache (
+		model        TEXT NOT NULL,                -- e.g. "nomic-embed-text"
+		content_hash TEXT NOT NULL,                -- sha256 of the embedded text
+		embedding    BLOB NOT NULL,                -- little-endian float32 values
+		created_at   INTEGER NOT NULL,             -- unix seconds
+		PRIMARY KEY (model, content_hash)
+	);`
+
+	// Opt-in usage telemetry: consent per category and daily feature counters, aggregated
+	// locally and only reported once the user approved the exact report
+	telemetryTables := `
+	CREATE TABLE IF NOT EXISTS telemetry_consent (
+		category   TEXT PRIMARY KEY,               -- "mcp", "rag", "queries"
+		enabled    INTEGER NOT NULL DEFAULT 0,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS telemetry_counters (
+		category TEXT NOT NULL,
+		feature  TEXT NOT NULL,                    -- e.g. "cqAskQuestion"
+		day      TEXT NOT NULL,                    -- UTC date, YYYY-MM-DD
+		count    INTEGER NOT NULL DEFAULT 0,
+		reported INTEGER NOT NULL DEFAULT 0,       -- 1 once included in a sent report
+		PRIMARY KEY (category, feature, day)
+	);`
+
+	// Social recovery of the identity key: the trustees this peer distributed shares to, the
+	// shares this peer holds for others, recovery requests it received as a trustee and the
+	// shares it collected while recovering its own key
+	keyEscrowTables := `
+	CREATE TABLE IF NOT EXISTS key_escrow_trustees (
+		trustee_id     TEXT PRIMARY KEY,
+		share_index    INTEGER NOT NULL,
+		threshold      INTEGER NOT NULL,
+		distributed_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS key_escrow_shares (
+		owner_id    TEXT PRIMARY KEY,              -- peer whose key the share belongs to
+		share_index INTEGER NOT NULL,
+		threshold   INTEGER NOT NULL,
+		share       BLOB NOT NULL,
+		received_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE IF NOT EXISTS key_escrow_requests (
+		id           TEXT PRIMARY KEY,
+		owner_id     TEXT NOT NULL,                -- peer whose key is being recovered
+		requester_id TEXT NOT NULL,                -- temporary identity of the new device
+		status       TEXT NOT NULL,                -- "pending", "approved", "denied"
+		created_at   DATETIME DEFAULT CURRENT_TIMESTAMP,
+		decided_at   DATETIME
+	);
+	CREATE TABLE IF NOT EXISTS key_escrow_collected (
+		owner_id    TEXT NOT NULL,
+		share_index INTEGER NOT NULL,
+		trustee_id  TEXT NOT NULL,
+		threshold   INTEGER NOT NULL,
+		share       BLOB NOT NULL,
+		received_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (owner_id, share_index)
+	);`
+
+	// Roles delegated to other users; only a hash of each access token is kept
+	roleAssignmentsTable := `
+	CREATE TABLE IF NOT EXISTS role_assignments (
+		user_id     TEXT PRIMARY KEY,
+		role        TEXT NOT NULL,                 -- "curator"
+		token_hash  TEXT NOT NULL UNIQUE,          -- hex SHA-256 of the access token
+		assigned_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Questions sent later, once or on a cron schedule, each sending of them and the answers
+	// peers gave to each sending
+	scheduledQueriesTables := `
+	CREATE TABLE IF NOT EXISTS scheduled_queries (
+		id         TEXT PRIMARY KEY,
+		question   TEXT NOT NULL,
+		peers      TEXT NOT NULL,                  -- JSON array; empty to broadcast
+		schedule   TEXT NOT NULL DEFAULT '',       -- cron expression; '' for a one-time query
+		next_run   DATETIME,                       -- NULL once there are no more runs
+		last_run   DATETIME,
+		active     BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_scheduled_queries_next_run ON scheduled_queries(active, next_run);
+	CREATE TABLE IF NOT EXISTS scheduled_query_runs (
+		id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+		scheduled_query_id TEXT NOT NULL,
+		sent_at            DATETIME NOT NULL,
+		error              TEXT NOT NULL DEFAULT ''  -- why sending failed, '' if it was sent
+	);
+	CREATE INDEX IF NOT EXISTS idx_scheduled_query_runs_query ON scheduled_query_runs(scheduled_query_id, sent_at);
+	CREATE TABLE IF NOT EXISTS scheduled_query_answers (
+		run_id      INTEGER NOT NULL,
+		user        TEXT NOT NULL,
+		answer      TEXT NOT NULL,
+		received_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (run_id, user)
+	);`
+
+	// Triage summaries of incoming queries, made when they arrive
+	queryScreeningsTable := `
+	CREATE TABLE IF NOT EXISTS query_screenings (
+		query_id          TEXT PRIMARY KEY,
+		summary           TEXT NOT NULL,
+		tags              TEXT NOT NULL,           -- JSON array
+		effort            TEXT NOT NULL,           -- "low", "medium", "high"
+		matched_documents INTEGER NOT NULL DEFAULT 0,
+		created_at        DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	// Outcomes of evaluating the automatic approval conditions for incoming queries
+	approvalDecisionsTable := `
+	CREATE TABLE IF NOT EXISTS approval_decisions (
+		id                INTEGER PRIMARY KEY AUTOINCREMENT,
+		query_id          TEXT NOT NULL,
+		decision          TEXT NOT NULL,           -- "accept", "reject", "none"
+		matched_condition TEXT NOT NULL DEFAULT '',
+		reason            TEXT NOT NULL DEFAULT '',
+		created_at        DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_approval_decisions_query ON approval_decisions(query_id);`
+
+	// Every MCP tool call, to detect and throttle runaway agents
+	toolCallsTable := `
+	CREATE TABLE IF NOT EXISTS tool_calls (
+		id          INTEGER PRIMARY KEY AUTOINCREMENT,
+		tool        TEXT NOT NULL,
+		caller      TEXT NOT NULL,                -- role:user ID of the MCP session
+		args_hash   TEXT NOT NULL,                -- SHA-256 of the JSON arguments
+		duration_ms INTEGER NOT NULL DEFAULT 0,
+		outcome     TEXT NOT NULL,                -- "ok" or the code of the error
+		created_at  DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS idx_tool_calls_created ON tool_calls(created_at);`
+
+	// Outgoing websocket messages waiting to be sent, flushed in order once connected
+	outboxTable := `
+	CREATE TABLE IF NOT EXISTS outbox (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		recipient  TEXT NOT NULL,
+		message    TEXT NOT NULL,               -- JSON of the message, before encryption
+		queued_at  DATETIME NOT NULL
+	);`
+
+	// Keys of the websocket groups this node is a member of, one per epoch
+	groupKeysTable := `
+	CREATE TABLE IF NOT EXISTS group_keys (
+		group_id   TEXT NOT NULL,
+		epoch      INTEGER NOT NULL,           -- incremented each time the owner rotates the key
+		key        BLOB NOT NULL,
+		created_at DATETIME NOT NULL,
+		PRIMARY KEY (group_id, epoch)
+	);`
+
+	// Ratcheting sessions of direct messages with each peer, as kept by the client
+	ratchetSessionsTable := `
+	CREATE TABLE IF NOT EXISTS ratchet_sessions (
+		peer       TEXT PRIMARY KEY,
+		state      BLOB NOT NULL,              -- JSON of the sessions, including their keys
+		updated_at DATETIME NOT NULL
+	);`
+
+	// Latest sequence number received from each peer, to drop replayed messages
+	peerSequencesTable := `
+	CREATE TABLE IF NOT EXISTS peer_sequences (
+		peer       TEXT PRIMARY KEY,
+		sequence   INTEGER NOT NULL,
+		updated_at DATETIME NOT NULL
+	);`
+
+	// Public key of each peer, trusted on first use or pinned, to notice unexpected changes
+	peerKeysTable := `
+	CREATE TABLE IF NOT EXISTS peer_keys (
+		peer       TEXT PRIMARY KEY,
+		public_key BLOB NOT NULL,
+		pinned     BOOLEAN NOT NULL DEFAULT FALSE,
+		first_seen DATETIME NOT NULL,
+		fetched_at DATETIME NOT NULL
+	);`
+
+	// Original files of documents, kept in the configured blob store
+	documentBlobsTable := `
+	CREATE TABLE IF NOT EXISTS document_blobs (
+		document_filename TEXT PRIMARY KEY,        -- document_associations.document_filename
+		blob_key          TEXT NOT NULL,           -- key of the file in the blob store
+		content_type      TEXT NOT NULL DEFAULT '',
+		size_bytes        INTEGER NOT NULL,
+		sha256            TEXT NOT NULL,           -- hex digest of the file
+		created_at        DATETIME NOT NULL,
+		unreferenced_at   DATETIME                 -- when the last association went away; NULL while referenced
+	);`
+
+	// Notes the host keeps about peers, and the datasets they are tagged with
+	contactsTables := `
+	CREATE TABLE IF NOT EXISTS contacts (
+		peer_id    TEXT PRIMARY KEY,
+		alias      TEXT,                           -- unique name the host refers to the peer by
+		notes      TEXT NOT NULL DEFAULT '',
+		updated_at DATETIME NOT NULL
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_contacts_alias ON contacts(alias);
+	CREATE TABLE IF NOT EXISTS contact_tags (
+		peer_id TEXT NOT NULL,
+		tag     TEXT NOT NULL,                     -- lowercase, e.g. "genomics"
+		PRIMARY KEY (peer_id, tag)
+	);
+	CREATE INDEX IF NOT EXISTS idx_contact_tags_tag ON contact_tags(tag);`
+
 	if _, err := db.Exec(answersTable); err != nil {
 		return fmt.Errorf("failed to create answers table: %v", err)
 	}
@@ -129,6 +365,67 @@ func createOriginalTables(db *sql.DB) error {
 		return fmt.Errorf("failed to create descriptions_global table: %v", err)
 	}
 
+	if _, err := db.Exec(llmRequestsTable); err != nil {
+		return fmt.Errorf("failed to create llm_requests table: %v", err)
+	}
+	if _, err := db.Exec(llmCacheTable); err != nil {
+		return fmt.Errorf("failed to create llm_cache table: %v", err)
+	}
+	if _, err := db.Exec(embeddingCacheTable); err != nil {
+		return fmt.Errorf("failed to create embedding_cache table: %v", err)
+	}
+	if _, err := db.Exec(telemetryTables); err != nil {
+		return fmt.Errorf("failed to create telemetry tables: %v", err)
+	}
+	if _, err := db.Exec(keyEscrowTables); err != nil {
+		return fmt.Errorf("failed to create key escrow tables: %v", err)
+	}
+	if _, err := db.Exec(roleAssignmentsTable); err != nil {
+		return fmt.Errorf("failed to create role_assignments table: %v", err)
+	}
+	if _, err := db.Exec(scheduledQueriesTables); err != nil {
+		return fmt.Errorf("failed to create scheduled query tables: %v", err)
+	}
+	if _, err := db.Exec(queryScreeningsTable); err != nil {
+		return fmt.Errorf("failed to create query_screenings table: %v", err)
+	}
+
+	if _, err := db.Exec(approvalDecisionsTable); err != nil {
+		return fmt.Errorf("failed to create approval_decisions table: %v", err)
+	}
+
+	if _, err := db.Exec(toolCallsTable); err != nil {
+		return fmt.Errorf("failed to create tool_calls table: %v", err)
+	}
+
+	if _, err := db.Exec(outboxTable); err != nil {
+		return fmt.Errorf("failed to create outbox table: %v", err)
+	}
+
+	if _, err := db.Exec(groupKeysTable); err != nil {
+		return fmt.Errorf("failed to create group_keys table: %v", err)
+	}
+
+	if _, err := db.Exec(ratchetSessionsTable); err != nil {
+		return fmt.Errorf("failed to create ratchet_sessions table: %v", err)
+	}
+
+	if _, err := db.Exec(peerSequencesTable); err != nil {
+		return fmt.Errorf("failed to create peer_sequences table: %v", err)
+	}
+
+	if _, err := db.Exec(peerKeysTable); err != nil {
+		return fmt.Errorf("failed to create peer_keys table: %v", err)
+	}
+
+	if _, err := db.Exec(documentBlobsTable); err != nil {
+		return fmt.Errorf("failed to create document_blobs table: %v", err)
+	}
+
+	if _, err := db.Exec(contactsTables); err != nil {
+		return fmt.Errorf("failed to create contact tables: %v", err)
+	}
+
 	// new migration for the queries table
 	if _, err := db.Exec(queriesTable); err != nil {
 		return fmt.Errorf("failed to create queries table: %v", err)
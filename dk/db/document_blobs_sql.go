@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DocumentBlob records where the original file of a document is kept in the blob store
+type DocumentBlob struct {
+	DocumentFilename string     `json:"document_filename"`
+	BlobKey          string     `json:"blob_key"`
+	ContentType      string     `json:"content_type,omitempty"`
+	SizeBytes        int64      `json:"size_bytes"`
+	SHA256           string     `json:"sha256"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UnreferencedAt   *time.Time `json:"unreferenced_at,omitempty"` // Since when no API or request uses the document
+	References       int        `json:"references"`                // API and request associations of the document
+}
+
+// PutDocumentBlob records the original file of a document, replacing the previous record
+func PutDocumentBlob(ctx context.Context, db *sql.DB, blob DocumentBlob) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO document_blobs
+			(document_filename, blob_key, content_type, size_bytes, sha256, created_at, unreferenced_at)
+		 VALUES (?, ?, ?, ?, ?, ?, NULL)`,
+		blob.DocumentFilename, blob.BlobKey, blob.ContentType, blob.SizeBytes, blob.SHA256, blob.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("put document blob: %w", err)
+	}
+	return nil
+}
+
+// GetDocumentBlob returns the original file record of a document, or ErrNotFound
+func GetDocumentBlob(ctx context.Context, db *sql.DB, filename string) (*DocumentBlob, error) {
+	blobs, err := queryDocumentBlobs(ctx, db, "WHERE b.document_filename = ?", filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(blobs) == 0 {
+		return nil, ErrNotFound
+	}
+	return &blobs[0], nil
+}
+
+// ListDocumentBlobs returns every original file record with its reference count
+func ListDocumentBlobs(ctx context.Context, db *sql.DB) ([]DocumentBlob, error) {
+	return queryDocumentBlobs(ctx, db, "ORDER BY b.created_at")
+}
+
+// DeleteDocumentBlob removes the original file record of a document
+func DeleteDocumentBlob(ctx context.Context, db *sql.DB, filename string) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM document_blobs WHERE document_filename = ?`, filename); err != nil {
+		return fmt.Errorf("delete document blob: %w", err)
+	}
+	return nil
+}
+
+// SetDocumentBlobUnreferenced records since when a document has no API or request
+// associations, or clears it when at is nil
+func SetDocumentBlobUnreferenced(ctx context.Context, db *sql.DB, filename string, at *time.Time) error {
+	if _, err := db.ExecContext(ctx, `UPDATE document_blobs SET unreferenced_at = ? WHERE document_filename = ?`, at, filename); err != nil {
+		return fmt.Errorf("update document blob: %w", err)
+	}
+	return nil
+}
+
+func queryDocumentBlobs(ctx context.Context, db *sql.DB, clause string, args ...any) ([]DocumentBlob, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT b.document_filename, b.blob_key, b.content_type, b.size_bytes, b.sha256, b.created_at, b.unreferenced_at,
+			(SELECT COUNT(*) FROM document_associations a
+			 WHERE a.document_filename = b.document_filename AND a.entity_type IN ('api', 'request'))
+		FROM document_blobs b `+clause, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query document blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var blobs []DocumentBlob
+	for rows.Next() {
+		var blob DocumentBlob
+		var unreferencedAt sql.NullTime
+		if err := rows.Scan(&blob.DocumentFilename, &blob.BlobKey, &blob.ContentType, &blob.SizeBytes,
+			&blob.SHA256, &blob.CreatedAt, &unreferencedAt, &blob.References); err != nil {
+			return nil, fmt.Errorf("scan document blob: %w", err)
+		}
+		if unreferencedAt.Valid {
+			blob.UnreferencedAt = &unreferencedAt.Time
+		}
+		blobs = append(blobs, blob)
+	}
+	return blobs, rows.Err()
+}
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// GetCachedEmbedding returns the embedding of a text hash computed by a model. It returns
+// ErrNotFound if there is none.
+func GetCachedEmbedding(ctx context.Context, db *sql.DB, model, contentHash string) ([]float32, error) {
+	var blob []byte
+	err := db.QueryRowContext(ctx,
+		`SELECT embedding FROM embedding_cache WHERE model = ? AND content_hash = ?`,
+		model, contentHash).Scan(&blob)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get cached embedding: %w", err)
+	}
+	if len(blob)%4 != 0 {
+		return nil, fmt.Errorf("get cached embedding: stored embedding has %d bytes", len(blob))
+	}
+
+	embedding := make([]float32, len(blob)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(blob[i*4:]))
+	}
+	return embedding, nil
+}
+
+// PutCachedEmbedding stores the embedding of a text hash computed by a model
+func PutCachedEmbedding(ctx context.Context, db *sql.DB, model, contentHash string, embedding []float32, now time.Time) error {
+	blob := make([]byte, len(embedding)*4)
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(blob[i*4:], math.Float32bits(v))
+	}
+	_, err := db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO embedding_cache (model, content_hash, embedding, created_at)
+		 VALUES (?, ?, ?, ?)`,
+		model, contentHash, blob, now.Unix())
+	if err != nil {
+		return fmt.Errorf("put cached embedding: %w", err)
+	}
+	return nil
+}
+
+// ClearEmbeddingCache removes the cached embeddings of a model, or of every model when model
+// is empty, and returns how many were removed
+func ClearEmbeddingCache(ctx context.Context, db *sql.DB, model string) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM embedding_cache WHERE ? = '' OR model = ?`, model, model)
+	if err != nil {
+		return 0, fmt.Errorf("clear embedding cache: %w", err)
+	}
+	return res.RowsAffected()
+}
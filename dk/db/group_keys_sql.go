@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GroupKey is the key of a websocket group for one epoch
+type GroupKey struct {
+	GroupID   string    `json:"group_id"`
+	Epoch     int       `json:"epoch"`
+	Key       []byte    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SaveGroupKey stores the key of a group for an epoch, replacing the key already stored
+func SaveGroupKey(ctx context.Context, db *sql.DB, k GroupKey) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO group_keys (group_id, epoch, key, created_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(group_id, epoch) DO UPDATE SET key = excluded.key, created_at = excluded.created_at`,
+		k.GroupID, k.Epoch, k.Key, k.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("save group key: %w", err)
+	}
+	return nil
+}
+
+// GetGroupKey returns the key of a group for an epoch, or its latest key when epoch is 0.
+// It returns nil when there is no such key.
+func GetGroupKey(ctx context.Context, db *sql.DB, groupID string, epoch int) (*GroupKey, error) {
+	query := `SELECT group_id, epoch, key, created_at FROM group_keys WHERE group_id = ? AND epoch = ?`
+	args := []any{groupID, epoch}
+	if epoch == 0 {
+		query = `SELECT group_id, epoch, key, created_at FROM group_keys WHERE group_id = ? ORDER BY epoch DESC LIMIT 1`
+		args = args[:1]
+	}
+	var k GroupKey
+	err := db.QueryRowContext(ctx, query, args...).Scan(&k.GroupID, &k.Epoch, &k.Key, &k.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get group key: %w", err)
+	}
+	return &k, nil
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestGroupKeys(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	if key, err := GetGroupKey(ctx, testDB.DB, "grp-1", 0); err != nil || key != nil {
+		t.Fatalf("Expected no key, got %+v (%v)", key, err)
+	}
+
+	for epoch, key := range map[int][]byte{1: []byte("first"), 2: []byte("second")} {
+		if err := SaveGroupKey(ctx, testDB.DB, GroupKey{GroupID: "grp-1", Epoch: epoch, Key: key, CreatedAt: time.Now()}); err != nil {
+			t.Fatalf("Failed to save group key: %v", err)
+		}
+	}
+	if err := SaveGroupKey(ctx, testDB.DB, GroupKey{GroupID: "grp-2", Epoch: 5, Key: []byte("other"), CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to save group key: %v", err)
+	}
+
+	latest, err := GetGroupKey(ctx, testDB.DB, "grp-1", 0)
+	if err != nil || latest == nil {
+		t.Fatalf("Failed to get the latest group key: %v", err)
+	}
+	if latest.Epoch != 2 || !bytes.Equal(latest.Key, []byte("second")) {
+		t.Errorf("Expected the key of epoch 2, got %+v", latest)
+	}
+
+	// Saving an epoch again replaces its key
+	if err := SaveGroupKey(ctx, testDB.DB, GroupKey{GroupID: "grp-1", Epoch: 1, Key: []byte("replaced"), CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to save group key: %v", err)
+	}
+	first, err := GetGroupKey(ctx, testDB.DB, "grp-1", 1)
+	if err != nil || first == nil || !bytes.Equal(first.Key, []byte("replaced")) {
+		t.Errorf("Expected the replaced key of epoch 1, got %+v (%v)", first, err)
+	}
+	if key, err := GetGroupKey(ctx, testDB.DB, "grp-1", 3); err != nil || key != nil {
+		t.Errorf("Expected no key for epoch 3, got %+v (%v)", key, err)
+	}
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Kinds of history records
+const (
+	HistoryQuery  = "query"  // A question a peer asked, with the drafted answer and its review
+	HistoryAnswer = "answer" // An answer a peer sent to a question that was asked
+)
+
+// HistoryRecord is a query or an answer exchanged with a peer
+type HistoryRecord struct {
+	Kind      string    `json:"kind"`
+	ID        string    `json:"id,omitempty"` // Query ID; answers are identified by question and peer
+	Peer      string    `json:"peer"`         // The peer that asked the query or sent the answer
+	Question  string    `json:"question"`
+	Answer    string    `json:"answer"`
+	Status    string    `json:"status,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListHistory returns the queries received and the answers received between since, inclusive,
+// and until, exclusive, oldest first. A zero bound leaves that side open.
+func ListHistory(ctx context.Context, db *sql.DB, since, until time.Time) ([]HistoryRecord, error) {
+	conditions, args := historyRange(since, until)
+	records := []HistoryRecord{}
+
+	rows, err := db.QueryContext(ctx, `SELECT id, from_source, question, COALESCE(answer, ''), status,
+		COALESCE(reason, ''), created_at FROM queries`+conditions, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list query history: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		record := HistoryRecord{Kind: HistoryQuery}
+		if err := rows.Scan(&record.ID, &record.Peer, &record.Question, &record.Answer, &record.Status,
+			&record.Reason, &record.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan query history: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.QueryContext(ctx, `SELECT user, question, answer, created_at FROM answers`+conditions, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list answer history: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		record := HistoryRecord{Kind: HistoryAnswer}
+		if err := rows.Scan(&record.Peer, &record.Question, &record.Answer, &record.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan answer history: %w", err)
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool { return records[i].CreatedAt.Before(records[j].CreatedAt) })
+	return records, nil
+}
+
+// historyRange returns the WHERE clause bounding created_at, which both tables set with
+// CURRENT_TIMESTAMP in UTC
+func historyRange(since, until time.Time) (string, []any) {
+	const layout = "2006-01-02 15:04:05"
+	var conditions string
+	var args []any
+	if !since.IsZero() {
+		conditions = " WHERE created_at >= ?"
+		args = append(args, since.UTC().Format(layout))
+	}
+	if !until.IsZero() {
+		if conditions == "" {
+			conditions = " WHERE created_at < ?"
+		} else {
+			conditions += " AND created_at < ?"
+		}
+		args = append(args, until.UTC().Format(layout))
+	}
+	return conditions, args
+}
@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned for page cursors that were not returned by a previous page
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// pageCursor marks the last row of a page; the next page starts after it. Cursors stay valid
+// when rows are added, unlike offsets, which shift as new queries and answers arrive.
+type pageCursor struct {
+	CreatedAt string `json:"t,omitempty"`
+	Row       int64  `json:"r"`
+}
+
+func (c pageCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodePageCursor(cursor string) (pageCursor, error) {
+	var c pageCursor
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || json.Unmarshal(raw, &c) != nil || c.Row <= 0 {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// QueryFilter selects the queries to list; empty fields match every query
+type QueryFilter struct {
+	Status string
+	From   string
+	Search string    // Case-insensitive text the question or answer contains
+	Since  time.Time // Only queries received at or after this time
+}
+
+// QueryPage is one page of listed queries, newest first
+type QueryPage struct {
+	Queries    []Query `json:"queries"`
+	Total      int     `json:"total"`                 // Queries matching the filter, across all pages
+	NextCursor string  `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+// ListQueriesPage lists up to limit queries matching the filter, newest first. The page starts
+// after the cursor of the previous page if one is given, otherwise after offset queries.
+func ListQueriesPage(ctx context.Context, db *sql.DB, filter QueryFilter, limit, offset int, cursor string) (*QueryPage, error) {
+	var where []string
+	var args []any
+	if filter.Status != "" {
+		where = append(where, "LOWER(status)=LOWER(?)")
+		args = append(args, filter.Status)
+	}
+	if filter.From != "" {
+		where = append(where, "from_source=?")
+		args = append(args, filter.From)
+	}
+	if filter.Search != "" {
+		where = append(where, "(instr(LOWER(question), LOWER(?)) > 0 OR instr(LOWER(COALESCE(answer, '')), LOWER(?)) > 0)")
+		args = append(args, filter.Search, filter.Search)
+	}
+	if !filter.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, filter.Since.UTC().Format("2006-01-02 15:04:05"))
+	}
+	conditions := ""
+	if len(where) > 0 {
+		conditions = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	page := &QueryPage{Queries: []Query{}}
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM queries"+conditions, args...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("count queries: %w", err)
+	}
+
+	if cursor != "" {
+		after, err := decodePageCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		if conditions == "" {
+			conditions = " WHERE "
+		} else {
+			conditions += " AND "
+		}
+		conditions += "(created_at, rowid) < (?, ?)"
+		args = append(args, after.CreatedAt, after.Row)
+		offset = 0
+	}
+	// One row more than asked tells whether there is a next page
+	args = append(args, limit+1, offset)
+	rows, err := db.QueryContext(ctx, `SELECT rowid, CAST(created_at AS TEXT), id, from_source, question,
+		COALESCE(answer, ''), COALESCE(documents_related, ''), status, COALESCE(reason, '')
+		FROM queries`+conditions+` ORDER BY created_at DESC, rowid DESC LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list queries: %w", err)
+	}
+	defer rows.Close()
+
+	var last pageCursor
+	for rows.Next() {
+		var q Query
+		var docs string
+		var next pageCursor
+		if err := rows.Scan(&next.Row, &next.CreatedAt, &q.ID, &q.From, &q.Question, &q.Answer, &docs, &q.Status, &q.Reason); err != nil {
+			return nil, fmt.Errorf("scan query row: %w", err)
+		}
+		if len(page.Queries) == limit {
+			page.NextCursor = last.encode()
+			break
+		}
+		_ = json.Unmarshal([]byte(docs), &q.DocumentsRelated)
+		page.Queries = append(page.Queries, q)
+		last = next
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	return page, attachQueryScreenings(ctx, db, page.Queries)
+}
+
+// AnswerFilter selects the answers to list; empty fields match every answer
+type AnswerFilter struct {
+	Question string // Case-insensitive text the question contains
+	User     string
+	Search   string // Case-insensitive text the answer contains
+}
+
+// AnswerPage is one page of listed answers, newest first
+type AnswerPage struct {
+	Answers    []Answer `json:"answers"`
+	Total      int      `json:"total"`                 // Answers matching the filter, across all pages
+	NextCursor string   `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+// ListAnswersPage lists up to limit answers matching the filter, newest first. The page starts
+// after the cursor of the previous page if one is given, otherwise after offset answers.
+func ListAnswersPage(ctx context.Context, db *sql.DB, filter AnswerFilter, limit, offset int, cursor string) (*AnswerPage, error) {
+	var where []string
+	var args []any
+	if filter.Question != "" {
+		where = append(where, "instr(LOWER(question), LOWER(?)) > 0")
+		args = append(args, filter.Question)
+	}
+	if filter.User != "" {
+		where = append(where, "user=?")
+		args = append(args, filter.User)
+	}
+	if filter.Search != "" {
+		where = append(where, "instr(LOWER(answer), LOWER(?)) > 0")
+		args = append(args, filter.Search)
+	}
+	conditions := ""
+	if len(where) > 0 {
+		conditions = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	page := &AnswerPage{Answers: []Answer{}}
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM answers"+conditions, args...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("count answers: %w", err)
+	}
+
+	if cursor != "" {
+		after, err := decodePageCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		if conditions == "" {
+			conditions = " WHERE "
+		} else {
+			conditions += " AND "
+		}
+		conditions += "id < ?"
+		args = append(args, after.Row)
+		offset = 0
+	}
+	args = append(args, limit+1, offset)
+	rows, err := db.QueryContext(ctx, `SELECT id, question, user, answer, created_at
+		FROM answers`+conditions+` ORDER BY id DESC LIMIT ? OFFSET ?`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list answers: %w", err)
+	}
+	defer rows.Close()
+
+	var last int64
+	for rows.Next() {
+		var a Answer
+		var id int64
+		if err := rows.Scan(&id, &a.Question, &a.User, &a.Text, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan answer row: %w", err)
+		}
+		if len(page.Answers) == limit {
+			page.NextCursor = pageCursor{Row: last}.encode()
+			break
+		}
+		page.Answers = append(page.Answers, a)
+		last = id
+	}
+	return page, rows.Err()
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestListQueriesPage(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := context.Background()
+	for i := 1; i <= 5; i++ {
+		status := "pending"
+		if i%2 == 0 {
+			status = "accepted"
+		}
+		q := Query{ID: fmt.Sprintf("qry-%d", i), From: "bob", Question: fmt.Sprintf("Question %d about weather", i), Status: status}
+		if err := InsertQuery(ctx, testDB.DB, q); err != nil {
+			t.Fatalf("Failed to insert query: %v", err)
+		}
+	}
+
+	// Walking the cursors returns every query once, newest first, even if queries arrive meanwhile
+	var ids []string
+	cursor := ""
+	for pages := 0; pages < 10; pages++ {
+		page, err := ListQueriesPage(ctx, testDB.DB, QueryFilter{}, 2, 0, cursor)
+		if err != nil {
+			t.Fatalf("ListQueriesPage failed: %v", err)
+		}
+		if page.Total < 5 {
+			t.Errorf("Expected at least 5 queries in total, got %d", page.Total)
+		}
+		for _, q := range page.Queries {
+			ids = append(ids, q.ID)
+		}
+		if pages == 0 {
+			InsertQuery(ctx, testDB.DB, Query{ID: "qry-6", From: "carol", Question: "Late question", Status: "pending"})
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if fmt.Sprint(ids) != "[qry-5 qry-4 qry-3 qry-2 qry-1]" {
+		t.Errorf("Unexpected pages: %v", ids)
+	}
+
+	page, err := ListQueriesPage(ctx, testDB.DB, QueryFilter{Status: "PENDING", Search: "WEATHER"}, 10, 1, "")
+	if err != nil {
+		t.Fatalf("ListQueriesPage failed: %v", err)
+	}
+	if page.Total != 3 || len(page.Queries) != 2 || page.Queries[0].ID != "qry-3" || page.NextCursor != "" {
+		t.Errorf("Unexpected filtered page: %+v", page)
+	}
+
+	if _, err := ListQueriesPage(ctx, testDB.DB, QueryFilter{}, 2, 0, "not-a-cursor"); err != ErrInvalidCursor {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+func TestListAnswersPage(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := context.Background()
+	for _, a := range []Answer{
+		{Question: "Is it sunny?", User: "bob", Text: "Yes, very sunny"},
+		{Question: "Is it sunny?", User: "carol", Text: "Cloudy here"},
+		{Question: "What is the budget?", User: "bob", Text: "Ten thousand"},
+	} {
+		if err := InsertAnswer(ctx, testDB.DB, a); err != nil {
+			t.Fatalf("Failed to insert answer: %v", err)
+		}
+	}
+
+	page, err := ListAnswersPage(ctx, testDB.DB, AnswerFilter{Question: "sunny"}, 1, 0, "")
+	if err != nil {
+		t.Fatalf("ListAnswersPage failed: %v", err)
+	}
+	if page.Total != 2 || len(page.Answers) != 1 || page.Answers[0].User != "carol" || page.NextCursor == "" {
+		t.Fatalf("Unexpected first page: %+v", page)
+	}
+	page, err = ListAnswersPage(ctx, testDB.DB, AnswerFilter{Question: "sunny"}, 1, 0, page.NextCursor)
+	if err != nil {
+		t.Fatalf("ListAnswersPage failed: %v", err)
+	}
+	if len(page.Answers) != 1 || page.Answers[0].User != "bob" || page.NextCursor != "" {
+		t.Errorf("Unexpected last page: %+v", page)
+	}
+
+	page, err = ListAnswersPage(ctx, testDB.DB, AnswerFilter{User: "bob", Search: "THOUSAND"}, 10, 0, "")
+	if err != nil || page.Total != 1 || page.Answers[0].Question != "What is the budget?" {
+		t.Errorf("Unexpected filtered page: %+v (%v)", page, err)
+	}
+}
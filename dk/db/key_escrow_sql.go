@@ -0,0 +1,249 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Status values of key escrow recovery requests
+const (
+	EscrowRequestPending  = "pending"
+	EscrowRequestApproved = "approved"
+	EscrowRequestDenied   = "denied"
+)
+
+// EscrowTrustee is a peer this peer gave a share of its identity key to
+type EscrowTrustee struct {
+	TrusteeID     string    `json:"trustee_id"`
+	ShareIndex    int       `json:"share_index"`
+	Threshold     int       `json:"threshold"`
+	DistributedAt time.Time `json:"distributed_at"`
+}
+
+// EscrowShare is a share of a peer's identity key, either held as a trustee or collected from
+// a trustee during a recovery (then TrusteeID is set)
+type EscrowShare struct {
+	OwnerID    string    `json:"owner_id"`
+	TrusteeID  string    `json:"trustee_id,omitempty"`
+	ShareIndex int       `json:"share_index"`
+	Threshold  int       `json:"threshold"`
+	Share      []byte    `json:"-"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// EscrowRequest is a request to release a held share to a recovering device
+type EscrowRequest struct {
+	ID          string     `json:"id"`
+	OwnerID     string     `json:"owner_id"`
+	RequesterID string     `json:"requester_id"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DecidedAt   *time.Time `json:"decided_at,omitempty"`
+}
+
+// ReplaceEscrowTrustees records the trustees of a new key split, replacing the previous one
+func ReplaceEscrowTrustees(ctx context.Context, db *sql.DB, trustees []EscrowTrustee) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM key_escrow_trustees`); err != nil {
+		return fmt.Errorf("clear escrow trustees: %w", err)
+	}
+	for _, t := range trustees {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO key_escrow_trustees (trustee_id, share_index, threshold) VALUES (?, ?, ?)`,
+			t.TrusteeID, t.ShareIndex, t.Threshold); err != nil {
+			return fmt.Errorf("insert escrow trustee: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ListEscrowTrustees returns the trustees of the current key split
+func ListEscrowTrustees(ctx context.Context, db *sql.DB) ([]EscrowTrustee, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT trustee_id, share_index, threshold, distributed_at FROM key_escrow_trustees ORDER BY share_index`)
+	if err != nil {
+		return nil, fmt.Errorf("list escrow trustees: %w", err)
+	}
+	defer rows.Close()
+
+	var out []EscrowTrustee
+	for rows.Next() {
+		var t EscrowTrustee
+		if err := rows.Scan(&t.TrusteeID, &t.ShareIndex, &t.Threshold, &t.DistributedAt); err != nil {
+			return nil, fmt.Errorf("scan escrow trustee: %w", err)
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// PutHeldEscrowShare stores a share held for another peer, replacing any earlier share of that peer
+func PutHeldEscrowShare(ctx context.Context, db *sql.DB, s EscrowShare) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO key_escrow_shares (owner_id, share_index, threshold, share, received_at)
+		 VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		s.OwnerID, s.ShareIndex, s.Threshold, s.Share)
+	if err != nil {
+		return fmt.Errorf("store escrow share: %w", err)
+	}
+	return nil
+}
+
+// GetHeldEscrowShare returns the share held for a peer, or ErrNotFound
+func GetHeldEscrowShare(ctx context.Context, db *sql.DB, ownerID string) (*EscrowShare, error) {
+	var s EscrowShare
+	err := db.QueryRowContext(ctx,
+		`SELECT owner_id, share_index, threshold, share, received_at FROM key_escrow_shares WHERE owner_id = ?`,
+		ownerID).Scan(&s.OwnerID, &s.ShareIndex, &s.Threshold, &s.Share, &s.ReceivedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get escrow share: %w", err)
+	}
+	return &s, nil
+}
+
+// ListHeldEscrowShares returns the shares held for other peers, without their values
+func ListHeldEscrowShares(ctx context.Context, db *sql.DB) ([]EscrowShare, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT owner_id, share_index, threshold, received_at FROM key_escrow_shares ORDER BY owner_id`)
+	if err != nil {
+		return nil, fmt.Errorf("list escrow shares: %w", err)
+	}
+	defer rows.Close()
+
+	var out []EscrowShare
+	for rows.Next() {
+		var s EscrowShare
+		if err := rows.Scan(&s.OwnerID, &s.ShareIndex, &s.Threshold, &s.ReceivedAt); err != nil {
+			return nil, fmt.Errorf("scan escrow share: %w", err)
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// InsertEscrowRequest stores a recovery request received as a trustee
+func InsertEscrowRequest(ctx context.Context, db *sql.DB, r EscrowRequest) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO key_escrow_requests (id, owner_id, requester_id, status) VALUES (?, ?, ?, ?)`,
+		r.ID, r.OwnerID, r.RequesterID, r.Status)
+	if err != nil {
+		return fmt.Errorf("insert escrow request: %w", err)
+	}
+	return nil
+}
+
+// GetEscrowRequest returns a recovery request by ID, or ErrNotFound
+func GetEscrowRequest(ctx context.Context, db *sql.DB, id string) (*EscrowRequest, error) {
+	var r EscrowRequest
+	var decided sql.NullTime
+	err := db.QueryRowContext(ctx,
+		`SELECT id, owner_id, requester_id, status, created_at, decided_at FROM key_escrow_requests WHERE id = ?`,
+		id).Scan(&r.ID, &r.OwnerID, &r.RequesterID, &r.Status, &r.CreatedAt, &decided)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get escrow request: %w", err)
+	}
+	if decided.Valid {
+		r.DecidedAt = &decided.Time
+	}
+	return &r, nil
+}
+
+// ListEscrowRequests returns recovery requests, newest first, optionally filtered by status
+func ListEscrowRequests(ctx context.Context, db *sql.DB, status string) ([]EscrowRequest, error) {
+	query := `SELECT id, owner_id, requester_id, status, created_at, decided_at FROM key_escrow_requests`
+	var args []interface{}
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY created_at DESC`
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list escrow requests: %w", err)
+	}
+	defer rows.Close()
+
+	var out []EscrowRequest
+	for rows.Next() {
+		var r EscrowRequest
+		var decided sql.NullTime
+		if err := rows.Scan(&r.ID, &r.OwnerID, &r.RequesterID, &r.Status, &r.CreatedAt, &decided); err != nil {
+			return nil, fmt.Errorf("scan escrow request: %w", err)
+		}
+		if decided.Valid {
+			r.DecidedAt = &decided.Time
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// DecideEscrowRequest approves or denies a pending recovery request. It returns ErrNotFound if
+// there is no pending request with that ID.
+func DecideEscrowRequest(ctx context.Context, db *sql.DB, id, status string) error {
+	res, err := db.ExecContext(ctx,
+		`UPDATE key_escrow_requests SET status = ?, decided_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		status, id, EscrowRequestPending)
+	if err != nil {
+		return fmt.Errorf("update escrow request: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// PutCollectedEscrowShare stores a share released by a trustee during a recovery
+func PutCollectedEscrowShare(ctx context.Context, db *sql.DB, s EscrowShare) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO key_escrow_collected (owner_id, share_index, trustee_id, threshold, share, received_at)
+		 VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		s.OwnerID, s.ShareIndex, s.TrusteeID, s.Threshold, s.Share)
+	if err != nil {
+		return fmt.Errorf("store collected escrow share: %w", err)
+	}
+	return nil
+}
+
+// ListCollectedEscrowShares returns the shares collected for recovering a peer's key
+func ListCollectedEscrowShares(ctx context.Context, db *sql.DB, ownerID string) ([]EscrowShare, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT owner_id, trustee_id, share_index, threshold, share, received_at
+		 FROM key_escrow_collected WHERE owner_id = ? ORDER BY share_index`, ownerID)
+	if err != nil {
+		return nil, fmt.Errorf("list collected escrow shares: %w", err)
+	}
+	defer rows.Close()
+
+	var out []EscrowShare
+	for rows.Next() {
+		var s EscrowShare
+		if err := rows.Scan(&s.OwnerID, &s.TrusteeID, &s.ShareIndex, &s.Threshold, &s.Share, &s.ReceivedAt); err != nil {
+			return nil, fmt.Errorf("scan collected escrow share: %w", err)
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// DeleteCollectedEscrowShares removes the collected shares of a peer once its key is recovered
+func DeleteCollectedEscrowShares(ctx context.Context, db *sql.DB, ownerID string) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM key_escrow_collected WHERE owner_id = ?`, ownerID); err != nil {
+		return fmt.Errorf("delete collected escrow shares: %w", err)
+	}
+	return nil
+}
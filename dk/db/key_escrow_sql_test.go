@@ -0,0 +1,93 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func openEscrowTestDB(t *testing.T) *sql.DB {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { testDB.Close() })
+
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	return testDB.DB
+}
+
+// TestKeyEscrowRequests tests storing held shares and deciding recovery requests
+func TestKeyEscrowRequests(t *testing.T) {
+	db := openEscrowTestDB(t)
+	ctx := context.Background()
+	owner := "owner-" + uuid.New().String()[:8]
+
+	share := EscrowShare{OwnerID: owner, ShareIndex: 2, Threshold: 2, Share: []byte{1, 2, 3}}
+	if err := PutHeldEscrowShare(ctx, db, share); err != nil {
+		t.Fatalf("Failed to store share: %v", err)
+	}
+	held, err := GetHeldEscrowShare(ctx, db, owner)
+	if err != nil {
+		t.Fatalf("Failed to get share: %v", err)
+	}
+	if held.ShareIndex != 2 || !bytes.Equal(held.Share, share.Share) {
+		t.Errorf("Unexpected share: %+v", held)
+	}
+	if _, err := GetHeldEscrowShare(ctx, db, "nobody"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	request := EscrowRequest{ID: uuid.New().String(), OwnerID: owner, RequesterID: "new-device", Status: EscrowRequestPending}
+	if err := InsertEscrowRequest(ctx, db, request); err != nil {
+		t.Fatalf("Failed to insert request: %v", err)
+	}
+	if err := DecideEscrowRequest(ctx, db, request.ID, EscrowRequestApproved); err != nil {
+		t.Fatalf("Failed to approve request: %v", err)
+	}
+	// A decided request cannot be decided again
+	if err := DecideEscrowRequest(ctx, db, request.ID, EscrowRequestDenied); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for a decided request, got %v", err)
+	}
+	got, err := GetEscrowRequest(ctx, db, request.ID)
+	if err != nil {
+		t.Fatalf("Failed to get request: %v", err)
+	}
+	if got.Status != EscrowRequestApproved || got.DecidedAt == nil {
+		t.Errorf("Unexpected request: %+v", got)
+	}
+}
+
+// TestCollectedEscrowShares tests collecting and clearing shares during a recovery
+func TestCollectedEscrowShares(t *testing.T) {
+	db := openEscrowTestDB(t)
+	ctx := context.Background()
+	owner := "owner-" + uuid.New().String()[:8]
+
+	for i, trustee := range []string{"bob", "carol"} {
+		if err := PutCollectedEscrowShare(ctx, db, EscrowShare{
+			OwnerID: owner, TrusteeID: trustee, ShareIndex: i + 1, Threshold: 2, Share: []byte{byte(i)},
+		}); err != nil {
+			t.Fatalf("Failed to store collected share: %v", err)
+		}
+	}
+	shares, err := ListCollectedEscrowShares(ctx, db, owner)
+	if err != nil {
+		t.Fatalf("Failed to list collected shares: %v", err)
+	}
+	if len(shares) != 2 || shares[0].TrusteeID != "bob" {
+		t.Errorf("Unexpected collected shares: %+v", shares)
+	}
+
+	if err := DeleteCollectedEscrowShares(ctx, db, owner); err != nil {
+		t.Fatalf("Failed to delete collected shares: %v", err)
+	}
+	if shares, _ := ListCollectedEscrowShares(ctx, db, owner); len(shares) != 0 {
+		t.Errorf("Expected no shares after deletion, got %d", len(shares))
+	}
+}
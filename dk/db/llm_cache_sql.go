@@ -0,0 +1,101 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// LLMCacheEntry is a cached LLM completion
+type LLMCacheEntry struct {
+	PromptHash string    `json:"prompt_hash"`
+	Provider   string    `json:"provider"`
+	Response   string    `json:"response"`
+	Hits       int64     `json:"hits"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// LLMCacheStats summarizes the contents of the LLM cache
+type LLMCacheStats struct {
+	Entries   int64 `json:"entries"`    // Unexpired entries
+	Expired   int64 `json:"expired"`    // Expired entries not yet removed
+	TotalHits int64 `json:"total_hits"` // Hits served by the unexpired entries
+}
+
+// GetLLMCacheEntry returns the unexpired cache entry for a prompt hash and counts the hit.
+// It returns ErrNotFound if there is none.
+func GetLLMCacheEntry(ctx context.Context, db *sql.DB, promptHash string, now time.Time) (*LLMCacheEntry, error) {
+	var e LLMCacheEntry
+	var created, expires int64
+	err := db.QueryRowContext(ctx,
+		`SELECT prompt_hash, provider, response, hits, created_at, expires_at
+		 FROM llm_cache WHERE prompt_hash = ? AND expires_at > ?`,
+		promptHash, now.Unix()).Scan(&e.PromptHash, &e.Provider, &e.Response, &e.Hits, &created, &expires)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get llm cache entry: %w", err)
+	}
+	e.CreatedAt = time.Unix(created, 0)
+	e.ExpiresAt = time.Unix(expires, 0)
+
+	if _, err := db.ExecContext(ctx,
+		`UPDATE llm_cache SET hits = hits + 1 WHERE prompt_hash = ?`, promptHash); err != nil {
+		return nil, fmt.Errorf("count llm cache hit: %w", err)
+	}
+	e.Hits++
+	return &e, nil
+}
+
+// PutLLMCacheEntry stores a completion, replacing any previous entry for the same prompt hash.
+// Expired entries are removed at the same time so the table does not grow without bound.
+func PutLLMCacheEntry(ctx context.Context, db *sql.DB, e LLMCacheEntry) error {
+	if _, err := DeleteExpiredLLMCacheEntries(ctx, db, e.CreatedAt); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO llm_cache (prompt_hash, provider, response, hits, created_at, expires_at)
+		 VALUES (?, ?, ?, 0, ?, ?)`,
+		e.PromptHash, e.Provider, e.Response, e.CreatedAt.Unix(), e.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("put llm cache entry: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredLLMCacheEntries removes the entries that expired before now
+func DeleteExpiredLLMCacheEntries(ctx context.Context, db *sql.DB, now time.Time) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM llm_cache WHERE expires_at <= ?`, now.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("delete expired llm cache entries: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// ClearLLMCache removes every cache entry and returns how many were removed
+func ClearLLMCache(ctx context.Context, db *sql.DB) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM llm_cache`)
+	if err != nil {
+		return 0, fmt.Errorf("clear llm cache: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// GetLLMCacheStats counts the entries of the cache and the hits they served
+func GetLLMCacheStats(ctx context.Context, db *sql.DB, now time.Time) (LLMCacheStats, error) {
+	var s LLMCacheStats
+	err := db.QueryRowContext(ctx,
+		`SELECT
+			COALESCE(SUM(CASE WHEN expires_at > ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN expires_at <= ? THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN expires_at > ? THEN hits ELSE 0 END), 0)
+		 FROM llm_cache`,
+		now.Unix(), now.Unix(), now.Unix()).Scan(&s.Entries, &s.Expired, &s.TotalHits)
+	if err != nil {
+		return s, fmt.Errorf("get llm cache stats: %w", err)
+	}
+	return s, nil
+}
@@ -0,0 +1,58 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLLMCache(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	entries := []LLMCacheEntry{
+		{PromptHash: "fresh", Provider: "openai/gpt-4o", Response: "cached answer", CreatedAt: now, ExpiresAt: now.Add(time.Hour)},
+		{PromptHash: "stale", Provider: "openai/gpt-4o", Response: "old answer", CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)},
+	}
+	for _, e := range entries {
+		if err := PutLLMCacheEntry(ctx, testDB.DB, e); err != nil {
+			t.Fatalf("Failed to store cache entry: %v", err)
+		}
+	}
+
+	entry, err := GetLLMCacheEntry(ctx, testDB.DB, "fresh", now)
+	if err != nil {
+		t.Fatalf("Failed to get cache entry: %v", err)
+	}
+	if entry.Response != "cached answer" || entry.Hits != 1 {
+		t.Errorf("Unexpected cache entry %+v", entry)
+	}
+	if _, err := GetLLMCacheEntry(ctx, testDB.DB, "stale", now); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound for an expired entry, got %v", err)
+	}
+
+	stats, err := GetLLMCacheStats(ctx, testDB.DB, now)
+	if err != nil {
+		t.Fatalf("Failed to get cache stats: %v", err)
+	}
+	if stats.Entries != 1 || stats.Expired != 1 || stats.TotalHits != 1 {
+		t.Errorf("Unexpected cache stats %+v", stats)
+	}
+
+	removed, err := DeleteExpiredLLMCacheEntries(ctx, testDB.DB, now)
+	if err != nil || removed != 1 {
+		t.Errorf("Expected 1 expired entry to be removed, got %d (%v)", removed, err)
+	}
+	if removed, err := ClearLLMCache(ctx, testDB.DB); err != nil || removed != 1 {
+		t.Errorf("Expected 1 entry to be cleared, got %d (%v)", removed, err)
+	}
+}
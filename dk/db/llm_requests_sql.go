@@ -0,0 +1,50 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// LLMRequest records which LLM provider served a request.
+type LLMRequest struct {
+	ID         int64  `json:"id"`
+	QueryID    string `json:"query_id,omitempty"`
+	Operation  string `json:"operation"`             // e.g. "answer", "forward"
+	Provider   string `json:"provider"`              // e.g. "openai/gpt-4o"
+	FailedOver string `json:"failed_over,omitempty"` // comma-separated providers tried first
+	CreatedAt  string `json:"created_at,omitempty"`
+}
+
+// InsertLLMRequest stores a new provider record.
+func InsertLLMRequest(ctx context.Context, db *sql.DB, r LLMRequest) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO llm_requests (query_id, operation, provider, failed_over)
+		 VALUES (?, ?, ?, ?)`,
+		r.QueryID, r.Operation, r.Provider, r.FailedOver)
+	if err != nil {
+		return fmt.Errorf("insert llm request: %w", err)
+	}
+	return nil
+}
+
+// ListLLMRequests returns the provider records of a query, newest first.
+func ListLLMRequests(ctx context.Context, db *sql.DB, queryID string) ([]LLMRequest, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, COALESCE(query_id, ''), operation, provider, COALESCE(failed_over, ''), created_at
+		 FROM llm_requests WHERE query_id = ? ORDER BY id DESC`, queryID)
+	if err != nil {
+		return nil, fmt.Errorf("list llm requests: %w", err)
+	}
+	defer rows.Close()
+
+	var out []LLMRequest
+	for rows.Next() {
+		var r LLMRequest
+		if err := rows.Scan(&r.ID, &r.QueryID, &r.Operation, &r.Provider, &r.FailedOver, &r.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan llm request row: %w", err)
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLLMRequests(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	entries := []LLMRequest{
+		{QueryID: "qry-1", Operation: "answer", Provider: "openai/gpt-4o"},
+		{QueryID: "qry-1", Operation: "answer", Provider: "ollama/llama3", FailedOver: "openai/gpt-4o"},
+		{QueryID: "qry-2", Operation: "answer", Provider: "openai/gpt-4o"},
+	}
+	for _, e := range entries {
+		if err := InsertLLMRequest(ctx, testDB.DB, e); err != nil {
+			t.Fatalf("Failed to insert LLM request: %v", err)
+		}
+	}
+
+	records, err := ListLLMRequests(ctx, testDB.DB, "qry-1")
+	if err != nil {
+		t.Fatalf("Failed to list LLM requests: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	if records[0].Provider != "ollama/llama3" || records[0].FailedOver != "openai/gpt-4o" {
+		t.Errorf("Expected newest record to be the failover, got %+v", records[0])
+	}
+}
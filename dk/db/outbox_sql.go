@@ -0,0 +1,64 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// OutboxMessage is an outgoing websocket message waiting to be sent
+type OutboxMessage struct {
+	ID        int64     `json:"id"`
+	Recipient string    `json:"recipient"`
+	Message   string    `json:"message"` // JSON of the message, before encryption
+	QueuedAt  time.Time `json:"queued_at"`
+}
+
+// InsertOutboxMessage adds a message to the end of the outbox
+func InsertOutboxMessage(ctx context.Context, db *sql.DB, m OutboxMessage) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO outbox (recipient, message, queued_at) VALUES (?, ?, ?)`,
+		m.Recipient, m.Message, m.QueuedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("insert outbox message: %w", err)
+	}
+	return nil
+}
+
+// ListOutboxMessages returns up to limit messages of the outbox, oldest first
+func ListOutboxMessages(ctx context.Context, db *sql.DB, limit int) ([]OutboxMessage, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, recipient, message, queued_at FROM outbox ORDER BY id LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list outbox messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []OutboxMessage{}
+	for rows.Next() {
+		var m OutboxMessage
+		if err := rows.Scan(&m.ID, &m.Recipient, &m.Message, &m.QueuedAt); err != nil {
+			return nil, fmt.Errorf("scan outbox message: %w", err)
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// DeleteOutboxMessage removes a message from the outbox
+func DeleteOutboxMessage(ctx context.Context, db *sql.DB, id int64) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM outbox WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("delete outbox message: %w", err)
+	}
+	return nil
+}
+
+// CountOutboxMessages returns the number of messages in the outbox
+func CountOutboxMessages(ctx context.Context, db *sql.DB) (int, error) {
+	var count int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM outbox`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count outbox messages: %w", err)
+	}
+	return count, nil
+}
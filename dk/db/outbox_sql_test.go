@@ -0,0 +1,57 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	queuedAt := time.Date(2025, 5, 2, 10, 0, 0, 500, time.UTC)
+	for _, recipient := range []string{"bob", "carol", "dave"} {
+		m := OutboxMessage{Recipient: recipient, Message: `{"to":"` + recipient + `"}`, QueuedAt: queuedAt}
+		if err := InsertOutboxMessage(ctx, testDB.DB, m); err != nil {
+			t.Fatalf("Failed to insert outbox message: %v", err)
+		}
+	}
+
+	pending, err := ListOutboxMessages(ctx, testDB.DB, 2)
+	if err != nil {
+		t.Fatalf("Failed to list outbox messages: %v", err)
+	}
+	if len(pending) != 2 || pending[0].Recipient != "bob" || pending[1].Recipient != "carol" {
+		t.Fatalf("Expected bob then carol, got %+v", pending)
+	}
+	if !pending[0].QueuedAt.Equal(queuedAt) {
+		t.Errorf("Expected queued at %v, got %v", queuedAt, pending[0].QueuedAt)
+	}
+
+	if err := DeleteOutboxMessage(ctx, testDB.DB, pending[0].ID); err != nil {
+		t.Fatalf("Failed to delete outbox message: %v", err)
+	}
+	count, err := CountOutboxMessages(ctx, testDB.DB)
+	if err != nil {
+		t.Fatalf("Failed to count outbox messages: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 messages left, got %d", count)
+	}
+	pending, err = ListOutboxMessages(ctx, testDB.DB, 10)
+	if err != nil {
+		t.Fatalf("Failed to list outbox messages: %v", err)
+	}
+	if len(pending) != 2 || pending[0].Recipient != "carol" || pending[0].Message != `{"to":"carol"}` {
+		t.Errorf("Expected carol first, got %+v", pending)
+	}
+}
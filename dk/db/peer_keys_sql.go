@@ -0,0 +1,45 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PeerKey is the public key trusted for a peer
+type PeerKey struct {
+	Peer      string
+	PublicKey []byte
+	Pinned    bool
+	FirstSeen time.Time
+	FetchedAt time.Time
+}
+
+// SavePeerKey stores the public key trusted for a peer, replacing the previous one
+func SavePeerKey(ctx context.Context, db *sql.DB, key PeerKey) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO peer_keys (peer, public_key, pinned, first_seen, fetched_at) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(peer) DO UPDATE SET public_key = excluded.public_key, pinned = excluded.pinned,
+		 first_seen = excluded.first_seen, fetched_at = excluded.fetched_at`,
+		key.Peer, key.PublicKey, key.Pinned, key.FirstSeen.UTC(), key.FetchedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("save peer key: %w", err)
+	}
+	return nil
+}
+
+// GetPeerKey returns the public key trusted for a peer, and whether there is one
+func GetPeerKey(ctx context.Context, db *sql.DB, peer string) (PeerKey, bool, error) {
+	key := PeerKey{Peer: peer}
+	err := db.QueryRowContext(ctx, `SELECT public_key, pinned, first_seen, fetched_at FROM peer_keys WHERE peer = ?`, peer).
+		Scan(&key.PublicKey, &key.Pinned, &key.FirstSeen, &key.FetchedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, false, nil
+	}
+	if err != nil {
+		return key, false, fmt.Errorf("get peer key: %w", err)
+	}
+	return key, true, nil
+}
@@ -0,0 +1,42 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPeerKeys(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	if _, found, err := GetPeerKey(ctx, testDB.DB, "alice"); err != nil || found {
+		t.Fatalf("Expected no key, got %v (%v)", found, err)
+	}
+	firstSeen := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	key := PeerKey{Peer: "alice", PublicKey: []byte("first"), FirstSeen: firstSeen, FetchedAt: firstSeen}
+	if err := SavePeerKey(ctx, testDB.DB, key); err != nil {
+		t.Fatalf("Failed to save peer key: %v", err)
+	}
+	key.PublicKey, key.Pinned, key.FetchedAt = []byte("pinned"), true, firstSeen.Add(time.Hour)
+	if err := SavePeerKey(ctx, testDB.DB, key); err != nil {
+		t.Fatalf("Failed to save peer key: %v", err)
+	}
+
+	stored, found, err := GetPeerKey(ctx, testDB.DB, "alice")
+	if err != nil || !found {
+		t.Fatalf("Expected the key of alice, got %v (%v)", found, err)
+	}
+	if !bytes.Equal(stored.PublicKey, []byte("pinned")) || !stored.Pinned || !stored.FirstSeen.Equal(firstSeen) || !stored.FetchedAt.Equal(firstSeen.Add(time.Hour)) {
+		t.Errorf("Expected the replaced key, got %+v", stored)
+	}
+}
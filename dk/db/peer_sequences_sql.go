@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SavePeerSequence stores the latest sequence number received from a peer
+func SavePeerSequence(ctx context.Context, db *sql.DB, peer string, sequence uint64) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO peer_sequences (peer, sequence, updated_at) VALUES (?, ?, ?)
+		 ON CONFLICT(peer) DO UPDATE SET sequence = MAX(sequence, excluded.sequence), updated_at = excluded.updated_at`,
+		peer, int64(sequence), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("save peer sequence: %w", err)
+	}
+	return nil
+}
+
+// GetPeerSequence returns the latest sequence number received from a peer, and whether there
+// is one
+func GetPeerSequence(ctx context.Context, db *sql.DB, peer string) (uint64, bool, error) {
+	var sequence int64
+	err := db.QueryRowContext(ctx, `SELECT sequence FROM peer_sequences WHERE peer = ?`, peer).Scan(&sequence)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("get peer sequence: %w", err)
+	}
+	return uint64(sequence), true, nil
+}
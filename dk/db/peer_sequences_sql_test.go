@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPeerSequences(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	if sequence, found, err := GetPeerSequence(ctx, testDB.DB, "alice"); err != nil || found {
+		t.Fatalf("Expected no sequence, got %d (%v)", sequence, err)
+	}
+	for _, sequence := range []uint64{1_700_000_000_000_000, 1_700_000_000_000_005, 1_700_000_000_000_002} {
+		if err := SavePeerSequence(ctx, testDB.DB, "alice", sequence); err != nil {
+			t.Fatalf("Failed to save peer sequence: %v", err)
+		}
+	}
+
+	// A lower sequence number never replaces a higher one
+	sequence, found, err := GetPeerSequence(ctx, testDB.DB, "alice")
+	if err != nil || !found || sequence != 1_700_000_000_000_005 {
+		t.Errorf("Expected the highest sequence, got %d (%v, %v)", sequence, found, err)
+	}
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Policy types, rule actions and periods accepted for new policies
+var (
+	validPolicyTypes = map[string]bool{
+		"free":      true,
+		"rate":      true,
+		"token":     true,
+		"time":      true,
+		"credit":    true,
+		"composite": true,
+	}
+	validRuleActions = map[string]bool{
+		"block":    true,
+		"throttle": true,
+		"notify":   true,
+		"log":      true,
+	}
+	validRulePeriods = map[string]bool{
+		"minute": true,
+		"hour":   true,
+		"day":    true,
+		"week":   true,
+		"month":  true,
+		"year":   true,
+	}
+)
+
+// ValidatePolicy checks a new policy and its rules. Every error it returns describes a
+// problem with the input and is meant to be shown to the caller as is.
+func ValidatePolicy(name, policyType string, rules []*PolicyRule) error {
+	if name == "" {
+		return errors.New("Policy name is required")
+	}
+
+	if policyType == "" {
+		return errors.New("Policy type is required")
+	}
+
+	if !validPolicyTypes[policyType] {
+		return errors.New("Invalid policy type. Must be one of: free, rate, token, time, credit, composite")
+	}
+
+	// Validate rules based on policy type
+	if policyType != "free" && len(rules) == 0 {
+		return errors.New("Rules are required for non-free policies")
+	}
+
+	// For non-composite policies, ensure rule types match policy type
+	if policyType != "free" && policyType != "composite" {
+		for _, rule := range rules {
+			if rule.RuleType != policyType {
+				return fmt.Errorf("Rule type '%s' doesn't match policy type '%s'", rule.RuleType, policyType)
+			}
+		}
+	}
+
+	// Validate each rule
+	for i, rule := range rules {
+		if rule.RuleType == "" {
+			return fmt.Errorf("Rule %d is missing rule_type", i+1)
+		}
+
+		if rule.Action == "" {
+			return fmt.Errorf("Rule %d is missing action", i+1)
+		}
+
+		if !validRuleActions[rule.Action] {
+			return fmt.Errorf("Invalid action '%s' in rule %d. Must be one of: block, throttle, notify, log", rule.Action, i+1)
+		}
+
+		// For non-free rules, limit value is required
+		if rule.RuleType != "free" && rule.LimitValue <= 0 {
+			return fmt.Errorf("Rule %d must have a positive limit_value", i+1)
+		}
+
+		// For time-based rules, period is required
+		needsPeriod := rule.RuleType == "rate" || rule.RuleType == "token" || rule.RuleType == "time" || rule.RuleType == "credit"
+		if needsPeriod && rule.Period == "" {
+			return fmt.Errorf("Rule %d requires a period", i+1)
+		}
+
+		// Validate period if provided
+		if rule.Period != "" && !validRulePeriods[rule.Period] {
+			return fmt.Errorf("Invalid period '%s' in rule %d. Must be one of: minute, hour, day, week, month, year", rule.Period, i+1)
+		}
+	}
+
+	return nil
+}
+
+// ValidatePolicyChange checks a request to change the policy of an API: the change takes
+// effect immediately or at a scheduled date in the future. Like ValidatePolicy, it only
+// returns errors describing the input.
+func ValidatePolicyChange(policyID string, effectiveImmediately bool, scheduledDate *time.Time) error {
+	if policyID == "" {
+		return errors.New("Policy ID is required")
+	}
+
+	if !effectiveImmediately && scheduledDate == nil {
+		return errors.New("Either effective_immediately must be true or scheduled_date must be provided")
+	}
+
+	// If scheduled date is provided, ensure it's in the future
+	if scheduledDate != nil && scheduledDate.Before(time.Now()) {
+		return errors.New("Scheduled date must be in the future")
+	}
+
+	return nil
+}
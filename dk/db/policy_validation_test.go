@@ -0,0 +1,61 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestValidatePolicy(t *testing.T) {
+	rateRule := func() *PolicyRule {
+		return &PolicyRule{RuleType: "rate", LimitValue: 10, Period: "minute", Action: "block"}
+	}
+	tests := []struct {
+		name       string
+		policyName string
+		policyType string
+		rules      []*PolicyRule
+		wantErr    string
+	}{
+		{"free without rules", "Free", "free", nil, ""},
+		{"valid rate", "Rate", "rate", []*PolicyRule{rateRule()}, ""},
+		{"missing name", "", "free", nil, "Policy name is required"},
+		{"unknown type", "X", "unlimited", nil, "Invalid policy type. Must be one of: free, rate, token, time, credit, composite"},
+		{"no rules", "Rate", "rate", nil, "Rules are required for non-free policies"},
+		{"mismatched rule", "Token", "token", []*PolicyRule{rateRule()}, "Rule type 'rate' doesn't match policy type 'token'"},
+		{"bad action", "Rate", "rate", []*PolicyRule{{RuleType: "rate", LimitValue: 1, Period: "day", Action: "ignore"}}, "Invalid action 'ignore' in rule 1. Must be one of: block, throttle, notify, log"},
+		{"no limit", "Rate", "rate", []*PolicyRule{{RuleType: "rate", Period: "day", Action: "log"}}, "Rule 1 must have a positive limit_value"},
+		{"no period", "Composite", "composite", []*PolicyRule{{RuleType: "token", LimitValue: 5, Action: "log"}}, "Rule 1 requires a period"},
+		{"bad period", "Rate", "rate", []*PolicyRule{{RuleType: "rate", LimitValue: 5, Period: "decade", Action: "log"}}, "Invalid period 'decade' in rule 1. Must be one of: minute, hour, day, week, month, year"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePolicy(tt.policyName, tt.policyType, tt.rules)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("expected the policy to be valid, got %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("expected %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidatePolicyChange(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	if err := ValidatePolicyChange("p1", true, nil); err != nil {
+		t.Errorf("immediate change rejected: %v", err)
+	}
+	if err := ValidatePolicyChange("p1", false, &future); err != nil {
+		t.Errorf("scheduled change rejected: %v", err)
+	}
+	if err := ValidatePolicyChange("", true, nil); err == nil {
+		t.Error("expected a missing policy ID to be rejected")
+	}
+	if err := ValidatePolicyChange("p1", false, nil); err == nil {
+		t.Error("expected a change without a date to be rejected")
+	}
+	if err := ValidatePolicyChange("p1", false, &past); err == nil {
+		t.Error("expected a past date to be rejected")
+	}
+}
@@ -17,6 +17,8 @@ type Query struct {
 	DocumentsRelated []string `json:"documents_related"`
 	Status           string   `json:"status"`
 	Reason           string   `json:"reason,omitempty"`
+	// Screening is the triage summary made when the query arrived; nil for older queries
+	Screening *QueryScreening `json:"screening,omitempty"`
 }
 
 // --- Helpers ---------------------------------------------------------------
@@ -101,3 +103,17 @@ func GetQuery(ctx context.Context, db *sql.DB, id string) (Query, error) {
 	_ = json.Unmarshal([]byte(docs), &q.DocumentsRelated)
 	return q, nil
 }
+
+// UpdateQueryAnswer replaces the drafted answer of a query; it returns sql.ErrNoRows if there
+// is no such query.
+func UpdateQueryAnswer(ctx context.Context, db *sql.DB, id, answer string) error {
+	res, err := db.ExecContext(ctx,
+		`UPDATE queries SET answer=? WHERE id=?`, answer, id)
+	if err != nil {
+		return fmt.Errorf("update answer: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
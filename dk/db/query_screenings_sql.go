@@ -0,0 +1,91 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Estimated effort of answering a query
+const (
+	EffortLow    = "low"    // The drafted answer is backed by documents and likely ready to send
+	EffortMedium = "medium" // The draft needs review or editing
+	EffortHigh   = "high"   // Nothing in the knowledge base answers it; it needs a manual answer
+)
+
+// QueryScreening is the triage summary of an incoming query
+type QueryScreening struct {
+	Summary          string   `json:"summary"`
+	Tags             []string `json:"tags"`
+	Effort           string   `json:"effort"`
+	MatchedDocuments int      `json:"matched_documents"`
+}
+
+// SaveQueryScreening stores the screening of a query, replacing any earlier one
+func SaveQueryScreening(ctx context.Context, db *sql.DB, queryID string, s QueryScreening) error {
+	tags, err := json.Marshal(s.Tags)
+	if err != nil {
+		return fmt.Errorf("encode tags: %w", err)
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO query_screenings (query_id, summary, tags, effort, matched_documents)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(query_id) DO UPDATE SET
+			summary = excluded.summary, tags = excluded.tags, effort = excluded.effort,
+			matched_documents = excluded.matched_documents, created_at = CURRENT_TIMESTAMP`,
+		queryID, s.Summary, string(tags), s.Effort, s.MatchedDocuments)
+	if err != nil {
+		return fmt.Errorf("save query screening: %w", err)
+	}
+	return nil
+}
+
+// GetQueryScreening returns the screening of a query, or ErrNotFound
+func GetQueryScreening(ctx context.Context, db *sql.DB, queryID string) (*QueryScreening, error) {
+	var s QueryScreening
+	var tags string
+	err := db.QueryRowContext(ctx,
+		`SELECT summary, tags, effort, matched_documents FROM query_screenings WHERE query_id = ?`,
+		queryID).Scan(&s.Summary, &tags, &s.Effort, &s.MatchedDocuments)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get query screening: %w", err)
+	}
+	_ = json.Unmarshal([]byte(tags), &s.Tags)
+	return &s, nil
+}
+
+// attachQueryScreenings sets the screening of the queries that have one
+func attachQueryScreenings(ctx context.Context, db *sql.DB, queries []Query) error {
+	if len(queries) == 0 {
+		return nil
+	}
+	placeholders := make([]string, len(queries))
+	args := make([]any, len(queries))
+	index := make(map[string]int, len(queries))
+	for i, q := range queries {
+		placeholders[i] = "?"
+		args[i] = q.ID
+		index[q.ID] = i
+	}
+	rows, err := db.QueryContext(ctx, `SELECT query_id, summary, tags, effort, matched_documents
+		FROM query_screenings WHERE query_id IN (`+strings.Join(placeholders, ",")+`)`, args...)
+	if err != nil {
+		return fmt.Errorf("list query screenings: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, tags string
+		s := &QueryScreening{}
+		if err := rows.Scan(&id, &s.Summary, &tags, &s.Effort, &s.MatchedDocuments); err != nil {
+			return fmt.Errorf("scan query screening: %w", err)
+		}
+		_ = json.Unmarshal([]byte(tags), &s.Tags)
+		queries[index[id]].Screening = s
+	}
+	return rows.Err()
+}
@@ -0,0 +1,34 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SaveRatchetState stores the ratcheting sessions with a peer, replacing the stored ones
+func SaveRatchetState(ctx context.Context, db *sql.DB, peer string, state []byte) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO ratchet_sessions (peer, state, updated_at) VALUES (?, ?, ?)
+		 ON CONFLICT(peer) DO UPDATE SET state = excluded.state, updated_at = excluded.updated_at`,
+		peer, state, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("save ratchet state: %w", err)
+	}
+	return nil
+}
+
+// GetRatchetState returns the ratcheting sessions with a peer, or nil when there are none
+func GetRatchetState(ctx context.Context, db *sql.DB, peer string) ([]byte, error) {
+	var state []byte
+	err := db.QueryRowContext(ctx, `SELECT state FROM ratchet_sessions WHERE peer = ?`, peer).Scan(&state)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get ratchet state: %w", err)
+	}
+	return state, nil
+}
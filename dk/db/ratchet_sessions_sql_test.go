@@ -0,0 +1,39 @@
+package db
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRatchetState(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	if state, err := GetRatchetState(ctx, testDB.DB, "alice"); err != nil || state != nil {
+		t.Fatalf("Expected no state, got %q (%v)", state, err)
+	}
+	for _, state := range []string{`{"capable":true}`, `{"capable":true,"active":"s1"}`} {
+		if err := SaveRatchetState(ctx, testDB.DB, "alice", []byte(state)); err != nil {
+			t.Fatalf("Failed to save ratchet state: %v", err)
+		}
+	}
+	if err := SaveRatchetState(ctx, testDB.DB, "bob", []byte(`{}`)); err != nil {
+		t.Fatalf("Failed to save ratchet state: %v", err)
+	}
+
+	state, err := GetRatchetState(ctx, testDB.DB, "alice")
+	if err != nil {
+		t.Fatalf("Failed to get ratchet state: %v", err)
+	}
+	if string(state) != `{"capable":true,"active":"s1"}` {
+		t.Errorf("Expected the latest state, got %q", state)
+	}
+}
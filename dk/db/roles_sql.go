@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RoleAssignment is a role the host delegated to a user
+type RoleAssignment struct {
+	UserID     string    `json:"user_id"`
+	Role       string    `json:"role"`
+	AssignedAt time.Time `json:"assigned_at"`
+}
+
+// AssignRole gives a user a role with a new access token, replacing the user's previous role
+// and token
+func AssignRole(ctx context.Context, db *sql.DB, userID, role, tokenHash string) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO role_assignments (user_id, role, token_hash) VALUES (?, ?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET role = excluded.role, token_hash = excluded.token_hash,
+		 assigned_at = CURRENT_TIMESTAMP`,
+		userID, role, tokenHash)
+	if err != nil {
+		return fmt.Errorf("assign role: %w", err)
+	}
+	return nil
+}
+
+// RevokeRole removes the role of a user; it returns sql.ErrNoRows if the user has none
+func RevokeRole(ctx context.Context, db *sql.DB, userID string) error {
+	res, err := db.ExecContext(ctx, `DELETE FROM role_assignments WHERE user_id = ?`, userID)
+	if err != nil {
+		return fmt.Errorf("revoke role: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListRoleAssignments returns all delegated roles
+func ListRoleAssignments(ctx context.Context, db *sql.DB) ([]RoleAssignment, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT user_id, role, assigned_at FROM role_assignments ORDER BY user_id`)
+	if err != nil {
+		return nil, fmt.Errorf("list role assignments: %w", err)
+	}
+	defer rows.Close()
+
+	out := []RoleAssignment{}
+	for rows.Next() {
+		var a RoleAssignment
+		if err := rows.Scan(&a.UserID, &a.Role, &a.AssignedAt); err != nil {
+			return nil, fmt.Errorf("scan role assignment: %w", err)
+		}
+		out = append(out, a)
+	}
+	return out, rows.Err()
+}
+
+// GetRoleAssignmentByToken returns the role an access token was issued for; it returns
+// sql.ErrNoRows for unknown or revoked tokens
+func GetRoleAssignmentByToken(ctx context.Context, db *sql.DB, tokenHash string) (*RoleAssignment, error) {
+	var a RoleAssignment
+	err := db.QueryRowContext(ctx,
+		`SELECT user_id, role, assigned_at FROM role_assignments WHERE token_hash = ?`, tokenHash).
+		Scan(&a.UserID, &a.Role, &a.AssignedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// ScheduledQuery is a question sent to peers later, once or on a recurring schedule
+type ScheduledQuery struct {
+	ID        string     `json:"id"`
+	Question  string     `json:"question"`
+	Peers     []string   `json:"peers,omitempty"`    // Empty to broadcast
+	Schedule  string     `json:"schedule,omitempty"` // Cron expression; empty for a one-time query
+	NextRun   *time.Time `json:"next_run,omitempty"` // Nil once a schedule has no more runs
+	LastRun   *time.Time `json:"last_run,omitempty"`
+	Active    bool       `json:"active"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// ScheduledQueryRun is one sending of a scheduled query, with the answers peers gave to it
+type ScheduledQueryRun struct {
+	ID      int64                  `json:"id"`
+	SentAt  time.Time              `json:"sent_at"`
+	Error   string                 `json:"error,omitempty"`
+	Answers []ScheduledQueryAnswer `json:"answers"`
+}
+
+// ScheduledQueryAnswer is an answer a peer gave to a run of a scheduled query
+type ScheduledQueryAnswer struct {
+	User       string    `json:"user"`
+	Answer     string    `json:"answer"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// InsertScheduledQuery stores a new scheduled query
+func InsertScheduledQuery(ctx context.Context, db *sql.DB, q ScheduledQuery) error {
+	peers, err := json.Marshal(q.Peers)
+	if err != nil {
+		return fmt.Errorf("encode peers: %w", err)
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO scheduled_queries (id, question, peers, schedule, next_run, active)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		q.ID, q.Question, string(peers), q.Schedule, q.NextRun, q.Active)
+	if err != nil {
+		return fmt.Errorf("insert scheduled query: %w", err)
+	}
+	return nil
+}
+
+const scheduledQueryColumns = `id, question, peers, schedule, next_run, last_run, active, created_at`
+
+func scanScheduledQuery(scanner interface{ Scan(...any) error }) (ScheduledQuery, error) {
+	var q ScheduledQuery
+	var peers string
+	var nextRun, lastRun sql.NullTime
+	if err := scanner.Scan(&q.ID, &q.Question, &peers, &q.Schedule, &nextRun, &lastRun, &q.Active, &q.CreatedAt); err != nil {
+		return q, err
+	}
+	_ = json.Unmarshal([]byte(peers), &q.Peers)
+	if nextRun.Valid {
+		q.NextRun = &nextRun.Time
+	}
+	if lastRun.Valid {
+		q.LastRun = &lastRun.Time
+	}
+	return q, nil
+}
+
+// GetScheduledQuery returns a scheduled query, or ErrNotFound
+func GetScheduledQuery(ctx context.Context, db *sql.DB, id string) (ScheduledQuery, error) {
+	q, err := scanScheduledQuery(db.QueryRowContext(ctx,
+		`SELECT `+scheduledQueryColumns+` FROM scheduled_queries WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return q, ErrNotFound
+	}
+	if err != nil {
+		return q, fmt.Errorf("get scheduled query: %w", err)
+	}
+	return q, nil
+}
+
+// ListScheduledQueries returns the scheduled queries by their next run, those without one last
+func ListScheduledQueries(ctx context.Context, db *sql.DB, activeOnly bool) ([]ScheduledQuery, error) {
+	query := `SELECT ` + scheduledQueryColumns + ` FROM scheduled_queries`
+	if activeOnly {
+		query += ` WHERE active = TRUE`
+	}
+	return listScheduledQueries(ctx, db, query+` ORDER BY next_run IS NULL, next_run, created_at`)
+}
+
+// DueScheduledQueries returns the active scheduled queries whose next run is at or before now
+func DueScheduledQueries(ctx context.Context, db *sql.DB, now time.Time) ([]ScheduledQuery, error) {
+	return listScheduledQueries(ctx, db, `SELECT `+scheduledQueryColumns+` FROM scheduled_queries
+		WHERE active = TRUE AND next_run IS NOT NULL AND next_run <= ? ORDER BY next_run`, now)
+}
+
+func listScheduledQueries(ctx context.Context, db *sql.DB, query string, args ...any) ([]ScheduledQuery, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list scheduled queries: %w", err)
+	}
+	defer rows.Close()
+
+	out := []ScheduledQuery{}
+	for rows.Next() {
+		q, err := scanScheduledQuery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan scheduled query: %w", err)
+		}
+		out = append(out, q)
+	}
+	return out, rows.Err()
+}
+
+// CancelScheduledQuery stops a scheduled query from running again; its runs are kept
+func CancelScheduledQuery(ctx context.Context, db *sql.DB, id string) error {
+	res, err := db.ExecContext(ctx,
+		`UPDATE scheduled_queries SET active = FALSE, next_run = NULL WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("cancel scheduled query: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordScheduledQueryRun records that a scheduled query was sent, or failed to be sent, and
+// moves it to its next run. A nil next run ends the schedule.
+func RecordScheduledQueryRun(ctx context.Context, db *sql.DB, id string, sentAt time.Time, sendErr error, nextRun *time.Time) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	errText := ""
+	if sendErr != nil {
+		errText = sendErr.Error()
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO scheduled_query_runs (scheduled_query_id, sent_at, error) VALUES (?, ?, ?)`,
+		id, sentAt, errText); err != nil {
+		return fmt.Errorf("insert scheduled query run: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE scheduled_queries SET last_run = ?, next_run = ?, active = ? WHERE id = ?`,
+		sentAt, nextRun, nextRun != nil, id); err != nil {
+		return fmt.Errorf("update scheduled query: %w", err)
+	}
+	return tx.Commit()
+}
+
+// RecordScheduledQueryAnswer attaches an answer to the latest successful run of the scheduled
+// queries that asked the question. It reports whether such a run exists.
+func RecordScheduledQueryAnswer(ctx context.Context, db *sql.DB, question, user, answer string) (bool, error) {
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO scheduled_query_answers (run_id, user, answer)
+		SELECT r.id, ?, ? FROM scheduled_query_runs r
+		JOIN scheduled_queries q ON q.id = r.scheduled_query_id
+		WHERE q.question = ? AND r.error = ''
+		ORDER BY r.sent_at DESC, r.id DESC LIMIT 1
+		ON CONFLICT(run_id, user) DO UPDATE SET answer = excluded.answer, received_at = CURRENT_TIMESTAMP`,
+		user, answer, question)
+	if err != nil {
+		return false, fmt.Errorf("record scheduled query answer: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ListScheduledQueryRuns returns the latest runs of a scheduled query, newest first, with their
+// answers
+func ListScheduledQueryRuns(ctx context.Context, db *sql.DB, id string, limit int) ([]ScheduledQueryRun, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, sent_at, error FROM scheduled_query_runs
+		WHERE scheduled_query_id = ? ORDER BY sent_at DESC, id DESC LIMIT ?`, id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("list scheduled query runs: %w", err)
+	}
+	runs := []ScheduledQueryRun{}
+	for rows.Next() {
+		run := ScheduledQueryRun{Answers: []ScheduledQueryAnswer{}}
+		if err := rows.Scan(&run.ID, &run.SentAt, &run.Error); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan scheduled query run: %w", err)
+		}
+		runs = append(runs, run)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range runs {
+		answers, err := db.QueryContext(ctx, `
+			SELECT user, answer, received_at FROM scheduled_query_answers
+			WHERE run_id = ? ORDER BY received_at, user`, runs[i].ID)
+		if err != nil {
+			return nil, fmt.Errorf("list scheduled query answers: %w", err)
+		}
+		for answers.Next() {
+			var a ScheduledQueryAnswer
+			if err := answers.Scan(&a.User, &a.Answer, &a.ReceivedAt); err != nil {
+				answers.Close()
+				return nil, fmt.Errorf("scan scheduled query answer: %w", err)
+			}
+			runs[i].Answers = append(runs[i].Answers, a)
+		}
+		answers.Close()
+		if err := answers.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return runs, nil
+}
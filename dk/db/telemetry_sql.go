@@ -0,0 +1,128 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// TelemetryCounter counts the uses of a feature on one day
+type TelemetryCounter struct {
+	Category string `json:"category"`
+	Feature  string `json:"feature"`
+	Day      string `json:"day"` // UTC date, YYYY-MM-DD
+	Count    int64  `json:"count"`
+	Reported bool   `json:"reported"` // Included in a sent report
+}
+
+// GetTelemetryConsent returns the categories the user opted in or out of
+func GetTelemetryConsent(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, `SELECT category, enabled FROM telemetry_consent`)
+	if err != nil {
+		return nil, fmt.Errorf("get telemetry consent: %w", err)
+	}
+	defer rows.Close()
+
+	consent := make(map[string]bool)
+	for rows.Next() {
+		var category string
+		var enabled bool
+		if err := rows.Scan(&category, &enabled); err != nil {
+			return nil, fmt.Errorf("scan telemetry consent: %w", err)
+		}
+		consent[category] = enabled
+	}
+	return consent, rows.Err()
+}
+
+// SetTelemetryConsent opts a category in or out. Opting out deletes the counters collected
+// for the category.
+func SetTelemetryConsent(ctx context.Context, db *sql.DB, category string, enabled bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO telemetry_consent (category, enabled, updated_at) VALUES (?, ?, CURRENT_TIMESTAMP)
+		 ON CONFLICT(category) DO UPDATE SET enabled = excluded.enabled, updated_at = excluded.updated_at`,
+		category, enabled); err != nil {
+		return fmt.Errorf("set telemetry consent: %w", err)
+	}
+	if !enabled {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM telemetry_counters WHERE category = ?`, category); err != nil {
+			return fmt.Errorf("delete telemetry counters: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// IncrementTelemetryCounter counts a use of a feature, but only if the user opted in to its
+// category
+func IncrementTelemetryCounter(ctx context.Context, db *sql.DB, category, feature, day string) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO telemetry_counters (category, feature, day, count)
+		 SELECT ?, ?, ?, 1
+		 WHERE EXISTS (SELECT 1 FROM telemetry_consent WHERE category = ? AND enabled = 1)
+		 ON CONFLICT(category, feature, day) DO UPDATE SET count = count + 1`,
+		category, feature, day, category)
+	if err != nil {
+		return fmt.Errorf("increment telemetry counter: %w", err)
+	}
+	return nil
+}
+
+// ListTelemetryCounters returns the counters, oldest first. With pendingBefore set, only the
+// unreported counters of days before it are returned.
+func ListTelemetryCounters(ctx context.Context, db *sql.DB, pendingBefore string) ([]TelemetryCounter, error) {
+	query := `SELECT category, feature, day, count, reported FROM telemetry_counters`
+	var args []any
+	if pendingBefore != "" {
+		query += ` WHERE reported = 0 AND day < ?`
+		args = append(args, pendingBefore)
+	}
+	query += ` ORDER BY day, category, feature`
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list telemetry counters: %w", err)
+	}
+	defer rows.Close()
+
+	counters := []TelemetryCounter{}
+	for rows.Next() {
+		var c TelemetryCounter
+		if err := rows.Scan(&c.Category, &c.Feature, &c.Day, &c.Count, &c.Reported); err != nil {
+			return nil, fmt.Errorf("scan telemetry counter: %w", err)
+		}
+		counters = append(counters, c)
+	}
+	return counters, rows.Err()
+}
+
+// MarkTelemetryReported marks the unreported counters of days up to and including lastDay as
+// reported
+func MarkTelemetryReported(ctx context.Context, db *sql.DB, lastDay string) error {
+	if _, err := db.ExecContext(ctx,
+		`UPDATE telemetry_counters SET reported = 1 WHERE reported = 0 AND day <= ?`, lastDay); err != nil {
+		return fmt.Errorf("mark telemetry reported: %w", err)
+	}
+	return nil
+}
+
+// PurgeTelemetry deletes the counters of a category, or of every category when it is empty,
+// and returns how many were deleted
+func PurgeTelemetry(ctx context.Context, db *sql.DB, category string) (int64, error) {
+	query := `DELETE FROM telemetry_counters`
+	var args []any
+	if category != "" {
+		query += ` WHERE category = ?`
+		args = append(args, category)
+	}
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("purge telemetry: %w", err)
+	}
+	return res.RowsAffected()
+}
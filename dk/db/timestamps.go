@@ -0,0 +1,163 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// utcDriverName is the SQLite driver that stores every time value in UTC. Times are stored as
+// text, so values written in different zones would neither compare nor sort correctly, and
+// peers in other zones would read them with the writer's offset.
+const utcDriverName = "sqlite-utc"
+
+func init() {
+	sql.Register(utcDriverName, utcDriver{&sqlite.Driver{}})
+}
+
+// sqliteConn is the set of interfaces the SQLite connections implement
+type sqliteConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+}
+
+type utcDriver struct {
+	driver.Driver
+}
+
+// Open implements driver.Driver
+func (d utcDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	sc, ok := conn.(sqliteConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("unsupported SQLite connection %T", conn)
+	}
+	return utcConn{sc}, nil
+}
+
+type utcConn struct {
+	sqliteConn
+}
+
+// CheckNamedValue implements driver.NamedValueChecker, converting time arguments to UTC and
+// leaving the others to the default conversion
+func (utcConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if t, ok := nv.Value.(time.Time); ok {
+		nv.Value = t.UTC()
+		return nil
+	}
+	return driver.ErrSkip
+}
+
+// normalizeStoredTimestamps rewrites in UTC the time values that were stored with a zone
+// offset before times were converted on write. It only touches columns declared as dates or
+// timestamps, and values that carry a non-UTC offset, so it is cheap to run again.
+func normalizeStoredTimestamps(db *sql.DB) error {
+	ctx := context.Background()
+	rows, err := db.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`)
+	if err != nil {
+		return fmt.Errorf("list tables: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan table: %w", err)
+		}
+		tables = append(tables, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("list tables: %w", err)
+	}
+
+	for _, table := range tables {
+		columns, err := timestampColumns(ctx, db, table)
+		if err != nil {
+			return err
+		}
+		for _, column := range columns {
+			if err := normalizeColumn(ctx, db, table, column); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// timestampColumns returns the columns of a table declared as a date or time
+func timestampColumns(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT name, type FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return nil, fmt.Errorf("describe table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var name, declared string
+		if err := rows.Scan(&name, &declared); err != nil {
+			return nil, fmt.Errorf("describe table %s: %w", table, err)
+		}
+		declared = strings.ToUpper(declared)
+		if strings.Contains(declared, "DATE") || strings.Contains(declared, "TIME") {
+			columns = append(columns, name)
+		}
+	}
+	return columns, rows.Err()
+}
+
+// normalizeColumn rewrites the values of a column that were stored with a non-UTC offset
+func normalizeColumn(ctx context.Context, db *sql.DB, table, column string) error {
+	// Values written by the driver look like "2006-01-02 15:04:05.999 -0700 MST", with the
+	// offset after a space, and RFC 3339 values end in "-07:00"; SQLite's CURRENT_TIMESTAMP
+	// and plain dates carry no offset
+	query := fmt.Sprintf(`SELECT rowid, %[1]q FROM %[2]q
+		WHERE typeof(%[1]q) = 'text' AND (
+			((%[1]q LIKE '%% +%%' OR %[1]q LIKE '%% -%%') AND %[1]q NOT LIKE '%% +0000 UTC%%') OR
+			(%[1]q GLOB '*[+-][0-9][0-9]:[0-9][0-9]' AND %[1]q NOT GLOB '*+00:00'))`,
+		column, table)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("read %s.%s: %w", table, column, err)
+	}
+	type value struct {
+		rowid int64
+		t     time.Time
+	}
+	var values []value
+	for rows.Next() {
+		var v value
+		if err := rows.Scan(&v.rowid, &v.t); err != nil {
+			// Not a time the driver can parse; leave it as it is
+			continue
+		}
+		values = append(values, v)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("read %s.%s: %w", table, column, err)
+	}
+
+	update := fmt.Sprintf(`UPDATE %q SET %q = ? WHERE rowid = ?`, table, column)
+	for _, v := range values {
+		if _, err := db.ExecContext(ctx, update, v.t, v.rowid); err != nil {
+			return fmt.Errorf("normalize %s.%s: %w", table, column, err)
+		}
+	}
+	return nil
+}
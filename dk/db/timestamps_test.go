@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimestampsStoredInUTC(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := context.Background()
+
+	// Times written in any zone are stored in UTC
+	zone := time.FixedZone("UTC+2", 2*60*60)
+	written := time.Date(2025, 3, 1, 1, 30, 0, 0, zone)
+	if err := AssignRole(ctx, testDB.DB, "carol", "curator", "hash-1"); err != nil {
+		t.Fatalf("AssignRole failed: %v", err)
+	}
+	if _, err := testDB.Exec(`UPDATE role_assignments SET assigned_at = ? WHERE user_id = ?`, written, "carol"); err != nil {
+		t.Fatalf("Failed to update assigned_at: %v", err)
+	}
+	var stored string
+	testDB.QueryRow(`SELECT CAST(assigned_at AS TEXT) FROM role_assignments WHERE user_id = ?`, "carol").Scan(&stored)
+	if stored != "2025-02-28 23:30:00 +0000 UTC" {
+		t.Errorf("Expected the time stored in UTC, got %q", stored)
+	}
+
+	// Values stored with an offset before are rewritten by the migrations
+	if _, err := testDB.Exec(`UPDATE role_assignments SET assigned_at = '2025-03-01 01:30:00 +0200 CEST' WHERE user_id = ?`, "carol"); err != nil {
+		t.Fatalf("Failed to store a time with an offset: %v", err)
+	}
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations again: %v", err)
+	}
+	testDB.QueryRow(`SELECT CAST(assigned_at AS TEXT) FROM role_assignments WHERE user_id = ?`, "carol").Scan(&stored)
+	if stored != "2025-02-28 23:30:00 +0000 UTC" {
+		t.Errorf("Expected the stored offset to be normalized to UTC, got %q", stored)
+	}
+	if _, err := testDB.Exec(`UPDATE role_assignments SET assigned_at = '2025-03-01T01:30:00+02:00' WHERE user_id = ?`, "carol"); err != nil {
+		t.Fatalf("Failed to store an RFC 3339 time: %v", err)
+	}
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations again: %v", err)
+	}
+	testDB.QueryRow(`SELECT CAST(assigned_at AS TEXT) FROM role_assignments WHERE user_id = ?`, "carol").Scan(&stored)
+	if stored != "2025-02-28 23:30:00 +0000 UTC" {
+		t.Errorf("Expected the RFC 3339 offset to be normalized to UTC, got %q", stored)
+	}
+}
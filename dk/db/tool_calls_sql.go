@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// ToolCallOK is the outcome of a tool call that succeeded; failed calls record their error code
+const ToolCallOK = "ok"
+
+// ToolCall is the audit record of an MCP tool call
+type ToolCall struct {
+	ID         int64     `json:"id"`
+	Tool       string    `json:"tool"`
+	Caller     string    `json:"caller"`    // role:user ID of the MCP session
+	ArgsHash   string    `json:"args_hash"` // SHA-256 of the JSON arguments, so repeated calls stand out
+	DurationMS int64     `json:"duration_ms"`
+	Outcome    string    `json:"outcome"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ToolCallFilter selects tool calls; zero fields match every call
+type ToolCallFilter struct {
+	Tool   string
+	Caller string
+	Since  time.Time
+	Limit  int
+}
+
+// ToolCallSummary counts the calls of a tool by a caller
+type ToolCallSummary struct {
+	Tool          string    `json:"tool"`
+	Caller        string    `json:"caller"`
+	Calls         int       `json:"calls"`
+	Failed        int       `json:"failed"`       // Including rate limited calls
+	RateLimited   int       `json:"rate_limited"` // Refused by a rate limit
+	AvgDurationMS int64     `json:"avg_duration_ms"`
+	LastCall      time.Time `json:"last_call"`
+}
+
+// InsertToolCall records a tool call
+func InsertToolCall(ctx context.Context, db *sql.DB, call ToolCall) error {
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO tool_calls (tool, caller, args_hash, duration_ms, outcome) VALUES (?, ?, ?, ?, ?)`,
+		call.Tool, call.Caller, call.ArgsHash, call.DurationMS, call.Outcome)
+	if err != nil {
+		return fmt.Errorf("insert tool call: %w", err)
+	}
+	return nil
+}
+
+// ListToolCalls returns the tool calls matching a filter, newest first
+func ListToolCalls(ctx context.Context, db *sql.DB, filter ToolCallFilter) ([]ToolCall, error) {
+	conditions, args := toolCallConditions(filter.Tool, filter.Caller, filter.Since)
+	query := `SELECT id, tool, caller, args_hash, duration_ms, outcome, created_at FROM tool_calls` +
+		conditions + ` ORDER BY id DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, filter.Limit)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list tool calls: %w", err)
+	}
+	defer rows.Close()
+
+	calls := []ToolCall{}
+	for rows.Next() {
+		var c ToolCall
+		if err := rows.Scan(&c.ID, &c.Tool, &c.Caller, &c.ArgsHash, &c.DurationMS, &c.Outcome, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan tool call: %w", err)
+		}
+		calls = append(calls, c)
+	}
+	return calls, rows.Err()
+}
+
+// SummarizeToolCalls counts the calls of each tool by each caller since a time, busiest first
+func SummarizeToolCalls(ctx context.Context, db *sql.DB, tool, caller string, since time.Time) ([]ToolCallSummary, error) {
+	conditions, args := toolCallConditions(tool, caller, since)
+	rows, err := db.QueryContext(ctx, `SELECT tool, caller, COUNT(*),
+		SUM(CASE WHEN outcome != ? THEN 1 ELSE 0 END), SUM(CASE WHEN outcome = 'rate_limited' THEN 1 ELSE 0 END),
+		CAST(AVG(duration_ms) AS INTEGER), MAX(created_at)
+		FROM tool_calls`+conditions+` GROUP BY tool, caller ORDER BY COUNT(*) DESC, tool, caller`,
+		append([]any{ToolCallOK}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("summarize tool calls: %w", err)
+	}
+	defer rows.Close()
+
+	summaries := []ToolCallSummary{}
+	for rows.Next() {
+		var s ToolCallSummary
+		var last string
+		if err := rows.Scan(&s.Tool, &s.Caller, &s.Calls, &s.Failed, &s.RateLimited, &s.AvgDurationMS, &last); err != nil {
+			return nil, fmt.Errorf("scan tool call summary: %w", err)
+		}
+		s.LastCall, _ = time.Parse(sqliteTimestamp, last)
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// sqliteTimestamp is the layout of CURRENT_TIMESTAMP
+const sqliteTimestamp = "2006-01-02 15:04:05"
+
+// toolCallConditions returns the WHERE clause of a tool call filter
+func toolCallConditions(tool, caller string, since time.Time) (string, []any) {
+	var conditions string
+	var args []any
+	add := func(condition string, arg any) {
+		if conditions == "" {
+			conditions = " WHERE " + condition
+		} else {
+			conditions += " AND " + condition
+		}
+		args = append(args, arg)
+	}
+	if tool != "" {
+		add("tool = ?", tool)
+	}
+	if caller != "" {
+		add("caller = ?", caller)
+	}
+	if !since.IsZero() {
+		add("created_at >= ?", since.UTC().Format(sqliteTimestamp))
+	}
+	return conditions, args
+}
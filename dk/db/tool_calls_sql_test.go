@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestToolCalls(t *testing.T) {
+	testDB, err := OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+
+	if err := RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	ctx := context.Background()
+	calls := []ToolCall{
+		{Tool: "cqAskQuestion", Caller: "host:", ArgsHash: "a", DurationMS: 10, Outcome: ToolCallOK},
+		{Tool: "cqAskQuestion", Caller: "host:", ArgsHash: "a", DurationMS: 30, Outcome: "rate_limited"},
+		{Tool: "cqAskQuestion", Caller: "curator:carol", ArgsHash: "b", DurationMS: 20, Outcome: "not_found"},
+		{Tool: "cqGetUsers", Caller: "host:", ArgsHash: "c", DurationMS: 5, Outcome: ToolCallOK},
+	}
+	for _, c := range calls {
+		if err := InsertToolCall(ctx, testDB.DB, c); err != nil {
+			t.Fatalf("Failed to insert tool call: %v", err)
+		}
+	}
+	if _, err := testDB.DB.Exec(`UPDATE tool_calls SET created_at = '2020-01-01 00:00:00' WHERE tool = 'cqGetUsers'`); err != nil {
+		t.Fatalf("Failed to age a tool call: %v", err)
+	}
+
+	listed, err := ListToolCalls(ctx, testDB.DB, ToolCallFilter{Tool: "cqAskQuestion", Limit: 2})
+	if err != nil {
+		t.Fatalf("Failed to list tool calls: %v", err)
+	}
+	if len(listed) != 2 || listed[0].Caller != "curator:carol" || listed[0].CreatedAt.IsZero() {
+		t.Errorf("Expected the two newest calls of cqAskQuestion, got %+v", listed)
+	}
+
+	since := time.Now().Add(-time.Hour)
+	summaries, err := SummarizeToolCalls(ctx, testDB.DB, "", "", since)
+	if err != nil {
+		t.Fatalf("Failed to summarize tool calls: %v", err)
+	}
+	if len(summaries) != 2 {
+		t.Fatalf("Expected the old call to be left out, got %+v", summaries)
+	}
+	host := summaries[0]
+	if host.Caller != "host:" || host.Calls != 2 || host.Failed != 1 || host.RateLimited != 1 || host.AvgDurationMS != 20 || host.LastCall.IsZero() {
+		t.Errorf("Unexpected summary: %+v", host)
+	}
+}
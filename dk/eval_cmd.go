@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"dk/core"
+	"dk/core/eval"
+	"dk/utils"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+)
+
+const evalUsage = `Usage: dk eval -cases FILE [flags]
+
+Runs the labeled questions of FILE against the documents indexed by this node, using the
+chunking, collections and reranking of the model configuration, and reports recall@k and
+MRR. Each line of FILE is a JSON object such as
+  {"id": "q1", "question": "How are peers authenticated?", "relevant": ["auth.md"]}
+
+Flags:
+`
+
+// runEvalCommand implements the "eval" subcommand and returns the exit code
+func runEvalCommand(vectorDBPath, modelConfigFile string, args []string) int {
+	fs := flag.NewFlagSet("eval", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, evalUsage)
+		fs.PrintDefaults()
+	}
+	casesFile := fs.String("cases", "", "JSONL file of labeled questions")
+	kList := fs.String("k", "1,3,5,10", "Comma-separated cutoffs to report recall at")
+	out := fs.String("out", "", "Write the full report as JSON to this file")
+	baselineFile := fs.String("baseline", "", "JSON report of an earlier run to compare against")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *casesFile == "" {
+		fs.Usage()
+		return 2
+	}
+	k, err := parseCutoffs(*kList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eval: invalid -k: %v\n", err)
+		return 2
+	}
+	cases, err := eval.LoadCases(*casesFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eval: %v\n", err)
+		return 1
+	}
+	var baseline *eval.Report
+	if *baselineFile != "" {
+		raw, err := os.ReadFile(*baselineFile)
+		if err == nil {
+			err = json.Unmarshal(raw, &baseline)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "eval: reading baseline: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	var collectionsConfig core.CollectionsConfig
+	if modelConfig, err := core.LoadModelConfig(modelConfigFile); err != nil {
+		fmt.Fprintf(os.Stderr, "eval: warning: evaluating without the model config: %v\n", err)
+	} else {
+		if modelConfig.Collections != nil {
+			collectionsConfig = *modelConfig.Collections
+		}
+		if modelConfig.Rerank != nil {
+			llmProvider, _ := core.CreateLLMProvider(modelConfig)
+			reranker, err := core.NewReranker(*modelConfig.Rerank, llmProvider)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "eval: %v\n", err)
+				return 1
+			}
+			ctx = core.WithReranker(ctx, reranker)
+		}
+	}
+	collections, err := core.SetupCollections(ctx, vectorDBPath, collectionsConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eval: %v\n", err)
+		return 1
+	}
+	chromemCollection, keywordIndex := collections.Default()
+	ctx = core.WithCollections(ctx, collections)
+	ctx = utils.WithChromemCollection(ctx, chromemCollection)
+	ctx = core.WithKeywordIndex(ctx, keywordIndex)
+
+	report, err := eval.Run(ctx, cases, eval.PipelineRetriever(ctx), k)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "eval: %v\n", err)
+		return 1
+	}
+	if *out != "" {
+		raw, err := json.MarshalIndent(report, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*out, append(raw, '\n'), 0o644)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "eval: writing report: %v\n", err)
+			return 1
+		}
+	}
+	report.WriteSummary(os.Stdout, baseline)
+	return 0
+}
+
+// parseCutoffs parses a comma-separated list of positive integers
+func parseCutoffs(value string) ([]int, error) {
+	var k []int
+	for _, field := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("%q is not a positive number", field)
+		}
+		k = append(k, n)
+	}
+	return k, nil
+}
@@ -0,0 +1,137 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HandleRevealAPIKey handles POST /api/apis/:id/keys/reveal. Other responses only show API keys
+// masked; this one returns the full key once the host re-authenticates with its HTTP token, and
+// records who revealed it and why.
+func HandleRevealAPIKey(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	apiID := getPathParam(r, "id")
+	if apiID == "" {
+		sendErrorResponse(w, "API ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req RevealAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		sendErrorResponse(w, "A reason is required to reveal an API key", http.StatusBadRequest)
+		return
+	}
+
+	// Without a host token there is no credential to re-authenticate with
+	hostToken := ""
+	if params, err := utils.ParamsFromContext(ctx); err == nil && params.HTTPToken != nil {
+		hostToken = *params.HTTPToken
+	}
+	if hostToken == "" {
+		sendErrorResponse(w, "Revealing API keys requires dk to run with -http_token; rotate the key instead", http.StatusForbidden)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(req.Token), []byte(hostToken)) != 1 {
+		log.Printf("[HTTP] Refused to reveal the key of API %s to %s: re-authentication failed", apiID, r.RemoteAddr)
+		sendErrorResponse(w, "Re-authentication failed", http.StatusUnauthorized)
+		return
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	api, err := db.GetAPI(database, apiID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendErrorResponse(w, "API not found", http.StatusNotFound)
+		} else {
+			sendErrorResponse(w, "Failed to retrieve API: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	userID, err := utils.UserIDFromContext(ctx)
+	if err != nil {
+		// For development/testing - in production, should return an error
+		userID = "local-user"
+	}
+	reveal := &db.APIKeyReveal{
+		APIID:      api.ID,
+		RevealedBy: userID,
+		Reason:     strings.TrimSpace(req.Reason),
+		RemoteAddr: r.RemoteAddr,
+		CreatedAt:  time.Now(),
+	}
+	// The key is only shown if the reveal could be audited
+	if err := db.CreateAPIKeyReveal(database, reveal); err != nil {
+		sendErrorResponse(w, "Failed to audit API key reveal: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[HTTP] Key of API %s revealed to %s (%s): %s", api.ID, reveal.RevealedBy, reveal.RemoteAddr, reveal.Reason)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(RevealAPIKeyResponse{
+		ID:         api.ID,
+		APIKey:     api.APIKey,
+		RevealedAt: reveal.CreatedAt,
+	})
+}
+
+// HandleListAPIKeyReveals handles GET /api/apis/:id/keys/reveals
+func HandleListAPIKeyReveals(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	apiID := getPathParam(r, "id")
+	if apiID == "" {
+		sendErrorResponse(w, "API ID is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := 20 // default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if val, err := strconv.Atoi(limitStr); err == nil && val > 0 {
+			limit = val
+		}
+	}
+
+	offset := 0 // default
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if val, err := strconv.Atoi(offsetStr); err == nil && val >= 0 {
+			offset = val
+		}
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	reveals, total, err := db.ListAPIKeyReveals(database, apiID, limit, offset)
+	if err != nil {
+		sendErrorResponse(w, "Failed to list API key reveals: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIKeyRevealListResponse{
+		Total:   total,
+		Limit:   limit,
+		Offset:  offset,
+		Reveals: reveals,
+	})
+}
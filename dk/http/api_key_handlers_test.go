@@ -0,0 +1,85 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"dk/db"
+	"dk/utils"
+)
+
+func TestMaskAPIKey(t *testing.T) {
+	tests := map[string]string{
+		"api_0b8f3c2e-6a41-4d3e-9f0a-5c7d2e1b9a44": "api_****9a44",
+		"short_key": "short_****",
+		"":          "",
+	}
+	for key, want := range tests {
+		if got := db.MaskAPIKey(key); got != want {
+			t.Errorf("MaskAPIKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestHandleRevealAPIKey(t *testing.T) {
+	ctx, testDB, err := setupTestContext(t)
+	if err != nil {
+		t.Fatalf("Failed to set up test context: %v", err)
+	}
+	defer testDB.Close()
+	api, err := createTestAPI(ctx, t)
+	if err != nil {
+		t.Fatalf("Failed to create test API: %v", err)
+	}
+
+	// Details only show the masked key
+	rec := httptest.NewRecorder()
+	HandleGetAPI(ctx, rec, httptest.NewRequest(http.MethodGet, "/api/apis/"+api.ID, nil))
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), api.APIKey) || !strings.Contains(rec.Body.String(), db.MaskAPIKey(api.APIKey)) {
+		t.Fatalf("Expected a masked key, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	reveal := func(body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		HandleRevealAPIKey(ctx, rec, httptest.NewRequest(http.MethodPost, "/api/apis/"+api.ID+"/keys/reveal", strings.NewReader(body)))
+		return rec
+	}
+
+	// Nothing to re-authenticate with unless the node has a host token
+	if rec := reveal(`{"token": "", "reason": "Configure the gateway"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without a host token, got %d", rec.Code)
+	}
+
+	hostToken := "host-secret"
+	ctx = utils.WithParams(ctx, utils.Parameters{HTTPToken: &hostToken})
+	if rec := reveal(`{"token": "wrong", "reason": "Configure the gateway"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong token, got %d", rec.Code)
+	}
+	if rec := reveal(`{"token": "host-secret", "reason": " "}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a reason, got %d", rec.Code)
+	}
+
+	rec = reveal(`{"token": "host-secret", "reason": "Configure the gateway"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response RevealAPIKeyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil || response.APIKey != api.APIKey {
+		t.Errorf("Expected the full key, got %+v (%v)", response, err)
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Error("Expected the revealed key not to be cached")
+	}
+
+	// Only the successful reveal is audited
+	reveals, total, err := db.ListAPIKeyReveals(testDB.DB, api.ID, 10, 0)
+	if err != nil {
+		t.Fatalf("ListAPIKeyReveals failed: %v", err)
+	}
+	if total != 1 || reveals[0].Reason != "Configure the gateway" || reveals[0].RevealedBy == "" {
+		t.Errorf("Unexpected audit trail: %d reveals, %+v", total, reveals)
+	}
+}
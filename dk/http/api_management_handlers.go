@@ -52,6 +52,14 @@ func HandleGetAPIs(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	status := r.URL.Query().Get("status")
 	externalUserID := r.URL.Query().Get("external_user_id")
 
+	// Metadata filters are given as meta.KEY=VALUE, e.g. ?meta.classification=restricted
+	metadata := map[string]string{}
+	for param, values := range r.URL.Query() {
+		if key, ok := strings.CutPrefix(param, "meta."); ok && key != "" && len(values) > 0 {
+			metadata[key] = values[0]
+		}
+	}
+
 	// Parse pagination parameters
 	limit := 20 // default
 	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
@@ -78,8 +86,8 @@ func HandleGetAPIs(ctx context.Context, w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Get the APIs from the database
-	apis, total, err := db.ListAPIs(database, status, externalUserID, limit, offset, sort, order)
+	// Get the APIs with their counts and policies from the database
+	apis, total, err := db.ListAPISummaries(database, status, externalUserID, metadata, limit, offset, sort, order)
 	if err != nil {
 		sendErrorResponse(w, "Failed to retrieve APIs: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -88,30 +96,13 @@ func HandleGetAPIs(ctx context.Context, w http.ResponseWriter, r *http.Request)
 	// Convert to response format
 	apiBasicList := make([]APIBasic, 0, len(apis))
 	for _, api := range apis {
-		// Get external user count
-		userCount, err := db.CountAPIExternalUsers(database, api.ID)
-		if err != nil {
-			sendErrorResponse(w, "Failed to count external users: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		// Get document count
-		docCount, err := db.CountAPIDocuments(database, api.ID)
-		if err != nil {
-			sendErrorResponse(w, "Failed to count documents: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-
 		// Get policy if available
 		var policyRef *PolicyRef
-		if api.PolicyID != nil {
-			policy, err := db.GetPolicy(database, *api.PolicyID)
-			if err == nil {
-				policyRef = &PolicyRef{
-					ID:   policy.ID,
-					Name: policy.Name,
-					Type: policy.Type,
-				}
+		if api.PolicyID != nil && api.PolicyName != "" {
+			policyRef = &PolicyRef{
+				ID:   *api.PolicyID,
+				Name: api.PolicyName,
+				Type: api.PolicyType,
 			}
 		}
 
@@ -124,8 +115,9 @@ func HandleGetAPIs(ctx context.Context, w http.ResponseWriter, r *http.Request)
 			CreatedAt:          api.CreatedAt,
 			UpdatedAt:          api.UpdatedAt,
 			Policy:             policyRef,
-			ExternalUsersCount: userCount,
-			DocumentsCount:     docCount,
+			ExternalUsersCount: api.ExternalUsersCount,
+			DocumentsCount:     api.DocumentsCount,
+			Metadata:           api.Metadata,
 		}
 
 		apiBasicList = append(apiBasicList, apiBasic)
@@ -264,11 +256,12 @@ func HandleGetAPI(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 		IsDeprecated:  api.IsDeprecated,
 		CreatedAt:     api.CreatedAt,
 		UpdatedAt:     api.UpdatedAt,
-		APIKey:        api.APIKey,
+		APIKey:        db.MaskAPIKey(api.APIKey),
 		ExternalUsers: userRefs,
 		Documents:     documentRefs,
 		Policy:        policyDetail,
 		UsageSummary:  usageSummary,
+		Metadata:      api.Metadata,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -330,6 +323,19 @@ func HandleCreateAPI(ctx context.Context, w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	// Attach custom metadata, validated against the metadata schema
+	if len(req.Metadata) > 0 {
+		if err := db.SetAPIMetadataTx(tx, api.ID, req.Metadata); err != nil {
+			if errors.Is(err, db.ErrInvalidMetadata) {
+				sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			} else {
+				sendErrorResponse(w, "Failed to save API metadata: "+err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		api.Metadata = req.Metadata
+	}
+
 	// Associate documents if provided
 	for _, docID := range req.DocumentIDs {
 		association := &db.DocumentAssociation{
@@ -366,8 +372,10 @@ func HandleCreateAPI(ctx context.Context, w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// Return the created API
+	// Return the created API. This is the only response that carries the full key without
+	// revealing it.
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(api)
 }
@@ -428,12 +436,27 @@ func HandleUpdateAPI(ctx context.Context, w http.ResponseWriter, r *http.Request
 		api.IsActive = *req.IsActive
 	}
 
+	if req.Metadata != nil {
+		if err := db.ValidateAPIMetadata(database, req.Metadata); err != nil {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Update the API in the database
 	if err := db.UpdateAPI(database, api); err != nil {
 		sendErrorResponse(w, "Failed to update API: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	if req.Metadata != nil {
+		if err := db.SetAPIMetadata(database, apiID, req.Metadata); err != nil {
+			sendErrorResponse(w, "Failed to save API metadata: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		api.Metadata = req.Metadata
+	}
+
 	// If policy was updated, record the change in policy_changes table
 	if req.PolicyID != nil {
 		// Get user ID from context or use a default for now
@@ -461,6 +484,7 @@ func HandleUpdateAPI(ctx context.Context, w http.ResponseWriter, r *http.Request
 	}
 
 	// Return the updated API
+	api.APIKey = db.MaskAPIKey(api.APIKey)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(api)
 }
@@ -516,6 +540,7 @@ func HandleDeprecateAPI(ctx context.Context, w http.ResponseWriter, r *http.Requ
 	}
 
 	// Return the updated API
+	api.APIKey = db.MaskAPIKey(api.APIKey)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(api)
 }
@@ -568,6 +593,35 @@ func HandleDeleteAPI(ctx context.Context, w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// HandleGetAPIArchive handles GET /api/apis/:id/archive
+func HandleGetAPIArchive(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	apiID := getPathParam(r, "id")
+	if apiID == "" {
+		sendErrorResponse(w, "API ID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Get database connection from context
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	archive, err := db.GetAPIArchive(database, apiID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendErrorResponse(w, "API archive not found", http.StatusNotFound)
+		} else {
+			sendErrorResponse(w, "Failed to retrieve API archive: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(archive)
+}
+
 // Note: Document type function is now provided by DocumentType() in document_utils.go
 
 // getAPIUsageSummary retrieves usage statistics for an API
@@ -933,6 +987,10 @@ func HandleCreateAPIRequest(ctx context.Context, w http.ResponseWriter, r *http.
 			}
 			return
 		}
+		if !tracker.IsActive {
+			sendErrorResponse(w, "Tracker is inactive: "+trackerID, http.StatusBadRequest)
+			return
+		}
 
 		// Create association
 		association := &db.RequestRequiredTracker{
@@ -1248,6 +1306,10 @@ func HandleResubmitAPIRequest(ctx context.Context, w http.ResponseWriter, r *htt
 				}
 				return
 			}
+			if !tracker.IsActive {
+				sendErrorResponse(w, "Tracker is inactive: "+trackerID, http.StatusBadRequest)
+				return
+			}
 
 			// Create association
 			association := &db.RequestRequiredTracker{
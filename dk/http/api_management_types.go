@@ -1,6 +1,7 @@
 package http
 
 import (
+	"dk/db"
 	"time"
 )
 
@@ -38,13 +39,15 @@ type APIUserAccess struct {
 
 // APIUserAccessRequest represents the request body for POST /api/apis/:id/users
 type APIUserAccessRequest struct {
-	UserID      string `json:"user_id"`
-	AccessLevel string `json:"access_level"` // "read", "write", "admin"
+	UserID            string `json:"user_id" validate:"required"`
+	AccessLevel       string `json:"access_level" validate:"required,oneof=read write admin"`
+	OverrideResidency bool   `json:"override_residency,omitempty"`
+	OverrideReason    string `json:"override_reason,omitempty"`
 }
 
 // APIUserAccessUpdateRequest represents the request body for PATCH /api/apis/:id/users/:user_id
 type APIUserAccessUpdateRequest struct {
-	AccessLevel string `json:"access_level"` // "read", "write", "admin"
+	AccessLevel string `json:"access_level" validate:"required,oneof=read write admin"`
 }
 
 // APIUserAccessResponse represents the response for user access operations
@@ -61,12 +64,13 @@ type APIUserAccessResponse struct {
 
 // APIListQueryParams represents the query parameters for filtering APIs
 type APIListQueryParams struct {
-	Status         string `json:"status"` // "active", "inactive", "deprecated"
-	ExternalUserID string `json:"external_user_id"`
-	Limit          int    `json:"limit"`
-	Offset         int    `json:"offset"`
-	Sort           string `json:"sort"`  // "name", "created_at"
-	Order          string `json:"order"` // "asc", "desc"
+	Status         string            `json:"status"` // "active", "inactive", "deprecated"
+	ExternalUserID string            `json:"external_user_id"`
+	Metadata       map[string]string `json:"metadata"` // Given as meta.KEY=VALUE parameters
+	Limit          int               `json:"limit"`
+	Offset         int               `json:"offset"`
+	Sort           string            `json:"sort"`  // "name", "created_at"
+	Order          string            `json:"order"` // "asc", "desc"
 }
 
 // APIListResponse represents the response for GET /api/apis
@@ -79,16 +83,17 @@ type APIListResponse struct {
 
 // APIBasic represents the simplified API information returned in lists
 type APIBasic struct {
-	ID                 string     `json:"id"`
-	Name               string     `json:"name"`
-	Description        string     `json:"description"`
-	IsActive           bool       `json:"is_active"`
-	IsDeprecated       bool       `json:"is_deprecated"`
-	CreatedAt          time.Time  `json:"created_at"`
-	UpdatedAt          time.Time  `json:"updated_at"`
-	Policy             *PolicyRef `json:"policy,omitempty"`
-	ExternalUsersCount int        `json:"external_users_count"`
-	DocumentsCount     int        `json:"documents_count"`
+	ID                 string            `json:"id"`
+	Name               string            `json:"name"`
+	Description        string            `json:"description"`
+	IsActive           bool              `json:"is_active"`
+	IsDeprecated       bool              `json:"is_deprecated"`
+	CreatedAt          time.Time         `json:"created_at"`
+	UpdatedAt          time.Time         `json:"updated_at"`
+	Policy             *PolicyRef        `json:"policy,omitempty"`
+	ExternalUsersCount int               `json:"external_users_count"`
+	DocumentsCount     int               `json:"documents_count"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
 }
 
 // PolicyRef provides a simple reference to a policy
@@ -100,18 +105,19 @@ type PolicyRef struct {
 
 // APIDetailResponse represents the response for GET /api/apis/:id
 type APIDetailResponse struct {
-	ID            string        `json:"id"`
-	Name          string        `json:"name"`
-	Description   string        `json:"description"`
-	IsActive      bool          `json:"is_active"`
-	IsDeprecated  bool          `json:"is_deprecated"`
-	CreatedAt     time.Time     `json:"created_at"`
-	UpdatedAt     time.Time     `json:"updated_at"`
-	APIKey        string        `json:"api_key"`
-	ExternalUsers []UserRef     `json:"external_users"`
-	Documents     []DocumentRef `json:"documents"`
-	Policy        *PolicyDetail `json:"policy,omitempty"`
-	UsageSummary  *UsageSummary `json:"usage_summary,omitempty"`
+	ID            string            `json:"id"`
+	Name          string            `json:"name"`
+	Description   string            `json:"description"`
+	IsActive      bool              `json:"is_active"`
+	IsDeprecated  bool              `json:"is_deprecated"`
+	CreatedAt     time.Time         `json:"created_at"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+	APIKey        string            `json:"api_key"` // Masked; see POST /api/apis/:id/keys/reveal
+	ExternalUsers []UserRef         `json:"external_users"`
+	Documents     []DocumentRef     `json:"documents"`
+	Policy        *PolicyDetail     `json:"policy,omitempty"`
+	UsageSummary  *UsageSummary     `json:"usage_summary,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
 }
 
 // UserRef provides a simple reference to a user
@@ -165,23 +171,29 @@ type UsageSummary struct {
 
 // CreateAPIRequest represents the request body for POST /api/apis
 type CreateAPIRequest struct {
-	Name          string   `json:"name"`
-	Description   string   `json:"description"`
-	PolicyID      string   `json:"policy_id"`
-	DocumentIDs   []string `json:"document_ids"`
-	ExternalUsers []struct {
-		UserID      string `json:"user_id"`
-		AccessLevel string `json:"access_level"`
-	} `json:"external_users"`
-	IsActive bool `json:"is_active"`
+	Name          string               `json:"name" validate:"required,max=200"`
+	Description   string               `json:"description"`
+	PolicyID      string               `json:"policy_id"`
+	DocumentIDs   []string             `json:"document_ids"`
+	ExternalUsers []ExternalUserAccess `json:"external_users" validate:"dive"`
+	IsActive      bool                 `json:"is_active"`
+	Metadata      map[string]string    `json:"metadata,omitempty"`
+}
+
+// ExternalUserAccess is the access of an external user to an API created with CreateAPIRequest
+type ExternalUserAccess struct {
+	UserID      string `json:"user_id" validate:"required"`
+	AccessLevel string `json:"access_level" validate:"required,oneof=read write admin"`
 }
 
 // UpdateAPIRequest represents the request body for PATCH /api/apis/:id
 type UpdateAPIRequest struct {
-	Name        *string `json:"name,omitempty"`
+	Name        *string `json:"name,omitempty" validate:"min=1,max=200"`
 	Description *string `json:"description,omitempty"`
 	PolicyID    *string `json:"policy_id,omitempty"`
 	IsActive    *bool   `json:"is_active,omitempty"`
+	// Metadata replaces all the metadata of the API when given; an empty object clears it
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // DeprecateAPIRequest represents the request body for POST /api/apis/:id/deprecate
@@ -262,7 +274,7 @@ type APIRequestRef struct {
 
 // CreateAPIRequestRequest represents the request body for POST /api/requests
 type CreateAPIRequestRequest struct {
-	APIName            string   `json:"api_name"`
+	APIName            string   `json:"api_name" validate:"required,max=200"`
 	Description        string   `json:"description"`
 	DocumentIDs        []string `json:"document_ids"`
 	RequiredTrackerIDs []string `json:"required_tracker_ids"`
@@ -271,10 +283,10 @@ type CreateAPIRequestRequest struct {
 
 // UpdateAPIRequestStatusRequest represents the request body for PATCH /api/requests/:id/status
 type UpdateAPIRequestStatusRequest struct {
-	Status       string `json:"status"`                  // "approved" or "denied"
-	PolicyID     string `json:"policy_id,omitempty"`     // Required if status is "approved"
-	CreateAPI    bool   `json:"create_api,omitempty"`    // Whether to automatically create an API
-	DenialReason string `json:"denial_reason,omitempty"` // Required if status is "denied"
+	Status       string `json:"status" validate:"required,oneof=approved denied"`
+	PolicyID     string `json:"policy_id,omitempty" validate:"required_if=status approved"`
+	CreateAPI    bool   `json:"create_api,omitempty"` // Whether to automatically create an API
+	DenialReason string `json:"denial_reason,omitempty" validate:"required_if=status denied"`
 }
 
 // ResubmitAPIRequestRequest represents the request body for POST /api/requests/:id/resubmit
@@ -329,9 +341,11 @@ type EntityAssociation struct {
 
 // DocumentAssociateRequest represents the request body for POST /api/documents/associate
 type DocumentAssociateRequest struct {
-	DocumentID string `json:"document_id"`
-	EntityID   string `json:"entity_id"`
-	EntityType string `json:"entity_type"`
+	DocumentID        string `json:"document_id" validate:"required"`
+	EntityID          string `json:"entity_id" validate:"required"`
+	EntityType        string `json:"entity_type" validate:"required,oneof=api request"`
+	OverrideResidency bool   `json:"override_residency,omitempty"`
+	OverrideReason    string `json:"override_reason,omitempty"`
 }
 
 // Policy Management Types
@@ -356,32 +370,32 @@ type PolicyListResponse struct {
 
 // CreatePolicyRequest represents the request body for POST /api/policies
 type CreatePolicyRequest struct {
-	Name        string       `json:"name"`
+	Name        string       `json:"name" validate:"required,max=200"`
 	Description string       `json:"description"`
-	Type        string       `json:"type"` // "free", "rate", "token", "time", "credit", "composite"
-	Rules       []PolicyRule `json:"rules,omitempty"`
+	Type        string       `json:"type" validate:"required,oneof=free rate token time credit composite"`
+	Rules       []PolicyRule `json:"rules,omitempty" validate:"required_unless=type free,dive"`
 }
 
 // UpdatePolicyRequest represents the request body for PATCH /api/policies/:id
 type UpdatePolicyRequest struct {
-	Name        *string      `json:"name,omitempty"`
+	Name        *string      `json:"name,omitempty" validate:"min=1,max=200"`
 	Description *string      `json:"description,omitempty"`
 	IsActive    *bool        `json:"is_active,omitempty"`
-	Rules       []PolicyRule `json:"rules,omitempty"`
+	Rules       []PolicyRule `json:"rules,omitempty" validate:"dive"`
 }
 
 // PolicyRule represents a single rule within a policy
 type PolicyRule struct {
-	RuleType   string  `json:"rule_type"` // "rate", "token", "time", "credit"
-	LimitValue float64 `json:"limit_value"`
-	Period     string  `json:"period,omitempty"` // "minute", "hour", "day", "week", "month", "year"
-	Action     string  `json:"action"`           // "block", "throttle", "notify", "log"
-	Priority   int     `json:"priority,omitempty"`
+	RuleType   string  `json:"rule_type" validate:"required,oneof=free rate token time credit"`
+	LimitValue float64 `json:"limit_value" validate:"min=0"`
+	Period     string  `json:"period,omitempty" validate:"oneof=minute hour day week month year"`
+	Action     string  `json:"action" validate:"required,oneof=block throttle notify log"`
+	Priority   int     `json:"priority,omitempty" validate:"min=0"`
 }
 
 // ChangePolicyRequest represents the request body for POST /api/apis/:id/policy
 type ChangePolicyRequest struct {
-	PolicyID             string     `json:"policy_id"`
+	PolicyID             string     `json:"policy_id" validate:"required"`
 	EffectiveImmediately bool       `json:"effective_immediately"`
 	ScheduledDate        *time.Time `json:"scheduled_date,omitempty"`
 	ChangeReason         string     `json:"change_reason"`
@@ -404,3 +418,70 @@ type PolicyChangeHistoryResponse struct {
 	APIID   string                 `json:"api_id"`
 	Changes []PolicyChangeResponse `json:"changes"`
 }
+
+// Data Residency Types
+
+// CollectionResidencyRequest represents the request body for PUT /api/residency/collections/:name
+type CollectionResidencyRequest struct {
+	Region string `json:"region" validate:"required"`
+}
+
+// ConsumerRegionRequest represents the request body for PUT /api/residency/consumers/:user_id
+type ConsumerRegionRequest struct {
+	Region string `json:"region" validate:"required"`
+}
+
+// ExportCheckRequest represents the request body for POST /api/residency/export-check
+type ExportCheckRequest struct {
+	Collection        string `json:"collection,omitempty"`
+	Destination       string `json:"destination" validate:"required"`
+	DestinationRegion string `json:"destination_region"`
+	OverrideResidency bool   `json:"override_residency,omitempty"`
+	OverrideReason    string `json:"override_reason,omitempty"`
+}
+
+// ExportCheckResponse represents the response for POST /api/residency/export-check
+type ExportCheckResponse struct {
+	Allowed        bool   `json:"allowed"`
+	Collection     string `json:"collection"`
+	RequiredRegion string `json:"required_region,omitempty"`
+	OverrideID     string `json:"override_id,omitempty"`
+}
+
+// ResidencyViolationResponse is returned when an operation is blocked by a residency constraint
+type ResidencyViolationResponse struct {
+	Error          string                  `json:"error"`
+	Collection     string                  `json:"collection"`
+	RequiredRegion string                  `json:"required_region"`
+	Violations     []db.ResidencyViolation `json:"violations,omitempty"`
+}
+
+// ResidencyOverrideListResponse represents the response for GET /api/residency/overrides
+type ResidencyOverrideListResponse struct {
+	Total     int                     `json:"total"`
+	Limit     int                     `json:"limit"`
+	Offset    int                     `json:"offset"`
+	Overrides []*db.ResidencyOverride `json:"overrides"`
+}
+
+// RevealAPIKeyRequest is the body of POST /api/apis/:id/keys/reveal. The host re-authenticates
+// by repeating its HTTP token.
+type RevealAPIKeyRequest struct {
+	Token  string `json:"token" validate:"required"`
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// RevealAPIKeyResponse represents the response for POST /api/apis/:id/keys/reveal
+type RevealAPIKeyResponse struct {
+	ID         string    `json:"id"`
+	APIKey     string    `json:"api_key"`
+	RevealedAt time.Time `json:"revealed_at"`
+}
+
+// APIKeyRevealListResponse represents the response for GET /api/apis/:id/keys/reveals
+type APIKeyRevealListResponse struct {
+	Total   int                `json:"total"`
+	Limit   int                `json:"limit"`
+	Offset  int                `json:"offset"`
+	Reveals []*db.APIKeyReveal `json:"reveals"`
+}
@@ -0,0 +1,86 @@
+package http
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// APIMetadataSchemaResponse represents the response for GET /api/api-metadata/fields
+type APIMetadataSchemaResponse struct {
+	Fields []db.APIMetadataField `json:"fields"`
+}
+
+// HandleListAPIMetadataFields handles GET /api/api-metadata/fields
+func HandleListAPIMetadataFields(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	fields, err := db.ListAPIMetadataFields(database)
+	if err != nil {
+		sendErrorResponse(w, "Failed to list metadata fields: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIMetadataSchemaResponse{Fields: fields})
+}
+
+// HandleSetAPIMetadataField handles PUT /api/api-metadata/fields/:key
+func HandleSetAPIMetadataField(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var field db.APIMetadataField
+	if err := json.NewDecoder(r.Body).Decode(&field); err != nil {
+		sendErrorResponse(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	field.Key = mux.Vars(r)["key"]
+	if field.Type == "" {
+		field.Type = db.MetadataString
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.SetAPIMetadataField(database, field); err != nil {
+		if errors.Is(err, db.ErrInvalidMetadata) {
+			sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		} else {
+			sendErrorResponse(w, "Failed to save metadata field: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(field)
+}
+
+// HandleDeleteAPIMetadataField handles DELETE /api/api-metadata/fields/:key
+func HandleDeleteAPIMetadataField(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.DeleteAPIMetadataField(database, mux.Vars(r)["key"]); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendErrorResponse(w, "Metadata field not found", http.StatusNotFound)
+		} else {
+			sendErrorResponse(w, "Failed to delete metadata field: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,114 @@
+package http
+
+import (
+	"context"
+	"dk/core"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DocumentURLResponse is a signed URL to download the original file of a document
+type DocumentURLResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// HandleGetDocumentURL returns a signed download URL for the original file of a document to
+// a consumer, identified by the X-API-ID and X-User-ID headers. The document must be
+// associated with the API and the consumer must have active access to it.
+func HandleGetDocumentURL(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	apiID := strings.TrimSpace(r.Header.Get("X-API-ID"))
+	userID := strings.TrimSpace(r.Header.Get("X-User-ID"))
+	if apiID == "" || userID == "" {
+		sendErrorResponse(w, "X-API-ID and X-User-ID headers are required", http.StatusBadRequest)
+		return
+	}
+	filename := mux.Vars(r)["filename"]
+	if filename == "" {
+		sendErrorResponse(w, "Document filename is required", http.StatusBadRequest)
+		return
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	access, err := db.GetAPIUserAccessByUserID(database, apiID, userID)
+	if err != nil || !access.IsActive {
+		sendErrorResponse(w, "Access denied: User does not have permission to use this API", http.StatusForbidden)
+		return
+	}
+	documents, err := db.GetAPIDocuments(database, apiID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get API documents: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	associated := false
+	for _, doc := range documents {
+		if doc.DocumentFilename == filename {
+			associated = true
+			break
+		}
+	}
+	if !associated {
+		sendErrorResponse(w, "Document not found", http.StatusNotFound)
+		return
+	}
+
+	signed, expiresAt, err := core.DocumentDownloadURL(ctx, filename, time.Now())
+	if errors.Is(err, core.ErrBlobNotFound) {
+		sendErrorResponse(w, "The original file of this document is not stored", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, "Failed to sign download URL: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DocumentURLResponse{URL: signed, ExpiresAt: expiresAt})
+}
+
+// HandleDownloadBlob serves a file of the local blob store to holders of a signed URL
+func HandleDownloadBlob(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	store, _ := core.BlobStoreFromContext(ctx)
+	local, ok := store.(*core.LocalBlobStore)
+	if !ok {
+		sendErrorResponse(w, "Blob not found", http.StatusNotFound)
+		return
+	}
+
+	key := mux.Vars(r)["key"]
+	query := r.URL.Query()
+	if err := local.VerifySignature(key, query.Get("expires"), query.Get("signature"), time.Now()); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	body, err := local.Get(r.Context(), key)
+	if errors.Is(err, core.ErrBlobNotFound) {
+		sendErrorResponse(w, "Blob not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		sendErrorResponse(w, "Failed to read blob: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	name := key[strings.LastIndex(key, "/")+1:]
+	w.Header().Set("Content-Type", InferContentType(name))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	io.Copy(w, body)
+}
@@ -0,0 +1,254 @@
+package http
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// creditBalancePath is where consumers look up their own balance. Looking it up is neither
+// charged nor blocked, so consumers can see why their requests are refused.
+const creditBalancePath = "/api/v1/credits/balance"
+
+// TopUpCreditsRequest is the payload for granting credits to a consumer
+type TopUpCreditsRequest struct {
+	Amount float64 `json:"amount" validate:"gt=0"`
+	Note   string  `json:"note,omitempty"`
+}
+
+// CreditPricingRequest is the payload for setting the credit pricing of a policy
+type CreditPricingRequest struct {
+	CreditsPerRequest float64 `json:"credits_per_request" validate:"min=0"`
+	CreditsPerToken   float64 `json:"credits_per_token" validate:"min=0"`
+}
+
+// CreditLedgerResponse is a page of a consumer's credit ledger
+type CreditLedgerResponse struct {
+	Items  []*db.CreditLedgerEntry `json:"items"`
+	Total  int                     `json:"total"`
+	Limit  int                     `json:"limit"`
+	Offset int                     `json:"offset"`
+}
+
+// HandleGetCreditBalance returns the credit balance of a consumer
+func HandleGetCreditBalance(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userID := getPathParam(r, "user_id")
+	if userID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	balance, err := db.GetCreditBalance(database, userID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get credit balance: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(balance)
+}
+
+// HandleGetOwnCreditBalance returns the credit balance of the consumer making the request,
+// identified by the X-User-ID header
+func HandleGetOwnCreditBalance(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userID := strings.TrimSpace(r.Header.Get("X-User-ID"))
+	if userID == "" {
+		sendErrorResponse(w, "X-User-ID header is required", http.StatusBadRequest)
+		return
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	balance, err := db.GetCreditBalance(database, userID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get credit balance: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(balance)
+}
+
+// HandleGetCreditLedger returns the top-ups and debits of a consumer, newest first
+func HandleGetCreditLedger(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userID := getPathParam(r, "user_id")
+	if userID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	limit := 50
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			sendErrorResponse(w, "Invalid limit parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			sendErrorResponse(w, "Invalid offset parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	entries, total, err := db.ListCreditLedger(database, userID, limit, offset)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get credit ledger: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []*db.CreditLedgerEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CreditLedgerResponse{Items: entries, Total: total, Limit: limit, Offset: offset})
+}
+
+// HandleTopUpCredits grants credits to a consumer
+func HandleTopUpCredits(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userID := getPathParam(r, "user_id")
+	if userID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req TopUpCreditsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Amount <= 0 {
+		sendErrorResponse(w, "Amount must be positive", http.StatusBadRequest)
+		return
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	currentUserID, err := utils.UserIDFromContext(ctx)
+	if err != nil {
+		// For development/testing - in production, should return an error
+		currentUserID = "local-user"
+	}
+
+	entry, err := db.TopUpCredits(database, userID, req.Amount, req.Note, currentUserID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to top up credits: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[HTTP] Granted %.2f credits to %s (balance %.2f)", req.Amount, userID, entry.BalanceAfter)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(entry)
+}
+
+// HandleGetCreditPricing returns the credit pricing of a policy
+func HandleGetCreditPricing(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	policyID := getPathParam(r, "id")
+	if policyID == "" {
+		sendErrorResponse(w, "Policy ID is required", http.StatusBadRequest)
+		return
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := db.GetPolicy(database, policyID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendErrorResponse(w, "Policy not found", http.StatusNotFound)
+		} else {
+			sendErrorResponse(w, "Failed to retrieve policy: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	pricing, err := db.GetCreditPricing(database, policyID)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get credit pricing: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pricing)
+}
+
+// HandleSetCreditPricing sets how many credits a policy charges per request and per token
+func HandleSetCreditPricing(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	policyID := getPathParam(r, "id")
+	if policyID == "" {
+		sendErrorResponse(w, "Policy ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req CreditPricingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.CreditsPerRequest < 0 || req.CreditsPerToken < 0 {
+		sendErrorResponse(w, "Credit prices cannot be negative", http.StatusBadRequest)
+		return
+	}
+	pricing := db.CreditPricing{
+		PolicyID:          policyID,
+		CreditsPerRequest: req.CreditsPerRequest,
+		CreditsPerToken:   req.CreditsPerToken,
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := db.GetPolicy(database, policyID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendErrorResponse(w, "Policy not found", http.StatusNotFound)
+		} else {
+			sendErrorResponse(w, "Failed to retrieve policy: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if err := db.SetCreditPricing(database, &pricing); err != nil {
+		sendErrorResponse(w, "Failed to set credit pricing: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pricing)
+}
@@ -273,6 +273,30 @@ func HandleUploadDocument(ctx context.Context, w http.ResponseWriter, r *http.Re
 		userID = "local-user"
 	}
 
+	// Create database connection
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	// Block uploads that would expose residency-restricted documents outside their region
+	overrideResidency, _ := strconv.ParseBool(r.FormValue("override_residency"))
+	if overrideResidency {
+		ctx = core.WithResidencyOverride(ctx, r.FormValue("override_reason"))
+	}
+	if entityType == "api" && entityID != "" {
+		violation, err := checkAPIResidency(ctx, database, entityID, filename, overrideResidency, r.FormValue("override_reason"))
+		if err != nil {
+			sendErrorResponse(w, "Failed to check data residency: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if violation != nil {
+			sendResidencyViolation(w, violation)
+			return
+		}
+	}
+
 	// Create metadata for the document
 	metadata := map[string]string{
 		"uploader_id":  userID,
@@ -280,16 +304,21 @@ func HandleUploadDocument(ctx context.Context, w http.ResponseWriter, r *http.Re
 		"content_type": InferContentType(filename),
 	}
 
-	// Add the document to the RAG system
-	if err := core.AddDocument(ctx, filename, string(fileContent), true, metadata); err != nil {
-		sendErrorResponse(w, "Failed to add document to vector database: "+err.Error(), http.StatusInternalServerError)
+	// Keep the original file so consumers can download it, unless the blob store is outside the
+	// region of the collection
+	if err := core.StoreDocumentBlob(ctx, filename, fileContent, metadata["content_type"]); errors.Is(err, core.ErrResidencyViolation) {
+		sendResidencyViolation(w, &ResidencyViolationResponse{
+			Error:      "Blob store does not satisfy the residency constraint of the collection: " + err.Error(),
+			Collection: currentCollectionName(ctx),
+		})
 		return
+	} else if err != nil {
+		utils.LogError(ctx, "Failed to store original of document %s: %v", filename, err)
 	}
 
-	// Create database connection
-	database, err := utils.DBFromContext(ctx)
-	if err != nil {
-		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+	// Add the document to the RAG system
+	if err := core.AddDocument(ctx, filename, string(fileContent), true, metadata); err != nil {
+		sendErrorResponse(w, "Failed to add document to vector database: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -390,6 +419,19 @@ func HandleAssociateDocument(ctx context.Context, w http.ResponseWriter, r *http
 		return
 	}
 
+	// Block associations that would expose residency-restricted documents outside their region
+	if req.EntityType == "api" {
+		violation, err := checkAPIResidency(ctx, database, req.EntityID, existingAssoc.DocumentFilename, req.OverrideResidency, req.OverrideReason)
+		if err != nil {
+			sendErrorResponse(w, "Failed to check data residency: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if violation != nil {
+			sendResidencyViolation(w, violation)
+			return
+		}
+	}
+
 	// Create new association with the same filename
 	association := &db.DocumentAssociation{
 		ID:               uuid.New().String(),
@@ -464,21 +506,10 @@ func HandleSoftDeleteDocument(ctx context.Context, w http.ResponseWriter, r *htt
 		return
 	}
 
-	// Update metadata to mark as deleted
+	// Mark the document as deleted, keeping its associations for a restore
 	now := time.Now()
-	deletionDate := now.Format(time.RFC3339)
-
-	// Create updated metadata
-	updatedMetadata := make(map[string]string)
-	for k, v := range doc.Metadata {
-		updatedMetadata[k] = v
-	}
-	updatedMetadata["is_deleted"] = "true"
-	updatedMetadata["deletion_date"] = deletionDate
-
-	// Update the document in RAG system
-	if err := core.UpdateDocument(ctx, assoc.DocumentFilename, doc.Content, updatedMetadata); err != nil {
-		sendErrorResponse(w, "Failed to update document: "+err.Error(), http.StatusInternalServerError)
+	if err := core.DeleteDocument(ctx, assoc.DocumentFilename); err != nil {
+		sendErrorResponse(w, "Failed to delete document: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -551,18 +582,8 @@ func HandleRestoreDocument(ctx context.Context, w http.ResponseWriter, r *http.R
 		return
 	}
 
-	// Create updated metadata
-	updatedMetadata := make(map[string]string)
-	for k, v := range doc.Metadata {
-		if k != "is_deleted" && k != "deletion_date" {
-			updatedMetadata[k] = v
-		}
-	}
-	updatedMetadata["is_deleted"] = "false"
-
-	// Update the document in RAG system
-	if err := core.UpdateDocument(ctx, assoc.DocumentFilename, doc.Content, updatedMetadata); err != nil {
-		sendErrorResponse(w, "Failed to update document: "+err.Error(), http.StatusInternalServerError)
+	if err := core.RestoreDocument(ctx, assoc.DocumentFilename); err != nil {
+		sendErrorResponse(w, "Failed to restore document: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -619,29 +640,9 @@ func HandlePermanentDeleteDocument(ctx context.Context, w http.ResponseWriter, r
 		return
 	}
 
-	// Start a transaction
-	tx, err := database.Begin()
-	if err != nil {
-		sendErrorResponse(w, "Failed to start transaction: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer tx.Rollback() // Roll back the transaction if it's not committed
-
-	// Delete all associations for this document
-	if err := db.DeleteAllDocumentAssociationsByFilenameTx(tx, assoc.DocumentFilename); err != nil {
-		sendErrorResponse(w, "Failed to delete document associations: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Remove the document from the RAG system
-	if err := core.RemoveDocument(ctx, assoc.DocumentFilename); err != nil {
-		sendErrorResponse(w, "Failed to remove document from vector database: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Commit the transaction
-	if err := tx.Commit(); err != nil {
-		sendErrorResponse(w, "Failed to commit transaction: "+err.Error(), http.StatusInternalServerError)
+	// Remove the document, its associations and its original file
+	if err := core.PurgeDocument(ctx, assoc.DocumentFilename); err != nil {
+		sendErrorResponse(w, "Failed to delete document: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
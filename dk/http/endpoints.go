@@ -6,8 +6,11 @@ import (
 	"dk/db"
 	"dk/utils"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
@@ -31,7 +34,8 @@ type RagResponse struct {
 
 // ErrorResponse represents the structure for error responses
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error  string       `json:"error"`
+	Fields []FieldError `json:"fields,omitempty"` // Invalid fields of the request body
 }
 
 // SingleDocumentResponse is returned by GET /rag/{file_name}
@@ -45,7 +49,8 @@ type PatchRagRequest struct {
 	FileContent string `json:"filecontent"`
 	// Metadata    []string `json:"metadata,omitempty"`
 
-	Metadata map[string]string `json:"metadata"` // arbitrary keys & values, all strings
+	Metadata   map[string]string `json:"metadata"`             // arbitrary keys & values, all strings
+	Collection string            `json:"collection,omitempty"` // Named collection of the document
 }
 
 // CountResponse is used by GET /rag/count
@@ -60,9 +65,10 @@ type FilterRequest struct {
 
 // RagQueryRequest is used by GET /rag with metadata filtering
 type RagQueryRequest struct {
-	Query      string            `json:"query"`
-	NumResults int               `json:"num_results"`
-	Metadata   map[string]string `json:"metadata"`
+	Query      string              `json:"query"`
+	NumResults int                 `json:"num_results"`
+	Metadata   map[string]string   `json:"metadata"`
+	Filter     core.MetadataFilter `json:"filter"` // Tags, source, owner and date range
 }
 
 // Using utils.TrackerDocuments directly for consistency
@@ -109,6 +115,16 @@ func SetupHTTPServer(ctx context.Context, port string, dbConn *db.DatabaseConnec
 	// Create a router with the gorilla/mux package for more flexibility
 	router := mux.NewRouter()
 
+	// Requests act as the host unless they carry the access token of a delegated role
+	var hostToken string
+	if params, err := utils.ParamsFromContext(ctx); err == nil && params.HTTPToken != nil {
+		hostToken = *params.HTTPToken
+	}
+	router.Use(RoleMiddleware(dbConn.DB, hostToken))
+
+	// Reject request bodies that break the validate tags of their type
+	router.Use(ValidationMiddleware)
+
 	// Add the policy enforcement middleware
 	router.Use(PolicyEnforcementMiddleware(dbConn))
 
@@ -142,6 +158,31 @@ func SetupHTTPServer(ctx context.Context, port string, dbConn *db.DatabaseConnec
 		HandleDeleteAPI(ctx, w, r)
 	}).Methods("DELETE")
 
+	router.HandleFunc("/api/apis/{id}/archive", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetAPIArchive(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/apis/{id}/keys/reveal", func(w http.ResponseWriter, r *http.Request) {
+		HandleRevealAPIKey(ctx, w, r)
+	}).Methods("POST")
+
+	router.HandleFunc("/api/apis/{id}/keys/reveals", func(w http.ResponseWriter, r *http.Request) {
+		HandleListAPIKeyReveals(ctx, w, r)
+	}).Methods("GET")
+
+	// API Metadata Schema Endpoints
+	router.HandleFunc("/api/api-metadata/fields", func(w http.ResponseWriter, r *http.Request) {
+		HandleListAPIMetadataFields(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/api-metadata/fields/{key}", func(w http.ResponseWriter, r *http.Request) {
+		HandleSetAPIMetadataField(ctx, w, r)
+	}).Methods("PUT")
+
+	router.HandleFunc("/api/api-metadata/fields/{key}", func(w http.ResponseWriter, r *http.Request) {
+		HandleDeleteAPIMetadataField(ctx, w, r)
+	}).Methods("DELETE")
+
 	// Policy Management Endpoints
 	router.HandleFunc("/api/policies", func(w http.ResponseWriter, r *http.Request) {
 		HandleListPolicies(ctx, w, r)
@@ -167,6 +208,40 @@ func SetupHTTPServer(ctx context.Context, port string, dbConn *db.DatabaseConnec
 		HandleDeletePolicy(ctx, w, r)
 	}).Methods("DELETE")
 
+	router.HandleFunc("/api/policies/{id}/pricing", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetCreditPricing(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/policies/{id}/pricing", func(w http.ResponseWriter, r *http.Request) {
+		HandleSetCreditPricing(ctx, w, r)
+	}).Methods("PUT")
+
+	// Credit Ledger Endpoints
+	router.HandleFunc(creditBalancePath, func(w http.ResponseWriter, r *http.Request) {
+		HandleGetOwnCreditBalance(ctx, w, r)
+	}).Methods("GET")
+
+	// Consumer downloads of the original files of documents
+	router.HandleFunc("/api/v1/documents/{filename}/url", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetDocumentURL(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc(core.LocalBlobPath+"{key:.+}", func(w http.ResponseWriter, r *http.Request) {
+		HandleDownloadBlob(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/credits/{user_id}", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetCreditBalance(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/credits/{user_id}/ledger", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetCreditLedger(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/credits/{user_id}/topup", func(w http.ResponseWriter, r *http.Request) {
+		HandleTopUpCredits(ctx, w, r)
+	}).Methods("POST")
+
 	router.HandleFunc("/api/apis/{id}/policy", func(w http.ResponseWriter, r *http.Request) {
 		HandleChangeAPIPolicy(ctx, w, r)
 	}).Methods("POST")
@@ -246,6 +321,128 @@ func SetupHTTPServer(ctx context.Context, port string, dbConn *db.DatabaseConnec
 		HandlePermanentDeleteDocument(ctx, w, r)
 	}).Methods("DELETE")
 
+	// Data Residency Endpoints
+	router.HandleFunc("/api/residency/collections", func(w http.ResponseWriter, r *http.Request) {
+		HandleListCollectionResidency(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/residency/collections/{name}", func(w http.ResponseWriter, r *http.Request) {
+		HandleSetCollectionResidency(ctx, w, r)
+	}).Methods("PUT")
+
+	router.HandleFunc("/api/residency/collections/{name}", func(w http.ResponseWriter, r *http.Request) {
+		HandleDeleteCollectionResidency(ctx, w, r)
+	}).Methods("DELETE")
+
+	router.HandleFunc("/api/residency/consumers/{user_id}", func(w http.ResponseWriter, r *http.Request) {
+		HandleSetConsumerRegion(ctx, w, r)
+	}).Methods("PUT")
+
+	router.HandleFunc("/api/residency/export-check", func(w http.ResponseWriter, r *http.Request) {
+		HandleCheckExportDestination(ctx, w, r)
+	}).Methods("POST")
+
+	router.HandleFunc("/api/residency/overrides", func(w http.ResponseWriter, r *http.Request) {
+		HandleListResidencyOverrides(ctx, w, r)
+	}).Methods("GET")
+
+	// Maintenance Endpoints
+	router.HandleFunc("/api/maintenance/consistency", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetConsistencyReport(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/maintenance/consistency/repair", func(w http.ResponseWriter, r *http.Request) {
+		HandleRepairConsistency(ctx, w, r)
+	}).Methods("POST")
+
+	router.HandleFunc("/api/maintenance/clock", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetClockStatus(ctx, w, r)
+	}).Methods("GET")
+
+	// Insights Endpoints
+	router.HandleFunc("/api/insights/gaps", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetKnowledgeGaps(ctx, w, r)
+	}).Methods("GET")
+
+	// LLM Cache Endpoints
+	router.HandleFunc("/api/llm/cache/stats", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetLLMCacheStats(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/llm/cache", func(w http.ResponseWriter, r *http.Request) {
+		HandleClearLLMCache(ctx, w, r)
+	}).Methods("DELETE")
+
+	// RAG Sources Endpoints
+	router.HandleFunc("/api/rag/sources", func(w http.ResponseWriter, r *http.Request) {
+		HandleListRagSources(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/rag/sources", func(w http.ResponseWriter, r *http.Request) {
+		HandleAddRagSource(ctx, w, r)
+	}).Methods("POST")
+
+	router.HandleFunc("/api/rag/sources", func(w http.ResponseWriter, r *http.Request) {
+		HandleUpdateRagSource(ctx, w, r)
+	}).Methods("PUT")
+
+	router.HandleFunc("/api/rag/sources", func(w http.ResponseWriter, r *http.Request) {
+		HandleRemoveRagSource(ctx, w, r)
+	}).Methods("DELETE")
+
+	router.HandleFunc("/api/rag/sources/reingest", func(w http.ResponseWriter, r *http.Request) {
+		HandleReingestRagSource(ctx, w, r)
+	}).Methods("POST")
+
+	// Telemetry Endpoints
+	router.HandleFunc("/api/telemetry", func(w http.ResponseWriter, r *http.Request) {
+		HandleGetTelemetry(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/telemetry/consent", func(w http.ResponseWriter, r *http.Request) {
+		HandleUpdateTelemetryConsent(ctx, w, r)
+	}).Methods("PUT")
+
+	router.HandleFunc("/api/telemetry/export", func(w http.ResponseWriter, r *http.Request) {
+		HandleExportTelemetry(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/telemetry/send", func(w http.ResponseWriter, r *http.Request) {
+		HandleSendTelemetry(ctx, w, r)
+	}).Methods("POST")
+
+	router.HandleFunc("/api/telemetry", func(w http.ResponseWriter, r *http.Request) {
+		HandlePurgeTelemetry(ctx, w, r)
+	}).Methods("DELETE")
+
+	// Role Endpoints
+	router.HandleFunc("/api/roles", func(w http.ResponseWriter, r *http.Request) {
+		HandleListRoles(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/roles/me", HandleGetOwnRole).Methods("GET")
+
+	router.HandleFunc("/api/roles/{user_id}", func(w http.ResponseWriter, r *http.Request) {
+		HandleAssignRole(ctx, w, r)
+	}).Methods("PUT")
+
+	router.HandleFunc("/api/roles/{user_id}", func(w http.ResponseWriter, r *http.Request) {
+		HandleRevokeRole(ctx, w, r)
+	}).Methods("DELETE")
+
+	// Query Review Endpoints, also open to curators
+	router.HandleFunc("/api/queries", func(w http.ResponseWriter, r *http.Request) {
+		HandleListQueries(ctx, w, r)
+	}).Methods("GET")
+
+	router.HandleFunc("/api/queries/{id}", func(w http.ResponseWriter, r *http.Request) {
+		HandleDraftAnswer(ctx, w, r)
+	}).Methods("PATCH")
+
+	router.HandleFunc("/api/queries/{id}/review", func(w http.ResponseWriter, r *http.Request) {
+		HandleReviewQuery(ctx, w, r)
+	}).Methods("POST")
+
 	// GET /rag/count - Get the total number of documents in the vector database
 	router.HandleFunc("/rag/count", func(w http.ResponseWriter, r *http.Request) {
 		chromemCollection, err := utils.ChromemCollectionFromContext(ctx)
@@ -330,8 +527,14 @@ func SetupHTTPServer(ctx context.Context, port string, dbConn *db.DatabaseConnec
 			return
 		}
 
+		collectionCtx, err := core.UseCollection(ctx, req.Collection, false)
+		if err != nil {
+			sendErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
 		// Update (remove ‑ then add) the document.
-		if err := core.UpdateDocument(ctx, req.Filename, req.FileContent, req.Metadata); err != nil {
+		if err := core.UpdateDocument(collectionCtx, req.Filename, req.FileContent, req.Metadata); err != nil {
 			sendErrorResponse(w, "Failed to update document: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -355,6 +558,13 @@ func SetupHTTPServer(ctx context.Context, port string, dbConn *db.DatabaseConnec
 			return
 		}
 
+		if req.Metadata == nil {
+			req.Metadata = make(map[string]string)
+		}
+		if req.Metadata["source"] == "" {
+			req.Metadata["source"] = "http"
+		}
+
 		if err := core.AddDocument(ctx, req.Filename, req.FileContent, true, req.Metadata); err != nil {
 			sendErrorResponse(w, "Failed to add document: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -398,7 +608,7 @@ func SetupHTTPServer(ctx context.Context, port string, dbConn *db.DatabaseConnec
 				req.Query, req.NumResults, req.Metadata)
 
 			// Retrieve documents with metadata filter
-			docs, err := core.RetrieveDocuments(ctx, req.Query, req.NumResults, req.Metadata)
+			docs, err := core.RetrieveDocuments(core.WithMetadataFilter(ctx, req.Filter), req.Query, req.NumResults, req.Metadata)
 			if err != nil {
 				log.Printf("[HTTP] Error retrieving documents: %v", err)
 
@@ -442,9 +652,15 @@ func SetupHTTPServer(ctx context.Context, port string, dbConn *db.DatabaseConnec
 			// Create an empty metadata map for the URL parameter version
 			metadata := make(map[string]string)
 
+			filter, err := metadataFilterFromQuery(r.URL.Query())
+			if err != nil {
+				sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
 			log.Printf("[HTTP] Processing URL-based RAG query: '%s' with numResults: %d", query, numResults)
 
-			docs, err := core.RetrieveDocuments(ctx, query, numResults, metadata)
+			docs, err := core.RetrieveDocuments(core.WithMetadataFilter(ctx, filter), query, numResults, metadata)
 			if err != nil {
 				log.Printf("[HTTP] Error retrieving documents with URL parameters: %v", err)
 
@@ -469,15 +685,29 @@ func SetupHTTPServer(ctx context.Context, port string, dbConn *db.DatabaseConnec
 		}
 	}).Methods("GET")
 
-	// DELETE /rag - Remove document from vector database
+	// DELETE /rag - Mark a document as deleted, or with permanent=true remove it from the vector
+	// database along with its document associations
 	router.HandleFunc("/rag", func(w http.ResponseWriter, r *http.Request) {
 		filename := r.URL.Query().Get("filename")
 		if filename == "" {
 			sendErrorResponse(w, "Filename parameter is required", http.StatusBadRequest)
 			return
 		}
+		collectionCtx, err := core.UseCollection(ctx, r.URL.Query().Get("collection"), false)
+		if err != nil {
+			sendErrorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
 
-		if err := core.RemoveDocument(ctx, filename); err != nil {
+		remove := core.DeleteDocument
+		if permanent, _ := strconv.ParseBool(r.URL.Query().Get("permanent")); permanent {
+			remove = core.PurgeDocument
+		}
+		if err := remove(collectionCtx, filename); err != nil {
+			if errors.Is(err, core.ErrDocumentNotFound) {
+				sendErrorResponse(w, err.Error(), http.StatusNotFound)
+				return
+			}
 			sendErrorResponse(w, "Failed to remove document: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -675,3 +905,27 @@ func sendErrorResponse(w http.ResponseWriter, message string, statusCode int) {
 	w.WriteHeader(statusCode)
 	json.NewEncoder(w).Encode(ErrorResponse{Error: message})
 }
+
+// metadataFilterFromQuery reads the tags, source, owner, after and before query parameters.
+// Dates are RFC 3339 timestamps or YYYY-MM-DD.
+func metadataFilterFromQuery(values url.Values) (core.MetadataFilter, error) {
+	filter := core.MetadataFilter{
+		Tags:   core.ParseTags(values.Get("tags")),
+		Source: values.Get("source"),
+		Owner:  values.Get("owner"),
+	}
+	for name, target := range map[string]**time.Time{"after": &filter.After, "before": &filter.Before} {
+		value := values.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			if t, err = time.Parse("2006-01-02", value); err != nil {
+				return filter, fmt.Errorf("invalid %s parameter: use RFC 3339 or YYYY-MM-DD", name)
+			}
+		}
+		*target = &t
+	}
+	return filter, nil
+}
@@ -0,0 +1,28 @@
+package http
+
+import (
+	"context"
+	"dk/core"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// HandleGetKnowledgeGaps returns the latest knowledge gap report: the rejected and unanswered
+// incoming queries grouped by topic, with documents suggested to answer them. The analysis is
+// run if none has run yet or when refresh=true is given.
+func HandleGetKnowledgeGaps(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	report := core.LastKnowledgeGapReport()
+	if report == nil || r.URL.Query().Get("refresh") == "true" {
+		var err error
+		report, err = core.AnalyzeKnowledgeGaps(ctx)
+		if err != nil {
+			log.Printf("[HTTP] Knowledge gap analysis failed: %v", err)
+			sendErrorResponse(w, "Failed to analyze knowledge gaps: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
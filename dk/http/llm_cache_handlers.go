@@ -0,0 +1,48 @@
+package http
+
+import (
+	"context"
+	"dk/core"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// HandleGetLLMCacheStats returns the hit metrics and size of the LLM response cache
+func HandleGetLLMCacheStats(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	cache := core.LLMCacheFromContext(ctx)
+	if cache == nil {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(core.LLMCacheStats{})
+		return
+	}
+
+	stats, err := cache.Stats(ctx)
+	if err != nil {
+		log.Printf("[HTTP] Failed to get LLM cache stats: %v", err)
+		sendErrorResponse(w, "Failed to get LLM cache stats: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// HandleClearLLMCache removes every cached completion
+func HandleClearLLMCache(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	cache := core.LLMCacheFromContext(ctx)
+	if cache == nil {
+		sendErrorResponse(w, "LLM cache is not enabled", http.StatusNotFound)
+		return
+	}
+
+	removed, err := cache.Clear(ctx)
+	if err != nil {
+		log.Printf("[HTTP] Failed to clear LLM cache: %v", err)
+		sendErrorResponse(w, "Failed to clear LLM cache: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"removed": removed})
+}
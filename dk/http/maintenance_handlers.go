@@ -0,0 +1,73 @@
+package http
+
+import (
+	"context"
+	"dk/core"
+	"dk/utils"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ClockResponse is the body of GET /api/maintenance/clock
+type ClockResponse struct {
+	LocalTime       time.Time `json:"local_time"`
+	ServerTime      time.Time `json:"server_time"` // Estimated from the skew measured at login
+	SkewMs          int64     `json:"skew_ms"`     // Server clock minus local clock
+	ToleranceMs     int64     `json:"tolerance_ms"`
+	MeasuredAt      time.Time `json:"measured_at"`
+	WithinTolerance bool      `json:"within_tolerance"`
+}
+
+// HandleGetConsistencyReport returns the result of the latest document consistency check.
+// A fresh dry-run check is performed if none has run yet or when refresh=true is given.
+func HandleGetConsistencyReport(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	report := core.LastConsistencyReport()
+	if report == nil || r.URL.Query().Get("refresh") == "true" {
+		var err error
+		report, err = core.CheckDocumentConsistency(ctx, false)
+		if err != nil {
+			log.Printf("[HTTP] Document consistency check failed: %v", err)
+			sendErrorResponse(w, "Failed to check document consistency: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// HandleRepairConsistency runs a document consistency check and deletes orphaned associations
+func HandleRepairConsistency(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	report, err := core.CheckDocumentConsistency(ctx, true)
+	if err != nil {
+		log.Printf("[HTTP] Document consistency repair failed: %v", err)
+		sendErrorResponse(w, "Failed to repair document consistency: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// HandleGetClockStatus reports the skew between the local clock and the server clock. Message
+// timestamps further ahead of the server clock than the tolerance are rejected by peers.
+func HandleGetClockStatus(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	dkClient, err := utils.DkFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Client not available", http.StatusInternalServerError)
+		return
+	}
+
+	status := dkClient.ClockStatus()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ClockResponse{
+		LocalTime:       time.Now().UTC(),
+		ServerTime:      dkClient.Now(),
+		SkewMs:          status.Skew.Milliseconds(),
+		ToleranceMs:     status.Tolerance.Milliseconds(),
+		MeasuredAt:      status.MeasuredAt,
+		WithinTolerance: status.WithinTolerance,
+	})
+}
@@ -25,12 +25,15 @@ type UsageMetrics struct {
 	WasBlocked      bool
 }
 
+// policyDecisionHeader tells consumers, and load tests, that a request was throttled or blocked
+const policyDecisionHeader = "X-Policy-Decision"
+
 // PolicyEnforcementMiddleware creates middleware for tracking usage and enforcing policies
 func PolicyEnforcementMiddleware(dbConn *db.DatabaseConnection) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Only apply to API endpoints
-			if !strings.HasPrefix(r.URL.Path, "/api/v1/") {
+			// Only apply to API endpoints; consumers can always look up their credit balance
+			if !strings.HasPrefix(r.URL.Path, "/api/v1/") || r.URL.Path == creditBalancePath {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -94,7 +97,7 @@ func PolicyEnforcementMiddleware(dbConn *db.DatabaseConnection) func(http.Handle
 			// 3. Check policy rules before processing
 			if shouldEnforcePolicy {
 				// Get current usage summaries
-				now := time.Now()
+				now := time.Now().UTC()
 				// For simplicity, we'll just check daily usage
 				startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 				endOfDay := startOfDay.Add(24 * time.Hour).Add(-time.Second)
@@ -109,6 +112,15 @@ func PolicyEnforcementMiddleware(dbConn *db.DatabaseConnection) func(http.Handle
 				for _, rule := range policy.Rules {
 					switch rule.Action {
 					case "block":
+						// Credit rules also block once the consumer's balance is used up
+						if rule.RuleType == "credit" && creditBalanceExhausted(dbConn.DB, userID) {
+							recordBlockedRequest(dbConn.DB, apiID, userID, r.URL.Path)
+							createQuotaNotification(dbConn.DB, apiID, userID, rule, 100.0, "limit_reached")
+							w.Header().Set(policyDecisionHeader, "block")
+							http.Error(w, "Insufficient credits", http.StatusPaymentRequired)
+							return
+						}
+
 						// Check if limit is exceeded
 						if isLimitExceeded(rule, usage) {
 							// Record blocked request
@@ -118,6 +130,7 @@ func PolicyEnforcementMiddleware(dbConn *db.DatabaseConnection) func(http.Handle
 							createQuotaNotification(dbConn.DB, apiID, userID, rule, 100.0, "limit_reached")
 
 							// Return 429 status code
+							w.Header().Set(policyDecisionHeader, "block")
 							http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 							return
 						}
@@ -129,6 +142,8 @@ func PolicyEnforcementMiddleware(dbConn *db.DatabaseConnection) func(http.Handle
 
 							// Record that we throttled
 							recordThrottledRequest(dbConn.DB, apiID, userID, r.URL.Path)
+							rw.isThrottled = true
+							w.Header().Set(policyDecisionHeader, "throttle")
 
 							// Create notification
 							createQuotaNotification(dbConn.DB, apiID, userID, rule, 100.0, "limit_reached")
@@ -160,7 +175,6 @@ func PolicyEnforcementMiddleware(dbConn *db.DatabaseConnection) func(http.Handle
 				ExternalUserID:  userID,
 				RequestCount:    1,
 				TokensUsed:      estimatedTokens,
-				CreditsConsumed: float64(estimatedTokens) * 0.001, // Example credit calculation
 				ExecutionTimeMs: int(duration.Milliseconds()),
 				Endpoint:        r.URL.Path,
 				WasThrottled:    rw.isThrottled,
@@ -262,6 +276,16 @@ func recordThrottledRequest(dbConn *sql.DB, apiID, userID, endpoint string) {
 	// The WasThrottled flag should be set in the responseWriter
 }
 
+// creditBalanceExhausted reports whether a consumer has no credits left
+func creditBalanceExhausted(dbConn *sql.DB, userID string) bool {
+	balance, err := db.GetCreditBalance(dbConn, userID)
+	if err != nil {
+		fmt.Printf("Error getting credit balance: %v\n", err)
+		return false
+	}
+	return balance.Balance <= 0
+}
+
 // recordUsage records API usage metrics
 func recordUsage(dbConn *db.DatabaseConnection, metrics *UsageMetrics) {
 	usage := &db.APIUsage{
@@ -278,6 +302,11 @@ func recordUsage(dbConn *db.DatabaseConnection, metrics *UsageMetrics) {
 		WasBlocked:      metrics.WasBlocked,
 	}
 
+	// Price the usage and debit the consumer's credits under credit based policies
+	if err := db.ChargeAPIUsage(dbConn.DB, usage); err != nil {
+		fmt.Printf("Error charging API usage: %v\n", err)
+	}
+
 	// Record raw usage
 	err := db.RecordAPIUsage(dbConn.DB, usage)
 	if err != nil {
@@ -286,7 +315,7 @@ func recordUsage(dbConn *db.DatabaseConnection, metrics *UsageMetrics) {
 	}
 
 	// Update daily summary
-	now := time.Now()
+	now := time.Now().UTC()
 	startOfDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 	endOfDay := startOfDay.Add(24 * time.Hour).Add(-time.Second)
 
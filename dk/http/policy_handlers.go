@@ -200,104 +200,21 @@ func HandleCreatePolicy(ctx context.Context, w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	// Validate request
-	if req.Name == "" {
-		sendErrorResponse(w, "Policy name is required", http.StatusBadRequest)
-		return
-	}
-
-	if req.Type == "" {
-		sendErrorResponse(w, "Policy type is required", http.StatusBadRequest)
-		return
-	}
-
-	// Validate policy type
-	validTypes := map[string]bool{
-		"free":      true,
-		"rate":      true,
-		"token":     true,
-		"time":      true,
-		"credit":    true,
-		"composite": true,
-	}
-
-	if !validTypes[req.Type] {
-		sendErrorResponse(w, "Invalid policy type. Must be one of: free, rate, token, time, credit, composite", http.StatusBadRequest)
-		return
+	// Validate request; the MCP policy tools apply the same checks
+	rules := make([]*db.PolicyRule, 0, len(req.Rules))
+	for _, ruleReq := range req.Rules {
+		rules = append(rules, &db.PolicyRule{
+			RuleType:   ruleReq.RuleType,
+			LimitValue: ruleReq.LimitValue,
+			Period:     ruleReq.Period,
+			Action:     ruleReq.Action,
+		})
 	}
-
-	// Validate rules based on policy type
-	if req.Type != "free" && len(req.Rules) == 0 {
-		sendErrorResponse(w, "Rules are required for non-free policies", http.StatusBadRequest)
+	if err := db.ValidatePolicy(req.Name, req.Type, rules); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// For non-composite policies, ensure rule types match policy type
-	if req.Type != "free" && req.Type != "composite" {
-		for _, rule := range req.Rules {
-			if rule.RuleType != req.Type {
-				sendErrorResponse(w, fmt.Sprintf("Rule type '%s' doesn't match policy type '%s'", rule.RuleType, req.Type), http.StatusBadRequest)
-				return
-			}
-		}
-	}
-
-	// Validate each rule
-	for i, rule := range req.Rules {
-		if rule.RuleType == "" {
-			sendErrorResponse(w, fmt.Sprintf("Rule %d is missing rule_type", i+1), http.StatusBadRequest)
-			return
-		}
-
-		if rule.Action == "" {
-			sendErrorResponse(w, fmt.Sprintf("Rule %d is missing action", i+1), http.StatusBadRequest)
-			return
-		}
-
-		// Validate action
-		validActions := map[string]bool{
-			"block":    true,
-			"throttle": true,
-			"notify":   true,
-			"log":      true,
-		}
-
-		if !validActions[rule.Action] {
-			sendErrorResponse(w, fmt.Sprintf("Invalid action '%s' in rule %d. Must be one of: block, throttle, notify, log", rule.Action, i+1), http.StatusBadRequest)
-			return
-		}
-
-		// For non-free rules, limit value is required
-		if rule.RuleType != "free" && rule.LimitValue <= 0 {
-			sendErrorResponse(w, fmt.Sprintf("Rule %d must have a positive limit_value", i+1), http.StatusBadRequest)
-			return
-		}
-
-		// For time-based rules, period is required
-		needsPeriod := rule.RuleType == "rate" || rule.RuleType == "token" || rule.RuleType == "time" || rule.RuleType == "credit"
-		if needsPeriod && rule.Period == "" {
-			sendErrorResponse(w, fmt.Sprintf("Rule %d requires a period", i+1), http.StatusBadRequest)
-			return
-		}
-
-		// Validate period if provided
-		if rule.Period != "" {
-			validPeriods := map[string]bool{
-				"minute": true,
-				"hour":   true,
-				"day":    true,
-				"week":   true,
-				"month":  true,
-				"year":   true,
-			}
-
-			if !validPeriods[rule.Period] {
-				sendErrorResponse(w, fmt.Sprintf("Invalid period '%s' in rule %d. Must be one of: minute, hour, day, week, month, year", rule.Period, i+1), http.StatusBadRequest)
-				return
-			}
-		}
-	}
-
 	// Get database connection from context
 	database, err := utils.DBFromContext(ctx)
 	if err != nil {
@@ -577,19 +494,8 @@ func HandleChangeAPIPolicy(ctx context.Context, w http.ResponseWriter, r *http.R
 	}
 
 	// Validate request
-	if req.PolicyID == "" {
-		sendErrorResponse(w, "Policy ID is required", http.StatusBadRequest)
-		return
-	}
-
-	if !req.EffectiveImmediately && req.ScheduledDate == nil {
-		sendErrorResponse(w, "Either effective_immediately must be true or scheduled_date must be provided", http.StatusBadRequest)
-		return
-	}
-
-	// If scheduled date is provided, ensure it's in the future
-	if req.ScheduledDate != nil && req.ScheduledDate.Before(time.Now()) {
-		sendErrorResponse(w, "Scheduled date must be in the future", http.StatusBadRequest)
+	if err := db.ValidatePolicyChange(req.PolicyID, req.EffectiveImmediately, req.ScheduledDate); err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
@@ -829,8 +735,8 @@ func HandleGetAPIsByPolicy(ctx context.Context, w http.ResponseWriter, r *http.R
 		return
 	}
 
-	// Get all APIs that use this policy
-	apis, total, err := db.ListAPIsByPolicy(database, policyID, limit, offset, sort, order)
+	// Get all APIs that use this policy, with their counts
+	apis, total, err := db.ListAPISummariesByPolicy(database, policyID, limit, offset, sort, order)
 	if err != nil {
 		sendErrorResponse(w, "Failed to retrieve APIs by policy: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -839,20 +745,6 @@ func HandleGetAPIsByPolicy(ctx context.Context, w http.ResponseWriter, r *http.R
 	// Convert to response format
 	apiList := make([]APIBasic, 0, len(apis))
 	for _, api := range apis {
-		// Get external user count
-		userCount, err := db.CountAPIExternalUsers(database, api.ID)
-		if err != nil {
-			sendErrorResponse(w, "Failed to count external users: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		// Get document count
-		docCount, err := db.CountAPIDocuments(database, api.ID)
-		if err != nil {
-			sendErrorResponse(w, "Failed to count documents: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-
 		apiBasic := APIBasic{
 			ID:                 api.ID,
 			Name:               api.Name,
@@ -861,8 +753,9 @@ func HandleGetAPIsByPolicy(ctx context.Context, w http.ResponseWriter, r *http.R
 			IsDeprecated:       api.IsDeprecated,
 			CreatedAt:          api.CreatedAt,
 			UpdatedAt:          api.UpdatedAt,
-			ExternalUsersCount: userCount,
-			DocumentsCount:     docCount,
+			ExternalUsersCount: api.ExternalUsersCount,
+			DocumentsCount:     api.DocumentsCount,
+			Metadata:           api.Metadata,
 		}
 
 		apiList = append(apiList, apiBasic)
@@ -0,0 +1,144 @@
+package http
+
+import (
+	"context"
+	"dk/core"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// HandleListRagSources returns the entries of the RAG sources file with their ingestion status
+func HandleListRagSources(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	watcher := core.SourceWatcherFromContext(ctx)
+	if watcher == nil {
+		sendErrorResponse(w, "RAG sources are not available", http.StatusNotFound)
+		return
+	}
+
+	sources, err := watcher.Sources()
+	if err != nil {
+		log.Printf("[HTTP] Failed to list RAG sources: %v", err)
+		sendErrorResponse(w, "Failed to list RAG sources: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sources)
+}
+
+// HandleAddRagSource adds an entry to the RAG sources file and ingests it
+func HandleAddRagSource(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	watcher := core.SourceWatcherFromContext(ctx)
+	if watcher == nil {
+		sendErrorResponse(w, "RAG sources are not available", http.StatusNotFound)
+		return
+	}
+
+	var source core.RagSource
+	if err := json.NewDecoder(r.Body).Decode(&source); err != nil {
+		sendErrorResponse(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status, err := watcher.AddSource(ctx, source)
+	if err != nil {
+		sendRagSourceError(w, "add", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(status)
+}
+
+// HandleUpdateRagSource replaces the entry named by the file and collection query parameters
+// and re-ingests it
+func HandleUpdateRagSource(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	watcher := core.SourceWatcherFromContext(ctx)
+	if watcher == nil {
+		sendErrorResponse(w, "RAG sources are not available", http.StatusNotFound)
+		return
+	}
+	file, collection := r.URL.Query().Get("file"), r.URL.Query().Get("collection")
+	if file == "" {
+		sendErrorResponse(w, "The file query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	var source core.RagSource
+	if err := json.NewDecoder(r.Body).Decode(&source); err != nil {
+		sendErrorResponse(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status, err := watcher.UpdateSource(ctx, collection, file, func(s *core.RagSource) { *s = source })
+	if err != nil {
+		sendRagSourceError(w, "update", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// HandleRemoveRagSource removes the entry named by the file and collection query parameters
+// and deletes its document from the vector database
+func HandleRemoveRagSource(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	watcher := core.SourceWatcherFromContext(ctx)
+	if watcher == nil {
+		sendErrorResponse(w, "RAG sources are not available", http.StatusNotFound)
+		return
+	}
+	file, collection := r.URL.Query().Get("file"), r.URL.Query().Get("collection")
+	if file == "" {
+		sendErrorResponse(w, "The file query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := watcher.RemoveSource(ctx, collection, file); err != nil {
+		sendRagSourceError(w, "remove", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleReingestRagSource embeds the entry named by the file and collection query parameters
+// again
+func HandleReingestRagSource(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	watcher := core.SourceWatcherFromContext(ctx)
+	if watcher == nil {
+		sendErrorResponse(w, "RAG sources are not available", http.StatusNotFound)
+		return
+	}
+	file, collection := r.URL.Query().Get("file"), r.URL.Query().Get("collection")
+	if file == "" {
+		sendErrorResponse(w, "The file query parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	status, err := watcher.Reingest(ctx, collection, file)
+	if err != nil {
+		sendRagSourceError(w, "re-ingest", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// sendRagSourceError maps RAG source errors to HTTP status codes
+func sendRagSourceError(w http.ResponseWriter, action string, err error) {
+	switch {
+	case errors.Is(err, core.ErrInvalidRagSource):
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, core.ErrRagSourceNotFound):
+		sendErrorResponse(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, core.ErrRagSourceExists):
+		sendErrorResponse(w, err.Error(), http.StatusConflict)
+	default:
+		log.Printf("[HTTP] Failed to %s RAG source: %v", action, err)
+		sendErrorResponse(w, "Failed to "+action+" RAG source: "+err.Error(), http.StatusInternalServerError)
+	}
+}
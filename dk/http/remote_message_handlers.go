@@ -38,14 +38,8 @@ func HandleSendRemoteMessage(ctx context.Context, w http.ResponseWriter, r *http
 		return
 	}
 
-	// Create the remote message
-	query := utils.RemoteMessage{
-		Type:    "query",
-		Message: req.Question,
-	}
-
-	// Marshal the query to JSON
-	jsonData, err := json.Marshal(query)
+	// Encode the query
+	content, err := utils.EncodePayload(&utils.QueryPayload{Question: req.Question})
 	if err != nil {
 		sendErrorResponse(w, "Failed to marshal query: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -54,7 +48,7 @@ func HandleSendRemoteMessage(ctx context.Context, w http.ResponseWriter, r *http
 	// Send the message based on whether peers were specified
 	if len(req.Peers) == 0 {
 		// Broadcast message to all peers
-		err = dkClient.BroadcastMessage(string(jsonData))
+		err = dkClient.BroadcastMessage(content)
 		if err != nil {
 			sendErrorResponse(w, "Failed to broadcast message: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -65,7 +59,7 @@ func HandleSendRemoteMessage(ctx context.Context, w http.ResponseWriter, r *http
 			err = dkClient.SendMessage(dk_client.Message{
 				From:      dkClient.UserID,
 				To:        peer,
-				Content:   string(jsonData),
+				Content:   content,
 				Timestamp: time.Now(),
 			})
 			if err != nil {
@@ -0,0 +1,330 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"dk/core"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// currentCollectionName returns the name of the chromem collection in use
+func currentCollectionName(ctx context.Context) string {
+	collection, err := utils.ChromemCollectionFromContext(ctx)
+	if err != nil || collection == nil {
+		return ""
+	}
+	return collection.Name
+}
+
+// checkAPIResidency verifies that associating a document of the current collection with an API
+// does not expose it to consumers flagged outside the collection's region. When a violation is
+// found and an override with a reason was supplied, the override is audited and nil is returned.
+func checkAPIResidency(ctx context.Context, database *sql.DB, apiID, filename string, override bool, reason string) (*ResidencyViolationResponse, error) {
+	collectionName := currentCollectionName(ctx)
+	residency, err := core.CollectionResidency(database, collectionName)
+	if err != nil || residency == nil {
+		return nil, err
+	}
+
+	violations, err := db.FindAPIResidencyViolations(database, apiID, residency.Region)
+	if err != nil {
+		return nil, err
+	}
+	if len(violations) == 0 {
+		return nil, nil
+	}
+
+	if override && strings.TrimSpace(reason) != "" {
+		regions := make([]string, 0, len(violations))
+		for _, v := range violations {
+			regions = append(regions, v.Region)
+		}
+		return nil, core.AuditResidencyOverride(ctx, database, &db.ResidencyOverride{
+			CollectionName:   collectionName,
+			RequiredRegion:   residency.Region,
+			DocumentFilename: filename,
+			TargetType:       "api",
+			TargetID:         apiID,
+			TargetRegion:     strings.Join(regions, ","),
+			Reason:           reason,
+		})
+	}
+
+	return &ResidencyViolationResponse{
+		Error:          "Document collection is restricted to region " + residency.Region + "; API has consumers outside that region",
+		Collection:     collectionName,
+		RequiredRegion: residency.Region,
+		Violations:     violations,
+	}, nil
+}
+
+// checkConsumerResidency verifies that granting a consumer access to an API does not expose
+// residency-restricted documents outside their region
+func checkConsumerResidency(ctx context.Context, database *sql.DB, apiID, userID string, override bool, reason string) (*ResidencyViolationResponse, error) {
+	if !override {
+		reason = ""
+	}
+	violation, err := core.CheckConsumerResidency(ctx, database, apiID, userID, reason)
+	if err != nil || violation == nil {
+		return nil, err
+	}
+	return &ResidencyViolationResponse{
+		Error:          violation.String(),
+		Collection:     violation.Collection,
+		RequiredRegion: violation.RequiredRegion,
+		Violations: []db.ResidencyViolation{{
+			ExternalUserID: violation.Consumer,
+			Region:         violation.ConsumerRegion,
+			RequiredRegion: violation.RequiredRegion,
+		}},
+	}, nil
+}
+
+// sendResidencyViolation writes a residency violation as a 403 response
+func sendResidencyViolation(w http.ResponseWriter, violation *ResidencyViolationResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(violation)
+}
+
+// HandleListCollectionResidency handles GET /api/residency/collections
+func HandleListCollectionResidency(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	residencies, err := db.ListCollectionResidencies(database)
+	if err != nil {
+		sendErrorResponse(w, "Failed to list collection residency: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(residencies)
+}
+
+// HandleSetCollectionResidency handles PUT /api/residency/collections/:name
+func HandleSetCollectionResidency(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collectionName := mux.Vars(r)["name"]
+	if collectionName == "" {
+		sendErrorResponse(w, "Collection name is required", http.StatusBadRequest)
+		return
+	}
+
+	var req CollectionResidencyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Region) == "" {
+		sendErrorResponse(w, "Region is required", http.StatusBadRequest)
+		return
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := utils.UserIDFromContext(ctx)
+	if err != nil {
+		// For development/testing - in production, should return an error
+		userID = "local-user"
+	}
+
+	residency := &db.CollectionResidency{
+		CollectionName: collectionName,
+		Region:         req.Region,
+		UpdatedAt:      time.Now(),
+		UpdatedBy:      userID,
+	}
+
+	if err := db.SetCollectionResidency(database, residency); err != nil {
+		sendErrorResponse(w, "Failed to set collection residency: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(residency)
+}
+
+// HandleDeleteCollectionResidency handles DELETE /api/residency/collections/:name
+func HandleDeleteCollectionResidency(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	collectionName := mux.Vars(r)["name"]
+	if collectionName == "" {
+		sendErrorResponse(w, "Collection name is required", http.StatusBadRequest)
+		return
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	if err := db.DeleteCollectionResidency(database, collectionName); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			sendErrorResponse(w, "Collection has no residency constraint", http.StatusNotFound)
+		} else {
+			sendErrorResponse(w, "Failed to delete collection residency: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleSetConsumerRegion handles PUT /api/residency/consumers/:user_id
+func HandleSetConsumerRegion(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["user_id"]
+	if userID == "" {
+		sendErrorResponse(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	var req ConsumerRegionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(req.Region) == "" {
+		sendErrorResponse(w, "Region is required", http.StatusBadRequest)
+		return
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	consumer := &db.ConsumerRegion{
+		ExternalUserID: userID,
+		Region:         req.Region,
+		UpdatedAt:      time.Now(),
+	}
+
+	if err := db.SetConsumerRegion(database, consumer); err != nil {
+		sendErrorResponse(w, "Failed to set consumer region: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(consumer)
+}
+
+// HandleCheckExportDestination handles POST /api/residency/export-check.
+// It lets external export and backup tooling check a destination before writing collection
+// data to it; the writers of the node, such as the blob store, enforce the check themselves.
+func HandleCheckExportDestination(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var req ExportCheckRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Destination == "" {
+		sendErrorResponse(w, "Destination is required", http.StatusBadRequest)
+		return
+	}
+
+	if req.Collection == "" {
+		req.Collection = currentCollectionName(ctx)
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	residency, err := core.CollectionResidency(database, req.Collection)
+	if err != nil {
+		sendErrorResponse(w, "Failed to check collection residency: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := ExportCheckResponse{Allowed: true, Collection: req.Collection}
+	if residency != nil {
+		response.RequiredRegion = residency.Region
+
+		overrideReason := ""
+		if req.OverrideResidency {
+			overrideReason = req.OverrideReason
+		}
+		override, err := core.CheckExportResidency(ctx, database, core.ExportDestination{
+			Collection: req.Collection,
+			Target:     req.Destination,
+			Region:     req.DestinationRegion,
+		}, overrideReason)
+		if errors.Is(err, core.ErrResidencyViolation) {
+			sendResidencyViolation(w, &ResidencyViolationResponse{
+				Error:          "Export destination does not satisfy residency constraint " + residency.Region,
+				Collection:     req.Collection,
+				RequiredRegion: residency.Region,
+			})
+			return
+		}
+		if err != nil {
+			sendErrorResponse(w, "Failed to record residency override: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if override != nil {
+			response.OverrideID = override.ID
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandleListResidencyOverrides handles GET /api/residency/overrides
+func HandleListResidencyOverrides(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	limit := 20 // default
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if val, err := strconv.Atoi(limitStr); err == nil && val > 0 {
+			limit = val
+		}
+	}
+
+	offset := 0 // default
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		if val, err := strconv.Atoi(offsetStr); err == nil && val >= 0 {
+			offset = val
+		}
+	}
+
+	database, err := utils.DBFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get database connection", http.StatusInternalServerError)
+		return
+	}
+
+	overrides, total, err := db.ListResidencyOverrides(database, limit, offset)
+	if err != nil {
+		sendErrorResponse(w, "Failed to list residency overrides: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ResidencyOverrideListResponse{
+		Total:     total,
+		Limit:     limit,
+		Offset:    offset,
+		Overrides: overrides,
+	})
+}
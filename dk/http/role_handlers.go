@@ -0,0 +1,225 @@
+package http
+
+import (
+	"context"
+	"database/sql"
+	"dk/core"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// curatorRoutes are the endpoints a curator may call, by method and route template
+var curatorRoutes = map[string]bool{
+	"GET /api/queries":              true,
+	"PATCH /api/queries/{id}":       true,
+	"POST /api/queries/{id}/review": true,
+	"GET /api/roles/me":             true,
+}
+
+// AssignRoleRequest is the body of PUT /api/roles/{user_id}
+type AssignRoleRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+// AssignRoleResponse carries the access token of a new role assignment. The token is not
+// stored and cannot be shown again.
+type AssignRoleResponse struct {
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+	Token  string `json:"token"`
+}
+
+// DraftAnswerRequest is the body of PATCH /api/queries/{id}
+type DraftAnswerRequest struct {
+	Answer string `json:"answer" validate:"required"`
+}
+
+// ReviewQueryRequest is the body of POST /api/queries/{id}/review
+type ReviewQueryRequest struct {
+	Approve bool `json:"approve"`
+}
+
+// RoleMiddleware authenticates requests and restricts delegated roles to their endpoints.
+// A request with "Authorization: Bearer <token>" acts with the role the token was issued for.
+// Other requests are made by the host; if hostToken is set they must present it instead.
+// Consumer endpoints under /api/v1/ are governed by API access policies and pass through.
+func RoleMiddleware(database *sql.DB, hostToken string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.HasPrefix(r.URL.Path, "/api/v1/") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			principal, err := core.AuthenticateBearer(r.Context(), database, r.Header.Get("Authorization"), hostToken)
+			if errors.Is(err, core.ErrInvalidRoleToken) || errors.Is(err, core.ErrAuthorizationRequired) {
+				sendErrorResponse(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				log.Printf("[HTTP] Failed to authenticate role token: %v", err)
+				sendErrorResponse(w, "Failed to authenticate", http.StatusInternalServerError)
+				return
+			}
+
+			if principal.Role != core.RoleHost {
+				template := ""
+				if route := mux.CurrentRoute(r); route != nil {
+					template, _ = route.GetPathTemplate()
+				}
+				if !curatorRoutes[r.Method+" "+template] {
+					sendErrorResponse(w, core.ErrForbidden.Error(), http.StatusForbidden)
+					return
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(core.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// HandleListRoles lists the roles the host delegated
+func HandleListRoles(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Database not available", http.StatusInternalServerError)
+		return
+	}
+	assignments, err := db.ListRoleAssignments(ctx, database)
+	if err != nil {
+		log.Printf("[HTTP] Failed to list roles: %v", err)
+		sendErrorResponse(w, "Failed to list roles: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]db.RoleAssignment{"roles": assignments})
+}
+
+// HandleGetOwnRole returns the principal of the request
+func HandleGetOwnRole(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(core.PrincipalFromContext(r.Context()))
+}
+
+// HandleAssignRole delegates a role to a user and returns the user's access token
+func HandleAssignRole(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	userID := mux.Vars(r)["user_id"]
+	var req AssignRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Database not available", http.StatusInternalServerError)
+		return
+	}
+	token, err := core.AssignRole(ctx, database, userID, req.Role)
+	if err != nil {
+		sendErrorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(AssignRoleResponse{UserID: userID, Role: req.Role, Token: token})
+}
+
+// HandleRevokeRole removes the role of a user, invalidating the user's access token
+func HandleRevokeRole(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Database not available", http.StatusInternalServerError)
+		return
+	}
+	err = db.RevokeRole(ctx, database, mux.Vars(r)["user_id"])
+	if errors.Is(err, sql.ErrNoRows) {
+		sendErrorResponse(w, "User has no role", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[HTTP] Failed to revoke role: %v", err)
+		sendErrorResponse(w, "Failed to revoke role: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListQueries lists incoming queries, optionally filtered by status and sender
+func HandleListQueries(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Database not available", http.StatusInternalServerError)
+		return
+	}
+	queries, err := db.ListQueries(ctx, database, r.URL.Query().Get("status"), r.URL.Query().Get("from"))
+	if err != nil {
+		log.Printf("[HTTP] Failed to list queries: %v", err)
+		sendErrorResponse(w, "Failed to list queries: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if queries == nil {
+		queries = []db.Query{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]db.Query{"queries": queries})
+}
+
+// HandleDraftAnswer replaces the drafted answer of a query
+func HandleDraftAnswer(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var req DraftAnswerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Answer) == "" {
+		sendErrorResponse(w, "An answer is required", http.StatusBadRequest)
+		return
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Database not available", http.StatusInternalServerError)
+		return
+	}
+	err = core.UpdateQueryAnswer(ctx, id, req.Answer)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendErrorResponse(w, "Query not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[HTTP] Failed to update answer: %v", err)
+		sendErrorResponse(w, "Failed to update answer: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	query, err := db.GetQuery(ctx, database, id)
+	if err != nil {
+		sendErrorResponse(w, "Failed to get query: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(query)
+}
+
+// HandleReviewQuery accepts, sending the answer to the peer that asked, or rejects a query
+func HandleReviewQuery(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var req ReviewQueryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx = core.WithPrincipal(ctx, core.PrincipalFromContext(r.Context()))
+	query, err := core.ReviewQuery(ctx, mux.Vars(r)["id"], req.Approve)
+	if errors.Is(err, sql.ErrNoRows) {
+		sendErrorResponse(w, "Query not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("[HTTP] Failed to review query: %v", err)
+		sendErrorResponse(w, "Failed to review query: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(query)
+}
@@ -0,0 +1,104 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRoleMiddleware(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := utils.WithDatabase(context.Background(), testDB.DB)
+	if err := db.InsertQuery(ctx, testDB.DB, db.Query{ID: "qry-1", From: "bob", Question: "What is DK?", Status: "pending"}); err != nil {
+		t.Fatalf("InsertQuery failed: %v", err)
+	}
+
+	newRouter := func(hostToken string) *mux.Router {
+		router := mux.NewRouter()
+		router.Use(RoleMiddleware(testDB.DB, hostToken))
+		router.HandleFunc("/api/roles/{user_id}", func(w http.ResponseWriter, r *http.Request) {
+			HandleAssignRole(ctx, w, r)
+		}).Methods("PUT")
+		router.HandleFunc("/api/roles/{user_id}", func(w http.ResponseWriter, r *http.Request) {
+			HandleRevokeRole(ctx, w, r)
+		}).Methods("DELETE")
+		router.HandleFunc("/api/queries/{id}", func(w http.ResponseWriter, r *http.Request) {
+			HandleDraftAnswer(ctx, w, r)
+		}).Methods("PATCH")
+		router.HandleFunc("/api/policies", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}).Methods("GET")
+		return router
+	}
+	do := func(router *mux.Router, method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+	router := newRouter("")
+
+	// Without a token the host may assign roles
+	rr := do(router, "PUT", "/api/roles/carol", "", `{"role": "curator"}`)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected 201 assigning the curator role, got %d: %s", rr.Code, rr.Body)
+	}
+	var assigned AssignRoleResponse
+	json.NewDecoder(rr.Body).Decode(&assigned)
+	if assigned.Token == "" {
+		t.Fatal("Expected an access token")
+	}
+	if rr := do(router, "PUT", "/api/roles/dave", "", `{"role": "host"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected the host role to be refused, got %d", rr.Code)
+	}
+
+	// The curator can draft answers but not reach host endpoints
+	if rr := do(router, "PATCH", "/api/queries/qry-1", assigned.Token, `{"answer": "A network"}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected the curator to draft an answer, got %d: %s", rr.Code, rr.Body)
+	}
+	if q, _ := db.GetQuery(ctx, testDB.DB, "qry-1"); q.Answer != "A network" {
+		t.Errorf("Expected the drafted answer to be stored, got %q", q.Answer)
+	}
+	for _, req := range [][2]string{{"GET", "/api/policies"}, {"PUT", "/api/roles/carol"}} {
+		if rr := do(router, req[0], req[1], assigned.Token, `{"role": "curator"}`); rr.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for %s %s as curator, got %d", req[0], req[1], rr.Code)
+		}
+	}
+	if rr := do(router, "GET", "/api/policies", "wrong", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unknown token, got %d", rr.Code)
+	}
+
+	// With a host token, requests must present it
+	hostRouter := newRouter("host-secret")
+	if rr := do(hostRouter, "GET", "/api/policies", "", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the host token, got %d", rr.Code)
+	}
+	if rr := do(hostRouter, "GET", "/api/policies", "host-secret", ""); rr.Code != http.StatusOK {
+		t.Errorf("Expected the host token to be accepted, got %d", rr.Code)
+	}
+
+	// Revoking the role invalidates the token
+	if rr := do(router, "DELETE", "/api/roles/carol", "", ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204 revoking the role, got %d", rr.Code)
+	}
+	if rr := do(router, "PATCH", "/api/queries/qry-1", assigned.Token, `{"answer": "Changed"}`); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 after revocation, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,152 @@
+package http
+
+import (
+	"context"
+	"dk/core"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// TelemetryStatus is the telemetry consent of each category and the report pending approval
+type TelemetryStatus struct {
+	Consent map[string]bool       `json:"consent"`
+	Pending core.TelemetryPreview `json:"pending"`
+}
+
+// HandleGetTelemetry returns the telemetry consent and exactly what would be reported
+func HandleGetTelemetry(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Database not available", http.StatusInternalServerError)
+		return
+	}
+
+	consent, err := db.GetTelemetryConsent(ctx, database)
+	if err != nil {
+		log.Printf("[HTTP] Failed to get telemetry consent: %v", err)
+		sendErrorResponse(w, "Failed to get telemetry consent: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := TelemetryStatus{Consent: make(map[string]bool)}
+	for _, category := range core.TelemetryCategories {
+		status.Consent[category] = consent[category]
+	}
+
+	status.Pending, err = core.TelemetryFromContext(ctx).Preview(ctx)
+	if err != nil {
+		log.Printf("[HTTP] Failed to build telemetry report: %v", err)
+		sendErrorResponse(w, "Failed to build telemetry report: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// HandleUpdateTelemetryConsent opts telemetry categories in or out. The body maps categories
+// to whether they are enabled; opting out deletes the counters of the category.
+func HandleUpdateTelemetryConsent(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var consent map[string]bool
+	if err := json.NewDecoder(r.Body).Decode(&consent); err != nil {
+		sendErrorResponse(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	for category := range consent {
+		if !core.IsTelemetryCategory(category) {
+			sendErrorResponse(w, "Unknown telemetry category: "+category, http.StatusBadRequest)
+			return
+		}
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Database not available", http.StatusInternalServerError)
+		return
+	}
+	for category, enabled := range consent {
+		if err := db.SetTelemetryConsent(ctx, database, category, enabled); err != nil {
+			log.Printf("[HTTP] Failed to update telemetry consent: %v", err)
+			sendErrorResponse(w, "Failed to update telemetry consent: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	HandleGetTelemetry(ctx, w, r)
+}
+
+// HandleExportTelemetry returns every collected counter, reported or not
+func HandleExportTelemetry(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Database not available", http.StatusInternalServerError)
+		return
+	}
+
+	counters, err := db.ListTelemetryCounters(ctx, database, "")
+	if err != nil {
+		log.Printf("[HTTP] Failed to export telemetry: %v", err)
+		sendErrorResponse(w, "Failed to export telemetry: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="telemetry.json"`)
+	json.NewEncoder(w).Encode(counters)
+}
+
+// HandlePurgeTelemetry deletes the collected counters, of one category if given
+func HandlePurgeTelemetry(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	category := r.URL.Query().Get("category")
+	if category != "" && !core.IsTelemetryCategory(category) {
+		sendErrorResponse(w, "Unknown telemetry category: "+category, http.StatusBadRequest)
+		return
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		sendErrorResponse(w, "Database not available", http.StatusInternalServerError)
+		return
+	}
+	removed, err := db.PurgeTelemetry(ctx, database, category)
+	if err != nil {
+		log.Printf("[HTTP] Failed to purge telemetry: %v", err)
+		sendErrorResponse(w, "Failed to purge telemetry: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"removed": removed})
+}
+
+// HandleSendTelemetry sends the pending report. The request must carry the digest of the
+// previewed report, so only a report the user has seen is ever sent.
+func HandleSendTelemetry(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Digest string `json:"digest"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Digest == "" {
+		sendErrorResponse(w, "The digest of the approved report is required", http.StatusBadRequest)
+		return
+	}
+
+	report, err := core.TelemetryFromContext(ctx).Send(ctx, req.Digest)
+	switch {
+	case errors.Is(err, core.ErrTelemetryNotConfigured):
+		sendErrorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, core.ErrTelemetryReportChanged):
+		sendErrorResponse(w, err.Error()+"; review the new report and approve it again", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("[HTTP] Failed to send telemetry: %v", err)
+		sendErrorResponse(w, "Failed to send telemetry: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"sent": report != nil, "report": report})
+}
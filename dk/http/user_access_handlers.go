@@ -212,6 +212,17 @@ func HandleGrantAPIAccess(ctx context.Context, w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	// Block grants that would expose residency-restricted documents outside their region
+	violation, err := checkConsumerResidency(ctx, database, apiID, req.UserID, req.OverrideResidency, req.OverrideReason)
+	if err != nil {
+		sendErrorResponse(w, "Failed to check data residency: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if violation != nil {
+		sendResidencyViolation(w, violation)
+		return
+	}
+
 	// Check if user already has access
 	existingAccess, err := db.GetAPIUserAccessByUserID(database, apiID, req.UserID)
 	if err != nil && !errors.Is(err, db.ErrNotFound) {
@@ -66,6 +66,20 @@ func setupTestDBAndContext(t *testing.T) (context.Context, *sql.DB) {
 		t.Fatalf("Failed to create api_user_access table: %v", err)
 	}
 
+	// Create the API metadata table
+	_, err = testDB.Exec(`
+		CREATE TABLE IF NOT EXISTS api_metadata (
+			api_id TEXT NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (api_id, key),
+			FOREIGN KEY (api_id) REFERENCES apis(id) ON DELETE CASCADE
+		)
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create api_metadata table: %v", err)
+	}
+
 	// Create a context with the database
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, "db", testDB) // Using string key instead of utils.DBContextKey
@@ -0,0 +1,259 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// FieldError describes one invalid field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// requestSchemas maps "METHOD /path/template" to the type of the request body the route
+// accepts. Bodies of these routes are checked against the `validate` tags of their type.
+var requestSchemas = map[string]func() any{
+	"POST /api/apis":                         func() any { return &CreateAPIRequest{} },
+	"PATCH /api/apis/{id}":                   func() any { return &UpdateAPIRequest{} },
+	"POST /api/apis/{id}/deprecate":          func() any { return &DeprecateAPIRequest{} },
+	"POST /api/apis/{id}/keys/reveal":        func() any { return &RevealAPIKeyRequest{} },
+	"POST /api/apis/{id}/policy":             func() any { return &ChangePolicyRequest{} },
+	"POST /api/apis/{id}/users":              func() any { return &APIUserAccessRequest{} },
+	"PATCH /api/apis/{id}/users/{user_id}":   func() any { return &APIUserAccessUpdateRequest{} },
+	"POST /api/policies":                     func() any { return &CreatePolicyRequest{} },
+	"PATCH /api/policies/{id}":               func() any { return &UpdatePolicyRequest{} },
+	"PUT /api/policies/{id}/pricing":         func() any { return &CreditPricingRequest{} },
+	"POST /api/credits/{user_id}/topup":      func() any { return &TopUpCreditsRequest{} },
+	"POST /api/requests":                     func() any { return &CreateAPIRequestRequest{} },
+	"PATCH /api/requests/{id}/status":        func() any { return &UpdateAPIRequestStatusRequest{} },
+	"POST /api/requests/{id}/resubmit":       func() any { return &ResubmitAPIRequestRequest{} },
+	"POST /api/documents/associate":          func() any { return &DocumentAssociateRequest{} },
+	"PUT /api/residency/collections/{name}":  func() any { return &CollectionResidencyRequest{} },
+	"PUT /api/residency/consumers/{user_id}": func() any { return &ConsumerRegionRequest{} },
+	"POST /api/residency/export-check":       func() any { return &ExportCheckRequest{} },
+	"PUT /api/roles/{user_id}":               func() any { return &AssignRoleRequest{} },
+	"POST /api/queries/{id}/review":          func() any { return &ReviewQueryRequest{} },
+	"PATCH /api/queries/{id}":                func() any { return &DraftAnswerRequest{} },
+}
+
+// ValidationMiddleware checks the JSON body of every /api route listed in requestSchemas
+// before its handler runs, and answers 400 with the list of invalid fields if any. Bodies
+// that are not valid JSON are left to the handler, which reports the decoding error.
+func ValidationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil || r.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		schema := requestSchemas[r.Method+" "+template]
+		if schema == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			sendErrorResponse(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		req := schema()
+		if err := json.Unmarshal(body, req); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if fields := Validate(req); len(fields) > 0 {
+			sendValidationErrors(w, fields)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sendValidationErrors answers 400 with the invalid fields of a request
+func sendValidationErrors(w http.ResponseWriter, fields []FieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body", Fields: fields})
+}
+
+// Validate checks a request against the `validate` tags of its fields and returns every
+// violation, named by the JSON path of the field. The tag is a comma-separated list of:
+//
+//	required              the field must not be empty
+//	required_if=F V       the field must not be empty when the field with JSON name F is V
+//	required_unless=F V   the field must not be empty unless the field F is V
+//	oneof=A B C           the value must be one of the listed words; empty values are skipped
+//	gt=N, min=N, max=N    numbers must be greater than, at least or at most N; strings
+//	                      and lists are measured by their length
+//	dive                  every element of a list of structs is validated too
+func Validate(v any) []FieldError {
+	var fields []FieldError
+	validateStruct(reflect.ValueOf(v), "", &fields)
+	return fields
+}
+
+func validateStruct(value reflect.Value, prefix string, fields *[]FieldError) {
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return
+	}
+
+	structType := value.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || !field.IsExported() {
+			continue
+		}
+		name := prefix + jsonName(field)
+		fieldValue := value.Field(i)
+		for _, rule := range strings.Split(tag, ",") {
+			key, arg, _ := strings.Cut(rule, "=")
+			if message := checkRule(key, arg, value, fieldValue); message != "" {
+				*fields = append(*fields, FieldError{Field: name, Message: message})
+				break
+			}
+			if key == "dive" && fieldValue.Kind() == reflect.Slice {
+				for j := 0; j < fieldValue.Len(); j++ {
+					validateStruct(fieldValue.Index(j), fmt.Sprintf("%s[%d].", name, j), fields)
+				}
+			}
+		}
+	}
+}
+
+// checkRule returns why value breaks the rule, or "" if it does not
+func checkRule(key, arg string, parent, value reflect.Value) string {
+	if key == "required" || key == "required_if" || key == "required_unless" {
+		if key != "required" {
+			other, want, _ := strings.Cut(arg, " ")
+			matches := fmt.Sprint(fieldByJSONName(parent, other)) == want
+			if matches != (key == "required_if") {
+				return ""
+			}
+		}
+		if isEmpty(value) {
+			return "is required"
+		}
+		return ""
+	}
+
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return ""
+		}
+		value = value.Elem()
+	}
+	switch key {
+	case "oneof":
+		if value.Kind() == reflect.String && value.String() != "" {
+			choices := strings.Fields(arg)
+			for _, choice := range choices {
+				if value.String() == choice {
+					return ""
+				}
+			}
+			return "must be one of: " + strings.Join(choices, ", ")
+		}
+	case "gt", "min", "max":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			return ""
+		}
+		n, unit := measure(value)
+		if unit != "" {
+			if arg != "1" {
+				unit += "s"
+			}
+			unit = " " + unit
+		}
+		switch {
+		case key == "gt" && n <= limit:
+			return "must be greater than " + arg + unit
+		case key == "min" && n < limit:
+			return "must be at least " + arg + unit
+		case key == "max" && n > limit:
+			return "must be at most " + arg + unit
+		}
+	}
+	return ""
+}
+
+// measure returns a number as is, and the length of a string or list with what it counts
+func measure(value reflect.Value) (float64, string) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), ""
+	case reflect.String:
+		return float64(len([]rune(value.String()))), "character"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(value.Len()), "item"
+	}
+	return 0, ""
+}
+
+// isEmpty reports whether a required field was left out
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	}
+	return value.IsZero()
+}
+
+// fieldByJSONName returns the value of the field of a struct with the given JSON name
+func fieldByJSONName(value reflect.Value, name string) any {
+	for i := 0; i < value.NumField(); i++ {
+		if jsonName(value.Type().Field(i)) == name {
+			field := value.Field(i)
+			if field.Kind() == reflect.Pointer {
+				if field.IsNil() {
+					return nil
+				}
+				field = field.Elem()
+			}
+			return field.Interface()
+		}
+	}
+	return nil
+}
+
+// jsonName returns the name a struct field has in JSON
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
@@ -0,0 +1,131 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestValidate(t *testing.T) {
+	name := ""
+	tests := []struct {
+		name string
+		req  any
+		want []FieldError
+	}{
+		{
+			name: "valid policy",
+			req: &CreatePolicyRequest{Name: "Hourly", Type: "rate", Rules: []PolicyRule{
+				{RuleType: "rate", LimitValue: 100, Period: "hour", Action: "block"},
+			}},
+		},
+		{
+			name: "free policy without rules",
+			req:  &CreatePolicyRequest{Name: "Free", Type: "free"},
+		},
+		{
+			name: "every invalid field is reported",
+			req:  &CreatePolicyRequest{Type: "unlimited"},
+			want: []FieldError{
+				{Field: "name", Message: "is required"},
+				{Field: "type", Message: "must be one of: free, rate, token, time, credit, composite"},
+				{Field: "rules", Message: "is required"},
+			},
+		},
+		{
+			name: "rules are validated",
+			req: &CreatePolicyRequest{Name: "Hourly", Type: "rate", Rules: []PolicyRule{
+				{RuleType: "rate", LimitValue: 100, Period: "hour", Action: "block"},
+				{RuleType: "rate", LimitValue: -1, Period: "fortnight"},
+			}},
+			want: []FieldError{
+				{Field: "rules[1].limit_value", Message: "must be at least 0"},
+				{Field: "rules[1].period", Message: "must be one of: minute, hour, day, week, month, year"},
+				{Field: "rules[1].action", Message: "is required"},
+			},
+		},
+		{
+			name: "required_if",
+			req:  &UpdateAPIRequestStatusRequest{Status: "denied"},
+			want: []FieldError{{Field: "denial_reason", Message: "is required"}},
+		},
+		{
+			name: "optional fields are only checked when given",
+			req:  &UpdateAPIRequest{},
+		},
+		{
+			name: "length of a given string",
+			req:  &UpdateAPIRequest{Name: &name},
+			want: []FieldError{{Field: "name", Message: "must be at least 1 character"}},
+		},
+		{
+			name: "gt",
+			req:  &TopUpCreditsRequest{Amount: 0},
+			want: []FieldError{{Field: "amount", Message: "must be greater than 0"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Validate(tt.req)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Validate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationMiddleware(t *testing.T) {
+	var handled string
+	router := mux.NewRouter()
+	router.Use(ValidationMiddleware)
+	router.HandleFunc("/api/apis/{id}/users", func(w http.ResponseWriter, r *http.Request) {
+		var req APIUserAccessRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		handled = req.UserID
+		w.WriteHeader(http.StatusCreated)
+	}).Methods("POST")
+
+	t.Run("invalid body is rejected", func(t *testing.T) {
+		handled = ""
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/apis/a1/users", strings.NewReader(`{"access_level":"owner"}`))
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status 400, got %d", rr.Code)
+		}
+		var resp ErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		want := []FieldError{
+			{Field: "user_id", Message: "is required"},
+			{Field: "access_level", Message: "must be one of: read, write, admin"},
+		}
+		if !reflect.DeepEqual(resp.Fields, want) {
+			t.Errorf("Expected fields %+v, got %+v", want, resp.Fields)
+		}
+		if handled != "" {
+			t.Error("Handler should not run for an invalid body")
+		}
+	})
+
+	t.Run("valid body reaches the handler", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/api/apis/a1/users", strings.NewReader(`{"user_id":"bob","access_level":"read"}`))
+		router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", rr.Code)
+		}
+		if handled != "bob" {
+			t.Errorf("Expected the handler to read the body, got user %q", handled)
+		}
+	})
+}
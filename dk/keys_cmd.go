@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"dk/utils"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const keysUsage = `Usage: dk -userId ID -key_store SOURCE [-private FILE -public FILE] keys <command>
+
+Manages the identity key kept in the key store given with -key_store.
+
+Commands:
+  import  Move the key of the -private and -public files into the key store. The files are
+          left in place; delete them once the node starts with the key store.
+  show    Print the public key of the key store
+`
+
+// runKeysCommand implements the "keys" subcommand and returns the exit code
+func runKeysCommand(params utils.Parameters, args []string) int {
+	if len(args) != 1 || *params.KeyStore == "" {
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+	source, err := utils.ParseKeySource(*params.KeyStore, *params.UserID)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "keys: %v\n", err)
+		return 2
+	}
+
+	switch args[0] {
+	case "import":
+		if _, err := os.Stat(*params.PrivateKeyPath); err != nil {
+			fmt.Fprintf(os.Stderr, "keys: %v\n", err)
+			return 1
+		}
+		publicKey, privateKey, err := utils.LoadOrCreateKeys(*params.PrivateKeyPath, *params.PublicKeyPath)
+		if err == nil && (len(privateKey) != ed25519.PrivateKeySize || !privateKey.Public().(ed25519.PublicKey).Equal(publicKey)) {
+			err = errors.New("the private and public key files do not hold the same key pair")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "keys: %v\n", err)
+			return 1
+		}
+		if err := utils.ImportIdentity(source, privateKey); err != nil {
+			fmt.Fprintf(os.Stderr, "keys: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Imported identity key %s\n", hex.EncodeToString(publicKey))
+	case "show":
+		privateKey, err := source.Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "keys: %v\n", err)
+			return 1
+		}
+		fmt.Println(hex.EncodeToString(privateKey.Public().(ed25519.PublicKey)))
+	default:
+		fmt.Fprint(os.Stderr, keysUsage)
+		return 2
+	}
+	return 0
+}
+
+// loadIdentity loads the identity key from the key store, or from the key files without one
+func loadIdentity(params utils.Parameters) (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	if *params.KeyStore == "" {
+		return utils.LoadOrCreateKeys(*params.PrivateKeyPath, *params.PublicKeyPath)
+	}
+	source, err := utils.ParseKeySource(*params.KeyStore, *params.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return utils.LoadOrCreateIdentity(source)
+}
@@ -2,11 +2,11 @@ package main
 
 import (
 	"context"
-	dk_client "dk/client"
 	"dk/core"
 	"dk/db"
 	"dk/http"
 	mcp_server "dk/mcp"
+	"dk/mcp/i18n"
 	"dk/utils"
 	"flag"
 	"github.com/mark3labs/mcp-go/server"
@@ -14,6 +14,7 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -24,12 +25,30 @@ func loadParameters() utils.Parameters {
 	// These flags remain unchanged.
 	params.PrivateKeyPath = flag.String("private", "path/to/private_key.pem", "Path to the private key file in PEM format")
 	params.PublicKeyPath = flag.String("public", "path/to/public_key.pem", "Path to the public key file in PEM format")
+	params.KeyStore = flag.String("key_store", "", "Where the identity key is kept instead of the -private and -public files: keystore:PATH (an encrypted file), keychain[:SERVICE] (the OS keychain) or pkcs11:MODULE (a PKCS#11 token); the passphrase or PIN is read from DK_KEY_PASSPHRASE")
 	params.UserID = flag.String("userId", "defaultUser", "User ID for authentication")
 
 	// Keep the rag_sources flag so that it isn't nil.
 	params.RagSourcesFile = flag.String("rag_sources", "/path/to/rag_sources.jsonl", "Path to the JSONL file containing source data")
-	params.ServerURL = flag.String("server", "https://localhost:8080", "Address to the websocket server")
+	params.ServerURL = flag.String("server", "https://localhost:8080", "Address to the websocket server, or a comma-separated list of servers to fail over between, the preferred one first")
+	params.PartnerServers = flag.String("partner_servers", "", "Comma-separated name=url list of the servers of partner networks to take part in as well, e.g. lab=https://lab.example.org")
+	params.WSCompression = flag.Bool("ws_compression", true, "Compress websocket messages with permessage-deflate when the server supports it")
+	params.TLSCACert = flag.String("tls_ca", "", "PEM file of CA certificates to trust for the server, in addition to the system roots, e.g. the CA or the self-signed certificate of a self-hosted server")
+	params.TLSPins = flag.String("tls_pin", "", "Comma-separated SHA-256 fingerprints (hex) of the public keys the server certificate or its CA must have")
+	params.TLSInsecure = flag.Bool("tls_insecure", false, "Skip the verification of the server certificate (for testing only)")
+	params.Proxy = flag.String("proxy", "", "HTTP (http://host:port) or SOCKS5 (socks5://host:port) proxy to reach the server through (default: HTTPS_PROXY and HTTP_PROXY from the environment)")
 	params.HTTPPort = flag.String("http_port", "8081", "Port for the HTTP server")
+	params.DocumentsDir = flag.String("documents_dir", "", "Directory whose files are kept indexed in the default collection")
+	params.WatchInterval = flag.Duration("watch_interval", 10*time.Second, "How often the RAG sources and documents directory are checked for changes (0 disables watching)")
+	params.WarmInterval = flag.Duration("warm_interval", 0, "How often to call the LLM provider to keep its connections open, so queries skip connection setup; each call may be billed or load the model (0 disables warming)")
+	params.DocumentAccess = flag.Bool("document_access", false, "Answer peers only from documents associated with the APIs they have access to")
+	params.ConsistencyRepair = flag.Bool("consistency_repair", false, "Delete orphaned document associations during the nightly consistency check")
+	params.HTTPToken = flag.String("http_token", "", "Token the host must send as 'Authorization: Bearer' to the HTTP API (default: requests without a role token act as the host)")
+	params.MCPToken = flag.String("mcp_token", "", "Access token of a delegated role, such as a curator, to restrict the MCP tools to")
+	params.MCPPort = flag.String("mcp_port", "", "Port to also serve the MCP tools on over HTTP with Server-Sent Events, on 127.0.0.1 unless -http_token is set (default: stdio only)")
+	params.MCPLanguage = flag.String("mcp_language", i18n.Default, "Default language of MCP tool descriptions and messages (e.g. en, es, pt); clients can choose another per session")
+	params.MCPToolPolicy = flag.String("mcp_tool_policy", "", "Path to a JSON file enabling, disabling or requiring confirmation for each MCP tool (default: all tools enabled)")
+	params.MCPPlugins = flag.String("mcp_plugins", "", "Directory of plugin manifests adding MCP tools run by external commands (default: no plugins)")
 	syftboxConfigPath := flag.String("syftbox_config", "~/.syftbox", "Path to syftbox config file")
 	params.SyftboxConfig = syftboxConfigPath
 
@@ -74,8 +93,28 @@ func loadParameters() utils.Parameters {
 
 func main() {
 	params := loadParameters()
+	if flag.Arg(0) == "sources" {
+		os.Exit(runSourcesCommand(*params.RagSourcesFile, flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "archive" {
+		os.Exit(runArchiveCommand(*params.DBPath, *params.UserID, flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "eval" {
+		os.Exit(runEvalCommand(*params.VectorDBPath, *params.ModelConfigFile, flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "keys" {
+		os.Exit(runKeysCommand(params, flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "replay" {
+		os.Exit(runReplayCommand(*params.DBPath, flag.Args()[1:]))
+	}
 	rootCtx := context.Background()
 
+	// Upgrade the data files of earlier versions, and refuse files written by a newer one
+	if err := core.CheckDataFiles(filepath.Dir(*params.DBPath)); err != nil {
+		log.Fatalf("Failed to load data files: %v", err)
+	}
+
 	// Initialize the database connection
 	database, err := db.Initialize(*params.DBPath)
 	if err != nil {
@@ -98,13 +137,36 @@ func main() {
 		log.Printf("Warning: Failed to run API Management migrations: %v", err)
 	}
 
-	publicKey, privateKey, err := utils.LoadOrCreateKeys(*params.PrivateKeyPath, *params.PublicKeyPath)
+	publicKey, privateKey, err := loadIdentity(params)
 	if err != nil {
 		log.Fatalf("Failed to load or create keys: %v", err)
 	}
 
-	client := dk_client.NewClient(*params.ServerURL, *params.UserID, privateKey, publicKey)
-	client.SetInsecure(true)
+	servers := strings.Split(*params.ServerURL, ",")
+	client := newServerClient(params, strings.TrimSpace(servers[0]), privateKey, publicKey)
+	// Messages queued while the connection is down are kept in the database until sent,
+	// however long the outage, so no peer query or answer is lost
+	client.SetOutboxStore(core.NewOutboxStore(database))
+	// Group keys are kept with the other data of the node, so group messages can be read
+	// after a restart
+	client.SetGroupKeyStore(core.NewGroupKeyStore(database))
+	// Ratcheting sessions too, so peers do not have to start new ones after a restart
+	client.SetRatchetStore(core.NewRatchetStore(database))
+	// and the latest sequence numbers of peers, so captured messages are not accepted again
+	client.SetSequenceStore(core.NewSequenceStore(database))
+	// and the key trusted for each peer, so a key the server changes is noticed across restarts
+	client.SetPeerKeyStore(core.NewPeerKeyStore(database))
+	// Connection state changes are logged, and scheduled queries wait while the connection is lost
+	client.SetHooks(core.ConnectionHooks())
+	reconnectPolicy := client.ReconnectPolicy()
+	reconnectPolicy.MaxQueueAge = 0
+	client.SetReconnectPolicy(reconnectPolicy)
+	if len(servers) > 1 {
+		if err := client.SetServerURLs(servers); err != nil {
+			log.Fatalf("Invalid server list: %v", err)
+		}
+		log.Printf("Using server %s of %d", client.SelectServer(), len(servers))
+	}
 	if err := client.Register(*params.UserID); err != nil {
 		log.Printf("Registration failed: %v", err)
 	}
@@ -118,29 +180,168 @@ func main() {
 	}
 
 	log.Printf("Token:  %s\n", client.Token())
+	joinPartnerNetworks(client, params, privateKey, publicKey)
 
 	// Load LLM model configuration and create provider.
 	modelConfig, err := core.LoadModelConfig(*params.ModelConfigFile)
 	if err != nil {
 		log.Printf("Warning: Failed to load model config: %v", err)
 	} else {
+		if modelConfig.Prompts != nil {
+			templates, err := core.NewPromptTemplates(*modelConfig.Prompts)
+			if err != nil {
+				log.Fatalf("Invalid prompt templates: %v", err)
+			}
+			rootCtx = core.WithPromptTemplates(rootCtx, templates)
+			log.Printf("Custom prompt templates loaded")
+		}
+		if modelConfig.TokenBudget != nil {
+			if err := modelConfig.TokenBudget.Validate(); err != nil {
+				log.Fatalf("Invalid token budget: %v", err)
+			}
+			rootCtx = core.WithTokenBudget(rootCtx, modelConfig.TokenBudget)
+			log.Printf("LLM prompt token budget: %d (%s)", modelConfig.TokenBudget.MaxPromptTokens, modelConfig.TokenBudget.Strategy)
+		}
+		if window := core.PromptWindow(&modelConfig); window > 0 {
+			rootCtx = core.WithPromptWindow(rootCtx, window)
+			log.Printf("LLM answer context packed into %d prompt tokens", window)
+		}
+		if modelConfig.Cache != nil {
+			cache := core.NewLLMCache(*modelConfig.Cache)
+			rootCtx = core.WithLLMCache(rootCtx, cache)
+			log.Printf("LLM response cache enabled (TTL %v)", cache.TTL)
+		}
 		llmProvider, err := core.CreateLLMProvider(modelConfig)
 		if err != nil {
 			log.Printf("Warning: Failed to create LLM provider: %v", err)
 		} else {
 			rootCtx = core.WithLLMProvider(rootCtx, llmProvider)
-			log.Printf("LLM provider '%s' initialized successfully with model '%s'", modelConfig.Provider, modelConfig.Model)
+			// Keep provider connections open so interactive queries skip connection setup
+			if *params.WarmInterval > 0 {
+				core.StartProviderWarmer(rootCtx, llmProvider, *params.WarmInterval)
+				log.Printf("LLM provider warmed every %v", *params.WarmInterval)
+			}
+			if chain, ok := llmProvider.(*core.FallbackProvider); ok {
+				log.Printf("LLM provider fallback chain initialized successfully: %v", chain.Names())
+			} else {
+				log.Printf("LLM provider '%s' initialized successfully with model '%s'", modelConfig.Provider, modelConfig.Model)
+			}
+		}
+		if modelConfig.Rerank != nil {
+			llmProvider, _ := core.LLMProviderFromContext(rootCtx)
+			reranker, err := core.NewReranker(*modelConfig.Rerank, llmProvider)
+			if err != nil {
+				log.Printf("Warning: Reranking disabled: %v", err)
+			} else {
+				rootCtx = core.WithReranker(rootCtx, reranker)
+				log.Printf("Reranking enabled (%s, %d candidates)", modelConfig.Rerank.Method, reranker.Candidates())
+			}
+		}
+		if modelConfig.Chunking != nil {
+			if err := modelConfig.Chunking.Validate(); err != nil {
+				log.Fatalf("Invalid chunking configuration: %v", err)
+			}
+			rootCtx = core.WithChunking(rootCtx, *modelConfig.Chunking)
+			log.Printf("Document chunking: %s (size %d, overlap %d)", modelConfig.Chunking.Strategy, modelConfig.Chunking.Size, modelConfig.Chunking.Overlap)
+		}
+		if modelConfig.Summarization != nil {
+			if err := modelConfig.Summarization.Validate(); err != nil {
+				log.Fatalf("Invalid summarization configuration: %v", err)
+			}
+			rootCtx = core.WithSummarization(rootCtx, *modelConfig.Summarization)
+			log.Printf("Documents over %d tokens are summarized at ingest", modelConfig.Summarization.MinTokens)
+		}
+		if len(modelConfig.PeerFilters) > 0 {
+			if err := modelConfig.PeerFilters.Validate(); err != nil {
+				log.Fatalf("Invalid peer filters: %v", err)
+			}
+			rootCtx = core.WithPeerFilters(rootCtx, modelConfig.PeerFilters)
+			log.Printf("Answers are restricted by metadata filters for %d peer entries", len(modelConfig.PeerFilters))
+		}
+		if modelConfig.Telemetry != nil && modelConfig.Telemetry.Endpoint != "" {
+			// Reports are only sent once the user approved them through the HTTP API
+			rootCtx = core.WithTelemetry(rootCtx, core.NewTelemetry(*modelConfig.Telemetry))
+			log.Printf("Approved telemetry reports are sent to %s", modelConfig.Telemetry.Endpoint)
 		}
 	}
 	rootCtx = utils.WithDatabaseConnection(rootCtx, dbConn)
 
+	// Original files of uploaded documents are kept next to the database unless a bucket is set
+	blobConfig := core.BlobStoreConfig{Backend: core.BlobBackendLocal}
+	if modelConfig.Blobs != nil {
+		blobConfig = *modelConfig.Blobs
+	}
+	if blobConfig.Backend == "" || blobConfig.Backend == core.BlobBackendLocal {
+		if blobConfig.Dir == "" {
+			blobConfig.Dir = filepath.Join(filepath.Dir(*params.DBPath), "blobs")
+		}
+		if blobConfig.PublicURL == "" {
+			blobConfig.PublicURL = "http://localhost:" + *params.HTTPPort
+		}
+	}
+	blobStore, err := core.NewBlobStore(blobConfig)
+	if err != nil {
+		log.Fatalf("Failed to open blob store: %v", err)
+	}
+	rootCtx = core.WithBlobStore(rootCtx, blobStore, blobConfig)
+
+	if *params.DocumentAccess {
+		rootCtx = core.WithDocumentAccess(rootCtx, true)
+		log.Println("Answers to peers only use documents of the APIs they have access to")
+	}
+
 	rootCtx = utils.WithDK(rootCtx, client)
 	client.SetReadLimit(1024 * 1024)
-	chromemCollection := core.SetupChromemCollection(*params.VectorDBPath)
+	// Each collection is indexed for keyword search too; retrieval fuses both rankings
+	var collectionsConfig core.CollectionsConfig
+	if modelConfig.Collections != nil {
+		collectionsConfig = *modelConfig.Collections
+	}
+	collections, err := core.SetupCollections(rootCtx, *params.VectorDBPath, collectionsConfig)
+	if err != nil {
+		log.Fatalf("Failed to open vector collections: %v", err)
+	}
+	chromemCollection, keywordIndex := collections.Default()
+	rootCtx = core.WithCollections(rootCtx, collections)
 	rootCtx = utils.WithChromemCollection(rootCtx, chromemCollection)
+	rootCtx = core.WithKeywordIndex(rootCtx, keywordIndex)
 	core.FeedChromem(rootCtx, *params.RagSourcesFile, false)
+	sourceWatcher := core.NewSourceWatcher(*params.RagSourcesFile, *params.DocumentsDir, *params.WatchInterval)
+	rootCtx = core.WithSourceWatcher(rootCtx, sourceWatcher)
+
+	// Peers exchange their collections and accepted topics on first contact
+	var handshakeConfig core.HandshakeConfig
+	if modelConfig.Handshake != nil {
+		handshakeConfig = *modelConfig.Handshake
+	}
+	handshakes := core.NewHandshakes(handshakeConfig)
+	rootCtx = core.WithHandshakes(rootCtx, handshakes)
 
-	mcpServer := mcp_server.NewMCPServer()
+	toolPolicy, err := mcp_server.LoadToolPolicy(*params.MCPToolPolicy)
+	if err != nil {
+		log.Fatalf("Failed to load MCP tool policy: %v", err)
+	}
+	plugins, err := mcp_server.LoadPlugins(rootCtx, *params.MCPPlugins)
+	if err != nil {
+		log.Fatalf("Failed to load MCP plugins: %v", err)
+	}
+	mcpServer, err := mcp_server.NewMCPServer(toolPolicy, plugins)
+	if err != nil {
+		log.Fatalf("Failed to create MCP server: %v", err)
+	}
+	mcpPrincipal := core.Principal{Role: core.RoleHost}
+	if *params.MCPToken != "" {
+		mcpPrincipal, err = core.AuthenticateRole(rootCtx, database, *params.MCPToken)
+		if err != nil {
+			log.Fatalf("Invalid MCP access token: %v", err)
+		}
+		log.Printf("MCP tools restricted to the %s role of %s", mcpPrincipal.Role, mcpPrincipal.UserID)
+	}
+	mcpLanguage, ok := i18n.Supported(*params.MCPLanguage)
+	if !ok {
+		log.Fatalf("Unsupported MCP language %q; choose one of %s", *params.MCPLanguage, strings.Join(i18n.Languages(), ", "))
+	}
+	chunking := core.ChunkingFromContext(rootCtx)
 
 	// Store LLM provider for reuse in the MCP context.
 	var llmProvider core.LLMProvider
@@ -148,23 +349,43 @@ func main() {
 		llmProvider = p
 	}
 
+	// Tool calls see the same services over stdio and SSE; only the principal differs
+	mcpContext := func(ctx context.Context) context.Context {
+		ctx = utils.WithParams(ctx, params)
+		ctx = core.WithCollections(ctx, collections)
+		ctx = utils.WithChromemCollection(ctx, chromemCollection)
+		ctx = core.WithKeywordIndex(ctx, keywordIndex)
+		ctx = core.WithSourceWatcher(ctx, sourceWatcher)
+		ctx = core.WithHandshakes(ctx, handshakes)
+		ctx = core.WithChunking(ctx, chunking)
+		ctx = i18n.WithLanguage(ctx, mcpLanguage)
+		ctx = utils.WithDK(ctx, client)
+		ctx = utils.WithDatabaseConnection(ctx, dbConn)
+		// Add LLM provider to MCP context if available.
+		if llmProvider != nil {
+			ctx = core.WithLLMProvider(ctx, llmProvider)
+		}
+		return ctx
+	}
+
 	go server.ServeStdio(
 		mcpServer,
 		server.WithStdioContextFunc(func(ctx context.Context) context.Context {
-			ctx = utils.WithParams(ctx, params)
-			ctx = utils.WithChromemCollection(ctx, chromemCollection)
-			ctx = utils.WithDK(ctx, client)
-			ctx = utils.WithDatabaseConnection(ctx, dbConn)
-			// Add LLM provider to MCP context if available.
-			if llmProvider != nil {
-				ctx = core.WithLLMProvider(ctx, llmProvider)
-			}
-			return ctx
+			return core.WithPrincipal(mcpContext(ctx), mcpPrincipal)
 		}),
 	)
 
+	if *params.MCPPort != "" {
+		go func() {
+			if err := mcp_server.ServeSSE(*params.MCPPort, mcpServer, database, *params.HTTPToken, mcpContext); err != nil {
+				log.Fatalf("MCP SSE server failed: %v", err)
+			}
+		}()
+	}
+
 	rootCtx = utils.WithParams(rootCtx, params)
 	go core.HandleRequests(rootCtx)
+	go core.HandleFileOffers(rootCtx)
 
 	// Set up the HTTP server with the database connection for usage tracking
 	http.SetupHTTPServer(rootCtx, *params.HTTPPort, dbConn)
@@ -173,6 +394,26 @@ func main() {
 	// Check every 5 minutes for pending changes
 	utils.StartPolicyWorker(rootCtx, database, 5*time.Minute)
 
+	// Start hourly archiving of APIs deprecated past their deprecation date
+	utils.StartAPIArchiveWorker(rootCtx, database, time.Hour)
+
+	// Start nightly check of document associations against the vector store
+	core.StartConsistencyWorker(rootCtx, 24*time.Hour, *params.ConsistencyRepair)
+
+	// Start nightly analysis of the questions the knowledge base could not answer
+	core.StartKnowledgeGapWorker(rootCtx, 24*time.Hour)
+
+	// Start daily removal of original document files past their lifecycle
+	core.StartBlobLifecycleWorker(rootCtx, 24*time.Hour)
+
+	// Send scheduled queries when they are due
+	core.StartQueryScheduler(rootCtx, time.Minute)
+
+	// Re-index the RAG sources and documents directory when they change
+	if *params.WatchInterval > 0 {
+		sourceWatcher.Start(rootCtx)
+	}
+
 	// Start background job to refresh usage summaries
 	// Run every 6 hours to calculate and update summaries
 	go func() {
@@ -0,0 +1,115 @@
+package mcp
+
+import (
+	"context"
+	"database/sql"
+	"dk/core"
+	"dk/db"
+	"dk/utils"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// Tool: Grant API Access
+//
+// This tool gives an external user read or write access to an API, reactivating a revoked
+// grant. Grants exposing residency-restricted documents to a consumer flagged in another region
+// are refused. It requires confirmation unless the tool policy says otherwise.
+// Input parameters: "api_id", "user" and optionally "access_level" ("read" by default).
+func HandleGrantAPIAccessTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	apiID, user, database, failure := apiAccessArguments(ctx, req)
+	if failure != nil {
+		return failure, nil
+	}
+	level, _ := req.Params.Arguments["access_level"].(string)
+	level = strings.ToLower(strings.TrimSpace(level))
+	if level == "" {
+		level = "read"
+	}
+	if level != "read" && level != "write" {
+		return errorResult(ErrorInvalidArgument, "'access_level' must be 'read' or 'write'"), nil
+	}
+
+	if _, err := db.GetAPI(database, apiID); err != nil {
+		return apiError("grant access to", apiID, err)
+	}
+	// Overrides are only possible through the HTTP API, which records the reason
+	if violation, err := core.CheckConsumerResidency(ctx, database, apiID, user, ""); err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to check data residency: %v", err)), nil
+	} else if violation != nil {
+		return errorResult(ErrorForbidden, violation.String()), nil
+	}
+
+	access, err := db.GetAPIUserAccessByUserID(database, apiID, user)
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		access = &db.APIUserAccess{
+			APIID:          apiID,
+			ExternalUserID: user,
+			AccessLevel:    level,
+			GrantedBy:      hostUserID(ctx),
+			IsActive:       true,
+		}
+		err = db.CreateAPIUserAccess(database, access)
+	case err != nil:
+	case access.IsActive && access.AccessLevel == level:
+		return errorResult(ErrorConflict, fmt.Sprintf("'%s' already has %s access to API '%s'", user, level, apiID)), nil
+	default:
+		// Revoked grants are reactivated, active ones change level
+		access.AccessLevel = level
+		access.IsActive = true
+		access.RevokedAt = nil
+		err = db.UpdateAPIUserAccess(database, access)
+	}
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to grant access: %v", err)), nil
+	}
+	return apiResult(access)
+}
+
+// Tool: Revoke API Access
+//
+// This tool revokes the access of an external user to an API. The grant is kept as revoked, so
+// it can be granted again. It requires confirmation unless the tool policy says otherwise.
+// Input parameters: "api_id" and "user".
+func HandleRevokeAPIAccessTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	apiID, user, database, failure := apiAccessArguments(ctx, req)
+	if failure != nil {
+		return failure, nil
+	}
+
+	access, err := db.GetAPIUserAccessByUserID(database, apiID, user)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && !access.IsActive) {
+		return errorResult(ErrorNotFound, fmt.Sprintf("'%s' has no access to API '%s'", user, apiID)), nil
+	}
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to look up access: %v", err)), nil
+	}
+
+	now := time.Now()
+	access.IsActive = false
+	access.RevokedAt = &now
+	if err := db.UpdateAPIUserAccess(database, access); err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to revoke access: %v", err)), nil
+	}
+	return apiResult(access)
+}
+
+// apiAccessArguments reads the API and user of an access tool, or returns the error result
+func apiAccessArguments(ctx context.Context, req mcp_lib.CallToolRequest) (string, string, *sql.DB, *mcp_lib.CallToolResult) {
+	apiID, _ := req.Params.Arguments["api_id"].(string)
+	user, _ := req.Params.Arguments["user"].(string)
+	apiID, user = strings.TrimSpace(apiID), strings.TrimPrefix(strings.TrimSpace(user), "@")
+	if apiID == "" || user == "" {
+		return "", "", nil, errorResult(ErrorInvalidArgument, "'api_id' and 'user' parameters are required")
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return "", "", nil, errorResult(ErrorInternal, err.Error())
+	}
+	return apiID, user, database, nil
+}
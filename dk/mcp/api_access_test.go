@@ -0,0 +1,128 @@
+package mcp
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+	"github.com/philippgille/chromem-go"
+)
+
+func TestAPIAccessTools(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if err := db.RunAPIMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run API migrations: %v", err)
+	}
+	api := &db.API{Name: "Reports", HostUserID: "local-user"}
+	if err := db.CreateAPI(testDB.DB, api); err != nil {
+		t.Fatalf("CreateAPI failed: %v", err)
+	}
+	ctx := utils.WithDatabase(context.Background(), testDB.DB)
+
+	access := func(result *mcp_lib.CallToolResult) db.APIUserAccess {
+		t.Helper()
+		if result.IsError {
+			t.Fatalf("Expected the access, got %s", resultText(t, result))
+		}
+		var access db.APIUserAccess
+		if err := json.Unmarshal([]byte(resultText(t, result)), &access); err != nil {
+			t.Fatalf("Invalid access: %v", err)
+		}
+		return access
+	}
+	grant := func(args map[string]any) *mcp_lib.CallToolResult {
+		t.Helper()
+		result, err := HandleGrantAPIAccessTool(ctx, callRequest("cqGrantAPIAccess", args))
+		if err != nil {
+			t.Fatalf("HandleGrantAPIAccessTool failed: %v", err)
+		}
+		return result
+	}
+	revoke := func(args map[string]any) *mcp_lib.CallToolResult {
+		t.Helper()
+		result, err := HandleRevokeAPIAccessTool(ctx, callRequest("cqRevokeAPIAccess", args))
+		if err != nil {
+			t.Fatalf("HandleRevokeAPIAccessTool failed: %v", err)
+		}
+		return result
+	}
+
+	// Invalid arguments and unknown APIs are refused
+	invalid := []struct {
+		args map[string]any
+		code string
+	}{
+		{map[string]any{"api_id": api.ID}, ErrorInvalidArgument},
+		{map[string]any{"api_id": api.ID, "user": "alice", "access_level": "admin"}, ErrorInvalidArgument},
+		{map[string]any{"api_id": "missing", "user": "alice"}, ErrorNotFound},
+	}
+	for _, tc := range invalid {
+		if envelope := decodeToolError(t, grant(tc.args)); envelope.Code != tc.code {
+			t.Errorf("%v: expected %s, got %+v", tc.args, tc.code, envelope)
+		}
+	}
+
+	// Grants are read by default, and change level rather than duplicate
+	granted := access(grant(map[string]any{"api_id": api.ID, "user": "@alice"}))
+	if granted.ExternalUserID != "alice" || granted.AccessLevel != "read" || !granted.IsActive || granted.GrantedBy != "local-user" {
+		t.Errorf("Expected an active read grant to alice, got %+v", granted)
+	}
+	if envelope := decodeToolError(t, grant(map[string]any{"api_id": api.ID, "user": "alice"})); envelope.Code != ErrorConflict {
+		t.Errorf("Expected a repeated grant to conflict, got %+v", envelope)
+	}
+	if upgraded := access(grant(map[string]any{"api_id": api.ID, "user": "alice", "access_level": "write"})); upgraded.ID != granted.ID || upgraded.AccessLevel != "write" {
+		t.Errorf("Expected the grant to change level, got %+v", upgraded)
+	}
+
+	// Revoked grants are kept, and reactivated by the next grant
+	revoked := access(revoke(map[string]any{"api_id": api.ID, "user": "alice"}))
+	if revoked.IsActive || revoked.RevokedAt == nil {
+		t.Errorf("Expected the grant to be revoked, got %+v", revoked)
+	}
+	if envelope := decodeToolError(t, revoke(map[string]any{"api_id": api.ID, "user": "alice"})); envelope.Code != ErrorNotFound {
+		t.Errorf("Expected revoking a revoked grant to fail, got %+v", envelope)
+	}
+	if envelope := decodeToolError(t, revoke(map[string]any{"api_id": api.ID, "user": "bob"})); envelope.Code != ErrorNotFound {
+		t.Errorf("Expected revoking a missing grant to fail, got %+v", envelope)
+	}
+	if reactivated := access(grant(map[string]any{"api_id": api.ID, "user": "alice"})); reactivated.ID != granted.ID || !reactivated.IsActive || reactivated.RevokedAt != nil {
+		t.Errorf("Expected the revoked grant to be reactivated, got %+v", reactivated)
+	}
+
+	// Grants exposing restricted documents to consumers flagged in another region are refused
+	if err := db.SetCollectionResidency(testDB.DB, &db.CollectionResidency{CollectionName: "reports", Region: "eu"}); err != nil {
+		t.Fatalf("SetCollectionResidency failed: %v", err)
+	}
+	if err := db.SetConsumerRegion(testDB.DB, &db.ConsumerRegion{ExternalUserID: "bob", Region: "us"}); err != nil {
+		t.Fatalf("SetConsumerRegion failed: %v", err)
+	}
+	if err := db.CreateDocumentAssociation(testDB.DB, &db.DocumentAssociation{DocumentFilename: "q3.txt", EntityID: api.ID, EntityType: "api"}); err != nil {
+		t.Fatalf("CreateDocumentAssociation failed: %v", err)
+	}
+	collection, err := chromem.NewDB().CreateCollection("reports", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	ctx = utils.WithChromemCollection(ctx, collection)
+	envelope := decodeToolError(t, grant(map[string]any{"api_id": api.ID, "user": "bob"}))
+	if envelope.Code != ErrorForbidden || !strings.Contains(envelope.Message, "flagged in US") {
+		t.Errorf("Expected the grant to bob to be refused, got %+v", envelope)
+	}
+	if _, err := db.GetAPIUserAccessByUserID(testDB.DB, api.ID, "bob"); err == nil {
+		t.Error("Expected no grant to be stored")
+	}
+	if granted := access(grant(map[string]any{"api_id": api.ID, "user": "carol"})); !granted.IsActive {
+		t.Errorf("Expected consumers without a region to be granted access, got %+v", granted)
+	}
+}
@@ -0,0 +1,213 @@
+package mcp
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// apiResult encodes an API or a list of APIs as the tool result
+func apiResult(value any) (*mcp_lib.CallToolResult, error) {
+	blob, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode APIs: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
+
+// apiError reports a failed API operation, naming unknown APIs plainly
+func apiError(action, id string, err error) (*mcp_lib.CallToolResult, error) {
+	if errors.Is(err, db.ErrNotFound) {
+		return errorResult(ErrorNotFound, fmt.Sprintf("API '%s' not found", id)), nil
+	}
+	return errorResult(errorCode(err), fmt.Sprintf("Failed to %s API: %v", action, err)), nil
+}
+
+// hostUserID returns the user ID APIs created from this node belong to
+func hostUserID(ctx context.Context) string {
+	if dkClient, err := utils.DkFromContext(ctx); err == nil && dkClient.UserID != "" {
+		return dkClient.UserID
+	}
+	return "local-user"
+}
+
+// Tool: List APIs
+//
+// This tool lists the hosted APIs with their user and document counts. API keys are left out.
+// Input parameters: optional "status", "external_user", "limit" and "offset".
+func HandleListAPIsTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, err.Error()), nil
+	}
+	status, _ := req.Params.Arguments["status"].(string)
+	externalUser, _ := req.Params.Arguments["external_user"].(string)
+	limit := 50
+	if value, ok := req.Params.Arguments["limit"].(float64); ok && value > 0 {
+		limit = int(value)
+	}
+	offset := 0
+	if value, ok := req.Params.Arguments["offset"].(float64); ok && value > 0 {
+		offset = int(value)
+	}
+
+	summaries, total, err := db.ListAPISummaries(database, strings.TrimSpace(status), strings.TrimPrefix(strings.TrimSpace(externalUser), "@"), nil, limit, offset, "name", "asc")
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to list APIs: %v", err)), nil
+	}
+	for _, summary := range summaries {
+		summary.APIKey = ""
+	}
+	return apiResult(map[string]interface{}{
+		"apis":  summaries,
+		"total": total,
+	})
+}
+
+// Tool: Create API
+//
+// This tool creates an API, optionally with a policy, associated documents and users granted
+// read access, and returns it with its key.
+// Input parameters: "name", and optionally "description", "policy_id", "documents", "users" and "inactive".
+func HandleCreateAPITool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	name, _ := req.Params.Arguments["name"].(string)
+	if strings.TrimSpace(name) == "" {
+		return errorResult(ErrorInvalidArgument, "'name' parameter is required"), nil
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, err.Error()), nil
+	}
+
+	description, _ := req.Params.Arguments["description"].(string)
+	inactive, _ := req.Params.Arguments["inactive"].(bool)
+	host := hostUserID(ctx)
+	api := &db.API{
+		ID:          uuid.New().String(),
+		Name:        strings.TrimSpace(name),
+		Description: description,
+		IsActive:    !inactive,
+		HostUserID:  host,
+	}
+	if policyID, _ := req.Params.Arguments["policy_id"].(string); strings.TrimSpace(policyID) != "" {
+		policyID = strings.TrimSpace(policyID)
+		if _, err := db.GetPolicy(database, policyID); err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				return errorResult(ErrorNotFound, fmt.Sprintf("Policy '%s' not found", policyID)), nil
+			}
+			return errorResult(errorCode(err), fmt.Sprintf("Failed to look up policy: %v", err)), nil
+		}
+		api.PolicyID = &policyID
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to start transaction: %v", err)), nil
+	}
+	defer tx.Rollback()
+
+	if err := db.CreateAPITx(tx, api); err != nil {
+		return apiError("create", api.ID, err)
+	}
+	for _, document := range stringList(req.Params.Arguments, "documents") {
+		association := &db.DocumentAssociation{DocumentFilename: document, EntityID: api.ID, EntityType: "api"}
+		if err := db.CreateDocumentAssociationTx(tx, association); err != nil {
+			return errorResult(errorCode(err), fmt.Sprintf("Failed to associate document '%s': %v", document, err)), nil
+		}
+	}
+	for _, user := range stringList(req.Params.Arguments, "users") {
+		access := &db.APIUserAccess{
+			APIID:          api.ID,
+			ExternalUserID: strings.TrimPrefix(user, "@"),
+			AccessLevel:    "read",
+			GrantedBy:      host,
+			IsActive:       true,
+		}
+		if err := db.CreateAPIUserAccessTx(tx, access); err != nil {
+			return errorResult(errorCode(err), fmt.Sprintf("Failed to grant access to '%s': %v", user, err)), nil
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to commit transaction: %v", err)), nil
+	}
+	return apiResult(api)
+}
+
+// Tool: Rotate API Key
+//
+// This tool replaces the key of an API with a new one. The old key stops working immediately.
+// Input parameters: "id" of the API.
+func HandleRotateAPIKeyTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	id, _ := req.Params.Arguments["id"].(string)
+	if strings.TrimSpace(id) == "" {
+		return errorResult(ErrorInvalidArgument, "'id' parameter is required"), nil
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, err.Error()), nil
+	}
+
+	key, err := db.RotateAPIKey(database, strings.TrimSpace(id))
+	if err != nil {
+		return apiError("rotate the key of", id, err)
+	}
+	return apiResult(map[string]string{"id": strings.TrimSpace(id), "api_key": key})
+}
+
+// Tool: Deprecate API
+//
+// This tool marks an API as deprecated, effective now or at a given date, with a message for
+// its users.
+// Input parameters: "id", and optionally "message" and "date" (RFC 3339 or YYYY-MM-DD).
+func HandleDeprecateAPITool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	id, _ := req.Params.Arguments["id"].(string)
+	if strings.TrimSpace(id) == "" {
+		return errorResult(ErrorInvalidArgument, "'id' parameter is required"), nil
+	}
+	date := time.Now().UTC()
+	if value, _ := req.Params.Arguments["date"].(string); strings.TrimSpace(value) != "" {
+		parsed, err := parseToolDate(strings.TrimSpace(value))
+		if err != nil {
+			return errorResult(ErrorInvalidArgument, err.Error()), nil
+		}
+		date = parsed
+	}
+	message, _ := req.Params.Arguments["message"].(string)
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, err.Error()), nil
+	}
+
+	api, err := db.GetAPI(database, strings.TrimSpace(id))
+	if err != nil {
+		return apiError("deprecate", id, err)
+	}
+	api.IsDeprecated = true
+	api.DeprecationDate = &date
+	api.DeprecationMessage = message
+	if err := db.UpdateAPI(database, api); err != nil {
+		return apiError("deprecate", id, err)
+	}
+	api.APIKey = ""
+	return apiResult(api)
+}
+
+// parseToolDate accepts a full RFC 3339 time or a plain date, taken as midnight UTC
+func parseToolDate(value string) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.UTC(), nil
+	}
+	parsed, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date '%s': use RFC 3339 or YYYY-MM-DD", value)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,148 @@
+package mcp
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"testing"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// apiManagementDB opens a test database with the API tables
+func apiManagementDB(t *testing.T) context.Context {
+	t.Helper()
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { testDB.Close() })
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if err := db.RunAPIMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run API migrations: %v", err)
+	}
+	return utils.WithDatabase(context.Background(), testDB.DB)
+}
+
+// decodeResult decodes the JSON text of a successful tool result into value
+func decodeResult(t *testing.T, result *mcp_lib.CallToolResult, value any) {
+	t.Helper()
+	if result.IsError {
+		t.Fatalf("Expected a result, got %s", resultText(t, result))
+	}
+	if err := json.Unmarshal([]byte(resultText(t, result)), value); err != nil {
+		t.Fatalf("Invalid result %q: %v", resultText(t, result), err)
+	}
+}
+
+func TestAPIManagementTools(t *testing.T) {
+	ctx := apiManagementDB(t)
+	database, _ := utils.DatabaseFromContext(ctx)
+	policy := &db.Policy{Name: "Free", Type: "free", IsActive: true}
+	if err := db.CreatePolicy(database, policy); err != nil {
+		t.Fatalf("CreatePolicy failed: %v", err)
+	}
+	call := func(tool string, handler func(context.Context, mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error), args map[string]any) *mcp_lib.CallToolResult {
+		t.Helper()
+		result, err := handler(ctx, callRequest(tool, args))
+		if err != nil {
+			t.Fatalf("%s failed: %v", tool, err)
+		}
+		return result
+	}
+
+	// Invalid arguments and unknown APIs or policies are refused
+	invalid := []struct {
+		tool    string
+		handler func(context.Context, mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error)
+		args    map[string]any
+		code    string
+	}{
+		{"cqCreateAPI", HandleCreateAPITool, map[string]any{"name": " "}, ErrorInvalidArgument},
+		{"cqCreateAPI", HandleCreateAPITool, map[string]any{"name": "Reports", "policy_id": "missing"}, ErrorNotFound},
+		{"cqRotateAPIKey", HandleRotateAPIKeyTool, map[string]any{}, ErrorInvalidArgument},
+		{"cqRotateAPIKey", HandleRotateAPIKeyTool, map[string]any{"id": "missing"}, ErrorNotFound},
+		{"cqDeprecateAPI", HandleDeprecateAPITool, map[string]any{}, ErrorInvalidArgument},
+		{"cqDeprecateAPI", HandleDeprecateAPITool, map[string]any{"id": "missing"}, ErrorNotFound},
+		{"cqDeprecateAPI", HandleDeprecateAPITool, map[string]any{"id": "missing", "date": "next week"}, ErrorInvalidArgument},
+	}
+	for _, tc := range invalid {
+		if envelope := decodeToolError(t, call(tc.tool, tc.handler, tc.args)); envelope.Code != tc.code {
+			t.Errorf("%s %v: expected %s, got %+v", tc.tool, tc.args, tc.code, envelope)
+		}
+	}
+
+	// Created APIs carry their key, policy, documents and the users granted read access
+	var api db.API
+	decodeResult(t, call("cqCreateAPI", HandleCreateAPITool, map[string]any{
+		"name": " Reports ", "description": "Monthly reports", "policy_id": policy.ID,
+		"documents": []any{"q1.txt", "q2.txt"}, "users": []any{"@alice"},
+	}), &api)
+	if api.Name != "Reports" || api.APIKey == "" || !api.IsActive || api.HostUserID != "local-user" || api.PolicyID == nil || *api.PolicyID != policy.ID {
+		t.Errorf("Expected the created API, got %+v", api)
+	}
+	if _, count, err := db.GetDocumentAssociationsByEntity(database, "api", api.ID); err != nil || count != 2 {
+		t.Errorf("Expected 2 associated documents, got %d (%v)", count, err)
+	}
+	if access, err := db.GetAPIUserAccessByUserID(database, api.ID, "alice"); err != nil || access.AccessLevel != "read" || !access.IsActive {
+		t.Errorf("Expected alice to have read access, got %+v (%v)", access, err)
+	}
+	var inactive db.API
+	decodeResult(t, call("cqCreateAPI", HandleCreateAPITool, map[string]any{"name": "Drafts", "inactive": true}), &inactive)
+	if inactive.IsActive {
+		t.Error("Expected the API to be created inactive")
+	}
+
+	// Listings leave the keys out and can be narrowed to the APIs of a user
+	var listing struct {
+		APIs  []map[string]any `json:"apis"`
+		Total int              `json:"total"`
+	}
+	decodeResult(t, call("cqListAPIs", HandleListAPIsTool, nil), &listing)
+	if listing.Total != 2 || len(listing.APIs) != 2 || listing.APIs[0]["name"] != "Drafts" {
+		t.Fatalf("Expected both APIs sorted by name, got %+v", listing)
+	}
+	for _, listed := range listing.APIs {
+		if _, ok := listed["api_key"]; ok {
+			t.Errorf("Expected the key to be left out, got %v", listed)
+		}
+	}
+	decodeResult(t, call("cqListAPIs", HandleListAPIsTool, map[string]any{"external_user": "@alice"}), &listing)
+	if listing.Total != 1 || listing.APIs[0]["id"] != api.ID {
+		t.Errorf("Expected only the API of alice, got %+v", listing)
+	}
+	decodeResult(t, call("cqListAPIs", HandleListAPIsTool, map[string]any{"limit": float64(1), "offset": float64(1)}), &listing)
+	if listing.Total != 2 || len(listing.APIs) != 1 || listing.APIs[0]["id"] != api.ID {
+		t.Errorf("Expected the second page, got %+v", listing)
+	}
+
+	// Rotating returns the new key, which replaces the old one
+	var rotated map[string]string
+	decodeResult(t, call("cqRotateAPIKey", HandleRotateAPIKeyTool, map[string]any{"id": api.ID}), &rotated)
+	if rotated["id"] != api.ID || rotated["api_key"] == "" || rotated["api_key"] == api.APIKey {
+		t.Errorf("Expected a new key, got %v", rotated)
+	}
+	if stored, err := db.GetAPI(database, api.ID); err != nil || stored.APIKey != rotated["api_key"] {
+		t.Errorf("Expected the new key to be stored, got %+v (%v)", stored, err)
+	}
+
+	// Deprecation takes a date, now by default, and a message, and leaves the key out
+	var deprecated db.API
+	decodeResult(t, call("cqDeprecateAPI", HandleDeprecateAPITool, map[string]any{"id": api.ID, "date": "2027-01-31", "message": "Use Reports v2"}), &deprecated)
+	if !deprecated.IsDeprecated || deprecated.DeprecationMessage != "Use Reports v2" || deprecated.APIKey != "" ||
+		deprecated.DeprecationDate == nil || !deprecated.DeprecationDate.Equal(time.Date(2027, 1, 31, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the API to be deprecated on the date, got %+v", deprecated)
+	}
+	before := time.Now().Add(-time.Second)
+	decodeResult(t, call("cqDeprecateAPI", HandleDeprecateAPITool, map[string]any{"id": inactive.ID}), &deprecated)
+	if deprecated.DeprecationDate == nil || deprecated.DeprecationDate.Before(before) {
+		t.Errorf("Expected the API to be deprecated now, got %+v", deprecated.DeprecationDate)
+	}
+	if stored, err := db.GetAPI(database, api.ID); err != nil || !stored.IsDeprecated || stored.APIKey != rotated["api_key"] {
+		t.Errorf("Expected the deprecation to be stored with the key, got %+v (%v)", stored, err)
+	}
+}
@@ -0,0 +1,43 @@
+package mcp
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// defaultDecisionsLimit is the number of approval decisions listed when no limit is given
+const defaultDecisionsLimit = 50
+
+// Tool: List Approval Decisions
+//
+// This tool lists the audit records of the automatic approval conditions: for each incoming
+// query, whether a condition accepted or rejected it, which one and why.
+// Input parameters: optionally "query_id" and "limit".
+func HandleListApprovalDecisionsTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	args := request.Params.Arguments
+	queryID, _ := args["query_id"].(string)
+	limit := defaultDecisionsLimit
+	if value, ok := args["limit"].(float64); ok && value > 0 {
+		limit = min(int(value), maxPageSize)
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Couldn't access the database: %v", err)), nil
+	}
+	decisions, err := db.ListApprovalDecisions(ctx, database, strings.TrimSpace(queryID), limit)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't list the approval decisions: %v", err)), nil
+	}
+	blob, err := json.MarshalIndent(decisions, "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode the approval decisions: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
@@ -0,0 +1,110 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"dk/utils"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// packFromArguments loads an approval pack from the "pack_path" or "pack_json" argument
+func packFromArguments(args map[string]interface{}) (*core.ApprovalPack, error) {
+	if raw, ok := args["pack_json"].(string); ok && strings.TrimSpace(raw) != "" {
+		return core.ParseApprovalPack([]byte(raw))
+	}
+
+	path, ok := args["pack_path"].(string)
+	if !ok || strings.TrimSpace(path) == "" {
+		return nil, fmt.Errorf("either 'pack_path' or 'pack_json' is required")
+	}
+	expanded, err := utils.ExpandHomePath(strings.TrimSpace(path))
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read approval pack: %w", err)
+	}
+	return core.ParseApprovalPack(data)
+}
+
+// Tool: Export Approval Pack
+//
+// This tool signs the installed automatic approval conditions as a shareable pack.
+// Input parameters: "name", optional "description" and optional "file_path" to write the pack to.
+func HandleExportApprovalPackTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	name, _ := req.Params.Arguments["name"].(string)
+	if strings.TrimSpace(name) == "" {
+		return errorResult(ErrorInvalidArgument, "'name' parameter is required"), nil
+	}
+	description, _ := req.Params.Arguments["description"].(string)
+
+	pack, err := core.ExportApprovalPack(ctx, strings.TrimSpace(name), strings.TrimSpace(description))
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't export approval pack: %v", err)), nil
+	}
+	blob, err := json.MarshalIndent(pack, "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Couldn't encode approval pack: %v", err)), nil
+	}
+
+	path, _ := req.Params.Arguments["file_path"].(string)
+	if strings.TrimSpace(path) == "" {
+		return mcp_lib.NewToolResultText(string(blob)), nil
+	}
+	expanded, err := utils.ExpandHomePath(strings.TrimSpace(path))
+	if err != nil {
+		return errorResult(ErrorInvalidArgument, fmt.Sprintf("Invalid file path: %v", err)), nil
+	}
+	if err := os.WriteFile(expanded, blob, 0644); err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't write approval pack: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(fmt.Sprintf("Approval pack '%s' with %d conditions written to %s.", pack.Name, len(pack.Conditions), expanded)), nil
+}
+
+// Tool: Preview Approval Pack
+//
+// This tool verifies a pack and reports which recent queries its conditions would have
+// approved, without installing anything.
+// Input parameters: "pack_path" or "pack_json", optional "limit" (number of recent queries).
+func HandlePreviewApprovalPackTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	pack, err := packFromArguments(req.Params.Arguments)
+	if err != nil {
+		return errorResult(ErrorInvalidArgument, err.Error()), nil
+	}
+
+	limit := 0
+	if l, ok := req.Params.Arguments["limit"].(float64); ok {
+		limit = int(l)
+	}
+
+	preview, err := core.PreviewApprovalPack(ctx, pack, limit)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't preview approval pack: %v", err)), nil
+	}
+	blob, _ := json.MarshalIndent(preview, "", "  ")
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
+
+// Tool: Import Approval Pack
+//
+// This tool verifies a pack's signature and author and installs its conditions.
+// Input parameters: "pack_path" or "pack_json".
+func HandleImportApprovalPackTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	pack, err := packFromArguments(req.Params.Arguments)
+	if err != nil {
+		return errorResult(ErrorInvalidArgument, err.Error()), nil
+	}
+
+	result, err := core.ImportApprovalPack(ctx, pack)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't import approval pack: %v", err)), nil
+	}
+	blob, _ := json.MarshalIndent(result, "", "  ")
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
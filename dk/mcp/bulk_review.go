@@ -0,0 +1,49 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"encoding/json"
+	"fmt"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// Tool: Process Queries
+//
+// This tool accepts or rejects every query matching a filter in one call, as cqProcessQuery
+// does for a single query.
+// Input parameters: "approve" and optionally "status", "from", "topic" and "dry_run".
+func HandleProcessQueriesTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	args := request.Params.Arguments
+	approve, ok := args["approve"].(bool)
+	if !ok {
+		return errorResult(ErrorInvalidArgument, "'approve' parameter is required"), nil
+	}
+	var selection core.QuerySelection
+	selection.Status, _ = args["status"].(string)
+	selection.From, _ = args["from"].(string)
+	selection.Topic, _ = args["topic"].(string)
+	dryRun, _ := args["dry_run"].(bool)
+
+	outcomes, err := core.ReviewQueries(ctx, selection, approve, dryRun)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't process the queries: %v", err)), nil
+	}
+	failed := 0
+	for _, outcome := range outcomes {
+		if outcome.Error != "" {
+			failed++
+		}
+	}
+	blob, err := json.MarshalIndent(struct {
+		DryRun  bool                 `json:"dry_run,omitempty"`
+		Matched int                  `json:"matched"`
+		Failed  int                  `json:"failed"`
+		Queries []core.ReviewOutcome `json:"queries"`
+	}{dryRun, len(outcomes), failed, outcomes}, "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode the result: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
@@ -0,0 +1,120 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"encoding/json"
+	"sync"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// cachedTool is how long the results of a read-only tool are reused, and the changes that make
+// them stale before that
+type cachedTool struct {
+	ttl     time.Duration
+	changes []core.Change
+}
+
+// generation sums the generations of the changes of the tool; it grows whenever one of them does
+func (t cachedTool) generation() uint64 {
+	var generation uint64
+	for _, change := range t.changes {
+		generation += core.ChangeGeneration(change)
+	}
+	return generation
+}
+
+// cachedTools are read-only tools whose results are reused for a short time, so an agent
+// calling them in a loop does not query the server or the database every time. Results are
+// dropped as soon as core writes what they read, whichever tool, endpoint or peer wrote it.
+var cachedTools = map[string]cachedTool{
+	"cqGetUsers":             {ttl: 15 * time.Second},
+	"cqGetUserDatasets":      {ttl: 30 * time.Second, changes: []core.Change{core.DocumentsChanged}},
+	"cqListRequestedQueries": {ttl: 5 * time.Second, changes: []core.Change{core.QueriesChanged}},
+}
+
+type cachedResult struct {
+	result     *mcp_lib.CallToolResult
+	generation uint64
+	expires    time.Time
+}
+
+// resultCache keeps the results of cached tools by tool and by call: the arguments, the
+// caller and the language of the session
+type resultCache struct {
+	mu      sync.Mutex
+	results map[string]map[string]cachedResult
+	now     func() time.Time
+}
+
+func newResultCache() *resultCache {
+	return &resultCache{results: make(map[string]map[string]cachedResult), now: time.Now}
+}
+
+// wrap returns the handler of a tool with the caching that applies to it. Failed calls, be
+// they errors or error results, are never cached.
+func (c *resultCache) wrap(name string, handler server.ToolHandlerFunc) server.ToolHandlerFunc {
+	tool, ok := cachedTools[name]
+	if !ok {
+		return handler
+	}
+	return func(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+		key, err := cacheKey(ctx, request)
+		if err != nil {
+			return handler(ctx, request)
+		}
+		// The generation is taken before the call, so a result read while a write was being
+		// made is already stale when it is stored
+		generation := tool.generation()
+		if result, ok := c.get(name, key, generation); ok {
+			return result, nil
+		}
+		result, err := handler(ctx, request)
+		if err == nil && result != nil && !result.IsError {
+			c.put(name, key, result, generation, tool.ttl)
+		}
+		return result, err
+	}
+}
+
+func (c *resultCache) get(tool, key string, generation uint64) (*mcp_lib.CallToolResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.results[tool][key]
+	if !ok || cached.generation != generation || !c.now().Before(cached.expires) {
+		return nil, false
+	}
+	return cached.result, true
+}
+
+func (c *resultCache) put(tool, key string, result *mcp_lib.CallToolResult, generation uint64, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	results, ok := c.results[tool]
+	if !ok {
+		results = make(map[string]cachedResult)
+		c.results[tool] = results
+	}
+	// Expired and stale results are dropped as new ones come in, so the cache stays small
+	for k, cached := range results {
+		if cached.generation != generation || !now.Before(cached.expires) {
+			delete(results, k)
+		}
+	}
+	results[key] = cachedResult{result: result, generation: generation, expires: now.Add(ttl)}
+}
+
+// cacheKey identifies a call by its arguments, its caller and the language of the session.
+// Arguments are maps, which encoding/json writes with sorted keys.
+func cacheKey(ctx context.Context, request mcp_lib.CallToolRequest) (string, error) {
+	principal := core.PrincipalFromContext(ctx)
+	key, err := json.Marshal([]any{principal.Role, principal.UserID, language(ctx), request.Params.Arguments})
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
@@ -0,0 +1,141 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"dk/db"
+	"dk/utils"
+	"fmt"
+	"testing"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+	"github.com/philippgille/chromem-go"
+)
+
+// countingHandler returns a handler answering with the number of calls so far, or failing
+// while fail is set
+func countingHandler(calls *int, fail *bool) func(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	return func(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+		*calls++
+		if *fail {
+			return errorResult(ErrorUnavailable, "server unreachable"), nil
+		}
+		return mcp_lib.NewToolResultText(fmt.Sprint(*calls)), nil
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	cache := newResultCache()
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	ctx := context.Background()
+	var calls int
+	var fail bool
+	handler := cache.wrap("cqGetUsers", countingHandler(&calls, &fail))
+	call := func(args map[string]any) *mcp_lib.CallToolResult {
+		t.Helper()
+		result, err := handler(ctx, callRequest("cqGetUsers", args))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// Repeated calls with the same arguments are answered from the cache
+	first := call(nil)
+	if second := call(nil); second != first || calls != 1 {
+		t.Errorf("Expected the cached result, got %d calls", calls)
+	}
+	if call(map[string]any{"active": true}); calls != 2 {
+		t.Errorf("Expected other arguments to call the tool, got %d calls", calls)
+	}
+
+	// Results expire after the time of the tool
+	now = now.Add(cachedTools["cqGetUsers"].ttl)
+	if call(nil); calls != 3 {
+		t.Errorf("Expected the expired result to be replaced, got %d calls", calls)
+	}
+
+	// Failures are never cached
+	fail = true
+	now = now.Add(cachedTools["cqGetUsers"].ttl)
+	if result := call(nil); !result.IsError {
+		t.Fatalf("Expected the failure, got %s", resultText(t, result))
+	}
+	fail = false
+	if result := call(nil); result.IsError || calls != 5 {
+		t.Errorf("Expected the failure not to be reused, got %d calls", calls)
+	}
+
+	// Tools that are not cached are called every time
+	uncached := cache.wrap("cqListDocuments", countingHandler(&calls, &fail))
+	uncached(ctx, callRequest("cqListDocuments", nil))
+	uncached(ctx, callRequest("cqListDocuments", nil))
+	if calls != 7 {
+		t.Errorf("Expected uncached tools to be called every time, got %d calls", calls)
+	}
+}
+
+func TestResultCacheInvalidation(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if err := db.InsertQuery(context.Background(), testDB.DB, db.Query{ID: "q1", From: "alice", Question: "Why?", Status: "pending"}); err != nil {
+		t.Fatalf("InsertQuery failed: %v", err)
+	}
+	collection, err := chromem.NewDB().CreateCollection("docs", nil, nil)
+	if err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	ctx := utils.WithDatabase(context.Background(), testDB.DB)
+	ctx = utils.WithChromemCollection(ctx, collection)
+
+	cache := newResultCache()
+	var queryCalls, datasetCalls int
+	var fail bool
+	queries := cache.wrap("cqListRequestedQueries", countingHandler(&queryCalls, &fail))
+	datasets := cache.wrap("cqGetUserDatasets", countingHandler(&datasetCalls, &fail))
+	call := func() {
+		t.Helper()
+		if _, err := queries(ctx, callRequest("cqListRequestedQueries", nil)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := datasets(ctx, callRequest("cqGetUserDatasets", map[string]any{"user_id": "alice"})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	call()
+	call()
+	if queryCalls != 1 || datasetCalls != 1 {
+		t.Fatalf("Expected the cached results, got %d and %d calls", queryCalls, datasetCalls)
+	}
+
+	// Writes through core drop what they change, whoever makes them
+	if err := core.UpdateQueryAnswer(ctx, "q1", "Because."); err != nil {
+		t.Fatalf("UpdateQueryAnswer failed: %v", err)
+	}
+	call()
+	if queryCalls != 2 || datasetCalls != 1 {
+		t.Errorf("Expected only the queries to be read again, got %d and %d calls", queryCalls, datasetCalls)
+	}
+	if _, err := core.ReviewQuery(ctx, "q1", false); err != nil {
+		t.Fatalf("ReviewQuery failed: %v", err)
+	}
+	call()
+	if queryCalls != 3 {
+		t.Errorf("Expected a review to drop the queries, got %d calls", queryCalls)
+	}
+	if err := core.RemoveDocument(ctx, "notes.txt"); err != nil {
+		t.Fatalf("RemoveDocument failed: %v", err)
+	}
+	call()
+	if queryCalls != 3 || datasetCalls != 2 {
+		t.Errorf("Expected only the datasets to be read again, got %d and %d calls", queryCalls, datasetCalls)
+	}
+}
@@ -0,0 +1,244 @@
+package mcp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"dk/core"
+	"dk/db"
+	"dk/mcp/i18n"
+	"dk/utils"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// confirmationTTL is how long a confirm token can be used
+const confirmationTTL = 5 * time.Minute
+
+// describers explain what a call of a tool requiring confirmation would do, from its
+// arguments, without doing it. Other tools are described by their arguments.
+var describers = map[string]func(ctx context.Context, args map[string]any) (string, error){
+	"cqProcessQuery":              describeProcessQuery,
+	"cqProcessQueries":            describeProcessQueries,
+	"cqProcessApplicationRequest": describeProcessApplicationRequest,
+}
+
+// dryRunTools are the tools that honour 'dry_run', listing what a call would change without
+// changing it. Only their dry runs skip the confirmation; other tools ignore the argument.
+var dryRunTools = map[string]bool{
+	"cqProcessQueries": true,
+}
+
+// pendingCall is a call awaiting confirmation
+type pendingCall struct {
+	tool    string
+	caller  string
+	digest  string // Of the arguments
+	expires time.Time
+}
+
+// confirmations are the confirm tokens handed out by tools requiring confirmation. A token
+// runs the call it was issued for once: same tool, caller and arguments. The token is bound
+// to the arguments, so a call the user has not seen described cannot run.
+type confirmations struct {
+	mu      sync.Mutex
+	pending map[string]pendingCall
+}
+
+func newConfirmations() *confirmations {
+	return &confirmations{pending: make(map[string]pendingCall)}
+}
+
+// confirmation is the answer to a call made without a valid confirm token
+type confirmation struct {
+	Message      string         `json:"message"`
+	Description  string         `json:"description"`
+	Arguments    map[string]any `json:"arguments"`
+	ConfirmToken string         `json:"confirm_token"`
+	ExpiresAt    time.Time      `json:"expires_at"`
+}
+
+// guard runs the handler when the call carries the confirm token issued for it. Otherwise it
+// describes the call and returns a new token. Dry runs of the tools in dryRunTools change
+// nothing and run right away.
+func (c *confirmations) guard(tool string, handler func(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error)) func(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	return func(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+		args := withoutToken(request.Params.Arguments)
+		if dryRun, _ := args["dry_run"].(bool); dryRun && dryRunTools[tool] {
+			return handler(ctx, request)
+		}
+		caller := callerOf(ctx)
+		digest, err := argsDigest(args)
+		if err != nil {
+			return errorResult(ErrorInvalidArgument, fmt.Sprintf("Invalid arguments: %v", err)), nil
+		}
+		if token, _ := request.Params.Arguments["confirm_token"].(string); token != "" {
+			if !c.redeem(token, tool, caller, digest) {
+				return errorResult(ErrorInvalidArgument, i18n.Message(language(ctx), "tool.confirmation_invalid", tool)), nil
+			}
+			request.Params.Arguments = args
+			return handler(ctx, request)
+		}
+
+		description, err := describe(ctx, tool, args)
+		if err != nil {
+			return errorResult(ErrorInvalidArgument, err.Error()), nil
+		}
+		token, expires, err := c.issue(tool, caller, digest)
+		if err != nil {
+			return errorResult(errorCode(err), fmt.Sprintf("Couldn't issue a confirm token: %v", err)), nil
+		}
+		blob, err := json.MarshalIndent(confirmation{
+			Message:      i18n.Message(language(ctx), "tool.confirmation_required", tool),
+			Description:  description,
+			Arguments:    args,
+			ConfirmToken: token,
+			ExpiresAt:    expires,
+		}, "", "  ")
+		if err != nil {
+			return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode the confirmation: %v", err)), nil
+		}
+		return mcp_lib.NewToolResultText(string(blob)), nil
+	}
+}
+
+// issue returns a new token for a call, forgetting the expired ones
+func (c *confirmations) issue(tool, caller, digest string) (string, time.Time, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", time.Time{}, err
+	}
+	token := hex.EncodeToString(buf)
+	now := time.Now()
+	expires := now.Add(confirmationTTL).UTC()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for t, call := range c.pending {
+		if now.After(call.expires) {
+			delete(c.pending, t)
+		}
+	}
+	c.pending[token] = pendingCall{tool: tool, caller: caller, digest: digest, expires: expires}
+	return token, expires, nil
+}
+
+// redeem reports whether a token was issued for the call and has not expired. A token is
+// used up by the first attempt, valid or not, so it cannot be guessed against.
+func (c *confirmations) redeem(token, tool, caller, digest string) bool {
+	c.mu.Lock()
+	call, ok := c.pending[token]
+	delete(c.pending, token)
+	c.mu.Unlock()
+	return ok && call.tool == tool && call.caller == caller && call.digest == digest &&
+		time.Now().Before(call.expires)
+}
+
+// callerOf identifies the session making a call, by role and user ID
+func callerOf(ctx context.Context) string {
+	principal := core.PrincipalFromContext(ctx)
+	return string(principal.Role) + ":" + principal.UserID
+}
+
+// argsDigest returns a digest of the arguments of a call
+func argsDigest(args map[string]any) (string, error) {
+	blob, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(blob)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// withoutToken returns the arguments of a call without its confirm token
+func withoutToken(args map[string]any) map[string]any {
+	rest := make(map[string]any, len(args))
+	for name, value := range args {
+		if name != "confirm_token" {
+			rest[name] = value
+		}
+	}
+	return rest
+}
+
+// describe explains what a call would do
+func describe(ctx context.Context, tool string, args map[string]any) (string, error) {
+	if describer, ok := describers[tool]; ok {
+		return describer(ctx, args)
+	}
+	blob, err := json.Marshal(args)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Call %s with the arguments %s.", tool, blob), nil
+}
+
+func describeProcessQuery(ctx context.Context, args map[string]any) (string, error) {
+	id, _ := args["id"].(string)
+	approve, ok := args["approve"].(bool)
+	if strings.TrimSpace(id) == "" || !ok {
+		return "", errors.New("'id' and 'approve' parameters are required")
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	query, err := db.GetQuery(ctx, database, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", errors.New(i18n.Message(language(ctx), "query.not_found", id))
+	}
+	if err != nil {
+		return "", err
+	}
+	if approve {
+		return fmt.Sprintf("Accept query %s from %s, %q, and send them the answer %q.", query.ID, query.From, query.Question, query.Answer), nil
+	}
+	return fmt.Sprintf("Reject query %s from %s, %q. Its answer is not sent.", query.ID, query.From, query.Question), nil
+}
+
+func describeProcessQueries(ctx context.Context, args map[string]any) (string, error) {
+	approve, ok := args["approve"].(bool)
+	if !ok {
+		return "", errors.New("'approve' parameter is required")
+	}
+	var selection core.QuerySelection
+	selection.Status, _ = args["status"].(string)
+	selection.From, _ = args["from"].(string)
+	selection.Topic, _ = args["topic"].(string)
+	outcomes, err := core.ReviewQueries(ctx, selection, approve, true)
+	if err != nil {
+		return "", err
+	}
+	action := "Reject"
+	if approve {
+		action = "Accept and send the answers of"
+	}
+	if len(outcomes) == 0 {
+		return "No query matches, so nothing would change.", nil
+	}
+	lines := []string{fmt.Sprintf("%s %d queries:", action, len(outcomes))}
+	for _, outcome := range outcomes {
+		lines = append(lines, fmt.Sprintf("- %s from %s: %q", outcome.ID, outcome.From, outcome.Question))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func describeProcessApplicationRequest(ctx context.Context, args map[string]any) (string, error) {
+	appName, _ := args["app_name"].(string)
+	approve, ok := args["approve"].(bool)
+	if strings.TrimSpace(appName) == "" || !ok {
+		return "", errors.New("'app_name' and 'approve' parameters are required")
+	}
+	if approve {
+		return fmt.Sprintf("Approve the application '%s': it moves from the inbox to the apps folder and runs.", appName), nil
+	}
+	return fmt.Sprintf("Deny the application '%s': it moves from the inbox to the rejected folder.", appName), nil
+}
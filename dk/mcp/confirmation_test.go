@@ -0,0 +1,133 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// callRequest returns a call of a tool with arguments.
+func callRequest(tool string, args map[string]any) mcp_lib.CallToolRequest {
+	var request mcp_lib.CallToolRequest
+	request.Params.Name = tool
+	request.Params.Arguments = args
+	return request
+}
+
+// resultText returns the text of a tool result.
+func resultText(t *testing.T, result *mcp_lib.CallToolResult) string {
+	t.Helper()
+	if result == nil || len(result.Content) == 0 {
+		t.Fatal("Expected a result with content")
+	}
+	text, ok := result.Content[0].(mcp_lib.TextContent)
+	if !ok {
+		t.Fatalf("Expected text content, got %T", result.Content[0])
+	}
+	return text.Text
+}
+
+// recordingHandler counts its calls and keeps the arguments of the last one.
+type recordingHandler struct {
+	calls int
+	args  map[string]any
+}
+
+func (h *recordingHandler) handle(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	h.calls++
+	h.args = request.Params.Arguments
+	return mcp_lib.NewToolResultText("done"), nil
+}
+
+// requestConfirmation calls a guarded tool without a token and returns the token issued.
+func requestConfirmation(t *testing.T, guarded func(context.Context, mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error), tool string, args map[string]any) string {
+	t.Helper()
+	result, err := guarded(context.Background(), callRequest(tool, args))
+	if err != nil || result.IsError {
+		t.Fatalf("Expected a confirmation, got %v", err)
+	}
+	var answer confirmation
+	if err := json.Unmarshal([]byte(resultText(t, result)), &answer); err != nil || answer.ConfirmToken == "" {
+		t.Fatalf("Expected a confirm token, got %v", err)
+	}
+	return answer.ConfirmToken
+}
+
+func TestConfirmationGuard(t *testing.T) {
+	confirms := newConfirmations()
+	handler := &recordingHandler{}
+	guarded := confirms.guard("cqGrantAPIAccess", handler.handle)
+	args := map[string]any{"api_id": "a1", "consumer": "bob"}
+
+	// A call without a token is described, not run
+	token := requestConfirmation(t, guarded, "cqGrantAPIAccess", args)
+	if handler.calls != 0 {
+		t.Fatal("Expected the call not to run before it is confirmed")
+	}
+
+	// The token runs the call it was issued for once, without the token in the arguments
+	confirmed := map[string]any{"api_id": "a1", "consumer": "bob", "confirm_token": token}
+	if result, _ := guarded(context.Background(), callRequest("cqGrantAPIAccess", confirmed)); result.IsError || handler.calls != 1 {
+		t.Fatalf("Expected the confirmed call to run, got %s", resultText(t, result))
+	}
+	if _, ok := handler.args["confirm_token"]; ok {
+		t.Error("Expected the confirm token to be removed from the arguments")
+	}
+	if result, _ := guarded(context.Background(), callRequest("cqGrantAPIAccess", confirmed)); !result.IsError || handler.calls != 1 {
+		t.Error("Expected a used token to be refused")
+	}
+
+	// A token does not run a call with other arguments, and is used up by the attempt
+	token = requestConfirmation(t, guarded, "cqGrantAPIAccess", args)
+	other := map[string]any{"api_id": "a1", "consumer": "mallory", "confirm_token": token}
+	if result, _ := guarded(context.Background(), callRequest("cqGrantAPIAccess", other)); !result.IsError {
+		t.Error("Expected a token to be refused for other arguments")
+	}
+	confirmed["confirm_token"] = token
+	if result, _ := guarded(context.Background(), callRequest("cqGrantAPIAccess", confirmed)); !result.IsError || handler.calls != 1 {
+		t.Error("Expected a token tried with other arguments to be used up")
+	}
+
+	// An expired token is refused
+	token = requestConfirmation(t, guarded, "cqGrantAPIAccess", args)
+	confirms.mu.Lock()
+	call := confirms.pending[token]
+	call.expires = time.Now().Add(-time.Second)
+	confirms.pending[token] = call
+	confirms.mu.Unlock()
+	confirmed["confirm_token"] = token
+	if result, _ := guarded(context.Background(), callRequest("cqGrantAPIAccess", confirmed)); !result.IsError || handler.calls != 1 {
+		t.Error("Expected an expired token to be refused")
+	}
+
+	// A token is bound to its tool
+	token = requestConfirmation(t, guarded, "cqGrantAPIAccess", args)
+	revoke := confirms.guard("cqRevokeAPIAccess", handler.handle)
+	confirmed["confirm_token"] = token
+	if result, _ := revoke(context.Background(), callRequest("cqRevokeAPIAccess", confirmed)); !result.IsError || handler.calls != 1 {
+		t.Error("Expected a token to be refused for another tool")
+	}
+}
+
+func TestConfirmationDryRun(t *testing.T) {
+	confirms := newConfirmations()
+	handler := &recordingHandler{}
+	args := map[string]any{"api_id": "a1", "consumer": "bob", "dry_run": true}
+
+	// Tools that ignore dry_run still need a confirmation
+	for _, tool := range []string{"cqGrantAPIAccess", "cqRevokeAPIAccess", "cqDeleteDocument"} {
+		requestConfirmation(t, confirms.guard(tool, handler.handle), tool, args)
+	}
+	if handler.calls != 0 {
+		t.Fatal("Expected dry_run not to skip the confirmation of tools that ignore it")
+	}
+
+	// Dry runs of the tools honouring dry_run run right away
+	review := confirms.guard("cqProcessQueries", handler.handle)
+	if result, _ := review(context.Background(), callRequest("cqProcessQueries", map[string]any{"approve": true, "dry_run": true})); result.IsError || handler.calls != 1 {
+		t.Error("Expected the dry run to run without a confirmation")
+	}
+}
@@ -0,0 +1,41 @@
+package mcp
+
+import (
+	"context"
+	"dk/utils"
+	"encoding/json"
+	"fmt"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// Tool: Connection Status
+//
+// This tool reports the state of the websocket connection to the server: connected or
+// reconnecting, the server URL, when the token expires and the last connection error, so a
+// dropped connection does not go unnoticed. With action "reconnect" it first drops the
+// connection and reconnects in the background.
+// Input parameters: optionally "action", either "status" (default) or "reconnect".
+func HandleConnectionStatusTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	dkClient, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to retrieve client from context: %v", err)), nil
+	}
+
+	action, _ := request.Params.Arguments["action"].(string)
+	switch action {
+	case "", "status":
+	case "reconnect":
+		if err := dkClient.Reconnect(); err != nil {
+			return errorResult(errorCode(err), fmt.Sprintf("Couldn't reconnect: %v", err)), nil
+		}
+	default:
+		return errorResult(ErrorInvalidArgument, fmt.Sprintf("Unknown action %q: use 'status' or 'reconnect'", action)), nil
+	}
+
+	blob, err := json.MarshalIndent(dkClient.Status(), "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode the connection status: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
@@ -0,0 +1,71 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"encoding/json"
+	"fmt"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// Tool: List Peers
+//
+// This tool lists the peers the server knows, with their status, together with the contacts
+// of the host, their aliases, notes and dataset tags.
+// Input parameters: optionally "tags" to only list peers tagged with any of them.
+func HandleListPeersTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	peers, err := core.ListPeers(ctx, stringList(request.Params.Arguments, "tags"))
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to list peers: %v", err)), nil
+	}
+	blob, err := json.MarshalIndent(peers, "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode peers: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
+
+// Tool: Update Contact
+//
+// This tool sets the alias and notes the host keeps about a peer.
+// Input parameters: "peer" and optionally "alias" and "notes"; omitted values are kept.
+func HandleUpdateContactTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	args := request.Params.Arguments
+	peer, _ := args["peer"].(string)
+	var alias, notes *string
+	if value, ok := args["alias"].(string); ok {
+		alias = &value
+	}
+	if value, ok := args["notes"].(string); ok {
+		notes = &value
+	}
+
+	contact, err := core.UpdateContact(ctx, peer, alias, notes)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't update the contact: %v", err)), nil
+	}
+	blob, _ := json.MarshalIndent(contact, "", "  ")
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
+
+// Tool: Tag Peer
+//
+// This tool adds and removes the dataset tags of a peer, which cqAskQuestion and
+// cqSubmitAppFolder accept in place of peer IDs.
+// Input parameters: "peer" (ID or alias) and "add" and/or "remove".
+func HandleTagPeerTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	args := request.Params.Arguments
+	peer, _ := args["peer"].(string)
+	add, remove := stringList(args, "add"), stringList(args, "remove")
+	if len(add) == 0 && len(remove) == 0 {
+		return errorResult(ErrorInvalidArgument, "'add' or 'remove' parameter is required"), nil
+	}
+
+	contact, err := core.TagPeer(ctx, peer, add, remove)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't tag the peer: %v", err)), nil
+	}
+	blob, _ := json.MarshalIndent(contact, "", "  ")
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
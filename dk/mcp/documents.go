@@ -0,0 +1,62 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// Tool: List Documents
+//
+// This tool lists the documents stored in the vector database with their chunk counts, sizes
+// and tags, without their content.
+// Input parameters: optionally "collection".
+func HandleListDocumentsTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	collection, _ := request.Params.Arguments["collection"].(string)
+	ctx, err := core.UseCollection(ctx, strings.TrimSpace(collection), false)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Invalid collection: %v", err)), nil
+	}
+
+	documents, err := core.ListDocumentStats(ctx)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to list documents: %v", err)), nil
+	}
+	blob, err := json.MarshalIndent(documents, "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode documents: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
+
+// Tool: Get Document Chunks
+//
+// This tool shows the chunks a document is stored in, in order, so the chunking of a
+// document can be checked.
+// Input parameters: "file_name" and optionally "collection".
+func HandleGetDocumentChunksTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	args := request.Params.Arguments
+	fileName, _ := args["file_name"].(string)
+	if strings.TrimSpace(fileName) == "" {
+		return errorResult(ErrorInvalidArgument, "'file_name' parameter is required"), nil
+	}
+	collection, _ := args["collection"].(string)
+	ctx, err := core.UseCollection(ctx, strings.TrimSpace(collection), false)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Invalid collection: %v", err)), nil
+	}
+
+	chunks, err := core.DocumentChunks(ctx, fileName)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't get the chunks of '%s': %v", fileName, err)), nil
+	}
+	blob, err := json.MarshalIndent(chunks, "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode chunks: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
@@ -0,0 +1,123 @@
+package mcp
+
+import (
+	"context"
+	"database/sql"
+	"dk/core"
+	"dk/db"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// Codes of tool errors, so that clients can act on a failure without parsing its message
+const (
+	ErrorInvalidArgument = "invalid_argument" // The arguments are missing or wrong; fix them before retrying
+	ErrorNotFound        = "not_found"        // What the call refers to does not exist
+	ErrorForbidden       = "forbidden"        // The caller may not do this
+	ErrorConflict        = "conflict"         // The call clashes with the current state, e.g. something already exists
+	ErrorRateLimited     = "rate_limited"     // The session called the tool too often; the call may succeed later
+	ErrorUnavailable     = "unavailable"      // A service is unreachable or too slow; the call may succeed later
+	ErrorInternal        = "internal"         // Anything else
+)
+
+// toolError is the envelope every failed tool call returns as its text content
+type toolError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"` // Whether the same call may succeed later
+}
+
+// errorSentinels map the errors handlers get from the core and database packages to their
+// code, checked in order with errors.Is
+var errorSentinels = []struct {
+	code string
+	errs []error
+}{
+	{ErrorUnavailable, []error{context.DeadlineExceeded}},
+	{ErrorForbidden, []error{core.ErrForbidden, core.ErrResidencyViolation, core.ErrInvalidPackSignature, core.ErrInsufficientCredits, os.ErrPermission}},
+	{ErrorNotFound, []error{db.ErrNotFound, sql.ErrNoRows, core.ErrDocumentNotFound, core.ErrCollectionNotFound, core.ErrRagSourceNotFound, core.ErrBlobNotFound, core.ErrNoTaggedPeers, os.ErrNotExist}},
+	{ErrorConflict, []error{core.ErrRagSourceExists, core.ErrExportExists, core.ErrArchiveMismatch, db.ErrAliasTaken}},
+	{ErrorInvalidArgument, []error{core.ErrInvalidRagSource, core.ErrUnsupportedSchemaVersion, core.ErrTokenBudgetExceeded, db.ErrInvalidMetadata, db.ErrInvalidCursor}},
+}
+
+// errorMarkers map words of error messages to their code, checked in order. They are the
+// fallback for errors that wrap none of errorSentinels; handlers that know the code of a
+// failure use errorResult with it.
+var errorMarkers = []struct {
+	code    string
+	markers []string
+}{
+	{ErrorInternal, []string{"not found in context"}}, // A dependency of the handler is missing
+	{ErrorUnavailable, []string{"timeout", "timed out", "deadline exceeded", "connection refused", "not connected", "unavailable", "no response", "try again"}},
+	{ErrorRateLimited, []string{"rate limit"}},
+	{ErrorForbidden, []string{"not permitted", "forbidden", "not allowed", "unauthorized"}},
+	{ErrorNotFound, []string{"not found", "doesn't exist", "does not exist", "no such"}},
+	{ErrorConflict, []string{"already exists", "already has", "already used", "cannot delete", "is inactive", "conflict"}},
+	{ErrorInvalidArgument, []string{"required", "invalid", "must be", "must not", "unknown", "expected", "unsupported", "parameter"}},
+}
+
+// errorResult returns a failed tool result with an explicit code
+func errorResult(code, message string) *mcp_lib.CallToolResult {
+	blob, err := json.MarshalIndent(toolError{Code: code, Message: message, Retryable: code == ErrorUnavailable || code == ErrorRateLimited}, "", "  ")
+	if err != nil {
+		return mcp_lib.NewToolResultError(message)
+	}
+	return mcp_lib.NewToolResultError(string(blob))
+}
+
+// errorCode returns the code of an error: that of the sentinel error it wraps or, failing that,
+// the one its message suggests
+func errorCode(err error) string {
+	for _, group := range errorSentinels {
+		for _, sentinel := range group.errs {
+			if errors.Is(err, sentinel) {
+				return group.code
+			}
+		}
+	}
+	return classifyError(err.Error())
+}
+
+// classifyError returns the code of an error message
+func classifyError(message string) string {
+	lower := strings.ToLower(message)
+	for _, group := range errorMarkers {
+		for _, marker := range group.markers {
+			if strings.Contains(lower, marker) {
+				return group.code
+			}
+		}
+	}
+	return ErrorInternal
+}
+
+// errorEnvelope puts failures of a tool call in the toolError envelope: error results that
+// are not in it yet, and errors of the handler, which would otherwise reach the client as
+// protocol errors. Successful results are returned as they are.
+func errorEnvelope(result *mcp_lib.CallToolResult, err error) (*mcp_lib.CallToolResult, error) {
+	if err != nil {
+		return errorResult(errorCode(err), err.Error()), nil
+	}
+	if result == nil || !result.IsError {
+		return result, nil
+	}
+	var texts []string
+	for _, content := range result.Content {
+		if text, ok := content.(mcp_lib.TextContent); ok {
+			texts = append(texts, text.Text)
+		}
+	}
+	message := strings.Join(texts, "\n")
+	var existing toolError
+	if json.Unmarshal([]byte(message), &existing) == nil && existing.Code != "" {
+		return result, nil
+	}
+	if message == "" {
+		message = "the tool failed without a message"
+	}
+	return errorResult(classifyError(message), message), nil
+}
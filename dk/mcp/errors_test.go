@@ -0,0 +1,204 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// decodeToolError returns the envelope of a failed tool result.
+func decodeToolError(t *testing.T, result *mcp_lib.CallToolResult) toolError {
+	t.Helper()
+	if result == nil || !result.IsError {
+		t.Fatal("Expected an error result")
+	}
+	var envelope toolError
+	if err := json.Unmarshal([]byte(resultText(t, result)), &envelope); err != nil {
+		t.Fatalf("Expected the error envelope, got %q", resultText(t, result))
+	}
+	return envelope
+}
+
+func TestErrorEnvelope(t *testing.T) {
+	// Errors of handlers take the code of the sentinel they wrap, then of their message
+	cases := []struct {
+		err       error
+		code      string
+		retryable bool
+	}{
+		{fmt.Errorf("load API: %w", db.ErrNotFound), ErrorNotFound, false},
+		{fmt.Errorf("copy: %w", core.ErrResidencyViolation), ErrorForbidden, false},
+		{fmt.Errorf("ask: %w", context.DeadlineExceeded), ErrorUnavailable, true},
+		{errors.New("connection refused"), ErrorUnavailable, true},
+		{errors.New("disk is full"), ErrorInternal, false},
+	}
+	for _, tc := range cases {
+		result, err := errorEnvelope(nil, tc.err)
+		if err != nil {
+			t.Fatalf("Expected the error in a result, got %v", err)
+		}
+		envelope := decodeToolError(t, result)
+		if envelope.Code != tc.code || envelope.Retryable != tc.retryable || envelope.Message != tc.err.Error() {
+			t.Errorf("%v: expected %s (retryable %v), got %+v", tc.err, tc.code, tc.retryable, envelope)
+		}
+	}
+
+	// Error results are put in the envelope once, keeping explicit codes
+	result, _ := errorEnvelope(mcp_lib.NewToolResultError("API 'x' not found"), nil)
+	if envelope := decodeToolError(t, result); envelope.Code != ErrorNotFound {
+		t.Errorf("Expected a classified error result, got %+v", envelope)
+	}
+	explicit := errorResult(ErrorConflict, "The query was not found in the queue")
+	if result, _ := errorEnvelope(explicit, nil); result != explicit || decodeToolError(t, result).Code != ErrorConflict {
+		t.Error("Expected an explicit code to be kept")
+	}
+	success := mcp_lib.NewToolResultText("done")
+	if result, _ := errorEnvelope(success, nil); result != success {
+		t.Error("Expected a successful result to be left alone")
+	}
+
+	// Handlers give the code of the failures they know
+	result, _ = HandleProcessQuestionTool(context.Background(), callRequest("cqProcessQuestion", map[string]any{}))
+	if envelope := decodeToolError(t, result); envelope.Code != ErrorInvalidArgument {
+		t.Errorf("Expected a missing argument to be an invalid argument, got %+v", envelope)
+	}
+}
+
+// serverTools returns the tools a server lists
+func serverTools(t *testing.T, mcpServer *server.MCPServer) []mcp_lib.Tool {
+	t.Helper()
+	response := mcpServer.HandleMessage(context.Background(), json.RawMessage(`{"jsonrpc": "2.0", "id": 1, "method": "tools/list"}`))
+	blob, err := json.Marshal(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list struct {
+		Result mcp_lib.ListToolsResult `json:"result"`
+	}
+	if err := json.Unmarshal(blob, &list); err != nil || len(list.Result.Tools) == 0 {
+		t.Fatalf("Expected the tools, got %s (%v)", blob, err)
+	}
+	return list.Result.Tools
+}
+
+func TestToolFailuresUseEnvelope(t *testing.T) {
+	// Confirmation is left out, so that every tool runs its handler
+	policy := ToolPolicy{Tools: make(map[string]ToolAccess)}
+	for tool := range confirmedTools {
+		policy.Tools[tool] = ToolEnabled
+	}
+	mcpServer, err := NewMCPServer(policy, nil)
+	if err != nil {
+		t.Fatalf("NewMCPServer failed: %v", err)
+	}
+
+	// Without arguments, a database or a client, every tool but the health check fails
+	for _, tool := range serverTools(t, mcpServer) {
+		message := fmt.Sprintf(`{"jsonrpc": "2.0", "id": 2, "method": "tools/call", "params": {"name": %q, "arguments": {}}}`, tool.Name)
+		blob, err := json.Marshal(mcpServer.HandleMessage(context.Background(), json.RawMessage(message)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response struct {
+			Result struct {
+				Content []mcp_lib.TextContent `json:"content"`
+				IsError bool                  `json:"isError"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(blob, &response); err != nil {
+			t.Fatalf("%s: invalid response %s", tool.Name, blob)
+		}
+		if tool.Name == "health" {
+			continue
+		}
+		if !response.Result.IsError || len(response.Result.Content) == 0 {
+			t.Errorf("%s: expected an error result, got %s", tool.Name, blob)
+			continue
+		}
+		var envelope toolError
+		text := response.Result.Content[0].Text
+		if err := json.Unmarshal([]byte(text), &envelope); err != nil || envelope.Code == "" || envelope.Message == "" {
+			t.Errorf("%s: expected the error envelope, got %q", tool.Name, text)
+		}
+	}
+}
+
+// blockingProvider never answers until its context ends.
+type blockingProvider struct{}
+
+func (blockingProvider) GenerateAnswer(ctx context.Context, question string, docs []core.Document) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+func (blockingProvider) GenerateStream(ctx context.Context, prompt string) (<-chan core.Chunk, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (blockingProvider) CheckAutomaticApproval(ctx context.Context, answer string, query core.Query, conditions []string) (string, bool, error) {
+	return "", false, nil
+}
+
+func (blockingProvider) GenerateDescription(ctx context.Context, text string) (string, error) {
+	return "", nil
+}
+
+func TestHandleHealthTool(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	defer func(timeout time.Duration) { healthTimeout = timeout }(healthTimeout)
+	healthTimeout = 200 * time.Millisecond
+
+	ctx := utils.WithDatabase(context.Background(), testDB.DB)
+	ctx = core.WithLLMProvider(ctx, blockingProvider{})
+	health := func(args map[string]any) healthReport {
+		t.Helper()
+		result, err := HandleHealthTool(ctx, callRequest("cqHealth", args))
+		if err != nil || result.IsError {
+			t.Fatalf("HandleHealthTool failed: %v", err)
+		}
+		var report healthReport
+		if err := json.Unmarshal([]byte(resultText(t, result)), &report); err != nil {
+			t.Fatalf("Invalid health report: %v", err)
+		}
+		return report
+	}
+
+	// Without a probe, the LLM is only reported as configured
+	report := health(map[string]any{})
+	want := map[string]string{"database": HealthOK, "vector_db": HealthUnconfigured, "llm": HealthOK, "websocket": HealthUnconfigured}
+	for name, status := range want {
+		if report.Components[name].Status != status {
+			t.Errorf("Expected %s to be %s, got %+v", name, status, report.Components[name])
+		}
+	}
+	if report.Status != HealthUnconfigured {
+		t.Errorf("Expected the worst status of the components, got %s", report.Status)
+	}
+
+	// A probe that times out reports the LLM down, within the timeout of the check
+	start := time.Now()
+	report = health(map[string]any{"probe_llm": true})
+	if elapsed := time.Since(start); elapsed > 2*healthTimeout {
+		t.Errorf("Expected the checks to end with their timeout, took %v", elapsed)
+	}
+	if llm := report.Components["llm"]; llm.Status != HealthDown || llm.LatencyMS < healthTimeout.Milliseconds() {
+		t.Errorf("Expected the LLM to be down after the timeout, got %+v", llm)
+	}
+	if report.Status != HealthDown {
+		t.Errorf("Expected the report to be down, got %s", report.Status)
+	}
+}
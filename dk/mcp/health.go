@@ -0,0 +1,169 @@
+package mcp
+
+import (
+	"context"
+	dk_client "dk/client"
+	"dk/core"
+	"dk/utils"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// healthTimeout bounds the check of each component
+var healthTimeout = 20 * time.Second
+
+// llmProbePrompt is sent to the LLM when the health check probes it
+const llmProbePrompt = "Reply with the single word OK."
+
+// Health of a component, from best to worst
+const (
+	HealthOK           = "ok"
+	HealthUnconfigured = "unconfigured" // The component is not set up
+	HealthDegraded     = "degraded"     // It works partly, or is recovering
+	HealthDown         = "down"
+)
+
+// healthRank orders the health states, so that the report takes the worst of its components
+var healthRank = map[string]int{HealthOK: 0, HealthUnconfigured: 1, HealthDegraded: 2, HealthDown: 3}
+
+// componentHealth is the health of one service dk relies on
+type componentHealth struct {
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// healthReport is the health of dk and the services it relies on
+type healthReport struct {
+	Status     string                     `json:"status"` // The worst status of the components
+	CheckedAt  time.Time                  `json:"checked_at"`
+	Components map[string]componentHealth `json:"components"`
+}
+
+// Tool: Health
+//
+// This tool checks the services dk relies on: the database, the vector database, the LLM
+// provider and the websocket connection to the server. The LLM is only called when
+// "probe_llm" is set, since calls may be billed.
+// Input parameters: optionally "probe_llm".
+func HandleHealthTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	probeLLM, _ := request.Params.Arguments["probe_llm"].(bool)
+
+	checks := map[string]func(ctx context.Context) componentHealth{
+		"database":  databaseHealth,
+		"vector_db": vectorDBHealth,
+		"llm":       func(ctx context.Context) componentHealth { return llmHealth(ctx, probeLLM) },
+	}
+	report := healthReport{
+		Status:     HealthOK,
+		CheckedAt:  time.Now().UTC(),
+		Components: make(map[string]componentHealth, len(checks)+1),
+	}
+
+	// The checks run concurrently, so the report takes as long as the slowest of them
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			health := timedCheck(ctx, check)
+			mu.Lock()
+			report.Components[name] = health
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	report.Components["websocket"] = websocketHealth(ctx)
+
+	for _, component := range report.Components {
+		if healthRank[component.Status] > healthRank[report.Status] {
+			report.Status = component.Status
+		}
+	}
+
+	blob, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode the health report: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
+
+// timedCheck runs a health check within healthTimeout and records how long it took
+func timedCheck(ctx context.Context, check func(ctx context.Context) componentHealth) componentHealth {
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+	start := time.Now()
+	health := check(ctx)
+	if health.Status != HealthUnconfigured {
+		health.LatencyMS = time.Since(start).Milliseconds()
+	}
+	return health
+}
+
+func databaseHealth(ctx context.Context) componentHealth {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return componentHealth{Status: HealthUnconfigured, Detail: err.Error()}
+	}
+	var one int
+	if err := database.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return componentHealth{Status: HealthDown, Detail: err.Error()}
+	}
+	return componentHealth{Status: HealthOK}
+}
+
+func vectorDBHealth(ctx context.Context) componentHealth {
+	collection, err := utils.ChromemCollectionFromContext(ctx)
+	if err != nil || collection == nil {
+		return componentHealth{Status: HealthUnconfigured, Detail: "no vector collection"}
+	}
+	if err := core.CheckChromemHealth(ctx); err != nil {
+		return componentHealth{Status: HealthDown, Detail: err.Error()}
+	}
+	return componentHealth{Status: HealthOK, Detail: fmt.Sprintf("%d documents", collection.Count())}
+}
+
+func llmHealth(ctx context.Context, probe bool) componentHealth {
+	provider, err := core.LLMProviderFromContext(ctx)
+	if err != nil {
+		return componentHealth{Status: HealthUnconfigured, Detail: err.Error()}
+	}
+	if !probe {
+		return componentHealth{Status: HealthOK, Detail: "configured; set probe_llm to call it"}
+	}
+	stream, err := provider.GenerateStream(ctx, llmProbePrompt)
+	if err == nil {
+		_, err = core.CollectStream(ctx, stream)
+	}
+	if err != nil {
+		return componentHealth{Status: HealthDown, Detail: err.Error()}
+	}
+	return componentHealth{Status: HealthOK, Detail: "answered a probe"}
+}
+
+func websocketHealth(ctx context.Context) componentHealth {
+	dkClient, err := utils.DkFromContext(ctx)
+	if err != nil {
+		return componentHealth{Status: HealthUnconfigured, Detail: err.Error()}
+	}
+	status := dkClient.Status()
+	health := componentHealth{Detail: status.ServerURL}
+	switch status.State {
+	case dk_client.StateConnected:
+		health.Status = HealthOK
+	case dk_client.StateReconnecting:
+		health.Status = HealthDegraded
+		health.Detail = fmt.Sprintf("reconnecting to %s, attempt %d", status.ServerURL, status.ReconnectAttempt)
+	default:
+		health.Status = HealthDown
+	}
+	if health.Status != HealthOK && status.LastError != "" {
+		health.Detail += ": " + status.LastError
+	}
+	return health
+}
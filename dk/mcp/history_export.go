@@ -0,0 +1,57 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"dk/utils"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// Tool: Export History
+//
+// This tool writes the queries peers asked, with their answers and statuses, and the answers
+// peers sent, for a date range to a JSON or CSV file, for compliance reporting.
+// Input parameters: "path" and optionally "format", "since", "until" and "overwrite".
+func HandleExportHistoryTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	args := request.Params.Arguments
+	options := core.HistoryExportOptions{}
+	options.Path, _ = args["path"].(string)
+	options.Format, _ = args["format"].(string)
+	options.Overwrite, _ = args["overwrite"].(bool)
+	if since, _ := args["since"].(string); since != "" {
+		t, err := parseToolDate(since)
+		if err != nil {
+			return errorResult(ErrorInvalidArgument, err.Error()), nil
+		}
+		options.Since = t
+	}
+	if until, _ := args["until"].(string); until != "" {
+		t, err := parseToolDate(until)
+		if err != nil {
+			return errorResult(ErrorInvalidArgument, err.Error()), nil
+		}
+		// A plain date includes the whole day
+		if _, err := time.Parse("2006-01-02", until); err == nil {
+			t = t.AddDate(0, 0, 1)
+		}
+		options.Until = t
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Couldn't access the database: %v", err)), nil
+	}
+	export, err := core.ExportHistory(ctx, database, options)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't export the history: %v", err)), nil
+	}
+	blob, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode the export: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
@@ -0,0 +1,160 @@
+// Package i18n translates the text the MCP server shows to users: the descriptions of tools and
+// prompts and the messages tools and prompts return. Tool names, parameter names and the
+// statuses stored in the database are identifiers and are never translated.
+//
+// Each language is a JSON catalog in the locales directory, named after its language tag. A
+// catalog may be partial: text it lacks falls back to English.
+package i18n
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default is the language used when none is selected, and the fallback of every catalog
+const Default = "en"
+
+// ToolText is the translated metadata of a tool
+type ToolText struct {
+	Description string            `json:"description,omitempty"`
+	Params      map[string]string `json:"params,omitempty"` // Parameter descriptions, by name
+}
+
+// PromptText is the translated metadata of a prompt
+type PromptText struct {
+	Description string            `json:"description,omitempty"`
+	Arguments   map[string]string `json:"arguments,omitempty"` // Argument descriptions, by name
+}
+
+// Catalog holds the translations of one language
+type Catalog struct {
+	Name     string                `json:"name"` // Name of the language, in that language
+	Tools    map[string]ToolText   `json:"tools,omitempty"`
+	Prompts  map[string]PromptText `json:"prompts,omitempty"`
+	Messages map[string]string     `json:"messages"` // fmt format strings, by key
+}
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalogs holds the catalog of each supported language, by language tag
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]Catalog {
+	entries, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Sprintf("i18n: read locales: %v", err))
+	}
+	loaded := make(map[string]Catalog, len(entries))
+	for _, entry := range entries {
+		raw, err := localeFiles.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("i18n: read %s: %v", entry.Name(), err))
+		}
+		var catalog Catalog
+		if err := json.Unmarshal(raw, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: parse %s: %v", entry.Name(), err))
+		}
+		loaded[strings.TrimSuffix(entry.Name(), ".json")] = catalog
+	}
+	return loaded
+}
+
+// Languages returns the tags of the supported languages, sorted
+func Languages() []string {
+	tags := make([]string, 0, len(catalogs))
+	for tag := range catalogs {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// Name returns the name of a supported language, in that language
+func Name(lang string) string {
+	return catalogs[lang].Name
+}
+
+// Supported returns the supported language a tag such as "pt-BR" or "es_ES" selects: the tag
+// itself if it has a catalog, otherwise its base language
+func Supported(tag string) (string, bool) {
+	tag = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if _, ok := catalogs[tag]; ok {
+		return tag, true
+	}
+	base, _, _ := strings.Cut(tag, "-")
+	if _, ok := catalogs[base]; ok {
+		return base, true
+	}
+	return "", false
+}
+
+// Negotiate returns the supported language an Accept-Language header prefers, or "" if it
+// names none
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if lang, ok := Supported(tag); ok && q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// Message returns the message of a key in a language, formatted with args. Keys missing from
+// the catalog of the language are taken from English.
+func Message(lang, key string, args ...any) string {
+	format, ok := catalogs[lang].Messages[key]
+	if !ok {
+		format, ok = catalogs[Default].Messages[key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Tool returns the translated metadata of a tool, if the language has any
+func Tool(lang, name string) (ToolText, bool) {
+	text, ok := catalogs[lang].Tools[name]
+	return text, ok
+}
+
+// Prompt returns the translated metadata of a prompt, if the language has any
+func Prompt(lang, name string) (PromptText, bool) {
+	text, ok := catalogs[lang].Prompts[name]
+	return text, ok
+}
+
+type languageKey struct{}
+
+// WithLanguage sets the language of the requests made with the context
+func WithLanguage(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, languageKey{}, lang)
+}
+
+// LanguageFromContext returns the language of the context, English unless one was set
+func LanguageFromContext(ctx context.Context) string {
+	if lang, ok := ctx.Value(languageKey{}).(string); ok && lang != "" {
+		return lang
+	}
+	return Default
+}
@@ -0,0 +1,79 @@
+package i18n
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestSupported(t *testing.T) {
+	cases := map[string]string{"es": "es", "pt-BR": "pt", "ES_es": "es", " en ": "en", "de": "", "": ""}
+	for tag, want := range cases {
+		got, ok := Supported(tag)
+		if got != want || ok != (want != "") {
+			t.Errorf("Supported(%q) = %q, %t; want %q", tag, got, ok, want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]string{
+		"pt-BR,pt;q=0.9,en;q=0.8": "pt",
+		"de-DE,es;q=0.5,en;q=0.7": "en",
+		"de, fr;q=0.9":            "",
+		"es;q=bad,en":             "en",
+		"":                        "",
+	}
+	for header, want := range cases {
+		if got := Negotiate(header); got != want {
+			t.Errorf("Negotiate(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	if got := Message("es", "status.accepted"); got != "aceptada" {
+		t.Errorf("Expected the Spanish status, got %q", got)
+	}
+	if got := Message("es", "query.not_found", "q-1"); got != "no se encontró la consulta con ID 'q-1'" {
+		t.Errorf("Unexpected formatted message: %q", got)
+	}
+	if got := Message("xx", "query.not_found", "q-1"); got != "query with ID 'q-1' not found" {
+		t.Errorf("Expected English for an unknown language, got %q", got)
+	}
+	if got := Message("es", "no.such.key"); got != "no.such.key" {
+		t.Errorf("Expected an unknown key to be returned as is, got %q", got)
+	}
+
+	ctx := context.Background()
+	if got := LanguageFromContext(ctx); got != Default {
+		t.Errorf("Expected the default language, got %q", got)
+	}
+	if got := LanguageFromContext(WithLanguage(ctx, "pt")); got != "pt" {
+		t.Errorf("Expected the language of the context, got %q", got)
+	}
+}
+
+// Translations are formatted with the arguments of the English message, so they must use the
+// same verbs in the same order
+func TestCatalogsMatchEnglish(t *testing.T) {
+	verbs := regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+	english := catalogs[Default]
+	for _, lang := range Languages() {
+		catalog := catalogs[lang]
+		if catalog.Name == "" {
+			t.Errorf("%s: missing name", lang)
+		}
+		for key, format := range catalog.Messages {
+			source, ok := english.Messages[key]
+			if !ok {
+				t.Errorf("%s: message %q is not in the English catalog", lang, key)
+				continue
+			}
+			if got, want := verbs.FindAllString(format, -1), verbs.FindAllString(source, -1); !slices.Equal(got, want) {
+				t.Errorf("%s: message %q uses verbs %v, English uses %v", lang, key, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"encoding/json"
+	"fmt"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// Tool: Knowledge Gaps
+//
+// This tool reports the topics of the incoming queries that were rejected or could not be
+// answered from the knowledge base, with documents suggested to fill each gap.
+// Input parameters: optional "refresh" to run the analysis again.
+func HandleKnowledgeGapsTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	refresh, _ := request.Params.Arguments["refresh"].(bool)
+	report := core.LastKnowledgeGapReport()
+	if report == nil || refresh {
+		var err error
+		report, err = core.AnalyzeKnowledgeGaps(ctx)
+		if err != nil {
+			return errorResult(errorCode(err), fmt.Sprintf("Failed to analyze knowledge gaps: %v", err)), nil
+		}
+	}
+
+	blob, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode knowledge gaps: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
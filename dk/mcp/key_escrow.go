@@ -0,0 +1,149 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// stringList returns the strings of an array argument
+func stringList(args map[string]interface{}, name string) []string {
+	var out []string
+	if items, ok := args[name].([]any); ok {
+		for _, item := range items {
+			if str, ok := item.(string); ok && strings.TrimSpace(str) != "" {
+				out = append(out, strings.TrimSpace(str))
+			}
+		}
+	}
+	return out
+}
+
+// Tool: Distribute Key Shares
+//
+// This tool splits the identity key into shares and sends one to each trustee.
+// Input parameters: "trustees" (peer IDs) and "threshold" (shares needed to recover the key).
+func HandleDistributeKeySharesTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	trustees := stringList(req.Params.Arguments, "trustees")
+	threshold, ok := req.Params.Arguments["threshold"].(float64)
+	if !ok {
+		return errorResult(ErrorInvalidArgument, "'threshold' parameter is required"), nil
+	}
+
+	records, err := core.DistributeIdentityKey(ctx, trustees, int(threshold))
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't distribute key shares: %v", err)), nil
+	}
+	names := make([]string, 0, len(records))
+	for _, r := range records {
+		names = append(names, r.TrusteeID)
+	}
+	return mcp_lib.NewToolResultText(fmt.Sprintf(
+		"Identity key split into %d shares. Any %d of %s can help you recover it.",
+		len(records), int(threshold), strings.Join(names, ", "))), nil
+}
+
+// Tool: List Key Escrow
+//
+// This tool lists this peer's trustees, the shares it holds for others and the recovery
+// requests it received.
+// Input parameters: optional "status" filter for the recovery requests.
+func HandleListKeyEscrowTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, err.Error()), nil
+	}
+	status, _ := req.Params.Arguments["status"].(string)
+
+	trustees, err := db.ListEscrowTrustees(ctx, database)
+	if err != nil {
+		return errorResult(errorCode(err), err.Error()), nil
+	}
+	held, err := db.ListHeldEscrowShares(ctx, database)
+	if err != nil {
+		return errorResult(errorCode(err), err.Error()), nil
+	}
+	requests, err := db.ListEscrowRequests(ctx, database, strings.TrimSpace(status))
+	if err != nil {
+		return errorResult(errorCode(err), err.Error()), nil
+	}
+
+	blob, _ := json.MarshalIndent(map[string]interface{}{
+		"trustees":          trustees,
+		"held_shares":       held,
+		"recovery_requests": requests,
+	}, "", "  ")
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
+
+// Tool: Decide Key Recovery
+//
+// This tool approves or denies a request to release a held share.
+// Input parameters: "id" of the recovery request and "approve".
+func HandleDecideKeyRecoveryTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	id, _ := req.Params.Arguments["id"].(string)
+	if strings.TrimSpace(id) == "" {
+		return errorResult(ErrorInvalidArgument, "'id' parameter is required"), nil
+	}
+	approve, _ := req.Params.Arguments["approve"].(bool)
+
+	request, err := core.DecideKeyRecovery(ctx, strings.TrimSpace(id), approve)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't process recovery request: %v", err)), nil
+	}
+	if approve {
+		return mcp_lib.NewToolResultText(fmt.Sprintf("Share of %s's key released to %s.", request.OwnerID, request.RequesterID)), nil
+	}
+	return mcp_lib.NewToolResultText(fmt.Sprintf("Recovery request %s denied.", request.ID)), nil
+}
+
+// Tool: Request Key Recovery
+//
+// This tool asks trustees to release their shares of a lost identity key to this device.
+// Input parameters: "owner" (the lost identity) and "trustees".
+func HandleRequestKeyRecoveryTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	owner, _ := req.Params.Arguments["owner"].(string)
+	trustees := stringList(req.Params.Arguments, "trustees")
+
+	if err := core.RequestKeyRecovery(ctx, owner, trustees); err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't request key recovery: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(fmt.Sprintf(
+		"Recovery requested from %d trustees. Each trustee has to approve the request before its share arrives.", len(trustees))), nil
+}
+
+// Tool: Complete Key Recovery
+//
+// This tool reconstructs a lost identity key from the collected shares and writes it to disk.
+// Input parameters: "owner", "private_key_path" and "public_key_path".
+func HandleCompleteKeyRecoveryTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	owner, _ := req.Params.Arguments["owner"].(string)
+	privatePath, _ := req.Params.Arguments["private_key_path"].(string)
+	publicPath, _ := req.Params.Arguments["public_key_path"].(string)
+	if strings.TrimSpace(owner) == "" || strings.TrimSpace(privatePath) == "" || strings.TrimSpace(publicPath) == "" {
+		return errorResult(ErrorInvalidArgument, "'owner', 'private_key_path' and 'public_key_path' are required"), nil
+	}
+
+	privatePath, err := utils.ExpandHomePath(strings.TrimSpace(privatePath))
+	if err != nil {
+		return errorResult(ErrorInvalidArgument, fmt.Sprintf("Invalid private key path: %v", err)), nil
+	}
+	publicPath, err = utils.ExpandHomePath(strings.TrimSpace(publicPath))
+	if err != nil {
+		return errorResult(ErrorInvalidArgument, fmt.Sprintf("Invalid public key path: %v", err)), nil
+	}
+
+	status, err := core.CompleteKeyRecovery(ctx, strings.TrimPrefix(strings.TrimSpace(owner), "@"), privatePath, publicPath)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't recover key: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(fmt.Sprintf(
+		"Identity key of %s recovered from the shares of %s. Restart with -userId %s -private %s -public %s.",
+		status.Owner, strings.Join(status.Trustees, ", "), status.Owner, privatePath, publicPath)), nil
+}
@@ -0,0 +1,124 @@
+package mcp
+
+import (
+	"context"
+	"dk/mcp/i18n"
+	"strings"
+	"sync"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// sessionLanguages holds the languages chosen with cqSetLanguage, by MCP session ID
+var sessionLanguages sync.Map
+
+// language returns the language of a request: the one chosen for its session with
+// cqSetLanguage, otherwise the one of its connection
+func language(ctx context.Context) string {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		if lang, ok := sessionLanguages.Load(session.SessionID()); ok {
+			return lang.(string)
+		}
+	}
+	return i18n.LanguageFromContext(ctx)
+}
+
+// statusText returns the translation of a query status, or the status itself if it has none
+func statusText(lang, status string) string {
+	key := "status." + status
+	if text := i18n.Message(lang, key); text != key {
+		return text
+	}
+	return status
+}
+
+// Tool: Set Language
+//
+// This tool chooses the language of the tool and prompt descriptions and of the messages tools
+// return for the rest of the session.
+// Input parameters: "language", a language tag such as "es" or "pt-BR".
+func HandleSetLanguageTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	tag, _ := request.Params.Arguments["language"].(string)
+	lang, ok := i18n.Supported(tag)
+	if !ok {
+		return errorResult(ErrorInvalidArgument, i18n.Message(language(ctx), "language.unsupported", tag, strings.Join(i18n.Languages(), ", "))), nil
+	}
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil {
+		return errorResult(ErrorInternal, "No MCP session to set the language of"), nil
+	}
+	sessionLanguages.Store(session.SessionID(), lang)
+	return mcp_lib.NewToolResultText(i18n.Message(lang, "language.set", i18n.Name(lang), lang)), nil
+}
+
+// localizeTools translates the descriptions of listed tools into the language of the request.
+// The tools are copied, so the registered ones keep their English descriptions.
+func localizeTools(ctx context.Context, result *mcp_lib.ListToolsResult) {
+	lang := language(ctx)
+	if lang == i18n.Default {
+		return
+	}
+	tools := make([]mcp_lib.Tool, len(result.Tools))
+	for i, tool := range result.Tools {
+		text, ok := i18n.Tool(lang, tool.Name)
+		if ok {
+			if text.Description != "" {
+				tool.Description = text.Description
+			}
+			tool.InputSchema.Properties = localizeProperties(tool.InputSchema.Properties, text.Params)
+		}
+		tools[i] = tool
+	}
+	result.Tools = tools
+}
+
+// localizeProperties returns a copy of the input schema properties of a tool with the given
+// parameter descriptions
+func localizeProperties(properties map[string]any, descriptions map[string]string) map[string]any {
+	if len(descriptions) == 0 {
+		return properties
+	}
+	localized := make(map[string]any, len(properties))
+	for name, property := range properties {
+		schema, ok := property.(map[string]any)
+		description, translated := descriptions[name]
+		if !ok || !translated {
+			localized[name] = property
+			continue
+		}
+		copied := make(map[string]any, len(schema))
+		for key, value := range schema {
+			copied[key] = value
+		}
+		copied["description"] = description
+		localized[name] = copied
+	}
+	return localized
+}
+
+// localizePrompts translates the descriptions of listed prompts into the language of the request
+func localizePrompts(ctx context.Context, result *mcp_lib.ListPromptsResult) {
+	lang := language(ctx)
+	if lang == i18n.Default {
+		return
+	}
+	prompts := make([]mcp_lib.Prompt, len(result.Prompts))
+	for i, prompt := range result.Prompts {
+		if text, ok := i18n.Prompt(lang, prompt.Name); ok {
+			if text.Description != "" {
+				prompt.Description = text.Description
+			}
+			arguments := make([]mcp_lib.PromptArgument, len(prompt.Arguments))
+			for j, argument := range prompt.Arguments {
+				if description, ok := text.Arguments[argument.Name]; ok {
+					argument.Description = description
+				}
+				arguments[j] = argument
+			}
+			prompt.Arguments = arguments
+		}
+		prompts[i] = prompt
+	}
+	result.Prompts = prompts
+}
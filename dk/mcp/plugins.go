@@ -0,0 +1,221 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	defaultPluginTimeout = 30 * time.Second
+	maxPluginOutput      = 4 << 20
+)
+
+// Plugin adds site-specific tools to the MCP server without changing dk. It is described by a
+// JSON manifest in the plugins directory:
+//
+//	{"command": "./weather", "args": ["--json"], "timeout_seconds": 10,
+//	 "tools": [{"name": "weather", "description": "...", "input_schema": {"type": "object", ...}}]}
+//
+// For every call dk runs the command in the plugins directory and writes a JSON-RPC 2.0
+// request to its standard input: "tools/call" with the tool name and arguments. The command
+// writes the response to its standard output and exits. When the manifest lists no tools, dk
+// asks the command for them with "tools/list" when it starts.
+type Plugin struct {
+	Name           string       `json:"-"` // Base name of the manifest
+	Command        string       `json:"command"`
+	Args           []string     `json:"args,omitempty"`
+	TimeoutSeconds int          `json:"timeout_seconds,omitempty"` // 30 seconds when not set
+	Tools          []PluginTool `json:"tools,omitempty"`
+
+	dir string
+}
+
+// PluginTool is a tool a plugin provides
+type PluginTool struct {
+	Name        string                  `json:"name"`
+	Description string                  `json:"description"`
+	InputSchema mcp_lib.ToolInputSchema `json:"input_schema"`
+}
+
+// pluginContent is an item of the result of a plugin call; only text is supported
+type pluginContent struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type pluginResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// LoadPlugins reads the plugin manifests, the *.json files of dir, in name order. An empty
+// dir loads no plugins.
+func LoadPlugins(ctx context.Context, dir string) ([]Plugin, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	manifests, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(manifests)
+
+	plugins := make([]Plugin, 0, len(manifests))
+	for _, manifest := range manifests {
+		data, err := os.ReadFile(manifest)
+		if err != nil {
+			return nil, err
+		}
+		plugin := Plugin{Name: strings.TrimSuffix(filepath.Base(manifest), ".json"), dir: dir}
+		if err := json.Unmarshal(data, &plugin); err != nil {
+			return nil, fmt.Errorf("invalid plugin manifest %s: %w", manifest, err)
+		}
+		if strings.TrimSpace(plugin.Command) == "" {
+			return nil, fmt.Errorf("plugin %s: command is required", plugin.Name)
+		}
+		if len(plugin.Tools) == 0 {
+			if plugin.Tools, err = plugin.listTools(ctx); err != nil {
+				return nil, fmt.Errorf("plugin %s: list tools: %w", plugin.Name, err)
+			}
+		}
+		seen := make(map[string]bool)
+		for i, tool := range plugin.Tools {
+			if strings.TrimSpace(tool.Name) == "" || seen[tool.Name] {
+				return nil, fmt.Errorf("plugin %s: tool names must be set and unique", plugin.Name)
+			}
+			seen[tool.Name] = true
+			if plugin.Tools[i].InputSchema.Type == "" {
+				plugin.Tools[i].InputSchema.Type = "object"
+			}
+			if plugin.Tools[i].InputSchema.Properties == nil {
+				plugin.Tools[i].InputSchema.Properties = map[string]any{}
+			}
+		}
+		plugins = append(plugins, plugin)
+	}
+	return plugins, nil
+}
+
+// listTools asks the command of the plugin for its tools
+func (p Plugin) listTools(ctx context.Context) ([]PluginTool, error) {
+	result, err := p.call(ctx, "tools/list", map[string]any{})
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Tools []PluginTool `json:"tools"`
+	}
+	if err := json.Unmarshal(result, &list); err != nil {
+		return nil, fmt.Errorf("invalid tools/list result: %w", err)
+	}
+	if len(list.Tools) == 0 {
+		return nil, errors.New("the plugin has no tools")
+	}
+	return list.Tools, nil
+}
+
+// handler returns the handler of a tool of the plugin
+func (p Plugin) handler(tool string) func(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	return func(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+		arguments := request.Params.Arguments
+		if arguments == nil {
+			arguments = map[string]any{}
+		}
+		result, err := p.call(ctx, "tools/call", map[string]any{"name": tool, "arguments": arguments})
+		if err != nil {
+			return errorResult(errorCode(err), fmt.Sprintf("Plugin %s failed: %v", p.Name, err)), nil
+		}
+		var call struct {
+			Content []pluginContent `json:"content"`
+			IsError bool            `json:"isError"`
+		}
+		if err := json.Unmarshal(result, &call); err != nil {
+			return errorResult(ErrorInternal, fmt.Sprintf("Plugin %s returned an invalid result: %v", p.Name, err)), nil
+		}
+		content := make([]mcp_lib.Content, 0, len(call.Content))
+		for _, item := range call.Content {
+			if item.Type != "text" {
+				return errorResult(ErrorInternal, fmt.Sprintf("Plugin %s returned unsupported %q content", p.Name, item.Type)), nil
+			}
+			content = append(content, mcp_lib.NewTextContent(item.Text))
+		}
+		return &mcp_lib.CallToolResult{Content: content, IsError: call.IsError}, nil
+	}
+}
+
+// call runs the command of the plugin with a JSON-RPC request and returns the result of its
+// response
+func (p Plugin) call(ctx context.Context, method string, params any) (json.RawMessage, error) {
+	timeout := defaultPluginTimeout
+	if p.TimeoutSeconds > 0 {
+		timeout = time.Duration(p.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	request, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, p.Command, p.Args...)
+	cmd.Dir = p.dir
+	cmd.Stdin = bytes.NewReader(append(request, '\n'))
+	var stdout, stderr limitedBuffer
+	stdout.limit, stderr.limit = maxPluginOutput, 4096
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("no response within %v", timeout)
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%w: %s", err, message)
+		}
+		return nil, err
+	}
+	if stdout.truncated {
+		return nil, fmt.Errorf("response larger than %d bytes", maxPluginOutput)
+	}
+
+	var response pluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return nil, fmt.Errorf("invalid JSON-RPC response: %w", err)
+	}
+	if response.Error != nil {
+		return nil, fmt.Errorf("%s (code %d)", response.Error.Message, response.Error.Code)
+	}
+	return response.Result, nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it. The buffer is not embedded, so
+// that io.Copy cannot bypass the limit with bytes.Buffer.ReadFrom.
+type limitedBuffer struct {
+	buffer    bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buffer.Len(); len(p) > room {
+		b.truncated = true
+		b.buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.buffer.Write(p)
+}
+
+func (b *limitedBuffer) Bytes() []byte  { return b.buffer.Bytes() }
+func (b *limitedBuffer) String() string { return b.buffer.String() }
@@ -0,0 +1,202 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestPluginHelperProcess is not a test: the plugins of the tests run the test binary again
+// with this test selected, and it acts as the plugin named after "--".
+func TestPluginHelperProcess(t *testing.T) {
+	if os.Getenv("DK_PLUGIN_HELPER") != "1" {
+		return
+	}
+	var mode string
+	for i, arg := range os.Args {
+		if arg == "--" && i+1 < len(os.Args) {
+			mode = os.Args[i+1]
+		}
+	}
+
+	var request struct {
+		Method string `json:"method"`
+		Params struct {
+			Name      string         `json:"name"`
+			Arguments map[string]any `json:"arguments"`
+		} `json:"params"`
+	}
+	if err := json.NewDecoder(os.Stdin).Decode(&request); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	respond := func(result any) {
+		json.NewEncoder(os.Stdout).Encode(map[string]any{"jsonrpc": "2.0", "id": 1, "result": result})
+	}
+
+	switch {
+	case mode == "rpc-error":
+		json.NewEncoder(os.Stdout).Encode(map[string]any{"jsonrpc": "2.0", "id": 1,
+			"error": map[string]any{"code": -32602, "message": "unknown city"}})
+	case request.Method == "tools/list" && mode == "no-tools":
+		respond(map[string]any{"tools": []any{}})
+	case request.Method == "tools/list":
+		respond(map[string]any{"tools": []map[string]any{
+			{"name": "echo", "description": "Echo the arguments"},
+			{"name": "summary", "description": "Echo the arguments", "input_schema": map[string]any{"type": "object"}},
+		}})
+	case mode == "slow":
+		time.Sleep(10 * time.Second)
+	case mode == "large":
+		os.Stdout.Write([]byte(strings.Repeat(" ", maxPluginOutput+1)))
+	case mode == "image":
+		respond(map[string]any{"content": []map[string]any{{"type": "image", "data": "AA=="}}})
+	default:
+		arguments, _ := json.Marshal(request.Params.Arguments)
+		respond(map[string]any{"content": []map[string]any{{"type": "text", "text": request.Params.Name + " " + string(arguments)}}})
+	}
+	os.Exit(0)
+}
+
+// writePluginManifest writes to dir the manifest of a plugin run by the helper process in mode.
+func writePluginManifest(t *testing.T, dir, name, mode string, tools string) {
+	t.Helper()
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest := map[string]any{
+		"command":         executable,
+		"args":            []string{"-test.run=^TestPluginHelperProcess$", "--", mode},
+		"timeout_seconds": 30,
+	}
+	if mode == "slow" {
+		manifest["timeout_seconds"] = 1
+	}
+	if tools != "" {
+		manifest["tools"] = json.RawMessage(tools)
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".json"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadPlugins(t *testing.T) {
+	t.Setenv("DK_PLUGIN_HELPER", "1")
+	ctx := context.Background()
+
+	// Plugins without tools in the manifest are asked for them
+	dir := t.TempDir()
+	writePluginManifest(t, dir, "weather", "", `[{"name": "weather", "description": "Forecast"}]`)
+	writePluginManifest(t, dir, "echo", "", "")
+	plugins, err := LoadPlugins(ctx, dir)
+	if err != nil {
+		t.Fatalf("Expected the plugins to load, got %v", err)
+	}
+	if len(plugins) != 2 || plugins[0].Name != "echo" || plugins[1].Name != "weather" {
+		t.Fatalf("Expected the plugins in name order, got %+v", plugins)
+	}
+	if tools := plugins[0].Tools; len(tools) != 2 || tools[0].Name != "echo" || tools[1].Name != "summary" {
+		t.Fatalf("Expected the tools listed by the plugin, got %+v", tools)
+	}
+	if schema := plugins[0].Tools[0].InputSchema; schema.Type != "object" || schema.Properties == nil {
+		t.Errorf("Expected an empty object schema by default, got %+v", schema)
+	}
+
+	// Invalid manifests and tool lists fail the load
+	invalid := []struct {
+		name     string
+		manifest string
+		mode     string
+		tools    string
+	}{
+		{"malformed", "{", "", ""},
+		{"no-command", `{"tools": [{"name": "x"}]}`, "", ""},
+		{"duplicate", "", "", `[{"name": "x"}, {"name": "x"}]`},
+		{"unnamed", "", "", `[{"name": " "}]`},
+		{"no-tools", "", "no-tools", ""},
+		{"list-error", "", "rpc-error", ""},
+	}
+	for _, tc := range invalid {
+		dir := t.TempDir()
+		if tc.manifest != "" {
+			if err := os.WriteFile(filepath.Join(dir, tc.name+".json"), []byte(tc.manifest), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			writePluginManifest(t, dir, tc.name, tc.mode, tc.tools)
+		}
+		if _, err := LoadPlugins(ctx, dir); err == nil || !strings.Contains(err.Error(), tc.name) {
+			t.Errorf("%s: expected the load to fail naming the plugin, got %v", tc.name, err)
+		}
+	}
+
+	// Plugins cannot replace the tools of dk or of other plugins
+	dir = t.TempDir()
+	writePluginManifest(t, dir, "shadow", "", `[{"name": "cqListDocuments"}]`)
+	plugins, err = LoadPlugins(ctx, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMCPServer(ToolPolicy{}, plugins); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected a plugin tool clashing with a dk tool to be refused, got %v", err)
+	}
+	dir = t.TempDir()
+	writePluginManifest(t, dir, "first", "", `[{"name": "forecast"}]`)
+	writePluginManifest(t, dir, "second", "", `[{"name": "forecast"}]`)
+	if plugins, err = LoadPlugins(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewMCPServer(ToolPolicy{}, plugins); err == nil || !strings.Contains(err.Error(), "second") {
+		t.Errorf("Expected a tool provided by two plugins to be refused, got %v", err)
+	}
+}
+
+func TestPluginCall(t *testing.T) {
+	t.Setenv("DK_PLUGIN_HELPER", "1")
+	ctx := context.Background()
+
+	cases := []struct {
+		mode    string
+		isError bool
+		text    string
+	}{
+		{"", false, `echo {"city":"Lisbon"}`},
+		{"slow", true, "no response within 1s"},
+		{"large", true, fmt.Sprintf("response larger than %d bytes", maxPluginOutput)},
+		{"rpc-error", true, "unknown city (code -32602)"},
+		{"image", true, `unsupported "image" content`},
+	}
+	for _, tc := range cases {
+		dir := t.TempDir()
+		writePluginManifest(t, dir, "plugin", tc.mode, `[{"name": "echo"}]`)
+		plugins, err := LoadPlugins(ctx, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := plugins[0].handler("echo")(ctx, callRequest("echo", map[string]any{"city": "Lisbon"}))
+		if err != nil {
+			t.Fatalf("%q: expected the failure in the result, got %v", tc.mode, err)
+		}
+		if result.IsError != tc.isError {
+			t.Errorf("%q: expected IsError %v, got %v", tc.mode, tc.isError, result.IsError)
+		}
+		text := resultText(t, result)
+		if tc.isError {
+			text = decodeToolError(t, result).Message
+		}
+		if !strings.Contains(text, tc.text) {
+			t.Errorf("%q: expected %q in the result, got %q", tc.mode, tc.text, text)
+		}
+	}
+}
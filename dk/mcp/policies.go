@@ -0,0 +1,254 @@
+package mcp
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// policyRuleSchema describes one rule of the "rules" argument
+var policyRuleSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"rule_type":   map[string]any{"type": "string", "description": "rate, token, time, credit or free"},
+		"limit_value": map[string]any{"type": "number", "description": "Limit per period"},
+		"period":      map[string]any{"type": "string", "description": "minute, hour, day, week, month or year"},
+		"action":      map[string]any{"type": "string", "description": "block, throttle, notify or log"},
+		"priority":    map[string]any{"type": "number", "description": "Lower runs first (default 100)"},
+	},
+	"required": []string{"rule_type", "action"},
+}
+
+// policyRules returns the rules of a tool call
+func policyRules(args map[string]interface{}) []*db.PolicyRule {
+	items, _ := args["rules"].([]any)
+	rules := make([]*db.PolicyRule, 0, len(items))
+	for _, item := range items {
+		fields, _ := item.(map[string]any)
+		rule := &db.PolicyRule{}
+		rule.RuleType, _ = fields["rule_type"].(string)
+		rule.LimitValue, _ = fields["limit_value"].(float64)
+		rule.Period, _ = fields["period"].(string)
+		rule.Action, _ = fields["action"].(string)
+		if priority, ok := fields["priority"].(float64); ok && priority > 0 {
+			rule.Priority = int(priority)
+		} else {
+			rule.Priority = 100 // Default priority
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// Tool: List Policies
+//
+// This tool lists the usage policies with their rules.
+// Input parameters: optional "type" and "active_only".
+func HandleListPoliciesTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, err.Error()), nil
+	}
+	policyType, _ := req.Params.Arguments["type"].(string)
+	activeOnly, _ := req.Params.Arguments["active_only"].(bool)
+
+	policies, total, err := db.ListPolicies(database, strings.TrimSpace(policyType), activeOnly, "", 100, 0, "name", "asc")
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to list policies: %v", err)), nil
+	}
+	for i, policy := range policies {
+		if withRules, err := db.GetPolicyWithRules(database, policy.ID); err == nil {
+			policies[i] = withRules
+		}
+	}
+	return apiResult(map[string]interface{}{
+		"policies": policies,
+		"total":    total,
+	})
+}
+
+// Tool: Create Policy
+//
+// This tool creates a usage policy, validated exactly like POST /api/policies.
+// Input parameters: "name", "type", "rules" and optionally "description".
+func HandleCreatePolicyTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	name, _ := req.Params.Arguments["name"].(string)
+	policyType, _ := req.Params.Arguments["type"].(string)
+	description, _ := req.Params.Arguments["description"].(string)
+	rules := policyRules(req.Params.Arguments)
+	if err := db.ValidatePolicy(name, policyType, rules); err != nil {
+		return errorResult(ErrorInvalidArgument, err.Error()), nil
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, err.Error()), nil
+	}
+	tx, err := database.Begin()
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to start transaction: %v", err)), nil
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	policy := &db.Policy{
+		ID:          uuid.New().String(),
+		Name:        name,
+		Description: description,
+		Type:        policyType,
+		IsActive:    true,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+		CreatedBy:   hostUserID(ctx),
+	}
+	if err := db.CreatePolicyTx(tx, policy); err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to create policy: %v", err)), nil
+	}
+	for _, rule := range rules {
+		rule.ID = uuid.New().String()
+		rule.PolicyID = policy.ID
+		rule.CreatedAt = now
+		if err := db.CreatePolicyRuleTx(tx, rule); err != nil {
+			return errorResult(errorCode(err), fmt.Sprintf("Failed to create policy rule: %v", err)), nil
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to commit transaction: %v", err)), nil
+	}
+
+	policy.Rules = make([]db.PolicyRule, 0, len(rules))
+	for _, rule := range rules {
+		policy.Rules = append(policy.Rules, *rule)
+	}
+	return apiResult(policy)
+}
+
+// Tool: Change API Policy
+//
+// This tool attaches a policy to an API, immediately or at a scheduled date, and records the
+// change in the API's policy history, like POST /api/apis/:id/policy.
+// Input parameters: "api_id", "policy_id", and optionally "scheduled_date" and "reason".
+func HandleChangeAPIPolicyTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	apiID, _ := req.Params.Arguments["api_id"].(string)
+	policyID, _ := req.Params.Arguments["policy_id"].(string)
+	reason, _ := req.Params.Arguments["reason"].(string)
+	apiID, policyID = strings.TrimSpace(apiID), strings.TrimSpace(policyID)
+	if apiID == "" {
+		return errorResult(ErrorInvalidArgument, "API ID is required"), nil
+	}
+
+	var scheduledDate *time.Time
+	if value, _ := req.Params.Arguments["scheduled_date"].(string); strings.TrimSpace(value) != "" {
+		parsed, err := parseToolDate(strings.TrimSpace(value))
+		if err != nil {
+			return errorResult(ErrorInvalidArgument, err.Error()), nil
+		}
+		scheduledDate = &parsed
+	}
+	effectiveImmediately := scheduledDate == nil
+	if err := db.ValidatePolicyChange(policyID, effectiveImmediately, scheduledDate); err != nil {
+		return errorResult(ErrorInvalidArgument, err.Error()), nil
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, err.Error()), nil
+	}
+	api, err := db.GetAPI(database, apiID)
+	if err != nil {
+		return apiError("retrieve", apiID, err)
+	}
+	policy, err := db.GetPolicy(database, policyID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return errorResult(ErrorNotFound, "Policy not found"), nil
+		}
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to retrieve policy: %v", err)), nil
+	}
+	if !policy.IsActive {
+		return errorResult(ErrorConflict, "Cannot assign inactive policy"), nil
+	}
+
+	effectiveDate := scheduledDate
+	if effectiveImmediately {
+		now := time.Now()
+		effectiveDate = &now
+	}
+
+	tx, err := database.Begin()
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to start transaction: %v", err)), nil
+	}
+	defer tx.Rollback()
+
+	change := &db.PolicyChange{
+		ID:            uuid.New().String(),
+		APIID:         apiID,
+		OldPolicyID:   api.PolicyID,
+		NewPolicyID:   &policyID,
+		ChangedAt:     time.Now(),
+		ChangedBy:     hostUserID(ctx),
+		EffectiveDate: effectiveDate,
+		ChangeReason:  reason,
+	}
+	if err := db.CreatePolicyChangeTx(tx, change); err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to record policy change: %v", err)), nil
+	}
+	if effectiveImmediately {
+		api.PolicyID = &policyID
+		api.UpdatedAt = time.Now()
+		if err := db.UpdateAPITx(tx, api); err != nil {
+			return errorResult(errorCode(err), fmt.Sprintf("Failed to update API: %v", err)), nil
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to commit transaction: %v", err)), nil
+	}
+
+	if effectiveImmediately {
+		return mcp_lib.NewToolResultText(fmt.Sprintf("Policy '%s' now applies to API '%s'.", policy.Name, api.Name)), nil
+	}
+	return mcp_lib.NewToolResultText(fmt.Sprintf("Policy '%s' will apply to API '%s' from %s.",
+		policy.Name, api.Name, effectiveDate.UTC().Format(time.RFC3339))), nil
+}
+
+// Tool: Delete Policy
+//
+// This tool deletes a policy and its rules. Policies still used by an API are kept.
+// Input parameters: "id" of the policy.
+func HandleDeletePolicyTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	id, _ := req.Params.Arguments["id"].(string)
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return errorResult(ErrorInvalidArgument, "Policy ID is required"), nil
+	}
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, err.Error()), nil
+	}
+
+	_, total, err := db.ListAPIsByPolicy(database, id, 1, 0, "", "")
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to check policy usage: %v", err)), nil
+	}
+	if total > 0 {
+		return errorResult(ErrorConflict, fmt.Sprintf("Cannot delete policy because it is currently used by %d APIs", total)), nil
+	}
+	if err := db.DeletePolicy(database, id); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return errorResult(ErrorNotFound, "Policy not found"), nil
+		}
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to delete policy: %v", err)), nil
+	}
+	if err := db.DeletePolicyRules(database, id); err != nil {
+		utils.LogError(ctx, "Failed to delete policy rules: %v", err)
+	}
+	return mcp_lib.NewToolResultText(fmt.Sprintf("Policy '%s' deleted.", id)), nil
+}
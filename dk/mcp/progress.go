@@ -0,0 +1,100 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"dk/mcp/i18n"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// answerProgressWindow is how long answers to a question are reported after it was asked
+const answerProgressWindow = 15 * time.Minute
+
+// answerProgress reports the answers peers send to a question as progress notifications of
+// the tool call that asked it
+type answerProgress struct {
+	session  server.ClientSession
+	token    mcp_lib.ProgressToken
+	lang     string
+	expected int // Peers the question went to; 0 when it was broadcast
+	answers  <-chan core.PeerAnswer
+	stop     func()
+}
+
+// newAnswerProgress starts watching for answers to a question when the caller asked for
+// progress notifications, and returns nil otherwise. Create it before sending the question,
+// so no answer is missed, then call report once it is sent or cancel if it was not.
+func newAnswerProgress(ctx context.Context, request mcp_lib.CallToolRequest, question string, expected int) *answerProgress {
+	if request.Params.Meta == nil || request.Params.Meta.ProgressToken == nil {
+		return nil
+	}
+	session := server.ClientSessionFromContext(ctx)
+	if session == nil || !session.Initialized() {
+		return nil
+	}
+	answers, stop := core.WatchAnswers(question)
+	return &answerProgress{
+		session:  session,
+		token:    request.Params.Meta.ProgressToken,
+		lang:     language(ctx),
+		expected: expected,
+		answers:  answers,
+		stop:     stop,
+	}
+}
+
+// report notifies the client of each answer in the background, until every peer the question
+// went to has answered or the progress window is over
+func (p *answerProgress) report() {
+	go func() {
+		defer p.stop()
+		timeout := time.NewTimer(answerProgressWindow)
+		defer timeout.Stop()
+		received := 0
+		for {
+			select {
+			case answer := <-p.answers:
+				received++
+				p.notify(received, i18n.Message(p.lang, "ask.progress", received, answer.From))
+				if p.expected > 0 && received >= p.expected {
+					return
+				}
+			case <-timeout.C:
+				return
+			}
+		}
+	}()
+}
+
+// cancel stops watching for answers
+func (p *answerProgress) cancel() {
+	if p != nil {
+		p.stop()
+	}
+}
+
+// notify sends a progress notification without blocking, like the MCP server does
+func (p *answerProgress) notify(progress int, message string) {
+	params := map[string]any{
+		"progressToken": p.token,
+		"progress":      progress,
+		"message":       message,
+	}
+	if p.expected > 0 {
+		params["total"] = p.expected
+	}
+	notification := mcp_lib.JSONRPCNotification{
+		JSONRPC: mcp_lib.JSONRPC_VERSION,
+		Notification: mcp_lib.Notification{
+			Method: "notifications/progress",
+			Params: mcp_lib.NotificationParams{AdditionalFields: params},
+		},
+	}
+	select {
+	case p.session.NotificationChannel() <- notification:
+	default:
+	}
+}
@@ -0,0 +1,157 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"dk/db"
+	"dk/mcp/i18n"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// defaultTriageLimit is the number of pending queries a triage prompt includes by default
+const defaultTriageLimit = 20
+
+// AddPrompt registers a prompt that walks the assistant through the given tools. Its handler
+// rejects callers whose role may not call all of them, as the prompt would be of no use to them.
+func (s scopedServer) AddPrompt(prompt mcp_lib.Prompt, tools []string, handler server.PromptHandlerFunc) {
+	// A prompt relying on a disabled tool could not be followed through
+	for _, tool := range tools {
+		if s.policy.Access(tool) == ToolDisabled {
+			return
+		}
+	}
+	s.MCPServer.AddPrompt(prompt, func(ctx context.Context, request mcp_lib.GetPromptRequest) (*mcp_lib.GetPromptResult, error) {
+		principal := core.PrincipalFromContext(ctx)
+		for _, tool := range tools {
+			if !principal.ToolAllowed(tool) {
+				return nil, errors.New(i18n.Message(language(ctx), "forbidden.prompt", principal.Role, tool, prompt.Name))
+			}
+		}
+		return handler(ctx, request)
+	})
+}
+
+// userPrompt returns a prompt result made of a single user message
+func userPrompt(description, text string) *mcp_lib.GetPromptResult {
+	return mcp_lib.NewGetPromptResult(description, []mcp_lib.PromptMessage{
+		mcp_lib.NewPromptMessage(mcp_lib.RoleUser, mcp_lib.NewTextContent(text)),
+	})
+}
+
+// Prompt: Triage Pending Queries
+//
+// This prompt lists the pending incoming queries, with the automatic approval conditions, and
+// asks the assistant to review each one and accept or reject it with cqUpdateEditAnswer and
+// cqProcessQuery.
+// Arguments: optional "from" to only include the queries of one peer, optional "limit".
+func HandleTriagePendingQueriesPrompt(ctx context.Context, request mcp_lib.GetPromptRequest) (*mcp_lib.GetPromptResult, error) {
+	lang := language(ctx)
+	args := request.Params.Arguments
+	limit := defaultTriageLimit
+	if raw := strings.TrimSpace(args["limit"]); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return nil, errors.New(i18n.Message(lang, "prompt.limit_invalid", raw))
+		}
+		limit = n
+	}
+
+	dbInstance, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, errors.New(i18n.Message(lang, "database.unavailable", err))
+	}
+	pending, err := db.ListQueries(ctx, dbInstance, "pending", strings.TrimSpace(args["from"]))
+	if err != nil {
+		return nil, fmt.Errorf("couldn't retrieve the pending queries: %w", err)
+	}
+	rules, err := db.ListRules(ctx, dbInstance)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't retrieve the automatic approval conditions: %w", err)
+	}
+
+	description := i18n.Message(lang, "triage.description", min(len(pending), limit))
+	if len(pending) == 0 {
+		return userPrompt(description, i18n.Message(lang, "triage.empty")), nil
+	}
+	omitted := 0
+	if len(pending) > limit {
+		omitted = len(pending) - limit
+		pending = pending[:limit]
+	}
+	queries, _ := json.MarshalIndent(pending, "", "  ")
+
+	var b strings.Builder
+	b.WriteString(i18n.Message(lang, "triage.instructions"))
+	b.WriteString("\n\n")
+	if len(rules) > 0 {
+		b.WriteString(i18n.Message(lang, "triage.conditions"))
+		b.WriteString("\n")
+		for _, rule := range rules {
+			fmt.Fprintf(&b, "- %s\n", rule)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(i18n.Message(lang, "triage.queries"))
+	fmt.Fprintf(&b, "\n%s\n", queries)
+	if omitted > 0 {
+		b.WriteString("\n")
+		b.WriteString(i18n.Message(lang, "triage.omitted", omitted))
+		b.WriteString("\n")
+	}
+	return userPrompt(description, b.String()), nil
+}
+
+// Prompt: Summarize Peer Answers
+//
+// This prompt includes the answers peers sent to a question asked with cqAskQuestion and asks
+// the assistant to summarize them.
+// Arguments: "question", optional "detailed" ("true" for an in-depth summary).
+func HandleSummarizePeerAnswersPrompt(ctx context.Context, request mcp_lib.GetPromptRequest) (*mcp_lib.GetPromptResult, error) {
+	lang := language(ctx)
+	args := request.Params.Arguments
+	question := strings.TrimSpace(args["question"])
+	if question == "" {
+		return nil, errors.New(i18n.Message(lang, "prompt.argument_required", "question"))
+	}
+	instructions := "summarize.concise"
+	if detailed, _ := strconv.ParseBool(args["detailed"]); detailed {
+		instructions = "summarize.detailed"
+	}
+
+	dbInstance, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return nil, errors.New(i18n.Message(lang, "database.unavailable", err))
+	}
+	answers, err := db.AnswersForQuestion(ctx, dbInstance, question)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't retrieve the answers: %w", err)
+	}
+
+	description := i18n.Message(lang, "summarize.description", len(answers))
+	if len(answers) == 0 {
+		return userPrompt(description, i18n.Message(lang, "summarize.empty", question)), nil
+	}
+	peers := make([]string, 0, len(answers))
+	for peer := range answers {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+
+	var b strings.Builder
+	b.WriteString(i18n.Message(lang, "summarize.answers", question))
+	b.WriteString("\n\n")
+	for _, peer := range peers {
+		fmt.Fprintf(&b, "--- %s ---\n%s\n\n", peer, strings.TrimSpace(answers[peer]))
+	}
+	b.WriteString(i18n.Message(lang, instructions))
+	return userPrompt(description, b.String()), nil
+}
@@ -0,0 +1,236 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"dk/db"
+	"dk/mcp/i18n"
+	"dk/utils"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// promptText returns the text of the single message of a rendered prompt
+func promptText(t *testing.T, result *mcp_lib.GetPromptResult) string {
+	t.Helper()
+	if len(result.Messages) != 1 || result.Messages[0].Role != mcp_lib.RoleUser {
+		t.Fatalf("Expected a single user message, got %+v", result.Messages)
+	}
+	text, ok := result.Messages[0].Content.(mcp_lib.TextContent)
+	if !ok {
+		t.Fatalf("Expected text content, got %T", result.Messages[0].Content)
+	}
+	return text.Text
+}
+
+// promptRequest builds a prompt request with the given arguments
+func promptRequest(name string, args map[string]string) mcp_lib.GetPromptRequest {
+	var request mcp_lib.GetPromptRequest
+	request.Params.Name = name
+	request.Params.Arguments = args
+	return request
+}
+
+// promptsDB opens a test database holding queries, an approval condition and answers
+func promptsDB(t *testing.T) context.Context {
+	t.Helper()
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { testDB.Close() })
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	ctx := context.Background()
+	for _, query := range []db.Query{
+		{ID: "q1", From: "alice", Question: "What is the budget?", Answer: "Ten.", Status: "pending"},
+		{ID: "q2", From: "bob", Question: "Who leads the project?", Answer: "Carol.", Status: "pending"},
+		{ID: "q3", From: "alice", Question: "Where is the office?", Answer: "Lisbon.", Status: "accepted"},
+	} {
+		if err := db.InsertQuery(ctx, testDB.DB, query); err != nil {
+			t.Fatalf("InsertQuery failed: %v", err)
+		}
+	}
+	if err := db.InsertRule(ctx, testDB.DB, "Never disclose salaries"); err != nil {
+		t.Fatalf("InsertRule failed: %v", err)
+	}
+	for _, answer := range []db.Answer{
+		{Question: "Is the release ready?", User: "bob", Text: "Not yet."},
+		{Question: "Is the release ready?", User: "alice", Text: "Yes, since Monday."},
+	} {
+		if err := db.InsertAnswer(ctx, testDB.DB, answer); err != nil {
+			t.Fatalf("InsertAnswer failed: %v", err)
+		}
+	}
+	return utils.WithDatabase(ctx, testDB.DB)
+}
+
+func TestTriagePendingQueriesPrompt(t *testing.T) {
+	ctx := promptsDB(t)
+	render := func(args map[string]string) (*mcp_lib.GetPromptResult, string) {
+		t.Helper()
+		result, err := HandleTriagePendingQueriesPrompt(ctx, promptRequest("triage_pending_queries", args))
+		if err != nil {
+			t.Fatalf("Expected the prompt, got %v", err)
+		}
+		return result, promptText(t, result)
+	}
+
+	// Every pending query is included with the approval conditions
+	result, text := render(nil)
+	if result.Description != i18n.Message(i18n.Default, "triage.description", 2) {
+		t.Errorf("Expected the number of pending queries in the description, got %q", result.Description)
+	}
+	for _, want := range []string{i18n.Message(i18n.Default, "triage.instructions"), "- Never disclose salaries", "What is the budget?", "Who leads the project?"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Expected the prompt to include %q, got %s", want, text)
+		}
+	}
+	if strings.Contains(text, "Where is the office?") {
+		t.Error("Expected the accepted query to be left out")
+	}
+
+	// The queries can be narrowed to a peer and limited, telling how many were left out
+	if _, text := render(map[string]string{"from": "bob"}); strings.Contains(text, "What is the budget?") || !strings.Contains(text, "Who leads the project?") {
+		t.Errorf("Expected only the queries of bob, got %s", text)
+	}
+	if result, text := render(map[string]string{"limit": "1"}); result.Description != i18n.Message(i18n.Default, "triage.description", 1) || !strings.Contains(text, i18n.Message(i18n.Default, "triage.omitted", 1)) {
+		t.Errorf("Expected one query and the omitted count, got %q: %s", result.Description, text)
+	}
+	if _, text := render(map[string]string{"from": "carol"}); text != i18n.Message(i18n.Default, "triage.empty") {
+		t.Errorf("Expected the empty prompt, got %s", text)
+	}
+
+	// Invalid limits and a missing database are refused
+	for _, limit := range []string{"0", "-3", "many"} {
+		_, err := HandleTriagePendingQueriesPrompt(ctx, promptRequest("triage_pending_queries", map[string]string{"limit": limit}))
+		if err == nil || err.Error() != i18n.Message(i18n.Default, "prompt.limit_invalid", limit) {
+			t.Errorf("limit %q: expected the limit to be refused, got %v", limit, err)
+		}
+	}
+	if _, err := HandleTriagePendingQueriesPrompt(context.Background(), promptRequest("triage_pending_queries", nil)); err == nil {
+		t.Error("Expected the prompt to fail without a database")
+	}
+}
+
+func TestSummarizePeerAnswersPrompt(t *testing.T) {
+	ctx := promptsDB(t)
+	render := func(args map[string]string) (*mcp_lib.GetPromptResult, string) {
+		t.Helper()
+		result, err := HandleSummarizePeerAnswersPrompt(ctx, promptRequest("summarize_peer_answers", args))
+		if err != nil {
+			t.Fatalf("Expected the prompt, got %v", err)
+		}
+		return result, promptText(t, result)
+	}
+
+	// The answers are included by peer, followed by concise instructions unless detailed is set
+	result, text := render(map[string]string{"question": " Is the release ready? "})
+	if result.Description != i18n.Message(i18n.Default, "summarize.description", 2) {
+		t.Errorf("Expected the number of answers in the description, got %q", result.Description)
+	}
+	alice, bob := strings.Index(text, "--- alice ---\nYes, since Monday."), strings.Index(text, "--- bob ---\nNot yet.")
+	if alice < 0 || bob < alice {
+		t.Errorf("Expected the answers sorted by peer, got %s", text)
+	}
+	if !strings.HasSuffix(text, i18n.Message(i18n.Default, "summarize.concise")) {
+		t.Errorf("Expected the concise instructions, got %s", text)
+	}
+	if _, text := render(map[string]string{"question": "Is the release ready?", "detailed": "true"}); !strings.HasSuffix(text, i18n.Message(i18n.Default, "summarize.detailed")) {
+		t.Errorf("Expected the detailed instructions, got %s", text)
+	}
+	if _, text := render(map[string]string{"question": "Is it raining?"}); text != i18n.Message(i18n.Default, "summarize.empty", "Is it raining?") {
+		t.Errorf("Expected the empty prompt, got %s", text)
+	}
+
+	// The question is required
+	for _, args := range []map[string]string{nil, {"question": "  "}, {"detailed": "true"}} {
+		_, err := HandleSummarizePeerAnswersPrompt(ctx, promptRequest("summarize_peer_answers", args))
+		if err == nil || err.Error() != i18n.Message(i18n.Default, "prompt.argument_required", "question") {
+			t.Errorf("%v: expected the missing question to be refused, got %v", args, err)
+		}
+	}
+}
+
+func TestServerPrompts(t *testing.T) {
+	listPrompts := func(policy ToolPolicy) map[string]mcp_lib.Prompt {
+		t.Helper()
+		mcpServer, err := NewMCPServer(policy, nil)
+		if err != nil {
+			t.Fatalf("NewMCPServer failed: %v", err)
+		}
+		blob, err := json.Marshal(mcpServer.HandleMessage(context.Background(), json.RawMessage(`{"jsonrpc": "2.0", "id": 1, "method": "prompts/list"}`)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var list struct {
+			Result mcp_lib.ListPromptsResult `json:"result"`
+		}
+		if err := json.Unmarshal(blob, &list); err != nil {
+			t.Fatalf("Expected the prompts, got %s (%v)", blob, err)
+		}
+		prompts := make(map[string]mcp_lib.Prompt)
+		for _, prompt := range list.Result.Prompts {
+			prompts[prompt.Name] = prompt
+		}
+		return prompts
+	}
+
+	// Every prompt is listed with its arguments, and the question is required
+	prompts := listPrompts(ToolPolicy{})
+	want := map[string][]string{
+		"triage_pending_queries": {"from", "limit"},
+		"summarize_peer_answers": {"question", "detailed"},
+	}
+	if len(prompts) != len(want) {
+		t.Errorf("Expected %d prompts, got %d", len(want), len(prompts))
+	}
+	for name, args := range want {
+		prompt, ok := prompts[name]
+		if !ok || len(prompt.Arguments) != len(args) {
+			t.Errorf("%s: expected the arguments %v, got %+v", name, args, prompt.Arguments)
+			continue
+		}
+		for i, arg := range prompt.Arguments {
+			if arg.Name != args[i] || arg.Required != (arg.Name == "question") {
+				t.Errorf("%s: unexpected argument %+v", name, arg)
+			}
+		}
+	}
+
+	// Prompts relying on a disabled tool are left out
+	disabled := listPrompts(ToolPolicy{Tools: map[string]ToolAccess{"cqProcessQuery": ToolDisabled}})
+	if _, ok := disabled["triage_pending_queries"]; ok {
+		t.Error("Expected the triage prompt to be left out")
+	}
+	if _, ok := disabled["summarize_peer_answers"]; !ok {
+		t.Error("Expected the summary prompt to be kept")
+	}
+
+	// Roles that may not call the tools of a prompt cannot get it
+	mcpServer, err := NewMCPServer(ToolPolicy{}, nil)
+	if err != nil {
+		t.Fatalf("NewMCPServer failed: %v", err)
+	}
+	ctx := promptsDB(t)
+	get := func(ctx context.Context) string {
+		message := `{"jsonrpc": "2.0", "id": 2, "method": "prompts/get", "params": {"name": "summarize_peer_answers", "arguments": {"question": "Is the release ready?"}}}`
+		blob, err := json.Marshal(mcpServer.HandleMessage(ctx, json.RawMessage(message)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(blob)
+	}
+	if response := get(core.WithPrincipal(ctx, core.Principal{Role: core.RoleCurator})); !strings.Contains(response, "Yes, since Monday.") {
+		t.Errorf("Expected a curator to get the prompt, got %s", response)
+	}
+	forbidden := i18n.Message(i18n.Default, "forbidden.prompt", "reader", "cqSummarizeAnswers", "summarize_peer_answers")
+	if response := get(core.WithPrincipal(ctx, core.Principal{Role: "reader"})); !strings.Contains(response, fmt.Sprintf("%q", forbidden)) {
+		t.Errorf("Expected the prompt to be refused, got %s", response)
+	}
+}
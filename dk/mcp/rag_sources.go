@@ -0,0 +1,151 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"encoding/json"
+	"fmt"
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// ragSourceResult encodes the status of a RAG source as the tool result
+func ragSourceResult(status any) (*mcp_lib.CallToolResult, error) {
+	blob, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode RAG sources: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
+
+// ragSourceName returns the required file name and the optional collection of a tool call
+func ragSourceName(args map[string]interface{}) (string, string, bool) {
+	file, _ := args["file"].(string)
+	collection, _ := args["collection"].(string)
+	return file, collection, file != ""
+}
+
+// Tool: List RAG Sources
+//
+// This tool lists the entries of the RAG sources file with their ingestion status.
+func HandleListRagSourcesTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	watcher := core.SourceWatcherFromContext(ctx)
+	if watcher == nil {
+		return errorResult(ErrorInternal, "RAG sources are not available"), nil
+	}
+	sources, err := watcher.Sources()
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to list RAG sources: %v", err)), nil
+	}
+	return ragSourceResult(sources)
+}
+
+// Tool: Add RAG Source
+//
+// This tool adds an entry to the RAG sources file and ingests it.
+// Input parameters: one of "text", "path" or "url", and optionally "file", "collection", "tags" and "owner".
+func HandleAddRagSourceTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	watcher := core.SourceWatcherFromContext(ctx)
+	if watcher == nil {
+		return errorResult(ErrorInternal, "RAG sources are not available"), nil
+	}
+
+	var source core.RagSource
+	applyRagSourceArgs(&source, req.Params.Arguments)
+	source.Collection, _ = req.Params.Arguments["collection"].(string)
+
+	status, err := watcher.AddSource(ctx, source)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to add RAG source: %v", err)), nil
+	}
+	return ragSourceResult(status)
+}
+
+// Tool: Update RAG Source
+//
+// This tool changes an entry of the RAG sources file and re-ingests it. Only the given fields change.
+// Input parameters: "file" and "collection" name the entry; "text", "path", "url", "new_file", "tags" and "owner" update it.
+func HandleUpdateRagSourceTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	watcher := core.SourceWatcherFromContext(ctx)
+	if watcher == nil {
+		return errorResult(ErrorInternal, "RAG sources are not available"), nil
+	}
+	file, collection, ok := ragSourceName(req.Params.Arguments)
+	if !ok {
+		return errorResult(ErrorInvalidArgument, "'file' parameter is required"), nil
+	}
+
+	status, err := watcher.UpdateSource(ctx, collection, file, func(source *core.RagSource) {
+		applyRagSourceArgs(source, req.Params.Arguments)
+		if newFile, ok := req.Params.Arguments["new_file"].(string); ok && newFile != "" {
+			source.FileName = newFile
+		}
+	})
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to update RAG source: %v", err)), nil
+	}
+	return ragSourceResult(status)
+}
+
+// Tool: Remove RAG Source
+//
+// This tool removes an entry from the RAG sources file and its document from the knowledge base.
+// Input parameters: "file" and optionally "collection".
+func HandleRemoveRagSourceTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	watcher := core.SourceWatcherFromContext(ctx)
+	if watcher == nil {
+		return errorResult(ErrorInternal, "RAG sources are not available"), nil
+	}
+	file, collection, ok := ragSourceName(req.Params.Arguments)
+	if !ok {
+		return errorResult(ErrorInvalidArgument, "'file' parameter is required"), nil
+	}
+
+	if err := watcher.RemoveSource(ctx, collection, file); err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to remove RAG source: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(fmt.Sprintf("RAG source '%s' removed.", file)), nil
+}
+
+// Tool: Re-ingest RAG Source
+//
+// This tool embeds an entry of the RAG sources file again, e.g. after the page behind a URL changed.
+// Input parameters: "file" and optionally "collection".
+func HandleReingestRagSourceTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	watcher := core.SourceWatcherFromContext(ctx)
+	if watcher == nil {
+		return errorResult(ErrorInternal, "RAG sources are not available"), nil
+	}
+	file, collection, ok := ragSourceName(req.Params.Arguments)
+	if !ok {
+		return errorResult(ErrorInvalidArgument, "'file' parameter is required"), nil
+	}
+
+	status, err := watcher.Reingest(ctx, collection, file)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to re-ingest RAG source: %v", err)), nil
+	}
+	return ragSourceResult(status)
+}
+
+// applyRagSourceArgs sets the content, tags and owner given in a tool call. Setting one kind of
+// content clears the others.
+func applyRagSourceArgs(source *core.RagSource, args map[string]interface{}) {
+	if text, ok := args["text"].(string); ok && text != "" {
+		source.Text, source.Path, source.URL = text, "", ""
+	}
+	if path, ok := args["path"].(string); ok && path != "" {
+		source.Text, source.Path, source.URL = "", path, ""
+	}
+	if url, ok := args["url"].(string); ok && url != "" {
+		source.Text, source.Path, source.URL = "", "", url
+	}
+	if file, ok := args["file"].(string); ok && source.FileName == "" {
+		source.FileName = file
+	}
+	if _, ok := args["tags"]; ok {
+		source.Tags = stringList(args, "tags")
+	}
+	if owner, ok := args["owner"].(string); ok {
+		source.Owner = owner
+	}
+}
@@ -0,0 +1,43 @@
+package mcp
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter enforces the rate limits of the tool policy for each tool and caller, over a
+// sliding window
+type rateLimiter struct {
+	policy ToolPolicy
+	mu     sync.Mutex
+	calls  map[string][]time.Time // Recent calls by tool and caller, oldest first
+}
+
+func newRateLimiter(policy ToolPolicy) *rateLimiter {
+	return &rateLimiter{policy: policy, calls: make(map[string][]time.Time)}
+}
+
+// allow records a call and reports whether the rate limit of the tool permits it. When it
+// does not, it returns how long until the oldest call in the window leaves it.
+func (l *rateLimiter) allow(tool, caller string) (bool, RateLimit, time.Duration) {
+	limit, ok := l.policy.RateLimit(tool)
+	if !ok {
+		return true, limit, 0
+	}
+	window := limit.window()
+	now := time.Now()
+	key := tool + "\x00" + caller
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	recent := l.calls[key]
+	for len(recent) > 0 && now.Sub(recent[0]) >= window {
+		recent = recent[1:]
+	}
+	if len(recent) >= limit.Calls {
+		l.calls[key] = recent
+		return false, limit, window - now.Sub(recent[0])
+	}
+	l.calls[key] = append(recent, now)
+	return true, limit, 0
+}
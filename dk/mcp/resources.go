@@ -0,0 +1,113 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"fmt"
+	"log"
+	"mime"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// documentURITemplate addresses an ingested document by its collection and file name, both
+// path-escaped
+const documentURITemplate = "dk://documents/{collection}/{file}"
+
+// documentURI returns the resource URI of a document
+func documentURI(collection, file string) string {
+	return "dk://documents/" + url.PathEscape(collection) + "/" + url.PathEscape(file)
+}
+
+// documentMIMEType returns the MIME type of the content of a document. Documents are stored as
+// extracted text, so PDFs, Word files and the like are served as plain text.
+func documentMIMEType(file string) string {
+	mimeType, _, _ := mime.ParseMediaType(mime.TypeByExtension(strings.ToLower(filepath.Ext(file))))
+	if strings.HasPrefix(mimeType, "text/") || mimeType == "application/json" || mimeType == "application/xml" {
+		return mimeType
+	}
+	return "text/plain"
+}
+
+// documentCollections returns the names of the collections documents are served from
+func documentCollections(ctx context.Context) []string {
+	collections := core.CollectionsFromContext(ctx)
+	if collections == nil {
+		return []string{core.DefaultCollection}
+	}
+	var names []string
+	for _, info := range collections.List() {
+		names = append(names, info.Name)
+	}
+	return names
+}
+
+// documentResources lists the ingested documents of every collection as resources. The list
+// is built on every request, so it follows documents as they are added and removed.
+func documentResources(ctx context.Context) []mcp_lib.Resource {
+	var resources []mcp_lib.Resource
+	for _, name := range documentCollections(ctx) {
+		collectionCtx, err := core.UseCollection(ctx, name, false)
+		if err == nil {
+			var docs []core.Document
+			if docs, err = core.ListDocuments(collectionCtx); err == nil {
+				for _, doc := range docs {
+					resources = append(resources, mcp_lib.NewResource(
+						documentURI(name, doc.FileName),
+						doc.FileName,
+						mcp_lib.WithResourceDescription(fmt.Sprintf("Document of the %s collection (%d bytes)", name, len(doc.Content))),
+						mcp_lib.WithMIMEType(documentMIMEType(doc.FileName)),
+					))
+				}
+			}
+		}
+		if err != nil {
+			log.Printf("[MCP] Failed to list the documents of collection %s: %v", name, err)
+		}
+	}
+	return resources
+}
+
+// templateArgument returns a variable matched by a resource template
+func templateArgument(request mcp_lib.ReadResourceRequest, name string) string {
+	switch value := request.Params.Arguments[name].(type) {
+	case string:
+		return value
+	case []string:
+		return strings.Join(value, ",")
+	}
+	return ""
+}
+
+// Resource: Document
+//
+// This resource returns the text of an ingested document, addressed as
+// dk://documents/{collection}/{file}.
+func HandleReadDocumentResource(ctx context.Context, request mcp_lib.ReadResourceRequest) ([]mcp_lib.ResourceContents, error) {
+	collection := templateArgument(request, "collection")
+	file := templateArgument(request, "file")
+	if collection == "" || file == "" {
+		return nil, fmt.Errorf("invalid document URI %q", request.Params.URI)
+	}
+	ctx, err := core.UseCollection(ctx, collection, false)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := core.GetDocument(ctx, "file", file, 1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document '%s': %w", file, err)
+	}
+	if doc == nil || doc.Metadata["is_deleted"] == "true" {
+		return nil, fmt.Errorf("%w: %s", core.ErrDocumentNotFound, file)
+	}
+	return []mcp_lib.ResourceContents{
+		mcp_lib.TextResourceContents{
+			URI:      request.Params.URI,
+			MIMEType: documentMIMEType(file),
+			Text:     doc.Content,
+		},
+	}, nil
+}
@@ -0,0 +1,59 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"dk/utils"
+	"errors"
+	"testing"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+	"github.com/philippgille/chromem-go"
+)
+
+// constantEmbedding embeds every text the same way, which is enough to store and list documents
+func constantEmbedding(_ context.Context, _ string) ([]float32, error) {
+	return []float32{1, 0, 0}, nil
+}
+
+func TestDocumentResources(t *testing.T) {
+	collection, err := chromem.NewDB().CreateCollection(core.DefaultCollection, nil, constantEmbedding)
+	if err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	ctx := utils.WithChromemCollection(context.Background(), collection)
+	if err := core.AddDocument(ctx, "notes.txt", "Meeting notes", false, nil); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+	if err := core.AddDocument(ctx, "old.txt", "Removed", false, map[string]string{"is_deleted": "true"}); err != nil {
+		t.Fatalf("AddDocument failed: %v", err)
+	}
+
+	// Soft-deleted documents are neither listed nor read
+	resources := documentResources(ctx)
+	if len(resources) != 1 || resources[0].Name != "notes.txt" || resources[0].URI != "dk://documents/"+core.DefaultCollection+"/notes.txt" {
+		t.Fatalf("Expected only notes.txt, got %+v", resources)
+	}
+	if resources[0].MIMEType != "text/plain" {
+		t.Errorf("Expected the MIME type of the file, got %s", resources[0].MIMEType)
+	}
+
+	read := func(file string) ([]mcp_lib.ResourceContents, error) {
+		var request mcp_lib.ReadResourceRequest
+		request.Params.URI = documentURI(core.DefaultCollection, file)
+		request.Params.Arguments = map[string]any{"collection": core.DefaultCollection, "file": file}
+		return HandleReadDocumentResource(ctx, request)
+	}
+	contents, err := read("notes.txt")
+	if err != nil || len(contents) != 1 {
+		t.Fatalf("Expected the document, got %+v (%v)", contents, err)
+	}
+	if text, ok := contents[0].(mcp_lib.TextResourceContents); !ok || text.Text != "Meeting notes" {
+		t.Errorf("Expected the text of the document, got %+v", contents[0])
+	}
+	for _, file := range []string{"old.txt", "missing.txt"} {
+		if _, err := read(file); !errors.Is(err, core.ErrDocumentNotFound) {
+			t.Errorf("%s: expected the document not to be found, got %v", file, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// scheduledRunsShown is the number of recent runs cqListScheduledQueries shows for one query
+const scheduledRunsShown = 10
+
+// HandleScheduleQueryTool registers a question to send later, once or on a cron schedule
+func HandleScheduleQueryTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	args := request.Params.Arguments
+	question, _ := args["question"].(string)
+	if strings.TrimSpace(question) == "" {
+		return errorResult(ErrorInvalidArgument, "'question' parameter is required"), nil
+	}
+	var peers []string
+	if list, ok := args["peers"].([]any); ok {
+		for _, item := range list {
+			if peer, ok := item.(string); ok && strings.TrimSpace(peer) != "" {
+				peers = append(peers, strings.TrimPrefix(strings.TrimSpace(peer), "@"))
+			}
+		}
+	}
+	schedule, _ := args["schedule"].(string)
+	var at time.Time
+	if value, _ := args["at"].(string); strings.TrimSpace(value) != "" {
+		parsed, err := parseToolDate(strings.TrimSpace(value))
+		if err != nil {
+			return errorResult(ErrorInvalidArgument, err.Error()), nil
+		}
+		at = parsed
+	}
+
+	scheduled, err := core.ScheduleQuery(ctx, question, peers, schedule, at)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't schedule the query: %v", err)), nil
+	}
+	blob, _ := json.MarshalIndent(scheduled, "", "  ")
+	return mcp_lib.NewToolResultText(fmt.Sprintf("Scheduled query %s, first sent at %s.\n%s",
+		scheduled.ID, scheduled.NextRun.Local().Format(time.RFC3339), blob)), nil
+}
+
+// HandleListScheduledQueriesTool lists the scheduled queries, or the recent runs and answers of
+// one of them
+func HandleListScheduledQueriesTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Database not available: %v", err)), nil
+	}
+	args := request.Params.Arguments
+
+	if id, _ := args["id"].(string); strings.TrimSpace(id) != "" {
+		id = strings.TrimSpace(id)
+		scheduled, err := db.GetScheduledQuery(ctx, database, id)
+		if errors.Is(err, db.ErrNotFound) {
+			return errorResult(ErrorNotFound, fmt.Sprintf("Scheduled query '%s' not found", id)), nil
+		}
+		if err != nil {
+			return errorResult(errorCode(err), fmt.Sprintf("Failed to get scheduled query: %v", err)), nil
+		}
+		runs, err := db.ListScheduledQueryRuns(ctx, database, id, scheduledRunsShown)
+		if err != nil {
+			return errorResult(errorCode(err), fmt.Sprintf("Failed to list runs: %v", err)), nil
+		}
+		blob, _ := json.MarshalIndent(map[string]any{"query": scheduled, "runs": runs}, "", "  ")
+		return mcp_lib.NewToolResultText(string(blob)), nil
+	}
+
+	includeInactive, _ := args["include_inactive"].(bool)
+	queries, err := db.ListScheduledQueries(ctx, database, !includeInactive)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to list scheduled queries: %v", err)), nil
+	}
+	if len(queries) == 0 {
+		return mcp_lib.NewToolResultText("No scheduled queries."), nil
+	}
+	blob, _ := json.MarshalIndent(queries, "", "  ")
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
+
+// HandleCancelScheduledQueryTool stops a scheduled query from being sent again
+func HandleCancelScheduledQueryTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Database not available: %v", err)), nil
+	}
+	id, _ := request.Params.Arguments["id"].(string)
+	if id = strings.TrimSpace(id); id == "" {
+		return errorResult(ErrorInvalidArgument, "'id' parameter is required"), nil
+	}
+	if err := db.CancelScheduledQuery(ctx, database, id); errors.Is(err, db.ErrNotFound) {
+		return errorResult(ErrorNotFound, fmt.Sprintf("Scheduled query '%s' not found", id)), nil
+	} else if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to cancel scheduled query: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(fmt.Sprintf("Scheduled query %s cancelled; its past runs and answers are kept.", id)), nil
+}
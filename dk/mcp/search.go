@@ -0,0 +1,81 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+const (
+	defaultSearchResults = 5
+	maxSearchResults     = 50
+	defaultSnippetLength = 300
+)
+
+// searchHit is a chunk of a document returned by search_documents
+type searchHit struct {
+	File     string            `json:"file"`
+	Score    float32           `json:"score"`
+	Snippet  string            `json:"snippet"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Tool: Search Documents
+//
+// This tool runs a similarity query against the local knowledge base and returns the closest
+// chunks with their scores, without generating an answer.
+// Input parameters: "query" and optionally "num_results", "collection", "tags" and "snippet_length".
+func HandleSearchDocumentsTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	args := request.Params.Arguments
+	query, _ := args["query"].(string)
+	if strings.TrimSpace(query) == "" {
+		return errorResult(ErrorInvalidArgument, "'query' parameter is required"), nil
+	}
+	numResults := defaultSearchResults
+	if value, ok := args["num_results"].(float64); ok && value > 0 {
+		numResults = min(int(value), maxSearchResults)
+	}
+	snippetLength := defaultSnippetLength
+	if value, ok := args["snippet_length"].(float64); ok && value > 0 {
+		snippetLength = int(value)
+	}
+	collection, _ := args["collection"].(string)
+	ctx, err := core.UseCollection(ctx, strings.TrimSpace(collection), false)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Invalid collection: %v", err)), nil
+	}
+
+	docs, err := core.SearchDocuments(ctx, query, numResults, core.MetadataFilter{Tags: stringList(args, "tags")})
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to search documents: %v", err)), nil
+	}
+
+	hits := make([]searchHit, 0, len(docs))
+	for _, doc := range docs {
+		hits = append(hits, searchHit{
+			File:     doc.FileName,
+			Score:    doc.Score,
+			Snippet:  snippet(doc.Content, snippetLength),
+			Metadata: doc.Metadata,
+		})
+	}
+	blob, err := json.MarshalIndent(hits, "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode search results: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
+
+// snippet collapses the whitespace of a chunk and cuts it to at most length characters
+func snippet(content string, length int) string {
+	text := strings.Join(strings.Fields(content), " ")
+	runes := []rune(text)
+	if len(runes) <= length {
+		return text
+	}
+	return strings.TrimSpace(string(runes[:length])) + "…"
+}
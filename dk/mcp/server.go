@@ -1,17 +1,134 @@
 package mcp
 
 import (
+	"context"
+	"dk/core"
+	"dk/mcp/i18n"
+	"fmt"
 	mcp_lib "github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
+	"strings"
+	"time"
 )
 
-func NewMCPServer() *server.MCPServer {
-	mcpServer := server.NewMCPServer(
+// scopedServer registers tools so that they can only be called by the roles permitted to use
+// them, see core.Principal.ToolAllowed, and as the tool policy of the operator allows
+type scopedServer struct {
+	*server.MCPServer
+	policy     ToolPolicy
+	registered map[string]bool // Every tool of the server, registered or disabled
+	cache      *resultCache    // Results of read-only tools, see cachedTools
+	confirms   *confirmations  // Calls awaiting confirmation
+	limiter    *rateLimiter    // Rate limits of the policy
+}
+
+// AddTool registers a tool whose handler rejects callers outside its scope. Disabled tools are
+// left out, and tools requiring confirmation first describe the call and return a token it
+// only runs with, see confirmations. Calls beyond the rate limit of the policy are refused.
+// Results of read-only tools are cached briefly, failures are returned in the toolError
+// envelope, and every call is recorded in the tool_calls table.
+func (s scopedServer) AddTool(tool mcp_lib.Tool, handler server.ToolHandlerFunc) {
+	s.registered[tool.Name] = true
+	access := s.policy.Access(tool.Name)
+	if access == ToolDisabled {
+		return
+	}
+	handler = s.cache.wrap(tool.Name, handler)
+	if access == ToolConfirm {
+		handler = s.confirms.guard(tool.Name, handler)
+		tool.InputSchema.Properties["confirm_token"] = map[string]any{
+			"type":        "string",
+			"description": "The token returned by a call without it, once the user has agreed to the described call. Without it the tool only describes what it would do.",
+		}
+	}
+	s.MCPServer.AddTool(tool, func(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+		start := time.Now()
+		result := s.call(ctx, tool.Name, handler, request)
+		recordToolCall(ctx, tool.Name, request.Params.Arguments, result, time.Since(start))
+		return result, nil
+	})
+}
+
+// call runs the handler of a tool if the caller may call it now
+func (s scopedServer) call(ctx context.Context, name string, handler server.ToolHandlerFunc, request mcp_lib.CallToolRequest) *mcp_lib.CallToolResult {
+	principal := core.PrincipalFromContext(ctx)
+	if !principal.ToolAllowed(name) {
+		return errorResult(ErrorForbidden, i18n.Message(language(ctx), "forbidden.tool", principal.Role, name))
+	}
+	if ok, limit, wait := s.limiter.allow(name, callerOf(ctx)); !ok {
+		return errorResult(ErrorRateLimited, i18n.Message(language(ctx), "tool.rate_limited", name, limit.Calls, limit.Per, max(wait.Round(time.Second), time.Second)))
+	}
+	result, _ := errorEnvelope(handler(ctx, request))
+	return result
+}
+
+// NewMCPServer creates the MCP server with the tools the policy leaves enabled, including the
+// tools of plugins. It fails when the policy lists a tool the server does not have, or when a
+// plugin provides a tool that already exists.
+func NewMCPServer(policy ToolPolicy, plugins []Plugin) (*server.MCPServer, error) {
+	// Tool calls are counted for telemetry if the user opted in
+	hooks := &server.Hooks{}
+	hooks.AddBeforeCallTool(func(ctx context.Context, id any, message *mcp_lib.CallToolRequest) {
+		core.RecordFeature(ctx, core.TelemetryMCP, message.Params.Name)
+	})
+	// Ingested documents are listed as resources next to the registered ones
+	// Descriptions are listed in the language of the session
+	hooks.AddAfterListTools(func(ctx context.Context, id any, message *mcp_lib.ListToolsRequest, result *mcp_lib.ListToolsResult) {
+		localizeTools(ctx, result)
+	})
+	hooks.AddAfterListPrompts(func(ctx context.Context, id any, message *mcp_lib.ListPromptsRequest, result *mcp_lib.ListPromptsResult) {
+		localizePrompts(ctx, result)
+	})
+	hooks.AddAfterListResources(func(ctx context.Context, id any, message *mcp_lib.ListResourcesRequest, result *mcp_lib.ListResourcesResult) {
+		result.Resources = append(result.Resources, documentResources(ctx)...)
+	})
+
+	mcpServer := scopedServer{server.NewMCPServer(
 		"openmined/dk-server",
 		"1.0.0",
 		server.WithResourceCapabilities(true, true),
 		server.WithPromptCapabilities(true),
 		server.WithLogging(),
+		server.WithHooks(hooks),
+	), policy, make(map[string]bool), newResultCache(), newConfirmations(), newRateLimiter(policy)}
+
+	// Resource: Document
+	mcpServer.AddResourceTemplate(
+		mcp_lib.NewResourceTemplate(documentURITemplate, "Document",
+			mcp_lib.WithTemplateDescription("An ingested document of the knowledge base, by collection and file name."),
+		),
+		HandleReadDocumentResource,
+	)
+
+	// Prompt: Triage Pending Queries
+	mcpServer.AddPrompt(
+		mcp_lib.NewPrompt("triage_pending_queries",
+			mcp_lib.WithPromptDescription("Review the queries waiting for approval and accept or reject each one."),
+			mcp_lib.WithArgument("from",
+				mcp_lib.ArgumentDescription("Only include the queries of this peer."),
+			),
+			mcp_lib.WithArgument("limit",
+				mcp_lib.ArgumentDescription("Maximum number of queries to include (20 by default)."),
+			),
+		),
+		[]string{"cqListRequestedQueries", "cqUpdateEditAnswer", "cqProcessQuery"},
+		HandleTriagePendingQueriesPrompt,
+	)
+
+	// Prompt: Summarize Peer Answers
+	mcpServer.AddPrompt(
+		mcp_lib.NewPrompt("summarize_peer_answers",
+			mcp_lib.WithPromptDescription("Summarize the answers peers sent to a question asked on the network."),
+			mcp_lib.WithArgument("question",
+				mcp_lib.ArgumentDescription("The question exactly as it was asked."),
+				mcp_lib.RequiredArgument(),
+			),
+			mcp_lib.WithArgument("detailed",
+				mcp_lib.ArgumentDescription("Set to 'true' for an in-depth summary instead of a concise one."),
+			),
+		),
+		[]string{"cqSummarizeAnswers"},
+		HandleSummarizePeerAnswersPrompt,
 	)
 
 	// Tool: Ask Question
@@ -25,10 +142,19 @@ func NewMCPServer() *server.MCPServer {
 			),
 			mcp_lib.WithArray(
 				"peers",
-				mcp_lib.Description("List of peer identifiers (without '@') to receive the question. Leave empty to broadcast to all peers."),
+				mcp_lib.Description("List of peer identifiers or aliases (without '@') to receive the question. Leave empty, without tags, to broadcast to all peers."),
 				mcp_lib.Items(map[string]any{"type": "string"}),
 				mcp_lib.Required(),
 			),
+			mcp_lib.WithArray(
+				"tags",
+				mcp_lib.Description("Also send the question to every peer tagged with any of these datasets (see cqTagPeer)."),
+				mcp_lib.Items(map[string]any{"type": "string"}),
+			),
+			mcp_lib.WithBoolean(
+				"force",
+				mcp_lib.Description("Also send the question to peers that announced they do not accept questions about its topic."),
+			),
 		),
 		HandleAskTool,
 	)
@@ -36,7 +162,7 @@ func NewMCPServer() *server.MCPServer {
 	// Tool: List Queries
 	mcpServer.AddTool(
 		mcp_lib.NewTool("cqListRequestedQueries",
-			mcp_lib.WithDescription("Retrieve all requested queries, optionally filtered by status or sender."),
+			mcp_lib.WithDescription("Retrieve the requested queries, newest first and one page at a time, optionally filtered by status, sender, text or date. Queries are summarized for triage unless the full view is asked for."),
 			mcp_lib.WithString(
 				"status",
 				mcp_lib.Description("Optional status filter (e.g., 'pending', 'accepted')."),
@@ -45,6 +171,12 @@ func NewMCPServer() *server.MCPServer {
 				"from",
 				mcp_lib.Description("Optional sender filter (peer identifier)."),
 			),
+			mcp_lib.WithString("search", mcp_lib.Description("Only list queries whose question or answer contains this text.")),
+			mcp_lib.WithString("since", mcp_lib.Description("Only list queries received on or after this date, as YYYY-MM-DD or RFC 3339.")),
+			mcp_lib.WithNumber("limit", mcp_lib.Description("Maximum number of queries to return (default 50, at most 200).")),
+			mcp_lib.WithNumber("offset", mcp_lib.Description("Number of queries to skip.")),
+			mcp_lib.WithString("cursor", mcp_lib.Description("The next_cursor of the previous page, to continue where it ended. Takes precedence over offset.")),
+			mcp_lib.WithString("view", mcp_lib.Description("'triage' (default) for a one-line summary, topic tags, estimated effort and matched documents count per query; 'full' for the complete question, drafted answer and related documents.")),
 		),
 		HandleListQueriesTool,
 	)
@@ -83,6 +215,159 @@ func NewMCPServer() *server.MCPServer {
 		HandleListApprovalConditionsTool,
 	)
 
+	// Tool: List Approval Decisions
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqListApprovalDecisions",
+			mcp_lib.WithDescription("List how the automatic approval conditions decided on incoming queries, newest first: accepted, rejected or left for review, by which condition and why."),
+			mcp_lib.WithString("query_id", mcp_lib.Description("Only list the decisions on this query.")),
+			mcp_lib.WithNumber("limit", mcp_lib.Description("Maximum number of decisions to return (50 by default).")),
+		),
+		HandleListApprovalDecisionsTool,
+	)
+
+	// Tool: Export Approval Pack
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqExportApprovalPack",
+			mcp_lib.WithDescription("Export the automatic approval conditions as a signed pack that can be shared with other peers."),
+			mcp_lib.WithString(
+				"name",
+				mcp_lib.Description("Name of the pack."),
+				mcp_lib.Required(),
+			),
+			mcp_lib.WithString(
+				"description",
+				mcp_lib.Description("Optional description of what the pack approves."),
+			),
+			mcp_lib.WithString(
+				"file_path",
+				mcp_lib.Description("Optional path to write the pack to. The pack is returned when omitted."),
+			),
+		),
+		HandleExportApprovalPackTool,
+	)
+
+	// Tool: Preview Approval Pack
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqPreviewApprovalPack",
+			mcp_lib.WithDescription("Verify an approval pack and show which recent queries its conditions would have approved, without installing it."),
+			mcp_lib.WithString(
+				"pack_path",
+				mcp_lib.Description("Path to the pack file."),
+			),
+			mcp_lib.WithString(
+				"pack_json",
+				mcp_lib.Description("The pack itself as JSON, instead of a file."),
+			),
+			mcp_lib.WithNumber(
+				"limit",
+				mcp_lib.Description("Number of recent answered queries to evaluate (default 20)."),
+			),
+		),
+		HandlePreviewApprovalPackTool,
+	)
+
+	// Tool: Import Approval Pack
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqImportApprovalPack",
+			mcp_lib.WithDescription("Verify an approval pack's signature and author, and add its conditions to the automatic approval conditions."),
+			mcp_lib.WithString(
+				"pack_path",
+				mcp_lib.Description("Path to the pack file."),
+			),
+			mcp_lib.WithString(
+				"pack_json",
+				mcp_lib.Description("The pack itself as JSON, instead of a file."),
+			),
+		),
+		HandleImportApprovalPackTool,
+	)
+
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqDistributeKeyShares",
+			mcp_lib.WithDescription("Split your identity key into shares and send one to each trusted peer, so the key can be recovered on a new device if it is lost."),
+			mcp_lib.WithArray(
+				"trustees",
+				mcp_lib.Description("Peers that will each hold one share."),
+				mcp_lib.Items(map[string]any{"type": "string"}),
+				mcp_lib.Required(),
+			),
+			mcp_lib.WithNumber(
+				"threshold",
+				mcp_lib.Description("Number of shares needed to recover the key (at least 2)."),
+				mcp_lib.Required(),
+			),
+		),
+		HandleDistributeKeySharesTool,
+	)
+
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqListKeyEscrow",
+			mcp_lib.WithDescription("List your key trustees, the key shares you hold for other peers and the recovery requests you received."),
+			mcp_lib.WithString(
+				"status",
+				mcp_lib.Description("Only list recovery requests with this status (pending, approved or denied)."),
+			),
+		),
+		HandleListKeyEscrowTool,
+	)
+
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqDecideKeyRecovery",
+			mcp_lib.WithDescription("Approve or deny a request to release the key share you hold for a peer. Only approve after confirming the request really comes from that peer."),
+			mcp_lib.WithString(
+				"id",
+				mcp_lib.Description("ID of the recovery request."),
+				mcp_lib.Required(),
+			),
+			mcp_lib.WithBoolean(
+				"approve",
+				mcp_lib.Description("True to release the share, false to deny the request."),
+				mcp_lib.Required(),
+			),
+		),
+		HandleDecideKeyRecoveryTool,
+	)
+
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqRequestKeyRecovery",
+			mcp_lib.WithDescription("Ask the trustees of a lost identity key to release their shares to this device."),
+			mcp_lib.WithString(
+				"owner",
+				mcp_lib.Description("User ID whose key was lost."),
+				mcp_lib.Required(),
+			),
+			mcp_lib.WithArray(
+				"trustees",
+				mcp_lib.Description("Peers that hold shares of the key."),
+				mcp_lib.Items(map[string]any{"type": "string"}),
+				mcp_lib.Required(),
+			),
+		),
+		HandleRequestKeyRecoveryTool,
+	)
+
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqCompleteKeyRecovery",
+			mcp_lib.WithDescription("Reconstruct a lost identity key from the released shares and write it to new key files."),
+			mcp_lib.WithString(
+				"owner",
+				mcp_lib.Description("User ID whose key is being recovered."),
+				mcp_lib.Required(),
+			),
+			mcp_lib.WithString(
+				"private_key_path",
+				mcp_lib.Description("Where to write the recovered private key."),
+				mcp_lib.Required(),
+			),
+			mcp_lib.WithString(
+				"public_key_path",
+				mcp_lib.Description("Where to write the public key."),
+				mcp_lib.Required(),
+			),
+		),
+		HandleCompleteKeyRecoveryTool,
+	)
+
 	// Tool: Accept Query
 	mcpServer.AddTool(
 		mcp_lib.NewTool("cqProcessQuery",
@@ -101,6 +386,32 @@ func NewMCPServer() *server.MCPServer {
 		HandleProcessQuestionTool,
 	)
 
+	// Tool: Process Queries
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqProcessQueries",
+			mcp_lib.WithDescription("Accept or reject every query matching a filter in one call. Run it with dry_run first to see which queries match."),
+			mcp_lib.WithBoolean("approve", mcp_lib.Description("True to accept the matching queries and send their answers, false to reject them."), mcp_lib.Required()),
+			mcp_lib.WithString("status", mcp_lib.Description("Only process queries with this status. Defaults to 'pending'.")),
+			mcp_lib.WithString("from", mcp_lib.Description("Only process the queries of this peer.")),
+			mcp_lib.WithString("topic", mcp_lib.Description("Regular expression, ignoring case, the question or the summary or a tag of its triage must match.")),
+			mcp_lib.WithBoolean("dry_run", mcp_lib.Description("List the matching queries without processing them.")),
+		),
+		HandleProcessQueriesTool,
+	)
+
+	// Tool: Export History
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqExportHistory",
+			mcp_lib.WithDescription("Export the queries peers asked, with their answers and statuses, and the answers peers sent, to a JSON or CSV file for compliance reporting."),
+			mcp_lib.WithString("path", mcp_lib.Description("File to write the export to."), mcp_lib.Required()),
+			mcp_lib.WithString("format", mcp_lib.Description("'json' or 'csv'. Defaults to the extension of the path, or json."), mcp_lib.Enum("json", "csv")),
+			mcp_lib.WithString("since", mcp_lib.Description("Only records from this date on, as YYYY-MM-DD or RFC 3339.")),
+			mcp_lib.WithString("until", mcp_lib.Description("Only records up to this date, included, as YYYY-MM-DD; or before this RFC 3339 time.")),
+			mcp_lib.WithBoolean("overwrite", mcp_lib.Description("Replace the file if it already exists.")),
+		),
+		HandleExportHistoryTool,
+	)
+
 	mcpServer.AddTool(
 		mcp_lib.NewTool("cqSummarizeAnswers",
 			// What this tool does, in one precise sentence
@@ -125,19 +436,327 @@ func NewMCPServer() *server.MCPServer {
 				),
 				mcp_lib.DefaultBool(false),
 			),
+
+			// Which answers to fetch, one page at a time
+			mcp_lib.WithString("question", mcp_lib.Description("Only include answers to questions containing this text. Defaults to related_question, or to all answers if no question contains it.")),
+			mcp_lib.WithString("user", mcp_lib.Description("Only include the answers of this peer.")),
+			mcp_lib.WithString("search", mcp_lib.Description("Only include answers containing this text.")),
+			mcp_lib.WithNumber("limit", mcp_lib.Description("Maximum number of answers to include (default 50, at most 200).")),
+			mcp_lib.WithNumber("offset", mcp_lib.Description("Number of answers to skip.")),
+			mcp_lib.WithString("cursor", mcp_lib.Description("The cursor returned with the previous page, to continue where it ended. Takes precedence over offset.")),
 		),
 		HandleAnswerListTool,
 	)
 
 	// Tool: Update RAG Knowledge Base
 	mcpServer.AddTool(mcp_lib.NewTool("updateKnowledgeSources",
-		mcp_lib.WithDescription("Updates knowledge sources by saving provided file name and content or file path, then refreshing the vector database."),
+		mcp_lib.WithDescription("Updates knowledge sources by saving provided file name and content or file path, then refreshing the vector database. PDF, DOCX and HTML files are converted to text."),
 		// Two string parameters: file_name and file_content.
 		mcp_lib.WithString("file_name", mcp_lib.Description("The name of the file to add (e.g., mydocument.pdf)")),
 		mcp_lib.WithString("file_content", mcp_lib.Description("The content of the file")),
-		mcp_lib.WithString("file_path", mcp_lib.Description("The content of the file")),
+		mcp_lib.WithString("content_encoding", mcp_lib.Description("Set to 'base64' when file_content is base64 encoded, e.g. for PDF or DOCX files")),
+		mcp_lib.WithString("file_path", mcp_lib.Description("Path to an existing file to add, instead of file_name and file_content")),
+		mcp_lib.WithString("collection", mcp_lib.Description("Named collection to add the file to. Defaults to the default collection; a new name creates the collection.")),
+		mcp_lib.WithArray("tags", mcp_lib.Description("Tags stored with the document, used to filter retrieval."), mcp_lib.Items(map[string]any{"type": "string"})),
 	), HandleUpdateRagSourcesTool)
 
+	// Tool: List Vector Collections
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqListCollections",
+			mcp_lib.WithDescription("List the named vector collections of the knowledge base and the number of documents in each."),
+		),
+		HandleListCollectionsTool,
+	)
+
+	// Tool: Knowledge Gaps
+	mcpServer.AddTool(
+		mcp_lib.NewTool("knowledge_gaps",
+			mcp_lib.WithDescription("Report the topics of incoming questions that were rejected or could not be answered from the knowledge base, largest first, with documents suggested to fill each gap."),
+			mcp_lib.WithBoolean("refresh", mcp_lib.Description("Run the analysis again instead of returning the latest report.")),
+		),
+		HandleKnowledgeGapsTool,
+	)
+
+	// Tool: Search Documents
+	mcpServer.AddTool(
+		mcp_lib.NewTool("search_documents",
+			mcp_lib.WithDescription("Search the local knowledge base by similarity and return the closest document chunks with their scores and a snippet, without generating an answer."),
+			mcp_lib.WithString("query", mcp_lib.Description("Text to search for."), mcp_lib.Required()),
+			mcp_lib.WithNumber("num_results", mcp_lib.Description("Maximum number of chunks to return (default 5, at most 50).")),
+			mcp_lib.WithString("collection", mcp_lib.Description("Collection to search. Defaults to the PersonalKnowledge collection.")),
+			mcp_lib.WithArray("tags", mcp_lib.Description("Only return documents that have all these tags."), mcp_lib.Items(map[string]any{"type": "string"})),
+			mcp_lib.WithNumber("snippet_length", mcp_lib.Description("Maximum number of characters of each snippet (default 300).")),
+		),
+		HandleSearchDocumentsTool,
+	)
+
+	// Tool: List Documents
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqListDocuments",
+			mcp_lib.WithDescription("List the documents stored in the knowledge base with their number of chunks, size and tags."),
+			mcp_lib.WithString("collection", mcp_lib.Description("Collection to list. Defaults to the PersonalKnowledge collection.")),
+		),
+		HandleListDocumentsTool,
+	)
+
+	// Tool: Get Document Chunks
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqGetDocumentChunks",
+			mcp_lib.WithDescription("Show the chunks a document is stored in, in order, with their position in the document. A stored summary comes last."),
+			mcp_lib.WithString("file_name", mcp_lib.Description("Name of the document."), mcp_lib.Required()),
+			mcp_lib.WithString("collection", mcp_lib.Description("Collection of the document. Defaults to the PersonalKnowledge collection.")),
+		),
+		HandleGetDocumentChunksTool,
+	)
+
+	// Tool: Delete Document
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqDeleteDocument",
+			mcp_lib.WithDescription("Delete a document from the knowledge base. The document is marked as deleted and left out of answers, and can be restored; set permanent to remove it for good, along with the associations linking it to APIs and requests."),
+			mcp_lib.WithString("file_name", mcp_lib.Description("Name of the document to delete."), mcp_lib.Required()),
+			mcp_lib.WithString("collection", mcp_lib.Description("Collection of the document. Defaults to the PersonalKnowledge collection.")),
+			mcp_lib.WithBoolean("permanent", mcp_lib.Description("Remove the document and its associations for good rather than marking it as deleted.")),
+		),
+		HandleDeleteDocumentTool,
+	)
+
+	// Tool: Update Document
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqUpdateDocument",
+			mcp_lib.WithDescription("Replace the content of an existing document in the knowledge base. Its tags and other metadata are kept unless new tags are given; if the new content cannot be embedded, the old content stays."),
+			mcp_lib.WithString("file_name", mcp_lib.Description("Name of the document to update."), mcp_lib.Required()),
+			mcp_lib.WithString("file_content", mcp_lib.Description("New content of the document."), mcp_lib.Required()),
+			mcp_lib.WithString("content_encoding", mcp_lib.Description("Set to 'base64' when file_content holds a base64 encoded binary file such as a PDF or DOCX.")),
+			mcp_lib.WithString("collection", mcp_lib.Description("Collection of the document. Defaults to the PersonalKnowledge collection.")),
+			mcp_lib.WithArray("tags", mcp_lib.Description("New tags; an empty list removes all tags."), mcp_lib.Items(map[string]any{"type": "string"})),
+		),
+		HandleUpdateDocumentTool,
+	)
+
+	// Tool: List RAG Sources
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqListRagSources",
+			mcp_lib.WithDescription("List the entries of the RAG sources file with their ingestion status ('pending', 'indexed' or 'failed') and last error."),
+		),
+		HandleListRagSourcesTool,
+	)
+
+	// Tool: Add RAG Source
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqAddRagSource",
+			mcp_lib.WithDescription("Add an entry to the RAG sources file and ingest it. Provide exactly one of 'text', 'path' or 'url'."),
+			mcp_lib.WithString("text", mcp_lib.Description("Inline content of the source.")),
+			mcp_lib.WithString("path", mcp_lib.Description("Path of a local file to ingest.")),
+			mcp_lib.WithString("url", mcp_lib.Description("HTTP(S) address of a document to download and ingest.")),
+			mcp_lib.WithString("file", mcp_lib.Description("Name the document is stored under. Required for 'text'; defaults to the base name of the path or URL.")),
+			mcp_lib.WithString("collection", mcp_lib.Description("Named collection to store the document in. Defaults to the PersonalKnowledge collection.")),
+			mcp_lib.WithArray("tags", mcp_lib.Description("Tags stored with the document."), mcp_lib.Items(map[string]any{"type": "string"})),
+			mcp_lib.WithString("owner", mcp_lib.Description("Owner stored with the document.")),
+		),
+		HandleAddRagSourceTool,
+	)
+
+	// Tool: Update RAG Source
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqUpdateRagSource",
+			mcp_lib.WithDescription("Change an entry of the RAG sources file and re-ingest it. Only the given fields change; setting 'text', 'path' or 'url' replaces the previous content."),
+			mcp_lib.WithString("file", mcp_lib.Description("Name of the entry to update."), mcp_lib.Required()),
+			mcp_lib.WithString("collection", mcp_lib.Description("Collection of the entry. Defaults to the PersonalKnowledge collection.")),
+			mcp_lib.WithString("new_file", mcp_lib.Description("New name of the entry.")),
+			mcp_lib.WithString("text", mcp_lib.Description("New inline content.")),
+			mcp_lib.WithString("path", mcp_lib.Description("New local file.")),
+			mcp_lib.WithString("url", mcp_lib.Description("New HTTP(S) address.")),
+			mcp_lib.WithArray("tags", mcp_lib.Description("New tags; an empty list removes all tags."), mcp_lib.Items(map[string]any{"type": "string"})),
+			mcp_lib.WithString("owner", mcp_lib.Description("New owner.")),
+		),
+		HandleUpdateRagSourceTool,
+	)
+
+	// Tool: Remove RAG Source
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqRemoveRagSource",
+			mcp_lib.WithDescription("Remove an entry from the RAG sources file and delete its document from the knowledge base."),
+			mcp_lib.WithString("file", mcp_lib.Description("Name of the entry to remove."), mcp_lib.Required()),
+			mcp_lib.WithString("collection", mcp_lib.Description("Collection of the entry. Defaults to the PersonalKnowledge collection.")),
+		),
+		HandleRemoveRagSourceTool,
+	)
+
+	// Tool: Re-ingest RAG Source
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqReingestRagSource",
+			mcp_lib.WithDescription("Embed an entry of the RAG sources file again, even if it did not change, e.g. when the document behind a URL was updated."),
+			mcp_lib.WithString("file", mcp_lib.Description("Name of the entry to re-ingest."), mcp_lib.Required()),
+			mcp_lib.WithString("collection", mcp_lib.Description("Collection of the entry. Defaults to the PersonalKnowledge collection.")),
+		),
+		HandleReingestRagSourceTool,
+	)
+
+	// Tool: List APIs
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqListAPIs",
+			mcp_lib.WithDescription("List the APIs hosted by this node with their status, policy, number of users and documents. API keys are not included."),
+			mcp_lib.WithString("status", mcp_lib.Description("Only list APIs with this status: 'active', 'inactive' or 'deprecated'.")),
+			mcp_lib.WithString("external_user", mcp_lib.Description("Only list APIs this peer has access to.")),
+			mcp_lib.WithNumber("limit", mcp_lib.Description("Maximum number of APIs to return (default 50).")),
+			mcp_lib.WithNumber("offset", mcp_lib.Description("Number of APIs to skip.")),
+		),
+		HandleListAPIsTool,
+	)
+
+	// Tool: Create API
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqCreateAPI",
+			mcp_lib.WithDescription("Create an API that gives peers access to documents of the knowledge base, and return it with its API key."),
+			mcp_lib.WithString("name", mcp_lib.Description("Name of the API."), mcp_lib.Required()),
+			mcp_lib.WithString("description", mcp_lib.Description("What the API offers.")),
+			mcp_lib.WithString("policy_id", mcp_lib.Description("ID of the usage policy applied to the API.")),
+			mcp_lib.WithArray("documents", mcp_lib.Description("File names of the documents served by the API."), mcp_lib.Items(map[string]any{"type": "string"})),
+			mcp_lib.WithArray("users", mcp_lib.Description("Peers granted read access to the API."), mcp_lib.Items(map[string]any{"type": "string"})),
+			mcp_lib.WithBoolean("inactive", mcp_lib.Description("Create the API inactive instead of active.")),
+		),
+		HandleCreateAPITool,
+	)
+
+	// Tool: Rotate API Key
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqRotateAPIKey",
+			mcp_lib.WithDescription("Replace the key of an API with a new one and return it. The old key stops working immediately."),
+			mcp_lib.WithString("id", mcp_lib.Description("ID of the API."), mcp_lib.Required()),
+		),
+		HandleRotateAPIKeyTool,
+	)
+
+	// Tool: Deprecate API
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqDeprecateAPI",
+			mcp_lib.WithDescription("Mark an API as deprecated, with a message telling its users what to use instead."),
+			mcp_lib.WithString("id", mcp_lib.Description("ID of the API."), mcp_lib.Required()),
+			mcp_lib.WithString("message", mcp_lib.Description("Deprecation message shown to the users of the API.")),
+			mcp_lib.WithString("date", mcp_lib.Description("Date the deprecation takes effect, as YYYY-MM-DD or RFC 3339. Defaults to now.")),
+		),
+		HandleDeprecateAPITool,
+	)
+
+	// Tool: Grant API Access
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqGrantAPIAccess",
+			mcp_lib.WithDescription("Give an external user read or write access to an API, or change the level of an existing grant. Asks for confirmation before granting."),
+			mcp_lib.WithString("api_id", mcp_lib.Description("ID of the API."), mcp_lib.Required()),
+			mcp_lib.WithString("user", mcp_lib.Description("ID of the external user (without '@')."), mcp_lib.Required()),
+			mcp_lib.WithString("access_level", mcp_lib.Description("'read' (default) or 'write'.")),
+		),
+		HandleGrantAPIAccessTool,
+	)
+
+	// Tool: Revoke API Access
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqRevokeAPIAccess",
+			mcp_lib.WithDescription("Revoke the access of an external user to an API. Asks for confirmation before revoking."),
+			mcp_lib.WithString("api_id", mcp_lib.Description("ID of the API."), mcp_lib.Required()),
+			mcp_lib.WithString("user", mcp_lib.Description("ID of the external user (without '@')."), mcp_lib.Required()),
+		),
+		HandleRevokeAPIAccessTool,
+	)
+
+	// Tool: List Policies
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqListPolicies",
+			mcp_lib.WithDescription("List the usage policies that can be attached to APIs, with their rules."),
+			mcp_lib.WithString("type", mcp_lib.Description("Only list policies of this type: free, rate, token, time, credit or composite.")),
+			mcp_lib.WithBoolean("active_only", mcp_lib.Description("Only list active policies.")),
+		),
+		HandleListPoliciesTool,
+	)
+
+	// Tool: Create Policy
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqCreatePolicy",
+			mcp_lib.WithDescription("Create a usage policy limiting how much peers may use an API. Every policy except 'free' needs rules; the rules of a non-composite policy must all be of the policy's type."),
+			mcp_lib.WithString("name", mcp_lib.Description("Name of the policy."), mcp_lib.Required()),
+			mcp_lib.WithString("type", mcp_lib.Description("free, rate, token, time, credit or composite."), mcp_lib.Required()),
+			mcp_lib.WithString("description", mcp_lib.Description("What the policy allows.")),
+			mcp_lib.WithArray("rules", mcp_lib.Description("Limits of the policy."), mcp_lib.Items(policyRuleSchema)),
+		),
+		HandleCreatePolicyTool,
+	)
+
+	// Tool: Change API Policy
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqChangeAPIPolicy",
+			mcp_lib.WithDescription("Attach a policy to an API, immediately or from a future date. The change is recorded in the API's policy history."),
+			mcp_lib.WithString("api_id", mcp_lib.Description("ID of the API."), mcp_lib.Required()),
+			mcp_lib.WithString("policy_id", mcp_lib.Description("ID of the active policy to attach."), mcp_lib.Required()),
+			mcp_lib.WithString("scheduled_date", mcp_lib.Description("Future date the policy takes effect, as YYYY-MM-DD or RFC 3339. Applies immediately when omitted.")),
+			mcp_lib.WithString("reason", mcp_lib.Description("Reason recorded with the change.")),
+		),
+		HandleChangeAPIPolicyTool,
+	)
+
+	// Tool: Delete Policy
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqDeletePolicy",
+			mcp_lib.WithDescription("Delete a policy and its rules. Policies still attached to an API cannot be deleted."),
+			mcp_lib.WithString("id", mcp_lib.Description("ID of the policy."), mcp_lib.Required()),
+		),
+		HandleDeletePolicyTool,
+	)
+
+	// Tool: List Trackers
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqListTrackers",
+			mcp_lib.WithDescription("List the trackers that API requests can require, with whether each is active."),
+			mcp_lib.WithBoolean("active_only", mcp_lib.Description("Only list active trackers.")),
+		),
+		HandleListTrackersTool,
+	)
+
+	// Tool: Create Tracker
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqCreateTracker",
+			mcp_lib.WithDescription("Create a tracker that API requests can require. New trackers are active."),
+			mcp_lib.WithString("name", mcp_lib.Description("Name of the tracker."), mcp_lib.Required()),
+			mcp_lib.WithString("description", mcp_lib.Description("What the tracker records.")),
+		),
+		HandleCreateTrackerTool,
+	)
+
+	// Tool: Set Tracker Active
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqSetTrackerActive",
+			mcp_lib.WithDescription("Activate or deactivate a tracker. New API requests cannot require an inactive tracker; requests that already require it keep it."),
+			mcp_lib.WithString("id", mcp_lib.Description("ID of the tracker."), mcp_lib.Required()),
+			mcp_lib.WithBoolean("active", mcp_lib.Description("True to activate the tracker, false to deactivate it."), mcp_lib.Required()),
+		),
+		HandleSetTrackerActiveTool,
+	)
+
+	// Tool: Get Usage Summary
+	mcpServer.AddTool(
+		mcp_lib.NewTool("get_usage_summary",
+			mcp_lib.WithDescription("Get how much peers used the hosted APIs: requests, tokens, credits, time and throttled or blocked requests per day, week or month, with totals per API and per external user."),
+			mcp_lib.WithString("period", mcp_lib.Description("daily (default), weekly or monthly.")),
+			mcp_lib.WithString("api_id", mcp_lib.Description("Only include this API. Every API is included when omitted.")),
+			mcp_lib.WithString("external_user", mcp_lib.Description("Only include this external user.")),
+			mcp_lib.WithString("from", mcp_lib.Description("Only include periods starting on or after this date, as YYYY-MM-DD or RFC 3339.")),
+			mcp_lib.WithString("to", mcp_lib.Description("Only include periods ending on or before this date, as YYYY-MM-DD or RFC 3339.")),
+		),
+		HandleGetUsageSummaryTool,
+	)
+
+	// Tool: Set Language
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqSetLanguage",
+			mcp_lib.WithDescription("Choose the language of tool descriptions and messages for the rest of this session."),
+			mcp_lib.WithString(
+				"language",
+				mcp_lib.Description("Language tag, such as 'en', 'es' or 'pt-BR'."),
+				mcp_lib.Required(),
+			),
+		),
+		HandleSetLanguageTool,
+	)
+
 	// Tool: Update Answer Content
 	mcpServer.AddTool(
 		mcp_lib.NewTool("cqUpdateEditAnswer",
@@ -168,6 +787,37 @@ func NewMCPServer() *server.MCPServer {
 		HandleGetActiveUsersTool,
 	)
 
+	// Tool: List Peers
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqListPeers",
+			mcp_lib.WithDescription("List the known peers with their online status, alias, notes and dataset tags. Peers come from the server and from your contacts."),
+			mcp_lib.WithArray("tags", mcp_lib.Description("Only list peers tagged with any of these datasets."), mcp_lib.Items(map[string]any{"type": "string"})),
+		),
+		HandleListPeersTool,
+	)
+
+	// Tool: Update Contact
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqUpdateContact",
+			mcp_lib.WithDescription("Set the alias and notes you keep about a peer. An alias can be used wherever a peer ID is asked for."),
+			mcp_lib.WithString("peer", mcp_lib.Description("ID of the peer (without '@')."), mcp_lib.Required()),
+			mcp_lib.WithString("alias", mcp_lib.Description("Single-word name for the peer; an empty string removes the alias. Omit to keep it.")),
+			mcp_lib.WithString("notes", mcp_lib.Description("Free-form notes about the peer. Omit to keep them.")),
+		),
+		HandleUpdateContactTool,
+	)
+
+	// Tool: Tag Peer
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqTagPeer",
+			mcp_lib.WithDescription("Tag a peer with the datasets it holds, e.g. 'genomics', so questions can be sent to every peer with a tag."),
+			mcp_lib.WithString("peer", mcp_lib.Description("ID or alias of the peer."), mcp_lib.Required()),
+			mcp_lib.WithArray("add", mcp_lib.Description("Tags to add."), mcp_lib.Items(map[string]any{"type": "string"})),
+			mcp_lib.WithArray("remove", mcp_lib.Description("Tags to remove."), mcp_lib.Items(map[string]any{"type": "string"})),
+		),
+		HandleTagPeerTool,
+	)
+
 	// Tool: Get User Descriptions
 	mcpServer.AddTool(
 		mcp_lib.NewTool("cqGetUserDatasets",
@@ -213,7 +863,7 @@ func NewMCPServer() *server.MCPServer {
 	// Tool: Submit App Folder
 	mcpServer.AddTool(
 		mcp_lib.NewTool("cqSubmitAppFolder",
-			mcp_lib.WithDescription("Submit an application folder to specified peers or broadcast to the entire network."),
+			mcp_lib.WithDescription("Submit an application folder to specified peers or to every online peer. The folder is sent as a file transfer in encrypted chunks, which the peers resume if the connection drops."),
 			mcp_lib.WithString(
 				"app_path",
 				mcp_lib.Description("The full path to the application folder to submit."),
@@ -226,14 +876,97 @@ func NewMCPServer() *server.MCPServer {
 			),
 			mcp_lib.WithArray(
 				"peers",
-				mcp_lib.Description("List of peer identifiers (without '@') to receive the app folder. Leave empty to broadcast to all peers."),
+				mcp_lib.Description("List of peer identifiers or aliases (without '@') to receive the app folder. Leave empty, without tags, to send it to every online peer."),
 				mcp_lib.Items(map[string]any{"type": "string"}),
 				mcp_lib.Required(),
 			),
+			mcp_lib.WithArray(
+				"tags",
+				mcp_lib.Description("Also send the app folder to every peer tagged with any of these datasets (see cqTagPeer)."),
+				mcp_lib.Items(map[string]any{"type": "string"}),
+			),
 		),
 		HandleSubmitAppFolderTool,
 	)
 
+	// Tool: Send Attachment
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqSendAttachment",
+			mcp_lib.WithDescription("Send a local file to peers as an attachment. The file is split into encrypted chunks and checked by the recipients once it is complete."),
+			mcp_lib.WithString(
+				"path",
+				mcp_lib.Description("Path of the file to send, at most 25 MiB."),
+				mcp_lib.Required(),
+			),
+			mcp_lib.WithArray(
+				"peers",
+				mcp_lib.Description("List of peer identifiers (without '@') to send the file to."),
+				mcp_lib.Items(map[string]any{"type": "string"}),
+				mcp_lib.Required(),
+			),
+			mcp_lib.WithString(
+				"note",
+				mcp_lib.Description("Optional message sent with the file."),
+			),
+		),
+		HandleSendAttachmentTool,
+	)
+
+	// Tool: Schedule Query
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqScheduleQuery",
+			mcp_lib.WithDescription("Schedule a question to be sent to peers later, once at a given time or repeatedly on a cron schedule. Answers to each sending are recorded with it."),
+			mcp_lib.WithString(
+				"question",
+				mcp_lib.Description("The question to send."),
+				mcp_lib.Required(),
+			),
+			mcp_lib.WithArray(
+				"peers",
+				mcp_lib.Description("List of peer identifiers (without '@') to send the question to. Leave empty to broadcast it."),
+				mcp_lib.Items(map[string]any{"type": "string"}),
+			),
+			mcp_lib.WithString(
+				"schedule",
+				mcp_lib.Description("Cron expression for a recurring query, in the node's local time, e.g. '0 9 * * mon' for Mondays at 9:00 or '@daily'."),
+			),
+			mcp_lib.WithString(
+				"at",
+				mcp_lib.Description("Time to send a one-time query, as RFC 3339 (e.g. '2025-07-01T09:00:00+02:00') or YYYY-MM-DD. Use instead of schedule."),
+			),
+		),
+		HandleScheduleQueryTool,
+	)
+
+	// Tool: List Scheduled Queries
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqListScheduledQueries",
+			mcp_lib.WithDescription("List the scheduled queries with their next run, or, given an ID, the recent runs of one scheduled query with the answers peers gave to each."),
+			mcp_lib.WithString(
+				"id",
+				mcp_lib.Description("ID of a scheduled query to show the runs and answers of."),
+			),
+			mcp_lib.WithBoolean(
+				"include_inactive",
+				mcp_lib.Description("Also list cancelled and finished scheduled queries."),
+			),
+		),
+		HandleListScheduledQueriesTool,
+	)
+
+	// Tool: Cancel Scheduled Query
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqCancelScheduledQuery",
+			mcp_lib.WithDescription("Stop a scheduled query from being sent again. Its past runs and answers are kept."),
+			mcp_lib.WithString(
+				"id",
+				mcp_lib.Description("ID of the scheduled query."),
+				mcp_lib.Required(),
+			),
+		),
+		HandleCancelScheduledQueryTool,
+	)
+
 	// Tool: Get Client Token
 	mcpServer.AddTool(
 		mcp_lib.NewTool("cqGetToken",
@@ -247,5 +980,52 @@ func NewMCPServer() *server.MCPServer {
 		HandleGetTokenTool,
 	)
 
-	return mcpServer
+	// Tool: Connection Status
+	mcpServer.AddTool(
+		mcp_lib.NewTool("connection_status",
+			mcp_lib.WithDescription("Show the state of the connection to the server (connected, reconnecting or disconnected), the server URL, when the token expires and the last connection error. Use action 'reconnect' to force a new connection."),
+			mcp_lib.WithString("action",
+				mcp_lib.Description("'status' to only report the connection (default) or 'reconnect' to drop it and reconnect in the background."),
+				mcp_lib.Enum("status", "reconnect"),
+			),
+		),
+		HandleConnectionStatusTool,
+	)
+
+	// Tool: Health
+	mcpServer.AddTool(
+		mcp_lib.NewTool("health",
+			mcp_lib.WithDescription("Check the services dk relies on: the database, the vector database, the LLM provider and the websocket connection. Each is reported as ok, unconfigured, degraded or down, with the overall status being the worst of them."),
+			mcp_lib.WithBoolean("probe_llm", mcp_lib.Description("Send a short prompt to the LLM provider to check that it answers. Off by default, since calls may be billed.")),
+		),
+		HandleHealthTool,
+	)
+
+	// Tool: List Tool Calls
+	mcpServer.AddTool(
+		mcp_lib.NewTool("cqListToolCalls",
+			mcp_lib.WithDescription("List the recorded MCP tool calls, newest first, with the caller, a hash of the arguments, the duration and the outcome. With summary, count the calls per tool and caller instead, to spot an agent calling a tool in a loop."),
+			mcp_lib.WithString("tool", mcp_lib.Description("Only include calls of this tool.")),
+			mcp_lib.WithString("caller", mcp_lib.Description("Only include calls of this caller, as role:user ID.")),
+			mcp_lib.WithString("since", mcp_lib.Description("Only include calls from this date, as YYYY-MM-DD or RFC 3339. Defaults to the last hour.")),
+			mcp_lib.WithNumber("limit", mcp_lib.Description("Maximum number of calls to list (50 by default).")),
+			mcp_lib.WithBoolean("summary", mcp_lib.Description("Count the calls, failures and rate limited calls per tool and caller instead of listing them.")),
+		),
+		HandleListToolCallsTool,
+	)
+
+	// Tools of plugins
+	for _, plugin := range plugins {
+		for _, tool := range plugin.Tools {
+			if mcpServer.registered[tool.Name] {
+				return nil, fmt.Errorf("plugin %s provides tool %s, which already exists", plugin.Name, tool.Name)
+			}
+			mcpServer.AddTool(mcp_lib.Tool{Name: tool.Name, Description: tool.Description, InputSchema: tool.InputSchema}, plugin.handler(tool.Name))
+		}
+	}
+
+	if unknown := policy.unknownTools(mcpServer.registered); len(unknown) > 0 {
+		return nil, fmt.Errorf("tool policy lists unknown tools: %s", strings.Join(unknown, ", "))
+	}
+	return mcpServer.MCPServer, nil
 }
@@ -0,0 +1,71 @@
+package mcp
+
+import (
+	"context"
+	"database/sql"
+	"dk/core"
+	"dk/mcp/i18n"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ServeSSE serves the MCP tools over HTTP so that remote clients and web UIs can use them.
+// Clients open an event stream with GET /sse and post their messages to /message. Every
+// request is authenticated like the HTTP API: a role access token sent as "Authorization:
+// Bearer" restricts the tools to that role, and when hostToken is set requests without a
+// token are refused. Requests without a token act as the host otherwise, so without hostToken
+// the tools are only served on the loopback interface. contextFunc prepares the context of tool calls, as for stdio. Clients
+// that send an Accept-Language header get descriptions and messages in that language.
+func ServeSSE(port string, mcpServer *server.MCPServer, database *sql.DB, hostToken string, contextFunc func(context.Context) context.Context) error {
+	sseServer := server.NewSSEServer(mcpServer,
+		// Clients resolve the message endpoint against the URL they reached the stream at
+		server.WithUseFullURLForMessageEndpoint(false),
+		server.WithSSEContextFunc(func(ctx context.Context, r *http.Request) context.Context {
+			ctx = core.WithPrincipal(contextFunc(ctx), core.PrincipalFromContext(r.Context()))
+			if lang := i18n.Negotiate(r.Header.Get("Accept-Language")); lang != "" {
+				ctx = i18n.WithLanguage(ctx, lang)
+			}
+			return ctx
+		}),
+	)
+
+	address := sseAddress(port, hostToken)
+	log.Printf("Serving MCP over SSE on %s", address)
+	return http.ListenAndServe(address, authenticateSSE(sseServer, database, hostToken))
+}
+
+// sseAddress returns the address to serve SSE on: all interfaces only when requests must
+// authenticate
+func sseAddress(port, hostToken string) string {
+	if hostToken == "" {
+		return "127.0.0.1:" + port
+	}
+	return ":" + port
+}
+
+// authenticateSSE rejects unauthenticated requests and records the principal of the others
+func authenticateSSE(next http.Handler, database *sql.DB, hostToken string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := core.AuthenticateBearer(r.Context(), database, r.Header.Get("Authorization"), hostToken)
+		if errors.Is(err, core.ErrInvalidRoleToken) || errors.Is(err, core.ErrAuthorizationRequired) {
+			writeSSEError(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("[MCP] Failed to authenticate role token: %v", err)
+			writeSSEError(w, "Failed to authenticate", http.StatusInternalServerError)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(core.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+func writeSSEError(w http.ResponseWriter, message string, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
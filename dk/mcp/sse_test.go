@@ -0,0 +1,74 @@
+package mcp
+
+import (
+	"context"
+	"dk/core"
+	"dk/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthenticateSSE(t *testing.T) {
+	testDB, err := db.OpenTestDB()
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	defer testDB.Close()
+	if err := db.RunMigrations(testDB.DB); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	curatorToken, err := core.AssignRole(context.Background(), testDB.DB, "carol", core.RoleCurator)
+	if err != nil {
+		t.Fatalf("AssignRole failed: %v", err)
+	}
+
+	var principal core.Principal
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal = core.PrincipalFromContext(r.Context())
+	})
+	request := func(hostToken, authorization string) int {
+		t.Helper()
+		principal = core.Principal{}
+		r := httptest.NewRequest(http.MethodGet, "/sse", nil)
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		authenticateSSE(next, testDB.DB, hostToken).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	cases := []struct {
+		hostToken     string
+		authorization string
+		status        int
+		principal     core.Principal
+	}{
+		// Without a host token, requests without a token act as the host
+		{"", "", http.StatusOK, core.Principal{Role: core.RoleHost}},
+		{"", "Bearer " + curatorToken, http.StatusOK, core.Principal{UserID: "carol", Role: core.RoleCurator}},
+		{"", "Bearer unknown", http.StatusUnauthorized, core.Principal{}},
+		// With one, they must send it or a role token
+		{"secret", "", http.StatusUnauthorized, core.Principal{}},
+		{"secret", "Bearer secret", http.StatusOK, core.Principal{Role: core.RoleHost}},
+		{"secret", "Bearer other", http.StatusUnauthorized, core.Principal{}},
+		{"secret", "Bearer " + curatorToken, http.StatusOK, core.Principal{UserID: "carol", Role: core.RoleCurator}},
+	}
+	for _, tc := range cases {
+		if status := request(tc.hostToken, tc.authorization); status != tc.status || principal != tc.principal {
+			t.Errorf("host token %q, %q: expected %d as %+v, got %d as %+v",
+				tc.hostToken, tc.authorization, tc.status, tc.principal, status, principal)
+		}
+	}
+}
+
+func TestSSEAddress(t *testing.T) {
+	// Unauthenticated requests act as the host, so they are only accepted from this machine
+	if address := sseAddress("8082", ""); address != "127.0.0.1:8082" {
+		t.Errorf("Expected the loopback interface without a host token, got %s", address)
+	}
+	if address := sseAddress("8082", "secret"); address != ":8082" {
+		t.Errorf("Expected all interfaces with a host token, got %s", address)
+	}
+}
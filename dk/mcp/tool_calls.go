@@ -0,0 +1,98 @@
+package mcp
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// defaultToolCallsWindow is how far back cqListToolCalls looks when no "since" is given
+const defaultToolCallsWindow = time.Hour
+
+// recordToolCall stores the audit record of a tool call. Nothing is recorded without a
+// database.
+func recordToolCall(ctx context.Context, tool string, args map[string]any, result *mcp_lib.CallToolResult, duration time.Duration) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return
+	}
+	digest, _ := argsDigest(withoutToken(args))
+	call := db.ToolCall{
+		Tool:       tool,
+		Caller:     callerOf(ctx),
+		ArgsHash:   digest,
+		DurationMS: duration.Milliseconds(),
+		Outcome:    outcomeOf(result),
+	}
+	if err := db.InsertToolCall(context.WithoutCancel(ctx), database, call); err != nil {
+		log.Printf("[MCP] Failed to record a call of %s: %v", tool, err)
+	}
+}
+
+// outcomeOf returns db.ToolCallOK for a successful result and the error code of a failed one
+func outcomeOf(result *mcp_lib.CallToolResult) string {
+	if result == nil || !result.IsError {
+		return db.ToolCallOK
+	}
+	for _, content := range result.Content {
+		if text, ok := content.(mcp_lib.TextContent); ok {
+			var envelope toolError
+			if json.Unmarshal([]byte(text.Text), &envelope) == nil && envelope.Code != "" {
+				return envelope.Code
+			}
+		}
+	}
+	return ErrorInternal
+}
+
+// Tool: List Tool Calls
+//
+// This tool lists the recorded MCP tool calls, or with "summary" counts them per tool and
+// caller, so that an agent calling a tool in a loop stands out.
+// Input parameters: optionally "tool", "caller", "since", "limit" and "summary".
+func HandleListToolCallsTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	args := request.Params.Arguments
+	tool, _ := args["tool"].(string)
+	caller, _ := args["caller"].(string)
+	summary, _ := args["summary"].(bool)
+	since := time.Now().Add(-defaultToolCallsWindow)
+	if value, _ := args["since"].(string); strings.TrimSpace(value) != "" {
+		parsed, err := parseToolDate(strings.TrimSpace(value))
+		if err != nil {
+			return errorResult(ErrorInvalidArgument, err.Error()), nil
+		}
+		since = parsed
+	}
+	limit, _, _ := pageArguments(args)
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Couldn't access the database: %v", err)), nil
+	}
+	var result any
+	if summary {
+		result, err = db.SummarizeToolCalls(ctx, database, strings.TrimSpace(tool), strings.TrimSpace(caller), since)
+	} else {
+		result, err = db.ListToolCalls(ctx, database, db.ToolCallFilter{
+			Tool:   strings.TrimSpace(tool),
+			Caller: strings.TrimSpace(caller),
+			Since:  since,
+			Limit:  limit,
+		})
+	}
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't list the tool calls: %v", err)), nil
+	}
+	blob, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode the tool calls: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
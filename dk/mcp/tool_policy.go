@@ -0,0 +1,142 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ToolAccess is how a tool may be called, whatever the role of the caller
+type ToolAccess string
+
+const (
+	ToolEnabled  ToolAccess = "enabled"  // Called freely
+	ToolDisabled ToolAccess = "disabled" // Not registered, so clients do not see it
+	ToolConfirm  ToolAccess = "confirm"  // Only runs when called again with the confirm token it returns
+)
+
+// ToolPolicy lets operators lock down MCP tools, such as those processing applications or
+// rejecting queries, and limit how often each session may call them. It is read from a JSON
+// file:
+//
+//	{"default": "enabled", "tools": {"cqProcessApplicationRequest": "disabled", "cqProcessQuery": "confirm"},
+//	 "rate_limits": {"*": {"calls": 120, "per": "1m"}, "cqAskQuestion": {"calls": 10, "per": "1m"}}}
+type ToolPolicy struct {
+	Default ToolAccess            `json:"default,omitempty"` // For tools not listed; enabled when empty
+	Tools   map[string]ToolAccess `json:"tools,omitempty"`
+	// RateLimits bound the calls of each tool by each session; "*" applies to tools not listed
+	RateLimits map[string]RateLimit `json:"rate_limits,omitempty"`
+}
+
+// RateLimit allows a number of calls in any window of a duration
+type RateLimit struct {
+	Calls int    `json:"calls"`
+	Per   string `json:"per"` // Go duration, such as "30s" or "1h"
+}
+
+// window returns the duration of the limit, or 0 if it is invalid
+func (r RateLimit) window() time.Duration {
+	d, err := time.ParseDuration(r.Per)
+	if err != nil || d <= 0 {
+		return 0
+	}
+	return d
+}
+
+// LoadToolPolicy reads a tool policy file. An empty path enables every tool.
+func LoadToolPolicy(path string) (ToolPolicy, error) {
+	var policy ToolPolicy
+	if path == "" {
+		return policy, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return policy, err
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return policy, fmt.Errorf("invalid tool policy %s: %w", path, err)
+	}
+	return policy, policy.Validate()
+}
+
+// Validate checks that every access is one of enabled, disabled or confirm
+func (p ToolPolicy) Validate() error {
+	valid := []ToolAccess{"", ToolEnabled, ToolDisabled, ToolConfirm}
+	if !slices.Contains(valid, p.Default) {
+		return fmt.Errorf("invalid default tool access %q: expected enabled, disabled or confirm", p.Default)
+	}
+	for tool, access := range p.Tools {
+		if access == "" || !slices.Contains(valid, access) {
+			return fmt.Errorf("invalid access %q for tool %s: expected enabled, disabled or confirm", access, tool)
+		}
+	}
+	for tool, limit := range p.RateLimits {
+		if limit.Calls <= 0 || limit.window() == 0 {
+			return fmt.Errorf("invalid rate limit for tool %s: expected a positive number of calls per duration, such as {\"calls\": 10, \"per\": \"1m\"}", tool)
+		}
+	}
+	return nil
+}
+
+// RateLimit returns the rate limit of a tool, if it has one
+func (p ToolPolicy) RateLimit(tool string) (RateLimit, bool) {
+	if limit, ok := p.RateLimits[tool]; ok {
+		return limit, true
+	}
+	limit, ok := p.RateLimits["*"]
+	return limit, ok
+}
+
+// confirmedTools change who can read the host's data, delete it or act on requests and keys
+// irreversibly, so they require confirmation unless the policy lists them or disables every
+// tool by default
+var confirmedTools = map[string]bool{
+	"cqGrantAPIAccess":              true,
+	"cqRevokeAPIAccess":             true,
+	"cqRotateAPIKey":                true,
+	"cqDeprecateAPI":                true,
+	"cqProcessQuery":                true,
+	"cqProcessQueries":              true,
+	"cqProcessApplicationRequest":   true,
+	"cqDecideKeyRecovery":           true,
+	"cqDistributeKeyShares":         true,
+	"cqDeleteDocument":              true,
+	"cqDeletePolicy":                true,
+	"cqRemoveRagSource":             true,
+	"cqRemoveAutoApprovalCondition": true,
+}
+
+// Access returns how a tool may be called
+func (p ToolPolicy) Access(tool string) ToolAccess {
+	if access, ok := p.Tools[tool]; ok {
+		return access
+	}
+	if confirmedTools[tool] && p.Default != ToolDisabled {
+		return ToolConfirm
+	}
+	if p.Default != "" {
+		return p.Default
+	}
+	return ToolEnabled
+}
+
+// unknownTools returns the tools the policy lists that are not among the registered ones, so
+// that a misspelled name does not leave a tool unlocked
+func (p ToolPolicy) unknownTools(registered map[string]bool) []string {
+	var unknown []string
+	for tool := range p.Tools {
+		if !registered[tool] {
+			unknown = append(unknown, tool)
+		}
+	}
+	for tool := range p.RateLimits {
+		if tool != "*" && !registered[tool] && !slices.Contains(unknown, tool) {
+			unknown = append(unknown, tool)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,177 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// destructivePrefixes name the tools that delete data, hand out keys or access, or answer
+// peers. A new tool named this way has to be added to confirmedTools.
+var destructivePrefixes = []string{"cqDelete", "cqRemove", "cqRevoke", "cqRotate", "cqDeprecate", "cqDecide", "cqDistribute", "cqGrant", "cqProcess"}
+
+func TestDestructiveToolsRequireConfirmation(t *testing.T) {
+	mcpServer, err := NewMCPServer(ToolPolicy{}, nil)
+	if err != nil {
+		t.Fatalf("NewMCPServer failed: %v", err)
+	}
+	registered := make(map[string]bool)
+	for _, tool := range serverTools(t, mcpServer) {
+		registered[tool.Name] = true
+		destructive := false
+		for _, prefix := range destructivePrefixes {
+			destructive = destructive || strings.HasPrefix(tool.Name, prefix)
+		}
+		if destructive && !confirmedTools[tool.Name] {
+			t.Errorf("%s: expected the tool to require confirmation", tool.Name)
+		}
+	}
+	for tool := range confirmedTools {
+		if !registered[tool] {
+			t.Errorf("%s: expected a registered tool", tool)
+		}
+	}
+
+	// Calls without a confirm token are described rather than run. Tools with a describer check
+	// their arguments to describe the call, which are missing here.
+	for tool := range confirmedTools {
+		if describers[tool] != nil {
+			continue
+		}
+		message := fmt.Sprintf(`{"jsonrpc": "2.0", "id": 2, "method": "tools/call", "params": {"name": %q, "arguments": {}}}`, tool)
+		blob, err := json.Marshal(mcpServer.HandleMessage(context.Background(), json.RawMessage(message)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var response struct {
+			Result struct {
+				Content []mcp_lib.TextContent `json:"content"`
+				IsError bool                  `json:"isError"`
+			} `json:"result"`
+		}
+		if err := json.Unmarshal(blob, &response); err != nil || response.Result.IsError || len(response.Result.Content) == 0 {
+			t.Errorf("%s: expected a confirmation, got %s", tool, blob)
+			continue
+		}
+		var answer confirmation
+		if err := json.Unmarshal([]byte(response.Result.Content[0].Text), &answer); err != nil || answer.ConfirmToken == "" {
+			t.Errorf("%s: expected a confirm token, got %s", tool, blob)
+		}
+	}
+
+	// Listing a tool in the policy or disabling every tool by default overrides the confirmation
+	for tool := range confirmedTools {
+		if access := (ToolPolicy{Tools: map[string]ToolAccess{tool: ToolEnabled}}).Access(tool); access != ToolEnabled {
+			t.Errorf("%s: expected the policy to enable the tool, got %s", tool, access)
+		}
+		if access := (ToolPolicy{Default: ToolDisabled}).Access(tool); access != ToolDisabled {
+			t.Errorf("%s: expected the tool to be disabled by default, got %s", tool, access)
+		}
+	}
+}
+
+func TestLoadToolPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tool_policy.json")
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without a file every tool is enabled, and destructive ones ask for confirmation
+	policy, err := LoadToolPolicy("")
+	if err != nil {
+		t.Fatalf("LoadToolPolicy failed: %v", err)
+	}
+	if policy.Access("cqListDocuments") != ToolEnabled || policy.Access("cqDeleteDocument") != ToolConfirm {
+		t.Errorf("Expected the default policy, got %+v", policy)
+	}
+	if _, ok := policy.RateLimit("cqAskQuestion"); ok {
+		t.Error("Expected no rate limit by default")
+	}
+	if _, err := LoadToolPolicy(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected a missing file to fail, got %v", err)
+	}
+
+	// Listed tools take their access and rate limit; the others the defaults of the file
+	write(`{"default": "confirm", "tools": {"cqProcessQuery": "enabled", "cqAskQuestion": "disabled"},
+		"rate_limits": {"*": {"calls": 120, "per": "1m"}, "cqGetDocumentChunks": {"calls": 10, "per": "30s"}}}`)
+	policy, err = LoadToolPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadToolPolicy failed: %v", err)
+	}
+	for tool, want := range map[string]ToolAccess{"cqProcessQuery": ToolEnabled, "cqAskQuestion": ToolDisabled, "cqListDocuments": ToolConfirm, "cqDeleteDocument": ToolConfirm} {
+		if access := policy.Access(tool); access != want {
+			t.Errorf("%s: expected %s, got %s", tool, want, access)
+		}
+	}
+	if limit, ok := policy.RateLimit("cqGetDocumentChunks"); !ok || limit.Calls != 10 || limit.window() != 30*time.Second {
+		t.Errorf("Expected the limit of the tool, got %+v", limit)
+	}
+	if limit, ok := policy.RateLimit("cqListDocuments"); !ok || limit.Calls != 120 || limit.window() != time.Minute {
+		t.Errorf("Expected the default limit, got %+v", limit)
+	}
+	mcpServer, err := NewMCPServer(policy, nil)
+	if err != nil {
+		t.Fatalf("NewMCPServer failed: %v", err)
+	}
+	for _, tool := range serverTools(t, mcpServer) {
+		if tool.Name == "cqAskQuestion" {
+			t.Error("Expected the disabled tool not to be registered")
+		}
+	}
+
+	// Loading the file again picks up its changes
+	write(`{"default": "disabled", "tools": {"cqListDocuments": "enabled"}}`)
+	policy, err = LoadToolPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadToolPolicy failed: %v", err)
+	}
+	if policy.Access("cqListDocuments") != ToolEnabled || policy.Access("cqProcessQuery") != ToolDisabled || policy.Access("cqDeleteDocument") != ToolDisabled {
+		t.Errorf("Expected the changed policy, got %+v", policy)
+	}
+	if _, ok := policy.RateLimit("cqGetDocumentChunks"); ok {
+		t.Error("Expected the removed rate limits to be gone")
+	}
+	mcpServer, err = NewMCPServer(policy, nil)
+	if err != nil {
+		t.Fatalf("NewMCPServer failed: %v", err)
+	}
+	if tools := serverTools(t, mcpServer); len(tools) != 1 || tools[0].Name != "cqListDocuments" {
+		t.Errorf("Expected only cqListDocuments, got %+v", tools)
+	}
+
+	// Malformed files and invalid entries are refused
+	for name, content := range map[string]string{
+		"syntax":         `{"tools": `,
+		"default access": `{"default": "maybe"}`,
+		"tool access":    `{"tools": {"cqProcessQuery": "sometimes"}}`,
+		"empty access":   `{"tools": {"cqProcessQuery": ""}}`,
+		"calls":          `{"rate_limits": {"cqAskQuestion": {"calls": 0, "per": "1m"}}}`,
+		"duration":       `{"rate_limits": {"*": {"calls": 5, "per": "soon"}}}`,
+	} {
+		write(content)
+		if _, err := LoadToolPolicy(path); err == nil {
+			t.Errorf("%s: expected the policy to be refused", name)
+		}
+	}
+
+	// Tools the server does not have are reported, so that a misspelled name is noticed
+	write(`{"tools": {"cqProcesQuery": "disabled", "cqListDocuments": "enabled"}, "rate_limits": {"*": {"calls": 5, "per": "1m"}, "cqAskQuestoin": {"calls": 1, "per": "1m"}}}`)
+	policy, err = LoadToolPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadToolPolicy failed: %v", err)
+	}
+	if _, err := NewMCPServer(policy, nil); err == nil || !strings.Contains(err.Error(), "cqAskQuestoin, cqProcesQuery") {
+		t.Errorf("Expected the unknown tools to be reported, got %v", err)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	dk_client "dk/client"
 	"dk/core"
 	"dk/db"
+	"dk/mcp/i18n"
 	"dk/utils"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +16,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,37 +31,54 @@ import (
 func HandleAnswerListTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
 	dbHandler, err := utils.DatabaseFromContext(ctx)
 	if err != nil {
-		return &mcp_lib.CallToolResult{Content: []mcp_lib.Content{
-			mcp_lib.TextContent{
-				Type: "text",
-				Text: fmt.Sprintf("Couldn't retrieve database instace. %v", err.Error()),
-			},
-		}}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Couldn't retrieve database instace. %v", err.Error())), nil
 	}
 
-	all, err := db.AllAnswers(ctx, dbHandler)
+	args := req.Params.Arguments
+	related, _ := args["related_question"].(string)
+	if related == "" {
+		related, _ = args["related_topic"].(string)
+	}
+	limit, offset, cursor := pageArguments(args)
+	question, explicit := args["question"].(string)
+	if !explicit {
+		question = related
+	}
+	user, _ := args["user"].(string)
+	search, _ := args["search"].(string)
+	filter := db.AnswerFilter{
+		Question: strings.TrimSpace(question),
+		User:     strings.TrimPrefix(strings.TrimSpace(user), "@"),
+		Search:   strings.TrimSpace(search),
+	}
+	page, err := db.ListAnswersPage(ctx, dbHandler, filter, limit, offset, cursor)
+	if err == nil && page.Total == 0 && !explicit && filter.Question != "" {
+		// The related question may be a topic rather than the text of a question
+		filter.Question = ""
+		page, err = db.ListAnswersPage(ctx, dbHandler, filter, limit, offset, cursor)
+	}
+	if errors.Is(err, db.ErrInvalidCursor) {
+		return errorResult(ErrorInvalidArgument, "Invalid 'cursor': pass the next_cursor of the previous page"), nil
+	}
 	if err != nil {
-		return &mcp_lib.CallToolResult{Content: []mcp_lib.Content{
-			mcp_lib.TextContent{
-				Type: "text",
-				Text: fmt.Sprintf("Couldn't retrieve all answers: %v", err.Error()),
-			},
-		}}, nil
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't retrieve all answers: %v", err.Error())), nil
 	}
-	raw, _ := json.MarshalIndent(all, "", "  ")
+	raw, _ := json.MarshalIndent(page, "", "  ")
 
-	args := req.Params.Arguments
 	detail := "general"
 	if d, ok := args["detailed_answer"].(bool); ok && d {
 		detail = "detailed"
+	} else if d, ok := args["detailed_answer"].(float64); ok && d != 0 {
+		detail = "detailed"
+	}
+	text := fmt.Sprintf("Given the Answers: %s, and related topic: %s, provide a %s answer.", string(raw), related, detail)
+	if page.NextCursor != "" {
+		text += fmt.Sprintf(" Only %d of the %d matching answers are shown; call the tool again with cursor %q for more.", len(page.Answers), page.Total, page.NextCursor)
 	}
-	related, _ := args["related_topic"].(string)
-
 	return &mcp_lib.CallToolResult{Content: []mcp_lib.Content{
 		mcp_lib.TextContent{
 			Type: "text",
-			Text: fmt.Sprintf("Given the Answers: %s, and related topic: %s, provide a %s answer.",
-				string(raw), related, detail),
+			Text: text,
 		},
 	}}, nil
 }
@@ -74,27 +94,13 @@ func HandleAnswerListTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mc
 func HandleGetAnswerTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
 	dbInstance, err := utils.DatabaseFromContext(ctx)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Couldn't retrieve database instance %v", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Couldn't retrieve database instance %v", err.Error())), nil
 	}
 
 	args := req.Params.Arguments
 	qID, _ := args["query"].(string)
 	if strings.TrimSpace(qID) == "" {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("'query' parameter is required"),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInvalidArgument, "'query' parameter is required"), nil
 	}
 
 	// optional delay
@@ -104,14 +110,7 @@ func HandleGetAnswerTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp
 
 	ans, err := db.AnswersForQuestion(ctx, dbInstance, qID)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Error while trying to get the answers for question %s : %v", qID, err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(errorCode(err), fmt.Sprintf("Error while trying to get the answers for question %s : %v", qID, err.Error())), nil
 	}
 
 	if len(ans) == 0 {
@@ -142,130 +141,240 @@ func HandleAskTool(
 	arguments := request.Params.Arguments
 	message, ok := arguments["question"].(string)
 	if !ok {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Some error happened at question casting \n\n arguments: %s\n\n, ok: %t", arguments, ok),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInvalidArgument, "'question' parameter is required"), nil
 	}
 
-	var peers []string
-	if r, exists := arguments["peers"]; exists {
-		for _, item := range r.([]any) {
-			if str, ok := item.(string); ok {
-				peers = append(peers, str)
-			}
-		}
+	// Aliases name peers and tags add every peer tagged with them
+	peers, err := core.ResolvePeers(ctx, stringList(arguments, "peers"), stringList(arguments, "tags"))
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't resolve peers: %v", err)), nil
 	}
 	dkClient, err := utils.DkFromContext(ctx)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Couldn't retrieve DK from context: %s", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Couldn't retrieve DK from context: %s", err.Error())), nil
 	}
-	query := utils.RemoteMessage{
-		Type:    "query",
-		Message: message,
+
+	// Peers whose handshake declines the topic of the question are skipped unless forced
+	var warnings []string
+	if handshakes := core.HandshakesFromContext(ctx); handshakes != nil && len(peers) > 0 {
+		force, _ := arguments["force"].(bool)
+		refusals := handshakes.Refusals(ctx, peers, message, core.HandshakeWait)
+		accepting := make([]string, 0, len(peers))
+		for _, peer := range peers {
+			refusal, refused := refusals[peer]
+			switch {
+			case !refused:
+				accepting = append(accepting, peer)
+			case force:
+				accepting = append(accepting, peer)
+				warnings = append(warnings, i18n.Message(language(ctx), "ask.refused_sent", refusal))
+			default:
+				warnings = append(warnings, i18n.Message(language(ctx), "ask.refused_skipped", refusal))
+			}
+		}
+		if len(accepting) == 0 {
+			return errorResult(ErrorForbidden, strings.Join(warnings, "\n")), nil
+		}
+		peers = accepting
 	}
-	jsonData, err := json.Marshal(query)
+
+	query := &utils.QueryPayload{Question: message}
+	content, err := utils.EncodePayload(query)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Couldn't marshal query: %s", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Couldn't marshal query: %s", err.Error())), nil
+	}
+
+	// Answers are reported as they arrive when the caller asked for progress notifications
+	progress := newAnswerProgress(ctx, request, query.Question, len(peers))
+	accepted, notices, err := sendQuestion(ctx, dkClient, peers, content)
+	warnings = append(warnings, notices...)
+	if accepted == 0 && err != nil {
+		progress.cancel()
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't send message: %s", err.Error())), nil
 	}
 
+	sent := i18n.Message(language(ctx), "ask.sent", query.Question)
+	if progress != nil {
+		progress.report()
+		sent = i18n.Message(language(ctx), "ask.sent_progress", query.Question)
+	}
+	return &mcp_lib.CallToolResult{
+		Content: []mcp_lib.Content{
+			mcp_lib.TextContent{
+				Type: "text",
+				Text: strings.Join(append(warnings, sent), "\n"),
+			},
+		},
+	}, nil
+}
+
+// askAcceptTimeout is how long the ask tool waits for the server to accept a question before
+// reporting it as still queued
+const askAcceptTimeout = 10 * time.Second
+
+// sendQuestion sends a question to peers, or broadcasts it without peers, and waits until the
+// server accepted it. It returns how many messages were accepted or stay queued to be sent once
+// the connection is back, warnings about the peers the question did not reach yet, and the
+// error of the last message that could not be sent.
+func sendQuestion(ctx context.Context, dkClient *dk_client.Client, peers []string, content string) (int, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, askAcceptTimeout)
+	defer cancel()
 	if len(peers) == 0 {
-		err = dkClient.BroadcastMessage(string(jsonData))
+		err := dkClient.BroadcastMessageSync(ctx, content)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return 1, []string{i18n.Message(language(ctx), "ask.queued", "broadcast")}, nil
+		}
 		if err != nil {
-			return &mcp_lib.CallToolResult{
-				Content: []mcp_lib.Content{
-					mcp_lib.TextContent{
-						Type: "text",
-						Text: fmt.Sprintf("Couldn't send message: %s", err.Error()),
-					},
-				},
-			}, nil
+			return 0, nil, err
 		}
-	} else {
-		for _, peer := range peers {
-			err = dkClient.SendMessage(dk_client.Message{
+		return 1, nil, nil
+	}
+
+	errs := make([]error, len(peers))
+	var wg sync.WaitGroup
+	for i, peer := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = dkClient.SendMessageSync(ctx, dk_client.Message{
 				From:      dkClient.UserID,
 				To:        peer,
-				Content:   string(jsonData),
+				Content:   content,
 				Timestamp: time.Now(),
 			})
-			if err != nil {
-				return &mcp_lib.CallToolResult{
-					Content: []mcp_lib.Content{
-						mcp_lib.TextContent{
-							Type: "text",
-							Text: fmt.Sprintf("Couldn't send message: %s", err.Error()),
-						},
-					},
-				}, nil
-			}
+		}()
+	}
+	wg.Wait()
+
+	var accepted int
+	var warnings []string
+	var lastErr error
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			accepted++
+		case errors.Is(err, context.DeadlineExceeded):
+			accepted++
+			warnings = append(warnings, i18n.Message(language(ctx), "ask.queued", peers[i]))
+		case errors.Is(err, dk_client.ErrRecipientUnknown):
+			lastErr = err
+			warnings = append(warnings, i18n.Message(language(ctx), "ask.unknown_peer", peers[i]))
+		default:
+			lastErr = err
+			warnings = append(warnings, i18n.Message(language(ctx), "ask.failed", peers[i], err))
 		}
 	}
+	return accepted, warnings, lastErr
+}
 
-	return &mcp_lib.CallToolResult{
-		Content: []mcp_lib.Content{
-			mcp_lib.TextContent{
-				Type: "text",
-				Text: fmt.Sprintf("Query request sent ... Instruct the user to ask the model for summarize on the query %s", query.Message),
-			},
-		},
-	}, nil
+// defaultPageSize and maxPageSize bound the items list tools return at once, so that long
+// histories do not fill the context of the model
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// pageArguments returns the "limit", "offset" and "cursor" arguments of a list tool
+func pageArguments(args map[string]any) (limit, offset int, cursor string) {
+	limit = defaultPageSize
+	if value, ok := args["limit"].(float64); ok && value > 0 {
+		limit = min(int(value), maxPageSize)
+	}
+	if value, ok := args["offset"].(float64); ok && value > 0 {
+		offset = int(value)
+	}
+	cursor, _ = args["cursor"].(string)
+	return limit, offset, strings.TrimSpace(cursor)
 }
 
 // Tool: List Queries
+//
+// This tool lists the incoming queries, newest first, one page at a time.
+// Input parameters: optional "status", "from", "search" and "since" filters, and "limit",
+// "offset" or "cursor" to page through the results.
 func HandleListQueriesTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
 	args := request.Params.Arguments
 	statusFilter, _ := args["status"].(string)
 	fromFilter, _ := args["from"].(string)
+	search, _ := args["search"].(string)
+	filter := db.QueryFilter{
+		Status: strings.TrimSpace(statusFilter),
+		From:   strings.TrimSpace(fromFilter),
+		Search: strings.TrimSpace(search),
+	}
+	if since, _ := args["since"].(string); since != "" {
+		t, err := parseToolDate(since)
+		if err != nil {
+			return errorResult(ErrorInvalidArgument, err.Error()), nil
+		}
+		filter.Since = t
+	}
 
 	dbInstance, err := utils.DatabaseFromContext(ctx)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Couldn't access the databse instance: %s", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Couldn't access the databse instance: %s", err.Error())), nil
 	}
 
-	list, err := db.ListQueries(ctx, dbInstance, statusFilter, fromFilter)
+	limit, offset, cursor := pageArguments(args)
+	page, err := db.ListQueriesPage(ctx, dbInstance, filter, limit, offset, cursor)
+	if errors.Is(err, db.ErrInvalidCursor) {
+		return errorResult(ErrorInvalidArgument, "Invalid 'cursor': pass the next_cursor of the previous page"), nil
+	}
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Couldn't retrieve the list of queries.: %s", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't retrieve the list of queries.: %s", err.Error())), nil
 	}
 
-	out, _ := json.MarshalIndent(list, "", "  ")
+	var out []byte
+	switch view, _ := args["view"].(string); strings.ToLower(strings.TrimSpace(view)) {
+	case "full":
+		out, _ = json.MarshalIndent(page, "", "  ")
+	case "", "triage":
+		out, _ = json.MarshalIndent(triagePage(page), "", "  ")
+	default:
+		return errorResult(ErrorInvalidArgument, "'view' must be 'triage' or 'full'"), nil
+	}
 	return &mcp_lib.CallToolResult{Content: []mcp_lib.Content{
 		mcp_lib.TextContent{Type: "text", Text: string(out)},
 	}}, nil
 }
 
+// queryTriageItem is a query as the triage view of cqListRequestedQueries shows it
+type queryTriageItem struct {
+	ID               string   `json:"id"`
+	From             string   `json:"from"`
+	Status           string   `json:"status"`
+	Summary          string   `json:"summary"`
+	Tags             []string `json:"tags,omitempty"`
+	Effort           string   `json:"effort,omitempty"`
+	MatchedDocuments int      `json:"matched_documents"`
+}
+
+// triagePage returns a page of queries with their screening instead of their full text.
+// Queries received before screening existed show their question as summary.
+func triagePage(page *db.QueryPage) any {
+	items := make([]queryTriageItem, len(page.Queries))
+	for i, q := range page.Queries {
+		items[i] = queryTriageItem{
+			ID:               q.ID,
+			From:             q.From,
+			Status:           q.Status,
+			Summary:          q.Question,
+			MatchedDocuments: len(q.DocumentsRelated),
+		}
+		if s := q.Screening; s != nil {
+			items[i].Summary = s.Summary
+			items[i].Tags = s.Tags
+			items[i].Effort = s.Effort
+			items[i].MatchedDocuments = s.MatchedDocuments
+		}
+	}
+	return struct {
+		Queries    []queryTriageItem `json:"queries"`
+		Total      int               `json:"total"`
+		NextCursor string            `json:"next_cursor,omitempty"`
+	}{items, page.Total, page.NextCursor}
+}
+
 // Tool: Add Automatic Approval Condition
 //
 // This tool extracts a condition from a sentence and appends it to the automatic_approval.json file.
@@ -274,38 +383,17 @@ func HandleListQueriesTool(ctx context.Context, request mcp_lib.CallToolRequest)
 func HandleAddApprovalConditionTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
 	dbHandle, err := utils.DatabaseFromContext(ctx)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Couldn't retrieve databse instance : %v'", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Couldn't retrieve databse instance : %v'", err.Error())), nil
 	}
 
 	ruleRaw, ok := req.Params.Arguments["sentence"].(string)
 	rule := strings.TrimSpace(ruleRaw)
 	if !ok || rule == "" {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("'sentence' parameter is required", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInvalidArgument, "'sentence' parameter is required"), nil
 	}
 
 	if err := db.InsertRule(ctx, dbHandle, rule); err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Couldn't add the new rule into the automatic approval register : %v", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't add the new rule into the automatic approval register : %v", err.Error())), nil
 	}
 	return &mcp_lib.CallToolResult{
 		Content: []mcp_lib.Content{
@@ -324,50 +412,22 @@ func HandleAddApprovalConditionTool(ctx context.Context, req mcp_lib.CallToolReq
 func HandleRemoveApprovalConditionTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
 	dbHandle, err := utils.DatabaseFromContext(ctx)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("DB unavailable: %v", err),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("DB unavailable: %v", err)), nil
 	}
 
 	ruleRaw, ok := req.Params.Arguments["condition"].(string)
 	rule := strings.TrimSpace(ruleRaw)
 	if !ok || rule == "" {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("'condition' parameter is required"),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInvalidArgument, "'condition' parameter is required"), nil
 	}
 
 	deleted, err := db.DeleteRule(ctx, dbHandle, rule)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Could not remove rule: %v", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(errorCode(err), fmt.Sprintf("Could not remove rule: %v", err.Error())), nil
 		// return errorResult(fmt.Sprintf("Could not remove rule: %v", err)), nil
 	}
 	if !deleted {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Condition '%s' not found.", rule),
-				},
-			},
-		}, nil
+		return errorResult(ErrorNotFound, fmt.Sprintf("Condition '%s' not found.", rule)), nil
 	}
 	return &mcp_lib.CallToolResult{
 		Content: []mcp_lib.Content{
@@ -385,25 +445,11 @@ func HandleRemoveApprovalConditionTool(ctx context.Context, req mcp_lib.CallTool
 func HandleListApprovalConditionsTool(ctx context.Context, _ mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
 	dbHandle, err := utils.DatabaseFromContext(ctx)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("DB unavailable: %v", err),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("DB unavailable: %v", err)), nil
 	}
 	rules, err := db.ListRules(ctx, dbHandle)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Could not list rules: %v", err),
-				},
-			},
-		}, nil
+		return errorResult(errorCode(err), fmt.Sprintf("Could not list rules: %v", err)), nil
 	}
 	// pretty print like before
 	blob, _ := json.MarshalIndent(rules, "", "  ")
@@ -411,7 +457,7 @@ func HandleListApprovalConditionsTool(ctx context.Context, _ mcp_lib.CallToolReq
 		Content: []mcp_lib.Content{
 			mcp_lib.TextContent{
 				Type: "text",
-				Text: fmt.Sprintf(string(blob)),
+				Text: string(blob),
 			},
 		},
 	}, nil
@@ -424,32 +470,39 @@ func HandleUpdateRagSourcesTool(ctx context.Context, request mcp_lib.CallToolReq
 	// If either is provided we enforce both to be valid.
 	fileName, hasFileName := args["file_name"].(string)
 	fileContent, hasFileContent := args["file_content"].(string)
-	metadata := make(map[string]string)
+	metadata := map[string]string{"source": "mcp"}
+	if tags := stringList(args, "tags"); len(tags) > 0 {
+		metadata["tags"] = strings.Join(tags, ",")
+	}
+
+	// Documents go to the default collection unless another one is named
+	collection, _ := args["collection"].(string)
+	ctx, err := core.UseCollection(ctx, strings.TrimSpace(collection), true)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Invalid collection: %v", err)), nil
+	}
 
 	if hasFileName || hasFileContent {
 		// Check that both parameters are provided and are not empty.
 		if !hasFileName || strings.TrimSpace(fileName) == "" {
-			return &mcp_lib.CallToolResult{
-				Content: []mcp_lib.Content{
-					mcp_lib.TextContent{
-						Type: "text",
-						Text: "'file_name' parameter is required when using the file_name/file_content workflow",
-					},
-				},
-			}, nil
+			return errorResult(ErrorInvalidArgument, "'file_name' parameter is required when using the file_name/file_content workflow"), nil
 		}
 		if !hasFileContent || strings.TrimSpace(fileContent) == "" {
-			return &mcp_lib.CallToolResult{
-				Content: []mcp_lib.Content{
-					mcp_lib.TextContent{
-						Type: "text",
-						Text: "'file_content' parameter is required when using the file_name/file_content workflow",
-					},
-				},
-			}, nil
+			return errorResult(ErrorInvalidArgument, "'file_content' parameter is required when using the file_name/file_content workflow"), nil
+		}
+
+		// Binary formats such as PDF and DOCX are passed base64 encoded
+		if encoding, _ := args["content_encoding"].(string); encoding == "base64" {
+			decoded, err := base64.StdEncoding.DecodeString(fileContent)
+			if err != nil {
+				return errorResult(ErrorInvalidArgument, fmt.Sprintf("'file_content' is not valid base64: %v", err)), nil
+			}
+			fileContent = string(decoded)
 		}
 
-		core.AddDocument(ctx, fileName, fileContent, true, metadata)
+		if err := core.AddDocument(ctx, fileName, fileContent, true, metadata); err != nil {
+			return errorResult(errorCode(err), fmt.Sprintf("Couldn't add RAG resource '%s': %v", fileName, err)), nil
+		}
 
 		// Return a success response.
 		return &mcp_lib.CallToolResult{
@@ -465,33 +518,21 @@ func HandleUpdateRagSourcesTool(ctx context.Context, request mcp_lib.CallToolReq
 	// Workflow 1: Fallback to using the file_path parameter.
 	filePath, ok := args["file_path"].(string)
 	if !ok || strings.TrimSpace(filePath) == "" {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: "Either 'file_path' or both 'file_name' and 'file_content' parameters are required",
-				},
-			},
-		}, nil
+		return errorResult(ErrorInvalidArgument, "Either 'file_path' or both 'file_name' and 'file_content' parameters are required"), nil
 	}
 
 	// Read the content from the file at the provided file_path.
 	data, err := os.ReadFile(filePath)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Error reading file at '%s': %v", filePath, err),
-				},
-			},
-		}, nil
+		return errorResult(errorCode(err), fmt.Sprintf("Error reading file at '%s': %v", filePath, err)), nil
 	}
 
 	// Determine the base file name.
 	baseFile := filepath.Base(filePath)
 
-	core.AddDocument(ctx, baseFile, string(data), true, metadata)
+	if err := core.AddDocument(ctx, baseFile, string(data), true, metadata); err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't add RAG resource '%s': %v", baseFile, err)), nil
+	}
 
 	// Return a success response.
 	return &mcp_lib.CallToolResult{
@@ -505,127 +546,27 @@ func HandleUpdateRagSourcesTool(ctx context.Context, request mcp_lib.CallToolReq
 }
 
 func HandleProcessQuestionTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	lang := language(ctx)
 	id, _ := request.Params.Arguments["id"].(string)
 	if strings.TrimSpace(id) == "" {
-		return nil, fmt.Errorf("'id' parameter is required")
+		return errorResult(ErrorInvalidArgument, i18n.Message(lang, "tool.parameter_required", "id")), nil
 	}
 
 	approved, _ := request.Params.Arguments["approve"].(bool)
 
-	dbInstance, err := utils.DatabaseFromContext(ctx)
-	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Error while trying to get db instance : %s", err.Error()),
-				},
-			},
-		}, nil
-	}
-
-	var newStatus = "accepted"
-	if !approved {
-		newStatus = "rejected"
-	}
-
-	if err := db.UpdateQueryStatus(ctx, dbInstance, id, newStatus); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, fmt.Errorf("query with ID '%s' not found", id)
-		}
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Error while trying to update the query status: %s", err.Error()),
-				},
-			},
-		}, nil
-	}
-
-	qry, err := db.GetQuery(ctx, dbInstance, id)
-	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Error while trying to get the query by its ID: %s", err.Error()),
-				},
-			},
-		}, nil
-	}
-
-	if approved {
-		dkClient, err := utils.DkFromContext(ctx)
-		if err != nil {
-			return &mcp_lib.CallToolResult{
-				Content: []mcp_lib.Content{
-					mcp_lib.TextContent{
-						Type: "text",
-						Text: fmt.Sprintf("Couldn't retrieve DK from context: %s", err.Error()),
-					},
-				},
-			}, nil
-		}
-
-		answerMessage := utils.AnswerMessage{
-			Query:  qry.Question,
-			Answer: qry.Answer,
-			From:   dkClient.UserID,
-		}
-
-		jsonAnswer, err := json.Marshal(answerMessage)
-		if err != nil {
-			return &mcp_lib.CallToolResult{
-				Content: []mcp_lib.Content{
-					mcp_lib.TextContent{
-						Type: "text",
-						Text: fmt.Sprintf("Couldn't marshal answer: %s", err.Error()),
-					},
-				},
-			}, nil
-		}
-
-		query := utils.RemoteMessage{
-			Type:    "answer",
-			Message: string(jsonAnswer),
-		}
-
-		jsonData, err := json.Marshal(query)
-		if err != nil {
-			return &mcp_lib.CallToolResult{
-				Content: []mcp_lib.Content{
-					mcp_lib.TextContent{
-						Type: "text",
-						Text: fmt.Sprintf("Couldn't marshal query: %s", err.Error()),
-					},
-				},
-			}, nil
-		}
-
-		err = dkClient.SendMessage(dk_client.Message{
-			From:      dkClient.UserID,
-			To:        qry.From,
-			Content:   string(jsonData),
-			Timestamp: time.Now(),
-		})
-		if err != nil {
-			return &mcp_lib.CallToolResult{
-				Content: []mcp_lib.Content{
-					mcp_lib.TextContent{
-						Type: "text",
-						Text: fmt.Sprintf("Couldn't send message: %s", err.Error()),
-					},
-				},
-			}, nil
-		}
+	qry, err := core.ReviewQuery(ctx, id, approved)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errorResult(ErrorNotFound, i18n.Message(lang, "query.not_found", id)), nil
+	}
+	if err != nil {
+		return errorResult(errorCode(err), i18n.Message(lang, "query.process_failed", err.Error())), nil
 	}
 
 	return &mcp_lib.CallToolResult{
 		Content: []mcp_lib.Content{
 			mcp_lib.TextContent{
 				Type: "text",
-				Text: fmt.Sprintf("Question '%s' has been %s.\n", qry.Question, newStatus),
+				Text: i18n.Message(lang, "query.processed", qry.Question, statusText(lang, qry.Status)),
 			},
 		},
 	}, nil
@@ -662,58 +603,34 @@ func HandleUpdateAnswerTool(
 ) (*mcp_lib.CallToolResult, error) {
 
 	//----------------------------------------------------------------------
-	// 1.  Grab the database handle from the context
-	//----------------------------------------------------------------------
-	// db, ok := ctx.Value("db").(*sql.DB) // replace if you use another helper
-	dbHandler, err := utils.DatabaseFromContext(ctx)
-	if err != nil {
-		return &mcp_lib.CallToolResult{Content: []mcp_lib.Content{
-			mcp_lib.TextContent{Type: "text", Text: "internal error: DB handle missing"},
-		}}, nil
-	}
-
-	//----------------------------------------------------------------------
-	// 2.  Read & validate input arguments
+	// 1.  Read & validate input arguments
 	//----------------------------------------------------------------------
 	args := request.Params.Arguments
 
 	queryID, _ := args["query_id"].(string)
 	if strings.TrimSpace(queryID) == "" {
-		return &mcp_lib.CallToolResult{Content: []mcp_lib.Content{
-			mcp_lib.TextContent{Type: "text", Text: "'query_id' parameter is required"},
-		}}, nil
+		return errorResult(ErrorInvalidArgument, "'query_id' parameter is required"), nil
 	}
 
 	newAnswer, _ := args["new_answer"].(string)
 	if strings.TrimSpace(newAnswer) == "" {
-		return &mcp_lib.CallToolResult{Content: []mcp_lib.Content{
-			mcp_lib.TextContent{Type: "text", Text: "'new_answer' parameter is required"},
-		}}, nil
+		return errorResult(ErrorInvalidArgument, "'new_answer' parameter is required"), nil
 	}
 
 	//----------------------------------------------------------------------
-	// 3.  Perform the UPDATE … SET answer = ? WHERE id = ?
-	//     The query table was created in db.RunMigrations (see db.go).
+	// 2.  Replace the answer; core tells the cached reads of the queries
+	//     that they changed. Unknown ids leave no row updated.
 	//----------------------------------------------------------------------
-	res, err := dbHandler.ExecContext(ctx,
-		`UPDATE queries SET answer = ? WHERE id = ?`, newAnswer, queryID)
-	if err != nil {
-		return &mcp_lib.CallToolResult{Content: []mcp_lib.Content{
-			mcp_lib.TextContent{Type: "text", Text: fmt.Sprintf("database error: %v", err)},
-		}}, nil
+	err := core.UpdateQueryAnswer(ctx, queryID, newAnswer)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errorResult(ErrorNotFound, fmt.Sprintf("No query found for id: %s", queryID)), nil
 	}
-
-	//----------------------------------------------------------------------
-	// 4.  Check whether the row actually existed
-	//----------------------------------------------------------------------
-	if n, _ := res.RowsAffected(); n == 0 {
-		return &mcp_lib.CallToolResult{Content: []mcp_lib.Content{
-			mcp_lib.TextContent{Type: "text", Text: fmt.Sprintf("No query found for id: %s", queryID)},
-		}}, nil
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("database error: %v", err)), nil
 	}
 
 	//----------------------------------------------------------------------
-	// 5.  Success
+	// 3.  Success
 	//----------------------------------------------------------------------
 	return &mcp_lib.CallToolResult{Content: []mcp_lib.Content{
 		mcp_lib.TextContent{
@@ -729,40 +646,19 @@ func HandleGetActiveUsersTool(ctx context.Context, request mcp_lib.CallToolReque
 	// Retrieve the DK (client) from the context.
 	dkClient, err := utils.DkFromContext(ctx)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Error retrieving client from context: %s", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Error retrieving client from context: %s", err.Error())), nil
 	}
 
 	// Get the active users using the client method.
 	userStatus, err := dkClient.GetActiveUsers()
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Failed to get active users: %s", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to get active users: %s", err.Error())), nil
 	}
 
 	// Format the result as JSON for a nice display.
 	resultJSON, err := json.MarshalIndent(userStatus, "", "  ")
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Error formatting result: %s", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Error formatting result: %s", err.Error())), nil
 	}
 
 	// Return the active/inactive users wrapped in a CallToolResult.
@@ -783,53 +679,25 @@ func HandleGetUserDatasetsTool(ctx context.Context, request mcp_lib.CallToolRequ
 	args := request.Params.Arguments
 	userID, ok := args["user_id"].(string)
 	if !ok || strings.TrimSpace(userID) == "" {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: "'user_id' parameter is required",
-				},
-			},
-		}, nil
+		return errorResult(ErrorInvalidArgument, "'user_id' parameter is required"), nil
 	}
 
 	// Retrieve the DK client from the context.
 	dkClient, err := utils.DkFromContext(ctx)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Failed to retrieve DK client from context: %s", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to retrieve DK client from context: %s", err.Error())), nil
 	}
 
 	// Call the client's GetUserDescriptions method.
 	descriptions, err := dkClient.GetUserDescriptions(userID)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Failed to get user descriptions: %s", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to get user descriptions: %s", err.Error())), nil
 	}
 
 	// Format the descriptions list as a JSON string.
 	formatted, err := json.MarshalIndent(descriptions, "", "  ")
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Error formatting descriptions: %s", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Error formatting descriptions: %s", err.Error())), nil
 	}
 
 	// Wrap the result in a CallToolResult.
@@ -849,20 +717,12 @@ func HandleGetPendingApplicationsTool(ctx context.Context, request mcp_lib.CallT
 	//----------------------------------------------------------------------
 	parameters, err := utils.ParamsFromContext(ctx)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{Type: "text", Text: fmt.Sprintf("Couldn't retrieve params from context: %s", err)},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Couldn't retrieve params from context: %s", err)), nil
 	}
 
 	cfgBytes, err := os.ReadFile(*parameters.SyftboxConfig)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{Type: "text", Text: fmt.Sprintf("Couldn't read Syftbox config at %s", *parameters.SyftboxConfig)},
-			},
-		}, nil
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't read Syftbox config at %s", *parameters.SyftboxConfig)), nil
 	}
 
 	var syftboxConfig struct {
@@ -875,11 +735,7 @@ func HandleGetPendingApplicationsTool(ctx context.Context, request mcp_lib.CallT
 		ClientTimeout float64 `json:"client_timeout"`
 	}
 	if err := json.Unmarshal(cfgBytes, &syftboxConfig); err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{Type: "text", Text: "Failed to parse syftbox config; please verify the file format."},
-			},
-		}, nil
+		return errorResult(ErrorInternal, "Failed to parse syftbox config; please verify the file format."), nil
 	}
 
 	//----------------------------------------------------------------------
@@ -888,11 +744,7 @@ func HandleGetPendingApplicationsTool(ctx context.Context, request mcp_lib.CallT
 	inboxPath := filepath.Join(syftboxConfig.DataDir, "datasites", syftboxConfig.Email, "inbox")
 	dirEntries, err := os.ReadDir(inboxPath)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{Type: "text", Text: fmt.Sprintf("Failed to read inbox directory: %s", err)},
-			},
-		}, nil
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to read inbox directory: %s", err)), nil
 	}
 
 	var inboxNames []string
@@ -949,14 +801,7 @@ func HandleGetPendingApplicationsTool(ctx context.Context, request mcp_lib.CallT
 
 	out, err := json.MarshalIndent(pending, "", "  ")
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Couldn't marshal the output result %v", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Couldn't marshal the output result %v", err.Error())), nil
 	}
 
 	return &mcp_lib.CallToolResult{
@@ -974,51 +819,23 @@ func HandleProcessApplicationRequestTool(ctx context.Context, request mcp_lib.Ca
 	args := request.Params.Arguments
 	appName, ok := args["app_name"].(string)
 	if !ok || strings.TrimSpace(appName) == "" {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: "'app_name' parameter is required",
-				},
-			},
-		}, nil
+		return errorResult(ErrorInvalidArgument, "'app_name' parameter is required"), nil
 	}
 
 	approval, ok := args["approve"].(bool)
 	if !ok || strings.TrimSpace(appName) == "" {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: "'approval' parameter is required",
-				},
-			},
-		}, nil
+		return errorResult(ErrorInvalidArgument, "'approval' parameter is required"), nil
 	}
 
 	parameters, err := utils.ParamsFromContext(ctx)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Couldn't retrieve params from context: %s", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Couldn't retrieve params from context: %s", err.Error())), nil
 	}
 
 	file, err := os.ReadFile(*parameters.SyftboxConfig)
 	if err != nil {
 		// Wrap the result in a CallToolResult.
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Couldn't find Syftbox config file in path %s, please verify if this path exist", *parameters.SyftboxConfig),
-				},
-			},
-		}, nil
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't find Syftbox config file in path %s, please verify if this path exist", *parameters.SyftboxConfig)), nil
 	}
 
 	var syftboxConfig struct {
@@ -1032,40 +849,19 @@ func HandleProcessApplicationRequestTool(ctx context.Context, request mcp_lib.Ca
 	}
 
 	if err := json.Unmarshal(file, &syftboxConfig); err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Failed to parse the syftbox config file. Please check if your config file is set properly."),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, "Failed to parse the syftbox config file. Please check if your config file is set properly."), nil
 	}
 
 	appPath := filepath.Join(syftboxConfig.DataDir, "datasites", syftboxConfig.Email, "inbox", appName)
 
 	prohibitedNames := appName == "approved" || appName == "rejected" || appName == "syftperm.yaml"
 	if prohibitedNames {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("You can't approve the %s folder/file", appName),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInvalidArgument, fmt.Sprintf("You can't approve the %s folder/file", appName)), nil
 	}
 
 	_, err = os.Stat(appPath)
 	if os.IsNotExist(err) {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("The app '%s' doesn't exist or isn't in pending state anymore. Please verify if you typed it properly.", appName),
-				},
-			},
-		}, nil
+		return errorResult(ErrorNotFound, fmt.Sprintf("The app '%s' doesn't exist or isn't in pending state anymore. Please verify if you typed it properly.", appName)), nil
 	}
 
 	approvalStatus := "approved"
@@ -1092,108 +888,22 @@ func HandleSubmitAppFolderTool(ctx context.Context, request mcp_lib.CallToolRequ
 	args := request.Params.Arguments
 	appPath, ok := args["app_path"].(string)
 	if !ok || strings.TrimSpace(appPath) == "" {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: "'app_path' parameter is required",
-				},
-			},
-		}, nil
+		return errorResult(ErrorInvalidArgument, "'app_path' parameter is required"), nil
 	}
 
 	appDescription, ok := args["description"].(string)
 	if !ok || strings.TrimSpace(appDescription) == "" {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: "'description' parameter is required",
-				},
-			},
-		}, nil
-	}
-
-	var peers []string
-	if r, exists := args["peers"]; exists {
-		for _, item := range r.([]any) {
-			if str, ok := item.(string); ok {
-				peers = append(peers, str)
-			}
-		}
-	}
-
-	result, err := core.ScanDirToMap(ctx, appPath)
-	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: "'app_path' parameter is required",
-				},
-			},
-		}, nil
+		return errorResult(ErrorInvalidArgument, "'description' parameter is required"), nil
 	}
 
-	dkClient, err := utils.DkFromContext(ctx)
-	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Couldn't retrieve DK from context: %s", err.Error()),
-				},
-			},
-		}, nil
-	}
-	query := utils.RemoteMessage{
-		Type:    "app",
-		Message: appDescription,
-		Files:   result,
-	}
-	jsonData, err := json.Marshal(query)
+	peers, err := core.ResolvePeers(ctx, stringList(args, "peers"), stringList(args, "tags"))
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Couldn't marshal query: %s", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't resolve peers: %v", err)), nil
 	}
-	if len(peers) == 0 {
-		err = dkClient.BroadcastMessage(string(jsonData))
-		if err != nil {
-			return &mcp_lib.CallToolResult{
-				Content: []mcp_lib.Content{
-					mcp_lib.TextContent{
-						Type: "text",
-						Text: fmt.Sprintf("Couldn't send message: %s", err.Error()),
-					},
-				},
-			}, nil
-		}
-	} else {
-		for _, peer := range peers {
-			err = dkClient.SendMessage(dk_client.Message{
-				From:      dkClient.UserID,
-				To:        peer,
-				Content:   string(jsonData),
-				Timestamp: time.Now(),
-			})
-			if err != nil {
 
-				return &mcp_lib.CallToolResult{
-					Content: []mcp_lib.Content{
-						mcp_lib.TextContent{
-							Type: "text",
-							Text: fmt.Sprintf("Couldn't send message: %s", err.Error()),
-						},
-					},
-				}, nil
-			}
-		}
+	// The app folder is sent as a file transfer, in encrypted chunks the peers can resume
+	if err := core.SendApplication(ctx, peers, appPath, appDescription); err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't send the app: %s", err.Error())), nil
 	}
 
 	return &mcp_lib.CallToolResult{
@@ -1207,6 +917,42 @@ func HandleSubmitAppFolderTool(ctx context.Context, request mcp_lib.CallToolRequ
 
 }
 
+// Tool: Send Attachment
+//
+// This tool sends a local file to peers as an attachment, in chunks the recipients put back
+// together and save in their attachments directory.
+// Input parameters: "path", "peers" and optionally "note".
+func HandleSendAttachmentTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	args := request.Params.Arguments
+	path, _ := args["path"].(string)
+	if strings.TrimSpace(path) == "" {
+		return errorResult(ErrorInvalidArgument, "'path' parameter is required"), nil
+	}
+	path, err := utils.ExpandHomePath(strings.TrimSpace(path))
+	if err != nil {
+		return errorResult(ErrorInvalidArgument, fmt.Sprintf("Invalid path: %v", err)), nil
+	}
+	var peers []string
+	if list, ok := args["peers"].([]any); ok {
+		for _, item := range list {
+			if peer, ok := item.(string); ok && strings.TrimSpace(peer) != "" {
+				peers = append(peers, strings.TrimPrefix(strings.TrimSpace(peer), "@"))
+			}
+		}
+	}
+	if len(peers) == 0 {
+		return errorResult(ErrorInvalidArgument, "'peers' must name at least one peer; attachments are not broadcast"), nil
+	}
+	note, _ := args["note"].(string)
+
+	attachment, err := core.SendAttachment(ctx, peers, path, note)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't send the attachment: %v", err)), nil
+	}
+	blob, _ := json.MarshalIndent(attachment, "", "  ")
+	return mcp_lib.NewToolResultText(fmt.Sprintf("Sent %s to %s.\n%s", attachment.FileName, strings.Join(peers, ", "), blob)), nil
+}
+
 // HandleGetTokenTool retrieves the current JWT token used by the client.
 // This tool can be useful for debugging authentication issues or extending
 // the client's functionality with external tools that need the token.
@@ -1214,14 +960,7 @@ func HandleGetTokenTool(ctx context.Context, _ mcp_lib.CallToolRequest) (*mcp_li
 	// Retrieve the DK client from the context
 	dkClient, err := utils.DkFromContext(ctx)
 	if err != nil {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: fmt.Sprintf("Failed to retrieve client from context: %s", err.Error()),
-				},
-			},
-		}, nil
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to retrieve client from context: %s", err.Error())), nil
 	}
 
 	// Get the token using the client's Token method
@@ -1229,14 +968,7 @@ func HandleGetTokenTool(ctx context.Context, _ mcp_lib.CallToolRequest) (*mcp_li
 
 	// Check if the token is empty
 	if token == "" {
-		return &mcp_lib.CallToolResult{
-			Content: []mcp_lib.Content{
-				mcp_lib.TextContent{
-					Type: "text",
-					Text: "No authentication token found. The client may not be logged in.",
-				},
-			},
-		}, nil
+		return errorResult(ErrorUnavailable, "No authentication token found. The client may not be logged in."), nil
 	}
 
 	// Return the token
@@ -1249,3 +981,84 @@ func HandleGetTokenTool(ctx context.Context, _ mcp_lib.CallToolRequest) (*mcp_li
 		},
 	}, nil
 }
+
+// HandleListCollectionsTool lists the vector collections and how many documents each holds
+func HandleListCollectionsTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	collections := core.CollectionsFromContext(ctx)
+	if collections == nil {
+		return errorResult(ErrorInternal, "Vector collections are not available"), nil
+	}
+
+	blob, err := json.MarshalIndent(collections.List(), "", "  ")
+	if err != nil {
+		return errorResult(ErrorInternal, fmt.Sprintf("Failed to encode collections: %v", err)), nil
+	}
+	return mcp_lib.NewToolResultText(string(blob)), nil
+}
+
+// Tool: Delete Document
+//
+// This tool marks a document as deleted, or removes it together with its document associations
+// when "permanent" is set.
+// Input parameters: "file_name" and optionally "collection" and "permanent".
+func HandleDeleteDocumentTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	args := request.Params.Arguments
+	fileName, _ := args["file_name"].(string)
+	if strings.TrimSpace(fileName) == "" {
+		return errorResult(ErrorInvalidArgument, "'file_name' parameter is required"), nil
+	}
+	collection, _ := args["collection"].(string)
+	ctx, err := core.UseCollection(ctx, strings.TrimSpace(collection), false)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Invalid collection: %v", err)), nil
+	}
+
+	if permanent, _ := args["permanent"].(bool); permanent {
+		if err := core.PurgeDocument(ctx, fileName); err != nil {
+			return errorResult(errorCode(err), fmt.Sprintf("Couldn't delete document '%s': %v", fileName, err)), nil
+		}
+		return mcp_lib.NewToolResultText(fmt.Sprintf("Document '%s' permanently deleted.", fileName)), nil
+	}
+	if err := core.DeleteDocument(ctx, fileName); err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't delete document '%s': %v", fileName, err)), nil
+	}
+	return mcp_lib.NewToolResultText(fmt.Sprintf("Document '%s' deleted.", fileName)), nil
+}
+
+// Tool: Update Document
+//
+// This tool replaces the content of a document, keeping its tags and other metadata unless new tags are given.
+// Input parameters: "file_name", "file_content" and optionally "content_encoding", "collection" and "tags".
+func HandleUpdateDocumentTool(ctx context.Context, request mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	args := request.Params.Arguments
+	fileName, _ := args["file_name"].(string)
+	fileContent, _ := args["file_content"].(string)
+	if strings.TrimSpace(fileName) == "" || strings.TrimSpace(fileContent) == "" {
+		return errorResult(ErrorInvalidArgument, "'file_name' and 'file_content' parameters are required"), nil
+	}
+	if encoding, _ := args["content_encoding"].(string); encoding == "base64" {
+		decoded, err := base64.StdEncoding.DecodeString(fileContent)
+		if err != nil {
+			return errorResult(ErrorInvalidArgument, fmt.Sprintf("'file_content' is not valid base64: %v", err)), nil
+		}
+		fileContent = string(decoded)
+	}
+	metadata := map[string]string{}
+	if _, ok := args["tags"]; ok {
+		metadata["tags"] = strings.Join(stringList(args, "tags"), ",")
+	}
+
+	collection, _ := args["collection"].(string)
+	ctx, err := core.UseCollection(ctx, strings.TrimSpace(collection), false)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Invalid collection: %v", err)), nil
+	}
+	if doc, err := core.GetDocument(ctx, "file", fileName, 1); err != nil || doc == nil {
+		return errorResult(ErrorNotFound, fmt.Sprintf("Document '%s' not found", fileName)), nil
+	}
+
+	if err := core.UpdateDocument(ctx, fileName, fileContent, metadata); err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Couldn't update document '%s': %v", fileName, err)), nil
+	}
+	return mcp_lib.NewToolResultText(fmt.Sprintf("Document '%s' updated.", fileName)), nil
+}
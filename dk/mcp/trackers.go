@@ -0,0 +1,100 @@
+package mcp
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"errors"
+	"fmt"
+	"strings"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// Tool: List Trackers
+//
+// This tool lists the trackers API requests can require.
+// Input parameters: optional "active_only".
+func HandleListTrackersTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, err.Error()), nil
+	}
+	activeOnly, _ := req.Params.Arguments["active_only"].(bool)
+
+	trackers, err := db.ListTrackers(database, activeOnly)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to list trackers: %v", err)), nil
+	}
+	return apiResult(map[string]interface{}{
+		"trackers": trackers,
+		"total":    len(trackers),
+	})
+}
+
+// Tool: Create Tracker
+//
+// This tool creates an active tracker that API requests can require.
+// Input parameters: "name" and optionally "description".
+func HandleCreateTrackerTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	name, _ := req.Params.Arguments["name"].(string)
+	description, _ := req.Params.Arguments["description"].(string)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errorResult(ErrorInvalidArgument, "Tracker name is required"), nil
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, err.Error()), nil
+	}
+	trackers, err := db.ListTrackers(database, false)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to list trackers: %v", err)), nil
+	}
+	for _, tracker := range trackers {
+		if strings.EqualFold(tracker.Name, name) {
+			return errorResult(ErrorConflict, fmt.Sprintf("A tracker named '%s' already exists (ID %s)", tracker.Name, tracker.ID)), nil
+		}
+	}
+
+	tracker := &db.Tracker{
+		Name:        name,
+		Description: strings.TrimSpace(description),
+		IsActive:    true,
+	}
+	if err := db.CreateTracker(database, tracker); err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to create tracker: %v", err)), nil
+	}
+	return apiResult(tracker)
+}
+
+// Tool: Set Tracker Active
+//
+// This tool activates or deactivates a tracker. New API requests cannot require an inactive
+// tracker; requests that already require it are unchanged.
+// Input parameters: "id" and "active".
+func HandleSetTrackerActiveTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	id, _ := req.Params.Arguments["id"].(string)
+	id = strings.TrimSpace(id)
+	active, ok := req.Params.Arguments["active"].(bool)
+	if id == "" || !ok {
+		return errorResult(ErrorInvalidArgument, "'id' and 'active' parameters are required"), nil
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, err.Error()), nil
+	}
+	if err := db.SetTrackerActive(database, id, active); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return errorResult(ErrorNotFound, "Tracker not found"), nil
+		}
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to update tracker: %v", err)), nil
+	}
+	state := "deactivated"
+	if active {
+		state = "activated"
+	}
+	return mcp_lib.NewToolResultText(fmt.Sprintf("Tracker '%s' %s.", id, state)), nil
+}
@@ -0,0 +1,79 @@
+package mcp
+
+import (
+	"context"
+	"dk/db"
+	"testing"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestTrackerTools(t *testing.T) {
+	ctx := apiManagementDB(t)
+	call := func(tool string, handler func(context.Context, mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error), args map[string]any) *mcp_lib.CallToolResult {
+		t.Helper()
+		result, err := handler(ctx, callRequest(tool, args))
+		if err != nil {
+			t.Fatalf("%s failed: %v", tool, err)
+		}
+		return result
+	}
+	list := func(activeOnly bool) map[string]bool {
+		t.Helper()
+		var listing struct {
+			Trackers []db.Tracker `json:"trackers"`
+			Total    int          `json:"total"`
+		}
+		decodeResult(t, call("cqListTrackers", HandleListTrackersTool, map[string]any{"active_only": activeOnly}), &listing)
+		if listing.Total != len(listing.Trackers) {
+			t.Errorf("Expected the total to count the trackers, got %d for %d", listing.Total, len(listing.Trackers))
+		}
+		found := make(map[string]bool)
+		for _, tracker := range listing.Trackers {
+			found[tracker.Name] = tracker.IsActive
+		}
+		return found
+	}
+
+	// Trackers are created active, and names are unique whatever their case
+	var audit, consent db.Tracker
+	decodeResult(t, call("cqCreateTracker", HandleCreateTrackerTool, map[string]any{"name": " Audit log ", "description": " Records every call "}), &audit)
+	if audit.ID == "" || audit.Name != "Audit log" || audit.Description != "Records every call" || !audit.IsActive {
+		t.Errorf("Expected the created tracker, got %+v", audit)
+	}
+	decodeResult(t, call("cqCreateTracker", HandleCreateTrackerTool, map[string]any{"name": "Consent"}), &consent)
+
+	// Invalid arguments, duplicates and unknown trackers are refused
+	invalid := []struct {
+		tool    string
+		handler func(context.Context, mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error)
+		args    map[string]any
+		code    string
+	}{
+		{"cqCreateTracker", HandleCreateTrackerTool, map[string]any{"name": "  "}, ErrorInvalidArgument},
+		{"cqCreateTracker", HandleCreateTrackerTool, map[string]any{"name": "AUDIT LOG"}, ErrorConflict},
+		{"cqSetTrackerActive", HandleSetTrackerActiveTool, map[string]any{"id": consent.ID}, ErrorInvalidArgument},
+		{"cqSetTrackerActive", HandleSetTrackerActiveTool, map[string]any{"active": false}, ErrorInvalidArgument},
+		{"cqSetTrackerActive", HandleSetTrackerActiveTool, map[string]any{"id": "missing", "active": false}, ErrorNotFound},
+	}
+	for _, tc := range invalid {
+		if envelope := decodeToolError(t, call(tc.tool, tc.handler, tc.args)); envelope.Code != tc.code {
+			t.Errorf("%s %v: expected %s, got %+v", tc.tool, tc.args, tc.code, envelope)
+		}
+	}
+
+	// Deactivated trackers stay listed unless active_only is set, and can be activated again
+	if text := resultText(t, call("cqSetTrackerActive", HandleSetTrackerActiveTool, map[string]any{"id": consent.ID, "active": false})); text != "Tracker '"+consent.ID+"' deactivated." {
+		t.Errorf("Expected the tracker to be deactivated, got %q", text)
+	}
+	if all := list(false); len(all) != 2 || !all["Audit log"] || all["Consent"] {
+		t.Errorf("Expected both trackers with consent inactive, got %v", all)
+	}
+	if active := list(true); len(active) != 1 || !active["Audit log"] {
+		t.Errorf("Expected only the active tracker, got %v", active)
+	}
+	call("cqSetTrackerActive", HandleSetTrackerActiveTool, map[string]any{"id": consent.ID, "active": true})
+	if active := list(true); len(active) != 2 {
+		t.Errorf("Expected the tracker to be active again, got %v", active)
+	}
+}
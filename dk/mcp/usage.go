@@ -0,0 +1,123 @@
+package mcp
+
+import (
+	"context"
+	"dk/db"
+	"dk/utils"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	mcp_lib "github.com/mark3labs/mcp-go/mcp"
+)
+
+// usageTotals adds up the usage summaries of one API, one user or everything
+type usageTotals struct {
+	Key               string  `json:"key,omitempty"`
+	Name              string  `json:"name,omitempty"`
+	TotalRequests     int     `json:"total_requests"`
+	TotalTokens       int     `json:"total_tokens"`
+	TotalCredits      float64 `json:"total_credits"`
+	TotalTimeMs       int     `json:"total_time_ms"`
+	ThrottledRequests int     `json:"throttled_requests"`
+	BlockedRequests   int     `json:"blocked_requests"`
+}
+
+func (t *usageTotals) add(summary *db.APIUsageSummary) {
+	t.TotalRequests += summary.TotalRequests
+	t.TotalTokens += summary.TotalTokens
+	t.TotalCredits += summary.TotalCredits
+	t.TotalTimeMs += summary.TotalTimeMs
+	t.ThrottledRequests += summary.ThrottledRequests
+	t.BlockedRequests += summary.BlockedRequests
+}
+
+// groupUsage returns the totals of the summaries grouped by key, in key order
+func groupUsage(summaries []*db.APIUsageSummary, key func(*db.APIUsageSummary) string) []*usageTotals {
+	groups := make(map[string]*usageTotals)
+	for _, summary := range summaries {
+		k := key(summary)
+		if groups[k] == nil {
+			groups[k] = &usageTotals{Key: k}
+		}
+		groups[k].add(summary)
+	}
+	result := make([]*usageTotals, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+	return result
+}
+
+// Tool: Get Usage Summary
+//
+// This tool reads the daily, weekly or monthly usage summaries of the hosted APIs and returns
+// them with totals per API, per external user and overall.
+// Input parameters: optional "period", "api_id", "external_user", "from" and "to".
+func HandleGetUsageSummaryTool(ctx context.Context, req mcp_lib.CallToolRequest) (*mcp_lib.CallToolResult, error) {
+	period, _ := req.Params.Arguments["period"].(string)
+	period = strings.ToLower(strings.TrimSpace(period))
+	if period == "" {
+		period = "daily"
+	}
+	if period != "daily" && period != "weekly" && period != "monthly" {
+		return errorResult(ErrorInvalidArgument, "'period' must be one of: daily, weekly, monthly"), nil
+	}
+	apiID, _ := req.Params.Arguments["api_id"].(string)
+	apiID = strings.TrimSpace(apiID)
+	externalUser, _ := req.Params.Arguments["external_user"].(string)
+	externalUser = strings.TrimPrefix(strings.TrimSpace(externalUser), "@")
+
+	var fromDate, toDate time.Time
+	if value, _ := req.Params.Arguments["from"].(string); strings.TrimSpace(value) != "" {
+		parsed, err := parseToolDate(strings.TrimSpace(value))
+		if err != nil {
+			return errorResult(ErrorInvalidArgument, err.Error()), nil
+		}
+		fromDate = parsed
+	}
+	if value, _ := req.Params.Arguments["to"].(string); strings.TrimSpace(value) != "" {
+		parsed, err := parseToolDate(strings.TrimSpace(value))
+		if err != nil {
+			return errorResult(ErrorInvalidArgument, err.Error()), nil
+		}
+		toDate = parsed
+	}
+
+	database, err := utils.DatabaseFromContext(ctx)
+	if err != nil {
+		return errorResult(ErrorInternal, err.Error()), nil
+	}
+	if apiID != "" {
+		if _, err := db.GetAPI(database, apiID); err != nil {
+			return apiError("retrieve", apiID, err)
+		}
+	}
+
+	summaries, err := db.GetAPIUsageSummaries(database, apiID, externalUser, period, fromDate, toDate)
+	if err != nil {
+		return errorResult(errorCode(err), fmt.Sprintf("Failed to get usage summaries: %v", err)), nil
+	}
+
+	totals := &usageTotals{}
+	for _, summary := range summaries {
+		totals.add(summary)
+	}
+	byAPI := groupUsage(summaries, func(s *db.APIUsageSummary) string { return s.APIID })
+	for _, group := range byAPI {
+		if api, err := db.GetAPI(database, group.Key); err == nil {
+			group.Name = api.Name
+		}
+	}
+	byUser := groupUsage(summaries, func(s *db.APIUsageSummary) string { return s.ExternalUserID })
+
+	return apiResult(map[string]interface{}{
+		"period":    period,
+		"totals":    totals,
+		"by_api":    byAPI,
+		"by_user":   byUser,
+		"summaries": summaries,
+	})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/ed25519"
+	dk_client "dk/client"
+	"dk/utils"
+	"log"
+	"strings"
+)
+
+// newServerClient creates a client of a websocket server with the TLS, proxy and compression
+// settings of the node.
+func newServerClient(params utils.Parameters, serverURL string, privateKey ed25519.PrivateKey, publicKey ed25519.PublicKey) *dk_client.Client {
+	client := dk_client.NewClient(serverURL, *params.UserID, privateKey, publicKey)
+	client.SetInsecure(*params.TLSInsecure)
+	if *params.TLSCACert != "" {
+		if err := client.LoadRootCAs(*params.TLSCACert); err != nil {
+			log.Fatalf("Failed to load the CA certificates: %v", err)
+		}
+	}
+	if *params.TLSPins != "" {
+		if err := client.PinCertificates(strings.Split(*params.TLSPins, ",")...); err != nil {
+			log.Fatalf("Failed to pin the server certificate: %v", err)
+		}
+	}
+	if err := client.SetProxy(*params.Proxy); err != nil {
+		log.Fatalf("Failed to set the proxy: %v", err)
+	}
+	client.SetCompression(*params.WSCompression)
+	return client
+}
+
+// joinPartnerNetworks connects to the servers of the partner networks with the identity of the
+// node, so it answers and queries peers of those networks as well. A partner network that cannot
+// be joined is skipped.
+func joinPartnerNetworks(client *dk_client.Client, params utils.Parameters, privateKey ed25519.PrivateKey, publicKey ed25519.PublicKey) {
+	if *params.PartnerServers == "" {
+		return
+	}
+	for _, entry := range strings.Split(*params.PartnerServers, ",") {
+		name, serverURL, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || name == "" || serverURL == "" {
+			log.Printf("Skipping partner network %q: expected name=url", entry)
+			continue
+		}
+		partner := newServerClient(params, serverURL, privateKey, publicKey)
+		if err := partner.Register(*params.UserID); err != nil {
+			log.Printf("Registration on partner network %s failed: %v", name, err)
+		}
+		if err := partner.Login(); err != nil {
+			log.Printf("Skipping partner network %s: login failed: %v", name, err)
+			continue
+		}
+		if err := partner.Connect(); err != nil {
+			log.Printf("Skipping partner network %s: connection failed: %v", name, err)
+			continue
+		}
+		if err := client.AddNetwork(name, partner); err != nil {
+			log.Printf("Skipping partner network %s: %v", name, err)
+			partner.Disconnect()
+			continue
+		}
+		log.Printf("Joined partner network %s at %s", name, serverURL)
+	}
+}
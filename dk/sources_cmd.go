@@ -0,0 +1,112 @@
+package main
+
+import (
+	"dk/core"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+const sourcesUsage = `Usage: dk -rag_sources=FILE sources <command> [flags]
+
+Edits the RAG sources file. A running node picks up the changes with its source watcher.
+
+Commands:
+  list                            List the entries
+  add [flags]                     Add an entry; one of -text, -path or -url is required
+  update -file NAME [flags]       Change the given fields of an entry
+  remove -file NAME [-collection] Remove an entry
+`
+
+// runSourcesCommand implements the "sources" subcommand and returns the exit code
+func runSourcesCommand(sourcesFile string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, sourcesUsage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("sources "+args[0], flag.ContinueOnError)
+	file := fs.String("file", "", "Name the document is stored under")
+	newFile := fs.String("new_file", "", "New name of the entry (update only)")
+	collection := fs.String("collection", "", "Named collection of the entry")
+	text := fs.String("text", "", "Inline content")
+	path := fs.String("path", "", "Local file to ingest")
+	url := fs.String("url", "", "HTTP(S) address to download and ingest")
+	tags := fs.String("tags", "", "Comma-separated tags")
+	owner := fs.String("owner", "", "Owner of the document")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// apply sets the given content, tags and owner; setting one kind of content clears the others
+	apply := func(source *core.RagSource) {
+		switch {
+		case set["text"]:
+			source.Text, source.Path, source.URL = *text, "", ""
+		case set["path"]:
+			source.Text, source.Path, source.URL = "", *path, ""
+		case set["url"]:
+			source.Text, source.Path, source.URL = "", "", *url
+		}
+		if set["tags"] {
+			source.Tags = core.ParseTags(*tags)
+		}
+		if set["owner"] {
+			source.Owner = *owner
+		}
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		var sources []core.RagSource
+		sources, err = core.ReadRagSources(sourcesFile)
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		e := json.NewEncoder(os.Stdout)
+		e.SetEscapeHTML(false)
+		for _, source := range sources {
+			if text := []rune(source.Text); len(text) > 80 {
+				source.Text = string(text[:80]) + "…"
+			}
+			e.Encode(source)
+		}
+	case "add":
+		source := core.RagSource{FileName: *file, Collection: *collection}
+		apply(&source)
+		err = core.AddRagSource(sourcesFile, source)
+	case "update":
+		if *file == "" {
+			err = errors.New("-file is required")
+			break
+		}
+		_, err = core.UpdateRagSource(sourcesFile, *collection, *file, func(source *core.RagSource) {
+			apply(source)
+			if *newFile != "" {
+				source.FileName = *newFile
+			}
+		})
+	case "remove":
+		if *file == "" {
+			err = errors.New("-file is required")
+			break
+		}
+		_, err = core.RemoveRagSource(sourcesFile, *collection, *file)
+	default:
+		fmt.Fprint(os.Stderr, sourcesUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "sources %s: %v\n", args[0], err)
+		return 1
+	}
+	if args[0] != "list" {
+		fmt.Printf("Updated %s\n", sourcesFile)
+	}
+	return 0
+}
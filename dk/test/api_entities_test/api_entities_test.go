@@ -444,10 +444,7 @@ func TestCreateAPI(t *testing.T) {
 				Description: "API created during testing",
 				PolicyID:    policyIDs[0],
 				DocumentIDs: []string{"doc1.txt", "doc2.txt"},
-				ExternalUsers: []struct {
-					UserID      string `json:"user_id"`
-					AccessLevel string `json:"access_level"`
-				}{
+				ExternalUsers: []httpPkg.ExternalUserAccess{
 					{UserID: "ext_user_1", AccessLevel: "read"},
 					{UserID: "ext_user_2", AccessLevel: "write"},
 				},
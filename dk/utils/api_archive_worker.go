@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"dk/db"
+	"log"
+	"time"
+)
+
+// StartAPIArchiveWorker begins a background worker that periodically archives deprecated APIs
+// whose deprecation date has passed, releasing their names for reuse.
+func StartAPIArchiveWorker(ctx context.Context, database *sql.DB, checkInterval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(checkInterval)
+		defer ticker.Stop()
+
+		archiveDeprecatedAPIs(database, time.Now())
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("API archive worker shutting down")
+				return
+			case now := <-ticker.C:
+				archiveDeprecatedAPIs(database, now)
+			}
+		}
+	}()
+
+	log.Printf("API archive worker started with check interval of %v", checkInterval)
+}
+
+// archiveDeprecatedAPIs archives the APIs deprecated past their deprecation date
+func archiveDeprecatedAPIs(database *sql.DB, now time.Time) {
+	apiIDs, err := db.ListAPIsDueForArchive(database, now)
+	if err != nil {
+		log.Printf("Error listing APIs due for archive: %v", err)
+		return
+	}
+
+	for _, apiID := range apiIDs {
+		archive, err := db.ArchiveAPI(database, apiID, now)
+		if err != nil {
+			log.Printf("Error archiving API %s: %v", apiID, err)
+			continue
+		}
+
+		log.Printf("Archived deprecated API %s (%s)", archive.APIID, archive.Name)
+	}
+}
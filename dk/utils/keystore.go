@@ -0,0 +1,377 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeyPassphraseEnv names the environment variable holding the passphrase of a keystore file, or
+// the PIN of a PKCS#11 token. It is not a flag, so it does not show in the process list of dk.
+const KeyPassphraseEnv = "DK_KEY_PASSPHRASE"
+
+// keychainService is the default service the identity key is kept under in the OS keychain.
+const keychainService = "DistributedKnowledge"
+
+// ErrKeyNotFound is returned by a KeySource that does not hold an identity key yet.
+var ErrKeyNotFound = errors.New("identity key not found")
+
+// KeySource keeps the Ed25519 identity key of the node somewhere else than in plain files.
+// The client needs the key in memory to sign and decrypt messages, so a source protects the
+// key at rest, not from the running node.
+type KeySource interface {
+	// Load returns the identity key, or ErrKeyNotFound.
+	Load() (ed25519.PrivateKey, error)
+	// Store keeps a new identity key. It never replaces an existing one.
+	Store(key ed25519.PrivateKey) error
+}
+
+// ParseKeySource returns the source of the identity key of userID described by spec:
+//
+//	keystore:PATH      a keystore file encrypted with the passphrase in DK_KEY_PASSPHRASE
+//	keychain[:SERVICE] the OS keychain: the macOS Keychain, or the Secret Service on Linux
+//	pkcs11:MODULE      a private data object on the token of a PKCS#11 module, unlocked with
+//	                   the PIN in DK_KEY_PASSPHRASE
+func ParseKeySource(spec, userID string) (KeySource, error) {
+	kind, value, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "keystore":
+		if value == "" {
+			return nil, errors.New("keystore: the path of the keystore file is missing")
+		}
+		return &keystoreFile{path: value, passphrase: os.Getenv(KeyPassphraseEnv)}, nil
+	case "keychain":
+		if value == "" {
+			value = keychainService
+		}
+		return &keychain{service: value, account: userID}, nil
+	case "pkcs11":
+		if value == "" {
+			return nil, errors.New("pkcs11: the path of the PKCS#11 module is missing")
+		}
+		return &pkcs11Token{module: value, label: "dk-identity-" + userID, pin: os.Getenv(KeyPassphraseEnv)}, nil
+	default:
+		return nil, fmt.Errorf("unknown key store %q: expected keystore:PATH, keychain[:SERVICE] or pkcs11:MODULE", spec)
+	}
+}
+
+// LoadOrCreateIdentity loads the identity key from a source, or generates one and stores it
+// there if the source has none yet.
+func LoadOrCreateIdentity(source KeySource) (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	privateKey, err := source.Load()
+	if errors.Is(err, ErrKeyNotFound) {
+		if _, privateKey, err = ed25519.GenerateKey(rand.Reader); err != nil {
+			return nil, nil, err
+		}
+		err = source.Store(privateKey)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return privateKey.Public().(ed25519.PublicKey), privateKey, nil
+}
+
+// keySeed returns the private key of a seed read from a key source.
+func keySeed(seed []byte) (ed25519.PrivateKey, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid identity key size: %d", len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// keystoreFile is an identity key kept in a file, encrypted with a key derived from a
+// passphrase with scrypt.
+type keystoreFile struct {
+	path       string
+	passphrase string
+}
+
+// keystoreContent is the content of a keystore file. The public key is in the clear, so the
+// identity a file holds can be told without the passphrase.
+type keystoreContent struct {
+	Version    int    `json:"version"`
+	PublicKey  string `json:"public_key"` // Hex
+	KDF        string `json:"kdf"`        // "scrypt"
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       string `json:"salt"`       // Hex
+	Nonce      string `json:"nonce"`      // Hex, of XChaCha20-Poly1305
+	Ciphertext string `json:"ciphertext"` // Hex, of the seed of the key
+}
+
+// Parameters of scrypt for new keystore files, taking about 100ms on current hardware.
+const (
+	keystoreScryptN = 1 << 15
+	keystoreScryptR = 8
+	keystoreScryptP = 1
+)
+
+func (k *keystoreFile) Load() (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(k.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var content keystoreContent
+	if err := json.Unmarshal(data, &content); err != nil || content.Version != 1 || content.KDF != "scrypt" || content.N > 1<<20 {
+		return nil, fmt.Errorf("%s is not a keystore file", k.path)
+	}
+	publicKey, err1 := hex.DecodeString(content.PublicKey)
+	salt, err2 := hex.DecodeString(content.Salt)
+	nonce, err3 := hex.DecodeString(content.Nonce)
+	ciphertext, err4 := hex.DecodeString(content.Ciphertext)
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return nil, fmt.Errorf("%s is not a keystore file: %w", k.path, err)
+	}
+	aead, err := k.aead(salt, content.N, content.R, content.P)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("%s is not a keystore file", k.path)
+	}
+	seed, err := aead.Open(nil, nonce, ciphertext, publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock %s: wrong passphrase", k.path)
+	}
+	privateKey, err := keySeed(seed)
+	if err != nil {
+		return nil, err
+	}
+	if !privateKey.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(publicKey)) {
+		return nil, fmt.Errorf("%s holds a key that does not match its public key", k.path)
+	}
+	return privateKey, nil
+}
+
+func (k *keystoreFile) Store(key ed25519.PrivateKey) error {
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	aead, err := k.aead(salt, keystoreScryptN, keystoreScryptR, keystoreScryptP)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	publicKey := key.Public().(ed25519.PublicKey)
+	data, err := json.MarshalIndent(keystoreContent{
+		Version:    1,
+		PublicKey:  hex.EncodeToString(publicKey),
+		KDF:        "scrypt",
+		N:          keystoreScryptN,
+		R:          keystoreScryptR,
+		P:          keystoreScryptP,
+		Salt:       hex.EncodeToString(salt),
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(aead.Seal(nil, nonce, key.Seed(), publicKey)),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(k.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(k.path)
+		return err
+	}
+	return f.Close()
+}
+
+// cipher derives the key of a keystore file from the passphrase.
+func (k *keystoreFile) aead(salt []byte, n, r, p int) (cipher.AEAD, error) {
+	if k.passphrase == "" {
+		return nil, fmt.Errorf("set %s to the passphrase of the keystore file %s", KeyPassphraseEnv, k.path)
+	}
+	key, err := scrypt.Key([]byte(k.passphrase), salt, n, r, p, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(key)
+}
+
+// ImportIdentity stores an existing identity key in a source. Importing the key the source
+// already holds does nothing; a source holding another key is left as it is.
+func ImportIdentity(source KeySource, key ed25519.PrivateKey) error {
+	stored, err := source.Load()
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		return source.Store(key)
+	case err != nil:
+		return err
+	case !stored.Equal(key):
+		return errors.New("the key store already holds another identity key")
+	}
+	return nil
+}
+
+// Secrets are never passed to the commands of key sources as arguments, which any user can
+// read in the process list: they go through stdin or the environment of the command.
+
+// runKeyCommand runs a command of a key source with stdin as its input and env added to its
+// environment, and returns its output. It is a variable so tests can stand in for the tools of
+// the OS.
+var runKeyCommand = func(stdin []byte, env []string, name string, args ...string) (stdout, stderr []byte, err error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	var out, errOut bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errOut
+	err = cmd.Run()
+	return out.Bytes(), errOut.Bytes(), err
+}
+
+// commandError describes a failed command of a key source with what it wrote to stderr.
+func commandError(name string, stderr []byte, err error) error {
+	if detail := strings.TrimSpace(string(stderr)); detail != "" {
+		return fmt.Errorf("%s failed: %s", name, detail)
+	}
+	return fmt.Errorf("%s failed: %w", name, err)
+}
+
+// keychainOS is the OS whose keychain is used, a variable so tests can use either tool.
+var keychainOS = runtime.GOOS
+
+// keychain is an identity key kept in the OS keychain, hex encoded, through the security tool
+// on macOS and secret-tool (libsecret) on Linux.
+type keychain struct {
+	service string
+	account string
+}
+
+func (k *keychain) Load() (ed25519.PrivateKey, error) {
+	var stdout, stderr []byte
+	var err error
+	switch keychainOS {
+	case "darwin":
+		stdout, stderr, err = runKeyCommand(nil, nil, "security", "find-generic-password", "-s", k.service, "-a", k.account, "-w")
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && exit.ExitCode() == 44 {
+			return nil, ErrKeyNotFound
+		}
+	case "linux":
+		stdout, stderr, err = runKeyCommand(nil, nil, "secret-tool", "lookup", "service", k.service, "account", k.account)
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && len(stdout) == 0 && len(stderr) == 0 {
+			return nil, ErrKeyNotFound
+		}
+	default:
+		return nil, fmt.Errorf("the OS keychain is not supported on %s", keychainOS)
+	}
+	if err != nil {
+		return nil, commandError("keychain lookup", stderr, err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(stdout)))
+	if err != nil {
+		return nil, fmt.Errorf("keychain entry %s of %s is not an identity key", k.service, k.account)
+	}
+	return keySeed(seed)
+}
+
+func (k *keychain) Store(key ed25519.PrivateKey) error {
+	secret := hex.EncodeToString(key.Seed())
+	var stderr []byte
+	var err error
+	switch keychainOS {
+	case "darwin":
+		// security only takes the secret as an argument, or from a terminal, so the command is
+		// given to its interactive mode on stdin
+		for _, value := range []string{k.service, k.account} {
+			if strings.ContainsAny(value, "\"\\\n") {
+				return fmt.Errorf("keychain service and account cannot contain quotes, backslashes or newlines: %q", value)
+			}
+		}
+		command := fmt.Sprintf("add-generic-password -s \"%s\" -a \"%s\" -l \"%s identity key\" -w %s\n", k.service, k.account, k.service, secret)
+		_, stderr, err = runKeyCommand([]byte(command), nil, "security", "-i")
+		// The interactive mode reports failed commands on stderr only
+		if err == nil && len(bytes.TrimSpace(stderr)) > 0 {
+			err = errors.New("add-generic-password failed")
+		}
+	case "linux":
+		_, stderr, err = runKeyCommand([]byte(secret), nil, "secret-tool", "store", "--label="+k.service+" identity key", "service", k.service, "account", k.account)
+	default:
+		return fmt.Errorf("the OS keychain is not supported on %s", keychainOS)
+	}
+	if err != nil {
+		return commandError("keychain store", stderr, err)
+	}
+	return nil
+}
+
+// pkcs11Token is an identity key kept as a private data object on a PKCS#11 token, such as a
+// smart card or an HSM, through pkcs11-tool (OpenSC). The object is only readable after logging
+// in with the PIN of the token, which pkcs11-tool reads from its environment (--pin env:VAR).
+type pkcs11Token struct {
+	module string
+	label  string
+	pin    string
+}
+
+// args returns the arguments of pkcs11-tool to log in to the token.
+func (p *pkcs11Token) args(args ...string) ([]string, error) {
+	if p.pin == "" {
+		return nil, fmt.Errorf("set %s to the PIN of the PKCS#11 token", KeyPassphraseEnv)
+	}
+	return append([]string{"--module", p.module, "--login", "--pin", "env:" + KeyPassphraseEnv}, args...), nil
+}
+
+// env returns the environment pkcs11-tool reads the PIN from.
+func (p *pkcs11Token) env() []string {
+	return []string{KeyPassphraseEnv + "=" + p.pin}
+}
+
+func (p *pkcs11Token) Load() (ed25519.PrivateKey, error) {
+	args, err := p.args("--list-objects", "--type", "data")
+	if err != nil {
+		return nil, err
+	}
+	stdout, stderr, err := runKeyCommand(nil, p.env(), "pkcs11-tool", args...)
+	if err != nil {
+		return nil, commandError("pkcs11-tool", stderr, err)
+	}
+	if !bytes.Contains(stdout, []byte("'"+p.label+"'")) {
+		return nil, ErrKeyNotFound
+	}
+
+	args, _ = p.args("--read-object", "--type", "data", "--label", p.label)
+	stdout, stderr, err = runKeyCommand(nil, p.env(), "pkcs11-tool", args...)
+	if err != nil {
+		return nil, commandError("pkcs11-tool", stderr, err)
+	}
+	return keySeed(stdout)
+}
+
+func (p *pkcs11Token) Store(key ed25519.PrivateKey) error {
+	args, err := p.args("--write-object", "/dev/stdin", "--type", "data", "--label", p.label, "--private")
+	if err != nil {
+		return err
+	}
+	if _, stderr, err := runKeyCommand(key.Seed(), p.env(), "pkcs11-tool", args...); err != nil {
+		return commandError("pkcs11-tool", stderr, err)
+	}
+	return nil
+}
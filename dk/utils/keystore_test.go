@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// exitError returns the error of a command that exited with a code.
+func exitError(code int) error {
+	return exec.Command("sh", "-c", fmt.Sprintf("exit %d", code)).Run()
+}
+
+// fakeKeyTools stands in for security, secret-tool and pkcs11-tool, keeping one secret, and
+// records the arguments and environment of every command run.
+type fakeKeyTools struct {
+	secret []byte // Hex for the keychains, raw for the token
+	pin    string
+	args   [][]string
+	env    []string
+}
+
+func (f *fakeKeyTools) run(stdin []byte, env []string, name string, args ...string) ([]byte, []byte, error) {
+	f.args = append(f.args, append([]string{name}, args...))
+	f.env = append(f.env, env...)
+	switch {
+	case name == "security" && slices.Equal(args, []string{"-i"}):
+		match := regexp.MustCompile(`-w (\S+)\n$`).FindSubmatch(stdin)
+		if match == nil || !bytes.HasPrefix(stdin, []byte("add-generic-password ")) {
+			return nil, []byte("unknown command"), nil
+		}
+		f.secret = match[1]
+	case name == "security" && args[0] == "find-generic-password":
+		if f.secret == nil {
+			return nil, []byte("The specified item could not be found in the keychain."), exitError(44)
+		}
+		return append(f.secret, '\n'), nil, nil
+	case name == "secret-tool" && args[0] == "store":
+		f.secret = stdin
+	case name == "secret-tool" && args[0] == "lookup":
+		if f.secret == nil {
+			return nil, nil, exitError(1)
+		}
+		return f.secret, nil, nil
+	case name == "pkcs11-tool":
+		if !slices.Contains(env, KeyPassphraseEnv+"="+f.pin) {
+			return nil, []byte("error: PKCS11 function C_Login failed: CKR_PIN_INCORRECT"), exitError(1)
+		}
+		switch {
+		case slices.Contains(args, "--write-object"):
+			f.secret = stdin
+		case slices.Contains(args, "--list-objects"):
+			if f.secret != nil {
+				return []byte("Data object 1\n  label:          'dk-identity-alice'\n"), nil, nil
+			}
+		case slices.Contains(args, "--read-object"):
+			return f.secret, nil, nil
+		}
+	default:
+		return nil, []byte("unexpected command"), exitError(1)
+	}
+	return nil, nil, nil
+}
+
+// useFakeKeyTools replaces the tools of the key sources for the duration of a test.
+func useFakeKeyTools(t *testing.T, goos string) *fakeKeyTools {
+	t.Helper()
+	tools := &fakeKeyTools{pin: "1234"}
+	previousRun, previousOS := runKeyCommand, keychainOS
+	runKeyCommand, keychainOS = tools.run, goos
+	t.Cleanup(func() { runKeyCommand, keychainOS = previousRun, previousOS })
+	return tools
+}
+
+// checkImportShow imports a key in a source, and checks it is shown, imported again without
+// change, and not replaced by another key.
+func checkImportShow(t *testing.T, source KeySource) ed25519.PrivateKey {
+	t.Helper()
+	if _, err := source.Load(); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("Expected an empty source, got %v", err)
+	}
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	if err := ImportIdentity(source, key); err != nil {
+		t.Fatalf("ImportIdentity failed: %v", err)
+	}
+	if shown, err := source.Load(); err != nil || !shown.Equal(key) {
+		t.Fatalf("Expected the imported key, got %v", err)
+	}
+	if err := ImportIdentity(source, key); err != nil {
+		t.Errorf("Expected the same key to be imported again, got %v", err)
+	}
+	_, other, _ := ed25519.GenerateKey(rand.Reader)
+	if err := ImportIdentity(source, other); err == nil {
+		t.Error("Expected another key not to replace the stored one")
+	}
+	return key
+}
+
+// checkNoSecretArgs fails if a secret appears in the arguments of a command.
+func checkNoSecretArgs(t *testing.T, tools *fakeKeyTools, secrets ...string) {
+	t.Helper()
+	for _, args := range tools.args {
+		for _, secret := range secrets {
+			if strings.Contains(strings.Join(args, " "), secret) {
+				t.Errorf("Expected no secret in the arguments of %s, got %q", args[0], args)
+			}
+		}
+	}
+}
+
+func TestKeychain(t *testing.T) {
+	for _, goos := range []string{"darwin", "linux"} {
+		t.Run(goos, func(t *testing.T) {
+			tools := useFakeKeyTools(t, goos)
+			source, err := ParseKeySource("keychain", "alice")
+			if err != nil {
+				t.Fatal(err)
+			}
+			key := checkImportShow(t, source)
+			checkNoSecretArgs(t, tools, hex.EncodeToString(key.Seed()))
+		})
+	}
+
+	useFakeKeyTools(t, "darwin")
+	source, _ := ParseKeySource(`keychain:dk" -w 00`, "alice")
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	if err := source.Store(key); err == nil {
+		t.Error("Expected a service with quotes to be refused")
+	}
+	useFakeKeyTools(t, "plan9")
+	if _, err := source.Load(); err == nil || errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected an unsupported OS to fail, got %v", err)
+	}
+}
+
+func TestPKCS11Token(t *testing.T) {
+	tools := useFakeKeyTools(t, "linux")
+	t.Setenv(KeyPassphraseEnv, tools.pin)
+	source, err := ParseKeySource("pkcs11:/usr/lib/opensc-pkcs11.so", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := checkImportShow(t, source)
+	checkNoSecretArgs(t, tools, tools.pin, hex.EncodeToString(key.Seed()))
+	if !slices.Contains(tools.args[0], "env:"+KeyPassphraseEnv) {
+		t.Errorf("Expected the PIN to be read from the environment, got %q", tools.args[0])
+	}
+
+	t.Setenv(KeyPassphraseEnv, "0000")
+	wrongPIN, _ := ParseKeySource("pkcs11:/usr/lib/opensc-pkcs11.so", "alice")
+	if _, err := wrongPIN.Load(); err == nil || !strings.Contains(err.Error(), "PIN_INCORRECT") {
+		t.Errorf("Expected a wrong PIN to fail, got %v", err)
+	}
+	t.Setenv(KeyPassphraseEnv, "")
+	noPIN, _ := ParseKeySource("pkcs11:/usr/lib/opensc-pkcs11.so", "alice")
+	if _, err := noPIN.Load(); err == nil {
+		t.Error("Expected a token without a PIN to fail")
+	}
+}
+
+func TestKeystoreFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.json")
+	t.Setenv(KeyPassphraseEnv, "correct horse")
+	source, err := ParseKeySource("keystore:"+path, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, key, err := LoadOrCreateIdentity(source)
+	if err != nil {
+		t.Fatalf("LoadOrCreateIdentity failed: %v", err)
+	}
+	if loaded, _, err := LoadOrCreateIdentity(source); err != nil || !loaded.Equal(publicKey) {
+		t.Fatalf("Expected the stored key, got %v", err)
+	}
+	if err := source.Store(key); err == nil {
+		t.Error("Expected an existing keystore file not to be replaced")
+	}
+
+	t.Setenv(KeyPassphraseEnv, "wrong")
+	wrong, _ := ParseKeySource("keystore:"+path, "alice")
+	if _, err := wrong.Load(); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("Expected a wrong passphrase to fail, got %v", err)
+	}
+	if _, err := ParseKeySource("vault:secret", "alice"); err == nil {
+		t.Error("Expected an unknown key store to be refused")
+	}
+}
@@ -0,0 +1,250 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// PayloadVersion is the version of the payloads this node sends. Payloads without a version,
+// from older nodes, are version 1. Payloads of a later version are refused, since their
+// fields may mean something else.
+const PayloadVersion = 1
+
+// MessageTypeAttachment is the type of the chunks of a file sent to a peer.
+const MessageTypeAttachment = "attachment"
+
+// Metadata keys of attachment chunks on the wire
+const (
+	attachmentIDKey     = "attachment_id"
+	attachmentIndexKey  = "index"
+	attachmentCountKey  = "count"
+	attachmentSizeKey   = "size"
+	attachmentSHA256Key = "sha256"
+	attachmentMIMEKey   = "mime_type"
+)
+
+var (
+	// ErrUnknownPayload is returned when decoding a payload of a type that is not registered.
+	ErrUnknownPayload = errors.New("unknown payload type")
+	// ErrUnsupportedPayloadVersion is returned when decoding a payload of a later version.
+	ErrUnsupportedPayloadVersion = errors.New("unsupported payload version")
+)
+
+// Payload is the typed content of a message between peers. It travels as a RemoteMessage of
+// its MessageType, encoded and decoded by the codec registered for that type.
+type Payload interface {
+	MessageType() string
+}
+
+// QueryPayload is a question asked to peers.
+type QueryPayload struct {
+	Question string
+	Metadata map[string]string // E.g. the API the question is asked through
+}
+
+// AnswerPayload is the answer of a peer to a question.
+type AnswerPayload struct {
+	Query  string
+	Answer string
+	From   string
+}
+
+// AppSubmissionPayload is an app sent to a peer for approval, with its files by path.
+type AppSubmissionPayload struct {
+	Description string
+	Files       map[string]string
+}
+
+// FileChunkPayload is a chunk of a file sent to a peer, with the description of the file.
+type FileChunkPayload struct {
+	AttachmentID string
+	Index        int
+	Count        int
+	Size         int // Of the whole file
+	SHA256       string
+	MIMEType     string
+	FileName     string
+	Note         string
+	Data         []byte
+}
+
+func (*QueryPayload) MessageType() string         { return MessageTypeQuery }
+func (*AnswerPayload) MessageType() string        { return MessageTypeAnswer }
+func (*AppSubmissionPayload) MessageType() string { return MessageTypeApp }
+func (*FileChunkPayload) MessageType() string     { return MessageTypeAttachment }
+
+// PayloadCodec converts the payloads of a message type to and from their RemoteMessage form.
+type PayloadCodec struct {
+	Encode func(Payload) (RemoteMessage, error)
+	Decode func(RemoteMessage) (Payload, error)
+}
+
+var (
+	payloadCodecs   = make(map[string]PayloadCodec)
+	payloadCodecsMu sync.RWMutex
+)
+
+// RegisterPayload registers the codec of a message type, replacing any previous one.
+func RegisterPayload(messageType string, codec PayloadCodec) {
+	payloadCodecsMu.Lock()
+	defer payloadCodecsMu.Unlock()
+	payloadCodecs[messageType] = codec
+}
+
+func payloadCodec(messageType string) (PayloadCodec, bool) {
+	payloadCodecsMu.RLock()
+	defer payloadCodecsMu.RUnlock()
+	codec, ok := payloadCodecs[messageType]
+	return codec, ok
+}
+
+// EncodePayload returns the message content carrying a payload.
+func EncodePayload(payload Payload) (string, error) {
+	codec, ok := payloadCodec(payload.MessageType())
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownPayload, payload.MessageType())
+	}
+	message, err := codec.Encode(payload)
+	if err != nil {
+		return "", err
+	}
+	message.Type = payload.MessageType()
+	message.Version = PayloadVersion
+	content, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// DecodeEnvelope parses the RemoteMessage of a message content and checks its version.
+func DecodeEnvelope(content string) (RemoteMessage, error) {
+	var message RemoteMessage
+	if err := json.Unmarshal([]byte(content), &message); err != nil {
+		return message, fmt.Errorf("invalid message content: %w", err)
+	}
+	if message.Version > PayloadVersion {
+		return message, fmt.Errorf("%w: %s version %d", ErrUnsupportedPayloadVersion, message.Type, message.Version)
+	}
+	return message, nil
+}
+
+// DecodePayload returns the payload carried by a message content.
+func DecodePayload(content string) (Payload, error) {
+	message, err := DecodeEnvelope(content)
+	if err != nil {
+		return nil, err
+	}
+	codec, ok := payloadCodec(message.Type)
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPayload, message.Type)
+	}
+	return codec.Decode(message)
+}
+
+// DecodePayloadAs returns the payload carried by a message content, which must be of type T.
+func DecodePayloadAs[T Payload](content string) (T, error) {
+	var zero T
+	payload, err := DecodePayload(content)
+	if err != nil {
+		return zero, err
+	}
+	typed, ok := payload.(T)
+	if !ok {
+		return zero, fmt.Errorf("unexpected %s payload, expected %s", payload.MessageType(), zero.MessageType())
+	}
+	return typed, nil
+}
+
+func init() {
+	RegisterPayload(MessageTypeQuery, PayloadCodec{
+		Encode: func(p Payload) (RemoteMessage, error) {
+			query := p.(*QueryPayload)
+			return RemoteMessage{Message: query.Question, Metadata: query.Metadata}, nil
+		},
+		Decode: func(m RemoteMessage) (Payload, error) {
+			if m.Message == "" {
+				return nil, errors.New("query without a question")
+			}
+			return &QueryPayload{Question: m.Message, Metadata: m.Metadata}, nil
+		},
+	})
+
+	// Answers carry an AnswerMessage encoded in Message
+	RegisterPayload(MessageTypeAnswer, PayloadCodec{
+		Encode: func(p Payload) (RemoteMessage, error) {
+			answer := p.(*AnswerPayload)
+			body, err := json.Marshal(AnswerMessage{Query: answer.Query, Answer: answer.Answer, From: answer.From})
+			return RemoteMessage{Message: string(body)}, err
+		},
+		Decode: func(m RemoteMessage) (Payload, error) {
+			var answer AnswerMessage
+			if err := json.Unmarshal([]byte(m.Message), &answer); err != nil {
+				return nil, fmt.Errorf("invalid answer payload: %w", err)
+			}
+			return &AnswerPayload{Query: answer.Query, Answer: answer.Answer, From: answer.From}, nil
+		},
+	})
+
+	RegisterPayload(MessageTypeApp, PayloadCodec{
+		Encode: func(p Payload) (RemoteMessage, error) {
+			app := p.(*AppSubmissionPayload)
+			return RemoteMessage{Message: app.Description, Files: app.Files}, nil
+		},
+		Decode: func(m RemoteMessage) (Payload, error) {
+			if len(m.Files) == 0 {
+				return nil, errors.New("app submission without files")
+			}
+			return &AppSubmissionPayload{Description: m.Message, Files: m.Files}, nil
+		},
+	})
+
+	// Chunks carry their data in base64 in Content, and the description of the file in
+	// Metadata
+	RegisterPayload(MessageTypeAttachment, PayloadCodec{
+		Encode: func(p Payload) (RemoteMessage, error) {
+			chunk := p.(*FileChunkPayload)
+			return RemoteMessage{
+				Message:  chunk.Note,
+				Filename: chunk.FileName,
+				Content:  base64.StdEncoding.EncodeToString(chunk.Data),
+				Metadata: map[string]string{
+					attachmentIDKey:     chunk.AttachmentID,
+					attachmentIndexKey:  strconv.Itoa(chunk.Index),
+					attachmentCountKey:  strconv.Itoa(chunk.Count),
+					attachmentSizeKey:   strconv.Itoa(chunk.Size),
+					attachmentSHA256Key: chunk.SHA256,
+					attachmentMIMEKey:   chunk.MIMEType,
+				},
+			}, nil
+		},
+		Decode: func(m RemoteMessage) (Payload, error) {
+			meta := m.Metadata
+			index, indexErr := strconv.Atoi(meta[attachmentIndexKey])
+			count, countErr := strconv.Atoi(meta[attachmentCountKey])
+			size, sizeErr := strconv.Atoi(meta[attachmentSizeKey])
+			if meta[attachmentIDKey] == "" || indexErr != nil || countErr != nil || sizeErr != nil {
+				return nil, errors.New("attachment chunk without a valid description")
+			}
+			data, err := base64.StdEncoding.DecodeString(m.Content)
+			if err != nil {
+				return nil, fmt.Errorf("attachment %s has an invalid chunk", meta[attachmentIDKey])
+			}
+			return &FileChunkPayload{
+				AttachmentID: meta[attachmentIDKey],
+				Index:        index,
+				Count:        count,
+				Size:         size,
+				SHA256:       meta[attachmentSHA256Key],
+				MIMEType:     meta[attachmentMIMEKey],
+				FileName:     m.Filename,
+				Note:         m.Message,
+				Data:         data,
+			}, nil
+		},
+	})
+}
@@ -22,20 +22,41 @@ import (
 )
 
 type Parameters struct {
-	PrivateKeyPath  *string
-	PublicKeyPath   *string
-	UserID          *string
-	VectorDBPath    *string
-	RagSourcesFile  *string
-	ModelConfigFile *string
-	ServerURL       *string
-	HTTPPort        *string
-	SyftboxConfig   *string
-	DBPath          *string
+	PrivateKeyPath    *string
+	PublicKeyPath     *string
+	KeyStore          *string // Source of the identity key instead of the key files, see ParseKeySource
+	UserID            *string
+	VectorDBPath      *string
+	RagSourcesFile    *string
+	ModelConfigFile   *string
+	ServerURL         *string
+	HTTPPort          *string
+	SyftboxConfig     *string
+	DBPath            *string
+	ConsistencyRepair *bool
+	DocumentAccess    *bool // Restricts answers to peers to the documents of their APIs
+	DocumentsDir      *string
+	WatchInterval     *time.Duration
+	WarmInterval      *time.Duration
+	HTTPToken         *string // Required for host access to the HTTP API when set
+	MCPToken          *string // Restricts the stdio MCP session to the role of this access token
+	MCPPort           *string // Serves MCP over SSE on this port as well when set
+	MCPLanguage       *string // Default language of MCP tool descriptions and messages
+	MCPToolPolicy     *string // JSON file enabling, disabling or requiring confirmation for MCP tools
+	MCPPlugins        *string // Directory of plugin manifests adding MCP tools
+	PartnerServers    *string // Comma-separated name=url servers of partner networks to join as well
+	WSCompression     *bool   // Offer permessage-deflate compression on the websocket connection
+	TLSCACert         *string // PEM file of CA certificates trusted for the server
+	TLSPins           *string // Comma-separated SHA-256 fingerprints of pinned server public keys
+	TLSInsecure       *bool   // Skip the verification of the server certificate
+	Proxy             *string // Proxy to reach the server through, instead of the one of the environment
 }
 
+// RemoteMessage is the content of a message between peers. The payloads registered with
+// RegisterPayload are encoded to and decoded from it, see EncodePayload.
 type RemoteMessage struct {
 	Type     string            `json:"type"`
+	Version  int               `json:"version,omitempty"` // PayloadVersion; 0 from older nodes is 1
 	Message  string            `json:"message,omitempty"`
 	Files    map[string]string `json:"files,omitempty"`
 	Filename string            `json:"filename,omitempty"`
@@ -327,13 +348,13 @@ func RegisterAPI(ctx context.Context, api APIInfo) error {
 		return fmt.Errorf("RegisterAPI: server URL is not configured")
 	}
 
-	url := fmt.Sprintf("%s/user/apis", *params.ServerURL)
-
 	// Get the client from context
 	dk, err := DkFromContext(ctx)
 	if err != nil {
 		return fmt.Errorf("RegisterAPI: failed to get DK client from context: %w", err)
 	}
+	// The client knows which of the configured servers it is connected to
+	url := fmt.Sprintf("%s/user/apis", dk.ServerURL())
 
 	// Access the JWT token directly from the client
 	token := dk.Token()
@@ -391,13 +412,13 @@ func RegisterTracker(ctx context.Context, tracker TrackerInfo) error {
 		return fmt.Errorf("RegisterTracker: server URL is not configured")
 	}
 
-	url := fmt.Sprintf("%s/user/trackers", *params.ServerURL)
-
 	// Get the client from context
 	dk, err := DkFromContext(ctx)
 	if err != nil {
 		return fmt.Errorf("RegisterTracker: failed to get DK client from context: %w", err)
 	}
+	// The client knows which of the configured servers it is connected to
+	url := fmt.Sprintf("%s/user/trackers", dk.ServerURL())
 
 	// Access the JWT token directly from the client
 	token := dk.Token()
@@ -466,13 +487,13 @@ func RegisterTrackerList(ctx context.Context, trackerList TrackerListPayload) er
 		return fmt.Errorf("RegisterTrackerList: server URL is not configured")
 	}
 
-	url := fmt.Sprintf("%s/user/trackers", *params.ServerURL)
-
 	// Get the client from context
 	dk, err := DkFromContext(ctx)
 	if err != nil {
 		return fmt.Errorf("RegisterTrackerList: failed to get DK client from context: %w", err)
 	}
+	// The client knows which of the configured servers it is connected to
+	url := fmt.Sprintf("%s/user/trackers", dk.ServerURL())
 
 	// Access the JWT token directly from the client
 	token := dk.Token()
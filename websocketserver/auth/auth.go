@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"strings"
@@ -24,6 +25,12 @@ type Service struct {
 	jwtSecret []byte
 	// challenges stores temporary challenges for users.
 	challenges sync.Map // map[user_id]challenge string
+
+	// Registration policy, see ConfigureRegistration.
+	registrationMode    string
+	registrationLimiter *registrationLimiter
+	trustedProxies      []*net.IPNet // Whose X-Forwarded-For header is trusted, see SetTrustedProxies
+	adminUserIDs        map[string]bool
 }
 
 // NewService creates a new authentication service instance.
@@ -43,8 +50,9 @@ func NewService(db *sql.DB) *Service {
 	}
 
 	return &Service{
-		db:        db,
-		jwtSecret: secret,
+		db:               db,
+		jwtSecret:        secret,
+		registrationMode: RegistrationModeOpen,
 	}
 }
 
@@ -53,6 +61,8 @@ type RegistrationPayload struct {
 	UserID    string `json:"user_id"`
 	Username  string `json:"username"`
 	PublicKey string `json:"public_key"` // base64-encoded public key
+	// InvitationCode is required when the server is in invite-only mode.
+	InvitationCode string `json:"invitation_code,omitempty"`
 }
 
 func (s *Service) HandleCheckUserID(w http.ResponseWriter, r *http.Request) {
@@ -110,19 +120,67 @@ func (a *Service) HandleRegistration(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+
+	clientIP := a.registrationIP(r)
+	if a.registrationLimiter != nil && !a.registrationLimiter.Allow(clientIP) {
+		http.Error(w, "Too many registrations, try again later", http.StatusTooManyRequests)
+		return
+	}
+	if a.inviteOnly() && payload.InvitationCode == "" {
+		http.Error(w, "An invitation code is required to register", http.StatusForbidden)
+		return
+	}
+
+	tx, err := a.db.Begin()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Registration error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
 	// Insert the new user into the database.
 	query := `INSERT INTO users (user_id, username, public_key) VALUES (?, ?, ?)`
-	_, err = a.db.Exec(query, payload.UserID, payload.Username, payload.PublicKey)
+	_, err = tx.Exec(query, payload.UserID, payload.Username, payload.PublicKey)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Registration error: %v", err), http.StatusInternalServerError)
 		return
 	}
+
+	// Codes are also tracked in open mode when one is supplied.
+	if payload.InvitationCode != "" {
+		if err := redeemInvitationCode(tx, payload.InvitationCode, payload.UserID, clientIP); err != nil {
+			if err == ErrInvalidInvitation {
+				NewLogger().LogAuthEvent(SecurityEvent{
+					Timestamp: time.Now(),
+					Event:     EventRegistration,
+					UserID:    payload.UserID,
+					IP:        clientIP,
+					Details:   "registration with invalid invitation code",
+				})
+				http.Error(w, err.Error(), http.StatusForbidden)
+			} else {
+				http.Error(w, fmt.Sprintf("Registration error: %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		http.Error(w, fmt.Sprintf("Registration error: %v", err), http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusCreated)
 	w.Write([]byte("User registered successfully"))
 }
 
 // HandleGetUserInfo retrieves a user's public key and other information
 func (a *Service) HandleGetUserInfo(w http.ResponseWriter, r *http.Request) {
+	// The keys of a user are served under /auth/users/{user_id}/keys
+	if userID, rest, found := strings.Cut(strings.TrimPrefix(r.URL.Path, "/auth/users/"), "/"); found {
+		a.handleUserKeys(w, r, userID, rest)
+		return
+	}
+
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
@@ -210,8 +268,8 @@ func (a *Service) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	challenge := base64.StdEncoding.EncodeToString(challengeBytes)
 	a.challenges.Store(payload.UserID, challenge)
 
-	// Return the challenge to the client.
-	resp := map[string]string{"challenge": challenge}
+	// Return the challenge to the client, with the server time for clock skew estimation.
+	resp := map[string]string{"challenge": challenge, "server_time": serverTime()}
 	jsonResp, _ := json.Marshal(resp)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonResp)
@@ -247,6 +305,13 @@ func (a *Service) handleChallengeResponse(w http.ResponseWriter, r *http.Request
 	query := "SELECT public_key FROM users WHERE user_id = ?"
 	err = a.db.QueryRow(query, payload.UserID).Scan(&publicKeyStr)
 	if err != nil {
+		NewLogger().LogAuthEvent(SecurityEvent{
+			Timestamp: time.Now(),
+			Event:     EventLogin,
+			UserID:    payload.UserID,
+			IP:        GetClientIP(r),
+			Details:   "login for unknown user",
+		})
 		http.Error(w, "User not found", http.StatusUnauthorized)
 		return
 	}
@@ -265,6 +330,13 @@ func (a *Service) handleChallengeResponse(w http.ResponseWriter, r *http.Request
 
 	// Verify the signature using ed25519.
 	if !ed25519.Verify(pubKeyBytes, []byte(challenge), signatureBytes) {
+		NewLogger().LogAuthEvent(SecurityEvent{
+			Timestamp: time.Now(),
+			Event:     EventLogin,
+			UserID:    payload.UserID,
+			IP:        GetClientIP(r),
+			Details:   "invalid challenge signature",
+		})
 		http.Error(w, "Authentication failed", http.StatusUnauthorized)
 		return
 	}
@@ -280,12 +352,18 @@ func (a *Service) handleChallengeResponse(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	resp := map[string]string{"token": tokenString}
+	resp := map[string]string{"token": tokenString, "server_time": serverTime()}
 	jsonResp, _ := json.Marshal(resp)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonResp)
 }
 
+// serverTime returns the current time of the server in UTC, as included in login responses
+// so that clients can estimate the skew of their clocks
+func serverTime() string {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
 // TokenVerifyResult holds the result of token verification with detailed information
 type TokenVerifyResult struct {
 	Valid  bool
@@ -0,0 +1,399 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"websocketserver/models"
+)
+
+// Registration modes
+const (
+	RegistrationModeOpen       = "open"
+	RegistrationModeInviteOnly = "invite-only"
+)
+
+// ErrInvalidInvitation is returned when an invitation code is unknown, revoked, expired or used up.
+var ErrInvalidInvitation = errors.New("invalid or exhausted invitation code")
+
+// registrationLimiter limits the number of registrations per client IP within a fixed window.
+type registrationLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	windows   map[string]*registrationWindow
+	lastSweep time.Time
+}
+
+type registrationWindow struct {
+	start time.Time
+	count int
+}
+
+func newRegistrationLimiter(limit int, window time.Duration) *registrationLimiter {
+	return &registrationLimiter{
+		limit:   limit,
+		window:  window,
+		windows: make(map[string]*registrationWindow),
+	}
+}
+
+// Allow returns true if another registration from ip is allowed in the current window.
+func (rl *registrationLimiter) Allow(ip string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	// Windows that ended are forgotten once per window, so the map only holds recent clients
+	if now.Sub(rl.lastSweep) >= rl.window {
+		for key, w := range rl.windows {
+			if now.Sub(w.start) >= rl.window {
+				delete(rl.windows, key)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	w, exists := rl.windows[ip]
+	if !exists || now.Sub(w.start) >= rl.window {
+		rl.windows[ip] = &registrationWindow{start: now, count: 1}
+		return true
+	}
+	if w.count >= rl.limit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+// ConfigureRegistration sets the registration mode, the per-IP registration rate limit
+// (registrations per hour, 0 disables it) and the users allowed to manage invitation codes.
+func (s *Service) ConfigureRegistration(mode string, ratePerHour int, adminUserIDs []string) error {
+	if mode != RegistrationModeOpen && mode != RegistrationModeInviteOnly {
+		return fmt.Errorf("unknown registration mode %q", mode)
+	}
+	s.registrationMode = mode
+
+	s.registrationLimiter = nil
+	if ratePerHour > 0 {
+		s.registrationLimiter = newRegistrationLimiter(ratePerHour, time.Hour)
+	}
+
+	s.adminUserIDs = make(map[string]bool, len(adminUserIDs))
+	for _, id := range adminUserIDs {
+		s.adminUserIDs[id] = true
+	}
+	return nil
+}
+
+// SetTrustedProxies sets the reverse proxies, as IP addresses or CIDR ranges, whose
+// X-Forwarded-For header gives the client IP registrations are limited by. The header of other
+// peers is ignored, since clients can set it to anything.
+func (s *Service) SetTrustedProxies(proxies []string) error {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %v", proxy, err)
+		}
+		networks = append(networks, network)
+	}
+	s.trustedProxies = networks
+	return nil
+}
+
+// registrationIP returns the IP address of the client of a registration: the address of the
+// peer, without its port, or, when the peer is a trusted proxy, the nearest address in
+// X-Forwarded-For that is not one.
+func (s *Service) registrationIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !s.trustedProxy(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		if !s.trustedProxy(hop) {
+			return hop
+		}
+	}
+	return host
+}
+
+// trustedProxy reports whether an address is one of the trusted proxies.
+func (s *Service) trustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range s.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// inviteOnly reports whether registration requires an invitation code.
+func (s *Service) inviteOnly() bool {
+	return s.registrationMode == RegistrationModeInviteOnly
+}
+
+// CreateInvitationCode mints a new invitation code usable maxUses times.
+func (s *Service) CreateInvitationCode(createdBy string, maxUses int, expiresAt *time.Time, note string) (*models.InvitationCode, error) {
+	if maxUses < 1 {
+		return nil, fmt.Errorf("max_uses must be at least 1")
+	}
+
+	codeBytes := make([]byte, 12)
+	if _, err := rand.Read(codeBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate invitation code: %v", err)
+	}
+
+	invitation := &models.InvitationCode{
+		Code:      base64.RawURLEncoding.EncodeToString(codeBytes),
+		CreatedBy: createdBy,
+		MaxUses:   maxUses,
+		Note:      note,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	query := `INSERT INTO invitation_codes (code, created_by, max_uses, note, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)`
+	if _, err := s.db.Exec(query, invitation.Code, invitation.CreatedBy, invitation.MaxUses, invitation.Note, invitation.ExpiresAt, invitation.CreatedAt); err != nil {
+		return nil, fmt.Errorf("failed to store invitation code: %v", err)
+	}
+	return invitation, nil
+}
+
+// ListInvitationCodes returns all invitation codes, newest first.
+func (s *Service) ListInvitationCodes() ([]models.InvitationCode, error) {
+	rows, err := s.db.Query(`SELECT code, created_by, max_uses, use_count, COALESCE(note, ''), expires_at, revoked, created_at
+		FROM invitation_codes ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invitation codes: %v", err)
+	}
+	defer rows.Close()
+
+	codes := []models.InvitationCode{}
+	for rows.Next() {
+		var invitation models.InvitationCode
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&invitation.Code, &invitation.CreatedBy, &invitation.MaxUses, &invitation.UseCount,
+			&invitation.Note, &expiresAt, &invitation.Revoked, &invitation.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan invitation code: %v", err)
+		}
+		if expiresAt.Valid {
+			invitation.ExpiresAt = &expiresAt.Time
+		}
+		codes = append(codes, invitation)
+	}
+	return codes, rows.Err()
+}
+
+// ListInvitationUses returns the registrations made with an invitation code.
+func (s *Service) ListInvitationUses(code string) ([]models.InvitationUse, error) {
+	rows, err := s.db.Query(`SELECT id, code, user_id, COALESCE(ip_address, ''), used_at
+		FROM invitation_code_uses WHERE code = ? ORDER BY used_at`, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query invitation uses: %v", err)
+	}
+	defer rows.Close()
+
+	uses := []models.InvitationUse{}
+	for rows.Next() {
+		var use models.InvitationUse
+		if err := rows.Scan(&use.ID, &use.Code, &use.UserID, &use.IPAddress, &use.UsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan invitation use: %v", err)
+		}
+		uses = append(uses, use)
+	}
+	return uses, rows.Err()
+}
+
+// RevokeInvitationCode prevents any further registrations with an invitation code.
+func (s *Service) RevokeInvitationCode(code string) error {
+	result, err := s.db.Exec(`UPDATE invitation_codes SET revoked = TRUE WHERE code = ?`, code)
+	if err != nil {
+		return fmt.Errorf("failed to revoke invitation code: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// redeemInvitationCode consumes one use of an invitation code and records who used it.
+// It must run in the same transaction as the user insert so a failed registration
+// does not burn a use.
+func redeemInvitationCode(tx *sql.Tx, code, userID, ip string) error {
+	result, err := tx.Exec(`UPDATE invitation_codes SET use_count = use_count + 1
+		WHERE code = ? AND revoked = FALSE AND use_count < max_uses
+		AND (expires_at IS NULL OR expires_at > ?)`, code, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to redeem invitation code: %v", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInvalidInvitation
+	}
+
+	if _, err := tx.Exec(`INSERT INTO invitation_code_uses (code, user_id, ip_address) VALUES (?, ?, ?)`, code, userID, ip); err != nil {
+		return fmt.Errorf("failed to record invitation use: %v", err)
+	}
+	return nil
+}
+
+// RequireAdmin verifies the bearer token of the request and checks that it belongs to an admin.
+// Otherwise it writes the error response and returns false.
+func (s *Service) RequireAdmin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+		http.Error(w, "Missing or invalid Authorization header", http.StatusUnauthorized)
+		return "", false
+	}
+
+	tokenResult := VerifyToken(strings.TrimPrefix(authHeader, "Bearer "), s, "")
+	if !tokenResult.Valid || tokenResult.Error != nil {
+		NewLogger().LogAuthEvent(SecurityEvent{
+			Timestamp: time.Now(),
+			Event:     EventTokenVerification,
+			IP:        GetClientIP(r),
+			Details:   "invalid admin token for " + r.URL.Path,
+		})
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+
+	if !s.adminUserIDs[tokenResult.UserID] {
+		NewLogger().LogAuthEvent(SecurityEvent{
+			Timestamp: time.Now(),
+			Event:     EventUnauthorizedAccess,
+			UserID:    tokenResult.UserID,
+			IP:        GetClientIP(r),
+			Details:   "non-admin attempted to access " + r.URL.Path,
+		})
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return "", false
+	}
+	return tokenResult.UserID, true
+}
+
+// CreateInvitationPayload is the expected JSON payload for minting an invitation code.
+type CreateInvitationPayload struct {
+	MaxUses       int    `json:"max_uses"`
+	ExpiresInDays int    `json:"expires_in_days,omitempty"`
+	Note          string `json:"note,omitempty"`
+}
+
+// HandleInvitations lists (GET) or mints (POST) invitation codes. Admin only.
+func (s *Service) HandleInvitations(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := s.RequireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		codes, err := s.ListInvitationCodes()
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(codes)
+
+	case http.MethodPost:
+		var payload CreateInvitationPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if payload.MaxUses == 0 {
+			payload.MaxUses = 1
+		}
+
+		var expiresAt *time.Time
+		if payload.ExpiresInDays > 0 {
+			t := time.Now().UTC().AddDate(0, 0, payload.ExpiresInDays)
+			expiresAt = &t
+		}
+
+		invitation, err := s.CreateInvitationCode(adminID, payload.MaxUses, expiresAt, payload.Note)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		log.Printf("Invitation code created by %s (max uses: %d)", adminID, invitation.MaxUses)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(invitation)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleInvitation returns the usage of (GET) or revokes (DELETE) a single invitation code.
+// The URL should be /admin/invitations/{code}. Admin only.
+func (s *Service) HandleInvitation(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := s.RequireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	code := strings.TrimPrefix(r.URL.Path, "/admin/invitations/")
+	if code == "" || strings.Contains(code, "/") {
+		http.Error(w, "Invitation code not specified in URL", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		uses, err := s.ListInvitationUses(code)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(uses)
+
+	case http.MethodDelete:
+		if err := s.RevokeInvitationCode(code); err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Invitation code not found", http.StatusNotFound)
+			} else {
+				http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			}
+			return
+		}
+		log.Printf("Invitation code revoked by %s", adminID)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
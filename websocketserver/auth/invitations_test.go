@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"websocketserver/db"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func setupInvitationTest(t *testing.T) *Service {
+	database, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	// A single connection keeps every query on the same in-memory database
+	database.SetMaxOpenConns(1)
+	t.Cleanup(func() { database.Close() })
+
+	if err := db.RunMigrations(database); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	return NewService(database)
+}
+
+func register(s *Service, userID, code string) int {
+	body, _ := json.Marshal(RegistrationPayload{
+		UserID:         userID,
+		Username:       userID,
+		PublicKey:      "cHVibGljLWtleQ==",
+		InvitationCode: code,
+	})
+	req := httptest.NewRequest(http.MethodPost, "/auth/register", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.HandleRegistration(rec, req)
+	return rec.Code
+}
+
+func TestInviteOnlyRegistration(t *testing.T) {
+	s := setupInvitationTest(t)
+	if err := s.ConfigureRegistration(RegistrationModeInviteOnly, 0, []string{"admin"}); err != nil {
+		t.Fatalf("Failed to configure registration: %v", err)
+	}
+
+	if code := register(s, "alice", ""); code != http.StatusForbidden {
+		t.Errorf("Expected 403 without invitation code, got %d", code)
+	}
+
+	invitation, err := s.CreateInvitationCode("admin", 2, nil, "team")
+	if err != nil {
+		t.Fatalf("Failed to create invitation code: %v", err)
+	}
+
+	if code := register(s, "alice", invitation.Code); code != http.StatusCreated {
+		t.Fatalf("Expected 201 with valid invitation code, got %d", code)
+	}
+
+	// A failed registration must not consume a use
+	if code := register(s, "alice", invitation.Code); code != http.StatusInternalServerError {
+		t.Errorf("Expected duplicate user registration to fail, got %d", code)
+	}
+
+	if code := register(s, "bob", invitation.Code); code != http.StatusCreated {
+		t.Fatalf("Expected 201 for second use, got %d", code)
+	}
+	if code := register(s, "carol", invitation.Code); code != http.StatusForbidden {
+		t.Errorf("Expected 403 once the code is used up, got %d", code)
+	}
+
+	uses, err := s.ListInvitationUses(invitation.Code)
+	if err != nil {
+		t.Fatalf("Failed to list invitation uses: %v", err)
+	}
+	if len(uses) != 2 || uses[0].UserID != "alice" || uses[1].UserID != "bob" {
+		t.Errorf("Unexpected invitation uses: %+v", uses)
+	}
+
+	codes, err := s.ListInvitationCodes()
+	if err != nil {
+		t.Fatalf("Failed to list invitation codes: %v", err)
+	}
+	if len(codes) != 1 || codes[0].UseCount != 2 {
+		t.Errorf("Expected one code with two uses, got %+v", codes)
+	}
+}
+
+func TestRevokedAndExpiredInvitations(t *testing.T) {
+	s := setupInvitationTest(t)
+	if err := s.ConfigureRegistration(RegistrationModeInviteOnly, 0, nil); err != nil {
+		t.Fatalf("Failed to configure registration: %v", err)
+	}
+
+	revoked, err := s.CreateInvitationCode("admin", 1, nil, "")
+	if err != nil {
+		t.Fatalf("Failed to create invitation code: %v", err)
+	}
+	if err := s.RevokeInvitationCode(revoked.Code); err != nil {
+		t.Fatalf("Failed to revoke invitation code: %v", err)
+	}
+	if code := register(s, "alice", revoked.Code); code != http.StatusForbidden {
+		t.Errorf("Expected 403 with revoked code, got %d", code)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	expired, err := s.CreateInvitationCode("admin", 1, &past, "")
+	if err != nil {
+		t.Fatalf("Failed to create invitation code: %v", err)
+	}
+	if code := register(s, "alice", expired.Code); code != http.StatusForbidden {
+		t.Errorf("Expected 403 with expired code, got %d", code)
+	}
+
+	if err := s.RevokeInvitationCode("unknown"); err != sql.ErrNoRows {
+		t.Errorf("Expected sql.ErrNoRows revoking unknown code, got %v", err)
+	}
+}
+
+func TestOpenRegistrationRateLimit(t *testing.T) {
+	s := setupInvitationTest(t)
+	if err := s.ConfigureRegistration(RegistrationModeOpen, 2, nil); err != nil {
+		t.Fatalf("Failed to configure registration: %v", err)
+	}
+
+	if code := register(s, "alice", ""); code != http.StatusCreated {
+		t.Fatalf("Expected 201 in open mode, got %d", code)
+	}
+	if code := register(s, "bob", ""); code != http.StatusCreated {
+		t.Fatalf("Expected 201 in open mode, got %d", code)
+	}
+	if code := register(s, "carol", ""); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 after exceeding the registration rate, got %d", code)
+	}
+
+	if err := s.ConfigureRegistration("closed", 0, nil); err == nil {
+		t.Error("Expected error for unknown registration mode")
+	}
+}
+
+// registerFrom registers a user from a peer address, with an X-Forwarded-For header if any.
+func registerFrom(s *Service, userID, remoteAddr, forwardedFor string) int {
+	body, _ := json.Marshal(RegistrationPayload{UserID: userID, Username: userID, PublicKey: "cHVibGljLWtleQ=="})
+	req := httptest.NewRequest(http.MethodPost, "/auth/register", bytes.NewReader(body))
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	rec := httptest.NewRecorder()
+	s.HandleRegistration(rec, req)
+	return rec.Code
+}
+
+func TestRegistrationRateLimitBypass(t *testing.T) {
+	s := setupInvitationTest(t)
+	if err := s.ConfigureRegistration(RegistrationModeOpen, 1, nil); err != nil {
+		t.Fatalf("Failed to configure registration: %v", err)
+	}
+	if code := registerFrom(s, "alice", "192.0.2.1:1234", ""); code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", code)
+	}
+	// Neither a new connection nor a forged header resets the limit
+	if code := registerFrom(s, "bob", "192.0.2.1:5678", ""); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 from another port, got %d", code)
+	}
+	if code := registerFrom(s, "carol", "192.0.2.1:5678", "198.51.100.7"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 with a forged X-Forwarded-For, got %d", code)
+	}
+}
+
+func TestRegistrationRateLimitBehindProxy(t *testing.T) {
+	s := setupInvitationTest(t)
+	if err := s.ConfigureRegistration(RegistrationModeOpen, 1, nil); err != nil {
+		t.Fatalf("Failed to configure registration: %v", err)
+	}
+	if err := s.SetTrustedProxies([]string{"10.0.0.0/8", "2001:db8::1"}); err != nil {
+		t.Fatalf("SetTrustedProxies failed: %v", err)
+	}
+
+	// Clients behind a trusted proxy are told apart by the address it forwards
+	if code := registerFrom(s, "alice", "10.0.0.1:443", "198.51.100.7, 10.0.0.2"); code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", code)
+	}
+	if code := registerFrom(s, "bob", "[2001:db8::1]:443", "203.0.113.5"); code != http.StatusCreated {
+		t.Fatalf("Expected 201 for another client, got %d", code)
+	}
+	// Addresses a client prepends to the header are not trusted
+	if code := registerFrom(s, "carol", "10.0.0.1:443", "203.0.113.99, 198.51.100.7"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 with a forged address before the forwarded one, got %d", code)
+	}
+
+	if err := s.SetTrustedProxies([]string{"proxy.example.com"}); err == nil {
+		t.Error("Expected an invalid trusted proxy to be refused")
+	}
+}
+
+func TestRegistrationLimiterEviction(t *testing.T) {
+	rl := newRegistrationLimiter(1, 10*time.Millisecond)
+	rl.Allow("192.0.2.1")
+	rl.Allow("192.0.2.2")
+	time.Sleep(20 * time.Millisecond)
+	if !rl.Allow("192.0.2.3") {
+		t.Fatal("Expected a new client to be allowed")
+	}
+	if len(rl.windows) != 1 {
+		t.Errorf("Expected the ended windows to be evicted, got %d windows", len(rl.windows))
+	}
+}
@@ -0,0 +1,266 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Errors of key rotation and revocation.
+var (
+	ErrInvalidPublicKey    = errors.New("invalid public key")
+	ErrInvalidKeySignature = errors.New("signature does not match the statement")
+	ErrKeyInUse            = errors.New("key was already used by this user")
+	ErrKeyNotFound         = errors.New("key is not a previous key of this user")
+)
+
+// RotationStatement is what a user's current key signs to name the key that replaces it.
+func RotationStatement(userID, newPublicKey string) string {
+	return "dk-key-rotation|" + userID + "|" + newPublicKey
+}
+
+// RevocationStatement is what a user's current key signs to revoke one of their previous keys.
+func RevocationStatement(userID, publicKey string) string {
+	return "dk-key-revocation|" + userID + "|" + publicKey
+}
+
+// PreviousKey is a key a user had before their current one. Messages signed by it are still
+// accepted unless it was revoked.
+type PreviousKey struct {
+	PublicKey string     `json:"public_key"` // base64-encoded
+	Successor string     `json:"successor"`  // the key that replaced it
+	Signature string     `json:"signature"`  // by this key, of the RotationStatement naming Successor
+	RetiredAt time.Time  `json:"retired_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// UserKeys is the key history of a user: the current key and the keys it replaced, latest first.
+type UserKeys struct {
+	UserID   string        `json:"user_id"`
+	Current  string        `json:"current"`
+	Previous []PreviousKey `json:"previous"`
+}
+
+// KeyRotationPayload is the expected JSON payload for rotating a key.
+type KeyRotationPayload struct {
+	PublicKey string `json:"public_key"` // base64-encoded new key
+	Signature string `json:"signature"`  // by the current key, of the RotationStatement
+	// RevokePrevious revokes the current key once replaced, e.g. when it leaked
+	RevokePrevious bool `json:"revoke_previous,omitempty"`
+}
+
+// KeyRevocationPayload is the expected JSON payload for revoking a previous key.
+type KeyRevocationPayload struct {
+	PublicKey string `json:"public_key"` // base64-encoded previous key
+	Signature string `json:"signature"`  // by the current key, of the RevocationStatement
+}
+
+// UserKeys returns the key history of a user.
+func (s *Service) UserKeys(userID string) (*UserKeys, error) {
+	keys := &UserKeys{UserID: userID, Previous: []PreviousKey{}}
+	if err := s.db.QueryRow("SELECT public_key FROM users WHERE user_id = ?", userID).Scan(&keys.Current); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`SELECT public_key, successor, signature, retired_at, revoked_at
+		FROM user_keys WHERE user_id = ? ORDER BY retired_at DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key PreviousKey
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&key.PublicKey, &key.Successor, &key.Signature, &key.RetiredAt, &revokedAt); err != nil {
+			return nil, err
+		}
+		if revokedAt.Valid {
+			key.RevokedAt = &revokedAt.Time
+		}
+		keys.Previous = append(keys.Previous, key)
+	}
+	return keys, rows.Err()
+}
+
+// verifyStatement checks a base64-encoded signature of a statement by a base64-encoded key.
+func verifyStatement(publicKey, statement, signature string) error {
+	pub, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return ErrInvalidPublicKey
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(pub, []byte(statement), sig) {
+		return ErrInvalidKeySignature
+	}
+	return nil
+}
+
+// RotateKey replaces the current key of a user with a new one, given the signature of the
+// current key naming it. The current key becomes a previous key, revoked if asked.
+func (s *Service) RotateKey(userID string, payload KeyRotationPayload) error {
+	newKey, err := base64.StdEncoding.DecodeString(payload.PublicKey)
+	if err != nil || len(newKey) != ed25519.PublicKeySize {
+		return ErrInvalidPublicKey
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current string
+	if err := tx.QueryRow("SELECT public_key FROM users WHERE user_id = ?", userID).Scan(&current); err != nil {
+		return err
+	}
+	if err := verifyStatement(current, RotationStatement(userID, payload.PublicKey), payload.Signature); err != nil {
+		return err
+	}
+	var used bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM user_keys WHERE user_id = ? AND public_key = ?)",
+		userID, payload.PublicKey).Scan(&used); err != nil {
+		return err
+	}
+	if used || payload.PublicKey == current {
+		return ErrKeyInUse
+	}
+
+	now := time.Now().UTC()
+	var revokedAt *time.Time
+	if payload.RevokePrevious {
+		revokedAt = &now
+	}
+	if _, err := tx.Exec(`INSERT INTO user_keys (user_id, public_key, successor, signature, retired_at, revoked_at)
+		VALUES (?, ?, ?, ?, ?, ?)`, userID, current, payload.PublicKey, payload.Signature, now, revokedAt); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE users SET public_key = ? WHERE user_id = ?", payload.PublicKey, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RevokeKey revokes a previous key of a user, given the signature of the current key, so
+// messages signed by it are no longer accepted.
+func (s *Service) RevokeKey(userID string, payload KeyRevocationPayload) error {
+	var current string
+	if err := s.db.QueryRow("SELECT public_key FROM users WHERE user_id = ?", userID).Scan(&current); err != nil {
+		return err
+	}
+	if err := verifyStatement(current, RevocationStatement(userID, payload.PublicKey), payload.Signature); err != nil {
+		return err
+	}
+	res, err := s.db.Exec("UPDATE user_keys SET revoked_at = COALESCE(revoked_at, ?) WHERE user_id = ? AND public_key = ?",
+		time.Now().UTC(), userID, payload.PublicKey)
+	if err != nil {
+		return err
+	}
+	if updated, _ := res.RowsAffected(); updated == 0 {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+// handleUserKeys serves the key history of a user: GET /auth/users/{user_id}/keys returns
+// it, POST /auth/users/{user_id}/keys rotates the key and POST
+// /auth/users/{user_id}/keys/revoke revokes a previous key. Changes need a token of the user.
+func (s *Service) handleUserKeys(w http.ResponseWriter, r *http.Request, userID, action string) {
+	if userID == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	switch {
+	case action == "keys" && r.Method == http.MethodGet:
+	case (action == "keys" || action == "keys/revoke") && r.Method == http.MethodPost:
+		if !s.requireUser(w, r, userID) {
+			return
+		}
+		var err error
+		if action == "keys" {
+			var payload KeyRotationPayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			err = s.RotateKey(userID, payload)
+		} else {
+			var payload KeyRevocationPayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				http.Error(w, "Invalid JSON", http.StatusBadRequest)
+				return
+			}
+			err = s.RevokeKey(userID, payload)
+		}
+		switch {
+		case errors.Is(err, ErrInvalidKeySignature):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		case errors.Is(err, ErrKeyInUse):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case errors.Is(err, ErrKeyNotFound):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case errors.Is(err, ErrInvalidPublicKey):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		NewLogger().LogAuthEvent(SecurityEvent{
+			Timestamp: time.Now(),
+			Event:     EventKeyRotation,
+			UserID:    userID,
+			IP:        GetClientIP(r),
+			Details:   "key " + strings.TrimPrefix(action, "keys/"),
+			Success:   true,
+		})
+		log.Printf("User %s changed their keys (%s)", userID, action)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keys, err := s.UserKeys(userID)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// requireUser verifies the bearer token of the request and checks that it belongs to a user.
+// Otherwise it writes the error response and returns false.
+func (s *Service) requireUser(w http.ResponseWriter, r *http.Request, userID string) bool {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+		http.Error(w, "Missing or invalid Authorization header", http.StatusUnauthorized)
+		return false
+	}
+	tokenResult := VerifyToken(strings.TrimPrefix(authHeader, "Bearer "), s, userID)
+	if !tokenResult.Valid || tokenResult.Error != nil {
+		NewLogger().LogAuthEvent(SecurityEvent{
+			Timestamp: time.Now(),
+			Event:     EventUnauthorizedAccess,
+			UserID:    userID,
+			IP:        GetClientIP(r),
+			Details:   "invalid token for " + r.URL.Path,
+		})
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
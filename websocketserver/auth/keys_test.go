@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestKeyRotation(t *testing.T) {
+	s := setupInvitationTest(t)
+	oldPub, oldPriv, _ := ed25519.GenerateKey(rand.Reader)
+	newPub, newPriv, _ := ed25519.GenerateKey(rand.Reader)
+	oldKey := base64.StdEncoding.EncodeToString(oldPub)
+	newKey := base64.StdEncoding.EncodeToString(newPub)
+	if _, err := s.db.Exec("INSERT INTO users (user_id, username, public_key) VALUES (?, ?, ?)", "alice", "alice", oldKey); err != nil {
+		t.Fatal(err)
+	}
+	token := func(userID string) string {
+		signed, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"user_id": userID,
+			"exp":     time.Now().Add(time.Hour).Unix(),
+		}).SignedString(s.jwtSecret)
+		return signed
+	}
+	sign := func(priv ed25519.PrivateKey, statement string) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, []byte(statement)))
+	}
+	call := func(method, path, userID string, payload any) (int, UserKeys) {
+		body, _ := json.Marshal(payload)
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if userID != "" {
+			req.Header.Set("Authorization", "Bearer "+token(userID))
+		}
+		rec := httptest.NewRecorder()
+		s.HandleGetUserInfo(rec, req)
+		var keys UserKeys
+		json.Unmarshal(rec.Body.Bytes(), &keys)
+		return rec.Code, keys
+	}
+
+	if code, keys := call(http.MethodGet, "/auth/users/alice/keys", "", nil); code != http.StatusOK || keys.Current != oldKey || len(keys.Previous) != 0 {
+		t.Fatalf("Unexpected keys before rotation: %d %+v", code, keys)
+	}
+
+	rotation := KeyRotationPayload{PublicKey: newKey, Signature: sign(oldPriv, RotationStatement("alice", newKey))}
+	if code, _ := call(http.MethodPost, "/auth/users/alice/keys", "mallory", rotation); code != http.StatusUnauthorized {
+		t.Errorf("Expected another user's token to be refused, got %d", code)
+	}
+	forged := KeyRotationPayload{PublicKey: newKey, Signature: sign(newPriv, RotationStatement("alice", newKey))}
+	if code, _ := call(http.MethodPost, "/auth/users/alice/keys", "alice", forged); code != http.StatusUnauthorized {
+		t.Errorf("Expected a rotation not signed by the current key to be refused, got %d", code)
+	}
+
+	code, keys := call(http.MethodPost, "/auth/users/alice/keys", "alice", rotation)
+	if code != http.StatusOK || keys.Current != newKey || len(keys.Previous) != 1 {
+		t.Fatalf("Unexpected keys after rotation: %d %+v", code, keys)
+	}
+	for _, event := range RecentAuthFailures(0) {
+		if event.Event == EventKeyRotation && event.UserID == "alice" {
+			t.Errorf("Expected the rotation not to be reported as a failure, got %+v", event)
+		}
+	}
+	previous := keys.Previous[0]
+	if previous.PublicKey != oldKey || previous.Successor != newKey || previous.RevokedAt != nil {
+		t.Errorf("Unexpected previous key %+v", previous)
+	}
+	if err := verifyStatement(previous.PublicKey, RotationStatement("alice", previous.Successor), previous.Signature); err != nil {
+		t.Errorf("Rotation signature does not verify: %v", err)
+	}
+
+	// A previous key cannot come back
+	back := KeyRotationPayload{PublicKey: oldKey, Signature: sign(newPriv, RotationStatement("alice", oldKey))}
+	if code, _ := call(http.MethodPost, "/auth/users/alice/keys", "alice", back); code != http.StatusConflict {
+		t.Errorf("Expected reusing a previous key to be refused, got %d", code)
+	}
+
+	// Only the current key revokes previous ones
+	revocation := KeyRevocationPayload{PublicKey: oldKey, Signature: sign(oldPriv, RevocationStatement("alice", oldKey))}
+	if code, _ := call(http.MethodPost, "/auth/users/alice/keys/revoke", "alice", revocation); code != http.StatusUnauthorized {
+		t.Errorf("Expected a revocation by the previous key to be refused, got %d", code)
+	}
+	revocation.Signature = sign(newPriv, RevocationStatement("alice", oldKey))
+	code, keys = call(http.MethodPost, "/auth/users/alice/keys/revoke", "alice", revocation)
+	if code != http.StatusOK || keys.Previous[0].RevokedAt == nil {
+		t.Fatalf("Expected the previous key to be revoked: %d %+v", code, keys)
+	}
+	unknown := KeyRevocationPayload{PublicKey: newKey, Signature: sign(newPriv, RevocationStatement("alice", newKey))}
+	if code, _ := call(http.MethodPost, "/auth/users/alice/keys/revoke", "alice", unknown); code != http.StatusNotFound {
+		t.Errorf("Expected revoking the current key to be refused, got %d", code)
+	}
+
+	// The user info still serves the current key
+	rec := httptest.NewRecorder()
+	s.HandleGetUserInfo(rec, httptest.NewRequest(http.MethodGet, "/auth/users/alice", nil))
+	if !bytes.Contains(rec.Body.Bytes(), []byte(newKey)) {
+		t.Errorf("Expected the user info to carry the new key, got %s", rec.Body.String())
+	}
+}
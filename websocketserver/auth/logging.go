@@ -4,19 +4,29 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 )
 
 // SecurityEvent represents a security-related event for audit logging
 type SecurityEvent struct {
-	Timestamp time.Time
-	Event     string
-	UserID    string
-	IP        string
-	Success   bool
-	Details   string
+	Timestamp time.Time `json:"timestamp"`
+	Event     string    `json:"event"`
+	UserID    string    `json:"user_id,omitempty"`
+	IP        string    `json:"ip,omitempty"`
+	Success   bool      `json:"success"`
+	Details   string    `json:"details,omitempty"`
 }
 
+// maxRecentFailures bounds the failed events kept for the admin UI
+const maxRecentFailures = 100
+
+// recentFailures holds the latest failed security events, oldest first.
+var recentFailures = struct {
+	sync.Mutex
+	events []SecurityEvent
+}{}
+
 // Logger handles security event logging
 type Logger struct {
 	// This could be expanded to include database logging, file logging, etc.
@@ -47,10 +57,33 @@ func (l *Logger) LogAuthEvent(event SecurityEvent) {
 
 	log.Println(logMessage)
 
+	if !event.Success {
+		recentFailures.Lock()
+		recentFailures.events = append(recentFailures.events, event)
+		if len(recentFailures.events) > maxRecentFailures {
+			recentFailures.events = recentFailures.events[len(recentFailures.events)-maxRecentFailures:]
+		}
+		recentFailures.Unlock()
+	}
+
 	// TODO: In production, this should also log to a dedicated security log file
 	// or database table for audit and compliance purposes
 }
 
+// RecentAuthFailures returns up to limit of the latest failed security events, newest first.
+func RecentAuthFailures(limit int) []SecurityEvent {
+	recentFailures.Lock()
+	defer recentFailures.Unlock()
+	if limit <= 0 || limit > len(recentFailures.events) {
+		limit = len(recentFailures.events)
+	}
+	events := make([]SecurityEvent, 0, limit)
+	for i := len(recentFailures.events) - 1; len(events) < limit; i-- {
+		events = append(events, recentFailures.events[i])
+	}
+	return events
+}
+
 // GetClientIP extracts the client IP address from the request
 // Properly handles reverse proxies by checking X-Forwarded-For header
 func GetClientIP(r *http.Request) string {
@@ -73,6 +106,8 @@ const (
 	EventUnauthorizedAccess   = "UNAUTHORIZED_ACCESS"
 	EventDirectMessageSending = "DIRECT_MESSAGE_SENDING"
 	EventWebSocketConnection  = "WEBSOCKET_CONNECTION"
+	EventRegistration         = "REGISTRATION"
+	EventKeyRotation          = "KEY_ROTATION"
 )
 
 // SendAuthErrorResponse sends a standardized authentication error response
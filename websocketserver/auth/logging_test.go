@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRecentAuthFailures(t *testing.T) {
+	logger := NewLogger()
+	logger.LogAuthEvent(SecurityEvent{Timestamp: time.Now(), Event: EventLogin, UserID: "alice", Success: true})
+	for i := 0; i < maxRecentFailures+5; i++ {
+		logger.LogAuthEvent(SecurityEvent{Timestamp: time.Now(), Event: EventLogin, UserID: fmt.Sprintf("user-%d", i)})
+	}
+
+	failures := RecentAuthFailures(0)
+	if len(failures) != maxRecentFailures {
+		t.Fatalf("expected %d failures to be kept, got %d", maxRecentFailures, len(failures))
+	}
+	if newest := fmt.Sprintf("user-%d", maxRecentFailures+4); failures[0].UserID != newest {
+		t.Errorf("expected the newest failure first, got %s", failures[0].UserID)
+	}
+	for _, event := range failures {
+		if event.Success {
+			t.Errorf("successful event %+v kept as a failure", event)
+		}
+	}
+	if latest := RecentAuthFailures(3); len(latest) != 3 || latest[2].UserID != failures[2].UserID {
+		t.Errorf("unexpected limited failures: %+v", latest)
+	}
+}
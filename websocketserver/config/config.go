@@ -3,15 +3,38 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Config holds application configuration settings
 type Config struct {
 	// Server settings
-	ServerAddr string
+	ServerAddr   string
+	DatabasePath string // SQLite database, shared by both nodes of an active/standby pair
 	// Rate limiting settings
 	MessageRateLimit  float64 // messages per second per user
 	MessageBurstLimit int     // maximum burst size
+	// Load shedding settings, 0 disables a limit
+	SoftConnectionLimit int     // connections above which low-priority traffic is deferred
+	SoftMessageRate     float64 // messages per second above which low-priority traffic is deferred
+	DegradedRetryAfter  int     // seconds clients are asked to wait before reconnecting while degraded
+	// Maintenance window announced to clients, RFC 3339 times; empty for none
+	MaintenanceStart string
+	MaintenanceEnd   string
+	// Registration settings
+	RegistrationMode      string   // "open" or "invite-only"
+	RegistrationRateLimit int      // registrations per hour per client IP, 0 disables the limit
+	AdminUserIDs          []string // users allowed to mint and revoke invitation codes
+	TrustedProxies        []string // reverse proxies (IPs or CIDR ranges) whose X-Forwarded-For header is trusted
+	// Failover settings, an empty role runs a single server
+	FailoverRole             string // "active" or "standby"
+	FailoverPeerURL          string // base URL of the other node
+	FailoverCheckInterval    int    // seconds between health checks of the peer
+	FailoverFailureThreshold int    // failed checks before the standby takes over
+	FailoverPromoteHook      string // shell command run on promotion, e.g. to move a virtual IP or update DNS
+	FailoverPeerInsecure     bool   // skip TLS verification of the peer's certificate
+	// Negotiate permessage-deflate compression with clients that offer it
+	WebSocketCompression bool
 }
 
 // GetEnv returns the value of the environment variable or a default value.
@@ -42,11 +65,44 @@ func GetEnvInt(key string, defaultVal int) int {
 	return defaultVal
 }
 
+// GetEnvList returns the value of the environment variable as a comma-separated list or nil.
+func GetEnvList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(GetEnv(key, ""), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 // LoadConfig loads the application configuration from environment variables.
 func LoadConfig() *Config {
 	return &Config{
 		ServerAddr:        GetEnv("SERVER_ADDR", ":443"),
+		DatabasePath:      GetEnv("DATABASE_PATH", "app.db"),
 		MessageRateLimit:  GetEnvFloat("MESSAGE_RATE_LIMIT", 5.0), // 5 messages per second by default
 		MessageBurstLimit: GetEnvInt("MESSAGE_BURST_LIMIT", 10),   // burst of 10 messages by default
+
+		SoftConnectionLimit: GetEnvInt("SOFT_CONNECTION_LIMIT", 0),
+		SoftMessageRate:     GetEnvFloat("SOFT_MESSAGE_RATE", 0),
+		DegradedRetryAfter:  GetEnvInt("DEGRADED_RETRY_AFTER", 30),
+
+		MaintenanceStart: GetEnv("MAINTENANCE_START", ""),
+		MaintenanceEnd:   GetEnv("MAINTENANCE_END", ""),
+
+		RegistrationMode:      GetEnv("REGISTRATION_MODE", "open"),
+		RegistrationRateLimit: GetEnvInt("REGISTRATION_RATE_LIMIT", 10), // 10 registrations per hour per IP by default
+		AdminUserIDs:          GetEnvList("ADMIN_USER_IDS"),
+		TrustedProxies:        GetEnvList("TRUSTED_PROXIES"),
+
+		FailoverRole:             GetEnv("FAILOVER_ROLE", ""),
+		FailoverPeerURL:          GetEnv("FAILOVER_PEER_URL", ""),
+		FailoverCheckInterval:    GetEnvInt("FAILOVER_CHECK_INTERVAL", 2),
+		FailoverFailureThreshold: GetEnvInt("FAILOVER_FAILURE_THRESHOLD", 3),
+		FailoverPromoteHook:      GetEnv("FAILOVER_PROMOTE_HOOK", ""),
+		FailoverPeerInsecure:     GetEnv("FAILOVER_PEER_INSECURE", "") == "true",
+
+		WebSocketCompression: GetEnv("WEBSOCKET_COMPRESSION", "true") != "false",
 	}
 }
@@ -68,6 +68,11 @@ func RunMigrations(db *sql.DB) error {
     is_broadcast BOOLEAN DEFAULT FALSE,
     signature TEXT,
     is_forward_message BOOLEAN DEFAULT FALSE,
+		message_id TEXT,  -- chosen by the sender to match acknowledgments
+		ack TEXT,         -- set on acknowledgment frames
+		group_id TEXT,    -- set on copies of a message sent to a group
+		seq INTEGER,      -- sequence number of the sender, for replay protection
+		seq_signature TEXT,
 		FOREIGN KEY(from_user) REFERENCES users(user_id),
 		FOREIGN KEY(to_user) REFERENCES users(user_id)
 	);`
@@ -107,12 +112,81 @@ func RunMigrations(db *sql.DB) error {
 		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY(user_id) REFERENCES users(user_id)
 	);`
+
+	// Groups of users, and their members
+	groupsTable := `
+	CREATE TABLE IF NOT EXISTS user_groups (
+		group_id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		owner TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(owner) REFERENCES users(user_id)
+	);`
+
+	groupMembersTable := `
+	CREATE TABLE IF NOT EXISTS group_members (
+		group_id TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		joined_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (group_id, user_id),
+		FOREIGN KEY(group_id) REFERENCES user_groups(group_id),
+		FOREIGN KEY(user_id) REFERENCES users(user_id)
+	);`
+
+	// Keys users had before their current one, with the signature of each naming its successor
+	userKeysTable := `
+	CREATE TABLE IF NOT EXISTS user_keys (
+		user_id TEXT NOT NULL,
+		public_key TEXT NOT NULL,
+		successor TEXT NOT NULL,
+		signature TEXT NOT NULL,
+		retired_at DATETIME NOT NULL,
+		revoked_at DATETIME,
+		PRIMARY KEY (user_id, public_key),
+		FOREIGN KEY(user_id) REFERENCES users(user_id)
+	);`
+
+	// Invitation codes for invite-only registration
+	invitationCodesTable := `
+	CREATE TABLE IF NOT EXISTS invitation_codes (
+		code TEXT PRIMARY KEY,
+		created_by TEXT NOT NULL,
+		max_uses INTEGER NOT NULL DEFAULT 1,
+		use_count INTEGER NOT NULL DEFAULT 0,
+		note TEXT,
+		expires_at DATETIME,
+		revoked BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	invitationUsesTable := `
+	CREATE TABLE IF NOT EXISTS invitation_code_uses (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		code TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		ip_address TEXT,
+		used_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY(code) REFERENCES invitation_codes(code),
+		FOREIGN KEY(user_id) REFERENCES users(user_id)
+	);`
 	if _, err := db.Exec(userTable); err != nil {
 		return fmt.Errorf("failed to create users table: %v", err)
 	}
 	if _, err := db.Exec(messageTable); err != nil {
 		return fmt.Errorf("failed to create messages table: %v", err)
 	}
+	// Columns added to the messages table of earlier versions
+	for _, column := range []struct{ name, definition string }{
+		{"message_id", "TEXT"},
+		{"ack", "TEXT"},
+		{"group_id", "TEXT"},
+		{"seq", "INTEGER"},
+		{"seq_signature", "TEXT"},
+	} {
+		if err := addColumnIfMissing(db, "messages", column.name, column.definition); err != nil {
+			return fmt.Errorf("failed to add %s column to messages table: %v", column.name, err)
+		}
+	}
 	if _, err := db.Exec(messageDeliveries); err != nil {
 		return fmt.Errorf("failed to create broadcast_deliveriestable: %v", err)
 	}
@@ -136,5 +210,53 @@ func RunMigrations(db *sql.DB) error {
 		return fmt.Errorf("failed to create user_apis table: %v", err)
 	}
 
+	if _, err := db.Exec(groupsTable); err != nil {
+		return fmt.Errorf("failed to create user_groups table: %v", err)
+	}
+	if _, err := db.Exec(groupMembersTable); err != nil {
+		return fmt.Errorf("failed to create group_members table: %v", err)
+	}
+
+	if _, err := db.Exec(userKeysTable); err != nil {
+		return fmt.Errorf("failed to create user_keys table: %v", err)
+	}
+
+	if _, err := db.Exec(invitationCodesTable); err != nil {
+		return fmt.Errorf("failed to create invitation_codes table: %v", err)
+	}
+	if _, err := db.Exec(invitationUsesTable); err != nil {
+		return fmt.Errorf("failed to create invitation_code_uses table: %v", err)
+	}
+
 	return nil
 }
+
+// addColumnIfMissing adds a column to a table created by an earlier version.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid        int
+			name, kind string
+			notNull    bool
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &kind, &notNull, &defaultVal, &primaryKey); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
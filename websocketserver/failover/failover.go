@@ -0,0 +1,246 @@
+// Package failover runs the server as one node of an active/standby pair. Both nodes share the
+// database, which holds users, sessions and pending messages, so a promoted standby continues
+// where the active node stopped once clients reconnect.
+package failover
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Roles of a node in an active/standby pair.
+const (
+	RoleActive  = "active"
+	RoleStandby = "standby"
+)
+
+// StatusPath is the endpoint nodes use to check each other.
+const StatusPath = "/failover/status"
+
+// hookTimeout bounds the promotion hook.
+const hookTimeout = 30 * time.Second
+
+// Config configures a node of an active/standby pair.
+type Config struct {
+	Role             string        // Initial role, "active" or "standby"
+	PeerURL          string        // Base URL of the other node, e.g. https://10.0.0.2
+	CheckInterval    time.Duration // Interval between health checks of the peer
+	FailureThreshold int           // Consecutive failed checks before the standby takes over
+	PromoteHook      string        // Shell command run on promotion, e.g. to move a virtual IP or update DNS
+	PeerInsecure     bool          // Skip TLS verification of the peer's certificate
+}
+
+// Status is the failover state of a node, as served on StatusPath.
+type Status struct {
+	Role         string    `json:"role"`
+	Since        time.Time `json:"since"`
+	PeerURL      string    `json:"peer_url,omitempty"`
+	PeerFailures int       `json:"peer_failures"`
+}
+
+// Manager tracks the role of this node, monitors the peer while on standby and promotes this
+// node when the active peer stops responding.
+type Manager struct {
+	config Config
+	client *http.Client
+
+	mu       sync.RWMutex
+	role     string
+	since    time.Time
+	failures int
+
+	// OnPromote is called after this node became active, before the promotion hook runs.
+	OnPromote func()
+}
+
+// NewManager validates the configuration and creates a manager in the configured role.
+func NewManager(config Config) (*Manager, error) {
+	if config.Role != RoleActive && config.Role != RoleStandby {
+		return nil, fmt.Errorf("invalid failover role %q: must be %q or %q", config.Role, RoleActive, RoleStandby)
+	}
+	if config.Role == RoleStandby && config.PeerURL == "" {
+		return nil, fmt.Errorf("a standby node needs the URL of its active peer")
+	}
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = 2 * time.Second
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 3
+	}
+	config.PeerURL = strings.TrimSuffix(config.PeerURL, "/")
+
+	return &Manager{
+		config: config,
+		client: &http.Client{
+			Timeout: config.CheckInterval,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: config.PeerInsecure},
+			},
+		},
+		role:  config.Role,
+		since: time.Now(),
+	}, nil
+}
+
+// Role returns the current role of this node.
+func (m *Manager) Role() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.role
+}
+
+// IsActive reports whether this node currently serves clients.
+func (m *Manager) IsActive() bool {
+	return m.Role() == RoleActive
+}
+
+// Status returns the failover state of this node.
+func (m *Manager) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return Status{Role: m.role, Since: m.since, PeerURL: m.config.PeerURL, PeerFailures: m.failures}
+}
+
+// Start begins failover handling. A node configured as active steps down if its peer has taken
+// over in the meantime, so a recovered node never competes with the promoted standby. A standby
+// monitors its peer until ctx is done or it is promoted.
+func (m *Manager) Start(ctx context.Context) {
+	if m.Role() == RoleActive {
+		if m.config.PeerURL != "" {
+			if status, err := m.peerStatus(ctx); err == nil && status.Role == RoleActive {
+				log.Printf("Failover: peer %s is active since %v, starting as standby", m.config.PeerURL, status.Since)
+				m.setRole(RoleStandby)
+			}
+		}
+		if m.Role() == RoleActive {
+			log.Printf("Failover: starting as active node")
+			return
+		}
+	}
+	log.Printf("Failover: starting as standby for %s", m.config.PeerURL)
+	go m.monitor(ctx)
+}
+
+// monitor checks the peer until it fails FailureThreshold times in a row, then promotes this node.
+func (m *Manager) monitor(ctx context.Context) {
+	ticker := time.NewTicker(m.config.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		status, err := m.peerStatus(ctx)
+		healthy := err == nil && status.Role == RoleActive
+
+		m.mu.Lock()
+		if healthy {
+			m.failures = 0
+		} else {
+			m.failures++
+		}
+		failures := m.failures
+		m.mu.Unlock()
+
+		if healthy {
+			continue
+		}
+		if err == nil {
+			err = fmt.Errorf("peer is %s", status.Role)
+		}
+		log.Printf("Failover: peer check failed (%d/%d): %v", failures, m.config.FailureThreshold, err)
+		if failures >= m.config.FailureThreshold {
+			m.promote()
+			return
+		}
+	}
+}
+
+// peerStatus fetches the failover status of the peer.
+func (m *Manager) peerStatus(ctx context.Context) (*Status, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.config.PeerURL+StatusPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("invalid status response: %v", err)
+	}
+	return &status, nil
+}
+
+func (m *Manager) setRole(role string) {
+	m.mu.Lock()
+	m.role = role
+	m.since = time.Now()
+	m.failures = 0
+	m.mu.Unlock()
+}
+
+// promote makes this node active and runs the promotion hook.
+func (m *Manager) promote() {
+	m.setRole(RoleActive)
+	log.Printf("Failover: peer %s is down, this node is now active", m.config.PeerURL)
+
+	if m.OnPromote != nil {
+		m.OnPromote()
+	}
+	if m.config.PromoteHook == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", m.config.PromoteHook)
+	cmd.Env = append(os.Environ(), "FAILOVER_ROLE="+RoleActive, "FAILOVER_PEER_URL="+m.config.PeerURL)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Failover: promotion hook failed: %v: %s", err, out)
+	} else {
+		log.Printf("Failover: promotion hook completed")
+	}
+}
+
+// Middleware rejects client traffic while this node is on standby, so clients and load
+// balancers move on to the active node. The status and health endpoints are always served.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.IsActive() && r.URL.Path != StatusPath && r.URL.Path != "/health" {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(m.config.CheckInterval.Seconds())+1))
+			http.Error(w, "Server is on standby", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// StatusHandler serves the failover status of this node.
+func (m *Manager) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(m.Status()); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+	}
+}
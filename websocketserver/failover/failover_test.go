@@ -0,0 +1,124 @@
+package failover
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newPeer starts a fake peer whose status can be switched between the given role and failing.
+func newPeer(t *testing.T, role string) (*httptest.Server, *atomic.Bool) {
+	var down atomic.Bool
+	peer, err := NewManager(Config{Role: role, PeerURL: "http://unused"})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "down", http.StatusInternalServerError)
+			return
+		}
+		peer.StatusHandler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &down
+}
+
+func TestStandbyPromotesAfterPeerFails(t *testing.T) {
+	peer, down := newPeer(t, RoleActive)
+	hookFile := filepath.Join(t.TempDir(), "promoted")
+
+	m, err := NewManager(Config{
+		Role:             RoleStandby,
+		PeerURL:          peer.URL,
+		CheckInterval:    10 * time.Millisecond,
+		FailureThreshold: 2,
+		PromoteHook:      "echo $FAILOVER_ROLE > " + hookFile,
+	})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	var promoted atomic.Bool
+	m.OnPromote = func() { promoted.Store(true) }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.Start(ctx)
+
+	time.Sleep(50 * time.Millisecond)
+	if m.IsActive() {
+		t.Fatal("Expected the standby to stay on standby while the peer is healthy")
+	}
+
+	down.Store(true)
+	deadline := time.Now().Add(2 * time.Second)
+	for !m.IsActive() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !m.IsActive() || !promoted.Load() {
+		t.Fatal("Expected the standby to be promoted after the peer failed")
+	}
+	if out, err := os.ReadFile(hookFile); err != nil || string(out) != "active\n" {
+		t.Errorf("Expected the promotion hook to run, got %q (%v)", out, err)
+	}
+}
+
+func TestActiveStepsDownWhenPeerIsActive(t *testing.T) {
+	peer, _ := newPeer(t, RoleActive)
+
+	m, err := NewManager(Config{Role: RoleActive, PeerURL: peer.URL, CheckInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.Start(ctx)
+
+	if m.Role() != RoleStandby {
+		t.Errorf("Expected a recovered node to start as standby, got %s", m.Role())
+	}
+}
+
+func TestMiddlewareRejectsTrafficOnStandby(t *testing.T) {
+	m, err := NewManager(Config{Role: RoleStandby, PeerURL: "http://peer"})
+	if err != nil {
+		t.Fatalf("NewManager failed: %v", err)
+	}
+	handler := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for path, want := range map[string]int{
+		"/ws":         http.StatusServiceUnavailable,
+		"/health":     http.StatusOK,
+		StatusPath:    http.StatusOK,
+		"/auth/login": http.StatusServiceUnavailable,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, rec.Code)
+		}
+	}
+
+	m.promote()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ws", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected traffic to pass once active, got %d", rec.Code)
+	}
+}
+
+func TestNewManagerValidatesConfig(t *testing.T) {
+	if _, err := NewManager(Config{Role: "primary"}); err == nil {
+		t.Error("Expected an error for an unknown role")
+	}
+	if _, err := NewManager(Config{Role: RoleStandby}); err == nil {
+		t.Error("Expected an error for a standby without a peer")
+	}
+}
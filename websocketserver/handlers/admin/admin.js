@@ -0,0 +1,104 @@
+// Admin dashboard: polls /admin/stats with the admin token kept in sessionStorage.
+(function () {
+  const refreshInterval = 5000;
+  const tokenKey = "dk-admin-token";
+  let timer = null;
+
+  const $ = (id) => document.getElementById(id);
+
+  function row(cells) {
+    const tr = document.createElement("tr");
+    for (const cell of cells) {
+      const td = document.createElement("td");
+      td.textContent = cell;
+      tr.appendChild(td);
+    }
+    return tr;
+  }
+
+  function fill(id, rows, empty) {
+    const body = $(id);
+    body.replaceChildren();
+    if (rows.length === 0) {
+      body.appendChild(row([empty]));
+      return;
+    }
+    rows.forEach((cells) => body.appendChild(row(cells)));
+  }
+
+  function render(stats) {
+    const perMinute = stats.messages_per_minute || [];
+    const hits = Object.entries(stats.rate_limit_hits || {}).sort((a, b) => b[1] - a[1]);
+    const users = stats.connected_users || [];
+
+    $("connections").textContent = users.length;
+    $("messages-hour").textContent = perMinute.reduce((sum, n) => sum + n, 0);
+    $("rate").textContent = stats.load.message_rate.toFixed(1);
+    $("rate-limit-total").textContent = hits.reduce((sum, [, n]) => sum + n, 0);
+    $("health").textContent = stats.load.status;
+    $("health").className = "value " + stats.load.status;
+    $("updated").textContent = "Updated " + new Date(stats.generated_at).toLocaleTimeString();
+
+    const max = Math.max(1, ...perMinute);
+    const chart = $("throughput");
+    chart.replaceChildren();
+    perMinute.forEach((count, i) => {
+      const bar = document.createElement("div");
+      bar.style.height = (100 * count / max) + "%";
+      bar.title = count + " messages, " + (perMinute.length - 1 - i) + " min ago";
+      chart.appendChild(bar);
+    });
+
+    fill("users", users.map((u) => [u.user_id, new Date(u.connected_at).toLocaleString()]), "No users connected");
+    fill("rate-limits", hits.map(([user, count]) => [user, String(count)]), "No rate-limited messages");
+    fill("auth-failures", (stats.auth_failures || []).map((e) => [
+      new Date(e.timestamp).toLocaleString(), e.event, e.user_id || "", e.ip || "", e.details || "",
+    ]), "No failures recorded");
+  }
+
+  function showLogin(message) {
+    clearInterval(timer);
+    sessionStorage.removeItem(tokenKey);
+    $("dashboard").hidden = true;
+    $("logout").hidden = true;
+    $("login").hidden = false;
+    $("error").textContent = message || "";
+  }
+
+  async function refresh() {
+    const token = sessionStorage.getItem(tokenKey);
+    if (!token) {
+      showLogin();
+      return;
+    }
+    try {
+      const resp = await fetch("/admin/stats", { headers: { Authorization: "Bearer " + token } });
+      if (resp.status === 401 || resp.status === 403) {
+        showLogin(resp.status === 403 ? "This user is not an admin." : "The token is invalid or expired.");
+        return;
+      }
+      if (!resp.ok) {
+        throw new Error(resp.status + " " + resp.statusText);
+      }
+      render(await resp.json());
+      $("login").hidden = true;
+      $("dashboard").hidden = false;
+      $("logout").hidden = false;
+    } catch (err) {
+      $("updated").textContent = "Update failed: " + err.message;
+    }
+  }
+
+  $("login").addEventListener("submit", (event) => {
+    event.preventDefault();
+    sessionStorage.setItem(tokenKey, $("token").value.trim());
+    $("token").value = "";
+    refresh();
+    clearInterval(timer);
+    timer = setInterval(refresh, refreshInterval);
+  });
+  $("logout").addEventListener("click", () => showLogin());
+
+  refresh();
+  timer = setInterval(refresh, refreshInterval);
+})();
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"time"
+	"websocketserver/auth"
+	"websocketserver/metrics"
+	"websocketserver/ws"
+)
+
+// adminAssets holds the static files of the admin UI, compiled into the binary so the
+// server can be deployed without them.
+//
+//go:embed admin
+var adminAssets embed.FS
+
+// recentAuthFailures is the number of failed authentication events shown in the admin UI.
+const recentAuthFailures = 50
+
+// AdminStats is the JSON payload behind the admin UI.
+type AdminStats struct {
+	GeneratedAt    time.Time            `json:"generated_at"`
+	ConnectedUsers []ws.ConnectedClient `json:"connected_users"`
+	Load           ws.LoadStatus        `json:"load"`
+	// MessagesPerMinute holds the messages sent in each of the last 60 minutes, oldest first
+	MessagesPerMinute []int                `json:"messages_per_minute"`
+	RateLimitHits     map[string]int       `json:"rate_limit_hits"`
+	AuthFailures      []auth.SecurityEvent `json:"auth_failures"`
+}
+
+// HandleAdminUI serves the embedded admin UI. The page itself holds no data; it asks for an
+// admin token and loads everything from /admin/stats.
+func HandleAdminUI() http.Handler {
+	assets, err := fs.Sub(adminAssets, "admin")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/admin/ui/", http.FileServer(http.FS(assets)))
+}
+
+// HandleAdminStats returns the connected users, message throughput, rate-limit hits and
+// recent authentication failures. Admin only.
+func HandleAdminStats(authService *auth.Service, wsServer *ws.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if _, ok := authService.RequireAdmin(w, r); !ok {
+			return
+		}
+
+		stats := AdminStats{
+			GeneratedAt:       time.Now().UTC(),
+			ConnectedUsers:    wsServer.ConnectedClients(),
+			Load:              wsServer.LoadStatus(),
+			MessagesPerMinute: metrics.GetMessageThroughput(),
+			RateLimitHits:     metrics.GetRateLimitHits(),
+			AuthFailures:      auth.RecentAuthFailures(recentAuthFailures),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		}
+	}
+}
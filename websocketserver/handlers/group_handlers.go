@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"websocketserver/auth"
+	"websocketserver/ws"
+)
+
+// CreateGroupPayload is the JSON body of a request creating a group
+type CreateGroupPayload struct {
+	Name string `json:"name"`
+}
+
+// HandleGroups lists the groups of the authenticated user (GET) or creates a group they own
+// (POST).
+func HandleGroups(authService *auth.Service, wsServer *ws.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authResult := authenticateRequest(r, authService)
+		if !authResult.Valid {
+			auth.SendAuthErrorResponse(w, authResult.ErrorMsg, authResult.ErrorCode)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			groups, err := wsServer.UserGroups(authResult.UserID)
+			if err != nil {
+				log.Printf("Failed to list the groups of %s: %v", authResult.UserID, err)
+				auth.SendAuthErrorResponse(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(groups)
+		case http.MethodPost:
+			var payload CreateGroupPayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				auth.SendAuthErrorResponse(w, "Invalid JSON payload", http.StatusBadRequest)
+				return
+			}
+			group, err := wsServer.CreateGroup(authResult.UserID, payload.Name)
+			if errors.Is(err, ws.ErrInvalidGroupName) {
+				auth.SendAuthErrorResponse(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to create a group for %s: %v", authResult.UserID, err)
+				auth.SendAuthErrorResponse(w, "Database error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(group)
+		default:
+			auth.SendAuthErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// HandleGroup serves a group: GET /groups/{id} returns it to its members, POST
+// /groups/{id}/join and POST /groups/{id}/leave change the membership of the authenticated
+// user.
+func HandleGroup(authService *auth.Service, wsServer *ws.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authResult := authenticateRequest(r, authService)
+		if !authResult.Valid {
+			auth.SendAuthErrorResponse(w, authResult.ErrorMsg, authResult.ErrorCode)
+			return
+		}
+		userID := authResult.UserID
+
+		groupID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/groups/"), "/")
+		if groupID == "" {
+			auth.SendAuthErrorResponse(w, "Group ID is required", http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			group, err := wsServer.Group(groupID)
+			if err == nil && !slices.Contains(group.Members, userID) {
+				err = ws.ErrNotGroupMember
+			}
+			if err != nil {
+				sendGroupError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(group)
+		case action == "join" && r.Method == http.MethodPost:
+			group, err := wsServer.JoinGroup(groupID, userID)
+			if err != nil {
+				sendGroupError(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(group)
+		case action == "leave" && r.Method == http.MethodPost:
+			if err := wsServer.LeaveGroup(groupID, userID); err != nil {
+				sendGroupError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			auth.SendAuthErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// sendGroupError answers a failed group operation.
+func sendGroupError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ws.ErrGroupNotFound):
+		auth.SendAuthErrorResponse(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ws.ErrNotGroupMember):
+		auth.SendAuthErrorResponse(w, err.Error(), http.StatusForbidden)
+	default:
+		log.Printf("Group operation failed: %v", err)
+		auth.SendAuthErrorResponse(w, "Database error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"websocketserver/auth"
+	"websocketserver/ws"
+)
+
+// HandleMessageHistory returns the messages the authenticated user exchanged with a peer:
+// GET /messages/history?peer={id}&before={RFC 3339 time}&limit={n}, latest first. Without
+// before, the latest messages are returned.
+func HandleMessageHistory(authService *auth.Service, wsServer *ws.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authResult := authenticateRequest(r, authService)
+		if !authResult.Valid {
+			auth.SendAuthErrorResponse(w, authResult.ErrorMsg, authResult.ErrorCode)
+			return
+		}
+		if r.Method != http.MethodGet {
+			auth.SendAuthErrorResponse(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		before := time.Now()
+		if value := query.Get("before"); value != "" {
+			parsed, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				auth.SendAuthErrorResponse(w, "Invalid before time", http.StatusBadRequest)
+				return
+			}
+			before = parsed
+		}
+		limit := 0
+		if value := query.Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 0 {
+				auth.SendAuthErrorResponse(w, "Invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		messages, err := wsServer.History(authResult.UserID, query.Get("peer"), before, limit)
+		if errors.Is(err, ws.ErrInvalidPeer) {
+			auth.SendAuthErrorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to read the history of %s: %v", authResult.UserID, err)
+			auth.SendAuthErrorResponse(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(messages)
+	}
+}
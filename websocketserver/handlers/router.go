@@ -12,6 +12,7 @@ func SetupRoutes(mux *http.ServeMux, database *sql.DB, authService *auth.Service
 	// WebSocket routes
 	mux.HandleFunc("/ws", wsServer.HandleWebSocket)
 	mux.HandleFunc("/active-users", wsServer.ActiveUsersHandler)
+	mux.HandleFunc("/health", wsServer.HealthHandler)
 
 	// Authentication routes
 	mux.HandleFunc("/auth/register", authService.HandleRegistration)
@@ -19,6 +20,15 @@ func SetupRoutes(mux *http.ServeMux, database *sql.DB, authService *auth.Service
 	mux.HandleFunc("/auth/check-userid/", authService.HandleCheckUserID)
 	mux.HandleFunc("/auth/users/", authService.HandleGetUserInfo)
 
+	// Invitation code administration
+	mux.HandleFunc("/admin/invitations", authService.HandleInvitations)
+	mux.HandleFunc("/admin/invitations/", authService.HandleInvitation)
+	mux.HandleFunc("/admin/maintenance", wsServer.HandleMaintenance)
+
+	// Admin dashboard; its data requires an admin token
+	mux.Handle("/admin/ui/", HandleAdminUI())
+	mux.HandleFunc("/admin/stats", HandleAdminStats(authService, wsServer))
+
 	// User data routes
 	mux.HandleFunc("/user/descriptions", HandleUserDescriptions(authService, database))
 	mux.HandleFunc("/user/descriptions/", HandleGetUserDescriptions(database))
@@ -27,6 +37,9 @@ func SetupRoutes(mux *http.ServeMux, database *sql.DB, authService *auth.Service
 	mux.HandleFunc("/user/apis", HandleUserAPIs(authService, database))
 	mux.HandleFunc("/apis", HandleGetPublicAPIs(database))
 	mux.HandleFunc("/direct-message/", HandleDirectMessage(authService, wsServer))
+	mux.HandleFunc("/groups", HandleGroups(authService, wsServer))
+	mux.HandleFunc("/groups/", HandleGroup(authService, wsServer))
+	mux.HandleFunc("/messages/history", HandleMessageHistory(authService, wsServer))
 	mux.HandleFunc("/register-document/", HandleRegisterDocument(authService, wsServer))
 	mux.HandleFunc("/append-document/", HandleAppendDocument(authService, wsServer))
 
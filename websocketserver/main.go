@@ -12,6 +12,7 @@ import (
 	"websocketserver/auth"
 	"websocketserver/config"
 	"websocketserver/db"
+	"websocketserver/failover"
 	"websocketserver/handlers"
 	"websocketserver/metrics"
 	"websocketserver/ws"
@@ -22,7 +23,7 @@ func main() {
 	cfg := config.LoadConfig()
 
 	// Initialize SQLite database and set WAL mode.
-	database, err := db.Initialize("app.db")
+	database, err := db.Initialize(cfg.DatabasePath)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
@@ -37,6 +38,13 @@ func main() {
 
 	// Initialize authentication service.
 	authService := auth.NewService(database)
+	if err := authService.ConfigureRegistration(cfg.RegistrationMode, cfg.RegistrationRateLimit, cfg.AdminUserIDs); err != nil {
+		log.Fatalf("Invalid registration configuration: %v", err)
+	}
+	if err := authService.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+	log.Printf("Registration mode: %s", cfg.RegistrationMode)
 
 	// Initialize WebSocket server with rate limiting.
 	wsServer := ws.NewServer(
@@ -45,6 +53,19 @@ func main() {
 		cfg.MessageRateLimit,
 		cfg.MessageBurstLimit,
 	)
+	wsServer.LoadShedder.SetSoftLimits(cfg.SoftConnectionLimit, cfg.SoftMessageRate)
+	wsServer.SetCompression(cfg.WebSocketCompression)
+	wsServer.SetDegradedRetryAfter(time.Duration(cfg.DegradedRetryAfter) * time.Second)
+	if cfg.MaintenanceStart != "" || cfg.MaintenanceEnd != "" {
+		start, startErr := time.Parse(time.RFC3339, cfg.MaintenanceStart)
+		end, endErr := time.Parse(time.RFC3339, cfg.MaintenanceEnd)
+		if startErr != nil || endErr != nil {
+			log.Fatalf("Invalid maintenance window: MAINTENANCE_START and MAINTENANCE_END must be RFC 3339 times")
+		}
+		if err := wsServer.SetMaintenanceWindow(&ws.MaintenanceWindow{Start: start, End: end}); err != nil {
+			log.Fatalf("Invalid maintenance window: %v", err)
+		}
+	}
 
 	// Setup HTTPS routes using the multiplexer.
 	mux := http.NewServeMux()
@@ -52,10 +73,34 @@ func main() {
 	// Setup all routes
 	handlers.SetupRoutes(mux, database, authService, wsServer)
 
+	// During a maintenance window, logins and connections are turned away until it ends.
+	// In an active/standby pair, the standby rejects client traffic until it takes over.
+	handler := wsServer.MaintenanceMiddleware(mux)
+	if cfg.FailoverRole != "" {
+		failoverManager, err := failover.NewManager(failover.Config{
+			Role:             cfg.FailoverRole,
+			PeerURL:          cfg.FailoverPeerURL,
+			CheckInterval:    time.Duration(cfg.FailoverCheckInterval) * time.Second,
+			FailureThreshold: cfg.FailoverFailureThreshold,
+			PromoteHook:      cfg.FailoverPromoteHook,
+			PeerInsecure:     cfg.FailoverPeerInsecure,
+		})
+		if err != nil {
+			log.Fatalf("Invalid failover configuration: %v", err)
+		}
+		failoverManager.OnPromote = func() {
+			// Sessions of the failed node ended when it stopped
+			metrics.CloseOpenSessionsPersist(time.Now())
+		}
+		mux.HandleFunc(failover.StatusPath, failoverManager.StatusHandler)
+		handler = failoverManager.Middleware(handler)
+		failoverManager.Start(context.Background())
+	}
+
 	// Create the HTTPS server instance.
 	httpsSrv := &http.Server{
 		Addr:    cfg.ServerAddr, // For example: ":443" (ensure this matches your configuration for HTTPS)
-		Handler: mux,
+		Handler: handler,
 	}
 
 	// Create the HTTP server instance with a redirect handler.
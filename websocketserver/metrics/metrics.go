@@ -116,6 +116,7 @@ func RecordMessageSent(sessionID string, isBroadcast bool) {
 		}
 	}
 	messageCounts.Unlock()
+	recordThroughput(time.Now())
 	fmt.Printf("Metrics: Message sent in session %s. IsBroadcast: %t\n", sessionID, isBroadcast)
 }
 
@@ -181,3 +182,92 @@ func CalculateChurnRate(period time.Duration) float64 {
 	}
 	return float64(churned) / float64(total)
 }
+
+// sheddingEvents counts low-priority messages deferred or dropped under load, keyed by action.
+var sheddingEvents = struct {
+	sync.Mutex
+	m map[string]int
+}{m: make(map[string]int)}
+
+// RecordShedding records a message deferred or dropped because the server is degraded.
+func RecordShedding(action string, isBroadcast bool) {
+	sheddingEvents.Lock()
+	sheddingEvents.m[action]++
+	sheddingEvents.Unlock()
+	fmt.Printf("Metrics: Message %s under load. IsBroadcast: %t\n", action, isBroadcast)
+}
+
+// GetSheddingEvents returns the number of shedding events per action.
+func GetSheddingEvents() map[string]int {
+	sheddingEvents.Lock()
+	defer sheddingEvents.Unlock()
+	events := make(map[string]int, len(sheddingEvents.m))
+	for action, count := range sheddingEvents.m {
+		events[action] = count
+	}
+	return events
+}
+
+// throughputWindow is the number of minutes of message throughput kept.
+const throughputWindow = 60
+
+// messageThroughput counts messages per minute over the last throughputWindow minutes.
+var messageThroughput = struct {
+	sync.Mutex
+	buckets [throughputWindow]int
+	minutes [throughputWindow]int64 // minute (Unix time / 60) each bucket counts
+}{}
+
+// recordThroughput counts a message in the bucket of the current minute.
+func recordThroughput(now time.Time) {
+	minute := now.Unix() / 60
+	i := minute % throughputWindow
+	messageThroughput.Lock()
+	if messageThroughput.minutes[i] != minute {
+		messageThroughput.minutes[i] = minute
+		messageThroughput.buckets[i] = 0
+	}
+	messageThroughput.buckets[i]++
+	messageThroughput.Unlock()
+}
+
+// GetMessageThroughput returns the number of messages sent in each of the last
+// throughputWindow minutes, oldest first; the last entry is the current minute.
+func GetMessageThroughput() []int {
+	current := time.Now().Unix() / 60
+	counts := make([]int, throughputWindow)
+	messageThroughput.Lock()
+	defer messageThroughput.Unlock()
+	for k := 0; k < throughputWindow; k++ {
+		minute := current - int64(throughputWindow-1-k)
+		i := minute % throughputWindow
+		if messageThroughput.minutes[i] == minute {
+			counts[k] = messageThroughput.buckets[i]
+		}
+	}
+	return counts
+}
+
+// rateLimitHits counts messages rejected by the rate limiter, keyed by user.
+var rateLimitHits = struct {
+	sync.Mutex
+	m map[string]int
+}{m: make(map[string]int)}
+
+// RecordRateLimitHit records a message rejected because its sender exceeded the rate limit.
+func RecordRateLimitHit(userID string) {
+	rateLimitHits.Lock()
+	rateLimitHits.m[userID]++
+	rateLimitHits.Unlock()
+}
+
+// GetRateLimitHits returns the number of rate-limited messages per user.
+func GetRateLimitHits() map[string]int {
+	rateLimitHits.Lock()
+	defer rateLimitHits.Unlock()
+	hits := make(map[string]int, len(rateLimitHits.m))
+	for userID, count := range rateLimitHits.m {
+		hits[userID] = count
+	}
+	return hits
+}
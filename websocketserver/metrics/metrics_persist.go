@@ -51,7 +51,7 @@ func RecordSessionStartPersist(sessionID, userID string, startTime time.Time) {
 		return
 	}
 	query := `INSERT INTO sessions(session_id, user_id, start_time) VALUES(?, ?, ?)`
-	if _, err := db.Exec(query, sessionID, userID, startTime); err != nil {
+	if _, err := db.Exec(query, sessionID, userID, startTime.UTC()); err != nil {
 		fmt.Printf("Error persisting session start: %v\n", err)
 	}
 }
@@ -69,7 +69,7 @@ func RecordSessionEndPersist(sessionID string, endTime time.Time) {
 	}
 	duration := int(endTime.Sub(startTime).Seconds())
 	update := `UPDATE sessions SET end_time = ?, duration = ? WHERE session_id = ?`
-	if _, err := db.Exec(update, endTime, duration, sessionID); err != nil {
+	if _, err := db.Exec(update, endTime.UTC(), duration, sessionID); err != nil {
 		fmt.Printf("Error updating session record: %v\n", err)
 	}
 }
@@ -80,7 +80,32 @@ func RecordMessageEventPersist(sessionID, userID string, isBroadcast bool, ts ti
 		return
 	}
 	query := `INSERT INTO message_events(session_id, user_id, is_broadcast, timestamp) VALUES(?, ?, ?, ?)`
-	if _, err := db.Exec(query, sessionID, userID, isBroadcast, ts); err != nil {
+	if _, err := db.Exec(query, sessionID, userID, isBroadcast, ts.UTC()); err != nil {
 		fmt.Printf("Error persisting message event: %v\n", err)
 	}
 }
+
+// CloseOpenSessionsPersist ends all sessions without an end time. A node taking over after a
+// failover calls it for the sessions of the node that stopped, whose clients are reconnecting.
+func CloseOpenSessionsPersist(endTime time.Time) {
+	if db == nil {
+		return
+	}
+	rows, err := db.Query(`SELECT session_id FROM sessions WHERE end_time IS NULL`)
+	if err != nil {
+		fmt.Printf("Error fetching open sessions: %v\n", err)
+		return
+	}
+	var sessionIDs []string
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err == nil {
+			sessionIDs = append(sessionIDs, sessionID)
+		}
+	}
+	rows.Close()
+
+	for _, sessionID := range sessionIDs {
+		RecordSessionEndPersist(sessionID, endTime)
+	}
+}
@@ -9,6 +9,29 @@ const (
 	MessageTypeAppendDocument     = "append_document"
 	MessageTypeRegisterDocSuccess = "register_document_success"
 	MessageTypeRegisterDocError   = "register_document_error"
+	MessageTypePresence           = "presence"           // Sent by the server when a watched user goes online or offline
+	MessageTypePresenceSubscribe  = "presence_subscribe" // Sent by a client to the server to choose the users it watches
+	MessageTypeGroupMember        = "group_member"       // Sent by the server to the owner of a group when its members change
+)
+
+// SystemAddress is the sender of the messages of the server, and the recipient of the control
+// messages clients send to it, which are handled and not stored.
+const SystemAddress = "system"
+
+// GroupAddressPrefix starts the recipient of a message to a group, followed by the group ID
+const GroupAddressPrefix = "group:"
+
+// Changes of the members of a group, reported to its owner
+const (
+	GroupMemberJoined = "joined"
+	GroupMemberLeft   = "left"
+)
+
+// Acknowledgments of a message, in the order they arrive at its sender
+const (
+	AckAccepted  = "accepted"  // The server stored the message
+	AckDelivered = "delivered" // The recipient's client received it
+	AckRead      = "read"      // The recipient's application consumed it
 )
 
 // User represents a registered user.
@@ -30,6 +53,13 @@ type Message struct {
 	IsBroadcast      bool      `json:"is_broadcast,omitempty"`
 	Signature        string    `json:"signature,omitempty"`          // Base64-encoded signature of message content
 	IsForwardMessage bool      `json:"is_forward_message,omitempty"` // Indicates if this is a forward message
+	MessageID        string    `json:"message_id,omitempty"`         // Chosen by the sender to match acknowledgments
+	Ack              string    `json:"ack,omitempty"`                // Set on acknowledgment frames: the state of message MessageID
+
+	// Replay protection: Sequence increases with each message of the sender, and
+	// SequenceSignature signs it with the content. The server stores and relays them.
+	Sequence          uint64 `json:"seq,omitempty"`
+	SequenceSignature string `json:"seq_signature,omitempty"` // Base64-encoded
 }
 
 // TrackerDocuments represents the structure for tracker documents
@@ -73,3 +103,57 @@ type API struct {
 	CreatedAt time.Time `json:"created_at,omitempty"`
 	UpdatedAt time.Time `json:"updated_at,omitempty"`
 }
+
+// InvitationCode represents a code that allows registration while the server is invite-only.
+type InvitationCode struct {
+	Code      string     `json:"code"`
+	CreatedBy string     `json:"created_by"`
+	MaxUses   int        `json:"max_uses"`
+	UseCount  int        `json:"use_count"`
+	Note      string     `json:"note,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Revoked   bool       `json:"revoked"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// InvitationUse records a registration made with an invitation code.
+type InvitationUse struct {
+	ID        int       `json:"id"`
+	Code      string    `json:"code"`
+	UserID    string    `json:"user_id"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	UsedAt    time.Time `json:"used_at"`
+}
+
+// Group is a set of users that messages can be sent to at once.
+type Group struct {
+	GroupID   string    `json:"group_id"`
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"` // Hands the group key to members and rotates it when one leaves
+	Members   []string  `json:"members"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PresenceSubscription is the content of the message choosing the users whose online and
+// offline transitions a connection is told about. It replaces the previous subscription.
+type PresenceSubscription struct {
+	Type  string   `json:"type"` // MessageTypePresenceSubscribe
+	Users []string `json:"users"`
+}
+
+// PresenceEvent is the content of the message telling a subscriber that a user went online or
+// offline. The current state of each user is sent when subscribing.
+type PresenceEvent struct {
+	Type   string `json:"type"` // MessageTypePresence
+	UserID string `json:"user_id"`
+	Online bool   `json:"online"`
+}
+
+// GroupMemberEvent is the content of the message telling the owner of a group that a user
+// joined or left it.
+type GroupMemberEvent struct {
+	Type    string `json:"type"` // MessageTypeGroupMember
+	GroupID string `json:"group_id"`
+	UserID  string `json:"user_id"`
+	Event   string `json:"event"` // GroupMemberJoined or GroupMemberLeft
+}
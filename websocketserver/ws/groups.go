@@ -0,0 +1,240 @@
+package ws
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"websocketserver/models"
+)
+
+// Errors of group operations.
+var (
+	ErrGroupNotFound    = errors.New("group not found")
+	ErrNotGroupMember   = errors.New("not a member of the group")
+	ErrInvalidGroupName = errors.New("group name is required")
+)
+
+// CreateGroup creates a group owned, and so far only joined, by a user.
+func (s *Server) CreateGroup(owner, name string) (models.Group, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return models.Group{}, ErrInvalidGroupName
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return models.Group{}, err
+	}
+	group := models.Group{
+		GroupID:   "grp-" + hex.EncodeToString(buf),
+		Name:      name,
+		Owner:     owner,
+		Members:   []string{owner},
+		CreatedAt: time.Now().UTC(),
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return models.Group{}, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("INSERT INTO user_groups (group_id, name, owner, created_at) VALUES (?, ?, ?, ?)",
+		group.GroupID, group.Name, owner, group.CreatedAt); err != nil {
+		return models.Group{}, err
+	}
+	if _, err := tx.Exec("INSERT INTO group_members (group_id, user_id, joined_at) VALUES (?, ?, ?)",
+		group.GroupID, owner, group.CreatedAt); err != nil {
+		return models.Group{}, err
+	}
+	return group, tx.Commit()
+}
+
+// Group returns a group with its members, in the order they joined.
+func (s *Server) Group(groupID string) (models.Group, error) {
+	group := models.Group{GroupID: groupID, Members: []string{}}
+	err := s.db.QueryRow("SELECT name, owner, created_at FROM user_groups WHERE group_id = ?", groupID).
+		Scan(&group.Name, &group.Owner, &group.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Group{}, ErrGroupNotFound
+	}
+	if err != nil {
+		return models.Group{}, err
+	}
+
+	rows, err := s.db.Query("SELECT user_id FROM group_members WHERE group_id = ? ORDER BY joined_at, rowid", groupID)
+	if err != nil {
+		return models.Group{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var member string
+		if err := rows.Scan(&member); err != nil {
+			return models.Group{}, err
+		}
+		group.Members = append(group.Members, member)
+	}
+	return group, rows.Err()
+}
+
+// UserGroups returns the groups a user is a member of.
+func (s *Server) UserGroups(userID string) ([]models.Group, error) {
+	rows, err := s.db.Query("SELECT group_id FROM group_members WHERE user_id = ? ORDER BY joined_at, rowid", userID)
+	if err != nil {
+		return nil, err
+	}
+	var groupIDs []string
+	for rows.Next() {
+		var groupID string
+		if err := rows.Scan(&groupID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		groupIDs = append(groupIDs, groupID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	groups := []models.Group{}
+	for _, groupID := range groupIDs {
+		group, err := s.Group(groupID)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
+
+// JoinGroup adds a user to a group and tells its owner, who hands the user the group key.
+// Joining a group again changes nothing.
+func (s *Server) JoinGroup(groupID, userID string) (models.Group, error) {
+	group, err := s.Group(groupID)
+	if err != nil {
+		return models.Group{}, err
+	}
+	res, err := s.db.Exec("INSERT OR IGNORE INTO group_members (group_id, user_id, joined_at) VALUES (?, ?, ?)",
+		groupID, userID, time.Now().UTC())
+	if err != nil {
+		return models.Group{}, err
+	}
+	if added, _ := res.RowsAffected(); added == 0 {
+		return group, nil
+	}
+	group.Members = append(group.Members, userID)
+	s.notifyGroupOwner(group.Owner, models.GroupMemberEvent{GroupID: groupID, UserID: userID, Event: models.GroupMemberJoined})
+	return group, nil
+}
+
+// LeaveGroup removes a user from a group and tells its owner, who rotates the group key so
+// the user cannot read later messages. When the owner leaves, the member who joined first
+// becomes the owner; a group without members is deleted.
+func (s *Server) LeaveGroup(groupID, userID string) error {
+	group, err := s.Group(groupID)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.Exec("DELETE FROM group_members WHERE group_id = ? AND user_id = ?", groupID, userID)
+	if err != nil {
+		return err
+	}
+	if removed, _ := res.RowsAffected(); removed == 0 {
+		return ErrNotGroupMember
+	}
+
+	owner := group.Owner
+	if owner == userID {
+		owner = ""
+		for _, member := range group.Members {
+			if member != userID {
+				owner = member
+				break
+			}
+		}
+		if owner == "" {
+			_, err := s.db.Exec("DELETE FROM user_groups WHERE group_id = ?", groupID)
+			return err
+		}
+		if _, err := s.db.Exec("UPDATE user_groups SET owner = ? WHERE group_id = ?", owner, groupID); err != nil {
+			return err
+		}
+	}
+	s.notifyGroupOwner(owner, models.GroupMemberEvent{GroupID: groupID, UserID: userID, Event: models.GroupMemberLeft})
+	return nil
+}
+
+// isGroupMember reports whether a user is a member of a group.
+func (s *Server) isGroupMember(groupID, userID string) bool {
+	var member bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM group_members WHERE group_id = ? AND user_id = ?)", groupID, userID).Scan(&member)
+	return err == nil && member
+}
+
+// notifyGroupOwner stores and delivers a membership change to the owner of a group, so an
+// owner who is offline handles it when they reconnect.
+func (s *Server) notifyGroupOwner(owner string, event models.GroupMemberEvent) {
+	event.Type = models.MessageTypeGroupMember
+	content, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	msg := models.Message{From: "system", To: owner, Timestamp: time.Now().UTC(), Content: string(content)}
+	if err := s.DeliverHTTPMessage(msg); err != nil {
+		log.Printf("Failed to tell %s that %s %s group %s: %v", owner, event.UserID, event.Event, event.GroupID, err)
+	}
+}
+
+// deliverGroupMessage stores a copy of a message to a group for each member but its sender,
+// and delivers the copies of members who are online. The copies keep the group address as
+// their recipient, which the sender signed.
+func (s *Server) deliverGroupMessage(msg models.Message, groupID string) error {
+	group, err := s.Group(groupID)
+	if err != nil {
+		return err
+	}
+	insertQuery := `INSERT INTO messages (from_user, to_user, timestamp, content, status, is_broadcast, signature, is_forward_message, message_id, ack, group_id, seq, seq_signature)
+	                VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	for _, member := range group.Members {
+		if member == msg.From {
+			continue
+		}
+		res, err := s.db.Exec(insertQuery, msg.From, member, msg.Timestamp.UTC(), msg.Content,
+			"pending", false, msg.Signature, false, msg.MessageID, "", groupID, msg.Sequence, msg.SequenceSignature)
+		if err != nil {
+			return fmt.Errorf("failed to store the copy for %s: %w", member, err)
+		}
+		copyID, _ := res.LastInsertId()
+		msg.ID = int(copyID)
+		s.deliverCopy(msg, member)
+	}
+	return nil
+}
+
+// deliverCopy delivers the copy of a group message stored for a member who is online, and
+// marks it as delivered.
+func (s *Server) deliverCopy(msg models.Message, member string) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	s.mu.RLock()
+	recipient, online := s.clients[member]
+	s.mu.RUnlock()
+	if !online {
+		return
+	}
+	select {
+	case recipient.send <- data:
+		if _, err := s.db.Exec("UPDATE messages SET status = ? WHERE id = ?", "delivered", msg.ID); err != nil {
+			log.Printf("Failed to update message status for msg %d: %v", msg.ID, err)
+		}
+	default:
+		log.Printf("Warning: send channel for client %s is full", member)
+	}
+}
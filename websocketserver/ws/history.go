@@ -0,0 +1,62 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"websocketserver/models"
+)
+
+// Number of messages History returns by default and at most.
+const (
+	DefaultHistoryLimit = 50
+	MaxHistoryLimit     = 500
+)
+
+// ErrInvalidPeer is returned by History for a missing peer.
+var ErrInvalidPeer = errors.New("a peer is required")
+
+// History returns the stored messages a user exchanged with a peer, sent before a time, latest
+// first. With the peer "broadcast" it returns the broadcasts sent since the user registered.
+// Acknowledgments and copies of group messages are left out. Messages are returned as stored:
+// direct messages are still encrypted for their recipient.
+func (s *Server) History(userID, peerID string, before time.Time, limit int) ([]models.Message, error) {
+	if peerID == "" {
+		return nil, ErrInvalidPeer
+	}
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	if limit > MaxHistoryLimit {
+		limit = MaxHistoryLimit
+	}
+
+	const columns = `SELECT id, from_user, to_user, timestamp, content, status, is_broadcast, COALESCE(signature, ''), COALESCE(is_forward_message, FALSE), COALESCE(message_id, ''), COALESCE(seq, 0), COALESCE(seq_signature, '') FROM messages `
+	query := columns + `WHERE ((from_user = ? AND to_user = ?) OR (from_user = ? AND to_user = ?))
+		AND is_broadcast = FALSE AND COALESCE(ack, '') = '' AND COALESCE(group_id, '') = '' AND timestamp < ?
+		ORDER BY timestamp DESC, id DESC LIMIT ?`
+	args := []any{userID, peerID, peerID, userID, before.UTC(), limit}
+	if peerID == "broadcast" {
+		query = columns + `WHERE is_broadcast = TRUE AND timestamp < ?
+			AND datetime(timestamp) >= (SELECT datetime(created_at) FROM users WHERE user_id = ?)
+			ORDER BY timestamp DESC, id DESC LIMIT ?`
+		args = []any{before.UTC(), userID, limit}
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the history of %s with %s: %w", userID, peerID, err)
+	}
+	defer rows.Close()
+
+	messages := make([]models.Message, 0)
+	for rows.Next() {
+		var msg models.Message
+		if err := rows.Scan(&msg.ID, &msg.From, &msg.To, &msg.Timestamp, &msg.Content, &msg.Status, &msg.IsBroadcast, &msg.Signature, &msg.IsForwardMessage, &msg.MessageID, &msg.Sequence, &msg.SequenceSignature); err != nil {
+			return nil, fmt.Errorf("failed to scan a message of the history of %s: %w", userID, err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
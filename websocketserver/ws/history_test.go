@@ -0,0 +1,77 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"websocketserver/db"
+)
+
+func TestHistory(t *testing.T) {
+	database, err := db.Initialize(t.TempDir() + "/history.db")
+	if err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	defer database.Close()
+	if err := db.RunMigrations(database); err != nil {
+		t.Fatalf("RunMigrations failed: %v", err)
+	}
+	start := time.Now().UTC().Add(-time.Hour)
+	for _, user := range []string{"alice", "bob", "carol"} {
+		if _, err := database.Exec("INSERT INTO users (user_id, username, public_key, created_at) VALUES (?, ?, '', ?)", user, user, start.Format(time.DateTime)); err != nil {
+			t.Fatalf("Failed to add %s: %v", user, err)
+		}
+	}
+	insert := func(from, to string, at time.Time, broadcast bool, ack, groupID string) {
+		t.Helper()
+		_, err := database.Exec("INSERT INTO messages (from_user, to_user, timestamp, content, status, is_broadcast, ack, group_id) VALUES (?, ?, ?, 'content', 'delivered', ?, ?, ?)",
+			from, to, at, broadcast, ack, groupID)
+		if err != nil {
+			t.Fatalf("Failed to add a message: %v", err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		at := start.Add(time.Duration(i)*time.Minute + 500*time.Millisecond)
+		if i%2 == 0 {
+			insert("alice", "bob", at, false, "", "")
+		} else {
+			insert("bob", "alice", at, false, "", "")
+		}
+	}
+	insert("bob", "alice", start.Add(10*time.Minute), false, "delivered", "")
+	insert("bob", "alice", start.Add(10*time.Minute), false, "", "team")
+	insert("carol", "alice", start.Add(10*time.Minute), false, "", "")
+	insert("carol", "broadcast", start.Add(10*time.Minute), true, "", "")
+	insert("carol", "broadcast", start.Add(-2*time.Hour), true, "", "")
+
+	s := NewServer(database, nil, 5, 10)
+	messages, err := s.History("alice", "bob", time.Now(), 3)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(messages) != 3 || !messages[0].Timestamp.Equal(start.Add(4*time.Minute+500*time.Millisecond)) || messages[0].From != "alice" {
+		t.Fatalf("Expected the 3 latest messages with bob, latest first, got %+v", messages)
+	}
+
+	// The next page starts before the oldest message of the previous one
+	older, err := s.History("alice", "bob", messages[2].Timestamp, 10)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(older) != 2 || older[0].From != "bob" || !older[1].Timestamp.Equal(start.Add(500*time.Millisecond)) {
+		t.Errorf("Expected the 2 older messages, got %+v", older)
+	}
+
+	// Broadcasts sent before the user registered are left out
+	broadcasts, err := s.History("alice", "broadcast", time.Now(), 0)
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(broadcasts) != 1 || !broadcasts[0].IsBroadcast {
+		t.Errorf("Expected the broadcast sent since alice registered, got %+v", broadcasts)
+	}
+
+	if _, err := s.History("alice", "", time.Now(), 0); err != ErrInvalidPeer {
+		t.Errorf("Expected ErrInvalidPeer without a peer, got %v", err)
+	}
+}
@@ -0,0 +1,237 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+	"websocketserver/metrics"
+	"websocketserver/models"
+)
+
+// Health states reported by the health endpoint.
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+// deferredQueueSize bounds the number of low-priority messages held back while degraded.
+const deferredQueueSize = 1024
+
+// drainInterval is how often deferred messages are retried.
+const drainInterval = time.Second
+
+// LoadShedder tracks server load against soft limits. While a limit is exceeded the server is
+// degraded: low-priority traffic (broadcasts and presence updates) is deferred so that direct
+// messages keep flowing. A limit of 0 disables it.
+type LoadShedder struct {
+	// softConnections is the number of connections above which the server is degraded
+	softConnections int
+	// softMessageRate is the messages per second above which the server is degraded
+	softMessageRate float64
+
+	mu sync.Mutex
+	// windowStart and windowCount count messages received in the current one-second window
+	windowStart time.Time
+	windowCount int
+	// lastRate is the message rate measured over the last complete window
+	lastRate float64
+	// deferred holds low-priority messages until load drops below the soft limits
+	deferred []models.Message
+	// shed counts deferred and dropped messages since startup
+	shed int
+}
+
+// LoadStatus is the health report of the server.
+type LoadStatus struct {
+	Status          string   `json:"status"`
+	Reasons         []string `json:"reasons,omitempty"`
+	Connections     int      `json:"connections"`
+	SoftConnections int      `json:"soft_connection_limit,omitempty"`
+	MessageRate     float64  `json:"message_rate"`
+	SoftMessageRate float64  `json:"soft_message_rate,omitempty"`
+	Deferred        int      `json:"deferred_messages"`
+	Shed            int      `json:"shed_messages"`
+	// Maintenance is the announced maintenance window, in progress or upcoming
+	Maintenance *MaintenanceWindow `json:"maintenance,omitempty"`
+	// RetryAfter is the number of seconds disconnected clients should wait before reconnecting
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// NewLoadShedder creates a load shedder with the given soft limits.
+func NewLoadShedder(softConnections int, softMessageRate float64) *LoadShedder {
+	return &LoadShedder{
+		softConnections: softConnections,
+		softMessageRate: softMessageRate,
+		windowStart:     time.Now(),
+	}
+}
+
+// SetSoftLimits changes the soft limits. A limit of 0 disables it.
+func (ls *LoadShedder) SetSoftLimits(softConnections int, softMessageRate float64) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.softConnections = softConnections
+	ls.softMessageRate = softMessageRate
+}
+
+// RecordMessage counts a received message towards the message rate.
+func (ls *LoadShedder) RecordMessage() {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.rollWindow(time.Now())
+	ls.windowCount++
+}
+
+// rollWindow starts a new rate window once the current one is over. Callers hold ls.mu.
+func (ls *LoadShedder) rollWindow(now time.Time) {
+	elapsed := now.Sub(ls.windowStart)
+	if elapsed < time.Second {
+		return
+	}
+	ls.lastRate = float64(ls.windowCount) / elapsed.Seconds()
+	ls.windowStart = now
+	ls.windowCount = 0
+}
+
+// rate returns the current message rate. Callers hold ls.mu.
+func (ls *LoadShedder) rate(now time.Time) float64 {
+	ls.rollWindow(now)
+	// Within a window, the running count is a lower bound of the rate
+	if current := float64(ls.windowCount); current > ls.lastRate {
+		return current
+	}
+	return ls.lastRate
+}
+
+// Status reports whether the server is degraded given the number of open connections.
+func (ls *LoadShedder) Status(connections int) LoadStatus {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	status := LoadStatus{
+		Status:          HealthOK,
+		Connections:     connections,
+		SoftConnections: ls.softConnections,
+		MessageRate:     ls.rate(time.Now()),
+		SoftMessageRate: ls.softMessageRate,
+		Deferred:        len(ls.deferred),
+		Shed:            ls.shed,
+	}
+	if ls.softConnections > 0 && connections > ls.softConnections {
+		status.Reasons = append(status.Reasons, "connection soft limit exceeded")
+	}
+	if ls.softMessageRate > 0 && status.MessageRate > ls.softMessageRate {
+		status.Reasons = append(status.Reasons, "message rate soft limit exceeded")
+	}
+	if len(status.Reasons) > 0 {
+		status.Status = HealthDegraded
+	}
+	return status
+}
+
+// Defer holds back a low-priority message. It returns false if the queue is full and the
+// message was dropped.
+func (ls *LoadShedder) Defer(msg models.Message) bool {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	ls.shed++
+	if len(ls.deferred) >= deferredQueueSize {
+		metrics.RecordShedding("dropped", msg.IsBroadcast)
+		return false
+	}
+	ls.deferred = append(ls.deferred, msg)
+	metrics.RecordShedding("deferred", msg.IsBroadcast)
+	return true
+}
+
+// takeDeferred removes and returns the deferred messages.
+func (ls *LoadShedder) takeDeferred() []models.Message {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	msgs := ls.deferred
+	ls.deferred = nil
+	return msgs
+}
+
+// IsLowPriority reports whether a message may be deferred under load. Broadcasts and presence
+// updates are low priority; direct messages and forward responses never are.
+func IsLowPriority(msg models.Message) bool {
+	if msg.IsForwardMessage {
+		return false
+	}
+	if msg.IsBroadcast {
+		return true
+	}
+	var content struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal([]byte(msg.Content), &content); err == nil {
+		return content.Type == models.MessageTypePresence
+	}
+	return false
+}
+
+// connectionCount returns the number of connected clients.
+func (s *Server) connectionCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.clients)
+}
+
+// LoadStatus returns the current health of the server with the hints for reconnecting clients.
+func (s *Server) LoadStatus() LoadStatus {
+	status := s.LoadShedder.Status(s.connectionCount())
+	s.applyHints(&status)
+	return status
+}
+
+// degraded reports whether low-priority traffic should be deferred right now.
+func (s *Server) degraded() bool {
+	return s.LoadShedder.Status(s.connectionCount()).Status == HealthDegraded
+}
+
+// drainDeferred delivers deferred messages once the server is no longer degraded.
+func (s *Server) drainDeferred() {
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.degraded() {
+			continue
+		}
+		msgs := s.LoadShedder.takeDeferred()
+		if len(msgs) == 0 {
+			continue
+		}
+		log.Printf("Load back under soft limits, delivering %d deferred messages", len(msgs))
+		for _, msg := range msgs {
+			if err := s.deliverMessage(msg, false, ""); err != nil {
+				log.Printf("Delivery error for deferred message %d: %v", msg.ID, err)
+			}
+		}
+	}
+}
+
+// HealthHandler reports "ok", "degraded" or "maintenance" with the load figures behind the
+// status and the delay clients should wait before reconnecting. A degraded server still accepts
+// traffic, so it is returned with 200 OK; during maintenance the status is 503 with a
+// Retry-After header.
+func (s *Server) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := s.LoadStatus()
+	w.Header().Set("Content-Type", "application/json")
+	if status.Status == HealthMaintenance {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", status.RetryAfter))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,73 @@
+package ws
+
+import (
+	"testing"
+	"websocketserver/models"
+)
+
+func TestLoadShedderStatus(t *testing.T) {
+	ls := NewLoadShedder(2, 0)
+	if status := ls.Status(2); status.Status != HealthOK {
+		t.Errorf("Expected ok at the soft limit, got %s", status.Status)
+	}
+	status := ls.Status(3)
+	if status.Status != HealthDegraded || len(status.Reasons) != 1 {
+		t.Errorf("Expected degraded above the connection soft limit, got %+v", status)
+	}
+
+	ls.SetSoftLimits(0, 5)
+	for i := 0; i < 6; i++ {
+		ls.RecordMessage()
+	}
+	if status := ls.Status(100); status.Status != HealthDegraded {
+		t.Errorf("Expected degraded above the message rate soft limit, got %+v", status)
+	}
+
+	ls.SetSoftLimits(0, 0)
+	if status := ls.Status(100); status.Status != HealthOK {
+		t.Errorf("Expected disabled limits never to degrade, got %+v", status)
+	}
+}
+
+func TestLoadShedderDefer(t *testing.T) {
+	ls := NewLoadShedder(1, 0)
+	for i := 0; i < deferredQueueSize; i++ {
+		if !ls.Defer(models.Message{ID: i, IsBroadcast: true}) {
+			t.Fatalf("Expected message %d to be deferred", i)
+		}
+	}
+	if ls.Defer(models.Message{IsBroadcast: true}) {
+		t.Error("Expected a message to be dropped once the queue is full")
+	}
+
+	status := ls.Status(0)
+	if status.Deferred != deferredQueueSize || status.Shed != deferredQueueSize+1 {
+		t.Errorf("Unexpected deferral counts %+v", status)
+	}
+	if msgs := ls.takeDeferred(); len(msgs) != deferredQueueSize || msgs[0].ID != 0 {
+		t.Errorf("Expected deferred messages in order, got %d", len(msgs))
+	}
+	if msgs := ls.takeDeferred(); len(msgs) != 0 {
+		t.Errorf("Expected the queue to be empty, got %d", len(msgs))
+	}
+}
+
+func TestIsLowPriority(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  models.Message
+		want bool
+	}{
+		{"Broadcast", models.Message{To: "broadcast", IsBroadcast: true, Content: "hello"}, true},
+		{"Presence", models.Message{To: "bob", Content: `{"type":"presence","status":"online"}`}, true},
+		{"Direct", models.Message{To: "bob", Content: "hello"}, false},
+		{"Forward", models.Message{To: "bob", Content: `{"type":"presence"}`, IsForwardMessage: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsLowPriority(tt.msg); got != tt.want {
+				t.Errorf("IsLowPriority() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// HealthMaintenance is reported while a maintenance window is in progress.
+const HealthMaintenance = "maintenance"
+
+// defaultDegradedRetryAfter is the reconnect delay suggested to clients while degraded.
+const defaultDegradedRetryAfter = 30 * time.Second
+
+// MaintenanceWindow is a period during which the server rejects logins and new connections.
+// It is announced on the health endpoint ahead of time so clients can plan reconnects.
+type MaintenanceWindow struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Validate checks that the window ends after it starts.
+func (m MaintenanceWindow) Validate() error {
+	if m.Start.IsZero() || m.End.IsZero() || !m.End.After(m.Start) {
+		return errors.New("maintenance window needs a start and an end after it")
+	}
+	return nil
+}
+
+// activeAt reports whether the window is in progress at the given time.
+func (m MaintenanceWindow) activeAt(now time.Time) bool {
+	return !now.Before(m.Start) && now.Before(m.End)
+}
+
+// SetMaintenanceWindow announces a maintenance window; nil clears it.
+func (s *Server) SetMaintenanceWindow(window *MaintenanceWindow) error {
+	if window != nil {
+		if err := window.Validate(); err != nil {
+			return err
+		}
+	}
+	s.maintenanceMu.Lock()
+	defer s.maintenanceMu.Unlock()
+	s.maintenance = window
+	return nil
+}
+
+// SetDegradedRetryAfter sets the reconnect delay suggested to clients while degraded.
+func (s *Server) SetDegradedRetryAfter(delay time.Duration) {
+	s.maintenanceMu.Lock()
+	defer s.maintenanceMu.Unlock()
+	s.degradedRetryAfter = delay
+}
+
+// MaintenanceWindow returns the announced window unless it is over.
+func (s *Server) MaintenanceWindow() *MaintenanceWindow {
+	s.maintenanceMu.RLock()
+	defer s.maintenanceMu.RUnlock()
+	if s.maintenance == nil || !time.Now().Before(s.maintenance.End) {
+		return nil
+	}
+	window := *s.maintenance
+	return &window
+}
+
+// retryAfterSeconds rounds a delay up to whole seconds, as sent in Retry-After headers.
+func retryAfterSeconds(delay time.Duration) int {
+	return int(math.Ceil(delay.Seconds()))
+}
+
+// applyHints adds the maintenance window and the suggested reconnect delay to a health report.
+func (s *Server) applyHints(status *LoadStatus) {
+	now := time.Now()
+	status.Maintenance = s.MaintenanceWindow()
+	switch {
+	case status.Maintenance != nil && status.Maintenance.activeAt(now):
+		status.Status = HealthMaintenance
+		status.RetryAfter = retryAfterSeconds(status.Maintenance.End.Sub(now))
+	case status.Status == HealthDegraded:
+		s.maintenanceMu.RLock()
+		status.RetryAfter = retryAfterSeconds(s.degradedRetryAfter)
+		s.maintenanceMu.RUnlock()
+	}
+}
+
+// MaintenanceMiddleware rejects logins and WebSocket connections during a maintenance window
+// with 503 and a Retry-After header pointing at its end. Other endpoints stay available.
+func (s *Server) MaintenanceMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/auth/login" || r.URL.Path == "/ws" {
+			if window := s.MaintenanceWindow(); window != nil && window.activeAt(time.Now()) {
+				w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfterSeconds(time.Until(window.End))))
+				http.Error(w, "Server is under maintenance", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// HandleMaintenance shows (GET), announces (PUT) or cancels (DELETE) the maintenance window.
+// Changing it requires an admin token.
+func (s *Server) HandleMaintenance(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if _, ok := s.authService.RequireAdmin(w, r); !ok {
+			return
+		}
+		var window MaintenanceWindow
+		if err := json.NewDecoder(r.Body).Decode(&window); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		if err := s.SetMaintenanceWindow(&window); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case http.MethodDelete:
+		if _, ok := s.authService.RequireAdmin(w, r); !ok {
+			return
+		}
+		s.SetMaintenanceWindow(nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]*MaintenanceWindow{"maintenance": s.MaintenanceWindow()})
+}
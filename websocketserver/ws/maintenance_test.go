@@ -0,0 +1,58 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindowHints(t *testing.T) {
+	s := NewServer(nil, nil, 5, 10)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := s.MaintenanceMiddleware(next)
+
+	if err := s.SetMaintenanceWindow(&MaintenanceWindow{Start: time.Now(), End: time.Now().Add(-time.Minute)}); err == nil {
+		t.Error("Expected a window ending before its start to be rejected")
+	}
+
+	// An upcoming window is announced but does not turn clients away
+	upcoming := MaintenanceWindow{Start: time.Now().Add(time.Hour), End: time.Now().Add(2 * time.Hour)}
+	s.SetMaintenanceWindow(&upcoming)
+	if status := s.LoadStatus(); status.Status != HealthOK || status.Maintenance == nil || status.RetryAfter != 0 {
+		t.Errorf("Expected an ok status announcing the window, got %+v", status)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/auth/login", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected logins before the window, got %d", rec.Code)
+	}
+
+	s.SetMaintenanceWindow(&MaintenanceWindow{Start: time.Now().Add(-time.Minute), End: time.Now().Add(90 * time.Second)})
+	status := s.LoadStatus()
+	if status.Status != HealthMaintenance || status.RetryAfter < 89 || status.RetryAfter > 90 {
+		t.Errorf("Expected maintenance with a retry after 90s, got %+v", status)
+	}
+	for _, path := range []string{"/auth/login", "/ws"} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+			t.Errorf("Expected %s to be rejected with Retry-After, got %d", path, rec.Code)
+		}
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/apis", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected other endpoints to stay available, got %d", rec.Code)
+	}
+
+	// A degraded server asks clients to hold off
+	s.SetMaintenanceWindow(nil)
+	s.LoadShedder.SetSoftLimits(0, 1)
+	for i := 0; i < 5; i++ {
+		s.LoadShedder.RecordMessage()
+	}
+	if status := s.LoadStatus(); status.Status != HealthDegraded || status.RetryAfter != 30 {
+		t.Errorf("Expected degraded with a retry after 30s, got %+v", status)
+	}
+}
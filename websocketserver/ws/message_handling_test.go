@@ -109,7 +109,7 @@ func TestDeliverMessage(t *testing.T) {
 		}
 
 		// Deliver the message
-		if err := server.deliverMessage(msg); err != nil {
+		if err := server.deliverMessage(msg, false, ""); err != nil {
 			t.Fatalf("Failed to deliver broadcast message: %v", err)
 		}
 
@@ -182,7 +182,7 @@ func TestDeliverMessage(t *testing.T) {
 		}
 
 		// Deliver the message
-		if err := server.deliverMessage(msg); err != nil {
+		if err := server.deliverMessage(msg, false, ""); err != nil {
 			t.Fatalf("Failed to deliver direct message: %v", err)
 		}
 
@@ -224,7 +224,7 @@ func TestDeliverMessage(t *testing.T) {
 		}
 
 		// Deliver the message (should not update database)
-		if err := server.deliverMessage(msg); err != nil {
+		if err := server.deliverMessage(msg, false, ""); err != nil {
 			t.Fatalf("Failed to deliver message to offline user: %v", err)
 		}
 
@@ -262,40 +262,36 @@ func TestRetrieveUndeliveredMessages(t *testing.T) {
 	server.clients["user1"] = client
 	server.mu.Unlock()
 
+	// The user registered before both messages, so the broadcast is delivered too
+	createdAt := time.Now().Add(-time.Hour)
+	mock.ExpectQuery(`^SELECT created_at FROM users WHERE user_id = \?$`).
+		WithArgs("user1").
+		WillReturnRows(sqlmock.NewRows([]string{"created_at"}).AddRow(createdAt))
+
 	// Set up query results
 	rows := sqlmock.NewRows([]string{
 		"id", "from_user", "to_user", "timestamp", "content", "status", "is_broadcast", "signature",
+		"message_id", "ack", "group_id", "seq", "seq_signature",
 	}).
-		AddRow(1, "user2", "user1", time.Now(), "Direct message", "pending", false, "").
-		AddRow(2, "user3", "broadcast", time.Now(), "Broadcast message", "pending", true, "")
+		AddRow(1, "user2", "user1", time.Now(), "Direct message", "pending", false, "", "", "", "", 0, "").
+		AddRow(2, "user3", "broadcast", time.Now(), "Broadcast message", "pending", true, "", "", "", "", 0, "")
 
-		// Expect the query
-	mock.ExpectQuery(`SELECT m\.id, m\.from_user, m\.to_user, m\.timestamp, m\.content, m\.status, m\.is_broadcast, m\.signature FROM messages m LEFT JOIN broadcast_deliveries bd ON m\.id = bd\.message_id AND bd\.user_id = \? WHERE \(\(m\.to_user = \? AND m\.status = 'pending'\) OR \(m\.is_broadcast = TRUE AND m\.status = 'pending'\)\) AND bd\.message_id IS NULL`).
-		WithArgs("user1", "user1").
+	// Expect the query
+	mock.ExpectQuery(`SELECT m\.id, .* FROM messages m LEFT JOIN broadcast_deliveries bd ON m\.id = bd\.message_id AND bd\.user_id = \? WHERE \(\s*\(m\.to_user = \? AND m\.status = 'pending'\)\s*OR\s*\(m\.is_broadcast = TRUE AND m\.status = 'pending' AND datetime\(m\.timestamp\) >= datetime\(\?\)\)\s*\)\s*AND bd\.message_id IS NULL`).
+		WithArgs("user1", "user1", createdAt).
 		WillReturnRows(rows)
 
-	// Expect direct message status update with escaped question marks
-	mock.ExpectExec(`^UPDATE messages SET status = \? WHERE id = \?$`).
-		WithArgs("delivered", 1).
-		WillReturnResult(sqlmock.NewResult(0, 1))
+	// The direct message is marked delivered when it is sent, then once it is processed
+	for i := 0; i < 2; i++ {
+		mock.ExpectExec(`^UPDATE messages SET status = \? WHERE id = \?$`).
+			WithArgs("delivered", 1).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
 
 	// Expect broadcast delivery recording with escaped characters.
 	mock.ExpectExec(`^INSERT INTO broadcast_deliveries \(message_id, user_id\) VALUES \(\?, \?\)$`).
 		WithArgs(2, "user1").
 		WillReturnResult(sqlmock.NewResult(0, 1))
-	// mock.ExpectQuery(`SELECT m\.id, m\.from_user, m\.to_user, m\.timestamp, m\.content, m\.status, m\.is_broadcast, m\.signature FROM messages m LEFT JOIN broadcast_deliveries bd ON m\.id = bd\.message_id AND bd\.user_id = \? WHERE \(\(m\.to_user = \? AND m\.status = 'pending'\) OR \(m\.is_broadcast = TRUE AND m\.status = 'pending'\)\) AND bd\.message_id IS NULL`).
-	// 	WithArgs("user1", "user1").
-	// 	WillReturnRows(rows)
-	//
-	// // Expect direct message status update
-	// mock.ExpectExec("UPDATE messages SET status = ? WHERE id = ?").
-	// 	WithArgs("delivered", 1).
-	// 	WillReturnResult(sqlmock.NewResult(0, 1))
-	//
-	// // Expect broadcast delivery recording
-	// mock.ExpectExec("INSERT INTO broadcast_deliveries").
-	// 	WithArgs(2, "user1").
-	// 	WillReturnResult(sqlmock.NewResult(0, 1))
 
 	// Retrieve undelivered messages
 	server.RetrieveUndeliveredMessages("user1")
@@ -362,8 +358,8 @@ func TestHandleWebSocket(t *testing.T) {
 		if rr.Code != http.StatusUnauthorized {
 			t.Errorf("Expected status unauthorized, got %d", rr.Code)
 		}
-		if !strings.Contains(rr.Body.String(), "Unauthorized") {
-			t.Errorf("Expected unauthorized message, got: %s", rr.Body.String())
+		if !strings.Contains(rr.Body.String(), "Missing authentication token") {
+			t.Errorf("Expected missing token message, got: %s", rr.Body.String())
 		}
 	})
 
@@ -0,0 +1,106 @@
+package ws
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"websocketserver/models"
+)
+
+// maxPresenceSubscriptions caps the number of users a connection can watch.
+const maxPresenceSubscriptions = 1000
+
+// handleControl handles a control message a client sent to the server.
+func (c *Client) handleControl(msg models.Message) {
+	var subscription models.PresenceSubscription
+	if err := json.Unmarshal([]byte(msg.Content), &subscription); err != nil || subscription.Type != models.MessageTypePresenceSubscribe {
+		log.Printf("Ignoring unknown control message from %s", c.userID)
+		return
+	}
+	if len(subscription.Users) > maxPresenceSubscriptions {
+		log.Printf("User %s watches %d users; keeping the first %d", c.userID, len(subscription.Users), maxPresenceSubscriptions)
+		subscription.Users = subscription.Users[:maxPresenceSubscriptions]
+	}
+	c.server.watchPresence(c, subscription.Users)
+}
+
+// watchPresence replaces the users a connection watches, and tells it their current state.
+func (s *Server) watchPresence(client *Client, users []string) {
+	s.presenceMu.Lock()
+	s.unwatchPresenceLocked(client)
+	watching := make(map[string]bool, len(users))
+	for _, userID := range users {
+		if userID == "" || userID == client.userID {
+			continue
+		}
+		watching[userID] = true
+		if s.watchers[userID] == nil {
+			s.watchers[userID] = make(map[*Client]bool)
+		}
+		s.watchers[userID][client] = true
+	}
+	s.watching[client] = watching
+	s.presenceMu.Unlock()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for userID := range watching {
+		_, online := s.clients[userID]
+		s.sendPresenceLocked(client, userID, online)
+	}
+}
+
+// unwatchPresence removes the subscription of a connection.
+func (s *Server) unwatchPresence(client *Client) {
+	s.presenceMu.Lock()
+	defer s.presenceMu.Unlock()
+	s.unwatchPresenceLocked(client)
+}
+
+func (s *Server) unwatchPresenceLocked(client *Client) {
+	for userID := range s.watching[client] {
+		delete(s.watchers[userID], client)
+		if len(s.watchers[userID]) == 0 {
+			delete(s.watchers, userID)
+		}
+	}
+	delete(s.watching, client)
+}
+
+// notifyPresence tells the connections watching a user that it went online or offline.
+func (s *Server) notifyPresence(userID string, online bool) {
+	s.presenceMu.Lock()
+	subscribers := make([]*Client, 0, len(s.watchers[userID]))
+	for client := range s.watchers[userID] {
+		subscribers = append(subscribers, client)
+	}
+	s.presenceMu.Unlock()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, client := range subscribers {
+		s.sendPresenceLocked(client, userID, online)
+	}
+}
+
+// sendPresenceLocked sends the state of a user to a connection, unless it was closed. It is
+// called with s.mu held, so the send channel cannot be closed meanwhile.
+func (s *Server) sendPresenceLocked(client *Client, userID string, online bool) {
+	if s.clients[client.userID] != client {
+		return
+	}
+	content, err := json.Marshal(models.PresenceEvent{Type: models.MessageTypePresence, UserID: userID, Online: online})
+	if err != nil {
+		return
+	}
+	data, err := json.Marshal(models.Message{From: models.SystemAddress, To: client.userID, Timestamp: time.Now().UTC(), Content: string(content)})
+	if err != nil {
+		return
+	}
+	select {
+	case client.send <- data:
+	default:
+		log.Printf("Warning: send channel for client %s is full, dropping presence of %s", client.userID, userID)
+	}
+}
@@ -0,0 +1,81 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+
+	"websocketserver/models"
+)
+
+// connectTestClient adds a connection to the server without a socket; what the server sends to
+// it is read from its send channel.
+func connectTestClient(s *Server, userID string) *Client {
+	client := &Client{userID: userID, send: make(chan []byte, 16), server: s}
+	s.mu.Lock()
+	s.clients[userID] = client
+	s.mu.Unlock()
+	return client
+}
+
+// nextPresence returns the presence event sent to a connection, or false if none was.
+func nextPresence(t *testing.T, client *Client) (models.PresenceEvent, bool) {
+	t.Helper()
+	select {
+	case data := <-client.send:
+		var msg models.Message
+		var event models.PresenceEvent
+		if err := json.Unmarshal(data, &msg); err != nil || json.Unmarshal([]byte(msg.Content), &event) != nil {
+			t.Fatalf("Expected a presence message, got %s", data)
+		}
+		if msg.From != models.SystemAddress || msg.To != client.userID || event.Type != models.MessageTypePresence {
+			t.Errorf("Expected a presence event from the server, got %+v", msg)
+		}
+		return event, true
+	default:
+		return models.PresenceEvent{}, false
+	}
+}
+
+func TestPresenceSubscription(t *testing.T) {
+	s := NewServer(nil, nil, 5, 10)
+	alice := connectTestClient(s, "alice")
+	connectTestClient(s, "bob")
+
+	content, _ := json.Marshal(models.PresenceSubscription{Type: models.MessageTypePresenceSubscribe, Users: []string{"bob", "carol", "alice"}})
+	alice.handleControl(models.Message{From: "alice", To: models.SystemAddress, Content: string(content)})
+
+	// The current state of each watched user is sent at once, but not of the subscriber itself
+	state := map[string]bool{}
+	for {
+		event, ok := nextPresence(t, alice)
+		if !ok {
+			break
+		}
+		state[event.UserID] = event.Online
+	}
+	if len(state) != 2 || !state["bob"] || state["carol"] {
+		t.Errorf("Expected bob online and carol offline, got %v", state)
+	}
+
+	carol := connectTestClient(s, "carol")
+	s.notifyPresence("carol", true)
+	if event, ok := nextPresence(t, alice); !ok || event.UserID != "carol" || !event.Online {
+		t.Errorf("Expected carol to go online, got %+v", event)
+	}
+	s.unregisterClient(carol)
+	if event, ok := nextPresence(t, alice); !ok || event.UserID != "carol" || event.Online {
+		t.Errorf("Expected carol to go offline, got %+v", event)
+	}
+
+	// Users not watched, and watchers that disconnected, are not notified
+	s.notifyPresence("dave", true)
+	if event, ok := nextPresence(t, alice); ok {
+		t.Errorf("Expected no event for an unwatched user, got %+v", event)
+	}
+	s.unregisterClient(alice)
+	s.presenceMu.Lock()
+	defer s.presenceMu.Unlock()
+	if len(s.watchers) != 0 || len(s.watching) != 0 {
+		t.Errorf("Expected the subscription to be removed, got %v", s.watchers)
+	}
+}
@@ -9,7 +9,10 @@ import (
 	"log"
 	"math/rand"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"websocketserver/auth"
 	"websocketserver/metrics"
@@ -30,20 +33,48 @@ type Server struct {
 	authService      *auth.Service
 	clients          map[string]*Client // mapping from user_id to client connection
 	RateLimiter      *RateLimiter       // rate limiter for message processing
+	LoadShedder      *LoadShedder       // soft limits and deferral of low-priority traffic
 	mu               sync.RWMutex
 	responseChannels map[string]chan models.Message // mapping from user_id to response channels
 	responseMu       sync.RWMutex                   // mutex for response channels
+
+	// Whether permessage-deflate compression is negotiated with clients that offer it
+	compression atomic.Bool
+
+	// Hints that tell clients when to reconnect
+	maintenance        *MaintenanceWindow
+	degradedRetryAfter time.Duration
+	maintenanceMu      sync.RWMutex
+
+	// Presence subscriptions: the connections watching each user, and the users each
+	// connection watches
+	watchers   map[string]map[*Client]bool
+	watching   map[*Client]map[string]bool
+	presenceMu sync.Mutex
 }
 
 // NewServer creates a new WebSocket server instance.
+// Soft limits are disabled until set with LoadShedder.SetSoftLimits.
 func NewServer(db *sql.DB, authService *auth.Service, messageRate float64, messageBurst int) *Server {
-	return &Server{
-		db:               db,
-		authService:      authService,
-		clients:          make(map[string]*Client),
-		RateLimiter:      NewRateLimiter(messageRate, messageBurst),
-		responseChannels: make(map[string]chan models.Message),
+	s := &Server{
+		db:                 db,
+		authService:        authService,
+		clients:            make(map[string]*Client),
+		RateLimiter:        NewRateLimiter(messageRate, messageBurst),
+		LoadShedder:        NewLoadShedder(0, 0),
+		responseChannels:   make(map[string]chan models.Message),
+		degradedRetryAfter: defaultDegradedRetryAfter,
+		watchers:           make(map[string]map[*Client]bool),
+		watching:           make(map[*Client]map[string]bool),
 	}
+	go s.drainDeferred()
+	return s
+}
+
+// SetCompression sets whether permessage-deflate compression is negotiated with clients that
+// offer it, for the connections opened from then on.
+func (s *Server) SetCompression(enabled bool) {
+	s.compression.Store(enabled)
 }
 
 // Client represents an individual WebSocket connection.
@@ -52,6 +83,8 @@ type Client struct {
 	conn   *websocket.Conn
 	send   chan []byte
 	server *Server
+	// connectedAt is when the connection was established
+	connectedAt time.Time
 
 	// Context for managing goroutine lifecycles.
 	ctx    context.Context
@@ -70,6 +103,12 @@ func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Use enhanced token verification
 	tokenResult := auth.VerifyToken(tokenStr, s.authService, "")
 	if !tokenResult.Valid || tokenResult.Error != nil {
+		auth.NewLogger().LogAuthEvent(auth.SecurityEvent{
+			Timestamp: time.Now(),
+			Event:     auth.EventWebSocketConnection,
+			IP:        auth.GetClientIP(r),
+			Details:   fmt.Sprintf("invalid token: %v", tokenResult.Error),
+		})
 		http.Error(w, fmt.Sprintf("Invalid token: %v", tokenResult.Error), http.StatusUnauthorized)
 		return
 	}
@@ -94,8 +133,10 @@ func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Log connection for security auditing
 	log.Printf("Authenticated WebSocket connection for user %s", userID)
 
-	// Upgrade the connection to WebSocket.
-	conn, err := upgrader.Upgrade(w, r, nil)
+	// Upgrade the connection to WebSocket, accepting compression if the client offers it.
+	connUpgrader := upgrader
+	connUpgrader.EnableCompression = s.compression.Load()
+	conn, err := connUpgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade error: %v", err)
 		return
@@ -105,12 +146,13 @@ func (s *Server) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	client := &Client{
-		userID: userID,
-		conn:   conn,
-		send:   make(chan []byte, 256),
-		server: s,
-		ctx:    ctx,
-		cancel: cancel,
+		userID:      userID,
+		conn:        conn,
+		send:        make(chan []byte, 256),
+		server:      s,
+		connectedAt: time.Now(),
+		ctx:         ctx,
+		cancel:      cancel,
 	}
 	s.registerClient(client)
 
@@ -185,12 +227,31 @@ func (s *Server) ActiveUsersHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ConnectedClient describes an open WebSocket connection.
+type ConnectedClient struct {
+	UserID      string    `json:"user_id"`
+	ConnectedAt time.Time `json:"connected_at"`
+}
+
+// ConnectedClients returns the open connections, sorted by user ID.
+func (s *Server) ConnectedClients() []ConnectedClient {
+	s.mu.RLock()
+	clients := make([]ConnectedClient, 0, len(s.clients))
+	for userID, client := range s.clients {
+		clients = append(clients, ConnectedClient{UserID: userID, ConnectedAt: client.connectedAt})
+	}
+	s.mu.RUnlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i].UserID < clients[j].UserID })
+	return clients
+}
+
 // registerClient adds a new client to the server and retrieves any undelivered messages.
 func (s *Server) registerClient(client *Client) {
 	s.mu.Lock()
 	s.clients[client.userID] = client
 	s.mu.Unlock()
 	log.Printf("User %s connected", client.userID)
+	s.notifyPresence(client.userID, true)
 
 	// Create a unique session ID using the client pointer.
 	sessionID := fmt.Sprintf("%p", client)
@@ -208,11 +269,16 @@ func (s *Server) registerClient(client *Client) {
 // unregisterClient removes a client from the server.
 func (s *Server) unregisterClient(client *Client) {
 	s.mu.Lock()
-	if _, ok := s.clients[client.userID]; ok {
+	_, removed := s.clients[client.userID]
+	if removed {
 		delete(s.clients, client.userID)
 		close(client.send)
 	}
 	s.mu.Unlock()
+	s.unwatchPresence(client)
+	if removed {
+		s.notifyPresence(client.userID, false)
+	}
 	// Clean up rate limiter for this user
 	s.RateLimiter.RemoveUser(client.userID)
 	// Record session end both in memory and persist to the database.
@@ -229,7 +295,15 @@ func (s *Server) unregisterClient(client *Client) {
 // deliverMessage sends the message to its intended recipient(s).
 // For broadcast messages, it iterates over all connected clients (skipping the sender).
 // If isReconnection is true, it only delivers to the specified targetUser.
+// While the server is degraded, low-priority messages are deferred instead of delivered.
 func (s *Server) deliverMessage(msg models.Message, isReconnection bool, targetUser string) error {
+	if !isReconnection && IsLowPriority(msg) && s.degraded() {
+		if !s.LoadShedder.Defer(msg) {
+			log.Printf("Warning: deferred queue is full, dropping message %d from %s", msg.ID, msg.From)
+		}
+		return nil
+	}
+
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return err
@@ -323,11 +397,11 @@ func (s *Server) RemoveResponseChannel(userID string) {
 // This is used for the direct message API endpoint
 func (s *Server) DeliverHTTPMessage(msg models.Message) error {
 	// First, save the message in the database
-	insertQuery := `INSERT INTO messages (from_user, to_user, timestamp, content, status, is_broadcast, signature, is_forward_message) 
-	                VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	insertQuery := `INSERT INTO messages (from_user, to_user, timestamp, content, status, is_broadcast, signature, is_forward_message, message_id, ack, seq, seq_signature) 
+	                VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	res, err := s.db.Exec(insertQuery, msg.From, msg.To, msg.Timestamp, msg.Content,
-		"pending", false, msg.Signature, msg.IsForwardMessage)
+	res, err := s.db.Exec(insertQuery, msg.From, msg.To, msg.Timestamp.UTC(), msg.Content,
+		"pending", false, msg.Signature, msg.IsForwardMessage, msg.MessageID, msg.Ack, msg.Sequence, msg.SequenceSignature)
 	if err != nil {
 		log.Printf("Failed to insert HTTP message from %s to %s: %v", msg.From, msg.To, err)
 		return err
@@ -341,6 +415,7 @@ func (s *Server) DeliverHTTPMessage(msg models.Message) error {
 	}
 
 	// Now attempt to deliver the message using the existing mechanism
+	s.LoadShedder.RecordMessage()
 	return s.deliverMessage(msg, false, "")
 }
 
@@ -372,9 +447,12 @@ func (c *Client) readPump() {
 			// Instrumentation: record message sent (using client pointer as sessionID).
 			sessionID := fmt.Sprintf("%p", c)
 
+			c.server.LoadShedder.RecordMessage()
+
 			// Apply rate limiting
 			if !c.server.RateLimiter.Allow(c.userID) {
 				log.Printf("Rate limit exceeded for user %s", c.userID)
+				metrics.RecordRateLimitHit(c.userID)
 
 				// Send rate limit error message to client
 				rateLimitErr := models.Message{
@@ -395,11 +473,36 @@ func (c *Client) readPump() {
 				continue
 			}
 
+			// Control messages, such as presence subscriptions, are handled by the server
+			if msg.To == models.SystemAddress {
+				c.handleControl(msg)
+				continue
+			}
+
 			// Determine if the message is a broadcast.
 			if msg.To == "broadcast" {
 				msg.IsBroadcast = true
 			}
 
+			// A broadcast reaches every user, so one claiming to come from someone else is
+			// dropped rather than relayed.
+			if msg.IsBroadcast && msg.From != c.userID {
+				log.Printf("Broadcast from %s claims to be from %s; dropping message", c.userID, msg.From)
+				continue
+			}
+
+			// Acknowledgments go back to the sender of a direct message, and are stored and
+			// delivered like one. They come from the connected user, whatever the frame says.
+			groupID, toGroup := strings.CutPrefix(msg.To, models.GroupAddressPrefix)
+			if msg.Ack != "" {
+				if msg.IsBroadcast || toGroup || msg.MessageID == "" {
+					log.Printf("Invalid acknowledgment from %s: it needs a message ID and a recipient", c.userID)
+					continue
+				}
+				msg.From = c.userID
+				msg.IsForwardMessage = false
+			}
+
 			// Check if this is a forward response message by either:
 			// 1. The message is marked with IsForwardMessage flag
 			// 2. The content has "type":"forward_response"
@@ -452,11 +555,30 @@ func (c *Client) readPump() {
 			metrics.RecordMessageSent(sessionID, msg.IsBroadcast)
 			metrics.RecordMessageEventPersist(sessionID, c.userID, msg.IsBroadcast, time.Now())
 
+			// Messages to a group are copied to each of its members, encrypted once with the
+			// group key. Only members may send them.
+			if toGroup && msg.Ack == "" {
+				if !c.server.isGroupMember(groupID, c.userID) {
+					log.Printf("User %s is not a member of group %s; dropping message", c.userID, groupID)
+					continue
+				}
+				msg.From = c.userID
+				msg.IsForwardMessage = false
+				if err := c.server.deliverGroupMessage(msg, groupID); err != nil {
+					log.Printf("Delivery error for group message from %s to %s: %v", c.userID, groupID, err)
+					continue
+				}
+				if msg.MessageID != "" {
+					c.acknowledge(msg.MessageID, models.AckAccepted)
+				}
+				continue
+			}
+
 			// Save the message with a "pending" status, including the signature if present.
-			insertQuery := `INSERT INTO messages (from_user, to_user, timestamp, content, status, is_broadcast, signature, is_forward_message) 
-                           VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
-			res, err := c.server.db.Exec(insertQuery, msg.From, msg.To, msg.Timestamp, msg.Content,
-				"pending", msg.IsBroadcast, msg.Signature, msg.IsForwardMessage)
+			insertQuery := `INSERT INTO messages (from_user, to_user, timestamp, content, status, is_broadcast, signature, is_forward_message, message_id, ack, seq, seq_signature) 
+                           VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+			res, err := c.server.db.Exec(insertQuery, msg.From, msg.To, msg.Timestamp.UTC(), msg.Content,
+				"pending", msg.IsBroadcast, msg.Signature, msg.IsForwardMessage, msg.MessageID, msg.Ack, msg.Sequence, msg.SequenceSignature)
 			if err != nil {
 				log.Printf("Failed to insert message from %s: %v", c.userID, err)
 				continue
@@ -465,6 +587,10 @@ func (c *Client) readPump() {
 			if err == nil {
 				msg.ID = int(lastID)
 			}
+			// Once stored, the message is not lost even if the recipient is offline
+			if msg.MessageID != "" && msg.Ack == "" {
+				c.acknowledge(msg.MessageID, models.AckAccepted)
+			}
 			// Attempt to deliver the message in real time.
 			// Pass false for isReconnection and empty string for targetUser since this is a normal message delivery
 			if err := c.server.deliverMessage(msg, false, ""); err != nil {
@@ -483,7 +609,7 @@ func (s *Server) RetrieveUndeliveredMessages(userID string) {
 		log.Printf("Failed to retrieve user registration time for %s: %v", userID, err)
 		// If we can't get the registration time, proceed with caution - just deliver direct messages
 		query := `
-            SELECT m.id, m.from_user, m.to_user, m.timestamp, m.content, m.status, m.is_broadcast, m.signature 
+            SELECT m.id, m.from_user, m.to_user, m.timestamp, m.content, m.status, m.is_broadcast, m.signature, COALESCE(m.message_id, ''), COALESCE(m.ack, ''), COALESCE(m.group_id, ''), COALESCE(m.seq, 0), COALESCE(m.seq_signature, '') 
             FROM messages m 
             LEFT JOIN broadcast_deliveries bd ON m.id = bd.message_id AND bd.user_id = ? 
             WHERE m.to_user = ? AND m.status = 'pending' AND bd.message_id IS NULL
@@ -503,7 +629,7 @@ func (s *Server) RetrieveUndeliveredMessages(userID string) {
 	// Query for undelivered messages, including both direct and broadcast messages
 	// For broadcast messages, we rely on the database's automatic timestamp
 	query := `
-        SELECT m.id, m.from_user, m.to_user, m.timestamp, m.content, m.status, m.is_broadcast, m.signature 
+        SELECT m.id, m.from_user, m.to_user, m.timestamp, m.content, m.status, m.is_broadcast, m.signature, COALESCE(m.message_id, ''), COALESCE(m.ack, ''), COALESCE(m.group_id, ''), COALESCE(m.seq, 0), COALESCE(m.seq_signature, '') 
         FROM messages m 
         LEFT JOIN broadcast_deliveries bd ON m.id = bd.message_id AND bd.user_id = ? 
         WHERE (
@@ -529,11 +655,18 @@ func (s *Server) RetrieveUndeliveredMessages(userID string) {
 func processMessages(s *Server, rows *sql.Rows, userID string) {
 	for rows.Next() {
 		var msg models.Message
-		if err := rows.Scan(&msg.ID, &msg.From, &msg.To, &msg.Timestamp, &msg.Content, &msg.Status, &msg.IsBroadcast, &msg.Signature); err != nil {
+		var groupID string
+		if err := rows.Scan(&msg.ID, &msg.From, &msg.To, &msg.Timestamp, &msg.Content, &msg.Status, &msg.IsBroadcast, &msg.Signature, &msg.MessageID, &msg.Ack, &groupID, &msg.Sequence, &msg.SequenceSignature); err != nil {
 			log.Printf("Error scanning message for %s: %v", userID, err)
 			continue
 		}
 
+		if groupID != "" {
+			// Copies of a group message keep the group address their sender signed
+			msg.To = models.GroupAddressPrefix + groupID
+			s.deliverCopy(msg, userID)
+			continue
+		}
 		// Pass true for isReconnection and userID for targetUser since this is a reconnection delivery
 		if err := s.deliverMessage(msg, true, userID); err != nil {
 			log.Printf("Error delivering undelivered message %d to %s: %v", msg.ID, userID, err)
@@ -550,6 +683,27 @@ func processMessages(s *Server, rows *sql.Rows, userID string) {
 	}
 }
 
+// acknowledge tells the client the state of a message it sent.
+func (c *Client) acknowledge(messageID, state string) {
+	ack := models.Message{
+		From:      "system",
+		To:        c.userID,
+		Timestamp: time.Now().UTC(),
+		Status:    "delivered",
+		MessageID: messageID,
+		Ack:       state,
+	}
+	data, err := json.Marshal(ack)
+	if err != nil {
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
+		log.Printf("Warning: send channel for client %s is full, dropping acknowledgment of %s", c.userID, messageID)
+	}
+}
+
 // writePump writes messages from the send channel to the WebSocket.
 // It periodically sends pings to keep the connection alive and listens for context cancellation.
 func (c *Client) writePump() {